@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/migrations"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit/redisstore"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime/pgbus"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime/redisbus"
+	"github.com/dfunani/AfroChat/backend/services"
+	"github.com/google/uuid"
+)
+
+const doctorUsage = `usage: afrochat doctor
+
+Checks the configuration in the environment and every dependency it names:
+the database and its migrations, attachment storage, Redis, the search
+cluster, the mail server and push credentials. Prints a report and exits non-zero if any check fails.`
+
+// doctorTimeout bounds each check, so an unreachable dependency fails
+// rather than hangs.
+const doctorTimeout = 10 * time.Second
+
+// errSkipped marks a check that does not apply to the configuration.
+var errSkipped = errors.New("skipped")
+
+// doctor runs the checks and tallies the report.
+type doctor struct {
+	failed int
+}
+
+// check runs one named check and prints its outcome. A check returning an
+// error wrapping errSkipped is reported as skipped, with the reason.
+func (d *doctor) check(name string, fn func(ctx context.Context) (string, error)) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	detail, err := fn(ctx)
+	switch {
+	case errors.Is(err, errSkipped):
+		fmt.Printf("SKIP  %-20s %s\n", name, detail)
+		return true
+	case err != nil:
+		d.failed++
+		fmt.Printf("FAIL  %-20s %v\n", name, err)
+		return false
+	default:
+		fmt.Printf("PASS  %-20s %s\n", name, detail)
+		return true
+	}
+}
+
+// runDoctor implements the doctor subcommand and returns the exit code.
+func runDoctor(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, doctorUsage)
+		return 2
+	}
+	d := &doctor{}
+
+	var appConfig *config.ApplicationConfig
+	ok := d.check("configuration", func(context.Context) (string, error) {
+		var err error
+		appConfig, err = config.LoadApplicationConfig()
+		if err != nil {
+			return "", err
+		}
+		return "environment " + appConfig.Env, nil
+	})
+	if !ok {
+		// Every other check needs the configuration.
+		return d.report()
+	}
+
+	var dbClient *database.DatabaseConnection
+	ok = d.check("database", func(ctx context.Context) (string, error) {
+		// One attempt is enough to tell; the server retries on startup.
+		appConfig.DBConnectTimeout = 0
+		var err error
+		if dbClient, err = services.CreateDatabaseClient(appConfig); err != nil {
+			return "", err
+		}
+		if err := dbClient.Health(ctx); err != nil {
+			return "", err
+		}
+		if appConfig.DBDriver == database.DriverSQLite {
+			return "SQLite at " + appConfig.DBPath, nil
+		}
+		return "Postgres at " + net.JoinHostPort(appConfig.DBHost, strconv.Itoa(appConfig.DBPort)), nil
+	})
+	if ok {
+		defer dbClient.Close()
+	}
+	d.check("migrations", func(ctx context.Context) (string, error) {
+		if !ok {
+			return "needs the database", errSkipped
+		}
+		if appConfig.DBDriver == database.DriverSQLite {
+			return "SQLite databases get their schema when the server starts", errSkipped
+		}
+		migrator, err := migrations.New(dbClient.SQLDB)
+		if err != nil {
+			return "", err
+		}
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return "", err
+		}
+		if len(status.Pending) > 0 {
+			return "", fmt.Errorf("%d pending, from %04d_%s; run afrochat migrate up",
+				len(status.Pending), status.Pending[0].Version, status.Pending[0].Name)
+		}
+		return "up to date at version " + strconv.FormatInt(status.Current, 10), nil
+	})
+
+	d.check("storage", func(ctx context.Context) (string, error) {
+		return checkStorage(ctx, appConfig)
+	})
+	d.check("rate limit store", func(ctx context.Context) (string, error) {
+		if appConfig.RateLimitStore != config.RateLimitStoreRedis {
+			return "in memory", errSkipped
+		}
+		store, err := redisstore.New(appConfig.RedisURL)
+		if err != nil {
+			return "", err
+		}
+		defer store.Close()
+		return "Redis", store.Ping(ctx)
+	})
+	d.check("realtime bus", func(ctx context.Context) (string, error) {
+		if appConfig.RealtimeBus == config.RealtimeBusPostgres {
+			if !ok {
+				return "needs the database", errSkipped
+			}
+			bus, err := pgbus.New(dbClient.SQLDB, dbClient.Config.PostgresDSN(), pgbus.DefaultChannel)
+			if err != nil {
+				return "", err
+			}
+			defer bus.Close()
+			return "Postgres LISTEN/NOTIFY", bus.Ping(ctx)
+		}
+		if appConfig.RealtimeBus != config.RealtimeBusRedis {
+			return "single instance", errSkipped
+		}
+		bus, err := redisbus.New(appConfig.RedisURL, redisbus.DefaultChannel)
+		if err != nil {
+			return "", err
+		}
+		defer bus.Close()
+		return "Redis", bus.Ping(ctx)
+	})
+	d.check("search", func(ctx context.Context) (string, error) {
+		if !ok {
+			return "needs the database", errSkipped
+		}
+		engine, err := services.NewSearchEngine(ctx, appConfig, dbClient)
+		if err != nil {
+			return "", err
+		}
+		if engine == nil {
+			return "Postgres full-text search", errSkipped
+		}
+		return "OpenSearch index " + appConfig.OpenSearchIndex, nil
+	})
+	d.check("mail", func(ctx context.Context) (string, error) {
+		if _, err := services.NewMailer(appConfig); err != nil {
+			return "", err
+		}
+		if appConfig.Mailer != config.MailerSMTP {
+			return "logged, not sent", errSkipped
+		}
+		address := net.JoinHostPort(appConfig.SMTPHost, strconv.Itoa(appConfig.SMTPPort))
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return "", err
+		}
+		conn.Close()
+		return "SMTP at " + address, nil
+	})
+	d.check("push", func(context.Context) (string, error) {
+		if _, err := services.NewPushSenders(appConfig); err != nil {
+			return "", err
+		}
+		if appConfig.Push != config.PushLive {
+			return "logged, not sent", errSkipped
+		}
+		return "credentials loaded", nil
+	})
+	return d.report()
+}
+
+// checkStorage writes, reads back and deletes an object, which proves the
+// configured credentials may do all three.
+func checkStorage(ctx context.Context, appConfig *config.ApplicationConfig) (string, error) {
+	store, err := services.NewStorage(appConfig)
+	if err != nil {
+		return "", err
+	}
+	key := "doctor-" + uuid.NewString()
+	probe := []byte("afrochat doctor")
+	if err := store.Put(ctx, key, bytes.NewReader(probe), int64(len(probe)), "text/plain"); err != nil {
+		return "", fmt.Errorf("cannot write: %w", err)
+	}
+	body, err := store.Open(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("cannot read: %w", err)
+	}
+	read, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return "", fmt.Errorf("cannot read: %w", err)
+	}
+	if !bytes.Equal(read, probe) {
+		return "", errors.New("read back different content than written")
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		return "", fmt.Errorf("cannot delete: %w", err)
+	}
+	switch appConfig.StorageBackend {
+	case config.StorageS3:
+		return "S3 bucket " + appConfig.S3Bucket, nil
+	case config.StorageGCS:
+		return "GCS bucket " + appConfig.GCSBucket, nil
+	}
+	return "local directory " + appConfig.StorageLocalDir, nil
+}
+
+// report prints the summary line and returns the exit code.
+func (d *doctor) report() int {
+	if d.failed > 0 {
+		fmt.Printf("\n%d check(s) failed\n", d.failed)
+		return 1
+	}
+	fmt.Println("\nAll checks passed")
+	return 0
+}
@@ -1,45 +1,1112 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
 
+	"github.com/dfunani/AfroChat/backend/pkg/abuse"
+	"github.com/dfunani/AfroChat/backend/pkg/archive"
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/capture"
+	"github.com/dfunani/AfroChat/backend/pkg/chaos"
+	"github.com/dfunani/AfroChat/backend/pkg/commands"
 	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/migrations"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/deeplink"
+	"github.com/dfunani/AfroChat/backend/pkg/deprecation"
+	"github.com/dfunani/AfroChat/backend/pkg/emoji"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/experiments"
+	"github.com/dfunani/AfroChat/backend/pkg/idgen"
+	"github.com/dfunani/AfroChat/backend/pkg/lifecycle"
+	"github.com/dfunani/AfroChat/backend/pkg/loadshed"
+	"github.com/dfunani/AfroChat/backend/pkg/logging"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit/redisstore"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime/pgbus"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime/redisbus"
+	"github.com/dfunani/AfroChat/backend/pkg/reminders"
+	"github.com/dfunani/AfroChat/backend/pkg/replay"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/secretbox"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
 	"github.com/dfunani/AfroChat/backend/services"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplay(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "doctor" {
+		os.Exit(runDoctor(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		os.Exit(runReindex(os.Args[2:]))
+	}
+
 	// Load configuration
-	appConfig := config.LoadApplicationConfig()
+	appConfig, err := config.LoadApplicationConfig()
+	if err != nil {
+		fatal("Failed to load configuration", err)
+	}
+
+	// Structured logs, tagged with the ID of the request they belong to
+	logLevel, err := logging.ParseLevel(appConfig.LogLevel)
+	if err != nil {
+		fatal("Failed to configure logging", err)
+	}
+	slog.SetDefault(logging.New(os.Stderr, appConfig.LogFormat, logLevel))
+
+	// Teardown steps run in reverse order, so the database is closed last
+	lifecycleManager := lifecycle.New(appConfig.ShutdownTimeout)
+
+	// Spans are exported to an OpenTelemetry collector when one is
+	// configured, until everything else has shut down
+	if appConfig.TracingEndpoint != "" {
+		exporter := tracing.NewOTLPExporter(appConfig.TracingEndpoint, appConfig.TracingServiceName)
+		exporter.Start()
+		tracing.SetDefault(tracing.NewTracer(exporter, appConfig.TracingSampleRate))
+		lifecycleManager.OnShutdown("trace exporter", exporter.Shutdown)
+		slog.Info("Tracing enabled", "endpoint", appConfig.TracingEndpoint, "sample_rate", appConfig.TracingSampleRate)
+	}
+
 	dbClient, err := services.CreateDatabaseClient(appConfig)
 	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		fatal("Failed to initialize database", err)
+	}
+	lifecycleManager.OnShutdown("database", func(context.Context) error { return dbClient.Close() })
+	dbMonitorCtx, stopDBMonitor := context.WithCancel(context.Background())
+	go dbClient.Monitor(dbMonitorCtx, appConfig.DBHealthInterval)
+	lifecycleManager.OnShutdown("database monitor", func(context.Context) error {
+		stopDBMonitor()
+		return nil
+	})
+	if appConfig.TracingEndpoint != "" {
+		if err := dbClient.DB.Use(database.QueryTracer{}); err != nil {
+			fatal("Failed to trace database queries", err)
+		}
+	}
+
+	readiness := services.NewReadiness()
+	readiness.Add("database", dbClient.Health)
+
+	// Refuse to serve against a schema missing migrations this build
+	// needs. SQLite databases, for local development, get theirs from the
+	// models instead.
+	if appConfig.DBDriver == database.DriverSQLite {
+		if err := dbClient.CreateSQLiteSchema(); err != nil {
+			fatal("Failed to create database schema", err)
+		}
+		slog.Warn("Using SQLite; Postgres-only features such as message search will fail", "path", appConfig.DBPath)
+	} else {
+		migrator, err := migrations.New(dbClient.SQLDB)
+		if err != nil {
+			fatal("Failed to load migrations", err)
+		}
+		if err := migrator.Check(context.Background()); err != nil {
+			fatal("Failed to check database schema", err)
+		}
+		readiness.Add("migrations", migrator.Check)
+	}
+
+	emojiCatalog, err := emoji.Load()
+	if err != nil {
+		fatal("Failed to load emoji catalog", err)
+	}
+
+	entitlements.SetContentLimits(appConfig.ContentLimits)
+	entitlements.SetRoomCaps(appConfig.RoomCaps)
+	trust.SetPolicy(appConfig.TrustPolicy)
+
+	tokens := auth.NewTokenManager(appConfig.JWTSecret, appConfig.JWTTTL, appConfig.RefreshTTL)
+
+	oauthSignIn, err := services.NewOAuth(dbClient, tokens, appConfig)
+	if err != nil {
+		fatal("Failed to initialize OAuth sign-in", err)
+	}
+
+	mail, err := services.NewMailer(appConfig)
+	if err != nil {
+		fatal("Failed to initialize mailer", err)
+	}
+
+	// Test mode keeps email and pushes for the debug endpoints to list, and
+	// seeds generated IDs so runs repeat
+	var captured *capture.Store
+	if appConfig.TestMode {
+		captured = capture.New()
+		mail = captured.Mailer()
+		idgen.Seed(appConfig.TestModeSeed)
+	}
+	mail = services.RequireMarketingConsent(mail, dbClient)
+
+	exposures := experiments.LogSink{}
+
+	// Anonymized usage sampling for product analytics, for users who opt in
+	if usageSampler := services.NewUsageSampler(appConfig, dbClient); usageSampler != nil {
+		services.SetUsageSampler(usageSampler)
+		usageSampler.Start()
+		lifecycleManager.OnShutdown("usage sampler", usageSampler.Shutdown)
+	}
+
+	// Slash commands run from the send path
+	slashCommands := commands.NewRegistry()
+	builtinCommands := []commands.Command{commands.Shrug(), reminders.Command(dbClient)}
+	if appConfig.GiphyAPIKey != "" {
+		builtinCommands = append(builtinCommands, commands.Giphy(commands.GiphySearchURL, appConfig.GiphyAPIKey))
+	}
+	for _, command := range builtinCommands {
+		if err := slashCommands.Register(command); err != nil {
+			fatal("Failed to register slash command", err)
+		}
+	}
+	services.SetCommands(slashCommands)
+
+	// Abuse scoring of signups and sends
+	if appConfig.AbuseShadowThreshold > 0 {
+		services.SetAbuseEvaluator(&abuse.Evaluator{
+			Scorers:   []abuse.Scorer{abuse.HeuristicScorer{}},
+			Threshold: appConfig.AbuseShadowThreshold,
+		})
+	}
+
+	clientConfig := services.NewClientConfigCache(dbClient)
+	cacheHints := services.NewCacheHints(appConfig)
+
+	// Rate limits, shared between instances through Redis when they run
+	// behind a load balancer
+	var rateStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if appConfig.RateLimitStore == config.RateLimitStoreRedis {
+		redisStore, err := redisstore.New(appConfig.RedisURL)
+		if err != nil {
+			fatal("Failed to initialize rate limiter", err)
+		}
+		lifecycleManager.OnShutdown("rate limiter", func(context.Context) error { return redisStore.Close() })
+		readiness.Add("rate limiter", redisStore.Ping)
+		rateStore = redisStore
+	}
+	limiter := ratelimit.New(rateStore)
+
+	// Public profiles, cached in Redis for the lookups behind every
+	// rendered message
+	profileCache, err := services.NewProfileCache(appConfig)
+	if err != nil {
+		fatal("Failed to initialize profile cache", err)
+	}
+	if profileCache != nil {
+		if err := profileCache.Listen(context.Background()); err != nil {
+			fatal("Failed to subscribe to profile invalidations", err)
+		}
+		lifecycleManager.OnShutdown("profile cache", func(context.Context) error { return profileCache.Close() })
+		readiness.Add("profile cache", profileCache.Ping)
+		services.SetProfileCache(profileCache)
 	}
 
-	defer dbClient.Close()
+	store, err := services.NewStorage(appConfig)
+	if err != nil {
+		fatal("Failed to initialize attachment storage", err)
+	}
+
+	// Content of archived messages, fetched back as they are queried
+	messageArchive := archive.New(store)
+	if err := dbClient.DB.Use(messageArchive); err != nil {
+		fatal("Failed to initialize message archive", err)
+	}
+
+	// Channel webhooks, delivered to the outgoing ones as background jobs
+	webhooks := services.NewWebhooks(dbClient, shortlink.Scanner{BlockedHosts: appConfig.ShortLinkBlockedHosts})
+
+	// RSS and Atom feeds of channels, posted into them by their bots
+	roomFeeds := services.NewRoomFeeds(dbClient, shortlink.Scanner{BlockedHosts: appConfig.ShortLinkBlockedHosts})
+
+	// Channel email addresses, posted into by the mail provider's inbound
+	// webhook and relayed to members who prefer mail
+	roomEmail := services.NewRoomEmail(dbClient, store, mail, appConfig.InboundEmailDomain, appConfig.InboundEmailToken)
+
+	// Channel automation rules, run as background jobs when their
+	// triggers fire
+	automations := services.NewAutomations(dbClient, webhooks)
+
+	// Billable usage, metered daily and exported to the billing provider
+	usage := services.NewUsage(dbClient, appConfig)
+
+	// Secrets kept in the database, sealed with SECRETS_KEY
+	var secrets *secretbox.Box
+	if appConfig.SecretsKey != "" {
+		secrets, err = secretbox.New(appConfig.SecretsKey)
+		if err != nil {
+			fatal("Failed to initialize secrets", err)
+		}
+	}
+
+	// Scheduled room exports, to owners' buckets or by email
+	roomExports := services.NewRoomExports(dbClient, store, mail, secrets)
+
+	hub := realtime.NewHub()
+	if appConfig.RealtimeBus != config.RealtimeBusLocal {
+		var bus interface {
+			realtime.Bus
+			Ping(context.Context) error
+		}
+		var err error
+		if appConfig.RealtimeBus == config.RealtimeBusPostgres {
+			bus, err = pgbus.New(dbClient.SQLDB, dbClient.Config.PostgresDSN(), pgbus.DefaultChannel)
+		} else {
+			bus, err = redisbus.New(appConfig.RedisURL, redisbus.DefaultChannel)
+		}
+		if err != nil {
+			fatal("Failed to initialize realtime bus", err)
+		}
+		lifecycleManager.OnShutdown("realtime bus", func(context.Context) error { return bus.Close() })
+		readiness.Add("realtime bus", bus.Ping)
+
+		if err := hub.UseBus(context.Background(), bus); err != nil {
+			fatal("Failed to subscribe to realtime bus", err)
+		}
+	}
+
+	// Push notifications for members without an open connection
+	senders, err := services.NewPushSenders(appConfig)
+	if err != nil {
+		fatal("Failed to initialize push notifications", err)
+	}
+	if captured != nil {
+		senders = captured.PushSenders()
+	}
+	notifier := services.NewNotifier(dbClient, senders, mail)
+	notifier.Start(appConfig.NotificationWorkers)
+	lifecycleManager.OnShutdown("push notifications", notifier.Shutdown)
+
+	// Message search, in Postgres until it outgrows it. A search cluster
+	// is kept up to date by background jobs.
+	var searchIndex search.Index = search.NewPostgresIndex(dbClient.DB)
+	searchEngine, err := services.NewSearchEngine(context.Background(), appConfig, dbClient)
+	if err != nil {
+		fatal("Failed to initialize search", err)
+	}
+	if searchEngine != nil {
+		searchIndex = services.QueuedSearchIndex(dbClient, searchEngine)
+	}
+
+	// Reply suggestions for direct messages, for users who opt in
+	suggester := services.NewSuggester(dbClient, hub, services.NewSuggestionProvider(appConfig))
+	suggester.Start()
+	lifecycleManager.OnShutdown("reply suggestions", suggester.Shutdown)
+
+	// Background jobs too slow to run during a request, and periodic
+	// housekeeping
+	jobRunner := services.NewJobRunner(dbClient)
+	jobRunner.Handle(services.JobDataExport, services.BuildDataExport(dbClient, store))
+	jobRunner.Handle(services.JobAccountErasure, services.EraseAccount(dbClient, store, searchIndex))
+	jobRunner.Handle(services.JobLegalExport, services.BuildLegalExport(dbClient, store))
+	jobRunner.Handle(services.JobWebhookDelivery, services.DeliverWebhook(webhooks))
+	jobRunner.Handle(services.JobArchiveDelivery, services.DeliverArchive(webhooks))
+	jobRunner.Handle(services.JobEmailRelay, services.RelayEmail(roomEmail))
+	jobRunner.Handle(services.JobAutomation, services.RunAutomation(automations, hub, notifier, suggester, searchIndex))
+	jobRunner.Handle(services.JobChannelAdmission, services.NotifyChannelAdmission(dbClient, notifier))
+	jobRunner.Handle(services.JobRoomExport, services.DeliverRoomExport(roomExports))
+	jobRunner.Handle(services.JobTranscript, services.RenderTranscript(dbClient, store))
+	if searchEngine != nil {
+		jobRunner.Handle(services.JobSearchIndex, services.IndexMessage(dbClient, searchEngine))
+	}
+	jobRunner.Schedule(services.JobPruneDevices, services.PushTokenPruneInterval, notifier.PruneDevices(appConfig.PushTokenMaxAge))
+	jobRunner.Schedule(services.JobPurgeCredentials, services.MaintenanceInterval, services.PurgeCredentials(dbClient))
+	jobRunner.Schedule(services.JobPurgeDataExports, services.MaintenanceInterval, services.PurgeDataExports(dbClient, store))
+	jobRunner.Schedule(services.JobPurgeTranscripts, services.MaintenanceInterval, services.PurgeTranscripts(dbClient, store))
+	jobRunner.Schedule(services.JobPurgeFailedJobs, services.MaintenanceInterval, services.PurgeFailedJobs(dbClient))
+	jobRunner.Schedule(services.JobPurgeInbox, services.MaintenanceInterval, services.PurgeInbox(dbClient))
+	jobRunner.Schedule(services.JobRoomExports, services.RoomExportScanInterval, services.QueueRoomExports(roomExports))
+	jobRunner.Schedule(services.JobDeliverReminders, services.ReminderScanInterval, services.DeliverReminders(dbClient, hub, notifier))
+	jobRunner.Schedule(services.JobRemindEvents, services.EventReminderScanInterval, services.RemindEvents(dbClient, hub, notifier))
+	jobRunner.Schedule(services.JobPollRoomFeeds, services.RoomFeedScanInterval, services.PollRoomFeeds(roomFeeds, hub, notifier, suggester, searchIndex))
+	jobRunner.Schedule(services.JobEmailDigests, services.DigestScanInterval, services.SendEmailDigests(dbClient, mail))
+	if appConfig.DBDriver == database.DriverPostgres {
+		jobRunner.Schedule(services.JobMessagePartitions, services.MessagePartitionInterval, services.MaintainMessagePartitions(dbClient, appConfig.MessageRetention))
+	}
+	if appConfig.MessageArchiveAfter > 0 {
+		jobRunner.Schedule(services.JobArchiveMessages, services.MessageArchiveInterval, services.ArchiveMessages(dbClient, store, messageArchive, appConfig.MessageArchiveAfter))
+	}
+	jobRunner.Schedule(services.JobReconcileCounters, services.CounterReconcileInterval, services.ReconcileCounters(dbClient))
+	jobRunner.Schedule(services.JobIdentityClusters, services.IdentityClusterInterval, services.ComputeIdentityClusters(dbClient))
+	jobRunner.Schedule(services.JobAwardBadges, services.BadgeInterval, services.AwardBadges(dbClient))
+	jobRunner.Schedule(services.JobMeterUsage, services.UsageMeterInterval, services.MeterUsage(usage))
+	if appConfig.OnboardingSender != "" {
+		jobRunner.Schedule(services.JobOnboardingDrip, services.OnboardingScanInterval, services.SendOnboarding(dbClient, hub, notifier, suggester, searchIndex, appConfig.OnboardingSender))
+	}
+	if appConfig.JobAlertWebhookURL != "" {
+		jobRunner.OnFailure(services.JobAlertWebhook(appConfig.JobAlertWebhookURL, appConfig.JobAlertWebhookSecret))
+	}
+	jobRunner.Start(appConfig.JobWorkers)
+	lifecycleManager.OnShutdown("background jobs", jobRunner.Shutdown)
+
+	// Short links, their destinations checked against blocked hosts
+	shortLinks := services.NewShortLinks(dbClient, shortlink.Scanner{BlockedHosts: appConfig.ShortLinkBlockedHosts}, appConfig.ShortLinkBaseURL, appConfig.ShortLinkTTL)
+
+	// Share links of messages, rooms and profiles
+	appLinks, err := deeplink.NewBase(appConfig.AppURL)
+	if err != nil {
+		fatal("Failed to initialize share links", err)
+	}
+	deepLinks := services.NewDeepLinks(dbClient, appLinks, shortLinks)
+	publicPages := services.NewPublicPages(dbClient, appLinks)
+
+	// CAPTCHAs for joining large public channels that ask for them
+	joinCaptcha := services.NewJoinCaptcha(appConfig)
+
+	// Settings guessed for new users from where they register
+	onboarding := services.NewOnboarding(dbClient, services.NewGeoIPProvider(appConfig))
+
+	services.RegisterRealtimeHandlers(hub, dbClient, notifier, suggester, searchIndex, limiter, appConfig.RateLimitMessages)
+	services.RegisterResumeTokens(hub, tokens)
+
+	presenceTracker := services.NewPresenceTracker(hub, dbClient)
+	notifier.SuppressWhileViewing(presenceTracker)
+	presenceCtx, stopPresence := context.WithCancel(context.Background())
+	go presenceTracker.Run(presenceCtx, services.PresenceRefreshInterval)
+	lifecycleManager.OnShutdown("presence tracker", func(context.Context) error {
+		stopPresence()
+		return nil
+	})
+
+	// Load shedding, sparing messaging at peak hours
+	var shedder *loadshed.Shedder
+	if appConfig.LoadShedEnabled {
+		shedder = services.NewLoadShedder(appConfig, dbClient, hub)
+		shedCtx, stopShedding := context.WithCancel(context.Background())
+		go shedder.Run(shedCtx)
+		lifecycleManager.OnShutdown("load shedder", func(context.Context) error {
+			stopShedding()
+			return nil
+		})
+	}
+	shedPreviews := services.Shed(shedder, loadshed.Lowest)
+	shedSearch := services.Shed(shedder, loadshed.Low)
+
+	// Fault injection for resilience testing, refused in production
+	var injector *chaos.Injector
+	if appConfig.ChaosEnabled {
+		injector = chaos.New(chaos.Config{
+			LatencyRate:   appConfig.ChaosLatencyRate,
+			MaxLatency:    appConfig.ChaosMaxLatency,
+			FrameDropRate: appConfig.ChaosFrameDropRate,
+			DBErrorRate:   appConfig.ChaosDBErrorRate,
+		})
+		hub.DropFrames(injector.DropFrame)
+	}
+
+	// Anonymized traffic recording for the replay command
+	var recorder *replay.Recorder
+	if appConfig.TraceFile != "" {
+		recorder, err = replay.NewRecorder(appConfig.TraceFile, appConfig.TraceSampleRate)
+		if err != nil {
+			fatal("Failed to start trace recording", err)
+		}
+		lifecycleManager.OnShutdown("trace recorder", func(context.Context) error { return recorder.Close() })
+		services.TraceEvents(hub, recorder)
+	}
+
+	// Registered last so it runs first: clients are told to reconnect
+	// elsewhere while the database and bus are still up.
+	lifecycleManager.OnShutdown("WebSocket connections", hub.Shutdown)
+
+	// Routes scheduled for removal are marked here with Deprecate
+	deprecations := deprecation.NewRegistry()
 
 	// Set Gin mode based on environment
-	if appConfig.Env == "production" {
+	if appConfig.Env == config.EnvProduction {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Create router
-	router := gin.Default()
+	// Create router. Unknown routes and methods answer in the API's error
+	// envelope.
+	router := gin.New()
+	if err := router.SetTrustedProxies(appConfig.TrustedProxies); err != nil {
+		fatal("Failed to configure trusted proxies", err)
+	}
+	router.HandleMethodNotAllowed = true
+	router.NoRoute(services.RouteNotFound)
+	router.NoMethod(services.MethodNotAllowed)
 
 	// Add middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	router.Use(services.RequestLogger())
+	router.Use(services.Tracing())
+	router.Use(services.RecoverPanic())
 	router.Use(services.CorsMiddleware())
+	router.Use(services.DatabaseAvailable(dbClient, max(int(appConfig.DBHealthInterval.Seconds()), 1)))
+	router.Use(services.DeprecationMiddleware(deprecations))
+	if recorder != nil {
+		router.Use(services.TraceMiddleware(recorder))
+	}
+	if injector != nil {
+		router.Use(services.ChaosMiddleware(injector))
+	}
 
 	// Health check endpoints
-	router.GET("/api/v1/health", services.HealthCheck)
+	router.GET("/api/v1/health", func(c *gin.Context) { services.HealthCheck(c, appConfig) })
 	router.GET("/api/v1/health/db", func(c *gin.Context) { services.DatabaseHealthCheck(c, dbClient) })
+	router.GET("/livez", services.Livez)
+	router.GET("/readyz", func(c *gin.Context) { services.Readyz(c, readiness) })
+
+	// Prometheus scrape endpoint, enabled by setting a metrics token
+	if appConfig.MetricsToken != "" {
+		router.GET("/metrics", func(c *gin.Context) { services.Metrics(c, appConfig.MetricsToken) })
+	}
+
+	// Captured side effects, for end-to-end tests in test mode
+	if captured != nil {
+		router.GET("/api/v1/debug/captures", func(c *gin.Context) { services.ListCaptures(c, captured, appConfig.TestModeToken) })
+		router.DELETE("/api/v1/debug/captures", func(c *gin.Context) { services.ClearCaptures(c, captured, appConfig.TestModeToken) })
+		router.POST("/api/v1/debug/reset", func(c *gin.Context) {
+			services.ResetTestData(c, dbClient, captured, appConfig.TestModeToken, appConfig.TestModeSeed)
+		})
+		router.POST("/api/v1/debug/fixtures", func(c *gin.Context) {
+			services.LoadFixtures(c, dbClient, appConfig.TestModeToken, appConfig.TestModeSeed)
+		})
+	}
+
+	// Client configuration, fetched by apps before sign-in
+	router.GET("/api/v1/client-config", func(c *gin.Context) { services.GetClientConfig(c, clientConfig) })
+
+	// Short links are followed from browsers and other apps, which send no
+	// client version
+	router.GET("/l/:code", func(c *gin.Context) { services.FollowShortLink(c, shortLinks) })
+
+	// Incoming webhooks are called by third-party services, authenticated
+	// by the token in their URL
+	hookLimit := services.RateLimit(limiter, "hooks", appConfig.RateLimitMessages, services.ByClientIP)
+	router.POST("/api/v1/hooks/:token", hookLimit, func(c *gin.Context) {
+		services.PostIncomingWebhook(c, dbClient, hub, notifier, suggester, searchIndex)
+	})
+
+	// USSD sessions are relayed by the aggregator, authenticated by the
+	// token in the callback URL
+	if appConfig.USSDCallbackToken != "" {
+		ussdGateway := services.NewUSSD(dbClient, hub, notifier, suggester, searchIndex, appConfig.USSDCallbackToken)
+		router.POST("/api/v1/ussd/:token", hookLimit, func(c *gin.Context) { services.USSDCallback(c, ussdGateway) })
+	}
+
+	// Inbound email for channels is posted by the mail provider,
+	// authenticated by the token in the webhook URL
+	if roomEmail.Enabled() {
+		router.POST("/api/v1/email/:token", hookLimit, func(c *gin.Context) {
+			services.ReceiveRoomEmail(c, roomEmail, hub, notifier, suggester, searchIndex)
+		})
+	}
+
+	// Public profiles, room previews and the pages for search engines are
+	// fetched without signing in by link unfurlers and crawlers
+	publicLimit := services.RateLimit(limiter, "public-pages", appConfig.RateLimitPublicPages, services.ByClientIP)
+	router.GET("/api/v1/public/users/:username", shedPreviews, publicLimit, func(c *gin.Context) { services.GetPublicProfilePage(c, publicPages) })
+	router.GET("/api/v1/public/rooms/:id", shedPreviews, publicLimit, func(c *gin.Context) { services.GetPublicRoomPreview(c, publicPages) })
+	router.GET("/sitemap.xml", shedPreviews, publicLimit, func(c *gin.Context) { services.GetSitemap(c, publicPages) })
+	router.GET("/og/rooms/:id", shedPreviews, publicLimit, func(c *gin.Context) { services.GetRoomOpenGraph(c, publicPages) })
+
+	// Outdated clients can still reach the routes above, so they can find
+	// out they need to upgrade; everything registered below rejects them.
+	router.Use(services.ClientVersionMiddleware(clientConfig))
+
+	// Auth endpoints, limited per client address against credential
+	// stuffing and mail flooding, and logins per account against password
+	// guessing spread over many addresses
+	registerLimit := services.RateLimit(limiter, "register", appConfig.RateLimitRegister, services.ByClientIP)
+	loginLimit := services.RateLimit(limiter, "login", appConfig.RateLimitLogin, services.ByClientIP)
+	loginAccountLimit := services.RateLimit(limiter, "login-account", appConfig.RateLimitLoginAccount, services.ByLoginEmail)
+	passwordResetLimit := services.RateLimit(limiter, "password-reset", appConfig.RateLimitPasswordReset, services.ByClientIP)
+	router.POST("/api/v1/auth/register", registerLimit, func(c *gin.Context) { services.Register(c, dbClient, tokens, mail, appConfig, onboarding) })
+	router.POST("/api/v1/auth/login", loginLimit, loginAccountLimit, func(c *gin.Context) { services.Login(c, dbClient, tokens) })
+	router.POST("/api/v1/auth/refresh", func(c *gin.Context) { services.Refresh(c, dbClient, tokens) })
+	router.POST("/api/v1/auth/verify-email", func(c *gin.Context) { services.VerifyEmail(c, dbClient) })
+	router.POST("/api/v1/auth/forgot-password", passwordResetLimit, func(c *gin.Context) { services.ForgotPassword(c, dbClient, mail, appConfig) })
+	router.POST("/api/v1/auth/reset-password", passwordResetLimit, func(c *gin.Context) { services.ResetPassword(c, dbClient) })
+	router.POST("/api/v1/auth/email-change/confirm", passwordResetLimit, func(c *gin.Context) { services.ConfirmEmailChange(c, dbClient, hub, mail, appConfig) })
+	router.POST("/api/v1/auth/appeals", passwordResetLimit, func(c *gin.Context) { services.FileAppeal(c, dbClient) })
+	router.POST("/api/v1/auth/email-change/revert", passwordResetLimit, func(c *gin.Context) { services.RevertEmailChange(c, dbClient, hub) })
+
+	// OAuth sign-in, driven by the browser rather than the app
+	router.GET("/api/v1/auth/oauth/:provider", loginLimit, func(c *gin.Context) { services.StartOAuth(c, oauthSignIn) })
+	router.GET("/api/v1/auth/oauth/:provider/callback", loginLimit, func(c *gin.Context) { services.OAuthCallback(c, oauthSignIn) })
+	router.POST("/api/v1/auth/oauth/:provider/callback", loginLimit, func(c *gin.Context) { services.OAuthCallback(c, oauthSignIn) })
+
+	// WebSocket endpoint authenticates its own handshake
+	router.GET("/api/v1/ws", func(c *gin.Context) { services.WebSocketHandler(c, dbClient, tokens, hub) })
+
+	// Authenticated routes that work before the current legal documents
+	// are accepted: accepting them, and signing out
+	authenticated := router.Group("/api/v1")
+	authenticated.Use(services.AuthMiddleware(dbClient, tokens), services.WorkspaceMembership(dbClient), services.RequireMethodScope(), services.TierRateLimit(limiter))
+	authenticated.POST("/auth/logout", services.V1(services.Logout(dbClient)))
+	authenticated.GET("/legal/pending", services.V1(services.ListPendingLegalDocuments(dbClient)))
+	authenticated.POST("/legal/accept", services.V1(services.AcceptLegalDocuments(dbClient)))
+
+	// Authenticated routes
+	authorized := router.Group("/api/v1")
+	authorized.Use(services.AuthMiddleware(dbClient, tokens), services.WorkspaceMembership(dbClient), services.RequireMethodScope(), services.TierRateLimit(limiter), services.RequireLegalAcceptance(dbClient))
+
+	// Legal endpoints
+	router.GET("/api/v1/legal", func(c *gin.Context) { services.ListLegalDocuments(c, dbClient) })
+
+	// Emoji catalog, shared by every client for consistent search
+	router.GET("/api/v1/emoji", func(c *gin.Context) { services.GetEmoji(c, emojiCatalog, cacheHints) })
+
+	// Cache lifetimes for clients to follow, tuned here rather than in the
+	// apps
+	router.GET("/api/v1/cache-manifest", func(c *gin.Context) { services.GetCacheManifest(c, cacheHints) })
+
+	// Waitlist endpoints
+	router.POST("/api/v1/waitlist", func(c *gin.Context) { services.JoinWaitlist(c, dbClient) })
+	router.GET("/api/v1/waitlist/:token", func(c *gin.Context) { services.WaitlistPosition(c, dbClient) })
+
+	authorized.POST("/auth/verify-email/resend", func(c *gin.Context) { services.ResendVerification(c, dbClient, mail, appConfig) })
+
+	// User endpoints
+	authorized.POST("/users/resolve", func(c *gin.Context) { services.ResolveUsers(c, dbClient) })
+	authorized.GET("/users/me", services.V1(services.GetMe))
+	authorized.PATCH("/users/me", services.V1(services.UpdateMe(dbClient)))
+	authorized.DELETE("/users/me", services.V1(services.DeleteMe(dbClient)))
+	authorized.POST("/users/me/email-change", services.V1(services.RequestEmailChange(dbClient, mail, appConfig)))
+	authorized.GET("/users/me/email-change", services.V1(services.GetEmailChange(dbClient)))
+	authorized.DELETE("/users/me/email-change", services.V1(services.CancelEmailChange(dbClient)))
+	authorized.POST("/users/me/export", services.V1(services.RequestDataExport(dbClient)))
+	authorized.GET("/users/me/exports/:id", services.V1(services.GetDataExport(dbClient, store)))
+	authorized.GET("/users/me/exports/:id/content", func(c *gin.Context) { services.DownloadDataExport(c, dbClient, store) })
+	authorized.GET("/users/me/sessions", services.V1(services.ListSessions(dbClient)))
+	authorized.DELETE("/users/me/sessions", services.V1(services.RevokeOtherSessions(dbClient)))
+	authorized.DELETE("/users/me/sessions/:id", services.V1(services.RevokeSession(dbClient)))
+	authorized.GET("/users/me/identities", services.V1(services.ListIdentities(dbClient)))
+	authorized.DELETE("/users/me/identities/:provider", services.V1(services.UnlinkIdentity(dbClient)))
+	authorized.GET("/users/me/suggestions", services.V1(services.GetSettingsSuggestions(dbClient)))
+	authorized.DELETE("/users/me/suggestions", services.V1(services.DismissSettingsSuggestions(dbClient)))
+	authorized.GET("/users/me/auto-replies", services.V1(services.ListAutoReplyRules(dbClient)))
+	authorized.POST("/users/me/auto-replies", services.V1(services.CreateAutoReplyRule(dbClient)))
+	authorized.PUT("/users/me/auto-replies/:id", services.V1(services.UpdateAutoReplyRule(dbClient)))
+	authorized.DELETE("/users/me/auto-replies/:id", services.V1(services.DeleteAutoReplyRule(dbClient)))
+	authorized.GET("/users/me/strikes", services.V1(services.ListMyStrikes(dbClient)))
+	authorized.GET("/users/me/consents", services.V1(services.GetConsents(dbClient)))
+	authorized.PUT("/users/me/consents", services.V1(services.UpdateConsents(dbClient)))
+	authorized.GET("/users/me/onboarding", services.V1(services.GetMyOnboarding(dbClient)))
+	authorized.POST("/users/me/onboarding/:key/complete", services.V1(services.CompleteOnboardingStep(dbClient)))
+	authorized.GET("/users/me/referrals", services.V1(services.GetReferrals(dbClient)))
+	authorized.GET("/users/me/badges", services.V1(services.ListMyBadges(dbClient)))
+	authorized.GET("/users/me/usage", services.V1(services.GetMyUsage(dbClient)))
+	authorized.PATCH("/users/me/badges/:badge", services.V1(services.UpdateMyBadge(dbClient)))
+	authorized.GET("/users/me/business", services.V1(services.GetMyBusinessProfile(dbClient)))
+	authorized.PUT("/users/me/business", services.V1(services.SaveMyBusinessProfile(dbClient)))
+	authorized.DELETE("/users/me/business", services.V1(services.DeleteMyBusinessProfile(dbClient)))
+	authorized.GET("/users/me/business/catalog", services.V1(services.ListCatalogItems(dbClient)))
+	authorized.POST("/users/me/business/catalog", services.V1(services.CreateCatalogItem(dbClient)))
+	authorized.PUT("/users/me/business/catalog/:id", services.V1(services.UpdateCatalogItem(dbClient)))
+	authorized.DELETE("/users/me/business/catalog/:id", services.V1(services.DeleteCatalogItem(dbClient)))
+	authorized.GET("/users/me/business/quick-replies", services.V1(services.ListQuickReplies(dbClient)))
+	authorized.POST("/users/me/business/quick-replies", services.V1(services.CreateQuickReply(dbClient)))
+	authorized.PUT("/users/me/business/quick-replies/:id", services.V1(services.UpdateQuickReply(dbClient)))
+	authorized.DELETE("/users/me/business/quick-replies/:id", services.V1(services.DeleteQuickReply(dbClient)))
+	authorized.GET("/users/me/business/labels", services.V1(services.ListLabels(dbClient)))
+	authorized.POST("/users/me/business/labels", services.V1(services.CreateLabel(dbClient)))
+	authorized.GET("/users/me/business/labels/export", func(c *gin.Context) { services.ExportLabeledConversations(c, dbClient) })
+	authorized.PUT("/users/me/business/labels/:id", services.V1(services.UpdateLabel(dbClient)))
+	authorized.DELETE("/users/me/business/labels/:id", services.V1(services.DeleteLabel(dbClient)))
+	authorized.GET("/users/:id", services.V1(services.GetUser(dbClient)))
+	authorized.POST("/users/:id/report", services.V1(services.ReportUser(dbClient)))
+	authorized.GET("/users/:id/business", services.V1(services.GetBusinessProfile(dbClient)))
+	authorized.GET("/users/:id/badges", services.V1(services.ListUserBadges(dbClient)))
+
+	// Conversation endpoints
+	authorized.POST("/conversations", func(c *gin.Context) { services.CreateConversation(c, dbClient) })
+	authorized.GET("/commands", services.V1(services.ListCommands))
+	authorized.GET("/reminders", services.V1(services.ListReminders(dbClient)))
+	authorized.DELETE("/reminders/:id", services.V1(services.CancelReminder(dbClient)))
+	authorized.GET("/conversations", services.V1(services.ListConversations(dbClient)))
+	authorized.GET("/conversations/:id", services.V1(services.GetConversation(dbClient)))
+	authorized.GET("/conversations/:id/messages", services.V1(services.ListMessages(dbClient)))
+	authorized.GET("/conversations/:id/messages/range", services.V1(services.ListMessageRange(dbClient)))
+	authorized.POST("/conversations/:id/messages", services.RateLimit(limiter, services.MessageLimitName, appConfig.RateLimitMessages, services.ByUser), func(c *gin.Context) { services.SendMessage(c, dbClient, hub, notifier, suggester, searchIndex) })
+	authorized.POST("/messages", services.RateLimit(limiter, services.MessageLimitName, appConfig.RateLimitMessages, services.ByUser), func(c *gin.Context) { services.SendMessageToUsers(c, dbClient, hub, notifier, suggester, searchIndex) })
+	authorized.POST("/conversations/:id/read", services.V1(services.MarkRead(dbClient, hub)))
+	authorized.GET("/conversations/:id/receipts", services.V1(services.ListReceipts(dbClient)))
+	authorized.GET("/conversations/:id/audit", services.V1(services.VerifyAuditChain(dbClient)))
+	authorized.GET("/conversations/:id/notes", services.V1(services.GetRoomNotes(dbClient)))
+	authorized.POST("/conversations/:id/notes/edits", services.V1(services.EditRoomNotes(dbClient, hub)))
+	authorized.GET("/conversations/:id/notes/edits", services.V1(services.ListRoomNoteEdits(dbClient)))
+	authorized.GET("/conversations/:id/events", services.V1(services.ListRoomEvents(dbClient)))
+	authorized.GET("/conversations/:id/calendar.ics", func(c *gin.Context) { services.GetRoomCalendar(c, dbClient) })
+	authorized.GET("/conversations/:id/faq", services.V1(services.ListRoomFAQ(dbClient)))
+	authorized.POST("/conversations/:id/faq", services.V1(services.CreateRoomFAQ(dbClient)))
+	authorized.PUT("/conversations/:id/faq/:faqId", services.V1(services.UpdateRoomFAQ(dbClient)))
+	authorized.DELETE("/conversations/:id/faq/:faqId", services.V1(services.DeleteRoomFAQ(dbClient)))
+	authorized.GET("/conversations/:id/exports/schedules", services.V1(services.ListRoomExports(roomExports)))
+	authorized.POST("/conversations/:id/exports/schedules", services.V1(services.CreateRoomExport(roomExports)))
+	authorized.PATCH("/conversations/:id/exports/schedules/:scheduleId", services.V1(services.UpdateRoomExport(roomExports)))
+	authorized.DELETE("/conversations/:id/exports/schedules/:scheduleId", services.V1(services.DeleteRoomExport(roomExports)))
+	authorized.POST("/conversations/:id/exports/schedules/:scheduleId/run", services.V1(services.RunRoomExport(roomExports)))
+	authorized.GET("/conversations/:id/exports/deliveries", services.V1(services.ListRoomExportDeliveries(roomExports)))
+	authorized.GET("/conversations/:id/exports/deliveries/:deliveryId/content", func(c *gin.Context) { services.DownloadRoomExport(c, roomExports) })
+	authorized.POST("/conversations/:id/transcripts", services.V1(services.RequestTranscript(dbClient)))
+	authorized.GET("/conversations/:id/transcripts/:transcriptId", services.V1(services.GetTranscript(dbClient, store)))
+	authorized.GET("/conversations/:id/transcripts/:transcriptId/content", func(c *gin.Context) { services.DownloadTranscript(c, dbClient, store) })
+	authorized.GET("/sync", services.V1(services.Sync(dbClient)))
+	authorized.PUT("/sync/acks", services.V1(services.AckSync(dbClient)))
+	authorized.GET("/sync/catch-up", services.V1(services.CatchUp(dbClient)))
+	authorized.POST("/realtime/poll", services.V1(services.OpenLongPoll(hub)))
+	authorized.GET("/realtime/poll/:id", services.V1(services.PollLongPoll(hub)))
+	authorized.POST("/realtime/poll/:id/events", services.V1(services.SendLongPollEvent(hub)))
+	authorized.DELETE("/realtime/poll/:id", services.V1(services.CloseLongPoll(hub)))
+
+	// Message endpoints
+	authorized.PATCH("/messages/:id", services.V1(services.EditMessage(dbClient, hub, searchIndex)))
+	authorized.DELETE("/messages/:id", services.V1(services.DeleteMessage(dbClient, hub, searchIndex)))
+	authorized.GET("/messages/:id/edits", services.V1(services.ListMessageEdits(dbClient)))
+	authorized.POST("/messages/:id/report", services.V1(services.ReportMessage(dbClient)))
+	authorized.PUT("/messages/:id/rsvp", services.V1(services.RSVPToEvent(dbClient, hub)))
+	authorized.DELETE("/messages/:id/rsvp", services.V1(services.ClearEventRSVP(dbClient, hub)))
+	authorized.GET("/messages/:id/rsvps", services.V1(services.ListEventRSVPs(dbClient)))
+	authorized.POST("/messages/:id/ack", services.V1(services.AcknowledgeAnnouncement(dbClient, hub)))
+	authorized.GET("/messages/:id/acks", services.V1(services.GetAnnouncementAcks(dbClient)))
+
+	// Contact and block endpoints
+	authorized.POST("/contacts/requests", services.V1(services.SendContactRequest(dbClient, hub)))
+	authorized.GET("/contacts/requests", services.V1(services.ListContactRequests(dbClient)))
+	authorized.POST("/contacts/requests/:id/accept", services.V1(services.AcceptContactRequest(dbClient, hub)))
+	authorized.POST("/contacts/requests/:id/reject", services.V1(services.RejectContactRequest(dbClient)))
+	authorized.DELETE("/contacts/requests/:id", services.V1(services.CancelContactRequest(dbClient)))
+	authorized.GET("/contacts", services.V1(services.ListContacts(dbClient)))
+	discoveryLimit := services.RateLimit(limiter, "contact-discovery", appConfig.RateLimitDiscovery, services.ByUser)
+	authorized.POST("/contacts/discover", discoveryLimit, services.V1(services.DiscoverContacts(dbClient)))
+	authorized.DELETE("/contacts/:user_id", services.V1(services.RemoveContact(dbClient)))
+	authorized.POST("/blocks", services.V1(services.BlockUser(dbClient)))
+	authorized.GET("/blocks", services.V1(services.ListBlockedUsers(dbClient)))
+	authorized.DELETE("/blocks/:user_id", services.V1(services.UnblockUser(dbClient)))
+
+	// End-to-end encryption key endpoints
+	authorized.GET("/keys/backup", services.V1(services.GetKeyBackup(dbClient)))
+	authorized.PUT("/keys/backup", services.V1(services.SaveKeyBackup(dbClient)))
+	authorized.DELETE("/keys/backup", services.V1(services.DeleteKeyBackup(dbClient)))
+	authorized.PUT("/keys/cross-signing", services.V1(services.SetCrossSigningKey(dbClient)))
+	authorized.PUT("/keys/devices/:device_id", services.V1(services.SaveDeviceKey(dbClient)))
+	authorized.POST("/keys/devices/:device_id/signature", services.V1(services.SignDevice(dbClient)))
+	authorized.DELETE("/keys/devices/:device_id", services.V1(services.DeleteDeviceKey(dbClient)))
+	authorized.PUT("/keys/devices/:device_id/signed-prekey", services.V1(services.SaveSignedPreKey(dbClient)))
+	authorized.POST("/keys/devices/:device_id/one-time-prekeys", services.V1(services.UploadOneTimePreKeys(dbClient)))
+	authorized.GET("/keys/devices/:device_id/one-time-prekeys/count", services.V1(services.CountOneTimePreKeys(dbClient)))
+	authorized.GET("/users/:id/keys", services.V1(services.GetUserKeys(dbClient)))
+	authorized.POST("/users/:id/keys/claim", services.V1(services.ClaimPreKeyBundles(dbClient, hub)))
+
+	// Search endpoints
+	authorized.GET("/search/messages", shedSearch, services.V1(services.SearchMessages(searchIndex)))
+
+	// Push notification endpoints
+	authorized.PUT("/devices/push-token", services.V1(services.RegisterPushToken(dbClient)))
+	authorized.DELETE("/devices/push-token", services.V1(services.UnregisterPushToken(dbClient)))
+	authorized.GET("/devices/web-push-key", services.V1(services.GetWebPushKey(senders)))
+	authorized.GET("/notifications/preferences", services.V1(services.GetNotificationPreferences(dbClient)))
+	authorized.PATCH("/notifications/preferences", services.V1(services.UpdateNotificationPreferences(dbClient)))
+	authorized.GET("/notifications/mutes", services.V1(services.ListMutes(dbClient)))
+	authorized.GET("/notifications", services.V1(services.ListInbox(dbClient)))
+	authorized.GET("/notifications/unread-count", services.V1(services.GetInboxUnreadCount(dbClient)))
+	authorized.POST("/notifications/read", services.V1(services.MarkInboxRead(dbClient, hub)))
+	authorized.DELETE("/notifications", services.V1(services.ClearInbox(dbClient, hub)))
+	authorized.DELETE("/notifications/:id", services.V1(services.DeleteInboxNotification(dbClient, hub)))
+	authorized.PUT("/conversations/:id/mute", services.V1(services.MuteConversation(dbClient)))
+	authorized.DELETE("/conversations/:id/mute", services.V1(services.UnmuteConversation(dbClient)))
+	authorized.GET("/conversations/pins", services.V1(services.ListPinnedConversations(dbClient)))
+	authorized.GET("/conversations/summaries", services.V1(services.ListConversationSummaries(dbClient)))
+	authorized.PUT("/conversations/pins", services.V1(services.ReorderPinnedConversations(dbClient)))
+	authorized.PUT("/conversations/:id/pin", services.V1(services.PinConversation(dbClient)))
+	authorized.DELETE("/conversations/:id/pin", services.V1(services.UnpinConversation(dbClient)))
+	authorized.GET("/conversations/:id/labels", services.V1(services.ListConversationLabels(dbClient)))
+	authorized.PUT("/conversations/:id/labels/:labelId", services.V1(services.ApplyLabel(dbClient)))
+	authorized.DELETE("/conversations/:id/labels/:labelId", services.V1(services.RemoveLabel(dbClient)))
+
+	// Upload endpoints
+	authorized.POST("/uploads", services.V1(services.CreateUpload(dbClient, store)))
+	authorized.POST("/uploads/:id/complete", services.V1(services.CompleteUpload(dbClient, store)))
+	authorized.GET("/uploads/:id", services.V1(services.GetUpload(dbClient, store)))
+	authorized.POST("/uploads/:id/view-once", services.V1(services.OpenViewOnce(dbClient)))
+	authorized.GET("/uploads/:id/content", func(c *gin.Context) { services.DownloadUpload(c, dbClient, store, cacheHints) })
+
+	// Short link endpoints
+	shortLinkLimit := services.RateLimit(limiter, "short-links", appConfig.RateLimitShortLinks, services.ByUser)
+	authorized.POST("/links", shortLinkLimit, services.V1(services.CreateShortLink(shortLinks)))
+	authorized.GET("/links", services.V1(services.ListShortLinks(shortLinks)))
+	authorized.GET("/links/resolve", services.V1(services.ResolveLink(deepLinks)))
+	authorized.GET("/links/:code", shedPreviews, services.V1(services.GetShortLinkStats(shortLinks)))
+
+	// Backup endpoints
+	authorized.POST("/backups", services.V1(services.CreateBackup(dbClient, store)))
+	authorized.GET("/backups", services.V1(services.ListBackups(dbClient)))
+	authorized.GET("/backups/:id", services.V1(services.GetBackup(dbClient, store)))
+	authorized.DELETE("/backups/:id", services.V1(services.DeleteBackup(dbClient, store)))
+	authorized.PUT("/backups/:id/content", func(c *gin.Context) { services.UploadBackupContent(c, dbClient, store) })
+	authorized.GET("/backups/:id/content", func(c *gin.Context) { services.DownloadBackup(c, dbClient, store) })
+	authorized.POST("/backups/:id/complete", services.V1(services.CompleteBackup(dbClient, store)))
+
+	// Workspace endpoints
+	authorized.GET("/workspaces", services.V1(services.ListWorkspaces(dbClient)))
+	authorized.POST("/workspaces", services.V1(services.CreateWorkspace(dbClient)))
+	authorized.GET("/workspaces/:slug/members", services.V1(services.ListWorkspaceMembers(dbClient)))
+	authorized.POST("/workspaces/:slug/members", services.V1(services.AddWorkspaceMember(dbClient)))
+	authorized.PATCH("/workspaces/:slug/members/:userId", services.V1(services.UpdateWorkspaceMemberRole(dbClient)))
+	authorized.DELETE("/workspaces/:slug/members/:userId", services.V1(services.RemoveWorkspaceMember(dbClient)))
+	authorized.GET("/workspaces/:slug/archive", services.V1(services.GetWorkspaceArchive(webhooks)))
+	authorized.PUT("/workspaces/:slug/archive", services.V1(services.SetWorkspaceArchive(webhooks)))
+	authorized.DELETE("/workspaces/:slug/archive", services.V1(services.DeleteWorkspaceArchive(webhooks)))
+
+	// Channel endpoints
+	authorized.POST("/channels", func(c *gin.Context) { services.CreateChannel(c, dbClient, hub) })
+	authorized.GET("/channels", func(c *gin.Context) { services.ListChannels(c, dbClient) })
+	authorized.POST("/channels/:id/join", func(c *gin.Context) { services.JoinChannel(c, dbClient, hub, joinCaptcha) })
+	authorized.DELETE("/channels/:id/waitlist/me", func(c *gin.Context) { services.LeaveChannelWaitlist(c, dbClient) })
+
+	channel := authorized.Group("/channels/:id")
+	channel.Use(services.ChannelMembership(dbClient))
+	channel.GET("", services.GetChannel)
+	channel.GET("/members", func(c *gin.Context) { services.ListChannelMembers(c, dbClient) })
+	channel.POST("/members", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), func(c *gin.Context) { services.AddChannelMembers(c, dbClient, hub) })
+	channel.DELETE("/members/:userId", func(c *gin.Context) { services.RemoveChannelMember(c, dbClient, hub) })
+	channel.PATCH("/members/:userId", services.RequireChannelRole(models.MemberRoleOwner), func(c *gin.Context) { services.UpdateChannelMemberRole(c, dbClient) })
+	channel.GET("/waitlist", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), func(c *gin.Context) { services.ListChannelWaitlist(c, dbClient) })
+	channel.PUT("/listing", services.RequireChannelRole(models.MemberRoleOwner), func(c *gin.Context) { services.SetChannelListing(c, dbClient) })
+	channel.PUT("/join-captcha", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), func(c *gin.Context) { services.SetChannelJoinCaptcha(c, dbClient) })
+	channel.PUT("/posting-reputation", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), func(c *gin.Context) { services.SetChannelPostingReputation(c, dbClient) })
+	channel.GET("/reputation", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), services.V1(services.ListChannelReputation(dbClient)))
+	channel.PUT("/age-gate", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), func(c *gin.Context) { services.SetChannelAgeGate(c, dbClient) })
+
+	hooks := channel.Group("/webhooks", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin))
+	hooks.POST("/incoming", services.V1(services.CreateIncomingWebhook(webhooks)))
+	hooks.GET("/incoming", services.V1(services.ListIncomingWebhooks(webhooks)))
+	hooks.DELETE("/incoming/:webhookId", services.V1(services.DeleteIncomingWebhook(webhooks)))
+	hooks.POST("/outgoing", services.V1(services.CreateOutgoingWebhook(webhooks)))
+	hooks.GET("/outgoing", services.V1(services.ListOutgoingWebhooks(webhooks)))
+	hooks.DELETE("/outgoing/:webhookId", services.V1(services.DeleteOutgoingWebhook(webhooks)))
+
+	feeds := channel.Group("/feeds", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin))
+	feeds.POST("", services.V1(services.CreateRoomFeed(roomFeeds)))
+	feeds.GET("", services.V1(services.ListRoomFeeds(roomFeeds)))
+	feeds.DELETE("/:feedId", services.V1(services.DeleteRoomFeed(roomFeeds)))
+
+	rules := channel.Group("/automations", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin))
+	rules.POST("", services.V1(services.CreateAutomationRule(automations)))
+	rules.GET("", services.V1(services.ListAutomationRules(automations)))
+	rules.PATCH("/:ruleId", services.V1(services.UpdateAutomationRule(automations)))
+	rules.DELETE("/:ruleId", services.V1(services.DeleteAutomationRule(automations)))
+	rules.GET("/:ruleId/runs", services.V1(services.ListAutomationRuns(automations)))
+
+	if roomEmail.Enabled() {
+		channel.GET("/email", services.V1(services.GetRoomEmail(roomEmail)))
+		channel.PUT("/email/relay", services.V1(services.SetRoomEmailRelay(roomEmail)))
+		channel.POST("/email", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), services.V1(services.CreateRoomEmail(roomEmail)))
+		channel.DELETE("/email", services.RequireChannelRole(models.MemberRoleOwner, models.MemberRoleAdmin), services.V1(services.DeleteRoomEmail(roomEmail)))
+	}
+
+	// Bot accounts and their API keys
+	authorized.POST("/bots", services.V1(services.CreateBot(dbClient)))
+	authorized.GET("/bots", services.V1(services.ListBots(dbClient)))
+	authorized.DELETE("/bots/:id", services.V1(services.DeleteBot(dbClient)))
+	authorized.POST("/bots/:id/keys", services.V1(services.CreateAPIKey(dbClient)))
+	authorized.GET("/bots/:id/keys", services.V1(services.ListAPIKeys(dbClient)))
+	authorized.DELETE("/bots/:id/keys/:keyId", services.V1(services.RevokeAPIKey(dbClient)))
+
+	// Experiment endpoints
+	authorized.GET("/experiments", func(c *gin.Context) { services.GetExperimentAssignments(c, dbClient) })
+	authorized.POST("/experiments/:key/exposures", func(c *gin.Context) { services.RecordExposure(c, dbClient, exposures) })
+
+	// Lawful-request endpoints, for legal staff only and audit-logged
+	lawful := authorized.Group("/lawful-requests")
+	lawful.Use(services.RequireScope(auth.ScopeAdmin), services.RequireRole(models.RoleLegal))
+	lawful.POST("/holds", services.V1(services.PlaceLegalHold(dbClient)))
+	lawful.GET("/holds", services.V1(services.ListLegalHolds(dbClient)))
+	lawful.GET("/holds/:id", services.V1(services.GetLegalHold(dbClient)))
+	lawful.POST("/holds/:id/release", services.V1(services.ReleaseLegalHold(dbClient)))
+	lawful.POST("/holds/:id/exports", services.V1(services.RequestLegalExport(dbClient)))
+	lawful.GET("/exports/:id", services.V1(services.GetLegalExport(dbClient)))
+	lawful.GET("/exports/:id/content", func(c *gin.Context) { services.DownloadLegalExport(c, dbClient, store) })
+	lawful.GET("/audit", services.V1(services.ListLegalAudit(dbClient)))
+
+	// Admin endpoints
+	admin := authorized.Group("/admin")
+	admin.Use(services.RequireScope(auth.ScopeAdmin), services.RequireRole(models.RoleAdmin, models.RoleModerator))
+	admin.GET("/overview", services.V1(services.GetAdminOverview(dbClient, hub)))
+	admin.GET("/users/:id", services.V1(services.GetAdminUserDossier(dbClient)))
+	admin.POST("/invites", func(c *gin.Context) { services.CreateInviteBatch(c, dbClient) })
+	admin.GET("/invites", func(c *gin.Context) { services.ListInvites(c, dbClient) })
+	admin.POST("/waitlist/admit", func(c *gin.Context) { services.AdmitWaitlist(c, dbClient, mail, shortLinks, appConfig) })
+	admin.GET("/client-config/rules", func(c *gin.Context) { services.ListClientConfigRules(c, dbClient) })
+	admin.POST("/client-config/rules", services.RequireRole(models.RoleAdmin), func(c *gin.Context) { services.CreateClientConfigRule(c, dbClient, clientConfig) })
+	admin.PATCH("/client-config/rules/:id", services.RequireRole(models.RoleAdmin), func(c *gin.Context) { services.UpdateClientConfigRule(c, dbClient, clientConfig) })
+	admin.DELETE("/client-config/rules/:id", services.RequireRole(models.RoleAdmin), func(c *gin.Context) { services.DeleteClientConfigRule(c, dbClient, clientConfig) })
+	admin.GET("/deprecations", func(c *gin.Context) { services.DeprecationReport(c, deprecations) })
+	admin.GET("/slo", services.SLOReport)
+	admin.GET("/experiments", func(c *gin.Context) { services.ListExperiments(c, dbClient) })
+	admin.POST("/experiments", services.RequireRole(models.RoleAdmin), func(c *gin.Context) { services.CreateExperiment(c, dbClient) })
+	admin.PATCH("/experiments/:id", services.RequireRole(models.RoleAdmin), func(c *gin.Context) { services.UpdateExperiment(c, dbClient) })
+	admin.POST("/users/:id/suspend", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationSuspend)))
+	admin.POST("/users/:id/unsuspend", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationUnsuspend)))
+	admin.POST("/users/:id/ban", services.RequireRole(models.RoleAdmin), services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationBan)))
+	admin.POST("/users/:id/unban", services.RequireRole(models.RoleAdmin), services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationUnban)))
+	admin.POST("/users/:id/logout", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationSignOut)))
+	admin.POST("/users/:id/shadow-restrict", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationShadowRestrict)))
+	admin.POST("/users/:id/shadow-lift", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationShadowLift)))
+	admin.POST("/users/:id/strikes", services.V1(services.IssueStrike(dbClient, hub, appConfig.StrikePolicy)))
+	admin.GET("/users/:id/strikes", services.V1(services.ListUserStrikes(dbClient)))
+	admin.GET("/users/:id/usage", services.V1(services.GetUserUsage(dbClient)))
+	admin.POST("/users/:id/unmute", services.V1(services.ModerateUser(dbClient, hub, searchIndex, models.ModerationUnmute)))
+	admin.GET("/moderation-actions", services.V1(services.ListModerationActions(dbClient)))
+	admin.GET("/moderation-templates", services.V1(services.ListModerationTemplates(dbClient)))
+	admin.POST("/moderation-templates", services.RequireRole(models.RoleAdmin), services.V1(services.CreateModerationTemplate(dbClient)))
+	admin.PUT("/moderation-templates/:id", services.RequireRole(models.RoleAdmin), services.V1(services.UpdateModerationTemplate(dbClient)))
+	admin.DELETE("/moderation-templates/:id", services.RequireRole(models.RoleAdmin), services.V1(services.DeleteModerationTemplate(dbClient)))
+	admin.POST("/moderation/bulk", services.V1(services.BulkModerate(dbClient, hub)))
+	admin.GET("/moderation/batches/:id", services.V1(services.GetModerationBatch(dbClient)))
+	admin.POST("/moderation/batches/:id/undo", services.V1(services.UndoModerationBatch(dbClient, hub, searchIndex)))
+	admin.GET("/appeals", services.V1(services.ListAppeals(dbClient)))
+	admin.GET("/appeals/:id", services.V1(services.GetAppeal(dbClient)))
+	admin.POST("/appeals/:id/decision", services.V1(services.DecideAppeal(dbClient, hub, mail)))
+	admin.GET("/reports", services.V1(services.ListReports(dbClient)))
+	admin.POST("/reports/:id/dismiss", services.V1(services.DismissReport(dbClient)))
+	admin.POST("/channels/:id/members/:userId/age-override", services.RequireRole(models.RoleAdmin), services.V1(services.OverrideAgeGate(dbClient, hub)))
+	admin.GET("/users/:id/devices", services.V1(services.AdminUserDevices(dbClient)))
+	admin.GET("/users/:id/identity-cluster", services.V1(services.GetUserIdentityCluster(dbClient)))
+	admin.GET("/identity-clusters", services.V1(services.ListIdentityClusters(dbClient)))
+	admin.POST("/links/:code/disable", services.V1(services.DisableShortLink(shortLinks)))
+	admin.GET("/welcome-rooms", services.V1(services.ListWelcomeRooms(dbClient)))
+	admin.POST("/welcome-rooms", services.RequireRole(models.RoleAdmin), services.V1(services.AddWelcomeRoom(dbClient)))
+	admin.DELETE("/welcome-rooms/:id", services.RequireRole(models.RoleAdmin), services.V1(services.DeleteWelcomeRoom(dbClient)))
+	admin.GET("/onboarding-steps", services.V1(services.ListOnboardingSteps(dbClient)))
+	admin.POST("/onboarding-steps", services.RequireRole(models.RoleAdmin), services.V1(services.CreateOnboardingStep(dbClient)))
+	admin.PUT("/onboarding-steps/:id", services.RequireRole(models.RoleAdmin), services.V1(services.UpdateOnboardingStep(dbClient)))
+	admin.DELETE("/onboarding-steps/:id", services.RequireRole(models.RoleAdmin), services.V1(services.DeleteOnboardingStep(dbClient)))
+	admin.POST("/conversations/:id/merge", services.RequireRole(models.RoleAdmin), services.V1(services.MergeRoom(dbClient, hub, searchIndex)))
+	admin.GET("/push-tokens/health", services.V1(services.PushTokenHealth(dbClient, appConfig.PushTokenMaxAge)))
+	admin.POST("/notifications", services.RequireRole(models.RoleAdmin), services.V1(services.SendSystemNotice(dbClient, hub)))
+	admin.GET("/erasures", services.RequireRole(models.RoleAdmin), services.V1(services.ListAccountErasures(dbClient)))
+	admin.GET("/erasures/:id", services.RequireRole(models.RoleAdmin), services.V1(services.GetAccountErasure(dbClient)))
+	admin.POST("/erasures/reapply", services.RequireRole(models.RoleAdmin), services.V1(services.ReapplyAccountErasures(dbClient)))
+	jobsAdmin := admin.Group("/jobs", services.RequireRole(models.RoleAdmin))
+	jobsAdmin.GET("", services.V1(services.JobQueueStats(dbClient)))
+	jobsAdmin.GET("/failed", services.V1(services.ListFailedJobs(dbClient)))
+	jobsAdmin.POST("/failed/retry", services.V1(services.RetryFailedJobs(dbClient)))
+	jobsAdmin.GET("/:id", services.V1(services.GetJob(dbClient)))
+	jobsAdmin.POST("/:id/retry", services.V1(services.RetryJob(dbClient)))
+	jobsAdmin.DELETE("/:id", services.V1(services.DiscardJob(dbClient)))
+
+	// API v2: the same endpoints with the error envelope and cursor pages.
+	// Endpoints move here as they are converted to services.Endpoint.
+	v2 := router.Group("/api/v2")
+	v2.Use(services.V2Envelope(), services.AuthMiddleware(dbClient, tokens), services.WorkspaceMembership(dbClient), services.RequireMethodScope(), services.TierRateLimit(limiter), services.RequireLegalAcceptance(dbClient))
+	v2.GET("/users/me", services.V2(services.GetMe))
+	v2.PATCH("/users/me", services.V2(services.UpdateMe(dbClient)))
+	v2.DELETE("/users/me", services.V2(services.DeleteMe(dbClient)))
+	v2.POST("/users/me/email-change", services.V2(services.RequestEmailChange(dbClient, mail, appConfig)))
+	v2.GET("/users/me/email-change", services.V2(services.GetEmailChange(dbClient)))
+	v2.DELETE("/users/me/email-change", services.V2(services.CancelEmailChange(dbClient)))
+	v2.POST("/users/me/export", services.V2(services.RequestDataExport(dbClient)))
+	v2.GET("/users/me/exports/:id", services.V2(services.GetDataExport(dbClient, store)))
+	v2.GET("/users/me/sessions", services.V2(services.ListSessions(dbClient)))
+	v2.DELETE("/users/me/sessions", services.V2(services.RevokeOtherSessions(dbClient)))
+	v2.DELETE("/users/me/sessions/:id", services.V2(services.RevokeSession(dbClient)))
+	v2.GET("/users/me/identities", services.V2(services.ListIdentities(dbClient)))
+	v2.DELETE("/users/me/identities/:provider", services.V2(services.UnlinkIdentity(dbClient)))
+	v2.GET("/users/me/suggestions", services.V2(services.GetSettingsSuggestions(dbClient)))
+	v2.DELETE("/users/me/suggestions", services.V2(services.DismissSettingsSuggestions(dbClient)))
+	v2.GET("/users/me/auto-replies", services.V2(services.ListAutoReplyRules(dbClient)))
+	v2.POST("/users/me/auto-replies", services.V2(services.CreateAutoReplyRule(dbClient)))
+	v2.PUT("/users/me/auto-replies/:id", services.V2(services.UpdateAutoReplyRule(dbClient)))
+	v2.DELETE("/users/me/auto-replies/:id", services.V2(services.DeleteAutoReplyRule(dbClient)))
+	v2.GET("/users/me/strikes", services.V2(services.ListMyStrikes(dbClient)))
+	v2.GET("/users/me/consents", services.V2(services.GetConsents(dbClient)))
+	v2.PUT("/users/me/consents", services.V2(services.UpdateConsents(dbClient)))
+	v2.GET("/users/me/onboarding", services.V2(services.GetMyOnboarding(dbClient)))
+	v2.POST("/users/me/onboarding/:key/complete", services.V2(services.CompleteOnboardingStep(dbClient)))
+	v2.GET("/users/me/referrals", services.V2(services.GetReferrals(dbClient)))
+	v2.GET("/users/me/badges", services.V2(services.ListMyBadges(dbClient)))
+	v2.GET("/users/me/usage", services.V2(services.GetMyUsage(dbClient)))
+	v2.PATCH("/users/me/badges/:badge", services.V2(services.UpdateMyBadge(dbClient)))
+	v2.GET("/users/me/business", services.V2(services.GetMyBusinessProfile(dbClient)))
+	v2.PUT("/users/me/business", services.V2(services.SaveMyBusinessProfile(dbClient)))
+	v2.DELETE("/users/me/business", services.V2(services.DeleteMyBusinessProfile(dbClient)))
+	v2.GET("/users/me/business/catalog", services.V2(services.ListCatalogItems(dbClient)))
+	v2.POST("/users/me/business/catalog", services.V2(services.CreateCatalogItem(dbClient)))
+	v2.PUT("/users/me/business/catalog/:id", services.V2(services.UpdateCatalogItem(dbClient)))
+	v2.DELETE("/users/me/business/catalog/:id", services.V2(services.DeleteCatalogItem(dbClient)))
+	v2.GET("/users/me/business/quick-replies", services.V2(services.ListQuickReplies(dbClient)))
+	v2.POST("/users/me/business/quick-replies", services.V2(services.CreateQuickReply(dbClient)))
+	v2.PUT("/users/me/business/quick-replies/:id", services.V2(services.UpdateQuickReply(dbClient)))
+	v2.DELETE("/users/me/business/quick-replies/:id", services.V2(services.DeleteQuickReply(dbClient)))
+	v2.GET("/users/me/business/labels", services.V2(services.ListLabels(dbClient)))
+	v2.POST("/users/me/business/labels", services.V2(services.CreateLabel(dbClient)))
+	v2.GET("/users/me/business/labels/export", func(c *gin.Context) { services.ExportLabeledConversations(c, dbClient) })
+	v2.PUT("/users/me/business/labels/:id", services.V2(services.UpdateLabel(dbClient)))
+	v2.DELETE("/users/me/business/labels/:id", services.V2(services.DeleteLabel(dbClient)))
+	v2.GET("/users/:id", services.V2(services.GetUser(dbClient)))
+	v2.POST("/users/:id/report", services.V2(services.ReportUser(dbClient)))
+	v2.GET("/users/:id/business", services.V2(services.GetBusinessProfile(dbClient)))
+	v2.GET("/users/:id/badges", services.V2(services.ListUserBadges(dbClient)))
+	v2.GET("/commands", services.V2(services.ListCommands))
+	v2.GET("/reminders", services.V2(services.ListReminders(dbClient)))
+	v2.DELETE("/reminders/:id", services.V2(services.CancelReminder(dbClient)))
+	v2.GET("/conversations", services.V2(services.ListConversations(dbClient)))
+	v2.GET("/conversations/:id", services.V2(services.GetConversation(dbClient)))
+	v2.GET("/conversations/:id/messages", services.V2(services.ListMessages(dbClient)))
+	v2.GET("/conversations/:id/messages/range", services.V2(services.ListMessageRange(dbClient)))
+	v2.POST("/conversations/:id/read", services.V2(services.MarkRead(dbClient, hub)))
+	v2.GET("/conversations/:id/receipts", services.V2(services.ListReceipts(dbClient)))
+	v2.GET("/conversations/:id/audit", services.V2(services.VerifyAuditChain(dbClient)))
+	v2.GET("/conversations/:id/notes", services.V2(services.GetRoomNotes(dbClient)))
+	v2.POST("/conversations/:id/notes/edits", services.V2(services.EditRoomNotes(dbClient, hub)))
+	v2.GET("/conversations/:id/notes/edits", services.V2(services.ListRoomNoteEdits(dbClient)))
+	v2.GET("/conversations/:id/events", services.V2(services.ListRoomEvents(dbClient)))
+	v2.GET("/conversations/:id/calendar.ics", func(c *gin.Context) { services.GetRoomCalendar(c, dbClient) })
+	v2.GET("/conversations/:id/faq", services.V2(services.ListRoomFAQ(dbClient)))
+	v2.POST("/conversations/:id/faq", services.V2(services.CreateRoomFAQ(dbClient)))
+	v2.PUT("/conversations/:id/faq/:faqId", services.V2(services.UpdateRoomFAQ(dbClient)))
+	v2.DELETE("/conversations/:id/faq/:faqId", services.V2(services.DeleteRoomFAQ(dbClient)))
+	v2.GET("/conversations/:id/exports/schedules", services.V2(services.ListRoomExports(roomExports)))
+	v2.POST("/conversations/:id/exports/schedules", services.V2(services.CreateRoomExport(roomExports)))
+	v2.PATCH("/conversations/:id/exports/schedules/:scheduleId", services.V2(services.UpdateRoomExport(roomExports)))
+	v2.DELETE("/conversations/:id/exports/schedules/:scheduleId", services.V2(services.DeleteRoomExport(roomExports)))
+	v2.POST("/conversations/:id/exports/schedules/:scheduleId/run", services.V2(services.RunRoomExport(roomExports)))
+	v2.GET("/conversations/:id/exports/deliveries", services.V2(services.ListRoomExportDeliveries(roomExports)))
+	v2.GET("/conversations/:id/exports/deliveries/:deliveryId/content", func(c *gin.Context) { services.DownloadRoomExport(c, roomExports) })
+	v2.POST("/conversations/:id/transcripts", services.V2(services.RequestTranscript(dbClient)))
+	v2.GET("/conversations/:id/transcripts/:transcriptId", services.V2(services.GetTranscript(dbClient, store)))
+	v2.GET("/conversations/:id/transcripts/:transcriptId/content", func(c *gin.Context) { services.DownloadTranscript(c, dbClient, store) })
+	v2.GET("/sync", services.V2(services.Sync(dbClient)))
+	v2.PUT("/sync/acks", services.V2(services.AckSync(dbClient)))
+	v2.GET("/sync/catch-up", services.V2(services.CatchUp(dbClient)))
+	v2.POST("/realtime/poll", services.V2(services.OpenLongPoll(hub)))
+	v2.GET("/realtime/poll/:id", services.V2(services.PollLongPoll(hub)))
+	v2.POST("/realtime/poll/:id/events", services.V2(services.SendLongPollEvent(hub)))
+	v2.DELETE("/realtime/poll/:id", services.V2(services.CloseLongPoll(hub)))
+	v2.PATCH("/messages/:id", services.V2(services.EditMessage(dbClient, hub, searchIndex)))
+	v2.DELETE("/messages/:id", services.V2(services.DeleteMessage(dbClient, hub, searchIndex)))
+	v2.GET("/messages/:id/edits", services.V2(services.ListMessageEdits(dbClient)))
+	v2.POST("/messages/:id/report", services.V2(services.ReportMessage(dbClient)))
+	v2.PUT("/messages/:id/rsvp", services.V2(services.RSVPToEvent(dbClient, hub)))
+	v2.DELETE("/messages/:id/rsvp", services.V2(services.ClearEventRSVP(dbClient, hub)))
+	v2.GET("/messages/:id/rsvps", services.V2(services.ListEventRSVPs(dbClient)))
+	v2.POST("/messages/:id/ack", services.V2(services.AcknowledgeAnnouncement(dbClient, hub)))
+	v2.GET("/messages/:id/acks", services.V2(services.GetAnnouncementAcks(dbClient)))
+	v2.POST("/contacts/requests", services.V2(services.SendContactRequest(dbClient, hub)))
+	v2.GET("/contacts/requests", services.V2(services.ListContactRequests(dbClient)))
+	v2.POST("/contacts/requests/:id/accept", services.V2(services.AcceptContactRequest(dbClient, hub)))
+	v2.POST("/contacts/requests/:id/reject", services.V2(services.RejectContactRequest(dbClient)))
+	v2.DELETE("/contacts/requests/:id", services.V2(services.CancelContactRequest(dbClient)))
+	v2.GET("/contacts", services.V2(services.ListContacts(dbClient)))
+	v2.POST("/contacts/discover", discoveryLimit, services.V2(services.DiscoverContacts(dbClient)))
+	v2.DELETE("/contacts/:user_id", services.V2(services.RemoveContact(dbClient)))
+	v2.POST("/blocks", services.V2(services.BlockUser(dbClient)))
+	v2.GET("/blocks", services.V2(services.ListBlockedUsers(dbClient)))
+	v2.DELETE("/blocks/:user_id", services.V2(services.UnblockUser(dbClient)))
+	v2.GET("/keys/backup", services.V2(services.GetKeyBackup(dbClient)))
+	v2.PUT("/keys/backup", services.V2(services.SaveKeyBackup(dbClient)))
+	v2.DELETE("/keys/backup", services.V2(services.DeleteKeyBackup(dbClient)))
+	v2.PUT("/keys/cross-signing", services.V2(services.SetCrossSigningKey(dbClient)))
+	v2.PUT("/keys/devices/:device_id", services.V2(services.SaveDeviceKey(dbClient)))
+	v2.POST("/keys/devices/:device_id/signature", services.V2(services.SignDevice(dbClient)))
+	v2.DELETE("/keys/devices/:device_id", services.V2(services.DeleteDeviceKey(dbClient)))
+	v2.PUT("/keys/devices/:device_id/signed-prekey", services.V2(services.SaveSignedPreKey(dbClient)))
+	v2.POST("/keys/devices/:device_id/one-time-prekeys", services.V2(services.UploadOneTimePreKeys(dbClient)))
+	v2.GET("/keys/devices/:device_id/one-time-prekeys/count", services.V2(services.CountOneTimePreKeys(dbClient)))
+	v2.GET("/users/:id/keys", services.V2(services.GetUserKeys(dbClient)))
+	v2.POST("/users/:id/keys/claim", services.V2(services.ClaimPreKeyBundles(dbClient, hub)))
+	v2.GET("/search/messages", shedSearch, services.V2(services.SearchMessages(searchIndex)))
+	v2.PUT("/devices/push-token", services.V2(services.RegisterPushToken(dbClient)))
+	v2.DELETE("/devices/push-token", services.V2(services.UnregisterPushToken(dbClient)))
+	v2.GET("/devices/web-push-key", services.V2(services.GetWebPushKey(senders)))
+	v2.GET("/notifications/preferences", services.V2(services.GetNotificationPreferences(dbClient)))
+	v2.PATCH("/notifications/preferences", services.V2(services.UpdateNotificationPreferences(dbClient)))
+	v2.GET("/notifications/mutes", services.V2(services.ListMutes(dbClient)))
+	v2.GET("/notifications", services.V2(services.ListInbox(dbClient)))
+	v2.GET("/notifications/unread-count", services.V2(services.GetInboxUnreadCount(dbClient)))
+	v2.POST("/notifications/read", services.V2(services.MarkInboxRead(dbClient, hub)))
+	v2.DELETE("/notifications", services.V2(services.ClearInbox(dbClient, hub)))
+	v2.DELETE("/notifications/:id", services.V2(services.DeleteInboxNotification(dbClient, hub)))
+	v2.PUT("/conversations/:id/mute", services.V2(services.MuteConversation(dbClient)))
+	v2.DELETE("/conversations/:id/mute", services.V2(services.UnmuteConversation(dbClient)))
+	v2.GET("/conversations/pins", services.V2(services.ListPinnedConversations(dbClient)))
+	v2.GET("/conversations/summaries", services.V2(services.ListConversationSummaries(dbClient)))
+	v2.PUT("/conversations/pins", services.V2(services.ReorderPinnedConversations(dbClient)))
+	v2.PUT("/conversations/:id/pin", services.V2(services.PinConversation(dbClient)))
+	v2.DELETE("/conversations/:id/pin", services.V2(services.UnpinConversation(dbClient)))
+	v2.GET("/conversations/:id/labels", services.V2(services.ListConversationLabels(dbClient)))
+	v2.PUT("/conversations/:id/labels/:labelId", services.V2(services.ApplyLabel(dbClient)))
+	v2.DELETE("/conversations/:id/labels/:labelId", services.V2(services.RemoveLabel(dbClient)))
+	v2.GET("/workspaces", services.V2(services.ListWorkspaces(dbClient)))
+	v2.POST("/workspaces", services.V2(services.CreateWorkspace(dbClient)))
+	v2.GET("/workspaces/:slug/members", services.V2(services.ListWorkspaceMembers(dbClient)))
+	v2.POST("/workspaces/:slug/members", services.V2(services.AddWorkspaceMember(dbClient)))
+	v2.PATCH("/workspaces/:slug/members/:userId", services.V2(services.UpdateWorkspaceMemberRole(dbClient)))
+	v2.DELETE("/workspaces/:slug/members/:userId", services.V2(services.RemoveWorkspaceMember(dbClient)))
+	v2.GET("/workspaces/:slug/archive", services.V2(services.GetWorkspaceArchive(webhooks)))
+	v2.PUT("/workspaces/:slug/archive", services.V2(services.SetWorkspaceArchive(webhooks)))
+	v2.DELETE("/workspaces/:slug/archive", services.V2(services.DeleteWorkspaceArchive(webhooks)))
+	v2.POST("/uploads", services.V2(services.CreateUpload(dbClient, store)))
+	v2.POST("/uploads/:id/complete", services.V2(services.CompleteUpload(dbClient, store)))
+	v2.GET("/uploads/:id", services.V2(services.GetUpload(dbClient, store)))
+	v2.POST("/uploads/:id/view-once", services.V2(services.OpenViewOnce(dbClient)))
+	v2.POST("/links", shortLinkLimit, services.V2(services.CreateShortLink(shortLinks)))
+	v2.GET("/links", services.V2(services.ListShortLinks(shortLinks)))
+	v2.GET("/links/resolve", services.V2(services.ResolveLink(deepLinks)))
+	v2.GET("/links/:code", shedPreviews, services.V2(services.GetShortLinkStats(shortLinks)))
+	v2.POST("/bots", services.V2(services.CreateBot(dbClient)))
+	v2.GET("/bots", services.V2(services.ListBots(dbClient)))
+	v2.DELETE("/bots/:id", services.V2(services.DeleteBot(dbClient)))
+	v2.POST("/bots/:id/keys", services.V2(services.CreateAPIKey(dbClient)))
+	v2.GET("/bots/:id/keys", services.V2(services.ListAPIKeys(dbClient)))
+	v2.DELETE("/bots/:id/keys/:keyId", services.V2(services.RevokeAPIKey(dbClient)))
+	v2.POST("/backups", services.V2(services.CreateBackup(dbClient, store)))
+	v2.GET("/backups", services.V2(services.ListBackups(dbClient)))
+	v2.GET("/backups/:id", services.V2(services.GetBackup(dbClient, store)))
+	v2.DELETE("/backups/:id", services.V2(services.DeleteBackup(dbClient, store)))
+	v2.POST("/backups/:id/complete", services.V2(services.CompleteBackup(dbClient, store)))
 
 	// Start server
-	log.Printf("🚀 AfroChat Backend starting on port %s", appConfig.Port)
-	log.Printf("📊 Database: %s:%s/%s", appConfig.DBHost, appConfig.DBPort, appConfig.DBName)
+	slog.Info("🚀 AfroChat Backend starting",
+		"port", appConfig.Port,
+		"database", fmt.Sprintf("%s:%d/%s", appConfig.DBHost, appConfig.DBPort, appConfig.DBName),
+		"region", appConfig.Region,
+		"realtime_bus", appConfig.RealtimeBus)
 
-	if err := router.Run(":" + appConfig.Port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	// Database faults start once startup has finished loading what it needs
+	if injector != nil {
+		if err := dbClient.DB.Use(injector); err != nil {
+			fatal("Failed to inject database faults", err)
+		}
+		slog.Warn("⚠️ Chaos enabled",
+			"latency_rate", appConfig.ChaosLatencyRate,
+			"max_latency", appConfig.ChaosMaxLatency.String(),
+			"ws_drop_rate", appConfig.ChaosFrameDropRate,
+			"db_error_rate", appConfig.ChaosDBErrorRate)
 	}
+	if captured != nil {
+		slog.Warn("⚠️ Test mode enabled: email and pushes are captured, not sent")
+	}
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", appConfig.Port),
+		Handler:           router,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := lifecycleManager.Run(context.Background(), server); err != nil {
+		fatal("Failed to run server", err)
+	}
+	slog.Info("👋 AfroChat Backend stopped")
+}
+
+// fatal logs why the server cannot start or keep running, and exits.
+func fatal(message string, err error) {
+	slog.Error(message, "error", err)
+	os.Exit(1)
 }
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/migrations"
+	"github.com/dfunani/AfroChat/backend/services"
+)
+
+const migrateUsage = `usage: afrochat migrate <command>
+
+commands:
+  up            apply every pending migration
+  down [steps]  revert the latest migration, or the latest steps of them
+  status        show the applied and pending migrations
+  create NAME   add empty up and down files for a new migration`
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	// Creating a migration only touches the source tree.
+	if args[0] == "create" {
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, migrateUsage)
+			return 2
+		}
+		up, down, err := migrations.Create(migrations.Dir, args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Created %s\nCreated %s\n", up, down)
+		return 0
+	}
+
+	appConfig, err := config.LoadApplicationConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if appConfig.DBDriver == database.DriverSQLite {
+		fmt.Fprintln(os.Stderr, "Migrations are for Postgres; SQLite databases get their schema when the server starts")
+		return 1
+	}
+	dbClient, err := services.CreateDatabaseClient(appConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer dbClient.Close()
+
+	migrator, err := migrations.New(dbClient.SQLDB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("Schema is up to date")
+		}
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				fmt.Fprintln(os.Stderr, "steps must be a positive whole number")
+				return 2
+			}
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, migration := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Printf("Current version: %d\nLatest version: %d\n", status.Current, status.Latest)
+		for _, migration := range status.Pending {
+			fmt.Printf("Pending %04d_%s\n", migration.Version, migration.Name)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,103 @@
+package abuse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Signals is what a scorer gets to look at. It deliberately carries
+// behavioural features rather than message history so that external
+// models never receive more content than the message being scored.
+type Signals struct {
+	UserID           uuid.UUID
+	AccountAge       time.Duration
+	MessageText      string
+	MessagesLastHour int
+	DistinctTargets  int
+	LinkCount        int
+	ReportsReceived  int
+}
+
+// Scorer is implemented by embedded heuristics or by clients of external
+// ML services. Scores are in [0, 1]; higher means more likely abusive.
+type Scorer interface {
+	Name() string
+	Score(ctx context.Context, signals Signals) (float64, error)
+}
+
+type Action string
+
+const (
+	ActionAllow          Action = "allow"
+	ActionShadowRestrict Action = "shadow_restrict"
+)
+
+// Decision records why an account was or was not restricted, so moderators
+// can review it later.
+type Decision struct {
+	Action Action
+	Score  float64
+	Scorer string
+}
+
+// Evaluator runs every scorer and acts on the highest score. A scorer error
+// never restricts an account on its own.
+type Evaluator struct {
+	Scorers   []Scorer
+	Threshold float64
+}
+
+func (e *Evaluator) Evaluate(ctx context.Context, signals Signals) (Decision, error) {
+	decision := Decision{Action: ActionAllow}
+	var firstErr error
+
+	for _, scorer := range e.Scorers {
+		score, err := scorer.Score(ctx, signals)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("scorer %s failed: %w", scorer.Name(), err)
+			}
+			continue
+		}
+		if score > decision.Score {
+			decision.Score = score
+			decision.Scorer = scorer.Name()
+		}
+	}
+
+	if decision.Score >= e.Threshold {
+		decision.Action = ActionShadowRestrict
+	}
+	return decision, firstErr
+}
+
+// HeuristicScorer is the built-in fallback used when no model is configured.
+type HeuristicScorer struct{}
+
+func (HeuristicScorer) Name() string {
+	return "heuristic"
+}
+
+func (HeuristicScorer) Score(_ context.Context, signals Signals) (float64, error) {
+	score := 0.0
+	if signals.AccountAge < 24*time.Hour {
+		score += 0.2
+	}
+	if signals.MessagesLastHour > 100 {
+		score += 0.3
+	}
+	if signals.DistinctTargets > 30 {
+		score += 0.3
+	}
+	if signals.LinkCount > 3 {
+		score += 0.2
+	}
+	score += 0.1 * float64(signals.ReportsReceived)
+	if score > 1 {
+		score = 1
+	}
+	return score, nil
+}
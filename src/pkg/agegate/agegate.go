@@ -0,0 +1,77 @@
+package agegate
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultMinimumAge = 13
+	AdultAge          = 18
+)
+
+var (
+	ErrTooYoung        = errors.New("user is below the minimum age for their country")
+	ErrAdultOnly       = errors.New("this room is restricted to users aged 18 and over")
+	ErrUnknownAge      = errors.New("date of birth is required for this room")
+	ErrInvalidBirthday = errors.New("date of birth is invalid")
+)
+
+// minimumAges holds the digital age of consent where it differs from the
+// default, keyed by ISO 3166-1 alpha-2 country code.
+var minimumAges = map[string]int{
+	"ZA": 18, // POPIA treats under-18s as children
+	"KE": 18, // Data Protection Act 2019
+	"NG": 13,
+	"GH": 13,
+	"DE": 16,
+	"FR": 15,
+	"NL": 16,
+	"IE": 16,
+	"GB": 13,
+	"US": 13,
+}
+
+// MinimumAge returns the minimum age to register from country.
+func MinimumAge(country string) int {
+	if age, ok := minimumAges[strings.ToUpper(country)]; ok {
+		return age
+	}
+	return DefaultMinimumAge
+}
+
+// Age returns the completed years between dateOfBirth and now.
+func Age(dateOfBirth, now time.Time) int {
+	years := now.Year() - dateOfBirth.Year()
+	if now.Month() < dateOfBirth.Month() || (now.Month() == dateOfBirth.Month() && now.Day() < dateOfBirth.Day()) {
+		years--
+	}
+	return years
+}
+
+// CheckRegistration validates an optional date of birth at signup. Users who
+// do not provide one are allowed to register but cannot join 18+ rooms.
+func CheckRegistration(dateOfBirth *time.Time, country string, now time.Time) error {
+	if dateOfBirth == nil {
+		return nil
+	}
+	if dateOfBirth.After(now) || Age(*dateOfBirth, now) > 130 {
+		return ErrInvalidBirthday
+	}
+	if Age(*dateOfBirth, now) < MinimumAge(country) {
+		return ErrTooYoung
+	}
+	return nil
+}
+
+// CheckAdultRoom is the join-path check for rooms flagged 18+.
+func CheckAdultRoom(dateOfBirth *time.Time, now time.Time) error {
+	if dateOfBirth == nil {
+		return ErrUnknownAge
+	}
+	if Age(*dateOfBirth, now) < AdultAge {
+		return ErrAdultOnly
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+// Package analytics samples how the product is used, for analytics that
+// cannot identify anyone. Users are known only by a keyed hash of their ID,
+// which cannot be reversed or linked to other data without the key, and
+// only counts of what they did are kept: never what they wrote, to whom,
+// or where.
+//
+// Only a sample of users, chosen by their hash so a user is sampled all the
+// time or never, is counted, and only those who opted in. Counts are summed
+// over an interval before they leave the server.
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// consentTTL is how long a user's consent is remembered before it is
+	// looked up again. Withdrawing consent through Forget takes effect at
+	// once.
+	consentTTL = 10 * time.Minute
+
+	// maxConsents bounds the consents remembered.
+	maxConsents = 100_000
+
+	publishTimeout = 30 * time.Second
+)
+
+// Usage is what one sampled user did over a period: how many times they
+// used each feature.
+type Usage struct {
+	Subject     string         `json:"subject"`
+	PeriodStart time.Time      `json:"period_start"`
+	PeriodEnd   time.Time      `json:"period_end"`
+	Features    map[string]int `json:"features"`
+}
+
+// Sink publishes usage to the analytics stream.
+type Sink interface {
+	Publish(ctx context.Context, usage []Usage) error
+}
+
+// Eraser is a Sink that can delete the usage it was sent for a subject,
+// when the account behind it is erased.
+type Eraser interface {
+	Erase(ctx context.Context, subject string) error
+}
+
+// LogSink writes usage to the log when no analytics stream is configured.
+type LogSink struct{}
+
+func (LogSink) Publish(_ context.Context, usage []Usage) error {
+	for _, u := range usage {
+		slog.Info("📊 Usage", "subject", u.Subject, "period_start", u.PeriodStart, "features", u.Features)
+	}
+	return nil
+}
+
+func (LogSink) Erase(_ context.Context, subject string) error {
+	slog.Info("📊 Usage erased", "subject", subject)
+	return nil
+}
+
+// HTTPSink posts usage as a JSON array to an analytics collector.
+type HTTPSink struct {
+	URL    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{URL: url, client: &http.Client{Timeout: publishTimeout}}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, usage []Usage) error {
+	body, err := json.Marshal(usage)
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to publish usage: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to publish usage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("analytics collector answered %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// Erase asks the collector to delete a subject's usage with a DELETE to
+// its URL naming the subject in the query.
+func (s *HTTPSink) Erase(ctx context.Context, subject string) error {
+	target, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("invalid analytics collector URL: %w", err)
+	}
+	query := target.Query()
+	query.Set("subject", subject)
+	target.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, target.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to erase usage: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to erase usage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("analytics collector answered %d: %s", resp.StatusCode, detail)
+	}
+	return nil
+}
+
+// ConsentFunc reports whether a user opted in to analytics.
+type ConsentFunc func(ctx context.Context, userID uuid.UUID) (bool, error)
+
+// Config sets how users are hashed and sampled, and how often usage is
+// published.
+type Config struct {
+	// HashKey keys the hash of user IDs. Changing it makes every user a
+	// new subject.
+	HashKey string
+
+	// SampleRate is the fraction of users counted.
+	SampleRate float64
+
+	// Interval is how long usage is summed over before it is published.
+	Interval time.Duration
+}
+
+// Sampler counts the usage of sampled, consenting users and publishes it
+// every interval. A nil Sampler counts nothing, so callers need not check
+// whether analytics are enabled.
+type Sampler struct {
+	config  Config
+	sink    Sink
+	consent ConsentFunc
+
+	mu          sync.Mutex
+	periodStart time.Time
+	usage       map[string]map[string]int
+	consents    map[uuid.UUID]consent
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type consent struct {
+	given   bool
+	checked time.Time
+}
+
+func New(config Config, sink Sink, consentFunc ConsentFunc) *Sampler {
+	return &Sampler{
+		config:      config,
+		sink:        sink,
+		consent:     consentFunc,
+		periodStart: time.Now(),
+		usage:       make(map[string]map[string]int),
+		consents:    make(map[uuid.UUID]consent),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Subject returns the keyed hash standing for a user.
+func (s *Sampler) Subject(userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(s.config.HashKey))
+	mac.Write(userID[:])
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// sampled reports whether a subject is in the sample. The hash is uniform,
+// so its leading bytes pick a sampleRate fraction of subjects.
+func (s *Sampler) sampled(subject string) bool {
+	raw, err := hex.DecodeString(subject[:16])
+	if err != nil {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(raw))/(1<<64) < s.config.SampleRate
+}
+
+// Add counts n uses of a feature by a user, if they are sampled and opted
+// in.
+func (s *Sampler) Add(ctx context.Context, userID uuid.UUID, feature string, n int) {
+	if s == nil || n <= 0 {
+		return
+	}
+	subject := s.Subject(userID)
+	if !s.sampled(subject) || !s.consented(ctx, userID) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	features, ok := s.usage[subject]
+	if !ok {
+		features = make(map[string]int)
+		s.usage[subject] = features
+	}
+	features[feature] += n
+}
+
+// consented reports whether a user opted in, remembering the answer for
+// consentTTL. A failed lookup counts as no.
+func (s *Sampler) consented(ctx context.Context, userID uuid.UUID) bool {
+	now := time.Now()
+	s.mu.Lock()
+	known, ok := s.consents[userID]
+	s.mu.Unlock()
+	if ok && now.Sub(known.checked) < consentTTL {
+		return known.given
+	}
+
+	given, err := s.consent(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check analytics consent", "error", err)
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.consents) >= maxConsents {
+		s.consents = make(map[uuid.UUID]consent)
+	}
+	s.consents[userID] = consent{given: given, checked: now}
+	return given
+}
+
+// Forget drops what is remembered of a user's consent and their usage not
+// yet published, as when they withdraw consent.
+func (s *Sampler) Forget(userID uuid.UUID) {
+	if s == nil {
+		return
+	}
+	subject := s.Subject(userID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.consents, userID)
+	delete(s.usage, subject)
+}
+
+// Erase forgets a user as Forget does and has the sink delete the usage
+// already published for them, if it can. It returns the subject the user
+// was known by, for the record of the erasure; a nil Sampler returns "".
+func (s *Sampler) Erase(ctx context.Context, userID uuid.UUID) (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	s.Forget(userID)
+	subject := s.Subject(userID)
+	eraser, ok := s.sink.(Eraser)
+	if !ok {
+		return subject, nil
+	}
+	return subject, eraser.Erase(ctx, subject)
+}
+
+// Start publishes usage every interval until Shutdown.
+func (s *Sampler) Start() {
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+				s.publish(ctx)
+				cancel()
+			}
+		}
+	}()
+}
+
+// Shutdown stops the sampler and publishes the usage of the period cut
+// short.
+func (s *Sampler) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	select {
+	case <-s.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return s.publish(ctx)
+}
+
+// publish sends the usage of the period ending now and starts the next.
+// Usage that fails to publish is dropped rather than held, so it never
+// builds up.
+func (s *Sampler) publish(ctx context.Context) error {
+	now := time.Now()
+	s.mu.Lock()
+	start, collected := s.periodStart, s.usage
+	s.periodStart = now
+	s.usage = make(map[string]map[string]int)
+	s.mu.Unlock()
+	if len(collected) == 0 {
+		return nil
+	}
+
+	usage := make([]Usage, 0, len(collected))
+	for subject, features := range collected {
+		usage = append(usage, Usage{Subject: subject, PeriodStart: start, PeriodEnd: now, Features: features})
+	}
+	if err := s.sink.Publish(ctx, usage); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish usage", "subjects", len(usage), "error", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package archive keeps the content of old messages in compressed segments
+// in object storage, and fills it back into messages as they are queried.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// cachedSegments is how many decoded segments are kept in memory, so
+// paging through archived history reads each segment once.
+const cachedSegments = 64
+
+// Entry is the archived content of one message.
+type Entry struct {
+	ID       uuid.UUID   `json:"id"`
+	Text     string      `json:"text"`
+	Entities models.JSON `json:"entities,omitempty"`
+	Payload  models.JSON `json:"payload,omitempty"`
+}
+
+// Segment is the content of the messages in a segment, by message ID.
+type Segment map[uuid.UUID]Entry
+
+// Archive reads and writes segments, caching the latest read.
+type Archive struct {
+	store storage.Storage
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[uuid.UUID]*list.Element
+}
+
+type cachedSegment struct {
+	id      uuid.UUID
+	segment Segment
+}
+
+func New(store storage.Storage) *Archive {
+	return &Archive{store: store, order: list.New(), entries: make(map[uuid.UUID]*list.Element)}
+}
+
+// Key is where a segment of a conversation is stored, before it is placed
+// in a residency region.
+func Key(conversationID, id uuid.UUID) string {
+	return "message-archive/" + conversationID.String() + "/" + id.String() + ".json.gz"
+}
+
+// EntryOf is the archived content of a message.
+func EntryOf(message *models.Message) Entry {
+	return Entry{ID: message.ID, Text: message.Text, Entities: message.Entities, Payload: message.Payload}
+}
+
+// Write stores the entries as segment id under key, replacing what it
+// held, and returns the size stored.
+func (a *Archive) Write(ctx context.Context, id uuid.UUID, key string, entries []Entry) (int64, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(entries); err != nil {
+		return 0, fmt.Errorf("failed to encode archive segment: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress archive segment: %w", err)
+	}
+	size := int64(buf.Len())
+	if err := a.store.Put(ctx, key, &buf, size, "application/gzip"); err != nil {
+		return 0, fmt.Errorf("failed to store archive segment: %w", err)
+	}
+	a.forget(id)
+	return size, nil
+}
+
+// cached returns segment id if it is in memory.
+func (a *Archive) cached(id uuid.UUID) (Segment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	element, ok := a.entries[id]
+	if !ok {
+		return nil, false
+	}
+	a.order.MoveToFront(element)
+	return element.Value.(*cachedSegment).segment, true
+}
+
+// Read loads segment id from key.
+func (a *Archive) Read(ctx context.Context, id uuid.UUID, key string) (Segment, error) {
+	if segment, ok := a.cached(id); ok {
+		return segment, nil
+	}
+	body, err := a.store.Open(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive segment %s: %w", id, err)
+	}
+	defer body.Close()
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress archive segment %s: %w", id, err)
+	}
+	var entries []Entry
+	if err := json.NewDecoder(io.LimitReader(gz, 1<<30)).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("failed to decode archive segment %s: %w", id, err)
+	}
+	segment := make(Segment, len(entries))
+	for _, entry := range entries {
+		segment[entry.ID] = entry
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.entries[id]; !ok {
+		a.entries[id] = a.order.PushFront(&cachedSegment{id: id, segment: segment})
+		if a.order.Len() > cachedSegments {
+			oldest := a.order.Back()
+			a.order.Remove(oldest)
+			delete(a.entries, oldest.Value.(*cachedSegment).id)
+		}
+	}
+	return segment, nil
+}
+
+// Delete removes segment id from key.
+func (a *Archive) Delete(ctx context.Context, id uuid.UUID, key string) error {
+	a.forget(id)
+	if err := a.store.Delete(ctx, key); err != nil {
+		return fmt.Errorf("failed to delete archive segment %s: %w", id, err)
+	}
+	return nil
+}
+
+func (a *Archive) forget(id uuid.UUID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if element, ok := a.entries[id]; ok {
+		a.order.Remove(element)
+		delete(a.entries, id)
+	}
+}
+
+// Name and Initialize make the archive a GORM plugin filling archived
+// content into the messages every query loads, preloads included, so
+// no repository has to know a message was archived. A segment that
+// cannot be read fails the query.
+func (a *Archive) Name() string {
+	return "archive"
+}
+
+func (a *Archive) Initialize(db *gorm.DB) error {
+	return db.Callback().Query().After("gorm:after_query").Register("archive:hydrate", a.hydrate)
+}
+
+func (a *Archive) hydrate(tx *gorm.DB) {
+	if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != (models.Message{}).TableName() {
+		return
+	}
+	var archived []*models.Message
+	collect := func(value reflect.Value) {
+		value = reflect.Indirect(value)
+		if !value.CanAddr() {
+			return
+		}
+		if message, ok := value.Addr().Interface().(*models.Message); ok && message.ArchiveSegmentID != nil {
+			archived = append(archived, message)
+		}
+	}
+	value := reflect.Indirect(tx.Statement.ReflectValue)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			collect(value.Index(i))
+		}
+	case reflect.Struct:
+		collect(value)
+	}
+
+	ctx := tx.Statement.Context
+	for _, message := range archived {
+		id := *message.ArchiveSegmentID
+		segment, ok := a.cached(id)
+		if !ok {
+			var keys []string
+			err := tx.Session(&gorm.Session{NewDB: true}).Model(&models.MessageArchiveSegment{}).
+				Where("id = ?", id).Pluck("storage_key", &keys).Error
+			if err == nil && len(keys) == 0 {
+				err = fmt.Errorf("archive segment %s does not exist", id)
+			}
+			if err == nil {
+				segment, err = a.Read(ctx, id, keys[0])
+			}
+			if err != nil {
+				tx.AddError(fmt.Errorf("failed to fetch archived messages: %w", err))
+				return
+			}
+		}
+		entry, found := segment[message.ID]
+		if !found {
+			tx.AddError(fmt.Errorf("archive segment %s is missing message %s", *message.ArchiveSegmentID, message.ID))
+			return
+		}
+		message.Text, message.Entities, message.Payload = entry.Text, entry.Entities, entry.Payload
+	}
+}
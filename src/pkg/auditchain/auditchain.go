@@ -0,0 +1,167 @@
+// Package auditchain makes the history of audit rooms tamper-evident. Each
+// message is hashed together with the hash of the message before it, so
+// altering, removing or reordering a stored message breaks the chain from
+// that message on.
+package auditchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+)
+
+// Reasons a chain fails verification.
+const (
+	// ReasonMissing means a message was removed: the chain skips its
+	// sequence number, or ends before the conversation's recorded length.
+	ReasonMissing = "missing"
+
+	// ReasonRelinked means a message no longer follows the message before
+	// it, as when messages were reordered or replaced.
+	ReasonRelinked = "relinked"
+
+	// ReasonAltered means a message's content no longer matches its hash.
+	ReasonAltered = "altered"
+
+	// ReasonHeadMismatch means the chain does not end at the hash the
+	// conversation recorded for its latest message.
+	ReasonHeadMismatch = "head_mismatch"
+)
+
+// link is what a message's hash covers, encoded as JSON in field order.
+type link struct {
+	Prev           []byte          `json:"prev"`
+	Seq            int64           `json:"seq"`
+	ID             uuid.UUID       `json:"id"`
+	ConversationID uuid.UUID       `json:"conversation_id"`
+	SenderID       uuid.UUID       `json:"sender_id"`
+	CreatedAt      time.Time       `json:"created_at"`
+	Type           string          `json:"type"`
+	Text           string          `json:"text"`
+	Entities       json.RawMessage `json:"entities"`
+	Payload        json.RawMessage `json:"payload"`
+	Attachments    []uuid.UUID     `json:"attachments"`
+}
+
+// Hash hashes a message as link seq of its conversation's chain, following
+// the message whose hash is prev; the first message follows nil. It covers
+// the attachments named by attachmentIDs, in any order.
+func Hash(prev []byte, seq int64, message *models.Message, attachmentIDs []uuid.UUID) ([]byte, error) {
+	entities, err := canonical(message.Entities)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := canonical(message.Payload)
+	if err != nil {
+		return nil, err
+	}
+	attachments := append([]uuid.UUID{}, attachmentIDs...)
+	slices.SortFunc(attachments, func(a, b uuid.UUID) int { return bytes.Compare(a[:], b[:]) })
+
+	// Postgres keeps timestamps to the microsecond, so the hash must not
+	// depend on finer precision or on the time zone they are read in.
+	encoded, err := json.Marshal(link{
+		Prev:           prev,
+		Seq:            seq,
+		ID:             message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		CreatedAt:      message.CreatedAt.UTC().Truncate(time.Microsecond),
+		Type:           message.Type,
+		Text:           message.Text,
+		Entities:       entities,
+		Payload:        payload,
+		Attachments:    attachments,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(encoded)
+	return sum[:], nil
+}
+
+// canonical re-encodes JSON with sorted keys and no insignificant space,
+// since Postgres stores jsonb in its own layout.
+func canonical(raw models.JSON) (json.RawMessage, error) {
+	if len(raw) == 0 {
+		return json.RawMessage("null"), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// Break is where verification found a chain broken.
+type Break struct {
+	Seq int64 `json:"seq"`
+
+	// MessageID is uuid.Nil when the message at Seq is missing.
+	MessageID uuid.UUID `json:"message_id"`
+	Reason    string    `json:"reason"`
+}
+
+// Verifier checks a chain one message at a time, in sequence order.
+type Verifier struct {
+	prev    []byte
+	next    int64
+	checked int64
+}
+
+func NewVerifier() *Verifier {
+	return &Verifier{next: 1}
+}
+
+// Check verifies the next message of the chain, with its attachments
+// loaded, and returns where the chain breaks if it does.
+func (v *Verifier) Check(message *models.Message) (*Break, error) {
+	seq := *message.AuditSeq
+	if seq != v.next {
+		return &Break{Seq: v.next, Reason: ReasonMissing}, nil
+	}
+	if !bytes.Equal(message.AuditPrevHash, v.prev) {
+		return &Break{Seq: seq, MessageID: message.ID, Reason: ReasonRelinked}, nil
+	}
+
+	attachmentIDs := make([]uuid.UUID, 0, len(message.Attachments))
+	for _, attachment := range message.Attachments {
+		attachmentIDs = append(attachmentIDs, attachment.ID)
+	}
+	hash, err := Hash(message.AuditPrevHash, seq, message, attachmentIDs)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(hash, message.AuditHash) {
+		return &Break{Seq: seq, MessageID: message.ID, Reason: ReasonAltered}, nil
+	}
+
+	v.prev = message.AuditHash
+	v.next++
+	v.checked++
+	return nil, nil
+}
+
+// Finish checks that the chain ended where the conversation recorded: at
+// length messages, the latest of them hashing to head.
+func (v *Verifier) Finish(length int64, head []byte) *Break {
+	if v.checked < length {
+		return &Break{Seq: v.next, Reason: ReasonMissing}
+	}
+	if !bytes.Equal(v.prev, head) {
+		return &Break{Seq: v.checked, Reason: ReasonHeadMismatch}
+	}
+	return nil
+}
+
+// Checked is the number of messages verified so far.
+func (v *Verifier) Checked() int64 {
+	return v.checked
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oauthStateIssuer differs from the access token issuer, so neither kind
+// of token passes for the other.
+const oauthStateIssuer = "afrochat-oauth"
+
+// OAuthState is what a sign-in with an OAuth provider needs to remember
+// while the user is away at the provider. It travels there and back in
+// the state parameter, signed so it cannot be altered.
+type OAuthState struct {
+	Provider string `json:"provider"`
+
+	// RedirectURI is the app address to return the user to.
+	RedirectURI string `json:"redirect_uri,omitempty"`
+
+	// InviteCode is redeemed if the sign-in creates an account.
+	InviteCode string `json:"invite_code,omitempty"`
+
+	// Binding is the hash of a secret kept in a cookie by the browser that
+	// started the sign-in, so no other browser can finish it.
+	Binding string `json:"binding"`
+
+	jwt.RegisteredClaims
+}
+
+// IssueOAuthState signs a state that expires after ttl.
+func (m *TokenManager) IssueOAuthState(state OAuthState, ttl time.Duration) (string, error) {
+	now := time.Now()
+	state.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    oauthStateIssuer,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, state).SignedString(m.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign OAuth state: %w", err)
+	}
+	return signed, nil
+}
+
+// ParseOAuthState validates a state issued by IssueOAuthState.
+func (m *TokenManager) ParseOAuthState(tokenString string) (*OAuthState, error) {
+	state := &OAuthState{}
+	_, err := jwt.ParseWithClaims(tokenString, state, func(*jwt.Token) (any, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(oauthStateIssuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return state, nil
+}
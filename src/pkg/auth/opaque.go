@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const opaqueTokenLen = 32
+
+// NewRefreshToken returns a random opaque refresh token and the hash to
+// store in its place.
+func NewRefreshToken() (token string, hash string, err error) {
+	return newOpaqueToken("refresh token")
+}
+
+// HashRefreshToken returns the hex SHA-256 of a refresh token. The tokens are
+// random, so a fast unsalted hash is enough to keep them out of the database.
+func HashRefreshToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// NewVerificationToken returns a random single-use token for an emailed
+// link and the hash to store in its place.
+func NewVerificationToken() (token string, hash string, err error) {
+	return newOpaqueToken("verification token")
+}
+
+// HashVerificationToken returns the hex SHA-256 of a verification token.
+func HashVerificationToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// APIKeyPrefix starts every API key, so they can be told apart from access
+// tokens.
+const APIKeyPrefix = "ak_"
+
+// NewAPIKey returns a random API key for a bot and the hash to store in its
+// place.
+func NewAPIKey() (key string, hash string, err error) {
+	token, _, err := newOpaqueToken("API key")
+	if err != nil {
+		return "", "", err
+	}
+	key = APIKeyPrefix + token
+	return key, HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex SHA-256 of an API key.
+func HashAPIKey(key string) string {
+	return hashOpaqueToken(key)
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than an
+// access token.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// NewWebhookToken returns a random token for an incoming webhook's URL and
+// the hash to store in its place.
+func NewWebhookToken() (token string, hash string, err error) {
+	return newOpaqueToken("webhook token")
+}
+
+// HashWebhookToken returns the hex SHA-256 of a webhook token.
+func HashWebhookToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+// NewViewOnceToken returns a random single-use token to download a
+// view-once attachment with and the hash to store in its place.
+func NewViewOnceToken() (token string, hash string, err error) {
+	return newOpaqueToken("view-once token")
+}
+
+// HashViewOnceToken returns the hex SHA-256 of a view-once token.
+func HashViewOnceToken(token string) string {
+	return hashOpaqueToken(token)
+}
+
+func newOpaqueToken(kind string) (string, string, error) {
+	raw := make([]byte, opaqueTokenLen)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", fmt.Errorf("failed to generate %s: %w", kind, err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashOpaqueToken(token), nil
+}
+
+func hashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
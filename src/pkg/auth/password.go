@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Argon2id parameters, following the OWASP baseline. They are encoded into
+// every hash so they can be raised later without invalidating old hashes.
+const (
+	argonTime    = 2
+	argonMemory  = 19 * 1024
+	argonThreads = 1
+	argonKeyLen  = 32
+	saltLen      = 16
+)
+
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// HashPassword derives an argon2id hash with a fresh random salt. The salt is
+// returned separately to match the users.salt column.
+func HashPassword(password string) (hash string, salt string, err error) {
+	saltBytes := make([]byte, saltLen)
+	if _, err := rand.Read(saltBytes); err != nil {
+		return "", "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), saltBytes, argonTime, argonMemory, argonThreads, argonKeyLen)
+	hash = fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads, base64.RawStdEncoding.EncodeToString(key))
+	return hash, base64.RawStdEncoding.EncodeToString(saltBytes), nil
+}
+
+// CheckPassword reports whether password matches hash and salt in constant
+// time.
+func CheckPassword(password, hash, salt string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[1] != "argon2id" {
+		return false, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrMalformedHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrMalformedHash
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	saltBytes, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+
+	key := argon2.IDKey([]byte(password), saltBytes, time, memory, threads, uint32(len(expected)))
+	return subtle.ConstantTimeCompare(key, expected) == 1, nil
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// resumeIssuer differs from the access token issuer, so neither kind of
+// token passes for the other.
+const resumeIssuer = "afrochat-resume"
+
+// ResumeClaims let a client reopen a dropped WebSocket as the same user
+// and session without presenting its access token again.
+type ResumeClaims struct {
+	SessionID string `json:"sid,omitempty"`
+
+	jwt.RegisteredClaims
+}
+
+// UserID returns the subject of the token as a UUID.
+func (c *ResumeClaims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// Session returns the session the token was issued for, or uuid.Nil.
+func (c *ResumeClaims) Session() uuid.UUID {
+	id, err := uuid.Parse(c.SessionID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// IssueResumeToken signs a resume token for the given user and session
+// that expires after ttl.
+func (m *TokenManager) IssueResumeToken(userID, sessionID uuid.UUID, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	claims := ResumeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    resumeIssuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+	}
+	if sessionID != uuid.Nil {
+		claims.SessionID = sessionID.String()
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign resume token: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// ParseResumeToken validates a token issued by IssueResumeToken.
+func (m *TokenManager) ParseResumeToken(tokenString string) (*ResumeClaims, error) {
+	claims := &ResumeClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (any, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(resumeIssuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,30 @@
+package auth
+
+// Scopes limit what an access token may do, so tokens for bots and
+// automation can be issued with less than their user could do. Checking a
+// scope never grants anything the user's role does not already allow.
+const (
+	// ScopeRead reads the user's data: profile, conversations, messages
+	// and contacts.
+	ScopeRead = "read"
+
+	// ScopeWrite changes the user's data, including sending messages.
+	ScopeWrite = "write"
+
+	// ScopeAdmin reaches the administration and moderation routes, for
+	// users whose role allows them.
+	ScopeAdmin = "admin"
+)
+
+// UserScopes are the scopes of a token issued to a user who signed in,
+// with ScopeAdmin for staff.
+func UserScopes(staff bool) []string {
+	if staff {
+		return []string{ScopeRead, ScopeWrite, ScopeAdmin}
+	}
+	return []string{ScopeRead, ScopeWrite}
+}
+
+// BotScopes are the scopes an API key may be issued with. Bots never reach
+// the administration routes.
+var BotScopes = []string{ScopeRead, ScopeWrite}
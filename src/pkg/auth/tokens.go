@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const issuer = "afrochat"
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+type Claims struct {
+	Username string `json:"username"`
+
+	// SessionID names the session the token was issued for, so revoking the
+	// session also rejects its outstanding access tokens.
+	SessionID string `json:"sid,omitempty"`
+
+	// Experiments holds the user's experiment variants as of issue time, so
+	// clients can branch before their first API call.
+	Experiments map[string]string `json:"experiments,omitempty"`
+
+	// Scope lists what the token may do, separated by spaces as in OAuth.
+	Scope string `json:"scope,omitempty"`
+
+	// Role and Tier are the user's as of issue time, for clients to adapt
+	// to. The server checks the user's current ones.
+	Role string `json:"role,omitempty"`
+	Tier string `json:"tier,omitempty"`
+
+	// Workspaces lists the workspaces the user belonged to as of issue
+	// time, the default one included. The token only reaches those, so one
+	// joined since needs a refreshed token.
+	Workspaces []string `json:"workspaces,omitempty"`
+
+	jwt.RegisteredClaims
+}
+
+// UserID returns the subject of the token as a UUID.
+func (c *Claims) UserID() (uuid.UUID, error) {
+	return uuid.Parse(c.Subject)
+}
+
+// Session returns the session the token was issued for, or uuid.Nil for
+// tokens issued before sessions existed.
+func (c *Claims) Session() uuid.UUID {
+	id, err := uuid.Parse(c.SessionID)
+	if err != nil {
+		return uuid.Nil
+	}
+	return id
+}
+
+// HasScope reports whether the token grants scope. Tokens issued before
+// scopes existed have none and may do anything their user can.
+func (c *Claims) HasScope(scope string) bool {
+	return c.Scope == "" || slices.Contains(strings.Fields(c.Scope), scope)
+}
+
+// InWorkspace reports whether the token reaches the workspace. Tokens
+// issued before workspaces were claimed, and API keys, name none and
+// reach any their user belongs to.
+func (c *Claims) InWorkspace(id uuid.UUID) bool {
+	return len(c.Workspaces) == 0 || slices.Contains(c.Workspaces, id.String())
+}
+
+// Grant describes the capabilities an access token is issued with.
+type Grant struct {
+	Role       string
+	Tier       string
+	Scopes     []string
+	Workspaces []uuid.UUID
+}
+
+// TokenManager issues and validates HS256 access tokens, and knows how long
+// the refresh tokens that renew them last.
+type TokenManager struct {
+	secret     []byte
+	ttl        time.Duration
+	refreshTTL time.Duration
+}
+
+func NewTokenManager(secret string, ttl, refreshTTL time.Duration) *TokenManager {
+	return &TokenManager{secret: []byte(secret), ttl: ttl, refreshTTL: refreshTTL}
+}
+
+func (m *TokenManager) TTL() time.Duration {
+	return m.ttl
+}
+
+func (m *TokenManager) RefreshTTL() time.Duration {
+	return m.refreshTTL
+}
+
+// Issue signs an access token for the given user and session, granting
+// what grant allows.
+func (m *TokenManager) Issue(userID, sessionID uuid.UUID, username string, grant Grant, experiments map[string]string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(m.ttl)
+	workspaces := make([]string, len(grant.Workspaces))
+	for i, id := range grant.Workspaces {
+		workspaces[i] = id.String()
+	}
+	claims := Claims{
+		Username:    username,
+		SessionID:   sessionID.String(),
+		Experiments: experiments,
+		Scope:       strings.Join(grant.Scopes, " "),
+		Role:        grant.Role,
+		Tier:        grant.Tier,
+		Workspaces:  workspaces,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			ID:        uuid.NewString(),
+		},
+	}
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.secret)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+	return token, expiresAt, nil
+}
+
+// Parse validates the signature, algorithm, issuer and expiry of a token.
+func (m *TokenManager) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(*jwt.Token) (any, error) {
+		return m.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(issuer),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
@@ -0,0 +1,121 @@
+package autoreply
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+)
+
+// Inbound describes a direct message that may trigger an auto-reply.
+type Inbound struct {
+	SenderID     uuid.UUID
+	RecipientID  uuid.UUID
+	Text         string
+	FirstContact bool
+	FromBot      bool
+	IsAutoReply  bool
+	ReceivedAt   time.Time
+}
+
+type pair struct {
+	owner  uuid.UUID
+	sender uuid.UUID
+}
+
+// Engine picks the auto-reply for an inbound DM. It keeps per-conversation
+// cooldown state so two auto-responding accounts cannot ping-pong forever.
+type Engine struct {
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	lastReply map[pair]time.Time
+}
+
+func NewEngine(cooldown time.Duration) *Engine {
+	return &Engine{
+		cooldown:  cooldown,
+		lastReply: make(map[pair]time.Time),
+	}
+}
+
+// Evaluate returns the rule that should answer msg, or nil. Rules are tried
+// in descending priority; location is the recipient's time zone.
+func (e *Engine) Evaluate(rules []models.AutoReplyRule, msg Inbound, location *time.Location) *models.AutoReplyRule {
+	// Never answer automated traffic: this is what breaks bot-to-bot loops.
+	if msg.FromBot || msg.IsAutoReply || msg.SenderID == msg.RecipientID {
+		return nil
+	}
+
+	key := pair{owner: msg.RecipientID, sender: msg.SenderID}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if last, ok := e.lastReply[key]; ok && msg.ReceivedAt.Sub(last) < e.cooldown {
+		return nil
+	}
+
+	sorted := make([]models.AutoReplyRule, len(rules))
+	copy(sorted, rules)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority > sorted[j].Priority })
+
+	for i := range sorted {
+		rule := &sorted[i]
+		if !rule.Enabled || !matches(rule, msg, location) {
+			continue
+		}
+		e.lastReply[key] = msg.ReceivedAt
+		return rule
+	}
+	return nil
+}
+
+// Prune drops cooldown entries older than the cooldown window.
+func (e *Engine) Prune(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, last := range e.lastReply {
+		if now.Sub(last) >= e.cooldown {
+			delete(e.lastReply, key)
+		}
+	}
+}
+
+func matches(rule *models.AutoReplyRule, msg Inbound, location *time.Location) bool {
+	switch rule.Kind {
+	case models.AutoReplyGreeting:
+		return msg.FirstContact
+	case models.AutoReplyKeyword:
+		return ContainsKeyword(msg.Text, rule.Keywords)
+	case models.AutoReplySchedule:
+		return inSchedule(rule, msg.ReceivedAt.In(location))
+	}
+	return false
+}
+
+// ContainsKeyword reports whether text contains one of the keywords,
+// separated by commas, ignoring case.
+func ContainsKeyword(text, keywords string) bool {
+	text = strings.ToLower(text)
+	for _, keyword := range strings.Split(keywords, ",") {
+		keyword = strings.ToLower(strings.TrimSpace(keyword))
+		if keyword != "" && strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func inSchedule(rule *models.AutoReplyRule, local time.Time) bool {
+	if rule.Days&(1<<uint(local.Weekday())) == 0 {
+		return false
+	}
+	minute := local.Hour()*60 + local.Minute()
+	if rule.StartMinute <= rule.EndMinute {
+		return minute >= rule.StartMinute && minute < rule.EndMinute
+	}
+	// Windows such as 18:00-08:00 wrap past midnight.
+	return minute >= rule.StartMinute || minute < rule.EndMinute
+}
@@ -0,0 +1,52 @@
+package autoreply
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+)
+
+// questionWords start messages that ask something without a question
+// mark, as people often type them.
+var questionWords = map[string]bool{
+	"who": true, "what": true, "when": true, "where": true, "why": true, "how": true, "which": true,
+	"is": true, "are": true, "can": true, "could": true, "do": true, "does": true, "did": true,
+	"will": true, "would": true, "should": true, "anyone": true, "anybody": true,
+}
+
+// IsQuestion reports whether text reads like a question: it has a
+// question mark or starts with a question word.
+func IsQuestion(text string) bool {
+	if strings.Contains(text, "?") {
+		return true
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(text), " ")
+	first = strings.TrimFunc(strings.ToLower(first), func(r rune) bool { return !unicode.IsLetter(r) })
+	return questionWords[first]
+}
+
+// MatchFAQ returns the enabled entry sharing the most keywords with a
+// question, or nil when none shares any. Ties go to the entry listed
+// first.
+func MatchFAQ(faqs []models.RoomFAQ, question string) *models.RoomFAQ {
+	question = strings.ToLower(question)
+	var best *models.RoomFAQ
+	bestScore := 0
+	for i := range faqs {
+		if !faqs[i].Enabled {
+			continue
+		}
+		score := 0
+		for _, keyword := range strings.Split(faqs[i].Keywords, ",") {
+			keyword = strings.ToLower(strings.TrimSpace(keyword))
+			if keyword != "" && strings.Contains(question, keyword) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = &faqs[i], score
+		}
+	}
+	return best
+}
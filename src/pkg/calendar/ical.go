@@ -0,0 +1,77 @@
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+// Entry is a single calendar event with a stable UID so that subscribed
+// calendars update events in place instead of duplicating them.
+type Entry struct {
+	UID       string
+	Event     content.Event
+	UpdatedAt time.Time
+}
+
+// WriteICS renders entries as an RFC 5545 calendar suitable for serving as
+// text/calendar at a subscribable URL.
+func WriteICS(w io.Writer, name string, entries []Entry) error {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:-//AfroChat//Room Calendar//EN")
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "METHOD:PUBLISH")
+	writeLine(&b, "X-WR-CALNAME:"+escapeText(name))
+
+	for _, entry := range entries {
+		event := entry.Event
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+escapeText(entry.UID))
+		writeLine(&b, "DTSTAMP:"+entry.UpdatedAt.UTC().Format(icsTimeFormat))
+		writeLine(&b, "DTSTART:"+event.StartsAt.UTC().Format(icsTimeFormat))
+		if event.EndsAt != nil {
+			writeLine(&b, "DTEND:"+event.EndsAt.UTC().Format(icsTimeFormat))
+		}
+		writeLine(&b, "SUMMARY:"+escapeText(event.Title))
+		if event.Location != "" {
+			writeLine(&b, "LOCATION:"+escapeText(event.Location))
+		}
+		writeLine(&b, "END:VEVENT")
+	}
+
+	writeLine(&b, "END:VCALENDAR")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write calendar: %w", err)
+	}
+	return nil
+}
+
+func escapeText(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(value)
+}
+
+// writeLine folds content lines longer than 75 octets as the spec requires,
+// without splitting a UTF-8 sequence.
+func writeLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// Continuation lines start with a space, which counts toward the limit.
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
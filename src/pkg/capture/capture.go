@@ -0,0 +1,126 @@
+package capture
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/push"
+)
+
+// The kinds of side effect captured.
+const (
+	KindEmail = "email"
+	KindPush  = "push"
+)
+
+// maxEntries bounds what a Store keeps. The oldest entries go first.
+const maxEntries = 1000
+
+// Entry is an email or push that was captured instead of sent. To is the
+// email address or device token, and Subject the email's subject or the
+// push's title.
+type Entry struct {
+	ID       int64             `json:"id"`
+	Kind     string            `json:"kind"`
+	Platform string            `json:"platform,omitempty"`
+	To       string            `json:"to"`
+	Subject  string            `json:"subject"`
+	Body     string            `json:"body"`
+	ThreadID string            `json:"thread_id,omitempty"`
+	Data     map[string]string `json:"data,omitempty"`
+	At       time.Time         `json:"at"`
+}
+
+// Store keeps outgoing email and pushes in memory instead of sending them,
+// for end-to-end tests of the apps against a real backend. Entries are
+// numbered from 1 in the order captured, and numbering starts over when
+// the store is cleared, so each test run sees the same IDs.
+type Store struct {
+	mu      sync.Mutex
+	entries []Entry
+	lastID  int64
+}
+
+func New() *Store {
+	return &Store{}
+}
+
+func (s *Store) record(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	entry.ID = s.lastID
+	entry.At = time.Now()
+	if len(s.entries) == maxEntries {
+		s.entries = s.entries[1:]
+	}
+	s.entries = append(s.entries, entry)
+}
+
+// List returns the entries captured after the ID after, oldest first,
+// keeping only those of kind and to when they are not empty.
+func (s *Store) List(kind, to string, after int64) []Entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]Entry, 0)
+	for _, entry := range s.entries {
+		if entry.ID <= after || (kind != "" && entry.Kind != kind) || (to != "" && entry.To != to) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Clear forgets every entry.
+func (s *Store) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = nil
+	s.lastID = 0
+}
+
+// Mailer returns a mailer that captures email into the store.
+func (s *Store) Mailer() mailer.Mailer {
+	return storeMailer{s}
+}
+
+// PushSenders returns push senders for every platform that capture pushes
+// into the store.
+func (s *Store) PushSenders() map[string]push.Sender {
+	return map[string]push.Sender{
+		push.PlatformAndroid: storeSender{s, push.PlatformAndroid},
+		push.PlatformIOS:     storeSender{s, push.PlatformIOS},
+		push.PlatformWeb:     storeSender{s, push.PlatformWeb},
+	}
+}
+
+type storeMailer struct {
+	store *Store
+}
+
+func (m storeMailer) Send(_ context.Context, message mailer.Message) error {
+	m.store.record(Entry{Kind: KindEmail, To: message.To, Subject: message.Subject, Body: message.Body})
+	return nil
+}
+
+type storeSender struct {
+	store    *Store
+	platform string
+}
+
+func (s storeSender) Send(_ context.Context, token string, notification push.Notification) error {
+	s.store.record(Entry{
+		Kind:     KindPush,
+		Platform: s.platform,
+		To:       token,
+		Subject:  notification.Title,
+		Body:     notification.Body,
+		ThreadID: notification.ThreadID,
+		Data:     maps.Clone(notification.Data),
+	})
+	return nil
+}
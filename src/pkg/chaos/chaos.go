@@ -0,0 +1,91 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrInjected is the error returned by injected database failures.
+var ErrInjected = errors.New("chaos: injected database failure")
+
+// Config sets how often each fault is injected. Rates are fractions from 0
+// to 1; a zero rate disables that fault.
+type Config struct {
+	// LatencyRate of requests are delayed by up to MaxLatency.
+	LatencyRate float64
+	MaxLatency  time.Duration
+
+	// FrameDropRate of WebSocket frames, in either direction, are dropped.
+	FrameDropRate float64
+
+	// DBErrorRate of database operations fail with ErrInjected.
+	DBErrorRate float64
+}
+
+// Injector decides at random when to inject faults, so clients can be
+// tested against a server that is slow, lossy and failing.
+type Injector struct {
+	config Config
+}
+
+func New(config Config) *Injector {
+	return &Injector{config: config}
+}
+
+// Delay waits a random time up to the maximum latency, for the configured
+// fraction of calls, or until ctx is done.
+func (i *Injector) Delay(ctx context.Context) {
+	if !roll(i.config.LatencyRate) || i.config.MaxLatency <= 0 {
+		return
+	}
+	timer := time.NewTimer(rand.N(i.config.MaxLatency))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// DropFrame reports whether to drop a WebSocket frame.
+func (i *Injector) DropFrame() bool {
+	return roll(i.config.FrameDropRate)
+}
+
+// Name and Initialize make the injector a GORM plugin that fails the
+// configured fraction of database operations before they run. Every
+// repository sees the failure as if it came from the database.
+func (i *Injector) Name() string {
+	return "chaos"
+}
+
+func (i *Injector) Initialize(db *gorm.DB) error {
+	inject := func(tx *gorm.DB) {
+		if roll(i.config.DBErrorRate) {
+			tx.AddError(ErrInjected)
+		}
+	}
+	if err := db.Callback().Create().Before("gorm:create").Register("chaos:create", inject); err != nil {
+		return err
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register("chaos:query", inject); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("chaos:update", inject); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("chaos:delete", inject); err != nil {
+		return err
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("chaos:row", inject); err != nil {
+		return err
+	}
+	return db.Callback().Raw().Before("gorm:raw").Register("chaos:raw", inject)
+}
+
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
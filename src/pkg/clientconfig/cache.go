@@ -0,0 +1,48 @@
+package clientconfig
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache holds the current rules in memory, reloading them at most once per
+// TTL so the public endpoint does not hit the database on every request.
+type Cache struct {
+	load func(ctx context.Context) ([]Rule, error)
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	rules    []Rule
+	loadedAt time.Time
+}
+
+func NewCache(ttl time.Duration, load func(ctx context.Context) ([]Rule, error)) *Cache {
+	return &Cache{load: load, ttl: ttl}
+}
+
+// Rules returns the cached rules, reloading them when stale. If a reload
+// fails the previous rules are kept and the error is returned with them.
+func (c *Cache) Rules(ctx context.Context) ([]Rule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < c.ttl {
+		return c.rules, nil
+	}
+
+	rules, err := c.load(ctx)
+	if err != nil {
+		return c.rules, err
+	}
+	c.rules = rules
+	c.loadedAt = time.Now()
+	return rules, nil
+}
+
+// Invalidate forces the next call to Rules to reload.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.loadedAt = time.Time{}
+	c.mu.Unlock()
+}
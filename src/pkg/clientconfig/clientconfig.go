@@ -0,0 +1,168 @@
+package clientconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+)
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWeb     = "web"
+)
+
+var (
+	ErrInvalidSettings = errors.New("invalid client settings")
+
+	hostPattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)+$`)
+)
+
+// Settings is the configuration delivered to clients. In a rule every field
+// is optional and only the fields that are set override earlier rules.
+type Settings struct {
+	Features            map[string]bool  `json:"features,omitempty"`
+	MinSupportedVersion string           `json:"min_supported_version,omitempty"`
+	UpgradeURL          string           `json:"upgrade_url,omitempty"`
+	UploadLimits        map[string]int64 `json:"upload_limits,omitempty"`
+	MediaCDNHosts       []string         `json:"media_cdn_hosts,omitempty"`
+}
+
+// Validate checks the values that are set.
+func (s Settings) Validate() error {
+	if s.MinSupportedVersion != "" {
+		if _, err := ParseVersion(s.MinSupportedVersion); err != nil {
+			return fmt.Errorf("%w: min_supported_version: %v", ErrInvalidSettings, err)
+		}
+	}
+	if s.UpgradeURL != "" {
+		if parsed, err := url.Parse(s.UpgradeURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("%w: upgrade_url must be an https URL", ErrInvalidSettings)
+		}
+	}
+	for kind, limit := range s.UploadLimits {
+		if limit <= 0 {
+			return fmt.Errorf("%w: upload limit for %q must be positive", ErrInvalidSettings, kind)
+		}
+	}
+	for _, host := range s.MediaCDNHosts {
+		if !hostPattern.MatchString(host) {
+			return fmt.Errorf("%w: invalid media CDN host %q", ErrInvalidSettings, host)
+		}
+	}
+	return nil
+}
+
+// Merge returns s with the fields set in override applied on top.
+func (s Settings) Merge(override Settings) Settings {
+	merged := Settings{
+		Features:            make(map[string]bool, len(s.Features)+len(override.Features)),
+		MinSupportedVersion: s.MinSupportedVersion,
+		UpgradeURL:          s.UpgradeURL,
+		UploadLimits:        make(map[string]int64, len(s.UploadLimits)+len(override.UploadLimits)),
+		MediaCDNHosts:       s.MediaCDNHosts,
+	}
+	for name, enabled := range s.Features {
+		merged.Features[name] = enabled
+	}
+	for name, enabled := range override.Features {
+		merged.Features[name] = enabled
+	}
+	for kind, limit := range s.UploadLimits {
+		merged.UploadLimits[kind] = limit
+	}
+	for kind, limit := range override.UploadLimits {
+		merged.UploadLimits[kind] = limit
+	}
+	if override.MinSupportedVersion != "" {
+		merged.MinSupportedVersion = override.MinSupportedVersion
+	}
+	if override.UpgradeURL != "" {
+		merged.UpgradeURL = override.UpgradeURL
+	}
+	if len(override.MediaCDNHosts) > 0 {
+		merged.MediaCDNHosts = override.MediaCDNHosts
+	}
+	return merged
+}
+
+// Rule applies Settings to clients on Platform ("" for every platform)
+// whose version is within [MinVersion, MaxVersion]; empty bounds are open.
+type Rule struct {
+	Platform   string
+	MinVersion string
+	MaxVersion string
+	Priority   int
+	Settings   Settings
+}
+
+func (r Rule) matches(platform string, version *Version) bool {
+	if r.Platform != "" && r.Platform != platform {
+		return false
+	}
+	if r.MinVersion == "" && r.MaxVersion == "" {
+		return true
+	}
+	// Version-scoped rules never apply to clients that did not say which
+	// version they are.
+	if version == nil {
+		return false
+	}
+	if min, err := ParseVersion(r.MinVersion); r.MinVersion != "" && (err != nil || version.Compare(min) < 0) {
+		return false
+	}
+	if max, err := ParseVersion(r.MaxVersion); r.MaxVersion != "" && (err != nil || version.Compare(max) > 0) {
+		return false
+	}
+	return true
+}
+
+// Defaults are the settings every client gets before any rule applies.
+func Defaults() Settings {
+	return Settings{
+		UploadLimits: map[string]int64{
+			"image":    content.MaxImageBytes,
+			"audio":    content.MaxAudioBytes,
+			"video":    content.MaxVideoBytes,
+			"document": content.MaxDocumentBytes,
+		},
+	}
+}
+
+// Resolve merges the matching rules onto Defaults. Rules apply in priority
+// order; at equal priority, platform-specific rules apply after global ones
+// so they win. version may be nil when the client did not send one.
+func Resolve(rules []Rule, platform string, version *Version) Settings {
+	matching := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.matches(platform, version) {
+			matching = append(matching, rule)
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool {
+		if matching[i].Priority != matching[j].Priority {
+			return matching[i].Priority < matching[j].Priority
+		}
+		return matching[i].Platform == "" && matching[j].Platform != ""
+	})
+
+	settings := Defaults()
+	for _, rule := range matching {
+		settings = settings.Merge(rule.Settings)
+	}
+	return settings
+}
+
+// Unsupported reports whether a client at version is older than the
+// minimum supported version in settings.
+func (s Settings) Unsupported(version Version) bool {
+	if s.MinSupportedVersion == "" {
+		return false
+	}
+	min, err := ParseVersion(s.MinSupportedVersion)
+	return err == nil && version.Compare(min) < 0
+}
@@ -0,0 +1,52 @@
+package clientconfig
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a client app version of the form major.minor.patch. Missing
+// components count as zero, and a pre-release or build suffix is ignored.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+func ParseVersion(value string) (Version, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if i := strings.IndexAny(value, "-+"); i >= 0 {
+		value = value[:i]
+	}
+	parts := strings.Split(value, ".")
+	if value == "" || len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q", value)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid version %q", value)
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// Compare returns -1, 0 or 1 as v is older than, equal to or newer than
+// other.
+func (v Version) Compare(other Version) int {
+	for _, d := range [3]int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
@@ -0,0 +1,22 @@
+package commands
+
+import (
+	"context"
+	"strings"
+)
+
+// shrug is written as markdown, which text messages are parsed as.
+const shrug = `¯\\\_(ツ)\_/¯`
+
+// Shrug returns the /shrug command, which posts its text followed by a
+// shrug.
+func Shrug() Command {
+	return Command{
+		Name:        "shrug",
+		Usage:       "/shrug [text]",
+		Description: "Post your message with ¯\\_(ツ)_/¯ appended",
+		Handler: func(ctx context.Context, invocation Invocation) (*Response, error) {
+			return &Response{Text: strings.TrimSpace(invocation.Args + " " + shrug)}, nil
+		},
+	}
+}
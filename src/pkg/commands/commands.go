@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrNotCommand     = errors.New("message is not a slash command")
+	ErrUnknownCommand = errors.New("unknown command")
+)
+
+// Invocation is a parsed slash command sent by a user in a conversation.
+type Invocation struct {
+	Name           string
+	Args           string
+	UserID         uuid.UUID
+	ConversationID uuid.UUID
+}
+
+// Response is what a handler wants shown. Ephemeral responses are only
+// delivered to the invoking user; public ones are posted to the conversation.
+type Response struct {
+	Text      string `json:"text"`
+	Ephemeral bool   `json:"ephemeral"`
+}
+
+type Handler func(ctx context.Context, invocation Invocation) (*Response, error)
+
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Handler     Handler
+}
+
+// Registry holds the commands built-in modules and bots have registered.
+type Registry struct {
+	mu       sync.RWMutex
+	commands map[string]Command
+}
+
+func NewRegistry() *Registry {
+	return &Registry{commands: make(map[string]Command)}
+}
+
+func (r *Registry) Register(command Command) error {
+	name := strings.ToLower(command.Name)
+	if !validName(name) {
+		return fmt.Errorf("invalid command name %q", command.Name)
+	}
+	if command.Handler == nil {
+		return fmt.Errorf("command %q has no handler", name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.commands[name]; exists {
+		return fmt.Errorf("command %q is already registered", name)
+	}
+	command.Name = name
+	r.commands[name] = command
+	return nil
+}
+
+// List returns registered commands sorted by name, for client autocomplete.
+func (r *Registry) List() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]Command, 0, len(r.commands))
+	for _, command := range r.commands {
+		list = append(list, command)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Dispatch parses text and runs the matching handler. It returns
+// ErrNotCommand for ordinary messages so callers can fall through to the
+// normal send path.
+func (r *Registry) Dispatch(ctx context.Context, text string, userID, conversationID uuid.UUID) (*Response, error) {
+	name, args, ok := Parse(text)
+	if !ok {
+		return nil, ErrNotCommand
+	}
+
+	r.mu.RLock()
+	command, exists := r.commands[name]
+	r.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("%w: /%s", ErrUnknownCommand, name)
+	}
+
+	return command.Handler(ctx, Invocation{
+		Name:           name,
+		Args:           args,
+		UserID:         userID,
+		ConversationID: conversationID,
+	})
+}
+
+// Parse splits "/name args..." into its parts. A leading "//" escapes the
+// slash so users can send messages that start with one.
+func Parse(text string) (string, string, bool) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	if !strings.HasPrefix(text, "/") || strings.HasPrefix(text, "//") {
+		return "", "", false
+	}
+
+	name, args, _ := strings.Cut(text[1:], " ")
+	name = strings.ToLower(name)
+	if !validName(name) {
+		return "", "", false
+	}
+	return name, strings.TrimSpace(args), true
+}
+
+// Unescape drops the slash escaping text that starts with "//", which is
+// then sent starting with a single one, and reports whether it did.
+func Unescape(text string) (string, bool) {
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	if !strings.HasPrefix(trimmed, "//") {
+		return text, false
+	}
+	return trimmed[1:], true
+}
+
+func validName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9') && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,91 @@
+package commands_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/commands"
+	"github.com/google/uuid"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		text, name, args string
+		ok               bool
+	}{
+		{"/shrug fine", "shrug", "fine", true},
+		{"  /Remind me in 2h  stretch ", "remind", "me in 2h  stretch", true},
+		{"//shrug", "", "", false},
+		{"/", "", "", false},
+		{"hello /shrug", "", "", false},
+	} {
+		name, args, ok := commands.Parse(tc.text)
+		if name != tc.name || args != tc.args || ok != tc.ok {
+			t.Errorf("Parse(%q) = %q, %q, %v; want %q, %q, %v", tc.text, name, args, ok, tc.name, tc.args, tc.ok)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for _, tc := range []struct {
+		text, want string
+		escaped    bool
+	}{
+		{"//shrug is a command", "/shrug is a command", true},
+		{" ///", "//", true},
+		{"/shrug", "/shrug", false},
+		{"a // b", "a // b", false},
+	} {
+		got, escaped := commands.Unescape(tc.text)
+		if got != tc.want || escaped != tc.escaped {
+			t.Errorf("Unescape(%q) = %q, %v; want %q, %v", tc.text, got, escaped, tc.want, tc.escaped)
+		}
+	}
+}
+
+func TestGiphy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("q") == "nothing" {
+			w.Write([]byte(`{"data":[]}`))
+			return
+		}
+		w.Write([]byte(`{"data":[{"images":{"original":{"url":"https://media.giphy.com/cat.gif"}}}]}`))
+	}))
+	defer server.Close()
+
+	registry := commands.NewRegistry()
+	if err := registry.Register(commands.Giphy(server.URL, "key")); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, tc := range []struct {
+		text, want string
+		ephemeral  bool
+	}{
+		{"/giphy cat", "https://media.giphy.com/cat.gif", false},
+		{"/giphy nothing", `No GIFs found for "nothing"`, true},
+		{"/giphy", "Usage: /giphy <search terms>", true},
+	} {
+		response, err := registry.Dispatch(ctx, tc.text, uuid.Nil, uuid.Nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.text, err)
+		}
+		if response.Text != tc.want || response.Ephemeral != tc.ephemeral {
+			t.Errorf("%s = %+v; want %q, ephemeral %v", tc.text, response, tc.want, tc.ephemeral)
+		}
+	}
+
+	unauthorized := commands.NewRegistry()
+	if err := unauthorized.Register(commands.Giphy(server.URL, "wrong")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.Dispatch(ctx, "/giphy cat", uuid.Nil, uuid.Nil); err == nil {
+		t.Fatal("rejected API key not reported")
+	}
+}
@@ -0,0 +1,64 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// GiphySearchURL is GIPHY's GIF search endpoint.
+const GiphySearchURL = "https://api.giphy.com/v1/gifs/search"
+
+// Giphy returns the /giphy command, which posts the top GIF GIPHY finds
+// for its text, as a link clients preview. Searches are rated PG-13.
+func Giphy(searchURL, apiKey string) Command {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return Command{
+		Name:        "giphy",
+		Usage:       "/giphy <search terms>",
+		Description: "Post a GIF from GIPHY",
+		Handler: func(ctx context.Context, invocation Invocation) (*Response, error) {
+			if invocation.Args == "" {
+				return &Response{Text: "Usage: /giphy <search terms>", Ephemeral: true}, nil
+			}
+			query := url.Values{
+				"api_key": {apiKey},
+				"q":       {invocation.Args},
+				"limit":   {"1"},
+				"rating":  {"pg-13"},
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, searchURL+"?"+query.Encode(), nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build GIPHY request: %w", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return nil, fmt.Errorf("failed to reach GIPHY: %w", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return nil, fmt.Errorf("GIPHY answered %s", resp.Status)
+			}
+
+			var result struct {
+				Data []struct {
+					Images struct {
+						Original struct {
+							URL string `json:"url"`
+						} `json:"original"`
+					} `json:"images"`
+				} `json:"data"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				return nil, fmt.Errorf("failed to decode GIPHY response: %w", err)
+			}
+			if len(result.Data) == 0 || result.Data[0].Images.Original.URL == "" {
+				return &Response{Text: fmt.Sprintf("No GIFs found for %q", invocation.Args), Ephemeral: true}, nil
+			}
+			return &Response{Text: result.Data[0].Images.Original.URL}, nil
+		},
+	}
+}
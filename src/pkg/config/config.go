@@ -1,34 +1,784 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/dfunani/AfroChat/backend/lib/utils"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/deeplink"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/geoip"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/secretbox"
+	"github.com/dfunani/AfroChat/backend/pkg/strikes"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
 )
 
 // AppConfig holds application configuration
 type ApplicationConfig struct {
-	Port   string
+	Port int
+
+	// DBDriver is postgres or sqlite. With sqlite the database is the
+	// file at DBPath, ":memory:" for one that is lost on exit, and the
+	// Postgres settings below are not needed.
+	DBDriver string
+	DBPath   string
+
 	DBHost string
-	DBPort string
+	DBPort int
 	DBUser string
 	DBPass string
 	DBName string
 	DBSSL  string
-	Env    string
+
+	// DBConnectTimeout is how long startup keeps retrying an unreachable
+	// database. Once running, the database is pinged every
+	// DBHealthInterval and requests are refused while it is down.
+	DBConnectTimeout time.Duration
+	DBHealthInterval time.Duration
+
+	// DBLogLevel is which queries are logged: silent, error, warn (failed
+	// and slow ones, the default in production) or info (every query, at
+	// debug level). Queries over DBSlowQueryThreshold are slow, and each
+	// statement is logged at most DBLogSampleBurst times a minute, or
+	// every time when that is zero.
+	DBLogLevel           string
+	DBSlowQueryThreshold time.Duration
+	DBLogSampleBurst     int
+
+	// MessageRetention is how long messages are kept, by the month they
+	// were sent in, before their month is dropped. Zero keeps them
+	// forever. Only Postgres, where messages are partitioned by month,
+	// drops them.
+	MessageRetention time.Duration
+
+	// MessageArchiveAfter is how old messages get before their content is
+	// moved to compressed segments in attachment storage, or zero to keep
+	// it all in the database.
+	MessageArchiveAfter time.Duration
+
+	Env string
+
+	// Region names the deployment, for the health check and logs to say
+	// which one answered. Where a user's content is kept is set by their
+	// residency instead.
+	Region string
+
+	ShutdownTimeout time.Duration
+
+	// LogFormat is json for log collectors or text for reading in a
+	// terminal. Lines below LogLevel are dropped.
+	LogFormat string
+	LogLevel  string
+
+	// MetricsToken guards the metrics endpoint, which is disabled when it
+	// is empty.
+	MetricsToken string
+
+	// USSDCallbackToken authenticates the USSD aggregator's callbacks,
+	// which it sends to /api/v1/ussd/<token>. USSD is disabled when it is
+	// empty.
+	USSDCallbackToken string
+
+	// InboundEmailDomain is the domain of channels' email addresses, whose
+	// mail the provider posts to /api/v1/email/<InboundEmailToken>.
+	// Channel email is disabled unless both are set.
+	InboundEmailDomain string
+	InboundEmailToken  string
+
+	// BillingUsageURL receives each account's metered usage of every day
+	// once it is over, signed like webhooks with BillingUsageSecret.
+	// Usage is metered but not exported when it is empty.
+	BillingUsageURL    string
+	BillingUsageSecret string
+
+	// Chaos settings inject faults to test client resilience. They are
+	// refused in production.
+	ChaosEnabled       bool
+	ChaosLatencyRate   float64
+	ChaosMaxLatency    time.Duration
+	ChaosFrameDropRate float64
+	ChaosDBErrorRate   float64
+
+	// TestMode captures email and pushes in memory instead of sending them,
+	// for end-to-end tests of the apps. TestModeToken guards the debug
+	// endpoints that list them and reset and seed the database.
+	// TestModeSeed seeds generated IDs, and the IDs of fixtures, so runs
+	// against an environment repeat. Test mode is refused in production.
+	TestMode      bool
+	TestModeToken string
+	TestModeSeed  string
+
+	// LoadShedEnabled turns away a growing share of low-priority requests,
+	// such as search and previews, as the server nears saturation. A timer
+	// firing LoadShedMaxLag late counts as saturated.
+	LoadShedEnabled bool
+	LoadShedMaxLag  time.Duration
+
+	// TraceFile records an anonymized trace of user traffic for the replay
+	// command, from a TraceSampleRate fraction of users. Recording is off
+	// when it is empty.
+	TraceFile       string
+	TraceSampleRate float64
+
+	// TracingEndpoint is the OTLP/HTTP address of the OpenTelemetry
+	// collector spans are sent to, named TracingServiceName. Traces that
+	// start here are recorded at TracingSampleRate; those continued from a
+	// caller follow its decision. Tracing is off when it is empty.
+	TracingEndpoint    string
+	TracingServiceName string
+	TracingSampleRate  float64
+
+	// AnalyticsEnabled samples anonymized usage of an AnalyticsSampleRate
+	// fraction of the users who opt in, summed over AnalyticsInterval and
+	// posted to AnalyticsEndpoint, or logged when it is empty. User IDs are
+	// hashed with AnalyticsHashKey. Off unless enabled, so self-hosted
+	// installs send nothing.
+	AnalyticsEnabled    bool
+	AnalyticsSampleRate float64
+	AnalyticsInterval   time.Duration
+	AnalyticsEndpoint   string
+	AnalyticsHashKey    string
+
+	// Rate limits on the auth and public page routes are per client IP
+	// address, and the message limit is per user on top of their tier's
+	// request limit, as is contact discovery, which would otherwise let
+	// phone numbers be tried wholesale. Logins are also limited per email
+	// address, so guessing one account's password from many addresses is
+	// slowed too. The redis store shares the limits between instances
+	// through REDIS_URL.
+	RateLimitStore         string
+	RateLimitLogin         ratelimit.Policy
+	RateLimitLoginAccount  ratelimit.Policy
+	RateLimitRegister      ratelimit.Policy
+	RateLimitPasswordReset ratelimit.Policy
+	RateLimitMessages      ratelimit.Policy
+	RateLimitShortLinks    ratelimit.Policy
+	RateLimitPublicPages   ratelimit.Policy
+	RateLimitDiscovery     ratelimit.Policy
+
+	// TrustedProxies are the addresses and CIDR ranges of the proxies in
+	// front of the server, whose X-Forwarded-For header gives the client
+	// address. None are trusted by default, so clients cannot dodge the
+	// per-address limits by making up the header.
+	TrustedProxies []string
+
+	// ContentLimits bound each message by the sender's tier. Each tier
+	// starts from its defaults, overridden for every tier by
+	// MESSAGE_LIMITS and then for the tier by MESSAGE_LIMITS_<TIER>.
+	ContentLimits map[entitlements.Tier]entitlements.ContentLimits
+
+	JWTSecret  string
+	JWTTTL     time.Duration
+	RefreshTTL time.Duration
+
+	RegistrationMode string
+	SignupURL        string
+
+	// AppURL is the public address of the web app. Share links of
+	// messages, rooms and profiles are made under it, for the mobile apps
+	// to claim as universal links.
+	AppURL string
+
+	// Cache lifetimes suggested to clients for each kind of resource, in
+	// Cache-Control headers and the cache manifest. Clients asking to save
+	// data are told to keep everything CacheLowBandwidthFactor times
+	// longer.
+	CacheProfileImageTTL    time.Duration
+	CacheEmojiTTL           time.Duration
+	CacheStickerPackTTL     time.Duration
+	CacheAttachmentTTL      time.Duration
+	CacheLowBandwidthFactor int
+
+	// OAuth sign-in. Each provider is offered once its client ID is set.
+	// Providers send users back to callbacks under OAuthCallbackURL, from
+	// where they return to the app at one of OAuthAppRedirectURLs.
+	OAuthCallbackURL     string
+	OAuthAppRedirectURLs []string
+	GoogleClientID       string
+	GoogleClientSecret   string
+	GitHubClientID       string
+	GitHubClientSecret   string
+	AppleClientID        string
+	AppleTeamID          string
+	AppleKeyID           string
+	AppleKeyFile         string
+
+	// ShortLinkBaseURL is the public address of the /l route short links
+	// are served from. Links users create expire after ShortLinkTTL, and
+	// none may lead to ShortLinkBlockedHosts or their subdomains.
+	ShortLinkBaseURL      string
+	ShortLinkTTL          time.Duration
+	ShortLinkBlockedHosts []string
+
+	// RealtimeBus relays realtime events between instances: not at all
+	// when local, over Redis, or over Postgres LISTEN/NOTIFY for
+	// deployments without Redis.
+	RealtimeBus string
+	RedisURL    string
+
+	// ProfileCache keeps the public profiles most lookups ask for in Redis
+	// for ProfileCacheTTL, or not at all when off. Updates invalidate them
+	// sooner.
+	ProfileCache    string
+	ProfileCacheTTL time.Duration
+
+	// SearchBackend finds messages with Postgres full-text search or an
+	// OpenSearch cluster, which a background indexer keeps up to date.
+	SearchBackend      string
+	OpenSearchURL      string
+	OpenSearchIndex    string
+	OpenSearchUsername string
+	OpenSearchPassword string
+
+	StorageBackend  string
+	StorageLocalDir string
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+	S3UseSSL        bool
+
+	// GCS settings name a Google Cloud Storage bucket and the service
+	// account key file used to reach it. GCSEndpoint is for emulators.
+	GCSBucket          string
+	GCSCredentialsFile string
+	GCSEndpoint        string
+
+	// SecretsKey, 32 bytes encoded as base64, encrypts the secrets kept in
+	// the database, such as the keys of the buckets room exports are
+	// delivered to. Exports to buckets are refused without it.
+	SecretsKey string
+
+	// StorageRegionBuckets keeps the attachments, backups and exports of
+	// users resident in a region in a bucket of its own, on the storage
+	// backend, to satisfy laws such as POPIA. Every region must be given
+	// one; without any, everything is kept in the one bucket.
+	StorageRegionBuckets map[residency.Region]string
+
+	Mailer           string
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     string
+	MailFrom         string
+	VerifyEmailURL   string
+	ResetPasswordURL string
+
+	// An email change is confirmed through links to ConfirmEmailChangeURL
+	// sent to both addresses, and undone through one to
+	// RevertEmailChangeURL sent to the old address.
+	ConfirmEmailChangeURL string
+	RevertEmailChangeURL  string
+
+	// Push sends notifications to offline users. The live sender pushes
+	// through FCM for Android, APNs for iOS and Web Push for browsers,
+	// each enabled by its credentials. WebPushVAPIDKey is the base64url
+	// P-256 private key Web Push identifies the server with, and
+	// WebPushSubject the contact URL sent with it.
+	Push                string
+	FCMCredentialsFile  string
+	APNsKeyFile         string
+	APNsKeyID           string
+	APNsTeamID          string
+	APNsTopic           string
+	APNsProduction      bool
+	WebPushVAPIDKey     string
+	WebPushSubject      string
+	NotificationWorkers int
+
+	// PushTokenMaxAge is how long a device token is kept without the app
+	// registering it again.
+	PushTokenMaxAge time.Duration
+
+	// SmartReplies selects the provider suggesting replies to direct
+	// messages for users who opt in, or turns suggestions off.
+	SmartReplies string
+
+	// GeoIP selects the provider guessing new users' country and time zone
+	// from their address, or turns guessing off.
+	GeoIP             string
+	MaxMindAccountID  string
+	MaxMindLicenseKey string
+	MaxMindHost       string
+
+	// JobWorkers is how many background jobs, such as data exports and
+	// account erasures, run at once.
+	JobWorkers int
+
+	// JobAlertWebhookURL, when set, is sent a JSON alert whenever a
+	// background job is given up on, signed with JobAlertWebhookSecret if
+	// that is set.
+	JobAlertWebhookURL    string
+	JobAlertWebhookSecret string
+
+	// RoomCaps cap the members of groups and channels. Users joining a
+	// full public channel wait in line for a place.
+	RoomCaps entitlements.RoomCaps
+
+	// TrustPolicy sets what accounts need, in age and activity, to reach
+	// each trust level, and the level that unlocks posting links, posting
+	// media and creating rooms. Staff and bots are not held to it.
+	TrustPolicy trust.Policy
+
+	// Captcha challenges accounts younger than CaptchaNewAccountAge, and
+	// those who resolved a short link to the channel, when they join
+	// a public channel of at least CaptchaMinRoomSize members whose owners
+	// turned the challenge on. Clients render the widget with
+	// CaptchaSiteKey, and tokens are checked with CaptchaSecret.
+	Captcha              string
+	CaptchaSiteKey       string
+	CaptchaSecret        string
+	CaptchaMinRoomSize   int
+	CaptchaNewAccountAge time.Duration
+
+	// GiphyAPIKey enables the /giphy slash command, which is not offered
+	// without one.
+	GiphyAPIKey string
+
+	// AbuseShadowThreshold is the abuse score, from 0 to 1, at which a
+	// signup or send shadow restricts its account pending review. Zero
+	// turns automatic restriction off.
+	AbuseShadowThreshold float64
+
+	// StrikePolicy sets how long the strikes moderators issue count and
+	// how many suspend an account for how long.
+	StrikePolicy strikes.Policy
+
+	// OnboardingSender is the username of the account the onboarding
+	// sequence is sent to new users from. Empty turns the sequence off.
+	OnboardingSender string
 }
 
-// LoadConfig loads configuration from environment variables
-func LoadApplicationConfig() *ApplicationConfig {
-	fmt.Println("Loading Application config...")
-	return &ApplicationConfig{
-		Port:   utils.GetEnv("PORT"),
-		DBHost: utils.GetEnv("DB_HOST"),
-		DBPort: utils.GetEnv("DB_PORT"),
-		DBUser: utils.GetEnv("DB_USER"),
-		DBPass: utils.GetEnv("DB_PASSWORD"),
-		DBName: utils.GetEnv("DB_NAME"),
-		DBSSL:  utils.GetEnv("DB_SSLMODE"),
-		Env:    utils.GetEnv("ENVIRONMENT"),
+const (
+	EnvLocal      = "local"
+	EnvProduction = "production"
+
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+
+	RegistrationOpen       = "open"
+	RegistrationInviteOnly = "invite_only"
+
+	RealtimeBusLocal    = "local"
+	RealtimeBusRedis    = "redis"
+	RealtimeBusPostgres = "postgres"
+
+	ProfileCacheOff   = "off"
+	ProfileCacheRedis = "redis"
+
+	SearchPostgres   = "postgres"
+	SearchOpenSearch = "opensearch"
+
+	StorageLocal = "local"
+	StorageS3    = "s3"
+	StorageGCS   = "gcs"
+
+	MailerLog  = "log"
+	MailerSMTP = "smtp"
+
+	PushLog  = "log"
+	PushLive = "live"
+
+	SmartRepliesOff    = "off"
+	SmartRepliesCanned = "canned"
+
+	GeoIPOff     = "off"
+	GeoIPMaxMind = "maxmind"
+
+	CaptchaOff       = "off"
+	CaptchaHCaptcha  = "hcaptcha"
+	CaptchaTurnstile = "turnstile"
+
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+// LoadApplicationConfig loads configuration from environment variables.
+// Settings missing from the environment are read from the file named by
+// CONFIG_FILE, or from .env in the working directory when it exists, and
+// otherwise take their defaults. Every invalid or missing setting is
+// reported in the returned error.
+func LoadApplicationConfig() (*ApplicationConfig, error) {
+	src := &source{}
+	path, explicit := os.LookupEnv("CONFIG_FILE")
+	if !explicit {
+		path = ".env"
+	}
+	if path != "" {
+		file, err := readFile(path)
+		switch {
+		case err == nil:
+			slog.Info("Loading application config from the environment and a file", "path", path)
+			src.file = file
+		case !explicit && errors.Is(err, fs.ErrNotExist):
+			slog.Info("Loading application config from the environment")
+		default:
+			return nil, err
+		}
+	}
+
+	appConfig := &ApplicationConfig{
+		Port: src.port("PORT", 8080),
+
+		DBDriver: src.oneOf("DB_DRIVER", database.DriverPostgres, database.DriverPostgres, database.DriverSQLite),
+		DBPath:   src.text("DB_PATH", "afrochat.db"),
+
+		DBHost: src.text("DB_HOST", "localhost"),
+		DBPort: src.port("DB_PORT", 5432),
+		DBSSL:  src.oneOf("DB_SSLMODE", "require", "disable", "allow", "prefer", "require", "verify-ca", "verify-full"),
+
+		DBConnectTimeout: src.duration("DB_CONNECT_TIMEOUT", 30*time.Second),
+		DBHealthInterval: src.duration("DB_HEALTH_INTERVAL", 5*time.Second),
+
+		DBSlowQueryThreshold: src.duration("DB_SLOW_QUERY_THRESHOLD", database.DefaultSlowQueryThreshold),
+		DBLogSampleBurst:     src.integer("DB_LOG_SAMPLE_BURST", 10),
+
+		MessageRetention:    src.duration("MESSAGE_RETENTION", 0),
+		MessageArchiveAfter: src.duration("MESSAGE_ARCHIVE_AFTER", 0),
+
+		Env:    src.text("ENVIRONMENT", EnvLocal),
+		Region: src.text("REGION", "default"),
+
+		ShutdownTimeout: src.duration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		LogFormat: src.oneOf("LOG_FORMAT", LogFormatJSON, LogFormatJSON, LogFormatText),
+		LogLevel:  src.oneOf("LOG_LEVEL", "info", "debug", "info", "warn", "error"),
+
+		MetricsToken: src.text("METRICS_TOKEN", ""),
+
+		USSDCallbackToken:  src.text("USSD_CALLBACK_TOKEN", ""),
+		InboundEmailDomain: src.text("INBOUND_EMAIL_DOMAIN", ""),
+		InboundEmailToken:  src.text("INBOUND_EMAIL_TOKEN", ""),
+		BillingUsageURL:    src.text("BILLING_USAGE_URL", ""),
+		BillingUsageSecret: src.text("BILLING_USAGE_SECRET", ""),
+
+		ChaosEnabled:       src.boolean("CHAOS_ENABLED", false),
+		ChaosLatencyRate:   src.fraction("CHAOS_LATENCY_RATE", 0),
+		ChaosMaxLatency:    src.duration("CHAOS_MAX_LATENCY", 2*time.Second),
+		ChaosFrameDropRate: src.fraction("CHAOS_WS_DROP_RATE", 0),
+		ChaosDBErrorRate:   src.fraction("CHAOS_DB_ERROR_RATE", 0),
+
+		TestMode:     src.boolean("TEST_MODE", false),
+		TestModeSeed: src.text("TEST_MODE_SEED", "afrochat"),
+
+		LoadShedEnabled: src.boolean("LOAD_SHED_ENABLED", true),
+		LoadShedMaxLag:  src.duration("LOAD_SHED_MAX_LAG", 100*time.Millisecond),
+
+		TraceFile:       src.text("TRACE_FILE", ""),
+		TraceSampleRate: src.fraction("TRACE_SAMPLE_RATE", 1),
+
+		TracingEndpoint:    src.text("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		TracingServiceName: src.text("OTEL_SERVICE_NAME", "afrochat-backend"),
+		TracingSampleRate:  src.fraction("OTEL_TRACES_SAMPLER_ARG", 1),
+
+		AnalyticsEnabled:    src.boolean("ANALYTICS_ENABLED", false),
+		AnalyticsSampleRate: src.fraction("ANALYTICS_SAMPLE_RATE", 0.1),
+		AnalyticsInterval:   src.duration("ANALYTICS_INTERVAL", time.Hour),
+		AnalyticsEndpoint:   src.text("ANALYTICS_ENDPOINT", ""),
+
+		RateLimitStore:         src.oneOf("RATE_LIMIT_STORE", RateLimitStoreMemory, RateLimitStoreMemory, RateLimitStoreRedis),
+		RateLimitLogin:         src.rate("RATE_LIMIT_LOGIN", ratelimit.Policy{Limit: 10, Period: time.Minute}),
+		RateLimitLoginAccount:  src.rate("RATE_LIMIT_LOGIN_ACCOUNT", ratelimit.Policy{Limit: 10, Period: 15 * time.Minute}),
+		RateLimitRegister:      src.rate("RATE_LIMIT_REGISTER", ratelimit.Policy{Limit: 5, Period: time.Hour}),
+		RateLimitPasswordReset: src.rate("RATE_LIMIT_PASSWORD_RESET", ratelimit.Policy{Limit: 5, Period: time.Hour}),
+		RateLimitMessages:      src.rate("RATE_LIMIT_MESSAGES", ratelimit.Policy{Limit: 30, Period: 10 * time.Second}),
+		RateLimitShortLinks:    src.rate("RATE_LIMIT_SHORT_LINKS", ratelimit.Policy{Limit: 20, Period: time.Hour}),
+		RateLimitPublicPages:   src.rate("RATE_LIMIT_PUBLIC_PAGES", ratelimit.Policy{Limit: 60, Period: time.Minute}),
+		RateLimitDiscovery:     src.rate("RATE_LIMIT_DISCOVERY", ratelimit.Policy{Limit: 10, Period: time.Hour}),
+
+		TrustedProxies: src.list("TRUSTED_PROXIES"),
+
+		JWTSecret:  src.required("JWT_SECRET"),
+		JWTTTL:     src.duration("JWT_TTL", 24*time.Hour),
+		RefreshTTL: src.duration("REFRESH_TOKEN_TTL", 720*time.Hour),
+
+		RegistrationMode: src.oneOf("REGISTRATION_MODE", RegistrationOpen, RegistrationOpen, RegistrationInviteOnly),
+		SignupURL:        src.text("SIGNUP_URL", "http://localhost:3000/signup"),
+
+		AppURL: src.text("APP_URL", "http://localhost:3000"),
+
+		CacheProfileImageTTL:    src.duration("CACHE_PROFILE_IMAGE_TTL", 24*time.Hour),
+		CacheEmojiTTL:           src.duration("CACHE_EMOJI_TTL", 24*time.Hour),
+		CacheStickerPackTTL:     src.duration("CACHE_STICKER_PACK_TTL", 7*24*time.Hour),
+		CacheAttachmentTTL:      src.duration("CACHE_ATTACHMENT_TTL", time.Hour),
+		CacheLowBandwidthFactor: src.integer("CACHE_LOW_BANDWIDTH_FACTOR", 4),
+
+		OAuthCallbackURL:     src.text("OAUTH_CALLBACK_URL", "http://localhost:8080/api/v1/auth/oauth"),
+		OAuthAppRedirectURLs: src.list("OAUTH_APP_REDIRECT_URLS"),
+		GoogleClientID:       src.text("GOOGLE_CLIENT_ID", ""),
+		GitHubClientID:       src.text("GITHUB_CLIENT_ID", ""),
+		AppleClientID:        src.text("APPLE_CLIENT_ID", ""),
+
+		ShortLinkBaseURL:      src.text("SHORT_LINK_BASE_URL", "http://localhost:8080/l"),
+		ShortLinkTTL:          src.duration("SHORT_LINK_TTL", 720*time.Hour),
+		ShortLinkBlockedHosts: src.list("SHORT_LINK_BLOCKED_HOSTS"),
+
+		RealtimeBus: src.oneOf("REALTIME_BUS", RealtimeBusLocal, RealtimeBusLocal, RealtimeBusRedis, RealtimeBusPostgres),
+		RedisURL:    src.text("REDIS_URL", "redis://localhost:6379/0"),
+
+		ProfileCache:    src.oneOf("PROFILE_CACHE", ProfileCacheOff, ProfileCacheOff, ProfileCacheRedis),
+		ProfileCacheTTL: src.duration("PROFILE_CACHE_TTL", 5*time.Minute),
+
+		SearchBackend:      src.oneOf("SEARCH_BACKEND", SearchPostgres, SearchPostgres, SearchOpenSearch),
+		OpenSearchIndex:    src.text("OPENSEARCH_INDEX", "afrochat-messages"),
+		OpenSearchUsername: src.text("OPENSEARCH_USERNAME", ""),
+		OpenSearchPassword: src.text("OPENSEARCH_PASSWORD", ""),
+
+		StorageBackend:  src.oneOf("STORAGE_BACKEND", StorageLocal, StorageLocal, StorageS3, StorageGCS),
+		StorageLocalDir: src.text("STORAGE_LOCAL_DIR", "./uploads"),
+		S3Endpoint:      src.text("S3_ENDPOINT", "localhost:9000"),
+		S3Region:        src.text("S3_REGION", "us-east-1"),
+		S3Bucket:        src.text("S3_BUCKET", "afrochat-attachments"),
+		S3UseSSL:        src.boolean("S3_USE_SSL", false),
+		GCSEndpoint:     src.text("GCS_ENDPOINT", ""),
+
+		StorageRegionBuckets: src.residencyBuckets("STORAGE_REGION_BUCKETS"),
+		SecretsKey:           src.text("SECRETS_KEY", ""),
+
+		Mailer:           src.oneOf("MAILER", MailerLog, MailerLog, MailerSMTP),
+		SMTPHost:         src.text("SMTP_HOST", "localhost"),
+		SMTPPort:         src.port("SMTP_PORT", 587),
+		SMTPUsername:     src.text("SMTP_USERNAME", ""),
+		SMTPPassword:     src.text("SMTP_PASSWORD", ""),
+		MailFrom:         src.text("MAIL_FROM", "AfroChat <no-reply@afrochat.local>"),
+		VerifyEmailURL:   src.text("VERIFY_EMAIL_URL", "http://localhost:3000/verify-email"),
+		ResetPasswordURL: src.text("RESET_PASSWORD_URL", "http://localhost:3000/reset-password"),
+
+		ConfirmEmailChangeURL: src.text("CONFIRM_EMAIL_CHANGE_URL", "http://localhost:3000/confirm-email-change"),
+		RevertEmailChangeURL:  src.text("REVERT_EMAIL_CHANGE_URL", "http://localhost:3000/revert-email-change"),
+
+		Push:                src.oneOf("PUSH", PushLog, PushLog, PushLive),
+		FCMCredentialsFile:  src.text("FCM_CREDENTIALS_FILE", ""),
+		APNsKeyFile:         src.text("APNS_KEY_FILE", ""),
+		APNsProduction:      src.boolean("APNS_PRODUCTION", false),
+		WebPushVAPIDKey:     src.text("WEB_PUSH_VAPID_KEY", ""),
+		NotificationWorkers: src.integer("NOTIFICATION_WORKERS", 4),
+		PushTokenMaxAge:     src.duration("PUSH_TOKEN_MAX_AGE", 60*24*time.Hour),
+
+		SmartReplies: src.oneOf("SMART_REPLIES", SmartRepliesOff, SmartRepliesOff, SmartRepliesCanned),
+
+		GeoIP:       src.oneOf("GEOIP", GeoIPOff, GeoIPOff, GeoIPMaxMind),
+		MaxMindHost: src.text("MAXMIND_HOST", geoip.DefaultMaxMindHost),
+
+		JobWorkers:            src.integer("JOB_WORKERS", 2),
+		JobAlertWebhookURL:    src.text("JOB_ALERT_WEBHOOK_URL", ""),
+		JobAlertWebhookSecret: src.text("JOB_ALERT_WEBHOOK_SECRET", ""),
+
+		RoomCaps: entitlements.RoomCaps{
+			Group:          src.integer("ROOM_CAP_GROUP", 0),
+			PublicChannel:  src.integer("ROOM_CAP_PUBLIC_CHANNEL", 0),
+			PrivateChannel: src.integer("ROOM_CAP_PRIVATE_CHANNEL", 0),
+		},
+
+		Captcha:              src.oneOf("CAPTCHA", CaptchaOff, CaptchaOff, CaptchaHCaptcha, CaptchaTurnstile),
+		CaptchaMinRoomSize:   src.integer("CAPTCHA_MIN_ROOM_SIZE", 500),
+		CaptchaNewAccountAge: src.duration("CAPTCHA_NEW_ACCOUNT_AGE", 72*time.Hour),
+
+		GiphyAPIKey: src.text("GIPHY_API_KEY", ""),
+
+		AbuseShadowThreshold: src.fraction("ABUSE_SHADOW_THRESHOLD", 0.8),
+	}
+
+	defaultTrust := trust.DefaultPolicy()
+	appConfig.TrustPolicy = trust.Policy{
+		Levels: map[trust.Level]trust.Requirement{
+			trust.LevelBasic:   src.trustRequirement("TRUST_LEVEL_BASIC", defaultTrust.Levels[trust.LevelBasic]),
+			trust.LevelMember:  src.trustRequirement("TRUST_LEVEL_MEMBER", defaultTrust.Levels[trust.LevelMember]),
+			trust.LevelTrusted: src.trustRequirement("TRUST_LEVEL_TRUSTED", defaultTrust.Levels[trust.LevelTrusted]),
+		},
+		Capabilities: map[trust.Capability]trust.Level{
+			trust.CapabilityPostLinks:  src.trustLevel("TRUST_POST_LINKS", defaultTrust.Capabilities[trust.CapabilityPostLinks]),
+			trust.CapabilityPostMedia:  src.trustLevel("TRUST_POST_MEDIA", defaultTrust.Capabilities[trust.CapabilityPostMedia]),
+			trust.CapabilityCreateRoom: src.trustLevel("TRUST_CREATE_ROOM", defaultTrust.Capabilities[trust.CapabilityCreateRoom]),
+		},
+		PenaltyPerReport: defaultTrust.PenaltyPerReport,
+	}
+
+	defaultStrikes := strikes.DefaultPolicy()
+	appConfig.StrikePolicy = strikes.Policy{
+		TTL:   src.duration("STRIKE_TTL", defaultStrikes.TTL),
+		Steps: src.strikeSteps("STRIKE_ESCALATION", defaultStrikes.Steps),
+	}
+	appConfig.OnboardingSender = src.text("ONBOARDING_SENDER", "")
+
+	shared := src.text("MESSAGE_LIMITS", "")
+	if _, err := entitlements.ParseContentLimits(shared, entitlements.ContentLimits{}); err != nil {
+		src.fail("MESSAGE_LIMITS", "is invalid: %v", err)
+		shared = ""
+	}
+	appConfig.ContentLimits = make(map[entitlements.Tier]entitlements.ContentLimits)
+	for tier, defaults := range entitlements.DefaultContentLimits {
+		limits, _ := entitlements.ParseContentLimits(shared, defaults)
+		appConfig.ContentLimits[tier] = src.contentLimits("MESSAGE_LIMITS_"+strings.ToUpper(string(tier)), limits)
+	}
+
+	// Production logs only the queries worth looking at unless told
+	// otherwise.
+	dbLogLevel := database.QueryLogInfo
+	if appConfig.Env == EnvProduction {
+		dbLogLevel = database.QueryLogWarn
+	}
+	appConfig.DBLogLevel = src.oneOf("DB_LOG_LEVEL", dbLogLevel,
+		database.QueryLogSilent, database.QueryLogError, database.QueryLogWarn, database.QueryLogInfo)
+
+	// Settings only some backends need are required only with them.
+	if appConfig.DBDriver == database.DriverPostgres {
+		appConfig.DBUser = src.required("DB_USER")
+		appConfig.DBPass = src.required("DB_PASSWORD")
+		appConfig.DBName = src.required("DB_NAME")
+	}
+	if appConfig.StorageBackend == StorageS3 {
+		appConfig.S3AccessKey = src.required("S3_ACCESS_KEY")
+		appConfig.S3SecretKey = src.required("S3_SECRET_KEY")
+	}
+	if appConfig.SearchBackend == SearchOpenSearch {
+		appConfig.OpenSearchURL = src.required("OPENSEARCH_URL")
+	}
+	if appConfig.StorageBackend == StorageGCS {
+		appConfig.GCSBucket = src.required("GCS_BUCKET")
+		appConfig.GCSCredentialsFile = src.required("GCS_CREDENTIALS_FILE")
+	}
+	if appConfig.AnalyticsEnabled {
+		appConfig.AnalyticsHashKey = src.required("ANALYTICS_HASH_KEY")
+	}
+	if appConfig.GoogleClientID != "" {
+		appConfig.GoogleClientSecret = src.required("GOOGLE_CLIENT_SECRET")
+	}
+	if appConfig.GitHubClientID != "" {
+		appConfig.GitHubClientSecret = src.required("GITHUB_CLIENT_SECRET")
+	}
+	if appConfig.AppleClientID != "" {
+		appConfig.AppleTeamID = src.required("APPLE_TEAM_ID")
+		appConfig.AppleKeyID = src.required("APPLE_KEY_ID")
+		appConfig.AppleKeyFile = src.required("APPLE_KEY_FILE")
+	}
+	if appConfig.APNsKeyFile != "" {
+		appConfig.APNsKeyID = src.required("APNS_KEY_ID")
+		appConfig.APNsTeamID = src.required("APNS_TEAM_ID")
+		appConfig.APNsTopic = src.required("APNS_TOPIC")
+	}
+	if appConfig.WebPushVAPIDKey != "" {
+		appConfig.WebPushSubject = src.required("WEB_PUSH_SUBJECT")
+	}
+	if appConfig.Captcha != CaptchaOff {
+		appConfig.CaptchaSiteKey = src.required("CAPTCHA_SITE_KEY")
+		appConfig.CaptchaSecret = src.required("CAPTCHA_SECRET")
+	}
+	if appConfig.GeoIP == GeoIPMaxMind {
+		appConfig.MaxMindAccountID = src.required("MAXMIND_ACCOUNT_ID")
+		appConfig.MaxMindLicenseKey = src.required("MAXMIND_LICENSE_KEY")
+	}
+	if appConfig.TestMode {
+		appConfig.TestModeToken = src.required("TEST_MODE_TOKEN")
+	}
+	if appConfig.RealtimeBus == RealtimeBusPostgres && appConfig.DBDriver != database.DriverPostgres {
+		src.fail("REALTIME_BUS", "can only be postgres with DB_DRIVER=postgres")
+	}
+	if appConfig.NotificationWorkers < 1 {
+		src.fail("NOTIFICATION_WORKERS", "must be at least 1")
+	}
+	if appConfig.CacheLowBandwidthFactor < 1 || appConfig.CacheLowBandwidthFactor > 30 {
+		src.fail("CACHE_LOW_BANDWIDTH_FACTOR", "must be between 1 and 30")
+	}
+	if _, err := deeplink.NewBase(appConfig.AppURL); err != nil {
+		src.fail("APP_URL", "must be an http or https URL")
+	}
+	if appConfig.DBLogSampleBurst < 0 {
+		src.fail("DB_LOG_SAMPLE_BURST", "must not be negative")
+	}
+	if appConfig.JobWorkers < 1 {
+		src.fail("JOB_WORKERS", "must be at least 1")
+	}
+	if appConfig.AnalyticsEnabled && appConfig.AnalyticsInterval < time.Minute {
+		src.fail("ANALYTICS_INTERVAL", "must be at least a minute")
+	}
+	if appConfig.SecretsKey != "" {
+		if _, err := secretbox.New(appConfig.SecretsKey); err != nil {
+			src.fail("SECRETS_KEY", fmt.Sprintf("must be %d bytes encoded as base64", secretbox.KeySize))
+		}
+	}
+	if appConfig.AnalyticsHashKey != "" && len(appConfig.AnalyticsHashKey) < 32 {
+		src.fail("ANALYTICS_HASH_KEY", "must be at least 32 characters")
+	}
+	if appConfig.AnalyticsEndpoint != "" {
+		if u, err := url.Parse(appConfig.AnalyticsEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			src.fail("ANALYTICS_ENDPOINT", "must be an http or https URL")
+		}
+	}
+	if appConfig.BillingUsageURL != "" {
+		if u, err := url.Parse(appConfig.BillingUsageURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			src.fail("BILLING_USAGE_URL", "must be an http or https URL")
+		}
+		if appConfig.BillingUsageSecret == "" {
+			src.fail("BILLING_USAGE_SECRET", "must be set with BILLING_USAGE_URL")
+		}
+	}
+	if appConfig.JobAlertWebhookURL != "" {
+		if u, err := url.Parse(appConfig.JobAlertWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			src.fail("JOB_ALERT_WEBHOOK_URL", "must be an http or https URL")
+		}
+	}
+	if appConfig.RoomCaps.Group < 0 {
+		src.fail("ROOM_CAP_GROUP", "must not be negative")
+	}
+	if appConfig.RoomCaps.PublicChannel < 0 {
+		src.fail("ROOM_CAP_PUBLIC_CHANNEL", "must not be negative")
+	}
+	if appConfig.RoomCaps.PrivateChannel < 0 {
+		src.fail("ROOM_CAP_PRIVATE_CHANNEL", "must not be negative")
+	}
+	if appConfig.CaptchaMinRoomSize < 0 {
+		src.fail("CAPTCHA_MIN_ROOM_SIZE", "must not be negative")
+	}
+	if len(appConfig.StorageRegionBuckets) > 0 {
+		for _, region := range residency.Regions {
+			if _, ok := appConfig.StorageRegionBuckets[region]; !ok {
+				src.fail("STORAGE_REGION_BUCKETS", "must name a bucket for region %s", region)
+			}
+		}
+	}
+	if appConfig.StrikePolicy.TTL < time.Hour {
+		src.fail("STRIKE_TTL", "must be at least an hour")
+	}
+	if appConfig.MessageRetention != 0 && appConfig.MessageRetention < 31*24*time.Hour {
+		src.fail("MESSAGE_RETENTION", "must be 0 or at least 31 days, as messages are dropped a month at a time")
+	}
+	if appConfig.MessageArchiveAfter != 0 && appConfig.MessageArchiveAfter < 7*24*time.Hour {
+		src.fail("MESSAGE_ARCHIVE_AFTER", "must be 0 or at least 7 days")
+	}
+	if appConfig.PushTokenMaxAge < 24*time.Hour {
+		src.fail("PUSH_TOKEN_MAX_AGE", "must be at least a day")
+	}
+	if appConfig.Push == PushLive && appConfig.FCMCredentialsFile == "" && appConfig.APNsKeyFile == "" && appConfig.WebPushVAPIDKey == "" {
+		src.fail("PUSH", "needs FCM_CREDENTIALS_FILE, APNS_KEY_FILE or WEB_PUSH_VAPID_KEY to be live")
+	}
+	if appConfig.SMTPUsername != "" && appConfig.SMTPPassword == "" {
+		src.fail("SMTP_PASSWORD", "is required when SMTP_USERNAME is set")
+	}
+	for _, proxy := range appConfig.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			src.fail("TRUSTED_PROXIES", "%q is not an IP address or CIDR range", proxy)
+		}
+	}
+	if appConfig.ChaosEnabled && appConfig.Env == EnvProduction {
+		src.fail("CHAOS_ENABLED", "must not be set in production")
+	}
+	if appConfig.TestMode && appConfig.Env == EnvProduction {
+		src.fail("TEST_MODE", "must not be set in production")
+	}
+
+	if err := src.err(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
+	return appConfig, nil
 }
@@ -0,0 +1,289 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/strikes"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/goccy/go-yaml"
+)
+
+// source reads settings from the environment, falling back to a config
+// file. It collects every problem instead of stopping at the first, so a
+// misconfigured deployment is told about all of them at once. Values are
+// never logged, since many settings are credentials.
+type source struct {
+	file map[string]string
+	errs []error
+}
+
+func (s *source) lookup(key string) (string, bool) {
+	if value := os.Getenv(key); value != "" {
+		return value, true
+	}
+	value, ok := s.file[key]
+	return value, ok && value != ""
+}
+
+func (s *source) fail(key string, format string, args ...any) {
+	s.errs = append(s.errs, fmt.Errorf("%s "+format, append([]any{key}, args...)...))
+}
+
+func (s *source) text(key, fallback string) string {
+	if value, ok := s.lookup(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// list reads a comma-separated list, dropping blank items.
+func (s *source) list(key string) []string {
+	value, ok := s.lookup(key)
+	if !ok {
+		return nil
+	}
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s *source) required(key string) string {
+	value, ok := s.lookup(key)
+	if !ok {
+		s.fail(key, "is required")
+	}
+	return value
+}
+
+func (s *source) integer(key string, fallback int) int {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		s.fail(key, "must be a whole number")
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) port(key string, fallback int) int {
+	port := s.integer(key, fallback)
+	if port < 1 || port > 65535 {
+		s.fail(key, "must be a port number between 1 and 65535")
+		return fallback
+	}
+	return port
+}
+
+func (s *source) duration(key string, fallback time.Duration) time.Duration {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		s.fail(key, "must be a positive duration such as 30m or 24h")
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) boolean(key string, fallback bool) bool {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		s.fail(key, "must be true or false")
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) fraction(key string, fallback float64) float64 {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil || parsed < 0 || parsed > 1 {
+		s.fail(key, "must be a number between 0 and 1")
+		return fallback
+	}
+	return parsed
+}
+
+func (s *source) rate(key string, fallback ratelimit.Policy) ratelimit.Policy {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	parsed, err := ratelimit.ParsePolicy(value)
+	if err != nil {
+		s.fail(key, "must be a rate such as 10/1m")
+		return fallback
+	}
+	return parsed
+}
+
+// contentLimits reads overrides of base in the form ParseContentLimits
+// takes.
+func (s *source) contentLimits(key string, base entitlements.ContentLimits) entitlements.ContentLimits {
+	value, ok := s.lookup(key)
+	if !ok {
+		return base
+	}
+	parsed, err := entitlements.ParseContentLimits(value, base)
+	if err != nil {
+		s.fail(key, "is invalid: %v", err)
+		return base
+	}
+	return parsed
+}
+
+// trustRequirement reads overrides of base in the form ParseRequirement
+// takes.
+func (s *source) trustRequirement(key string, base trust.Requirement) trust.Requirement {
+	value, ok := s.lookup(key)
+	if !ok {
+		return base
+	}
+	parsed, err := trust.ParseRequirement(value, base)
+	if err != nil {
+		s.fail(key, "is invalid: %v", err)
+		return base
+	}
+	return parsed
+}
+
+// strikeSteps reads escalation steps in the form strikes.ParseSteps takes.
+func (s *source) strikeSteps(key string, fallback []strikes.Step) []strikes.Step {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	steps, err := strikes.ParseSteps(value)
+	if err != nil {
+		s.fail(key, "is invalid: %v", err)
+		return fallback
+	}
+	return steps
+}
+
+// trustLevel reads the name of a trust level.
+func (s *source) trustLevel(key string, fallback trust.Level) trust.Level {
+	value, ok := s.lookup(key)
+	if !ok {
+		return fallback
+	}
+	level, err := trust.ParseLevel(value)
+	if err != nil {
+		s.fail(key, "must be one of new, basic, member, trusted")
+		return fallback
+	}
+	return level
+}
+
+func (s *source) residencyBuckets(key string) map[residency.Region]string {
+	value, ok := s.lookup(key)
+	if !ok {
+		return nil
+	}
+	buckets, err := residency.ParseBuckets(value)
+	if err != nil {
+		s.fail(key, "%v", err)
+		return nil
+	}
+	return buckets
+}
+
+func (s *source) oneOf(key, fallback string, allowed ...string) string {
+	value := s.text(key, fallback)
+	for _, a := range allowed {
+		if value == a {
+			return value
+		}
+	}
+	s.fail(key, "must be one of %s", strings.Join(allowed, ", "))
+	return fallback
+}
+
+func (s *source) err() error {
+	return errors.Join(s.errs...)
+}
+
+// readFile reads settings from a YAML file (.yaml or .yml) or a dotenv
+// file (anything else). Both use the environment variable names as keys.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return parseYAML(data)
+	}
+	return parseDotenv(data)
+}
+
+func parseYAML(data []byte) (map[string]string, error) {
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[string]any, []any:
+			return nil, fmt.Errorf("config file setting %s must be a single value", key)
+		case nil:
+			continue
+		}
+		values[strings.ToUpper(key)] = fmt.Sprint(value)
+	}
+	return values, nil
+}
+
+// parseDotenv reads KEY=value lines, optionally prefixed with export and
+// with the value in single or double quotes, like example.env.
+func parseDotenv(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("config file line %d is not KEY=value", n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
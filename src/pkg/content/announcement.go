@@ -0,0 +1,28 @@
+package content
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Announcement is posted by room admins. When RequireAck is set, clients show
+// an acknowledge button and the server fills in AckCount for admins.
+type Announcement struct {
+	Title      string `json:"title"`
+	Body       string `json:"body"`
+	RequireAck bool   `json:"require_ack"`
+	AckCount   int    `json:"ack_count,omitempty"`
+}
+
+func (a *Announcement) Validate() error {
+	a.Title = strings.TrimSpace(a.Title)
+	a.Body = strings.TrimSpace(a.Body)
+	if a.Title == "" || len(a.Title) > 200 {
+		return fmt.Errorf("%w: title must be 1-200 characters", ErrInvalidContent)
+	}
+	if a.Body == "" || len(a.Body) > 8000 {
+		return fmt.Errorf("%w: body must be 1-8000 characters", ErrInvalidContent)
+	}
+	a.AckCount = 0
+	return nil
+}
@@ -0,0 +1,127 @@
+package content
+
+import (
+	"fmt"
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// AttachmentKind groups uploaded files by how clients render them.
+type AttachmentKind string
+
+const (
+	AttachmentImage    AttachmentKind = "image"
+	AttachmentAudio    AttachmentKind = "audio"
+	AttachmentVideo    AttachmentKind = "video"
+	AttachmentDocument AttachmentKind = "document"
+)
+
+const (
+	MaxImageBytes int64 = 20 * 1024 * 1024
+	MaxAudioBytes int64 = 50 * 1024 * 1024
+	MaxVideoBytes int64 = 200 * 1024 * 1024
+)
+
+// attachmentTypes is the allowlist of uploadable MIME types.
+var attachmentTypes = map[string]AttachmentKind{
+	"image/jpeg": AttachmentImage,
+	"image/png":  AttachmentImage,
+	"image/gif":  AttachmentImage,
+	"image/webp": AttachmentImage,
+	"image/heic": AttachmentImage,
+
+	"audio/mpeg": AttachmentAudio,
+	"audio/mp4":  AttachmentAudio,
+	"audio/aac":  AttachmentAudio,
+	"audio/ogg":  AttachmentAudio,
+	"audio/wav":  AttachmentAudio,
+	"audio/webm": AttachmentAudio,
+
+	"video/mp4":       AttachmentVideo,
+	"video/webm":      AttachmentVideo,
+	"video/quicktime": AttachmentVideo,
+
+	"application/pdf":    AttachmentDocument,
+	"application/zip":    AttachmentDocument,
+	"application/msword": AttachmentDocument,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": AttachmentDocument,
+	"application/vnd.ms-excel": AttachmentDocument,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         AttachmentDocument,
+	"application/vnd.ms-powerpoint":                                             AttachmentDocument,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": AttachmentDocument,
+	"text/plain": AttachmentDocument,
+	"text/csv":   AttachmentDocument,
+}
+
+// MaxAttachmentBytes is the largest upload allowed for a kind.
+func MaxAttachmentBytes(kind AttachmentKind) int64 {
+	switch kind {
+	case AttachmentImage:
+		return MaxImageBytes
+	case AttachmentAudio:
+		return MaxAudioBytes
+	case AttachmentVideo:
+		return MaxVideoBytes
+	}
+	return MaxDocumentBytes
+}
+
+// Upload is a file a client wants to attach, as declared by the client.
+type Upload struct {
+	FileName  string
+	MimeType  string
+	SizeBytes int64
+}
+
+// Validate normalizes the declared name and type and checks them against
+// the allowlist and size limits, returning the attachment kind.
+func (u *Upload) Validate() (AttachmentKind, error) {
+	name := strings.TrimSpace(u.FileName)
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return "", fmt.Errorf("%w: invalid file_name", ErrInvalidContent)
+	}
+	u.FileName = name
+
+	mediaType, _, err := mime.ParseMediaType(u.MimeType)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid mime_type", ErrInvalidContent)
+	}
+	kind, ok := attachmentTypes[mediaType]
+	if !ok {
+		return "", fmt.Errorf("%w: files of type %s cannot be uploaded", ErrInvalidContent, mediaType)
+	}
+	u.MimeType = mediaType
+
+	if limit := MaxAttachmentBytes(kind); u.SizeBytes <= 0 || u.SizeBytes > limit {
+		return "", fmt.Errorf("%w: %s uploads must be between 1 and %d bytes", ErrInvalidContent, kind, limit)
+	}
+	return kind, nil
+}
+
+// CheckSniffed compares the declared type with the type detected from the
+// file's first bytes (as by http.DetectContentType). Media must really be
+// media of the declared kind, and nothing may turn out to be HTML, which a
+// browser could render from a download link.
+func (u *Upload) CheckSniffed(sniffed string) error {
+	mediaType, _, _ := mime.ParseMediaType(sniffed)
+	if mediaType == "text/html" || mediaType == "text/xml" {
+		return fmt.Errorf("%w: file content does not match %s", ErrInvalidContent, u.MimeType)
+	}
+
+	var matches bool
+	switch attachmentTypes[u.MimeType] {
+	case AttachmentImage:
+		matches = strings.HasPrefix(mediaType, "image/")
+	case AttachmentAudio, AttachmentVideo:
+		// Containers such as MP4, WebM and Ogg hold either.
+		matches = strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") || mediaType == "application/ogg"
+	default:
+		// Sniffing cannot tell most document formats apart.
+		matches = true
+	}
+	if !matches && mediaType != "application/octet-stream" {
+		return fmt.Errorf("%w: file content does not match %s", ErrInvalidContent, u.MimeType)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package content
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MaxCodeBytes     = 64 * 1024
+	CodePreviewLines = 15
+	maxLanguageLen   = 32
+)
+
+var languageAliases = map[string]string{
+	"bash":        "shell",
+	"c++":         "cpp",
+	"cs":          "csharp",
+	"c#":          "csharp",
+	"golang":      "go",
+	"js":          "javascript",
+	"kt":          "kotlin",
+	"md":          "markdown",
+	"py":          "python",
+	"rb":          "ruby",
+	"rs":          "rust",
+	"sh":          "shell",
+	"text":        "plaintext",
+	"ts":          "typescript",
+	"txt":         "plaintext",
+	"yml":         "yaml",
+	"zsh":         "shell",
+	"objc":        "objectivec",
+	"objective-c": "objectivec",
+}
+
+var knownLanguages = map[string]bool{
+	"c": true, "cpp": true, "csharp": true, "css": true, "dart": true, "dockerfile": true,
+	"go": true, "html": true, "java": true, "javascript": true, "json": true, "kotlin": true,
+	"markdown": true, "objectivec": true, "php": true, "plaintext": true, "python": true,
+	"ruby": true, "rust": true, "scala": true, "shell": true, "sql": true, "swift": true,
+	"typescript": true, "xml": true, "yaml": true,
+}
+
+// CodeBlock is the payload of a code snippet message. Clients highlight Code
+// using Language and show Preview until the reader expands a truncated block.
+type CodeBlock struct {
+	Language  string `json:"language"`
+	Code      string `json:"code"`
+	LineCount int    `json:"line_count"`
+	Preview   string `json:"preview"`
+	Truncated bool   `json:"truncated"`
+}
+
+// NewCodeBlock validates a snippet and fills in the server-computed fields.
+// Unknown languages fall back to plaintext rather than being rejected.
+func NewCodeBlock(language, code string) (*CodeBlock, error) {
+	code = strings.ReplaceAll(code, "\r\n", "\n")
+	if strings.TrimSpace(code) == "" {
+		return nil, fmt.Errorf("%w: code must not be empty", ErrInvalidContent)
+	}
+	if len(code) > MaxCodeBytes {
+		return nil, fmt.Errorf("%w: code exceeds %d bytes", ErrInvalidContent, MaxCodeBytes)
+	}
+	if !utf8.ValidString(code) {
+		return nil, fmt.Errorf("%w: code must be valid UTF-8", ErrInvalidContent)
+	}
+
+	lines := strings.Split(strings.TrimRight(code, "\n"), "\n")
+	block := &CodeBlock{
+		Language:  NormalizeLanguage(language),
+		Code:      code,
+		LineCount: len(lines),
+		Preview:   code,
+	}
+	if len(lines) > CodePreviewLines {
+		block.Preview = strings.Join(lines[:CodePreviewLines], "\n")
+		block.Truncated = true
+	}
+	return block, nil
+}
+
+// NormalizeLanguage maps a declared language to its canonical name.
+func NormalizeLanguage(language string) string {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if len(language) > maxLanguageLen {
+		return "plaintext"
+	}
+	if alias, ok := languageAliases[language]; ok {
+		language = alias
+	}
+	if !knownLanguages[language] {
+		return "plaintext"
+	}
+	return language
+}
@@ -0,0 +1,73 @@
+package content
+
+import (
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const maxContactEntries = 10
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()-]{7,20}$`)
+
+type ContactPhone struct {
+	Label  string `json:"label"`
+	Number string `json:"number"`
+}
+
+type ContactEmail struct {
+	Label   string `json:"label"`
+	Address string `json:"address"`
+}
+
+// ContactCard is a vCard-style shared contact. UserID is set when the contact
+// is an AfroChat user so clients can offer to open a DM.
+type ContactCard struct {
+	DisplayName  string         `json:"display_name"`
+	Organization string         `json:"organization,omitempty"`
+	Phones       []ContactPhone `json:"phones,omitempty"`
+	Emails       []ContactEmail `json:"emails,omitempty"`
+	UserID       *uuid.UUID     `json:"user_id,omitempty"`
+}
+
+func (c *ContactCard) Validate() error {
+	c.DisplayName = strings.TrimSpace(c.DisplayName)
+	if c.DisplayName == "" || len(c.DisplayName) > 100 {
+		return fmt.Errorf("%w: display_name must be 1-100 characters", ErrInvalidContent)
+	}
+	if len(c.Organization) > 100 {
+		return fmt.Errorf("%w: organization must be at most 100 characters", ErrInvalidContent)
+	}
+	if len(c.Phones) == 0 && len(c.Emails) == 0 && c.UserID == nil {
+		return fmt.Errorf("%w: contact needs a phone, email or user", ErrInvalidContent)
+	}
+	if len(c.Phones) > maxContactEntries || len(c.Emails) > maxContactEntries {
+		return fmt.Errorf("%w: at most %d phones and emails", ErrInvalidContent, maxContactEntries)
+	}
+	for i, phone := range c.Phones {
+		if !phonePattern.MatchString(phone.Number) {
+			return fmt.Errorf("%w: invalid phone number %q", ErrInvalidContent, phone.Number)
+		}
+		c.Phones[i].Label = truncateLabel(phone.Label)
+	}
+	for i, email := range c.Emails {
+		address, err := mail.ParseAddress(email.Address)
+		if err != nil {
+			return fmt.Errorf("%w: invalid email %q", ErrInvalidContent, email.Address)
+		}
+		c.Emails[i].Address = address.Address
+		c.Emails[i].Label = truncateLabel(email.Label)
+	}
+	return nil
+}
+
+func truncateLabel(label string) string {
+	label = strings.TrimSpace(label)
+	if runes := []rune(label); len(runes) > 30 {
+		return string(runes[:30])
+	}
+	return label
+}
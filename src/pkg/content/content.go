@@ -0,0 +1,102 @@
+package content
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Type identifies the payload carried by a message.
+type Type string
+
+const (
+	TypeText         Type = "text"
+	TypeCode         Type = "code"
+	TypeContact      Type = "contact"
+	TypeDocument     Type = "document"
+	TypeEvent        Type = "event"
+	TypeAnnouncement Type = "announcement"
+	TypeEncrypted    Type = "encrypted"
+
+	// TypeSystem messages are posted by the server to explain a change to
+	// their room. Clients cannot send them, so Decode rejects the type.
+	TypeSystem Type = "system"
+)
+
+// ErrInvalidContent is wrapped by every payload validation failure so callers
+// can map it to a 400 response.
+var ErrInvalidContent = errors.New("invalid message content")
+
+// LimitError is content over one of the sender's limits. It wraps
+// ErrInvalidContent.
+type LimitError struct {
+	// Field is the part of the message over its limit, named as in
+	// requests.
+	Field string
+	Limit int
+
+	// Unit is what Limit counts, such as "characters".
+	Unit string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s: %s may have at most %d %s", ErrInvalidContent, e.Field, e.Limit, e.Unit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrInvalidContent
+}
+
+// Decode parses and validates a raw client payload for the given type and
+// returns the typed value that should be stored and sent to clients.
+func Decode(contentType Type, raw json.RawMessage) (any, error) {
+	switch contentType {
+	case TypeCode:
+		var input struct {
+			Language string `json:"language"`
+			Code     string `json:"code"`
+		}
+		if err := unmarshal(raw, &input); err != nil {
+			return nil, err
+		}
+		return NewCodeBlock(input.Language, input.Code)
+	case TypeContact:
+		var card ContactCard
+		if err := unmarshal(raw, &card); err != nil {
+			return nil, err
+		}
+		return &card, card.Validate()
+	case TypeDocument:
+		var document Document
+		if err := unmarshal(raw, &document); err != nil {
+			return nil, err
+		}
+		return &document, document.Validate()
+	case TypeEvent:
+		var event Event
+		if err := unmarshal(raw, &event); err != nil {
+			return nil, err
+		}
+		return &event, event.Validate()
+	case TypeAnnouncement:
+		var announcement Announcement
+		if err := unmarshal(raw, &announcement); err != nil {
+			return nil, err
+		}
+		return &announcement, announcement.Validate()
+	case TypeEncrypted:
+		var encrypted Encrypted
+		if err := unmarshal(raw, &encrypted); err != nil {
+			return nil, err
+		}
+		return &encrypted, encrypted.Validate()
+	}
+	return nil, fmt.Errorf("%w: unsupported type %q", ErrInvalidContent, contentType)
+}
+
+func unmarshal(raw json.RawMessage, target any) error {
+	if err := json.Unmarshal(raw, target); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidContent, err)
+	}
+	return nil
+}
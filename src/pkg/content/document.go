@@ -0,0 +1,44 @@
+package content
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const MaxDocumentBytes int64 = 100 * 1024 * 1024
+
+// Document describes a shared file. The bytes live in object storage; the
+// message only carries the metadata clients need to render and fetch it.
+type Document struct {
+	FileName  string `json:"file_name"`
+	SizeBytes int64  `json:"size_bytes"`
+	MimeType  string `json:"mime_type"`
+	URL       string `json:"url"`
+}
+
+func (d *Document) Validate() error {
+	name := strings.TrimSpace(d.FileName)
+	if name == "" || len(name) > 255 || strings.ContainsAny(name, `/\`) || name != filepath.Base(name) {
+		return fmt.Errorf("%w: invalid file_name", ErrInvalidContent)
+	}
+	d.FileName = name
+
+	if d.SizeBytes <= 0 || d.SizeBytes > MaxDocumentBytes {
+		return fmt.Errorf("%w: size_bytes must be between 1 and %d", ErrInvalidContent, MaxDocumentBytes)
+	}
+
+	mediaType, _, err := mime.ParseMediaType(d.MimeType)
+	if err != nil {
+		return fmt.Errorf("%w: invalid mime_type", ErrInvalidContent)
+	}
+	d.MimeType = mediaType
+
+	link, err := url.Parse(d.URL)
+	if err != nil || link.Scheme != "https" || link.Host == "" {
+		return fmt.Errorf("%w: url must be an https link", ErrInvalidContent)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package content
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxEncryptedRecipients = 100
+	maxCiphertextBytes     = 64 * 1024
+	maxAlgorithmLen        = 100
+	maxDeviceIDLen         = 64
+)
+
+// DeviceCiphertext is a message encrypted for one recipient device.
+type DeviceCiphertext struct {
+	UserID   uuid.UUID `json:"user_id"`
+	DeviceID string    `json:"device_id"`
+
+	// PreKey marks the first message of a session, which the recipient
+	// decrypts with the prekeys it was started from.
+	PreKey bool `json:"prekey"`
+
+	// Body is the base64 ciphertext.
+	Body []byte `json:"body"`
+}
+
+// Encrypted is the payload of an end-to-end encrypted message: one
+// ciphertext for each device of each member, the sender's other devices
+// included. The server stores and relays it without being able to read
+// it.
+type Encrypted struct {
+	// Algorithm names the client's encryption scheme.
+	Algorithm      string             `json:"algorithm"`
+	SenderDeviceID string             `json:"sender_device_id"`
+	Ciphertexts    []DeviceCiphertext `json:"ciphertexts"`
+}
+
+func (e *Encrypted) Validate() error {
+	if e.Algorithm == "" || len(e.Algorithm) > maxAlgorithmLen {
+		return fmt.Errorf("%w: algorithm must be 1-%d characters", ErrInvalidContent, maxAlgorithmLen)
+	}
+	if e.SenderDeviceID == "" || len(e.SenderDeviceID) > maxDeviceIDLen {
+		return fmt.Errorf("%w: sender_device_id must be 1-%d characters", ErrInvalidContent, maxDeviceIDLen)
+	}
+	if len(e.Ciphertexts) == 0 || len(e.Ciphertexts) > maxEncryptedRecipients {
+		return fmt.Errorf("%w: ciphertexts must list 1-%d devices", ErrInvalidContent, maxEncryptedRecipients)
+	}
+	for _, ciphertext := range e.Ciphertexts {
+		if ciphertext.UserID == uuid.Nil || ciphertext.DeviceID == "" || len(ciphertext.DeviceID) > maxDeviceIDLen {
+			return fmt.Errorf("%w: each ciphertext needs a user_id and device_id", ErrInvalidContent)
+		}
+		if len(ciphertext.Body) == 0 || len(ciphertext.Body) > maxCiphertextBytes {
+			return fmt.Errorf("%w: each ciphertext body must be 1-%d bytes", ErrInvalidContent, maxCiphertextBytes)
+		}
+	}
+	return nil
+}
+
+const (
+	// maxEncryptedMetadataBytes bounds the opaque metadata of an encrypted
+	// attachment, room for an encrypted file name, key and digest.
+	maxEncryptedMetadataBytes = 4 * 1024
+
+	// encryptionOverhead is what encryption may add to a file on top of the
+	// limit for its kind: padding, nonces and authentication tags.
+	encryptionOverhead int64 = 64 * 1024
+)
+
+// EncryptedManifest describes a client-encrypted attachment for an
+// end-to-end encrypted conversation. The server cannot read the file, so
+// the limits of the declared kind are enforced on the manifest, and the
+// stored size must match it.
+type EncryptedManifest struct {
+	Kind      AttachmentKind `json:"kind"`
+	SizeBytes int64          `json:"size_bytes"`
+
+	// Metadata is opaque to the server and returned as given, for the
+	// client's encrypted file name, key and digest.
+	Metadata []byte `json:"metadata"`
+}
+
+func (m *EncryptedManifest) Validate() error {
+	switch m.Kind {
+	case AttachmentImage, AttachmentAudio, AttachmentVideo, AttachmentDocument:
+	default:
+		return fmt.Errorf("%w: kind must be image, audio, video or document", ErrInvalidContent)
+	}
+	if limit := MaxAttachmentBytes(m.Kind) + encryptionOverhead; m.SizeBytes <= 0 || m.SizeBytes > limit {
+		return fmt.Errorf("%w: encrypted %s uploads must be between 1 and %d bytes", ErrInvalidContent, m.Kind, limit)
+	}
+	if len(m.Metadata) > maxEncryptedMetadataBytes {
+		return fmt.Errorf("%w: metadata must be at most %d bytes", ErrInvalidContent, maxEncryptedMetadataBytes)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package content
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type RSVPStatus string
+
+const (
+	RSVPGoing    RSVPStatus = "going"
+	RSVPMaybe    RSVPStatus = "maybe"
+	RSVPDeclined RSVPStatus = "declined"
+)
+
+func (s RSVPStatus) Valid() bool {
+	return s == RSVPGoing || s == RSVPMaybe || s == RSVPDeclined
+}
+
+// Event is the interactive message posted when an event is created in a room.
+// RSVP counts are filled in by the server when the message is delivered.
+type Event struct {
+	Title     string             `json:"title"`
+	StartsAt  time.Time          `json:"starts_at"`
+	EndsAt    *time.Time         `json:"ends_at,omitempty"`
+	Location  string             `json:"location,omitempty"`
+	RSVPCount map[RSVPStatus]int `json:"rsvp_count,omitempty"`
+}
+
+func (e *Event) Validate() error {
+	e.Title = strings.TrimSpace(e.Title)
+	if e.Title == "" || len(e.Title) > 200 {
+		return fmt.Errorf("%w: title must be 1-200 characters", ErrInvalidContent)
+	}
+	if e.StartsAt.IsZero() {
+		return fmt.Errorf("%w: starts_at is required", ErrInvalidContent)
+	}
+	if e.EndsAt != nil && !e.EndsAt.After(e.StartsAt) {
+		return fmt.Errorf("%w: ends_at must be after starts_at", ErrInvalidContent)
+	}
+	if len(e.Location) > 255 {
+		return fmt.Errorf("%w: location must be at most 255 characters", ErrInvalidContent)
+	}
+	e.RSVPCount = nil
+	return nil
+}
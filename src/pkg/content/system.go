@@ -0,0 +1,19 @@
+package content
+
+import "github.com/google/uuid"
+
+// The changes system messages explain, named in their payload's Event.
+const (
+	NoticeRoomMerged = "room.merged"
+)
+
+// RoomMerged is the payload of the system message posted in a room when
+// another room is merged into it. Its text says the same for apps that do
+// not know the event.
+type RoomMerged struct {
+	Event        string    `json:"event"`
+	SourceID     uuid.UUID `json:"source_id"`
+	SourceTitle  string    `json:"source_title"`
+	MovedHistory bool      `json:"moved_history"`
+	MovedMembers int       `json:"moved_members"`
+}
@@ -0,0 +1,42 @@
+// Package crosssigning checks that a user's cross-signing key vouches for
+// a device's identity key. Devices sign with Ed25519.
+package crosssigning
+
+import (
+	"crypto/ed25519"
+
+	"github.com/google/uuid"
+)
+
+// domain separates device signatures from anything else the same key
+// might sign.
+const domain = "afrochat-device-signature-v1"
+
+// Payload is what the cross-signing key signs to vouch for a device: the
+// domain, the user, the device ID and its identity key, separated by NUL
+// bytes. Naming the user stops a signature being reused for an account
+// with the same keys.
+func Payload(userID uuid.UUID, deviceID string, identityKey []byte) []byte {
+	payload := make([]byte, 0, len(domain)+len(deviceID)+len(identityKey)+40)
+	payload = append(payload, domain...)
+	payload = append(payload, 0)
+	payload = append(payload, userID.String()...)
+	payload = append(payload, 0)
+	payload = append(payload, deviceID...)
+	payload = append(payload, 0)
+	return append(payload, identityKey...)
+}
+
+// ValidKey reports whether key is an Ed25519 public key.
+func ValidKey(key []byte) bool {
+	return len(key) == ed25519.PublicKeySize
+}
+
+// Verify reports whether signature is the cross-signing key's signature
+// of the device's Payload.
+func Verify(crossSigningKey, signature []byte, userID uuid.UUID, deviceID string, identityKey []byte) bool {
+	if !ValidKey(crossSigningKey) || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(crossSigningKey, Payload(userID, deviceID, identityKey), signature)
+}
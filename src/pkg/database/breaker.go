@@ -0,0 +1,84 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrUnavailable is returned by database operations while the circuit
+// breaker is open.
+var ErrUnavailable = errors.New("database unavailable")
+
+// maxIdleConns is how many idle connections the pool keeps.
+const maxIdleConns = 5
+
+// Available reports whether the database answered the last health check.
+func (c *DatabaseConnection) Available() bool {
+	return !c.unavailable.Load()
+}
+
+// Monitor pings the database every interval until ctx is done. A failed
+// ping opens the circuit breaker, failing queries at once with
+// ErrUnavailable instead of leaving them waiting on a dead database. The
+// first successful ping after an outage closes it again, after dropping
+// the idle connections the outage left broken.
+func (c *DatabaseConnection) Monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+		err := c.Health(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch {
+		case err != nil && c.unavailable.CompareAndSwap(false, true):
+			slog.Error("Database unreachable, failing queries until it recovers", "error", err)
+		case err == nil && c.unavailable.Load():
+			c.SQLDB.SetMaxIdleConns(0)
+			c.SQLDB.SetMaxIdleConns(maxIdleConns)
+			c.unavailable.Store(false)
+			slog.Info("Database reachable again")
+		}
+	}
+}
+
+// circuitBreaker is a GORM plugin failing every operation with
+// ErrUnavailable while the connection's breaker is open.
+type circuitBreaker struct {
+	conn *DatabaseConnection
+}
+
+func (circuitBreaker) Name() string {
+	return "circuit_breaker"
+}
+
+func (b circuitBreaker) Initialize(db *gorm.DB) error {
+	reject := func(tx *gorm.DB) {
+		if !b.conn.Available() {
+			tx.AddError(ErrUnavailable)
+		}
+	}
+	callbacks := db.Callback()
+	registrations := []error{
+		callbacks.Create().Before("gorm:create").Register("circuit_breaker:create", reject),
+		callbacks.Query().Before("gorm:query").Register("circuit_breaker:query", reject),
+		callbacks.Update().Before("gorm:update").Register("circuit_breaker:update", reject),
+		callbacks.Delete().Before("gorm:delete").Register("circuit_breaker:delete", reject),
+		callbacks.Row().Before("gorm:row").Register("circuit_breaker:row", reject),
+		callbacks.Raw().Before("gorm:raw").Register("circuit_breaker:raw", reject),
+	}
+	return errors.Join(registrations...)
+}
@@ -1,57 +1,95 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
-	"github.com/dfunani/AfroChat/backend/lib/utils"
 	_ "github.com/lib/pq" // PostgreSQL driver
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+)
+
+// Database drivers. Postgres is what runs in production; SQLite spares
+// local development and tests a database server, and lacks the
+// Postgres-only features some queries use.
+const (
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
 )
 
 // Config holds database configuration
 type DatabaseConfig struct {
+	// Driver is DriverPostgres, the default, or DriverSQLite.
+	Driver string
+
+	// Path is the SQLite database file, or ":memory:" for a database
+	// that lives as long as the connection.
+	Path string
+
 	Host     string
-	Port     string
+	Port     int
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
+
+	// ConnectTimeout is how long NewDatabaseConnection keeps retrying
+	// before giving up. Zero means a single attempt.
+	ConnectTimeout time.Duration
+
+	// QueryLog is what is logged of the queries run.
+	QueryLog QueryLogConfig
 }
 
+const (
+	// dialTimeout bounds each attempt to open a connection, so requests
+	// fail rather than hang while the database is unreachable.
+	dialTimeout = 5 * time.Second
+
+	initialConnectBackoff = 500 * time.Millisecond
+	maxConnectBackoff     = 8 * time.Second
+)
+
 // Connection holds database connection and configuration
 type DatabaseConnection struct {
 	DB     *gorm.DB
 	Config *DatabaseConfig
 	SQLDB  *sql.DB
-}
 
-// NewConfig creates a new database configuration from environment variables
-func NewDatabaseConfig() *DatabaseConfig {
-	return &DatabaseConfig{
-		Host:     utils.GetEnv("DB_HOST"),
-		Port:     utils.GetEnv("DB_PORT"),
-		User:     utils.GetEnv("DB_USER"),
-		Password: utils.GetEnv("DB_PASSWORD"),
-		DBName:   utils.GetEnv("DB_NAME"),
-		SSLMode:  utils.GetEnv("DB_SSLMODE"),
-	}
+	// unavailable opens the circuit breaker: set by Monitor while the
+	// database is unreachable, it fails queries at once.
+	unavailable atomic.Bool
 }
 
-// NewConnection creates a new database connection
+// NewConnection creates a new database connection. While the database is
+// unreachable it retries with exponential backoff for up to
+// config.ConnectTimeout, so the server can start before Postgres does.
 func NewDatabaseConnection(config *DatabaseConfig) (*DatabaseConnection, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode)
-
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
-	if err != nil {
-		return nil, err
+	dialector := config.dialector()
+	queries := newQueryLogger(config.QueryLog)
+	deadline := time.Now().Add(config.ConnectTimeout)
+	backoff := initialConnectBackoff
+	var db *gorm.DB
+	for attempt := 1; ; attempt++ {
+		var err error
+		db, err = gorm.Open(dialector, &gorm.Config{
+			Logger:         queries,
+			TranslateError: true,
+		})
+		if err == nil {
+			break
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("failed to connect after %d attempt(s): %w", attempt, err)
+		}
+		slog.Warn("Database unavailable, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
 
 	sqlDB, err := db.DB()
@@ -59,39 +97,68 @@ func NewDatabaseConnection(config *DatabaseConfig) (*DatabaseConnection, error)
 		return nil, fmt.Errorf("failed to get sql db: %w", err)
 	}
 
-	sqlDB.SetMaxOpenConns(25)
-	sqlDB.SetMaxIdleConns(5)
-	sqlDB.SetConnMaxLifetime(5 * time.Minute)
-	sqlDB.SetConnMaxIdleTime(1 * time.Minute)
+	if config.Driver == DriverSQLite {
+		// SQLite allows one writer at a time, and an in-memory database
+		// is lost with its connection, so one connection is kept open.
+		sqlDB.SetMaxOpenConns(1)
+	} else {
+		sqlDB.SetMaxOpenConns(25)
+		sqlDB.SetMaxIdleConns(maxIdleConns)
+		sqlDB.SetConnMaxLifetime(5 * time.Minute)
+		sqlDB.SetConnMaxIdleTime(1 * time.Minute)
+	}
 
-	return &DatabaseConnection{
+	conn := &DatabaseConnection{
 		DB:     db,
 		Config: config,
 		SQLDB:  sqlDB,
-	}, nil
+	}
+	if err := db.Use(circuitBreaker{conn}); err != nil {
+		return nil, fmt.Errorf("failed to install circuit breaker: %w", err)
+	}
+	if config.Driver == DriverSQLite {
+		if err := db.Use(uuidKeys{}); err != nil {
+			return nil, fmt.Errorf("failed to install UUID keys: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// dialector returns the GORM dialector for the configured driver.
+func (config *DatabaseConfig) dialector() gorm.Dialector {
+	if config.Driver == DriverSQLite {
+		return sqlite.Open("file:" + config.Path + "?_foreign_keys=on&_busy_timeout=5000")
+	}
+	return postgres.Open(config.PostgresDSN())
+}
+
+// PostgresDSN is the connection string of the Postgres database, for
+// connections opened outside GORM such as the realtime bus's listener.
+func (config *DatabaseConfig) PostgresDSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s connect_timeout=%d",
+		config.Host, config.Port, config.User, config.Password, config.DBName, config.SSLMode, int(dialTimeout.Seconds()))
 }
 
 func (c *DatabaseConnection) Close() error {
 	if c.SQLDB == nil {
 		return nil
 	}
-	log.Println("Closing database connection...")
+	slog.Info("Closing database connection")
 	if err := c.SQLDB.Close(); err != nil {
 		return fmt.Errorf("failed to close sql db: %w", err)
 	}
-	log.Println("Database connection closed successfully")
+	slog.Info("Database connection closed")
 	return nil
 }
 
-func (c *DatabaseConnection) Health() error {
-	log.Println("Checking database connection health...")
+// Health pings the database, giving up when ctx is done.
+func (c *DatabaseConnection) Health(ctx context.Context) error {
 	if c.SQLDB == nil {
 		return fmt.Errorf("no database connection found")
 	}
 
-	if err := c.SQLDB.Ping(); err != nil {
+	if err := c.SQLDB.PingContext(ctx); err != nil {
 		return fmt.Errorf("failed to ping database: %w", err)
 	}
-	log.Println("Database connection ping successful")
 	return nil
 }
@@ -0,0 +1,50 @@
+package dbtest
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// QueryBudget returns a handle on the test database that counts the
+// statements run through it, preloads and transactions included, and
+// fails the test when it ends if there were more than budget, listing
+// them. Pass it to the repository an endpoint uses, so a list that
+// starts querying once per row is caught.
+func QueryBudget(t testing.TB, conn *database.DatabaseConnection, budget int) *gorm.DB {
+	t.Helper()
+	counter := &queryCounter{Interface: logger.Discard}
+	t.Cleanup(func() {
+		counter.mu.Lock()
+		defer counter.mu.Unlock()
+		if len(counter.statements) > budget {
+			t.Errorf("ran %d queries, over the budget of %d:\n%s", len(counter.statements), budget, strings.Join(counter.statements, "\n"))
+		}
+	})
+	return conn.DB.Session(&gorm.Session{Logger: counter})
+}
+
+// queryCounter is a GORM logger recording every statement traced.
+type queryCounter struct {
+	logger.Interface
+
+	mu         sync.Mutex
+	statements []string
+}
+
+func (c *queryCounter) LogMode(logger.LogLevel) logger.Interface {
+	return c
+}
+
+func (c *queryCounter) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statements = append(c.statements, sql)
+}
@@ -0,0 +1,33 @@
+// Package dbtest gives tests a database of their own without a database
+// server.
+package dbtest
+
+import (
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+)
+
+// New returns an empty in-memory SQLite database with the full schema,
+// closed when the test ends. Each call gets its own database, so tests
+// may run in parallel. Queries using Postgres-only SQL fail against it.
+func New(t testing.TB) *database.DatabaseConnection {
+	t.Helper()
+	conn, err := database.NewDatabaseConnection(&database.DatabaseConfig{
+		Driver:   database.DriverSQLite,
+		Path:     ":memory:",
+		QueryLog: database.QueryLogConfig{Level: database.QueryLogSilent},
+	})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := conn.Close(); err != nil {
+			t.Errorf("failed to close test database: %v", err)
+		}
+	})
+	if err := conn.CreateSQLiteSchema(); err != nil {
+		t.Fatalf("failed to create test database schema: %v", err)
+	}
+	return conn
+}
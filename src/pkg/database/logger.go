@@ -0,0 +1,208 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Query log levels, from quietest to most verbose.
+const (
+	QueryLogSilent = "silent"
+	QueryLogError  = "error"
+	QueryLogWarn   = "warn"
+	QueryLogInfo   = "info"
+)
+
+var queryLogLevels = map[string]logger.LogLevel{
+	QueryLogSilent: logger.Silent,
+	QueryLogError:  logger.Error,
+	QueryLogWarn:   logger.Warn,
+	QueryLogInfo:   logger.Info,
+}
+
+const (
+	// DefaultSlowQueryThreshold is how long a query runs before it is
+	// logged as a warning, unless configured otherwise.
+	DefaultSlowQueryThreshold = 200 * time.Millisecond
+
+	// querySampleWindow is the period QueryLogConfig.SampleBurst counts
+	// over.
+	querySampleWindow = time.Minute
+
+	// maxSampledStatements bounds the statements counted in a window.
+	// Statements beyond it are logged unsampled.
+	maxSampledStatements = 1000
+)
+
+// QueryLogConfig chooses which of GORM's messages and queries are logged.
+type QueryLogConfig struct {
+	// Level is one of the QueryLog levels: silent logs nothing, error
+	// logs failed queries, warn adds slow ones and info every query, at
+	// debug level.
+	Level string
+
+	// SlowThreshold is how long a query runs before it is slow; zero means
+	// DefaultSlowQueryThreshold.
+	SlowThreshold time.Duration
+
+	// SampleBurst is how many times a minute the same statement, whatever
+	// its arguments, is logged as slow or run. Further runs are counted
+	// and the count reported on the statement's next line. Failed queries
+	// are always logged, and zero logs every run.
+	SampleBurst int
+}
+
+// newQueryLogger builds the logger config asks for. Unknown levels log
+// everything.
+func newQueryLogger(config QueryLogConfig) queryLogger {
+	level, ok := queryLogLevels[config.Level]
+	if !ok {
+		level = logger.Info
+	}
+	slow := config.SlowThreshold
+	if slow <= 0 {
+		slow = DefaultSlowQueryThreshold
+	}
+	l := queryLogger{level: level, slowThreshold: slow}
+	if config.SampleBurst > 0 {
+		l.sampler = &querySampler{burst: config.SampleBurst}
+	}
+	return l
+}
+
+// queryLogger logs GORM's messages and queries through slog, with the
+// request ID of the context queries run with. Failed queries are errors
+// and slow ones warnings; the rest are logged at debug level.
+type queryLogger struct {
+	level         logger.LogLevel
+	slowThreshold time.Duration
+	sampler       *querySampler
+}
+
+func (l queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l.level = level
+	return l
+}
+
+func (l queryLogger) Info(ctx context.Context, format string, args ...any) {
+	if l.level >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l queryLogger) Warn(ctx context.Context, format string, args ...any) {
+	if l.level >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l queryLogger) Error(ctx context.Context, format string, args ...any) {
+	if l.level >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	level, message := slog.LevelDebug, "Query"
+	switch {
+	// Lookups that find nothing are answered, not failed.
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		level, message = slog.LevelError, "Query failed"
+	case elapsed > l.slowThreshold && l.level >= logger.Warn:
+		level, message = slog.LevelWarn, "Slow query"
+	case l.level < logger.Info:
+		return
+	}
+	if !slog.Default().Enabled(ctx, level) {
+		return
+	}
+
+	sql, rows := fc()
+	var suppressed int
+	if l.sampler != nil && level != slog.LevelError {
+		var ok bool
+		if ok, suppressed = l.sampler.allow(message+" "+normalizeSQL(sql), time.Now()); !ok {
+			return
+		}
+	}
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if suppressed > 0 {
+		attrs = append(attrs, slog.Int("suppressed", suppressed))
+	}
+	if level == slog.LevelError {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	slog.LogAttrs(ctx, level, message, attrs...)
+}
+
+// querySampler lets through the first burst runs of each statement in a
+// window and counts the rest.
+type querySampler struct {
+	burst int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+	// carried is the runs of each statement suppressed in the previous
+	// window and not yet reported.
+	carried map[string]int
+}
+
+// allow reports whether to log a run of statement, and how many runs of it
+// were suppressed since it was last logged.
+func (s *querySampler) allow(statement string, now time.Time) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.windowStart) >= querySampleWindow {
+		s.carried = make(map[string]int)
+		for key, count := range s.counts {
+			if count > s.burst {
+				s.carried[key] = count - s.burst
+			}
+		}
+		s.counts = make(map[string]int)
+		s.windowStart = now
+	}
+
+	count, ok := s.counts[statement]
+	if !ok && len(s.counts) >= maxSampledStatements {
+		return true, 0
+	}
+	s.counts[statement] = count + 1
+	if count >= s.burst {
+		return false, 0
+	}
+	suppressed := s.carried[statement]
+	delete(s.carried, statement)
+	return true, suppressed
+}
+
+var (
+	sqlStrings = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumbers = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	sqlLists   = regexp.MustCompile(`\(\?(?:\s*,\s*\?)*\)`)
+)
+
+// normalizeSQL strips the values from a statement, so runs of it with
+// different arguments sample together.
+func normalizeSQL(sql string) string {
+	sql = sqlStrings.ReplaceAllString(sql, "?")
+	sql = sqlNumbers.ReplaceAllString(sql, "?")
+	return sqlLists.ReplaceAllString(sql, "(?)")
+}
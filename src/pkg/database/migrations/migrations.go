@@ -0,0 +1,313 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dir is where migration files live, relative to the backend source root.
+// Create writes new migrations there; they are embedded at build time.
+const Dir = "pkg/database/migrations/sql"
+
+// lockID is the Postgres advisory lock held while migrating, so instances
+// starting together do not apply the same migration twice.
+const lockID = 7_140_311_288
+
+//go:embed sql/*.sql
+var files embed.FS
+
+var (
+	fileName      = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	migrationName = regexp.MustCompile(`^\w+$`)
+)
+
+// ErrSchemaBehind means the database is missing migrations this build
+// expects.
+var ErrSchemaBehind = errors.New("database schema is behind")
+
+// Migration is one numbered schema change. Up applies it and Down reverts
+// it; each runs in a transaction.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the embedded migrations to a database, recording which
+// have run in the schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func New(db *sql.DB) (*Migrator, error) {
+	migrations, err := load(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// load reads the NNNN_name.up.sql and NNNN_name.down.sql pairs in dir,
+// ordered by version. Every migration must have both.
+func load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	found := make(map[string]bool)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s is not named NNNN_name.up.sql or NNNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+		found[fmt.Sprint(version, ".", match[3])] = true
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if !found[fmt.Sprint(migration.Version, ".up")] || !found[fmt.Sprint(migration.Version, ".down")] {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Status is how far a database has been migrated.
+type Status struct {
+	// Current is the latest applied version, 0 for an empty database.
+	Current int64
+	// Latest is the latest version this build knows.
+	Latest  int64
+	Pending []Migration
+}
+
+// Status reports which migrations have been applied.
+func (m *Migrator) Status(ctx context.Context) (*Status, error) {
+	if err := ensureTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+	applied, err := appliedVersions(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.status(applied), nil
+}
+
+func (m *Migrator) status(applied map[int64]bool) *Status {
+	status := &Status{}
+	for version := range applied {
+		status.Current = max(status.Current, version)
+	}
+	for _, migration := range m.migrations {
+		status.Latest = migration.Version
+		if !applied[migration.Version] {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status
+}
+
+// Check returns ErrSchemaBehind if any migration has not been applied. A
+// database ahead of this build passes, so rolling back a release does not
+// require rolling back its migrations first.
+func (m *Migrator) Check(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if len(status.Pending) > 0 {
+		return fmt.Errorf("%w: %d migration(s) pending, starting with %d_%s; run the migrate command",
+			ErrSchemaBehind, len(status.Pending), status.Pending[0].Version, status.Pending[0].Name)
+	}
+	return nil
+}
+
+// Up applies every pending migration in order and returns those applied.
+// It stops at the first failure, whose transaction is rolled back.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.status(versions).Pending {
+			err := inTx(ctx, conn, migration.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+					migration.Version, migration.Name, time.Now())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the latest steps applied migrations, newest first, and
+// returns those reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if !versions[migration.Version] {
+				continue
+			}
+			err := inTx(ctx, conn, migration.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// locked runs fn on a single connection holding the migration lock.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("failed to take the migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID)
+
+	if err := ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+func ensureTable(ctx context.Context, db execer) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+func appliedVersions(ctx context.Context, db execer) (map[int64]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+		}
+		versions[version] = true
+	}
+	return versions, rows.Err()
+}
+
+// inTx runs a migration script and records it in one transaction, so a
+// failed migration leaves nothing half-applied.
+func inTx(ctx context.Context, conn *sql.Conn, script string, record func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Create writes an empty up and down file for a new migration under dir,
+// numbered after the latest one there, and returns their paths.
+func Create(dir, name string) (up, down string, err error) {
+	name = strings.ToLower(strings.Join(strings.Fields(name), "_"))
+	if !migrationName.MatchString(name) {
+		return "", "", fmt.Errorf("migration name %q may only contain letters, digits and underscores", name)
+	}
+
+	migrations, err := load(os.DirFS(dir), ".")
+	if err != nil {
+		return "", "", err
+	}
+	version := int64(1)
+	if len(migrations) > 0 {
+		version = migrations[len(migrations)-1].Version + 1
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%04d_%s", version, name))
+	up, down = base+".up.sql", base+".down.sql"
+	for _, file := range []string{up, down} {
+		if err := os.WriteFile(file, []byte("-- "+filepath.Base(file)+"\n"), 0o644); err != nil {
+			return "", "", fmt.Errorf("failed to create migration: %w", err)
+		}
+	}
+	return up, down, nil
+}
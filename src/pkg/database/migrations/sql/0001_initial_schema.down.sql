@@ -0,0 +1,22 @@
+DROP TABLE IF EXISTS "client_config_rules";
+DROP TABLE IF EXISTS "experiment_variants";
+DROP TABLE IF EXISTS "experiments";
+DROP TABLE IF EXISTS "channels";
+DROP TABLE IF EXISTS "backups";
+DROP TABLE IF EXISTS "attachments";
+DROP TABLE IF EXISTS "message_receipts";
+DROP TABLE IF EXISTS "messages";
+DROP TABLE IF EXISTS "conversation_members";
+DROP TABLE IF EXISTS "conversations";
+DROP TABLE IF EXISTS "waitlist_entries";
+DROP TABLE IF EXISTS "verification_tokens";
+DROP TABLE IF EXISTS "sessions";
+DROP TABLE IF EXISTS "invite_codes";
+DROP TABLE IF EXISTS "legal_acceptances";
+DROP TABLE IF EXISTS "legal_documents";
+DROP TABLE IF EXISTS "reminders";
+DROP TABLE IF EXISTS "auto_reply_rules";
+DROP TABLE IF EXISTS "quick_replies";
+DROP TABLE IF EXISTS "catalog_items";
+DROP TABLE IF EXISTS "business_profiles";
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,398 @@
+-- The schema as AutoMigrate left it. Every statement only creates what is
+-- missing, so databases that were set up by AutoMigrate adopt this
+-- migration without changes.
+
+CREATE TABLE IF NOT EXISTS "users" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "email" varchar(255) NOT NULL,
+    "username" varchar(50) NOT NULL,
+    "display_name" varchar(100) NOT NULL,
+    "first_name" varchar(50),
+    "last_name" varchar(50),
+    "avatar_url" text,
+    "bio" text,
+    "account_type" varchar(20) DEFAULT 'personal',
+    "phone_number" varchar(20),
+    "time_zone" varchar(50) DEFAULT 'UTC',
+    "location" varchar(100),
+    "country_code" varchar(2),
+    "home_region" varchar(32) DEFAULT 'default',
+    "residency" varchar(16) DEFAULT 'default',
+    "status" varchar(20) DEFAULT 'offline',
+    "is_verified" boolean DEFAULT false,
+    "is_active" boolean DEFAULT true,
+    "is_suspended" boolean DEFAULT false,
+    "is_banned" boolean DEFAULT false,
+    "is_premium" boolean DEFAULT false,
+    "role" varchar(20) DEFAULT 'user',
+    "invite_code_id" uuid,
+    "is_shadow_restricted" boolean DEFAULT false,
+    "shadow_restricted_by" varchar(50),
+    "password_hash" varchar(255) NOT NULL,
+    "salt" varchar(255) NOT NULL,
+    "last_seen_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    "suspended_at" timestamptz,
+    "banned_at" timestamptz,
+    "restricted_at" timestamptz,
+    "premium_at" timestamptz,
+    "last_login_at" timestamptz,
+    "last_logout_at" timestamptz,
+    "last_activity_at" timestamptz,
+    "date_of_birth" date,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_users_deleted_at" ON "users" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_users_is_shadow_restricted" ON "users" ("is_shadow_restricted");
+CREATE INDEX IF NOT EXISTS "idx_users_residency" ON "users" ("residency");
+CREATE INDEX IF NOT EXISTS "idx_users_home_region" ON "users" ("home_region");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_username" ON "users" ("username");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_users_email" ON "users" ("email");
+
+CREATE TABLE IF NOT EXISTS "business_profiles" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "category" varchar(50),
+    "description" text,
+    "website" varchar(255),
+    "email" varchar(255),
+    "address" varchar(255),
+    "is_verified" boolean DEFAULT false,
+    "verified_at" timestamptz,
+    "away_enabled" boolean DEFAULT false,
+    "away_message" text,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_business_profiles_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_business_profiles_deleted_at" ON "business_profiles" ("deleted_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_business_profiles_user_id" ON "business_profiles" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "catalog_items" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "business_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "description" text,
+    "price_cents" bigint NOT NULL DEFAULT 0,
+    "currency" varchar(3) NOT NULL DEFAULT 'ZAR',
+    "image_url" text,
+    "is_available" boolean DEFAULT true,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_catalog_items_business" FOREIGN KEY ("business_id") REFERENCES "business_profiles"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_catalog_items_deleted_at" ON "catalog_items" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_catalog_items_business_id" ON "catalog_items" ("business_id");
+
+CREATE TABLE IF NOT EXISTS "quick_replies" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "business_id" uuid NOT NULL,
+    "shortcut" varchar(30) NOT NULL,
+    "text" text NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_quick_replies_business" FOREIGN KEY ("business_id") REFERENCES "business_profiles"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_quick_replies_business_shortcut" ON "quick_replies" ("business_id","shortcut");
+
+CREATE TABLE IF NOT EXISTS "auto_reply_rules" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "owner_id" uuid NOT NULL,
+    "kind" varchar(20) NOT NULL,
+    "keywords" text,
+    "reply" text NOT NULL,
+    "priority" bigint DEFAULT 0,
+    "enabled" boolean DEFAULT true,
+    "days" bigint DEFAULT 127,
+    "start_minute" bigint DEFAULT 0,
+    "end_minute" bigint DEFAULT 1440,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_auto_reply_rules_owner" FOREIGN KEY ("owner_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_auto_reply_rules_owner_id" ON "auto_reply_rules" ("owner_id");
+
+CREATE TABLE IF NOT EXISTS "reminders" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "conversation_id" uuid,
+    "text" text NOT NULL,
+    "remind_at" timestamptz NOT NULL,
+    "delivered_at" timestamptz,
+    "cancelled_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_reminders_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_reminders_due" ON "reminders" ("remind_at","delivered_at");
+CREATE INDEX IF NOT EXISTS "idx_reminders_user" ON "reminders" ("user_id");
+
+CREATE TABLE IF NOT EXISTS "legal_documents" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "kind" varchar(30) NOT NULL,
+    "version" varchar(20) NOT NULL,
+    "url" text NOT NULL,
+    "published_at" timestamptz NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_legal_documents_published_at" ON "legal_documents" ("published_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_legal_documents_kind_version" ON "legal_documents" ("kind","version");
+
+CREATE TABLE IF NOT EXISTS "legal_acceptances" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "document_id" uuid NOT NULL,
+    "ip_address" varchar(45),
+    "user_agent" varchar(255),
+    "accepted_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_legal_acceptances_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_legal_acceptances_document" FOREIGN KEY ("document_id") REFERENCES "legal_documents"("id") ON DELETE RESTRICT
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_legal_acceptances_user_document" ON "legal_acceptances" ("user_id","document_id");
+
+CREATE TABLE IF NOT EXISTS "invite_codes" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "code" varchar(16) NOT NULL,
+    "batch_id" uuid NOT NULL,
+    "note" varchar(255),
+    "max_uses" bigint NOT NULL DEFAULT 1,
+    "uses" bigint NOT NULL DEFAULT 0,
+    "expires_at" timestamptz,
+    "created_by_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_invite_codes_batch_id" ON "invite_codes" ("batch_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_invite_codes_code" ON "invite_codes" ("code");
+
+CREATE TABLE IF NOT EXISTS "sessions" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "refresh_token_hash" varchar(64) NOT NULL,
+    "previous_token_hash" varchar(64),
+    "user_agent" varchar(255),
+    "ip_address" varchar(45),
+    "platform" varchar(20),
+    "client_version" varchar(20),
+    "created_at" timestamptz,
+    "last_used_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    "revoked_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_sessions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_sessions_previous_token_hash" ON "sessions" ("previous_token_hash");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_sessions_refresh_token_hash" ON "sessions" ("refresh_token_hash");
+CREATE INDEX IF NOT EXISTS "idx_sessions_user_revoked" ON "sessions" ("user_id","revoked_at");
+
+CREATE TABLE IF NOT EXISTS "verification_tokens" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "purpose" varchar(32) NOT NULL,
+    "token_hash" varchar(64) NOT NULL,
+    "created_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    "used_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_verification_tokens_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_verification_tokens_token_hash" ON "verification_tokens" ("token_hash");
+CREATE INDEX IF NOT EXISTS "idx_verification_tokens_user_purpose" ON "verification_tokens" ("user_id","purpose");
+
+CREATE TABLE IF NOT EXISTS "waitlist_entries" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "email" varchar(255) NOT NULL,
+    "token" varchar(32) NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'waiting',
+    "invite_code_id" uuid,
+    "admitted_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_waitlist_status_created" ON "waitlist_entries" ("status","created_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_waitlist_entries_token" ON "waitlist_entries" ("token");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_waitlist_entries_email" ON "waitlist_entries" ("email");
+
+CREATE TABLE IF NOT EXISTS "conversations" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "kind" varchar(20) NOT NULL,
+    "title" varchar(100),
+    "direct_key" varchar(73),
+    "created_by_id" uuid NOT NULL,
+    "last_message_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_conversations_deleted_at" ON "conversations" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_conversations_last_message_at" ON "conversations" ("last_message_at");
+CREATE INDEX IF NOT EXISTS "idx_conversations_creator_created" ON "conversations" ("created_by_id","created_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_conversations_direct_key" ON "conversations" ("direct_key");
+
+CREATE TABLE IF NOT EXISTS "conversation_members" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "role" varchar(20) NOT NULL DEFAULT 'member',
+    "joined_at" timestamptz NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_conversation_members_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_conversations_members" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_conversation_members_deleted_at" ON "conversation_members" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_conversation_members_user_id" ON "conversation_members" ("user_id");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_conversation_members_conversation_user" ON "conversation_members" ("conversation_id","user_id");
+
+CREATE TABLE IF NOT EXISTS "messages" (
+    "id" uuid,
+    "conversation_id" uuid NOT NULL,
+    "sender_id" uuid NOT NULL,
+    "client_id" varchar(64),
+    "type" varchar(20) NOT NULL DEFAULT 'text',
+    "text" text,
+    "entities" jsonb,
+    "payload" jsonb,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_messages_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_messages_sender" FOREIGN KEY ("sender_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_messages_deleted_at" ON "messages" ("deleted_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_messages_sender_client" ON "messages" ("sender_id","client_id");
+CREATE INDEX IF NOT EXISTS "idx_messages_sender_id" ON "messages" ("sender_id");
+CREATE INDEX IF NOT EXISTS "idx_messages_conversation_history" ON "messages" ("conversation_id","created_at","id");
+
+CREATE TABLE IF NOT EXISTS "message_receipts" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "delivered_message_id" uuid,
+    "delivered_at" timestamptz,
+    "read_message_id" uuid,
+    "read_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_message_receipts_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_message_receipts_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_message_receipts_conversation_user" ON "message_receipts" ("conversation_id","user_id");
+
+CREATE TABLE IF NOT EXISTS "attachments" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "uploader_id" uuid NOT NULL,
+    "message_id" uuid,
+    "kind" varchar(20) NOT NULL,
+    "file_name" varchar(255) NOT NULL,
+    "mime_type" varchar(255) NOT NULL,
+    "size_bytes" bigint NOT NULL,
+    "storage_key" varchar(255) NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_attachments_uploader" FOREIGN KEY ("uploader_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_messages_attachments" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS "idx_attachments_deleted_at" ON "attachments" ("deleted_at");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_attachments_storage_key" ON "attachments" ("storage_key");
+CREATE INDEX IF NOT EXISTS "idx_attachments_message_id" ON "attachments" ("message_id");
+CREATE INDEX IF NOT EXISTS "idx_attachments_uploader_created" ON "attachments" ("uploader_id","created_at");
+
+CREATE TABLE IF NOT EXISTS "backups" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "version" bigint NOT NULL,
+    "session_id" uuid,
+    "device_name" varchar(100),
+    "scheme" varchar(50) NOT NULL,
+    "size_bytes" bigint NOT NULL,
+    "sha256" varchar(64) NOT NULL,
+    "storage_key" varchar(255) NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_backups_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_backups_session" FOREIGN KEY ("session_id") REFERENCES "sessions"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_backups_storage_key" ON "backups" ("storage_key");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_backups_user_version" ON "backups" ("user_id","version");
+
+CREATE TABLE IF NOT EXISTS "channels" (
+    "conversation_id" uuid,
+    "name" varchar(80) NOT NULL,
+    "description" varchar(500),
+    "is_private" boolean DEFAULT false,
+    "created_by_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("conversation_id"),
+    CONSTRAINT "fk_channels_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS "idx_channels_deleted_at" ON "channels" ("deleted_at");
+CREATE INDEX IF NOT EXISTS "idx_channels_name" ON "channels" ("name");
+
+CREATE TABLE IF NOT EXISTS "experiments" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "key" varchar(64) NOT NULL,
+    "description" varchar(500),
+    "status" varchar(20) NOT NULL DEFAULT 'draft',
+    "traffic_percent" bigint NOT NULL DEFAULT 100,
+    "created_by_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_experiments_status" ON "experiments" ("status");
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_experiments_key" ON "experiments" ("key");
+
+CREATE TABLE IF NOT EXISTS "experiment_variants" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "experiment_id" uuid NOT NULL,
+    "key" varchar(64) NOT NULL,
+    "weight" bigint NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_experiments_variants" FOREIGN KEY ("experiment_id") REFERENCES "experiments"("id")
+);
+CREATE UNIQUE INDEX IF NOT EXISTS "idx_experiment_variants_experiment_key" ON "experiment_variants" ("experiment_id","key");
+
+CREATE TABLE IF NOT EXISTS "client_config_rules" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "platform" varchar(20),
+    "min_version" varchar(32),
+    "max_version" varchar(32),
+    "priority" bigint NOT NULL DEFAULT 0,
+    "settings" jsonb NOT NULL,
+    "enabled" boolean NOT NULL,
+    "note" varchar(255),
+    "updated_by_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX IF NOT EXISTS "idx_client_config_rules_platform" ON "client_config_rules" ("platform");
+
+-- Superseded by idx_messages_conversation_history, which adds id.
+DROP INDEX IF EXISTS "idx_messages_conversation_created";
@@ -0,0 +1,28 @@
+CREATE TABLE "contacts" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "requester_id" uuid NOT NULL,
+    "addressee_id" uuid NOT NULL,
+    "pair_key" varchar(73) NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "accepted_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_contacts_requester" FOREIGN KEY ("requester_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_contacts_addressee" FOREIGN KEY ("addressee_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_contacts_pair_key" ON "contacts" ("pair_key");
+CREATE INDEX "idx_contacts_addressee_id" ON "contacts" ("addressee_id");
+CREATE INDEX "idx_contacts_requester_id" ON "contacts" ("requester_id");
+
+CREATE TABLE "blocks" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "blocker_id" uuid NOT NULL,
+    "blocked_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_blocks_blocker" FOREIGN KEY ("blocker_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_blocks_blocked" FOREIGN KEY ("blocked_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_blocks_blocked_id" ON "blocks" ("blocked_id");
+CREATE UNIQUE INDEX "idx_blocks_blocker_blocked" ON "blocks" ("blocker_id","blocked_id");
@@ -0,0 +1,26 @@
+CREATE TABLE "device_tokens" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "session_id" uuid NOT NULL,
+    "platform" varchar(20) NOT NULL,
+    "token" varchar(512) NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_device_tokens_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_device_tokens_session" FOREIGN KEY ("session_id") REFERENCES "sessions"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_device_tokens_token" ON "device_tokens" ("token");
+CREATE INDEX "idx_device_tokens_session_id" ON "device_tokens" ("session_id");
+CREATE INDEX "idx_device_tokens_user_id" ON "device_tokens" ("user_id");
+
+CREATE TABLE "notification_preferences" (
+    "user_id" uuid,
+    "direct_messages" boolean NOT NULL,
+    "group_messages" boolean NOT NULL,
+    "mentions" boolean NOT NULL,
+    "show_previews" boolean NOT NULL,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_notification_preferences_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
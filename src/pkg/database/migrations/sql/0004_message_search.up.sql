@@ -0,0 +1,5 @@
+-- Adding a stored generated column rewrites the messages table, holding
+-- an exclusive lock until it is done.
+ALTER TABLE "messages" ADD COLUMN "search_vector" tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', coalesce("text", ''))) STORED;
+CREATE INDEX "idx_messages_search_vector" ON "messages" USING GIN ("search_vector");
@@ -0,0 +1,3 @@
+DROP TABLE "device_keys";
+DROP TABLE "cross_signing_keys";
+DROP TABLE "key_backups";
@@ -0,0 +1,35 @@
+CREATE TABLE "key_backups" (
+    "user_id" uuid,
+    "version" bigint NOT NULL,
+    "algorithm" varchar(100) NOT NULL,
+    "kdf_params" jsonb NOT NULL,
+    "ciphertext" bytea NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_key_backups_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+
+CREATE TABLE "cross_signing_keys" (
+    "user_id" uuid,
+    "public_key" bytea NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_cross_signing_keys_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+
+CREATE TABLE "device_keys" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "device_id" varchar(64) NOT NULL,
+    "display_name" varchar(100),
+    "identity_key" bytea NOT NULL,
+    "signature" bytea,
+    "signed_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_device_keys_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_device_keys_user_device" ON "device_keys" ("user_id","device_id");
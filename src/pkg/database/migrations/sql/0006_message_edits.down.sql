@@ -0,0 +1,2 @@
+DROP TABLE "message_edits";
+ALTER TABLE "messages" DROP COLUMN "edited_at";
@@ -0,0 +1,13 @@
+ALTER TABLE "messages" ADD COLUMN "edited_at" timestamptz;
+
+CREATE TABLE "message_edits" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "message_id" uuid NOT NULL,
+    "text" text,
+    "entities" jsonb,
+    "written_at" timestamptz NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_message_edits_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_message_edits_history" ON "message_edits" ("message_id","created_at");
@@ -0,0 +1,10 @@
+DROP INDEX "idx_messages_audit_chain";
+ALTER TABLE "messages"
+    DROP COLUMN "audit_hash",
+    DROP COLUMN "audit_prev_hash",
+    DROP COLUMN "audit_seq";
+
+ALTER TABLE "conversations"
+    DROP COLUMN "audit_head",
+    DROP COLUMN "audit_length",
+    DROP COLUMN "audited";
@@ -0,0 +1,11 @@
+ALTER TABLE "conversations"
+    ADD COLUMN "audited" boolean NOT NULL DEFAULT false,
+    ADD COLUMN "audit_length" bigint NOT NULL DEFAULT 0,
+    ADD COLUMN "audit_head" bytea;
+
+ALTER TABLE "messages"
+    ADD COLUMN "audit_seq" bigint,
+    ADD COLUMN "audit_prev_hash" bytea,
+    ADD COLUMN "audit_hash" bytea;
+-- Building the index blocks writes to messages until it is done.
+CREATE UNIQUE INDEX "idx_messages_audit_chain" ON "messages" ("conversation_id","audit_seq");
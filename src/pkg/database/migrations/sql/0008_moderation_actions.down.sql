@@ -0,0 +1,2 @@
+DROP TABLE "moderation_actions";
+ALTER TABLE "users" DROP COLUMN "suspended_until";
@@ -0,0 +1,17 @@
+ALTER TABLE "users" ADD COLUMN "suspended_until" timestamptz;
+
+CREATE TABLE "moderation_actions" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "actor_id" uuid,
+    "action" varchar(20) NOT NULL,
+    "reason" text NOT NULL,
+    "until" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_moderation_actions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_moderation_actions_actor" FOREIGN KEY ("actor_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_moderation_actions_created_at" ON "moderation_actions" ("created_at");
+CREATE INDEX "idx_moderation_actions_actor_id" ON "moderation_actions" ("actor_id");
+CREATE INDEX "idx_moderation_actions_user_created" ON "moderation_actions" ("user_id","created_at");
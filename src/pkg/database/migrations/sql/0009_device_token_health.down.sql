@@ -0,0 +1,6 @@
+DROP INDEX "idx_device_tokens_updated_at";
+ALTER TABLE "device_tokens"
+    DROP COLUMN "last_pushed_at",
+    DROP COLUMN "last_failure_at",
+    DROP COLUMN "last_error",
+    DROP COLUMN "failures";
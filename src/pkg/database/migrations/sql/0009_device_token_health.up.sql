@@ -0,0 +1,14 @@
+ALTER TABLE "device_tokens"
+    ADD COLUMN "last_pushed_at" timestamptz,
+    ADD COLUMN "last_failure_at" timestamptz,
+    ADD COLUMN "last_error" varchar(255),
+    ADD COLUMN "failures" bigint NOT NULL DEFAULT 0;
+CREATE INDEX "idx_device_tokens_updated_at" ON "device_tokens" ("updated_at");
+
+-- APNs tokens are now stored in lowercase; keep the most recently
+-- registered of any that differed only in case.
+DELETE FROM "device_tokens" AS "older" USING "device_tokens" AS "newer"
+WHERE "older"."platform" = 'ios' AND "newer"."platform" = 'ios'
+    AND lower("older"."token") = lower("newer"."token")
+    AND ("older"."updated_at", "older"."id") < ("newer"."updated_at", "newer"."id");
+UPDATE "device_tokens" SET "token" = lower("token") WHERE "platform" = 'ios' AND "token" <> lower("token");
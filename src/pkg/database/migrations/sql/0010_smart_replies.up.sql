@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "smart_replies" boolean NOT NULL DEFAULT false;
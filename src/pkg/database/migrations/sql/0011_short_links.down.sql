@@ -0,0 +1,2 @@
+DROP TABLE "short_link_clicks";
+DROP TABLE "short_links";
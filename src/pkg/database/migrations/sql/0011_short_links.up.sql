@@ -0,0 +1,28 @@
+CREATE TABLE "short_links" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "code" varchar(16) NOT NULL,
+    "destination" text NOT NULL,
+    "kind" varchar(20) NOT NULL,
+    "created_by_id" uuid,
+    "expires_at" timestamptz,
+    "disabled_at" timestamptz,
+    "disabled_reason" varchar(255),
+    "clicks" bigint NOT NULL DEFAULT 0,
+    "last_clicked_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_short_links_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_short_links_creator" ON "short_links" ("created_by_id","created_at");
+CREATE UNIQUE INDEX "idx_short_links_code" ON "short_links" ("code");
+
+CREATE TABLE "short_link_clicks" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "link_id" uuid NOT NULL,
+    "platform" varchar(20) NOT NULL,
+    "referrer_host" varchar(255),
+    "clicked_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_short_link_clicks_link" FOREIGN KEY ("link_id") REFERENCES "short_links"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_short_link_clicks_link_time" ON "short_link_clicks" ("link_id","clicked_at");
@@ -0,0 +1,13 @@
+CREATE TABLE "user_identities" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "provider" varchar(20) NOT NULL,
+    "subject" varchar(255) NOT NULL,
+    "email" varchar(255),
+    "created_at" timestamptz,
+    "last_login_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_user_identities_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_user_identities_provider_subject" ON "user_identities" ("provider","subject");
+CREATE INDEX "idx_user_identities_user_id" ON "user_identities" ("user_id");
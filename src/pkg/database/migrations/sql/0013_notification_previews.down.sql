@@ -0,0 +1,3 @@
+ALTER TABLE "notification_preferences"
+    DROP COLUMN "filter_profanity",
+    DROP COLUMN "preview_mode";
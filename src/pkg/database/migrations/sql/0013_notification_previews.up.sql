@@ -0,0 +1,4 @@
+ALTER TABLE "notification_preferences"
+    ADD COLUMN "preview_mode" varchar(20) NOT NULL DEFAULT 'full',
+    ADD COLUMN "filter_profanity" boolean NOT NULL DEFAULT true;
+UPDATE "notification_preferences" SET "preview_mode" = 'sender_only' WHERE NOT "show_previews";
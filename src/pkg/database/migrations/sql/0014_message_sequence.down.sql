@@ -0,0 +1,6 @@
+DROP INDEX "idx_messages_conversation_seq";
+ALTER TABLE "messages"
+    DROP COLUMN "seq";
+
+ALTER TABLE "conversations"
+    DROP COLUMN "last_seq";
@@ -0,0 +1,22 @@
+ALTER TABLE "conversations"
+    ADD COLUMN "last_seq" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "messages"
+    ADD COLUMN "seq" bigint;
+
+-- Number existing history in creation order, tombstones included.
+UPDATE "messages" SET "seq" = "numbered"."seq"
+FROM (
+    SELECT "id", row_number() OVER (PARTITION BY "conversation_id" ORDER BY "created_at", "id") AS "seq"
+    FROM "messages"
+) AS "numbered"
+WHERE "messages"."id" = "numbered"."id";
+UPDATE "conversations" SET "last_seq" = "latest"."seq"
+FROM (
+    SELECT "conversation_id", max("seq") AS "seq" FROM "messages" GROUP BY "conversation_id"
+) AS "latest"
+WHERE "conversations"."id" = "latest"."conversation_id";
+
+ALTER TABLE "messages"
+    ALTER COLUMN "seq" SET NOT NULL;
+-- Building the index blocks writes to messages until it is done.
+CREATE UNIQUE INDEX "idx_messages_conversation_seq" ON "messages" ("conversation_id","seq");
@@ -0,0 +1,2 @@
+DROP TABLE "room_note_edits";
+DROP TABLE "room_notes";
@@ -0,0 +1,21 @@
+CREATE TABLE "room_notes" (
+    "conversation_id" uuid,
+    "text" text NOT NULL DEFAULT '',
+    "version" bigint NOT NULL DEFAULT 0,
+    "updated_by_id" uuid,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("conversation_id"),
+    CONSTRAINT "fk_room_notes_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_notes_updated_by" FOREIGN KEY ("updated_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+
+CREATE TABLE "room_note_edits" (
+    "conversation_id" uuid,
+    "version" bigint,
+    "author_id" uuid,
+    "operation" jsonb NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("conversation_id","version"),
+    CONSTRAINT "fk_room_note_edits_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_note_edits_author" FOREIGN KEY ("author_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
@@ -0,0 +1,28 @@
+CREATE TABLE "jobs" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "kind" varchar(50) NOT NULL,
+    "payload" jsonb NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'pending',
+    "attempts" bigint NOT NULL DEFAULT 0,
+    "run_at" timestamptz NOT NULL,
+    "last_error" text,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_jobs_status_run_at" ON "jobs" ("status","run_at");
+
+CREATE TABLE "data_exports" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "size_bytes" bigint NOT NULL DEFAULT 0,
+    "storage_key" varchar(255),
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_data_exports_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_data_exports_storage_key" ON "data_exports" ("storage_key");
+CREATE INDEX "idx_data_exports_user_id" ON "data_exports" ("user_id");
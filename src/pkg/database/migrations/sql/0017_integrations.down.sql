@@ -0,0 +1,4 @@
+DROP TABLE "outgoing_webhooks";
+DROP TABLE "incoming_webhooks";
+DROP TABLE "api_keys";
+DROP TABLE "bots";
@@ -0,0 +1,60 @@
+CREATE TABLE "bots" (
+    "user_id" uuid,
+    "owner_id" uuid NOT NULL,
+    "description" varchar(500),
+    "created_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_bots_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_bots_owner" FOREIGN KEY ("owner_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_bots_owner_id" ON "bots" ("owner_id");
+
+CREATE TABLE "api_keys" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "bot_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "prefix" varchar(16) NOT NULL,
+    "key_hash" varchar(64) NOT NULL,
+    "scopes" varchar(100) NOT NULL,
+    "last_used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_api_keys_bot" FOREIGN KEY ("bot_id") REFERENCES "bots"("user_id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_api_keys_key_hash" ON "api_keys" ("key_hash");
+CREATE INDEX "idx_api_keys_bot_id" ON "api_keys" ("bot_id");
+
+CREATE TABLE "incoming_webhooks" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "bot_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "token_hash" varchar(64) NOT NULL,
+    "created_by_id" uuid,
+    "last_used_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_incoming_webhooks_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_incoming_webhooks_bot" FOREIGN KEY ("bot_id") REFERENCES "bots"("user_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_incoming_webhooks_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_incoming_webhooks_token_hash" ON "incoming_webhooks" ("token_hash");
+CREATE INDEX "idx_incoming_webhooks_bot_id" ON "incoming_webhooks" ("bot_id");
+CREATE INDEX "idx_incoming_webhooks_conversation_id" ON "incoming_webhooks" ("conversation_id");
+
+CREATE TABLE "outgoing_webhooks" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "url" text NOT NULL,
+    "secret" varchar(64) NOT NULL,
+    "trigger_words" text,
+    "created_by_id" uuid,
+    "last_delivered_at" timestamptz,
+    "last_error" text,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_outgoing_webhooks_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_outgoing_webhooks_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_outgoing_webhooks_conversation_id" ON "outgoing_webhooks" ("conversation_id");
@@ -0,0 +1,7 @@
+CREATE TABLE "scheduled_tasks" (
+    "name" varchar(50),
+    "next_run_at" timestamptz NOT NULL,
+    "last_run_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("name")
+);
@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "public_page" boolean NOT NULL DEFAULT false;
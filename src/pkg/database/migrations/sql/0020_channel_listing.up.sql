@@ -0,0 +1 @@
+ALTER TABLE "channels" ADD COLUMN "is_listed" boolean NOT NULL DEFAULT false;
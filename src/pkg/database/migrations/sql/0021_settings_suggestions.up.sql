@@ -0,0 +1,10 @@
+CREATE TABLE "settings_suggestions" (
+    "user_id" uuid,
+    "country_code" varchar(2) NOT NULL,
+    "time_zone" varchar(50),
+    "language" varchar(16) NOT NULL,
+    "room_ids" jsonb,
+    "created_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_settings_suggestions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
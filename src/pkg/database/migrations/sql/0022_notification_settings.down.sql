@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS "conversation_mutes";
+
+ALTER TABLE "notification_preferences" DROP COLUMN "dnd_end";
+ALTER TABLE "notification_preferences" DROP COLUMN "dnd_start";
+ALTER TABLE "notification_preferences" DROP COLUMN "email_enabled";
+ALTER TABLE "notification_preferences" DROP COLUMN "push_enabled";
@@ -0,0 +1,14 @@
+ALTER TABLE "notification_preferences" ADD COLUMN "push_enabled" boolean NOT NULL DEFAULT true;
+ALTER TABLE "notification_preferences" ADD COLUMN "email_enabled" boolean NOT NULL DEFAULT false;
+ALTER TABLE "notification_preferences" ADD COLUMN "dnd_start" varchar(5);
+ALTER TABLE "notification_preferences" ADD COLUMN "dnd_end" varchar(5);
+
+CREATE TABLE "conversation_mutes" (
+    "user_id" uuid,
+    "conversation_id" uuid,
+    "until" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("user_id","conversation_id"),
+    CONSTRAINT "fk_conversation_mutes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_conversation_mutes_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
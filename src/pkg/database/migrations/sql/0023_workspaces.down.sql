@@ -0,0 +1,8 @@
+DELETE FROM "conversations" WHERE "workspace_id" <> '00000000-0000-0000-0000-000000000001';
+ALTER TABLE "conversations" ALTER COLUMN "direct_key" TYPE varchar(73);
+DROP INDEX IF EXISTS "idx_conversations_workspace_id";
+ALTER TABLE "conversations" DROP CONSTRAINT IF EXISTS "fk_conversations_workspace";
+ALTER TABLE "conversations" DROP COLUMN "workspace_id";
+
+DROP TABLE IF EXISTS "workspace_members";
+DROP TABLE IF EXISTS "workspaces";
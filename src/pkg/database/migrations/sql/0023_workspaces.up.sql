@@ -0,0 +1,33 @@
+CREATE TABLE "workspaces" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "slug" varchar(50) NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "created_by_id" uuid,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_workspaces_slug" ON "workspaces" ("slug");
+
+CREATE TABLE "workspace_members" (
+    "workspace_id" uuid,
+    "user_id" uuid,
+    "role" varchar(20) NOT NULL DEFAULT 'member',
+    "joined_at" timestamptz NOT NULL,
+    PRIMARY KEY ("workspace_id","user_id"),
+    CONSTRAINT "fk_workspace_members_workspace" FOREIGN KEY ("workspace_id") REFERENCES "workspaces"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_workspace_members_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_workspace_members_user_id" ON "workspace_members" ("user_id");
+
+-- Existing conversations belong to the default workspace, which everyone
+-- is a member of.
+INSERT INTO "workspaces" ("id", "slug", "name", "created_at", "updated_at")
+VALUES ('00000000-0000-0000-0000-000000000001', 'default', 'AfroChat', now(), now());
+
+ALTER TABLE "conversations" ADD COLUMN "workspace_id" uuid NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001';
+ALTER TABLE "conversations" ALTER COLUMN "workspace_id" DROP DEFAULT;
+ALTER TABLE "conversations" ADD CONSTRAINT "fk_conversations_workspace" FOREIGN KEY ("workspace_id") REFERENCES "workspaces"("id") ON DELETE CASCADE;
+CREATE INDEX "idx_conversations_workspace_id" ON "conversations" ("workspace_id");
+
+ALTER TABLE "conversations" ALTER COLUMN "direct_key" TYPE varchar(110);
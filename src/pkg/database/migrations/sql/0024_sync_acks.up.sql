@@ -0,0 +1,9 @@
+CREATE TABLE "sync_acks" (
+    "session_id" uuid,
+    "conversation_id" uuid,
+    "seq" bigint NOT NULL,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("session_id","conversation_id"),
+    CONSTRAINT "fk_sync_acks_session" FOREIGN KEY ("session_id") REFERENCES "sessions"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_sync_acks_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
@@ -0,0 +1,23 @@
+CREATE TABLE "signed_prekeys" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "device_key_id" uuid NOT NULL,
+    "key_id" bigint NOT NULL,
+    "public_key" bytea NOT NULL,
+    "signature" bytea NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_signed_prekeys_device_key" FOREIGN KEY ("device_key_id") REFERENCES "device_keys"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_signed_prekeys_device_key_id" ON "signed_prekeys" ("device_key_id");
+
+CREATE TABLE "one_time_prekeys" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "device_key_id" uuid NOT NULL,
+    "key_id" bigint NOT NULL,
+    "public_key" bytea NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_one_time_prekeys_device_key" FOREIGN KEY ("device_key_id") REFERENCES "device_keys"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_one_time_prekeys_device_key" ON "one_time_prekeys" ("device_key_id","key_id");
@@ -0,0 +1,3 @@
+ALTER TABLE "conversations" DROP COLUMN "compliance_archived";
+
+DROP TABLE IF EXISTS "workspace_archives";
@@ -0,0 +1,15 @@
+CREATE TABLE "workspace_archives" (
+    "workspace_id" uuid,
+    "url" text NOT NULL,
+    "secret" varchar(64) NOT NULL,
+    "created_by_id" uuid,
+    "last_delivered_at" timestamptz,
+    "last_error" text,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("workspace_id"),
+    CONSTRAINT "fk_workspace_archives_workspace" FOREIGN KEY ("workspace_id") REFERENCES "workspaces"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_workspace_archives_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+
+ALTER TABLE "conversations" ADD COLUMN "compliance_archived" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,11 @@
+CREATE TABLE "welcome_rooms" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "channel_id" uuid NOT NULL,
+    "country_code" varchar(2) NOT NULL DEFAULT '',
+    "created_by_id" uuid,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_welcome_rooms_channel" FOREIGN KEY ("channel_id") REFERENCES "channels"("conversation_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_welcome_rooms_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_welcome_rooms_channel_country" ON "welcome_rooms" ("channel_id","country_code");
@@ -0,0 +1,11 @@
+CREATE TABLE "channel_waitlist" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "channel_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_channel_waitlist_channel" FOREIGN KEY ("channel_id") REFERENCES "channels"("conversation_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_channel_waitlist_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_channel_waitlist_channel_created" ON "channel_waitlist" ("channel_id","created_at");
+CREATE UNIQUE INDEX "idx_channel_waitlist_channel_user" ON "channel_waitlist" ("channel_id","user_id");
@@ -0,0 +1,20 @@
+CREATE TABLE "room_faqs" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "question" varchar(300) NOT NULL,
+    "keywords" text NOT NULL,
+    "answer" text NOT NULL,
+    "enabled" boolean NOT NULL,
+    "auto_post" boolean NOT NULL DEFAULT false,
+    "author_id" uuid,
+    "hits" bigint NOT NULL DEFAULT 0,
+    "auto_posts" bigint NOT NULL DEFAULT 0,
+    "last_hit_at" timestamptz,
+    "last_posted_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_room_faqs_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_faqs_author" FOREIGN KEY ("author_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_room_faqs_conversation_id" ON "room_faqs" ("conversation_id");
@@ -0,0 +1 @@
+ALTER TABLE "users" ADD COLUMN "analytics_opt_in" boolean NOT NULL DEFAULT false;
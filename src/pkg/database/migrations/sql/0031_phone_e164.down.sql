@@ -0,0 +1,8 @@
+-- Numbers saved as written may not fit the old column; keep their
+-- normalized form instead.
+UPDATE "users" SET "phone_number" = "phone_e164" WHERE length("phone_number") > 20;
+ALTER TABLE "users" ALTER COLUMN "phone_number" TYPE varchar(20);
+DROP INDEX "idx_users_phone_e164";
+ALTER TABLE "users"
+    DROP COLUMN "phone_e164",
+    DROP COLUMN "phone_discoverable";
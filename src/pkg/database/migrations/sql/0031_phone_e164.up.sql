@@ -0,0 +1,9 @@
+ALTER TABLE "users" ALTER COLUMN "phone_number" TYPE varchar(32);
+ALTER TABLE "users"
+    ADD COLUMN "phone_e164" varchar(16),
+    ADD COLUMN "phone_discoverable" boolean NOT NULL DEFAULT false;
+CREATE INDEX "idx_users_phone_e164" ON "users" ("phone_e164");
+
+-- Phone numbers could only be saved in E.164 until now, so they are
+-- already normalized.
+UPDATE "users" SET "phone_e164" = "phone_number" WHERE "phone_number" IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "room_export_deliveries";
+DROP TABLE IF EXISTS "room_export_schedules";
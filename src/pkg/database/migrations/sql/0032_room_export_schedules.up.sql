@@ -0,0 +1,43 @@
+CREATE TABLE "room_export_schedules" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "created_by_id" uuid,
+    "destination" varchar(10) NOT NULL,
+    "s3_endpoint" varchar(255),
+    "s3_region" varchar(50),
+    "s3_bucket" varchar(63),
+    "s3_prefix" varchar(255),
+    "s3_access_key" varchar(128),
+    "s3_secret_key" varchar(255),
+    "interval_days" bigint NOT NULL,
+    "enabled" boolean NOT NULL,
+    "next_run_at" timestamptz NOT NULL,
+    "last_run_at" timestamptz,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_room_export_schedules_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_export_schedules_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_room_export_schedules_conversation_id" ON "room_export_schedules" ("conversation_id");
+CREATE INDEX "idx_room_export_schedules_next_run_at" ON "room_export_schedules" ("next_run_at");
+
+CREATE TABLE "room_export_deliveries" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "schedule_id" uuid NOT NULL,
+    "conversation_id" uuid NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "location" text NOT NULL,
+    "messages" bigint NOT NULL DEFAULT 0,
+    "size_bytes" bigint NOT NULL DEFAULT 0,
+    "error" text,
+    "storage_key" varchar(255),
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_room_export_deliveries_schedule" FOREIGN KEY ("schedule_id") REFERENCES "room_export_schedules"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_export_deliveries_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_room_export_deliveries_schedule_id" ON "room_export_deliveries" ("schedule_id");
+CREATE INDEX "idx_room_export_deliveries_conversation_created" ON "room_export_deliveries" ("conversation_id", "created_at");
+CREATE UNIQUE INDEX "idx_room_export_deliveries_storage_key" ON "room_export_deliveries" ("storage_key");
@@ -0,0 +1,16 @@
+CREATE TABLE "room_merges" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "source_id" uuid NOT NULL,
+    "target_id" uuid NOT NULL,
+    "merged_by_id" uuid,
+    "moved_history" boolean NOT NULL,
+    "moved_members" bigint NOT NULL DEFAULT 0,
+    "moved_messages" bigint NOT NULL DEFAULT 0,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_room_merges_source" FOREIGN KEY ("source_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_merges_target" FOREIGN KEY ("target_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_merges_merged_by" FOREIGN KEY ("merged_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_room_merges_source_id" ON "room_merges" ("source_id");
+CREATE INDEX "idx_room_merges_target_id" ON "room_merges" ("target_id");
@@ -0,0 +1,9 @@
+-- Realtime events too large for a Postgres notification, held briefly for
+-- the other instances when REALTIME_BUS=postgres. No model maps it.
+CREATE TABLE "realtime_bus_messages" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "payload" text NOT NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_realtime_bus_messages_created_at" ON "realtime_bus_messages" ("created_at");
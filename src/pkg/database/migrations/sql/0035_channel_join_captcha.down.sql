@@ -0,0 +1 @@
+ALTER TABLE "channels" DROP COLUMN "join_captcha";
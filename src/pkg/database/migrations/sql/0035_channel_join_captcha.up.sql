@@ -0,0 +1 @@
+ALTER TABLE "channels" ADD COLUMN "join_captcha" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,3 @@
+ALTER TABLE "moderation_actions" DROP COLUMN "conversation_id";
+
+ALTER TABLE "channels" DROP COLUMN "adult_only";
@@ -0,0 +1,4 @@
+ALTER TABLE "channels" ADD COLUMN "adult_only" boolean NOT NULL DEFAULT false;
+
+ALTER TABLE "moderation_actions" ADD COLUMN "conversation_id" uuid;
+ALTER TABLE "moderation_actions" ADD CONSTRAINT "fk_moderation_actions_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE SET NULL;
@@ -0,0 +1,19 @@
+CREATE TABLE "room_feeds" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "bot_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "url" text NOT NULL,
+    "seen_guids" jsonb,
+    "created_by_id" uuid,
+    "last_polled_at" timestamptz,
+    "last_error" text,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_room_feeds_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_feeds_bot" FOREIGN KEY ("bot_id") REFERENCES "bots"("user_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_feeds_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_room_feeds_conversation_id" ON "room_feeds" ("conversation_id");
+CREATE INDEX "idx_room_feeds_bot_id" ON "room_feeds" ("bot_id");
+CREATE INDEX "idx_room_feeds_last_polled_at" ON "room_feeds" ("last_polled_at");
@@ -0,0 +1,30 @@
+CREATE TABLE "room_events" (
+    "message_id" uuid NOT NULL,
+    "starts_at" timestamptz NOT NULL,
+    "ends_at" timestamptz,
+    "reminded_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("message_id"),
+    CONSTRAINT "fk_room_events_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_room_events_starts_at" ON "room_events" ("starts_at");
+
+-- Events posted before this table existed
+INSERT INTO "room_events" ("message_id", "starts_at", "ends_at", "created_at")
+SELECT "id", ("payload"->>'starts_at')::timestamptz, ("payload"->>'ends_at')::timestamptz, "created_at"
+FROM "messages"
+WHERE "type" = 'event' AND "payload"->>'starts_at' IS NOT NULL;
+
+CREATE TABLE "event_rsvps" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "message_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "status" varchar(10) NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_event_rsvps_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_event_rsvps_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_event_rsvps_message_user" ON "event_rsvps" ("message_id", "user_id");
+CREATE INDEX "idx_event_rsvps_user_id" ON "event_rsvps" ("user_id");
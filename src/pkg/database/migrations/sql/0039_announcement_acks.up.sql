@@ -0,0 +1,11 @@
+CREATE TABLE "announcement_acks" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "message_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_announcement_acks_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_announcement_acks_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_announcement_acks_message_user" ON "announcement_acks" ("message_id", "user_id");
+CREATE INDEX "idx_announcement_acks_user_id" ON "announcement_acks" ("user_id");
@@ -0,0 +1 @@
+ALTER TABLE "room_export_schedules" ALTER COLUMN "s3_secret_key" TYPE varchar(255);
@@ -0,0 +1,3 @@
+-- Sealed secret keys are longer than the keys themselves. Keys stored in
+-- the clear are sealed by the next export using them.
+ALTER TABLE "room_export_schedules" ALTER COLUMN "s3_secret_key" TYPE text;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "conversation_labelings";
+DROP TABLE IF EXISTS "conversation_labels";
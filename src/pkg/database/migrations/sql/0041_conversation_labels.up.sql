@@ -0,0 +1,21 @@
+CREATE TABLE "conversation_labels" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "business_id" uuid NOT NULL,
+    "name" varchar(50) NOT NULL,
+    "color" varchar(7) NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_conversation_labels_business" FOREIGN KEY ("business_id") REFERENCES "business_profiles"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_conversation_labels_business_name" ON "conversation_labels" ("business_id", "name");
+
+CREATE TABLE "conversation_labelings" (
+    "label_id" uuid NOT NULL,
+    "conversation_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("label_id", "conversation_id"),
+    CONSTRAINT "fk_conversation_labelings_label" FOREIGN KEY ("label_id") REFERENCES "conversation_labels"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_conversation_labelings_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_conversation_labelings_conversation_id" ON "conversation_labelings" ("conversation_id");
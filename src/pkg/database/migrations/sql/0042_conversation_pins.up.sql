@@ -0,0 +1,11 @@
+CREATE TABLE "conversation_pins" (
+    "user_id" uuid NOT NULL,
+    "conversation_id" uuid NOT NULL,
+    "position" bigint NOT NULL DEFAULT 0,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("user_id", "conversation_id"),
+    CONSTRAINT "fk_conversation_pins_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_conversation_pins_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_conversation_pins_conversation_id" ON "conversation_pins" ("conversation_id");
@@ -0,0 +1,32 @@
+CREATE TABLE "referral_codes" (
+    "user_id" uuid NOT NULL,
+    "code" varchar(16) NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("user_id"),
+    CONSTRAINT "fk_referral_codes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_referral_codes_code" ON "referral_codes" ("code");
+
+CREATE TABLE "referrals" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "referrer_id" uuid NOT NULL,
+    "referred_id" uuid NOT NULL,
+    "created_at" timestamptz,
+    "qualified_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_referrals_referrer" FOREIGN KEY ("referrer_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_referrals_referred" FOREIGN KEY ("referred_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_referrals_referrer_id" ON "referrals" ("referrer_id");
+CREATE UNIQUE INDEX "idx_referrals_referred_id" ON "referrals" ("referred_id");
+CREATE INDEX "idx_referrals_qualified_at" ON "referrals" ("qualified_at");
+
+CREATE TABLE "referral_rewards" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "referrer_id" uuid NOT NULL,
+    "milestone" bigint NOT NULL,
+    "reached_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_referral_rewards_referrer" FOREIGN KEY ("referrer_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_referral_rewards_referrer_milestone" ON "referral_rewards" ("referrer_id", "milestone");
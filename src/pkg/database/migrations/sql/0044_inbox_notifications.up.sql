@@ -0,0 +1,19 @@
+CREATE TABLE "inbox_notifications" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "kind" varchar(30) NOT NULL,
+    "title" varchar(200) NOT NULL,
+    "body" varchar(500) NOT NULL,
+    "actor_id" uuid,
+    "conversation_id" uuid,
+    "message_id" uuid,
+    "read_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_inbox_notifications_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_inbox_notifications_actor" FOREIGN KEY ("actor_id") REFERENCES "users"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_inbox_notifications_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_inbox_notifications_user" ON "inbox_notifications" ("user_id", "created_at");
+CREATE INDEX "idx_inbox_notifications_actor_id" ON "inbox_notifications" ("actor_id");
+CREATE INDEX "idx_inbox_notifications_conversation_id" ON "inbox_notifications" ("conversation_id");
@@ -0,0 +1 @@
+ALTER TABLE "messages" ADD COLUMN "shadowed" boolean NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+ALTER TABLE "users" ADD COLUMN "home_region" varchar(32) DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS "idx_users_home_region" ON "users" ("home_region");
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "idx_users_home_region";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "home_region";
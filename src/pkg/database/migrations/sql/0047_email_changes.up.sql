@@ -0,0 +1,17 @@
+CREATE TABLE "email_changes" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "old_email" varchar(255) NOT NULL,
+    "new_email" varchar(255) NOT NULL,
+    "old_confirmed_at" timestamptz,
+    "new_confirmed_at" timestamptz,
+    "completed_at" timestamptz,
+    "revertible_until" timestamptz,
+    "reverted_at" timestamptz,
+    "cancelled_at" timestamptz,
+    "created_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_email_changes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_email_changes_user_id" ON "email_changes" ("user_id");
@@ -0,0 +1,26 @@
+CREATE TABLE "account_erasures" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "data_erased_at" timestamptz,
+    "files_deleted" bigint NOT NULL DEFAULT 0,
+    "files_failed" bigint NOT NULL DEFAULT 0,
+    "files_deleted_at" timestamptz,
+    "search_entries_removed" bigint NOT NULL DEFAULT 0,
+    "search_purged_at" timestamptz,
+    "caches_purged_at" timestamptz,
+    "analytics_subject" varchar(64) NOT NULL DEFAULT '',
+    "analytics_purged_at" timestamptz,
+    "attempts" bigint NOT NULL DEFAULT 0,
+    "last_error" varchar(500) NOT NULL DEFAULT '',
+    "requested_at" timestamptz NOT NULL,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_account_erasures_user_id" ON "account_erasures" ("user_id");
+CREATE INDEX "idx_account_erasures_requested_at" ON "account_erasures" ("requested_at");
+
+-- Accounts erased before erasures were recorded are listed as requested
+-- when they were deleted, so restoring an older backup erases them too.
+INSERT INTO "account_erasures" ("user_id", "data_erased_at", "requested_at", "completed_at")
+SELECT "id", "deleted_at", "deleted_at", "deleted_at" FROM "users"
+WHERE "deleted_at" IS NOT NULL AND "email" LIKE 'erased-%@invalid';
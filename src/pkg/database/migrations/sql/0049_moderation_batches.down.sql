@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "reports";
+DROP INDEX IF EXISTS "idx_moderation_actions_batch_id";
+ALTER TABLE "moderation_actions" DROP CONSTRAINT IF EXISTS "fk_moderation_actions_batch";
+ALTER TABLE "moderation_actions" DROP COLUMN IF EXISTS "batch_id";
+DROP TABLE IF EXISTS "moderation_batches";
+DROP TABLE IF EXISTS "moderation_templates";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "muted_until";
@@ -0,0 +1,63 @@
+ALTER TABLE "users" ADD COLUMN "muted_until" timestamptz;
+
+CREATE TABLE "moderation_templates" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "name" varchar(100) NOT NULL,
+    "action" varchar(20) NOT NULL,
+    "warn_text" text NOT NULL DEFAULT '',
+    "duration_seconds" bigint NOT NULL DEFAULT 0,
+    "created_by_id" uuid,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_moderation_templates_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_moderation_templates_name" ON "moderation_templates" ("name");
+
+CREATE TABLE "moderation_batches" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "actor_id" uuid,
+    "template_id" uuid,
+    "action" varchar(20) NOT NULL,
+    "reason" text NOT NULL,
+    "warn_text" text NOT NULL DEFAULT '',
+    "until" timestamptz,
+    "undoable_until" timestamptz NOT NULL,
+    "undone_at" timestamptz,
+    "undone_by_id" uuid,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_moderation_batches_actor" FOREIGN KEY ("actor_id") REFERENCES "users"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_moderation_batches_template" FOREIGN KEY ("template_id") REFERENCES "moderation_templates"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_moderation_batches_undone_by" FOREIGN KEY ("undone_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_moderation_batches_actor_id" ON "moderation_batches" ("actor_id");
+CREATE INDEX "idx_moderation_batches_created_at" ON "moderation_batches" ("created_at");
+
+ALTER TABLE "moderation_actions" ADD COLUMN "batch_id" uuid;
+ALTER TABLE "moderation_actions" ADD CONSTRAINT "fk_moderation_actions_batch" FOREIGN KEY ("batch_id") REFERENCES "moderation_batches"("id") ON DELETE SET NULL;
+CREATE INDEX "idx_moderation_actions_batch_id" ON "moderation_actions" ("batch_id");
+
+CREATE TABLE "reports" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "reporter_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "message_id" uuid,
+    "reason" text NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'open',
+    "batch_id" uuid,
+    "resolved_by_id" uuid,
+    "resolved_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_reports_reporter" FOREIGN KEY ("reporter_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_reports_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_reports_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_reports_batch" FOREIGN KEY ("batch_id") REFERENCES "moderation_batches"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_reports_resolved_by" FOREIGN KEY ("resolved_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_reports_reporter_id" ON "reports" ("reporter_id");
+CREATE INDEX "idx_reports_user_id" ON "reports" ("user_id");
+CREATE INDEX "idx_reports_message_id" ON "reports" ("message_id");
+CREATE INDEX "idx_reports_batch_id" ON "reports" ("batch_id");
+CREATE INDEX "idx_reports_status_created" ON "reports" ("status", "created_at");
@@ -0,0 +1,14 @@
+CREATE TABLE "strikes" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "actor_id" uuid,
+    "reason" text NOT NULL,
+    "suspension_id" uuid,
+    "created_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_strikes_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_strikes_actor" FOREIGN KEY ("actor_id") REFERENCES "users"("id") ON DELETE SET NULL,
+    CONSTRAINT "fk_strikes_suspension" FOREIGN KEY ("suspension_id") REFERENCES "moderation_actions"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_strikes_user_expires" ON "strikes" ("user_id", "expires_at");
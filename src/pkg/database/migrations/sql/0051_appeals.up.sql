@@ -0,0 +1,18 @@
+CREATE TABLE "appeals" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "action_id" uuid NOT NULL,
+    "statement" text NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'pending',
+    "reviewer_id" uuid,
+    "note" text NOT NULL DEFAULT '',
+    "decided_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_appeals_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_appeals_action" FOREIGN KEY ("action_id") REFERENCES "moderation_actions"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_appeals_reviewer" FOREIGN KEY ("reviewer_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_appeals_user_id" ON "appeals" ("user_id");
+CREATE UNIQUE INDEX "idx_appeals_action_id" ON "appeals" ("action_id");
+CREATE INDEX "idx_appeals_status_created" ON "appeals" ("status", "created_at");
@@ -0,0 +1,2 @@
+ALTER TABLE "attachments" ADD COLUMN "encrypted" boolean NOT NULL DEFAULT false;
+ALTER TABLE "attachments" ADD COLUMN "metadata" bytea;
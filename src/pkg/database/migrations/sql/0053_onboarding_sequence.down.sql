@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "onboarding_progress";
+DROP TABLE IF EXISTS "onboarding_steps";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "onboarding_messages";
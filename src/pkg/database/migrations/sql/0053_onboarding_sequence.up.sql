@@ -0,0 +1,28 @@
+ALTER TABLE "users" ADD COLUMN "onboarding_messages" boolean NOT NULL DEFAULT true;
+
+CREATE TABLE "onboarding_steps" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "key" varchar(50) NOT NULL,
+    "text" text NOT NULL,
+    "delay_seconds" bigint NOT NULL,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_onboarding_steps_key" ON "onboarding_steps" ("key");
+
+CREATE TABLE "onboarding_progress" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "step_id" uuid NOT NULL,
+    "message_id" uuid,
+    "sent_at" timestamptz,
+    "completed_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_onboarding_progress_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_onboarding_progress_step" FOREIGN KEY ("step_id") REFERENCES "onboarding_steps"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_onboarding_progress_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_onboarding_progress_user_step" ON "onboarding_progress" ("user_id", "step_id");
+CREATE INDEX "idx_onboarding_progress_step_id" ON "onboarding_progress" ("step_id");
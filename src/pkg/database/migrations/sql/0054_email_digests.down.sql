@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "idx_notification_preferences_email_digest";
+ALTER TABLE "notification_preferences" DROP COLUMN IF EXISTS "digest_sent_at";
+ALTER TABLE "notification_preferences" DROP COLUMN IF EXISTS "email_digest";
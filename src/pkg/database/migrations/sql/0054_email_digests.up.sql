@@ -0,0 +1,3 @@
+ALTER TABLE "notification_preferences" ADD COLUMN "email_digest" boolean NOT NULL DEFAULT false;
+ALTER TABLE "notification_preferences" ADD COLUMN "digest_sent_at" timestamptz;
+CREATE INDEX "idx_notification_preferences_email_digest" ON "notification_preferences" ("email_digest");
@@ -0,0 +1,2 @@
+DELETE FROM "device_tokens" WHERE "platform" = 'web';
+ALTER TABLE "device_tokens" ALTER COLUMN "token" TYPE varchar(512);
@@ -0,0 +1 @@
+ALTER TABLE "device_tokens" ALTER COLUMN "token" TYPE varchar(1024);
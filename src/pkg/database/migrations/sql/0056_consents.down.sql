@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "consent_events";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "marketing_emails";
@@ -0,0 +1,12 @@
+ALTER TABLE "users" ADD COLUMN "marketing_emails" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "consent_events" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "purpose" varchar(30) NOT NULL,
+    "granted" boolean NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_consent_events_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_consent_events_user_created" ON "consent_events" ("user_id", "created_at");
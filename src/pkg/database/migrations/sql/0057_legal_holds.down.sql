@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "legal_audit_entries";
+DROP FUNCTION IF EXISTS "legal_audit_entries_append_only"();
+DROP TABLE IF EXISTS "legal_exports";
+DROP TABLE IF EXISTS "preserved_messages";
+DROP TABLE IF EXISTS "legal_holds";
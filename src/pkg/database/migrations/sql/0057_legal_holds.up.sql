@@ -0,0 +1,76 @@
+CREATE TABLE "legal_holds" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "reference" varchar(100) NOT NULL,
+    "reason" text NOT NULL,
+    "user_id" uuid,
+    "conversation_id" uuid,
+    "placed_by_id" uuid NOT NULL,
+    "released_at" timestamptz,
+    "released_by_id" uuid,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_legal_holds_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE RESTRICT,
+    CONSTRAINT "fk_legal_holds_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE RESTRICT,
+    CONSTRAINT "chk_legal_holds_subject" CHECK (("user_id" IS NULL) <> ("conversation_id" IS NULL))
+);
+CREATE INDEX "idx_legal_holds_user_id" ON "legal_holds" ("user_id");
+CREATE INDEX "idx_legal_holds_conversation_id" ON "legal_holds" ("conversation_id");
+CREATE INDEX "idx_legal_holds_released_at" ON "legal_holds" ("released_at");
+
+CREATE TABLE "preserved_messages" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "message_id" uuid NOT NULL,
+    "conversation_id" uuid NOT NULL,
+    "sender_id" uuid NOT NULL,
+    "type" varchar(20) NOT NULL,
+    "text" text,
+    "entities" jsonb,
+    "payload" jsonb,
+    "edits" jsonb,
+    "sent_at" timestamptz NOT NULL,
+    "preserved_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id")
+);
+CREATE UNIQUE INDEX "idx_preserved_messages_message_id" ON "preserved_messages" ("message_id");
+CREATE INDEX "idx_preserved_messages_conversation_id" ON "preserved_messages" ("conversation_id");
+CREATE INDEX "idx_preserved_messages_sender_id" ON "preserved_messages" ("sender_id");
+
+CREATE TABLE "legal_exports" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "hold_id" uuid NOT NULL,
+    "requested_by_id" uuid NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "size_bytes" bigint NOT NULL DEFAULT 0,
+    "storage_key" varchar(255),
+    "created_at" timestamptz,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_legal_exports_hold" FOREIGN KEY ("hold_id") REFERENCES "legal_holds"("id") ON DELETE RESTRICT
+);
+CREATE INDEX "idx_legal_exports_hold_id" ON "legal_exports" ("hold_id");
+CREATE UNIQUE INDEX "idx_legal_exports_storage_key" ON "legal_exports" ("storage_key");
+
+CREATE TABLE "legal_audit_entries" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "actor_id" uuid NOT NULL,
+    "action" varchar(30) NOT NULL,
+    "hold_id" uuid,
+    "export_id" uuid,
+    "ip_address" varchar(45) NOT NULL,
+    "detail" text NOT NULL DEFAULT '',
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_legal_audit_entries_actor_id" ON "legal_audit_entries" ("actor_id");
+CREATE INDEX "idx_legal_audit_entries_hold_id" ON "legal_audit_entries" ("hold_id");
+CREATE INDEX "idx_legal_audit_entries_created_at" ON "legal_audit_entries" ("created_at");
+
+-- The audit log is only ever appended to.
+CREATE FUNCTION "legal_audit_entries_append_only"() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'the legal audit log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+CREATE TRIGGER "legal_audit_entries_append_only"
+    BEFORE UPDATE OR DELETE ON "legal_audit_entries"
+    FOR EACH ROW EXECUTE FUNCTION "legal_audit_entries_append_only"();
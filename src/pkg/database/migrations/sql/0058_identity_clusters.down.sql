@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "identity_cluster_members";
+DROP TABLE IF EXISTS "identity_clusters";
+DROP TABLE IF EXISTS "identity_signals";
@@ -0,0 +1,33 @@
+CREATE TABLE "identity_signals" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "user_id" uuid NOT NULL,
+    "kind" varchar(20) NOT NULL,
+    "value" varchar(64) NOT NULL,
+    "first_seen_at" timestamptz NOT NULL,
+    "last_seen_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_identity_signals_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_identity_signals_user_kind_value" ON "identity_signals" ("user_id", "kind", "value");
+CREATE INDEX "idx_identity_signals_kind_value" ON "identity_signals" ("kind", "value");
+CREATE INDEX "idx_identity_signals_last_seen_at" ON "identity_signals" ("last_seen_at");
+
+CREATE TABLE "identity_clusters" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "member_count" bigint NOT NULL,
+    "banned_count" bigint NOT NULL,
+    "newest_member_at" timestamptz NOT NULL,
+    "computed_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_identity_clusters_newest_member_at" ON "identity_clusters" ("newest_member_at");
+
+CREATE TABLE "identity_cluster_members" (
+    "cluster_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "banned" boolean NOT NULL,
+    PRIMARY KEY ("cluster_id", "user_id"),
+    CONSTRAINT "fk_identity_clusters_members" FOREIGN KEY ("cluster_id") REFERENCES "identity_clusters"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_identity_cluster_members_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_identity_cluster_members_user_id" ON "identity_cluster_members" ("user_id");
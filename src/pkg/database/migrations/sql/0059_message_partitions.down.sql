@@ -0,0 +1,73 @@
+-- Fails if resends or races left duplicates the unpartitioned table's
+-- unique indexes reject.
+ALTER TABLE "messages" RENAME TO "messages_partitioned";
+DROP TRIGGER IF EXISTS "messages_release_references" ON "messages_partitioned";
+DROP FUNCTION IF EXISTS "messages_release_references"();
+DROP FUNCTION IF EXISTS "release_message_references"(uuid[]);
+DROP TABLE IF EXISTS "message_client_ids";
+DROP INDEX IF EXISTS "idx_messages_deleted_at";
+DROP INDEX IF EXISTS "idx_messages_sender_client";
+DROP INDEX IF EXISTS "idx_messages_sender_id";
+DROP INDEX IF EXISTS "idx_messages_conversation_history";
+DROP INDEX IF EXISTS "idx_messages_conversation_seq";
+DROP INDEX IF EXISTS "idx_messages_audit_chain";
+DROP INDEX IF EXISTS "idx_messages_search_vector";
+ALTER TABLE "messages_partitioned" DROP CONSTRAINT "fk_messages_conversation";
+ALTER TABLE "messages_partitioned" DROP CONSTRAINT "fk_messages_sender";
+
+CREATE TABLE "messages" (
+    "id" uuid,
+    "conversation_id" uuid NOT NULL,
+    "seq" bigint NOT NULL,
+    "sender_id" uuid NOT NULL,
+    "client_id" varchar(64),
+    "type" varchar(20) NOT NULL DEFAULT 'text',
+    "text" text,
+    "entities" jsonb,
+    "payload" jsonb,
+    "shadowed" boolean NOT NULL DEFAULT false,
+    "audit_seq" bigint,
+    "audit_prev_hash" bytea,
+    "audit_hash" bytea,
+    "search_vector" tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce("text", ''))) STORED,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    "edited_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_messages_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_messages_sender" FOREIGN KEY ("sender_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+INSERT INTO "messages" (
+    "id", "conversation_id", "seq", "sender_id", "client_id", "type", "text", "entities", "payload", "shadowed",
+    "audit_seq", "audit_prev_hash", "audit_hash", "created_at", "updated_at", "edited_at", "deleted_at"
+)
+SELECT
+    "id", "conversation_id", "seq", "sender_id", "client_id", "type", "text", "entities", "payload", "shadowed",
+    "audit_seq", "audit_prev_hash", "audit_hash", "created_at", "updated_at", "edited_at", "deleted_at"
+FROM "messages_partitioned";
+DROP TABLE "messages_partitioned";
+
+CREATE INDEX "idx_messages_deleted_at" ON "messages" ("deleted_at");
+CREATE UNIQUE INDEX "idx_messages_sender_client" ON "messages" ("sender_id","client_id");
+CREATE INDEX "idx_messages_sender_id" ON "messages" ("sender_id");
+CREATE INDEX "idx_messages_conversation_history" ON "messages" ("conversation_id","created_at","id");
+CREATE UNIQUE INDEX "idx_messages_conversation_seq" ON "messages" ("conversation_id","seq");
+CREATE UNIQUE INDEX "idx_messages_audit_chain" ON "messages" ("conversation_id","audit_seq");
+CREATE INDEX "idx_messages_search_vector" ON "messages" USING GIN ("search_vector");
+
+-- References left dangling while they were unchecked are cleared first.
+UPDATE "attachments" SET "message_id" = NULL WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+DELETE FROM "message_edits" WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+DELETE FROM "room_events" WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+DELETE FROM "event_rsvps" WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+DELETE FROM "announcement_acks" WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+UPDATE "reports" SET "message_id" = NULL WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+UPDATE "onboarding_progress" SET "message_id" = NULL WHERE "message_id" NOT IN (SELECT "id" FROM "messages");
+ALTER TABLE "attachments" ADD CONSTRAINT "fk_messages_attachments" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL;
+ALTER TABLE "message_edits" ADD CONSTRAINT "fk_message_edits_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE;
+ALTER TABLE "room_events" ADD CONSTRAINT "fk_room_events_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE;
+ALTER TABLE "event_rsvps" ADD CONSTRAINT "fk_event_rsvps_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE;
+ALTER TABLE "announcement_acks" ADD CONSTRAINT "fk_announcement_acks_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE CASCADE;
+ALTER TABLE "reports" ADD CONSTRAINT "fk_reports_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL;
+ALTER TABLE "onboarding_progress" ADD CONSTRAINT "fk_onboarding_progress_message" FOREIGN KEY ("message_id") REFERENCES "messages"("id") ON DELETE SET NULL;
@@ -0,0 +1,124 @@
+-- Partition messages by month of creation, so old months can be dropped
+-- whole and history queries only touch the months they read. The table is
+-- rebuilt, holding an exclusive lock on messages until it is done.
+--
+-- A partitioned table can only enforce uniqueness over columns that
+-- include created_at:
+--   - the primary key becomes (id, created_at);
+--   - sequence numbers and audit chains stay unique through the
+--     conversation lock they are assigned under, and are indexed only;
+--   - resends are de-duplicated by message_client_ids instead;
+--   - foreign keys to messages(id) are replaced by the
+--     release_message_references() trigger below.
+ALTER TABLE "messages" RENAME TO "messages_unpartitioned";
+
+ALTER TABLE "attachments" DROP CONSTRAINT "fk_messages_attachments";
+ALTER TABLE "message_edits" DROP CONSTRAINT "fk_message_edits_message";
+ALTER TABLE "room_events" DROP CONSTRAINT "fk_room_events_message";
+ALTER TABLE "event_rsvps" DROP CONSTRAINT "fk_event_rsvps_message";
+ALTER TABLE "announcement_acks" DROP CONSTRAINT "fk_announcement_acks_message";
+ALTER TABLE "reports" DROP CONSTRAINT "fk_reports_message";
+ALTER TABLE "onboarding_progress" DROP CONSTRAINT "fk_onboarding_progress_message";
+
+UPDATE "messages_unpartitioned" SET "created_at" = coalesce("updated_at", now()) WHERE "created_at" IS NULL;
+
+CREATE TABLE "messages" (
+    "id" uuid NOT NULL,
+    "conversation_id" uuid NOT NULL,
+    "seq" bigint NOT NULL,
+    "sender_id" uuid NOT NULL,
+    "client_id" varchar(64),
+    "type" varchar(20) NOT NULL DEFAULT 'text',
+    "text" text,
+    "entities" jsonb,
+    "payload" jsonb,
+    "shadowed" boolean NOT NULL DEFAULT false,
+    "audit_seq" bigint,
+    "audit_prev_hash" bytea,
+    "audit_hash" bytea,
+    "search_vector" tsvector GENERATED ALWAYS AS (to_tsvector('simple', coalesce("text", ''))) STORED,
+    "created_at" timestamptz NOT NULL,
+    "updated_at" timestamptz,
+    "edited_at" timestamptz,
+    "deleted_at" timestamptz,
+    PRIMARY KEY ("id", "created_at"),
+    CONSTRAINT "fk_messages_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_messages_sender" FOREIGN KEY ("sender_id") REFERENCES "users"("id") ON DELETE CASCADE
+) PARTITION BY RANGE ("created_at");
+
+-- One partition per UTC month from the oldest message to three months
+-- ahead; the maintenance job keeps creating them from there. Messages
+-- falling outside every month land in the default partition.
+DO $$
+DECLARE
+    bound timestamp;
+BEGIN
+    SELECT date_trunc('month', coalesce(min("created_at"), now()) AT TIME ZONE 'UTC')
+    INTO bound FROM "messages_unpartitioned";
+    WHILE bound <= date_trunc('month', now() AT TIME ZONE 'UTC') + interval '3 months' LOOP
+        EXECUTE format('CREATE TABLE %I PARTITION OF "messages" FOR VALUES FROM (%L) TO (%L)',
+            'messages_' || to_char(bound, 'YYYY_MM'),
+            bound AT TIME ZONE 'UTC',
+            (bound + interval '1 month') AT TIME ZONE 'UTC');
+        bound := bound + interval '1 month';
+    END LOOP;
+END
+$$;
+CREATE TABLE "messages_default" PARTITION OF "messages" DEFAULT;
+
+INSERT INTO "messages" (
+    "id", "conversation_id", "seq", "sender_id", "client_id", "type", "text", "entities", "payload", "shadowed",
+    "audit_seq", "audit_prev_hash", "audit_hash", "created_at", "updated_at", "edited_at", "deleted_at"
+)
+SELECT
+    "id", "conversation_id", "seq", "sender_id", "client_id", "type", "text", "entities", "payload", "shadowed",
+    "audit_seq", "audit_prev_hash", "audit_hash", "created_at", "updated_at", "edited_at", "deleted_at"
+FROM "messages_unpartitioned";
+
+DROP TABLE "messages_unpartitioned";
+
+CREATE INDEX "idx_messages_deleted_at" ON "messages" ("deleted_at");
+CREATE INDEX "idx_messages_sender_client" ON "messages" ("sender_id","client_id");
+CREATE INDEX "idx_messages_sender_id" ON "messages" ("sender_id");
+CREATE INDEX "idx_messages_conversation_history" ON "messages" ("conversation_id","created_at","id");
+CREATE INDEX "idx_messages_conversation_seq" ON "messages" ("conversation_id","seq");
+CREATE INDEX "idx_messages_audit_chain" ON "messages" ("conversation_id","audit_seq");
+CREATE INDEX "idx_messages_search_vector" ON "messages" USING GIN ("search_vector");
+
+CREATE TABLE "message_client_ids" (
+    "sender_id" uuid NOT NULL,
+    "client_id" varchar(64) NOT NULL,
+    "message_id" uuid NOT NULL,
+    PRIMARY KEY ("sender_id", "client_id"),
+    CONSTRAINT "fk_message_client_ids_sender" FOREIGN KEY ("sender_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_message_client_ids_message_id" ON "message_client_ids" ("message_id");
+INSERT INTO "message_client_ids" ("sender_id", "client_id", "message_id")
+SELECT "sender_id", "client_id", "id" FROM "messages" WHERE "client_id" IS NOT NULL;
+
+-- What the foreign keys to messages did on delete, for the messages given.
+-- Deleting a message row runs it; dropping a partition runs it for the
+-- partition's messages first.
+CREATE FUNCTION "release_message_references"("ids" uuid[]) RETURNS void AS $$
+BEGIN
+    UPDATE "attachments" SET "message_id" = NULL WHERE "message_id" = ANY("ids");
+    DELETE FROM "message_edits" WHERE "message_id" = ANY("ids");
+    DELETE FROM "room_events" WHERE "message_id" = ANY("ids");
+    DELETE FROM "event_rsvps" WHERE "message_id" = ANY("ids");
+    DELETE FROM "announcement_acks" WHERE "message_id" = ANY("ids");
+    UPDATE "reports" SET "message_id" = NULL WHERE "message_id" = ANY("ids");
+    UPDATE "onboarding_progress" SET "message_id" = NULL WHERE "message_id" = ANY("ids");
+    DELETE FROM "message_client_ids" WHERE "message_id" = ANY("ids");
+END
+$$ LANGUAGE plpgsql;
+
+CREATE FUNCTION "messages_release_references"() RETURNS trigger AS $$
+BEGIN
+    PERFORM "release_message_references"(ARRAY[OLD."id"]);
+    RETURN OLD;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "messages_release_references"
+    AFTER DELETE ON "messages"
+    FOR EACH ROW EXECUTE FUNCTION "messages_release_references"();
@@ -0,0 +1,5 @@
+-- Content still archived is lost from the database: restore it before
+-- rolling back.
+DROP INDEX IF EXISTS "idx_messages_archive_segment_id";
+ALTER TABLE "messages" DROP COLUMN IF EXISTS "archive_segment_id";
+DROP TABLE IF EXISTS "message_archive_segments";
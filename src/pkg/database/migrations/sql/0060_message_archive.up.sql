@@ -0,0 +1,17 @@
+CREATE TABLE "message_archive_segments" (
+    "id" uuid,
+    "conversation_id" uuid NOT NULL,
+    "first_at" timestamptz NOT NULL,
+    "last_at" timestamptz NOT NULL,
+    "message_count" bigint NOT NULL,
+    "size_bytes" bigint NOT NULL,
+    "storage_key" varchar(255) NOT NULL,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id")
+);
+CREATE INDEX "idx_message_archive_segments_conversation_id" ON "message_archive_segments" ("conversation_id");
+CREATE UNIQUE INDEX "idx_message_archive_segments_storage_key" ON "message_archive_segments" ("storage_key");
+
+-- Adding a nullable column leaves the partitions' rows as they are.
+ALTER TABLE "messages" ADD COLUMN "archive_segment_id" uuid;
+CREATE INDEX "idx_messages_archive_segment_id" ON "messages" ("archive_segment_id");
@@ -0,0 +1,2 @@
+ALTER TABLE "conversation_members" DROP COLUMN IF EXISTS "unread_count";
+ALTER TABLE "conversations" DROP COLUMN IF EXISTS "member_count";
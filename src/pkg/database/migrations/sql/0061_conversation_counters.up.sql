@@ -0,0 +1,25 @@
+-- Counters conversation lists read instead of counting members and unread
+-- messages, kept up to date by the code that changes them and
+-- reconciled daily.
+ALTER TABLE "conversations" ADD COLUMN "member_count" bigint NOT NULL DEFAULT 0;
+ALTER TABLE "conversation_members" ADD COLUMN "unread_count" bigint NOT NULL DEFAULT 0;
+
+UPDATE "conversations" SET "member_count" = (
+    SELECT COUNT(*) FROM "conversation_members"
+    WHERE "conversation_members"."conversation_id" = "conversations"."id"
+    AND "conversation_members"."deleted_at" IS NULL
+);
+
+UPDATE "conversation_members" SET "unread_count" = (
+    SELECT COUNT(*) FROM "messages"
+    WHERE "messages"."conversation_id" = "conversation_members"."conversation_id"
+    AND "messages"."sender_id" <> "conversation_members"."user_id"
+    AND "messages"."deleted_at" IS NULL AND NOT "messages"."shadowed"
+    AND "messages"."seq" > COALESCE((
+        SELECT "read_messages"."seq" FROM "message_receipts"
+        JOIN "messages" "read_messages" ON "read_messages"."id" = "message_receipts"."read_message_id"
+        WHERE "message_receipts"."conversation_id" = "conversation_members"."conversation_id"
+        AND "message_receipts"."user_id" = "conversation_members"."user_id"
+    ), 0)
+)
+WHERE "deleted_at" IS NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE "conversation_members" ADD COLUMN "unread_count" bigint NOT NULL DEFAULT 0;
+UPDATE "conversation_members" m SET "unread_count" = s."unread_count"
+FROM "conversation_summaries" s
+WHERE s."user_id" = m."user_id" AND s."conversation_id" = m."conversation_id";
+DROP TABLE IF EXISTS "conversation_summaries";
@@ -0,0 +1,49 @@
+-- One row per member of a conversation with what their inbox shows of it,
+-- read in a single query on idx_conversation_summaries_inbox. Unread
+-- counts move here from conversation_members.
+CREATE TABLE "conversation_summaries" (
+    "user_id" uuid,
+    "conversation_id" uuid,
+    "workspace_id" uuid NOT NULL,
+    "kind" varchar(20) NOT NULL,
+    "title" varchar(100),
+    "last_message_id" uuid,
+    "last_sender_id" uuid,
+    "last_message_type" varchar(20),
+    "last_message_preview" text NOT NULL DEFAULT '',
+    "last_activity_at" timestamptz NOT NULL,
+    "unread_count" bigint NOT NULL DEFAULT 0,
+    "muted" boolean NOT NULL DEFAULT false,
+    "muted_until" timestamptz,
+    "pinned" boolean NOT NULL DEFAULT false,
+    "pin_position" bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY ("user_id", "conversation_id"),
+    CONSTRAINT "fk_conversation_summaries_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_conversation_summaries_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_conversation_summaries_conversation_id" ON "conversation_summaries" ("conversation_id");
+CREATE INDEX "idx_conversation_summaries_inbox" ON "conversation_summaries"
+    ("user_id", "workspace_id", "pinned" DESC, "pin_position", "last_activity_at" DESC, "conversation_id" DESC);
+
+INSERT INTO "conversation_summaries" (
+    "user_id", "conversation_id", "workspace_id", "kind", "title",
+    "last_message_id", "last_sender_id", "last_message_type", "last_message_preview", "last_activity_at",
+    "unread_count", "muted", "muted_until", "pinned", "pin_position"
+)
+SELECT
+    m."user_id", c."id", c."workspace_id", c."kind", c."title",
+    last."id", last."sender_id", last."type",
+    CASE WHEN last."type" = 'encrypted' OR last."deleted_at" IS NOT NULL THEN ''
+        ELSE left(btrim(regexp_replace(coalesce(last."text", ''), '\s+', ' ', 'g')), 140) END,
+    coalesce(last."created_at", c."created_at"),
+    m."unread_count",
+    mute."user_id" IS NOT NULL, mute."until",
+    pin."user_id" IS NOT NULL, coalesce(pin."position", 0)
+FROM "conversation_members" m
+JOIN "conversations" c ON c."id" = m."conversation_id" AND c."deleted_at" IS NULL
+LEFT JOIN "messages" last ON last."conversation_id" = c."id" AND last."seq" = c."last_seq" AND NOT last."shadowed"
+LEFT JOIN "conversation_mutes" mute ON mute."user_id" = m."user_id" AND mute."conversation_id" = c."id"
+LEFT JOIN "conversation_pins" pin ON pin."user_id" = m."user_id" AND pin."conversation_id" = c."id"
+WHERE m."deleted_at" IS NULL;
+
+ALTER TABLE "conversation_members" DROP COLUMN "unread_count";
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "view_once_tokens";
+ALTER TABLE "attachments" DROP COLUMN "view_once";
+ALTER TABLE "messages" DROP COLUMN "view_once";
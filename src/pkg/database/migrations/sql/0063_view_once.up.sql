@@ -0,0 +1,20 @@
+-- View-once messages and the single-use tokens their recipients download
+-- the photos and voice notes with.
+ALTER TABLE "messages" ADD COLUMN "view_once" boolean NOT NULL DEFAULT false;
+ALTER TABLE "attachments" ADD COLUMN "view_once" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "view_once_tokens" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "attachment_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "token_hash" varchar(64) NOT NULL,
+    "created_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    "consumed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_view_once_tokens_attachment" FOREIGN KEY ("attachment_id") REFERENCES "attachments"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_view_once_tokens_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX "idx_view_once_tokens_attachment_user" ON "view_once_tokens" ("attachment_id", "user_id");
+CREATE INDEX "idx_view_once_tokens_user_id" ON "view_once_tokens" ("user_id");
+CREATE UNIQUE INDEX "idx_view_once_tokens_token_hash" ON "view_once_tokens" ("token_hash");
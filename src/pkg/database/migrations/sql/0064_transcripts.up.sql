@@ -0,0 +1,18 @@
+CREATE TABLE "transcripts" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "user_id" uuid NOT NULL,
+    "status" varchar(20) NOT NULL,
+    "messages" bigint NOT NULL DEFAULT 0,
+    "size_bytes" bigint NOT NULL DEFAULT 0,
+    "storage_key" varchar(255),
+    "expires_at" timestamptz,
+    "created_at" timestamptz,
+    "completed_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_transcripts_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_transcripts_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_transcripts_conversation_id" ON "transcripts" ("conversation_id");
+CREATE INDEX "idx_transcripts_user_id" ON "transcripts" ("user_id");
+CREATE UNIQUE INDEX "idx_transcripts_storage_key" ON "transcripts" ("storage_key");
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "ussd_sessions";
+ALTER TABLE "users" DROP COLUMN "ussd_access";
@@ -0,0 +1,15 @@
+-- USSD access is opted into per account, and each USSD session keeps the
+-- conversations it listed.
+ALTER TABLE "users" ADD COLUMN "ussd_access" boolean NOT NULL DEFAULT false;
+
+CREATE TABLE "ussd_sessions" (
+    "id" varchar(100),
+    "user_id" uuid NOT NULL,
+    "conversation_ids" jsonb,
+    "created_at" timestamptz,
+    "expires_at" timestamptz NOT NULL,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_ussd_sessions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_ussd_sessions_user_id" ON "ussd_sessions" ("user_id");
+CREATE INDEX "idx_ussd_sessions_expires_at" ON "ussd_sessions" ("expires_at");
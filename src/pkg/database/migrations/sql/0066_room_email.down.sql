@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "room_email_relays";
+DROP TABLE IF EXISTS "room_email_addresses";
@@ -0,0 +1,21 @@
+CREATE TABLE "room_email_addresses" (
+    "conversation_id" uuid,
+    "local_part" varchar(64) NOT NULL,
+    "created_by_id" uuid,
+    "last_received_at" timestamptz,
+    "created_at" timestamptz,
+    PRIMARY KEY ("conversation_id"),
+    CONSTRAINT "fk_room_email_addresses_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_email_addresses_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX "idx_room_email_addresses_local_part" ON "room_email_addresses" ("local_part");
+
+CREATE TABLE "room_email_relays" (
+    "conversation_id" uuid,
+    "user_id" uuid,
+    "created_at" timestamptz,
+    PRIMARY KEY ("conversation_id", "user_id"),
+    CONSTRAINT "fk_room_email_relays_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_email_relays_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_room_email_relays_user_id" ON "room_email_relays" ("user_id");
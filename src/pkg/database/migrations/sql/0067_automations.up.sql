@@ -0,0 +1,38 @@
+CREATE TABLE "automation_rules" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "conversation_id" uuid NOT NULL,
+    "name" varchar(100) NOT NULL,
+    "trigger_type" varchar(20) NOT NULL,
+    "keywords" text NOT NULL DEFAULT '',
+    "action_type" varchar(20) NOT NULL,
+    "enabled" boolean NOT NULL,
+    "bot_id" uuid,
+    "text" text NOT NULL DEFAULT '',
+    "label_id" uuid,
+    "url" text NOT NULL DEFAULT '',
+    "secret" varchar(64) NOT NULL DEFAULT '',
+    "created_by_id" uuid,
+    "created_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_automation_rules_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_automation_rules_bot" FOREIGN KEY ("bot_id") REFERENCES "bots"("user_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_automation_rules_label" FOREIGN KEY ("label_id") REFERENCES "conversation_labels"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_automation_rules_created_by" FOREIGN KEY ("created_by_id") REFERENCES "users"("id") ON DELETE SET NULL
+);
+CREATE INDEX "idx_automation_rules_conversation_id" ON "automation_rules" ("conversation_id");
+CREATE INDEX "idx_automation_rules_bot_id" ON "automation_rules" ("bot_id");
+CREATE INDEX "idx_automation_rules_label_id" ON "automation_rules" ("label_id");
+
+CREATE TABLE "automation_runs" (
+    "id" uuid DEFAULT gen_random_uuid(),
+    "rule_id" uuid NOT NULL,
+    "message_id" uuid,
+    "user_id" uuid,
+    "status" varchar(20) NOT NULL,
+    "error" text,
+    "created_at" timestamptz,
+    PRIMARY KEY ("id"),
+    CONSTRAINT "fk_automation_runs_rule" FOREIGN KEY ("rule_id") REFERENCES "automation_rules"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_automation_runs_rule_created" ON "automation_runs" ("rule_id", "created_at");
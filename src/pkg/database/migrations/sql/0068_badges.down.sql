@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "activity_days";
+DROP TABLE IF EXISTS "user_badges";
@@ -0,0 +1,16 @@
+CREATE TABLE "user_badges" (
+    "user_id" uuid,
+    "badge" varchar(30),
+    "hidden" boolean NOT NULL DEFAULT false,
+    "awarded_at" timestamptz NOT NULL,
+    PRIMARY KEY ("user_id", "badge"),
+    CONSTRAINT "fk_user_badges_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+
+CREATE TABLE "activity_days" (
+    "user_id" uuid,
+    "day" varchar(10),
+    PRIMARY KEY ("user_id", "day"),
+    CONSTRAINT "fk_activity_days_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_activity_days_day" ON "activity_days" ("day");
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS "room_contributions";
+ALTER TABLE "channels" DROP COLUMN IF EXISTS "posting_reputation";
@@ -0,0 +1,20 @@
+ALTER TABLE "channels" ADD COLUMN "posting_reputation" integer NOT NULL DEFAULT 0;
+
+CREATE TABLE "room_contributions" (
+    "conversation_id" uuid,
+    "user_id" uuid,
+    "messages" bigint NOT NULL DEFAULT 0,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("conversation_id", "user_id"),
+    CONSTRAINT "fk_room_contributions_conversation" FOREIGN KEY ("conversation_id") REFERENCES "conversations"("id") ON DELETE CASCADE,
+    CONSTRAINT "fk_room_contributions_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_room_contributions_user_id" ON "room_contributions" ("user_id");
+
+INSERT INTO "room_contributions" ("conversation_id", "user_id", "messages", "updated_at")
+SELECT "messages"."conversation_id", "messages"."sender_id", COUNT(*), MAX("messages"."created_at")
+FROM "messages"
+JOIN "conversations" ON "conversations"."id" = "messages"."conversation_id" AND "conversations"."kind" = 'channel'
+JOIN "users" ON "users"."id" = "messages"."sender_id"
+WHERE NOT "messages"."shadowed"
+GROUP BY "messages"."conversation_id", "messages"."sender_id";
@@ -0,0 +1,11 @@
+CREATE TABLE "usage_records" (
+    "user_id" uuid,
+    "meter" varchar(30),
+    "day" varchar(10),
+    "quantity" bigint NOT NULL,
+    "exported_at" timestamptz,
+    "updated_at" timestamptz,
+    PRIMARY KEY ("user_id", "meter", "day"),
+    CONSTRAINT "fk_usage_records_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
+CREATE INDEX "idx_usage_records_exported_at" ON "usage_records" ("exported_at");
@@ -0,0 +1,8 @@
+CREATE TABLE "channel_link_visits" (
+    "channel_id" uuid,
+    "user_id" uuid,
+    "visited_at" timestamptz NOT NULL,
+    PRIMARY KEY ("channel_id", "user_id"),
+    CONSTRAINT "fk_channel_link_visits_channel" FOREIGN KEY ("channel_id") REFERENCES "channels"("conversation_id") ON DELETE CASCADE,
+    CONSTRAINT "fk_channel_link_visits_user" FOREIGN KEY ("user_id") REFERENCES "users"("id") ON DELETE CASCADE
+);
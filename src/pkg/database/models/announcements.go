@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AnnouncementAck records a member acknowledging an announcement that asks
+// for it.
+type AnnouncementAck struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Announcement
+	MessageID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_announcement_acks_message_user" json:"message_id"`
+	Message   Message   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Member
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_announcement_acks_message_user;index" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"acknowledged_at"`
+}
+
+func (AnnouncementAck) TableName() string {
+	return "announcement_acks"
+}
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	AttachmentPending = "pending"
+	AttachmentReady   = "ready"
+)
+
+// Attachment is an uploaded file. It belongs to its uploader until it is
+// sent with a message, after which the conversation's members can fetch it.
+type Attachment struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Uploader
+	UploaderID uuid.UUID `gorm:"type:uuid;not null;index:idx_attachments_uploader_created,priority:1" json:"uploader_id"`
+	Uploader   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Message the file was sent with, once sent
+	MessageID *uuid.UUID `gorm:"type:uuid;index" json:"message_id"`
+
+	// File
+	Kind       string `gorm:"not null;size:20" json:"kind"`
+	FileName   string `gorm:"not null;size:255" json:"file_name"`
+	MimeType   string `gorm:"not null;size:255" json:"mime_type"`
+	SizeBytes  int64  `gorm:"not null" json:"size_bytes"`
+	StorageKey string `gorm:"uniqueIndex;not null;size:255" json:"-"`
+
+	// Encrypted attachments are blobs a client encrypted for an end-to-end
+	// encrypted conversation. Their name and type are placeholders, and
+	// Metadata is the client's, opaque to the server.
+	Encrypted bool   `gorm:"not null;default:false" json:"encrypted"`
+	Metadata  []byte `json:"metadata,omitempty"`
+
+	// ViewOnce attachments were sent with a view-once message. Each
+	// recipient can download them once, with a ViewOnceToken, and nobody
+	// else can download them at all.
+	ViewOnce bool `gorm:"not null;default:false" json:"view_once"`
+
+	// Status is pending until the bytes of a presigned upload arrive
+	Status string `gorm:"not null;size:20" json:"status"`
+
+	// Timestamps
+	CreatedAt time.Time      `gorm:"index:idx_attachments_uploader_created,priority:2" json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Attachment) TableName() string {
+	return "attachments"
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	AutoReplyKeyword  = "keyword"
+	AutoReplySchedule = "schedule"
+	AutoReplyGreeting = "greeting"
+)
+
+type AutoReplyRule struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	OwnerID uuid.UUID `gorm:"type:uuid;index;not null" json:"owner_id"`
+	Owner   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Rule
+	Kind     string `gorm:"not null;size:20" json:"kind"`
+	Keywords string `gorm:"type:text" json:"keywords"`
+	Reply    string `gorm:"type:text;not null" json:"reply"`
+	Priority int    `gorm:"default:0" json:"priority"`
+	Enabled  bool   `gorm:"default:true" json:"enabled"`
+
+	// Schedule, in minutes after midnight in the owner's time zone.
+	// Days is a bitmask with Sunday as bit 0.
+	Days        int `gorm:"default:127" json:"days"`
+	StartMinute int `gorm:"default:0" json:"start_minute"`
+	EndMinute   int `gorm:"default:1440" json:"end_minute"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (AutoReplyRule) TableName() string {
+	return "auto_reply_rules"
+}
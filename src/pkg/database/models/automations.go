@@ -0,0 +1,94 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// AutomationKeyword fires on a message containing one of the rule's
+	// keywords, AutomationMemberJoined on a member joining the channel.
+	AutomationKeyword      = "keyword"
+	AutomationMemberJoined = "member_joined"
+
+	// AutomationPostMessage posts the rule's text as its bot,
+	// AutomationAddLabel applies its creator's business label to the
+	// channel, and AutomationCallWebhook posts the event to its URL.
+	AutomationPostMessage = "post_message"
+	AutomationAddLabel    = "add_label"
+	AutomationCallWebhook = "call_webhook"
+
+	AutomationRunSucceeded = "succeeded"
+	AutomationRunFailed    = "failed"
+)
+
+// AutomationRule runs an action in a channel whenever its trigger fires
+// there. Owners and admins of the channel configure them.
+type AutomationRule struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Channel
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Rule. Keywords are separated by commas.
+	Name     string `gorm:"not null;size:100" json:"name"`
+	Trigger  string `gorm:"column:trigger_type;not null;size:20" json:"trigger"`
+	Keywords string `gorm:"type:text;not null;default:''" json:"keywords"`
+	Action   string `gorm:"column:action_type;not null;size:20" json:"action"`
+	Enabled  bool   `gorm:"not null" json:"enabled"`
+
+	// Text posted by BotID, where "{name}" is replaced by the display name
+	// of who set the rule off
+	BotID *uuid.UUID `gorm:"type:uuid;index" json:"bot_id,omitempty"`
+	Bot   *Bot       `gorm:"foreignKey:BotID;references:UserID;constraint:OnDelete:CASCADE" json:"-"`
+	Text  string     `gorm:"type:text;not null;default:''" json:"text,omitempty"`
+
+	// Label applied
+	LabelID *uuid.UUID         `gorm:"type:uuid;index" json:"label_id,omitempty"`
+	Label   *ConversationLabel `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Webhook called, signed with Secret
+	URL    string `gorm:"type:text;not null;default:''" json:"url,omitempty"`
+	Secret string `gorm:"size:64;not null;default:''" json:"-"`
+
+	// Creator. Rules outlive their creator's account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (AutomationRule) TableName() string {
+	return "automation_rules"
+}
+
+// AutomationRun is the execution log of a rule: one entry each time it
+// fired, with what set it off and how its action went.
+type AutomationRun struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Rule
+	RuleID uuid.UUID      `gorm:"type:uuid;not null;index:idx_automation_runs_rule_created,priority:1" json:"rule_id"`
+	Rule   AutomationRule `gorm:"foreignKey:RuleID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// What set it off: the message posted, or the member who joined
+	MessageID *uuid.UUID `gorm:"type:uuid" json:"message_id,omitempty"`
+	UserID    *uuid.UUID `gorm:"type:uuid" json:"user_id,omitempty"`
+
+	// Outcome, and why it failed if it did
+	Status string  `gorm:"not null;size:20" json:"status"`
+	Error  *string `gorm:"type:text" json:"error,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_automation_runs_rule_created,priority:2" json:"created_at"`
+}
+
+func (AutomationRun) TableName() string {
+	return "automation_runs"
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	BackupPending = "pending"
+	BackupReady   = "ready"
+)
+
+// Backup is one version of a user's chat backup. Clients encrypt backups
+// before uploading them, so the server only ever holds ciphertext.
+type Backup struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID  uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_backups_user_version,priority:1" json:"-"`
+	User    User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Version int       `gorm:"not null;uniqueIndex:idx_backups_user_version,priority:2" json:"version"`
+
+	// Device (session) that made the backup; only it may upload the bytes
+	SessionID  *uuid.UUID `gorm:"type:uuid" json:"session_id"`
+	Session    *Session   `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	DeviceName string     `gorm:"size:100" json:"device_name"`
+
+	// Ciphertext, described by the client. Scheme names the client's
+	// encryption format so restoring devices know how to decrypt.
+	Scheme     string `gorm:"not null;size:50" json:"scheme"`
+	SizeBytes  int64  `gorm:"not null" json:"size_bytes"`
+	SHA256     string `gorm:"not null;size:64" json:"sha256"`
+	StorageKey string `gorm:"uniqueIndex;not null;size:255" json:"-"`
+
+	// Status is pending until the bytes arrive and match SizeBytes and SHA256
+	Status string `gorm:"not null;size:20" json:"status"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Backup) TableName() string {
+	return "backups"
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// BadgeEarlyAdopter is earned by the first accounts to sign up,
+	// BadgeCommunityBuilder by growing a channel or bringing in people,
+	// and BadgeStreak by sending messages seven days in a row.
+	BadgeEarlyAdopter     = "early_adopter"
+	BadgeCommunityBuilder = "community_builder"
+	BadgeStreak           = "7_day_streak"
+
+	// ActivityDayLayout is the layout of ActivityDay.Day.
+	ActivityDayLayout = "2006-01-02"
+)
+
+// UserBadge is a badge a user earned. Badges are kept once earned, and
+// shown on the user's profile unless they hid it.
+type UserBadge struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Badge  string    `gorm:"primaryKey;size:30" json:"badge"`
+
+	Hidden bool `gorm:"not null;default:false" json:"hidden"`
+
+	// Timestamps
+	AwardedAt time.Time `gorm:"not null" json:"awarded_at"`
+}
+
+func (UserBadge) TableName() string {
+	return "user_badges"
+}
+
+// ActivityDay records that a user was active on a day, in UTC. Streaks
+// are counted from it.
+type ActivityDay struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Day    string    `gorm:"primaryKey;size:10;index" json:"day"`
+}
+
+func (ActivityDay) TableName() string {
+	return "activity_days"
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	AccountTypePersonal = "personal"
+	AccountTypeBusiness = "business"
+	AccountTypeBot      = "bot"
+)
+
+type BusinessProfile struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;uniqueIndex;not null" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Business Info
+	Name        string  `gorm:"not null;size:100" json:"name"`
+	Category    string  `gorm:"size:50" json:"category"`
+	Description string  `gorm:"type:text" json:"description"`
+	Website     *string `gorm:"size:255" json:"website"`
+	Email       *string `gorm:"size:255" json:"email"`
+	Address     *string `gorm:"size:255" json:"address"`
+
+	// Verification
+	IsVerified bool       `gorm:"default:false" json:"is_verified"`
+	VerifiedAt *time.Time `json:"verified_at"`
+
+	// Away Message
+	AwayEnabled bool   `gorm:"default:false" json:"away_enabled"`
+	AwayMessage string `gorm:"type:text" json:"away_message"`
+
+	// Timestamps
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (BusinessProfile) TableName() string {
+	return "business_profiles"
+}
+
+type CatalogItem struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	BusinessID uuid.UUID       `gorm:"type:uuid;index;not null" json:"business_id"`
+	Business   BusinessProfile `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Product Info
+	Name        string  `gorm:"not null;size:100" json:"name"`
+	Description string  `gorm:"type:text" json:"description"`
+	PriceCents  int64   `gorm:"not null;default:0" json:"price_cents"`
+	Currency    string  `gorm:"not null;size:3;default:ZAR" json:"currency"`
+	ImageURL    *string `gorm:"type:text" json:"image_url"`
+	IsAvailable bool    `gorm:"default:true" json:"is_available"`
+
+	// Timestamps
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (CatalogItem) TableName() string {
+	return "catalog_items"
+}
+
+type QuickReply struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	BusinessID uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_quick_replies_business_shortcut" json:"business_id"`
+	Business   BusinessProfile `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Template
+	Shortcut string `gorm:"not null;size:30;uniqueIndex:idx_quick_replies_business_shortcut" json:"shortcut"`
+	Text     string `gorm:"type:text;not null" json:"text"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (QuickReply) TableName() string {
+	return "quick_replies"
+}
+
+// ConversationLabel is a tag a business sorts its conversations with, such
+// as "New lead", "Paid" or "Pending". Only the business sees its labels.
+type ConversationLabel struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	BusinessID uuid.UUID       `gorm:"type:uuid;not null;uniqueIndex:idx_conversation_labels_business_name" json:"business_id"`
+	Business   BusinessProfile `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Label, with the color clients show it in as "#rrggbb"
+	Name  string `gorm:"not null;size:50;uniqueIndex:idx_conversation_labels_business_name" json:"name"`
+	Color string `gorm:"not null;size:7" json:"color"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ConversationLabel) TableName() string {
+	return "conversation_labels"
+}
+
+// ConversationLabeling applies a business's label to one of its
+// conversations.
+type ConversationLabeling struct {
+	// Primary Key
+	LabelID        uuid.UUID         `gorm:"type:uuid;primaryKey" json:"label_id"`
+	Label          ConversationLabel `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID         `gorm:"type:uuid;primaryKey;index" json:"conversation_id"`
+	Conversation   Conversation      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ConversationLabeling) TableName() string {
+	return "conversation_labelings"
+}
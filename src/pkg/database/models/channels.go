@@ -0,0 +1,120 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Channel is a named group conversation with managed membership. It shares
+// its ID with the conversation that carries its messages, and its members
+// are that conversation's members, with roles owner, admin and member.
+type Channel struct {
+	// Primary Key, shared with the channel's conversation
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Basic Info
+	Name        string `gorm:"not null;size:80;index" json:"name"`
+	Description string `gorm:"size:500" json:"description"`
+	IsPrivate   bool   `gorm:"default:false" json:"is_private"`
+
+	// IsListed offers a public channel to search engines, in the sitemap
+	// and with Open Graph metadata for its page. Owners opt in.
+	IsListed bool `gorm:"not null;default:false" json:"is_listed"`
+
+	// JoinCaptcha has new accounts, and those who followed a link from
+	// outside the app, solve a CAPTCHA to join the public channel once it
+	// is large, against raids. Owners and admins opt in.
+	JoinCaptcha bool `gorm:"not null;default:false" json:"join_captcha"`
+
+	// AdultOnly restricts the channel to users who gave a date of birth
+	// making them 18 or over. Platform admins can let others in, which
+	// the moderation log records.
+	AdultOnly bool `gorm:"not null;default:false" json:"adult_only"`
+
+	// PostingReputation is the reputation in the channel members need to
+	// post links and media there, unlocked as they contribute. Owners and
+	// admins set it; zero lets everyone.
+	PostingReputation int `gorm:"not null;default:0" json:"posting_reputation"`
+
+	// Creator
+	CreatedByID uuid.UUID `gorm:"type:uuid;not null" json:"created_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Channel) TableName() string {
+	return "channels"
+}
+
+// ChannelMember is a channel membership: the conversation_members row of
+// the channel's conversation.
+type ChannelMember = ConversationMember
+
+// WelcomeRoom is a public channel new users are added to when they sign
+// up: all of them, or those from one country.
+type WelcomeRoom struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Room
+	ChannelID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_welcome_rooms_channel_country" json:"channel_id"`
+	Channel   Channel   `gorm:"foreignKey:ChannelID;references:ConversationID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// CountryCode limits the room to users from that country, as guessed
+	// when they signed up. Empty means everyone.
+	CountryCode string `gorm:"size:2;not null;default:'';uniqueIndex:idx_welcome_rooms_channel_country" json:"country_code"`
+
+	// Creator. The room outlives their account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (WelcomeRoom) TableName() string {
+	return "welcome_rooms"
+}
+
+// ChannelWaitlistEntry is a user waiting for a place in a full public
+// channel. Users are admitted in the order they joined the line.
+type ChannelWaitlistEntry struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Place in line
+	ChannelID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_channel_waitlist_channel_user;index:idx_channel_waitlist_channel_created,priority:1" json:"channel_id"`
+	Channel   Channel   `gorm:"foreignKey:ChannelID;references:ConversationID;constraint:OnDelete:CASCADE" json:"-"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_channel_waitlist_channel_user" json:"user_id"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_channel_waitlist_channel_created,priority:2" json:"created_at"`
+}
+
+func (ChannelWaitlistEntry) TableName() string {
+	return "channel_waitlist"
+}
+
+// ChannelLinkVisit records a user resolving a short link to a channel they
+// were not in, so joining it counts as arriving from outside the app.
+type ChannelLinkVisit struct {
+	// Primary Key
+	ChannelID uuid.UUID `gorm:"type:uuid;primaryKey" json:"channel_id"`
+	Channel   Channel   `gorm:"foreignKey:ChannelID;references:ConversationID;constraint:OnDelete:CASCADE" json:"-"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey" json:"user_id"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	VisitedAt time.Time `gorm:"not null" json:"visited_at"`
+}
+
+func (ChannelLinkVisit) TableName() string {
+	return "channel_link_visits"
+}
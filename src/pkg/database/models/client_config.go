@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type ClientConfigRule struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Targeting: an empty platform or version bound matches everything
+	Platform   string `gorm:"size:20;index" json:"platform"`
+	MinVersion string `gorm:"size:32" json:"min_version"`
+	MaxVersion string `gorm:"size:32" json:"max_version"`
+	Priority   int    `gorm:"not null;default:0" json:"priority"`
+
+	// Settings holds a partial clientconfig.Settings object
+	Settings JSON   `gorm:"type:jsonb;not null" json:"settings"`
+	Enabled  bool   `gorm:"not null" json:"enabled"`
+	Note     string `gorm:"size:255" json:"note"`
+
+	// Editor
+	UpdatedByID uuid.UUID `gorm:"type:uuid;not null" json:"updated_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ClientConfigRule) TableName() string {
+	return "client_config_rules"
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What a user can consent to. Each is a flag on the user: analytics is
+// AnalyticsOptIn, marketing emails MarketingEmails and contact discovery
+// PhoneDiscoverable.
+const (
+	ConsentAnalytics        = "analytics"
+	ConsentMarketingEmails  = "marketing_emails"
+	ConsentContactDiscovery = "contact_discovery"
+)
+
+// ConsentEvent records a user giving or withdrawing consent, so when they
+// agreed to what can be shown later.
+type ConsentEvent struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_consent_events_user_created,priority:1" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Purpose string `gorm:"not null;size:30" json:"purpose"`
+	Granted bool   `gorm:"not null" json:"granted"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_consent_events_user_created,priority:2" json:"created_at"`
+}
+
+func (ConsentEvent) TableName() string {
+	return "consent_events"
+}
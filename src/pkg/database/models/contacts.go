@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ContactPending  = "pending"
+	ContactAccepted = "accepted"
+)
+
+// Contact is a friend request between two users, which makes them contacts
+// once the addressee accepts it. Each pair of users has at most one,
+// whichever of them sent it.
+type Contact struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Users
+	RequesterID uuid.UUID `gorm:"type:uuid;not null;index" json:"requester_id"`
+	Requester   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	AddresseeID uuid.UUID `gorm:"type:uuid;not null;index" json:"addressee_id"`
+	Addressee   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// PairKey is the sorted pair of user IDs, like a direct conversation's
+	PairKey string `gorm:"uniqueIndex;not null;size:73" json:"-"`
+
+	// Status
+	Status     string     `gorm:"not null;size:20" json:"status"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Contact) TableName() string {
+	return "contacts"
+}
+
+// Block stops the blocked user from messaging the blocker, sending them
+// friend requests or seeing their presence.
+type Block struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Users
+	BlockerID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_blocks_blocker_blocked,priority:1" json:"-"`
+	Blocker   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	BlockedID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_blocks_blocker_blocked,priority:2;index" json:"blocked_id"`
+	Blocked   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Block) TableName() string {
+	return "blocks"
+}
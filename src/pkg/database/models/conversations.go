@@ -0,0 +1,173 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	ConversationDirect  = "direct"
+	ConversationGroup   = "group"
+	ConversationChannel = "channel"
+
+	MemberRoleOwner  = "owner"
+	MemberRoleAdmin  = "admin"
+	MemberRoleMember = "member"
+)
+
+type Conversation struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Basic Info
+	Kind  string  `gorm:"not null;size:20" json:"kind"`
+	Title *string `gorm:"size:100" json:"title"`
+
+	// Workspace the conversation belongs to. Only its members can be
+	// added to the conversation.
+	WorkspaceID uuid.UUID `gorm:"type:uuid;not null;index" json:"workspace_id"`
+	Workspace   Workspace `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// DirectKey is the sorted pair of member IDs for direct conversations,
+	// prefixed with the workspace outside the default one, so each pair of
+	// users has at most one per workspace.
+	DirectKey *string `gorm:"size:110;uniqueIndex" json:"-"`
+
+	// Creator
+	CreatedByID uuid.UUID `gorm:"type:uuid;not null;index:idx_conversations_creator_created,priority:1" json:"created_by_id"`
+
+	// Members
+	Members []ConversationMember `json:"members,omitempty"`
+
+	// Audited conversations are audit rooms, whose messages are chained by
+	// hash so tampering with their history can be detected. AuditLength
+	// and AuditHead are the number of messages in the chain and the hash
+	// of the latest.
+	Audited     bool   `gorm:"not null;default:false" json:"audited"`
+	AuditLength int64  `gorm:"not null;default:0" json:"-"`
+	AuditHead   []byte `json:"-"`
+
+	// ComplianceArchived conversations have their messages copied to
+	// their workspace's archive, for apps to show members a banner.
+	ComplianceArchived bool `gorm:"not null;default:false" json:"compliance_archived"`
+
+	// LastSeq is the Seq of the latest message.
+	LastSeq int64 `gorm:"not null;default:0" json:"last_seq"`
+
+	// MemberCount is how many members the conversation has, kept up to
+	// date as they join and leave so lists need not count them.
+	MemberCount int64 `gorm:"not null;default:0" json:"member_count"`
+
+	// UnreadCount is set, where conversations are listed for a user, to
+	// how many messages the user has not read. It is not stored here but
+	// in ConversationSummary.
+	UnreadCount int64 `gorm:"-" json:"unread_count"`
+
+	// LastMessage is set, where conversations are listed for a user, to
+	// the latest message when the user can see it.
+	LastMessage *Message `gorm:"-" json:"last_message,omitempty"`
+
+	// Pinned is set, where conversations are listed for a user, on those
+	// the user pinned. It is not stored here but in ConversationPin.
+	Pinned bool `gorm:"-" json:"pinned,omitempty"`
+
+	// Timestamps
+	LastMessageAt *time.Time     `gorm:"index" json:"last_message_at"`
+	CreatedAt     time.Time      `gorm:"index:idx_conversations_creator_created,priority:2" json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+	DeletedAt     gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (Conversation) TableName() string {
+	return "conversations"
+}
+
+type ConversationMember struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Membership
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_conversation_members_conversation_user" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID         uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_conversation_members_conversation_user;index" json:"user_id"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Role           string       `gorm:"not null;size:20;default:member" json:"role"`
+
+	// Timestamps
+	JoinedAt  time.Time      `gorm:"not null" json:"joined_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
+}
+
+func (ConversationMember) TableName() string {
+	return "conversation_members"
+}
+
+// ConversationPin keeps a conversation at the top of one of its members'
+// conversation lists. Position orders a user's pins, lowest first.
+type ConversationPin struct {
+	// Primary Key
+	UserID         uuid.UUID    `gorm:"type:uuid;primaryKey" json:"-"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey;index" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Order
+	Position int `gorm:"not null;default:0" json:"position"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ConversationPin) TableName() string {
+	return "conversation_pins"
+}
+
+// ConversationSummary is what a user's inbox shows of one of their
+// conversations, kept up to date as messages are sent, read and deleted
+// and as the user pins and mutes it, so the inbox is read with a single
+// query. Each current member of a conversation has one.
+type ConversationSummary struct {
+	// Primary Key
+	UserID         uuid.UUID    `gorm:"type:uuid;primaryKey;index:idx_conversation_summaries_inbox,priority:1" json:"-"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey;index;index:idx_conversation_summaries_inbox,priority:6,sort:desc" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Conversation
+	WorkspaceID uuid.UUID `gorm:"type:uuid;not null;index:idx_conversation_summaries_inbox,priority:2" json:"workspace_id"`
+	Kind        string    `gorm:"not null;size:20" json:"kind"`
+	Title       *string   `gorm:"size:100" json:"title"`
+
+	// Latest message the user can see. The preview is the start of its
+	// text, empty once it is deleted and for messages without text.
+	LastMessageID      *uuid.UUID `gorm:"type:uuid" json:"last_message_id"`
+	LastSenderID       *uuid.UUID `gorm:"type:uuid" json:"last_sender_id"`
+	LastMessageType    *string    `gorm:"size:20" json:"last_message_type"`
+	LastMessagePreview string     `gorm:"not null;default:''" json:"last_message_preview"`
+
+	// LastActivityAt is when the latest message was sent, or when the
+	// conversation was created before any was.
+	LastActivityAt time.Time `gorm:"not null;index:idx_conversation_summaries_inbox,priority:5,sort:desc" json:"last_activity_at"`
+
+	// UnreadCount is how many messages others sent after the user's read
+	// marker.
+	UnreadCount int64 `gorm:"not null;default:0" json:"unread_count"`
+
+	// Muted is set while the user has the conversation muted, until
+	// MutedUntil if the mute ends by itself.
+	Muted      bool       `gorm:"not null;default:false" json:"muted"`
+	MutedUntil *time.Time `json:"muted_until"`
+
+	// Pinned conversations come first in the inbox, by PinPosition.
+	Pinned      bool `gorm:"not null;default:false;index:idx_conversation_summaries_inbox,priority:3,sort:desc" json:"pinned"`
+	PinPosition int  `gorm:"not null;default:0;index:idx_conversation_summaries_inbox,priority:4" json:"-"`
+}
+
+func (ConversationSummary) TableName() string {
+	return "conversation_summaries"
+}
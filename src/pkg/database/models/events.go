@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomEvent records when an event message's event takes place, so upcoming
+// events can be found without reading message payloads. Its room is the
+// message's conversation.
+type RoomEvent struct {
+	// Primary Key, the event message
+	MessageID uuid.UUID `gorm:"type:uuid;primaryKey" json:"message_id"`
+	Message   Message   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Schedule
+	StartsAt time.Time  `gorm:"not null;index" json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at"`
+
+	// Timestamps. RemindedAt is set once the members going have been
+	// reminded.
+	RemindedAt *time.Time `json:"reminded_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (RoomEvent) TableName() string {
+	return "room_events"
+}
+
+// EventRSVP is a member's answer to an event.
+type EventRSVP struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Event
+	MessageID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_event_rsvps_message_user" json:"message_id"`
+	Message   Message   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Member
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_event_rsvps_message_user;index" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Answer, one of the content.RSVPStatus values
+	Status string `gorm:"not null;size:10" json:"status"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (EventRSVP) TableName() string {
+	return "event_rsvps"
+}
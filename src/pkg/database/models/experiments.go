@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ExperimentDraft   = "draft"
+	ExperimentRunning = "running"
+	ExperimentStopped = "stopped"
+)
+
+type Experiment struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Definition
+	Key            string              `gorm:"uniqueIndex;not null;size:64" json:"key"`
+	Description    string              `gorm:"size:500" json:"description"`
+	Status         string              `gorm:"not null;size:20;default:draft;index" json:"status"`
+	TrafficPercent int                 `gorm:"not null;default:100" json:"traffic_percent"`
+	Variants       []ExperimentVariant `json:"variants"`
+
+	// Creator
+	CreatedByID uuid.UUID `gorm:"type:uuid;not null" json:"created_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Experiment) TableName() string {
+	return "experiments"
+}
+
+type ExperimentVariant struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Experiment
+	ExperimentID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_experiment_variants_experiment_key" json:"-"`
+	Experiment   Experiment `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Variant
+	Key    string `gorm:"not null;size:64;uniqueIndex:idx_experiment_variants_experiment_key" json:"key"`
+	Weight int    `gorm:"not null" json:"weight"`
+}
+
+func (ExperimentVariant) TableName() string {
+	return "experiment_variants"
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is an archive of everything a user has stored with us, built
+// in the background at their request.
+type DataExport struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Archive, once built. It can be downloaded until ExpiresAt.
+	Status     string     `gorm:"not null;size:20" json:"status"`
+	SizeBytes  int64      `gorm:"not null;default:0" json:"size_bytes"`
+	StorageKey *string    `gorm:"uniqueIndex;size:255" json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (DataExport) TableName() string {
+	return "data_exports"
+}
+
+// AccountErasure records the erasure of an account's personal data, step
+// by step, as evidence it was done. The records outlive the data they
+// describe: they list the accounts to erase again after restoring a
+// database backup taken before their erasure, so no user comes back from
+// one. They hold no personal data beyond the account's ID.
+type AccountErasure struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// UserID names the erased account. It is not a foreign key, so the
+	// record cannot go with the account.
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+
+	// Steps, each stamped once done. Files and search entries are
+	// counted, with the files that could not be deleted.
+	DataErasedAt         *time.Time `json:"data_erased_at"`
+	FilesDeleted         int        `gorm:"not null;default:0" json:"files_deleted"`
+	FilesFailed          int        `gorm:"not null;default:0" json:"files_failed"`
+	FilesDeletedAt       *time.Time `json:"files_deleted_at"`
+	SearchEntriesRemoved int        `gorm:"not null;default:0" json:"search_entries_removed"`
+	SearchPurgedAt       *time.Time `json:"search_purged_at"`
+	CachesPurgedAt       *time.Time `json:"caches_purged_at"`
+	// AnalyticsSubject is the keyed hash the account was counted under in
+	// analytics, if analytics were on, which cannot be traced back to it.
+	AnalyticsSubject  string     `gorm:"not null;size:64;default:''" json:"analytics_subject"`
+	AnalyticsPurgedAt *time.Time `json:"analytics_purged_at"`
+
+	// Attempts counts the runs of the erasure job, and LastError is why
+	// the latest failed, if it did.
+	Attempts  int    `gorm:"not null;default:0" json:"attempts"`
+	LastError string `gorm:"not null;size:500;default:''" json:"last_error"`
+
+	// Timestamps
+	RequestedAt time.Time  `gorm:"not null;index" json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (AccountErasure) TableName() string {
+	return "account_erasures"
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OAuth providers users can sign in with
+const (
+	ProviderGoogle = "google"
+	ProviderGitHub = "github"
+	ProviderApple  = "apple"
+)
+
+// UserIdentity links an account at an OAuth provider to a user, who can
+// then sign in with it instead of a password.
+type UserIdentity struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Provider account. Subject is the provider's stable ID for it; the
+	// email is as the provider last reported it, and may have changed
+	// there since.
+	Provider string `gorm:"not null;size:20;uniqueIndex:idx_user_identities_provider_subject,priority:1" json:"provider"`
+	Subject  string `gorm:"not null;size:255;uniqueIndex:idx_user_identities_provider_subject,priority:2" json:"-"`
+	Email    string `gorm:"size:255" json:"email"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+}
+
+func (UserIdentity) TableName() string {
+	return "user_identities"
+}
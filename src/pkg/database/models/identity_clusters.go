@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The kinds of identity signal accounts are linked by.
+const (
+	IdentitySignalFingerprint = "fingerprint"
+	IdentitySignalPhone       = "phone"
+	IdentitySignalIP          = "ip"
+)
+
+// IdentitySignal records that a user was seen with a device fingerprint,
+// phone number or IP address. The value is a hash, so accounts can be
+// matched on it without the table keeping where anyone signed in from.
+type IdentitySignal struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_identity_signals_user_kind_value,priority:1" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Kind  string `gorm:"not null;size:20;uniqueIndex:idx_identity_signals_user_kind_value,priority:2;index:idx_identity_signals_kind_value,priority:1" json:"kind"`
+	Value string `gorm:"not null;size:64;uniqueIndex:idx_identity_signals_user_kind_value,priority:3;index:idx_identity_signals_kind_value,priority:2" json:"-"`
+
+	// Timestamps
+	FirstSeenAt time.Time `gorm:"not null" json:"first_seen_at"`
+	LastSeenAt  time.Time `gorm:"not null;index" json:"last_seen_at"`
+}
+
+func (IdentitySignal) TableName() string {
+	return "identity_signals"
+}
+
+// IdentityCluster is a group of accounts linked by shared signals around
+// at least one banned account, as the periodic scan last found it.
+type IdentityCluster struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	MemberCount int `gorm:"not null" json:"member_count"`
+	BannedCount int `gorm:"not null" json:"banned_count"`
+
+	// NewestMemberAt is when the most recently created member signed up,
+	// which puts likely evasions first.
+	NewestMemberAt time.Time `gorm:"not null;index" json:"newest_member_at"`
+
+	Members []IdentityClusterMember `gorm:"foreignKey:ClusterID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	ComputedAt time.Time `gorm:"not null" json:"computed_at"`
+}
+
+func (IdentityCluster) TableName() string {
+	return "identity_clusters"
+}
+
+// IdentityClusterMember is an account in a cluster.
+type IdentityClusterMember struct {
+	ClusterID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Banned    bool      `gorm:"not null" json:"banned"`
+}
+
+func (IdentityClusterMember) TableName() string {
+	return "identity_cluster_members"
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Bot is an account run by a program rather than a person. It is a user of
+// AccountTypeBot with no password: it signs in with the API keys its owner
+// issues, and posts into channels through incoming webhooks.
+type Bot struct {
+	// Primary Key, shared with the bot's user
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Owner
+	OwnerID uuid.UUID `gorm:"type:uuid;not null;index" json:"owner_id"`
+	Owner   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Description string `gorm:"size:500" json:"description"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (Bot) TableName() string {
+	return "bots"
+}
+
+// APIKey lets a bot call the API as itself, with the scopes it was issued
+// with. Only its hash is stored; Prefix is kept to tell keys apart.
+type APIKey struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Bot
+	BotID uuid.UUID `gorm:"type:uuid;not null;index" json:"bot_id"`
+	Bot   Bot       `gorm:"foreignKey:BotID;references:UserID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// Key
+	Name    string `gorm:"not null;size:100" json:"name"`
+	Prefix  string `gorm:"not null;size:16" json:"prefix"`
+	KeyHash string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	Scopes  string `gorm:"not null;size:100" json:"scopes"`
+
+	// Timestamps
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// IncomingWebhook lets a third party post into a channel as a bot, by
+// sending messages to a secret URL. Only the hash of the URL's token is
+// stored.
+type IncomingWebhook struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Channel
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Bot the messages are posted as
+	BotID uuid.UUID `gorm:"type:uuid;not null;index" json:"bot_id"`
+	Bot   Bot       `gorm:"foreignKey:BotID;references:UserID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// Webhook
+	Name      string `gorm:"not null;size:100" json:"name"`
+	TokenHash string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+
+	// Creator. Webhooks outlive their creator's account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func (IncomingWebhook) TableName() string {
+	return "incoming_webhooks"
+}
+
+// OutgoingWebhook sends a channel's new messages to a third party's URL,
+// signed with Secret. TriggerWords, separated by commas, limit it to
+// messages starting with one of them; without any it sends every message.
+type OutgoingWebhook struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Channel
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Webhook
+	Name         string `gorm:"not null;size:100" json:"name"`
+	URL          string `gorm:"type:text;not null" json:"url"`
+	Secret       string `gorm:"not null;size:64" json:"-"`
+	TriggerWords string `gorm:"type:text" json:"trigger_words"`
+
+	// Creator. Webhooks outlive their creator's account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Last delivery, and why it failed if it did
+	LastDeliveredAt *time.Time `json:"last_delivered_at"`
+	LastError       *string    `gorm:"type:text" json:"last_error"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (OutgoingWebhook) TableName() string {
+	return "outgoing_webhooks"
+}
+
+// RoomFeed posts the new items of an RSS or Atom feed into a channel as a
+// bot. SeenGUIDs are the items the feed listed when last polled, null
+// until its first poll.
+type RoomFeed struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Channel
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Bot the items are posted as
+	BotID uuid.UUID `gorm:"type:uuid;not null;index" json:"bot_id"`
+	Bot   Bot       `gorm:"foreignKey:BotID;references:UserID;constraint:OnDelete:CASCADE" json:"-"`
+
+	// Feed
+	Name      string `gorm:"not null;size:100" json:"name"`
+	URL       string `gorm:"type:text;not null" json:"url"`
+	SeenGUIDs JSON   `gorm:"type:jsonb" json:"-"`
+
+	// Creator. Feeds outlive their creator's account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Last poll, and why it failed if it did
+	LastPolledAt *time.Time `gorm:"index" json:"last_polled_at"`
+	LastError    *string    `gorm:"type:text" json:"last_error"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (RoomFeed) TableName() string {
+	return "room_feeds"
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type InviteCode struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Code
+	Code    string    `gorm:"uniqueIndex;not null;size:16" json:"code"`
+	BatchID uuid.UUID `gorm:"type:uuid;index;not null" json:"batch_id"`
+	Note    string    `gorm:"size:255" json:"note"`
+
+	// Usage
+	MaxUses   int        `gorm:"not null;default:1" json:"max_uses"`
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt *time.Time `json:"expires_at"`
+
+	// Creator
+	CreatedByID uuid.UUID `gorm:"type:uuid;not null" json:"created_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (InviteCode) TableName() string {
+	return "invite_codes"
+}
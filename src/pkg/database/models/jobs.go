@@ -0,0 +1,59 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// Job is background work queued in the database, so it survives restarts
+// and is shared out between instances. Workers run pending jobs once
+// RunAt has passed, retrying failures until attempts run out. Finished
+// jobs are removed; failed ones stay for inspection.
+type Job struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Work. Kind names the handler and Payload is its input.
+	Kind    string `gorm:"not null;size:50" json:"kind"`
+	Payload JSON   `gorm:"type:jsonb;not null" json:"payload"`
+
+	// Progress
+	Status    string    `gorm:"not null;size:20;default:pending;index:idx_jobs_status_run_at,priority:1" json:"status"`
+	Attempts  int       `gorm:"not null;default:0" json:"attempts"`
+	RunAt     time.Time `gorm:"not null;index:idx_jobs_status_run_at,priority:2" json:"run_at"`
+	LastError string    `gorm:"type:text" json:"last_error,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// ScheduledTask records when a periodic job next falls due. Every instance
+// runs the scheduler, and whichever moves NextRunAt on first queues the
+// job, so it runs once however many instances there are.
+type ScheduledTask struct {
+	// Primary Key, the kind of job queued
+	Name string `gorm:"primaryKey;size:50" json:"name"`
+
+	// Schedule
+	NextRunAt time.Time  `gorm:"not null" json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ScheduledTask) TableName() string {
+	return "scheduled_tasks"
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KeyBackup is a user's end-to-end encryption keys, encrypted by the
+// client with a key derived from a passphrase only the user knows, so a
+// new device can recover message history. The server holds ciphertext and
+// the parameters needed to derive the key again, never the passphrase.
+type KeyBackup struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Version increases with every replacement, so a device can replace
+	// only the backup it last saw.
+	Version int `gorm:"not null" json:"version"`
+
+	// Ciphertext, described by the client. Algorithm names the client's
+	// key derivation and encryption scheme, and KDFParams holds its salt
+	// and cost parameters.
+	Algorithm  string `gorm:"not null;size:100" json:"algorithm"`
+	KDFParams  JSON   `gorm:"type:jsonb;not null" json:"kdf_params"`
+	Ciphertext []byte `gorm:"not null" json:"ciphertext"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (KeyBackup) TableName() string {
+	return "key_backups"
+}
+
+// CrossSigningKey is the Ed25519 public key a user signs their devices'
+// identity keys with, so their contacts can trust a new device by
+// trusting the user once.
+type CrossSigningKey struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	PublicKey []byte `gorm:"not null" json:"public_key"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (CrossSigningKey) TableName() string {
+	return "cross_signing_keys"
+}
+
+// DeviceKey is the Ed25519 identity key of one of a user's devices. A
+// device is trusted once the user's cross-signing key has signed it.
+type DeviceKey struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_device_keys_user_device,priority:1" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// DeviceID is chosen by the client and stable across sign-ins
+	DeviceID    string `gorm:"not null;size:64;uniqueIndex:idx_device_keys_user_device,priority:2" json:"device_id"`
+	DisplayName string `gorm:"size:100" json:"display_name"`
+	IdentityKey []byte `gorm:"not null" json:"identity_key"`
+
+	// Signature by the cross-signing key, nil until the user signs the
+	// device or after they replace their cross-signing key
+	Signature []byte     `json:"signature"`
+	SignedAt  *time.Time `json:"signed_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (DeviceKey) TableName() string {
+	return "device_keys"
+}
+
+// SignedPreKey is a device's current medium-term prekey, signed with its
+// identity key. Devices replace it every so often; a new identity key
+// discards it.
+type SignedPreKey struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Device
+	DeviceKeyID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"-"`
+	DeviceKey   DeviceKey `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// KeyID is chosen by the device, so it can tell which of its private
+	// keys a session was started with.
+	KeyID     int    `gorm:"not null" json:"key_id"`
+	PublicKey []byte `gorm:"not null" json:"public_key"`
+	Signature []byte `gorm:"not null" json:"signature"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (SignedPreKey) TableName() string {
+	return "signed_prekeys"
+}
+
+// OneTimePreKey is a prekey a device uploaded in a batch, handed out to a
+// single peer starting a session and then deleted.
+type OneTimePreKey struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Device
+	DeviceKeyID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_one_time_prekeys_device_key,priority:1" json:"-"`
+	DeviceKey   DeviceKey `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	KeyID     int    `gorm:"not null;uniqueIndex:idx_one_time_prekeys_device_key,priority:2" json:"key_id"`
+	PublicKey []byte `gorm:"not null" json:"public_key"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"-"`
+}
+
+func (OneTimePreKey) TableName() string {
+	return "one_time_prekeys"
+}
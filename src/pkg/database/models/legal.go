@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	LegalTermsOfService = "terms_of_service"
+	LegalPrivacyPolicy  = "privacy_policy"
+)
+
+type LegalDocument struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Document
+	Kind    string `gorm:"not null;size:30;uniqueIndex:idx_legal_documents_kind_version" json:"kind"`
+	Version string `gorm:"not null;size:20;uniqueIndex:idx_legal_documents_kind_version" json:"version"`
+	URL     string `gorm:"type:text;not null" json:"url"`
+
+	// Timestamps
+	PublishedAt time.Time `gorm:"index;not null" json:"published_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (LegalDocument) TableName() string {
+	return "legal_documents"
+}
+
+type LegalAcceptance struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Who accepted what
+	UserID     uuid.UUID     `gorm:"type:uuid;not null;uniqueIndex:idx_legal_acceptances_user_document" json:"user_id"`
+	User       User          `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	DocumentID uuid.UUID     `gorm:"type:uuid;not null;uniqueIndex:idx_legal_acceptances_user_document" json:"document_id"`
+	Document   LegalDocument `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+
+	// Evidence
+	IPAddress string `gorm:"size:45" json:"ip_address"`
+	UserAgent string `gorm:"size:255" json:"user_agent"`
+
+	// Timestamps
+	AcceptedAt time.Time `gorm:"not null" json:"accepted_at"`
+}
+
+func (LegalAcceptance) TableName() string {
+	return "legal_acceptances"
+}
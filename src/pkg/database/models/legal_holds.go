@@ -0,0 +1,134 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoleLegal is the role of the staff handling lawful requests, the only
+// users who can place legal holds and export what they preserve. It
+// reaches none of the administration routes.
+const RoleLegal = "legal"
+
+// What the lawful-request audit log records.
+const (
+	LegalAuditHoldPlaced     = "hold_placed"
+	LegalAuditHoldReleased   = "hold_released"
+	LegalAuditHoldViewed     = "hold_viewed"
+	LegalAuditHoldsListed    = "holds_listed"
+	LegalAuditExportCreated  = "export_requested"
+	LegalAuditExportViewed   = "export_viewed"
+	LegalAuditExportDownload = "export_downloaded"
+	LegalAuditLogViewed      = "audit_log_viewed"
+)
+
+const (
+	LegalExportPending = "pending"
+	LegalExportReady   = "ready"
+	LegalExportFailed  = "failed"
+)
+
+// LegalHold preserves a user's or a conversation's messages for a lawful
+// request. While it is active, the messages are kept from deletion: an
+// erasure of the user waits for its release, and messages deleted in the
+// conversation or by the user are copied to PreservedMessage first.
+type LegalHold struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Reference names the request the hold answers, such as a court order
+	// or case number.
+	Reference string `gorm:"not null;size:100" json:"reference"`
+	Reason    string `gorm:"type:text;not null" json:"reason"`
+
+	// Subject: exactly one of a user or a conversation.
+	UserID         *uuid.UUID    `gorm:"type:uuid;index" json:"user_id"`
+	User           *User         `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+	ConversationID *uuid.UUID    `gorm:"type:uuid;index" json:"conversation_id"`
+	Conversation   *Conversation `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+
+	PlacedByID   uuid.UUID  `gorm:"type:uuid;not null" json:"placed_by_id"`
+	ReleasedAt   *time.Time `gorm:"index" json:"released_at"`
+	ReleasedByID *uuid.UUID `gorm:"type:uuid" json:"released_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (LegalHold) TableName() string {
+	return "legal_holds"
+}
+
+// PreservedMessage is a copy of a held message taken as it was deleted,
+// with the versions it was edited from.
+type PreservedMessage struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// The message, which stays as a tombstone
+	MessageID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"message_id"`
+	ConversationID uuid.UUID `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	SenderID       uuid.UUID `gorm:"type:uuid;not null;index" json:"sender_id"`
+
+	Type     string `gorm:"not null;size:20" json:"type"`
+	Text     string `gorm:"type:text" json:"text"`
+	Entities JSON   `gorm:"type:jsonb" json:"entities"`
+	Payload  JSON   `gorm:"type:jsonb" json:"payload"`
+	Edits    JSON   `gorm:"type:jsonb" json:"edits"`
+
+	// Timestamps
+	SentAt      time.Time `gorm:"not null" json:"sent_at"`
+	PreservedAt time.Time `gorm:"not null" json:"preserved_at"`
+}
+
+func (PreservedMessage) TableName() string {
+	return "preserved_messages"
+}
+
+// LegalExport is an archive of what a legal hold preserves, built in the
+// background for the legal staff who asked for it.
+type LegalExport struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	HoldID        uuid.UUID `gorm:"type:uuid;not null;index" json:"hold_id"`
+	Hold          LegalHold `gorm:"constraint:OnDelete:RESTRICT" json:"-"`
+	RequestedByID uuid.UUID `gorm:"type:uuid;not null" json:"requested_by_id"`
+
+	// Archive, once built
+	Status     string  `gorm:"not null;size:20" json:"status"`
+	SizeBytes  int64   `gorm:"not null;default:0" json:"size_bytes"`
+	StorageKey *string `gorm:"uniqueIndex;size:255" json:"-"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (LegalExport) TableName() string {
+	return "legal_exports"
+}
+
+// LegalAuditEntry records one thing legal staff did or looked at. The log
+// is only ever appended to.
+type LegalAuditEntry struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	ActorID  uuid.UUID  `gorm:"type:uuid;not null;index" json:"actor_id"`
+	Action   string     `gorm:"not null;size:30" json:"action"`
+	HoldID   *uuid.UUID `gorm:"type:uuid;index" json:"hold_id"`
+	ExportID *uuid.UUID `gorm:"type:uuid" json:"export_id"`
+
+	// IPAddress is where the request came from and Detail what it asked.
+	IPAddress string `gorm:"not null;size:45" json:"ip_address"`
+	Detail    string `gorm:"type:text;not null;default:''" json:"detail"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (LegalAuditEntry) TableName() string {
+	return "legal_audit_entries"
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/idgen"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type Message struct {
+	// Primary Key, time-ordered so IDs sort by creation. It breaks ties in
+	// the history index for messages created in the same microsecond.
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;index:idx_messages_conversation_history,priority:3" json:"id"`
+
+	// Conversation
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index:idx_messages_conversation_history,priority:1;index:idx_messages_audit_chain,priority:1;index:idx_messages_conversation_seq,priority:1" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Seq numbers the message in its conversation from 1, without gaps, in
+	// the order messages were stored. A client that sees a jump in Seq has
+	// missed messages, and can fetch them by range. The conversation lock
+	// it is assigned under keeps it unique: messages are partitioned by
+	// month, which no unique index can span.
+	Seq int64 `gorm:"not null;index:idx_messages_conversation_seq,priority:2" json:"seq"`
+
+	// Sender
+	SenderID uuid.UUID `gorm:"type:uuid;not null;index;index:idx_messages_sender_client,priority:1" json:"sender_id"`
+	Sender   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// ClientID is the sender's own identifier for the message, used to
+	// de-duplicate resends, which MessageClientID keeps unique.
+	ClientID *string `gorm:"size:64;index:idx_messages_sender_client,priority:2" json:"client_id,omitempty"`
+
+	// Content. Postgres indexes Text for search in the generated
+	// search_vector column, which the model leaves out.
+	Type     string `gorm:"not null;size:20;default:text" json:"type"`
+	Text     string `gorm:"type:text" json:"text"`
+	Entities JSON   `gorm:"type:jsonb" json:"entities"`
+	Payload  JSON   `gorm:"type:jsonb" json:"payload,omitempty"`
+
+	// Shadowed messages were sent by a shadow restricted account, and are
+	// shown to others as tombstones until the restriction is lifted.
+	Shadowed bool `gorm:"not null;default:false" json:"-"`
+
+	// ViewOnce messages carry photos or voice notes each recipient can
+	// open once.
+	ViewOnce bool `gorm:"not null;default:false" json:"view_once,omitempty"`
+
+	// Files sent with the message
+	Attachments []Attachment `gorm:"constraint:OnDelete:SET NULL" json:"attachments,omitempty"`
+
+	// Audit chain, in audit rooms only. AuditSeq numbers the message in
+	// its conversation's chain, and AuditHash covers the message and
+	// AuditPrevHash, the hash of the message before it.
+	AuditSeq      *int64 `gorm:"index:idx_messages_audit_chain,priority:2" json:"audit_seq,omitempty"`
+	AuditPrevHash []byte `json:"audit_prev_hash,omitempty"`
+	AuditHash     []byte `json:"audit_hash,omitempty"`
+
+	// ArchiveSegmentID is the archive segment holding the content of a
+	// message archived to object storage, whose content columns are then
+	// empty. Queries fill them back in from the segment.
+	ArchiveSegmentID *uuid.UUID `gorm:"type:uuid;index" json:"-"`
+
+	// Timestamps. EditedAt is set once the text has been edited, and
+	// DeletedAt marks a tombstone: a deleted message keeps its place in
+	// history with its content removed.
+	CreatedAt time.Time      `gorm:"index:idx_messages_conversation_history,priority:2" json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	EditedAt  *time.Time     `json:"edited_at,omitempty"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
+}
+
+func (Message) TableName() string {
+	return "messages"
+}
+
+func (m *Message) BeforeCreate(*gorm.DB) error {
+	if m.ID == uuid.Nil {
+		m.ID = idgen.NewUUID()
+	}
+	return nil
+}
+
+// MessageClientID claims a ClientID for the message its sender sent with
+// it, so a resend fails to claim it again.
+type MessageClientID struct {
+	SenderID  uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	Sender    User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ClientID  string    `gorm:"size:64;primaryKey" json:"-"`
+	MessageID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+}
+
+func (MessageClientID) TableName() string {
+	return "message_client_ids"
+}
+
+// MessageArchiveSegment indexes a compressed object holding the content
+// of old messages of one conversation, sent from FirstAt through LastAt.
+type MessageArchiveSegment struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+
+	ConversationID uuid.UUID `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	FirstAt        time.Time `gorm:"not null" json:"first_at"`
+	LastAt         time.Time `gorm:"not null" json:"last_at"`
+
+	// MessageCount is how many messages the object holds. Once fewer
+	// messages point to it, because they were deleted or erased, the
+	// object is rewritten without them.
+	MessageCount int    `gorm:"not null" json:"message_count"`
+	SizeBytes    int64  `gorm:"not null" json:"size_bytes"`
+	StorageKey   string `gorm:"not null;size:255;uniqueIndex" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (MessageArchiveSegment) TableName() string {
+	return "message_archive_segments"
+}
+
+// MessageEdit is a version of a message's text that an edit replaced.
+// Together with the message they make up its edit history.
+type MessageEdit struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Message
+	MessageID uuid.UUID `gorm:"type:uuid;not null;index:idx_message_edits_history,priority:1" json:"message_id"`
+	Message   Message   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// The replaced text
+	Text     string `gorm:"type:text" json:"text"`
+	Entities JSON   `gorm:"type:jsonb" json:"entities"`
+
+	// WrittenAt is when this version was sent or last edited, and
+	// CreatedAt when the edit replaced it.
+	WrittenAt time.Time `gorm:"not null" json:"written_at"`
+	CreatedAt time.Time `gorm:"index:idx_message_edits_history,priority:2" json:"replaced_at"`
+}
+
+func (MessageEdit) TableName() string {
+	return "message_edits"
+}
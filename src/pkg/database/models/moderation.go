@@ -0,0 +1,201 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Moderation actions
+const (
+	ModerationSuspend   = "suspend"
+	ModerationUnsuspend = "unsuspend"
+	ModerationBan       = "ban"
+	ModerationUnban     = "unban"
+	ModerationSignOut   = "sign_out"
+
+	// ModerationAgeOverride lets a user into an 18+ room they could not
+	// join themselves.
+	ModerationAgeOverride = "age_override"
+
+	// ModerationShadowRestrict delivers the account's messages to itself
+	// alone, and ModerationShadowLift releases them. Restrictions made by
+	// the abuse scorers have no actor, and are confirmed by a moderator
+	// restricting the account again.
+	ModerationShadowRestrict = "shadow_restrict"
+	ModerationShadowLift     = "shadow_lift"
+
+	// ModerationWarn tells the account off without restricting it.
+	// ModerationMute stops it sending messages until a set time, and
+	// ModerationUnmute lets it send again.
+	ModerationWarn   = "warn"
+	ModerationMute   = "mute"
+	ModerationUnmute = "unmute"
+)
+
+// ModerationAction records which staff member took an action against an
+// account, and why. Entries are never changed or removed.
+type ModerationAction struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Target account
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_moderation_actions_user_created,priority:1" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// ActorID is the staff member who took the action. It is kept when
+	// their account is deleted, so the log still shows an action was
+	// taken by someone.
+	ActorID *uuid.UUID `gorm:"type:uuid;index" json:"actor_id"`
+	Actor   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// ConversationID is the room an action concerned, for actions taken
+	// in one room rather than on the whole account. The entry outlives
+	// the room.
+	ConversationID *uuid.UUID    `gorm:"type:uuid" json:"conversation_id,omitempty"`
+	Conversation   *Conversation `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Action
+	Action string `gorm:"not null;size:20" json:"action"`
+	Reason string `gorm:"type:text;not null" json:"reason"`
+
+	// Until is when a suspension or mute ends, nil for a suspension that
+	// lasts until it is lifted.
+	Until *time.Time `json:"until,omitempty"`
+
+	// BatchID is the bulk action the entry was taken in, if any.
+	BatchID *uuid.UUID       `gorm:"type:uuid;index" json:"batch_id,omitempty"`
+	Batch   *ModerationBatch `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_moderation_actions_user_created,priority:2;index" json:"created_at"`
+}
+
+func (ModerationAction) TableName() string {
+	return "moderation_actions"
+}
+
+// ModerationTemplate is a reusable moderation action: the action, how long
+// it lasts and the warning sent to the account.
+type ModerationTemplate struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	Name   string `gorm:"not null;size:100;uniqueIndex" json:"name"`
+	Action string `gorm:"not null;size:20" json:"action"`
+
+	// WarnText is sent to the account's inbox when the template is
+	// applied, empty to send nothing.
+	WarnText string `gorm:"type:text;not null;default:''" json:"warn_text"`
+
+	// DurationSeconds is how long a mute or suspension lasts, 0 for a
+	// suspension until it is lifted. Other actions take none.
+	DurationSeconds int64 `gorm:"not null;default:0" json:"duration_seconds"`
+
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (ModerationTemplate) TableName() string {
+	return "moderation_templates"
+}
+
+// ModerationBatch is one action taken against many accounts at once, which
+// can be undone until UndoableUntil.
+type ModerationBatch struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	ActorID *uuid.UUID `gorm:"type:uuid;index" json:"actor_id"`
+	Actor   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// TemplateID is the template the batch applied, if any. The batch
+	// keeps its own copy of what it did.
+	TemplateID *uuid.UUID          `gorm:"type:uuid" json:"template_id,omitempty"`
+	Template   *ModerationTemplate `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	Action   string     `gorm:"not null;size:20" json:"action"`
+	Reason   string     `gorm:"type:text;not null" json:"reason"`
+	WarnText string     `gorm:"type:text;not null;default:''" json:"warn_text"`
+	Until    *time.Time `json:"until,omitempty"`
+
+	UndoableUntil time.Time  `gorm:"not null" json:"undoable_until"`
+	UndoneAt      *time.Time `json:"undone_at,omitempty"`
+	UndoneByID    *uuid.UUID `gorm:"type:uuid" json:"undone_by_id,omitempty"`
+	UndoneBy      *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+func (ModerationBatch) TableName() string {
+	return "moderation_batches"
+}
+
+// Strike is a warning a moderator issued an account, which counts towards
+// suspending it until it expires.
+type Strike struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_strikes_user_expires,priority:1" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	ActorID *uuid.UUID `gorm:"type:uuid" json:"actor_id,omitempty"`
+	Actor   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	Reason string `gorm:"type:text;not null" json:"reason"`
+
+	// SuspensionID is the suspension the strike escalated to, if reaching
+	// it took a step.
+	SuspensionID *uuid.UUID        `gorm:"type:uuid" json:"suspension_id,omitempty"`
+	Suspension   *ModerationAction `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `gorm:"not null;index:idx_strikes_user_expires,priority:2" json:"expires_at"`
+}
+
+func (Strike) TableName() string {
+	return "strikes"
+}
+
+// Appeal statuses
+const (
+	AppealPending = "pending"
+	AppealGranted = "granted"
+	AppealDenied  = "denied"
+)
+
+// Appeal is an account asking for a suspension or ban to be lifted. Each
+// action can be appealed once.
+type Appeal struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	ActionID uuid.UUID        `gorm:"type:uuid;not null;uniqueIndex" json:"action_id"`
+	Action   ModerationAction `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Statement string `gorm:"type:text;not null" json:"statement"`
+	Status    string `gorm:"not null;size:20;default:pending;index:idx_appeals_status_created,priority:1" json:"status"`
+
+	// The moderator's decision, and what they told the account
+	ReviewerID *uuid.UUID `gorm:"type:uuid" json:"reviewer_id,omitempty"`
+	Reviewer   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	Note       string     `gorm:"type:text;not null;default:''" json:"note"`
+	DecidedAt  *time.Time `json:"decided_at,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_appeals_status_created,priority:2" json:"created_at"`
+}
+
+func (Appeal) TableName() string {
+	return "appeals"
+}
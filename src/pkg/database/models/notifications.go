@@ -0,0 +1,173 @@
+package models
+
+import (
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/preview"
+	"github.com/google/uuid"
+)
+
+// DeviceToken is a push notification token registered by a signed-in
+// device. It belongs to the session that registered it, so signing out or
+// revoking the session stops pushes to the device.
+type DeviceToken struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Owner
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User      User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	Session   Session   `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Token, as issued to the app by FCM or APNs, or the Web Push
+	// subscription of a browser as JSON
+	Platform string `gorm:"not null;size:20" json:"platform"`
+	Token    string `gorm:"uniqueIndex;not null;size:1024" json:"-"`
+
+	// Delivery health. Failures counts consecutive failed pushes and is
+	// reset by a successful one; tokens the push service rejects outright
+	// are deleted instead.
+	LastPushedAt  *time.Time `json:"last_pushed_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LastError     *string    `gorm:"size:255" json:"last_error"`
+	Failures      int        `gorm:"not null;default:0" json:"failures"`
+
+	// Timestamps. Apps register their token on every launch, so UpdatedAt
+	// is when the device was last seen using it.
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `gorm:"index" json:"updated_at"`
+}
+
+func (DeviceToken) TableName() string {
+	return "device_tokens"
+}
+
+// NotificationPreferences are which messages a user is pushed about while
+// offline. Users without a row get the defaults.
+type NotificationPreferences struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Which messages to push
+	DirectMessages bool `gorm:"not null" json:"direct_messages"`
+	GroupMessages  bool `gorm:"not null" json:"group_messages"`
+	Mentions       bool `gorm:"not null" json:"mentions"`
+
+	// PreviewMode is how much of a message its notifications show on lock
+	// screens: one of the preview package's modes. FilterProfanity masks
+	// profane words in what they show.
+	PreviewMode     string `gorm:"not null;size:20;default:full" json:"preview_mode"`
+	FilterProfanity bool   `gorm:"not null;default:true" json:"filter_profanity"`
+
+	// ShowPreviews is whether PreviewMode shows message text, for clients
+	// older than preview modes.
+	ShowPreviews bool `gorm:"not null" json:"show_previews"`
+
+	// Channels the notifications go out on: pushes to the user's devices
+	// and emails to their address.
+	PushEnabled  bool `gorm:"not null;default:true" json:"push_enabled"`
+	EmailEnabled bool `gorm:"not null;default:false" json:"email_enabled"`
+
+	// EmailDigest sends an hourly email summing up the messages the user
+	// has not read, conversation by conversation. DigestSentAt is when
+	// the last one covered activity up to.
+	EmailDigest  bool       `gorm:"not null;default:false;index" json:"email_digest"`
+	DigestSentAt *time.Time `json:"-"`
+
+	// DNDStart and DNDEnd are the do-not-disturb hours, as "HH:MM" in the
+	// user's time zone, during which nothing is pushed or emailed. They
+	// wrap past midnight when DNDEnd is the earlier, and are null when the
+	// user has none.
+	DNDStart *string `gorm:"size:5" json:"dnd_start"`
+	DNDEnd   *string `gorm:"size:5" json:"dnd_end"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (NotificationPreferences) TableName() string {
+	return "notification_preferences"
+}
+
+// DefaultNotificationPreferences pushes every message, at any hour and
+// with filtered previews of its text, and emails none.
+func DefaultNotificationPreferences(userID uuid.UUID) NotificationPreferences {
+	return NotificationPreferences{
+		UserID:          userID,
+		DirectMessages:  true,
+		GroupMessages:   true,
+		Mentions:        true,
+		PreviewMode:     preview.Full,
+		FilterProfanity: true,
+		ShowPreviews:    true,
+		PushEnabled:     true,
+	}
+}
+
+// ConversationMute silences a conversation for one of its members: nothing
+// is pushed or emailed about its messages, which still arrive over open
+// connections but flagged silent.
+type ConversationMute struct {
+	// Primary Key
+	UserID         uuid.UUID    `gorm:"type:uuid;primaryKey" json:"-"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Until is when the mute ends by itself, or null to last until the
+	// user unmutes.
+	Until *time.Time `json:"until"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ConversationMute) TableName() string {
+	return "conversation_mutes"
+}
+
+// Kinds of InboxNotification.
+const (
+	InboxMention         = "mention"
+	InboxInvite          = "invite"
+	InboxContactRequest  = "contact_request"
+	InboxContactAccepted = "contact_accepted"
+	InboxSystem          = "system"
+)
+
+// InboxNotification is an entry in a user's notification center: a
+// mention, an invite, a friend request or a notice from the operators. It
+// stays, read or unread, until the user clears it or it ages out.
+type InboxNotification struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Recipient
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_inbox_notifications_user,priority:1" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// What happened: one of the Inbox kinds, and how to show it
+	Kind  string `gorm:"not null;size:30" json:"kind"`
+	Title string `gorm:"not null;size:200" json:"title"`
+	Body  string `gorm:"not null;size:500" json:"body"`
+
+	// Who did it and where, when there is someone and somewhere to link
+	// to. MessageID is the message that mentioned the user.
+	ActorID        *uuid.UUID    `gorm:"type:uuid;index" json:"actor_id"`
+	Actor          *User         `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	ConversationID *uuid.UUID    `gorm:"type:uuid;index" json:"conversation_id"`
+	Conversation   *Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	MessageID      *uuid.UUID    `gorm:"type:uuid" json:"message_id"`
+
+	// ReadAt is when the user read it, or null while it is unread.
+	ReadAt *time.Time `json:"read_at"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_inbox_notifications_user,priority:2" json:"created_at"`
+}
+
+func (InboxNotification) TableName() string {
+	return "inbox_notifications"
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OnboardingStep is one direct message of the sequence sent to new users,
+// DelaySeconds after they sign up: a profile tip, a nudge to find
+// contacts or to join communities.
+type OnboardingStep struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Key names the step to clients, which mark it complete by it once the
+	// user has done what it suggests.
+	Key          string `gorm:"uniqueIndex;not null;size:50" json:"key"`
+	Text         string `gorm:"type:text;not null" json:"text"`
+	DelaySeconds int64  `gorm:"not null" json:"delay_seconds"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (OnboardingStep) TableName() string {
+	return "onboarding_steps"
+}
+
+// OnboardingProgress is where a user is with one onboarding step. A step
+// the user completed before it fell due is never sent.
+type OnboardingProgress struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_onboarding_progress_user_step,priority:1" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	StepID uuid.UUID      `gorm:"type:uuid;not null;uniqueIndex:idx_onboarding_progress_user_step,priority:2;index" json:"step_id"`
+	Step   OnboardingStep `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// MessageID is the direct message the step was sent as.
+	MessageID   *uuid.UUID `gorm:"type:uuid" json:"message_id"`
+	Message     *Message   `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	SentAt      *time.Time `json:"sent_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (OnboardingProgress) TableName() string {
+	return "onboarding_progress"
+}
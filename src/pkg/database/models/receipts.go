@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptRead      = "read"
+)
+
+// MessageReceipt records how far a member has received and read a
+// conversation. Each marker covers its message and everything before it,
+// so one row per member is enough however long the history grows.
+type MessageReceipt struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Member
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_message_receipts_conversation_user" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID         uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex:idx_message_receipts_conversation_user" json:"user_id"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Markers
+	DeliveredMessageID *uuid.UUID `gorm:"type:uuid" json:"delivered_message_id"`
+	DeliveredAt        *time.Time `json:"delivered_at"`
+	ReadMessageID      *uuid.UUID `gorm:"type:uuid" json:"read_message_id"`
+	ReadAt             *time.Time `json:"read_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (MessageReceipt) TableName() string {
+	return "message_receipts"
+}
@@ -0,0 +1,66 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReferralCode is a user's personal code for inviting people to sign up.
+// It is made the first time the user asks for it.
+type ReferralCode struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Code
+	Code string `gorm:"uniqueIndex;not null;size:16" json:"code"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (ReferralCode) TableName() string {
+	return "referral_codes"
+}
+
+// Referral attributes a signup to the user whose code it used. It
+// qualifies once the new account verifies its email, and only qualified
+// referrals count towards the referrer's rewards.
+type Referral struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Referrer and the account they brought in
+	ReferrerID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	Referrer   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ReferredID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex" json:"-"`
+	Referred   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	QualifiedAt *time.Time `gorm:"index" json:"qualified_at"`
+}
+
+func (Referral) TableName() string {
+	return "referrals"
+}
+
+// ReferralReward records a referrer reaching a milestone: a number of
+// qualified referrals that earns them a reward.
+type ReferralReward struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Referrer and the milestone they reached
+	ReferrerID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_referral_rewards_referrer_milestone" json:"-"`
+	Referrer   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Milestone  int       `gorm:"not null;uniqueIndex:idx_referral_rewards_referrer_milestone" json:"milestone"`
+
+	// Timestamps
+	ReachedAt time.Time `gorm:"not null" json:"reached_at"`
+}
+
+func (ReferralReward) TableName() string {
+	return "referral_rewards"
+}
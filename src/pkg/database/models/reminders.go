@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type Reminder struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;index:idx_reminders_user;not null" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Conversation the reminder was set from, if any
+	ConversationID *uuid.UUID `gorm:"type:uuid" json:"conversation_id"`
+
+	// Reminder
+	Text     string    `gorm:"type:text;not null" json:"text"`
+	RemindAt time.Time `gorm:"index:idx_reminders_due;not null" json:"remind_at"`
+
+	// Timestamps
+	DeliveredAt *time.Time `gorm:"index:idx_reminders_due" json:"delivered_at"`
+	CancelledAt *time.Time `json:"cancelled_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+func (Reminder) TableName() string {
+	return "reminders"
+}
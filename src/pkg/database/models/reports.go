@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Report statuses
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"
+	ReportDismissed = "dismissed"
+)
+
+// Report is a user flagging another user, or a message they sent, to the
+// moderators.
+type Report struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	ReporterID uuid.UUID `gorm:"type:uuid;not null;index" json:"reporter_id"`
+	Reporter   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// UserID is the reported account: the one reported, or the sender of
+	// the reported message.
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"user_id"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// MessageID is the reported message, nil when the account itself was
+	// reported. The report outlives the message.
+	MessageID *uuid.UUID `gorm:"type:uuid;index" json:"message_id,omitempty"`
+	Message   *Message   `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	Reason string `gorm:"type:text;not null" json:"reason"`
+	Status string `gorm:"not null;size:20;default:open;index:idx_reports_status_created,priority:1" json:"status"`
+
+	// BatchID is the bulk moderation action that settled the report.
+	BatchID *uuid.UUID       `gorm:"type:uuid;index" json:"batch_id,omitempty"`
+	Batch   *ModerationBatch `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	ResolvedByID *uuid.UUID `gorm:"type:uuid" json:"resolved_by_id,omitempty"`
+	ResolvedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_reports_status_created,priority:2" json:"created_at"`
+}
+
+func (Report) TableName() string {
+	return "reports"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomContribution counts what a member contributed to a channel, from
+// which their reputation there is computed along with the reports against
+// what they posted in it.
+type RoomContribution struct {
+	// Primary Key
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID         uuid.UUID    `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Messages int64 `gorm:"not null;default:0" json:"messages"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (RoomContribution) TableName() string {
+	return "room_contributions"
+}
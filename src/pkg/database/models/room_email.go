@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomEmailAddress is the address mail is sent to to post into a channel:
+// LocalPart at the configured inbound domain. The local part is random,
+// so only those told the address can post by mail, and owners and admins
+// replace it when it leaks.
+type RoomEmailAddress struct {
+	// Primary Key, shared with the channel's conversation
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	LocalPart string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+
+	// Creator. The address outlives their account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	LastReceivedAt *time.Time `json:"last_received_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+func (RoomEmailAddress) TableName() string {
+	return "room_email_addresses"
+}
+
+// RoomEmailRelay has a channel's new messages emailed to a member who
+// prefers mail, with the channel's address to reply to.
+type RoomEmailRelay struct {
+	// Primary Key
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"channel_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID         uuid.UUID    `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	User           User         `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (RoomEmailRelay) TableName() string {
+	return "room_email_relays"
+}
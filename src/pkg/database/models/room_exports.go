@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Where scheduled room exports are delivered: to an S3 bucket of the
+	// owner's, or by email to the owner.
+	RoomExportToS3    = "s3"
+	RoomExportToEmail = "email"
+
+	RoomExportDelivered = "delivered"
+	RoomExportFailed    = "failed"
+)
+
+// RoomExportSchedule has a JSON snapshot of a group or channel, with its
+// members and messages, delivered every IntervalDays. The room's owner
+// sets it up, and deliveries stop when they no longer own the room.
+type RoomExportSchedule struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Room
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Owner who set it up. Emailed snapshots go to them.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Destination, with the bucket for s3. Snapshots are written under
+	// S3Prefix. The secret key is sealed with the server's SECRETS_KEY, as
+	// secretbox describes, and never returned.
+	Destination string `gorm:"not null;size:10" json:"destination"`
+	S3Endpoint  string `gorm:"size:255" json:"s3_endpoint,omitempty"`
+	S3Region    string `gorm:"size:50" json:"s3_region,omitempty"`
+	S3Bucket    string `gorm:"size:63" json:"s3_bucket,omitempty"`
+	S3Prefix    string `gorm:"size:255" json:"s3_prefix,omitempty"`
+	S3AccessKey string `gorm:"size:128" json:"s3_access_key,omitempty"`
+	S3SecretKey string `gorm:"type:text" json:"-"`
+
+	// Schedule. Disabled schedules keep their settings but never run.
+	IntervalDays int        `gorm:"not null" json:"interval_days"`
+	Enabled      bool       `gorm:"not null" json:"enabled"`
+	NextRunAt    time.Time  `gorm:"not null;index" json:"next_run_at"`
+	LastRunAt    *time.Time `json:"last_run_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (RoomExportSchedule) TableName() string {
+	return "room_export_schedules"
+}
+
+// RoomExportDelivery logs one attempt of a schedule to deliver a snapshot,
+// with why it failed if it did.
+type RoomExportDelivery struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Schedule and its room
+	ScheduleID     uuid.UUID          `gorm:"type:uuid;not null;index" json:"schedule_id"`
+	Schedule       RoomExportSchedule `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID          `gorm:"type:uuid;not null;index:idx_room_export_deliveries_conversation_created,priority:1" json:"conversation_id"`
+	Conversation   Conversation       `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Outcome. Location is the object written or the address emailed.
+	Status    string  `gorm:"not null;size:20" json:"status"`
+	Location  string  `gorm:"type:text;not null" json:"location"`
+	Messages  int     `gorm:"not null;default:0" json:"messages"`
+	SizeBytes int64   `gorm:"not null;default:0" json:"size_bytes"`
+	Error     *string `gorm:"type:text" json:"error"`
+
+	// Emailed snapshots are kept in our storage, for the link in the email,
+	// until ExpiresAt.
+	StorageKey *string    `gorm:"uniqueIndex;size:255" json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_room_export_deliveries_conversation_created,priority:2" json:"created_at"`
+}
+
+func (RoomExportDelivery) TableName() string {
+	return "room_export_deliveries"
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomFAQ is an answer to a question often asked in a group or channel,
+// which its owner and admins maintain. Members asking a question sharing
+// its keywords are pointed to it, or it is posted to the room for them.
+type RoomFAQ struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Room
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Entry. Keywords are comma-separated, as for auto-replies.
+	Question string `gorm:"not null;size:300" json:"question"`
+	Keywords string `gorm:"type:text;not null" json:"keywords"`
+	Answer   string `gorm:"type:text;not null" json:"answer"`
+	Enabled  bool   `gorm:"not null" json:"enabled"`
+
+	// AutoPost posts the answer to the room rather than suggesting it to
+	// the member who asked.
+	AutoPost bool `gorm:"not null;default:false" json:"auto_post"`
+
+	// Author is who last wrote the entry, and whom answers are posted as.
+	AuthorID *uuid.UUID `gorm:"type:uuid" json:"author_id"`
+	Author   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Hits counts the questions the entry answered, AutoPosts those it
+	// answered in the room.
+	Hits         int64      `gorm:"not null;default:0" json:"hits"`
+	AutoPosts    int64      `gorm:"not null;default:0" json:"auto_posts"`
+	LastHitAt    *time.Time `json:"last_hit_at"`
+	LastPostedAt *time.Time `json:"last_posted_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (RoomFAQ) TableName() string {
+	return "room_faqs"
+}
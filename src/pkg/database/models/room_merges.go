@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomMerge records a group or channel an admin merged into another, to
+// consolidate duplicate communities. The merged room is deleted, and its
+// members asking for it are redirected to the room it went into.
+type RoomMerge struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// The merged room, merged at most once, and the room it went into.
+	// Merging the target later moves its redirects on to the new room.
+	SourceID uuid.UUID    `gorm:"type:uuid;not null;uniqueIndex" json:"source_id"`
+	Source   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	TargetID uuid.UUID    `gorm:"type:uuid;not null;index" json:"target_id"`
+	Target   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Admin who merged them. The record outlives their account.
+	MergedByID *uuid.UUID `gorm:"type:uuid" json:"merged_by_id"`
+	MergedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// What moved. Members already in the target are not counted.
+	MovedHistory  bool  `gorm:"not null" json:"moved_history"`
+	MovedMembers  int   `gorm:"not null;default:0" json:"moved_members"`
+	MovedMessages int64 `gorm:"not null;default:0" json:"moved_messages"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (RoomMerge) TableName() string {
+	return "room_merges"
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RoomNote holds the shared notes of a group or channel, such as its rules
+// and useful links, which its owner and admins edit together.
+type RoomNote struct {
+	// Primary Key, one document per room
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Content. Version counts the edits made to it.
+	Text    string `gorm:"type:text;not null;default:''" json:"text"`
+	Version int64  `gorm:"not null;default:0" json:"version"`
+
+	// Last editor
+	UpdatedByID *uuid.UUID `gorm:"type:uuid" json:"updated_by_id"`
+	UpdatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (RoomNote) TableName() string {
+	return "room_notes"
+}
+
+// RoomNoteEdit is one edit to a room's notes, the operation that produced
+// Version. Edits are kept so one made against an older version can be
+// transformed past those made since.
+type RoomNoteEdit struct {
+	// Primary Key
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Version        int64        `gorm:"primaryKey;autoIncrement:false" json:"version"`
+
+	// Author
+	AuthorID *uuid.UUID `gorm:"type:uuid" json:"author_id"`
+	Author   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Operation is the edit as an ot.Operation.
+	Operation JSON `gorm:"type:jsonb;not null" json:"operation"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (RoomNoteEdit) TableName() string {
+	return "room_note_edits"
+}
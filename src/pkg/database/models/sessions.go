@@ -0,0 +1,43 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is one signed-in device. Access tokens name the session they were
+// issued for, and the refresh token that renews them is rotated on every use.
+type Session struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_sessions_user_revoked" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Refresh token. Only SHA-256 hashes are stored; the previous hash is
+	// kept so a replayed, already-rotated token can be detected.
+	RefreshTokenHash  string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	PreviousTokenHash string `gorm:"index;size:64" json:"-"`
+
+	// Device, as last seen signing in or refreshing
+	SessionDevice `gorm:"embedded"`
+
+	// Timestamps
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `gorm:"index:idx_sessions_user_revoked" json:"-"`
+}
+
+type SessionDevice struct {
+	UserAgent     string `gorm:"size:255" json:"user_agent"`
+	IPAddress     string `gorm:"size:45" json:"ip_address"`
+	Platform      string `gorm:"size:20" json:"platform"`
+	ClientVersion string `gorm:"size:20" json:"client_version"`
+}
+
+func (Session) TableName() string {
+	return "sessions"
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What a short link was made for
+const (
+	ShortLinkInvite = "invite"
+	ShortLinkShare  = "share"
+)
+
+// ShortLink is a short /l/:code address that redirects to a longer URL,
+// such as a signup link carrying an invite code.
+type ShortLink struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	// Link
+	Code        string `gorm:"uniqueIndex;not null;size:16" json:"code"`
+	Destination string `gorm:"type:text;not null" json:"destination"`
+	Kind        string `gorm:"not null;size:20" json:"kind"`
+
+	// CreatedByID is the user who made the link. Links outlive their
+	// creator's account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid;index:idx_short_links_creator,priority:1" json:"-"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Lifetime. A disabled link was found to lead somewhere unsafe, or
+	// was taken down by staff.
+	ExpiresAt      *time.Time `json:"expires_at"`
+	DisabledAt     *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason *string    `gorm:"size:255" json:"disabled_reason,omitempty"`
+
+	// Clicks
+	Clicks        int64      `gorm:"not null;default:0" json:"clicks"`
+	LastClickedAt *time.Time `json:"last_clicked_at"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_short_links_creator,priority:2" json:"created_at"`
+}
+
+func (ShortLink) TableName() string {
+	return "short_links"
+}
+
+// ShortLinkClick is one follow of a short link. Clicks are counted by
+// platform and referring site only; nothing identifies who clicked.
+type ShortLinkClick struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	LinkID uuid.UUID `gorm:"type:uuid;not null;index:idx_short_link_clicks_link_time,priority:1" json:"-"`
+	Link   ShortLink `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Platform     string  `gorm:"not null;size:20" json:"platform"`
+	ReferrerHost *string `gorm:"size:255" json:"referrer_host"`
+
+	// Timestamps
+	ClickedAt time.Time `gorm:"not null;index:idx_short_link_clicks_link_time,priority:2" json:"clicked_at"`
+}
+
+func (ShortLinkClick) TableName() string {
+	return "short_link_clicks"
+}
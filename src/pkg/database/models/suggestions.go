@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SettingsSuggestion is what was guessed about a new user from where they
+// signed up: their country, time zone and language, and local rooms they
+// might join. The guesses are offered to the user, who may take or leave
+// them.
+type SettingsSuggestion struct {
+	// Primary Key, the user the suggestions are for
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Guesses
+	CountryCode string `gorm:"not null;size:2" json:"country_code"`
+	TimeZone    string `gorm:"size:50" json:"time_zone"`
+	Language    string `gorm:"not null;size:16" json:"language"`
+
+	// RoomIDs lists public channels popular in the country.
+	RoomIDs JSON `gorm:"type:jsonb" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (SettingsSuggestion) TableName() string {
+	return "settings_suggestions"
+}
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SyncAck records the Seq of the latest message a signed-in device has
+// acknowledged in a conversation, so that after reconnecting it can be
+// sent exactly the messages it has not got.
+type SyncAck struct {
+	// Primary Key
+	SessionID      uuid.UUID    `gorm:"type:uuid;primaryKey" json:"-"`
+	Session        Session      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	ConversationID uuid.UUID    `gorm:"type:uuid;primaryKey" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	Seq int64 `gorm:"not null" json:"seq"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (SyncAck) TableName() string {
+	return "sync_acks"
+}
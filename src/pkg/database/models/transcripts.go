@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TranscriptPending = "pending"
+	TranscriptReady   = "ready"
+	TranscriptFailed  = "failed"
+)
+
+// Transcript is a PDF of a conversation's history as a member sees it,
+// with sender names and times, rendered in the background at their
+// request.
+type Transcript struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Conversation
+	ConversationID uuid.UUID    `gorm:"type:uuid;not null;index" json:"conversation_id"`
+	Conversation   Conversation `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Member who asked for it, the only one who can download it
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Document, once rendered. It can be downloaded until ExpiresAt.
+	Status     string     `gorm:"not null;size:20" json:"status"`
+	Messages   int        `gorm:"not null;default:0" json:"messages"`
+	SizeBytes  int64      `gorm:"not null;default:0" json:"size_bytes"`
+	StorageKey *string    `gorm:"uniqueIndex;size:255" json:"-"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+
+	// Timestamps
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+func (Transcript) TableName() string {
+	return "transcripts"
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UsageStorageBytes meters the bytes an account stores: its attachments
+// and chat backups.
+const UsageStorageBytes = "storage_bytes"
+
+// UsageRecord is an account's billable usage of a meter on a day, in
+// UTC. Gauges like stored bytes hold the day's last measurement. Records
+// are exported to the billing provider once their day is over.
+type UsageRecord struct {
+	// Primary Key
+	UserID uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Meter  string    `gorm:"primaryKey;size:30" json:"meter"`
+	Day    string    `gorm:"primaryKey;size:10" json:"day"`
+
+	Quantity int64 `gorm:"not null" json:"quantity"`
+
+	ExportedAt *time.Time `gorm:"index" json:"exported_at,omitempty"`
+
+	// Timestamps
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (UsageRecord) TableName() string {
+	return "usage_records"
+}
@@ -7,6 +7,19 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Who shadow restricted an account: the abuse scorers, pending review, or
+// a moderator.
+const (
+	ShadowRestrictedByScorer    = "scorer"
+	ShadowRestrictedByModerator = "moderator"
+)
+
 type User struct {
 	// Primary Key
 	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
@@ -19,11 +32,20 @@ type User struct {
 	LastName    string  `gorm:"size:50" json:"last_name"`
 	AvatarURL   *string `gorm:"type:text" json:"avatar_url"`
 	Bio         string  `gorm:"type:text" json:"bio"`
+	AccountType string  `gorm:"default:personal;size:20" json:"account_type"`
 
 	// Contact & Location
-	PhoneNumber *string `gorm:"size:20" json:"phone_number"`
+	PhoneNumber *string `gorm:"size:32" json:"phone_number"`
 	TimeZone    string  `gorm:"default:UTC;size:50" json:"time_zone"`
 	Location    *string `gorm:"size:100" json:"location"`
+	CountryCode *string `gorm:"size:2" json:"country_code"`
+	Residency   string  `gorm:"default:default;size:16;index" json:"-"`
+
+	// PhoneE164 is PhoneNumber normalized, which numbers are compared by.
+	// PhoneDiscoverable lets users who have the number find the account
+	// by it.
+	PhoneE164         *string `gorm:"size:16;index" json:"phone_e164"`
+	PhoneDiscoverable bool    `gorm:"not null;default:false" json:"phone_discoverable"`
 
 	// Status & Permissions
 	Status      string `gorm:"default:offline;size:20" json:"status"`
@@ -32,6 +54,40 @@ type User struct {
 	IsSuspended bool   `gorm:"default:false" json:"is_suspended"`
 	IsBanned    bool   `gorm:"default:false" json:"is_banned"`
 	IsPremium   bool   `gorm:"default:false" json:"is_premium"`
+	Role        string `gorm:"default:user;size:20" json:"-"`
+
+	// SmartReplies opts the user in to reply suggestions for direct
+	// messages they receive.
+	SmartReplies bool `gorm:"not null;default:false" json:"smart_replies"`
+
+	// PublicPage lets anyone, signed in or not, see the user's public
+	// profile page, as link previews and search engines do.
+	PublicPage bool `gorm:"not null;default:false" json:"public_page"`
+
+	// AnalyticsOptIn lets the user's usage, counted anonymously, be
+	// sampled for product analytics.
+	AnalyticsOptIn bool `gorm:"not null;default:false" json:"analytics_opt_in"`
+
+	// MarketingEmails lets product news and offers be emailed to the
+	// user. Emails about the account and its activity are sent regardless.
+	MarketingEmails bool `gorm:"not null;default:false" json:"marketing_emails"`
+
+	// OnboardingMessages lets the onboarding sequence send the user its
+	// tips over their first days.
+	OnboardingMessages bool `gorm:"not null;default:true" json:"onboarding_messages"`
+
+	// USSDAccess lets the user reach their direct messages by dialling the
+	// USSD menu from PhoneE164. Changing the number turns it off.
+	USSDAccess bool `gorm:"column:ussd_access;not null;default:false" json:"ussd_access"`
+
+	// Invite the account registered with, when registration is invite-only
+	InviteCodeID *uuid.UUID `gorm:"type:uuid" json:"-"`
+
+	// Shadow restriction: messages are delivered only to the sender
+	// while a moderator reviews the account. ShadowRestrictedBy is one of
+	// the ShadowRestrictedBy values. Never exposed to clients.
+	IsShadowRestricted bool    `gorm:"default:false;index" json:"-"`
+	ShadowRestrictedBy *string `gorm:"size:50" json:"-"`
 
 	// Security
 	PasswordHash string `gorm:"not null;size:255" json:"-"`
@@ -43,11 +99,15 @@ type User struct {
 	UpdatedAt      time.Time      `json:"updated_at"`
 	DeletedAt      gorm.DeletedAt `gorm:"index" json:"-"`
 	SuspendedAt    *time.Time     `json:"suspended_at"`
+	SuspendedUntil *time.Time     `json:"suspended_until"`
+	MutedUntil     *time.Time     `json:"-"`
 	BannedAt       *time.Time     `json:"banned_at"`
+	RestrictedAt   *time.Time     `json:"-"`
 	PremiumAt      *time.Time     `json:"premium_at"`
 	LastLoginAt    *time.Time     `json:"last_login_at"`
 	LastLogoutAt   *time.Time     `json:"last_logout_at"`
 	LastActivityAt *time.Time     `json:"last_activity_at"`
+	DateOfBirth    *time.Time     `gorm:"type:date" json:"-"`
 }
 
 func (User) TableName() string {
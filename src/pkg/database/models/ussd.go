@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// USSDSession is a session of the USSD menu, named by the aggregator. It
+// keeps the direct conversations it listed, so a choice made from the
+// list picks the conversation shown even if the order changed since.
+type USSDSession struct {
+	// Primary Key: the aggregator's session ID
+	ID string `gorm:"primaryKey;size:100" json:"id"`
+
+	// User the dialling number belongs to
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// ConversationIDs are the conversations listed, in the order shown.
+	ConversationIDs JSON `gorm:"type:jsonb" json:"conversation_ids"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `gorm:"not null;index" json:"expires_at"`
+}
+
+func (USSDSession) TableName() string {
+	return "ussd_sessions"
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+
+	// An email change is confirmed from both addresses, and can then be
+	// reverted from the old one.
+	TokenEmailChangeOld    = "email_change_old"
+	TokenEmailChangeNew    = "email_change_new"
+	TokenEmailChangeRevert = "email_change_revert"
+
+	// An account refused sign-in for a suspension or ban is given a token
+	// to appeal it with.
+	TokenAppeal = "appeal"
+)
+
+// VerificationToken is a single-use token emailed to a user to prove they
+// control their address, to verify it, reset their password or change it.
+type VerificationToken struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;index:idx_verification_tokens_user_purpose" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Token. Only its SHA-256 hash is stored.
+	Purpose   string `gorm:"not null;size:32;index:idx_verification_tokens_user_purpose" json:"purpose"`
+	TokenHash string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+
+	// Timestamps
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+func (VerificationToken) TableName() string {
+	return "verification_tokens"
+}
+
+// EmailChange moves an account to a new email address. It takes effect
+// once the links emailed to both the old and the new address are followed,
+// and until RevertibleUntil the old address can undo it, in case whoever
+// asked for it had taken over the account.
+type EmailChange struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Owner
+	UserID uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	OldEmail string `gorm:"not null;size:255" json:"old_email"`
+	NewEmail string `gorm:"not null;size:255" json:"new_email"`
+
+	// Progress
+	OldConfirmedAt  *time.Time `json:"old_confirmed_at"`
+	NewConfirmedAt  *time.Time `json:"new_confirmed_at"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	RevertibleUntil *time.Time `json:"revertible_until"`
+	RevertedAt      *time.Time `json:"reverted_at"`
+	CancelledAt     *time.Time `json:"cancelled_at"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
+
+func (EmailChange) TableName() string {
+	return "email_changes"
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ViewOnceToken lets one recipient of a view-once attachment download it
+// once. A recipient holds at most one token per attachment, and once it is
+// consumed the attachment stays closed to them.
+type ViewOnceToken struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"-"`
+
+	AttachmentID uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_view_once_tokens_attachment_user,priority:1" json:"attachment_id"`
+	Attachment   Attachment `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Recipient
+	UserID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_view_once_tokens_attachment_user,priority:2;index" json:"-"`
+	User   User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Token. Only its SHA-256 hash is stored.
+	TokenHash string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+
+	// Timestamps
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at"`
+}
+
+func (ViewOnceToken) TableName() string {
+	return "view_once_tokens"
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistAdmitted = "admitted"
+)
+
+type WaitlistEntry struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Applicant
+	Email string `gorm:"uniqueIndex;not null;size:255" json:"email"`
+
+	// Token lets the applicant check their position without an account.
+	Token string `gorm:"uniqueIndex;not null;size:32" json:"-"`
+
+	// Admission
+	Status       string     `gorm:"not null;size:20;default:waiting;index:idx_waitlist_status_created,priority:1" json:"status"`
+	InviteCodeID *uuid.UUID `gorm:"type:uuid" json:"invite_code_id,omitempty"`
+	AdmittedAt   *time.Time `json:"admitted_at,omitempty"`
+
+	// Timestamps
+	CreatedAt time.Time `gorm:"index:idx_waitlist_status_created,priority:2" json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (WaitlistEntry) TableName() string {
+	return "waitlist_entries"
+}
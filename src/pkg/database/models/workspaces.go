@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultWorkspaceID is the workspace every user belongs to without being
+// added, which holds the conversations of deployments hosting a single
+// community. Its slug is DefaultWorkspaceSlug.
+var DefaultWorkspaceID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+const DefaultWorkspaceSlug = "default"
+
+// Workspace is a community hosted on the deployment. Every conversation
+// belongs to one, and only its members can see or join them.
+type Workspace struct {
+	// Primary Key
+	ID uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()" json:"id"`
+
+	// Basic Info. Slug names the workspace in URLs and the X-Workspace
+	// header.
+	Slug string `gorm:"uniqueIndex;not null;size:50" json:"slug"`
+	Name string `gorm:"not null;size:100" json:"name"`
+
+	// Creator, null for the default workspace
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (Workspace) TableName() string {
+	return "workspaces"
+}
+
+// WorkspaceMember is a user's membership of a workspace, with one of the
+// MemberRole roles. The default workspace has no rows: everyone is a
+// member.
+type WorkspaceMember struct {
+	// Primary Key
+	WorkspaceID uuid.UUID `gorm:"type:uuid;primaryKey" json:"workspace_id"`
+	Workspace   Workspace `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	UserID      uuid.UUID `gorm:"type:uuid;primaryKey;index" json:"user_id"`
+	User        User      `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Role        string    `gorm:"not null;size:20;default:member" json:"role"`
+
+	// Timestamps
+	JoinedAt time.Time `gorm:"not null" json:"joined_at"`
+}
+
+func (WorkspaceMember) TableName() string {
+	return "workspace_members"
+}
+
+// WorkspaceArchive copies the messages of a workspace's rooms to an
+// institution's archiving endpoint for compliance, signed with Secret.
+// Rooms being archived are marked ComplianceArchived, so their members
+// are told.
+type WorkspaceArchive struct {
+	// Primary Key
+	WorkspaceID uuid.UUID `gorm:"type:uuid;primaryKey" json:"workspace_id"`
+	Workspace   Workspace `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+
+	// Endpoint
+	URL    string `gorm:"type:text;not null" json:"url"`
+	Secret string `gorm:"not null;size:64" json:"-"`
+
+	// Creator. The archive outlives their account.
+	CreatedByID *uuid.UUID `gorm:"type:uuid" json:"created_by_id"`
+	CreatedBy   *User      `gorm:"constraint:OnDelete:SET NULL" json:"-"`
+
+	// Last delivery, and why it failed if it did
+	LastDeliveredAt *time.Time `json:"last_delivered_at"`
+	LastError       *string    `gorm:"type:text" json:"last_error"`
+
+	// Timestamps
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (WorkspaceArchive) TableName() string {
+	return "workspace_archives"
+}
@@ -0,0 +1,55 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AnnouncementRepository struct {
+	db *gorm.DB
+}
+
+func NewAnnouncementRepository(db *gorm.DB) *AnnouncementRepository {
+	return &AnnouncementRepository{db: db}
+}
+
+// Acknowledge records userID acknowledging the announcement, and returns
+// the acknowledgment, which is the earlier one when they already had.
+func (r *AnnouncementRepository) Acknowledge(ctx context.Context, messageID, userID uuid.UUID) (*models.AnnouncementAck, error) {
+	db := r.db.WithContext(ctx)
+	created := models.AnnouncementAck{MessageID: messageID, UserID: userID, CreatedAt: time.Now()}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&created).Error; err != nil {
+		return nil, fmt.Errorf("failed to acknowledge announcement: %w", err)
+	}
+	var ack models.AnnouncementAck
+	if err := db.Where("message_id = ? AND user_id = ?", messageID, userID).Take(&ack).Error; err != nil {
+		return nil, fmt.Errorf("failed to load acknowledgment: %w", err)
+	}
+	return &ack, nil
+}
+
+// Acks returns the acknowledgments of the announcement, earliest first.
+func (r *AnnouncementRepository) Acks(ctx context.Context, messageID uuid.UUID) ([]models.AnnouncementAck, error) {
+	acks := []models.AnnouncementAck{}
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("created_at").Find(&acks).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list acknowledgments: %w", err)
+	}
+	return acks, nil
+}
+
+// AckCount counts the acknowledgments of the announcement.
+func (r *AnnouncementRepository) AckCount(ctx context.Context, messageID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.AnnouncementAck{}).Where("message_id = ?", messageID).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count acknowledgments: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AppealRepository struct {
+	db *gorm.DB
+}
+
+func NewAppealRepository(db *gorm.DB) *AppealRepository {
+	return &AppealRepository{db: db}
+}
+
+// Appealable returns the suspension or ban keeping the user out, which
+// their appeal is against, or ErrNotFound when they are neither.
+func (r *AppealRepository) Appealable(ctx context.Context, userID uuid.UUID) (*models.ModerationAction, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Select("id", "is_banned", "is_suspended", "suspended_until").First(&user, "id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account: %w", err)
+	}
+	var kind string
+	switch {
+	case user.IsBanned:
+		kind = models.ModerationBan
+	case user.IsSuspended && (user.SuspendedUntil == nil || time.Now().Before(*user.SuspendedUntil)):
+		kind = models.ModerationSuspend
+	default:
+		return nil, ErrNotFound
+	}
+
+	var action models.ModerationAction
+	err = r.db.WithContext(ctx).
+		Where("user_id = ? AND action = ?", userID, kind).
+		Order("created_at DESC").
+		First(&action).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation action: %w", err)
+	}
+	return &action, nil
+}
+
+// File records an appeal against the action keeping its user out. It
+// returns ErrNotFound when nothing is, and gorm.ErrDuplicatedKey when the
+// action was already appealed.
+func (r *AppealRepository) File(ctx context.Context, appeal *models.Appeal) error {
+	action, err := r.Appealable(ctx, appeal.UserID)
+	if err != nil {
+		return err
+	}
+	appeal.ActionID = action.ID
+	appeal.Status = models.AppealPending
+	if err := r.db.WithContext(ctx).Create(appeal).Error; err != nil {
+		return fmt.Errorf("failed to file appeal: %w", err)
+	}
+	return nil
+}
+
+// Get returns an appeal and the action it is against, or ErrNotFound.
+func (r *AppealRepository) Get(ctx context.Context, id uuid.UUID) (*models.Appeal, error) {
+	var appeal models.Appeal
+	err := r.db.WithContext(ctx).Preload("Action").First(&appeal, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load appeal: %w", err)
+	}
+	return &appeal, nil
+}
+
+// List returns up to limit appeals with status filed after after, oldest
+// first, so the queue is worked in order.
+func (r *AppealRepository) List(ctx context.Context, status string, after *pagination.Cursor, limit int) ([]models.Appeal, error) {
+	query := r.db.WithContext(ctx).Where("status = ?", status)
+	if after != nil {
+		query = query.Where("(created_at, id) > (?, ?)", after.Time, after.ID)
+	}
+	var appeals []models.Appeal
+	if err := query.Order("created_at, id").Limit(limit).Find(&appeals).Error; err != nil {
+		return nil, fmt.Errorf("failed to list appeals: %w", err)
+	}
+	return appeals, nil
+}
+
+// Decide settles a pending appeal on behalf of reviewerID. Granting it
+// lifts the suspension or ban, recorded in the moderation log, if it is
+// still in force. It returns ErrNotFound when there is no such pending
+// appeal.
+func (r *AppealRepository) Decide(ctx context.Context, id, reviewerID uuid.UUID, granted bool, note string) (*models.Appeal, error) {
+	var appeal models.Appeal
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Preload("Action").
+			First(&appeal, "id = ? AND status = ?", id, models.AppealPending).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load appeal: %w", err)
+		}
+
+		now := time.Now()
+		appeal.Status, appeal.ReviewerID, appeal.Note, appeal.DecidedAt = models.AppealDenied, &reviewerID, note, &now
+		if granted {
+			appeal.Status = models.AppealGranted
+			var user models.User
+			if err := tx.Select("id", "is_banned", "is_suspended").First(&user, "id = ?", appeal.UserID).Error; err != nil {
+				return fmt.Errorf("failed to load account: %w", err)
+			}
+			lift := ""
+			switch {
+			case appeal.Action.Action == models.ModerationBan && user.IsBanned:
+				lift = models.ModerationUnban
+			case appeal.Action.Action == models.ModerationSuspend && user.IsSuspended:
+				lift = models.ModerationUnsuspend
+			}
+			if lift != "" {
+				err := applyModeration(tx, &models.ModerationAction{
+					UserID:  appeal.UserID,
+					ActorID: &reviewerID,
+					Action:  lift,
+					Reason:  "appeal granted: " + note,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return tx.Model(&appeal).Updates(map[string]any{
+			"status":      appeal.Status,
+			"reviewer_id": reviewerID,
+			"note":        note,
+			"decided_at":  now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &appeal, nil
+}
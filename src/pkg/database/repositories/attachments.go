@@ -0,0 +1,85 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AttachmentRepository struct {
+	db *gorm.DB
+}
+
+func NewAttachmentRepository(db *gorm.DB) *AttachmentRepository {
+	return &AttachmentRepository{db: db}
+}
+
+// Create stores a new attachment record.
+func (r *AttachmentRepository) Create(ctx context.Context, attachment *models.Attachment) error {
+	if err := r.db.WithContext(ctx).Create(attachment).Error; err != nil {
+		return fmt.Errorf("failed to create attachment: %w", err)
+	}
+	return nil
+}
+
+// Get loads an attachment.
+func (r *AttachmentRepository) Get(ctx context.Context, id uuid.UUID) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := r.db.WithContext(ctx).First(&attachment, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load attachment: %w", err)
+	}
+	return &attachment, nil
+}
+
+// MarkReady records that the bytes of a pending upload have arrived.
+func (r *AttachmentRepository) MarkReady(ctx context.Context, attachment *models.Attachment, sizeBytes int64) error {
+	err := r.db.WithContext(ctx).Model(attachment).
+		Updates(map[string]any{"status": models.AttachmentReady, "size_bytes": sizeBytes}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update attachment: %w", err)
+	}
+	return nil
+}
+
+// CountSince counts the uploads a user started at or after since.
+func (r *AttachmentRepository) CountSince(ctx context.Context, uploaderID uuid.UUID, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Attachment{}).
+		Where("uploader_id = ? AND created_at >= ?", uploaderID, since).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count uploads: %w", err)
+	}
+	return count, nil
+}
+
+// IsVisibleTo reports whether userID may fetch the attachment: its uploader
+// always can, and members of the conversation it was sent to can once it
+// has been sent.
+func (r *AttachmentRepository) IsVisibleTo(ctx context.Context, attachment *models.Attachment, userID uuid.UUID) (bool, error) {
+	if attachment.UploaderID == userID {
+		return true, nil
+	}
+	if attachment.MessageID == nil {
+		return false, nil
+	}
+
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Message{}).
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id AND conversation_members.deleted_at IS NULL").
+		Where("messages.id = ? AND conversation_members.user_id = ?", *attachment.MessageID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check attachment access: %w", err)
+	}
+	return count > 0, nil
+}
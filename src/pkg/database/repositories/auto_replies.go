@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AutoReplyRepository struct {
+	db *gorm.DB
+}
+
+func NewAutoReplyRepository(db *gorm.DB) *AutoReplyRepository {
+	return &AutoReplyRepository{db: db}
+}
+
+// List returns a user's auto-reply rules, highest priority first.
+func (r *AutoReplyRepository) List(ctx context.Context, ownerID uuid.UUID) ([]models.AutoReplyRule, error) {
+	var rules []models.AutoReplyRule
+	err := r.db.WithContext(ctx).
+		Where("owner_id = ?", ownerID).
+		Order("priority DESC, created_at ASC, id ASC").
+		Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list auto-reply rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Enabled returns a user's enabled auto-reply rules.
+func (r *AutoReplyRepository) Enabled(ctx context.Context, ownerID uuid.UUID) ([]models.AutoReplyRule, error) {
+	var rules []models.AutoReplyRule
+	err := r.db.WithContext(ctx).
+		Where("owner_id = ? AND enabled = ?", ownerID, true).
+		Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auto-reply rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Get loads one of a user's auto-reply rules.
+func (r *AutoReplyRepository) Get(ctx context.Context, ownerID, id uuid.UUID) (*models.AutoReplyRule, error) {
+	var rule models.AutoReplyRule
+	err := r.db.WithContext(ctx).First(&rule, "id = ? AND owner_id = ?", id, ownerID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load auto-reply rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// Create adds a rule, failing with ErrLimitReached when its owner already
+// has limit rules.
+func (r *AutoReplyRepository) Create(ctx context.Context, rule *models.AutoReplyRule, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.AutoReplyRule{}).Where("owner_id = ?", rule.OwnerID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count auto-reply rules: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		enabled := rule.Enabled
+		if err := tx.Create(rule).Error; err != nil {
+			return fmt.Errorf("failed to create auto-reply rule: %w", err)
+		}
+		// Enabled defaults to true, which a disabled rule's zero value
+		// does not override on insert.
+		if !enabled {
+			if err := tx.Model(rule).Update("enabled", false).Error; err != nil {
+				return fmt.Errorf("failed to create auto-reply rule: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Update saves every setting of a rule.
+func (r *AutoReplyRepository) Update(ctx context.Context, rule *models.AutoReplyRule) error {
+	err := r.db.WithContext(ctx).Model(rule).
+		Select("kind", "keywords", "reply", "priority", "enabled", "days", "start_minute", "end_minute", "updated_at").
+		Updates(rule).Error
+	if err != nil {
+		return fmt.Errorf("failed to update auto-reply rule: %w", err)
+	}
+	return nil
+}
+
+// Delete removes one of a user's auto-reply rules.
+func (r *AutoReplyRepository) Delete(ctx context.Context, ownerID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.AutoReplyRule{}, "id = ? AND owner_id = ?", id, ownerID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete auto-reply rule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
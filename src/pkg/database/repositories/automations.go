@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type AutomationRepository struct {
+	db *gorm.DB
+}
+
+func NewAutomationRepository(db *gorm.DB) *AutomationRepository {
+	return &AutomationRepository{db: db}
+}
+
+// Create stores a new rule, failing with ErrLimitReached when its channel
+// already has limit of them.
+func (r *AutomationRepository) Create(ctx context.Context, rule *models.AutomationRule, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.AutomationRule{}).Where("conversation_id = ?", rule.ConversationID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count automation rules: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Omit("Conversation", "Bot", "Label", "CreatedBy").Create(rule).Error; err != nil {
+			return fmt.Errorf("failed to create automation rule: %w", err)
+		}
+		return nil
+	})
+}
+
+// List returns a channel's rules, oldest first.
+func (r *AutomationRepository) List(ctx context.Context, conversationID uuid.UUID) ([]models.AutomationRule, error) {
+	rules := []models.AutomationRule{}
+	if err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Order("created_at").Find(&rules).Error; err != nil {
+		return nil, fmt.Errorf("failed to list automation rules: %w", err)
+	}
+	return rules, nil
+}
+
+// Get loads a rule, or returns ErrNotFound.
+func (r *AutomationRepository) Get(ctx context.Context, id uuid.UUID) (*models.AutomationRule, error) {
+	var rule models.AutomationRule
+	err := r.db.WithContext(ctx).First(&rule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load automation rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// Triggered returns the channel's enabled rules with the trigger.
+func (r *AutomationRepository) Triggered(ctx context.Context, conversationID uuid.UUID, trigger string) ([]models.AutomationRule, error) {
+	var rules []models.AutomationRule
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND trigger_type = ? AND enabled = ?", conversationID, trigger, true).
+		Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load automation rules: %w", err)
+	}
+	return rules, nil
+}
+
+// SetEnabled turns one of a channel's rules on or off.
+func (r *AutomationRepository) SetEnabled(ctx context.Context, conversationID, id uuid.UUID, enabled bool) (*models.AutomationRule, error) {
+	result := r.db.WithContext(ctx).Model(&models.AutomationRule{}).
+		Where("id = ? AND conversation_id = ?", id, conversationID).
+		Update("enabled", enabled)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update automation rule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return r.Get(ctx, id)
+}
+
+// Delete removes one of a channel's rules with its execution log.
+func (r *AutomationRepository) Delete(ctx context.Context, conversationID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND conversation_id = ?", id, conversationID).Delete(&models.AutomationRule{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete automation rule: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordRun adds to a rule's execution log, which keeps its latest keep
+// entries.
+func (r *AutomationRepository) RecordRun(ctx context.Context, run *models.AutomationRun, keep int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Rule").Create(run).Error; err != nil {
+			return fmt.Errorf("failed to record automation run: %w", err)
+		}
+		latest := tx.Model(&models.AutomationRun{}).Select("id").
+			Where("rule_id = ?", run.RuleID).
+			Order("created_at DESC").
+			Limit(keep)
+		err := tx.Where("rule_id = ? AND id NOT IN (?)", run.RuleID, latest).Delete(&models.AutomationRun{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to prune automation runs: %w", err)
+		}
+		return nil
+	})
+}
+
+// Runs returns one of a channel's rule's execution log, newest first, or
+// ErrNotFound when the channel has no such rule.
+func (r *AutomationRepository) Runs(ctx context.Context, conversationID, ruleID uuid.UUID) ([]models.AutomationRun, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.AutomationRule{}).
+		Where("id = ? AND conversation_id = ?", ruleID, conversationID).
+		Count(&count).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load automation rule: %w", err)
+	}
+	if count == 0 {
+		return nil, ErrNotFound
+	}
+	runs := []models.AutomationRun{}
+	if err := r.db.WithContext(ctx).Where("rule_id = ?", ruleID).Order("created_at DESC").Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list automation runs: %w", err)
+	}
+	return runs, nil
+}
@@ -0,0 +1,64 @@
+package repositories_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+func TestAutomationRunsKeepLatest(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	room := createRoom(t, db, owner.ID)
+
+	automations := repositories.NewAutomationRepository(db.DB)
+	rule := models.AutomationRule{ConversationID: room.ID, Name: "greet", Trigger: models.AutomationMemberJoined, Action: models.AutomationCallWebhook, Enabled: true}
+	if err := automations.Create(ctx, &rule, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := automations.Create(ctx, &models.AutomationRule{ConversationID: room.ID, Name: "again", Trigger: models.AutomationMemberJoined, Action: models.AutomationCallWebhook}, 1); !errors.Is(err, repositories.ErrLimitReached) {
+		t.Fatalf("second rule err = %v, want ErrLimitReached", err)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	var latest uuid.UUID
+	for i := range 5 {
+		run := models.AutomationRun{RuleID: rule.ID, UserID: &owner.ID, Status: models.AutomationRunSucceeded, CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if err := automations.RecordRun(ctx, &run, 3); err != nil {
+			t.Fatal(err)
+		}
+		latest = run.ID
+	}
+	runs, err := automations.Runs(ctx, room.ID, rule.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].ID != latest {
+		t.Fatalf("kept %d runs starting %v, want 3 starting %v", len(runs), runs[0].ID, latest)
+	}
+	if _, err := automations.Runs(ctx, uuid.New(), rule.ID); !errors.Is(err, repositories.ErrNotFound) {
+		t.Fatalf("runs of another channel's rule err = %v, want ErrNotFound", err)
+	}
+
+	triggered, err := automations.Triggered(ctx, room.ID, models.AutomationMemberJoined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(triggered) != 1 {
+		t.Fatalf("triggered %d rules, want 1", len(triggered))
+	}
+	if _, err := automations.SetEnabled(ctx, room.ID, rule.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if triggered, err = automations.Triggered(ctx, room.ID, models.AutomationMemberJoined); err != nil || len(triggered) != 0 {
+		t.Fatalf("triggered %d disabled rules (err %v), want none", len(triggered), err)
+	}
+}
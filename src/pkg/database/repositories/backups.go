@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BackupRepository struct {
+	db *gorm.DB
+}
+
+func NewBackupRepository(db *gorm.DB) *BackupRepository {
+	return &BackupRepository{db: db}
+}
+
+// Create stores a new pending backup as the user's next version. A user
+// uploads one backup at a time, so any upload they left pending is removed
+// and returned for the caller to clean up after.
+func (r *BackupRepository) Create(ctx context.Context, backup *models.Backup) ([]models.Backup, error) {
+	var stale []models.Backup
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Returning{}).
+			Where("user_id = ? AND status = ?", backup.UserID, models.BackupPending).
+			Delete(&stale).Error; err != nil {
+			return err
+		}
+
+		var latest int
+		if err := tx.Model(&models.Backup{}).
+			Where("user_id = ?", backup.UserID).
+			Select("COALESCE(MAX(version), 0)").
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+		backup.Version = latest + 1
+		return tx.Create(backup).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backup: %w", err)
+	}
+	return stale, nil
+}
+
+// Get loads one of the user's backups.
+func (r *BackupRepository) Get(ctx context.Context, userID, id uuid.UUID) (*models.Backup, error) {
+	var backup models.Backup
+	err := r.db.WithContext(ctx).First(&backup, "id = ? AND user_id = ?", id, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load backup: %w", err)
+	}
+	return &backup, nil
+}
+
+// ListReady returns the user's completed backups, newest first.
+func (r *BackupRepository) ListReady(ctx context.Context, userID uuid.UUID) ([]models.Backup, error) {
+	var backups []models.Backup
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, models.BackupReady).
+		Order("version DESC").
+		Find(&backups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	return backups, nil
+}
+
+// MarkReady completes a pending backup and removes all but the user's keep
+// newest completed backups, returning the removed ones for the caller to
+// clean up after.
+func (r *BackupRepository) MarkReady(ctx context.Context, backup *models.Backup, keep int) ([]models.Backup, error) {
+	var pruned []models.Backup
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(backup).Update("status", models.BackupReady).Error; err != nil {
+			return err
+		}
+
+		newest := tx.Model(&models.Backup{}).
+			Select("id").
+			Where("user_id = ? AND status = ?", backup.UserID, models.BackupReady).
+			Order("version DESC").
+			Limit(keep)
+		return tx.Clauses(clause.Returning{}).
+			Where("user_id = ? AND status = ? AND id NOT IN (?)", backup.UserID, models.BackupReady, newest).
+			Delete(&pruned).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete backup: %w", err)
+	}
+	return pruned, nil
+}
+
+// Delete removes a backup.
+func (r *BackupRepository) Delete(ctx context.Context, backup *models.Backup) error {
+	if err := r.db.WithContext(ctx).Delete(backup).Error; err != nil {
+		return fmt.Errorf("failed to delete backup: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BadgeRepository struct {
+	db *gorm.DB
+}
+
+func NewBadgeRepository(db *gorm.DB) *BadgeRepository {
+	return &BadgeRepository{db: db}
+}
+
+// RecordActivity notes that the user was active on the day of at.
+// Recording the same day again does nothing.
+func (r *BadgeRepository) RecordActivity(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	day := models.ActivityDay{UserID: userID, Day: at.UTC().Format(models.ActivityDayLayout)}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Omit("User").
+		Create(&day).Error
+	if err != nil {
+		return fmt.Errorf("failed to record activity: %w", err)
+	}
+	return nil
+}
+
+// PurgeActivity deletes the activity of days before the day of before,
+// returning how many were deleted.
+func (r *BadgeRepository) PurgeActivity(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("day < ?", before.UTC().Format(models.ActivityDayLayout)).Delete(&models.ActivityDay{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge activity: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Award gives the badge to the users who do not have it yet, returning
+// how many earned it now.
+func (r *BadgeRepository) Award(ctx context.Context, badge string, userIDs []uuid.UUID, now time.Time) (int64, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	awards := make([]models.UserBadge, len(userIDs))
+	for i, userID := range userIDs {
+		awards[i] = models.UserBadge{UserID: userID, Badge: badge, AwardedAt: now}
+	}
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Omit("User").
+		CreateInBatches(awards, 500)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to award badges: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// List returns the user's badges, oldest first, leaving out those they
+// hid unless withHidden.
+func (r *BadgeRepository) List(ctx context.Context, userID uuid.UUID, withHidden bool) ([]models.UserBadge, error) {
+	badges := []models.UserBadge{}
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if !withHidden {
+		query = query.Where("hidden = ?", false)
+	}
+	if err := query.Order("awarded_at, badge").Find(&badges).Error; err != nil {
+		return nil, fmt.Errorf("failed to list badges: %w", err)
+	}
+	return badges, nil
+}
+
+// SetHidden hides one of the user's badges from their profile or shows
+// it again, or returns ErrNotFound when they have not earned it.
+func (r *BadgeRepository) SetHidden(ctx context.Context, userID uuid.UUID, badge string, hidden bool) (*models.UserBadge, error) {
+	result := r.db.WithContext(ctx).Model(&models.UserBadge{}).
+		Where("user_id = ? AND badge = ?", userID, badge).
+		Update("hidden", hidden)
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update badge: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	var updated models.UserBadge
+	if err := r.db.WithContext(ctx).First(&updated, "user_id = ? AND badge = ?", userID, badge).Error; err != nil {
+		return nil, fmt.Errorf("failed to load badge: %w", err)
+	}
+	return &updated, nil
+}
+
+// EarlyAdopters returns the first limit people to sign up, bots left out.
+func (r *BadgeRepository) EarlyAdopters(ctx context.Context, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Where("id NOT IN (?)", r.db.Model(&models.Bot{}).Select("user_id")).
+		Order("created_at, id").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find early adopters: %w", err)
+	}
+	return ids, nil
+}
+
+// CommunityBuilders returns the owners of channels with at least
+// minMembers members and the users with at least minReferrals qualified
+// referrals.
+func (r *BadgeRepository) CommunityBuilders(ctx context.Context, minMembers, minReferrals int) ([]uuid.UUID, error) {
+	var owners []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.ConversationMember{}).
+		Distinct("conversation_members.user_id").
+		Joins("JOIN channels ON channels.conversation_id = conversation_members.conversation_id AND channels.deleted_at IS NULL").
+		Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id").
+		Where("conversation_members.role = ? AND conversations.member_count >= ?", models.MemberRoleOwner, minMembers).
+		Pluck("conversation_members.user_id", &owners).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find channel owners: %w", err)
+	}
+	var referrers []uuid.UUID
+	err = r.db.WithContext(ctx).Model(&models.Referral{}).
+		Where("qualified_at IS NOT NULL").
+		Group("referrer_id").
+		Having("COUNT(*) >= ?", minReferrals).
+		Pluck("referrer_id", &referrers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find referrers: %w", err)
+	}
+	return append(owners, referrers...), nil
+}
+
+// Streaks returns the users active on each of the days days ending with
+// the day of last.
+func (r *BadgeRepository) Streaks(ctx context.Context, last time.Time, days int) ([]uuid.UUID, error) {
+	last = last.UTC()
+	first := last.AddDate(0, 0, 1-days)
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.ActivityDay{}).
+		Where("day >= ? AND day <= ?", first.Format(models.ActivityDayLayout), last.Format(models.ActivityDayLayout)).
+		Group("user_id").
+		Having("COUNT(*) >= ?", days).
+		Pluck("user_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find streaks: %w", err)
+	}
+	return ids, nil
+}
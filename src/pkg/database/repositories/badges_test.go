@@ -0,0 +1,73 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+)
+
+func TestBadgeStreaks(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	steady := createUser(t, db, "steady")
+	gap := createUser(t, db, "gap")
+
+	badges := repositories.NewBadgeRepository(db.DB)
+	now := time.Date(2026, 3, 10, 8, 0, 0, 0, time.UTC)
+	for i := range 7 {
+		day := now.AddDate(0, 0, -i)
+		for range 2 {
+			if err := badges.RecordActivity(ctx, steady.ID, day); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i != 3 {
+			if err := badges.RecordActivity(ctx, gap.ID, day); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	streaks, err := badges.Streaks(ctx, now, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streaks) != 1 || streaks[0] != steady.ID {
+		t.Fatalf("streaks %v, want only %v", streaks, steady.ID)
+	}
+
+	for range 2 {
+		if _, err := badges.Award(ctx, models.BadgeStreak, streaks, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := badges.SetHidden(ctx, steady.ID, models.BadgeStreak, true); err != nil {
+		t.Fatal(err)
+	}
+	shown, err := badges.List(ctx, steady.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := badges.List(ctx, steady.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shown) != 0 || len(all) != 1 {
+		t.Fatalf("shown %d and all %d badges, want 0 and 1", len(shown), len(all))
+	}
+	if _, err := badges.SetHidden(ctx, gap.ID, models.BadgeStreak, true); err != repositories.ErrNotFound {
+		t.Fatalf("hiding an unearned badge err = %v, want ErrNotFound", err)
+	}
+
+	purged, err := badges.PurgeActivity(ctx, now.AddDate(0, 0, -5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Fatalf("purged %d days, want 2", purged)
+	}
+}
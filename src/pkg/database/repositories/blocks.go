@@ -0,0 +1,110 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BlockRepository struct {
+	db *gorm.DB
+}
+
+func NewBlockRepository(db *gorm.DB) *BlockRepository {
+	return &BlockRepository{db: db}
+}
+
+// Block blocks blockedID for blockerID, ending any contact or pending
+// request between them. Blocking twice is not an error.
+func (r *BlockRepository) Block(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "blocker_id"}, {Name: "blocked_id"}},
+			DoNothing: true,
+		}).Create(&models.Block{BlockerID: blockerID, BlockedID: blockedID}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("pair_key = ?", directKey(blockerID, blockedID)).Delete(&models.Contact{}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+	return nil
+}
+
+// Unblock removes a block. It returns ErrNotFound if there was none.
+func (r *BlockRepository) Unblock(ctx context.Context, blockerID, blockedID uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).
+		Delete(&models.Block{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to unblock user: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// List returns the users blockerID has blocked, most recent first, with
+// the blocked users loaded.
+func (r *BlockRepository) List(ctx context.Context, blockerID uuid.UUID) ([]models.Block, error) {
+	var blocks []models.Block
+	err := r.db.WithContext(ctx).
+		Preload("Blocked").
+		Where("blocker_id = ?", blockerID).
+		Order("created_at DESC").
+		Find(&blocks).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blocked users: %w", err)
+	}
+	return blocks, nil
+}
+
+// Between reports whether either user has blocked the other.
+func (r *BlockRepository) Between(ctx context.Context, userA, userB uuid.UUID) (bool, error) {
+	found, err := blocked(r.db.WithContext(ctx), userA, userB)
+	if err != nil {
+		return false, fmt.Errorf("failed to check blocks: %w", err)
+	}
+	return found, nil
+}
+
+// InDirect reports whether the sender and the other member of a direct
+// conversation have blocked one another. It is false for any other kind
+// of conversation.
+func (r *BlockRepository) InDirect(ctx context.Context, conversationID, senderID uuid.UUID) (bool, error) {
+	var found bool
+	err := r.db.WithContext(ctx).Raw(`SELECT EXISTS (
+		SELECT 1 FROM conversations
+		JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.user_id <> ?
+		JOIN blocks ON (blocks.blocker_id = conversation_members.user_id AND blocks.blocked_id = ?)
+			OR (blocks.blocker_id = ? AND blocks.blocked_id = conversation_members.user_id)
+		WHERE conversations.id = ? AND conversations.kind = ?
+	)`, senderID, senderID, senderID, conversationID, models.ConversationDirect).Scan(&found).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check blocks: %w", err)
+	}
+	return found, nil
+}
+
+// BlockedWith returns a subquery selecting the users who have blocked
+// userID or whom userID has blocked.
+func BlockedWith(db *gorm.DB, userID uuid.UUID) *gorm.DB {
+	return db.Model(&models.Block{}).
+		Select("CASE WHEN blocker_id = ? THEN blocked_id ELSE blocker_id END", userID).
+		Where("blocker_id = ? OR blocked_id = ?", userID, userID)
+}
+
+func blocked(db *gorm.DB, userA, userB uuid.UUID) (bool, error) {
+	var count int64
+	err := db.Model(&models.Block{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userA, userB, userB, userA).
+		Count(&count).Error
+	return count > 0, err
+}
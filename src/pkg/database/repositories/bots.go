@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// keyTouchInterval is how stale an API key's last use may get before using
+// it records the time again, so busy bots do not write on every request.
+const keyTouchInterval = time.Minute
+
+type BotRepository struct {
+	db *gorm.DB
+}
+
+func NewBotRepository(db *gorm.DB) *BotRepository {
+	return &BotRepository{db: db}
+}
+
+// Create stores a bot's user and the bot together. A taken username fails
+// with gorm.ErrDuplicatedKey.
+func (r *BotRepository) Create(ctx context.Context, user *models.User, bot *models.Bot) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		bot.UserID = user.ID
+		bot.User = *user
+		return tx.Omit("User", "Owner").Create(bot).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create bot: %w", err)
+	}
+	return nil
+}
+
+// ListForOwner returns a user's bots with their users, oldest first.
+func (r *BotRepository) ListForOwner(ctx context.Context, ownerID uuid.UUID) ([]models.Bot, error) {
+	var bots []models.Bot
+	err := r.db.WithContext(ctx).
+		Joins("User").
+		Where("bots.owner_id = ?", ownerID).
+		Order("bots.created_at").
+		Find(&bots).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bots: %w", err)
+	}
+	return bots, nil
+}
+
+// GetForOwner returns one of a user's bots with its user.
+func (r *BotRepository) GetForOwner(ctx context.Context, ownerID, botID uuid.UUID) (*models.Bot, error) {
+	var bot models.Bot
+	err := r.db.WithContext(ctx).
+		Joins("User").
+		Where("bots.user_id = ? AND bots.owner_id = ?", botID, ownerID).
+		First(&bot).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load bot: %w", err)
+	}
+	return &bot, nil
+}
+
+// Delete removes a bot with its keys and webhooks. Its user is deleted
+// like any other account, so its messages keep their sender.
+func (r *BotRepository) Delete(ctx context.Context, bot *models.Bot) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.Bot{}, "user_id = ?", bot.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.User{}, "id = ?", bot.UserID).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete bot: %w", err)
+	}
+	return nil
+}
+
+// IsBot reports whether a user is a bot.
+func (r *BotRepository) IsBot(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var count int64
+	if err := r.db.WithContext(ctx).Model(&models.Bot{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check bot: %w", err)
+	}
+	return count > 0, nil
+}
+
+// CreateKey stores a new API key.
+func (r *BotRepository) CreateKey(ctx context.Context, key *models.APIKey) error {
+	if err := r.db.WithContext(ctx).Omit("Bot").Create(key).Error; err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+// Keys returns a bot's API keys, oldest first.
+func (r *BotRepository) Keys(ctx context.Context, botID uuid.UUID) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := r.db.WithContext(ctx).Where("bot_id = ?", botID).Order("created_at").Find(&keys).Error; err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// DeleteKey revokes one of a bot's API keys.
+func (r *BotRepository) DeleteKey(ctx context.Context, botID, keyID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND bot_id = ?", keyID, botID).Delete(&models.APIKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke API key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Authenticate returns the API key with hash, recording that it was used.
+func (r *BotRepository) Authenticate(ctx context.Context, hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.db.WithContext(ctx).Where("key_hash = ?", hash).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API key: %w", err)
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > keyTouchInterval {
+		// Recording the use is best effort; the key is valid regardless.
+		r.db.WithContext(ctx).Model(&key).Update("last_used_at", now)
+	}
+	return &key, nil
+}
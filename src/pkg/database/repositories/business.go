@@ -0,0 +1,392 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type BusinessRepository struct {
+	db *gorm.DB
+}
+
+func NewBusinessRepository(db *gorm.DB) *BusinessRepository {
+	return &BusinessRepository{db: db}
+}
+
+// Profile loads a user's business profile.
+func (r *BusinessRepository) Profile(ctx context.Context, userID uuid.UUID) (*models.BusinessProfile, error) {
+	var profile models.BusinessProfile
+	err := r.db.WithContext(ctx).First(&profile, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load business profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// SaveProfile creates or updates a user's business profile and makes the
+// account a business account.
+func (r *BusinessRepository) SaveProfile(ctx context.Context, profile *models.BusinessProfile) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if profile.ID == uuid.Nil {
+			err = tx.Create(profile).Error
+		} else {
+			err = tx.Model(profile).
+				Select("name", "category", "description", "website", "email", "address",
+					"is_verified", "verified_at", "away_enabled", "away_message", "updated_at").
+				Updates(profile).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to save business profile: %w", err)
+		}
+		err = tx.Model(&models.User{}).
+			Where("id = ?", profile.UserID).
+			Update("account_type", models.AccountTypeBusiness).Error
+		if err != nil {
+			return fmt.Errorf("failed to update account type: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteProfile removes a user's business profile with its catalog and
+// quick replies, and makes the account a personal one again.
+func (r *BusinessRepository) DeleteProfile(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Hard deleted, so the user can set up a business profile again.
+		result := tx.Unscoped().Delete(&models.BusinessProfile{}, "user_id = ?", userID)
+		if result.Error != nil {
+			return fmt.Errorf("failed to delete business profile: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		err := tx.Model(&models.User{}).
+			Where("id = ? AND account_type = ?", userID, models.AccountTypeBusiness).
+			Update("account_type", models.AccountTypePersonal).Error
+		if err != nil {
+			return fmt.Errorf("failed to update account type: %w", err)
+		}
+		return nil
+	})
+}
+
+// CatalogItems returns a business's catalog by name, only the available
+// items unless all is set.
+func (r *BusinessRepository) CatalogItems(ctx context.Context, businessID uuid.UUID, all bool) ([]models.CatalogItem, error) {
+	items := []models.CatalogItem{}
+	query := r.db.WithContext(ctx).Where("business_id = ?", businessID)
+	if !all {
+		query = query.Where("is_available = ?", true)
+	}
+	if err := query.Order("name ASC, id ASC").Find(&items).Error; err != nil {
+		return nil, fmt.Errorf("failed to list catalog items: %w", err)
+	}
+	return items, nil
+}
+
+// CatalogItem loads one item of a business's catalog.
+func (r *BusinessRepository) CatalogItem(ctx context.Context, businessID, id uuid.UUID) (*models.CatalogItem, error) {
+	var item models.CatalogItem
+	err := r.db.WithContext(ctx).First(&item, "id = ? AND business_id = ?", id, businessID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load catalog item: %w", err)
+	}
+	return &item, nil
+}
+
+// CreateCatalogItem adds an item to a business's catalog, failing with
+// ErrLimitReached when the catalog already has limit items.
+func (r *BusinessRepository) CreateCatalogItem(ctx context.Context, item *models.CatalogItem, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.CatalogItem{}).Where("business_id = ?", item.BusinessID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count catalog items: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		available := item.IsAvailable
+		if err := tx.Create(item).Error; err != nil {
+			return fmt.Errorf("failed to create catalog item: %w", err)
+		}
+		// IsAvailable defaults to true, which an unavailable item's zero
+		// value does not override on insert.
+		if !available {
+			if err := tx.Model(item).Update("is_available", false).Error; err != nil {
+				return fmt.Errorf("failed to create catalog item: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// UpdateCatalogItem saves every field of a catalog item.
+func (r *BusinessRepository) UpdateCatalogItem(ctx context.Context, item *models.CatalogItem) error {
+	err := r.db.WithContext(ctx).Model(item).
+		Select("name", "description", "price_cents", "currency", "image_url", "is_available", "updated_at").
+		Updates(item).Error
+	if err != nil {
+		return fmt.Errorf("failed to update catalog item: %w", err)
+	}
+	return nil
+}
+
+// DeleteCatalogItem removes an item from a business's catalog.
+func (r *BusinessRepository) DeleteCatalogItem(ctx context.Context, businessID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.CatalogItem{}, "id = ? AND business_id = ?", id, businessID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete catalog item: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// QuickReplies returns a business's quick replies by shortcut.
+func (r *BusinessRepository) QuickReplies(ctx context.Context, businessID uuid.UUID) ([]models.QuickReply, error) {
+	replies := []models.QuickReply{}
+	err := r.db.WithContext(ctx).
+		Where("business_id = ?", businessID).
+		Order("shortcut ASC").
+		Find(&replies).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quick replies: %w", err)
+	}
+	return replies, nil
+}
+
+// QuickReply loads one of a business's quick replies.
+func (r *BusinessRepository) QuickReply(ctx context.Context, businessID, id uuid.UUID) (*models.QuickReply, error) {
+	var reply models.QuickReply
+	err := r.db.WithContext(ctx).First(&reply, "id = ? AND business_id = ?", id, businessID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quick reply: %w", err)
+	}
+	return &reply, nil
+}
+
+// CreateQuickReply adds a quick reply, failing with ErrLimitReached when
+// the business already has limit of them and with gorm.ErrDuplicatedKey
+// when its shortcut is taken.
+func (r *BusinessRepository) CreateQuickReply(ctx context.Context, reply *models.QuickReply, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.QuickReply{}).Where("business_id = ?", reply.BusinessID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count quick replies: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Create(reply).Error; err != nil {
+			return fmt.Errorf("failed to create quick reply: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateQuickReply saves the shortcut and text of a quick reply, failing
+// with gorm.ErrDuplicatedKey when the shortcut is taken.
+func (r *BusinessRepository) UpdateQuickReply(ctx context.Context, reply *models.QuickReply) error {
+	err := r.db.WithContext(ctx).Model(reply).
+		Select("shortcut", "text", "updated_at").
+		Updates(reply).Error
+	if err != nil {
+		return fmt.Errorf("failed to update quick reply: %w", err)
+	}
+	return nil
+}
+
+// DeleteQuickReply removes one of a business's quick replies.
+func (r *BusinessRepository) DeleteQuickReply(ctx context.Context, businessID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.QuickReply{}, "id = ? AND business_id = ?", id, businessID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete quick reply: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Labels returns a business's conversation labels by name.
+func (r *BusinessRepository) Labels(ctx context.Context, businessID uuid.UUID) ([]models.ConversationLabel, error) {
+	labels := []models.ConversationLabel{}
+	err := r.db.WithContext(ctx).
+		Where("business_id = ?", businessID).
+		Order("name ASC").
+		Find(&labels).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list labels: %w", err)
+	}
+	return labels, nil
+}
+
+// Label loads one of a business's conversation labels.
+func (r *BusinessRepository) Label(ctx context.Context, businessID, id uuid.UUID) (*models.ConversationLabel, error) {
+	var label models.ConversationLabel
+	err := r.db.WithContext(ctx).First(&label, "id = ? AND business_id = ?", id, businessID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load label: %w", err)
+	}
+	return &label, nil
+}
+
+// CreateLabel adds a conversation label, failing with ErrLimitReached when
+// the business already has limit of them and with gorm.ErrDuplicatedKey
+// when its name is taken.
+func (r *BusinessRepository) CreateLabel(ctx context.Context, label *models.ConversationLabel, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ConversationLabel{}).Where("business_id = ?", label.BusinessID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count labels: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Create(label).Error; err != nil {
+			return fmt.Errorf("failed to create label: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateLabel saves the name and color of a label, failing with
+// gorm.ErrDuplicatedKey when the name is taken.
+func (r *BusinessRepository) UpdateLabel(ctx context.Context, label *models.ConversationLabel) error {
+	err := r.db.WithContext(ctx).Model(label).
+		Select("name", "color", "updated_at").
+		Updates(label).Error
+	if err != nil {
+		return fmt.Errorf("failed to update label: %w", err)
+	}
+	return nil
+}
+
+// DeleteLabel removes one of a business's labels, and so takes it off
+// every conversation it was applied to.
+func (r *BusinessRepository) DeleteLabel(ctx context.Context, businessID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.ConversationLabel{}, "id = ? AND business_id = ?", id, businessID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete label: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ApplyLabel applies a label to a conversation, doing nothing if it
+// already is.
+func (r *BusinessRepository) ApplyLabel(ctx context.Context, labelID, conversationID uuid.UUID) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.ConversationLabeling{LabelID: labelID, ConversationID: conversationID, CreatedAt: time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to apply label: %w", err)
+	}
+	return nil
+}
+
+// RemoveLabel takes a label off a conversation, returning ErrNotFound if
+// it was not applied.
+func (r *BusinessRepository) RemoveLabel(ctx context.Context, labelID, conversationID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.ConversationLabeling{}, "label_id = ? AND conversation_id = ?", labelID, conversationID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove label: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ConversationLabels returns the labels a business applied to a
+// conversation, by name.
+func (r *BusinessRepository) ConversationLabels(ctx context.Context, businessID, conversationID uuid.UUID) ([]models.ConversationLabel, error) {
+	labels := []models.ConversationLabel{}
+	err := r.db.WithContext(ctx).
+		Joins("JOIN conversation_labelings ON conversation_labelings.label_id = conversation_labels.id").
+		Where("conversation_labels.business_id = ? AND conversation_labelings.conversation_id = ?", businessID, conversationID).
+		Order("conversation_labels.name ASC").
+		Find(&labels).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversation labels: %w", err)
+	}
+	return labels, nil
+}
+
+// LabeledConversation is a conversation a business labeled, with the
+// names of its labels.
+type LabeledConversation struct {
+	Conversation models.Conversation
+	Labels       []string
+}
+
+// LabeledConversations returns the conversations of the business's owner
+// that carry one of its labels, or labelID alone when it is set, most
+// recently active first with their members.
+func (r *BusinessRepository) LabeledConversations(ctx context.Context, business *models.BusinessProfile, labelID uuid.UUID) ([]LabeledConversation, error) {
+	db := r.db.WithContext(ctx)
+	query := db.Model(&models.ConversationLabeling{}).
+		Select("conversation_labelings.conversation_id, conversation_labels.name").
+		Joins("JOIN conversation_labels ON conversation_labels.id = conversation_labelings.label_id").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversation_labelings.conversation_id AND conversation_members.deleted_at IS NULL").
+		Where("conversation_labels.business_id = ? AND conversation_members.user_id = ?", business.ID, business.UserID)
+	if labelID != uuid.Nil {
+		query = query.Where("conversation_labelings.conversation_id IN (?)",
+			db.Model(&models.ConversationLabeling{}).Select("conversation_id").Where("label_id = ?", labelID))
+	}
+	var rows []struct {
+		ConversationID uuid.UUID
+		Name           string
+	}
+	if err := query.Order("conversation_labels.name ASC").Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to list labeled conversations: %w", err)
+	}
+	labels := make(map[uuid.UUID][]string)
+	ids := make([]uuid.UUID, 0, len(rows))
+	for _, row := range rows {
+		if _, ok := labels[row.ConversationID]; !ok {
+			ids = append(ids, row.ConversationID)
+		}
+		labels[row.ConversationID] = append(labels[row.ConversationID], row.Name)
+	}
+
+	labeled := make([]LabeledConversation, 0, len(ids))
+	if len(ids) == 0 {
+		return labeled, nil
+	}
+	var conversations []models.Conversation
+	err := db.Preload("Members.User").
+		Where("id IN ?", ids).
+		Order("COALESCE(last_message_at, created_at) DESC, id DESC").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load labeled conversations: %w", err)
+	}
+	for _, conversation := range conversations {
+		labeled = append(labeled, LabeledConversation{Conversation: conversation, Labels: labels[conversation.ID]})
+	}
+	return labeled, nil
+}
@@ -0,0 +1,653 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLastOwner = errors.New("the channel owner cannot leave or be demoted; transfer ownership first")
+
+// ChannelWithRole is a channel together with the requesting user's role.
+type ChannelWithRole struct {
+	models.Channel
+	Role string `json:"role"`
+}
+
+type ChannelRepository struct {
+	db *gorm.DB
+}
+
+func NewChannelRepository(db *gorm.DB) *ChannelRepository {
+	return &ChannelRepository{db: db}
+}
+
+// Create creates a channel and its conversation in a workspace, with
+// creatorID as owner and memberIDs as members.
+func (r *ChannelRepository) Create(ctx context.Context, workspaceID, creatorID uuid.UUID, name, description string, isPrivate bool, memberIDs []uuid.UUID) (*models.Channel, error) {
+	archived, err := workspaceArchived(r.db.WithContext(ctx), workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	conversation := models.Conversation{
+		WorkspaceID:        workspaceID,
+		Kind:               models.ConversationChannel,
+		Title:              &name,
+		CreatedByID:        creatorID,
+		ComplianceArchived: archived,
+		Members:            []models.ConversationMember{{UserID: creatorID, Role: models.MemberRoleOwner, JoinedAt: now}},
+	}
+	seen := map[uuid.UUID]bool{creatorID: true}
+	for _, memberID := range memberIDs {
+		if !seen[memberID] {
+			seen[memberID] = true
+			conversation.Members = append(conversation.Members, models.ConversationMember{
+				UserID:   memberID,
+				Role:     models.MemberRoleMember,
+				JoinedAt: now,
+			})
+		}
+	}
+	conversation.MemberCount = int64(len(conversation.Members))
+
+	var channel models.Channel
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return err
+		}
+		if _, err := syncSummaries(tx, []uuid.UUID{conversation.ID}); err != nil {
+			return err
+		}
+		channel = models.Channel{
+			ConversationID: conversation.ID,
+			Name:           name,
+			Description:    description,
+			IsPrivate:      isPrivate,
+			CreatedByID:    creatorID,
+		}
+		return tx.Create(&channel).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel: %w", err)
+	}
+	return &channel, nil
+}
+
+// Get loads a channel with its conversation.
+func (r *ChannelRepository) Get(ctx context.Context, id uuid.UUID) (*models.Channel, error) {
+	var channel models.Channel
+	err := r.db.WithContext(ctx).Joins("Conversation").First(&channel, "channels.conversation_id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load channel: %w", err)
+	}
+	return &channel, nil
+}
+
+// SetListed offers a channel to search engines or withdraws it.
+func (r *ChannelRepository) SetListed(ctx context.Context, id uuid.UUID, listed bool) error {
+	err := r.db.WithContext(ctx).Model(&models.Channel{}).
+		Where("conversation_id = ?", id).
+		Update("is_listed", listed).Error
+	if err != nil {
+		return fmt.Errorf("failed to update channel listing: %w", err)
+	}
+	return nil
+}
+
+// SetJoinCaptcha turns the CAPTCHA for joining a channel on or off.
+func (r *ChannelRepository) SetJoinCaptcha(ctx context.Context, id uuid.UUID, required bool) error {
+	err := r.db.WithContext(ctx).Model(&models.Channel{}).
+		Where("conversation_id = ?", id).
+		Update("join_captcha", required).Error
+	if err != nil {
+		return fmt.Errorf("failed to update channel join captcha: %w", err)
+	}
+	return nil
+}
+
+// SetPostingReputation sets the reputation members need to post links and
+// media in a channel.
+func (r *ChannelRepository) SetPostingReputation(ctx context.Context, id uuid.UUID, reputation int) error {
+	err := r.db.WithContext(ctx).Model(&models.Channel{}).
+		Where("conversation_id = ?", id).
+		Update("posting_reputation", reputation).Error
+	if err != nil {
+		return fmt.Errorf("failed to update channel posting reputation: %w", err)
+	}
+	return nil
+}
+
+// SetAdultOnly restricts a channel to adults or lifts the restriction.
+func (r *ChannelRepository) SetAdultOnly(ctx context.Context, id uuid.UUID, adultOnly bool) error {
+	err := r.db.WithContext(ctx).Model(&models.Channel{}).
+		Where("conversation_id = ?", id).
+		Update("adult_only", adultOnly).Error
+	if err != nil {
+		return fmt.Errorf("failed to update channel age gate: %w", err)
+	}
+	return nil
+}
+
+// Listed returns up to limit public channels of the default workspace
+// offered to search engines, most recently active first. Other workspaces
+// are not public.
+func (r *ChannelRepository) Listed(ctx context.Context, limit int) ([]models.Channel, error) {
+	var channels []models.Channel
+	err := r.db.WithContext(ctx).
+		Joins("Conversation").
+		Where("channels.is_listed = ? AND channels.is_private = ?", true, false).
+		Where(`"Conversation".workspace_id = ?`, models.DefaultWorkspaceID).
+		Order(`COALESCE("Conversation".last_message_at, channels.updated_at) DESC`).
+		Limit(limit).
+		Find(&channels).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels for search engines: %w", err)
+	}
+	return channels, nil
+}
+
+// PopularIn returns up to limit listed public channels with the most
+// members from a country, most first.
+func (r *ChannelRepository) PopularIn(ctx context.Context, countryCode string, limit int) ([]models.Channel, error) {
+	var channels []models.Channel
+	err := r.db.WithContext(ctx).
+		Select("channels.*").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = channels.conversation_id AND conversation_members.deleted_at IS NULL").
+		Joins("JOIN users ON users.id = conversation_members.user_id AND users.country_code = ?", countryCode).
+		Joins("JOIN conversations ON conversations.id = channels.conversation_id AND conversations.workspace_id = ?", models.DefaultWorkspaceID).
+		Where("channels.is_listed = ? AND channels.is_private = ?", true, false).
+		Group("channels.conversation_id").
+		Order("COUNT(*) DESC").
+		Limit(limit).
+		Find(&channels).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list popular channels: %w", err)
+	}
+	return channels, nil
+}
+
+// ListForUser returns the channels of a workspace userID belongs to with
+// their role in each, ordered by name.
+func (r *ChannelRepository) ListForUser(ctx context.Context, workspaceID, userID uuid.UUID) ([]ChannelWithRole, error) {
+	var channels []ChannelWithRole
+	err := r.db.WithContext(ctx).
+		Table("channels").
+		Select("channels.*, conversation_members.role AS role").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = channels.conversation_id AND conversation_members.deleted_at IS NULL").
+		Joins("JOIN conversations ON conversations.id = channels.conversation_id").
+		Where("conversation_members.user_id = ? AND channels.deleted_at IS NULL AND conversations.workspace_id = ?", userID, workspaceID).
+		Order("channels.name ASC").
+		Scan(&channels).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channels: %w", err)
+	}
+	return channels, nil
+}
+
+// Member returns userID's membership of the channel.
+func (r *ChannelRepository) Member(ctx context.Context, channelID, userID uuid.UUID) (*models.ChannelMember, error) {
+	var member models.ChannelMember
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND user_id = ?", channelID, userID).
+		First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load channel member: %w", err)
+	}
+	return &member, nil
+}
+
+// Members lists the channel's members, owner first, with their users.
+func (r *ChannelRepository) Members(ctx context.Context, channelID uuid.UUID) ([]models.ChannelMember, error) {
+	var members []models.ChannelMember
+	err := r.db.WithContext(ctx).
+		Preload("User", withErased).
+		Where("conversation_id = ?", channelID).
+		Order("CASE role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, joined_at ASC").
+		Find(&members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channel members: %w", err)
+	}
+	return members, nil
+}
+
+// withErased preloads users whether or not they were erased, since their
+// memberships outlive them.
+func withErased(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// MemberCount returns how many members the channel has.
+func (r *ChannelRepository) MemberCount(ctx context.Context, channelID uuid.UUID) (int64, error) {
+	var counts []int64
+	err := r.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id = ?", channelID).
+		Pluck("member_count", &counts).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count channel members: %w", err)
+	}
+	if len(counts) == 0 {
+		return 0, nil
+	}
+	return counts[0], nil
+}
+
+// AddMembers adds users as members. Existing members keep their role, and
+// users who previously left are restored.
+func (r *ChannelRepository) AddMembers(ctx context.Context, channelID uuid.UUID, userIDs []uuid.UUID) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	members := make([]models.ChannelMember, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, models.ChannelMember{
+			ConversationID: channelID,
+			UserID:         userID,
+			Role:           models.MemberRoleMember,
+			JoinedAt:       now,
+		})
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "conversation_id"}, {Name: "user_id"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"deleted_at": nil,
+				"role":       models.MemberRoleMember,
+				"joined_at":  now,
+				"updated_at": now,
+			}),
+			Where: clause.Where{Exprs: []clause.Expression{
+				clause.Expr{SQL: "conversation_members.deleted_at IS NOT NULL"},
+			}},
+		}).Create(&members).Error
+		if err != nil {
+			return err
+		}
+		return refreshMemberCounts(tx, []uuid.UUID{channelID})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add channel members: %w", err)
+	}
+	return nil
+}
+
+// RemoveMember removes userID from the channel. The owner cannot be removed.
+func (r *ChannelRepository) RemoveMember(ctx context.Context, channelID, userID uuid.UUID) error {
+	var removed int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("conversation_id = ? AND user_id = ? AND role <> ?", channelID, userID, models.MemberRoleOwner).
+			Delete(&models.ChannelMember{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		removed = result.RowsAffected
+		return refreshMemberCounts(tx, []uuid.UUID{channelID})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove channel member: %w", err)
+	}
+	if removed == 0 {
+		return r.missingOrOwner(ctx, channelID, userID)
+	}
+	return nil
+}
+
+// SetRole changes a member's role between admin and member. The owner's
+// role only changes through TransferOwnership.
+func (r *ChannelRepository) SetRole(ctx context.Context, channelID, userID uuid.UUID, role string) error {
+	result := r.db.WithContext(ctx).Model(&models.ChannelMember{}).
+		Where("conversation_id = ? AND user_id = ? AND role <> ?", channelID, userID, models.MemberRoleOwner).
+		Update("role", role)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update channel role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return r.missingOrOwner(ctx, channelID, userID)
+	}
+	return nil
+}
+
+// TransferOwnership makes newOwnerID the owner and demotes the current
+// owner to admin.
+func (r *ChannelRepository) TransferOwnership(ctx context.Context, channelID, currentOwnerID, newOwnerID uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ChannelMember{}).
+			Where("conversation_id = ? AND user_id = ?", channelID, newOwnerID).
+			Update("role", models.MemberRoleOwner)
+		if result.Error != nil {
+			return fmt.Errorf("failed to transfer channel ownership: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotMember
+		}
+		if err := tx.Model(&models.ChannelMember{}).
+			Where("conversation_id = ? AND user_id = ?", channelID, currentOwnerID).
+			Update("role", models.MemberRoleAdmin).Error; err != nil {
+			return fmt.Errorf("failed to transfer channel ownership: %w", err)
+		}
+		return nil
+	})
+}
+
+func (r *ChannelRepository) missingOrOwner(ctx context.Context, channelID, userID uuid.UUID) error {
+	member, err := r.Member(ctx, channelID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+	if member.Role == models.MemberRoleOwner {
+		return ErrLastOwner
+	}
+	return ErrNotMember
+}
+
+// WelcomeRooms lists the welcome rooms, those for everyone first, then by
+// country.
+func (r *ChannelRepository) WelcomeRooms(ctx context.Context) ([]models.WelcomeRoom, error) {
+	var rooms []models.WelcomeRoom
+	if err := r.db.WithContext(ctx).Order("country_code ASC, created_at ASC").Find(&rooms).Error; err != nil {
+		return nil, fmt.Errorf("failed to list welcome rooms: %w", err)
+	}
+	return rooms, nil
+}
+
+// AddWelcomeRoom makes a channel a welcome room. Adding it again for the
+// same country fails with gorm.ErrDuplicatedKey.
+func (r *ChannelRepository) AddWelcomeRoom(ctx context.Context, room *models.WelcomeRoom) error {
+	if err := r.db.WithContext(ctx).Create(room).Error; err != nil {
+		return fmt.Errorf("failed to add welcome room: %w", err)
+	}
+	return nil
+}
+
+// DeleteWelcomeRoom stops adding new users to a welcome room. Its members
+// stay.
+func (r *ChannelRepository) DeleteWelcomeRoom(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.WelcomeRoom{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete welcome room: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// JoinWelcomeRooms adds a new user to the welcome rooms for everyone and
+// for their country, if known, and returns the channels joined. Rooms
+// that have since turned private or been deleted are skipped, as are 18+
+// rooms unless the user is an adult, and the user waits in line for those
+// with cap members.
+func (r *ChannelRepository) JoinWelcomeRooms(ctx context.Context, userID uuid.UUID, countryCode *string, adult bool, cap int) ([]uuid.UUID, error) {
+	countries := []string{""}
+	if countryCode != nil && *countryCode != "" {
+		countries = append(countries, *countryCode)
+	}
+	query := r.db.WithContext(ctx).Model(&models.WelcomeRoom{}).
+		Distinct("welcome_rooms.channel_id").
+		Joins("JOIN channels ON channels.conversation_id = welcome_rooms.channel_id AND channels.deleted_at IS NULL").
+		Where("welcome_rooms.country_code IN ? AND channels.is_private = ?", countries, false)
+	if !adult {
+		query = query.Where("channels.adult_only = ?", false)
+	}
+	var channelIDs []uuid.UUID
+	err := query.Pluck("welcome_rooms.channel_id", &channelIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find welcome rooms: %w", err)
+	}
+	joined := make([]uuid.UUID, 0, len(channelIDs))
+	for _, channelID := range channelIDs {
+		_, position, err := r.Join(ctx, channelID, userID, cap)
+		if err != nil {
+			return nil, err
+		}
+		if position == 0 {
+			joined = append(joined, channelID)
+		}
+	}
+	return joined, nil
+}
+
+// Join adds userID to the channel or, when it has cap members, puts them
+// at the end of its waitlist. Users already waiting are admitted first as
+// places free up, so nobody jumps the line. It returns everyone admitted,
+// including userID if they got in, and otherwise userID's position in
+// line. Members joining again are left as they are. A cap of zero means
+// no cap.
+func (r *ChannelRepository) Join(ctx context.Context, channelID, userID uuid.UUID, cap int) (admitted []uuid.UUID, position int64, err error) {
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockChannel(tx, channelID); err != nil {
+			return err
+		}
+		var members int64
+		err := tx.Model(&models.ChannelMember{}).
+			Where("conversation_id = ? AND user_id = ?", channelID, userID).
+			Count(&members).Error
+		if err != nil {
+			return fmt.Errorf("failed to check channel membership: %w", err)
+		}
+		if members > 0 {
+			return nil
+		}
+
+		entry := models.ChannelWaitlistEntry{ChannelID: channelID, UserID: userID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&entry).Error; err != nil {
+			return fmt.Errorf("failed to join channel waitlist: %w", err)
+		}
+		if admitted, err = admitWaiting(ctx, tx, channelID, cap); err != nil {
+			return err
+		}
+		if slices.Contains(admitted, userID) {
+			return nil
+		}
+		err = tx.Model(&models.ChannelWaitlistEntry{}).
+			Where("channel_id = ? AND created_at <= (?)", channelID,
+				tx.Model(&models.ChannelWaitlistEntry{}).Select("created_at").Where("channel_id = ? AND user_id = ?", channelID, userID)).
+			Count(&position).Error
+		if err != nil {
+			return fmt.Errorf("failed to find channel waitlist position: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return admitted, position, nil
+}
+
+// AddMembersWithin adds users as AddMembers does unless that would take
+// the channel past cap members, when it fails with ErrLimitReached. Users
+// added leave the channel's waitlist. A cap of zero means no cap.
+func (r *ChannelRepository) AddMembersWithin(ctx context.Context, channelID uuid.UUID, userIDs []uuid.UUID, cap int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockChannel(tx, channelID); err != nil {
+			return err
+		}
+		if cap > 0 {
+			var members, already int64
+			err := tx.Model(&models.ChannelMember{}).Where("conversation_id = ?", channelID).Count(&members).Error
+			if err == nil {
+				err = tx.Model(&models.ChannelMember{}).
+					Where("conversation_id = ? AND user_id IN ?", channelID, userIDs).
+					Count(&already).Error
+			}
+			if err != nil {
+				return fmt.Errorf("failed to count channel members: %w", err)
+			}
+			added := make(map[uuid.UUID]bool, len(userIDs))
+			for _, userID := range userIDs {
+				added[userID] = true
+			}
+			if members+int64(len(added))-already > int64(cap) {
+				return ErrLimitReached
+			}
+		}
+		if err := NewChannelRepository(tx).AddMembers(ctx, channelID, userIDs); err != nil {
+			return err
+		}
+		err := tx.Where("channel_id = ? AND user_id IN ?", channelID, userIDs).Delete(&models.ChannelWaitlistEntry{}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update channel waitlist: %w", err)
+		}
+		return nil
+	})
+}
+
+// AdmitPastAgeGate adds the user an age override is for to its channel,
+// within cap members as AddMembersWithin does, and records the override
+// in the moderation log, together.
+func (r *ChannelRepository) AdmitPastAgeGate(ctx context.Context, override *models.ModerationAction, cap int) error {
+	if override.Action != models.ModerationAgeOverride || override.ConversationID == nil {
+		return fmt.Errorf("not an age override: %q", override.Action)
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := NewChannelRepository(tx).AddMembersWithin(ctx, *override.ConversationID, []uuid.UUID{override.UserID}, cap); err != nil {
+			return err
+		}
+		if err := tx.Create(override).Error; err != nil {
+			return fmt.Errorf("failed to record moderation action: %w", err)
+		}
+		return nil
+	})
+}
+
+// AdmitWaiting admits users from the channel's waitlist, in order, until
+// it has cap members, and returns those admitted. A cap of zero means no
+// cap.
+func (r *ChannelRepository) AdmitWaiting(ctx context.Context, channelID uuid.UUID, cap int) ([]uuid.UUID, error) {
+	var admitted []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := lockChannel(tx, channelID); err != nil {
+			return err
+		}
+		var err error
+		admitted, err = admitWaiting(ctx, tx, channelID, cap)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return admitted, nil
+}
+
+// Waitlist returns the users waiting for a place in the channel, first in
+// line first.
+func (r *ChannelRepository) Waitlist(ctx context.Context, channelID uuid.UUID) ([]models.ChannelWaitlistEntry, error) {
+	var entries []models.ChannelWaitlistEntry
+	err := r.db.WithContext(ctx).
+		Where("channel_id = ?", channelID).
+		Order("created_at ASC, id ASC").
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list channel waitlist: %w", err)
+	}
+	return entries, nil
+}
+
+// LeaveWaitlist takes userID out of the channel's waitlist.
+func (r *ChannelRepository) LeaveWaitlist(ctx context.Context, channelID, userID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.ChannelWaitlistEntry{}, "channel_id = ? AND user_id = ?", channelID, userID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to leave channel waitlist: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordLinkVisit notes that the user resolved a link to the channel from
+// outside the app, at the time given.
+func (r *ChannelRepository) RecordLinkVisit(ctx context.Context, channelID, userID uuid.UUID, at time.Time) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "channel_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"visited_at"}),
+	}).Create(&models.ChannelLinkVisit{ChannelID: channelID, UserID: userID, VisitedAt: at}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record channel link visit: %w", err)
+	}
+	return nil
+}
+
+// VisitedViaLink reports whether the user resolved a link to the channel
+// since the time given.
+func (r *ChannelRepository) VisitedViaLink(ctx context.Context, channelID, userID uuid.UUID, since time.Time) (bool, error) {
+	var visits int64
+	err := r.db.WithContext(ctx).Model(&models.ChannelLinkVisit{}).
+		Where("channel_id = ? AND user_id = ? AND visited_at >= ?", channelID, userID, since).
+		Count(&visits).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check channel link visits: %w", err)
+	}
+	return visits > 0, nil
+}
+
+// lockChannel locks the channel's row for the rest of tx, so its members
+// and waitlist change one transaction at a time.
+func lockChannel(tx *gorm.DB, channelID uuid.UUID) error {
+	var channel models.Channel
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("conversation_id").
+		First(&channel, "conversation_id = ?", channelID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock channel: %w", err)
+	}
+	return nil
+}
+
+// admitWaiting moves users from the head of the channel's waitlist into
+// the channel until it has cap members. The channel must be locked.
+func admitWaiting(ctx context.Context, tx *gorm.DB, channelID uuid.UUID, cap int) ([]uuid.UUID, error) {
+	limit := -1
+	if cap > 0 {
+		var members int64
+		if err := tx.Model(&models.ChannelMember{}).Where("conversation_id = ?", channelID).Count(&members).Error; err != nil {
+			return nil, fmt.Errorf("failed to count channel members: %w", err)
+		}
+		if members >= int64(cap) {
+			return nil, nil
+		}
+		limit = cap - int(members)
+	}
+
+	var userIDs []uuid.UUID
+	err := tx.Model(&models.ChannelWaitlistEntry{}).
+		Where("channel_id = ?", channelID).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read channel waitlist: %w", err)
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	if err := NewChannelRepository(tx).AddMembers(ctx, channelID, userIDs); err != nil {
+		return nil, err
+	}
+	if err := tx.Where("channel_id = ? AND user_id IN ?", channelID, userIDs).Delete(&models.ChannelWaitlistEntry{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to update channel waitlist: %w", err)
+	}
+	return userIDs, nil
+}
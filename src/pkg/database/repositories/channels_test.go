@@ -0,0 +1,50 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+)
+
+func TestChannelLinkVisits(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	visitor := createUser(t, db, "visitor")
+	channels := repositories.NewChannelRepository(db.DB)
+	channel, err := channels.Create(ctx, models.DefaultWorkspaceID, owner.ID, "garden", "", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	visited := func(since time.Time) bool {
+		t.Helper()
+		ok, err := channels.VisitedViaLink(ctx, channel.ConversationID, visitor.ID, since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+	if visited(now.Add(-time.Hour)) {
+		t.Fatal("visit reported before any was recorded")
+	}
+	if err := channels.RecordLinkVisit(ctx, channel.ConversationID, visitor.ID, now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if visited(now.Add(-time.Hour)) {
+		t.Fatal("visit from before the window reported")
+	}
+	// Visiting again moves the visit up rather than adding another.
+	if err := channels.RecordLinkVisit(ctx, channel.ConversationID, visitor.ID, now); err != nil {
+		t.Fatal(err)
+	}
+	if !visited(now.Add(-time.Hour)) {
+		t.Fatal("recent visit not reported")
+	}
+}
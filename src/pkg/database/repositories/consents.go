@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// consentColumns are the user columns holding each consent.
+var consentColumns = map[string]string{
+	models.ConsentAnalytics:        "analytics_opt_in",
+	models.ConsentMarketingEmails:  "marketing_emails",
+	models.ConsentContactDiscovery: "phone_discoverable",
+}
+
+type ConsentRepository struct {
+	db *gorm.DB
+}
+
+func NewConsentRepository(db *gorm.DB) *ConsentRepository {
+	return &ConsentRepository{db: db}
+}
+
+// Update gives or withdraws the user's consent to each purpose in
+// consents, recording an event for each that changed, and returns the
+// events.
+func (r *ConsentRepository) Update(ctx context.Context, userID uuid.UUID, consents map[string]bool) ([]models.ConsentEvent, error) {
+	var events []models.ConsentEvent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		current := map[string]bool{
+			models.ConsentAnalytics:        user.AnalyticsOptIn,
+			models.ConsentMarketingEmails:  user.MarketingEmails,
+			models.ConsentContactDiscovery: user.PhoneDiscoverable,
+		}
+		updates := make(map[string]any)
+		for purpose, granted := range consents {
+			column, ok := consentColumns[purpose]
+			if !ok {
+				return fmt.Errorf("unknown consent purpose %q", purpose)
+			}
+			if current[purpose] == granted {
+				continue
+			}
+			updates[column] = granted
+			events = append(events, models.ConsentEvent{UserID: userID, Purpose: purpose, Granted: granted})
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		if err := tx.Model(&user).Updates(updates).Error; err != nil {
+			return err
+		}
+		return tx.Create(&events).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update consents: %w", err)
+	}
+	return events, nil
+}
+
+// History returns up to limit of the user's consent events, newest
+// first.
+func (r *ConsentRepository) History(ctx context.Context, userID uuid.UUID, limit int) ([]models.ConsentEvent, error) {
+	var events []models.ConsentEvent
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list consent events: %w", err)
+	}
+	return events, nil
+}
+
+// MarketingAllowed reports whether the account using email takes
+// marketing emails. Addresses without an active account do not.
+func (r *ConsentRepository) MarketingAllowed(ctx context.Context, email string) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("email = ? AND marketing_emails = ? AND is_active = ? AND is_banned = ?", email, true, true, false).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up marketing consent: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ContactRepository struct {
+	db *gorm.DB
+}
+
+func NewContactRepository(db *gorm.DB) *ContactRepository {
+	return &ContactRepository{db: db}
+}
+
+// Request sends a friend request from requester to addressee. If the
+// addressee had already sent one the other way, it is accepted instead. An
+// existing request or contact between them is returned unchanged, with
+// changed false. It returns ErrBlocked if either user has blocked the other.
+func (r *ContactRepository) Request(ctx context.Context, requesterID, addresseeID uuid.UUID) (*models.Contact, bool, error) {
+	var contact models.Contact
+	changed := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		blocked, err := blocked(tx, requesterID, addresseeID)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			return ErrBlocked
+		}
+
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&contact, "pair_key = ?", directKey(requesterID, addresseeID)).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			contact = models.Contact{
+				RequesterID: requesterID,
+				AddresseeID: addresseeID,
+				PairKey:     directKey(requesterID, addresseeID),
+				Status:      models.ContactPending,
+			}
+			changed = true
+			return tx.Create(&contact).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		if contact.Status == models.ContactPending && contact.AddresseeID == requesterID {
+			now := time.Now()
+			contact.Status, contact.AcceptedAt = models.ContactAccepted, &now
+			changed = true
+			return tx.Save(&contact).Error
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBlocked) {
+		return nil, false, err
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to send friend request: %w", err)
+	}
+	return &contact, changed, nil
+}
+
+// Accept accepts a pending request sent to addresseeID. It returns
+// ErrNotFound if there is no such request.
+func (r *ContactRepository) Accept(ctx context.Context, id, addresseeID uuid.UUID) (*models.Contact, error) {
+	var contact models.Contact
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&contact).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND addressee_id = ? AND status = ?", id, addresseeID, models.ContactPending).
+		Updates(map[string]any{"status": models.ContactAccepted, "accepted_at": now})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to accept friend request: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &contact, nil
+}
+
+// Reject removes a pending request sent to addresseeID.
+func (r *ContactRepository) Reject(ctx context.Context, id, addresseeID uuid.UUID) error {
+	return r.deletePending(ctx, "id = ? AND addressee_id = ?", id, addresseeID)
+}
+
+// Cancel removes a pending request sent by requesterID.
+func (r *ContactRepository) Cancel(ctx context.Context, id, requesterID uuid.UUID) error {
+	return r.deletePending(ctx, "id = ? AND requester_id = ?", id, requesterID)
+}
+
+func (r *ContactRepository) deletePending(ctx context.Context, query string, args ...any) error {
+	result := r.db.WithContext(ctx).
+		Where(query, args...).
+		Where("status = ?", models.ContactPending).
+		Delete(&models.Contact{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove friend request: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Remove ends the contact between two users. It returns ErrNotFound if
+// they are not contacts.
+func (r *ContactRepository) Remove(ctx context.Context, userID, otherID uuid.UUID) error {
+	result := r.db.WithContext(ctx).
+		Where("pair_key = ? AND status = ?", directKey(userID, otherID), models.ContactAccepted).
+		Delete(&models.Contact{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to remove contact: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListContacts returns a page of the user's contacts, most recently
+// accepted first, with both users loaded.
+func (r *ContactRepository) ListContacts(ctx context.Context, userID uuid.UUID, after *pagination.Cursor, limit int) ([]models.Contact, error) {
+	query := r.db.WithContext(ctx).
+		Where("(requester_id = ? OR addressee_id = ?) AND status = ?", userID, userID, models.ContactAccepted)
+	return r.list(query, "accepted_at", after, limit)
+}
+
+// ListRequests returns a page of the pending requests sent to the user, or
+// with outgoing those sent by the user, newest first, with both users
+// loaded.
+func (r *ContactRepository) ListRequests(ctx context.Context, userID uuid.UUID, outgoing bool, after *pagination.Cursor, limit int) ([]models.Contact, error) {
+	column := "addressee_id"
+	if outgoing {
+		column = "requester_id"
+	}
+	query := r.db.WithContext(ctx).
+		Where(column+" = ? AND status = ?", userID, models.ContactPending)
+	return r.list(query, "created_at", after, limit)
+}
+
+func (r *ContactRepository) list(query *gorm.DB, orderColumn string, after *pagination.Cursor, limit int) ([]models.Contact, error) {
+	if after != nil {
+		query = query.Where("("+orderColumn+", id) < (?, ?)", after.Time, after.ID)
+	}
+	var contacts []models.Contact
+	err := query.
+		Preload("Requester").
+		Preload("Addressee").
+		Order(orderColumn + " DESC, id DESC").
+		Limit(limit).
+		Find(&contacts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list contacts: %w", err)
+	}
+	return contacts, nil
+}
+
+// ContactCursor is the position of a contact in ListContacts.
+func ContactCursor(contact *models.Contact) pagination.Cursor {
+	return pagination.Cursor{Time: *contact.AcceptedAt, ID: contact.ID}
+}
+
+// RequestCursor is the position of a request in ListRequests.
+func RequestCursor(contact *models.Contact) pagination.Cursor {
+	return pagination.Cursor{Time: contact.CreatedAt, ID: contact.ID}
+}
+
+// DiscoverByPhone returns the users whose normalized phone number is one of
+// numbers and who let themselves be found by it, among the members of the
+// workspace. Users who blocked the searcher, or were blocked by them, and
+// the searcher themselves are left out.
+func (r *ContactRepository) DiscoverByPhone(ctx context.Context, searcherID, workspaceID uuid.UUID, numbers []string) ([]models.User, error) {
+	if len(numbers) == 0 {
+		return nil, nil
+	}
+	query := r.db.WithContext(ctx).
+		Where("phone_e164 IN ? AND phone_discoverable = ? AND is_active = ? AND is_banned = ? AND id <> ?", numbers, true, true, false, searcherID).
+		Where("id NOT IN (?)", r.db.Model(&models.Block{}).Select("blocker_id").Where("blocked_id = ?", searcherID)).
+		Where("id NOT IN (?)", r.db.Model(&models.Block{}).Select("blocked_id").Where("blocker_id = ?", searcherID))
+	if workspaceID != models.DefaultWorkspaceID {
+		query = query.Where("id IN (?)", r.db.Model(&models.WorkspaceMember{}).
+			Select("user_id").
+			Where("workspace_id = ?", workspaceID))
+	}
+
+	var users []models.User
+	if err := query.Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to discover contacts: %w", err)
+	}
+	return users, nil
+}
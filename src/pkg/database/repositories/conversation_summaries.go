@@ -0,0 +1,399 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/preview"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Summaries are written in the transactions of the changes they reflect:
+// sending, editing, deleting and reading messages, joining and leaving,
+// pinning and muting. CounterRepository.Reconcile corrects any drift.
+
+// summaryPreviewRunes is how much of the latest message's text a summary
+// keeps.
+const summaryPreviewRunes = 140
+
+// summaryPreview is the preview a summary shows of a message.
+func summaryPreview(message *models.Message) string {
+	if message.Type == string(content.TypeEncrypted) || message.DeletedAt.Valid {
+		return ""
+	}
+	return preview.Snippet(message.Text, summaryPreviewRunes)
+}
+
+// summarizeSent makes a new message the latest in its members' summaries,
+// and adds it to the unread count of all but its sender. Shadowed
+// messages are only shown to their sender.
+func summarizeSent(tx *gorm.DB, message *models.Message) error {
+	query := tx.Model(&models.ConversationSummary{}).Where("conversation_id = ?", message.ConversationID)
+	if message.Shadowed {
+		query = query.Where("user_id = ?", message.SenderID)
+	}
+	err := query.UpdateColumns(map[string]any{
+		"last_message_id":      message.ID,
+		"last_sender_id":       message.SenderID,
+		"last_message_type":    message.Type,
+		"last_message_preview": summaryPreview(message),
+		"last_activity_at":     message.CreatedAt,
+		"unread_count":         gorm.Expr("unread_count + CASE WHEN user_id = ? THEN 0 ELSE 1 END", message.SenderID),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update conversation summaries: %w", err)
+	}
+	return nil
+}
+
+// summarizeEdited updates the preview of an edited message where it is
+// the latest.
+func summarizeEdited(tx *gorm.DB, message *models.Message) error {
+	err := tx.Model(&models.ConversationSummary{}).
+		Where("last_message_id = ?", message.ID).
+		UpdateColumn("last_message_preview", summaryPreview(message)).Error
+	if err != nil {
+		return fmt.Errorf("failed to update conversation summaries: %w", err)
+	}
+	return nil
+}
+
+// readSeq is the Seq of the message the member of the summary in the
+// outer query read up to, or 0.
+const readSeq = `COALESCE((SELECT read_messages.seq FROM message_receipts
+	JOIN messages read_messages ON read_messages.id = message_receipts.read_message_id
+	WHERE message_receipts.conversation_id = conversation_summaries.conversation_id
+	AND message_receipts.user_id = conversation_summaries.user_id), 0)`
+
+// unreadCount is the number of messages the member of the summary in the
+// outer query has not read: those others sent after the read marker,
+// left out when deleted or shadowed.
+const unreadCount = `(SELECT COUNT(*) FROM messages
+	WHERE messages.conversation_id = conversation_summaries.conversation_id
+	AND messages.sender_id <> conversation_summaries.user_id
+	AND messages.deleted_at IS NULL AND messages.shadowed = ?
+	AND messages.seq > ` + readSeq + `)`
+
+// summarizeDeleted takes a deleted message off the unread count of the
+// members who had not read it, and empties its preview where it is the
+// latest.
+func summarizeDeleted(tx *gorm.DB, message *models.Message) error {
+	if !message.Shadowed {
+		err := tx.Model(&models.ConversationSummary{}).
+			Where("conversation_id = ? AND user_id <> ? AND unread_count > 0", message.ConversationID, message.SenderID).
+			Where(readSeq+" < ?", message.Seq).
+			UpdateColumn("unread_count", gorm.Expr("unread_count - 1")).Error
+		if err != nil {
+			return fmt.Errorf("failed to uncount unread message: %w", err)
+		}
+	}
+	err := tx.Model(&models.ConversationSummary{}).
+		Where("last_message_id = ?", message.ID).
+		UpdateColumn("last_message_preview", "").Error
+	if err != nil {
+		return fmt.Errorf("failed to update conversation summaries: %w", err)
+	}
+	return nil
+}
+
+// recountUnread recounts what members of a conversation have not read,
+// after their read markers moved or history was moved in. Only the
+// messages after each marker are counted.
+func recountUnread(tx *gorm.DB, conversationID uuid.UUID, userIDs ...uuid.UUID) error {
+	query := tx.Model(&models.ConversationSummary{}).Where("conversation_id = ?", conversationID)
+	if len(userIDs) > 0 {
+		query = query.Where("user_id IN ?", userIDs)
+	}
+	if err := query.UpdateColumn("unread_count", gorm.Expr(unreadCount, false)).Error; err != nil {
+		return fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return nil
+}
+
+// summarizePin records in a user's summary whether the conversation is
+// pinned, and where.
+func summarizePin(tx *gorm.DB, userID, conversationID uuid.UUID, pinned bool, position int) error {
+	err := tx.Model(&models.ConversationSummary{}).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		UpdateColumns(map[string]any{"pinned": pinned, "pin_position": position}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update conversation summary: %w", err)
+	}
+	return nil
+}
+
+// summarizeMute records in a user's summary whether the conversation is
+// muted, and until when.
+func summarizeMute(tx *gorm.DB, userID, conversationID uuid.UUID, muted bool, until *time.Time) error {
+	err := tx.Model(&models.ConversationSummary{}).
+		Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+		UpdateColumns(map[string]any{"muted": muted, "muted_until": until}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update conversation summary: %w", err)
+	}
+	return nil
+}
+
+// lastMessages returns the latest message of each conversation, by Seq,
+// unless it is shadowed.
+func lastMessages(db *gorm.DB, conversationIDs []uuid.UUID) (map[uuid.UUID]*models.Message, error) {
+	var messages []models.Message
+	err := db.Unscoped().
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id AND conversations.last_seq = messages.seq").
+		Where("messages.conversation_id IN ? AND messages.shadowed = ?", conversationIDs, false).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load last messages: %w", err)
+	}
+	last := make(map[uuid.UUID]*models.Message, len(messages))
+	for i := range messages {
+		last[messages[i].ConversationID] = &messages[i]
+	}
+	return last, nil
+}
+
+// refreshLastMessages makes the latest message of each conversation the
+// one its summaries show, where they show another, and returns how many
+// summaries it changed. Conversations whose latest message is shadowed
+// are left as they are.
+func refreshLastMessages(tx *gorm.DB, conversationIDs []uuid.UUID) (int64, error) {
+	last, err := lastMessages(tx, conversationIDs)
+	if err != nil {
+		return 0, err
+	}
+	var changed int64
+	for conversationID, message := range last {
+		result := tx.Model(&models.ConversationSummary{}).
+			Where("conversation_id = ? AND (last_message_id IS NULL OR last_message_id <> ?)", conversationID, message.ID).
+			UpdateColumns(map[string]any{
+				"last_message_id":      message.ID,
+				"last_sender_id":       message.SenderID,
+				"last_message_type":    message.Type,
+				"last_message_preview": summaryPreview(message),
+				"last_activity_at":     message.CreatedAt,
+			})
+		if result.Error != nil {
+			return 0, fmt.Errorf("failed to update conversation summaries: %w", result.Error)
+		}
+		changed += result.RowsAffected
+	}
+	return changed, nil
+}
+
+// What the pins and mutes of the member of the summary in the outer query
+// say the summary should show.
+const (
+	summaryPinned = `EXISTS (SELECT 1 FROM conversation_pins
+		WHERE conversation_pins.user_id = conversation_summaries.user_id AND conversation_pins.conversation_id = conversation_summaries.conversation_id)`
+	summaryPinPosition = `COALESCE((SELECT conversation_pins.position FROM conversation_pins
+		WHERE conversation_pins.user_id = conversation_summaries.user_id AND conversation_pins.conversation_id = conversation_summaries.conversation_id), 0)`
+	summaryMuted = `EXISTS (SELECT 1 FROM conversation_mutes
+		WHERE conversation_mutes.user_id = conversation_summaries.user_id AND conversation_mutes.conversation_id = conversation_summaries.conversation_id)`
+	summaryMutedUntil = `(SELECT conversation_mutes.until FROM conversation_mutes
+		WHERE conversation_mutes.user_id = conversation_summaries.user_id AND conversation_mutes.conversation_id = conversation_summaries.conversation_id)`
+)
+
+// syncSummaries gives every current member of the conversations a
+// summary and removes those of members who left, returning how many it
+// added and removed. New summaries start with nothing unread.
+func syncSummaries(tx *gorm.DB, conversationIDs []uuid.UUID) (int64, error) {
+	if len(conversationIDs) == 0 {
+		return 0, nil
+	}
+	removed := tx.Where("conversation_id IN ? AND NOT EXISTS (?)", conversationIDs,
+		tx.Model(&models.ConversationMember{}).Select("1").
+			Where("conversation_members.conversation_id = conversation_summaries.conversation_id AND conversation_members.user_id = conversation_summaries.user_id")).
+		Delete(&models.ConversationSummary{})
+	if removed.Error != nil {
+		return 0, fmt.Errorf("failed to remove conversation summaries: %w", removed.Error)
+	}
+
+	var members []models.ConversationMember
+	err := tx.Preload("Conversation").
+		Where("conversation_id IN ? AND NOT EXISTS (?)", conversationIDs,
+			tx.Model(&models.ConversationSummary{}).Select("1").
+				Where("conversation_summaries.conversation_id = conversation_members.conversation_id AND conversation_summaries.user_id = conversation_members.user_id")).
+		Find(&members).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to find members without summaries: %w", err)
+	}
+	if len(members) == 0 {
+		return removed.RowsAffected, nil
+	}
+
+	last, err := lastMessages(tx, conversationIDs)
+	if err != nil {
+		return 0, err
+	}
+	var pins []models.ConversationPin
+	var mutes []models.ConversationMute
+	err = tx.Where("conversation_id IN ?", conversationIDs).Find(&pins).Error
+	if err == nil {
+		err = tx.Where("conversation_id IN ?", conversationIDs).Find(&mutes).Error
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to load pins and mutes: %w", err)
+	}
+	type key struct{ userID, conversationID uuid.UUID }
+	pinned := make(map[key]models.ConversationPin, len(pins))
+	for _, pin := range pins {
+		pinned[key{pin.UserID, pin.ConversationID}] = pin
+	}
+	muted := make(map[key]models.ConversationMute, len(mutes))
+	for _, mute := range mutes {
+		muted[key{mute.UserID, mute.ConversationID}] = mute
+	}
+
+	summaries := make([]models.ConversationSummary, len(members))
+	for i, member := range members {
+		conversation := member.Conversation
+		summary := models.ConversationSummary{
+			UserID:         member.UserID,
+			ConversationID: member.ConversationID,
+			WorkspaceID:    conversation.WorkspaceID,
+			Kind:           conversation.Kind,
+			Title:          conversation.Title,
+			LastActivityAt: conversation.CreatedAt,
+		}
+		if message, ok := last[member.ConversationID]; ok {
+			summary.LastMessageID = &message.ID
+			summary.LastSenderID = &message.SenderID
+			summary.LastMessageType = &message.Type
+			summary.LastMessagePreview = summaryPreview(message)
+			summary.LastActivityAt = message.CreatedAt
+		}
+		if pin, ok := pinned[key{member.UserID, member.ConversationID}]; ok {
+			summary.Pinned, summary.PinPosition = true, pin.Position
+		}
+		if mute, ok := muted[key{member.UserID, member.ConversationID}]; ok {
+			summary.Muted, summary.MutedUntil = true, mute.Until
+		}
+		summaries[i] = summary
+	}
+	added := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&summaries)
+	if added.Error != nil {
+		return 0, fmt.Errorf("failed to add conversation summaries: %w", added.Error)
+	}
+	return removed.RowsAffected + added.RowsAffected, nil
+}
+
+// withUnread sets UnreadCount on the user's conversations.
+func withUnread(db *gorm.DB, userID uuid.UUID, conversations []models.Conversation) error {
+	ids := make([]uuid.UUID, len(conversations))
+	for i := range conversations {
+		ids[i] = conversations[i].ID
+	}
+	var summaries []models.ConversationSummary
+	err := db.Select("conversation_id", "unread_count").
+		Where("user_id = ? AND conversation_id IN ?", userID, ids).
+		Find(&summaries).Error
+	if err != nil {
+		return fmt.Errorf("failed to load unread counts: %w", err)
+	}
+	unread := make(map[uuid.UUID]int64, len(summaries))
+	for _, summary := range summaries {
+		unread[summary.ConversationID] = summary.UnreadCount
+	}
+	for i := range conversations {
+		conversations[i].UnreadCount = unread[conversations[i].ID]
+	}
+	return nil
+}
+
+type ConversationSummaryRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationSummaryRepository(db *gorm.DB) *ConversationSummaryRepository {
+	return &ConversationSummaryRepository{db: db}
+}
+
+// Sync gives every current member of the conversations a summary, for
+// conversations created outside this package.
+func (r *ConversationSummaryRepository) Sync(ctx context.Context, conversationIDs []uuid.UUID) error {
+	_, err := syncSummaries(r.db.WithContext(ctx), conversationIDs)
+	return err
+}
+
+// SummaryCursor is the position of a summary in the inbox, for paging.
+func SummaryCursor(summary *models.ConversationSummary) pagination.Cursor {
+	return pagination.Cursor{Time: summary.LastActivityAt, ID: summary.ConversationID}
+}
+
+// Inbox returns the user's conversation summaries in a workspace: without
+// a cursor, every pinned one in the user's order and then the limit most
+// recently active others, and after a cursor the next limit others. The
+// caller passes how many pins a user may have at most, and learns
+// whether more follow from hasMore. It runs as a single query on the
+// inbox index.
+func (r *ConversationSummaryRepository) Inbox(ctx context.Context, workspaceID, userID uuid.UUID, after *pagination.Cursor, limit, maxPins int) (summaries []models.ConversationSummary, hasMore bool, err error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ? AND workspace_id = ?", userID, workspaceID)
+	rows := limit + 1
+	if after != nil {
+		query = query.Where("pinned = ? AND (last_activity_at, conversation_id) < (?, ?)", false, after.Time, after.ID)
+	} else {
+		rows += maxPins
+	}
+	err = query.
+		Order("pinned DESC, pin_position ASC, last_activity_at DESC, conversation_id DESC").
+		Limit(rows).
+		Find(&summaries).Error
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list conversation summaries: %w", err)
+	}
+
+	now := time.Now()
+	unpinned := 0
+	for i := range summaries {
+		if summaries[i].MutedUntil != nil && !summaries[i].MutedUntil.After(now) {
+			summaries[i].Muted, summaries[i].MutedUntil = false, nil
+		}
+		if summaries[i].Pinned {
+			continue
+		}
+		if unpinned == limit {
+			return summaries[:i], true, nil
+		}
+		unpinned++
+	}
+	return summaries, false, nil
+}
+
+// LatestDirect returns the user's most recently active direct
+// conversations in any workspace, up to limit, most recent first.
+func (r *ConversationSummaryRepository) LatestDirect(ctx context.Context, userID uuid.UUID, limit int) ([]models.ConversationSummary, error) {
+	var summaries []models.ConversationSummary
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND kind = ?", userID, models.ConversationDirect).
+		Order("last_activity_at DESC, conversation_id DESC").
+		Limit(limit).
+		Find(&summaries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversation summaries: %w", err)
+	}
+	return summaries, nil
+}
+
+// Unread sums the user's unread messages and counts the conversations
+// holding them.
+func (r *ConversationSummaryRepository) Unread(ctx context.Context, userID uuid.UUID) (messages, conversations int64, err error) {
+	var totals struct {
+		Messages      int64
+		Conversations int64
+	}
+	err = r.db.WithContext(ctx).Model(&models.ConversationSummary{}).
+		Select("COALESCE(SUM(unread_count), 0) AS messages, COUNT(*) AS conversations").
+		Where("user_id = ? AND unread_count > 0", userID).
+		Scan(&totals).Error
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return totals.Messages, totals.Conversations, nil
+}
@@ -0,0 +1,458 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ConversationRepository struct {
+	db *gorm.DB
+}
+
+func NewConversationRepository(db *gorm.DB) *ConversationRepository {
+	return &ConversationRepository{db: db}
+}
+
+func directKey(a, b uuid.UUID) string {
+	if a.String() > b.String() {
+		a, b = b, a
+	}
+	return a.String() + ":" + b.String()
+}
+
+// workspaceDirectKey is the DirectKey of the direct conversation between
+// two users in a workspace.
+func workspaceDirectKey(workspaceID, a, b uuid.UUID) string {
+	if workspaceID == models.DefaultWorkspaceID {
+		return directKey(a, b)
+	}
+	return workspaceID.String() + ":" + directKey(a, b)
+}
+
+// FindOrCreateDirect returns the direct conversation between two users in
+// a workspace, creating it on first contact. created reports whether it is
+// new.
+func (r *ConversationRepository) FindOrCreateDirect(ctx context.Context, workspaceID, userA, userB uuid.UUID) (*models.Conversation, bool, error) {
+	key := workspaceDirectKey(workspaceID, userA, userB)
+	db := r.db.WithContext(ctx)
+
+	if conversation, err := r.findDirect(db, key); err == nil || !errors.Is(err, ErrNotFound) {
+		return conversation, false, err
+	}
+
+	archived, err := workspaceArchived(db, workspaceID)
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	conversation := models.Conversation{
+		WorkspaceID:        workspaceID,
+		Kind:               models.ConversationDirect,
+		DirectKey:          &key,
+		CreatedByID:        userA,
+		ComplianceArchived: archived,
+		MemberCount:        2,
+		Members: []models.ConversationMember{
+			{UserID: userA, Role: models.MemberRoleMember, JoinedAt: now},
+			{UserID: userB, Role: models.MemberRoleMember, JoinedAt: now},
+		},
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return err
+		}
+		_, err := syncSummaries(tx, []uuid.UUID{conversation.ID})
+		return err
+	})
+	if err != nil {
+		// Another request created it first.
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			existing, err := r.findDirect(db, key)
+			return existing, false, err
+		}
+		return nil, false, fmt.Errorf("failed to create direct conversation: %w", err)
+	}
+	return &conversation, true, nil
+}
+
+func (r *ConversationRepository) findDirect(db *gorm.DB, key string) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := db.Preload("Members").Where("direct_key = ?", key).First(&conversation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load direct conversation: %w", err)
+	}
+	return &conversation, nil
+}
+
+// CreateGroup creates a group conversation in a workspace owned by
+// creatorID, which is an audit room when audited is set. An empty title
+// leaves the group untitled.
+func (r *ConversationRepository) CreateGroup(ctx context.Context, workspaceID, creatorID uuid.UUID, title string, memberIDs []uuid.UUID, audited bool) (*models.Conversation, error) {
+	archived, err := workspaceArchived(r.db.WithContext(ctx), workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	conversation := models.Conversation{
+		WorkspaceID:        workspaceID,
+		Kind:               models.ConversationGroup,
+		CreatedByID:        creatorID,
+		Audited:            audited,
+		ComplianceArchived: archived,
+		Members:            []models.ConversationMember{{UserID: creatorID, Role: models.MemberRoleOwner, JoinedAt: now}},
+	}
+	if title != "" {
+		conversation.Title = &title
+	}
+	seen := map[uuid.UUID]bool{creatorID: true}
+	for _, memberID := range memberIDs {
+		if !seen[memberID] {
+			seen[memberID] = true
+			conversation.Members = append(conversation.Members, models.ConversationMember{
+				UserID:   memberID,
+				Role:     models.MemberRoleMember,
+				JoinedAt: now,
+			})
+		}
+	}
+	conversation.MemberCount = int64(len(conversation.Members))
+
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&conversation).Error; err != nil {
+			return err
+		}
+		_, err := syncSummaries(tx, []uuid.UUID{conversation.ID})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create group conversation: %w", err)
+	}
+	return &conversation, nil
+}
+
+// FindGroupWithMembers returns the most recently active group in a
+// workspace whose members are exactly memberIDs, or ErrNotFound.
+func (r *ConversationRepository) FindGroupWithMembers(ctx context.Context, workspaceID uuid.UUID, memberIDs []uuid.UUID) (*models.Conversation, error) {
+	members := make(map[uuid.UUID]bool, len(memberIDs))
+	for _, memberID := range memberIDs {
+		members[memberID] = true
+	}
+	if len(members) == 0 {
+		return nil, ErrNotFound
+	}
+
+	db := r.db.WithContext(ctx)
+	var ids []uuid.UUID
+	err := db.Model(&models.Conversation{}).
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.deleted_at IS NULL").
+		Where("conversations.workspace_id = ? AND conversations.kind = ?", workspaceID, models.ConversationGroup).
+		Group("conversations.id, conversations.last_message_at, conversations.created_at").
+		Having("COUNT(*) = ? AND SUM(CASE WHEN conversation_members.user_id IN ? THEN 1 ELSE 0 END) = ?", len(members), memberIDs, len(members)).
+		Order("COALESCE(conversations.last_message_at, conversations.created_at) DESC").
+		Limit(1).
+		Pluck("conversations.id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find group by members: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, ErrNotFound
+	}
+	return r.Get(ctx, ids[0])
+}
+
+// Get loads a conversation with its members.
+func (r *ConversationRepository) Get(ctx context.Context, id uuid.UUID) (*models.Conversation, error) {
+	var conversation models.Conversation
+	err := r.db.WithContext(ctx).Preload("Members").First(&conversation, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation: %w", err)
+	}
+	return &conversation, nil
+}
+
+// ConversationFilter narrows down the conversations ListForUser returns.
+// Its zero value keeps them all.
+type ConversationFilter struct {
+	// LabelID keeps the conversations the label is applied to.
+	LabelID uuid.UUID
+
+	// Unpinned leaves out the conversations the user pinned.
+	Unpinned bool
+}
+
+// forUser selects the user's conversations in a workspace that pass
+// filter, with their members.
+func (r *ConversationRepository) forUser(ctx context.Context, workspaceID, userID uuid.UUID, filter ConversationFilter) *gorm.DB {
+	query := r.db.WithContext(ctx).
+		Preload("Members").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.deleted_at IS NULL").
+		Where("conversation_members.user_id = ? AND conversations.workspace_id = ?", userID, workspaceID)
+	if filter.LabelID != uuid.Nil {
+		query = query.Where("conversations.id IN (?)", r.db.Model(&models.ConversationLabeling{}).
+			Select("conversation_id").Where("label_id = ?", filter.LabelID))
+	}
+	if filter.Unpinned {
+		query = query.Where("conversations.id NOT IN (?)", r.db.Model(&models.ConversationPin{}).
+			Select("conversation_id").Where("user_id = ?", userID))
+	}
+	return query
+}
+
+// ListForUser returns a page of the user's conversations in a workspace
+// that pass filter, most recently active first, starting after the given
+// cursor if any.
+func (r *ConversationRepository) ListForUser(ctx context.Context, workspaceID, userID uuid.UUID, filter ConversationFilter, after *pagination.Cursor, limit int) ([]models.Conversation, error) {
+	query := r.forUser(ctx, workspaceID, userID, filter)
+	if after != nil {
+		query = query.Where("(COALESCE(conversations.last_message_at, conversations.created_at), conversations.id) < (?, ?)", after.Time, after.ID)
+	}
+
+	var conversations []models.Conversation
+	err := query.
+		Order("COALESCE(conversations.last_message_at, conversations.created_at) DESC, conversations.id DESC").
+		Limit(limit).
+		Find(&conversations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+	if err := r.withListing(ctx, userID, conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// withListing sets what a conversation list shows beyond the
+// conversations and their members: the user's unread count and the last
+// message. Each is loaded for the whole page in one query.
+func (r *ConversationRepository) withListing(ctx context.Context, userID uuid.UUID, conversations []models.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+	db := r.db.WithContext(ctx)
+	if err := withUnread(db, userID, conversations); err != nil {
+		return err
+	}
+
+	ids := make([]uuid.UUID, len(conversations))
+	for i := range conversations {
+		ids[i] = conversations[i].ID
+	}
+	var messages []models.Message
+	err := db.Preload("Attachments", liveAttachments).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id AND conversations.last_seq = messages.seq").
+		Where("messages.conversation_id IN ? AND (messages.shadowed = ? OR messages.sender_id = ?)", ids, false, userID).
+		Find(&messages).Error
+	if err != nil {
+		return fmt.Errorf("failed to load last messages: %w", err)
+	}
+	last := make(map[uuid.UUID]*models.Message, len(messages))
+	for i := range messages {
+		last[messages[i].ConversationID] = &messages[i]
+	}
+	for i := range conversations {
+		conversations[i].LastMessage = last[conversations[i].ID]
+	}
+	return nil
+}
+
+// PinnedForUser returns the conversations the user pinned in a workspace
+// that pass filter, in the user's order, marked Pinned.
+func (r *ConversationRepository) PinnedForUser(ctx context.Context, workspaceID, userID uuid.UUID, filter ConversationFilter) ([]models.Conversation, error) {
+	filter.Unpinned = false
+	var conversations []models.Conversation
+	err := r.forUser(ctx, workspaceID, userID, filter).
+		Joins("JOIN conversation_pins ON conversation_pins.conversation_id = conversations.id AND conversation_pins.user_id = conversation_members.user_id").
+		Order("conversation_pins.position ASC, conversation_pins.created_at ASC").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pinned conversations: %w", err)
+	}
+	for i := range conversations {
+		conversations[i].Pinned = true
+	}
+	if err := r.withListing(ctx, userID, conversations); err != nil {
+		return nil, err
+	}
+	return conversations, nil
+}
+
+// PinnedIDs returns the IDs of the conversations the user pinned in a
+// workspace and still belongs to, in the user's order.
+func (r *ConversationRepository) PinnedIDs(ctx context.Context, workspaceID, userID uuid.UUID) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	err := r.db.WithContext(ctx).Model(&models.ConversationPin{}).
+		Joins("JOIN conversations ON conversations.id = conversation_pins.conversation_id AND conversations.deleted_at IS NULL").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversation_pins.conversation_id AND conversation_members.user_id = conversation_pins.user_id AND conversation_members.deleted_at IS NULL").
+		Where("conversation_pins.user_id = ? AND conversations.workspace_id = ?", userID, workspaceID).
+		Order("conversation_pins.position ASC, conversation_pins.created_at ASC").
+		Pluck("conversation_pins.conversation_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+	return ids, nil
+}
+
+// Pin pins a conversation for a user after the user's other pins in its
+// workspace, doing nothing if it is pinned already. It fails with
+// ErrLimitReached when the user has limit pins in the workspace.
+func (r *ConversationRepository) Pin(ctx context.Context, userID uuid.UUID, conversation *models.Conversation, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var pinned int64
+		err := tx.Model(&models.ConversationPin{}).
+			Where("user_id = ? AND conversation_id = ?", userID, conversation.ID).
+			Count(&pinned).Error
+		if err != nil || pinned > 0 {
+			return err
+		}
+
+		inWorkspace := tx.Model(&models.Conversation{}).Select("id").Where("workspace_id = ?", conversation.WorkspaceID)
+		var last struct {
+			Count    int64
+			Position *int
+		}
+		err = tx.Model(&models.ConversationPin{}).
+			Select("COUNT(*) AS count, MAX(position) AS position").
+			Where("user_id = ? AND conversation_id IN (?)", userID, inWorkspace).
+			Scan(&last).Error
+		if err != nil {
+			return fmt.Errorf("failed to count pins: %w", err)
+		}
+		if last.Count >= int64(limit) {
+			return ErrLimitReached
+		}
+		pin := models.ConversationPin{UserID: userID, ConversationID: conversation.ID}
+		if last.Position != nil {
+			pin.Position = *last.Position + 1
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&pin).Error; err != nil {
+			return fmt.Errorf("failed to pin conversation: %w", err)
+		}
+		return summarizePin(tx, userID, conversation.ID, true, pin.Position)
+	})
+}
+
+// Unpin unpins a conversation for a user, returning ErrNotFound if it was
+// not pinned.
+func (r *ConversationRepository) Unpin(ctx context.Context, userID, conversationID uuid.UUID) error {
+	var unpinned int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.ConversationPin{}, "user_id = ? AND conversation_id = ?", userID, conversationID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		unpinned = result.RowsAffected
+		return summarizePin(tx, userID, conversationID, false, 0)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unpin conversation: %w", err)
+	}
+	if unpinned == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ReorderPins puts the user's pins in the order of conversationIDs.
+func (r *ConversationRepository) ReorderPins(ctx context.Context, userID uuid.UUID, conversationIDs []uuid.UUID) error {
+	now := time.Now()
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for position, conversationID := range conversationIDs {
+			result := tx.Model(&models.ConversationPin{}).
+				Where("user_id = ? AND conversation_id = ?", userID, conversationID).
+				Updates(map[string]any{"position": position, "updated_at": now})
+			err := result.Error
+			if err == nil && result.RowsAffected > 0 {
+				err = summarizePin(tx, userID, conversationID, true, position)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to reorder pins: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ChangedForUser returns the user's conversations in a workspace that
+// changed, that the user joined, or whose members joined, left or changed
+// role after since. Sending a message touches its conversation, so active
+// conversations are included too.
+func (r *ConversationRepository) ChangedForUser(ctx context.Context, workspaceID, userID uuid.UUID, since time.Time) ([]models.Conversation, error) {
+	var conversations []models.Conversation
+	err := r.db.WithContext(ctx).
+		Preload("Members").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.deleted_at IS NULL").
+		Where("conversation_members.user_id = ? AND conversations.workspace_id = ?", userID, workspaceID).
+		Where("conversations.updated_at > ? OR EXISTS (?)", since,
+			r.db.Unscoped().Model(&models.ConversationMember{}).Select("1").
+				Where("conversation_id = conversations.id AND (updated_at > ? OR deleted_at > ?)", since, since)).
+		Order("conversations.id").
+		Find(&conversations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed conversations: %w", err)
+	}
+	return conversations, nil
+}
+
+// LeftSince returns the conversations in a workspace the user left, or
+// that were deleted while they belonged to them, after since.
+func (r *ConversationRepository) LeftSince(ctx context.Context, workspaceID, userID uuid.UUID, since time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.ConversationMember{}).
+		Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id").
+		Where("conversation_members.user_id = ? AND conversations.workspace_id = ?", userID, workspaceID).
+		Where("conversation_members.deleted_at > ? OR conversations.deleted_at > ?", since, since).
+		Distinct().
+		Pluck("conversation_members.conversation_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list left conversations: %w", err)
+	}
+	return ids, nil
+}
+
+// ActivityCursor is the cursor positioned at a conversation in ListForUser
+// order.
+func ActivityCursor(conversation *models.Conversation) pagination.Cursor {
+	activity := conversation.CreatedAt
+	if conversation.LastMessageAt != nil {
+		activity = *conversation.LastMessageAt
+	}
+	return pagination.Cursor{Time: activity, ID: conversation.ID}
+}
+
+// IsMember reports whether userID currently belongs to the conversation.
+func (r *ConversationRepository) IsMember(ctx context.Context, conversationID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.ConversationMember{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check membership: %w", err)
+	}
+	return count > 0, nil
+}
+
+// MemberIDs returns the IDs of the conversation's current members.
+func (r *ConversationRepository) MemberIDs(ctx context.Context, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.ConversationMember{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,103 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Conversations keep their member count in a counter updated in the same
+// transaction as what changes it, as conversation summaries keep their
+// members' unread counts, so listing conversations counts nothing.
+// CounterRepository.Reconcile recomputes both from scratch and corrects
+// any drift, such as members removed by a cascade.
+
+// memberCount is the number of current members of the conversation
+// in the outer query.
+const memberCount = `(SELECT COUNT(*) FROM conversation_members
+	WHERE conversation_members.conversation_id = conversations.id AND conversation_members.deleted_at IS NULL)`
+
+// refreshMemberCounts recounts the members of the conversations, and
+// gives those who joined summaries and drops those of who left.
+func refreshMemberCounts(tx *gorm.DB, conversationIDs []uuid.UUID) error {
+	err := tx.Model(&models.Conversation{}).
+		Where("id IN ?", conversationIDs).
+		UpdateColumn("member_count", gorm.Expr(memberCount)).Error
+	if err != nil {
+		return fmt.Errorf("failed to count members: %w", err)
+	}
+	_, err = syncSummaries(tx, conversationIDs)
+	return err
+}
+
+type CounterRepository struct {
+	db *gorm.DB
+}
+
+func NewCounterRepository(db *gorm.DB) *CounterRepository {
+	return &CounterRepository{db: db}
+}
+
+// Reconcile recomputes the counters and member summaries of up to limit
+// conversations after the given one, in ID order, and returns the last
+// conversation it went through, or uuid.Nil once there are none left,
+// and how many counters and summaries it had to correct.
+func (r *CounterRepository) Reconcile(ctx context.Context, after uuid.UUID, limit int) (uuid.UUID, int64, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id > ?", after).
+		Order("id").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("failed to list conversations to reconcile: %w", err)
+	}
+	if len(ids) == 0 {
+		return uuid.Nil, 0, nil
+	}
+
+	var corrected int64
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		members := tx.Model(&models.Conversation{}).
+			Where("id IN ? AND member_count <> "+memberCount, ids).
+			UpdateColumn("member_count", gorm.Expr(memberCount))
+		if members.Error != nil {
+			return members.Error
+		}
+		synced, err := syncSummaries(tx, ids)
+		if err != nil {
+			return err
+		}
+		unread := tx.Model(&models.ConversationSummary{}).
+			Where("conversation_id IN ? AND unread_count <> "+unreadCount, ids, false).
+			UpdateColumn("unread_count", gorm.Expr(unreadCount, false))
+		if unread.Error != nil {
+			return unread.Error
+		}
+		pinned := tx.Model(&models.ConversationSummary{}).
+			Where("conversation_id IN ? AND (pinned <> "+summaryPinned+" OR pin_position <> "+summaryPinPosition+")", ids).
+			UpdateColumns(map[string]any{"pinned": gorm.Expr(summaryPinned), "pin_position": gorm.Expr(summaryPinPosition)})
+		if pinned.Error != nil {
+			return pinned.Error
+		}
+		muted := tx.Model(&models.ConversationSummary{}).
+			Where("conversation_id IN ? AND muted <> "+summaryMuted, ids).
+			UpdateColumns(map[string]any{"muted": gorm.Expr(summaryMuted), "muted_until": gorm.Expr(summaryMutedUntil)})
+		if muted.Error != nil {
+			return muted.Error
+		}
+		latest, err := refreshLastMessages(tx, ids)
+		if err != nil {
+			return err
+		}
+		corrected = members.RowsAffected + synced + unread.RowsAffected + pinned.RowsAffected + muted.RowsAffected + latest
+		return nil
+	})
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("failed to reconcile counters: %w", err)
+	}
+	return ids[len(ids)-1], corrected, nil
+}
@@ -0,0 +1,166 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sides of an email change a confirmation link is for.
+const (
+	EmailChangeOld = "old"
+	EmailChangeNew = "new"
+)
+
+type EmailChangeRepository struct {
+	db *gorm.DB
+}
+
+func NewEmailChangeRepository(db *gorm.DB) *EmailChangeRepository {
+	return &EmailChangeRepository{db: db}
+}
+
+// Start stores a new email change, cancelling any the user has pending, so
+// only the most recently asked for can complete.
+func (r *EmailChangeRepository) Start(ctx context.Context, change *models.EmailChange) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := pendingEmailChanges(tx, change.UserID, time.Now()).
+			Update("cancelled_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(change).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start email change: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the user's email change awaiting confirmation, or
+// ErrNotFound.
+func (r *EmailChangeRepository) Pending(ctx context.Context, userID uuid.UUID) (*models.EmailChange, error) {
+	var change models.EmailChange
+	err := pendingEmailChanges(r.db.WithContext(ctx), userID, time.Now()).First(&change).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email change: %w", err)
+	}
+	return &change, nil
+}
+
+// Cancel cancels the user's pending email change, returning ErrNotFound if
+// there is none.
+func (r *EmailChangeRepository) Cancel(ctx context.Context, userID uuid.UUID) error {
+	result := pendingEmailChanges(r.db.WithContext(ctx), userID, time.Now()).Update("cancelled_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel email change: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Confirm records that the link for side of the user's pending change was
+// followed, within tx. Once both sides are confirmed the account moves to
+// the new address, which the link proved, and the change can be reverted
+// until revertWindow has passed. It returns ErrNotFound without a pending
+// change and gorm.ErrDuplicatedKey if the address was taken meanwhile.
+func (r *EmailChangeRepository) Confirm(tx *gorm.DB, userID uuid.UUID, side string, revertWindow time.Duration) (*models.EmailChange, error) {
+	now := time.Now()
+	var change models.EmailChange
+	err := pendingEmailChanges(tx, userID, now).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&change).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email change: %w", err)
+	}
+
+	updates := map[string]any{}
+	switch side {
+	case EmailChangeOld:
+		change.OldConfirmedAt = &now
+		updates["old_confirmed_at"] = now
+	case EmailChangeNew:
+		change.NewConfirmedAt = &now
+		updates["new_confirmed_at"] = now
+	default:
+		return nil, fmt.Errorf("unknown side of email change %q", side)
+	}
+	if change.OldConfirmedAt != nil && change.NewConfirmedAt != nil {
+		err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]any{
+			"email":       change.NewEmail,
+			"is_verified": true,
+		}).Error
+		if err != nil {
+			return nil, err
+		}
+		revertibleUntil := now.Add(revertWindow)
+		change.CompletedAt, change.RevertibleUntil = &now, &revertibleUntil
+		updates["completed_at"], updates["revertible_until"] = now, revertibleUntil
+	}
+	if err := tx.Model(&change).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to confirm email change: %w", err)
+	}
+	return &change, nil
+}
+
+// Revert moves the user back to the address of their latest completed
+// change, within tx, if it is still revertible, and signs out every
+// session. It returns ErrNotFound if there is nothing to revert and
+// gorm.ErrDuplicatedKey if the old address was taken meanwhile.
+func (r *EmailChangeRepository) Revert(tx *gorm.DB, userID uuid.UUID) (*models.EmailChange, error) {
+	now := time.Now()
+	var change models.EmailChange
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND completed_at IS NOT NULL AND reverted_at IS NULL AND revertible_until > ?", userID, now).
+		Order("completed_at DESC").
+		First(&change).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email change: %w", err)
+	}
+
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Update("email", change.OldEmail).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Model(&change).Update("reverted_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to revert email change: %w", err)
+	}
+	change.RevertedAt = &now
+	if _, err := NewSessionRepository(tx).RevokeAll(tx.Statement.Context, userID, uuid.Nil); err != nil {
+		return nil, err
+	}
+	return &change, nil
+}
+
+// PurgeSettled deletes changes that can no longer complete or be reverted
+// since before, returning how many it deleted.
+func (r *EmailChangeRepository) PurgeSettled(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("(completed_at IS NULL AND (expires_at < @before OR cancelled_at < @before)) OR revertible_until < @before OR reverted_at < @before",
+			map[string]any{"before": before}).
+		Delete(&models.EmailChange{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge email changes: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+func pendingEmailChanges(db *gorm.DB, userID uuid.UUID, now time.Time) *gorm.DB {
+	return db.Model(&models.EmailChange{}).
+		Where("user_id = ? AND completed_at IS NULL AND cancelled_at IS NULL AND expires_at > ?", userID, now)
+}
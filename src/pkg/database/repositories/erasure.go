@@ -0,0 +1,263 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErasedDisplayName is shown in place of an erased user's name.
+const ErasedDisplayName = "Deleted user"
+
+// unheldAttachment is the condition leaving out attachments of messages
+// in conversations under legal hold.
+const unheldAttachment = "(message_id IS NULL OR message_id NOT IN (SELECT messages.id FROM messages JOIN legal_holds ON legal_holds.conversation_id = messages.conversation_id AND legal_holds.released_at IS NULL))"
+
+// EraseUser removes a user's personal data for good, returning the storage
+// keys of their files for the caller to delete once it commits.
+//
+// Their messages become tombstones and their attachments, backups,
+// exports, keys, sessions, contacts, blocks, bots and settings are
+// deleted. The user row stays, stripped of everything identifying, so
+// conversations keep their shape. Messages in audit rooms are kept, since
+// the room's chain must stay verifiable, as are moderation records and
+// messages in conversations under legal hold. A user under legal hold is
+// not erased: ErrLegalHold is returned until the hold is released.
+func EraseUser(ctx context.Context, db *gorm.DB, userID uuid.UUID) ([]string, error) {
+	var storageKeys []string
+	user := sql.Named("user", userID)
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var erased models.User
+		if err := tx.Unscoped().First(&erased, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		held, err := NewLegalHoldRepository(tx).UserHeld(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if held {
+			return ErrLegalHold
+		}
+
+		owned := []struct {
+			model any
+			where string
+		}{
+			{&models.Attachment{}, "uploader_id = @user AND " + unheldAttachment},
+			{&models.Backup{}, "user_id = @user"},
+			{&models.DataExport{}, "user_id = @user AND storage_key IS NOT NULL"},
+			{&models.Transcript{}, "user_id = @user AND storage_key IS NOT NULL"},
+		}
+		for _, files := range owned {
+			var keys []string
+			if err := tx.Unscoped().Model(files.model).Where(files.where, user).Pluck("storage_key", &keys).Error; err != nil {
+				return err
+			}
+			storageKeys = append(storageKeys, keys...)
+		}
+
+		audited := tx.Model(&models.Conversation{}).Select("id").Where("audited")
+		sent := tx.Unscoped().Model(&models.Message{}).Select("id").
+			Where("sender_id = ? AND conversation_id NOT IN (?) AND conversation_id NOT IN (?)", userID, audited, HeldConversations(tx))
+		if err := tx.Where("message_id IN (?)", sent).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		err = tx.Unscoped().Model(&models.Message{}).
+			Where("id IN (?)", sent).
+			Updates(map[string]any{
+				"text":               "",
+				"entities":           nil,
+				"payload":            nil,
+				"client_id":          nil,
+				"archive_segment_id": nil,
+				"deleted_at":         gorm.Expr("COALESCE(deleted_at, ?)", time.Now()),
+			}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&models.ConversationSummary{}).
+			Where("last_message_id IN (?)", sent).
+			UpdateColumn("last_message_preview", "").Error
+		if err != nil {
+			return err
+		}
+
+		deletions := []struct {
+			model any
+			where string
+		}{
+			{&models.ViewOnceToken{}, "user_id = @user OR attachment_id IN (SELECT id FROM attachments WHERE uploader_id = @user AND " + unheldAttachment + ")"},
+			{&models.Attachment{}, "uploader_id = @user AND " + unheldAttachment},
+			{&models.Backup{}, "user_id = @user"},
+			{&models.DataExport{}, "user_id = @user"},
+			{&models.Transcript{}, "user_id = @user"},
+			{&models.KeyBackup{}, "user_id = @user"},
+			{&models.CrossSigningKey{}, "user_id = @user"},
+			{&models.DeviceKey{}, "user_id = @user"},
+			{&models.DeviceToken{}, "user_id = @user"},
+			{&models.Session{}, "user_id = @user"},
+			{&models.USSDSession{}, "user_id = @user"},
+			{&models.VerificationToken{}, "user_id = @user"},
+			{&models.EmailChange{}, "user_id = @user"},
+			{&models.UserIdentity{}, "user_id = @user"},
+			{&models.NotificationPreferences{}, "user_id = @user"},
+			{&models.InboxNotification{}, "user_id = @user OR actor_id = @user"},
+			{&models.ConversationPin{}, "user_id = @user"},
+			{&models.RoomEmailRelay{}, "user_id = @user"},
+			{&models.ChannelLinkVisit{}, "user_id = @user"},
+			{&models.AutomationRun{}, "user_id = @user"},
+			{&models.RoomContribution{}, "user_id = @user"},
+			{&models.MessageReceipt{}, "user_id = @user"},
+			{&models.Reminder{}, "user_id = @user"},
+			{&models.AutoReplyRule{}, "owner_id = @user"},
+			{&models.BusinessProfile{}, "user_id = @user"},
+			{&models.LegalAcceptance{}, "user_id = @user"},
+			{&models.Contact{}, "requester_id = @user OR addressee_id = @user"},
+			{&models.Block{}, "blocker_id = @user OR blocked_id = @user"},
+			{&models.ReferralCode{}, "user_id = @user"},
+			{&models.Referral{}, "referrer_id = @user OR referred_id = @user"},
+			{&models.ReferralReward{}, "referrer_id = @user"},
+			{&models.Bot{}, "owner_id = @user"},
+			{&models.Report{}, "reporter_id = @user"},
+			{&models.Appeal{}, "user_id = @user"},
+			{&models.OnboardingProgress{}, "user_id = @user"},
+			{&models.ConsentEvent{}, "user_id = @user"},
+			{&models.UserBadge{}, "user_id = @user"},
+			{&models.ActivityDay{}, "user_id = @user"},
+			{&models.IdentitySignal{}, "user_id = @user"},
+			{&models.IdentityClusterMember{}, "user_id = @user"},
+		}
+		for _, deletion := range deletions {
+			if err := tx.Unscoped().Where(deletion.where, user).Delete(deletion.model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("email = ?", erased.Email).Delete(&models.WaitlistEntry{}).Error; err != nil {
+			return err
+		}
+		for _, created := range []any{&models.ShortLink{}, &models.IncomingWebhook{}, &models.OutgoingWebhook{}, &models.RoomEmailAddress{}, &models.AutomationRule{}} {
+			if err := tx.Model(created).Where("created_by_id = ?", userID).Update("created_by_id", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		// The email and username stay unique without naming anyone.
+		id := strings.ReplaceAll(userID.String(), "-", "")
+		return tx.Unscoped().Model(&erased).Select("*").Omit("id", "created_at").Updates(models.User{
+			Email:       "erased-" + id + "@invalid",
+			Username:    "deleted_" + id,
+			DisplayName: ErasedDisplayName,
+			AccountType: erased.AccountType,
+			TimeZone:    "UTC",
+			Residency:   erased.Residency,
+			Status:      "offline",
+			Role:        models.RoleUser,
+			DeletedAt:   gorm.DeletedAt{Time: time.Now(), Valid: true},
+		}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to erase user: %w", err)
+	}
+	return storageKeys, nil
+}
+
+type AccountErasureRepository struct {
+	db *gorm.DB
+}
+
+func NewAccountErasureRepository(db *gorm.DB) *AccountErasureRepository {
+	return &AccountErasureRepository{db: db}
+}
+
+// Create records a requested erasure.
+func (r *AccountErasureRepository) Create(ctx context.Context, erasure *models.AccountErasure) error {
+	if erasure.RequestedAt.IsZero() {
+		erasure.RequestedAt = time.Now()
+	}
+	if err := r.db.WithContext(ctx).Create(erasure).Error; err != nil {
+		return fmt.Errorf("failed to record account erasure: %w", err)
+	}
+	return nil
+}
+
+// Get loads an erasure record, or returns ErrNotFound.
+func (r *AccountErasureRepository) Get(ctx context.Context, id uuid.UUID) (*models.AccountErasure, error) {
+	var erasure models.AccountErasure
+	err := r.db.WithContext(ctx).First(&erasure, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account erasure: %w", err)
+	}
+	return &erasure, nil
+}
+
+// Open returns the user's erasure not yet completed, recording one if
+// there is none, for the job carrying it out.
+func (r *AccountErasureRepository) Open(ctx context.Context, userID uuid.UUID) (*models.AccountErasure, error) {
+	var erasure models.AccountErasure
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND completed_at IS NULL", userID).
+		Order("requested_at DESC").
+		First(&erasure).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		erasure = models.AccountErasure{UserID: userID}
+		return &erasure, r.Create(ctx, &erasure)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load account erasure: %w", err)
+	}
+	return &erasure, nil
+}
+
+// Save writes back the progress of an erasure.
+func (r *AccountErasureRepository) Save(ctx context.Context, erasure *models.AccountErasure) error {
+	if err := r.db.WithContext(ctx).Save(erasure).Error; err != nil {
+		return fmt.Errorf("failed to save account erasure: %w", err)
+	}
+	return nil
+}
+
+// List returns a page of erasures requested since the given time, oldest
+// first, or only those not completed with pending.
+func (r *AccountErasureRepository) List(ctx context.Context, since time.Time, pending bool, after *pagination.Cursor, limit int) ([]models.AccountErasure, error) {
+	query := r.db.WithContext(ctx).Where("requested_at >= ?", since)
+	if pending {
+		query = query.Where("completed_at IS NULL")
+	}
+	if after != nil {
+		query = query.Where("(requested_at, id) > (?, ?)", after.Time, after.ID)
+	}
+	var erasures []models.AccountErasure
+	if err := query.Order("requested_at, id").Limit(limit).Find(&erasures).Error; err != nil {
+		return nil, fmt.Errorf("failed to list account erasures: %w", err)
+	}
+	return erasures, nil
+}
+
+// ErasedSince returns the distinct accounts erased since the given time.
+func (r *AccountErasureRepository) ErasedSince(ctx context.Context, since time.Time) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.AccountErasure{}).
+		Distinct("user_id").
+		Where("requested_at >= ?", since).
+		Pluck("user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list erased accounts: %w", err)
+	}
+	return userIDs, nil
+}
+
+// ErasureCursor is the position of an erasure in List.
+func ErasureCursor(erasure *models.AccountErasure) pagination.Cursor {
+	return pagination.Cursor{Time: erasure.RequestedAt, ID: erasure.ID}
+}
@@ -0,0 +1,233 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UpcomingEvent is an event message with its schedule.
+type UpcomingEvent struct {
+	Message   models.Message
+	RoomEvent models.RoomEvent
+}
+
+type EventRepository struct {
+	db *gorm.DB
+}
+
+func NewEventRepository(db *gorm.DB) *EventRepository {
+	return &EventRepository{db: db}
+}
+
+// createRoomEvent records the schedule of an event message as it is
+// stored, within its transaction.
+func createRoomEvent(tx *gorm.DB, message *models.Message) error {
+	var event content.Event
+	if err := json.Unmarshal(message.Payload, &event); err != nil {
+		return fmt.Errorf("failed to decode event: %w", err)
+	}
+	return tx.Create(&models.RoomEvent{
+		MessageID: message.ID,
+		StartsAt:  event.StartsAt,
+		EndsAt:    event.EndsAt,
+		CreatedAt: message.CreatedAt,
+	}).Error
+}
+
+// Get returns the schedule of the event message messageID.
+func (r *EventRepository) Get(ctx context.Context, messageID uuid.UUID) (*models.RoomEvent, error) {
+	var event models.RoomEvent
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Take(&event).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get event: %w", err)
+	}
+	return &event, nil
+}
+
+// SetRSVP records userID's answer to the event, replacing any earlier one.
+func (r *EventRepository) SetRSVP(ctx context.Context, messageID, userID uuid.UUID, status content.RSVPStatus) error {
+	now := time.Now()
+	rsvp := models.EventRSVP{MessageID: messageID, UserID: userID, Status: string(status), CreatedAt: now, UpdatedAt: now}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "message_id"}, {Name: "user_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "updated_at"}),
+	}).Create(&rsvp).Error
+	if err != nil {
+		return fmt.Errorf("failed to set RSVP: %w", err)
+	}
+	return nil
+}
+
+// ClearRSVP removes userID's answer to the event.
+func (r *EventRepository) ClearRSVP(ctx context.Context, messageID, userID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Where("message_id = ? AND user_id = ?", messageID, userID).Delete(&models.EventRSVP{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to clear RSVP: %w", err)
+	}
+	return nil
+}
+
+// RSVPs returns the answers to the event, most recent first.
+func (r *EventRepository) RSVPs(ctx context.Context, messageID uuid.UUID) ([]models.EventRSVP, error) {
+	rsvps := []models.EventRSVP{}
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("updated_at DESC").Find(&rsvps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list RSVPs: %w", err)
+	}
+	return rsvps, nil
+}
+
+// RSVPCounts counts the answers to the event by status.
+func (r *EventRepository) RSVPCounts(ctx context.Context, messageID uuid.UUID) (map[content.RSVPStatus]int, error) {
+	var rows []struct {
+		Status string
+		Count  int
+	}
+	err := r.db.WithContext(ctx).Model(&models.EventRSVP{}).
+		Select("status, COUNT(*) AS count").
+		Where("message_id = ?", messageID).
+		Group("status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count RSVPs: %w", err)
+	}
+	counts := make(map[content.RSVPStatus]int, len(rows))
+	for _, row := range rows {
+		counts[content.RSVPStatus(row.Status)] = row.Count
+	}
+	return counts, nil
+}
+
+// Upcoming returns the events of a conversation not over by after, soonest
+// first. Events of deleted messages are left out.
+func (r *EventRepository) Upcoming(ctx context.Context, conversationID uuid.UUID, after time.Time, limit int) ([]UpcomingEvent, error) {
+	var events []models.RoomEvent
+	err := r.db.WithContext(ctx).
+		Joins("JOIN messages ON messages.id = room_events.message_id").
+		Where("messages.conversation_id = ? AND messages.deleted_at IS NULL", conversationID).
+		Where("COALESCE(room_events.ends_at, room_events.starts_at) >= ?", after).
+		Order("room_events.starts_at").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list upcoming events: %w", err)
+	}
+	return r.withMessages(ctx, events)
+}
+
+// ClaimDueReminders marks as reminded the events starting by before that
+// have not been, and returns them. Events that started by now, and those
+// of deleted messages, are marked without being returned, as a reminder
+// would come too late.
+func (r *EventRepository) ClaimDueReminders(ctx context.Context, now, before time.Time, limit int) ([]UpcomingEvent, error) {
+	var claimed []models.RoomEvent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var due []models.RoomEvent
+		err := tx.Where("reminded_at IS NULL AND starts_at <= ?", before).
+			Order("starts_at").
+			Limit(limit).
+			Find(&due).Error
+		if err != nil {
+			return err
+		}
+		for _, event := range due {
+			// Another instance may claim the same event at once; only
+			// the one that marks it reminds.
+			marked := tx.Model(&models.RoomEvent{}).
+				Where("message_id = ? AND reminded_at IS NULL", event.MessageID).
+				Update("reminded_at", now)
+			if marked.Error != nil {
+				return marked.Error
+			}
+			if marked.RowsAffected == 1 && event.StartsAt.After(now) {
+				claimed = append(claimed, event)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim event reminders: %w", err)
+	}
+	return r.withMessages(ctx, claimed)
+}
+
+// Attending returns the members of the conversation who answered the event
+// going or maybe.
+func (r *EventRepository) Attending(ctx context.Context, messageID, conversationID uuid.UUID) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.EventRSVP{}).
+		Joins("JOIN conversation_members ON conversation_members.user_id = event_rsvps.user_id AND conversation_members.conversation_id = ? AND conversation_members.deleted_at IS NULL", conversationID).
+		Where("event_rsvps.message_id = ? AND event_rsvps.status IN ?", messageID, []string{string(content.RSVPGoing), string(content.RSVPMaybe)}).
+		Pluck("event_rsvps.user_id", &userIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attendees: %w", err)
+	}
+	return userIDs, nil
+}
+
+// withMessages pairs events with their messages, dropping those whose
+// message was deleted.
+func (r *EventRepository) withMessages(ctx context.Context, events []models.RoomEvent) ([]UpcomingEvent, error) {
+	if len(events) == 0 {
+		return []UpcomingEvent{}, nil
+	}
+	ids := make([]uuid.UUID, len(events))
+	for i, event := range events {
+		ids[i] = event.MessageID
+	}
+	var messages []models.Message
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to load event messages: %w", err)
+	}
+	byID := make(map[uuid.UUID]models.Message, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+	}
+	upcoming := make([]UpcomingEvent, 0, len(events))
+	for _, event := range events {
+		if message, ok := byID[event.MessageID]; ok {
+			upcoming = append(upcoming, UpcomingEvent{Message: message, RoomEvent: event})
+		}
+	}
+	return upcoming, nil
+}
+
+// RSVPCountsByEvent counts the answers to each of the events by status.
+func (r *EventRepository) RSVPCountsByEvent(ctx context.Context, messageIDs []uuid.UUID) (map[uuid.UUID]map[content.RSVPStatus]int, error) {
+	counts := make(map[uuid.UUID]map[content.RSVPStatus]int, len(messageIDs))
+	if len(messageIDs) == 0 {
+		return counts, nil
+	}
+	var rows []struct {
+		MessageID uuid.UUID
+		Status    string
+		Count     int
+	}
+	err := r.db.WithContext(ctx).Model(&models.EventRSVP{}).
+		Select("message_id, status, COUNT(*) AS count").
+		Where("message_id IN ?", messageIDs).
+		Group("message_id, status").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count RSVPs: %w", err)
+	}
+	for _, row := range rows {
+		if counts[row.MessageID] == nil {
+			counts[row.MessageID] = map[content.RSVPStatus]int{}
+		}
+		counts[row.MessageID][content.RSVPStatus(row.Status)] = row.Count
+	}
+	return counts, nil
+}
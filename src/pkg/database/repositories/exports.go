@@ -0,0 +1,124 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type DataExportRepository struct {
+	db *gorm.DB
+}
+
+func NewDataExportRepository(db *gorm.DB) *DataExportRepository {
+	return &DataExportRepository{db: db}
+}
+
+// Create stores a new pending export.
+func (r *DataExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	export.Status = models.DataExportPending
+	if err := r.db.WithContext(ctx).Create(export).Error; err != nil {
+		return fmt.Errorf("failed to create data export: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the user's export still being built, or ErrNotFound.
+func (r *DataExportRepository) Pending(ctx context.Context, userID uuid.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status = ?", userID, models.DataExportPending).
+		First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data export: %w", err)
+	}
+	return &export, nil
+}
+
+// Get loads an export by ID alone, for the job building it.
+func (r *DataExportRepository) Get(ctx context.Context, id uuid.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := r.db.WithContext(ctx).First(&export, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data export: %w", err)
+	}
+	return &export, nil
+}
+
+// GetForUser loads one of the user's exports.
+func (r *DataExportRepository) GetForUser(ctx context.Context, userID, id uuid.UUID) (*models.DataExport, error) {
+	var export models.DataExport
+	err := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).First(&export).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load data export: %w", err)
+	}
+	return &export, nil
+}
+
+// MarkReady records a built archive and removes the user's earlier
+// exports, returning them for the caller to clean up after.
+func (r *DataExportRepository) MarkReady(ctx context.Context, export *models.DataExport, storageKey string, sizeBytes int64, ttl time.Duration) ([]models.DataExport, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	var pruned []models.DataExport
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(export).Updates(map[string]any{
+			"status":       models.DataExportReady,
+			"storage_key":  storageKey,
+			"size_bytes":   sizeBytes,
+			"expires_at":   expires,
+			"completed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.Returning{}).
+			Where("user_id = ? AND id <> ? AND status <> ?", export.UserID, export.ID, models.DataExportPending).
+			Delete(&pruned).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete data export: %w", err)
+	}
+	return pruned, nil
+}
+
+// MarkFailed records that an export could not be built.
+func (r *DataExportRepository) MarkFailed(ctx context.Context, id uuid.UUID) error {
+	err := r.db.WithContext(ctx).Model(&models.DataExport{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"status": models.DataExportFailed, "completed_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record data export failure: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes ready exports that expired and failed ones that
+// finished before failedBefore, returning them for the caller to clean up
+// after.
+func (r *DataExportRepository) PurgeExpired(ctx context.Context, failedBefore time.Time) ([]models.DataExport, error) {
+	var purged []models.DataExport
+	err := r.db.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("(status = ? AND expires_at <= ?) OR (status = ? AND completed_at < ?)",
+			models.DataExportReady, time.Now(), models.DataExportFailed, failedBefore).
+		Delete(&purged).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge data exports: %w", err)
+	}
+	return purged, nil
+}
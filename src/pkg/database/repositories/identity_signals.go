@@ -0,0 +1,134 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdentityLink is a signal two accounts share.
+type IdentityLink struct {
+	UserID  uuid.UUID
+	OtherID uuid.UUID
+	Kind    string
+	Value   string
+}
+
+type IdentitySignalRepository struct {
+	db *gorm.DB
+}
+
+func NewIdentitySignalRepository(db *gorm.DB) *IdentitySignalRepository {
+	return &IdentitySignalRepository{db: db}
+}
+
+// Record notes that the user was seen with the signals now, keeping when
+// each was first seen.
+func (r *IdentitySignalRepository) Record(ctx context.Context, userID uuid.UUID, signals []models.IdentitySignal) error {
+	if len(signals) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for i := range signals {
+		signals[i].UserID, signals[i].FirstSeenAt, signals[i].LastSeenAt = userID, now, now
+	}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "kind"}, {Name: "value"}},
+		DoUpdates: clause.AssignmentColumns([]string{"last_seen_at"}),
+	}).Create(&signals).Error
+	if err != nil {
+		return fmt.Errorf("failed to record identity signals: %w", err)
+	}
+	return nil
+}
+
+// PurgeStale deletes signals not seen since before.
+func (r *IdentitySignalRepository) PurgeStale(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("last_seen_at < ?", before).Delete(&models.IdentitySignal{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge identity signals: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Links returns the signals the users share with other accounts, leaving
+// out values seen on more than maxSharers accounts: an address behind a
+// carrier NAT or a shared computer links strangers.
+func (r *IdentitySignalRepository) Links(ctx context.Context, userIDs []uuid.UUID, maxSharers int) ([]IdentityLink, error) {
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+	sharers := r.db.Model(&models.IdentitySignal{}).
+		Select("kind, value").
+		Group("kind, value").
+		Having("COUNT(*) > ?", maxSharers)
+	var links []IdentityLink
+	err := r.db.WithContext(ctx).Table("identity_signals AS a").
+		Select("a.user_id, b.user_id AS other_id, a.kind, a.value").
+		Joins("JOIN identity_signals AS b ON b.kind = a.kind AND b.value = a.value AND b.user_id <> a.user_id").
+		Where("a.user_id IN ?", userIDs).
+		Where("(a.kind, a.value) NOT IN (?)", sharers).
+		Order("a.user_id, b.user_id").
+		Scan(&links).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to link identity signals: %w", err)
+	}
+	return links, nil
+}
+
+// BannedUsers returns the banned accounts there are signals for, which
+// clusters are grown from.
+func (r *IdentitySignalRepository) BannedUsers(ctx context.Context) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.User{}).Unscoped().
+		Where("is_banned = ? AND id IN (?)", true, r.db.Model(&models.IdentitySignal{}).Select("user_id")).
+		Order("banned_at DESC").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list banned users: %w", err)
+	}
+	return ids, nil
+}
+
+// ReplaceClusters swaps the stored clusters for a fresh scan's.
+func (r *IdentitySignalRepository) ReplaceClusters(ctx context.Context, clusters []models.IdentityCluster) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.IdentityClusterMember{}).Error; err != nil {
+			return fmt.Errorf("failed to clear identity cluster members: %w", err)
+		}
+		if err := tx.Where("1 = 1").Delete(&models.IdentityCluster{}).Error; err != nil {
+			return fmt.Errorf("failed to clear identity clusters: %w", err)
+		}
+		for i := range clusters {
+			if err := tx.Create(&clusters[i]).Error; err != nil {
+				return fmt.Errorf("failed to store identity cluster: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ListClusters returns a page of stored clusters with their members,
+// newest member first.
+func (r *IdentitySignalRepository) ListClusters(ctx context.Context, after *pagination.Cursor, limit int) ([]models.IdentityCluster, error) {
+	query := r.db.WithContext(ctx).Preload("Members")
+	if after != nil {
+		query = query.Where("(newest_member_at, id) < (?, ?)", after.Time, after.ID)
+	}
+	var clusters []models.IdentityCluster
+	if err := query.Order("newest_member_at DESC, id DESC").Limit(limit).Find(&clusters).Error; err != nil {
+		return nil, fmt.Errorf("failed to list identity clusters: %w", err)
+	}
+	return clusters, nil
+}
+
+// IdentityClusterCursor is the cursor of the page after a cluster.
+func IdentityClusterCursor(cluster *models.IdentityCluster) pagination.Cursor {
+	return pagination.Cursor{Time: cluster.NewestMemberAt, ID: cluster.ID}
+}
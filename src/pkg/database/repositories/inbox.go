@@ -0,0 +1,120 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// inboxBatchSize bounds the rows of one insert when notifying many users.
+const inboxBatchSize = 500
+
+type InboxRepository struct {
+	db *gorm.DB
+}
+
+func NewInboxRepository(db *gorm.DB) *InboxRepository {
+	return &InboxRepository{db: db}
+}
+
+// Add stores notifications, filling in their IDs.
+func (r *InboxRepository) Add(ctx context.Context, notifications []models.InboxNotification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := r.db.WithContext(ctx).CreateInBatches(&notifications, inboxBatchSize).Error; err != nil {
+		return fmt.Errorf("failed to add notifications: %w", err)
+	}
+	return nil
+}
+
+// List returns a page of the user's notifications, or with unread only
+// those not yet read, newest first.
+func (r *InboxRepository) List(ctx context.Context, userID uuid.UUID, unread bool, after *pagination.Cursor, limit int) ([]models.InboxNotification, error) {
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if unread {
+		query = query.Where("read_at IS NULL")
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+	var notifications []models.InboxNotification
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&notifications).Error; err != nil {
+		return nil, fmt.Errorf("failed to list notifications: %w", err)
+	}
+	return notifications, nil
+}
+
+// InboxCursor is the position of a notification in List.
+func InboxCursor(notification *models.InboxNotification) pagination.Cursor {
+	return pagination.Cursor{Time: notification.CreatedAt, ID: notification.ID}
+}
+
+// UnreadCount counts the user's unread notifications, for the badge on
+// the bell.
+func (r *InboxRepository) UnreadCount(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.InboxNotification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count unread notifications: %w", err)
+	}
+	return count, nil
+}
+
+// MarkRead marks the user's notifications with the given IDs read, or
+// every one of them when ids is empty, and returns how many were unread.
+// IDs of other users' notifications are ignored.
+func (r *InboxRepository) MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID, at time.Time) (int64, error) {
+	query := r.db.WithContext(ctx).Model(&models.InboxNotification{}).
+		Where("user_id = ? AND read_at IS NULL", userID)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Update("read_at", at)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to mark notifications read: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Delete clears one of the user's notifications.
+func (r *InboxRepository) Delete(ctx context.Context, userID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND user_id = ?", id, userID).Delete(&models.InboxNotification{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete notification: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Clear deletes the user's notifications, or with readOnly only those
+// already read, and returns how many it deleted.
+func (r *InboxRepository) Clear(ctx context.Context, userID uuid.UUID, readOnly bool) (int64, error) {
+	query := r.db.WithContext(ctx).Where("user_id = ?", userID)
+	if readOnly {
+		query = query.Where("read_at IS NOT NULL")
+	}
+	result := query.Delete(&models.InboxNotification{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to clear notifications: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// Purge deletes the notifications created before the given time.
+func (r *InboxRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("created_at < ?", before).Delete(&models.InboxNotification{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge notifications: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,229 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type JobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+// Enqueue queues a job to run as soon as a worker is free. Created with a
+// transaction, the job is only queued if the transaction commits.
+func (r *JobRepository) Enqueue(ctx context.Context, kind string, payload any) (*models.Job, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode job payload: %w", err)
+	}
+	job := models.Job{Kind: kind, Payload: models.JSON(raw), Status: models.JobPending, RunAt: time.Now()}
+	if err := r.db.WithContext(ctx).Create(&job).Error; err != nil {
+		return nil, fmt.Errorf("failed to queue job: %w", err)
+	}
+	return &job, nil
+}
+
+// Claim takes the next due job and marks it running, or returns
+// ErrNotFound when none is due. Workers on other instances skip the job
+// while it is claimed. A job left running for longer than lease, by a
+// worker that died, is claimed again.
+func (r *JobRepository) Claim(ctx context.Context, lease time.Duration) (*models.Job, error) {
+	now := time.Now()
+	db := r.db.WithContext(ctx)
+	// Built rather than raw, as SQLite has no row locks to skip and its
+	// dialect leaves the locking clause out.
+	next := db.Model(&models.Job{}).Select("id").
+		Where("(status = ? AND run_at <= ?) OR (status = ? AND updated_at < ?)", models.JobPending, now, models.JobRunning, now.Add(-lease)).
+		Order("run_at").
+		Limit(1).
+		Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+	var job models.Job
+	err := db.Model(&job).Clauses(clause.Returning{}).
+		Where("id = (?)", next).
+		Updates(map[string]any{"status": models.JobRunning, "attempts": gorm.Expr("attempts + 1"), "updated_at": now}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+	if job.ID == uuid.Nil {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+// Finish removes a job that ran successfully.
+func (r *JobRepository) Finish(ctx context.Context, id uuid.UUID) error {
+	if err := r.db.WithContext(ctx).Delete(&models.Job{}, "id = ?", id).Error; err != nil {
+		return fmt.Errorf("failed to finish job: %w", err)
+	}
+	return nil
+}
+
+// Fail records a job's error. A nil retryAt gives up on the job;
+// otherwise it runs again then.
+func (r *JobRepository) Fail(ctx context.Context, id uuid.UUID, jobErr error, retryAt *time.Time) error {
+	updates := map[string]any{"status": models.JobFailed, "last_error": jobErr.Error()}
+	if retryAt != nil {
+		updates["status"] = models.JobPending
+		updates["run_at"] = *retryAt
+	}
+	if err := r.db.WithContext(ctx).Model(&models.Job{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record job failure: %w", err)
+	}
+	return nil
+}
+
+// QueueIfDue queues a job of kind if its schedule has fallen due, and moves
+// the schedule on by interval, reporting whether it queued one. A schedule
+// seen for the first time is due at once. The job is not queued again
+// while an earlier one of its kind is still pending or running.
+func (r *JobRepository) QueueIfDue(ctx context.Context, kind string, interval time.Duration) (bool, error) {
+	now := time.Now()
+	queued := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.ScheduledTask{Name: kind, NextRunAt: now}).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&models.ScheduledTask{}).
+			Where("name = ? AND next_run_at <= ?", kind, now).
+			Updates(map[string]any{"next_run_at": now.Add(interval), "last_run_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		var outstanding int64
+		err = tx.Model(&models.Job{}).
+			Where("kind = ? AND status IN ?", kind, []string{models.JobPending, models.JobRunning}).
+			Count(&outstanding).Error
+		if err != nil || outstanding > 0 {
+			return err
+		}
+		if _, err := NewJobRepository(tx).Enqueue(ctx, kind, struct{}{}); err != nil {
+			return err
+		}
+		queued = true
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to queue scheduled job: %w", err)
+	}
+	return queued, nil
+}
+
+// PurgeFailed deletes jobs that were given up on before before, returning
+// how many it deleted.
+func (r *JobRepository) PurgeFailed(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("status = ? AND updated_at < ?", models.JobFailed, before).Delete(&models.Job{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge failed jobs: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// JobCount is how many jobs of a kind are in a status.
+type JobCount struct {
+	Kind   string `json:"kind"`
+	Status string `json:"status"`
+	Count  int64  `json:"count"`
+}
+
+// Counts returns how many jobs of each kind are in each status.
+func (r *JobRepository) Counts(ctx context.Context) ([]JobCount, error) {
+	var counts []JobCount
+	err := r.db.WithContext(ctx).Model(&models.Job{}).
+		Select("kind, status, COUNT(*) AS count").
+		Group("kind, status").
+		Order("kind, status").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count jobs: %w", err)
+	}
+	return counts, nil
+}
+
+// Get returns a job in any status.
+func (r *JobRepository) Get(ctx context.Context, id uuid.UUID) (*models.Job, error) {
+	var job models.Job
+	err := r.db.WithContext(ctx).First(&job, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load job: %w", err)
+	}
+	return &job, nil
+}
+
+// ListFailed returns the jobs that were given up on, of kind if it is not
+// empty, most recently failed first, from before the cursor.
+func (r *JobRepository) ListFailed(ctx context.Context, kind string, before *pagination.Cursor, limit int) ([]models.Job, error) {
+	query := r.db.WithContext(ctx).Where("status = ?", models.JobFailed)
+	if kind != "" {
+		query = query.Where("kind = ?", kind)
+	}
+	if before != nil {
+		query = query.Where("(updated_at, id) < (?, ?)", before.Time, before.ID)
+	}
+
+	var jobs []models.Job
+	if err := query.Order("updated_at DESC, id DESC").Limit(limit).Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list failed jobs: %w", err)
+	}
+	return jobs, nil
+}
+
+// Retry queues a job that was given up on to run again at once, with its
+// attempts starting over. It returns ErrNotFound unless the job failed.
+// The last error is kept until the job next runs.
+func (r *JobRepository) Retry(ctx context.Context, id uuid.UUID) (*models.Job, error) {
+	var job models.Job
+	result := r.db.WithContext(ctx).Model(&job).Clauses(clause.Returning{}).
+		Where("id = ? AND status = ?", id, models.JobFailed).
+		Updates(map[string]any{"status": models.JobPending, "attempts": 0, "run_at": time.Now()})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to retry job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+// RetryFailed queues every job of kind that was given up on to run again,
+// as Retry does, returning how many it queued.
+func (r *JobRepository) RetryFailed(ctx context.Context, kind string) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.Job{}).
+		Where("status = ? AND kind = ?", models.JobFailed, kind).
+		Updates(map[string]any{"status": models.JobPending, "attempts": 0, "run_at": time.Now()})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to retry jobs: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// DeleteFailed discards a job that was given up on, returning ErrNotFound
+// unless the job failed.
+func (r *JobRepository) DeleteFailed(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND status = ?", id, models.JobFailed).Delete(&models.Job{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete job: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package repositories
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type KeyRepository struct {
+	db *gorm.DB
+}
+
+func NewKeyRepository(db *gorm.DB) *KeyRepository {
+	return &KeyRepository{db: db}
+}
+
+// SaveBackup stores a user's key backup if the stored one is at
+// expectedVersion, 0 meaning none, and sets the backup's version to the
+// next one. It returns ErrStaleVersion if another device replaced the
+// backup first.
+func (r *KeyRepository) SaveBackup(ctx context.Context, backup *models.KeyBackup, expectedVersion int) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.KeyBackup
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", backup.UserID).
+			First(&current).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if expectedVersion != 0 {
+				return ErrStaleVersion
+			}
+			backup.Version = 1
+			return tx.Create(backup).Error
+		case err != nil:
+			return err
+		case current.Version != expectedVersion:
+			return ErrStaleVersion
+		}
+		backup.Version = current.Version + 1
+		backup.CreatedAt = current.CreatedAt
+		return tx.Save(backup).Error
+	})
+	if errors.Is(err, ErrStaleVersion) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save key backup: %w", err)
+	}
+	return nil
+}
+
+// Backup returns a user's key backup, or ErrNotFound.
+func (r *KeyRepository) Backup(ctx context.Context, userID uuid.UUID) (*models.KeyBackup, error) {
+	var backup models.KeyBackup
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&backup).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key backup: %w", err)
+	}
+	return &backup, nil
+}
+
+// DeleteBackup removes a user's key backup. It returns ErrNotFound if
+// there was none.
+func (r *KeyRepository) DeleteBackup(ctx context.Context, userID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("user_id = ?", userID).Delete(&models.KeyBackup{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete key backup: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetCrossSigningKey stores a user's cross-signing key. Replacing it with
+// a different key withdraws every device signature made by the old one.
+func (r *KeyRepository) SetCrossSigningKey(ctx context.Context, key *models.CrossSigningKey) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.CrossSigningKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", key.UserID).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(key).Error
+		}
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(current.PublicKey, key.PublicKey) {
+			*key = current
+			return nil
+		}
+
+		err = tx.Model(&models.DeviceKey{}).
+			Where("user_id = ?", key.UserID).
+			Updates(map[string]any{"signature": nil, "signed_at": nil}).Error
+		if err != nil {
+			return err
+		}
+		key.CreatedAt = current.CreatedAt
+		return tx.Save(key).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set cross-signing key: %w", err)
+	}
+	return nil
+}
+
+// CrossSigningKey returns a user's cross-signing key, or ErrNotFound.
+func (r *KeyRepository) CrossSigningKey(ctx context.Context, userID uuid.UUID) (*models.CrossSigningKey, error) {
+	var key models.CrossSigningKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cross-signing key: %w", err)
+	}
+	return &key, nil
+}
+
+// SaveDevice registers a device's identity key or updates it. A changed
+// identity key loses its signature, since the signature was for the old
+// key, and the device's prekeys.
+func (r *KeyRepository) SaveDevice(ctx context.Context, device *models.DeviceKey) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.DeviceKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND device_id = ?", device.UserID, device.DeviceID).
+			First(&current).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(device).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		device.ID, device.CreatedAt = current.ID, current.CreatedAt
+		if bytes.Equal(current.IdentityKey, device.IdentityKey) {
+			device.Signature, device.SignedAt = current.Signature, current.SignedAt
+		} else {
+			// The prekeys were signed for, or go with, the old key.
+			if err := tx.Where("device_key_id = ?", current.ID).Delete(&models.SignedPreKey{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("device_key_id = ?", current.ID).Delete(&models.OneTimePreKey{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(device).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save device key: %w", err)
+	}
+	return nil
+}
+
+// Device returns one of a user's devices, or ErrNotFound.
+func (r *KeyRepository) Device(ctx context.Context, userID uuid.UUID, deviceID string) (*models.DeviceKey, error) {
+	var device models.DeviceKey
+	err := r.db.WithContext(ctx).Where("user_id = ? AND device_id = ?", userID, deviceID).First(&device).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load device key: %w", err)
+	}
+	return &device, nil
+}
+
+// SignDevice stores the cross-signing signature of a device's current
+// identity key. It returns ErrNotFound if the device is gone or its key
+// changed since it was signed.
+func (r *KeyRepository) SignDevice(ctx context.Context, device *models.DeviceKey, signature []byte) error {
+	now := time.Now()
+	result := r.db.WithContext(ctx).Model(&models.DeviceKey{}).
+		Where("id = ? AND identity_key = ?", device.ID, device.IdentityKey).
+		Updates(map[string]any{"signature": signature, "signed_at": now})
+	if result.Error != nil {
+		return fmt.Errorf("failed to sign device: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	device.Signature, device.SignedAt = signature, &now
+	return nil
+}
+
+// Devices returns a user's devices, oldest first.
+func (r *KeyRepository) Devices(ctx context.Context, userID uuid.UUID) ([]models.DeviceKey, error) {
+	var devices []models.DeviceKey
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at ASC").Find(&devices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list device keys: %w", err)
+	}
+	return devices, nil
+}
+
+// DeleteDevice removes a device's identity key. It returns ErrNotFound if
+// there was none.
+func (r *KeyRepository) DeleteDevice(ctx context.Context, userID uuid.UUID, deviceID string) error {
+	result := r.db.WithContext(ctx).Where("user_id = ? AND device_id = ?", userID, deviceID).Delete(&models.DeviceKey{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete device key: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PreKeyBundle is what a peer needs to start a session with one device
+// while it is offline.
+type PreKeyBundle struct {
+	Device       models.DeviceKey    `json:"device"`
+	SignedPreKey models.SignedPreKey `json:"signed_prekey"`
+
+	// OneTimePreKey is nil once the device has run out, and the session
+	// starts from the signed prekey alone.
+	OneTimePreKey *models.OneTimePreKey `json:"one_time_prekey"`
+
+	// Remaining is how many one-time prekeys the device has left.
+	Remaining int64 `json:"-"`
+}
+
+// SaveSignedPreKey stores a device's signed prekey, replacing the one it
+// had.
+func (r *KeyRepository) SaveSignedPreKey(ctx context.Context, preKey *models.SignedPreKey) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_key_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"key_id", "public_key", "signature", "updated_at"}),
+	}).Create(preKey).Error
+	if err != nil {
+		return fmt.Errorf("failed to save signed prekey: %w", err)
+	}
+	return nil
+}
+
+// AddOneTimePreKeys stores a batch of a device's one-time prekeys and
+// returns how many it has. Keys with an ID the device already uses are
+// skipped. It returns ErrLimitReached if the device would hold more than
+// limit.
+func (r *KeyRepository) AddOneTimePreKeys(ctx context.Context, deviceKeyID uuid.UUID, preKeys []models.OneTimePreKey, limit int) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Locking the device serializes uploads, so the limit holds.
+		var device models.DeviceKey
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, "id = ?", deviceKeyID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.OneTimePreKey{}).Where("device_key_id = ?", deviceKeyID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count+int64(len(preKeys)) > int64(limit) {
+			return ErrLimitReached
+		}
+		for i := range preKeys {
+			preKeys[i].DeviceKeyID = deviceKeyID
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&preKeys)
+		if result.Error != nil {
+			return result.Error
+		}
+		count += result.RowsAffected
+		return nil
+	})
+	if errors.Is(err, ErrLimitReached) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to add one-time prekeys: %w", err)
+	}
+	return count, nil
+}
+
+// CountOneTimePreKeys returns how many one-time prekeys a device has left.
+func (r *KeyRepository) CountOneTimePreKeys(ctx context.Context, deviceKeyID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.OneTimePreKey{}).Where("device_key_id = ?", deviceKeyID).Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count one-time prekeys: %w", err)
+	}
+	return count, nil
+}
+
+// ClaimBundles returns a prekey bundle for each of a user's devices with a
+// signed prekey, oldest device first, taking one of each device's one-time
+// prekeys so that no other peer is given it.
+func (r *KeyRepository) ClaimBundles(ctx context.Context, userID uuid.UUID) ([]PreKeyBundle, error) {
+	var signed []models.SignedPreKey
+	err := r.db.WithContext(ctx).
+		Joins("DeviceKey").
+		Where("\"DeviceKey\".user_id = ?", userID).
+		Order("\"DeviceKey\".created_at ASC").
+		Find(&signed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signed prekeys: %w", err)
+	}
+
+	bundles := make([]PreKeyBundle, 0, len(signed))
+	for _, preKey := range signed {
+		bundle := PreKeyBundle{Device: preKey.DeviceKey, SignedPreKey: preKey}
+		var claimed []models.OneTimePreKey
+		err := r.db.WithContext(ctx).Raw(`DELETE FROM one_time_prekeys WHERE id = (
+				SELECT id FROM one_time_prekeys WHERE device_key_id = ?
+				ORDER BY key_id LIMIT 1 FOR UPDATE SKIP LOCKED
+			) RETURNING *`, preKey.DeviceKeyID).Scan(&claimed).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim one-time prekey: %w", err)
+		}
+		if len(claimed) > 0 {
+			bundle.OneTimePreKey = &claimed[0]
+		}
+		if bundle.Remaining, err = r.CountOneTimePreKeys(ctx, preKey.DeviceKeyID); err != nil {
+			return nil, err
+		}
+		bundles = append(bundles, bundle)
+	}
+	return bundles, nil
+}
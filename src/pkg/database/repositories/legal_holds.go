@@ -0,0 +1,267 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrLegalHold means what was to be deleted is under an active legal
+// hold.
+var ErrLegalHold = errors.New("under legal hold")
+
+type LegalHoldRepository struct {
+	db *gorm.DB
+}
+
+func NewLegalHoldRepository(db *gorm.DB) *LegalHoldRepository {
+	return &LegalHoldRepository{db: db}
+}
+
+// Create places a hold.
+func (r *LegalHoldRepository) Create(ctx context.Context, hold *models.LegalHold) error {
+	if err := r.db.WithContext(ctx).Create(hold).Error; err != nil {
+		return fmt.Errorf("failed to place legal hold: %w", err)
+	}
+	return nil
+}
+
+// Get loads a hold, or returns ErrNotFound.
+func (r *LegalHoldRepository) Get(ctx context.Context, id uuid.UUID) (*models.LegalHold, error) {
+	var hold models.LegalHold
+	err := r.db.WithContext(ctx).First(&hold, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load legal hold: %w", err)
+	}
+	return &hold, nil
+}
+
+// List returns a page of holds, newest first, or only those not released
+// with active.
+func (r *LegalHoldRepository) List(ctx context.Context, active bool, after *pagination.Cursor, limit int) ([]models.LegalHold, error) {
+	query := r.db.WithContext(ctx)
+	if active {
+		query = query.Where("released_at IS NULL")
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+	var holds []models.LegalHold
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&holds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list legal holds: %w", err)
+	}
+	return holds, nil
+}
+
+// Release ends an active hold, or returns ErrNotFound if it is not one.
+func (r *LegalHoldRepository) Release(ctx context.Context, id, releasedByID uuid.UUID) (*models.LegalHold, error) {
+	result := r.db.WithContext(ctx).Model(&models.LegalHold{}).
+		Where("id = ? AND released_at IS NULL", id).
+		Updates(map[string]any{"released_at": time.Now(), "released_by_id": releasedByID})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to release legal hold: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+	return r.Get(ctx, id)
+}
+
+// UserHeld reports whether the user is under an active hold.
+func (r *LegalHoldRepository) UserHeld(ctx context.Context, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.LegalHold{}).
+		Where("user_id = ? AND released_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up legal holds: %w", err)
+	}
+	return count > 0, nil
+}
+
+// MessageHeld reports whether a message is under an active hold, through
+// its sender or its conversation.
+func (r *LegalHoldRepository) MessageHeld(ctx context.Context, message *models.Message) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.LegalHold{}).
+		Where("released_at IS NULL AND (user_id = ? OR conversation_id = ?)", message.SenderID, message.ConversationID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up legal holds: %w", err)
+	}
+	return count > 0, nil
+}
+
+// HeldConversations is a subquery of the conversations under an active
+// hold, for deletions to leave them out.
+func HeldConversations(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.LegalHold{}).
+		Select("conversation_id").
+		Where("released_at IS NULL AND conversation_id IS NOT NULL")
+}
+
+// HeldUsers is a subquery of the users under an active hold.
+func HeldUsers(db *gorm.DB) *gorm.DB {
+	return db.Model(&models.LegalHold{}).
+		Select("user_id").
+		Where("released_at IS NULL AND user_id IS NOT NULL")
+}
+
+// preservedEdit is a version a preserved message was edited from.
+type preservedEdit struct {
+	Text      string      `json:"text"`
+	Entities  models.JSON `json:"entities"`
+	WrittenAt time.Time   `json:"written_at"`
+}
+
+// Preserve copies a message about to be deleted, with its edit history,
+// if it is under an active hold. It runs in the deletion's transaction.
+func (r *LegalHoldRepository) Preserve(ctx context.Context, message *models.Message) error {
+	held, err := r.MessageHeld(ctx, message)
+	if err != nil || !held {
+		return err
+	}
+	var edits []models.MessageEdit
+	if err := r.db.WithContext(ctx).Where("message_id = ?", message.ID).Order("created_at").Find(&edits).Error; err != nil {
+		return fmt.Errorf("failed to load edits to preserve: %w", err)
+	}
+	versions := make([]preservedEdit, len(edits))
+	for i, edit := range edits {
+		versions[i] = preservedEdit{Text: edit.Text, Entities: edit.Entities, WrittenAt: edit.WrittenAt}
+	}
+	history, err := json.Marshal(versions)
+	if err != nil {
+		return fmt.Errorf("failed to encode edits to preserve: %w", err)
+	}
+
+	preserved := models.PreservedMessage{
+		MessageID:      message.ID,
+		ConversationID: message.ConversationID,
+		SenderID:       message.SenderID,
+		Type:           message.Type,
+		Text:           message.Text,
+		Entities:       message.Entities,
+		Payload:        message.Payload,
+		Edits:          history,
+		SentAt:         message.CreatedAt,
+		PreservedAt:    time.Now(),
+	}
+	if err := r.db.WithContext(ctx).Create(&preserved).Error; err != nil {
+		return fmt.Errorf("failed to preserve message: %w", err)
+	}
+	return nil
+}
+
+// Preserved returns up to limit messages preserved for a hold's subject
+// after the cursor, oldest first.
+func (r *LegalHoldRepository) Preserved(ctx context.Context, hold *models.LegalHold, after pagination.Cursor, limit int) ([]models.PreservedMessage, error) {
+	query := r.db.WithContext(ctx).Where("(sent_at, id) > (?, ?)", after.Time, after.ID)
+	if hold.UserID != nil {
+		query = query.Where("sender_id = ?", *hold.UserID)
+	} else {
+		query = query.Where("conversation_id = ?", *hold.ConversationID)
+	}
+	var preserved []models.PreservedMessage
+	if err := query.Order("sent_at, id").Limit(limit).Find(&preserved).Error; err != nil {
+		return nil, fmt.Errorf("failed to list preserved messages: %w", err)
+	}
+	return preserved, nil
+}
+
+// Messages returns up to limit messages of a hold's subject after the
+// cursor, oldest first, deleted ones included.
+func (r *LegalHoldRepository) Messages(ctx context.Context, hold *models.LegalHold, after pagination.Cursor, limit int) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).Unscoped().Where("(created_at, id) > (?, ?)", after.Time, after.ID)
+	if hold.UserID != nil {
+		query = query.Where("sender_id = ?", *hold.UserID)
+	} else {
+		query = query.Where("conversation_id = ?", *hold.ConversationID)
+	}
+	var messages []models.Message
+	if err := query.Order("created_at, id").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to list held messages: %w", err)
+	}
+	return messages, nil
+}
+
+// Audit appends an entry to the lawful-request audit log.
+func (r *LegalHoldRepository) Audit(ctx context.Context, entry *models.LegalAuditEntry) error {
+	if err := r.db.WithContext(ctx).Create(entry).Error; err != nil {
+		return fmt.Errorf("failed to write legal audit entry: %w", err)
+	}
+	return nil
+}
+
+// AuditLog returns a page of the audit log, newest first, or only the
+// entries about one hold.
+func (r *LegalHoldRepository) AuditLog(ctx context.Context, holdID *uuid.UUID, after *pagination.Cursor, limit int) ([]models.LegalAuditEntry, error) {
+	query := r.db.WithContext(ctx)
+	if holdID != nil {
+		query = query.Where("hold_id = ?", *holdID)
+	}
+	if after != nil {
+		query = query.Where("(created_at, id) < (?, ?)", after.Time, after.ID)
+	}
+	var entries []models.LegalAuditEntry
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list legal audit entries: %w", err)
+	}
+	return entries, nil
+}
+
+// CreateExport records a requested export.
+func (r *LegalHoldRepository) CreateExport(ctx context.Context, export *models.LegalExport) error {
+	export.Status = models.LegalExportPending
+	if err := r.db.WithContext(ctx).Create(export).Error; err != nil {
+		return fmt.Errorf("failed to create legal export: %w", err)
+	}
+	return nil
+}
+
+// GetExport loads an export, or returns ErrNotFound.
+func (r *LegalHoldRepository) GetExport(ctx context.Context, id uuid.UUID) (*models.LegalExport, error) {
+	var export models.LegalExport
+	err := r.db.WithContext(ctx).First(&export, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load legal export: %w", err)
+	}
+	return &export, nil
+}
+
+// FinishExport records that an export was built and stored under key, or
+// failed when key is empty.
+func (r *LegalHoldRepository) FinishExport(ctx context.Context, export *models.LegalExport, key string, size int64) error {
+	now := time.Now()
+	export.CompletedAt = &now
+	export.Status = models.LegalExportFailed
+	if key != "" {
+		export.Status, export.StorageKey, export.SizeBytes = models.LegalExportReady, &key, size
+	}
+	if err := r.db.WithContext(ctx).Save(export).Error; err != nil {
+		return fmt.Errorf("failed to save legal export: %w", err)
+	}
+	return nil
+}
+
+// LegalHoldCursor is the cursor of the page after a hold.
+func LegalHoldCursor(hold *models.LegalHold) pagination.Cursor {
+	return pagination.Cursor{Time: hold.CreatedAt, ID: hold.ID}
+}
+
+// LegalAuditCursor is the cursor of the page after an audit entry.
+func LegalAuditCursor(entry *models.LegalAuditEntry) pagination.Cursor {
+	return pagination.Cursor{Time: entry.CreatedAt, ID: entry.ID}
+}
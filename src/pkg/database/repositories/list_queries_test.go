@@ -0,0 +1,135 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+// The list endpoints load a page with a fixed number of queries however
+// many rows it holds.
+
+func TestListForUserQueryBudget(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	messages := repositories.NewMessageRepository(db.DB)
+	conversations := repositories.NewConversationRepository(db.DB)
+	for i := 0; i < 10; i++ {
+		member := createUser(t, db, fmt.Sprintf("member%d", i))
+		room := createRoom(t, db, owner.ID, member.ID)
+		for _, sender := range []uuid.UUID{owner.ID, member.ID, member.ID} {
+			if _, err := messages.Create(ctx, &models.Message{ConversationID: room.ID, SenderID: sender, Text: "hello"}, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if i%2 == 0 {
+			if err := conversations.Pin(ctx, owner.ID, room, 10); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The page, its members, unread counts, last messages and their
+	// attachments.
+	budget := repositories.NewConversationRepository(dbtest.QueryBudget(t, db, 10))
+	unpinned, err := budget.ListForUser(ctx, models.DefaultWorkspaceID, owner.ID, repositories.ConversationFilter{Unpinned: true}, nil, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, err := budget.PinnedForUser(ctx, models.DefaultWorkspaceID, owner.ID, repositories.ConversationFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpinned) != 5 || len(pinned) != 5 {
+		t.Fatalf("listed %d unpinned and %d pinned conversations, want 5 and 5", len(unpinned), len(pinned))
+	}
+	for _, conversation := range append(unpinned, pinned...) {
+		if len(conversation.Members) != 2 || conversation.MemberCount != 2 {
+			t.Errorf("conversation %s has %d members loaded and %d counted, want 2", conversation.ID, len(conversation.Members), conversation.MemberCount)
+		}
+		if conversation.UnreadCount != 2 {
+			t.Errorf("conversation %s has %d unread, want 2", conversation.ID, conversation.UnreadCount)
+		}
+		if conversation.LastMessage == nil || conversation.LastMessage.Seq != 3 {
+			t.Errorf("conversation %s has last message %v, want #3", conversation.ID, conversation.LastMessage)
+		}
+	}
+}
+
+func TestMembersQueryBudget(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	memberIDs := make([]uuid.UUID, 10)
+	for i := range memberIDs {
+		memberIDs[i] = createUser(t, db, fmt.Sprintf("member%d", i)).ID
+	}
+	room := createRoom(t, db, owner.ID, memberIDs...)
+
+	// The members and their users.
+	members, err := repositories.NewChannelRepository(dbtest.QueryBudget(t, db, 2)).Members(ctx, room.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 11 {
+		t.Fatalf("listed %d members, want 11", len(members))
+	}
+	for _, member := range members {
+		if member.User.ID != member.UserID {
+			t.Errorf("member %s has user %s loaded", member.UserID, member.User.ID)
+		}
+	}
+}
+
+func TestInboxQueryBudget(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	member := createUser(t, db, "member")
+	messages := repositories.NewMessageRepository(db.DB)
+	conversations := repositories.NewConversationRepository(db.DB)
+	rooms := make([]*models.Conversation, 4)
+	start := time.Now()
+	for i := range rooms {
+		rooms[i] = createRoom(t, db, owner.ID, member.ID)
+		message := &models.Message{ConversationID: rooms[i].ID, SenderID: member.ID, Text: fmt.Sprintf("hello %d", i), CreatedAt: start.Add(time.Duration(i) * time.Minute)}
+		if _, err := messages.Create(ctx, message, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conversations.Pin(ctx, owner.ID, rooms[0], 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first page holds the pin and the two most recently active others.
+	summaries, hasMore, err := repositories.NewConversationSummaryRepository(dbtest.QueryBudget(t, db, 1)).
+		Inbox(ctx, models.DefaultWorkspaceID, owner.ID, nil, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*models.Conversation{rooms[0], rooms[3], rooms[2]}
+	if len(summaries) != len(want) || !hasMore {
+		t.Fatalf("listed %d summaries with more %v, want %d and more", len(summaries), hasMore, len(want))
+	}
+	for i, summary := range summaries {
+		if summary.ConversationID != want[i].ID {
+			t.Errorf("summary %d is of %s, want %s", i, summary.ConversationID, want[i].ID)
+		}
+		if summary.UnreadCount != 1 || summary.LastMessagePreview == "" {
+			t.Errorf("summary of %s has %d unread and preview %q, want 1 and the message", summary.ConversationID, summary.UnreadCount, summary.LastMessagePreview)
+		}
+	}
+	if !summaries[0].Pinned {
+		t.Errorf("summary of the pinned conversation is not pinned")
+	}
+}
@@ -0,0 +1,143 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MessageArchiveRepository struct {
+	db *gorm.DB
+}
+
+func NewMessageArchiveRepository(db *gorm.DB) *MessageArchiveRepository {
+	return &MessageArchiveRepository{db: db}
+}
+
+// archivable are the messages with content still in the database.
+func archivable(db *gorm.DB, before time.Time) *gorm.DB {
+	return db.Model(&models.Message{}).
+		Where("created_at < ? AND archive_segment_id IS NULL", before)
+}
+
+// Conversations returns up to limit conversations with messages sent
+// before before still to archive.
+func (r *MessageArchiveRepository) Conversations(ctx context.Context, before time.Time, limit int) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := archivable(r.db.WithContext(ctx), before).
+		Distinct("conversation_id").
+		Limit(limit).
+		Pluck("conversation_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations to archive: %w", err)
+	}
+	return ids, nil
+}
+
+// Archive moves the content of up to limit of the conversation's oldest
+// messages sent before before into a new segment. write stores the
+// messages' content for the segment ID and returns its key and size; it runs
+// with the messages locked, so they cannot be edited or deleted between
+// being written and being emptied. It returns the messages archived.
+func (r *MessageArchiveRepository) Archive(ctx context.Context, conversationID uuid.UUID, before time.Time, limit int, write func(id uuid.UUID, messages []models.Message) (string, int64, error)) (int, error) {
+	var archived int
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var messages []models.Message
+		err := archivable(tx, before).
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("conversation_id = ?", conversationID).
+			Order("seq").
+			Limit(limit).
+			Find(&messages).Error
+		if err != nil || len(messages) == 0 {
+			return err
+		}
+
+		segment := models.MessageArchiveSegment{
+			ID:             uuid.New(),
+			ConversationID: conversationID,
+			FirstAt:        messages[0].CreatedAt,
+			LastAt:         messages[len(messages)-1].CreatedAt,
+			MessageCount:   len(messages),
+		}
+		ids := make([]uuid.UUID, len(messages))
+		for i, message := range messages {
+			ids[i] = message.ID
+		}
+		if segment.StorageKey, segment.SizeBytes, err = write(segment.ID, messages); err != nil {
+			return err
+		}
+		if err := tx.Create(&segment).Error; err != nil {
+			return err
+		}
+		// Archiving leaves updated_at alone: the message has not changed
+		// for anyone reading it.
+		err = tx.Model(&models.Message{}).
+			Where("id IN ?", ids).
+			UpdateColumns(map[string]any{"text": "", "entities": nil, "payload": nil, "archive_segment_id": segment.ID}).Error
+		if err != nil {
+			return err
+		}
+		archived = len(messages)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive messages: %w", err)
+	}
+	return archived, nil
+}
+
+// Stale returns up to limit segments fewer messages point to than they
+// hold, because messages were deleted, erased, edited or dropped since.
+func (r *MessageArchiveRepository) Stale(ctx context.Context, limit int) ([]models.MessageArchiveSegment, error) {
+	live := r.db.Unscoped().Model(&models.Message{}).
+		Select("COUNT(*)").
+		Where("messages.archive_segment_id = message_archive_segments.id")
+	var segments []models.MessageArchiveSegment
+	err := r.db.WithContext(ctx).
+		Where("message_count > (?)", live).
+		Order("created_at").
+		Limit(limit).
+		Find(&segments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list stale archive segments: %w", err)
+	}
+	return segments, nil
+}
+
+// MessageIDs returns the messages still pointing to a segment.
+func (r *MessageArchiveRepository) MessageIDs(ctx context.Context, segmentID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Unscoped().Model(&models.Message{}).
+		Where("archive_segment_id = ?", segmentID).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archived messages: %w", err)
+	}
+	return ids, nil
+}
+
+// Shrink records that a segment was rewritten to hold count messages in
+// size bytes.
+func (r *MessageArchiveRepository) Shrink(ctx context.Context, segmentID uuid.UUID, count int, size int64) error {
+	err := r.db.WithContext(ctx).Model(&models.MessageArchiveSegment{}).
+		Where("id = ?", segmentID).
+		Updates(map[string]any{"message_count": count, "size_bytes": size}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update archive segment: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a segment no message points to any more.
+func (r *MessageArchiveRepository) Delete(ctx context.Context, segmentID uuid.UUID) error {
+	if err := r.db.WithContext(ctx).Delete(&models.MessageArchiveSegment{}, "id = ?", segmentID).Error; err != nil {
+		return fmt.Errorf("failed to delete archive segment: %w", err)
+	}
+	return nil
+}
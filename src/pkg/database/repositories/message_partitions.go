@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"gorm.io/gorm"
+)
+
+// messagePartitionName is how a month of messages is named, in UTC:
+// messages_2026_01 holds January 2026.
+var messagePartitionName = regexp.MustCompile(`^messages_(\d{4}_\d{2})$`)
+
+// MessagePartition is the table holding one month of messages, on
+// Postgres, where messages are partitioned by when they were created.
+type MessagePartition struct {
+	Name string
+	From time.Time
+	To   time.Time
+}
+
+func newMessagePartition(month time.Time) MessagePartition {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return MessagePartition{Name: "messages_" + from.Format("2006_01"), From: from, To: from.AddDate(0, 1, 0)}
+}
+
+type MessagePartitionRepository struct {
+	db *gorm.DB
+}
+
+func NewMessagePartitionRepository(db *gorm.DB) *MessagePartitionRepository {
+	return &MessagePartitionRepository{db: db}
+}
+
+// List returns the monthly partitions, oldest first. Messages outside
+// them are in the default partition, which is left out.
+func (r *MessagePartitionRepository) List(ctx context.Context) ([]MessagePartition, error) {
+	var names []string
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'messages'::regclass`).Scan(&names).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message partitions: %w", err)
+	}
+	var partitions []MessagePartition
+	for _, name := range names {
+		match := messagePartitionName.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+		month, err := time.Parse("2006_01", match[1])
+		if err != nil {
+			continue
+		}
+		partitions = append(partitions, newMessagePartition(month))
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].From.Before(partitions[j].From) })
+	return partitions, nil
+}
+
+// Ensure creates the partitions for the months from from through to that
+// do not exist yet, and returns how many it created.
+func (r *MessagePartitionRepository) Ensure(ctx context.Context, from, to time.Time) (int, error) {
+	existing, err := r.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	have := make(map[string]bool, len(existing))
+	for _, partition := range existing {
+		have[partition.Name] = true
+	}
+	created := 0
+	for month := newMessagePartition(from); !month.From.After(to); month = newMessagePartition(month.To) {
+		if have[month.Name] {
+			continue
+		}
+		// The bounds are formatted here, as DDL takes no parameters.
+		err := r.db.WithContext(ctx).Exec(fmt.Sprintf(`CREATE TABLE %q PARTITION OF "messages" FOR VALUES FROM ('%s') TO ('%s')`,
+			month.Name, month.From.Format(time.RFC3339), month.To.Format(time.RFC3339))).Error
+		if err != nil {
+			return created, fmt.Errorf("failed to create message partition %s: %w", month.Name, err)
+		}
+		created++
+	}
+	return created, nil
+}
+
+// Held reports whether any message in the partition is under an active
+// legal hold, through its sender or its conversation.
+func (r *MessagePartitionRepository) Held(ctx context.Context, partition MessagePartition) (bool, error) {
+	var held bool
+	err := r.db.WithContext(ctx).Raw(`SELECT EXISTS (?)`,
+		r.db.Model(&models.Message{}).Unscoped().Select("1").
+			Where("created_at >= ? AND created_at < ?", partition.From, partition.To).
+			Where("conversation_id IN (?) OR sender_id IN (?)", HeldConversations(r.db), HeldUsers(r.db)),
+	).Scan(&held).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to look up held messages: %w", err)
+	}
+	return held, nil
+}
+
+// Drop deletes a partition and every message in it, releasing what
+// referred to them as deleting each message would have.
+func (r *MessagePartitionRepository) Drop(ctx context.Context, partition MessagePartition) error {
+	if !messagePartitionName.MatchString(partition.Name) {
+		return fmt.Errorf("%q is not a message partition", partition.Name)
+	}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`SELECT release_message_references(ARRAY(SELECT id FROM messages WHERE created_at >= ? AND created_at < ?))`,
+			partition.From, partition.To).Error
+		if err != nil {
+			return fmt.Errorf("failed to release references to %s: %w", partition.Name, err)
+		}
+		if err := tx.Exec(fmt.Sprintf(`ALTER TABLE "messages" DETACH PARTITION %q`, partition.Name)).Error; err != nil {
+			return fmt.Errorf("failed to detach %s: %w", partition.Name, err)
+		}
+		if err := tx.Exec(fmt.Sprintf(`DROP TABLE %q`, partition.Name)).Error; err != nil {
+			return fmt.Errorf("failed to drop %s: %w", partition.Name, err)
+		}
+		return nil
+	})
+}
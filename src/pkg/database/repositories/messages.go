@@ -0,0 +1,428 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auditchain"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/idgen"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type MessageRepository struct {
+	db *gorm.DB
+}
+
+func NewMessageRepository(db *gorm.DB) *MessageRepository {
+	return &MessageRepository{db: db}
+}
+
+// Create persists a message, attaches the sender's uploads named by
+// attachmentIDs, bumps the conversation's activity time and numbers the
+// message after the conversation's latest. In an audit room the message
+// is added to the conversation's hash chain. If the sender already sent a
+// message with the same ClientID, message is replaced with the stored
+// one, or its tombstone, and created is false. It returns
+// ErrInvalidAttachment when an upload is not the sender's, not ready,
+// already sent, encrypted when the message is not (or the reverse), or
+// neither a photo nor a voice note in a view-once message.
+func (r *MessageRepository) Create(ctx context.Context, message *models.Message, attachmentIDs []uuid.UUID) (bool, error) {
+	db := r.db.WithContext(ctx)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if message.ID == uuid.Nil {
+			message.ID = idgen.NewUUID()
+		}
+		if message.CreatedAt.IsZero() {
+			message.CreatedAt = time.Now()
+		}
+
+		// Bumping the activity time locks the conversation, which keeps
+		// sequence numbers and an audit room's chain in order while members
+		// send at once.
+		var conversation models.Conversation
+		err := tx.Model(&conversation).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "last_seq"}, {Name: "kind"}, {Name: "audited"}, {Name: "audit_length"}, {Name: "audit_head"}}}).
+			Where("id = ?", message.ConversationID).
+			Updates(map[string]any{"last_message_at": message.CreatedAt, "last_seq": gorm.Expr("last_seq + 1")}).Error
+		if err != nil {
+			return err
+		}
+		message.Seq = conversation.LastSeq
+		if conversation.Audited {
+			seq := conversation.AuditLength + 1
+			hash, err := auditchain.Hash(conversation.AuditHead, seq, message, attachmentIDs)
+			if err != nil {
+				return err
+			}
+			message.AuditSeq = &seq
+			message.AuditPrevHash = conversation.AuditHead
+			message.AuditHash = hash
+		}
+
+		if message.ClientID != nil {
+			claim := models.MessageClientID{SenderID: message.SenderID, ClientID: *message.ClientID, MessageID: message.ID}
+			if err := tx.Create(&claim).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit("Attachments").Create(message).Error; err != nil {
+			return err
+		}
+		if len(attachmentIDs) > 0 {
+			// End-to-end encrypted messages carry only client-encrypted
+			// files, and those files go with nothing else.
+			// View-once messages carry only photos and voice notes.
+			encrypted := message.Type == string(content.TypeEncrypted)
+			attachable := tx.Model(&models.Attachment{}).
+				Where("id IN ? AND uploader_id = ? AND status = ? AND encrypted = ? AND message_id IS NULL",
+					attachmentIDs, message.SenderID, models.AttachmentReady, encrypted)
+			if message.ViewOnce {
+				attachable = attachable.Where("kind IN ?", []string{string(content.AttachmentImage), string(content.AttachmentAudio)})
+			}
+			attached := attachable.Updates(map[string]any{"message_id": message.ID, "view_once": message.ViewOnce})
+			if attached.Error != nil {
+				return attached.Error
+			}
+			if attached.RowsAffected != int64(len(attachmentIDs)) {
+				return ErrInvalidAttachment
+			}
+			if err := tx.Where("message_id = ?", message.ID).Order("created_at").Find(&message.Attachments).Error; err != nil {
+				return err
+			}
+		}
+		if message.Type == string(content.TypeEvent) {
+			if err := createRoomEvent(tx, message); err != nil {
+				return err
+			}
+		}
+		if err := summarizeSent(tx, message); err != nil {
+			return err
+		}
+		if conversation.Kind == models.ConversationChannel && !message.Shadowed {
+			if err := countContribution(tx, message); err != nil {
+				return err
+			}
+		}
+		if !conversation.Audited {
+			return nil
+		}
+		return tx.Model(&models.Conversation{}).
+			Where("id = ?", message.ConversationID).
+			Updates(map[string]any{"audit_length": *message.AuditSeq, "audit_head": message.AuditHash}).Error
+	})
+	if err == nil {
+		return true, nil
+	}
+	// The message was not numbered and the chain was not extended.
+	message.Seq = 0
+	message.AuditSeq, message.AuditPrevHash, message.AuditHash = nil, nil, nil
+	if errors.Is(err, ErrInvalidAttachment) {
+		return false, err
+	}
+
+	if errors.Is(err, gorm.ErrDuplicatedKey) && message.ClientID != nil {
+		if existing, lookupErr := r.BySenderClientID(ctx, message.SenderID, *message.ClientID); lookupErr == nil {
+			*message = *existing
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("failed to create message: %w", err)
+}
+
+// BySenderClientID loads the message the sender sent with clientID, or its
+// tombstone if it was deleted since.
+func (r *MessageRepository) BySenderClientID(ctx context.Context, senderID uuid.UUID, clientID string) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments", liveAttachments).
+		Where("sender_id = ? AND client_id = ?", senderID, clientID).
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	return &message, nil
+}
+
+// Get loads a single message.
+func (r *MessageRepository) Get(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Preload("Attachments").First(&message, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	return &message, nil
+}
+
+// liveAttachments keeps the attachments of deleted messages out of
+// queries that include tombstones, which would otherwise load them.
+const liveAttachments = "deleted_at IS NULL"
+
+// Edit replaces the text of a message, keeping the replaced version in its
+// edit history. It returns ErrNotFound when the message does not exist or
+// was deleted.
+func (r *MessageRepository) Edit(ctx context.Context, id uuid.UUID, text string, entities models.JSON) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "id = ?", id).Error; err != nil {
+			return err
+		}
+
+		written := message.CreatedAt
+		if message.EditedAt != nil {
+			written = *message.EditedAt
+		}
+		edit := models.MessageEdit{
+			MessageID: message.ID,
+			Text:      message.Text,
+			Entities:  message.Entities,
+			WrittenAt: written,
+		}
+		if err := tx.Create(&edit).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		message.Text = text
+		message.Entities = entities
+		message.EditedAt = &now
+		message.ArchiveSegmentID = nil
+		if err := tx.Select("Text", "Entities", "EditedAt", "ArchiveSegmentID").Save(&message).Error; err != nil {
+			return err
+		}
+		if err := summarizeEdited(tx, &message); err != nil {
+			return err
+		}
+		return tx.Where("message_id = ?", message.ID).Order("created_at").Find(&message.Attachments).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit message: %w", err)
+	}
+	return &message, nil
+}
+
+// Delete turns a message into a tombstone: its content, attachments and
+// edit history are removed, and it keeps its place in history with
+// DeletedAt set. It returns ErrNotFound when the message does not exist or
+// was already deleted.
+func (r *MessageRepository) Delete(ctx context.Context, id uuid.UUID) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := NewLegalHoldRepository(tx).Preserve(ctx, &message); err != nil {
+			return err
+		}
+
+		message.Text = ""
+		message.Entities = nil
+		message.Payload = nil
+		message.ArchiveSegmentID = nil
+		message.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+		if err := tx.Select("Text", "Entities", "Payload", "ArchiveSegmentID", "DeletedAt").Save(&message).Error; err != nil {
+			return err
+		}
+		if err := summarizeDeleted(tx, &message); err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("message_id = ?", message.ID).Delete(&models.MessageEdit{}).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete message: %w", err)
+	}
+	return &message, nil
+}
+
+// Edits returns the versions a message's edits replaced, oldest first.
+func (r *MessageRepository) Edits(ctx context.Context, messageID uuid.UUID) ([]models.MessageEdit, error) {
+	var edits []models.MessageEdit
+	err := r.db.WithContext(ctx).
+		Where("message_id = ?", messageID).
+		Order("created_at").
+		Find(&edits).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list message edits: %w", err)
+	}
+	return edits, nil
+}
+
+// ListChain returns up to limit messages of an audit room's chain with
+// sequence numbers after after and up to through, in chain order.
+// Tombstones are included, and messages are loaded with every attachment
+// they were sent with.
+func (r *MessageRepository) ListChain(ctx context.Context, conversationID uuid.UUID, after, through int64, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments").
+		Where("conversation_id = ? AND audit_seq > ? AND audit_seq <= ?", conversationID, after, through).
+		Order("audit_seq").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit chain: %w", err)
+	}
+	return messages, nil
+}
+
+// ListSeqRange returns up to limit messages of a conversation numbered from
+// from through to, in sequence order. Tombstones are included, so a range
+// with no gaps comes back complete.
+func (r *MessageRepository) ListSeqRange(ctx context.Context, conversationID uuid.UUID, from, to int64, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments", liveAttachments).
+		Where("conversation_id = ? AND seq BETWEEN ? AND ?", conversationID, from, to).
+		Order("seq").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ListBefore returns up to limit messages of a conversation older than
+// before, newest first, including tombstones. A nil before starts from the
+// newest message.
+func (r *MessageRepository) ListBefore(ctx context.Context, conversationID uuid.UUID, before *pagination.Cursor, limit int) ([]models.Message, error) {
+	query := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments", liveAttachments).
+		Where("conversation_id = ?", conversationID)
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.Time, before.ID)
+	}
+
+	var messages []models.Message
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ListAfter returns up to limit messages of a conversation newer than
+// after, oldest first, including tombstones.
+func (r *MessageRepository) ListAfter(ctx context.Context, conversationID uuid.UUID, after pagination.Cursor, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments", liveAttachments).
+		Where("conversation_id = ? AND (created_at, id) > (?, ?)", conversationID, after.Time, after.ID).
+		Order("created_at ASC, id ASC").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ListSent returns up to limit of the messages a user sent after the
+// cursor, in any conversation, oldest first. Deleted messages are left
+// out.
+func (r *MessageRepository) ListSent(ctx context.Context, senderID uuid.UUID, after pagination.Cursor, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).
+		Where("sender_id = ? AND (created_at, id) > (?, ?)", senderID, after.Time, after.ID).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sent messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ListAll returns up to limit messages sent after the cursor in any
+// conversation, oldest first, for walking every message. Deleted messages
+// are left out.
+func (r *MessageRepository) ListAll(ctx context.Context, after pagination.Cursor, limit int) ([]models.Message, error) {
+	var messages []models.Message
+	err := r.db.WithContext(ctx).
+		Where("(created_at, id) > (?, ?)", after.Time, after.ID).
+		Order("created_at, id").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages: %w", err)
+	}
+	return messages, nil
+}
+
+// messageChangedAt is when a message was last created, edited or deleted.
+const messageChangedAt = "GREATEST(messages.updated_at, COALESCE(messages.deleted_at, messages.updated_at))"
+
+// ListChanged returns up to limit messages created, edited or deleted after
+// the cursor in any conversation of the workspace the user belongs to, in
+// change order.
+// Deleted messages are included with DeletedAt set.
+func (r *MessageRepository) ListChanged(ctx context.Context, workspaceID, userID uuid.UUID, after pagination.Cursor, limit int) ([]models.Message, error) {
+	memberships := r.db.Model(&models.ConversationMember{}).
+		Select("conversation_members.conversation_id").
+		Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id").
+		Where("conversation_members.user_id = ? AND conversations.workspace_id = ?", userID, workspaceID)
+
+	var messages []models.Message
+	err := r.db.WithContext(ctx).Unscoped().
+		Preload("Attachments", liveAttachments).
+		Where("messages.conversation_id IN (?)", memberships).
+		Where("("+messageChangedAt+", messages.id) > (?, ?)", after.Time, after.ID).
+		Order(messageChangedAt + ", messages.id").
+		Limit(limit).
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed messages: %w", err)
+	}
+	return messages, nil
+}
+
+// ChangeCursor is the cursor positioned at a message in ListChanged order.
+func ChangeCursor(message *models.Message) pagination.Cursor {
+	changed := message.UpdatedAt
+	if message.DeletedAt.Valid && message.DeletedAt.Time.After(changed) {
+		changed = message.DeletedAt.Time
+	}
+	return pagination.Cursor{Time: changed, ID: message.ID}
+}
+
+// CursorAt returns the history position of a message in the conversation.
+// Deleted messages still have a position, so paging can continue past one
+// removed after the client loaded it.
+func (r *MessageRepository) CursorAt(ctx context.Context, conversationID, messageID uuid.UUID) (*pagination.Cursor, error) {
+	return messageCursor(r.db.WithContext(ctx), conversationID, messageID)
+}
+
+func messageCursor(db *gorm.DB, conversationID, messageID uuid.UUID) (*pagination.Cursor, error) {
+	var message models.Message
+	err := db.Unscoped().
+		Select("id", "created_at").
+		Where("id = ? AND conversation_id = ?", messageID, conversationID).
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	return &pagination.Cursor{Time: message.CreatedAt, ID: message.ID}, nil
+}
@@ -0,0 +1,120 @@
+package repositories_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+func TestMessageCreateDedupesResends(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	sender := createUser(t, db, "sender")
+	room := createRoom(t, db, sender.ID)
+	messages := repositories.NewMessageRepository(db.DB)
+
+	clientID := "client-1"
+	first := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: "hello", ClientID: &clientID}
+	created, err := messages.Create(ctx, first, nil)
+	if err != nil || !created {
+		t.Fatalf("Create returned %v, %v, want a new message", created, err)
+	}
+	resend := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: "hello", ClientID: &clientID}
+	created, err = messages.Create(ctx, resend, nil)
+	if err != nil || created {
+		t.Fatalf("Create of a resend returned %v, %v, want the stored message", created, err)
+	}
+	if resend.ID != first.ID || resend.Seq != first.Seq {
+		t.Errorf("resend came back as %s #%d, want %s #%d", resend.ID, resend.Seq, first.ID, first.Seq)
+	}
+
+	next := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: "again"}
+	if _, err := messages.Create(ctx, next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if next.Seq != first.Seq+1 {
+		t.Errorf("message after a resend is #%d, want #%d", next.Seq, first.Seq+1)
+	}
+}
+
+// Each test gets a database of its own: rooms created in parallel tests
+// under the same names all number their messages from 1.
+func TestMessageCreateIsolatedPerTest(t *testing.T) {
+	t.Parallel()
+	for i := range 4 {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			t.Parallel()
+			db := dbtest.New(t)
+			sender := createUser(t, db, "sender")
+			room := createRoom(t, db, sender.ID)
+			message := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: "first"}
+			if _, err := repositories.NewMessageRepository(db.DB).Create(context.Background(), message, nil); err != nil {
+				t.Fatal(err)
+			}
+			if message.Seq != 1 {
+				t.Errorf("first message is #%d, want #1", message.Seq)
+			}
+			var count int64
+			if err := db.DB.Model(&models.User{}).Count(&count).Error; err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("database holds %d users, want only this test's", count)
+			}
+		})
+	}
+}
+
+func TestMessageDeletePreservesHeldMessages(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	sender := createUser(t, db, "sender")
+	room := createRoom(t, db, sender.ID)
+	messages := repositories.NewMessageRepository(db.DB)
+
+	held := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: "evidence"}
+	if _, err := messages.Create(ctx, held, nil); err != nil {
+		t.Fatal(err)
+	}
+	hold := &models.LegalHold{Reference: "case-1", Reason: "court order", ConversationID: &room.ID, PlacedByID: sender.ID}
+	if err := repositories.NewLegalHoldRepository(db.DB).Create(ctx, hold); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := messages.Delete(ctx, held.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	var preserved []models.PreservedMessage
+	if err := db.DB.Find(&preserved).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(preserved) != 1 || preserved[0].MessageID != held.ID || preserved[0].Text != "evidence" {
+		t.Fatalf("preserved %+v, want a copy of the deleted message", preserved)
+	}
+}
+
+func createUser(t *testing.T, db *database.DatabaseConnection, username string) *models.User {
+	t.Helper()
+	user := &models.User{Email: username + "@example.com", Username: username, DisplayName: username, TimeZone: "UTC"}
+	if err := db.DB.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func createRoom(t *testing.T, db *database.DatabaseConnection, creatorID uuid.UUID, memberIDs ...uuid.UUID) *models.Conversation {
+	t.Helper()
+	room, err := repositories.NewConversationRepository(db.DB).CreateGroup(context.Background(), models.DefaultWorkspaceID, creatorID, "room", memberIDs, false)
+	if err != nil {
+		t.Fatalf("failed to create room: %v", err)
+	}
+	return room
+}
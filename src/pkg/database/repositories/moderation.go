@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ModerationRepository struct {
+	db *gorm.DB
+}
+
+func NewModerationRepository(db *gorm.DB) *ModerationRepository {
+	return &ModerationRepository{db: db}
+}
+
+// Apply makes a moderation action's change to the target account and
+// records it, together. Suspending, banning and signing out also revoke
+// every session of the account, and lifting a shadow restriction shows
+// others the messages sent under it. It returns ErrNotFound when the
+// account does not exist.
+func (r *ModerationRepository) Apply(ctx context.Context, action *models.ModerationAction) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return applyModeration(tx, action)
+	})
+}
+
+func applyModeration(tx *gorm.DB, action *models.ModerationAction) error {
+	now := time.Now()
+	var updates map[string]any
+	signOut := false
+	switch action.Action {
+	case models.ModerationSuspend:
+		updates = map[string]any{"is_suspended": true, "suspended_at": now, "suspended_until": action.Until}
+		signOut = true
+	case models.ModerationUnsuspend:
+		updates = map[string]any{"is_suspended": false, "suspended_until": nil}
+	case models.ModerationBan:
+		updates = map[string]any{"is_banned": true, "banned_at": now}
+		signOut = true
+	case models.ModerationUnban:
+		updates = map[string]any{"is_banned": false}
+	case models.ModerationSignOut:
+		updates = map[string]any{"last_logout_at": now}
+		signOut = true
+	case models.ModerationShadowRestrict:
+		by := models.ShadowRestrictedByModerator
+		if action.ActorID == nil {
+			by = models.ShadowRestrictedByScorer
+		}
+		updates = map[string]any{"is_shadow_restricted": true, "shadow_restricted_by": by}
+	case models.ModerationShadowLift:
+		updates = map[string]any{"is_shadow_restricted": false, "shadow_restricted_by": nil}
+	case models.ModerationMute:
+		if action.Until == nil {
+			return fmt.Errorf("a mute needs an end")
+		}
+		updates = map[string]any{"muted_until": action.Until}
+	case models.ModerationUnmute:
+		updates = map[string]any{"muted_until": nil}
+	case models.ModerationWarn:
+		// A warning changes nothing on the account; the caller delivers it.
+	default:
+		return fmt.Errorf("unknown moderation action %q", action.Action)
+	}
+
+	target := tx.Model(&models.User{}).Where("id = ?", action.UserID)
+	if updates != nil {
+		result := target.Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update moderated account: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+	} else {
+		var found int64
+		if err := target.Count(&found).Error; err != nil {
+			return fmt.Errorf("failed to load moderated account: %w", err)
+		}
+		if found == 0 {
+			return ErrNotFound
+		}
+	}
+
+	// Lifting a restriction releases what the account sent under it.
+	if action.Action == models.ModerationShadowLift {
+		err := tx.Model(&models.Message{}).
+			Where("sender_id = ? AND shadowed", action.UserID).
+			Update("shadowed", false).Error
+		if err != nil {
+			return fmt.Errorf("failed to release shadowed messages: %w", err)
+		}
+	}
+	if signOut {
+		err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", action.UserID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return fmt.Errorf("failed to revoke sessions: %w", err)
+		}
+	}
+	if err := tx.Create(action).Error; err != nil {
+		return fmt.Errorf("failed to record moderation action: %w", err)
+	}
+	return nil
+}
+
+// List returns up to limit moderation actions older than before, newest
+// first, against userID or against anyone when userID is uuid.Nil.
+func (r *ModerationRepository) List(ctx context.Context, userID uuid.UUID, before *pagination.Cursor, limit int) ([]models.ModerationAction, error) {
+	query := r.db.WithContext(ctx)
+	if userID != uuid.Nil {
+		query = query.Where("user_id = ?", userID)
+	}
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.Time, before.ID)
+	}
+
+	var actions []models.ModerationAction
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&actions).Error; err != nil {
+		return nil, fmt.Errorf("failed to list moderation actions: %w", err)
+	}
+	return actions, nil
+}
@@ -0,0 +1,208 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// inverseModeration is the action undoing each action a batch can take.
+// Warnings and sign-outs cannot be taken back and are left standing.
+var inverseModeration = map[string]string{
+	models.ModerationSuspend:        models.ModerationUnsuspend,
+	models.ModerationBan:            models.ModerationUnban,
+	models.ModerationShadowRestrict: models.ModerationShadowLift,
+	models.ModerationMute:           models.ModerationUnmute,
+}
+
+// ApplyBatch records batch and takes its action against each of userIDs,
+// marking the open reports among reportIDs actioned by it, all together.
+// It returns the moderation log entries, and ErrNotFound if any account
+// does not exist.
+func (r *ModerationRepository) ApplyBatch(ctx context.Context, batch *models.ModerationBatch, userIDs, reportIDs []uuid.UUID) ([]models.ModerationAction, error) {
+	actions := make([]models.ModerationAction, 0, len(userIDs))
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return fmt.Errorf("failed to record moderation batch: %w", err)
+		}
+		for _, userID := range userIDs {
+			action := models.ModerationAction{
+				UserID:  userID,
+				ActorID: batch.ActorID,
+				Action:  batch.Action,
+				Reason:  batch.Reason,
+				Until:   batch.Until,
+				BatchID: &batch.ID,
+			}
+			if err := applyModeration(tx, &action); err != nil {
+				return err
+			}
+			actions = append(actions, action)
+		}
+		if len(reportIDs) == 0 {
+			return nil
+		}
+		err := tx.Model(&models.Report{}).
+			Where("id IN ? AND status = ?", reportIDs, models.ReportOpen).
+			Updates(map[string]any{
+				"status":         models.ReportActioned,
+				"batch_id":       batch.ID,
+				"resolved_by_id": batch.ActorID,
+				"resolved_at":    batch.CreatedAt,
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to resolve reports: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return actions, nil
+}
+
+// GetBatch returns a moderation batch and the log entries of the actions
+// it took, or ErrNotFound.
+func (r *ModerationRepository) GetBatch(ctx context.Context, id uuid.UUID) (*models.ModerationBatch, []models.ModerationAction, error) {
+	var batch models.ModerationBatch
+	err := r.db.WithContext(ctx).First(&batch, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load moderation batch: %w", err)
+	}
+	var actions []models.ModerationAction
+	if err := r.db.WithContext(ctx).Where("batch_id = ?", id).Order("created_at, id").Find(&actions).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to load moderation batch actions: %w", err)
+	}
+	return &batch, actions, nil
+}
+
+// UndoBatch takes back what a batch did, on behalf of actorID: its
+// suspensions, bans, shadow restrictions and mutes are lifted, each
+// recorded in the moderation log, and the reports it settled are opened
+// again. It returns the entries undoing the batch, ErrNotFound if there is
+// no such batch and ErrExpired if it was already undone or its undo window
+// has closed.
+func (r *ModerationRepository) UndoBatch(ctx context.Context, id, actorID uuid.UUID) (*models.ModerationBatch, []models.ModerationAction, error) {
+	var batch models.ModerationBatch
+	var undone []models.ModerationAction
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&batch, "id = ?", id).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load moderation batch: %w", err)
+		}
+		now := time.Now()
+		if batch.UndoneAt != nil || !now.Before(batch.UndoableUntil) {
+			return ErrExpired
+		}
+
+		var taken []models.ModerationAction
+		if err := tx.Where("batch_id = ?", id).Order("created_at, id").Find(&taken).Error; err != nil {
+			return fmt.Errorf("failed to load moderation batch actions: %w", err)
+		}
+		for _, action := range taken {
+			inverse, ok := inverseModeration[action.Action]
+			if !ok {
+				continue
+			}
+			entry := models.ModerationAction{
+				UserID:  action.UserID,
+				ActorID: &actorID,
+				Action:  inverse,
+				Reason:  "undid moderation batch " + batch.ID.String(),
+			}
+			if err := applyModeration(tx, &entry); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			undone = append(undone, entry)
+		}
+
+		err = tx.Model(&models.Report{}).Where("batch_id = ?", id).Updates(map[string]any{
+			"status":         models.ReportOpen,
+			"batch_id":       nil,
+			"resolved_by_id": nil,
+			"resolved_at":    nil,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to reopen reports: %w", err)
+		}
+		batch.UndoneAt, batch.UndoneByID = &now, &actorID
+		return tx.Model(&batch).Updates(map[string]any{"undone_at": now, "undone_by_id": actorID}).Error
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &batch, undone, nil
+}
+
+type ModerationTemplateRepository struct {
+	db *gorm.DB
+}
+
+func NewModerationTemplateRepository(db *gorm.DB) *ModerationTemplateRepository {
+	return &ModerationTemplateRepository{db: db}
+}
+
+// List returns every moderation template by name.
+func (r *ModerationTemplateRepository) List(ctx context.Context) ([]models.ModerationTemplate, error) {
+	var templates []models.ModerationTemplate
+	if err := r.db.WithContext(ctx).Order("name").Find(&templates).Error; err != nil {
+		return nil, fmt.Errorf("failed to list moderation templates: %w", err)
+	}
+	return templates, nil
+}
+
+// Get returns a moderation template, or ErrNotFound.
+func (r *ModerationTemplateRepository) Get(ctx context.Context, id uuid.UUID) (*models.ModerationTemplate, error) {
+	var template models.ModerationTemplate
+	err := r.db.WithContext(ctx).First(&template, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load moderation template: %w", err)
+	}
+	return &template, nil
+}
+
+// Create stores a template, returning gorm.ErrDuplicatedKey when its name
+// is taken.
+func (r *ModerationTemplateRepository) Create(ctx context.Context, template *models.ModerationTemplate) error {
+	if err := r.db.WithContext(ctx).Create(template).Error; err != nil {
+		return fmt.Errorf("failed to create moderation template: %w", err)
+	}
+	return nil
+}
+
+// Save stores changes to a template, returning gorm.ErrDuplicatedKey when
+// its new name is taken.
+func (r *ModerationTemplateRepository) Save(ctx context.Context, template *models.ModerationTemplate) error {
+	if err := r.db.WithContext(ctx).Save(template).Error; err != nil {
+		return fmt.Errorf("failed to update moderation template: %w", err)
+	}
+	return nil
+}
+
+// Delete removes a template, returning ErrNotFound if there is none. The
+// batches that applied it keep what they did.
+func (r *ModerationTemplateRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.ModerationTemplate{}, "id = ?", id)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete moderation template: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,377 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type NotificationRepository struct {
+	db *gorm.DB
+}
+
+func NewNotificationRepository(db *gorm.DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+// How RegisterDevice stored a push token.
+const (
+	DeviceNew        = "new"
+	DeviceRefreshed  = "refreshed"
+	DeviceReassigned = "reassigned"
+)
+
+// RegisterDevice stores the push token for a session, replacing any token
+// the session registered before, and returns how it stored it. A token
+// moves to the new session if another account signed in on the same
+// device. Registering the same token again only marks it as seen.
+func (r *NotificationRepository) RegisterDevice(ctx context.Context, device *models.DeviceToken) (string, error) {
+	outcome := DeviceNew
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.DeviceToken
+		if err := tx.Where("token = ?", device.Token).Limit(1).Find(&existing).Error; err != nil {
+			return err
+		}
+		if existing.ID != uuid.Nil && existing.SessionID == device.SessionID {
+			outcome = DeviceRefreshed
+			*device = existing
+			return tx.Model(device).Update("updated_at", time.Now()).Error
+		}
+		if existing.ID != uuid.Nil && existing.UserID != device.UserID {
+			outcome = DeviceReassigned
+		}
+
+		err := tx.Where("token = ? OR session_id = ?", device.Token, device.SessionID).
+			Delete(&models.DeviceToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(device).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to register device: %w", err)
+	}
+	return outcome, nil
+}
+
+// UnregisterDevice removes the push token of a session. It returns
+// ErrNotFound if the session had none.
+func (r *NotificationRepository) UnregisterDevice(ctx context.Context, sessionID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("session_id = ?", sessionID).Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to unregister device: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeleteToken forgets a token the push service rejected.
+func (r *NotificationRepository) DeleteToken(ctx context.Context, token string) error {
+	if err := r.db.WithContext(ctx).Where("token = ?", token).Delete(&models.DeviceToken{}).Error; err != nil {
+		return fmt.Errorf("failed to delete device token: %w", err)
+	}
+	return nil
+}
+
+// RecordPush records the outcome of a push to a device: a success clears
+// its failures, and a failure is counted with its error.
+func (r *NotificationRepository) RecordPush(ctx context.Context, deviceID uuid.UUID, pushErr error) error {
+	now := time.Now()
+	updates := map[string]any{"last_pushed_at": now, "failures": 0}
+	if pushErr != nil {
+		message := pushErr.Error()
+		if len(message) > 255 {
+			message = message[:255]
+		}
+		updates = map[string]any{
+			"last_failure_at": now,
+			"last_error":      message,
+			"failures":        gorm.Expr("failures + 1"),
+		}
+	}
+	// Skipping hooks keeps updated_at as when the device last registered.
+	err := r.db.WithContext(ctx).Model(&models.DeviceToken{}).
+		Where("id = ?", deviceID).
+		UpdateColumns(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to record push: %w", err)
+	}
+	return nil
+}
+
+// PruneTokens deletes the tokens no push should go to: those of sessions
+// that were revoked or expired, and those the device has not registered
+// again since staleBefore, which apps that are still installed do on every
+// launch. It returns how many of each it deleted.
+func (r *NotificationRepository) PruneTokens(ctx context.Context, staleBefore time.Time) (signedOut, stale int64, err error) {
+	db := r.db.WithContext(ctx)
+	result := db.Where("session_id IN (?)", r.db.Model(&models.Session{}).
+		Select("id").
+		Where("revoked_at IS NOT NULL OR expires_at <= ?", time.Now())).
+		Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		return 0, 0, fmt.Errorf("failed to prune signed out devices: %w", result.Error)
+	}
+	signedOut = result.RowsAffected
+
+	result = db.Where("updated_at < ?", staleBefore).Delete(&models.DeviceToken{})
+	if result.Error != nil {
+		return signedOut, 0, fmt.Errorf("failed to prune stale devices: %w", result.Error)
+	}
+	return signedOut, result.RowsAffected, nil
+}
+
+// AllDevices returns every push token of a user, including those of
+// sessions that have ended, most recently registered first.
+func (r *NotificationRepository) AllDevices(ctx context.Context, userID uuid.UUID) ([]models.DeviceToken, error) {
+	var devices []models.DeviceToken
+	err := r.db.WithContext(ctx).Preload("Session").
+		Where("user_id = ?", userID).
+		Order("updated_at DESC").
+		Find(&devices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+
+// TokenHealth is a count of one platform's push tokens by condition.
+type TokenHealth struct {
+	Platform string `json:"platform"`
+	Tokens   int64  `json:"tokens"`
+
+	// Failing tokens' latest push failed.
+	Failing int64 `json:"failing"`
+
+	// NeverPushed tokens have not had a push delivered since registering.
+	NeverPushed int64 `json:"never_pushed"`
+
+	// SignedOut and Stale tokens are waiting to be pruned.
+	SignedOut int64 `json:"signed_out"`
+	Stale     int64 `json:"stale"`
+}
+
+// TokenHealth counts the push tokens of each platform by condition, taking
+// tokens not registered since staleBefore as stale.
+func (r *NotificationRepository) TokenHealth(ctx context.Context, staleBefore time.Time) ([]TokenHealth, error) {
+	var health []TokenHealth
+	err := r.db.WithContext(ctx).Model(&models.DeviceToken{}).
+		Select(`device_tokens.platform,
+			COUNT(*) AS tokens,
+			COUNT(*) FILTER (WHERE device_tokens.failures > 0) AS failing,
+			COUNT(*) FILTER (WHERE device_tokens.last_pushed_at IS NULL) AS never_pushed,
+			COUNT(*) FILTER (WHERE sessions.revoked_at IS NOT NULL OR sessions.expires_at <= ?) AS signed_out,
+			COUNT(*) FILTER (WHERE device_tokens.updated_at < ?) AS stale`, time.Now(), staleBefore).
+		Joins("JOIN sessions ON sessions.id = device_tokens.session_id").
+		Group("device_tokens.platform").
+		Order("device_tokens.platform").
+		Scan(&health).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count device tokens: %w", err)
+	}
+	return health, nil
+}
+
+// Devices returns the push tokens of a user's active sessions.
+func (r *NotificationRepository) Devices(ctx context.Context, userID uuid.UUID) ([]models.DeviceToken, error) {
+	var devices []models.DeviceToken
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Where("session_id IN (?)", r.db.Model(&models.Session{}).
+			Select("id").
+			Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now())).
+		Find(&devices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+
+// Preferences returns a user's notification preferences, or the defaults
+// if they have never changed them.
+func (r *NotificationRepository) Preferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error) {
+	var preferences models.NotificationPreferences
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).First(&preferences).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		preferences = models.DefaultNotificationPreferences(userID)
+		return &preferences, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification preferences: %w", err)
+	}
+	return &preferences, nil
+}
+
+// SavePreferences stores a user's notification preferences.
+func (r *NotificationRepository) SavePreferences(ctx context.Context, preferences *models.NotificationPreferences) error {
+	err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, UpdateAll: true}).
+		Create(preferences).Error
+	if err != nil {
+		return fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+	return nil
+}
+
+// Mute silences a conversation for a user, replacing any mute they set on
+// it before.
+func (r *NotificationRepository) Mute(ctx context.Context, mute *models.ConversationMute) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "conversation_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"until", "created_at"}),
+		}).Create(mute).Error
+		if err != nil {
+			return err
+		}
+		return summarizeMute(tx, mute.UserID, mute.ConversationID, true, mute.Until)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to mute conversation: %w", err)
+	}
+	return nil
+}
+
+// Unmute lifts a user's mute of a conversation, returning ErrNotFound if
+// it was not muted.
+func (r *NotificationRepository) Unmute(ctx context.Context, userID, conversationID uuid.UUID) error {
+	var unmuted int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND conversation_id = ? AND (until IS NULL OR until > ?)", userID, conversationID, time.Now()).
+			Delete(&models.ConversationMute{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		unmuted = result.RowsAffected
+		return summarizeMute(tx, userID, conversationID, false, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unmute conversation: %w", err)
+	}
+	if unmuted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Mutes returns the user's mutes that have not ended, soonest to end
+// first.
+func (r *NotificationRepository) Mutes(ctx context.Context, userID uuid.UUID) ([]models.ConversationMute, error) {
+	var mutes []models.ConversationMute
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND (until IS NULL OR until > ?)", userID, time.Now()).
+		Order("until ASC NULLS LAST, created_at ASC").
+		Find(&mutes).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mutes: %w", err)
+	}
+	return mutes, nil
+}
+
+// MutedIn returns which of userIDs have the conversation muted at the
+// given time.
+func (r *NotificationRepository) MutedIn(ctx context.Context, conversationID uuid.UUID, userIDs []uuid.UUID, at time.Time) ([]uuid.UUID, error) {
+	var muted []uuid.UUID
+	if len(userIDs) == 0 {
+		return muted, nil
+	}
+	err := r.db.WithContext(ctx).Model(&models.ConversationMute{}).
+		Where("conversation_id = ? AND user_id IN ? AND (until IS NULL OR until > ?)", conversationID, userIDs, at).
+		Pluck("user_id", &muted).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load mutes: %w", err)
+	}
+	return muted, nil
+}
+
+// DoNotDisturb is a user's do-not-disturb hours with the time zone they
+// are in.
+type DoNotDisturb struct {
+	UserID   uuid.UUID
+	DNDStart string
+	DNDEnd   string
+	TimeZone string
+}
+
+// DoNotDisturb returns the do-not-disturb hours of those of userIDs who
+// set any.
+func (r *NotificationRepository) DoNotDisturb(ctx context.Context, userIDs []uuid.UUID) ([]DoNotDisturb, error) {
+	var hours []DoNotDisturb
+	if len(userIDs) == 0 {
+		return hours, nil
+	}
+	err := r.db.WithContext(ctx).Model(&models.NotificationPreferences{}).
+		Select("notification_preferences.user_id, notification_preferences.dnd_start, notification_preferences.dnd_end, users.time_zone").
+		Joins("JOIN users ON users.id = notification_preferences.user_id").
+		Where("notification_preferences.user_id IN ? AND dnd_start IS NOT NULL AND dnd_end IS NOT NULL", userIDs).
+		Scan(&hours).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load do-not-disturb hours: %w", err)
+	}
+	return hours, nil
+}
+
+// DigestRecipients returns up to limit users who take email digests and
+// were last sent one at or before dueBefore, or never.
+func (r *NotificationRepository) DigestRecipients(ctx context.Context, dueBefore time.Time, limit int) ([]models.NotificationPreferences, error) {
+	var due []models.NotificationPreferences
+	err := r.db.WithContext(ctx).
+		Joins("JOIN users ON users.id = notification_preferences.user_id AND users.deleted_at IS NULL AND users.is_banned = ?", false).
+		Where("notification_preferences.email_digest = ?", true).
+		Where("notification_preferences.digest_sent_at IS NULL OR notification_preferences.digest_sent_at <= ?", dueBefore).
+		Order("notification_preferences.digest_sent_at ASC NULLS FIRST").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find digest recipients: %w", err)
+	}
+	return due, nil
+}
+
+// MissedConversation is the activity in one conversation a user has not
+// read.
+type MissedConversation struct {
+	ConversationID uuid.UUID
+	Messages       int64
+}
+
+// MissedActivity returns, for each conversation of the user they have
+// not muted, how many messages others sent after since that the user has
+// not read, busiest first.
+func (r *NotificationRepository) MissedActivity(ctx context.Context, userID uuid.UUID, since time.Time) ([]MissedConversation, error) {
+	var missed []MissedConversation
+	err := r.db.WithContext(ctx).Model(&models.Message{}).
+		Select("messages.conversation_id, COUNT(*) AS messages").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = messages.conversation_id AND conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL", userID).
+		Joins("LEFT JOIN message_receipts ON message_receipts.conversation_id = messages.conversation_id AND message_receipts.user_id = ?", userID).
+		Where("messages.created_at > ? AND messages.sender_id <> ? AND messages.shadowed = ?", since, userID, false).
+		Joins("LEFT JOIN messages AS read_messages ON read_messages.id = message_receipts.read_message_id").
+		Where("read_messages.id IS NULL OR messages.created_at > read_messages.created_at").
+		Where("NOT EXISTS (SELECT 1 FROM conversation_mutes WHERE conversation_mutes.user_id = ? AND conversation_mutes.conversation_id = messages.conversation_id AND (conversation_mutes.until IS NULL OR conversation_mutes.until > ?))", userID, time.Now()).
+		Group("messages.conversation_id").
+		Order("messages DESC").
+		Scan(&missed).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load missed activity: %w", err)
+	}
+	return missed, nil
+}
+
+// DigestSent records that a user's digest covered activity up to at.
+func (r *NotificationRepository) DigestSent(ctx context.Context, userID uuid.UUID, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.NotificationPreferences{}).
+		Where("user_id = ?", userID).
+		Update("digest_sent_at", at).Error
+	if err != nil {
+		return fmt.Errorf("failed to record digest: %w", err)
+	}
+	return nil
+}
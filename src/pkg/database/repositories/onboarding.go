@@ -0,0 +1,179 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OnboardingRepository struct {
+	db *gorm.DB
+}
+
+func NewOnboardingRepository(db *gorm.DB) *OnboardingRepository {
+	return &OnboardingRepository{db: db}
+}
+
+// Steps returns the onboarding sequence in the order it is sent.
+func (r *OnboardingRepository) Steps(ctx context.Context) ([]models.OnboardingStep, error) {
+	var steps []models.OnboardingStep
+	if err := r.db.WithContext(ctx).Order("delay_seconds, key").Find(&steps).Error; err != nil {
+		return nil, fmt.Errorf("failed to list onboarding steps: %w", err)
+	}
+	return steps, nil
+}
+
+// GetStep returns an onboarding step, or ErrNotFound.
+func (r *OnboardingRepository) GetStep(ctx context.Context, id uuid.UUID) (*models.OnboardingStep, error) {
+	return r.findStep(ctx, "id = ?", id)
+}
+
+// StepByKey returns the onboarding step with the given key, or
+// ErrNotFound.
+func (r *OnboardingRepository) StepByKey(ctx context.Context, key string) (*models.OnboardingStep, error) {
+	return r.findStep(ctx, "key = ?", key)
+}
+
+func (r *OnboardingRepository) findStep(ctx context.Context, query string, arg any) (*models.OnboardingStep, error) {
+	var step models.OnboardingStep
+	err := r.db.WithContext(ctx).First(&step, query, arg).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load onboarding step: %w", err)
+	}
+	return &step, nil
+}
+
+// CreateStep stores a step, returning gorm.ErrDuplicatedKey when its key
+// is taken.
+func (r *OnboardingRepository) CreateStep(ctx context.Context, step *models.OnboardingStep) error {
+	if err := r.db.WithContext(ctx).Create(step).Error; err != nil {
+		return fmt.Errorf("failed to create onboarding step: %w", err)
+	}
+	return nil
+}
+
+// SaveStep stores changes to a step, returning gorm.ErrDuplicatedKey when
+// its new key is taken.
+func (r *OnboardingRepository) SaveStep(ctx context.Context, step *models.OnboardingStep) error {
+	if err := r.db.WithContext(ctx).Save(step).Error; err != nil {
+		return fmt.Errorf("failed to update onboarding step: %w", err)
+	}
+	return nil
+}
+
+// DeleteStep removes a step and everyone's progress on it, returning
+// ErrNotFound if there is none. Messages already sent for it stay.
+func (r *OnboardingRepository) DeleteStep(ctx context.Context, id uuid.UUID) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&models.OnboardingProgress{}, "step_id = ?", id).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.OnboardingStep{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete onboarding step: %w", err)
+	}
+	return err
+}
+
+// DueRecipients returns up to limit users the step fell due for at now,
+// having signed up its delay ago but no more than window before that,
+// who still take onboarding messages and have no progress on it.
+func (r *OnboardingRepository) DueRecipients(ctx context.Context, step *models.OnboardingStep, now time.Time, window time.Duration, limit int) ([]uuid.UUID, error) {
+	dueAt := now.Add(-time.Duration(step.DelaySeconds) * time.Second)
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("created_at <= ? AND created_at > ?", dueAt, dueAt.Add(-window)).
+		Where("onboarding_messages = ? AND is_banned = ?", true, false).
+		Where("NOT EXISTS (SELECT 1 FROM onboarding_progress WHERE onboarding_progress.user_id = users.id AND onboarding_progress.step_id = ?)", step.ID).
+		Order("created_at").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find onboarding recipients: %w", err)
+	}
+	return ids, nil
+}
+
+// Claim records a step as being sent to a user, reporting false when it
+// already has progress, as when another run sent it or the user
+// completed it first.
+func (r *OnboardingRepository) Claim(ctx context.Context, userID, stepID uuid.UUID, at time.Time) (bool, error) {
+	progress := models.OnboardingProgress{UserID: userID, StepID: stepID, SentAt: &at}
+	result := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&progress)
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim onboarding step: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Sent records the message a claimed step was sent as.
+func (r *OnboardingRepository) Sent(ctx context.Context, userID, stepID, messageID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Model(&models.OnboardingProgress{}).
+		Where("user_id = ? AND step_id = ?", userID, stepID).
+		Update("message_id", messageID).Error
+	if err != nil {
+		return fmt.Errorf("failed to record onboarding message: %w", err)
+	}
+	return nil
+}
+
+// Unclaim drops the claim on a step that failed to send, for a later run
+// to try again, unless the user completed it meanwhile.
+func (r *OnboardingRepository) Unclaim(ctx context.Context, userID, stepID uuid.UUID) error {
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND step_id = ? AND message_id IS NULL AND completed_at IS NULL", userID, stepID).
+		Delete(&models.OnboardingProgress{}).Error
+	if err != nil {
+		return fmt.Errorf("failed to release onboarding step: %w", err)
+	}
+	return nil
+}
+
+// Progress returns a user's progress on each step they have any on.
+func (r *OnboardingRepository) Progress(ctx context.Context, userID uuid.UUID) ([]models.OnboardingProgress, error) {
+	var progress []models.OnboardingProgress
+	if err := r.db.WithContext(ctx).Where("user_id = ?", userID).Find(&progress).Error; err != nil {
+		return nil, fmt.Errorf("failed to load onboarding progress: %w", err)
+	}
+	return progress, nil
+}
+
+// Complete marks a step complete for a user, keeping the first time it
+// was, and returns their progress on it. A step completed before it was
+// sent is never sent.
+func (r *OnboardingRepository) Complete(ctx context.Context, userID, stepID uuid.UUID, at time.Time) (*models.OnboardingProgress, error) {
+	db := r.db.WithContext(ctx)
+	progress := models.OnboardingProgress{UserID: userID, StepID: stepID, CompletedAt: &at}
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "step_id"}},
+		DoUpdates: clause.Set{{
+			Column: clause.Column{Name: "completed_at"},
+			Value:  gorm.Expr("COALESCE(onboarding_progress.completed_at, excluded.completed_at)"),
+		}},
+	}).Create(&progress).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete onboarding step: %w", err)
+	}
+	var stored models.OnboardingProgress
+	if err := db.First(&stored, "user_id = ? AND step_id = ?", userID, stepID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load onboarding progress: %w", err)
+	}
+	return &stored, nil
+}
@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ReceiptRepository struct {
+	db *gorm.DB
+}
+
+func NewReceiptRepository(db *gorm.DB) *ReceiptRepository {
+	return &ReceiptRepository{db: db}
+}
+
+// ReceiptChange reports which markers a receipt moved.
+type ReceiptChange struct {
+	Delivered bool
+	Read      bool
+
+	// SentAt is when the acknowledged message was sent.
+	SentAt time.Time
+}
+
+// Changed reports whether either marker moved.
+func (c ReceiptChange) Changed() bool {
+	return c.Delivered || c.Read
+}
+
+// Mark moves the user's delivered marker, and for ReceiptRead also the read
+// marker, up to messageID. Markers never move backwards, so a late or
+// repeated receipt changes nothing. It returns ErrNotFound when the message
+// is not in the conversation.
+func (r *ReceiptRepository) Mark(ctx context.Context, conversationID, userID, messageID uuid.UUID, status string) (*models.MessageReceipt, ReceiptChange, error) {
+	var receipt models.MessageReceipt
+	var change ReceiptChange
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		target, err := messageCursor(tx, conversationID, messageID)
+		if err != nil {
+			return err
+		}
+		change.SentAt = target.Time
+
+		err = tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "conversation_id"}, {Name: "user_id"}},
+			DoNothing: true,
+		}).Create(&models.MessageReceipt{ConversationID: conversationID, UserID: userID}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+			First(&receipt).Error
+		if err != nil {
+			return err
+		}
+
+		// behind reports whether a marker is unset or before the target.
+		behind := func(marker *uuid.UUID) (bool, error) {
+			if marker == nil {
+				return true, nil
+			}
+			current, err := messageCursor(tx, conversationID, *marker)
+			if errors.Is(err, ErrNotFound) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+			return current.Before(*target), nil
+		}
+
+		now := time.Now()
+		move, err := behind(receipt.DeliveredMessageID)
+		if err != nil {
+			return err
+		}
+		if move {
+			receipt.DeliveredMessageID, receipt.DeliveredAt = &messageID, &now
+			change.Delivered = true
+		}
+		if status == models.ReceiptRead {
+			if move, err = behind(receipt.ReadMessageID); err != nil {
+				return err
+			}
+			if move {
+				receipt.ReadMessageID, receipt.ReadAt = &messageID, &now
+				change.Read = true
+			}
+		}
+		if !change.Changed() {
+			return nil
+		}
+		if err := tx.Save(&receipt).Error; err != nil {
+			return err
+		}
+		if !change.Read {
+			return nil
+		}
+		return recountUnread(tx, conversationID, userID)
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, ReceiptChange{}, err
+	}
+	if err != nil {
+		return nil, ReceiptChange{}, fmt.Errorf("failed to mark receipt: %w", err)
+	}
+	return &receipt, change, nil
+}
+
+// List returns the receipts of every member who has acknowledged a message
+// in the conversation.
+func (r *ReceiptRepository) List(ctx context.Context, conversationID uuid.UUID) ([]models.MessageReceipt, error) {
+	var receipts []models.MessageReceipt
+	if err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Find(&receipts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+	return receipts, nil
+}
+
+// ListIn returns the receipts of every member of the given conversations.
+func (r *ReceiptRepository) ListIn(ctx context.Context, conversationIDs []uuid.UUID) ([]models.MessageReceipt, error) {
+	receipts := []models.MessageReceipt{}
+	if len(conversationIDs) == 0 {
+		return receipts, nil
+	}
+	if err := r.db.WithContext(ctx).Where("conversation_id IN ?", conversationIDs).Find(&receipts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+	return receipts, nil
+}
+
+// ChangedForUser returns the receipts that moved after since in the
+// workspace's conversations userID belongs to.
+func (r *ReceiptRepository) ChangedForUser(ctx context.Context, workspaceID, userID uuid.UUID, since time.Time) ([]models.MessageReceipt, error) {
+	receipts := []models.MessageReceipt{}
+	err := r.db.WithContext(ctx).
+		Joins("JOIN conversations ON conversations.id = message_receipts.conversation_id").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = message_receipts.conversation_id AND conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL", userID).
+		Where("conversations.workspace_id = ? AND message_receipts.updated_at > ?", workspaceID, since).
+		Find(&receipts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed receipts: %w", err)
+	}
+	return receipts, nil
+}
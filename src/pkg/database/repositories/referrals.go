@@ -0,0 +1,157 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// referralCodeAttempts bounds the fresh codes tried when one clashes with
+// another user's.
+const referralCodeAttempts = 5
+
+type ReferralRepository struct {
+	db *gorm.DB
+}
+
+func NewReferralRepository(db *gorm.DB) *ReferralRepository {
+	return &ReferralRepository{db: db}
+}
+
+// Code returns the user's referral code, making one with generate the
+// first time.
+func (r *ReferralRepository) Code(ctx context.Context, userID uuid.UUID, generate func() (string, error)) (*models.ReferralCode, error) {
+	db := r.db.WithContext(ctx)
+	var code models.ReferralCode
+	err := db.First(&code, "user_id = ?", userID).Error
+	if err == nil {
+		return &code, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load referral code: %w", err)
+	}
+
+	for range referralCodeAttempts {
+		value, err := generate()
+		if err != nil {
+			return nil, err
+		}
+		// A concurrent request may make the user's code first, in which
+		// case nothing is inserted and that code is returned.
+		result := db.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "user_id"}}, DoNothing: true}).
+			Create(&models.ReferralCode{UserID: userID, Code: value, CreatedAt: time.Now()})
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			continue
+		}
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to create referral code: %w", result.Error)
+		}
+		var created models.ReferralCode
+		if err := db.First(&created, "user_id = ?", userID).Error; err != nil {
+			return nil, fmt.Errorf("failed to load referral code: %w", err)
+		}
+		return &created, nil
+	}
+	return nil, errors.New("failed to create referral code: every code tried was taken")
+}
+
+// Attribute records that referredID signed up with a referral code,
+// returning ErrNotFound when no user has the code.
+func (r *ReferralRepository) Attribute(ctx context.Context, code string, referredID uuid.UUID) (*models.Referral, error) {
+	db := r.db.WithContext(ctx)
+	var owner models.ReferralCode
+	err := db.First(&owner, "code = ?", code).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && owner.UserID == referredID) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load referral code: %w", err)
+	}
+	referral := models.Referral{ReferrerID: owner.UserID, ReferredID: referredID}
+	if err := db.Create(&referral).Error; err != nil {
+		return nil, fmt.Errorf("failed to record referral: %w", err)
+	}
+	return &referral, nil
+}
+
+// Qualify qualifies the referral that brought referredID in, if any and
+// not already qualified, and records the milestones its referrer reached
+// by it. It returns the rewards recorded, which are none most of the time.
+func (r *ReferralRepository) Qualify(ctx context.Context, referredID uuid.UUID, at time.Time, milestones []int) ([]models.ReferralReward, error) {
+	var rewards []models.ReferralReward
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var referral models.Referral
+		result := tx.Model(&referral).
+			Clauses(clause.Returning{Columns: []clause.Column{{Name: "referrer_id"}}}).
+			Where("referred_id = ? AND qualified_at IS NULL", referredID).
+			Update("qualified_at", at)
+		if result.Error != nil {
+			return fmt.Errorf("failed to qualify referral: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		var qualified int64
+		err := tx.Model(&models.Referral{}).
+			Where("referrer_id = ? AND qualified_at IS NOT NULL", referral.ReferrerID).
+			Count(&qualified).Error
+		if err != nil {
+			return fmt.Errorf("failed to count referrals: %w", err)
+		}
+		for _, milestone := range milestones {
+			if int64(milestone) > qualified {
+				continue
+			}
+			reward := models.ReferralReward{ReferrerID: referral.ReferrerID, Milestone: milestone, ReachedAt: at}
+			created := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&reward)
+			if created.Error != nil {
+				return fmt.Errorf("failed to record referral reward: %w", created.Error)
+			}
+			if created.RowsAffected > 0 {
+				rewards = append(rewards, reward)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rewards, nil
+}
+
+// ReferralStats is how a referrer's referrals are doing.
+type ReferralStats struct {
+	Signups   int64                   `json:"signups"`
+	Qualified int64                   `json:"qualified"`
+	Rewards   []models.ReferralReward `json:"rewards"`
+}
+
+// Stats counts the signups a user referred and how many qualified, with
+// the milestones they reached in order.
+func (r *ReferralRepository) Stats(ctx context.Context, referrerID uuid.UUID) (*ReferralStats, error) {
+	db := r.db.WithContext(ctx)
+	var counts struct {
+		Signups   int64
+		Qualified int64
+	}
+	err := db.Model(&models.Referral{}).
+		Select("COUNT(*) AS signups, COUNT(qualified_at) AS qualified").
+		Where("referrer_id = ?", referrerID).
+		Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count referrals: %w", err)
+	}
+	stats := ReferralStats{Signups: counts.Signups, Qualified: counts.Qualified, Rewards: []models.ReferralReward{}}
+	err = db.Where("referrer_id = ?", referrerID).Order("milestone ASC").Find(&stats.Rewards).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list referral rewards: %w", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,95 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ReminderRepository struct {
+	db *gorm.DB
+}
+
+func NewReminderRepository(db *gorm.DB) *ReminderRepository {
+	return &ReminderRepository{db: db}
+}
+
+// Create adds a reminder, failing with ErrLimitReached when its user
+// already has limit reminders pending.
+func (r *ReminderRepository) Create(ctx context.Context, reminder *models.Reminder, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		err := tx.Model(&models.Reminder{}).
+			Where("user_id = ? AND delivered_at IS NULL AND cancelled_at IS NULL", reminder.UserID).
+			Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to count reminders: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Create(reminder).Error; err != nil {
+			return fmt.Errorf("failed to create reminder: %w", err)
+		}
+		return nil
+	})
+}
+
+// Pending returns a user's reminders yet to be delivered, soonest first.
+func (r *ReminderRepository) Pending(ctx context.Context, userID uuid.UUID) ([]models.Reminder, error) {
+	reminders := []models.Reminder{}
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND delivered_at IS NULL AND cancelled_at IS NULL", userID).
+		Order("remind_at ASC, id ASC").
+		Find(&reminders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reminders: %w", err)
+	}
+	return reminders, nil
+}
+
+// Cancel cancels one of a user's pending reminders.
+func (r *ReminderRepository) Cancel(ctx context.Context, userID, id uuid.UUID, at time.Time) error {
+	result := r.db.WithContext(ctx).Model(&models.Reminder{}).
+		Where("id = ? AND user_id = ? AND delivered_at IS NULL AND cancelled_at IS NULL", id, userID).
+		Update("cancelled_at", at)
+	if result.Error != nil {
+		return fmt.Errorf("failed to cancel reminder: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ClaimDue marks up to limit reminders due by now as delivered and
+// returns them. A reminder another instance claimed first is left out.
+func (r *ReminderRepository) ClaimDue(ctx context.Context, now time.Time, limit int) ([]models.Reminder, error) {
+	var due []models.Reminder
+	err := r.db.WithContext(ctx).
+		Where("remind_at <= ? AND delivered_at IS NULL AND cancelled_at IS NULL", now).
+		Order("remind_at").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due reminders: %w", err)
+	}
+	claimed := due[:0]
+	for _, reminder := range due {
+		result := r.db.WithContext(ctx).Model(&models.Reminder{}).
+			Where("id = ? AND delivered_at IS NULL AND cancelled_at IS NULL", reminder.ID).
+			Update("delivered_at", now)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to claim reminder: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			reminder.DeliveredAt = &now
+			claimed = append(claimed, reminder)
+		}
+	}
+	return claimed, nil
+}
@@ -0,0 +1,100 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type ReportRepository struct {
+	db *gorm.DB
+}
+
+func NewReportRepository(db *gorm.DB) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+// Create files a report.
+func (r *ReportRepository) Create(ctx context.Context, report *models.Report) error {
+	if err := r.db.WithContext(ctx).Create(report).Error; err != nil {
+		return fmt.Errorf("failed to file report: %w", err)
+	}
+	return nil
+}
+
+// List returns up to limit reports older than before, newest first, with
+// status, or any status when it is empty.
+func (r *ReportRepository) List(ctx context.Context, status string, before *pagination.Cursor, limit int) ([]models.Report, error) {
+	query := r.db.WithContext(ctx)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.Time, before.ID)
+	}
+
+	var reports []models.Report
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+	return reports, nil
+}
+
+// Open returns the open reports among ids, and those of the messages in
+// messageIDs.
+func (r *ReportRepository) Open(ctx context.Context, ids, messageIDs []uuid.UUID) ([]models.Report, error) {
+	var reports []models.Report
+	if len(ids) == 0 && len(messageIDs) == 0 {
+		return reports, nil
+	}
+	query := r.db.WithContext(ctx).Where("status = ?", models.ReportOpen)
+	switch {
+	case len(ids) > 0 && len(messageIDs) > 0:
+		query = query.Where("id IN ? OR message_id IN ?", ids, messageIDs)
+	case len(ids) > 0:
+		query = query.Where("id IN ?", ids)
+	default:
+		query = query.Where("message_id IN ?", messageIDs)
+	}
+	if err := query.Find(&reports).Error; err != nil {
+		return nil, fmt.Errorf("failed to load reports: %w", err)
+	}
+	return reports, nil
+}
+
+// Dismiss closes an open report without action, returning ErrNotFound if
+// there is no such open report.
+func (r *ReportRepository) Dismiss(ctx context.Context, id, moderatorID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Model(&models.Report{}).
+		Where("id = ? AND status = ?", id, models.ReportOpen).
+		Updates(map[string]any{
+			"status":         models.ReportDismissed,
+			"resolved_by_id": moderatorID,
+			"resolved_at":    time.Now(),
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to dismiss report: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CountAgainst counts the reports filed against a user since a time, open
+// or upheld.
+func (r *ReportRepository) CountAgainst(ctx context.Context, userID uuid.UUID, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Report{}).
+		Where("user_id = ? AND status <> ? AND created_at > ?", userID, models.ReportDismissed, since).
+		Count(&count).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reports: %w", err)
+	}
+	return count, nil
+}
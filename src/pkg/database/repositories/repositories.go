@@ -0,0 +1,37 @@
+package repositories
+
+import "errors"
+
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrNotMember = errors.New("user is not a member of the conversation")
+
+	// ErrTokenReused means an already-rotated refresh token was presented,
+	// so it has probably leaked; the session it belonged to is revoked.
+	ErrTokenReused = errors.New("refresh token was already used")
+
+	// ErrInvalidAttachment means an attachment cannot be sent: it does not
+	// exist, belongs to someone else, is still uploading, was already sent
+	// or is client-encrypted for a message that is not (or the reverse).
+	ErrInvalidAttachment = errors.New("attachment cannot be sent")
+
+	// ErrBlocked means one of the users has blocked the other.
+	ErrBlocked = errors.New("user is blocked")
+
+	// ErrStaleVersion means a record was replaced since the caller last
+	// read it.
+	ErrStaleVersion = errors.New("record was changed by someone else")
+
+	// ErrTooLong means an edit would take a document past its length limit.
+	ErrTooLong = errors.New("document is too long")
+
+	// ErrLimitReached means storing more would take the owner past a
+	// limit on how many they may keep.
+	ErrLimitReached = errors.New("limit reached")
+
+	// ErrExpired means the window for changing a record has closed.
+	ErrExpired = errors.New("too late to change this")
+
+	// ErrConsumed means a single-use grant was already used up.
+	ErrConsumed = errors.New("already used")
+)
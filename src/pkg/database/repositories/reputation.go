@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReportPenalty is how much reputation each report against a message a
+// member posted in a channel costs them there, unless it was dismissed.
+const ReportPenalty = 10
+
+// MemberReputation is a member's reputation in a channel: a point per
+// message they posted there, less ReportPenalty per report against one.
+type MemberReputation struct {
+	UserID      uuid.UUID `json:"user_id"`
+	DisplayName string    `json:"display_name"`
+	Role        string    `json:"role"`
+	Messages    int64     `json:"messages"`
+	Reports     int64     `json:"reports"`
+	Score       int64     `json:"score"`
+}
+
+type ReputationRepository struct {
+	db *gorm.DB
+}
+
+func NewReputationRepository(db *gorm.DB) *ReputationRepository {
+	return &ReputationRepository{db: db}
+}
+
+// countContribution counts a message posted to a channel towards its
+// sender's reputation there.
+func countContribution(tx *gorm.DB, message *models.Message) error {
+	contribution := models.RoomContribution{ConversationID: message.ConversationID, UserID: message.SenderID, Messages: 1, UpdatedAt: message.CreatedAt}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "conversation_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"messages":   gorm.Expr("room_contributions.messages + 1"),
+			"updated_at": message.CreatedAt,
+		}),
+	}).Omit("Conversation", "User").Create(&contribution).Error
+	if err != nil {
+		return fmt.Errorf("failed to count contribution: %w", err)
+	}
+	return nil
+}
+
+// reportsAgainst counts the reports, other than dismissed ones, against
+// the messages each user posted in the channel.
+func (r *ReputationRepository) reportsAgainst(conversationID uuid.UUID) *gorm.DB {
+	return r.db.Model(&models.Report{}).
+		Select("reports.user_id, COUNT(*) AS reports").
+		Joins("JOIN messages ON messages.id = reports.message_id").
+		Where("messages.conversation_id = ? AND reports.status <> ?", conversationID, models.ReportDismissed).
+		Group("reports.user_id")
+}
+
+// Rankings returns the limit current members of the channel with the
+// highest reputation there, highest first.
+func (r *ReputationRepository) Rankings(ctx context.Context, conversationID uuid.UUID, limit int) ([]MemberReputation, error) {
+	rankings := []MemberReputation{}
+	err := r.db.WithContext(ctx).Model(&models.ConversationMember{}).
+		Select(`conversation_members.user_id, users.display_name, conversation_members.role,
+			COALESCE(room_contributions.messages, 0) AS messages,
+			COALESCE(reported.reports, 0) AS reports,
+			COALESCE(room_contributions.messages, 0) - ? * COALESCE(reported.reports, 0) AS score`, ReportPenalty).
+		Joins("JOIN users ON users.id = conversation_members.user_id").
+		Joins("LEFT JOIN room_contributions ON room_contributions.conversation_id = conversation_members.conversation_id AND room_contributions.user_id = conversation_members.user_id").
+		Joins("LEFT JOIN (?) AS reported ON reported.user_id = conversation_members.user_id", r.reportsAgainst(conversationID)).
+		Where("conversation_members.conversation_id = ?", conversationID).
+		Order("score DESC, conversation_members.joined_at").
+		Limit(limit).
+		Scan(&rankings).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank members: %w", err)
+	}
+	return rankings, nil
+}
+
+// Score returns the user's reputation in the channel.
+func (r *ReputationRepository) Score(ctx context.Context, conversationID, userID uuid.UUID) (int64, error) {
+	var messages []int64
+	err := r.db.WithContext(ctx).Model(&models.RoomContribution{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Pluck("messages", &messages).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load contribution: %w", err)
+	}
+	var reports int64
+	err = r.db.WithContext(ctx).Model(&models.Report{}).
+		Joins("JOIN messages ON messages.id = reports.message_id").
+		Where("messages.conversation_id = ? AND reports.user_id = ? AND reports.status <> ?", conversationID, userID, models.ReportDismissed).
+		Count(&reports).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reports: %w", err)
+	}
+	var score int64
+	if len(messages) > 0 {
+		score = messages[0]
+	}
+	return score - ReportPenalty*reports, nil
+}
@@ -0,0 +1,73 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+func TestReputationRankings(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	chatty := createUser(t, db, "chatty")
+	reported := createUser(t, db, "reported")
+	channel, err := repositories.NewChannelRepository(db.DB).Create(ctx, models.DefaultWorkspaceID, owner.ID, "garden", "", false, []uuid.UUID{chatty.ID, reported.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := repositories.NewMessageRepository(db.DB)
+	post := func(sender *models.User, shadowed bool) *models.Message {
+		message := &models.Message{ConversationID: channel.ConversationID, SenderID: sender.ID, Text: "hello", Shadowed: shadowed}
+		if _, err := messages.Create(ctx, message, nil); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	for range 3 {
+		post(chatty, false)
+	}
+	post(chatty, true)
+	var last *models.Message
+	for range 12 {
+		last = post(reported, false)
+	}
+	for _, status := range []string{models.ReportOpen, models.ReportDismissed} {
+		report := models.Report{ReporterID: owner.ID, UserID: reported.ID, MessageID: &last.ID, Reason: "spam", Status: status}
+		if err := db.DB.Create(&report).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reputation := repositories.NewReputationRepository(db.DB)
+	rankings, err := reputation.Rankings(ctx, channel.ConversationID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		userID            uuid.UUID
+		messages, reports int64
+	}{{chatty.ID, 3, 0}, {reported.ID, 12, 1}, {owner.ID, 0, 0}}
+	if len(rankings) != len(want) {
+		t.Fatalf("ranked %d members, want %d", len(rankings), len(want))
+	}
+	for i, w := range want {
+		got := rankings[i]
+		if got.UserID != w.userID || got.Messages != w.messages || got.Reports != w.reports || got.Score != w.messages-repositories.ReportPenalty*w.reports {
+			t.Fatalf("rank %d = %+v, want %+v", i, got, w)
+		}
+	}
+	score, err := reputation.Score(ctx, channel.ConversationID, reported.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score != 12-repositories.ReportPenalty {
+		t.Fatalf("score %d, want %d", score, 12-repositories.ReportPenalty)
+	}
+}
@@ -0,0 +1,125 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoomEmailRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomEmailRepository(db *gorm.DB) *RoomEmailRepository {
+	return &RoomEmailRepository{db: db}
+}
+
+// SetAddress gives a channel the address, replacing any it had, so mail
+// to the old one is no longer accepted.
+func (r *RoomEmailRepository) SetAddress(ctx context.Context, address *models.RoomEmailAddress) error {
+	err := r.db.WithContext(ctx).Omit("Conversation", "CreatedBy").Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "conversation_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"local_part", "created_by_id", "last_received_at", "created_at"}),
+	}).Create(address).Error
+	if err != nil {
+		return fmt.Errorf("failed to set room email address: %w", err)
+	}
+	return nil
+}
+
+// Address returns a channel's address, or ErrNotFound when it has none.
+func (r *RoomEmailRepository) Address(ctx context.Context, conversationID uuid.UUID) (*models.RoomEmailAddress, error) {
+	return r.address(ctx, "conversation_id = ?", conversationID)
+}
+
+// ByLocalPart returns the address with the local part, or ErrNotFound.
+func (r *RoomEmailRepository) ByLocalPart(ctx context.Context, localPart string) (*models.RoomEmailAddress, error) {
+	return r.address(ctx, "local_part = ?", localPart)
+}
+
+func (r *RoomEmailRepository) address(ctx context.Context, where string, value any) (*models.RoomEmailAddress, error) {
+	var address models.RoomEmailAddress
+	err := r.db.WithContext(ctx).Where(where, value).First(&address).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room email address: %w", err)
+	}
+	return &address, nil
+}
+
+// RecordReceived notes that mail to the address was posted.
+func (r *RoomEmailRepository) RecordReceived(ctx context.Context, conversationID uuid.UUID, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.RoomEmailAddress{}).
+		Where("conversation_id = ?", conversationID).
+		Update("last_received_at", at).Error
+	if err != nil {
+		return fmt.Errorf("failed to record received email: %w", err)
+	}
+	return nil
+}
+
+// DeleteAddress removes a channel's address, returning ErrNotFound when
+// it had none. Members' relays stay, to resume with a new address.
+func (r *RoomEmailRepository) DeleteAddress(ctx context.Context, conversationID uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Delete(&models.RoomEmailAddress{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete room email address: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Relays reports whether the user has the channel's messages emailed.
+func (r *RoomEmailRepository) Relays(ctx context.Context, conversationID, userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.RoomEmailRelay{}).
+		Where("conversation_id = ? AND user_id = ?", conversationID, userID).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load email relay: %w", err)
+	}
+	return count > 0, nil
+}
+
+// SetRelay turns emailing the channel's messages to the user on or off.
+func (r *RoomEmailRepository) SetRelay(ctx context.Context, conversationID, userID uuid.UUID, enabled bool) error {
+	db := r.db.WithContext(ctx)
+	var err error
+	if enabled {
+		relay := models.RoomEmailRelay{ConversationID: conversationID, UserID: userID}
+		err = db.Omit("Conversation", "User").Clauses(clause.OnConflict{DoNothing: true}).Create(&relay).Error
+	} else {
+		err = db.Where("conversation_id = ? AND user_id = ?", conversationID, userID).Delete(&models.RoomEmailRelay{}).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to set email relay: %w", err)
+	}
+	return nil
+}
+
+// RelayRecipients returns the current members of the channel, other than
+// the sender, who have its messages emailed to a verified address. There
+// are none while the channel has no address to reply to.
+func (r *RoomEmailRepository) RelayRecipients(ctx context.Context, conversationID, senderID uuid.UUID) ([]models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).
+		Joins("JOIN room_email_relays ON room_email_relays.user_id = users.id").
+		Joins("JOIN room_email_addresses ON room_email_addresses.conversation_id = room_email_relays.conversation_id").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = room_email_relays.conversation_id AND conversation_members.user_id = users.id AND conversation_members.deleted_at IS NULL").
+		Where("room_email_relays.conversation_id = ? AND users.id <> ? AND users.is_verified = ?", conversationID, senderID, true).
+		Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list email relay recipients: %w", err)
+	}
+	return users, nil
+}
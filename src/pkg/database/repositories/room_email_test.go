@@ -0,0 +1,58 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+func TestRoomEmailRelayRecipients(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	reader := createUser(t, db, "reader")
+	unverified := createUser(t, db, "unverified")
+	leaver := createUser(t, db, "leaver")
+	if err := db.DB.Model(&models.User{}).Where("id IN ?", []uuid.UUID{owner.ID, reader.ID, leaver.ID}).Update("is_verified", true).Error; err != nil {
+		t.Fatal(err)
+	}
+	channels := repositories.NewChannelRepository(db.DB)
+	channel, err := channels.Create(ctx, models.DefaultWorkspaceID, owner.ID, "garden", "", false, []uuid.UUID{reader.ID, unverified.ID, leaver.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := repositories.NewRoomEmailRepository(db.DB)
+	for _, user := range []*models.User{owner, reader, unverified, leaver} {
+		if err := emails.SetRelay(ctx, channel.ConversationID, user.ID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := channels.RemoveMember(ctx, channel.ConversationID, leaver.ID); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err := emails.RelayRecipients(ctx, channel.ConversationID, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 0 {
+		t.Fatalf("recipients without an address %d, want none", len(recipients))
+	}
+
+	err = emails.SetAddress(ctx, &models.RoomEmailAddress{ConversationID: channel.ConversationID, LocalPart: "room-garden", CreatedByID: &owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients, err = emails.RelayRecipients(ctx, channel.ConversationID, owner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recipients) != 1 || recipients[0].ID != reader.ID {
+		t.Fatalf("recipients %+v, want only the verified member other than the sender", recipients)
+	}
+}
@@ -0,0 +1,246 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RoomExportRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomExportRepository(db *gorm.DB) *RoomExportRepository {
+	return &RoomExportRepository{db: db}
+}
+
+// List returns a room's export schedules, oldest first.
+func (r *RoomExportRepository) List(ctx context.Context, conversationID uuid.UUID) ([]models.RoomExportSchedule, error) {
+	var schedules []models.RoomExportSchedule
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ?", conversationID).
+		Order("created_at ASC, id ASC").
+		Find(&schedules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list room export schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// Get loads one of a room's export schedules.
+func (r *RoomExportRepository) Get(ctx context.Context, conversationID, id uuid.UUID) (*models.RoomExportSchedule, error) {
+	var schedule models.RoomExportSchedule
+	err := r.db.WithContext(ctx).First(&schedule, "id = ? AND conversation_id = ?", id, conversationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room export schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// GetByID loads an export schedule by ID alone, for the job delivering it.
+func (r *RoomExportRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.RoomExportSchedule, error) {
+	var schedule models.RoomExportSchedule
+	err := r.db.WithContext(ctx).First(&schedule, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room export schedule: %w", err)
+	}
+	return &schedule, nil
+}
+
+// Create adds an export schedule to a room, failing with ErrLimitReached
+// when the room already has limit schedules.
+func (r *RoomExportRepository) Create(ctx context.Context, schedule *models.RoomExportSchedule, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.RoomExportSchedule{}).Where("conversation_id = ?", schedule.ConversationID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count room export schedules: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Create(schedule).Error; err != nil {
+			return fmt.Errorf("failed to create room export schedule: %w", err)
+		}
+		return nil
+	})
+}
+
+// Update saves when and whether a schedule runs. Its destination is fixed
+// once created.
+func (r *RoomExportRepository) Update(ctx context.Context, schedule *models.RoomExportSchedule) error {
+	err := r.db.WithContext(ctx).Model(schedule).
+		Select("interval_days", "enabled", "next_run_at", "updated_at").
+		Updates(schedule).Error
+	if err != nil {
+		return fmt.Errorf("failed to update room export schedule: %w", err)
+	}
+	return nil
+}
+
+// SetSecretKey replaces the stored secret key of a schedule's bucket, as
+// when a key stored in the clear is sealed.
+func (r *RoomExportRepository) SetSecretKey(ctx context.Context, id uuid.UUID, secretKey string) error {
+	err := r.db.WithContext(ctx).Model(&models.RoomExportSchedule{}).
+		Where("id = ?", id).
+		Update("s3_secret_key", secretKey).Error
+	if err != nil {
+		return fmt.Errorf("failed to update room export secret key: %w", err)
+	}
+	return nil
+}
+
+// Disable stops a schedule running, as when its creator no longer owns the
+// room.
+func (r *RoomExportRepository) Disable(ctx context.Context, id uuid.UUID) error {
+	err := r.db.WithContext(ctx).Model(&models.RoomExportSchedule{}).
+		Where("id = ?", id).
+		Update("enabled", false).Error
+	if err != nil {
+		return fmt.Errorf("failed to disable room export schedule: %w", err)
+	}
+	return nil
+}
+
+// Delete removes one of a room's export schedules with its delivery log,
+// returning the storage keys of the snapshots it kept for the caller to
+// clean up after.
+func (r *RoomExportRepository) Delete(ctx context.Context, conversationID, id uuid.UUID) ([]string, error) {
+	var keys []string
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.RoomExportDelivery{}).
+			Where("schedule_id = ? AND storage_key IS NOT NULL", id).
+			Pluck("storage_key", &keys).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Delete(&models.RoomExportSchedule{}, "id = ? AND conversation_id = ?", id, conversationID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Delete(&models.RoomExportDelivery{}, "schedule_id = ?", id).Error
+	})
+	if errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete room export schedule: %w", err)
+	}
+	return keys, nil
+}
+
+// ClaimDue returns up to limit enabled schedules due at now, moving each
+// on to its next run.
+func (r *RoomExportRepository) ClaimDue(ctx context.Context, now time.Time, limit int) ([]models.RoomExportSchedule, error) {
+	var due []models.RoomExportSchedule
+	err := r.db.WithContext(ctx).
+		Where("enabled = ? AND next_run_at <= ?", true, now).
+		Order("next_run_at").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due room exports: %w", err)
+	}
+	for i := range due {
+		due[i].NextRunAt = now.Add(time.Duration(due[i].IntervalDays) * 24 * time.Hour)
+		due[i].LastRunAt = &now
+		err := r.db.WithContext(ctx).Model(&due[i]).
+			Updates(map[string]any{"next_run_at": due[i].NextRunAt, "last_run_at": now}).Error
+		if err != nil {
+			return nil, fmt.Errorf("failed to advance room export schedule: %w", err)
+		}
+	}
+	return due, nil
+}
+
+// RecordDelivery adds a delivery to a schedule's log.
+func (r *RoomExportRepository) RecordDelivery(ctx context.Context, delivery *models.RoomExportDelivery) error {
+	if err := r.db.WithContext(ctx).Create(delivery).Error; err != nil {
+		return fmt.Errorf("failed to record room export delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns up to limit of a room's deliveries, newest first,
+// starting after the given cursor if any.
+func (r *RoomExportRepository) ListDeliveries(ctx context.Context, conversationID uuid.UUID, before *pagination.Cursor, limit int) ([]models.RoomExportDelivery, error) {
+	query := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID)
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.Time, before.ID)
+	}
+	var deliveries []models.RoomExportDelivery
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&deliveries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list room export deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// GetDelivery loads one of a room's deliveries.
+func (r *RoomExportRepository) GetDelivery(ctx context.Context, conversationID, id uuid.UUID) (*models.RoomExportDelivery, error) {
+	var delivery models.RoomExportDelivery
+	err := r.db.WithContext(ctx).First(&delivery, "id = ? AND conversation_id = ?", id, conversationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room export delivery: %w", err)
+	}
+	return &delivery, nil
+}
+
+// ExpireSnapshots forgets the emailed snapshots that expired by now,
+// returning their storage keys for the caller to delete. Their deliveries
+// stay in the log.
+func (r *RoomExportRepository) ExpireSnapshots(ctx context.Context, now time.Time) ([]string, error) {
+	var expired []models.RoomExportDelivery
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Select("id", "storage_key").
+			Where("storage_key IS NOT NULL AND expires_at <= ?", now).
+			Find(&expired).Error
+		if err != nil || len(expired) == 0 {
+			return err
+		}
+		ids := make([]uuid.UUID, len(expired))
+		for i, delivery := range expired {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&models.RoomExportDelivery{}).
+			Where("id IN ?", ids).
+			Update("storage_key", nil).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire room export snapshots: %w", err)
+	}
+	keys := make([]string, 0, len(expired))
+	for _, delivery := range expired {
+		keys = append(keys, *delivery.StorageKey)
+	}
+	return keys, nil
+}
+
+// PurgeDeliveries deletes the deliveries logged before before, whose
+// snapshots have expired.
+func (r *RoomExportRepository) PurgeDeliveries(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("created_at < ? AND storage_key IS NULL", before).
+		Delete(&models.RoomExportDelivery{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge room export deliveries: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
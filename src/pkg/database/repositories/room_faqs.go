@@ -0,0 +1,112 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type RoomFAQRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomFAQRepository(db *gorm.DB) *RoomFAQRepository {
+	return &RoomFAQRepository{db: db}
+}
+
+// List returns a room's FAQ entries, oldest first.
+func (r *RoomFAQRepository) List(ctx context.Context, conversationID uuid.UUID) ([]models.RoomFAQ, error) {
+	var faqs []models.RoomFAQ
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ?", conversationID).
+		Order("created_at ASC, id ASC").
+		Find(&faqs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list room FAQ: %w", err)
+	}
+	return faqs, nil
+}
+
+// Get loads one of a room's FAQ entries.
+func (r *RoomFAQRepository) Get(ctx context.Context, conversationID, id uuid.UUID) (*models.RoomFAQ, error) {
+	var faq models.RoomFAQ
+	err := r.db.WithContext(ctx).First(&faq, "id = ? AND conversation_id = ?", id, conversationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load room FAQ entry: %w", err)
+	}
+	return &faq, nil
+}
+
+// Create adds an entry to a room's FAQ, failing with ErrLimitReached when
+// the room already has limit entries.
+func (r *RoomFAQRepository) Create(ctx context.Context, faq *models.RoomFAQ, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.RoomFAQ{}).Where("conversation_id = ?", faq.ConversationID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count room FAQ entries: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Create(faq).Error; err != nil {
+			return fmt.Errorf("failed to create room FAQ entry: %w", err)
+		}
+		return nil
+	})
+}
+
+// Update saves the content and settings of an entry. Its counters are
+// left as they are.
+func (r *RoomFAQRepository) Update(ctx context.Context, faq *models.RoomFAQ) error {
+	err := r.db.WithContext(ctx).Model(faq).
+		Select("question", "keywords", "answer", "enabled", "auto_post", "author_id", "updated_at").
+		Updates(faq).Error
+	if err != nil {
+		return fmt.Errorf("failed to update room FAQ entry: %w", err)
+	}
+	return nil
+}
+
+// Delete removes one of a room's FAQ entries.
+func (r *RoomFAQRepository) Delete(ctx context.Context, conversationID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Delete(&models.RoomFAQ{}, "id = ? AND conversation_id = ?", id, conversationID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete room FAQ entry: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordHit counts a question the entry answered.
+func (r *RoomFAQRepository) RecordHit(ctx context.Context, id uuid.UUID, at time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.RoomFAQ{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"hits": gorm.Expr("hits + 1"), "last_hit_at": at}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record room FAQ hit: %w", err)
+	}
+	return nil
+}
+
+// ClaimAutoPost reports whether the entry may be posted to its room at
+// at, which it may not within interval of its last post, and counts the
+// post if so. Of members asking at once, one gets the post.
+func (r *RoomFAQRepository) ClaimAutoPost(ctx context.Context, id uuid.UUID, at time.Time, interval time.Duration) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.RoomFAQ{}).
+		Where("id = ? AND (last_posted_at IS NULL OR last_posted_at <= ?)", id, at.Add(-interval)).
+		Updates(map[string]any{"auto_posts": gorm.Expr("auto_posts + 1"), "last_posted_at": at})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim room FAQ post: %w", result.Error)
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type RoomMergeRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomMergeRepository(db *gorm.DB) *RoomMergeRepository {
+	return &RoomMergeRepository{db: db}
+}
+
+// Merge moves the members of merge.SourceID, with its history when
+// merge.MovedHistory is set, into merge.TargetID, deletes the source and
+// records the merge with what moved. Members join the target as plain
+// members, rejoining if they had left it. Moved messages, tombstones
+// included, keep their IDs and times and are numbered after the target's
+// latest in their old order. The source's welcome room entries move to
+// the target when it is a channel. It returns the IDs of the moved
+// messages, for the caller to reindex, or ErrNotFound when the source is
+// gone, as when it was merged meanwhile.
+func (r *RoomMergeRepository) Merge(ctx context.Context, merge *models.RoomMerge) ([]uuid.UUID, error) {
+	var movedIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var source models.Conversation
+		if err := tx.First(&source, "id = ?", merge.SourceID).Error; err != nil {
+			return err
+		}
+		// Deleting the source first keeps a second merge of it out, and
+		// new messages, which lock it to be numbered.
+		deleted := tx.Delete(&models.Conversation{}, "id = ?", merge.SourceID)
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		var joining []uuid.UUID
+		err := tx.Model(&models.ConversationMember{}).
+			Where("conversation_id = ? AND user_id NOT IN (?)", merge.SourceID,
+				tx.Model(&models.ConversationMember{}).Select("user_id").Where("conversation_id = ?", merge.TargetID)).
+			Pluck("user_id", &joining).Error
+		if err != nil {
+			return err
+		}
+		if err := NewChannelRepository(tx).AddMembers(ctx, merge.TargetID, joining); err != nil {
+			return err
+		}
+		merge.MovedMembers = len(joining)
+		if err := tx.Where("conversation_id = ?", merge.SourceID).Delete(&models.ConversationMember{}).Error; err != nil {
+			return err
+		}
+		if _, err := syncSummaries(tx, []uuid.UUID{merge.SourceID}); err != nil {
+			return err
+		}
+
+		if merge.MovedHistory && source.LastSeq > 0 {
+			// Bumping the target's sequence locks it against new messages
+			// numbered in between.
+			var target models.Conversation
+			err := tx.Model(&target).
+				Clauses(clause.Returning{Columns: []clause.Column{{Name: "last_seq"}}}).
+				Where("id = ?", merge.TargetID).
+				Update("last_seq", gorm.Expr("last_seq + ?", source.LastSeq)).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Unscoped().Model(&models.Message{}).
+				Where("conversation_id = ?", merge.SourceID).
+				Pluck("id", &movedIDs).Error
+			if err != nil {
+				return err
+			}
+			moved := tx.Unscoped().Model(&models.Message{}).
+				Where("conversation_id = ?", merge.SourceID).
+				Updates(map[string]any{
+					"conversation_id": merge.TargetID,
+					"seq":             gorm.Expr("seq + ?", target.LastSeq-source.LastSeq),
+				})
+			if moved.Error != nil {
+				return moved.Error
+			}
+			merge.MovedMessages = moved.RowsAffected
+			err = tx.Model(&models.MessageArchiveSegment{}).
+				Where("conversation_id = ?", merge.SourceID).
+				Update("conversation_id", merge.TargetID).Error
+			if err != nil {
+				return err
+			}
+			if source.LastMessageAt != nil {
+				err := tx.Model(&models.Conversation{}).
+					Where("id = ? AND (last_message_at IS NULL OR last_message_at < ?)", merge.TargetID, *source.LastMessageAt).
+					Update("last_message_at", *source.LastMessageAt).Error
+				if err != nil {
+					return err
+				}
+			}
+			if _, err := refreshLastMessages(tx, []uuid.UUID{merge.TargetID}); err != nil {
+				return err
+			}
+			if err := recountUnread(tx, merge.TargetID); err != nil {
+				return err
+			}
+		}
+
+		var targetChannels int64
+		if err := tx.Model(&models.Channel{}).Where("conversation_id = ?", merge.TargetID).Count(&targetChannels).Error; err != nil {
+			return err
+		}
+		if targetChannels > 0 {
+			err := tx.Model(&models.WelcomeRoom{}).
+				Where("channel_id = ? AND country_code NOT IN (?)", merge.SourceID,
+					tx.Model(&models.WelcomeRoom{}).Select("country_code").Where("channel_id = ?", merge.TargetID)).
+				Update("channel_id", merge.TargetID).Error
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("channel_id = ?", merge.SourceID).Delete(&models.WelcomeRoom{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("channel_id = ?", merge.SourceID).Delete(&models.ChannelWaitlistEntry{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.Channel{}, "conversation_id = ?", merge.SourceID).Error; err != nil {
+			return err
+		}
+
+		// Rooms merged into the source before now lead to the target.
+		err = tx.Model(&models.RoomMerge{}).
+			Where("target_id = ?", merge.SourceID).
+			Update("target_id", merge.TargetID).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(merge).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge rooms: %w", err)
+	}
+	return movedIDs, nil
+}
+
+// Redirect returns the room a merged room went into, or ErrNotFound when
+// the room was never merged.
+func (r *RoomMergeRepository) Redirect(ctx context.Context, sourceID uuid.UUID) (uuid.UUID, error) {
+	var merge models.RoomMerge
+	err := r.db.WithContext(ctx).Select("target_id").First(&merge, "source_id = ?", sourceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return uuid.Nil, ErrNotFound
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to load room merge: %w", err)
+	}
+	return merge.TargetID, nil
+}
@@ -0,0 +1,81 @@
+package repositories_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+)
+
+func TestMergeRenumbersMovedHistory(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	owner := createUser(t, db, "owner")
+	member := createUser(t, db, "member")
+	target := createRoom(t, db, owner.ID)
+	source := createRoom(t, db, owner.ID, member.ID)
+	messages := repositories.NewMessageRepository(db.DB)
+
+	post := func(room *models.Conversation, sender *models.User, text string) *models.Message {
+		t.Helper()
+		message := &models.Message{ConversationID: room.ID, SenderID: sender.ID, Text: text}
+		if _, err := messages.Create(ctx, message, nil); err != nil {
+			t.Fatal(err)
+		}
+		return message
+	}
+	post(target, owner, "t1")
+	post(target, owner, "t2")
+	s1 := post(source, owner, "s1")
+	s2 := post(source, member, "s2")
+	s3 := post(source, member, "s3")
+	if err := db.DB.Delete(s2).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	merge := &models.RoomMerge{SourceID: source.ID, TargetID: target.ID, MergedByID: &owner.ID, MovedHistory: true}
+	movedIDs, err := repositories.NewRoomMergeRepository(db.DB).Merge(ctx, merge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movedIDs) != 3 || merge.MovedMessages != 3 || merge.MovedMembers != 1 {
+		t.Errorf("merge moved %d messages (%d IDs) and %d members, want 3 and 1", merge.MovedMessages, len(movedIDs), merge.MovedMembers)
+	}
+
+	var history []models.Message
+	if err := db.DB.Unscoped().Where("conversation_id = ?", target.ID).Order("seq").Find(&history).Error; err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"t1", "t2", "s1", "s2", "s3"}
+	if len(history) != len(want) {
+		t.Fatalf("target holds %d messages, want %d", len(history), len(want))
+	}
+	for i, message := range history {
+		if message.Seq != int64(i+1) || message.Text != want[i] {
+			t.Errorf("message %d is %q #%d, want %q #%d", i, message.Text, message.Seq, want[i], i+1)
+		}
+	}
+	if history[2].ID != s1.ID || history[4].ID != s3.ID || !history[3].DeletedAt.Valid {
+		t.Error("moved messages lost their IDs or tombstones")
+	}
+
+	next := &models.Message{ConversationID: target.ID, SenderID: member.ID, Text: "after"}
+	if _, err := messages.Create(ctx, next, nil); err != nil {
+		t.Fatal(err)
+	}
+	if next.Seq != 6 {
+		t.Errorf("first message after the merge is #%d, want #6", next.Seq)
+	}
+
+	rooms := repositories.NewRoomMergeRepository(db.DB)
+	if redirect, err := rooms.Redirect(ctx, source.ID); err != nil || redirect != target.ID {
+		t.Errorf("Redirect returned %s, %v, want the target", redirect, err)
+	}
+	if _, err := rooms.Merge(ctx, &models.RoomMerge{SourceID: source.ID, TargetID: target.ID}); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("merging the source again returned %v, want ErrNotFound", err)
+	}
+}
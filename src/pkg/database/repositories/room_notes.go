@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/ot"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxRoomNoteLag is how many versions behind an edit may be. Clients
+// further behind reload the notes rather than have the server transform
+// their edit past a long history.
+const maxRoomNoteLag = 500
+
+type RoomNoteRepository struct {
+	db *gorm.DB
+}
+
+func NewRoomNoteRepository(db *gorm.DB) *RoomNoteRepository {
+	return &RoomNoteRepository{db: db}
+}
+
+// Get returns a room's notes, which are empty at version 0 until first
+// edited.
+func (r *RoomNoteRepository) Get(ctx context.Context, conversationID uuid.UUID) (*models.RoomNote, error) {
+	note := models.RoomNote{ConversationID: conversationID}
+	err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).First(&note).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to load room notes: %w", err)
+	}
+	return &note, nil
+}
+
+// Edit applies an operation made against version base of a room's notes.
+// The operation is first transformed past the edits made since base, so
+// concurrent editors keep each other's changes; where two insert at the
+// same place, the earlier edit's text comes first. It returns the notes
+// and the edit as applied, which is nil when the operation changed
+// nothing. It returns ErrStaleVersion when base is unknown or too old,
+// ErrTooLong when the notes would exceed maxLength characters, and an
+// error wrapping ot.ErrInvalid when the operation does not fit the notes.
+func (r *RoomNoteRepository) Edit(ctx context.Context, conversationID, authorID uuid.UUID, base int64, op ot.Operation, maxLength int) (*models.RoomNote, *models.RoomNoteEdit, error) {
+	var note models.RoomNote
+	var edit *models.RoomNoteEdit
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Rooms get their notes on first edit. Locking them keeps edits in
+		// version order while admins edit at once.
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RoomNote{ConversationID: conversationID}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("conversation_id = ?", conversationID).
+			First(&note).Error
+		if err != nil {
+			return err
+		}
+		if base > note.Version || note.Version-base > maxRoomNoteLag {
+			return ErrStaleVersion
+		}
+
+		var since []models.RoomNoteEdit
+		err = tx.Where("conversation_id = ? AND version > ?", conversationID, base).
+			Order("version").
+			Find(&since).Error
+		if err != nil {
+			return err
+		}
+		for _, prior := range since {
+			var priorOp ot.Operation
+			if err := json.Unmarshal(prior.Operation, &priorOp); err != nil {
+				return fmt.Errorf("failed to decode room note edit %d: %w", prior.Version, err)
+			}
+			if _, op, err = ot.Transform(priorOp, op); err != nil {
+				return err
+			}
+		}
+
+		text, err := op.Apply(note.Text)
+		if err != nil {
+			return err
+		}
+		if op.Noop() {
+			return nil
+		}
+		if utf8.RuneCountInString(text) > maxLength {
+			return ErrTooLong
+		}
+		encoded, err := json.Marshal(op)
+		if err != nil {
+			return err
+		}
+
+		note.Text = text
+		note.Version++
+		note.UpdatedByID = &authorID
+		if err := tx.Save(&note).Error; err != nil {
+			return err
+		}
+		edit = &models.RoomNoteEdit{
+			ConversationID: conversationID,
+			Version:        note.Version,
+			AuthorID:       &authorID,
+			Operation:      models.JSON(encoded),
+			CreatedAt:      time.Now(),
+		}
+		return tx.Create(edit).Error
+	})
+	if errors.Is(err, ErrStaleVersion) || errors.Is(err, ErrTooLong) || errors.Is(err, ot.ErrInvalid) {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to edit room notes: %w", err)
+	}
+	return &note, edit, nil
+}
+
+// EditsSince returns up to limit edits to a room's notes after version
+// since, oldest first, for clients catching up on edits they missed.
+func (r *RoomNoteRepository) EditsSince(ctx context.Context, conversationID uuid.UUID, since int64, limit int) ([]models.RoomNoteEdit, error) {
+	var edits []models.RoomNoteEdit
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND version > ?", conversationID, since).
+		Order("version").
+		Limit(limit).
+		Find(&edits).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list room note edits: %w", err)
+	}
+	return edits, nil
+}
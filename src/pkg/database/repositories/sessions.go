@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Create stores a new session.
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	if err := r.db.WithContext(ctx).Create(session).Error; err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+	return nil
+}
+
+// Rotate exchanges the refresh token hashed as presentedHash for newHash,
+// extending the session to expiresAt and recording the device it was used
+// from. It returns ErrNotFound for unknown, expired or revoked tokens, and
+// ErrTokenReused, after revoking the session, for a token already rotated.
+func (r *SessionRepository) Rotate(ctx context.Context, presentedHash, newHash string, expiresAt time.Time, device models.SessionDevice) (*models.Session, error) {
+	now := time.Now()
+	var session models.Session
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("refresh_token_hash = ? AND revoked_at IS NULL AND expires_at > ?", presentedHash, now).
+			First(&session).Error
+		if err != nil {
+			return err
+		}
+
+		session.PreviousTokenHash = session.RefreshTokenHash
+		session.RefreshTokenHash = newHash
+		session.SessionDevice = device
+		session.LastUsedAt = now
+		session.ExpiresAt = expiresAt
+		return tx.Save(&session).Error
+	})
+	if err == nil {
+		return &session, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	revoked := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("previous_token_hash = ? AND revoked_at IS NULL", presentedHash).
+		Update("revoked_at", now)
+	if revoked.Error != nil {
+		return nil, fmt.Errorf("failed to revoke reused session: %w", revoked.Error)
+	}
+	if revoked.RowsAffected > 0 {
+		return nil, ErrTokenReused
+	}
+	return nil, ErrNotFound
+}
+
+// IsActive reports whether the user's session exists and is neither revoked
+// nor expired.
+func (r *SessionRepository) IsActive(ctx context.Context, userID, id uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > ?", id, userID, time.Now()).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ListActive returns the user's usable sessions, most recently used first.
+func (r *SessionRepository) ListActive(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// Revoke ends one of the user's sessions. It returns ErrNotFound when the
+// session does not exist or was already revoked.
+func (r *SessionRepository) Revoke(ctx context.Context, userID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke session: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAll ends every session of the user except keep, which may be
+// uuid.Nil, and returns how many were revoked.
+func (r *SessionRepository) RevokeAll(ctx context.Context, userID, keep uuid.UUID) (int64, error) {
+	result := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
+
+// PurgeEnded deletes sessions that expired or were revoked before before,
+// along with their devices, returning how many it deleted.
+func (r *SessionRepository) PurgeEnded(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("expires_at < ? OR revoked_at < ?", before, before).
+		Delete(&models.Session{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge sessions: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
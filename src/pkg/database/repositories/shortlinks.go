@@ -0,0 +1,146 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// codeAttempts is how many fresh codes Create tries before giving up. With
+// 62^8 codes a second attempt is already unlikely.
+const codeAttempts = 5
+
+type ShortLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewShortLinkRepository(db *gorm.DB) *ShortLinkRepository {
+	return &ShortLinkRepository{db: db}
+}
+
+// Create gives a link a fresh code and stores it.
+func (r *ShortLinkRepository) Create(ctx context.Context, link *models.ShortLink) error {
+	for attempt := 0; attempt < codeAttempts; attempt++ {
+		code, err := shortlink.NewCode()
+		if err != nil {
+			return err
+		}
+		link.Code = code
+		err = r.db.WithContext(ctx).Create(link).Error
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create short link: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to create short link: no free code after %d attempts", codeAttempts)
+}
+
+// Resolve returns the link with code, expired and disabled ones included.
+func (r *ShortLinkRepository) Resolve(ctx context.Context, code string) (*models.ShortLink, error) {
+	var link models.ShortLink
+	err := r.db.WithContext(ctx).Where("code = ?", code).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load short link: %w", err)
+	}
+	return &link, nil
+}
+
+// RecordClick counts a follow of a link.
+func (r *ShortLinkRepository) RecordClick(ctx context.Context, click *models.ShortLinkClick) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(click).Error; err != nil {
+			return fmt.Errorf("failed to record click: %w", err)
+		}
+		err := tx.Model(&models.ShortLink{}).Where("id = ?", click.LinkID).Updates(map[string]any{
+			"clicks":          gorm.Expr("clicks + 1"),
+			"last_clicked_at": click.ClickedAt,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to count click: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListByCreator returns up to limit of a user's links created before
+// before, newest first.
+func (r *ShortLinkRepository) ListByCreator(ctx context.Context, userID uuid.UUID, before *pagination.Cursor, limit int) ([]models.ShortLink, error) {
+	query := r.db.WithContext(ctx).Where("created_by_id = ?", userID)
+	if before != nil {
+		query = query.Where("(created_at, id) < (?, ?)", before.Time, before.ID)
+	}
+
+	var links []models.ShortLink
+	if err := query.Order("created_at DESC, id DESC").Limit(limit).Find(&links).Error; err != nil {
+		return nil, fmt.Errorf("failed to list short links: %w", err)
+	}
+	return links, nil
+}
+
+// Disable stops a link from redirecting. It returns ErrNotFound when there
+// is no such link, and leaves an already disabled link's reason alone.
+func (r *ShortLinkRepository) Disable(ctx context.Context, code, reason string) (*models.ShortLink, error) {
+	err := r.db.WithContext(ctx).Model(&models.ShortLink{}).
+		Where("code = ? AND disabled_at IS NULL", code).
+		Updates(map[string]any{"disabled_at": time.Now(), "disabled_reason": reason}).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to disable short link: %w", err)
+	}
+	return r.Resolve(ctx, code)
+}
+
+// DailyClicks is the number of clicks a link had on one day, in UTC.
+type DailyClicks struct {
+	Day    time.Time `json:"day"`
+	Clicks int64     `json:"clicks"`
+}
+
+// PlatformClicks is the number of clicks a link had from one platform.
+type PlatformClicks struct {
+	Platform string `json:"platform"`
+	Clicks   int64  `json:"clicks"`
+}
+
+// ClickStats breaks down a link's clicks since a time.
+type ClickStats struct {
+	Daily     []DailyClicks    `json:"daily"`
+	Platforms []PlatformClicks `json:"platforms"`
+}
+
+// Stats counts a link's clicks since a time by day and by platform.
+func (r *ShortLinkRepository) Stats(ctx context.Context, linkID uuid.UUID, since time.Time) (*ClickStats, error) {
+	clicks := r.db.WithContext(ctx).Model(&models.ShortLinkClick{}).
+		Where("link_id = ? AND clicked_at >= ?", linkID, since)
+
+	stats := ClickStats{Daily: []DailyClicks{}, Platforms: []PlatformClicks{}}
+	err := clicks.Session(&gorm.Session{}).
+		Select("date_trunc('day', clicked_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS clicks").
+		Group("day").
+		Order("day").
+		Scan(&stats.Daily).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count clicks by day: %w", err)
+	}
+	err = clicks.Session(&gorm.Session{}).
+		Select("platform, COUNT(*) AS clicks").
+		Group("platform").
+		Order("clicks DESC, platform").
+		Scan(&stats.Platforms).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count clicks by platform: %w", err)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,106 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/strikes"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type StrikeRepository struct {
+	db *gorm.DB
+}
+
+func NewStrikeRepository(db *gorm.DB) *StrikeRepository {
+	return &StrikeRepository{db: db}
+}
+
+// Issue records a strike against an account, with a warning in the
+// moderation log, expiring after the policy's TTL. When the strikes still
+// counting reach a step of the policy the account is suspended for it too,
+// unless it is already suspended for longer. It returns the suspension, if
+// any, how many strikes count, and ErrNotFound when the account does not
+// exist.
+func (r *StrikeRepository) Issue(ctx context.Context, strike *models.Strike, policy strikes.Policy) (*models.ModerationAction, int, error) {
+	var suspension *models.ModerationAction
+	var active int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Strikes issued together are counted one after the other.
+		var user models.User
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "is_suspended", "suspended_until").
+			First(&user, "id = ?", strike.UserID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load account: %w", err)
+		}
+
+		now := time.Now()
+		warning := models.ModerationAction{
+			UserID:  strike.UserID,
+			ActorID: strike.ActorID,
+			Action:  models.ModerationWarn,
+			Reason:  strike.Reason,
+		}
+		if err := applyModeration(tx, &warning); err != nil {
+			return err
+		}
+		strike.ExpiresAt = now.Add(policy.TTL)
+		if err := tx.Create(strike).Error; err != nil {
+			return fmt.Errorf("failed to record strike: %w", err)
+		}
+		err = tx.Model(&models.Strike{}).
+			Where("user_id = ? AND expires_at > ?", strike.UserID, now).
+			Count(&active).Error
+		if err != nil {
+			return fmt.Errorf("failed to count strikes: %w", err)
+		}
+
+		length, ok := policy.Escalation(int(active))
+		if !ok {
+			return nil
+		}
+		until := now.Add(length)
+		if user.IsSuspended && (user.SuspendedUntil == nil || user.SuspendedUntil.After(until)) {
+			return nil
+		}
+		suspension = &models.ModerationAction{
+			UserID:  strike.UserID,
+			ActorID: strike.ActorID,
+			Action:  models.ModerationSuspend,
+			Reason:  fmt.Sprintf("reached %d strikes", active),
+			Until:   &until,
+		}
+		if err := applyModeration(tx, suspension); err != nil {
+			return err
+		}
+		strike.SuspensionID = &suspension.ID
+		return tx.Model(strike).Update("suspension_id", suspension.ID).Error
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return suspension, int(active), nil
+}
+
+// List returns every strike issued an account, newest first, expired or
+// not.
+func (r *StrikeRepository) List(ctx context.Context, userID uuid.UUID) ([]models.Strike, error) {
+	var issued []models.Strike
+	err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Find(&issued).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list strikes: %w", err)
+	}
+	return issued, nil
+}
@@ -0,0 +1,48 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type SettingsSuggestionRepository struct {
+	db *gorm.DB
+}
+
+func NewSettingsSuggestionRepository(db *gorm.DB) *SettingsSuggestionRepository {
+	return &SettingsSuggestionRepository{db: db}
+}
+
+// Create stores a new user's suggestions.
+func (r *SettingsSuggestionRepository) Create(ctx context.Context, suggestion *models.SettingsSuggestion) error {
+	if err := r.db.WithContext(ctx).Omit("User").Create(suggestion).Error; err != nil {
+		return fmt.Errorf("failed to store settings suggestions: %w", err)
+	}
+	return nil
+}
+
+// Get returns a user's suggestions, or ErrNotFound when there are none.
+func (r *SettingsSuggestionRepository) Get(ctx context.Context, userID uuid.UUID) (*models.SettingsSuggestion, error) {
+	var suggestion models.SettingsSuggestion
+	err := r.db.WithContext(ctx).First(&suggestion, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings suggestions: %w", err)
+	}
+	return &suggestion, nil
+}
+
+// Delete dismisses a user's suggestions.
+func (r *SettingsSuggestionRepository) Delete(ctx context.Context, userID uuid.UUID) error {
+	if err := r.db.WithContext(ctx).Delete(&models.SettingsSuggestion{}, "user_id = ?", userID).Error; err != nil {
+		return fmt.Errorf("failed to dismiss settings suggestions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// SyncGap is a conversation with messages a device has not acknowledged:
+// those numbered after AckedSeq, through LastSeq.
+type SyncGap struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	AckedSeq       int64     `json:"acked_seq"`
+	LastSeq        int64     `json:"last_seq"`
+}
+
+type SyncAckRepository struct {
+	db *gorm.DB
+}
+
+func NewSyncAckRepository(db *gorm.DB) *SyncAckRepository {
+	return &SyncAckRepository{db: db}
+}
+
+// Ack records that the session's device has every message up to each
+// ack's Seq. Acks never move backwards or past the conversation's latest
+// message, and those for conversations userID does not belong to are
+// ignored.
+func (r *SyncAckRepository) Ack(ctx context.Context, sessionID, userID uuid.UUID, acks []models.SyncAck) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, ack := range acks {
+			err := tx.Exec(`INSERT INTO sync_acks (session_id, conversation_id, seq, updated_at)
+				SELECT ?, conversations.id, LEAST(?, conversations.last_seq), NOW()
+				FROM conversations
+				JOIN conversation_members ON conversation_members.conversation_id = conversations.id
+					AND conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL
+				WHERE conversations.id = ? AND conversations.deleted_at IS NULL
+				ON CONFLICT (session_id, conversation_id) DO UPDATE
+				SET seq = EXCLUDED.seq, updated_at = EXCLUDED.updated_at
+				WHERE sync_acks.seq < EXCLUDED.seq`,
+				sessionID, ack.Seq, userID, ack.ConversationID).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record sync acks: %w", err)
+	}
+	return nil
+}
+
+// Behind returns the conversations in a workspace with messages after the
+// session's acks, by conversation ID. Conversations the device never
+// acknowledged are left to the initial sync.
+func (r *SyncAckRepository) Behind(ctx context.Context, workspaceID, userID, sessionID uuid.UUID) ([]SyncGap, error) {
+	var gaps []SyncGap
+	err := r.db.WithContext(ctx).
+		Table("sync_acks").
+		Select("sync_acks.conversation_id, sync_acks.seq AS acked_seq, conversations.last_seq").
+		Joins("JOIN conversations ON conversations.id = sync_acks.conversation_id AND conversations.deleted_at IS NULL").
+		Joins("JOIN conversation_members ON conversation_members.conversation_id = conversations.id AND conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL", userID).
+		Where("sync_acks.session_id = ? AND conversations.workspace_id = ?", sessionID, workspaceID).
+		Where("conversations.last_seq > sync_acks.seq").
+		Order("sync_acks.conversation_id").
+		Scan(&gaps).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unacknowledged conversations: %w", err)
+	}
+	return gaps, nil
+}
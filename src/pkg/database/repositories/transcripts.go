@@ -0,0 +1,132 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TranscriptRepository struct {
+	db *gorm.DB
+}
+
+func NewTranscriptRepository(db *gorm.DB) *TranscriptRepository {
+	return &TranscriptRepository{db: db}
+}
+
+// Create stores a new pending transcript.
+func (r *TranscriptRepository) Create(ctx context.Context, transcript *models.Transcript) error {
+	transcript.Status = models.TranscriptPending
+	if err := r.db.WithContext(ctx).Create(transcript).Error; err != nil {
+		return fmt.Errorf("failed to create transcript: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the user's transcript of the conversation still being
+// rendered, or ErrNotFound.
+func (r *TranscriptRepository) Pending(ctx context.Context, conversationID, userID uuid.UUID) (*models.Transcript, error) {
+	var transcript models.Transcript
+	err := r.db.WithContext(ctx).
+		Where("conversation_id = ? AND user_id = ? AND status = ?", conversationID, userID, models.TranscriptPending).
+		First(&transcript).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transcript: %w", err)
+	}
+	return &transcript, nil
+}
+
+// Get loads a transcript by ID alone, for the job rendering it.
+func (r *TranscriptRepository) Get(ctx context.Context, id uuid.UUID) (*models.Transcript, error) {
+	var transcript models.Transcript
+	err := r.db.WithContext(ctx).First(&transcript, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transcript: %w", err)
+	}
+	return &transcript, nil
+}
+
+// GetForUser loads one of the user's transcripts of the conversation.
+func (r *TranscriptRepository) GetForUser(ctx context.Context, conversationID, userID, id uuid.UUID) (*models.Transcript, error) {
+	var transcript models.Transcript
+	err := r.db.WithContext(ctx).
+		Where("id = ? AND conversation_id = ? AND user_id = ?", id, conversationID, userID).
+		First(&transcript).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load transcript: %w", err)
+	}
+	return &transcript, nil
+}
+
+// MarkReady records a rendered transcript and removes the user's earlier
+// transcripts of the conversation, returning them for the caller to clean
+// up after.
+func (r *TranscriptRepository) MarkReady(ctx context.Context, transcript *models.Transcript, storageKey string, sizeBytes int64, messages int, ttl time.Duration) ([]models.Transcript, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	var pruned []models.Transcript
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(transcript).Updates(map[string]any{
+			"status":       models.TranscriptReady,
+			"storage_key":  storageKey,
+			"size_bytes":   sizeBytes,
+			"messages":     messages,
+			"expires_at":   expires,
+			"completed_at": now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Clauses(clause.Returning{}).
+			Where("conversation_id = ? AND user_id = ? AND id <> ? AND status <> ?",
+				transcript.ConversationID, transcript.UserID, transcript.ID, models.TranscriptPending).
+			Delete(&pruned).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete transcript: %w", err)
+	}
+	transcript.Status, transcript.StorageKey, transcript.SizeBytes, transcript.Messages = models.TranscriptReady, &storageKey, sizeBytes, messages
+	transcript.ExpiresAt, transcript.CompletedAt = &expires, &now
+	return pruned, nil
+}
+
+// MarkFailed records that a transcript could not be rendered.
+func (r *TranscriptRepository) MarkFailed(ctx context.Context, id uuid.UUID) error {
+	err := r.db.WithContext(ctx).Model(&models.Transcript{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"status": models.TranscriptFailed, "completed_at": time.Now()}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record transcript failure: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired deletes ready transcripts that expired and failed ones
+// that finished before failedBefore, returning them for the caller to
+// clean up after.
+func (r *TranscriptRepository) PurgeExpired(ctx context.Context, failedBefore time.Time) ([]models.Transcript, error) {
+	var purged []models.Transcript
+	err := r.db.WithContext(ctx).Clauses(clause.Returning{}).
+		Where("(status = ? AND expires_at <= ?) OR (status = ? AND completed_at < ?)",
+			models.TranscriptReady, time.Now(), models.TranscriptFailed, failedBefore).
+		Delete(&purged).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge transcripts: %w", err)
+	}
+	return purged, nil
+}
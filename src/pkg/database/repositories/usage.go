@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type UsageRepository struct {
+	db *gorm.DB
+}
+
+func NewUsageRepository(db *gorm.DB) *UsageRepository {
+	return &UsageRepository{db: db}
+}
+
+// StoredBytes returns the bytes each account stores in ready attachments
+// and chat backups. Accounts storing nothing are left out.
+func (r *UsageRepository) StoredBytes(ctx context.Context) (map[uuid.UUID]int64, error) {
+	var stored []struct {
+		UserID uuid.UUID
+		Bytes  int64
+	}
+	err := r.db.WithContext(ctx).Model(&models.Attachment{}).
+		Select("uploader_id AS user_id, SUM(size_bytes) AS bytes").
+		Where("status = ?", models.AttachmentReady).
+		Group("uploader_id").
+		Scan(&stored).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum attachments: %w", err)
+	}
+	var backups []struct {
+		UserID uuid.UUID
+		Bytes  int64
+	}
+	err = r.db.WithContext(ctx).Model(&models.Backup{}).
+		Select("user_id, SUM(size_bytes) AS bytes").
+		Where("status = ?", models.BackupReady).
+		Group("user_id").
+		Scan(&backups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum backups: %w", err)
+	}
+	totals := make(map[uuid.UUID]int64, len(stored))
+	for _, s := range append(stored, backups...) {
+		if s.Bytes > 0 {
+			totals[s.UserID] += s.Bytes
+		}
+	}
+	return totals, nil
+}
+
+// Measure sets the quantities of the day's records, replacing earlier
+// measurements of that day.
+func (r *UsageRepository) Measure(ctx context.Context, records []models.UsageRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "meter"}, {Name: "day"}},
+		DoUpdates: clause.AssignmentColumns([]string{"quantity", "updated_at"}),
+	}).Omit("User").CreateInBatches(records, 500).Error
+	if err != nil {
+		return fmt.Errorf("failed to record usage: %w", err)
+	}
+	return nil
+}
+
+// Unexported returns up to limit records of days before the day of
+// before that were not exported yet, oldest first.
+func (r *UsageRepository) Unexported(ctx context.Context, before time.Time, limit int) ([]models.UsageRecord, error) {
+	var records []models.UsageRecord
+	err := r.db.WithContext(ctx).
+		Where("exported_at IS NULL AND day < ?", before.UTC().Format(time.DateOnly)).
+		Order("day, user_id, meter").
+		Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+	return records, nil
+}
+
+// MarkExported records that the records were exported at the time.
+func (r *UsageRepository) MarkExported(ctx context.Context, records []models.UsageRecord, at time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, record := range records {
+			err := tx.Model(&models.UsageRecord{}).
+				Where("user_id = ? AND meter = ? AND day = ?", record.UserID, record.Meter, record.Day).
+				Update("exported_at", at).Error
+			if err != nil {
+				return fmt.Errorf("failed to mark usage exported: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// List returns the account's usage from the day of since on, oldest
+// first.
+func (r *UsageRepository) List(ctx context.Context, userID uuid.UUID, since time.Time) ([]models.UsageRecord, error) {
+	records := []models.UsageRecord{}
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND day >= ?", userID, since.UTC().Format(time.DateOnly)).
+		Order("day, meter").
+		Find(&records).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list usage: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,54 @@
+package repositories_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+)
+
+func TestUsageExport(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	user := createUser(t, db, "user")
+
+	usage := repositories.NewUsageRepository(db.DB)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	measure := func(day time.Time, quantity int64) {
+		t.Helper()
+		record := models.UsageRecord{UserID: user.ID, Meter: models.UsageStorageBytes, Day: day.Format(time.DateOnly), Quantity: quantity, UpdatedAt: day}
+		if err := usage.Measure(ctx, []models.UsageRecord{record}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	yesterday := now.AddDate(0, 0, -1)
+	measure(yesterday, 100)
+	measure(yesterday, 250)
+	measure(now, 300)
+
+	pending, err := usage.Unexported(ctx, now, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Quantity != 250 {
+		t.Fatalf("pending %+v, want yesterday's last measurement alone", pending)
+	}
+	if err := usage.MarkExported(ctx, pending, now); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err = usage.Unexported(ctx, now, 10); err != nil || len(pending) != 0 {
+		t.Fatalf("pending %d after export (err %v), want none", len(pending), err)
+	}
+
+	records, err := usage.List(ctx, user.ID, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ExportedAt == nil || records[1].ExportedAt != nil {
+		t.Fatalf("records %+v, want yesterday's exported and today's not", records)
+	}
+}
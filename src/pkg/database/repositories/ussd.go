@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type USSDSessionRepository struct {
+	db *gorm.DB
+}
+
+func NewUSSDSessionRepository(db *gorm.DB) *USSDSessionRepository {
+	return &USSDSessionRepository{db: db}
+}
+
+// Account returns the user who turned on USSD access for the phone
+// number, in E.164. It returns ErrNotFound unless exactly one account
+// qualifies, so a number entered on two accounts reaches neither.
+func (r *USSDSessionRepository) Account(ctx context.Context, phoneE164 string) (*models.User, error) {
+	var users []models.User
+	err := r.db.WithContext(ctx).
+		Where("phone_e164 = ? AND ussd_access = ?", phoneE164, true).
+		Limit(2).
+		Find(&users).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load USSD account: %w", err)
+	}
+	if len(users) != 1 {
+		return nil, ErrNotFound
+	}
+	return &users[0], nil
+}
+
+// Save stores the conversations a session listed, replacing any it listed
+// before, until ttl from now.
+func (r *USSDSessionRepository) Save(ctx context.Context, id string, userID uuid.UUID, conversationIDs []uuid.UUID, ttl time.Duration) error {
+	listed, err := json.Marshal(conversationIDs)
+	if err != nil {
+		return err
+	}
+	session := models.USSDSession{ID: id, UserID: userID, ConversationIDs: listed, ExpiresAt: time.Now().Add(ttl)}
+	err = r.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"conversation_ids", "expires_at"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.Column{Table: "ussd_sessions", Name: "user_id"}, Value: userID}}},
+	}).Create(&session).Error
+	if err != nil {
+		return fmt.Errorf("failed to save USSD session: %w", err)
+	}
+	return nil
+}
+
+// Listed returns the conversations the user's unexpired session listed,
+// or ErrNotFound.
+func (r *USSDSessionRepository) Listed(ctx context.Context, id string, userID uuid.UUID) ([]uuid.UUID, error) {
+	var session models.USSDSession
+	err := r.db.WithContext(ctx).
+		Where("id = ? AND user_id = ? AND expires_at > ?", id, userID, time.Now()).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load USSD session: %w", err)
+	}
+	var ids []uuid.UUID
+	if err := json.Unmarshal(session.ConversationIDs, &ids); err != nil {
+		return nil, fmt.Errorf("failed to decode USSD session: %w", err)
+	}
+	return ids, nil
+}
+
+// PurgeExpired deletes sessions that expired before before, returning how
+// many it deleted.
+func (r *USSDSessionRepository) PurgeExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("expires_at < ?", before).Delete(&models.USSDSession{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge USSD sessions: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type VerificationTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewVerificationTokenRepository(db *gorm.DB) *VerificationTokenRepository {
+	return &VerificationTokenRepository{db: db}
+}
+
+// Replace stores a new token and expires any unused tokens the user holds
+// for the same purpose, so only the most recently emailed link works.
+func (r *VerificationTokenRepository) Replace(ctx context.Context, token *models.VerificationToken) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.VerificationToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", token.UserID, token.Purpose, time.Now()).
+			Update("expires_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+	return nil
+}
+
+// Consume marks the unused, unexpired token with the given hash as used and
+// runs apply in the same transaction, so the token is spent only if what it
+// authorizes succeeds. It returns ErrNotFound when no such token exists.
+func (r *VerificationTokenRepository) Consume(ctx context.Context, purpose, hash string, apply func(tx *gorm.DB, token *models.VerificationToken) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		var token models.VerificationToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, now).
+			First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load verification token: %w", err)
+		}
+
+		if err := tx.Model(&token).Update("used_at", now).Error; err != nil {
+			return fmt.Errorf("failed to use verification token: %w", err)
+		}
+		token.UsedAt = &now
+		return apply(tx, &token)
+	})
+}
+
+// PurgeSpent deletes tokens that expired or were used before before,
+// returning how many it deleted.
+func (r *VerificationTokenRepository) PurgeSpent(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("expires_at < ? OR used_at < ?", before, before).
+		Delete(&models.VerificationToken{})
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to purge verification tokens: %w", result.Error)
+	}
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,83 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ViewOnceTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewViewOnceTokenRepository(db *gorm.DB) *ViewOnceTokenRepository {
+	return &ViewOnceTokenRepository{db: db}
+}
+
+// Issue stores token as the recipient's token for its attachment,
+// replacing an unconsumed one they were issued before, so only the most
+// recent works. It returns ErrConsumed once the recipient has downloaded
+// the attachment.
+func (r *ViewOnceTokenRepository) Issue(ctx context.Context, token *models.ViewOnceToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var existing models.ViewOnceToken
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("attachment_id = ? AND user_id = ?", token.AttachmentID, token.UserID).
+			First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := tx.Create(token).Error; err != nil {
+				return fmt.Errorf("failed to create view-once token: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load view-once token: %w", err)
+		}
+		if existing.ConsumedAt != nil {
+			return ErrConsumed
+		}
+
+		err = tx.Model(&existing).Updates(map[string]any{"token_hash": token.TokenHash, "expires_at": token.ExpiresAt}).Error
+		if err != nil {
+			return fmt.Errorf("failed to replace view-once token: %w", err)
+		}
+		token.ID, token.CreatedAt = existing.ID, existing.CreatedAt
+		return nil
+	})
+}
+
+// Consume marks the recipient's unexpired token for the attachment with
+// the given hash as consumed. It returns ErrNotFound when there is no such
+// token and ErrConsumed when it was already used; the single conditional
+// update keeps two downloads racing with one token from both succeeding.
+func (r *ViewOnceTokenRepository) Consume(ctx context.Context, attachmentID, userID uuid.UUID, hash string) error {
+	db := r.db.WithContext(ctx)
+	now := time.Now()
+	consumed := db.Model(&models.ViewOnceToken{}).
+		Where("attachment_id = ? AND user_id = ? AND token_hash = ? AND consumed_at IS NULL AND expires_at > ?", attachmentID, userID, hash, now).
+		Update("consumed_at", now)
+	if consumed.Error != nil {
+		return fmt.Errorf("failed to consume view-once token: %w", consumed.Error)
+	}
+	if consumed.RowsAffected == 1 {
+		return nil
+	}
+
+	var spent int64
+	err := db.Model(&models.ViewOnceToken{}).
+		Where("attachment_id = ? AND user_id = ? AND consumed_at IS NOT NULL", attachmentID, userID).
+		Count(&spent).Error
+	if err != nil {
+		return fmt.Errorf("failed to load view-once token: %w", err)
+	}
+	if spent > 0 {
+		return ErrConsumed
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,66 @@
+package repositories_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/dbtest"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+func TestViewOnceTokenConsumedOnce(t *testing.T) {
+	t.Parallel()
+	db := dbtest.New(t)
+	ctx := context.Background()
+	sender := createUser(t, db, "sender")
+	recipient := createUser(t, db, "recipient")
+	room := createRoom(t, db, sender.ID, recipient.ID)
+
+	photo := &models.Attachment{UploaderID: sender.ID, Kind: "image", FileName: "photo.jpg", MimeType: "image/jpeg", SizeBytes: 1, StorageKey: "attachments/photo", Status: models.AttachmentReady}
+	document := &models.Attachment{UploaderID: sender.ID, Kind: "document", FileName: "notes.pdf", MimeType: "application/pdf", SizeBytes: 1, StorageKey: "attachments/notes", Status: models.AttachmentReady}
+	for _, attachment := range []*models.Attachment{photo, document} {
+		if err := repositories.NewAttachmentRepository(db.DB).Create(ctx, attachment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	messages := repositories.NewMessageRepository(db.DB)
+	rejected := &models.Message{ConversationID: room.ID, SenderID: sender.ID, ViewOnce: true}
+	if _, err := messages.Create(ctx, rejected, []uuid.UUID{document.ID}); !errors.Is(err, repositories.ErrInvalidAttachment) {
+		t.Fatalf("view-once message with a document returned %v, want ErrInvalidAttachment", err)
+	}
+	message := &models.Message{ConversationID: room.ID, SenderID: sender.ID, ViewOnce: true}
+	if _, err := messages.Create(ctx, message, []uuid.UUID{photo.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Attachments) != 1 || !message.Attachments[0].ViewOnce {
+		t.Fatalf("attachments %+v, want the photo marked view-once", message.Attachments)
+	}
+
+	tokens := repositories.NewViewOnceTokenRepository(db.DB)
+	expires := time.Now().Add(time.Minute)
+	if err := tokens.Issue(ctx, &models.ViewOnceToken{AttachmentID: photo.ID, UserID: recipient.ID, TokenHash: "first", ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Issue(ctx, &models.ViewOnceToken{AttachmentID: photo.ID, UserID: recipient.ID, TokenHash: "second", ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Consume(ctx, photo.ID, recipient.ID, "first"); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("replaced token returned %v, want ErrNotFound", err)
+	}
+	if err := tokens.Consume(ctx, photo.ID, sender.ID, "second"); !errors.Is(err, repositories.ErrNotFound) {
+		t.Errorf("another user's token returned %v, want ErrNotFound", err)
+	}
+	if err := tokens.Consume(ctx, photo.ID, recipient.ID, "second"); err != nil {
+		t.Fatalf("first download returned %v", err)
+	}
+	if err := tokens.Consume(ctx, photo.ID, recipient.ID, "second"); !errors.Is(err, repositories.ErrConsumed) {
+		t.Errorf("second download returned %v, want ErrConsumed", err)
+	}
+	if err := tokens.Issue(ctx, &models.ViewOnceToken{AttachmentID: photo.ID, UserID: recipient.ID, TokenHash: "third", ExpiresAt: expires}); !errors.Is(err, repositories.ErrConsumed) {
+		t.Errorf("new token after viewing returned %v, want ErrConsumed", err)
+	}
+}
@@ -0,0 +1,208 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// maxWebhookError is how much of a failed delivery's error is kept.
+const maxWebhookError = 500
+
+type WebhookRepository struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepository(db *gorm.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+// CreateIncoming stores a new incoming webhook.
+func (r *WebhookRepository) CreateIncoming(ctx context.Context, hook *models.IncomingWebhook) error {
+	if err := r.db.WithContext(ctx).Omit("Conversation", "Bot", "CreatedBy").Create(hook).Error; err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListIncoming returns a channel's incoming webhooks, oldest first.
+func (r *WebhookRepository) ListIncoming(ctx context.Context, conversationID uuid.UUID) ([]models.IncomingWebhook, error) {
+	var hooks []models.IncomingWebhook
+	if err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Order("created_at").Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// DeleteIncoming removes one of a channel's incoming webhooks.
+func (r *WebhookRepository) DeleteIncoming(ctx context.Context, conversationID, id uuid.UUID) error {
+	return r.delete(ctx, &models.IncomingWebhook{}, conversationID, id)
+}
+
+// IncomingByToken returns the incoming webhook whose token has hash,
+// recording that it was used.
+func (r *WebhookRepository) IncomingByToken(ctx context.Context, hash string) (*models.IncomingWebhook, error) {
+	var hook models.IncomingWebhook
+	err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&hook).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook: %w", err)
+	}
+	now := time.Now()
+	if err := r.db.WithContext(ctx).Model(&hook).Update("last_used_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to record webhook use: %w", err)
+	}
+	return &hook, nil
+}
+
+// CreateOutgoing stores a new outgoing webhook.
+func (r *WebhookRepository) CreateOutgoing(ctx context.Context, hook *models.OutgoingWebhook) error {
+	if err := r.db.WithContext(ctx).Omit("Conversation", "CreatedBy").Create(hook).Error; err != nil {
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// ListOutgoing returns a channel's outgoing webhooks, oldest first.
+func (r *WebhookRepository) ListOutgoing(ctx context.Context, conversationID uuid.UUID) ([]models.OutgoingWebhook, error) {
+	var hooks []models.OutgoingWebhook
+	if err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Order("created_at").Find(&hooks).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	return hooks, nil
+}
+
+// GetOutgoing returns an outgoing webhook.
+func (r *WebhookRepository) GetOutgoing(ctx context.Context, id uuid.UUID) (*models.OutgoingWebhook, error) {
+	var hook models.OutgoingWebhook
+	err := r.db.WithContext(ctx).First(&hook, "id = ?", id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load webhook: %w", err)
+	}
+	return &hook, nil
+}
+
+// DeleteOutgoing removes one of a channel's outgoing webhooks.
+func (r *WebhookRepository) DeleteOutgoing(ctx context.Context, conversationID, id uuid.UUID) error {
+	return r.delete(ctx, &models.OutgoingWebhook{}, conversationID, id)
+}
+
+// RecordDelivery records the outcome of delivering to an outgoing
+// webhook: a nil deliveryErr clears the last error.
+func (r *WebhookRepository) RecordDelivery(ctx context.Context, id uuid.UUID, deliveryErr error) error {
+	updates := map[string]any{"last_delivered_at": time.Now(), "last_error": nil}
+	if deliveryErr != nil {
+		message := deliveryErr.Error()
+		if len(message) > maxWebhookError {
+			message = message[:maxWebhookError]
+		}
+		updates = map[string]any{"last_error": message}
+	}
+	if err := r.db.WithContext(ctx).Model(&models.OutgoingWebhook{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// CreateFeed stores a new room feed, failing with ErrLimitReached when
+// the channel already has limit feeds.
+func (r *WebhookRepository) CreateFeed(ctx context.Context, feed *models.RoomFeed, limit int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.RoomFeed{}).Where("conversation_id = ?", feed.ConversationID).Count(&count).Error; err != nil {
+			return fmt.Errorf("failed to count feeds: %w", err)
+		}
+		if count >= int64(limit) {
+			return ErrLimitReached
+		}
+		if err := tx.Omit("Conversation", "Bot", "CreatedBy").Create(feed).Error; err != nil {
+			return fmt.Errorf("failed to create feed: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListFeeds returns a channel's feeds, oldest first.
+func (r *WebhookRepository) ListFeeds(ctx context.Context, conversationID uuid.UUID) ([]models.RoomFeed, error) {
+	feeds := []models.RoomFeed{}
+	if err := r.db.WithContext(ctx).Where("conversation_id = ?", conversationID).Order("created_at").Find(&feeds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list feeds: %w", err)
+	}
+	return feeds, nil
+}
+
+// DeleteFeed removes one of a channel's feeds.
+func (r *WebhookRepository) DeleteFeed(ctx context.Context, conversationID, id uuid.UUID) error {
+	return r.delete(ctx, &models.RoomFeed{}, conversationID, id)
+}
+
+// ClaimDueFeeds returns up to limit feeds not polled since before,
+// longest waiting first, marking them polled at now so other instances
+// leave them be.
+func (r *WebhookRepository) ClaimDueFeeds(ctx context.Context, before, now time.Time, limit int) ([]models.RoomFeed, error) {
+	var due []models.RoomFeed
+	err := r.db.WithContext(ctx).
+		Where("last_polled_at IS NULL OR last_polled_at <= ?", before).
+		Order("last_polled_at NULLS FIRST, created_at").
+		Limit(limit).
+		Find(&due).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list due feeds: %w", err)
+	}
+	claimed := due[:0]
+	for _, feed := range due {
+		query := r.db.WithContext(ctx).Model(&models.RoomFeed{}).Where("id = ?", feed.ID)
+		if feed.LastPolledAt == nil {
+			query = query.Where("last_polled_at IS NULL")
+		} else {
+			query = query.Where("last_polled_at = ?", *feed.LastPolledAt)
+		}
+		result := query.Update("last_polled_at", now)
+		if result.Error != nil {
+			return nil, fmt.Errorf("failed to claim feed: %w", result.Error)
+		}
+		if result.RowsAffected == 1 {
+			feed.LastPolledAt = &now
+			claimed = append(claimed, feed)
+		}
+	}
+	return claimed, nil
+}
+
+// RecordPoll records the outcome of polling a feed: the items it listed,
+// or why it failed, keeping the items seen before.
+func (r *WebhookRepository) RecordPoll(ctx context.Context, id uuid.UUID, seen models.JSON, pollErr error) error {
+	updates := map[string]any{"seen_guids": seen, "last_error": nil}
+	if pollErr != nil {
+		message := pollErr.Error()
+		if len(message) > maxWebhookError {
+			message = message[:maxWebhookError]
+		}
+		updates = map[string]any{"last_error": message}
+	}
+	if err := r.db.WithContext(ctx).Model(&models.RoomFeed{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to record feed poll: %w", err)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) delete(ctx context.Context, model any, conversationID, id uuid.UUID) error {
+	result := r.db.WithContext(ctx).Where("id = ? AND conversation_id = ?", id, conversationID).Delete(model)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete webhook: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrLastWorkspaceOwner means the workspace owner tried to leave or be
+// demoted.
+var ErrLastWorkspaceOwner = errors.New("the workspace owner cannot leave or be demoted")
+
+// WorkspaceWithRole is a workspace together with the requesting user's
+// role.
+type WorkspaceWithRole struct {
+	models.Workspace
+	Role string `json:"role"`
+}
+
+type WorkspaceRepository struct {
+	db *gorm.DB
+}
+
+func NewWorkspaceRepository(db *gorm.DB) *WorkspaceRepository {
+	return &WorkspaceRepository{db: db}
+}
+
+// Create stores a workspace with creatorID as its owner. A taken slug
+// fails with gorm.ErrDuplicatedKey.
+func (r *WorkspaceRepository) Create(ctx context.Context, workspace *models.Workspace, creatorID uuid.UUID) error {
+	workspace.CreatedByID = &creatorID
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      creatorID,
+			Role:        models.MemberRoleOwner,
+			JoinedAt:    time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create workspace: %w", err)
+	}
+	return nil
+}
+
+// GetBySlug loads the workspace with the given slug.
+func (r *WorkspaceRepository) GetBySlug(ctx context.Context, slug string) (*models.Workspace, error) {
+	var workspace models.Workspace
+	err := r.db.WithContext(ctx).First(&workspace, "slug = ?", slug).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workspace: %w", err)
+	}
+	return &workspace, nil
+}
+
+// Member returns userID's membership of the workspace. Every user is a
+// member of the default workspace.
+func (r *WorkspaceRepository) Member(ctx context.Context, workspaceID, userID uuid.UUID) (*models.WorkspaceMember, error) {
+	if workspaceID == models.DefaultWorkspaceID {
+		return &models.WorkspaceMember{WorkspaceID: workspaceID, UserID: userID, Role: models.MemberRoleMember}, nil
+	}
+	var member models.WorkspaceMember
+	err := r.db.WithContext(ctx).First(&member, "workspace_id = ? AND user_id = ?", workspaceID, userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workspace member: %w", err)
+	}
+	return &member, nil
+}
+
+// ListForUser returns the workspaces userID belongs to with their role in
+// each, the default workspace first and the rest by name.
+func (r *WorkspaceRepository) ListForUser(ctx context.Context, userID uuid.UUID) ([]WorkspaceWithRole, error) {
+	var workspaces []WorkspaceWithRole
+	err := r.db.WithContext(ctx).
+		Table("workspaces").
+		Select("workspaces.*, workspace_members.role AS role").
+		Joins("JOIN workspace_members ON workspace_members.workspace_id = workspaces.id").
+		Where("workspace_members.user_id = ?", userID).
+		Order("workspaces.name ASC").
+		Scan(&workspaces).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+
+	var defaultWorkspace models.Workspace
+	if err := r.db.WithContext(ctx).First(&defaultWorkspace, "id = ?", models.DefaultWorkspaceID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load default workspace: %w", err)
+	}
+	return append([]WorkspaceWithRole{{Workspace: defaultWorkspace, Role: models.MemberRoleMember}}, workspaces...), nil
+}
+
+// IDsForUser returns the IDs of the workspaces the user belongs to, the
+// default one first.
+func (r *WorkspaceRepository) IDsForUser(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.WorkspaceMember{}).
+		Where("user_id = ?", userID).
+		Order("workspace_id").
+		Pluck("workspace_id", &ids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspaces: %w", err)
+	}
+	return append([]uuid.UUID{models.DefaultWorkspaceID}, ids...), nil
+}
+
+// Members returns the members of a workspace other than the default one,
+// owner first, then by when they joined, with their users.
+func (r *WorkspaceRepository) Members(ctx context.Context, workspaceID uuid.UUID) ([]models.WorkspaceMember, error) {
+	var members []models.WorkspaceMember
+	err := r.db.WithContext(ctx).
+		Preload("User", withErased).
+		Where("workspace_id = ?", workspaceID).
+		Order(clause.Expr{SQL: "role = ? DESC, joined_at ASC", Vars: []any{models.MemberRoleOwner}}).
+		Find(&members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace members: %w", err)
+	}
+	return members, nil
+}
+
+// AddMember adds userID to the workspace with the given role. Existing
+// members keep theirs.
+func (r *WorkspaceRepository) AddMember(ctx context.Context, workspaceID, userID uuid.UUID, role string) (*models.WorkspaceMember, error) {
+	member := models.WorkspaceMember{WorkspaceID: workspaceID, UserID: userID, Role: role, JoinedAt: time.Now()}
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&member).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to add workspace member: %w", err)
+	}
+	return r.Member(ctx, workspaceID, userID)
+}
+
+// SetRole changes a member's role between admin and member. The owner's
+// role cannot change.
+func (r *WorkspaceRepository) SetRole(ctx context.Context, workspaceID, userID uuid.UUID, role string) error {
+	result := r.db.WithContext(ctx).Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id = ? AND role <> ?", workspaceID, userID, models.MemberRoleOwner).
+		Update("role", role)
+	if result.Error != nil {
+		return fmt.Errorf("failed to update workspace role: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return r.missingOrOwner(ctx, workspaceID, userID)
+	}
+	return nil
+}
+
+// RemoveMember removes userID from the workspace and from every
+// conversation in it, so they keep no access to its rooms. The owner
+// cannot be removed.
+func (r *WorkspaceRepository) RemoveMember(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("workspace_id = ? AND user_id = ? AND role <> ?", workspaceID, userID, models.MemberRoleOwner).
+			Delete(&models.WorkspaceMember{})
+		if result.Error != nil {
+			return fmt.Errorf("failed to remove workspace member: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return r.missingOrOwner(ctx, workspaceID, userID)
+		}
+		var conversationIDs []uuid.UUID
+		err := tx.Model(&models.ConversationMember{}).
+			Where("user_id = ? AND conversation_id IN (?)", userID,
+				tx.Model(&models.Conversation{}).Select("id").Where("workspace_id = ?", workspaceID)).
+			Pluck("conversation_id", &conversationIDs).Error
+		if err == nil && len(conversationIDs) > 0 {
+			err = tx.Where("user_id = ? AND conversation_id IN ?", userID, conversationIDs).Delete(&models.ConversationMember{}).Error
+		}
+		if err == nil && len(conversationIDs) > 0 {
+			err = refreshMemberCounts(tx, conversationIDs)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove member from workspace conversations: %w", err)
+		}
+		return nil
+	})
+	return err
+}
+
+// NonMembers returns those of userIDs who do not belong to the workspace.
+func (r *WorkspaceRepository) NonMembers(ctx context.Context, workspaceID uuid.UUID, userIDs []uuid.UUID) ([]uuid.UUID, error) {
+	if workspaceID == models.DefaultWorkspaceID || len(userIDs) == 0 {
+		return nil, nil
+	}
+	var members []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id IN ?", workspaceID, userIDs).
+		Pluck("user_id", &members).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to check workspace members: %w", err)
+	}
+	isMember := make(map[uuid.UUID]bool, len(members))
+	for _, id := range members {
+		isMember[id] = true
+	}
+	var outsiders []uuid.UUID
+	for _, id := range userIDs {
+		if !isMember[id] {
+			outsiders = append(outsiders, id)
+		}
+	}
+	return outsiders, nil
+}
+
+// SetArchive stores the workspace's archive, replacing any earlier one,
+// and marks every room in the workspace as archived.
+func (r *WorkspaceRepository) SetArchive(ctx context.Context, archive *models.WorkspaceArchive) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "workspace_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"url", "secret", "created_by_id", "last_delivered_at", "last_error", "updated_at"}),
+		}).Create(archive).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Conversation{}).
+			Where("workspace_id = ? AND compliance_archived = ?", archive.WorkspaceID, false).
+			Update("compliance_archived", true).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set workspace archive: %w", err)
+	}
+	return nil
+}
+
+// Archive loads the workspace's archive.
+func (r *WorkspaceRepository) Archive(ctx context.Context, workspaceID uuid.UUID) (*models.WorkspaceArchive, error) {
+	var archive models.WorkspaceArchive
+	err := r.db.WithContext(ctx).First(&archive, "workspace_id = ?", workspaceID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load workspace archive: %w", err)
+	}
+	return &archive, nil
+}
+
+// DeleteArchive stops archiving the workspace's rooms.
+func (r *WorkspaceRepository) DeleteArchive(ctx context.Context, workspaceID uuid.UUID) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("workspace_id = ?", workspaceID).Delete(&models.WorkspaceArchive{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return tx.Model(&models.Conversation{}).
+			Where("workspace_id = ? AND compliance_archived = ?", workspaceID, true).
+			Update("compliance_archived", false).Error
+	})
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete workspace archive: %w", err)
+	}
+	return err
+}
+
+// RecordArchiveDelivery records the outcome of delivering to the
+// workspace's archive: a nil deliveryErr clears the last error.
+func (r *WorkspaceRepository) RecordArchiveDelivery(ctx context.Context, workspaceID uuid.UUID, deliveryErr error) error {
+	updates := map[string]any{"last_delivered_at": time.Now(), "last_error": nil}
+	if deliveryErr != nil {
+		message := deliveryErr.Error()
+		if len(message) > maxWebhookError {
+			message = message[:maxWebhookError]
+		}
+		updates = map[string]any{"last_error": message}
+	}
+	err := r.db.WithContext(ctx).Model(&models.WorkspaceArchive{}).Where("workspace_id = ?", workspaceID).Updates(updates).Error
+	if err != nil {
+		return fmt.Errorf("failed to record archive delivery: %w", err)
+	}
+	return nil
+}
+
+// workspaceArchived reports whether the workspace's rooms are copied to an
+// archive, for new rooms to be marked as such.
+func workspaceArchived(db *gorm.DB, workspaceID uuid.UUID) (bool, error) {
+	if workspaceID == models.DefaultWorkspaceID {
+		return false, nil
+	}
+	var count int64
+	if err := db.Model(&models.WorkspaceArchive{}).Where("workspace_id = ?", workspaceID).Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check workspace archive: %w", err)
+	}
+	return count > 0, nil
+}
+
+func (r *WorkspaceRepository) missingOrOwner(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	member, err := r.Member(ctx, workspaceID, userID)
+	if errors.Is(err, ErrNotFound) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return err
+	}
+	if member.Role == models.MemberRoleOwner {
+		return ErrLastWorkspaceOwner
+	}
+	return ErrNotMember
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Reset deletes every row of the schema's tables and puts back the default
+// workspace, leaving the database as a fresh one. It is for test mode,
+// where end-to-end runs start from a known state; the migrations table is
+// left alone.
+func (c *DatabaseConnection) Reset(ctx context.Context) error {
+	tables := make([]string, 0, len(schemaModels))
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: c.DB}
+		if err := stmt.Parse(model); err != nil {
+			return fmt.Errorf("failed to parse model: %w", err)
+		}
+		tables = append(tables, stmt.Quote(stmt.Schema.Table))
+	}
+
+	return c.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if c.Config.Driver == DriverSQLite {
+			// Checked at commit, by when every table is empty.
+			if err := tx.Exec("PRAGMA defer_foreign_keys = ON").Error; err != nil {
+				return err
+			}
+			for _, table := range tables {
+				if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+					return fmt.Errorf("failed to empty %s: %w", table, err)
+				}
+			}
+		} else if err := tx.Exec("TRUNCATE TABLE " + strings.Join(tables, ", ") + " RESTART IDENTITY CASCADE").Error; err != nil {
+			return fmt.Errorf("failed to empty tables: %w", err)
+		}
+		return createDefaultWorkspace(tx)
+	})
+}
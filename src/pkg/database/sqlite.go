@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// postgresUUIDDefault is the column default Postgres generates UUID keys
+// with. SQLite has no such function, so keys are generated in Go.
+const postgresUUIDDefault = "gen_random_uuid()"
+
+// schemaModels are the tables CreateSQLiteSchema creates.
+var schemaModels = []any{
+	&models.User{}, &models.Session{}, &models.USSDSession{}, &models.VerificationToken{}, &models.EmailChange{}, &models.UserIdentity{},
+	&models.Workspace{}, &models.WorkspaceMember{}, &models.WorkspaceArchive{},
+	&models.Conversation{}, &models.ConversationMember{}, &models.ConversationSummary{}, &models.Channel{}, &models.WelcomeRoom{}, &models.ChannelWaitlistEntry{}, &models.ChannelLinkVisit{},
+	&models.Message{}, &models.MessageClientID{}, &models.MessageArchiveSegment{}, &models.MessageEdit{}, &models.MessageReceipt{}, &models.Attachment{}, &models.ViewOnceToken{},
+	&models.RoomEvent{}, &models.EventRSVP{}, &models.AnnouncementAck{},
+	&models.Contact{}, &models.Block{}, &models.ConversationMute{}, &models.ConversationPin{}, &models.NotificationPreferences{},
+	&models.InboxNotification{}, &models.DeviceToken{}, &models.SyncAck{}, &models.Reminder{}, &models.RoomNote{}, &models.RoomNoteEdit{}, &models.RoomFAQ{},
+	&models.AutoReplyRule{}, &models.QuickReply{}, &models.BusinessProfile{}, &models.CatalogItem{},
+	&models.ConversationLabel{}, &models.ConversationLabeling{},
+	&models.Bot{}, &models.APIKey{}, &models.IncomingWebhook{}, &models.OutgoingWebhook{}, &models.RoomFeed{}, &models.RoomEmailAddress{}, &models.RoomEmailRelay{}, &models.RoomContribution{},
+	&models.AutomationRule{}, &models.AutomationRun{},
+	&models.InviteCode{}, &models.ReferralCode{}, &models.Referral{}, &models.ReferralReward{},
+	&models.WaitlistEntry{}, &models.ShortLink{}, &models.ShortLinkClick{},
+	&models.DeviceKey{}, &models.SignedPreKey{}, &models.OneTimePreKey{}, &models.KeyBackup{}, &models.CrossSigningKey{},
+	&models.LegalDocument{}, &models.LegalAcceptance{}, &models.ModerationAction{},
+	&models.ModerationTemplate{}, &models.ModerationBatch{}, &models.Report{}, &models.Strike{}, &models.Appeal{},
+	&models.OnboardingStep{}, &models.OnboardingProgress{}, &models.ConsentEvent{}, &models.UserBadge{}, &models.ActivityDay{}, &models.UsageRecord{},
+	&models.LegalHold{}, &models.PreservedMessage{}, &models.LegalExport{}, &models.LegalAuditEntry{},
+	&models.IdentitySignal{}, &models.IdentityCluster{}, &models.IdentityClusterMember{},
+	&models.Experiment{}, &models.ExperimentVariant{}, &models.ClientConfigRule{},
+	&models.SettingsSuggestion{}, &models.DataExport{}, &models.Transcript{}, &models.AccountErasure{}, &models.Backup{}, &models.Job{}, &models.ScheduledTask{},
+	&models.RoomExportSchedule{}, &models.RoomExportDelivery{},
+	&models.RoomMerge{},
+}
+
+// CreateSQLiteSchema creates the tables of a SQLite database from the
+// models, with the default workspace. The migrations are written for
+// Postgres and are not run against SQLite, so full-text search and the
+// other Postgres-only features they add are missing.
+func (c *DatabaseConnection) CreateSQLiteSchema() error {
+	if c.Config.Driver != DriverSQLite {
+		return fmt.Errorf("the schema of a %s database comes from its migrations", c.Config.Driver)
+	}
+	for _, model := range schemaModels {
+		stmt := &gorm.Statement{DB: c.DB}
+		if err := stmt.Parse(model); err != nil {
+			return fmt.Errorf("failed to parse model: %w", err)
+		}
+		// The schema is cached per connection, so this only changes
+		// how SQLite tables are made.
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == postgresUUIDDefault {
+				field.HasDefaultValue = false
+				field.DefaultValue = ""
+			}
+		}
+	}
+	if err := c.DB.AutoMigrate(schemaModels...); err != nil {
+		return fmt.Errorf("failed to create schema: %w", err)
+	}
+
+	return createDefaultWorkspace(c.DB)
+}
+
+// createDefaultWorkspace adds the workspace the 0023 migration seeds
+// Postgres with, unless it is there already.
+func createDefaultWorkspace(db *gorm.DB) error {
+	now := time.Now()
+	err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Workspace{
+		ID:        models.DefaultWorkspaceID,
+		Slug:      models.DefaultWorkspaceSlug,
+		Name:      "AfroChat",
+		CreatedAt: now,
+		UpdatedAt: now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to create default workspace: %w", err)
+	}
+	return nil
+}
+
+// uuidKeys is a GORM plugin filling in the UUID keys Postgres would
+// generate, for databases that cannot.
+type uuidKeys struct{}
+
+func (uuidKeys) Name() string {
+	return "uuid_keys"
+}
+
+func (uuidKeys) Initialize(db *gorm.DB) error {
+	return db.Callback().Create().Before("gorm:create").Register("uuid_keys:create", assignUUIDKeys)
+}
+
+func assignUUIDKeys(tx *gorm.DB) {
+	if tx.Statement.Schema == nil {
+		return
+	}
+	// The tag is read rather than the default, which CreateSQLiteSchema
+	// clears.
+	var fields []*schema.Field
+	for _, field := range tx.Statement.Schema.Fields {
+		if field.TagSettings["DEFAULT"] == postgresUUIDDefault {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	assign := func(value reflect.Value) {
+		for _, field := range fields {
+			if _, zero := field.ValueOf(tx.Statement.Context, value); zero {
+				if err := field.Set(tx.Statement.Context, value, uuid.New()); err != nil {
+					tx.AddError(err)
+				}
+			}
+		}
+	}
+	switch value := tx.Statement.ReflectValue; value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			assign(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		assign(value)
+	}
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"gorm.io/gorm"
+)
+
+const querySpanKey = "tracing:span"
+
+// QueryTracer is a GORM plugin recording a span for every database
+// operation, as a child of the span of the context the query runs with.
+// Spans carry the SQL with its placeholders, never the values bound to
+// them.
+type QueryTracer struct{}
+
+func (QueryTracer) Name() string {
+	return "tracing"
+}
+
+func (QueryTracer) Initialize(db *gorm.DB) error {
+	callbacks := db.Callback()
+	registrations := []error{
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startQuerySpan("create")),
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endQuerySpan),
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startQuerySpan("query")),
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endQuerySpan),
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startQuerySpan("update")),
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endQuerySpan),
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuerySpan("delete")),
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endQuerySpan),
+		callbacks.Row().Before("gorm:row").Register("tracing:before_row", startQuerySpan("row")),
+		callbacks.Row().After("gorm:row").Register("tracing:after_row", endQuerySpan),
+		callbacks.Raw().Before("gorm:raw").Register("tracing:before_raw", startQuerySpan("raw")),
+		callbacks.Raw().After("gorm:raw").Register("tracing:after_raw", endQuerySpan),
+	}
+	return errors.Join(registrations...)
+}
+
+func startQuerySpan(operation string) func(*gorm.DB) {
+	return func(tx *gorm.DB) {
+		name := "db " + operation
+		if tx.Statement.Table != "" {
+			name += " " + tx.Statement.Table
+		}
+		_, span := tracing.Start(tx.Statement.Context, name, tracing.KindClient,
+			tracing.String("db.system", "postgresql"),
+			tracing.String("db.operation.name", operation))
+		if span != nil {
+			tx.InstanceSet(querySpanKey, span)
+		}
+	}
+}
+
+func endQuerySpan(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(querySpanKey)
+	if !ok {
+		return
+	}
+	span := value.(*tracing.Span)
+	span.SetAttributes(
+		tracing.String("db.collection.name", tx.Statement.Table),
+		tracing.String("db.query.text", tx.Statement.SQL.String()),
+		tracing.Int("db.rows_affected", tx.Statement.RowsAffected))
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		span.RecordError(tx.Error)
+	}
+	span.End()
+}
@@ -0,0 +1,137 @@
+// Package deeplink makes and reads the share links of messages, rooms and
+// profiles. A link is a web address under the app's public URL, so it
+// opens the web app anywhere and the mobile apps where they claim the
+// address as a universal link. The apps' own afrochat:// scheme takes the
+// same paths.
+//
+//	<app>/c/<conversation id>/<seq>   a message, by its number in the conversation
+//	<app>/join/<channel id>           a room, to view or join
+//	<app>/u/<username>                a profile
+package deeplink
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Scheme is the custom URL scheme of the mobile apps.
+const Scheme = "afrochat"
+
+// What a link leads to
+const (
+	KindMessage = "message"
+	KindRoom    = "room"
+	KindProfile = "profile"
+)
+
+// ErrNotDeepLink means a URL is not one of the app's links.
+var ErrNotDeepLink = errors.New("not an AfroChat link")
+
+// Link is a parsed deep link. ConversationID is set for messages and
+// rooms, Seq for messages and Username for profiles.
+type Link struct {
+	Kind           string
+	ConversationID uuid.UUID
+	Seq            int64
+	Username       string
+}
+
+// Base makes and reads links under the app's public URL.
+type Base struct {
+	url *url.URL
+}
+
+// NewBase returns the links under appURL, an http or https address.
+func NewBase(appURL string) (*Base, error) {
+	parsed, err := url.Parse(appURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("app URL %q must be an http or https address", appURL)
+	}
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/")
+	parsed.RawQuery, parsed.Fragment = "", ""
+	return &Base{url: parsed}, nil
+}
+
+// Message returns the permalink of the message numbered seq in a
+// conversation.
+func (b *Base) Message(conversationID uuid.UUID, seq int64) string {
+	return b.url.String() + "/c/" + conversationID.String() + "/" + strconv.FormatInt(seq, 10)
+}
+
+// Room returns the link inviting people to a room.
+func (b *Base) Room(conversationID uuid.UUID) string {
+	return b.url.String() + "/join/" + conversationID.String()
+}
+
+// Profile returns the link to a user's profile.
+func (b *Base) Profile(username string) string {
+	return b.url.String() + "/u/" + url.PathEscape(username)
+}
+
+// String returns the link's canonical web address.
+func (b *Base) String(link Link) string {
+	switch link.Kind {
+	case KindMessage:
+		return b.Message(link.ConversationID, link.Seq)
+	case KindRoom:
+		return b.Room(link.ConversationID)
+	default:
+		return b.Profile(link.Username)
+	}
+}
+
+// Parse reads a link, given as a web address under the base or in the
+// apps' scheme. Anything else fails with ErrNotDeepLink.
+func (b *Base) Parse(raw string) (Link, error) {
+	parsed, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return Link{}, ErrNotDeepLink
+	}
+
+	var path string
+	switch {
+	case strings.EqualFold(parsed.Scheme, Scheme):
+		// afrochat://c/<id>/<seq> puts the first segment in the host.
+		path = parsed.Host + parsed.EscapedPath()
+	case strings.EqualFold(parsed.Scheme, b.url.Scheme) && strings.EqualFold(parsed.Host, b.url.Host):
+		var ok bool
+		path, ok = strings.CutPrefix(parsed.EscapedPath(), b.url.EscapedPath())
+		if !ok || (path != "" && path[0] != '/') {
+			return Link{}, ErrNotDeepLink
+		}
+	default:
+		return Link{}, ErrNotDeepLink
+	}
+
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(segments) == 3 && segments[0] == "c":
+		id, err := uuid.Parse(segments[1])
+		if err != nil {
+			return Link{}, ErrNotDeepLink
+		}
+		seq, err := strconv.ParseInt(segments[2], 10, 64)
+		if err != nil || seq < 1 {
+			return Link{}, ErrNotDeepLink
+		}
+		return Link{Kind: KindMessage, ConversationID: id, Seq: seq}, nil
+	case len(segments) == 2 && segments[0] == "join":
+		id, err := uuid.Parse(segments[1])
+		if err != nil {
+			return Link{}, ErrNotDeepLink
+		}
+		return Link{Kind: KindRoom, ConversationID: id}, nil
+	case len(segments) == 2 && segments[0] == "u":
+		username, err := url.PathUnescape(segments[1])
+		if err != nil || username == "" {
+			return Link{}, ErrNotDeepLink
+		}
+		return Link{Kind: KindProfile, Username: username}, nil
+	}
+	return Link{}, ErrNotDeepLink
+}
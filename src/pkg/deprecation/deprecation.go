@@ -0,0 +1,134 @@
+package deprecation
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Notice describes a deprecated route. Sunset and Link are optional.
+type Notice struct {
+	Deprecated time.Time `json:"deprecated"`
+	Sunset     time.Time `json:"sunset,omitempty"`
+	Link       string    `json:"link,omitempty"`
+	Successor  string    `json:"successor,omitempty"`
+}
+
+// Headers returns the response headers announcing the deprecation:
+// Deprecation (RFC 9745), Sunset (RFC 8594) and a deprecation Link.
+func (n Notice) Headers() map[string]string {
+	headers := map[string]string{
+		"Deprecation": fmt.Sprintf("@%d", n.Deprecated.Unix()),
+	}
+	if !n.Sunset.IsZero() {
+		headers["Sunset"] = n.Sunset.UTC().Format(http.TimeFormat)
+	}
+	if n.Link != "" {
+		headers["Link"] = fmt.Sprintf("<%s>; rel=\"deprecation\"", n.Link)
+	}
+	return headers
+}
+
+// ClientUsage counts calls to a deprecated route from one client version.
+type ClientUsage struct {
+	Client    string    `json:"client"`
+	Count     int64     `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// RouteReport is the usage of one deprecated route since start-up.
+type RouteReport struct {
+	Method  string        `json:"method"`
+	Path    string        `json:"path"`
+	Notice  Notice        `json:"notice"`
+	Total   int64         `json:"total"`
+	Clients []ClientUsage `json:"clients"`
+}
+
+// Registry holds route deprecation metadata and counts how often each
+// deprecated route is still called, by client version. Counts are kept in
+// memory per instance.
+type Registry struct {
+	mu      sync.RWMutex
+	notices map[route]Notice
+	usage   map[route]map[string]*ClientUsage
+}
+
+type route struct {
+	method string
+	path   string
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		notices: make(map[route]Notice),
+		usage:   make(map[route]map[string]*ClientUsage),
+	}
+}
+
+// Deprecate marks the route registered as method and path, using the
+// router's pattern such as "/api/v1/users/:id".
+func (r *Registry) Deprecate(method, path string, notice Notice) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.notices[route{method, path}] = notice
+}
+
+// Lookup returns the notice for a route, if it is deprecated.
+func (r *Registry) Lookup(method, path string) (Notice, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	notice, ok := r.notices[route{method, path}]
+	return notice, ok
+}
+
+// Record counts a call to a deprecated route by client and reports whether
+// it was the first from that client.
+func (r *Registry) Record(method, path, client string, at time.Time) bool {
+	key := route{method, path}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := r.usage[key]
+	if clients == nil {
+		clients = make(map[string]*ClientUsage)
+		r.usage[key] = clients
+	}
+	usage, seen := clients[client]
+	if !seen {
+		usage = &ClientUsage{Client: client, FirstSeen: at}
+		clients[client] = usage
+	}
+	usage.Count++
+	usage.LastSeen = at
+	return !seen
+}
+
+// Report lists every deprecated route with its usage, busiest first.
+func (r *Registry) Report() []RouteReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reports := make([]RouteReport, 0, len(r.notices))
+	for key, notice := range r.notices {
+		report := RouteReport{Method: key.method, Path: key.path, Notice: notice, Clients: []ClientUsage{}}
+		for _, usage := range r.usage[key] {
+			report.Total += usage.Count
+			report.Clients = append(report.Clients, *usage)
+		}
+		sort.Slice(report.Clients, func(i, j int) bool {
+			return report.Clients[i].Count > report.Clients[j].Count
+		})
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		if reports[i].Total != reports[j].Total {
+			return reports[i].Total > reports[j].Total
+		}
+		return reports[i].Path < reports[j].Path
+	})
+	return reports
+}
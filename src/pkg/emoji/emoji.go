@@ -0,0 +1,181 @@
+// Package emoji serves the emoji every client offers: their categories,
+// search keywords in each supported language, and skin-tone variants.
+// Clients share one versioned catalog, so searching for an emoji finds the
+// same ones on every platform.
+package emoji
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//go:embed data/emoji.json
+var catalogData []byte
+
+// DefaultLocale is the language used when a client's is not supported,
+// and for keywords missing in a supported one.
+const DefaultLocale = "en"
+
+// Skin tones, by their Fitzpatrick modifier.
+var skinTones = []struct {
+	name     string
+	modifier rune
+}{
+	{"light", '\U0001F3FB'},
+	{"medium_light", '\U0001F3FC'},
+	{"medium", '\U0001F3FD'},
+	{"medium_dark", '\U0001F3FE'},
+	{"dark", '\U0001F3FF'},
+}
+
+// variationSelector asks for emoji rather than text presentation. Skin
+// tone modifiers take its place.
+const variationSelector = "\uFE0F"
+
+type catalogFile struct {
+	Version    string   `json:"version"`
+	Locales    []string `json:"locales"`
+	Categories []struct {
+		ID    string            `json:"id"`
+		Names map[string]string `json:"names"`
+	} `json:"categories"`
+	Emoji []struct {
+		Emoji     string              `json:"emoji"`
+		Name      string              `json:"name"`
+		Category  string              `json:"category"`
+		SkinTones bool                `json:"skin_tones"`
+		Keywords  map[string][]string `json:"keywords"`
+	} `json:"emoji"`
+}
+
+// SkinTone is an emoji with a skin tone applied.
+type SkinTone struct {
+	Tone  string `json:"tone"`
+	Emoji string `json:"emoji"`
+}
+
+// Emoji is one emoji with its keywords in a set's language.
+type Emoji struct {
+	Emoji     string     `json:"emoji"`
+	Name      string     `json:"name"`
+	Keywords  []string   `json:"keywords"`
+	SkinTones []SkinTone `json:"skin_tones,omitempty"`
+}
+
+// Category is a group of emoji named in a set's language, in the order
+// pickers show them.
+type Category struct {
+	ID    string  `json:"id"`
+	Name  string  `json:"name"`
+	Emoji []Emoji `json:"emoji"`
+}
+
+// Set is the catalog in one language.
+type Set struct {
+	Version    string     `json:"version"`
+	Locale     string     `json:"locale"`
+	Categories []Category `json:"categories"`
+}
+
+// Catalog holds the emoji set of each supported language.
+type Catalog struct {
+	version string
+	locales []string
+	sets    map[string]*Set
+}
+
+// Load reads the catalog built into the server.
+func Load() (*Catalog, error) {
+	var file catalogFile
+	if err := json.Unmarshal(catalogData, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse emoji catalog: %w", err)
+	}
+
+	catalog := &Catalog{version: file.Version, locales: file.Locales, sets: make(map[string]*Set)}
+	for _, locale := range file.Locales {
+		set := &Set{Version: file.Version, Locale: locale}
+		index := make(map[string]int)
+		for _, category := range file.Categories {
+			name := category.Names[locale]
+			if name == "" {
+				name = category.Names[DefaultLocale]
+			}
+			index[category.ID] = len(set.Categories)
+			set.Categories = append(set.Categories, Category{ID: category.ID, Name: name, Emoji: []Emoji{}})
+		}
+
+		for _, entry := range file.Emoji {
+			i, ok := index[entry.Category]
+			if !ok {
+				return nil, fmt.Errorf("emoji %s has unknown category %q", entry.Emoji, entry.Category)
+			}
+			keywords := entry.Keywords[locale]
+			if len(keywords) == 0 {
+				keywords = entry.Keywords[DefaultLocale]
+			}
+			emoji := Emoji{Emoji: entry.Emoji, Name: entry.Name, Keywords: keywords}
+			if entry.SkinTones {
+				emoji.SkinTones = variants(entry.Emoji)
+			}
+			set.Categories[i].Emoji = append(set.Categories[i].Emoji, emoji)
+		}
+		catalog.sets[locale] = set
+	}
+	if catalog.sets[DefaultLocale] == nil {
+		return nil, fmt.Errorf("emoji catalog has no %q keywords", DefaultLocale)
+	}
+	return catalog, nil
+}
+
+// variants applies each skin tone to a single-character emoji.
+func variants(base string) []SkinTone {
+	base = strings.TrimSuffix(base, variationSelector)
+	tones := make([]SkinTone, 0, len(skinTones))
+	for _, tone := range skinTones {
+		tones = append(tones, SkinTone{Tone: tone.name, Emoji: base + string(tone.modifier)})
+	}
+	return tones
+}
+
+// Version identifies the catalog's contents; it changes whenever they do.
+func (c *Catalog) Version() string {
+	return c.version
+}
+
+// Locales lists the languages the catalog has keywords in.
+func (c *Catalog) Locales() []string {
+	return c.locales
+}
+
+// Set returns the catalog in a supported locale, or in the default one.
+func (c *Catalog) Set(locale string) *Set {
+	if set, ok := c.sets[locale]; ok {
+		return set
+	}
+	return c.sets[DefaultLocale]
+}
+
+// Negotiate picks the supported locale best matching an explicit request,
+// such as a query parameter, or else an Accept-Language header. Regional
+// variants match their language, so fr-CA is served fr.
+func (c *Catalog) Negotiate(requested, acceptLanguage string) string {
+	candidates := []string{requested}
+	if requested == "" {
+		// Quality weights are ignored: clients list languages in order of
+		// preference anyway.
+		for _, part := range strings.Split(acceptLanguage, ",") {
+			tag, _, _ := strings.Cut(part, ";")
+			candidates = append(candidates, tag)
+		}
+	}
+	for _, candidate := range candidates {
+		language, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(candidate)), "-")
+		language, _, _ = strings.Cut(language, "_")
+		if _, ok := c.sets[language]; ok {
+			return language
+		}
+	}
+	return DefaultLocale
+}
@@ -0,0 +1,180 @@
+package entitlements
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tier is an account's subscription level.
+type Tier string
+
+const (
+	TierFree     Tier = "free"
+	TierPremium  Tier = "premium"
+	TierBusiness Tier = "business"
+)
+
+// Limits are the rate limits and quotas that come with a tier.
+type Limits struct {
+	// RequestsPerMinute caps authenticated API requests.
+	RequestsPerMinute int `json:"requests_per_minute"`
+
+	ContentLimits
+
+	// RoomsPerDay caps groups and channels created per UTC day.
+	RoomsPerDay int `json:"rooms_per_day"`
+
+	// UploadsPerDay caps media uploads per UTC day.
+	UploadsPerDay int `json:"uploads_per_day"`
+
+	// BackupBytes caps the size of one encrypted chat backup.
+	BackupBytes int64 `json:"backup_bytes"`
+}
+
+// ContentLimits bound what a single message may contain.
+type ContentLimits struct {
+	// MessageLength is the longest text message, in characters.
+	MessageLength int `json:"message_length"`
+
+	// AttachmentsPerMessage caps the files sent with one message.
+	AttachmentsPerMessage int `json:"attachments_per_message"`
+
+	// MentionsPerMessage caps the distinct users one message mentions.
+	MentionsPerMessage int `json:"mentions_per_message"`
+}
+
+// DefaultContentLimits are each tier's content limits unless configured
+// otherwise.
+var DefaultContentLimits = map[Tier]ContentLimits{
+	TierFree:     {MessageLength: 4000, AttachmentsPerMessage: 10, MentionsPerMessage: 50},
+	TierPremium:  {MessageLength: 8000, AttachmentsPerMessage: 10, MentionsPerMessage: 50},
+	TierBusiness: {MessageLength: 16000, AttachmentsPerMessage: 10, MentionsPerMessage: 50},
+}
+
+var limits = map[Tier]Limits{
+	TierFree: {
+		RequestsPerMinute: 120,
+		ContentLimits:     DefaultContentLimits[TierFree],
+		RoomsPerDay:       20,
+		UploadsPerDay:     50,
+		BackupBytes:       2 << 30,
+	},
+	TierPremium: {
+		RequestsPerMinute: 300,
+		ContentLimits:     DefaultContentLimits[TierPremium],
+		RoomsPerDay:       100,
+		UploadsPerDay:     500,
+		BackupBytes:       10 << 30,
+	},
+	TierBusiness: {
+		RequestsPerMinute: 600,
+		ContentLimits:     DefaultContentLimits[TierBusiness],
+		RoomsPerDay:       500,
+		UploadsPerDay:     2000,
+		BackupBytes:       25 << 30,
+	},
+}
+
+// SetContentLimits replaces the content limits of the tiers given, as
+// configured. It must be called before serving, since For is not
+// synchronized with it.
+func SetContentLimits(content map[Tier]ContentLimits) {
+	for tier, c := range content {
+		if l, ok := limits[tier]; ok {
+			l.ContentLimits = c
+			limits[tier] = l
+		}
+	}
+}
+
+// RoomCaps cap how many members a room may have, by type, whatever the
+// tiers of its members. Zero means no cap.
+type RoomCaps struct {
+	Group          int `json:"group"`
+	PublicChannel  int `json:"public_channel"`
+	PrivateChannel int `json:"private_channel"`
+}
+
+var roomCaps RoomCaps
+
+// SetRoomCaps sets the room caps, as configured. It must be called before
+// serving, since Rooms is not synchronized with it.
+func SetRoomCaps(caps RoomCaps) {
+	roomCaps = caps
+}
+
+// Rooms returns the room caps.
+func Rooms() RoomCaps {
+	return roomCaps
+}
+
+// Channel returns the cap of a public or private channel.
+func (c RoomCaps) Channel(private bool) int {
+	if private {
+		return c.PrivateChannel
+	}
+	return c.PublicChannel
+}
+
+// contentLimitKeys name the fields of ContentLimits in configuration.
+var contentLimitKeys = map[string]func(*ContentLimits) *int{
+	"message_length":          func(c *ContentLimits) *int { return &c.MessageLength },
+	"attachments_per_message": func(c *ContentLimits) *int { return &c.AttachmentsPerMessage },
+	"mentions_per_message":    func(c *ContentLimits) *int { return &c.MentionsPerMessage },
+}
+
+// ParseContentLimits reads overrides of base such as
+// "message_length=8000,attachments_per_message=20". Limits left out keep
+// their value in base, and every limit must be positive.
+func ParseContentLimits(s string, base ContentLimits) (ContentLimits, error) {
+	parsed := base
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(item, "=")
+		field, ok := contentLimitKeys[strings.TrimSpace(key)]
+		if !ok {
+			return base, fmt.Errorf("unknown content limit %q", key)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n < 1 {
+			return base, fmt.Errorf("content limit %s must be a positive integer", key)
+		}
+		*field(&parsed) = n
+	}
+	return parsed, nil
+}
+
+// For returns the limits of a tier. Unknown tiers get the free limits.
+func For(tier Tier) Limits {
+	if l, ok := limits[tier]; ok {
+		return l
+	}
+	return limits[TierFree]
+}
+
+// Quota is the state of a daily allowance.
+type Quota struct {
+	Limit int
+	Used  int
+	Reset time.Time
+}
+
+func (q Quota) Remaining() int {
+	return max(q.Limit-q.Used, 0)
+}
+
+func (q Quota) Exhausted() bool {
+	return q.Used >= q.Limit
+}
+
+// Day returns the UTC day containing now as [start, end), the window daily
+// quotas are counted over.
+func Day(now time.Time) (time.Time, time.Time) {
+	start := now.UTC().Truncate(24 * time.Hour)
+	return start, start.Add(24 * time.Hour)
+}
@@ -0,0 +1,104 @@
+package experiments
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// buckets is the resolution of traffic splits: 0.01% per bucket.
+const buckets = 10000
+
+var (
+	ErrInvalidExperiment = errors.New("invalid experiment")
+
+	keyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]{0,63}$`)
+)
+
+type Variant struct {
+	Key    string `json:"key"`
+	Weight int    `json:"weight"`
+}
+
+// Experiment is the assignment-relevant part of an experiment definition.
+// TrafficPercent of users are enrolled; enrolled users are split across
+// Variants in proportion to their weights.
+type Experiment struct {
+	Key            string    `json:"key"`
+	TrafficPercent int       `json:"traffic_percent"`
+	Variants       []Variant `json:"variants"`
+}
+
+func (e Experiment) Validate() error {
+	if !keyPattern.MatchString(e.Key) {
+		return fmt.Errorf("%w: key must be lowercase letters, digits, '_', '.' or '-'", ErrInvalidExperiment)
+	}
+	if e.TrafficPercent < 0 || e.TrafficPercent > 100 {
+		return fmt.Errorf("%w: traffic_percent must be between 0 and 100", ErrInvalidExperiment)
+	}
+	if len(e.Variants) < 2 {
+		return fmt.Errorf("%w: at least two variants are required", ErrInvalidExperiment)
+	}
+	seen := make(map[string]bool, len(e.Variants))
+	for _, variant := range e.Variants {
+		if !keyPattern.MatchString(variant.Key) {
+			return fmt.Errorf("%w: invalid variant key %q", ErrInvalidExperiment, variant.Key)
+		}
+		if seen[variant.Key] {
+			return fmt.Errorf("%w: duplicate variant %q", ErrInvalidExperiment, variant.Key)
+		}
+		if variant.Weight < 1 {
+			return fmt.Errorf("%w: variant %q needs a positive weight", ErrInvalidExperiment, variant.Key)
+		}
+		seen[variant.Key] = true
+	}
+	return nil
+}
+
+// Assign returns the user's variant, or false when the user falls outside
+// the experiment's traffic. The same user always gets the same answer, and
+// enrolment and variant use separate hashes so raising TrafficPercent adds
+// users without moving anyone already enrolled to another variant.
+func Assign(experiment Experiment, userID uuid.UUID) (string, bool) {
+	if bucket(experiment.Key+":traffic", userID) >= experiment.TrafficPercent*buckets/100 {
+		return "", false
+	}
+
+	total := 0
+	for _, variant := range experiment.Variants {
+		total += variant.Weight
+	}
+	if total <= 0 {
+		return "", false
+	}
+
+	point := bucket(experiment.Key+":variant", userID) * total / buckets
+	for _, variant := range experiment.Variants {
+		if point < variant.Weight {
+			return variant.Key, true
+		}
+		point -= variant.Weight
+	}
+	return "", false
+}
+
+// AssignAll returns the user's variant for every experiment they are
+// enrolled in, keyed by experiment key.
+func AssignAll(experiments []Experiment, userID uuid.UUID) map[string]string {
+	assignments := make(map[string]string, len(experiments))
+	for _, experiment := range experiments {
+		if variant, ok := Assign(experiment, userID); ok {
+			assignments[experiment.Key] = variant
+		}
+	}
+	return assignments
+}
+
+func bucket(seed string, userID uuid.UUID) int {
+	sum := sha256.Sum256(append([]byte(seed+":"), userID[:]...))
+	return int(binary.BigEndian.Uint64(sum[:8]) % buckets)
+}
@@ -0,0 +1,32 @@
+package experiments
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Exposure records that a user actually saw their assigned variant. Only
+// exposed users belong in an experiment's analysis.
+type Exposure struct {
+	Experiment string    `json:"experiment"`
+	Variant    string    `json:"variant"`
+	UserID     uuid.UUID `json:"user_id"`
+	ExposedAt  time.Time `json:"exposed_at"`
+}
+
+// ExposureSink forwards exposures to the analytics stream.
+type ExposureSink interface {
+	Record(ctx context.Context, exposure Exposure) error
+}
+
+// LogSink writes exposures to the log until an analytics stream is
+// configured.
+type LogSink struct{}
+
+func (LogSink) Record(_ context.Context, exposure Exposure) error {
+	slog.Info("🧪 Exposure", "experiment", exposure.Experiment, "variant", exposure.Variant, "user_id", exposure.UserID)
+	return nil
+}
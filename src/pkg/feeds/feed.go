@@ -0,0 +1,150 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Item is a feed entry normalized across RSS and Atom.
+type Item struct {
+	GUID        string
+	Title       string
+	Link        string
+	Summary     string
+	ImageURL    string
+	PublishedAt time.Time
+}
+
+type rssDocument struct {
+	Channel struct {
+		Items []struct {
+			GUID        string `xml:"guid"`
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+			Enclosure   struct {
+				URL  string `xml:"url,attr"`
+				Type string `xml:"type,attr"`
+			} `xml:"enclosure"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// Parse decodes an RSS 2.0 or Atom document into items.
+func Parse(data []byte) ([]Item, error) {
+	var root struct {
+		XMLName xml.Name
+	}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	switch root.XMLName.Local {
+	case "rss":
+		return parseRSS(data)
+	case "feed":
+		return parseAtom(data)
+	}
+	return nil, fmt.Errorf("unsupported feed format %q", root.XMLName.Local)
+}
+
+func parseRSS(data []byte) ([]Item, error) {
+	var doc rssDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rss feed: %w", err)
+	}
+
+	items := make([]Item, 0, len(doc.Channel.Items))
+	for _, entry := range doc.Channel.Items {
+		item := Item{
+			GUID:        strings.TrimSpace(entry.GUID),
+			Title:       strings.TrimSpace(entry.Title),
+			Link:        strings.TrimSpace(entry.Link),
+			Summary:     strings.TrimSpace(entry.Description),
+			PublishedAt: parseTime(entry.PubDate),
+		}
+		if strings.HasPrefix(entry.Enclosure.Type, "image/") {
+			item.ImageURL = entry.Enclosure.URL
+		}
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func parseAtom(data []byte) ([]Item, error) {
+	var doc atomDocument
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse atom feed: %w", err)
+	}
+
+	items := make([]Item, 0, len(doc.Entries))
+	for _, entry := range doc.Entries {
+		item := Item{
+			GUID:    strings.TrimSpace(entry.ID),
+			Title:   strings.TrimSpace(entry.Title),
+			Summary: strings.TrimSpace(entry.Summary),
+		}
+		if item.Summary == "" {
+			item.Summary = strings.TrimSpace(entry.Content)
+		}
+		for _, link := range entry.Links {
+			switch {
+			case link.Rel == "" || link.Rel == "alternate":
+				if item.Link == "" {
+					item.Link = link.Href
+				}
+			case link.Rel == "enclosure" && strings.HasPrefix(link.Type, "image/"):
+				item.ImageURL = link.Href
+			}
+		}
+		item.PublishedAt = parseTime(entry.Published)
+		if item.PublishedAt.IsZero() {
+			item.PublishedAt = parseTime(entry.Updated)
+		}
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+var timeLayouts = []string{
+	time.RFC3339,
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+}
+
+func parseTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
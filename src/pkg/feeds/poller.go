@@ -0,0 +1,91 @@
+package feeds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+const (
+	maxFeedBytes = 5 * 1024 * 1024
+
+	// maxSeen bounds the item GUIDs remembered of one feed.
+	maxSeen = 1000
+)
+
+// Poller fetches feeds and reports only the items not seen before. It
+// keeps no state itself: callers store the GUIDs each poll returns and
+// pass them to the next, so any instance can poll any feed. The first
+// poll of a feed, with no GUIDs seen, reports nothing, so adding a feed to
+// a room does not flood it with the back catalogue.
+type Poller struct {
+	client *http.Client
+}
+
+// NewPoller returns a poller fetching feeds with client.
+func NewPoller(client *http.Client) *Poller {
+	return &Poller{client: client}
+}
+
+// Poll fetches feedURL and returns its items not in seen, oldest first,
+// with the GUIDs to pass as seen next time: those the feed lists now, as
+// items it dropped will not come back. A nil seen primes the feed.
+func (p *Poller) Poll(ctx context.Context, feedURL string, seen []string) ([]Item, []string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build feed request: %w", err)
+	}
+	req.Header.Set("User-Agent", "AfroChat-FeedBot/1.0")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("feed returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxFeedBytes))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+
+	items, err := Parse(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	fresh, current := Fresh(items, seen)
+	return fresh, current, nil
+}
+
+// Fresh returns the items not in seen, oldest first, and the GUIDs of
+// items, the newest maxSeen of them. Feeds list their newest items first.
+// A nil seen returns no items.
+func Fresh(items []Item, seen []string) ([]Item, []string) {
+	known := make(map[string]bool, len(seen))
+	for _, guid := range seen {
+		known[guid] = true
+	}
+	current := []string{}
+	listed := make(map[string]bool, len(items))
+	for _, item := range items {
+		if item.GUID != "" && !listed[item.GUID] && len(current) < maxSeen {
+			listed[item.GUID] = true
+			current = append(current, item.GUID)
+		}
+	}
+	if seen == nil {
+		return nil, current
+	}
+	var fresh []Item
+	for i := len(items) - 1; i >= 0; i-- {
+		if guid := items[i].GUID; guid != "" && !known[guid] {
+			known[guid] = true
+			fresh = append(fresh, items[i])
+		}
+	}
+	return fresh, current
+}
@@ -0,0 +1,177 @@
+// Package geoip guesses where a client is from its IP address, so new
+// accounts can be offered settings that suit them. Guesses are rough and
+// only ever used as suggestions.
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknown means the address could not be placed, as with private and
+// reserved addresses or those the database does not cover.
+var ErrUnknown = errors.New("location unknown")
+
+// Location is where an address is thought to be.
+type Location struct {
+	// CountryCode is the ISO 3166-1 alpha-2 code, in upper case.
+	CountryCode string
+	// TimeZone is the IANA time zone, when known.
+	TimeZone string
+}
+
+// Provider places IP addresses.
+type Provider interface {
+	Lookup(ctx context.Context, addr netip.Addr) (Location, error)
+}
+
+// DefaultMaxMindHost serves the free GeoLite2 web service; paid GeoIP2
+// accounts use geoip.maxmind.com.
+const DefaultMaxMindHost = "geolite.info"
+
+// MaxMind places addresses with MaxMind's GeoIP2 City web service.
+type MaxMind struct {
+	accountID  string
+	licenseKey string
+	host       string
+	client     *http.Client
+}
+
+func NewMaxMind(accountID, licenseKey, host string) *MaxMind {
+	return &MaxMind{
+		accountID:  accountID,
+		licenseKey: licenseKey,
+		host:       host,
+		client:     &http.Client{Timeout: 3 * time.Second},
+	}
+}
+
+type maxMindCity struct {
+	Country struct {
+		ISOCode string `json:"iso_code"`
+	} `json:"country"`
+	Location struct {
+		TimeZone string `json:"time_zone"`
+	} `json:"location"`
+}
+
+type maxMindError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+func (m *MaxMind) Lookup(ctx context.Context, addr netip.Addr) (Location, error) {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return Location{}, ErrUnknown
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+m.host+"/geoip/v2.1/city/"+addr.String(), nil)
+	if err != nil {
+		return Location{}, fmt.Errorf("failed to build geolocation request: %w", err)
+	}
+	req.SetBasicAuth(m.accountID, m.licenseKey)
+	req.Header.Set("Accept", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return Location{}, fmt.Errorf("failed to look up address: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr maxMindError
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Code == "IP_ADDRESS_NOT_FOUND" || apiErr.Code == "IP_ADDRESS_RESERVED" {
+			return Location{}, ErrUnknown
+		}
+		return Location{}, fmt.Errorf("geolocation service answered %d: %s", resp.StatusCode, apiErr.Code)
+	}
+	var city maxMindCity
+	if err := json.NewDecoder(resp.Body).Decode(&city); err != nil {
+		return Location{}, fmt.Errorf("failed to decode geolocation: %w", err)
+	}
+	if city.Country.ISOCode == "" {
+		return Location{}, ErrUnknown
+	}
+	return Location{CountryCode: strings.ToUpper(city.Country.ISOCode), TimeZone: city.Location.TimeZone}, nil
+}
+
+// Cached remembers a provider's answers, including ErrUnknown, for a
+// while, since lookups are paid for and addresses rarely move. Other
+// errors are not remembered.
+type Cached struct {
+	provider Provider
+	ttl      time.Duration
+	size     int
+
+	mu      sync.Mutex
+	entries map[netip.Addr]cachedLocation
+}
+
+type cachedLocation struct {
+	location  Location
+	err       error
+	expiresAt time.Time
+}
+
+// NewCached caches up to size of provider's answers for ttl each.
+func NewCached(provider Provider, ttl time.Duration, size int) *Cached {
+	return &Cached{provider: provider, ttl: ttl, size: size, entries: make(map[netip.Addr]cachedLocation)}
+}
+
+func (c *Cached) Lookup(ctx context.Context, addr netip.Addr) (Location, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[addr]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.location, entry.err
+	}
+
+	location, err := c.provider.Lookup(ctx, addr)
+	if err != nil && !errors.Is(err, ErrUnknown) {
+		return Location{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.size {
+		now := time.Now()
+		for cached, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, cached)
+			}
+		}
+		if len(c.entries) >= c.size {
+			c.entries = make(map[netip.Addr]cachedLocation)
+		}
+	}
+	c.entries[addr] = cachedLocation{location: location, err: err, expiresAt: time.Now().Add(c.ttl)}
+	return location, err
+}
+
+// languages is the language most widely used online in each country,
+// where that is not English.
+var languages = map[string]string{
+	"AO": "pt", "BF": "fr", "BI": "fr", "BJ": "fr", "CD": "fr", "CF": "fr",
+	"CG": "fr", "CI": "fr", "CM": "fr", "CV": "pt", "DJ": "fr", "DZ": "ar",
+	"EG": "ar", "ET": "am", "GA": "fr", "GN": "fr", "GQ": "es", "GW": "pt",
+	"KM": "fr", "LY": "ar", "MA": "ar", "MG": "fr", "ML": "fr", "MR": "ar",
+	"MZ": "pt", "NE": "fr", "RW": "rw", "SD": "ar", "SN": "fr", "SO": "so",
+	"ST": "pt", "TD": "fr", "TG": "fr", "TN": "ar", "TZ": "sw",
+	"FR": "fr", "BE": "fr", "PT": "pt", "BR": "pt", "ES": "es", "DE": "de",
+	"SA": "ar", "AE": "ar",
+}
+
+// Language returns the default language of a country as a BCP 47 tag,
+// falling back to English.
+func Language(countryCode string) string {
+	if language, ok := languages[strings.ToUpper(countryCode)]; ok {
+		return language
+	}
+	return "en"
+}
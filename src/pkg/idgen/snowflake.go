@@ -0,0 +1,65 @@
+package idgen
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	workerBits   = 10
+	sequenceBits = 12
+	maxWorkerID  = 1<<workerBits - 1
+	maxSequence  = 1<<sequenceBits - 1
+)
+
+// Epoch is the Snowflake epoch; IDs hold milliseconds since this instant.
+var Epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Snowflake produces 63-bit IDs (41-bit time, 10-bit worker, 12-bit sequence)
+// for tables that want compact bigint keys. Each instance needs a distinct
+// worker ID so IDs never collide across shards.
+type Snowflake struct {
+	workerID int64
+
+	mu       sync.Mutex
+	lastMs   int64
+	sequence int64
+}
+
+func NewSnowflake(workerID int64) (*Snowflake, error) {
+	if workerID < 0 || workerID > maxWorkerID {
+		return nil, fmt.Errorf("worker id must be between 0 and %d", maxWorkerID)
+	}
+	return &Snowflake{workerID: workerID}, nil
+}
+
+func (s *Snowflake) Next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := time.Since(Epoch).Milliseconds()
+	if ms < s.lastMs {
+		ms = s.lastMs
+	}
+	if ms == s.lastMs {
+		s.sequence = (s.sequence + 1) & maxSequence
+		if s.sequence == 0 {
+			// Sequence exhausted for this millisecond: wait for the next one.
+			for ms <= s.lastMs {
+				time.Sleep(100 * time.Microsecond)
+				ms = time.Since(Epoch).Milliseconds()
+			}
+		}
+	} else {
+		s.sequence = 0
+	}
+	s.lastMs = ms
+
+	return ms<<(workerBits+sequenceBits) | s.workerID<<sequenceBits | s.sequence
+}
+
+// SnowflakeTime extracts the creation time from a Snowflake ID.
+func SnowflakeTime(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>(workerBits+sequenceBits)) * time.Millisecond)
+}
@@ -0,0 +1,176 @@
+package idgen
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ErrInvalidID = errors.New("invalid ulid")
+
+// ID is a ULID: a 48-bit millisecond timestamp followed by 80 random bits.
+// It has the same size as a UUID, so existing uuid columns can store IDs
+// from this package unchanged and new rows simply sort by creation time.
+type ID [16]byte
+
+func (id ID) Time() time.Time {
+	ms := uint64(id[0])<<40 | uint64(id[1])<<32 | uint64(id[2])<<24 | uint64(id[3])<<16 | uint64(id[4])<<8 | uint64(id[5])
+	return time.UnixMilli(int64(ms)).UTC()
+}
+
+func (id ID) UUID() uuid.UUID {
+	return uuid.UUID(id)
+}
+
+// String encodes the ID as 26 Crockford base32 characters.
+func (id ID) String() string {
+	out := make([]byte, 26)
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+	// 128 bits are emitted as 26 groups of 5, the first group holding 3 bits.
+	for i := 25; i >= 0; i-- {
+		out[i] = crockford[lo&0x1F]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(out)
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalText(text []byte) error {
+	parsed, err := Parse(string(text))
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+func Parse(value string) (ID, error) {
+	var id ID
+	if len(value) != 26 || value[0] > '7' {
+		return id, fmt.Errorf("%w: %q", ErrInvalidID, value)
+	}
+
+	var hi, lo uint64
+	for _, c := range strings.ToUpper(value) {
+		index := strings.IndexRune(crockford, c)
+		if index < 0 {
+			return id, fmt.Errorf("%w: %q", ErrInvalidID, value)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(index)
+	}
+	binary.BigEndian.PutUint64(id[:8], hi)
+	binary.BigEndian.PutUint64(id[8:], lo)
+	return id, nil
+}
+
+// FromUUID reinterprets a stored uuid as an ID, e.g. for cursors.
+func FromUUID(value uuid.UUID) ID {
+	return ID(value)
+}
+
+// Floor returns the smallest ID with t's millisecond, which no ID made at
+// or after t sorts below, for finding rows by creation time in their
+// primary key index.
+func Floor(t time.Time) ID {
+	var id ID
+	ms := uint64(t.UnixMilli())
+	id[0], id[1], id[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+	id[3], id[4], id[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+	return id
+}
+
+// Generator produces monotonic ULIDs: IDs generated in the same millisecond
+// increment the random part so they still sort in creation order.
+type Generator struct {
+	entropy io.Reader
+
+	mu     sync.Mutex
+	lastMs uint64
+	last   ID
+}
+
+func NewGenerator() *Generator {
+	return &Generator{entropy: rand.Reader}
+}
+
+// NewSeededGenerator returns a generator whose random bits are a function
+// of seed, so IDs made at the same times come out the same on every run.
+// It is for test mode and must not make IDs that have to be unguessable.
+func NewSeededGenerator(seed string) *Generator {
+	return &Generator{entropy: seededEntropy(seed)}
+}
+
+func seededEntropy(seed string) io.Reader {
+	return mathrand.NewChaCha8(sha256.Sum256([]byte(seed)))
+}
+
+func (g *Generator) New() ID {
+	return g.At(time.Now())
+}
+
+func (g *Generator) At(t time.Time) ID {
+	ms := uint64(t.UnixMilli())
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var id ID
+	if ms <= g.lastMs {
+		// Same (or skewed-back) clock: keep the previous timestamp and
+		// increment, which preserves ordering across the whole process.
+		id = g.last
+		for i := 15; i >= 6; i-- {
+			id[i]++
+			if id[i] != 0 {
+				break
+			}
+		}
+	} else {
+		id[0], id[1], id[2] = byte(ms>>40), byte(ms>>32), byte(ms>>24)
+		id[3], id[4], id[5] = byte(ms>>16), byte(ms>>8), byte(ms)
+		if _, err := io.ReadFull(g.entropy, id[6:]); err != nil {
+			panic(fmt.Sprintf("idgen: failed to read entropy: %v", err))
+		}
+		g.lastMs = ms
+	}
+	g.last = id
+	return id
+}
+
+var defaultGenerator = NewGenerator()
+
+// Seed makes the process-wide generator's random bits a function of seed
+// from here on, for test mode runs to repeat. The time part of the IDs
+// still follows the clock.
+func Seed(seed string) {
+	defaultGenerator.mu.Lock()
+	defer defaultGenerator.mu.Unlock()
+	defaultGenerator.entropy = seededEntropy(seed)
+}
+
+// New returns a ULID from the process-wide generator.
+func New() ID {
+	return defaultGenerator.New()
+}
+
+// NewUUID returns a time-ordered ID for use in existing uuid primary keys.
+func NewUUID() uuid.UUID {
+	return defaultGenerator.New().UUID()
+}
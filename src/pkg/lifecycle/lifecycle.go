@@ -0,0 +1,86 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+type step struct {
+	name string
+	stop func(ctx context.Context) error
+}
+
+// Manager serves an HTTP server until the process is told to stop, then
+// shuts it down and tears down what it depends on.
+type Manager struct {
+	timeout time.Duration
+	steps   []step
+}
+
+// New creates a manager that gives shutdown timeout to finish before
+// giving up on whatever is still running.
+func New(timeout time.Duration) *Manager {
+	return &Manager{timeout: timeout}
+}
+
+// OnShutdown registers a teardown step. Steps run after the server has
+// stopped, in reverse order of registration like deferred calls, so
+// something registered right after it was started is stopped after
+// everything that was started later and may use it.
+func (m *Manager) OnShutdown(name string, stop func(ctx context.Context) error) {
+	m.steps = append(m.steps, step{name: name, stop: stop})
+}
+
+// Run serves srv until SIGINT or SIGTERM arrives, ctx is cancelled or the
+// server fails. It then stops accepting connections, waits for in-flight
+// requests, and runs the teardown steps, all within the shutdown timeout.
+// A step that fails or runs out of time is logged and the rest still run.
+func (m *Manager) Run(ctx context.Context, srv *http.Server) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	var runErr error
+	select {
+	case err := <-serveErr:
+		runErr = fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+		slog.InfoContext(ctx, "Shutting down")
+	}
+	// A second signal falls back to the default behaviour and kills the
+	// process, for when shutdown hangs.
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), m.timeout)
+	defer cancel()
+
+	if runErr == nil {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.ErrorContext(ctx, "Failed to finish in-flight requests", "error", err)
+		}
+		if err := <-serveErr; !errors.Is(err, http.ErrServerClosed) {
+			runErr = fmt.Errorf("server stopped: %w", err)
+		}
+	}
+
+	for i := len(m.steps) - 1; i >= 0; i-- {
+		step := m.steps[i]
+		if err := step.stop(shutdownCtx); err != nil {
+			slog.ErrorContext(ctx, "Failed to stop", "step", step.name, "error", err)
+			continue
+		}
+		slog.InfoContext(ctx, "Stopped", "step", step.name)
+	}
+	return runErr
+}
@@ -0,0 +1,131 @@
+// Package loadshed turns away low-priority requests when the server is
+// near saturation, so that messaging keeps its latency at peak hours.
+package loadshed
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority orders the requests that may be shed. Requests without one,
+// such as sending and receiving messages, are never shed.
+type Priority int
+
+const (
+	// Lowest requests, such as link previews and analytics, are shed
+	// first.
+	Lowest Priority = iota
+	// Low requests, such as search, are shed once pressure is higher.
+	Low
+)
+
+// shedFrom is the pressure at which each priority starts being shed. The
+// shed fraction then grows linearly to all requests at a pressure of 1.
+var shedFrom = [...]float64{Lowest: 0.5, Low: 0.7}
+
+func (p Priority) String() string {
+	if p == Lowest {
+		return "lowest"
+	}
+	return "low"
+}
+
+// decay is how much of the previous pressure a sample keeps, so pressure
+// rises at once but falls over several samples rather than flapping.
+const decay = 0.8
+
+// Signal measures one resource: 0 when idle, 1 when saturated. It may
+// exceed 1.
+type Signal func() float64
+
+// Config sets how the shedder samples.
+type Config struct {
+	// Interval between samples.
+	Interval time.Duration
+
+	// MaxSchedulerLag is the lateness of a timer, for want of a free
+	// thread or during garbage collection, that counts as saturated.
+	MaxSchedulerLag time.Duration
+}
+
+// Shedder samples scheduler lag and the watched signals, and decides which
+// requests to shed from the highest of them.
+type Shedder struct {
+	config Config
+
+	mu      sync.Mutex
+	names   []string
+	signals []Signal
+
+	// pressure holds the float64 bits of the smoothed pressure.
+	pressure atomic.Uint64
+}
+
+func New(config Config) *Shedder {
+	return &Shedder{config: config}
+}
+
+// Watch adds a signal to the samples. Call it before Run.
+func (s *Shedder) Watch(name string, signal Signal) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, name)
+	s.signals = append(s.signals, signal)
+}
+
+// Pressure returns the current pressure: the highest signal, smoothed.
+func (s *Shedder) Pressure() float64 {
+	return math.Float64frombits(s.pressure.Load())
+}
+
+// Shed reports whether to turn away a request of the given priority. The
+// fraction shed grows with pressure, so load falls off gradually.
+func (s *Shedder) Shed(priority Priority) bool {
+	from := shedFrom[priority]
+	fraction := (s.Pressure() - from) / (1 - from)
+	return fraction > 0 && rand.Float64() < fraction
+}
+
+// Run samples every interval until ctx is done.
+func (s *Shedder) Run(ctx context.Context) {
+	timer := time.NewTimer(s.config.Interval)
+	defer timer.Stop()
+	for {
+		due := time.Now().Add(s.config.Interval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		s.sample(max(time.Since(due), 0))
+		timer.Reset(s.config.Interval)
+	}
+}
+
+// sample records the pressure given how late the sampling timer fired.
+func (s *Shedder) sample(lag time.Duration) {
+	highest := "scheduler"
+	current := float64(lag) / float64(s.config.MaxSchedulerLag)
+	s.mu.Lock()
+	for i, signal := range s.signals {
+		if value := signal(); value > current {
+			highest, current = s.names[i], value
+		}
+	}
+	s.mu.Unlock()
+
+	previous := s.Pressure()
+	pressure := max(current, previous*decay)
+	s.pressure.Store(math.Float64bits(pressure))
+
+	if shedding, was := pressure > shedFrom[Lowest], previous > shedFrom[Lowest]; shedding && !was {
+		slog.Warn("Shedding low-priority requests", "pressure", pressure, "signal", highest, "scheduler_lag", lag)
+	} else if was && !shedding {
+		slog.Info("Stopped shedding requests", "pressure", pressure)
+	}
+}
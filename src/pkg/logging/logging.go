@@ -0,0 +1,77 @@
+// Package logging writes structured logs. Lines logged with a context
+// carrying a request ID are tagged with it, so everything logged while
+// handling one request can be found together, and with the ID of the
+// trace the context belongs to.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+)
+
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose log lines carry id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID ctx carries, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// New returns a logger writing to w as JSON lines or, for reading in a
+// terminal, as key=value text, dropping lines below level.
+func New(w io.Writer, format string, level slog.Level) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewJSONHandler(w, options)
+	if format == FormatText {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.ToUpper(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q", s)
+	}
+	return level, nil
+}
+
+// contextHandler adds the request and trace IDs of the context a line is
+// logged with.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if trace := tracing.SpanContextFromContext(ctx); trace.IsValid() {
+		record.AddAttrs(slog.String("trace_id", trace.TraceID.String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
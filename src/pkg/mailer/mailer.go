@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+
+	// ReplyTo, when set, is where replies go instead of the sender.
+	ReplyTo string
+
+	// Marketing marks product news and offers, which are only sent to
+	// those who agreed to them.
+	Marketing bool
+}
+
+// Mailer delivers outgoing email.
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+// LogMailer writes messages to the log instead of sending them. It is meant
+// for local development, where no mail server is configured.
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (*LogMailer) Send(_ context.Context, message Message) error {
+	slog.Info("📧 Email", "to", message.To, "subject", message.Subject, "body", message.Body)
+	return nil
+}
@@ -0,0 +1,123 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SMTPConfig locates an SMTP relay. Username may be empty for relays that
+// accept mail without authentication.
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// SMTPMailer sends mail through an SMTP relay, upgrading the connection
+// with STARTTLS whenever the relay offers it.
+type SMTPMailer struct {
+	config SMTPConfig
+	from   *mail.Address
+}
+
+func NewSMTPMailer(config SMTPConfig) (*SMTPMailer, error) {
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address %q: %w", config.From, err)
+	}
+	return &SMTPMailer{config: config, from: from}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", message.To, err)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.config.Host, strconv.Itoa(m.config.Port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return fmt.Errorf("failed to connect to mail server: %w", err)
+	}
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		return fmt.Errorf("failed to greet mail server: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.config.Host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %w", err)
+		}
+	}
+	if m.config.Username != "" {
+		// PlainAuth refuses to send credentials over an unencrypted
+		// connection to anything but localhost.
+		auth := smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("failed to authenticate with mail server: %w", err)
+		}
+	}
+
+	if err := client.Mail(m.from.Address); err != nil {
+		return fmt.Errorf("mail server rejected sender: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("mail server rejected recipient: %w", err)
+	}
+	body, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if _, err := body.Write(m.compose(to, message)); err != nil {
+		body.Close()
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := body.Close(); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	return client.Quit()
+}
+
+// compose renders a message as a plain-text RFC 5322 email.
+func (m *SMTPMailer) compose(to *mail.Address, message Message) []byte {
+	var b strings.Builder
+	headers := [][2]string{
+		{"From", m.from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "text/plain; charset=utf-8"},
+		{"Content-Transfer-Encoding", "8bit"},
+	}
+	if message.ReplyTo != "" {
+		headers = append(headers, [2]string{"Reply-To", message.ReplyTo})
+	}
+	for _, header := range headers {
+		b.WriteString(header[0] + ": " + header[1] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	// SMTP requires CRLF line endings in the body.
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,301 @@
+package markdown
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+type EntityType string
+
+const (
+	EntityBold        EntityType = "bold"
+	EntityItalic      EntityType = "italic"
+	EntityCode        EntityType = "code"
+	EntityPre         EntityType = "pre"
+	EntityQuote       EntityType = "blockquote"
+	EntityListItem    EntityType = "list_item"
+	EntityOrderedItem EntityType = "ordered_list_item"
+)
+
+// Entity marks a formatted range of the plain text. Offset and Length are
+// measured in UTF-16 code units so web, iOS and Android clients agree on them.
+type Entity struct {
+	Type     EntityType `json:"type"`
+	Offset   int        `json:"offset"`
+	Length   int        `json:"length"`
+	Language string     `json:"language,omitempty"`
+	Number   int        `json:"number,omitempty"`
+}
+
+// Document is the normalized form stored with a message: the text with all
+// markup removed plus the entities describing how to render it.
+type Document struct {
+	Text     string   `json:"text"`
+	Entities []Entity `json:"entities"`
+}
+
+// Parse converts the supported markdown subset (bold, italics, inline code,
+// fenced code, lists and quotes) into a Document. Anything outside the
+// subset is kept as literal text, so no markup ever reaches a client.
+func Parse(source string) *Document {
+	lines := strings.Split(Sanitize(source), "\n")
+	b := &builder{}
+
+	for i := 0; i < len(lines); i++ {
+		if i > 0 {
+			b.write("\n")
+		}
+		line := lines[i]
+
+		if language, ok := fenceOpen(line); ok {
+			if end := fenceClose(lines, i+1); end != -1 {
+				start := b.pos
+				b.write(strings.Join(lines[i+1:end], "\n"))
+				b.add(Entity{Type: EntityPre, Offset: start, Length: b.pos - start, Language: language})
+				i = end
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, ">"):
+			start := b.pos
+			b.inline(strings.TrimPrefix(strings.TrimPrefix(line, ">"), " "))
+			b.add(Entity{Type: EntityQuote, Offset: start, Length: b.pos - start})
+		case isBullet(line):
+			start := b.pos
+			b.inline(line[2:])
+			b.add(Entity{Type: EntityListItem, Offset: start, Length: b.pos - start})
+		default:
+			if number, rest, ok := orderedItem(line); ok {
+				start := b.pos
+				b.inline(rest)
+				b.add(Entity{Type: EntityOrderedItem, Offset: start, Length: b.pos - start, Number: number})
+				continue
+			}
+			b.inline(line)
+		}
+	}
+
+	sort.SliceStable(b.entities, func(i, j int) bool {
+		if b.entities[i].Offset != b.entities[j].Offset {
+			return b.entities[i].Offset < b.entities[j].Offset
+		}
+		return b.entities[i].Length > b.entities[j].Length
+	})
+
+	return &Document{Text: b.out.String(), Entities: b.entities}
+}
+
+// Sanitize normalizes line endings and strips control and bidi-override
+// characters that could be used to spoof the rendered text.
+func Sanitize(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r == '\r':
+			return '\n'
+		case unicode.IsControl(r):
+			return -1
+		case r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+			return -1
+		}
+		return r
+	}, source)
+}
+
+// Escape returns text written as markdown that Parse reads back as the
+// same text, for posting text from elsewhere as a message.
+func Escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if isMarkerRune(r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+type builder struct {
+	out      strings.Builder
+	pos      int
+	entities []Entity
+}
+
+func (b *builder) write(s string) {
+	for _, r := range s {
+		b.writeRune(r)
+	}
+}
+
+func (b *builder) writeRune(r rune) {
+	b.out.WriteRune(r)
+	b.pos += utf16.RuneLen(r)
+}
+
+func (b *builder) add(entity Entity) {
+	if entity.Length > 0 {
+		b.entities = append(b.entities, entity)
+	}
+}
+
+type openMarker struct {
+	marker string
+	typ    EntityType
+	start  int
+}
+
+func (b *builder) inline(s string) {
+	runes := []rune(s)
+	var stack []openMarker
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		if r == '\\' && i+1 < len(runes) && isMarkerRune(runes[i+1]) {
+			b.writeRune(runes[i+1])
+			i += 2
+			continue
+		}
+
+		if r == '`' {
+			if end := indexRune(runes, i+1, '`'); end > i+1 {
+				start := b.pos
+				for _, c := range runes[i+1 : end] {
+					b.writeRune(c)
+				}
+				b.add(Entity{Type: EntityCode, Offset: start, Length: b.pos - start})
+				i = end + 1
+				continue
+			}
+		}
+
+		if marker, typ := markerAt(runes, i); marker != "" {
+			width := len(marker)
+			if n := len(stack); n > 0 && stack[n-1].marker == marker && canClose(runes, i, width) {
+				open := stack[n-1]
+				stack = stack[:n-1]
+				b.add(Entity{Type: typ, Offset: open.start, Length: b.pos - open.start})
+				i += width
+				continue
+			}
+			if canOpen(runes, i, width) && hasClosing(runes, i+width, marker) {
+				stack = append(stack, openMarker{marker: marker, typ: typ, start: b.pos})
+				i += width
+				continue
+			}
+		}
+
+		b.writeRune(r)
+		i++
+	}
+}
+
+func markerAt(runes []rune, i int) (string, EntityType) {
+	switch runes[i] {
+	case '*':
+		if i+1 < len(runes) && runes[i+1] == '*' {
+			return "**", EntityBold
+		}
+		return "*", EntityItalic
+	case '_':
+		return "_", EntityItalic
+	}
+	return "", ""
+}
+
+func canOpen(runes []rune, i, width int) bool {
+	if i > 0 && isWordRune(runes[i-1]) && runes[i] == '_' {
+		return false
+	}
+	return i+width < len(runes) && !unicode.IsSpace(runes[i+width])
+}
+
+func canClose(runes []rune, i, width int) bool {
+	if i+width < len(runes) && isWordRune(runes[i+width]) && runes[i] == '_' {
+		return false
+	}
+	return i > 0 && !unicode.IsSpace(runes[i-1])
+}
+
+func hasClosing(runes []rune, from int, marker string) bool {
+	width := len(marker)
+	for i := from; i+width <= len(runes); i++ {
+		if runes[i] == '\\' {
+			i++
+			continue
+		}
+		if string(runes[i:i+width]) != marker {
+			continue
+		}
+		// A lone "*" must not match the first half of a "**" pair.
+		if marker == "*" && i+1 < len(runes) && runes[i+1] == '*' {
+			i++
+			continue
+		}
+		if canClose(runes, i, width) {
+			return true
+		}
+	}
+	return false
+}
+
+func indexRune(runes []rune, from int, target rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == target {
+			return i
+		}
+	}
+	return -1
+}
+
+func isMarkerRune(r rune) bool {
+	return r == '*' || r == '_' || r == '`' || r == '\\' || r == '>'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isBullet(line string) bool {
+	return strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ ")
+}
+
+func orderedItem(line string) (int, string, bool) {
+	dot := strings.Index(line, ". ")
+	if dot < 1 || dot > 9 {
+		return 0, "", false
+	}
+	for _, r := range line[:dot] {
+		if r < '0' || r > '9' {
+			return 0, "", false
+		}
+	}
+	number, err := strconv.Atoi(line[:dot])
+	if err != nil {
+		return 0, "", false
+	}
+	return number, line[dot+2:], true
+}
+
+func fenceOpen(line string) (string, bool) {
+	if !strings.HasPrefix(line, "```") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "```")), true
+}
+
+func fenceClose(lines []string, from int) int {
+	for i := from; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "```" {
+			return i
+		}
+	}
+	return -1
+}
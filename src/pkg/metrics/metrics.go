@@ -0,0 +1,300 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LatencyBuckets are histogram bounds, in seconds, for request-scale
+// latencies.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// Histogram counts observations into buckets, in the Prometheus sense: each
+// bucket counts the observations at or below its bound. Like a Counter, it
+// counts separately for each combination of the values of its labels.
+type Histogram struct {
+	name   string
+	help   string
+	bounds []float64
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// histogramSeries holds the observations of one combination of label
+// values.
+type histogramSeries struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func NewHistogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	sorted := append([]float64(nil), bounds...)
+	sort.Float64s(sorted)
+	return &Histogram{name: name, help: help, bounds: sorted, labels: labels, series: make(map[string]*histogramSeries)}
+}
+
+// Observe records an observation with the given label values, in the order
+// the labels were declared.
+func (h *Histogram) Observe(d time.Duration, values ...string) {
+	seconds := d.Seconds()
+	key := strings.Join(values, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	series, ok := h.series[key]
+	if !ok {
+		series = &histogramSeries{counts: make([]uint64, len(h.bounds))}
+		h.series[key] = series
+	}
+	// counts holds per-bucket counts; they are accumulated when written.
+	if i := sort.SearchFloat64s(h.bounds, seconds); i < len(h.bounds) {
+		series.counts[i]++
+	}
+	series.sum += seconds
+	series.count++
+}
+
+func (h *Histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	keys := make([]string, 0, len(h.series))
+	series := make(map[string]histogramSeries, len(h.series))
+	for key, s := range h.series {
+		keys = append(keys, key)
+		series[key] = histogramSeries{counts: append([]uint64(nil), s.counts...), sum: s.sum, count: s.count}
+	}
+	h.mu.Unlock()
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	// An unlabelled histogram is written, empty, before its first
+	// observation, as it always was.
+	if len(h.labels) == 0 && len(keys) == 0 {
+		keys = append(keys, "")
+		series[""] = histogramSeries{counts: make([]uint64, len(h.bounds))}
+	}
+	for _, key := range keys {
+		s := series[key]
+		pairs := labelPairs(h.labels, key)
+		var cumulative uint64
+		for i, bound := range h.bounds {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, joinLabels(pairs, fmt.Sprintf("le=%q", formatFloat(bound))), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s} %d\n", h.name, joinLabels(pairs, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n%s_count%s %d\n", h.name, braces(pairs), formatFloat(s.sum), h.name, braces(pairs), s.count)
+	}
+}
+
+// Counter counts events, separately for each combination of the values of
+// its labels.
+type Counter struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{name: name, help: help, labels: labels, counts: make(map[string]uint64)}
+}
+
+// Inc counts an event with the given label values, in the order the
+// labels were declared.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add counts n events with the given label values.
+func (c *Counter) Add(n uint64, values ...string) {
+	key := strings.Join(values, "\xff")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[key] += n
+}
+
+func (c *Counter) writeTo(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.counts))
+	for key := range c.counts {
+		keys = append(keys, key)
+	}
+	counts := make(map[string]uint64, len(c.counts))
+	for key, count := range c.counts {
+		counts[key] = count
+	}
+	c.mu.Unlock()
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %d\n", c.name, braces(labelPairs(c.labels, key)), counts[key])
+	}
+}
+
+// Gauge holds values that go up and down, such as the length of a queue,
+// separately for each combination of the values of its labels.
+type Gauge struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// Set sets the value for the given label values, in the order the labels
+// were declared.
+func (g *Gauge) Set(value float64, values ...string) {
+	key := strings.Join(values, "\xff")
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = value
+}
+
+func (g *Gauge) writeTo(w io.Writer) {
+	g.mu.Lock()
+	keys := make([]string, 0, len(g.values))
+	values := make(map[string]float64, len(g.values))
+	for key, value := range g.values {
+		keys = append(keys, key)
+		values[key] = value
+	}
+	g.mu.Unlock()
+	sort.Strings(keys)
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, braces(labelPairs(g.labels, key)), formatFloat(values[key]))
+	}
+}
+
+// labelPairs renders the label values joined in key as name="value" pairs.
+func labelPairs(labels []string, key string) []string {
+	values := strings.Split(key, "\xff")
+	pairs := make([]string, 0, len(labels))
+	for i, label := range labels {
+		if i < len(values) {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", label, values[i]))
+		}
+	}
+	return pairs
+}
+
+func joinLabels(pairs []string, extra string) string {
+	return strings.Join(append(append([]string(nil), pairs...), extra), ",")
+}
+
+// braces wraps label pairs for a sample, or returns nothing without any.
+func braces(pairs []string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Registry holds the instruments exposed on the metrics endpoint.
+type Registry struct {
+	mu         sync.Mutex
+	histograms []*Histogram
+	counters   []*Counter
+	gauges     []*Gauge
+	slos       []*SLO
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Histogram creates and registers a histogram.
+func (r *Registry) Histogram(name, help string, bounds []float64, labels ...string) *Histogram {
+	h := NewHistogram(name, help, bounds, labels...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.histograms = append(r.histograms, h)
+	return h
+}
+
+// Counter creates and registers a counter.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c := NewCounter(name, help, labels...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = append(r.counters, c)
+	return c
+}
+
+// Gauge creates and registers a gauge.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	g := NewGauge(name, help, labels...)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges = append(r.gauges, g)
+	return g
+}
+
+// SLO creates and registers an SLO.
+func (r *Registry) SLO(name string, objective float64, threshold time.Duration) *SLO {
+	slo := NewSLO(name, objective, threshold)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.slos = append(r.slos, slo)
+	return slo
+}
+
+// SLOs returns the registered SLOs.
+func (r *Registry) SLOs() []*SLO {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*SLO(nil), r.slos...)
+}
+
+// WritePrometheus writes every instrument in the Prometheus text format.
+// SLOs are written as burn-rate gauges per window, for alerting rules.
+func (r *Registry) WritePrometheus(w io.Writer, now time.Time) {
+	r.mu.Lock()
+	histograms := append([]*Histogram(nil), r.histograms...)
+	counters := append([]*Counter(nil), r.counters...)
+	gauges := append([]*Gauge(nil), r.gauges...)
+	slos := append([]*SLO(nil), r.slos...)
+	r.mu.Unlock()
+
+	for _, h := range histograms {
+		h.writeTo(w)
+	}
+	for _, c := range counters {
+		c.writeTo(w)
+	}
+	for _, g := range gauges {
+		g.writeTo(w)
+	}
+	if len(slos) == 0 {
+		return
+	}
+	fmt.Fprint(w, "# HELP afrochat_slo_burn_rate Rate at which the SLO's error budget is being spent; 1 spends it exactly over the SLO period.\n# TYPE afrochat_slo_burn_rate gauge\n")
+	for _, slo := range slos {
+		for _, window := range slo.Status(now).Windows {
+			fmt.Fprintf(w, "afrochat_slo_burn_rate{slo=%q,window=%q} %s\n", slo.Name(), window.Window, formatFloat(window.BurnRate))
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
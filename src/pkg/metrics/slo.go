@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+// sloHistory is how far back an SLO keeps per-minute counts: the longest
+// window any burn-rate rule looks at.
+const sloHistory = 6 * time.Hour
+
+// burnRateWindows are the windows burn rates are reported over.
+var burnRateWindows = []struct {
+	label    string
+	duration time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+// burnRateAlerts are multiwindow burn-rate alerts: each fires when both its
+// long and its short window burn faster than the factor. The long window
+// makes the alert significant and the short one makes it stop soon after
+// the problem does. A factor of 14.4 over an hour spends 2% of a 30-day
+// budget; 6 over six hours spends 5%.
+var burnRateAlerts = []struct {
+	long, short time.Duration
+	factor      float64
+}{
+	{time.Hour, 5 * time.Minute, 14.4},
+	{6 * time.Hour, 30 * time.Minute, 6},
+}
+
+// SLO tracks a latency objective: Objective of events should take at most
+// Threshold. Events are counted per minute, so burn rates can be computed
+// over recent windows.
+type SLO struct {
+	name      string
+	objective float64
+	threshold time.Duration
+
+	mu      sync.Mutex
+	minutes []sloMinute
+}
+
+type sloMinute struct {
+	start time.Time
+	good  uint64
+	total uint64
+}
+
+func NewSLO(name string, objective float64, threshold time.Duration) *SLO {
+	return &SLO{
+		name:      name,
+		objective: objective,
+		threshold: threshold,
+		minutes:   make([]sloMinute, int(sloHistory/time.Minute)),
+	}
+}
+
+func (s *SLO) Name() string {
+	return s.name
+}
+
+// Record counts an event that took latency, finishing at now.
+func (s *SLO) Record(latency time.Duration, now time.Time) {
+	start := now.Truncate(time.Minute)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := &s.minutes[start.Unix()/60%int64(len(s.minutes))]
+	if !slot.start.Equal(start) {
+		*slot = sloMinute{start: start}
+	}
+	slot.total++
+	if latency <= s.threshold {
+		slot.good++
+	}
+}
+
+// WindowStatus is how an SLO fared over one recent window.
+type WindowStatus struct {
+	Window    string  `json:"window"`
+	Events    uint64  `json:"events"`
+	ErrorRate float64 `json:"error_rate"`
+	BurnRate  float64 `json:"burn_rate"`
+}
+
+// SLOStatus is an SLO's recent performance and whether it is alerting.
+type SLOStatus struct {
+	Name             string         `json:"name"`
+	Objective        float64        `json:"objective"`
+	ThresholdSeconds float64        `json:"threshold_seconds"`
+	Windows          []WindowStatus `json:"windows"`
+	Alerting         bool           `json:"alerting"`
+}
+
+// Status computes the SLO's burn rates as of now.
+func (s *SLO) Status(now time.Time) SLOStatus {
+	status := SLOStatus{
+		Name:             s.name,
+		Objective:        s.objective,
+		ThresholdSeconds: s.threshold.Seconds(),
+	}
+	burn := make(map[time.Duration]float64, len(burnRateWindows))
+	for _, window := range burnRateWindows {
+		good, total := s.count(window.duration, now)
+		w := WindowStatus{Window: window.label, Events: total}
+		if total > 0 {
+			w.ErrorRate = float64(total-good) / float64(total)
+			w.BurnRate = w.ErrorRate / (1 - s.objective)
+		}
+		burn[window.duration] = w.BurnRate
+		status.Windows = append(status.Windows, w)
+	}
+	for _, alert := range burnRateAlerts {
+		if burn[alert.long] > alert.factor && burn[alert.short] > alert.factor {
+			status.Alerting = true
+		}
+	}
+	return status
+}
+
+// count sums the minutes that started within window of now.
+func (s *SLO) count(window time.Duration, now time.Time) (good, total uint64) {
+	since := now.Truncate(time.Minute).Add(-window)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, minute := range s.minutes {
+		if minute.start.After(since) && !minute.start.After(now) {
+			good += minute.good
+			total += minute.total
+		}
+	}
+	return good, total
+}
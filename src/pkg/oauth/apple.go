@@ -0,0 +1,144 @@
+package oauth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	appleIssuer   = "https://appleid.apple.com"
+	appleAuthURL  = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL = "https://appleid.apple.com/auth/token"
+
+	// appleSecretLifetime is how long each client secret is valid. A new
+	// one is signed for every exchange.
+	appleSecretLifetime = 5 * time.Minute
+)
+
+// AppleConfig identifies the Services ID users sign in to and the key
+// that authenticates the server to Apple.
+type AppleConfig struct {
+	// ClientID is the Services ID.
+	ClientID string
+
+	KeyFile string
+	KeyID   string
+	TeamID  string
+}
+
+// Apple signs users in with their Apple IDs. Apple posts the code back as
+// a form, so the callback must accept POST.
+type Apple struct {
+	config AppleConfig
+	key    *ecdsa.PrivateKey
+	client *http.Client
+}
+
+// NewApple reads the .p8 Sign in with Apple key downloaded from the Apple
+// developer portal.
+func NewApple(config AppleConfig) (*Apple, error) {
+	raw, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Sign in with Apple key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Sign in with Apple key: %w", err)
+	}
+	return &Apple{config: config, key: key, client: &http.Client{Timeout: requestTimeout}}, nil
+}
+
+// AuthCodeURL ignores challenge: Apple does not support PKCE.
+func (a *Apple) AuthCodeURL(state, _, redirectURI string) string {
+	params := url.Values{
+		"client_id":     {a.config.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"response_mode": {"form_post"},
+		"scope":         {"name email"},
+		"state":         {state},
+	}
+	return appleAuthURL + "?" + params.Encode()
+}
+
+func (a *Apple) Exchange(ctx context.Context, code, _, redirectURI string, callback url.Values) (*Identity, error) {
+	secret, err := a.clientSecret()
+	if err != nil {
+		return nil, err
+	}
+	token, err := redeemCode(ctx, a.client, appleTokenURL, url.Values{
+		"client_id":     {a.config.ClientID},
+		"client_secret": {secret},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The ID token came straight from Apple over TLS, so its signature
+	// needs no checking; its issuer and audience still do.
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token.IDToken, claims); err != nil {
+		return nil, fmt.Errorf("failed to parse Apple ID token: %w", err)
+	}
+	issuer, _ := claims.GetIssuer()
+	audience, _ := claims.GetAudience()
+	subject, _ := claims.GetSubject()
+	if issuer != appleIssuer || len(audience) != 1 || audience[0] != a.config.ClientID {
+		return nil, errors.New("apple ID token was issued for another client")
+	}
+	if subject == "" {
+		return nil, errors.New("apple ID token has no subject")
+	}
+	identity := &Identity{Subject: subject}
+	identity.Email, _ = claims["email"].(string)
+	// email_verified is a string in some tokens and a boolean in others.
+	switch verified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = verified
+	case string:
+		identity.EmailVerified = verified == "true"
+	}
+
+	// Apple sends the user's name only the first time they sign in, in
+	// the callback rather than the ID token.
+	var user struct {
+		Name struct {
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"name"`
+	}
+	if raw := callback.Get("user"); raw != "" && json.Unmarshal([]byte(raw), &user) == nil {
+		identity.Name = strings.TrimSpace(user.Name.FirstName + " " + user.Name.LastName)
+	}
+	return identity, nil
+}
+
+// clientSecret signs the JWT Apple accepts as a client secret.
+func (a *Apple) clientSecret() (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": a.config.TeamID,
+		"sub": a.config.ClientID,
+		"aud": appleIssuer,
+		"iat": now.Unix(),
+		"exp": now.Add(appleSecretLifetime).Unix(),
+	})
+	token.Header["kid"] = a.config.KeyID
+	signed, err := token.SignedString(a.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign Apple client secret: %w", err)
+	}
+	return signed, nil
+}
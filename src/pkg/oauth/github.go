@@ -0,0 +1,83 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	githubAuthURL   = "https://github.com/login/oauth/authorize"
+	githubTokenURL  = "https://github.com/login/oauth/access_token"
+	githubUserURL   = "https://api.github.com/user"
+	githubEmailsURL = "https://api.github.com/user/emails"
+)
+
+// GitHub signs users in with their GitHub accounts through a GitHub OAuth
+// app.
+type GitHub struct {
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+func NewGitHub(clientID, clientSecret string) *GitHub {
+	return &GitHub{clientID: clientID, clientSecret: clientSecret, client: &http.Client{Timeout: requestTimeout}}
+}
+
+func (g *GitHub) AuthCodeURL(state, challenge, redirectURI string) string {
+	params := url.Values{
+		"client_id":             {g.clientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"read:user user:email"},
+		"state":                 {state},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	return githubAuthURL + "?" + params.Encode()
+}
+
+func (g *GitHub) Exchange(ctx context.Context, code, verifier, redirectURI string, _ url.Values) (*Identity, error) {
+	token, err := redeemCode(ctx, g.client, githubTokenURL, url.Values{
+		"client_id":     {g.clientID},
+		"client_secret": {g.clientSecret},
+		"code":          {code},
+		"code_verifier": {verifier},
+		"redirect_uri":  {redirectURI},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, g.client, githubUserURL, token.AccessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("github user has no id")
+	}
+	identity := &Identity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name, Username: user.Login}
+
+	// The profile only shows an email the user made public, and not
+	// whether it is verified; the emails endpoint shows both.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, g.client, githubEmailsURL, token.AccessToken, &emails); err != nil {
+		return nil, err
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email, identity.EmailVerified = email.Email, email.Verified
+		}
+	}
+	return identity, nil
+}
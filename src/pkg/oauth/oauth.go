@@ -0,0 +1,126 @@
+// Package oauth signs users in with their accounts at Google, GitHub and
+// Apple through the OAuth 2.0 authorization code flow. Providers only
+// identify the user; what happens to the identity is up to the caller.
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const requestTimeout = 10 * time.Second
+
+// Identity is the account a user signed in with, as the provider describes
+// it.
+type Identity struct {
+	// Subject is the provider's stable ID for the account. Emails and
+	// usernames can change; it does not.
+	Subject string
+
+	Email string
+
+	// EmailVerified reports whether the provider checked that the user
+	// owns Email.
+	EmailVerified bool
+
+	Name string
+
+	// Username is the account's handle at the provider, if it has one.
+	Username string
+}
+
+// Provider is an OAuth 2.0 authorization server users sign in with.
+type Provider interface {
+	// AuthCodeURL is where to send the user to sign in. The provider sends
+	// them back to redirectURI with a code and state. challenge is the
+	// PKCE S256 challenge of the verifier later passed to Exchange.
+	AuthCodeURL(state, challenge, redirectURI string) string
+
+	// Exchange redeems a code for the identity of the user who signed in.
+	// callback holds every parameter the provider sent back with it.
+	Exchange(ctx context.Context, code, verifier, redirectURI string, callback url.Values) (*Identity, error)
+}
+
+// NewVerifier returns a random PKCE code verifier.
+func NewVerifier() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate PKCE verifier: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Challenge returns the S256 PKCE challenge of a verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// redeemCode posts an authorization code grant to a token endpoint.
+func redeemCode(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (*tokenResponse, error) {
+	form.Set("grant_type", "authorization_code")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach token endpoint: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode token response with status %d: %w", resp.StatusCode, err)
+	}
+	// GitHub reports errors with status 200.
+	if token.Error != "" {
+		return nil, fmt.Errorf("token endpoint refused code: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("token endpoint refused code with status %d", resp.StatusCode)
+	}
+	return &token, nil
+}
+
+// getJSON fetches a resource with an access token.
+func getJSON(ctx context.Context, client *http.Client, resourceURL, accessToken string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, resourceURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", resourceURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s answered with status %d", resourceURL, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", resourceURL, err)
+	}
+	return nil
+}
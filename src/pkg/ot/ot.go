@@ -0,0 +1,254 @@
+// Package ot merges concurrent edits to plain text by operational
+// transformation. Several people can edit the same text at once: each
+// edit is made against the version its author saw, and transforming it
+// past the edits made since lets the server apply it without losing
+// anyone's changes.
+//
+// Positions count Unicode code points.
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalid means an operation is malformed or does not fit the text it
+// is applied to.
+var ErrInvalid = errors.New("invalid operation")
+
+// Component is one step of an operation. Exactly one field is set.
+type Component struct {
+	Retain int
+	Insert string
+	Delete int
+}
+
+// Operation is an edit to a whole text: its components run from the start
+// of the text to the end, keeping, inserting and deleting text as they go.
+//
+// In JSON an operation is an array in which a positive number retains that
+// many characters, a negative number deletes them and a string inserts
+// itself, so [5, "big ", -3] keeps the first five characters, inserts
+// "big " and deletes the three after them.
+type Operation []Component
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	parts := make([]any, len(o))
+	for i, c := range o {
+		switch {
+		case c.Retain > 0:
+			parts[i] = c.Retain
+		case c.Delete > 0:
+			parts[i] = -c.Delete
+		default:
+			parts[i] = c.Insert
+		}
+	}
+	return json.Marshal(parts)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	var parts []any
+	if err := json.Unmarshal(data, &parts); err != nil {
+		return err
+	}
+	var b builder
+	for _, part := range parts {
+		switch part := part.(type) {
+		case float64:
+			n := int(part)
+			if float64(n) != part || n == 0 {
+				return fmt.Errorf("%w: counts must be non-zero integers", ErrInvalid)
+			}
+			if n > 0 {
+				b.retain(n)
+			} else {
+				b.delete(-n)
+			}
+		case string:
+			if part == "" {
+				return fmt.Errorf("%w: inserts must not be empty", ErrInvalid)
+			}
+			b.insert(part)
+		default:
+			return fmt.Errorf("%w: components must be numbers or strings", ErrInvalid)
+		}
+	}
+	*o = b.op
+	return nil
+}
+
+// BaseLen is the length of the text the operation applies to.
+func (o Operation) BaseLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + c.Delete
+	}
+	return n
+}
+
+// TargetLen is the length of the text the operation produces.
+func (o Operation) TargetLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + utf8.RuneCountInString(c.Insert)
+	}
+	return n
+}
+
+// Noop reports whether the operation leaves the text unchanged.
+func (o Operation) Noop() bool {
+	for _, c := range o {
+		if c.Retain == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply returns text with the operation applied.
+func (o Operation) Apply(text string) (string, error) {
+	runes := []rune(text)
+	if o.BaseLen() != len(runes) {
+		return "", fmt.Errorf("%w: operation spans %d characters, text has %d", ErrInvalid, o.BaseLen(), len(runes))
+	}
+	var out strings.Builder
+	pos := 0
+	for _, c := range o {
+		switch {
+		case c.Retain > 0:
+			out.WriteString(string(runes[pos : pos+c.Retain]))
+			pos += c.Retain
+		case c.Delete > 0:
+			pos += c.Delete
+		default:
+			out.WriteString(c.Insert)
+		}
+	}
+	return out.String(), nil
+}
+
+// Transform takes two operations made concurrently against the same text
+// and returns a' and b' such that applying a then b' gives the same text
+// as applying b then a'. Where both insert at the same place, a's insert
+// comes first.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, fmt.Errorf("%w: concurrent operations span %d and %d characters", ErrInvalid, a.BaseLen(), b.BaseLen())
+	}
+
+	var a1, b1 builder
+	i, j := 0, 0
+	var ca, cb *Component
+	next := func(op Operation, k *int) *Component {
+		if *k >= len(op) {
+			return nil
+		}
+		c := op[*k]
+		*k++
+		return &c
+	}
+	ca, cb = next(a, &i), next(b, &j)
+	for ca != nil || cb != nil {
+		if ca != nil && ca.Insert != "" {
+			a1.insert(ca.Insert)
+			b1.retain(utf8.RuneCountInString(ca.Insert))
+			ca = next(a, &i)
+			continue
+		}
+		if cb != nil && cb.Insert != "" {
+			a1.retain(utf8.RuneCountInString(cb.Insert))
+			b1.insert(cb.Insert)
+			cb = next(b, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return nil, nil, fmt.Errorf("%w: concurrent operations do not line up", ErrInvalid)
+		}
+		lenA, lenB := ca.Retain+ca.Delete, cb.Retain+cb.Delete
+		n := min(lenA, lenB)
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			a1.retain(n)
+			b1.retain(n)
+		case ca.Delete > 0 && cb.Retain > 0:
+			a1.delete(n)
+		case ca.Retain > 0 && cb.Delete > 0:
+			b1.delete(n)
+		}
+		// Text both deleted is simply gone.
+		if ca = shorten(ca, n); ca == nil {
+			ca = next(a, &i)
+		}
+		if cb = shorten(cb, n); cb == nil {
+			cb = next(b, &j)
+		}
+	}
+	return a1.op, b1.op, nil
+}
+
+// shorten consumes n characters of a retain or delete, returning nil once
+// it is used up.
+func shorten(c *Component, n int) *Component {
+	if c.Retain > 0 {
+		c.Retain -= n
+	} else {
+		c.Delete -= n
+	}
+	if c.Retain == 0 && c.Delete == 0 {
+		return nil
+	}
+	return c
+}
+
+// builder assembles operations in canonical form: adjacent components of
+// the same kind are merged, and an insert next to a delete comes first.
+type builder struct {
+	op Operation
+}
+
+func (b *builder) retain(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(b.op) - 1; last >= 0 && b.op[last].Retain > 0 {
+		b.op[last].Retain += n
+		return
+	}
+	b.op = append(b.op, Component{Retain: n})
+}
+
+func (b *builder) delete(n int) {
+	if n <= 0 {
+		return
+	}
+	if last := len(b.op) - 1; last >= 0 && b.op[last].Delete > 0 {
+		b.op[last].Delete += n
+		return
+	}
+	b.op = append(b.op, Component{Delete: n})
+}
+
+func (b *builder) insert(s string) {
+	if s == "" {
+		return
+	}
+	last := len(b.op) - 1
+	if last >= 0 && b.op[last].Delete > 0 {
+		if last >= 1 && b.op[last-1].Insert != "" {
+			b.op[last-1].Insert += s
+			return
+		}
+		b.op = append(b.op, b.op[last])
+		b.op[last] = Component{Insert: s}
+		return
+	}
+	if last >= 0 && b.op[last].Insert != "" {
+		b.op[last].Insert += s
+		return
+	}
+	b.op = append(b.op, Component{Insert: s})
+}
@@ -0,0 +1,74 @@
+package pagination
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidLimit  = errors.New("invalid limit")
+)
+
+// Cursor marks a position in a list ordered by (Time, ID). Clients treat
+// the encoded form as opaque.
+type Cursor struct {
+	Time time.Time
+	ID   uuid.UUID
+}
+
+func (c Cursor) Encode() string {
+	raw := strconv.FormatInt(c.Time.UnixNano(), 10) + ":" + c.ID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// Before reports whether c comes before other in (Time, ID) order.
+func (c Cursor) Before(other Cursor) bool {
+	if !c.Time.Equal(other.Time) {
+		return c.Time.Before(other.Time)
+	}
+	return bytes.Compare(c.ID[:], other.ID[:]) < 0
+}
+
+// Decode parses an encoded cursor. An empty string means "from the start"
+// and yields a nil cursor.
+func Decode(encoded string) (*Cursor, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parsedID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Time: time.Unix(0, n).UTC(), ID: parsedID}, nil
+}
+
+// Limit parses a page size, using fallback when raw is empty.
+func Limit(raw string, fallback, max int) (int, error) {
+	if raw == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > max {
+		return 0, ErrInvalidLimit
+	}
+	return limit, nil
+}
@@ -0,0 +1,279 @@
+// Package pdf writes plain text documents as PDF: pages of wrapped lines
+// set in the standard Helvetica fonts, which every reader provides, so no
+// font is embedded. Text outside Windows-1252 is shown as question marks.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Style is a face of Helvetica.
+type Style int
+
+const (
+	Regular Style = iota
+	Bold
+	Oblique
+)
+
+// fontNames are the base fonts of the styles, in resource order.
+var fontNames = [...]string{"Helvetica", "Helvetica-Bold", "Helvetica-Oblique"}
+
+const (
+	// A4 in points.
+	pageWidth  = 595.0
+	pageHeight = 842.0
+	margin     = 56.0
+
+	// lineSpacing is the leading as a multiple of the font size.
+	lineSpacing = 1.3
+	footerSize  = 8.0
+)
+
+// Document is a PDF being written a line at a time.
+type Document struct {
+	title   string
+	created time.Time
+	pages   []*bytes.Buffer
+	y       float64
+}
+
+// New starts a document with the given title, shown by readers in place
+// of the file name.
+func New(title string, created time.Time) *Document {
+	return &Document{title: title, created: created}
+}
+
+// Write sets text in style at size points, wrapped to the page width and
+// continued on new pages as needed. Newlines start new lines.
+func (d *Document) Write(style Style, size float64, text string) {
+	leading := size * lineSpacing
+	for _, paragraph := range strings.Split(text, "\n") {
+		for _, line := range wrap(style, size, paragraph, pageWidth-2*margin) {
+			page := d.reserve(leading)
+			fmt.Fprintf(page, "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n", style+1, size, margin, d.y, escape(line))
+		}
+	}
+}
+
+// Gap leaves points of vertical space, unless at the top of a page.
+func (d *Document) Gap(points float64) {
+	if len(d.pages) == 0 || d.y == pageHeight-margin {
+		return
+	}
+	d.y -= points
+}
+
+// reserve makes room for a line of the given height and returns the page
+// to draw it on, with d.y at its baseline.
+func (d *Document) reserve(height float64) *bytes.Buffer {
+	if len(d.pages) == 0 || d.y-height < margin {
+		d.pages = append(d.pages, &bytes.Buffer{})
+		d.y = pageHeight - margin
+	}
+	d.y -= height
+	return d.pages[len(d.pages)-1]
+}
+
+// WriteTo writes the finished document, numbering its pages in their
+// footers. A document with nothing written has one blank page.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.pages = append(d.pages, &bytes.Buffer{})
+	}
+
+	out := &bytes.Buffer{}
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 4 are the catalog, the page tree, the fonts' resource
+	// dictionary and the document info; the fonts follow, then each page
+	// with its content stream.
+	fontBase := 5
+	pageBase := fontBase + len(fontNames)
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", pageBase+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	fonts := make([]string, len(fontNames))
+	for i := range fontNames {
+		fonts[i] = fmt.Sprintf("/F%d %d 0 R", i+1, fontBase+i)
+	}
+	object(fmt.Sprintf("<< /Font << %s >> >>", strings.Join(fonts, " ")))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (AfroChat) /CreationDate (D:%s) >>",
+		escape(d.title), d.created.UTC().Format("20060102150405Z")))
+	for _, name := range fontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+	}
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("Page %d of %d", i+1, len(d.pages))
+		x := pageWidth - margin - width(Regular, footerSize, footer)
+		fmt.Fprintf(page, "BT /F1 %.1f Tf %.2f %.2f Td (%s) Tj ET\n", footerSize, x, margin/2, escape(footer))
+
+		stream, err := deflate(page.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources 3 0 R /Contents %d 0 R >>",
+			pageWidth, pageHeight, pageBase+2*i+1))
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(out, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.WriteTo(w)
+}
+
+func deflate(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return nil, fmt.Errorf("failed to compress page: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress page: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// wrap breaks text into lines no wider than maxWidth, between words where
+// it can and within a word too long for a line of its own.
+func wrap(style Style, size float64, text string, maxWidth float64) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	line := ""
+	for _, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if width(style, size, candidate) <= maxWidth {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		for width(style, size, word) > maxWidth {
+			cut := fit(style, size, word, maxWidth)
+			lines = append(lines, word[:cut])
+			word = word[cut:]
+		}
+		line = word
+	}
+	return append(lines, line)
+}
+
+// fit returns the length in bytes of the longest prefix of word that fits
+// in maxWidth, at least one rune.
+func fit(style Style, size float64, word string, maxWidth float64) int {
+	end := 0
+	for i, r := range word {
+		if end > 0 && width(style, size, word[:i+utf8.RuneLen(r)]) > maxWidth {
+			break
+		}
+		end = i + utf8.RuneLen(r)
+	}
+	return end
+}
+
+// width is how wide text is set in style at size points.
+func width(style Style, size float64, text string) float64 {
+	widths := &regularWidths
+	if style == Bold {
+		widths = &boldWidths
+	}
+	units := 0
+	for _, r := range text {
+		if r >= ' ' && r <= '~' {
+			units += widths[r-' ']
+		} else {
+			units += defaultWidth
+		}
+	}
+	return float64(units) * size / 1000
+}
+
+// escape encodes text in Windows-1252 as the body of a PDF string.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		c, ok := winAnsi(r)
+		if !ok {
+			c = '?'
+		}
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// winAnsi returns the Windows-1252 code of r, with control characters as
+// spaces.
+func winAnsi(r rune) (byte, bool) {
+	switch {
+	case r < ' ':
+		return ' ', true
+	case r < 0x7f, r >= 0xa0 && r <= 0xff:
+		return byte(r), true
+	}
+	c, ok := winAnsiExtras[r]
+	return c, ok
+}
+
+// winAnsiExtras are the characters Windows-1252 has in 0x80 to 0x9f.
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87,
+	'ˆ': 0x88, '‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e,
+	'‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+	'˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b, 'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// Glyph widths in thousandths of the font size, for ' ' through '~' in
+// Helvetica and Helvetica-Bold; Helvetica-Oblique shares Helvetica's.
+// Other characters are taken to be as wide as a digit.
+const defaultWidth = 556
+
+var regularWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var boldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
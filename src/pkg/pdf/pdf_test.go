@@ -0,0 +1,55 @@
+package pdf_test
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/pdf"
+)
+
+func TestDocumentStructure(t *testing.T) {
+	doc := pdf.New("Transcript (draft)", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC))
+	for i := range 120 {
+		doc.Write(pdf.Bold, 10, fmt.Sprintf("Sender %d", i))
+		doc.Write(pdf.Regular, 10, strings.Repeat("A long message that wraps over several lines. ", 6))
+		doc.Gap(6)
+	}
+	var out bytes.Buffer
+	if _, err := doc.WriteTo(&out); err != nil {
+		t.Fatal(err)
+	}
+	file := out.Bytes()
+
+	if !bytes.HasPrefix(file, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(file, []byte("%%EOF\n")) {
+		t.Fatal("output is not framed as a PDF")
+	}
+	pages := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(file)
+	if pages == nil {
+		t.Fatal("no page tree")
+	}
+	if count, _ := strconv.Atoi(string(pages[1])); count < 2 {
+		t.Errorf("document has %d pages, want the text continued on more", count)
+	}
+	if !bytes.Contains(file, []byte(`/Title (Transcript \(draft\))`)) {
+		t.Error("title is missing or its parentheses unescaped")
+	}
+
+	// Every cross-reference entry points at the object it numbers.
+	start := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(file)
+	xref, _ := strconv.Atoi(string(start[1]))
+	if !bytes.HasPrefix(file[xref:], []byte("xref\n")) {
+		t.Fatal("startxref does not point at the cross-reference table")
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(file[xref:], -1)
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(file[offset:], []byte(want)) {
+			t.Errorf("entry %d points at %q", i+1, file[offset:offset+10])
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package phone normalizes phone numbers, written the way people write
+// them, to E.164: a +, the country calling code and the national number,
+// with nothing else. Numbers written without a country code are read as
+// dialled from a region, usually the user's own.
+//
+// Validation is by the length of the national number in each region,
+// which catches typing mistakes without the full numbering plans. Regions
+// missing from the table are accepted on the general E.164 rules alone.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrInvalid = errors.New("invalid phone number")
+
+	// ErrNoCountry is returned for a number written without a country code
+	// when there is no region to read it in.
+	ErrNoCountry = errors.New("phone number has no country code")
+)
+
+// numbering describes how numbers are written in a region: its calling
+// code, the trunk prefix dialled before national numbers from inside the
+// country, and the lengths its national numbers can have.
+type numbering struct {
+	code    string
+	trunk   string
+	lengths []int
+}
+
+// regions are keyed by ISO 3166-1 alpha-2 code. Africa comes first and is
+// most complete.
+var regions = map[string]numbering{
+	"ZA": {"27", "0", []int{9}},
+	"NG": {"234", "0", []int{8, 10}},
+	"KE": {"254", "0", []int{9}},
+	"GH": {"233", "0", []int{9}},
+	"EG": {"20", "0", []int{8, 9, 10}},
+	"MA": {"212", "0", []int{9}},
+	"DZ": {"213", "0", []int{8, 9}},
+	"TN": {"216", "", []int{8}},
+	"LY": {"218", "0", []int{9}},
+	"ET": {"251", "0", []int{9}},
+	"TZ": {"255", "0", []int{9}},
+	"UG": {"256", "0", []int{9}},
+	"RW": {"250", "0", []int{9}},
+	"BI": {"257", "", []int{8}},
+	"SO": {"252", "0", []int{8, 9}},
+	"SD": {"249", "0", []int{9}},
+	"SS": {"211", "0", []int{9}},
+	"ZW": {"263", "0", []int{8, 9}},
+	"ZM": {"260", "0", []int{9}},
+	"MW": {"265", "0", []int{7, 9}},
+	"MZ": {"258", "", []int{8, 9}},
+	"BW": {"267", "", []int{7, 8}},
+	"NA": {"264", "0", []int{8, 9}},
+	"LS": {"266", "", []int{8}},
+	"SZ": {"268", "", []int{8}},
+	"AO": {"244", "", []int{9}},
+	"CD": {"243", "0", []int{9}},
+	"CG": {"242", "", []int{9}},
+	"CM": {"237", "", []int{9}},
+	"GA": {"241", "", []int{7, 8}},
+	"CI": {"225", "", []int{10}},
+	"SN": {"221", "", []int{9}},
+	"ML": {"223", "", []int{8}},
+	"BF": {"226", "", []int{8}},
+	"NE": {"227", "", []int{8}},
+	"BJ": {"229", "", []int{8, 10}},
+	"TG": {"228", "", []int{8}},
+	"GM": {"220", "", []int{7}},
+	"GN": {"224", "", []int{9}},
+	"SL": {"232", "0", []int{8}},
+	"LR": {"231", "0", []int{7, 8, 9}},
+	"MG": {"261", "0", []int{9}},
+	"MU": {"230", "", []int{7, 8}},
+
+	"US": {"1", "1", []int{10}},
+	"CA": {"1", "1", []int{10}},
+	"GB": {"44", "0", []int{9, 10}},
+	"IE": {"353", "0", []int{7, 8, 9}},
+	"FR": {"33", "0", []int{9}},
+	"BE": {"32", "0", []int{8, 9}},
+	"NL": {"31", "0", []int{9}},
+	"DE": {"49", "0", []int{6, 7, 8, 9, 10, 11, 12, 13}},
+	"CH": {"41", "0", []int{9}},
+	"PT": {"351", "", []int{9}},
+	"ES": {"34", "", []int{9}},
+	"SE": {"46", "0", []int{7, 8, 9}},
+	"RU": {"7", "8", []int{10}},
+	"TR": {"90", "0", []int{10}},
+	"AE": {"971", "0", []int{8, 9}},
+	"SA": {"966", "0", []int{8, 9}},
+	"IN": {"91", "0", []int{10}},
+	"PK": {"92", "0", []int{10}},
+	"BD": {"880", "0", []int{10}},
+	"CN": {"86", "0", []int{10, 11}},
+	"JP": {"81", "0", []int{9, 10}},
+	"AU": {"61", "0", []int{9}},
+	"BR": {"55", "0", []int{10, 11}},
+	"MX": {"52", "", []int{10}},
+}
+
+// byCode lists the regions sharing each calling code.
+var byCode = func() map[string][]numbering {
+	codes := make(map[string][]numbering)
+	for _, region := range regions {
+		codes[region.code] = append(codes[region.code], region)
+	}
+	return codes
+}()
+
+// Normalize returns raw in E.164. A number with a country code, written
+// with a leading + or an international prefix (00, or 011 from North
+// America), stands alone; one without is read as dialled in region, an
+// ISO 3166-1 alpha-2 code that may be empty.
+func Normalize(raw, region string) (string, error) {
+	digits, international, err := clean(raw)
+	if err != nil {
+		return "", err
+	}
+	region = strings.ToUpper(region)
+	local, known := regions[region]
+
+	if !international {
+		switch {
+		case strings.HasPrefix(digits, "00"):
+			digits, international = digits[2:], true
+		case region != "" && local.code == "1" && strings.HasPrefix(digits, "011"):
+			digits, international = digits[3:], true
+		}
+	}
+	if international {
+		return withCode(digits)
+	}
+	if !known {
+		if region == "" {
+			return "", ErrNoCountry
+		}
+		return "", fmt.Errorf("%w: unknown region %q", ErrInvalid, region)
+	}
+
+	national := digits
+	if local.trunk != "" && strings.HasPrefix(national, local.trunk) && !validLength(local, len(national)) {
+		national = national[len(local.trunk):]
+	}
+	if validLength(local, len(national)) {
+		return "+" + local.code + national, nil
+	}
+	// The country code may have been typed without its +.
+	if strings.HasPrefix(digits, local.code) {
+		return withCode(digits)
+	}
+	return "", fmt.Errorf("%w: wrong length for %s", ErrInvalid, region)
+}
+
+// clean strips the punctuation people write numbers with, reporting
+// whether the number started with a +.
+func clean(raw string) (string, bool, error) {
+	raw = strings.TrimSpace(raw)
+	international := strings.HasPrefix(raw, "+")
+	if international {
+		raw = raw[1:]
+	}
+	var digits strings.Builder
+	for _, r := range raw {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/':
+		default:
+			return "", false, fmt.Errorf("%w: unexpected %q", ErrInvalid, r)
+		}
+	}
+	if digits.Len() == 0 {
+		return "", false, ErrInvalid
+	}
+	return digits.String(), international, nil
+}
+
+// withCode validates digits that start with a calling code.
+func withCode(digits string) (string, error) {
+	// E.164 numbers have at most 15 digits and calling codes do not start
+	// with 0.
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", fmt.Errorf("%w: not an international number", ErrInvalid)
+	}
+	// Calling codes are prefix-free, so at most one of these is a code.
+	for size := 1; size <= 3; size++ {
+		numberings, ok := byCode[digits[:size]]
+		if !ok {
+			continue
+		}
+		for _, n := range numberings {
+			if validLength(n, len(digits)-size) {
+				return "+" + digits, nil
+			}
+		}
+		return "", fmt.Errorf("%w: wrong length for +%s", ErrInvalid, digits[:size])
+	}
+	return "+" + digits, nil
+}
+
+func validLength(n numbering, length int) bool {
+	for _, l := range n.lengths {
+		if l == length {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+// Package prekeys checks the prekeys devices publish so others can start
+// end-to-end encrypted sessions with them while they are offline, as in
+// Signal's X3DH: a signed prekey, signed with the device's Ed25519
+// identity key and replaced every so often, and one-time prekeys, each
+// handed out once. Prekeys are X25519 public keys.
+package prekeys
+
+import (
+	"crypto/ed25519"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// KeySize is the size of an X25519 public key.
+const KeySize = 32
+
+// domain separates signed prekey signatures from anything else the same
+// identity key might sign.
+const domain = "afrochat-signed-prekey-v1"
+
+// ValidKey reports whether key is an X25519 public key.
+func ValidKey(key []byte) bool {
+	return len(key) == KeySize
+}
+
+// Payload is what a device's identity key signs to vouch for its signed
+// prekey: the domain, the user, the device ID, the key ID and the public
+// key, separated by NUL bytes.
+func Payload(userID uuid.UUID, deviceID string, keyID int, publicKey []byte) []byte {
+	payload := make([]byte, 0, len(domain)+len(deviceID)+len(publicKey)+56)
+	payload = append(payload, domain...)
+	payload = append(payload, 0)
+	payload = append(payload, userID.String()...)
+	payload = append(payload, 0)
+	payload = append(payload, deviceID...)
+	payload = append(payload, 0)
+	payload = strconv.AppendInt(payload, int64(keyID), 10)
+	payload = append(payload, 0)
+	return append(payload, publicKey...)
+}
+
+// Verify reports whether signature is the identity key's signature of the
+// signed prekey's Payload.
+func Verify(identityKey, signature []byte, userID uuid.UUID, deviceID string, keyID int, publicKey []byte) bool {
+	if len(identityKey) != ed25519.PublicKeySize || len(signature) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(identityKey, Payload(userID, deviceID, keyID, publicKey), signature)
+}
@@ -0,0 +1,57 @@
+package presence
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// focus is the conversation each connection of each user has in view, for
+// the connections that report it. uuid.Nil means none is.
+type focus struct {
+	mu    sync.Mutex
+	users map[uuid.UUID]map[uuid.UUID]uuid.UUID
+}
+
+// Focus records that a connection of a user has conversationID in view,
+// or no conversation when it is uuid.Nil, as when the app is in the
+// background.
+func (t *Tracker) Focus(userID, connectionID, conversationID uuid.UUID) {
+	t.focus.mu.Lock()
+	defer t.focus.mu.Unlock()
+	if t.focus.users == nil {
+		t.focus.users = make(map[uuid.UUID]map[uuid.UUID]uuid.UUID)
+	}
+	connections, ok := t.focus.users[userID]
+	if !ok {
+		connections = make(map[uuid.UUID]uuid.UUID)
+		t.focus.users[userID] = connections
+	}
+	connections[connectionID] = conversationID
+}
+
+// Unfocus forgets what a connection had in view, once it closes.
+func (t *Tracker) Unfocus(userID, connectionID uuid.UUID) {
+	t.focus.mu.Lock()
+	defer t.focus.mu.Unlock()
+	connections := t.focus.users[userID]
+	delete(connections, connectionID)
+	if len(connections) == 0 {
+		delete(t.focus.users, userID)
+	}
+}
+
+// Viewing reports whether any connection of the user on this instance has
+// conversationID in view, and whether any reports what it has in view at
+// all. A user whose connections report nothing may be looking anywhere.
+func (t *Tracker) Viewing(userID, conversationID uuid.UUID) (viewing, reported bool) {
+	t.focus.mu.Lock()
+	defer t.focus.mu.Unlock()
+	connections := t.focus.users[userID]
+	for _, focused := range connections {
+		if focused == conversationID {
+			return true, true
+		}
+	}
+	return false, len(connections) > 0
+}
@@ -0,0 +1,171 @@
+package presence
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+)
+
+// Update is broadcast when a user's presence changes.
+type Update struct {
+	UserID     uuid.UUID `json:"user_id"`
+	Status     string    `json:"status"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// Store persists presence.
+type Store interface {
+	// SetStatus records a user's status and when they were last seen.
+	SetStatus(ctx context.Context, userID uuid.UUID, status string, lastSeenAt time.Time) error
+	// TouchLastSeen refreshes last_seen_at for users that are still online
+	// and marks them online again.
+	TouchLastSeen(ctx context.Context, userIDs []uuid.UUID, at time.Time) error
+	// ExpireStale marks users offline whose last_seen_at is older than
+	// before, returning them. It clears statuses left behind by instances
+	// that died without marking their users offline.
+	ExpireStale(ctx context.Context, before time.Time) ([]uuid.UUID, error)
+}
+
+// Connections reports which users have live connections on this instance.
+type Connections interface {
+	IsOnline(userID uuid.UUID) bool
+	OnlineUserIDs() []uuid.UUID
+}
+
+// Audience returns who should hear about a user's presence changes.
+type Audience func(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error)
+
+// Broadcast delivers an update to the given users.
+type Broadcast func(userIDs []uuid.UUID, update Update)
+
+// Tracker turns connect and disconnect events into presence changes. A
+// user only goes offline once they have had no connection for the grace
+// period, so reconnects and network blips do not flap their status.
+type Tracker struct {
+	connections Connections
+	store       Store
+	audience    Audience
+	broadcast   Broadcast
+	grace       time.Duration
+
+	mu      sync.Mutex
+	online  map[uuid.UUID]bool
+	pending map[uuid.UUID]*time.Timer
+
+	focus focus
+}
+
+func NewTracker(connections Connections, store Store, audience Audience, broadcast Broadcast, grace time.Duration) *Tracker {
+	return &Tracker{
+		connections: connections,
+		store:       store,
+		audience:    audience,
+		broadcast:   broadcast,
+		grace:       grace,
+		online:      make(map[uuid.UUID]bool),
+		pending:     make(map[uuid.UUID]*time.Timer),
+	}
+}
+
+// Connected is called whenever a user opens a connection.
+func (t *Tracker) Connected(userID uuid.UUID) {
+	t.mu.Lock()
+	if timer, ok := t.pending[userID]; ok {
+		timer.Stop()
+		delete(t.pending, userID)
+	}
+	if t.online[userID] {
+		t.mu.Unlock()
+		return
+	}
+	t.online[userID] = true
+	t.mu.Unlock()
+
+	t.publish(userID, StatusOnline)
+}
+
+// Disconnected is called whenever a user closes a connection.
+func (t *Tracker) Disconnected(userID uuid.UUID) {
+	if t.connections.IsOnline(userID) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[userID]; ok || !t.online[userID] {
+		return
+	}
+	t.pending[userID] = time.AfterFunc(t.grace, func() { t.expire(userID) })
+}
+
+func (t *Tracker) expire(userID uuid.UUID) {
+	t.mu.Lock()
+	delete(t.pending, userID)
+	if t.connections.IsOnline(userID) || !t.online[userID] {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.online, userID)
+	t.mu.Unlock()
+
+	t.publish(userID, StatusOffline)
+}
+
+func (t *Tracker) publish(userID uuid.UUID, status string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if err := t.store.SetStatus(ctx, userID, status, now); err != nil {
+		slog.ErrorContext(ctx, "Failed to persist presence", "user_id", userID, "error", err)
+	}
+	t.notify(ctx, Update{UserID: userID, Status: status, LastSeenAt: now})
+}
+
+func (t *Tracker) notify(ctx context.Context, update Update) {
+	recipients, err := t.audience(ctx, update.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load presence audience", "user_id", update.UserID, "error", err)
+		return
+	}
+	if len(recipients) > 0 {
+		t.broadcast(recipients, update)
+	}
+}
+
+// Run refreshes last_seen_at for connected users every interval and expires
+// users no instance has refreshed for two intervals. It returns when ctx is
+// done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := time.Now().UTC()
+			if err := t.store.TouchLastSeen(ctx, t.connections.OnlineUserIDs(), now); err != nil {
+				slog.ErrorContext(ctx, "Failed to refresh last_seen_at", "error", err)
+			}
+
+			expired, err := t.store.ExpireStale(ctx, now.Add(-2*interval))
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to expire stale presence", "error", err)
+				continue
+			}
+			for _, userID := range expired {
+				t.notify(ctx, Update{UserID: userID, Status: StatusOffline, LastSeenAt: now})
+			}
+		}
+	}
+}
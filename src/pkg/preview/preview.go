@@ -0,0 +1,142 @@
+// Package preview writes the text that announces a message outside the
+// app: push notifications now, and any other channel that previews
+// messages later, so every channel shows a user the same thing.
+package preview
+
+import (
+	_ "embed"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// How much of a message a preview shows
+const (
+	// Full shows the sender and the message text.
+	Full = "full"
+	// SenderOnly shows who sent the message but not what it says.
+	SenderOnly = "sender_only"
+	// None shows only that a message arrived.
+	None = "none"
+)
+
+// Modes lists the preview modes, for validation.
+var Modes = []string{Full, SenderOnly, None}
+
+// Message is what a preview is written from.
+type Message struct {
+	Sender string
+
+	// Conversation is the title of a group conversation, empty for direct
+	// messages.
+	Conversation string
+
+	Text string
+}
+
+// Settings are a recipient's preview choices.
+type Settings struct {
+	Mode string
+
+	// Filter masks profanity in everything the preview shows.
+	Filter bool
+}
+
+// Preview is a title and body to show a recipient.
+type Preview struct {
+	Title string
+	Body  string
+}
+
+const (
+	genericTitle = "AfroChat"
+	genericBody  = "New message"
+)
+
+// Build writes the preview of a message, cutting the body to maxRunes
+// characters. Unknown modes show nothing, as None does.
+func Build(message Message, settings Settings, maxRunes int) Preview {
+	if settings.Mode != Full && settings.Mode != SenderOnly {
+		return Preview{Title: genericTitle, Body: genericBody}
+	}
+
+	title := message.Sender
+	if message.Conversation != "" {
+		title = message.Sender + " in " + message.Conversation
+	}
+	body := genericBody
+	if settings.Mode == Full && strings.TrimSpace(message.Text) != "" {
+		body = message.Text
+	}
+	// Masking comes first, so a cut cannot leave part of a word unmasked.
+	if settings.Filter {
+		title, body = Mask(title), Mask(body)
+	}
+	return Preview{Title: title, Body: truncate(body, maxRunes)}
+}
+
+// Snippet is the start of a message's text on one line, for lists of
+// conversations, cut to maxRunes characters.
+func Snippet(text string, maxRunes int) string {
+	return truncate(strings.Join(strings.Fields(text), " "), maxRunes)
+}
+
+func truncate(text string, maxRunes int) string {
+	if utf8.RuneCountInString(text) <= maxRunes {
+		return text
+	}
+	return string([]rune(text)[:maxRunes]) + "…"
+}
+
+//go:embed data/profanity.txt
+var profanityList string
+
+var profanity = func() map[string]bool {
+	words := make(map[string]bool)
+	for _, line := range strings.Split(profanityList, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			words[line] = true
+		}
+	}
+	return words
+}()
+
+// leet undoes the substitutions commonly used to slip words past filters.
+var leet = strings.NewReplacer("0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s")
+
+// Mask replaces all but the first letter of each profane word with
+// asterisks. Words are matched whole and regardless of case, so names
+// that merely contain one are left alone.
+func Mask(text string) string {
+	var out strings.Builder
+	out.Grow(len(text))
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		word := text[start:end]
+		if profanity[leet.Replace(strings.ToLower(word))] {
+			first, size := utf8.DecodeRuneInString(word)
+			out.WriteRune(first)
+			out.WriteString(strings.Repeat("*", utf8.RuneCountInString(word[size:])))
+		} else {
+			out.WriteString(word)
+		}
+		start = -1
+	}
+	for i, r := range text {
+		// @ and $ stand in for letters within words, but start mentions
+		// and amounts.
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || (start >= 0 && (r == '@' || r == '$')) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		flush(i)
+		out.WriteRune(r)
+	}
+	flush(len(text))
+	return out.String()
+}
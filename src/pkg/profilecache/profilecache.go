@@ -0,0 +1,235 @@
+// Package profilecache caches serialized public profiles by user ID in
+// Redis, shared between instances, and in memory in front of it. Updates
+// delete the shared entry and announce the ID over pub/sub, so every
+// instance drops its copy. Announcements are delivered at most once, so
+// entries also expire after a short TTL, bounding how long a missed one
+// serves a stale profile. Users found missing are cached too, for less
+// time, so lookups of unknown IDs do not all reach the database.
+package profilecache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultChannel is the Redis pub/sub channel invalidations are
+	// announced on.
+	DefaultChannel = "afrochat:profile-invalidations"
+
+	// keyPrefix namespaces profile keys in a Redis database shared with
+	// other uses.
+	keyPrefix = "afrochat:profile:"
+
+	// missingMarker is stored for users found missing. Profiles are JSON
+	// objects, so it cannot be mistaken for one.
+	missingMarker = "-"
+
+	// maxLocalEntries bounds the memory the in-process tier uses.
+	maxLocalEntries = 10_000
+)
+
+// Entry is a cached lookup: a user's serialized profile, or that no such
+// user was found.
+type Entry struct {
+	Profile []byte
+	Missing bool
+}
+
+type localEntry struct {
+	Entry
+	expiresAt time.Time
+}
+
+// Cache is a two-tier profile cache.
+type Cache struct {
+	client     *redis.Client
+	channel    string
+	ttl        time.Duration
+	missingTTL time.Duration
+
+	mu    sync.Mutex
+	local map[uuid.UUID]localEntry
+}
+
+// New connects to the Redis server at url, such as
+// "redis://:password@localhost:6379/0". Profiles are kept for ttl and
+// users found missing for missingTTL.
+func New(url, channel string, ttl, missingTTL time.Duration) (*Cache, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Cache{
+		client:     client,
+		channel:    channel,
+		ttl:        ttl,
+		missingTTL: missingTTL,
+		local:      make(map[uuid.UUID]localEntry),
+	}, nil
+}
+
+// Get returns the cached entries of the given users. Users not cached are
+// left out.
+func (c *Cache) Get(ctx context.Context, ids []uuid.UUID) (map[uuid.UUID]Entry, error) {
+	entries := make(map[uuid.UUID]Entry, len(ids))
+	var remote []uuid.UUID
+	now := time.Now()
+	c.mu.Lock()
+	for _, id := range ids {
+		if cached, ok := c.local[id]; ok && now.Before(cached.expiresAt) {
+			entries[id] = cached.Entry
+		} else {
+			remote = append(remote, id)
+		}
+	}
+	c.mu.Unlock()
+	if len(remote) == 0 {
+		return entries, nil
+	}
+
+	keys := make([]string, len(remote))
+	for i, id := range remote {
+		keys[i] = keyPrefix + id.String()
+	}
+	values, err := c.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return entries, fmt.Errorf("failed to read cached profiles: %w", err)
+	}
+	fetched := make(map[uuid.UUID]Entry)
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		entry := Entry{Missing: raw == missingMarker}
+		if !entry.Missing {
+			entry.Profile = []byte(raw)
+		}
+		entries[remote[i]] = entry
+		fetched[remote[i]] = entry
+	}
+	// The local copies may outlive the shared ones by up to ttl, which an
+	// invalidation announcement cuts short.
+	c.remember(fetched, now)
+	return entries, nil
+}
+
+// Set caches profiles, serialized, and that the users in missing were not
+// found.
+func (c *Cache) Set(ctx context.Context, profiles map[uuid.UUID][]byte, missing []uuid.UUID) error {
+	entries := make(map[uuid.UUID]Entry, len(profiles)+len(missing))
+	pipe := c.client.Pipeline()
+	for id, profile := range profiles {
+		pipe.Set(ctx, keyPrefix+id.String(), profile, c.ttl)
+		entries[id] = Entry{Profile: profile}
+	}
+	for _, id := range missing {
+		pipe.Set(ctx, keyPrefix+id.String(), missingMarker, c.missingTTL)
+		entries[id] = Entry{Missing: true}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to cache profiles: %w", err)
+	}
+	c.remember(entries, time.Now())
+	return nil
+}
+
+// Invalidate drops a user's cached profile on every instance, for the
+// next lookup to load it afresh.
+func (c *Cache) Invalidate(ctx context.Context, id uuid.UUID) error {
+	c.forget(id)
+	if err := c.client.Del(ctx, keyPrefix+id.String()).Err(); err != nil {
+		return fmt.Errorf("failed to invalidate profile: %w", err)
+	}
+	if err := c.client.Publish(ctx, c.channel, id.String()).Err(); err != nil {
+		return fmt.Errorf("failed to announce profile invalidation: %w", err)
+	}
+	return nil
+}
+
+// Listen returns once subscribed to invalidation announcements and drops
+// the announced profiles from memory in the background until ctx is done.
+// The client reconnects on its own after network errors.
+func (c *Cache) Listen(ctx context.Context) error {
+	subscription := c.client.Subscribe(ctx, c.channel)
+	if _, err := subscription.Receive(ctx); err != nil {
+		subscription.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", c.channel, err)
+	}
+	go func() {
+		defer subscription.Close()
+		messages := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case received, ok := <-messages:
+				if !ok {
+					return
+				}
+				id, err := uuid.Parse(received.Payload)
+				if err != nil {
+					slog.WarnContext(ctx, "Dropping malformed profile invalidation", "error", err)
+					continue
+				}
+				c.forget(id)
+			}
+		}
+	}()
+	return nil
+}
+
+// Ping checks that Redis answers.
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
+
+func (c *Cache) remember(entries map[uuid.UUID]Entry, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.local)+len(entries) > maxLocalEntries {
+		for id, cached := range c.local {
+			if !now.Before(cached.expiresAt) {
+				delete(c.local, id)
+			}
+		}
+		if len(c.local)+len(entries) > maxLocalEntries {
+			c.local = make(map[uuid.UUID]localEntry)
+		}
+	}
+	for id, entry := range entries {
+		ttl := c.ttl
+		if entry.Missing {
+			ttl = c.missingTTL
+		}
+		c.local[id] = localEntry{Entry: entry, expiresAt: now.Add(ttl)}
+	}
+}
+
+func (c *Cache) forget(id uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.local, id)
+}
@@ -0,0 +1,167 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	apnsProductionHost  = "https://api.push.apple.com"
+	apnsDevelopmentHost = "https://api.sandbox.push.apple.com"
+
+	// apnsTokenLifetime is how long a provider token is reused. Apple
+	// rejects tokens older than an hour and throttles refreshing more
+	// often than every twenty minutes.
+	apnsTokenLifetime = 40 * time.Minute
+)
+
+// APNsConfig identifies the signing key and app for APNs token-based
+// authentication.
+type APNsConfig struct {
+	KeyFile string
+	KeyID   string
+	TeamID  string
+
+	// Topic is the app's bundle ID.
+	Topic string
+
+	// Production selects the production environment over the sandbox
+	// used by development builds.
+	Production bool
+}
+
+// APNsSender sends pushes to iOS devices through the Apple Push
+// Notification service.
+type APNsSender struct {
+	config APNsConfig
+	host   string
+	key    *ecdsa.PrivateKey
+	client *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsSender reads the .p8 signing key downloaded from the Apple
+// developer portal.
+func NewAPNsSender(config APNsConfig) (*APNsSender, error) {
+	raw, err := os.ReadFile(config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read APNs key: %w", err)
+	}
+	key, err := jwt.ParseECPrivateKeyFromPEM(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse APNs key: %w", err)
+	}
+	host := apnsDevelopmentHost
+	if config.Production {
+		host = apnsProductionHost
+	}
+	// APNs only speaks HTTP/2, which the default transport negotiates.
+	return &APNsSender{config: config, host: host, key: key, client: &http.Client{Timeout: 15 * time.Second}}, nil
+}
+
+type apnsAlert struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type apnsAPS struct {
+	Alert    apnsAlert `json:"alert"`
+	Sound    string    `json:"sound"`
+	ThreadID string    `json:"thread-id,omitempty"`
+}
+
+func (s *APNsSender) Send(ctx context.Context, token string, notification Notification) error {
+	providerToken, err := s.providerToken()
+	if err != nil {
+		return err
+	}
+
+	// Custom data sits beside the aps dictionary.
+	payload := map[string]any{"aps": apnsAPS{
+		Alert:    apnsAlert{Title: notification.Title, Body: notification.Body},
+		Sound:    "default",
+		ThreadID: notification.ThreadID,
+	}}
+	for key, value := range notification.Data {
+		payload[key] = value
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode push: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.host+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build push request: %w", err)
+	}
+	req.Header.Set("Authorization", "bearer "+providerToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", s.config.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	req.Header.Set("apns-priority", "10")
+	if notification.CollapseKey != "" {
+		req.Header.Set("apns-collapse-id", notification.CollapseKey)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reason struct {
+		Reason string `json:"reason"`
+	}
+	json.NewDecoder(resp.Body).Decode(&reason)
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		return &InvalidTokenError{Reason: ReasonUnregistered}
+	case reason.Reason == "BadDeviceToken":
+		return &InvalidTokenError{Reason: ReasonBadToken}
+	case reason.Reason == "DeviceTokenNotForTopic":
+		return &InvalidTokenError{Reason: ReasonWrongApp}
+	case resp.StatusCode == http.StatusForbidden && reason.Reason == "ExpiredProviderToken":
+		s.mu.Lock()
+		s.token = ""
+		s.mu.Unlock()
+	}
+	return fmt.Errorf("APNs rejected push with status %d: %s", resp.StatusCode, reason.Reason)
+}
+
+// providerToken returns the signed JWT APNs authenticates requests with,
+// reusing it until it gets old.
+func (s *APNsSender) providerToken() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.token != "" && time.Since(s.issuedAt) < apnsTokenLifetime {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.config.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.config.KeyID
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign APNs token: %w", err)
+	}
+	s.token, s.issuedAt = signed, now
+	return signed, nil
+}
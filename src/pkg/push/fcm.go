@@ -0,0 +1,142 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/googleauth"
+)
+
+const fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMSender sends pushes to Android devices through the Firebase Cloud
+// Messaging HTTP v1 API, authenticating as a service account.
+type FCMSender struct {
+	projectID string
+	tokens    *googleauth.TokenSource
+	client    *http.Client
+}
+
+// NewFCMSender reads a service account key file downloaded from the
+// Firebase console.
+func NewFCMSender(credentialsFile string) (*FCMSender, error) {
+	account, err := googleauth.LoadServiceAccount(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load FCM credentials: %w", err)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	return &FCMSender{
+		projectID: account.ProjectID,
+		tokens:    googleauth.NewTokenSource(account, fcmScope, client),
+		client:    client,
+	}, nil
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      fcmAndroid        `json:"android"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmAndroid struct {
+	Priority     string                 `json:"priority"`
+	CollapseKey  string                 `json:"collapse_key,omitempty"`
+	Notification fcmAndroidNotification `json:"notification"`
+}
+
+type fcmAndroidNotification struct {
+	Tag string `json:"tag,omitempty"`
+}
+
+func (s *FCMSender) Send(ctx context.Context, token string, notification Notification) error {
+	accessToken, err := s.tokens.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to authorize FCM push: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]fcmMessage{"message": {
+		Token:        token,
+		Notification: fcmNotification{Title: notification.Title, Body: notification.Body},
+		Data:         notification.Data,
+		Android: fcmAndroid{
+			Priority:     "high",
+			CollapseKey:  notification.CollapseKey,
+			Notification: fcmAndroidNotification{Tag: notification.ThreadID},
+		},
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to encode push: %w", err)
+	}
+	endpoint := "https://fcm.googleapis.com/v1/projects/" + url.PathEscape(s.projectID) + "/messages:send"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build push request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if reason := fcmRejectionReason(resp.StatusCode, detail); reason != "" {
+		return &InvalidTokenError{Reason: reason}
+	}
+	return fmt.Errorf("FCM rejected push with status %d: %s", resp.StatusCode, detail)
+}
+
+// fcmError is the error body of the FCM v1 API. The FCM-specific code is
+// in a detail of type google.firebase.fcm.v1.FcmError.
+type fcmError struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// fcmRejectionReason returns why FCM rejected the token a push was sent
+// to, or "" if the push failed for another reason.
+func fcmRejectionReason(status int, detail []byte) string {
+	var body fcmError
+	json.Unmarshal(detail, &body)
+	for _, d := range body.Error.Details {
+		switch d.ErrorCode {
+		case "UNREGISTERED":
+			return ReasonUnregistered
+		case "SENDER_ID_MISMATCH":
+			return ReasonWrongApp
+		}
+	}
+	switch {
+	// FCM answers 404 for tokens of uninstalled apps.
+	case status == http.StatusNotFound:
+		return ReasonUnregistered
+	// Invalid arguments are usually a bad payload; only those naming the
+	// token condemn it.
+	case body.Error.Status == "INVALID_ARGUMENT" && strings.Contains(body.Error.Message, "registration token"):
+		return ReasonBadToken
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package push
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformWeb     = "web"
+)
+
+// ErrInvalidToken means the push service no longer accepts the device
+// token, usually because the app was uninstalled. The token should be
+// forgotten.
+var ErrInvalidToken = errors.New("device token is no longer registered")
+
+// Why a push service rejected a device token.
+const (
+	// ReasonUnregistered means the app was uninstalled, or the token
+	// expired and the app has since been given a new one.
+	ReasonUnregistered = "unregistered"
+
+	// ReasonBadToken means the token was never valid, as when a client
+	// registered a mangled or sandbox token.
+	ReasonBadToken = "bad_token"
+
+	// ReasonWrongApp means the token was issued to a different app or
+	// Firebase project than the one pushing.
+	ReasonWrongApp = "wrong_app"
+)
+
+// InvalidTokenError is the push service's feedback that a device token is
+// dead. It matches ErrInvalidToken.
+type InvalidTokenError struct {
+	Reason string
+}
+
+func (e *InvalidTokenError) Error() string {
+	return "device token rejected: " + e.Reason
+}
+
+func (e *InvalidTokenError) Is(target error) bool {
+	return target == ErrInvalidToken
+}
+
+// RejectionReason returns why err says a device token is dead, or "" if
+// it does not.
+func RejectionReason(err error) string {
+	var invalid *InvalidTokenError
+	if errors.As(err, &invalid) {
+		return invalid.Reason
+	}
+	if errors.Is(err, ErrInvalidToken) {
+		return ReasonUnregistered
+	}
+	return ""
+}
+
+// NormalizeToken returns the form a device token is stored and sent in, so
+// the same device registering the token written differently is recognized.
+// APNs tokens are lowercase hex, which some clients wrap in angle brackets
+// or space out; FCM tokens are opaque and only trimmed.
+func NormalizeToken(platform, token string) (string, error) {
+	token = strings.TrimSpace(token)
+	if platform == PlatformIOS {
+		token = strings.ToLower(strings.NewReplacer("<", "", ">", "", " ", "").Replace(token))
+		if _, err := hex.DecodeString(token); err != nil || len(token) < 64 {
+			return "", errors.New("APNs device tokens are at least 64 hex digits")
+		}
+		return token, nil
+	}
+	if token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return "", errors.New("device token must not be empty or contain spaces")
+	}
+	return token, nil
+}
+
+// Notification is a push shown on a device.
+type Notification struct {
+	Title string
+	Body  string
+
+	// ThreadID groups related notifications on the device, such as those
+	// for one conversation.
+	ThreadID string
+
+	// CollapseKey makes the notification replace one with the same key
+	// still shown on the device, rather than add to it, so a burst of
+	// messages shows as one updated notification. APNs takes keys of at
+	// most 64 bytes.
+	CollapseKey string
+
+	// Data is delivered to the app alongside the alert.
+	Data map[string]string
+}
+
+// Sender delivers pushes to devices of one platform.
+type Sender interface {
+	Send(ctx context.Context, token string, notification Notification) error
+}
+
+// LogSender logs pushes instead of sending them, for local development.
+type LogSender struct{}
+
+func (LogSender) Send(_ context.Context, token string, notification Notification) error {
+	slog.Info("📲 Push", "token_prefix", token[:min(len(token), 8)], "title", notification.Title, "body", notification.Body)
+	return nil
+}
@@ -0,0 +1,337 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// webPushTTL is how long a push service holds a push for a browser
+	// that is offline before dropping it.
+	webPushTTL = 24 * time.Hour
+
+	// vapidTokenLifetime is how long a VAPID token is reused for one push
+	// service. Push services reject tokens expiring more than a day out.
+	vapidTokenLifetime = 12 * time.Hour
+
+	// webPushRecordSize is the aes128gcm record size. Pushes are sent as
+	// a single record, so it only has to exceed the payload.
+	webPushRecordSize = 4096
+)
+
+// Subscription is a browser's Web Push subscription, as the
+// PushSubscription.toJSON() of the Push API gives it: the push service
+// endpoint to post to and the keys payloads are encrypted for.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256DH string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// NormalizeSubscription checks a subscription and returns the form it is
+// stored and sent in, the device token of a browser.
+func NormalizeSubscription(subscription Subscription) (string, error) {
+	endpoint, err := url.Parse(strings.TrimSpace(subscription.Endpoint))
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return "", errors.New("subscription endpoint must be an https URL")
+	}
+	subscription.Endpoint = endpoint.String()
+	if _, err := subscriptionKey(subscription); err != nil {
+		return "", err
+	}
+	if auth, err := decodeBase64URL(subscription.Keys.Auth); err != nil || len(auth) != 16 {
+		return "", errors.New("subscription auth secret must be 16 bytes of base64url")
+	}
+	token, err := json.Marshal(subscription)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode subscription: %w", err)
+	}
+	return string(token), nil
+}
+
+func subscriptionKey(subscription Subscription) (*ecdh.PublicKey, error) {
+	raw, err := decodeBase64URL(subscription.Keys.P256DH)
+	if err != nil {
+		return nil, errors.New("subscription p256dh key must be base64url")
+	}
+	key, err := ecdh.P256().NewPublicKey(raw)
+	if err != nil {
+		return nil, errors.New("subscription p256dh key must be an uncompressed P-256 point")
+	}
+	return key, nil
+}
+
+// decodeBase64URL decodes base64url with or without padding, as browsers
+// and libraries differ on it.
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// WebPushConfig is the VAPID identity pushes to browsers are sent under.
+type WebPushConfig struct {
+	// PrivateKey is the base64url P-256 private key, as web-push tools
+	// generate it. The public key browsers subscribe with derives from it.
+	PrivateKey string
+
+	// Subject is a mailto: or https: URL push services can reach the
+	// sender at.
+	Subject string
+}
+
+// WebPushSender sends pushes to browsers through their push services,
+// encrypting payloads for the subscription (RFC 8291) and identifying
+// itself with VAPID (RFC 8292).
+type WebPushSender struct {
+	subject   string
+	key       *ecdsa.PrivateKey
+	publicKey string
+	client    *http.Client
+
+	mu     sync.Mutex
+	tokens map[string]vapidToken
+}
+
+type vapidToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// NewWebPushSender parses the VAPID private key.
+func NewWebPushSender(config WebPushConfig) (*WebPushSender, error) {
+	raw, err := decodeBase64URL(config.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode VAPID key: %w", err)
+	}
+	private, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VAPID key: %w", err)
+	}
+	public := private.PublicKey().Bytes()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(public[1:33]),
+			Y:     new(big.Int).SetBytes(public[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+	return &WebPushSender{
+		subject:   config.Subject,
+		key:       key,
+		publicKey: base64.RawURLEncoding.EncodeToString(public),
+		client:    &http.Client{Timeout: 15 * time.Second},
+		tokens:    make(map[string]vapidToken),
+	}, nil
+}
+
+// PublicKey is the base64url application server key browsers subscribe
+// with.
+func (s *WebPushSender) PublicKey() string {
+	return s.publicKey
+}
+
+type webPushPayload struct {
+	Title string            `json:"title"`
+	Body  string            `json:"body"`
+	Tag   string            `json:"tag,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// Send pushes to the subscription a browser registered as its token. The
+// service worker is given the notification as JSON, with the collapse key
+// as the tag to replace an earlier notification with.
+func (s *WebPushSender) Send(ctx context.Context, token string, notification Notification) error {
+	var subscription Subscription
+	if err := json.Unmarshal([]byte(token), &subscription); err != nil {
+		return &InvalidTokenError{Reason: ReasonBadToken}
+	}
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil {
+		return &InvalidTokenError{Reason: ReasonBadToken}
+	}
+	tag := notification.CollapseKey
+	if tag == "" {
+		tag = notification.ThreadID
+	}
+	payload, err := json.Marshal(webPushPayload{
+		Title: notification.Title,
+		Body:  notification.Body,
+		Tag:   tag,
+		Data:  notification.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode push: %w", err)
+	}
+	body, err := encryptWebPush(subscription, payload)
+	if errors.Is(err, errBadSubscriptionKeys) {
+		return &InvalidTokenError{Reason: ReasonBadToken}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encrypt push: %w", err)
+	}
+	vapid, err := s.vapidToken(endpoint.Scheme + "://" + endpoint.Host)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build push request: %w", err)
+	}
+	req.Header.Set("Authorization", "vapid t="+vapid+", k="+s.publicKey)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", fmt.Sprint(int(webPushTTL.Seconds())))
+	req.Header.Set("Urgency", "high")
+	if topic := webPushTopic(notification.CollapseKey); topic != "" {
+		req.Header.Set("Topic", topic)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	switch resp.StatusCode {
+	// Push services answer 410 for expired or unsubscribed subscriptions,
+	// and some 404.
+	case http.StatusGone, http.StatusNotFound:
+		return &InvalidTokenError{Reason: ReasonUnregistered}
+	}
+	return fmt.Errorf("push service rejected push with status %d: %s", resp.StatusCode, detail)
+}
+
+// webPushTopic turns a collapse key into a Topic header, which push
+// services take as at most 32 base64url characters.
+func webPushTopic(collapseKey string) string {
+	topic := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return -1
+	}, strings.ReplaceAll(collapseKey, "-", ""))
+	return topic[:min(len(topic), 32)]
+}
+
+// vapidToken returns the signed JWT identifying the sender to the push
+// service at audience, reusing it until it gets old.
+func (s *WebPushSender) vapidToken(audience string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if cached, ok := s.tokens[audience]; ok && now.Before(cached.expiresAt.Add(-time.Hour)) {
+		return cached.token, nil
+	}
+
+	expiresAt := now.Add(vapidTokenLifetime)
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"aud": audience,
+		"exp": expiresAt.Unix(),
+		"sub": s.subject,
+	}).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign VAPID token: %w", err)
+	}
+	s.tokens[audience] = vapidToken{token: signed, expiresAt: expiresAt}
+	return signed, nil
+}
+
+// errBadSubscriptionKeys means a subscription's keys cannot be encrypted
+// for.
+var errBadSubscriptionKeys = errors.New("subscription keys are invalid")
+
+// encryptWebPush encrypts a payload for a subscription with the
+// aes128gcm content coding (RFC 8188), keyed as Web Push requires
+// (RFC 8291), as a single record.
+func encryptWebPush(subscription Subscription, payload []byte) ([]byte, error) {
+	userAgentKey, err := subscriptionKey(subscription)
+	if err != nil {
+		return nil, errBadSubscriptionKeys
+	}
+	authSecret, err := decodeBase64URL(subscription.Keys.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, errBadSubscriptionKeys
+	}
+	if len(payload)+17 > webPushRecordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	local, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := local.ECDH(userAgentKey)
+	if err != nil {
+		return nil, err
+	}
+	localPublic := local.PublicKey().Bytes()
+
+	keyInfo := append([]byte("WebPush: info\x00"), userAgentKey.Bytes()...)
+	keyInfo = append(keyInfo, localPublic...)
+	ikm := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, authSecret, keyInfo), ikm); err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	prk := hkdf.Extract(sha256.New, ikm, salt)
+	contentKey := make([]byte, 16)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: aes128gcm\x00")), contentKey); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, prk, []byte("Content-Encoding: nonce\x00")), nonce); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is the salt, record size and sender's public key; the
+	// one record is the payload closed by the last-record delimiter.
+	header := make([]byte, 0, 16+4+1+len(localPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, webPushRecordSize)
+	header = append(header, byte(len(localPublic)))
+	header = append(header, localPublic...)
+	return gcm.Seal(header, nonce, append(payload, 0x02), nil), nil
+}
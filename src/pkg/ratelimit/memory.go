@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are forgotten.
+const sweepInterval = time.Minute
+
+// MemoryStore holds buckets in memory, so each instance enforces its
+// limits separately.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, policy Policy) (Result, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A full bucket is the same as no bucket.
+	if now.Sub(s.swept) >= sweepInterval {
+		for k, b := range s.buckets {
+			if !now.Before(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.swept = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Limit), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = min(float64(policy.Limit), b.tokens+now.Sub(b.updated).Seconds()*policy.rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	result := policy.Result(allowed, b.tokens, now)
+	b.full = result.Reset
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Limit requests per Period on average, in bursts of up to
+// Limit. Requests spend tokens from a bucket that refills continuously,
+// so a client that used up its burst gets a request back every
+// Period/Limit rather than waiting for a window to end.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy parses a policy written as limit/period, such as 10/1m.
+func ParsePolicy(text string) (Policy, error) {
+	limit, period, ok := strings.Cut(text, "/")
+	if !ok {
+		return Policy{}, errors.New("rate limit must be written as limit/period, such as 10/1m")
+	}
+	var policy Policy
+	var err error
+	if policy.Limit, err = strconv.Atoi(limit); err != nil || policy.Limit < 1 {
+		return Policy{}, errors.New("rate limit must allow at least 1 request")
+	}
+	if policy.Period, err = time.ParseDuration(period); err != nil || policy.Period <= 0 {
+		return Policy{}, errors.New("rate limit period must be a positive duration such as 1m")
+	}
+	return policy, nil
+}
+
+func (p Policy) String() string {
+	return fmt.Sprintf("%d/%s", p.Limit, p.Period)
+}
+
+// rate is how many tokens the bucket regains per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result describes a bucket holding tokens after a request, for stores.
+func (p Policy) Result(allowed bool, tokens float64, now time.Time) Result {
+	result := Result{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     now.Add(seconds((float64(p.Limit) - tokens) / p.rate())),
+	}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / p.rate())
+	}
+	return result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// Result is the outcome of one Allow call.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is when the bucket will be full again.
+	Reset time.Time
+
+	// RetryAfter is how long until a denied request would be allowed.
+	RetryAfter time.Duration
+}
+
+// Store holds the token buckets. Take spends a token from the bucket for
+// key, if it has one.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// Limiter applies policies to keys, such as a user or an IP address and
+// the route they are calling.
+type Limiter struct {
+	store Store
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{store: store}
+}
+
+// Allow records a request for key and reports whether policy allows it.
+// Requests are allowed when the store fails: an outage of the limiter
+// should not take the API down with it.
+func (l *Limiter) Allow(ctx context.Context, key string, policy Policy) Result {
+	result, err := l.store.Take(ctx, key, policy)
+	if err != nil {
+		slog.WarnContext(ctx, "Rate limiter unavailable, allowing request", "error", err)
+		return Result{Allowed: true, Limit: policy.Limit, Remaining: policy.Limit, Reset: time.Now()}
+	}
+	return result
+}
@@ -0,0 +1,90 @@
+package redisstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix namespaces bucket keys in a Redis database shared with other
+// uses.
+const keyPrefix = "afrochat:ratelimit:"
+
+// take refills and spends from a bucket atomically. It uses the Redis
+// server's clock, so instances with skewed clocks agree. Buckets expire
+// once they would be full again.
+var take = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or capacity
+local updated = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// Store holds buckets in Redis, so every instance enforces the same
+// limits.
+type Store struct {
+	client *redis.Client
+}
+
+// New connects to the Redis server at url, such as
+// "redis://:password@localhost:6379/0".
+func New(url string) (*Store, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Store{client: client}, nil
+}
+
+func (s *Store) Take(ctx context.Context, key string, policy ratelimit.Policy) (ratelimit.Result, error) {
+	rate := float64(policy.Limit) / policy.Period.Seconds()
+	reply, err := take.Run(ctx, s.client, []string{keyPrefix + key}, policy.Limit, rate).Slice()
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("failed to take from bucket: %w", err)
+	}
+	if len(reply) != 2 {
+		return ratelimit.Result{}, fmt.Errorf("unexpected bucket reply %v", reply)
+	}
+	allowed, _ := reply[0].(int64)
+	text, _ := reply[1].(string)
+	tokens, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return ratelimit.Result{}, fmt.Errorf("unexpected bucket reply %v", reply)
+	}
+	return policy.Result(allowed == 1, tokens, time.Now()), nil
+}
+
+// Ping checks that Redis answers.
+func (s *Store) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *Store) Close() error {
+	return s.client.Close()
+}
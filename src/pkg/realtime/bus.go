@@ -0,0 +1,49 @@
+package realtime
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// BusMessage is an event addressed to users, relayed between instances.
+type BusMessage struct {
+	Origin  string      `json:"origin"`
+	UserIDs []uuid.UUID `json:"user_ids"`
+	Event   Event       `json:"event"`
+}
+
+// Bus relays events between backend instances so a user connected to any
+// instance receives events sent from every other.
+type Bus interface {
+	Publish(ctx context.Context, message BusMessage) error
+	// Subscribe delivers every message published by any instance to
+	// handler until ctx is done.
+	Subscribe(ctx context.Context, handler func(BusMessage)) error
+	Close() error
+}
+
+// Router is a Bus that also records which instance holds each user's
+// connections, so events go straight to the instances that need them
+// rather than to every instance, and any instance can serve any user.
+type Router interface {
+	Bus
+
+	// Register and Unregister record a connection of a user opening and
+	// closing on an instance.
+	Register(ctx context.Context, instanceID string, userID, connectionID uuid.UUID) error
+	Unregister(ctx context.Context, instanceID string, userID, connectionID uuid.UUID) error
+
+	// Locate returns the live instances holding connections of the given
+	// users, with the users each holds.
+	Locate(ctx context.Context, userIDs []uuid.UUID) (map[string][]uuid.UUID, error)
+
+	// Heartbeat marks an instance live for a while. The connections of an
+	// instance that stops beating are taken to be gone.
+	Heartbeat(ctx context.Context, instanceID string) error
+
+	// PublishTo sends a message to one instance only, which receives it
+	// through SubscribeInstance.
+	PublishTo(ctx context.Context, instanceID string, message BusMessage) error
+	SubscribeInstance(ctx context.Context, instanceID string, handler func(BusMessage)) error
+}
@@ -0,0 +1,155 @@
+package realtime
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 64 * 1024
+	sendBufferSize = 256
+)
+
+// Client is one WebSocket connection, or one long-polling client. A user
+// may hold several at once, one per device or tab.
+type Client struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	SessionID   uuid.UUID
+	Resumed     bool
+	ConnectedAt time.Time
+
+	hub  *Hub
+	conn *websocket.Conn
+
+	// poll is set, and conn nil, for long-polling clients.
+	poll *pollState
+
+	mu     sync.Mutex
+	send   chan []byte
+	closed bool
+
+	// subscriptions is nil until the client changes what it receives.
+	subscriptions *subscriptions
+}
+
+// Send queues an event for delivery, unless the client unsubscribed from
+// its class. A client whose buffer is full is too slow to keep up and is
+// disconnected rather than blocking the sender.
+func (c *Client) Send(event Event) {
+	if !c.wants(event) {
+		return
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to marshal event", "event_type", event.Type, "error", err)
+		return
+	}
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	select {
+	case c.send <- payload:
+		c.mu.Unlock()
+		return
+	default:
+	}
+	c.mu.Unlock()
+
+	slog.Warn("Dropping slow realtime client", "client_id", c.ID, "user_id", c.UserID, "long_polling", c.LongPolling())
+	c.hub.unregister(c)
+}
+
+// LongPolling reports whether the client receives its events by long
+// polling rather than over a WebSocket.
+func (c *Client) LongPolling() bool {
+	return c.poll != nil
+}
+
+func (c *Client) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.send)
+	}
+}
+
+// readPump decodes inbound frames and routes them to the hub's handlers. It
+// owns the read side of the connection and unregisters the client on exit.
+func (c *Client) readPump() {
+	defer func() {
+		c.hub.unregister(c)
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, payload, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Warn("WebSocket read error", "user_id", c.UserID, "error", err)
+			}
+			return
+		}
+
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil || event.Type == "" {
+			c.Send(errorEvent("", "malformed event"))
+			continue
+		}
+		if c.hub.drops() {
+			continue
+		}
+		c.hub.dispatch(c, event)
+	}
+}
+
+// writePump owns the write side of the connection: queued events, pings
+// and the final close frame.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.hub.writers.Done()
+	}()
+
+	for {
+		select {
+		case payload, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.hub.closeCode(), ""))
+				return
+			}
+			if c.hub.drops() {
+				continue
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, payload); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package realtime
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is the envelope for every frame sent over the socket in either
+// direction. RequestID is echoed back on replies so clients can match them.
+// Clients may set Traceparent, a W3C trace context, on inbound events to
+// trace their handling as part of the client's own trace.
+type Event struct {
+	Type        string          `json:"type"`
+	RequestID   string          `json:"request_id,omitempty"`
+	Traceparent string          `json:"traceparent,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+	Timestamp   time.Time       `json:"ts"`
+}
+
+const (
+	EventError     = "error"
+	EventConnected = "connected"
+	EventShutdown  = "server.shutdown"
+
+	// EventSignedOut tells a connection its user was signed out, just
+	// before the server closes it.
+	EventSignedOut = "session.signed_out"
+)
+
+// NewEvent marshals data into an event of the given type.
+func NewEvent(eventType string, data any) (Event, error) {
+	event := Event{Type: eventType, Timestamp: time.Now().UTC()}
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return event, err
+		}
+		event.Data = raw
+	}
+	return event, nil
+}
+
+type errorData struct {
+	Message string `json:"message"`
+}
+
+func errorEvent(requestID, message string) Event {
+	event, _ := NewEvent(EventError, errorData{Message: message})
+	event.RequestID = requestID
+	return event
+}
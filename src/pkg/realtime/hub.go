@@ -0,0 +1,531 @@
+package realtime
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// heartbeatInterval is how often an instance routing through a Router
+// marks itself live.
+const heartbeatInterval = 10 * time.Second
+
+// signOutMemory is how long the hub remembers that a user was signed out,
+// which bounds the lifetime of anything that lets a client reconnect
+// without authenticating afresh.
+const signOutMemory = time.Hour
+
+// HandlerFunc handles one inbound event type from a client. ctx carries
+// the span recording the event's handling.
+type HandlerFunc func(ctx context.Context, client *Client, event Event)
+
+// Hub tracks every connected client by user and routes inbound events to
+// the handlers registered for their type.
+type Hub struct {
+	mu       sync.RWMutex
+	clients  map[uuid.UUID]map[*Client]bool
+	polls    map[uuid.UUID]*Client
+	handlers map[string]HandlerFunc
+	closing  bool
+	writers  sync.WaitGroup
+
+	// signedOut holds when each user was last signed out, on any instance.
+	signedOut map[uuid.UUID]time.Time
+
+	onConnect    []func(client *Client)
+	onDisconnect []func(client *Client)
+	onEvent      []func(client *Client, event Event)
+
+	// bus is nil when running as a single instance, and router set when
+	// the bus can address instances directly.
+	bus        Bus
+	router     Router
+	instanceID string
+
+	// dropFrame is nil unless faults are being injected.
+	dropFrame func() bool
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		clients:    make(map[uuid.UUID]map[*Client]bool),
+		polls:      make(map[uuid.UUID]*Client),
+		handlers:   make(map[string]HandlerFunc),
+		signedOut:  make(map[uuid.UUID]time.Time),
+		instanceID: uuid.NewString(),
+	}
+}
+
+// UseBus relays every event sent through the hub to the other instances on
+// bus, and delivers theirs to local clients. When bus is a Router, the
+// hub registers its connections with it and sends events only to the
+// instances holding their users. Call it before serving.
+func (h *Hub) UseBus(ctx context.Context, bus Bus) error {
+	receive := func(message BusMessage) {
+		// Events from this instance were delivered locally when sent.
+		if message.Origin == h.instanceID {
+			return
+		}
+		for _, userID := range message.UserIDs {
+			h.deliver(userID, message.Event)
+		}
+	}
+	if err := bus.Subscribe(ctx, receive); err != nil {
+		return err
+	}
+	router, ok := bus.(Router)
+	if ok {
+		if err := router.SubscribeInstance(ctx, h.instanceID, receive); err != nil {
+			return err
+		}
+		if err := router.Heartbeat(ctx, h.instanceID); err != nil {
+			return err
+		}
+		go h.heartbeat(ctx, router)
+	}
+
+	h.mu.Lock()
+	h.bus = bus
+	h.router = router
+	h.mu.Unlock()
+	return nil
+}
+
+// heartbeat keeps the instance marked live until ctx is done.
+func (h *Hub) heartbeat(ctx context.Context, router Router) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := router.Heartbeat(ctx, h.instanceID); err != nil {
+				slog.WarnContext(ctx, "Failed to mark instance live", "instance_id", h.instanceID, "error", err)
+			}
+		}
+	}
+}
+
+// InstanceID identifies this instance to the others.
+func (h *Hub) InstanceID() string {
+	return h.instanceID
+}
+
+// track registers a new connection with the router, if there is one, so
+// events for its user are routed to this instance.
+func (h *Hub) track(client *Client) {
+	h.mu.RLock()
+	router := h.router
+	h.mu.RUnlock()
+	if router == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := router.Register(ctx, h.instanceID, client.UserID, client.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to register connection", "connection_id", client.ID, "error", err)
+	}
+}
+
+// untrack removes a closed connection from the router, if there is one.
+func (h *Hub) untrack(client *Client) {
+	h.mu.RLock()
+	router := h.router
+	h.mu.RUnlock()
+	if router == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := router.Unregister(ctx, h.instanceID, client.UserID, client.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to unregister connection", "connection_id", client.ID, "error", err)
+	}
+}
+
+// DropFrames makes the hub silently drop each frame, inbound or outbound,
+// for which drop returns true, to test that clients recover from lost
+// events. Call it before serving.
+func (h *Hub) DropFrames(drop func() bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.dropFrame = drop
+}
+
+// drops reports whether to drop a frame.
+func (h *Hub) drops() bool {
+	h.mu.RLock()
+	drop := h.dropFrame
+	h.mu.RUnlock()
+	return drop != nil && drop()
+}
+
+// Handle registers the handler for an inbound event type.
+func (h *Hub) Handle(eventType string, handler HandlerFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[eventType] = handler
+}
+
+// OnConnect registers a callback run after a client is registered.
+func (h *Hub) OnConnect(callback func(client *Client)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onConnect = append(h.onConnect, callback)
+}
+
+// OnEvent registers a callback run with each inbound event before it is
+// handled.
+func (h *Hub) OnEvent(callback func(client *Client, event Event)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onEvent = append(h.onEvent, callback)
+}
+
+// OnDisconnect registers a callback run after a client is removed.
+func (h *Hub) OnDisconnect(callback func(client *Client)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onDisconnect = append(h.onDisconnect, callback)
+}
+
+// Serve registers an upgraded connection for userID, signed in with
+// sessionID, and blocks until it disconnects. resumed reports whether the
+// client reopened a dropped connection with a resume token.
+func (h *Hub) Serve(conn *websocket.Conn, userID, sessionID uuid.UUID, resumed bool) {
+	client := &Client{
+		ID:          uuid.New(),
+		UserID:      userID,
+		SessionID:   sessionID,
+		Resumed:     resumed,
+		ConnectedAt: time.Now(),
+		hub:         h,
+		conn:        conn,
+		send:        make(chan []byte, sendBufferSize),
+	}
+
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseServiceRestart, ""))
+		conn.Close()
+		return
+	}
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]bool)
+	}
+	h.clients[userID][client] = true
+	callbacks := h.onConnect
+	h.writers.Add(1)
+	h.mu.Unlock()
+
+	go client.writePump()
+	h.track(client)
+
+	event, _ := NewEvent(EventConnected, map[string]any{"connection_id": client.ID, "user_id": userID, "resumed": resumed})
+	client.Send(event)
+	for _, callback := range callbacks {
+		callback(client)
+	}
+
+	client.readPump()
+}
+
+func (h *Hub) unregister(client *Client) {
+	h.mu.Lock()
+	connections, ok := h.clients[client.UserID]
+	if !ok || !connections[client] {
+		h.mu.Unlock()
+		return
+	}
+	delete(connections, client)
+	if len(connections) == 0 {
+		delete(h.clients, client.UserID)
+	}
+	if client.poll != nil {
+		delete(h.polls, client.ID)
+	}
+	callbacks := h.onDisconnect
+	h.mu.Unlock()
+
+	client.close()
+	h.untrack(client)
+	for _, callback := range callbacks {
+		callback(client)
+	}
+}
+
+func (h *Hub) dispatch(client *Client, event Event) {
+	h.mu.RLock()
+	handler, ok := h.handlers[event.Type]
+	callbacks := h.onEvent
+	h.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		callback(client, event)
+	}
+	if !ok {
+		client.Send(errorEvent(event.RequestID, "unknown event type "+event.Type))
+		return
+	}
+
+	// Events continue the client's trace when they carry one, and
+	// otherwise each start their own; a connection can last for days.
+	ctx := context.Background()
+	if parent, ok := tracing.ParseTraceparent(event.Traceparent); ok {
+		ctx = tracing.ContextWithRemoteParent(ctx, parent)
+	}
+	ctx, span := tracing.Start(ctx, "ws "+event.Type, tracing.KindServer,
+		tracing.String("websocket.event_type", event.Type),
+		tracing.String("websocket.connection_id", client.ID.String()),
+		tracing.String("enduser.id", client.UserID.String()))
+	defer span.End()
+	handler(ctx, client, event)
+}
+
+// SendToUser delivers an event to every connection of a user, on every
+// instance when a bus is configured, and reports whether the user had any
+// connections on this instance.
+func (h *Hub) SendToUser(userID uuid.UUID, event Event) bool {
+	delivered := h.deliver(userID, event)
+	h.publish([]uuid.UUID{userID}, event)
+	return delivered
+}
+
+// SendToUsers delivers an event to each of the given users.
+func (h *Hub) SendToUsers(userIDs []uuid.UUID, event Event) {
+	for _, userID := range userIDs {
+		h.deliver(userID, event)
+	}
+	h.publish(userIDs, event)
+}
+
+// publish relays an event to the other instances. With a router it goes
+// only to those holding the users' connections, except sign-outs, which
+// every instance must remember; when the router cannot say where users
+// are, it goes to every instance.
+func (h *Hub) publish(userIDs []uuid.UUID, event Event) {
+	h.mu.RLock()
+	bus, router := h.bus, h.router
+	h.mu.RUnlock()
+	if bus == nil || len(userIDs) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if router != nil && event.Type != EventSignedOut {
+		located, err := router.Locate(ctx, userIDs)
+		if err == nil {
+			for instanceID, users := range located {
+				if instanceID == h.instanceID {
+					continue
+				}
+				message := BusMessage{Origin: h.instanceID, UserIDs: users, Event: event}
+				if err := router.PublishTo(ctx, instanceID, message); err != nil {
+					slog.ErrorContext(ctx, "Failed to route event", "event_type", event.Type, "instance_id", instanceID, "error", err)
+				}
+			}
+			return
+		}
+		slog.WarnContext(ctx, "Failed to locate users, sending to every instance", "event_type", event.Type, "error", err)
+	}
+	message := BusMessage{Origin: h.instanceID, UserIDs: userIDs, Event: event}
+	if err := bus.Publish(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "Failed to publish event to the realtime bus", "event_type", event.Type, "error", err)
+	}
+}
+
+// deliver sends an event to the user's connections on this instance.
+func (h *Hub) deliver(userID uuid.UUID, event Event) bool {
+	if event.Type == EventSignedOut {
+		h.rememberSignOut(userID, event.Timestamp)
+	}
+
+	h.mu.RLock()
+	targets := make([]*Client, 0, len(h.clients[userID]))
+	for client := range h.clients[userID] {
+		targets = append(targets, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range targets {
+		client.Send(event)
+		if event.Type == EventSignedOut {
+			// Closing lets the queued events flush first.
+			h.unregister(client)
+		}
+	}
+	return len(targets) > 0
+}
+
+// SignOut sends EventSignedOut with reason to every connection of a user,
+// on every instance, and closes them.
+func (h *Hub) SignOut(userID uuid.UUID, reason string) {
+	event, err := NewEvent(EventSignedOut, map[string]string{"reason": reason})
+	if err != nil {
+		slog.Error("Failed to encode event", "event_type", EventSignedOut, "error", err)
+		return
+	}
+	h.SendToUser(userID, event)
+}
+
+func (h *Hub) rememberSignOut(userID uuid.UUID, at time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for id, signedOutAt := range h.signedOut {
+		if time.Since(signedOutAt) > signOutMemory {
+			delete(h.signedOut, id)
+		}
+	}
+	if at.After(h.signedOut[userID]) {
+		h.signedOut[userID] = at
+	}
+}
+
+// SignedOutSince reports whether the user was signed out at or after t, as
+// far back as the hub remembers. Anything issued to the user before a
+// sign-out must not let them back in.
+func (h *Hub) SignedOutSince(userID uuid.UUID, t time.Time) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	signedOutAt, ok := h.signedOut[userID]
+	return ok && !signedOutAt.Before(t)
+}
+
+// IsOnline reports whether a user has at least one open connection on this
+// instance.
+func (h *Hub) IsOnline(userID uuid.UUID) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
+
+// IsConnected reports whether a user has an open connection on any
+// instance, as far as the router knows. Without one only this instance is
+// known.
+func (h *Hub) IsConnected(userID uuid.UUID) bool {
+	if h.IsOnline(userID) {
+		return true
+	}
+	h.mu.RLock()
+	router := h.router
+	h.mu.RUnlock()
+	if router == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	located, err := router.Locate(ctx, []uuid.UUID{userID})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to locate user", "user_id", userID, "error", err)
+		return false
+	}
+	return len(located) > 0
+}
+
+// ConnectedSince returns when the user's longest-open connection on this
+// instance was opened, and false if the user has none.
+func (h *Hub) ConnectedSince(userID uuid.UUID) (time.Time, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var since time.Time
+	for client := range h.clients[userID] {
+		if since.IsZero() || client.ConnectedAt.Before(since) {
+			since = client.ConnectedAt
+		}
+	}
+	return since, !since.IsZero()
+}
+
+// OnlineUserIDs returns the users with at least one open connection.
+func (h *Hub) OnlineUserIDs() []uuid.UUID {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]uuid.UUID, 0, len(h.clients))
+	for userID := range h.clients {
+		ids = append(ids, userID)
+	}
+	return ids
+}
+
+// ConnectionCount returns the number of open connections.
+func (h *Hub) ConnectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	count := 0
+	for _, connections := range h.clients {
+		count += len(connections)
+	}
+	return count
+}
+
+// QueueFill returns the fraction of the WebSocket send buffers holding
+// events not yet written, from 0 when every client keeps up to 1.
+// Long-polling clients hold events between polls and are left out.
+func (h *Hub) QueueFill() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	queued, capacity := 0, 0
+	for _, connections := range h.clients {
+		for client := range connections {
+			if client.LongPolling() {
+				continue
+			}
+			queued += len(client.send)
+			capacity += cap(client.send)
+		}
+	}
+	if capacity == 0 {
+		return 0
+	}
+	return float64(queued) / float64(capacity)
+}
+
+func (h *Hub) closeCode() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.closing {
+		return websocket.CloseServiceRestart
+	}
+	return websocket.CloseNormalClosure
+}
+
+// Shutdown tells every client the server is going away, closes their
+// connections and waits for queued events to flush or for ctx to expire.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	var all []*Client
+	for _, connections := range h.clients {
+		for client := range connections {
+			all = append(all, client)
+		}
+	}
+	h.mu.Unlock()
+
+	event, _ := NewEvent(EventShutdown, nil)
+	for _, client := range all {
+		client.Send(event)
+		h.unregister(client)
+	}
+	slog.InfoContext(ctx, "Closing WebSocket connections", "count", len(all))
+
+	drained := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,220 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// pollIdleTimeout is how long a long-polling client may go without polling
+// before it is disconnected, as a WebSocket would be without pongs.
+const pollIdleTimeout = pongWait
+
+var (
+	// ErrPollNotFound means there is no long-polling client with that ID
+	// for the user on this instance: it never existed, was closed, was
+	// dropped for polling too rarely or was opened on another instance.
+	// The client opens a new one and catches up.
+	ErrPollNotFound = errors.New("long poll not found")
+
+	// ErrPollBusy means another poll of the same client is still waiting.
+	ErrPollBusy = errors.New("long poll already in progress")
+
+	// ErrHubClosing means the hub is shutting down and accepts no new
+	// clients.
+	ErrHubClosing = errors.New("realtime hub is shutting down")
+)
+
+// PolledEvent is an event as returned to a long-polling client, numbered
+// so that the client can acknowledge it on its next poll.
+type PolledEvent struct {
+	Seq   int64           `json:"seq"`
+	Event json.RawMessage `json:"event"`
+}
+
+// pollState holds the events taken off a long-polling client's queue
+// until the client acknowledges them, so a response lost on the way is
+// sent again.
+type pollState struct {
+	busy chan struct{}
+
+	// Guarded by busy
+	seq     int64
+	pending []PolledEvent
+
+	// Guarded by the client's mu
+	lastPoll time.Time
+	polling  bool
+}
+
+// OpenPoll registers a long-polling client for userID, signed in with
+// sessionID. It receives the same events as a WebSocket connection,
+// queued until collected with Poll, and is disconnected when it stops
+// polling.
+func (h *Hub) OpenPoll(userID, sessionID uuid.UUID) (*Client, error) {
+	client := &Client{
+		ID:          uuid.New(),
+		UserID:      userID,
+		SessionID:   sessionID,
+		ConnectedAt: time.Now(),
+		hub:         h,
+		send:        make(chan []byte, sendBufferSize),
+		poll:        &pollState{busy: make(chan struct{}, 1), lastPoll: time.Now()},
+	}
+
+	h.mu.Lock()
+	if h.closing {
+		h.mu.Unlock()
+		return nil, ErrHubClosing
+	}
+	if h.clients[userID] == nil {
+		h.clients[userID] = make(map[*Client]bool)
+	}
+	h.clients[userID][client] = true
+	h.polls[client.ID] = client
+	callbacks := h.onConnect
+	h.mu.Unlock()
+
+	go client.reapIdlePoll()
+	h.track(client)
+
+	event, _ := NewEvent(EventConnected, map[string]any{"connection_id": client.ID, "user_id": userID, "resumed": false})
+	client.Send(event)
+	for _, callback := range callbacks {
+		callback(client)
+	}
+	return client, nil
+}
+
+// Poll returns the events queued for the long-polling client connectionID
+// of userID after acknowledging those up to after, waiting up to wait for
+// one to arrive when none are queued. At most limit events are returned;
+// an empty result means the wait ran out. Events are returned again until
+// acknowledged.
+func (h *Hub) Poll(ctx context.Context, userID, connectionID uuid.UUID, after int64, wait time.Duration, limit int) ([]PolledEvent, error) {
+	client := h.pollClient(userID, connectionID)
+	if client == nil {
+		return nil, ErrPollNotFound
+	}
+	state := client.poll
+	select {
+	case state.busy <- struct{}{}:
+	default:
+		return nil, ErrPollBusy
+	}
+	defer func() { <-state.busy }()
+	client.pollStarted()
+	defer client.pollFinished()
+
+	acked := 0
+	for acked < len(state.pending) && state.pending[acked].Seq <= after {
+		acked++
+	}
+	state.pending = state.pending[acked:]
+
+	open := true
+	if len(state.pending) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case payload, ok := <-client.send:
+			if open = ok; open {
+				state.take(payload)
+			}
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+drain:
+	for open && len(state.pending) < sendBufferSize {
+		select {
+		case payload, ok := <-client.send:
+			if open = ok; open {
+				state.take(payload)
+			}
+		default:
+			break drain
+		}
+	}
+
+	if !open && len(state.pending) == 0 {
+		return nil, ErrPollNotFound
+	}
+	events := state.pending[:min(limit, len(state.pending))]
+	return append([]PolledEvent(nil), events...), nil
+}
+
+// PollSend handles an event sent by the long-polling client connectionID
+// of userID as if it had arrived over a WebSocket. Replies are queued for
+// the client's next poll.
+func (h *Hub) PollSend(userID, connectionID uuid.UUID, event Event) error {
+	client := h.pollClient(userID, connectionID)
+	if client == nil {
+		return ErrPollNotFound
+	}
+	h.dispatch(client, event)
+	return nil
+}
+
+// ClosePoll disconnects the long-polling client connectionID of userID.
+func (h *Hub) ClosePoll(userID, connectionID uuid.UUID) error {
+	client := h.pollClient(userID, connectionID)
+	if client == nil {
+		return ErrPollNotFound
+	}
+	h.unregister(client)
+	return nil
+}
+
+func (h *Hub) pollClient(userID, connectionID uuid.UUID) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	client := h.polls[connectionID]
+	if client == nil || client.UserID != userID {
+		return nil
+	}
+	return client
+}
+
+// take numbers an event taken off the queue and holds it until it is
+// acknowledged.
+func (s *pollState) take(payload []byte) {
+	s.seq++
+	s.pending = append(s.pending, PolledEvent{Seq: s.seq, Event: payload})
+}
+
+func (c *Client) pollStarted() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.poll.polling = true
+}
+
+func (c *Client) pollFinished() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.poll.polling = false
+	c.poll.lastPoll = time.Now()
+}
+
+// reapIdlePoll disconnects a long-polling client once it has gone
+// pollIdleTimeout without polling.
+func (c *Client) reapIdlePoll() {
+	ticker := time.NewTicker(pollIdleTimeout / 4)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.mu.Lock()
+		closed := c.closed
+		idle := !c.poll.polling && time.Since(c.poll.lastPoll) > pollIdleTimeout
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		if idle {
+			c.hub.unregister(c)
+			return
+		}
+	}
+}
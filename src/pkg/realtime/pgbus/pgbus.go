@@ -0,0 +1,178 @@
+package pgbus
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/lib/pq"
+)
+
+// DefaultChannel is the Postgres notification channel instances share.
+const DefaultChannel = "afrochat_realtime"
+
+const (
+	// maxNotifyPayload is the largest payload Postgres accepts in a
+	// notification. Larger messages are spilled to spillTable.
+	maxNotifyPayload = 7999
+
+	// spillTable holds messages too large for a notification until every
+	// instance has had time to read them, spillTTL.
+	spillTable = "realtime_bus_messages"
+	spillTTL   = time.Minute
+
+	// spillPrefix marks a notification naming a spilled message, where
+	// others carry the message itself as JSON.
+	spillPrefix = "spill:"
+
+	connectTimeout = 5 * time.Second
+)
+
+// Bus is a realtime.Bus over Postgres LISTEN/NOTIFY, for deployments
+// running several instances without Redis. It publishes through the
+// application's database pool and listens on a connection of its own.
+// Delivery is at most once, as with Redis: an instance that is
+// disconnected from Postgres misses what is published meanwhile.
+type Bus struct {
+	db       *sql.DB
+	listener *pq.Listener
+	channel  string
+}
+
+// New listens for notifications on channel over a connection opened with
+// dsn, and publishes through db, which must reach the same database.
+func New(db *sql.DB, dsn, channel string) (*Bus, error) {
+	connected := make(chan error, 1)
+	listener := pq.NewListener(dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			select {
+			case connected <- nil:
+			default:
+			}
+		case pq.ListenerEventConnectionAttemptFailed:
+			select {
+			case connected <- err:
+			default:
+			}
+		case pq.ListenerEventDisconnected:
+			slog.Warn("Lost the realtime bus connection to Postgres, reconnecting", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("Reconnected the realtime bus to Postgres")
+		}
+	})
+
+	select {
+	case err := <-connected:
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to connect to Postgres: %w", err)
+		}
+	case <-time.After(connectTimeout):
+		listener.Close()
+		return nil, errors.New("failed to connect to Postgres: timed out")
+	}
+	return &Bus{db: db, listener: listener, channel: channel}, nil
+}
+
+// Publish sends message in a notification, or when it is too large,
+// stores it and sends its ID.
+func (b *Bus) Publish(ctx context.Context, message realtime.BusMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	notification := string(payload)
+	if len(payload) > maxNotifyPayload {
+		if notification, err = b.spill(ctx, payload); err != nil {
+			return err
+		}
+	}
+	if _, err := b.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", b.channel, notification); err != nil {
+		return fmt.Errorf("failed to notify %s: %w", b.channel, err)
+	}
+	return nil
+}
+
+// spill stores a message too large for a notification, clearing out those
+// every instance has read by now, and returns the notification naming it.
+func (b *Bus) spill(ctx context.Context, payload []byte) (string, error) {
+	_, err := b.db.ExecContext(ctx, "DELETE FROM "+spillTable+" WHERE created_at < $1", time.Now().Add(-spillTTL))
+	if err != nil {
+		return "", fmt.Errorf("failed to clear spilled realtime bus messages: %w", err)
+	}
+	var id string
+	err = b.db.QueryRowContext(ctx, "INSERT INTO "+spillTable+" (payload) VALUES ($1) RETURNING id", string(payload)).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("failed to spill realtime bus message: %w", err)
+	}
+	return spillPrefix + id, nil
+}
+
+// Subscribe returns once the instance listens on the channel and delivers
+// messages in the background. The listener reconnects on its own after
+// network errors.
+func (b *Bus) Subscribe(ctx context.Context, handler func(realtime.BusMessage)) error {
+	if err := b.listener.Listen(b.channel); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", b.channel, err)
+	}
+
+	go func() {
+		notifications := b.listener.NotificationChannel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification, ok := <-notifications:
+				if !ok {
+					return
+				}
+				// A nil notification follows a reconnect.
+				if notification == nil {
+					continue
+				}
+				payload, err := b.payload(ctx, notification.Extra)
+				if err != nil {
+					slog.WarnContext(ctx, "Dropping realtime bus message", "error", err)
+					continue
+				}
+				var message realtime.BusMessage
+				if err := json.Unmarshal(payload, &message); err != nil {
+					slog.WarnContext(ctx, "Dropping malformed realtime bus message", "error", err)
+					continue
+				}
+				handler(message)
+			}
+		}
+	}()
+	return nil
+}
+
+// payload returns the message a notification carries or names.
+func (b *Bus) payload(ctx context.Context, notification string) ([]byte, error) {
+	id, spilled := strings.CutPrefix(notification, spillPrefix)
+	if !spilled {
+		return []byte(notification), nil
+	}
+	var payload string
+	err := b.db.QueryRowContext(ctx, "SELECT payload FROM "+spillTable+" WHERE id = $1", id).Scan(&payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spilled message %s: %w", id, err)
+	}
+	return []byte(payload), nil
+}
+
+// Ping checks that the listening connection is up.
+func (b *Bus) Ping(context.Context) error {
+	return b.listener.Ping()
+}
+
+func (b *Bus) Close() error {
+	return b.listener.Close()
+}
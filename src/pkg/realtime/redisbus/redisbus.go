@@ -0,0 +1,212 @@
+package redisbus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultChannel is the Redis pub/sub channel instances share. Each
+	// instance also listens on a channel of its own, named by appending
+	// its ID.
+	DefaultChannel = "afrochat:realtime"
+
+	// connectionsKeyPrefix namespaces the hashes of each user's
+	// connections, from connection ID to instance ID.
+	connectionsKeyPrefix = "afrochat:connections:"
+
+	// instanceKeyPrefix namespaces the keys that mark instances live.
+	instanceKeyPrefix = "afrochat:instance:"
+
+	// instanceTTL is how long an instance is taken to be live after a
+	// heartbeat, a few heartbeats long.
+	instanceTTL = 30 * time.Second
+)
+
+// Bus is a realtime.Router over Redis pub/sub, with a registry of
+// connections in Redis hashes. Delivery is at most once: an instance that
+// is disconnected from Redis misses what is published meanwhile.
+type Bus struct {
+	client  *redis.Client
+	channel string
+}
+
+// New connects to the Redis server at url, such as
+// "redis://:password@localhost:6379/0".
+func New(url, channel string) (*Bus, error) {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	client := redis.NewClient(options)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Bus{client: client, channel: channel}, nil
+}
+
+func (b *Bus) Publish(ctx context.Context, message realtime.BusMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+// Subscribe returns once the subscription is confirmed and delivers
+// messages in the background. The client reconnects on its own after
+// network errors.
+func (b *Bus) Subscribe(ctx context.Context, handler func(realtime.BusMessage)) error {
+	return b.subscribe(ctx, b.channel, handler)
+}
+
+func (b *Bus) subscribe(ctx context.Context, channel string, handler func(realtime.BusMessage)) error {
+	subscription := b.client.Subscribe(ctx, channel)
+	if _, err := subscription.Receive(ctx); err != nil {
+		subscription.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", channel, err)
+	}
+
+	go func() {
+		defer subscription.Close()
+		messages := subscription.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case received, ok := <-messages:
+				if !ok {
+					return
+				}
+				var message realtime.BusMessage
+				if err := json.Unmarshal([]byte(received.Payload), &message); err != nil {
+					slog.WarnContext(ctx, "Dropping malformed realtime bus message", "error", err)
+					continue
+				}
+				handler(message)
+			}
+		}
+	}()
+	return nil
+}
+
+// Ping checks that Redis answers.
+func (b *Bus) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+func (b *Bus) Close() error {
+	return b.client.Close()
+}
+
+// PublishTo sends a message to one instance only.
+func (b *Bus) PublishTo(ctx context.Context, instanceID string, message realtime.BusMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.instanceChannel(instanceID), payload).Err()
+}
+
+// SubscribeInstance delivers the messages sent to one instance, like
+// Subscribe.
+func (b *Bus) SubscribeInstance(ctx context.Context, instanceID string, handler func(realtime.BusMessage)) error {
+	return b.subscribe(ctx, b.instanceChannel(instanceID), handler)
+}
+
+// Register records a connection in the hash of the user's connections,
+// mapping its ID to the instance holding it.
+func (b *Bus) Register(ctx context.Context, instanceID string, userID, connectionID uuid.UUID) error {
+	if err := b.client.HSet(ctx, connectionsKeyPrefix+userID.String(), connectionID.String(), instanceID).Err(); err != nil {
+		return fmt.Errorf("failed to register connection: %w", err)
+	}
+	return nil
+}
+
+func (b *Bus) Unregister(ctx context.Context, instanceID string, userID, connectionID uuid.UUID) error {
+	if err := b.client.HDel(ctx, connectionsKeyPrefix+userID.String(), connectionID.String()).Err(); err != nil {
+		return fmt.Errorf("failed to unregister connection: %w", err)
+	}
+	return nil
+}
+
+// Locate reads the users' connections and leaves out, and deletes, those
+// of instances that stopped beating, as after a crash.
+func (b *Bus) Locate(ctx context.Context, userIDs []uuid.UUID) (map[string][]uuid.UUID, error) {
+	pipe := b.client.Pipeline()
+	reads := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		reads[i] = pipe.HGetAll(ctx, connectionsKeyPrefix+userID.String())
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to locate connections: %w", err)
+	}
+
+	live := make(map[string]bool)
+	for _, read := range reads {
+		for _, instanceID := range read.Val() {
+			live[instanceID] = false
+		}
+	}
+	if len(live) == 0 {
+		return nil, nil
+	}
+	instanceIDs := make([]string, 0, len(live))
+	keys := make([]string, 0, len(live))
+	for instanceID := range live {
+		instanceIDs = append(instanceIDs, instanceID)
+		keys = append(keys, instanceKeyPrefix+instanceID)
+	}
+	beats, err := b.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check instances: %w", err)
+	}
+	for i, beat := range beats {
+		live[instanceIDs[i]] = beat != nil
+	}
+
+	located := make(map[string][]uuid.UUID)
+	stale := b.client.Pipeline()
+	for i, read := range reads {
+		held := make(map[string]bool)
+		for connectionID, instanceID := range read.Val() {
+			if !live[instanceID] {
+				stale.HDel(ctx, connectionsKeyPrefix+userIDs[i].String(), connectionID)
+				continue
+			}
+			if !held[instanceID] {
+				held[instanceID] = true
+				located[instanceID] = append(located[instanceID], userIDs[i])
+			}
+		}
+	}
+	if stale.Len() > 0 {
+		if _, err := stale.Exec(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to delete connections of stopped instances", "error", err)
+		}
+	}
+	return located, nil
+}
+
+// Heartbeat marks an instance live for instanceTTL.
+func (b *Bus) Heartbeat(ctx context.Context, instanceID string) error {
+	if err := b.client.Set(ctx, instanceKeyPrefix+instanceID, time.Now().Unix(), instanceTTL).Err(); err != nil {
+		return fmt.Errorf("failed to mark instance live: %w", err)
+	}
+	return nil
+}
+
+func (b *Bus) instanceChannel(instanceID string) string {
+	return b.channel + ":" + instanceID
+}
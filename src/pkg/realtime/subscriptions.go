@@ -0,0 +1,158 @@
+package realtime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// maxConversationSubscriptions bounds the conversations one client may set
+// its own subscriptions for.
+const maxConversationSubscriptions = 1000
+
+var (
+	ErrUnknownEventClass = errors.New("unknown event class")
+
+	// ErrNotPerConversation is returned for a class of events that are not
+	// about a conversation, which can only be turned on or off everywhere.
+	ErrNotPerConversation = errors.New("event class is not per conversation")
+
+	ErrTooManySubscriptions = errors.New("too many conversation subscriptions")
+)
+
+// eventClasses are the kinds of events a client may turn off, by the
+// prefix of their types. Messages and everything else are always sent.
+var eventClasses = map[string]string{
+	"presence":  "presence.",
+	"typing":    "typing.",
+	"receipts":  "receipt.",
+	"reactions": "reaction.",
+}
+
+// conversationless are the classes whose events are not about one
+// conversation.
+var conversationless = map[string]bool{"presence": true}
+
+// Subscriptions are the classes of events a client receives. Every class
+// is on until turned off. A class turned on or off for a conversation
+// stays so whatever is set for every conversation afterwards.
+type Subscriptions struct {
+	Events        map[string]bool               `json:"events"`
+	Conversations map[uuid.UUID]map[string]bool `json:"conversations"`
+}
+
+// Subscribe turns classes of events on or off for the client, for one
+// conversation or, with conversationID uuid.Nil, for every conversation.
+// It returns the client's subscriptions. They last as long as the
+// connection.
+func (c *Client) Subscribe(classes []string, conversationID uuid.UUID, on bool) (Subscriptions, error) {
+	for _, class := range classes {
+		if _, ok := eventClasses[class]; !ok {
+			return Subscriptions{}, fmt.Errorf("%w %q", ErrUnknownEventClass, class)
+		}
+		if conversationID != uuid.Nil && conversationless[class] {
+			return Subscriptions{}, fmt.Errorf("%w: %s", ErrNotPerConversation, class)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		c.subscriptions = &subscriptions{off: make(map[string]bool), conversations: make(map[uuid.UUID]map[string]bool)}
+	}
+	s := c.subscriptions
+	if conversationID == uuid.Nil {
+		for _, class := range classes {
+			s.off[class] = !on
+		}
+		return s.view(), nil
+	}
+	overrides, ok := s.conversations[conversationID]
+	if !ok {
+		if len(s.conversations) >= maxConversationSubscriptions {
+			return Subscriptions{}, ErrTooManySubscriptions
+		}
+		overrides = make(map[string]bool)
+		s.conversations[conversationID] = overrides
+	}
+	for _, class := range classes {
+		overrides[class] = on
+	}
+	return s.view(), nil
+}
+
+// Subscriptions returns the classes of events the client receives.
+func (c *Client) Subscriptions() Subscriptions {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		return (&subscriptions{}).view()
+	}
+	return c.subscriptions.view()
+}
+
+// wants reports whether the client subscribes to an event. Only clients
+// that changed their subscriptions pay for looking inside events.
+func (c *Client) wants(event Event) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.subscriptions == nil {
+		return true
+	}
+	class := classOf(event.Type)
+	if class == "" {
+		return true
+	}
+	return c.subscriptions.wants(class, event)
+}
+
+type subscriptions struct {
+	off           map[string]bool
+	conversations map[uuid.UUID]map[string]bool
+}
+
+func (s *subscriptions) wants(class string, event Event) bool {
+	if len(s.conversations) > 0 {
+		var about struct {
+			ConversationID uuid.UUID `json:"conversation_id"`
+		}
+		if json.Unmarshal(event.Data, &about) == nil {
+			if on, ok := s.conversations[about.ConversationID][class]; ok {
+				return on
+			}
+		}
+	}
+	return !s.off[class]
+}
+
+func (s *subscriptions) view() Subscriptions {
+	view := Subscriptions{
+		Events:        make(map[string]bool, len(eventClasses)),
+		Conversations: make(map[uuid.UUID]map[string]bool, len(s.conversations)),
+	}
+	for class := range eventClasses {
+		view.Events[class] = !s.off[class]
+	}
+	for id, overrides := range s.conversations {
+		copied := make(map[string]bool, len(overrides))
+		for class, on := range overrides {
+			copied[class] = on
+		}
+		view.Conversations[id] = copied
+	}
+	return view
+}
+
+// classOf returns the class of an event type, or "" for events that are
+// always sent.
+func classOf(eventType string) string {
+	for class, prefix := range eventClasses {
+		if strings.HasPrefix(eventType, prefix) {
+			return class
+		}
+	}
+	return ""
+}
@@ -0,0 +1,59 @@
+package reminders
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/commands"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+// maxPending caps the reminders a user may have pending.
+const maxPending = 100
+
+// Command returns the /remind command backed by the reminders table.
+func Command(dbConnection *database.DatabaseConnection) commands.Command {
+	return commands.Command{
+		Name:        "remind",
+		Usage:       "/remind me in 2h <text>",
+		Description: "Set a reminder sent to you when it is due",
+		Handler: func(ctx context.Context, invocation commands.Invocation) (*commands.Response, error) {
+			var user models.User
+			if err := dbConnection.DB.WithContext(ctx).First(&user, "id = ?", invocation.UserID).Error; err != nil {
+				return nil, fmt.Errorf("failed to load user: %w", err)
+			}
+
+			location, err := time.LoadLocation(user.TimeZone)
+			if err != nil {
+				location = time.UTC
+			}
+
+			remindAt, text, err := Parse(invocation.Args, time.Now(), location)
+			if err != nil {
+				return &commands.Response{Text: err.Error(), Ephemeral: true}, nil
+			}
+
+			reminder := models.Reminder{UserID: user.ID, Text: text, RemindAt: remindAt}
+			if invocation.ConversationID != uuid.Nil {
+				reminder.ConversationID = &invocation.ConversationID
+			}
+			err = repositories.NewReminderRepository(dbConnection.DB).Create(ctx, &reminder, maxPending)
+			if errors.Is(err, repositories.ErrLimitReached) {
+				return &commands.Response{Text: fmt.Sprintf("You can have at most %d reminders pending", maxPending), Ephemeral: true}, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to save reminder: %w", err)
+			}
+
+			return &commands.Response{
+				Text:      fmt.Sprintf("I'll remind you on %s: %s", remindAt.In(location).Format("Mon 2 Jan 15:04"), text),
+				Ephemeral: true,
+			}, nil
+		},
+	}
+}
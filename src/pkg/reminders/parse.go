@@ -0,0 +1,105 @@
+package reminders
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidReminder = errors.New(`expected "me in 2h <text>", "me at 18:00 <text>" or "me tomorrow at 9:00 <text>"`)
+
+const maxReminderDelay = 365 * 24 * time.Hour
+
+var unitAliases = map[string]time.Duration{
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+}
+
+// Parse reads the arguments of a /remind command. Clock times are
+// interpreted in location, the user's time zone.
+func Parse(args string, now time.Time, location *time.Location) (time.Time, string, error) {
+	fields := strings.Fields(args)
+	if len(fields) < 3 || strings.ToLower(fields[0]) != "me" {
+		return time.Time{}, "", ErrInvalidReminder
+	}
+
+	var remindAt time.Time
+	var rest []string
+	switch strings.ToLower(fields[1]) {
+	case "in":
+		delay, consumed, err := parseDelay(fields[2:])
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		remindAt, rest = now.Add(delay), fields[2+consumed:]
+	case "at":
+		clock, err := parseClock(fields[2], now.In(location), 0)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		if !clock.After(now) {
+			clock = clock.AddDate(0, 0, 1)
+		}
+		remindAt, rest = clock, fields[3:]
+	case "tomorrow":
+		if len(fields) < 4 || strings.ToLower(fields[2]) != "at" {
+			return time.Time{}, "", ErrInvalidReminder
+		}
+		clock, err := parseClock(fields[3], now.In(location), 1)
+		if err != nil {
+			return time.Time{}, "", err
+		}
+		remindAt, rest = clock, fields[4:]
+	default:
+		return time.Time{}, "", ErrInvalidReminder
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(strings.Join(rest, " "), "to "))
+	if text == "" {
+		return time.Time{}, "", fmt.Errorf("reminder text is required")
+	}
+	if remindAt.Sub(now) > maxReminderDelay {
+		return time.Time{}, "", fmt.Errorf("reminders can be at most a year away")
+	}
+	return remindAt.UTC(), text, nil
+}
+
+// parseDelay accepts "2h", "1h30m", "90 minutes" and "2 days" and returns the
+// number of fields it consumed.
+func parseDelay(fields []string) (time.Duration, int, error) {
+	if len(fields) == 0 {
+		return 0, 0, ErrInvalidReminder
+	}
+	if delay, err := time.ParseDuration(fields[0]); err == nil && delay > 0 {
+		return delay, 1, nil
+	}
+	if amount, err := strconv.Atoi(fields[0]); err == nil && amount > 0 && len(fields) > 1 {
+		if unit, ok := unitAliases[strings.ToLower(fields[1])]; ok {
+			return time.Duration(amount) * unit, 2, nil
+		}
+	}
+	// Compact day and week forms such as "2d" which time.ParseDuration rejects.
+	number := strings.TrimRightFunc(fields[0], func(r rune) bool { return r < '0' || r > '9' })
+	if amount, err := strconv.Atoi(number); err == nil && amount > 0 {
+		if unit, ok := unitAliases[strings.ToLower(fields[0][len(number):])]; ok {
+			return time.Duration(amount) * unit, 1, nil
+		}
+	}
+	return 0, 0, ErrInvalidReminder
+}
+
+func parseClock(value string, localNow time.Time, addDays int) (time.Time, error) {
+	for _, layout := range []string{"15:04", "3:04pm", "3pm", "15h04"} {
+		t, err := time.Parse(layout, strings.ToLower(value))
+		if err != nil {
+			continue
+		}
+		day := localNow.AddDate(0, 0, addDays)
+		return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), 0, 0, localNow.Location()), nil
+	}
+	return time.Time{}, ErrInvalidReminder
+}
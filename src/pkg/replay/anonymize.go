@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+)
+
+// maxCreated bounds the IDs recorded from one response, so list responses
+// do not bloat the trace.
+const maxCreated = 100
+
+// structuralKeys hold values that shape a request rather than carry user
+// content, such as enums and page sizes. They are recorded as they are;
+// every other string is masked.
+var structuralKeys = map[string]bool{
+	"type":         true,
+	"kind":         true,
+	"status":       true,
+	"role":         true,
+	"platform":     true,
+	"version":      true,
+	"language":     true,
+	"content_type": true,
+	"direction":    true,
+	"limit":        true,
+	"order":        true,
+	"sort":         true,
+}
+
+// anonymizer rewrites recorded requests so no user content or identifier
+// reaches the trace. Each ID becomes a placeholder, the same one wherever
+// the ID appears, so the replayer can tell which requests refer to the
+// same resource. Text is masked letter for letter, keeping its length and
+// shape. Placeholders live as long as the recorder, so a recording holds
+// one entry per ID it has seen.
+type anonymizer struct {
+	mu  sync.Mutex
+	ids map[uuid.UUID]string
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{ids: make(map[uuid.UUID]string)}
+}
+
+// id returns the placeholder for an ID, and whether this is the first time
+// the ID was seen.
+func (a *anonymizer) id(id uuid.UUID) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if placeholder, ok := a.ids[id]; ok {
+		return placeholder, false
+	}
+	placeholder := fmt.Sprintf("{{id:%d}}", len(a.ids)+1)
+	a.ids[id] = placeholder
+	return placeholder, true
+}
+
+// path replaces the IDs in a URL path with placeholders.
+func (a *anonymizer) path(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if id, err := uuid.Parse(segment); err == nil {
+			segments[i], _ = a.id(id)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// query anonymizes query parameters as though they were JSON fields.
+// Cursors are dropped, since they mean nothing to another database.
+func (a *anonymizer) query(values url.Values) url.Values {
+	if len(values) == 0 {
+		return nil
+	}
+	anonymized := make(url.Values, len(values))
+	for key, list := range values {
+		if key == "cursor" {
+			continue
+		}
+		for _, value := range list {
+			anonymized.Add(key, a.text(key, value))
+		}
+	}
+	return anonymized
+}
+
+// body anonymizes a JSON request body. Anything else is not recorded.
+func (a *anonymizer) body(raw []byte) json.RawMessage {
+	var value any
+	if len(raw) == 0 || json.Unmarshal(raw, &value) != nil {
+		return nil
+	}
+	anonymized, err := json.Marshal(a.value("", value))
+	if err != nil {
+		return nil
+	}
+	return anonymized
+}
+
+func (a *anonymizer) value(key string, value any) any {
+	switch value := value.(type) {
+	case map[string]any:
+		for field, nested := range value {
+			value[field] = a.value(field, nested)
+		}
+		return value
+	case []any:
+		for i, nested := range value {
+			value[i] = a.value(key, nested)
+		}
+		return value
+	case string:
+		return a.text(key, value)
+	default:
+		// Numbers, booleans and nulls say nothing about anyone.
+		return value
+	}
+}
+
+func (a *anonymizer) text(key, value string) string {
+	if id, err := uuid.Parse(value); err == nil {
+		placeholder, _ := a.id(id)
+		return placeholder
+	}
+	if structuralKeys[key] {
+		return value
+	}
+	if _, err := time.Parse(time.RFC3339, value); err == nil {
+		return value
+	}
+	return mask(value)
+}
+
+// mask replaces every letter and digit with x, keeping spaces and
+// punctuation so the text keeps its length and word boundaries.
+func mask(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return 'x'
+		}
+		return r
+	}, text)
+}
+
+// created finds the IDs a JSON response introduced, such as that of a
+// conversation it created, keyed by their path in the response.
+func (a *anonymizer) created(raw []byte) map[string]string {
+	var value any
+	if len(raw) == 0 || json.Unmarshal(raw, &value) != nil {
+		return nil
+	}
+	created := make(map[string]string)
+	a.walk("", value, created)
+	if len(created) == 0 {
+		return nil
+	}
+	return created
+}
+
+func (a *anonymizer) walk(path string, value any, created map[string]string) {
+	if len(created) >= maxCreated {
+		return
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		for field, nested := range value {
+			a.walk(join(path, field), nested, created)
+		}
+	case []any:
+		for i, nested := range value {
+			a.walk(join(path, strconv.Itoa(i)), nested, created)
+		}
+	case string:
+		if id, err := uuid.Parse(value); err == nil {
+			if placeholder, isNew := a.id(id); isNew {
+				created[path] = placeholder
+			}
+		}
+	}
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+// lookup returns the string at a path written by walk.
+func lookup(value any, path string) (string, bool) {
+	for _, field := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			value = node[field]
+		case []any:
+			i, err := strconv.Atoi(field)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", false
+			}
+			value = node[i]
+		default:
+			return "", false
+		}
+	}
+	text, ok := value.(string)
+	return text, ok
+}
@@ -0,0 +1,269 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+var placeholderPattern = regexp.MustCompile(`\{\{id:\d+\}\}`)
+
+// Replayer re-drives a trace against a target instance, keeping the
+// recorded gaps between requests, scaled by Speed. Each recorded user is
+// played by a fresh account registered on the target, and each user's
+// requests are sent in recorded order.
+//
+// Resources created during the recording are mapped to the ones the
+// replay creates. Requests for resources that existed before the
+// recording started fail on the target and are reported as such.
+type Replayer struct {
+	Target *url.URL
+
+	// Speed scales time: 2 replays twice as fast as recorded. Zero sends
+	// every request as soon as the one before it for the same user is
+	// done.
+	Speed float64
+
+	// Password is given to the accounts the replay registers.
+	Password string
+
+	client *http.Client
+	runID  string
+
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+func NewReplayer(target *url.URL, speed float64, password string) *Replayer {
+	run := make([]byte, 4)
+	rand.Read(run)
+	return &Replayer{
+		Target:   target,
+		Speed:    speed,
+		Password: password,
+		client:   &http.Client{Timeout: time.Minute},
+		runID:    hex.EncodeToString(run),
+		ids:      make(map[string]string),
+	}
+}
+
+// player is one recorded user, signed in to the target.
+type player struct {
+	token string
+	conn  *websocket.Conn
+	queue chan Entry
+}
+
+// Run replays entries and returns how the target performed. It stops early
+// if ctx ends.
+func (r *Replayer) Run(ctx context.Context, entries []Entry) (*Report, error) {
+	// Entries are written as requests finish, so restore arrival order.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Offset < entries[j].Offset })
+
+	players := make(map[string]*player)
+	for _, entry := range entries {
+		if _, ok := players[entry.User]; ok {
+			continue
+		}
+		p := &player{queue: make(chan Entry, len(entries))}
+		if entry.User != "" {
+			token, err := r.signUp(ctx, entry.User, len(players))
+			if err != nil {
+				return nil, err
+			}
+			p.token = token
+		}
+		players[entry.User] = p
+	}
+
+	report := newReport()
+	var wg sync.WaitGroup
+	for _, p := range players {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range p.queue {
+				r.play(ctx, p, entry, report)
+			}
+			if p.conn != nil {
+				p.conn.Close()
+			}
+		}()
+	}
+
+	start := time.Now()
+	for _, entry := range entries {
+		if r.Speed > 0 {
+			due := start.Add(time.Duration(float64(entry.Offset) / r.Speed))
+			select {
+			case <-time.After(time.Until(due)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		players[entry.User].queue <- entry
+	}
+	for _, p := range players {
+		close(p.queue)
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+	return report, ctx.Err()
+}
+
+// signUp registers an account on the target to play a recorded user, and
+// binds the user's placeholder to the new account's ID.
+func (r *Replayer) signUp(ctx context.Context, placeholder string, n int) (string, error) {
+	username := fmt.Sprintf("replay_%s_%d", r.runID, n)
+	body, _ := json.Marshal(map[string]string{
+		"email":        username + "@replay.invalid",
+		"username":     username,
+		"password":     r.Password,
+		"display_name": username,
+	})
+	resp, err := r.do(ctx, "", http.MethodPost, "/api/v1/auth/register", nil, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to register %s: %w", username, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("failed to register %s: status %d: %s", username, resp.StatusCode, detail)
+	}
+
+	var account struct {
+		AccessToken string `json:"access_token"`
+		User        struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return "", fmt.Errorf("failed to read account of %s: %w", username, err)
+	}
+	r.bind(placeholder, account.User.ID)
+	return account.AccessToken, nil
+}
+
+func (r *Replayer) play(ctx context.Context, p *player, entry Entry, report *Report) {
+	if ctx.Err() != nil {
+		return
+	}
+	if entry.Kind == KindWS {
+		report.addEvent(entry, r.sendEvent(ctx, p, entry))
+		return
+	}
+
+	query := make(url.Values, len(entry.Query))
+	for key, values := range entry.Query {
+		for _, value := range values {
+			query.Add(key, r.resolve(value))
+		}
+	}
+	var body []byte
+	if len(entry.Body) > 0 {
+		body = []byte(r.resolve(string(entry.Body)))
+	}
+
+	started := time.Now()
+	resp, err := r.do(ctx, p.token, entry.Method, r.resolve(entry.Path), query, body)
+	if err != nil {
+		report.addHTTP(entry, 0, time.Since(started))
+		return
+	}
+	defer resp.Body.Close()
+	response, _ := io.ReadAll(resp.Body)
+	report.addHTTP(entry, resp.StatusCode, time.Since(started))
+
+	if len(entry.Created) > 0 && resp.StatusCode < 300 {
+		var value any
+		if json.Unmarshal(response, &value) == nil {
+			for path, placeholder := range entry.Created {
+				if id, ok := lookup(value, path); ok {
+					r.bind(placeholder, id)
+				}
+			}
+		}
+	}
+}
+
+func (r *Replayer) do(ctx context.Context, token, method, path string, query url.Values, body []byte) (*http.Response, error) {
+	target := r.Target.JoinPath(path)
+	target.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r.client.Do(req)
+}
+
+// sendEvent sends a recorded event over the player's WebSocket, opening it
+// on first use. Replies are read and discarded.
+func (r *Replayer) sendEvent(ctx context.Context, p *player, entry Entry) error {
+	if p.conn == nil {
+		endpoint := r.Target.JoinPath("/api/v1/ws")
+		endpoint.Scheme = strings.Replace(endpoint.Scheme, "http", "ws", 1)
+		header := http.Header{"Authorization": {"Bearer " + p.token}}
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, endpoint.String(), header)
+		if err != nil {
+			return err
+		}
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		p.conn = conn
+	}
+
+	event := map[string]any{"type": entry.Event, "request_id": uuid.NewString()}
+	if len(entry.Data) > 0 {
+		event["data"] = json.RawMessage(r.resolve(string(entry.Data)))
+	}
+	return p.conn.WriteJSON(event)
+}
+
+// resolve replaces placeholders with the IDs they are bound to on the
+// target. A placeholder never bound stands for a resource the target does
+// not have, and gets a random ID that consistently fails to resolve.
+func (r *Replayer) resolve(text string) string {
+	return placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		id, ok := r.ids[placeholder]
+		if !ok {
+			id = uuid.NewString()
+			r.ids[placeholder] = id
+		}
+		return id
+	})
+}
+
+func (r *Replayer) bind(placeholder, id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ids[placeholder] = id
+}
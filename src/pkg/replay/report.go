@@ -0,0 +1,117 @@
+package replay
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Report is how a target performed replaying a trace, per route.
+type Report struct {
+	Elapsed time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*RouteStats
+}
+
+// RouteStats compares one route's replayed requests with the recording.
+type RouteStats struct {
+	Route    string
+	Requests int
+
+	// Failed counts requests that got no response; Mismatched those whose
+	// status differs from the recorded one.
+	Failed     int
+	Mismatched int
+
+	Recorded []time.Duration
+	Replayed []time.Duration
+}
+
+func newReport() *Report {
+	return &Report{routes: make(map[string]*RouteStats)}
+}
+
+func (r *Report) route(name string) *RouteStats {
+	stats, ok := r.routes[name]
+	if !ok {
+		stats = &RouteStats{Route: name}
+		r.routes[name] = stats
+	}
+	return stats
+}
+
+func (r *Report) addHTTP(entry Entry, status int, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.route(entry.Method + " " + placeholderPattern.ReplaceAllString(entry.Path, ":id"))
+	stats.Requests++
+	switch {
+	case status == 0:
+		stats.Failed++
+	case status != entry.Status:
+		stats.Mismatched++
+	}
+	if status != 0 {
+		stats.Replayed = append(stats.Replayed, latency)
+	}
+	if entry.Latency > 0 {
+		stats.Recorded = append(stats.Recorded, entry.Latency)
+	}
+}
+
+func (r *Report) addEvent(entry Entry, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := r.route("WS " + entry.Event)
+	stats.Requests++
+	if err != nil {
+		stats.Failed++
+	}
+}
+
+// Routes returns the stats of every route, busiest first.
+func (r *Report) Routes() []*RouteStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	routes := make([]*RouteStats, 0, len(r.routes))
+	for _, stats := range r.routes {
+		routes = append(routes, stats)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Requests != routes[j].Requests {
+			return routes[i].Requests > routes[j].Requests
+		}
+		return routes[i].Route < routes[j].Route
+	})
+	return routes
+}
+
+// Write prints the report as a table, with the recorded and replayed 95th
+// percentile latency of each route side by side.
+func (r *Report) Write(w io.Writer) {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ROUTE\tREQUESTS\tFAILED\tSTATUS CHANGED\tRECORDED P95\tREPLAYED P50\tREPLAYED P95\tREPLAYED P99")
+	for _, stats := range r.Routes() {
+		fmt.Fprintf(table, "%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			stats.Route, stats.Requests, stats.Failed, stats.Mismatched,
+			percentile(stats.Recorded, 0.95),
+			percentile(stats.Replayed, 0.50),
+			percentile(stats.Replayed, 0.95),
+			percentile(stats.Replayed, 0.99))
+	}
+	table.Flush()
+	fmt.Fprintf(w, "\nReplayed in %s\n", r.Elapsed.Round(time.Millisecond))
+}
+
+func percentile(latencies []time.Duration, p float64) string {
+	if len(latencies) == 0 {
+		return "-"
+	}
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))].Round(time.Microsecond).String()
+}
@@ -0,0 +1,172 @@
+// Package replay records anonymized traces of the requests and WebSocket
+// events a server handles, and re-drives them against another instance
+// for performance regression and soak testing.
+package replay
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	KindHTTP = "http"
+	KindWS   = "ws"
+)
+
+// Entry is one recorded request or inbound WebSocket event. IDs in it are
+// placeholders like {{id:7}}, and user content is masked.
+type Entry struct {
+	// Offset is when it arrived, from the start of the recording.
+	Offset time.Duration `json:"offset"`
+	Kind   string        `json:"kind"`
+
+	// User is the placeholder for the signed-in user, empty for
+	// anonymous requests.
+	User string `json:"user,omitempty"`
+
+	// HTTP requests
+	Method  string          `json:"method,omitempty"`
+	Path    string          `json:"path,omitempty"`
+	Query   url.Values      `json:"query,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Status  int             `json:"status,omitempty"`
+	Latency time.Duration   `json:"latency,omitempty"`
+
+	// Created maps paths in the JSON response to the placeholders of IDs
+	// it introduced, so the replayer can learn the IDs the target assigns
+	// in their place.
+	Created map[string]string `json:"created,omitempty"`
+
+	// WebSocket events
+	Event string          `json:"event,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+// Recorder anonymizes requests and events and appends them to a trace
+// file, one JSON entry per line. It is safe for concurrent use.
+type Recorder struct {
+	anonymizer *anonymizer
+	started    time.Time
+	sampleRate float64
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// NewRecorder starts recording to path, truncating any trace already
+// there. Only users in a sampleRate fraction are recorded; sampling by
+// user rather than by request keeps each recorded user's requests whole.
+func NewRecorder(path string, sampleRate float64) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace file: %w", err)
+	}
+	return &Recorder{
+		anonymizer: newAnonymizer(),
+		started:    time.Now(),
+		sampleRate: sampleRate,
+		file:       file,
+		writer:     bufio.NewWriter(file),
+	}, nil
+}
+
+// Sampled reports whether requests from a user are recorded. Anonymous
+// requests are recorded at the sample rate too, all or none of them.
+func (r *Recorder) Sampled(userID uuid.UUID) bool {
+	hash := fnv.New32a()
+	hash.Write(userID[:])
+	return float64(hash.Sum32())/(1<<32) < r.sampleRate
+}
+
+// RecordHTTP records a handled request. Bodies that are not JSON are
+// left out.
+func (r *Recorder) RecordHTTP(at time.Time, userID uuid.UUID, method, path string, query url.Values, body []byte, status int, latency time.Duration, response []byte) {
+	entry := Entry{
+		Offset:  at.Sub(r.started),
+		Kind:    KindHTTP,
+		User:    r.user(userID),
+		Method:  method,
+		Path:    r.anonymizer.path(path),
+		Query:   r.anonymizer.query(query),
+		Body:    r.anonymizer.body(body),
+		Status:  status,
+		Latency: latency,
+	}
+	// IDs the request mentioned are known by now, so only new ones count.
+	entry.Created = r.anonymizer.created(response)
+	r.write(entry)
+}
+
+// RecordEvent records an inbound WebSocket event.
+func (r *Recorder) RecordEvent(at time.Time, userID uuid.UUID, eventType string, data []byte) {
+	r.write(Entry{
+		Offset: at.Sub(r.started),
+		Kind:   KindWS,
+		User:   r.user(userID),
+		Event:  eventType,
+		Data:   r.anonymizer.body(data),
+	})
+}
+
+func (r *Recorder) user(userID uuid.UUID) string {
+	if userID == uuid.Nil {
+		return ""
+	}
+	placeholder, _ := r.anonymizer.id(userID)
+	return placeholder
+}
+
+func (r *Recorder) write(entry Entry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.writer.Write(line)
+	r.writer.WriteByte('\n')
+}
+
+// Close flushes the trace and closes its file.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return r.file.Close()
+}
+
+// ReadTrace loads a trace written by a Recorder, in recorded order.
+func ReadTrace(path string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("trace line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	return entries, nil
+}
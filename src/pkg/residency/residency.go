@@ -0,0 +1,89 @@
+package residency
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Region is a data residency jurisdiction. Content tagged with a region
+// must be stored in infrastructure located in that jurisdiction.
+type Region string
+
+const (
+	RegionDefault     Region = "default"
+	RegionSouthAfrica Region = "za"
+	RegionKenya       Region = "ke"
+	RegionNigeria     Region = "ng"
+	RegionEU          Region = "eu"
+)
+
+// Regions lists the regions whose content is kept apart from the default
+// region's.
+var Regions = []Region{RegionSouthAfrica, RegionKenya, RegionNigeria, RegionEU}
+
+// Known reports whether region is one of Regions.
+func Known(region Region) bool {
+	return slices.Contains(Regions, region)
+}
+
+// countryRegions lists countries whose data-protection law requires local or
+// adequate-jurisdiction storage, keyed by ISO 3166-1 alpha-2 code.
+var countryRegions = map[string]Region{
+	"ZA": RegionSouthAfrica, // POPIA
+	"KE": RegionKenya,       // Data Protection Act 2019
+	"NG": RegionNigeria,     // NDPA 2023
+	"AT": RegionEU, "BE": RegionEU, "DE": RegionEU, "DK": RegionEU, "ES": RegionEU,
+	"FI": RegionEU, "FR": RegionEU, "IE": RegionEU, "IT": RegionEU, "NL": RegionEU,
+	"PL": RegionEU, "PT": RegionEU, "SE": RegionEU,
+}
+
+// ForCountry returns the residency region a user from country belongs to.
+func ForCountry(country string) Region {
+	if region, ok := countryRegions[strings.ToUpper(country)]; ok {
+		return region
+	}
+	return RegionDefault
+}
+
+// Policy decides where content for a residency region is stored. Buckets
+// maps regions to storage buckets; regions without an entry use Default.
+type Policy struct {
+	Default string
+	Buckets map[Region]string
+}
+
+func (p Policy) BucketFor(region Region) string {
+	if bucket, ok := p.Buckets[region]; ok {
+		return bucket
+	}
+	return p.Default
+}
+
+// Strict reports whether content in region may not fall back to the default
+// bucket. Regions named by law must be configured explicitly.
+func (p Policy) Strict(region Region) bool {
+	return region != RegionDefault
+}
+
+// ParseBuckets reads the buckets of regions from a list such as
+// "za=afrochat-za,ke=afrochat-ke".
+func ParseBuckets(s string) (map[Region]string, error) {
+	buckets := make(map[Region]string)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		region, bucket, _ := strings.Cut(item, "=")
+		region, bucket = strings.TrimSpace(region), strings.TrimSpace(bucket)
+		if !Known(Region(region)) {
+			return nil, fmt.Errorf("unknown residency region %q", region)
+		}
+		if bucket == "" {
+			return nil, fmt.Errorf("region %s needs a bucket", region)
+		}
+		buckets[Region(region)] = bucket
+	}
+	return buckets, nil
+}
@@ -0,0 +1,274 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// openSearchMapping indexes the text for full-text search and the rest
+// as exact values for filtering. created_at keeps nanoseconds so it
+// orders messages exactly as the database does.
+const openSearchMapping = `{
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "id":              {"type": "keyword"},
+      "conversation_id": {"type": "keyword"},
+      "sender_id":       {"type": "keyword"},
+      "text":            {"type": "text"},
+      "created_at":      {"type": "date_nanos"}
+    }
+  }
+}`
+
+// OpenSearchConfig locates the cluster and the index holding messages.
+// Username and Password are for clusters with basic authentication.
+type OpenSearchConfig struct {
+	URL      string
+	Index    string
+	Username string
+	Password string
+}
+
+// OpenSearch searches messages in an OpenSearch (or Elasticsearch) index,
+// which ranks and scales better than Postgres on large archives. The index
+// holds only what is needed to find messages; they are loaded from the
+// database, so results never show a message as it was before an edit or
+// after it was deleted.
+type OpenSearch struct {
+	config OpenSearchConfig
+	db     *gorm.DB
+	client *http.Client
+}
+
+func NewOpenSearch(config OpenSearchConfig, db *gorm.DB) *OpenSearch {
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	return &OpenSearch{config: config, db: db, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// openSearchDocument is a message as indexed.
+type openSearchDocument struct {
+	ID             uuid.UUID `json:"id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	SenderID       uuid.UUID `json:"sender_id"`
+	Text           string    `json:"text"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// do sends a request to the cluster and returns the response body when
+// the status is one of ok.
+func (o *OpenSearch) do(ctx context.Context, method, path, contentType string, body []byte, ok ...int) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, o.config.URL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if o.config.Username != "" {
+		req.SetBasicAuth(o.config.Username, o.config.Password)
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return content, resp.StatusCode, nil
+		}
+	}
+	if len(content) > 4096 {
+		content = content[:4096]
+	}
+	return nil, resp.StatusCode, fmt.Errorf("OpenSearch answered %d: %s", resp.StatusCode, content)
+}
+
+// Ping checks that the cluster answers.
+func (o *OpenSearch) Ping(ctx context.Context) error {
+	_, _, err := o.do(ctx, http.MethodGet, "/", "", nil, http.StatusOK)
+	return err
+}
+
+// EnsureIndex creates the index with its mapping, unless it exists.
+func (o *OpenSearch) EnsureIndex(ctx context.Context) error {
+	path := "/" + url.PathEscape(o.config.Index)
+	_, status, err := o.do(ctx, http.MethodHead, path, "", nil, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+	if status == http.StatusOK {
+		return nil
+	}
+	if _, _, err := o.do(ctx, http.MethodPut, path, "application/json", []byte(openSearchMapping), http.StatusOK); err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	return nil
+}
+
+// Put indexes messages in one bulk request, replacing earlier versions.
+func (o *OpenSearch) Put(ctx context.Context, messages []models.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, message := range messages {
+		action := map[string]map[string]string{"index": {"_index": o.config.Index, "_id": message.ID.String()}}
+		document := openSearchDocument{
+			ID:             message.ID,
+			ConversationID: message.ConversationID,
+			SenderID:       message.SenderID,
+			Text:           message.Text,
+			CreatedAt:      message.CreatedAt,
+		}
+		if err := encoder.Encode(action); err != nil {
+			return fmt.Errorf("failed to encode search document: %w", err)
+		}
+		if err := encoder.Encode(document); err != nil {
+			return fmt.Errorf("failed to encode search document: %w", err)
+		}
+	}
+	content, _, err := o.do(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to index messages: %w", err)
+	}
+
+	// A bulk request succeeds as a whole even when items fail.
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []struct {
+			Index struct {
+				ID    string          `json:"_id"`
+				Error json.RawMessage `json:"error"`
+			} `json:"index"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	if result.Errors {
+		for _, item := range result.Items {
+			if item.Index.Error != nil {
+				return fmt.Errorf("failed to index message %s: %s", item.Index.ID, item.Index.Error)
+			}
+		}
+	}
+	return nil
+}
+
+// Delete drops a message from the index. A message that was never indexed
+// is not an error.
+func (o *OpenSearch) Delete(ctx context.Context, messageID uuid.UUID) error {
+	path := "/" + url.PathEscape(o.config.Index) + "/_doc/" + messageID.String()
+	if _, _, err := o.do(ctx, http.MethodDelete, path, "", nil, http.StatusOK, http.StatusNotFound); err != nil {
+		return fmt.Errorf("failed to remove message from search index: %w", err)
+	}
+	return nil
+}
+
+func (o *OpenSearch) Search(ctx context.Context, query Query) ([]models.Message, error) {
+	// The cluster knows nothing of membership, so the conversations the
+	// user may search are looked up first.
+	var conversationIDs []uuid.UUID
+	err := o.db.WithContext(ctx).Model(&models.ConversationMember{}).
+		Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id").
+		Where("conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL AND conversations.workspace_id = ?", query.UserID, query.WorkspaceID).
+		Pluck("conversation_members.conversation_id", &conversationIDs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversations to search: %w", err)
+	}
+	if len(conversationIDs) == 0 {
+		return nil, nil
+	}
+
+	filters := []any{map[string]any{"terms": map[string]any{"conversation_id": conversationIDs}}}
+	if query.SenderID != uuid.Nil {
+		filters = append(filters, map[string]any{"term": map[string]any{"sender_id": query.SenderID}})
+	}
+	if query.Since != nil || query.Until != nil {
+		bounds := map[string]any{}
+		if query.Since != nil {
+			bounds["gte"] = query.Since.Format(time.RFC3339Nano)
+		}
+		if query.Until != nil {
+			bounds["lt"] = query.Until.Format(time.RFC3339Nano)
+		}
+		filters = append(filters, map[string]any{"range": map[string]any{"created_at": bounds}})
+	}
+	request := map[string]any{
+		"size":    query.Limit,
+		"_source": false,
+		"query": map[string]any{"bool": map[string]any{
+			"must": map[string]any{"simple_query_string": map[string]any{
+				"query":            query.Text,
+				"fields":           []string{"text"},
+				"default_operator": "and",
+			}},
+			"filter": filters,
+		}},
+		"sort": []any{
+			map[string]string{"created_at": "desc"},
+			map[string]string{"id": "desc"},
+		},
+	}
+	if query.After != nil {
+		request["search_after"] = []any{query.After.Time.UnixNano(), query.After.ID}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode search: %w", err)
+	}
+	path := "/" + url.PathEscape(o.config.Index) + "/_search"
+	content, _, err := o.do(ctx, http.MethodPost, path, "application/json", body, http.StatusOK)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID string `json:"_id"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.Unmarshal(content, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse search results: %w", err)
+	}
+	ids := make([]uuid.UUID, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		if id, err := uuid.Parse(hit.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// Messages deleted or shadowed since they were indexed are left out.
+	var messages []models.Message
+	err = o.db.WithContext(ctx).
+		Preload("Attachments").
+		Where("id IN ? AND NOT shadowed", ids).
+		Order("created_at DESC, id DESC").
+		Find(&messages).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load found messages: %w", err)
+	}
+	return messages, nil
+}
@@ -0,0 +1,63 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PostgresIndex searches the messages table through its search_vector
+// column, which Postgres derives from the text of every message. The
+// 'simple' configuration matches words as written, without stemming or
+// stop words, since messages are in many languages Postgres has no
+// dictionary for.
+type PostgresIndex struct {
+	db *gorm.DB
+}
+
+func NewPostgresIndex(db *gorm.DB) *PostgresIndex {
+	return &PostgresIndex{db: db}
+}
+
+// Add does nothing: the column is generated as the message is stored.
+func (p *PostgresIndex) Add(context.Context, *models.Message) error {
+	return nil
+}
+
+// Remove does nothing: searches skip deleted messages.
+func (p *PostgresIndex) Remove(context.Context, uuid.UUID) error {
+	return nil
+}
+
+func (p *PostgresIndex) Search(ctx context.Context, query Query) ([]models.Message, error) {
+	db := p.db.WithContext(ctx).
+		Preload("Attachments").
+		Where("search_vector @@ websearch_to_tsquery('simple', ?)", query.Text).
+		Where("conversation_id IN (?)", p.db.Model(&models.ConversationMember{}).
+			Select("conversation_members.conversation_id").
+			Joins("JOIN conversations ON conversations.id = conversation_members.conversation_id").
+			Where("conversation_members.user_id = ? AND conversation_members.deleted_at IS NULL AND conversations.workspace_id = ?", query.UserID, query.WorkspaceID))
+	// Shadowed messages are found by their sender alone.
+	db = db.Where("NOT shadowed OR sender_id = ?", query.UserID)
+	if query.SenderID != uuid.Nil {
+		db = db.Where("sender_id = ?", query.SenderID)
+	}
+	if query.Since != nil {
+		db = db.Where("created_at >= ?", *query.Since)
+	}
+	if query.Until != nil {
+		db = db.Where("created_at < ?", *query.Until)
+	}
+	if query.After != nil {
+		db = db.Where("(created_at, id) < (?, ?)", query.After.Time, query.After.ID)
+	}
+
+	var messages []models.Message
+	if err := db.Order("created_at DESC, id DESC").Limit(query.Limit).Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	return messages, nil
+}
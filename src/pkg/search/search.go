@@ -0,0 +1,94 @@
+// Package search finds messages by their text. Index hides the engine, so
+// the Postgres full-text index can be replaced by a dedicated search
+// service without touching the API.
+package search
+
+import (
+	"context"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/google/uuid"
+)
+
+// MaxQueryLength is the longest search text accepted, in characters.
+const MaxQueryLength = 256
+
+// Query is a message search on behalf of a user. Only messages in
+// conversations of the workspace the user belongs to match.
+type Query struct {
+	UserID      uuid.UUID
+	WorkspaceID uuid.UUID
+	Text        string
+
+	// Optional filters: SenderID is uuid.Nil for any sender, and Since and
+	// Until bound when the message was sent.
+	SenderID uuid.UUID
+	Since    *time.Time
+	Until    *time.Time
+
+	// After is where the previous page ended; nil for the first page.
+	After *pagination.Cursor
+	Limit int
+}
+
+// Index makes messages searchable.
+type Index interface {
+	// Add indexes a newly stored message, or reindexes an edited one. It
+	// is called while the message is being sent, so engines with a remote
+	// index should queue the message rather than wait. Engines that index
+	// inside the database may have nothing to do.
+	Add(ctx context.Context, message *models.Message) error
+
+	// Remove drops a deleted message from the index, under the same
+	// constraints as Add.
+	Remove(ctx context.Context, messageID uuid.UUID) error
+
+	// Search returns up to query.Limit matching messages, newest first,
+	// with their attachments loaded.
+	Search(ctx context.Context, query Query) ([]models.Message, error)
+}
+
+// Cursor is the position of a message in search results.
+func Cursor(message *models.Message) pagination.Cursor {
+	return pagination.Cursor{Time: message.CreatedAt, ID: message.ID}
+}
+
+// Remote is a search engine outside the database. Writing to it takes a
+// network round trip, so it is done by a background indexer rather than
+// while a message is sent.
+type Remote interface {
+	// Put indexes messages, replacing earlier versions of them.
+	Put(ctx context.Context, messages []models.Message) error
+
+	// Delete drops a message from the index.
+	Delete(ctx context.Context, messageID uuid.UUID) error
+
+	// Search is as for Index.
+	Search(ctx context.Context, query Query) ([]models.Message, error)
+}
+
+// Queued is the Index of a Remote engine. Add and Remove only hand the
+// message ID to Enqueue; the indexer then writes the message as stored at
+// that time, or drops it when it is gone, so the index ends up right
+// whatever order the writes run in.
+type Queued struct {
+	Remote
+	Enqueue func(ctx context.Context, messageID uuid.UUID) error
+}
+
+func (q *Queued) Add(ctx context.Context, message *models.Message) error {
+	return q.Enqueue(ctx, message.ID)
+}
+
+func (q *Queued) Remove(ctx context.Context, messageID uuid.UUID) error {
+	return q.Enqueue(ctx, messageID)
+}
+
+// Indexable reports whether a stored message belongs in a search index:
+// one with text that is not shadowed. Encrypted messages have none the
+// server can read, and shadowed ones are indexed once released.
+func Indexable(message *models.Message) bool {
+	return message.Text != "" && !message.Shadowed
+}
@@ -0,0 +1,82 @@
+// Package secretbox encrypts secrets kept in the database, such as the
+// keys of owners' buckets, with AES-256-GCM under a key of the server's,
+// so a leaked database or backup does not leak them too.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeySize is the size of the key, before it is base64 encoded.
+const KeySize = 32
+
+// sealedPrefix marks sealed values and the version of their format.
+const sealedPrefix = "sb1:"
+
+// ErrNotSealed means a value was stored before secrets were sealed.
+var ErrNotSealed = errors.New("value is not sealed")
+
+// Box seals and opens secrets with one key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New returns a box using key, KeySize bytes encoded as standard base64.
+func New(key string) (*Box, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("secret key is not base64: %w", err)
+	}
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes, not %d", KeySize, len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext under a random nonce. The result is text, safe
+// to store in a string column.
+func (b *Box) Seal(plaintext string) (string, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(plaintext), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a value returned by Seal. Values stored before secrets
+// were sealed fail with ErrNotSealed.
+func (b *Box) Open(sealed string) (string, error) {
+	if !IsSealed(sealed) {
+		return "", ErrNotSealed
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil || len(raw) < b.aead.NonceSize() {
+		return "", errors.New("sealed value is malformed")
+	}
+	nonce, ciphertext := raw[:b.aead.NonceSize()], raw[b.aead.NonceSize():]
+	plaintext, err := b.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", errors.New("sealed value could not be opened; was the key changed?")
+	}
+	return string(plaintext), nil
+}
+
+// IsSealed reports whether value was returned by Seal.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
@@ -0,0 +1,148 @@
+// Package shortlink makes the codes of short links and checks where they
+// lead before anyone is sent there.
+package shortlink
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"unicode"
+)
+
+const (
+	// CodeLength gives 62^8 codes, enough that guessing one is hopeless.
+	CodeLength = 8
+
+	// MaxDestinationLength is the longest destination a link may have.
+	MaxDestinationLength = 2048
+
+	codeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+// Platforms clicks are counted by.
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+	PlatformDesktop = "desktop"
+	PlatformOther   = "other"
+)
+
+var ErrUnsafeDestination = errors.New("unsafe destination")
+
+// UnsafeDestinationError says why a destination was refused. It matches
+// ErrUnsafeDestination with errors.Is.
+type UnsafeDestinationError struct {
+	Reason string
+}
+
+func (e *UnsafeDestinationError) Error() string {
+	return "unsafe destination: " + e.Reason
+}
+
+func (e *UnsafeDestinationError) Is(target error) bool {
+	return target == ErrUnsafeDestination
+}
+
+func unsafe(reason string) error {
+	return &UnsafeDestinationError{Reason: reason}
+}
+
+// NewCode returns a random link code.
+func NewCode() (string, error) {
+	buf := make([]byte, CodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate link code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// ValidCode reports whether code could be a link code, so lookups of
+// junk can be refused without a query.
+func ValidCode(code string) bool {
+	if len(code) != CodeLength {
+		return false
+	}
+	for i := 0; i < len(code); i++ {
+		if !strings.ContainsRune(codeAlphabet, rune(code[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// Scanner refuses destinations a short link must not lead to: anything
+// but plain web addresses, addresses on private networks, lookalike
+// international hostnames, and hosts known for abuse.
+type Scanner struct {
+	// BlockedHosts are refused along with their subdomains.
+	BlockedHosts []string
+}
+
+// Scan checks a destination and returns it normalised, or an
+// UnsafeDestinationError saying why it was refused. Links are scanned
+// when created and again when followed, so hosts blocked later stop
+// working too.
+func (s Scanner) Scan(destination string) (string, error) {
+	destination = strings.TrimSpace(destination)
+	if destination == "" {
+		return "", unsafe("destination is empty")
+	}
+	if len(destination) > MaxDestinationLength {
+		return "", unsafe(fmt.Sprintf("destination is longer than %d characters", MaxDestinationLength))
+	}
+	if strings.IndexFunc(destination, unicode.IsControl) >= 0 {
+		return "", unsafe("destination contains control characters")
+	}
+
+	link, err := url.Parse(destination)
+	if err != nil {
+		return "", unsafe("destination is not a valid URL")
+	}
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return "", unsafe("only http and https links are allowed")
+	}
+	if link.User != nil {
+		return "", unsafe("links may not carry credentials")
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(link.Hostname()), ".")
+	switch {
+	case host == "":
+		return "", unsafe("destination has no host")
+	case net.ParseIP(host) != nil:
+		return "", unsafe("links must name a host, not an address")
+	case host == "localhost" || !strings.Contains(host, "."):
+		return "", unsafe("links may not lead to local hosts")
+	case strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") || strings.HasSuffix(host, ".internal"):
+		return "", unsafe("links may not lead to local hosts")
+	case strings.HasPrefix(host, "xn--") || strings.Contains(host, ".xn--"):
+		return "", unsafe("international hostnames are not allowed")
+	}
+	for _, blocked := range s.BlockedHosts {
+		blocked = strings.TrimSuffix(strings.ToLower(blocked), ".")
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return "", unsafe("destination host is blocked")
+		}
+	}
+	return link.String(), nil
+}
+
+// ClientPlatform names the platform of the client sending userAgent.
+func ClientPlatform(userAgent string) string {
+	agent := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(agent, "android"):
+		return PlatformAndroid
+	case strings.Contains(agent, "iphone") || strings.Contains(agent, "ipad") || strings.Contains(agent, "ios"):
+		return PlatformIOS
+	case strings.Contains(agent, "windows") || strings.Contains(agent, "macintosh") || strings.Contains(agent, "linux"):
+		return PlatformDesktop
+	}
+	return PlatformOther
+}
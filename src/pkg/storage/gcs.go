@@ -0,0 +1,284 @@
+package storage
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/googleauth"
+)
+
+const (
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+	// gcsDefaultEndpoint is Google Cloud Storage itself; emulators such as
+	// fake-gcs-server listen elsewhere.
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+
+	// gcsMaxExpiry is the longest a V4 signed URL may be valid.
+	gcsMaxExpiry = 7 * 24 * time.Hour
+)
+
+// GCSConfig locates a Google Cloud Storage bucket and the service account
+// key file of an account allowed to read and write its objects.
+type GCSConfig struct {
+	Bucket          string
+	CredentialsFile string
+
+	// Endpoint overrides the API address, for emulators. Empty means
+	// Google Cloud Storage.
+	Endpoint string
+}
+
+// GCS stores objects in a Google Cloud Storage bucket through its JSON
+// API. Clients can upload and download directly with V4 signed URLs.
+type GCS struct {
+	bucket   string
+	endpoint string
+	account  *googleauth.ServiceAccount
+	tokens   *googleauth.TokenSource
+	client   *http.Client
+}
+
+func NewGCS(cfg GCSConfig) (*GCS, error) {
+	account, err := googleauth.LoadServiceAccount(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load GCS credentials: %w", err)
+	}
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = gcsDefaultEndpoint
+	}
+	if _, err := url.Parse(endpoint); err != nil {
+		return nil, fmt.Errorf("invalid GCS endpoint: %w", err)
+	}
+	// Uploads and downloads stream; ctx bounds them rather than a client
+	// timeout.
+	client := &http.Client{}
+	return &GCS{
+		bucket:   cfg.Bucket,
+		endpoint: endpoint,
+		account:  account,
+		tokens:   googleauth.NewTokenSource(account, gcsScope, client),
+		client:   client,
+	}, nil
+}
+
+// objectURL returns the JSON API URL of an object's metadata.
+func (g *GCS) objectURL(key string) string {
+	return g.endpoint + "/storage/v1/b/" + url.PathEscape(g.bucket) + "/o/" + url.PathEscape(key)
+}
+
+// do sends an authorized request and returns the response when its status
+// is one of ok. A 404 is ErrNotFound.
+func (g *GCS) do(req *http.Request, ok ...int) (*http.Response, error) {
+	token, err := g.tokens.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize GCS request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range ok {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return nil, fmt.Errorf("GCS answered %d: %s", resp.StatusCode, detail)
+}
+
+func (g *GCS) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	query := url.Values{"uploadType": {"media"}, "name": {key}}
+	endpoint := g.endpoint + "/upload/storage/v1/b/" + url.PathEscape(g.bucket) + "/o?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+	resp, err := g.do(req, http.StatusOK)
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (g *GCS) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(key)+"?alt=media", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	resp, err := g.do(req, http.StatusOK)
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	return resp.Body, nil
+}
+
+func (g *GCS) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.objectURL(key), nil)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+	resp, err := g.do(req, http.StatusOK)
+	if errors.Is(err, ErrNotFound) {
+		return ObjectInfo{}, ErrNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The API encodes the 64-bit size as a string.
+	var object struct {
+		Size        string `json:"size"`
+		ContentType string `json:"contentType"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&object); err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+	size, err := strconv.ParseInt(object.Size, 10, 64)
+	if err != nil {
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: invalid size %q", object.Size)
+	}
+	return ObjectInfo{Size: size, ContentType: object.ContentType}, nil
+}
+
+// Delete removes the object. Deleting a missing object succeeds, as with
+// the other backends.
+func (g *GCS) Delete(ctx context.Context, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, g.objectURL(key), nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	resp, err := g.do(req, http.StatusNoContent, http.StatusOK)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PresignPut signs the content type into the URL, so the client must upload
+// with the type it declared.
+func (g *GCS) PresignPut(_ context.Context, key, contentType string, expiry time.Duration) (*PresignedRequest, error) {
+	u, err := g.sign(http.MethodPut, key, map[string]string{"content-type": contentType}, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+	return &PresignedRequest{
+		Method:    http.MethodPut,
+		URL:       u,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(expiry),
+	}, nil
+}
+
+func (g *GCS) PresignGet(_ context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	u, err := g.sign(http.MethodGet, key, nil, expiry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign download: %w", err)
+	}
+	return &PresignedRequest{Method: http.MethodGet, URL: u, ExpiresAt: time.Now().Add(expiry)}, nil
+}
+
+// sign returns a V4 signed URL for the object, signed with the service
+// account's key. headers, with lowercase names, must be sent with the
+// request as given.
+func (g *GCS) sign(method, key string, headers map[string]string, expiry time.Duration) (string, error) {
+	if expiry <= 0 || expiry > gcsMaxExpiry {
+		return "", fmt.Errorf("expiry must be positive and at most %s", gcsMaxExpiry)
+	}
+	endpoint, err := url.Parse(g.endpoint)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	scope := date + "/auto/storage/goog4_request"
+
+	signed := map[string]string{"host": endpoint.Host}
+	for name, value := range headers {
+		signed[name] = value
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(signed[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := url.Values{
+		"X-Goog-Algorithm":     {"GOOG4-RSA-SHA256"},
+		"X-Goog-Credential":    {g.account.ClientEmail + "/" + scope},
+		"X-Goog-Date":          {now.Format("20060102T150405Z")},
+		"X-Goog-Expires":       {strconv.Itoa(int(expiry.Seconds()))},
+		"X-Goog-SignedHeaders": {signedHeaders},
+	}
+	// Encode sorts by key and escapes as the canonical query needs, save
+	// for spaces, which V4 signing writes as %20.
+	canonicalQuery := strings.ReplaceAll(query.Encode(), "+", "%20")
+	path := "/" + url.PathEscape(g.bucket) + "/" + escapeObjectPath(key)
+
+	canonicalRequest := strings.Join([]string{
+		method, path, canonicalQuery, canonicalHeaders.String(), signedHeaders, "UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256", now.Format("20060102T150405Z"), scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, g.account.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return g.endpoint + path + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
+
+// escapeObjectPath percent-encodes an object name for the path of a signed
+// URL, keeping its slashes. Everything but unreserved characters is
+// encoded, as the canonical request requires.
+func escapeObjectPath(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '.', b == '_', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
@@ -0,0 +1,29 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/storage/storagetest"
+)
+
+// TestGCS runs against the bucket named by STORAGETEST_GCS_BUCKET, with
+// the service account key file STORAGETEST_GCS_CREDENTIALS_FILE, on an
+// emulator such as fake-gcs-server when STORAGETEST_GCS_ENDPOINT is set.
+// It is skipped when no bucket is named.
+func TestGCS(t *testing.T) {
+	bucket := os.Getenv("STORAGETEST_GCS_BUCKET")
+	if bucket == "" {
+		t.Skip("STORAGETEST_GCS_BUCKET is not set")
+	}
+	store, err := storage.NewGCS(storage.GCSConfig{
+		Bucket:          bucket,
+		CredentialsFile: os.Getenv("STORAGETEST_GCS_CREDENTIALS_FILE"),
+		Endpoint:        os.Getenv("STORAGETEST_GCS_ENDPOINT"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Run(t, store)
+}
@@ -0,0 +1,16 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/storage/storagetest"
+)
+
+func TestLocal(t *testing.T) {
+	store, err := storage.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Run(t, store)
+}
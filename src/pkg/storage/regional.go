@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+)
+
+// ErrNoResidentBucket is returned for objects of a residency region that
+// has no bucket of its own, which may not fall back to the default one.
+var ErrNoResidentBucket = errors.New("no bucket for residency region")
+
+// Regional keeps the objects of each residency region in that region's
+// bucket, chosen by the region their key starts with as ResidentKey makes
+// them. Other objects go in the default bucket.
+type Regional struct {
+	policy   residency.Policy
+	backends map[string]Storage
+}
+
+// NewRegional opens the default bucket and those of policy's regions with
+// open.
+func NewRegional(policy residency.Policy, open func(bucket string) (Storage, error)) (*Regional, error) {
+	regional := &Regional{policy: policy, backends: make(map[string]Storage)}
+	buckets := []string{policy.Default}
+	for _, bucket := range policy.Buckets {
+		buckets = append(buckets, bucket)
+	}
+	for _, bucket := range buckets {
+		if _, ok := regional.backends[bucket]; ok {
+			continue
+		}
+		backend, err := open(bucket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open bucket %q: %w", bucket, err)
+		}
+		regional.backends[bucket] = backend
+	}
+	return regional, nil
+}
+
+// ResidentKey returns key placed in region's bucket when store keeps
+// regions apart, and key unchanged otherwise, so objects stored before
+// that was turned on stay where they are.
+func ResidentKey(store Storage, region residency.Region, key string) string {
+	if _, ok := store.(*Regional); !ok || !residency.Known(region) {
+		return key
+	}
+	return string(region) + "/" + key
+}
+
+func (r *Regional) backend(key string) (Storage, error) {
+	prefix, _, _ := strings.Cut(key, "/")
+	region := residency.Region(prefix)
+	if !residency.Known(region) {
+		return r.backends[r.policy.Default], nil
+	}
+	bucket, ok := r.policy.Buckets[region]
+	if !ok {
+		if r.policy.Strict(region) {
+			return nil, fmt.Errorf("%w %s", ErrNoResidentBucket, region)
+		}
+		bucket = r.policy.BucketFor(region)
+	}
+	return r.backends[bucket], nil
+}
+
+func (r *Regional) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	backend, err := r.backend(key)
+	if err != nil {
+		return err
+	}
+	return backend.Put(ctx, key, body, size, contentType)
+}
+
+func (r *Regional) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	backend, err := r.backend(key)
+	if err != nil {
+		return nil, err
+	}
+	return backend.Open(ctx, key)
+}
+
+func (r *Regional) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	backend, err := r.backend(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return backend.Stat(ctx, key)
+}
+
+func (r *Regional) Delete(ctx context.Context, key string) error {
+	backend, err := r.backend(key)
+	if err != nil {
+		return err
+	}
+	return backend.Delete(ctx, key)
+}
+
+func (r *Regional) PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (*PresignedRequest, error) {
+	backend, err := r.backend(key)
+	if err != nil {
+		return nil, err
+	}
+	return backend.PresignPut(ctx, key, contentType, expiry)
+}
+
+func (r *Regional) PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	backend, err := r.backend(key)
+	if err != nil {
+		return nil, err
+	}
+	return backend.PresignGet(ctx, key, expiry)
+}
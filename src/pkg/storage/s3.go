@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config locates a bucket on AWS S3 or any S3-compatible service such as
+// MinIO.
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+
+	// Transport, when set, makes the requests to the service, as to
+	// refuse addresses a bucket named by a user must not resolve to.
+	Transport http.RoundTripper
+}
+
+// S3 stores objects in an S3-compatible bucket. Clients can upload and
+// download directly with presigned URLs.
+type S3 struct {
+	client *minio.Client
+	bucket string
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:    cfg.UseSSL,
+		Region:    cfg.Region,
+		Transport: cfg.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &S3{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, body, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to upload object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	// GetObject is lazy; stat first so a missing object fails here.
+	if _, err := s.Stat(ctx, key); err != nil {
+		return nil, err
+	}
+	object, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	return object, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == minio.NoSuchKey {
+			return ObjectInfo{}, ErrNotFound
+		}
+		return ObjectInfo{}, fmt.Errorf("failed to stat object: %w", err)
+	}
+	return ObjectInfo{Size: info.Size, ContentType: info.ContentType}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+	return nil
+}
+
+// PresignPut signs the content type into the URL, so the client must upload
+// with the type it declared.
+func (s *S3) PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (*PresignedRequest, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	u, err := s.client.PresignHeader(ctx, http.MethodPut, s.bucket, key, expiry, nil, headers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %w", err)
+	}
+	return &PresignedRequest{
+		Method:    http.MethodPut,
+		URL:       u.String(),
+		Headers:   map[string]string{"Content-Type": contentType},
+		ExpiresAt: time.Now().Add(expiry),
+	}, nil
+}
+
+func (s *S3) PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error) {
+	u, err := s.client.PresignedGetObject(ctx, s.bucket, key, expiry, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign download: %w", err)
+	}
+	return &PresignedRequest{Method: http.MethodGet, URL: u.String(), ExpiresAt: time.Now().Add(expiry)}, nil
+}
@@ -0,0 +1,38 @@
+package storage_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/storage/storagetest"
+)
+
+// TestS3 runs against the bucket named by STORAGETEST_S3_BUCKET, on the
+// MinIO of compose.yaml unless STORAGETEST_S3_ENDPOINT says otherwise. It
+// is skipped when no bucket is named.
+func TestS3(t *testing.T) {
+	bucket := os.Getenv("STORAGETEST_S3_BUCKET")
+	if bucket == "" {
+		t.Skip("STORAGETEST_S3_BUCKET is not set")
+	}
+	store, err := storage.NewS3(storage.S3Config{
+		Endpoint:  envOr("STORAGETEST_S3_ENDPOINT", "localhost:9000"),
+		Region:    envOr("STORAGETEST_S3_REGION", "us-east-1"),
+		Bucket:    bucket,
+		AccessKey: envOr("STORAGETEST_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("STORAGETEST_S3_SECRET_KEY", "minioadmin"),
+		UseSSL:    os.Getenv("STORAGETEST_S3_USE_SSL") == "true",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storagetest.Run(t, store)
+}
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("object not found")
+
+	// ErrPresignUnsupported is returned by backends that cannot hand out
+	// direct upload or download URLs; clients upload through the API instead.
+	ErrPresignUnsupported = errors.New("storage backend does not support presigned URLs")
+)
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// PresignedRequest is a URL a client may use directly against the backend
+// until ExpiresAt, sending Headers with the request.
+type PresignedRequest struct {
+	Method    string            `json:"method"`
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Storage stores attachment bytes under opaque keys.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Delete(ctx context.Context, key string) error
+
+	PresignPut(ctx context.Context, key, contentType string, expiry time.Duration) (*PresignedRequest, error)
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (*PresignedRequest, error)
+}
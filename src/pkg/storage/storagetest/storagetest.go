@@ -0,0 +1,132 @@
+// Package storagetest is the conformance suite every storage backend must
+// pass, so the rest of the server can treat them alike.
+package storagetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/google/uuid"
+)
+
+// Run checks store against the contract of storage.Storage. Objects are
+// written under a prefix of their own and deleted when the test ends, so
+// it may run against a shared bucket.
+func Run(t *testing.T, store storage.Storage) {
+	prefix := "storagetest/" + uuid.NewString() + "/"
+	ctx := context.Background()
+
+	put := func(t *testing.T, key string, body []byte, contentType string) {
+		t.Helper()
+		if err := store.Put(ctx, key, bytes.NewReader(body), int64(len(body)), contentType); err != nil {
+			t.Fatalf("Put(%q) failed: %v", key, err)
+		}
+		t.Cleanup(func() { store.Delete(ctx, key) })
+	}
+	read := func(t *testing.T, key string) []byte {
+		t.Helper()
+		body, err := store.Open(ctx, key)
+		if err != nil {
+			t.Fatalf("Open(%q) failed: %v", key, err)
+		}
+		defer body.Close()
+		content, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("reading %q failed: %v", key, err)
+		}
+		return content
+	}
+
+	t.Run("missing objects are ErrNotFound", func(t *testing.T) {
+		key := prefix + "missing"
+		if _, err := store.Stat(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Stat of a missing object returned %v, want ErrNotFound", err)
+		}
+		if _, err := store.Open(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Open of a missing object returned %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("objects read back as written", func(t *testing.T) {
+		key := prefix + "nested/path/object.bin"
+		content := []byte("attachment bytes \x00\xff")
+		put(t, key, content, "application/octet-stream")
+
+		info, err := store.Stat(ctx, key)
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Size != int64(len(content)) {
+			t.Errorf("Stat size is %d, want %d", info.Size, len(content))
+		}
+		// Backends that keep no metadata report no type.
+		if info.ContentType != "" && info.ContentType != "application/octet-stream" {
+			t.Errorf("Stat content type is %q, want application/octet-stream", info.ContentType)
+		}
+		if got := read(t, key); !bytes.Equal(got, content) {
+			t.Errorf("Open read %q, want %q", got, content)
+		}
+	})
+
+	t.Run("Put replaces an object", func(t *testing.T) {
+		key := prefix + "replaced"
+		put(t, key, []byte("first"), "text/plain")
+		put(t, key, []byte("second version"), "text/plain")
+		if got := read(t, key); string(got) != "second version" {
+			t.Errorf("Open read %q after replacing, want %q", got, "second version")
+		}
+	})
+
+	t.Run("Delete removes an object and tolerates a missing one", func(t *testing.T) {
+		key := prefix + "deleted"
+		put(t, key, []byte("gone soon"), "text/plain")
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+		if _, err := store.Stat(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("Stat after Delete returned %v, want ErrNotFound", err)
+		}
+		if err := store.Delete(ctx, key); err != nil {
+			t.Errorf("Delete of a missing object failed: %v", err)
+		}
+	})
+
+	t.Run("presigned requests are usable or unsupported", func(t *testing.T) {
+		key := prefix + "presigned"
+		upload, putErr := store.PresignPut(ctx, key, "image/png", time.Minute)
+		download, getErr := store.PresignGet(ctx, key, time.Minute)
+		if errors.Is(putErr, storage.ErrPresignUnsupported) || errors.Is(getErr, storage.ErrPresignUnsupported) {
+			if !errors.Is(putErr, storage.ErrPresignUnsupported) || !errors.Is(getErr, storage.ErrPresignUnsupported) {
+				t.Fatalf("backend supports one kind of presigned URL only: put %v, get %v", putErr, getErr)
+			}
+			return
+		}
+		if putErr != nil || getErr != nil {
+			t.Fatalf("presigning failed: put %v, get %v", putErr, getErr)
+		}
+		checkPresigned(t, upload, "PUT")
+		checkPresigned(t, download, "GET")
+		if upload.Headers["Content-Type"] != "image/png" {
+			t.Errorf("presigned upload headers are %v, want the declared Content-Type", upload.Headers)
+		}
+	})
+}
+
+func checkPresigned(t *testing.T, request *storage.PresignedRequest, method string) {
+	t.Helper()
+	if request.Method != method {
+		t.Errorf("presigned method is %q, want %q", request.Method, method)
+	}
+	if u, err := url.Parse(request.URL); err != nil || !u.IsAbs() {
+		t.Errorf("presigned URL %q is not absolute", request.URL)
+	}
+	if !request.ExpiresAt.After(time.Now()) {
+		t.Errorf("presigned %s expires at %s, in the past", method, request.ExpiresAt)
+	}
+}
@@ -0,0 +1,79 @@
+// Package strikes decides when the warnings moderators issue an account
+// add up to a suspension. Each warning is a strike that counts for a while
+// and then expires; reaching a step's number of strikes still counting
+// suspends the account for that step's time.
+package strikes
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Step suspends an account for Suspension when it reaches Strikes.
+type Step struct {
+	Strikes    int
+	Suspension time.Duration
+}
+
+// Policy is how long strikes count and the steps they escalate by, in
+// ascending order of strikes.
+type Policy struct {
+	TTL   time.Duration
+	Steps []Step
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		TTL: 90 * 24 * time.Hour,
+		Steps: []Step{
+			{Strikes: 3, Suspension: 24 * time.Hour},
+			{Strikes: 5, Suspension: 7 * 24 * time.Hour},
+		},
+	}
+}
+
+// Escalation returns the suspension an account earns on reaching active
+// strikes, if reaching it takes a step.
+func (p Policy) Escalation(active int) (time.Duration, bool) {
+	for _, step := range p.Steps {
+		if step.Strikes == active {
+			return step.Suspension, true
+		}
+	}
+	return 0, false
+}
+
+// ParseSteps reads steps such as "3=24h,5=168h", each a number of strikes
+// and the suspension reaching it earns. An empty string has no steps, so
+// strikes never escalate.
+func ParseSteps(s string) ([]Step, error) {
+	var steps []Step
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		count, suspension, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("step %q must be strikes=duration", item)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(count))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("step %q must start with a number of strikes of at least 1", item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(suspension))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("step %q must end with a duration such as 24h", item)
+		}
+		if slices.ContainsFunc(steps, func(step Step) bool { return step.Strikes == n }) {
+			return nil, errors.New("each number of strikes may have one step")
+		}
+		steps = append(steps, Step{Strikes: n, Suspension: d})
+	}
+	slices.SortFunc(steps, func(a, b Step) int { return a.Strikes - b.Strikes })
+	return steps, nil
+}
@@ -0,0 +1,83 @@
+// Package suggest proposes short replies to incoming direct messages, for
+// clients to offer as one-tap answers.
+package suggest
+
+import (
+	"context"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxSuggestions is how many replies are offered for a message.
+	MaxSuggestions = 3
+
+	// maxRunes keeps suggestions short enough to fit on a chip.
+	maxRunes = 40
+)
+
+// Provider suggests replies to a message. It is given only the message's
+// text: never who sent it, who received it or the conversation around it.
+// It may return any number of suggestions; Clean picks those offered.
+type Provider interface {
+	Suggest(ctx context.Context, text string) ([]string, error)
+}
+
+// Clean trims suggestions and drops empty, overlong and repeated ones,
+// keeping at most MaxSuggestions in order.
+func Clean(suggestions []string) []string {
+	seen := make(map[string]bool, len(suggestions))
+	cleaned := make([]string, 0, MaxSuggestions)
+	for _, suggestion := range suggestions {
+		suggestion = strings.TrimSpace(suggestion)
+		key := strings.ToLower(suggestion)
+		if suggestion == "" || utf8.RuneCountInString(suggestion) > maxRunes || seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, suggestion)
+		if len(cleaned) == MaxSuggestions {
+			break
+		}
+	}
+	return cleaned
+}
+
+// cannedRule offers replies to messages with a word starting with any of
+// its phrases. Phrases ending in a space must match whole words.
+type cannedRule struct {
+	phrases []string
+	replies []string
+}
+
+// cannedRules are tried in order; the first match wins.
+var cannedRules = []cannedRule{
+	{[]string{"thank", "thx ", "ngiyabonga", "enkosi", "asante"}, []string{"You're welcome!", "Anytime 🙂", "No problem"}},
+	{[]string{"sorry", "apologi"}, []string{"No worries", "It's okay", "Don't worry about it"}},
+	{[]string{"congrat", "well done"}, []string{"Thank you!", "Thanks so much 🙏", "Appreciate it"}},
+	{[]string{"how are you", "how's it going", "howzit", "unjani"}, []string{"I'm good, thanks!", "Doing well, and you?", "Not bad, you?"}},
+	{[]string{"good morning", "good evening", "hello", "hi ", "hey ", "sawubona", "molo ", "jambo"}, []string{"Hi!", "Hey 👋", "Hello, how are you?"}},
+}
+
+// Canned suggests replies from a fixed set of phrases, on the server
+// itself. Message text never leaves it.
+type Canned struct{}
+
+func (Canned) Suggest(_ context.Context, text string) ([]string, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+	normalized := " " + strings.Join(words, " ") + " "
+	for _, rule := range cannedRules {
+		for _, phrase := range rule.phrases {
+			if strings.Contains(normalized, " "+phrase) {
+				return rule.replies, nil
+			}
+		}
+	}
+	if strings.HasSuffix(strings.TrimSpace(text), "?") {
+		return []string{"Yes", "No", "Not sure"}, nil
+	}
+	return nil, nil
+}
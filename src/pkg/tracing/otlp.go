@@ -0,0 +1,243 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	otlpQueueSize     = 4096
+	otlpBatchSize     = 512
+	otlpFlushInterval = 5 * time.Second
+	otlpTimeout       = 10 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector over
+// OTLP/HTTP with JSON encoding. Spans that arrive while the queue is full
+// are dropped rather than slowing down the work they describe.
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	client      *http.Client
+
+	mu      sync.RWMutex
+	closed  bool
+	spans   chan *Span
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// NewOTLPExporter returns an exporter sending to the collector at
+// endpoint, such as http://localhost:4318, naming this service
+// serviceName. It sends nothing until Start.
+func NewOTLPExporter(endpoint, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		url:         strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: otlpTimeout},
+		spans:       make(chan *Span, otlpQueueSize),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start sends queued spans in the background until Shutdown.
+func (e *OTLPExporter) Start() {
+	go func() {
+		defer close(e.done)
+		ticker := time.NewTicker(otlpFlushInterval)
+		defer ticker.Stop()
+
+		batch := make([]*Span, 0, otlpBatchSize)
+		for {
+			select {
+			case span, ok := <-e.spans:
+				if !ok {
+					e.send(batch)
+					return
+				}
+				batch = append(batch, span)
+				if len(batch) < otlpBatchSize {
+					continue
+				}
+			case <-ticker.C:
+			}
+			e.send(batch)
+			batch = batch[:0]
+		}
+	}()
+}
+
+// ExportSpan queues an ended span. It never blocks.
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.spans <- span:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Shutdown sends the spans still queued, waiting until ctx is done.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	e.closed = true
+	close(e.spans)
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) send(batch []*Span) {
+	if dropped := e.dropped.Swap(0); dropped > 0 {
+		slog.Warn("Trace export queue is full; dropped spans", "spans", dropped)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		slog.Error("Failed to encode spans", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), otlpTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Failed to build trace export request", "error", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		slog.Warn("Failed to export spans", "spans", len(batch), "error", err)
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		slog.Warn("Trace collector refused spans", "spans", len(batch), "status", resp.StatusCode)
+	}
+}
+
+// The OTLP JSON encoding of an export request. IDs are hex, and 64-bit
+// integers are strings.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// OTLP status codes.
+const (
+	otlpStatusUnset = 0
+	otlpStatusError = 2
+)
+
+func (e *OTLPExporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		span.mu.Lock()
+		encoded := otlpSpan{
+			TraceID:           span.context.TraceID.String(),
+			SpanID:            span.context.SpanID.String(),
+			Name:              span.name,
+			Kind:              span.kind,
+			StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.attributes),
+			Status:            otlpStatus{Code: otlpStatusUnset},
+		}
+		if span.failed {
+			encoded.Status = otlpStatus{Code: otlpStatusError, Message: span.statusMessage}
+		}
+		span.mu.Unlock()
+		if span.parent != (SpanID{}) {
+			encoded.ParentSpanID = span.parent.String()
+		}
+		spans = append(spans, encoded)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes([]Attribute{String("service.name", e.serviceName)})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "afrochat"}, Spans: spans}},
+	}}}
+}
+
+func otlpAttributes(attributes []Attribute) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for _, attribute := range attributes {
+		var value map[string]any
+		switch v := attribute.Value.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, otlpAttribute{Key: attribute.Key, Value: value})
+	}
+	return encoded
+}
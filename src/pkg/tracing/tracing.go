@@ -0,0 +1,264 @@
+// Package tracing records spans in the OpenTelemetry model and passes
+// trace context between services in W3C traceparent headers. Spans are
+// exported over OTLP, so any OpenTelemetry collector can receive them.
+//
+// Tracing is off until SetDefault installs a tracer. Until then Start
+// returns nil spans, whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+
+	// Sampled spans are recorded; their descendants are too.
+	Sampled bool
+}
+
+// IsValid reports whether the context names a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the context as a W3C traceparent header.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header. Later versions of the
+// format are read as version 00, as the specification asks.
+func ParseTraceparent(header string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	var sc SpanContext
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// Kind is the role of a span, numbered as in OTLP.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+// Attribute is a key and a string, int64, float64 or bool value.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+func String(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Int(key string, value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Exporter receives spans as they end. It must not block.
+type Exporter interface {
+	ExportSpan(span *Span)
+}
+
+// Tracer samples and exports spans.
+type Tracer struct {
+	exporter Exporter
+
+	// threshold is compared with the low half of trace IDs, which are
+	// random, so a sampleRate fraction of traces falls below it.
+	threshold uint64
+	always    bool
+}
+
+// NewTracer returns a tracer recording a sampleRate fraction of the traces
+// started here. Traces continued from a caller follow the caller's
+// sampling decision.
+func NewTracer(exporter Exporter, sampleRate float64) *Tracer {
+	return &Tracer{
+		exporter:  exporter,
+		threshold: uint64(sampleRate * math.MaxUint64),
+		always:    sampleRate >= 1,
+	}
+}
+
+func (t *Tracer) sample(traceID TraceID) bool {
+	return t.always || binary.BigEndian.Uint64(traceID[8:]) < t.threshold
+}
+
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetDefault installs the tracer Start uses, turning tracing on.
+func SetDefault(t *Tracer) {
+	defaultTracer.Store(t)
+}
+
+// Span is one timed operation of a trace. A nil span records nothing.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  SpanID
+	name    string
+	kind    Kind
+	start   time.Time
+
+	mu            sync.Mutex
+	end           time.Time
+	attributes    []Attribute
+	failed        bool
+	statusMessage string
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// Start begins a span as a child of the span ctx carries, or of the
+// remote parent set with ContextWithRemoteParent, or else as the root of
+// a new trace. It returns a context carrying the span.
+func Start(ctx context.Context, name string, kind Kind, attributes ...Attribute) (context.Context, *Span) {
+	tracer := defaultTracer.Load()
+	if tracer == nil {
+		return ctx, nil
+	}
+
+	parent := SpanContextFromContext(ctx)
+	span := &Span{tracer: tracer, name: name, kind: kind, start: time.Now(), attributes: attributes}
+	if parent.IsValid() {
+		span.context = SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+		span.parent = parent.SpanID
+	} else {
+		span.context.TraceID = TraceID(randomBytes(16))
+		span.context.Sampled = tracer.sample(span.context.TraceID)
+	}
+	span.context.SpanID = SpanID(randomBytes(8))
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func randomBytes(n int) []byte {
+	buf := make([]byte, n)
+	// crypto/rand.Read does not fail on supported platforms.
+	_, _ = rand.Read(buf)
+	return buf
+}
+
+// SpanContextFromContext returns the context of the span ctx carries, or
+// of its remote parent, or an invalid context when it has neither.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span, ok := ctx.Value(spanKey{}).(*Span); ok {
+		return span.context
+	}
+	remote, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return remote
+}
+
+// ContextWithRemoteParent returns a context whose spans continue the trace
+// of parent, which was started elsewhere: in another service, or in a
+// request whose work carries on in the background.
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	if !parent.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// Context returns the span's identity, to pass to the work it leads to.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// SetAttributes adds attributes describing the operation.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.attributes = append(s.attributes, attributes...)
+	s.mu.Unlock()
+}
+
+// RecordError marks the operation failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Fail(err.Error())
+}
+
+// Fail marks the operation failed.
+func (s *Span) Fail(message string) {
+	if s == nil || !s.context.Sampled {
+		return
+	}
+	s.mu.Lock()
+	s.failed = true
+	s.statusMessage = message
+	s.mu.Unlock()
+}
+
+// End finishes the span and, if it is sampled, exports it. Later calls do
+// nothing.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	ended := !s.end.IsZero()
+	if !ended {
+		s.end = time.Now()
+	}
+	s.mu.Unlock()
+	if !ended && s.context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpan(s)
+	}
+}
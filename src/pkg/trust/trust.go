@@ -0,0 +1,190 @@
+package trust
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelNew Level = iota
+	LevelBasic
+	LevelMember
+	LevelTrusted
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelBasic:
+		return "basic"
+	case LevelMember:
+		return "member"
+	case LevelTrusted:
+		return "trusted"
+	}
+	return "new"
+}
+
+type Capability string
+
+const (
+	CapabilityPostLinks  Capability = "post_links"
+	CapabilityPostMedia  Capability = "post_media"
+	CapabilityCreateRoom Capability = "create_room"
+)
+
+var ErrInsufficientTrust = errors.New("account trust level too low")
+
+// Activity is the account history a level is computed from.
+type Activity struct {
+	CreatedAt     time.Time
+	MessagesSent  int
+	DaysActive    int
+	ReportsUpheld int
+	VerifiedEmail bool
+}
+
+// Requirement is what an account needs to reach a level.
+type Requirement struct {
+	MinAccountAge time.Duration
+	MinMessages   int
+	MinDaysActive int
+}
+
+// Policy holds configurable thresholds and the minimum level for each
+// capability.
+type Policy struct {
+	Levels       map[Level]Requirement
+	Capabilities map[Capability]Level
+	// An upheld report knocks an account down one level per report.
+	PenaltyPerReport int
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		Levels: map[Level]Requirement{
+			LevelBasic:   {MinAccountAge: 24 * time.Hour, MinMessages: 5},
+			LevelMember:  {MinAccountAge: 7 * 24 * time.Hour, MinMessages: 50, MinDaysActive: 3},
+			LevelTrusted: {MinAccountAge: 30 * 24 * time.Hour, MinMessages: 500, MinDaysActive: 15},
+		},
+		Capabilities: map[Capability]Level{
+			CapabilityPostLinks:  LevelBasic,
+			CapabilityPostMedia:  LevelBasic,
+			CapabilityCreateRoom: LevelMember,
+		},
+		PenaltyPerReport: 1,
+	}
+}
+
+// LevelFor computes an account's level at now. Unverified accounts never
+// rise above basic.
+func (p Policy) LevelFor(activity Activity, now time.Time) Level {
+	age := now.Sub(activity.CreatedAt)
+	level := LevelNew
+	for _, candidate := range []Level{LevelBasic, LevelMember, LevelTrusted} {
+		req, ok := p.Levels[candidate]
+		if !ok || age < req.MinAccountAge || activity.MessagesSent < req.MinMessages || activity.DaysActive < req.MinDaysActive {
+			break
+		}
+		level = candidate
+	}
+
+	if !activity.VerifiedEmail && level > LevelBasic {
+		level = LevelBasic
+	}
+	level -= Level(activity.ReportsUpheld * p.PenaltyPerReport)
+	if level < LevelNew {
+		level = LevelNew
+	}
+	return level
+}
+
+// Check is the shared capability gate used by handlers.
+func (p Policy) Check(activity Activity, capability Capability, now time.Time) error {
+	required, ok := p.Capabilities[capability]
+	if !ok {
+		return nil
+	}
+	if level := p.LevelFor(activity, now); level < required {
+		return fmt.Errorf("%w: %s requires %s, account is %s", ErrInsufficientTrust, capability, required, level)
+	}
+	return nil
+}
+
+// policy is the policy handlers enforce, as configured.
+var policy = DefaultPolicy()
+
+// SetPolicy replaces the policy handlers enforce. It must be called before
+// serving, since Current is not synchronized with it.
+func SetPolicy(p Policy) {
+	policy = p
+}
+
+// Current returns the policy handlers enforce.
+func Current() Policy {
+	return policy
+}
+
+// ParseLevel reads a level by its name.
+func ParseLevel(s string) (Level, error) {
+	for _, level := range []Level{LevelNew, LevelBasic, LevelMember, LevelTrusted} {
+		if strings.TrimSpace(s) == level.String() {
+			return level, nil
+		}
+	}
+	return LevelNew, fmt.Errorf("unknown trust level %q", s)
+}
+
+// ParseRequirement reads overrides of base such as
+// "account_age=72h,messages=20,days_active=2". Thresholds left out keep
+// their value in base.
+func ParseRequirement(s string, base Requirement) (Requirement, error) {
+	parsed := base
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(item, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "account_age":
+			age, err := time.ParseDuration(value)
+			if err != nil || age < 0 {
+				return base, errors.New("account_age must be a duration such as 72h")
+			}
+			parsed.MinAccountAge = age
+		case "messages", "days_active":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return base, fmt.Errorf("%s must be a whole number", key)
+			}
+			if key == "messages" {
+				parsed.MinMessages = n
+			} else {
+				parsed.MinDaysActive = n
+			}
+		default:
+			return base, fmt.Errorf("unknown trust threshold %q", key)
+		}
+	}
+	return parsed, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S`)
+
+// HasLink reports whether text contains a web link, which needs
+// CapabilityPostLinks.
+func HasLink(text string) bool {
+	return linkPattern.MatchString(text)
+}
+
+// LinkCount counts the web links in text.
+func LinkCount(text string) int {
+	return len(linkPattern.FindAllStringIndex(text, -1))
+}
@@ -0,0 +1,77 @@
+// Package ussd speaks the text protocol of USSD aggregators such as
+// Africa's Talking. Each callback of a session carries every input so far
+// joined by '*', so a menu is a path through those inputs, and the reply
+// starts with CON to wait for more input or END to close the session.
+package ussd
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxLength is how many characters fit on a USSD screen.
+	MaxLength = 182
+
+	// Back and Home, as inputs, go back a step and back to the top.
+	Back = "0"
+	Home = "00"
+)
+
+// Reply is a screen to show: a prompt for more input, or the last one of
+// the session.
+type Reply struct {
+	Text string
+	End  bool
+}
+
+// Continue shows lines and waits for input.
+func Continue(lines ...string) Reply {
+	return Reply{Text: strings.Join(lines, "\n")}
+}
+
+// End shows lines and closes the session.
+func End(lines ...string) Reply {
+	return Reply{Text: strings.Join(lines, "\n"), End: true}
+}
+
+// String is the reply as the aggregator expects it, cut to MaxLength.
+func (r Reply) String() string {
+	prefix := "CON "
+	if r.End {
+		prefix = "END "
+	}
+	return prefix + Truncate(r.Text, MaxLength)
+}
+
+// Inputs splits a callback's text into the session's inputs, following
+// Back and Home as it goes.
+func Inputs(text string) []string {
+	if text == "" {
+		return nil
+	}
+	var inputs []string
+	for _, input := range strings.Split(text, "*") {
+		switch input {
+		case Back:
+			if len(inputs) > 0 {
+				inputs = inputs[:len(inputs)-1]
+			}
+		case Home:
+			inputs = inputs[:0]
+		default:
+			inputs = append(inputs, input)
+		}
+	}
+	return inputs
+}
+
+// Truncate cuts text to at most n characters, ending in "..." when it had
+// to cut.
+func Truncate(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	runes := []rune(text)
+	return strings.TrimRight(string(runes[:n-3]), " ") + "..."
+}
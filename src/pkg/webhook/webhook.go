@@ -0,0 +1,129 @@
+// Package webhook signs the payloads of outgoing webhooks and sends them
+// only to public addresses.
+//
+// A payload is signed with the webhook's secret by HMAC-SHA256 over the
+// Unix time of sending, a dot and the body, and the signature travels in
+// the X-AfroChat-Signature header as "t=<time>,v1=<hex>". Receivers check
+// it with Verify, and refuse old timestamps to stop replays.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SignatureHeader carries a payload's signature.
+const SignatureHeader = "X-AfroChat-Signature"
+
+var (
+	ErrBadSignature = errors.New("webhook signature does not match")
+	ErrPrivateHost  = errors.New("webhook host is on a private network")
+)
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// Sign returns the signature header for body sent at.
+func Sign(secret string, at time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + mac(secret, timestamp, body)
+}
+
+// Verify checks a signature header against body, refusing signatures made
+// more than tolerance before or after now.
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || signature == "" {
+		return ErrBadSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > tolerance || age < -tolerance {
+		return ErrBadSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(mac(secret, timestamp, body))) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+func mac(secret, timestamp string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Matches reports whether text starts with one of the comma-separated
+// trigger words, ignoring case. Without trigger words everything matches.
+func Matches(text, triggerWords string) bool {
+	text = strings.ToLower(strings.TrimSpace(text))
+	hasWords := false
+	for _, word := range strings.Split(triggerWords, ",") {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		hasWords = true
+		if strings.HasPrefix(text, word) {
+			return true
+		}
+	}
+	return !hasWords
+}
+
+// NewClient returns an HTTP client for delivering webhooks. It refuses to
+// connect to loopback, private and link-local addresses wherever a host
+// name resolves, so webhooks cannot reach into the network they are sent
+// from, and does not follow redirects.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+				return ErrPrivateHost
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/services"
+)
+
+const reindexUsage = `usage: afrochat reindex
+
+Writes every message to the search cluster named by SEARCH_BACKEND, oldest
+first, creating its index if missing. Run it after switching to OpenSearch
+or pointing OPENSEARCH_INDEX at a new index; the server keeps the index up
+to date from then on. Running it again is safe.`
+
+// reindexBatch is how many messages are read and indexed at a time.
+const reindexBatch = 500
+
+// runReindex implements the reindex subcommand and returns the exit code.
+func runReindex(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, reindexUsage)
+		return 2
+	}
+	appConfig, err := config.LoadApplicationConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+	if appConfig.SearchBackend != config.SearchOpenSearch {
+		fmt.Fprintln(os.Stderr, "Postgres indexes messages as they are stored; set SEARCH_BACKEND=opensearch to reindex")
+		return 1
+	}
+	dbClient, err := services.CreateDatabaseClient(appConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize database: %v\n", err)
+		return 1
+	}
+	defer dbClient.Close()
+
+	// Interrupting stops after the current batch.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	engine, err := services.NewSearchEngine(ctx, appConfig, dbClient)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	messages := repositories.NewMessageRepository(dbClient.DB)
+	var after pagination.Cursor
+	indexed := 0
+	for {
+		batch, err := messages.ListAll(ctx, after, reindexBatch)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(batch) == 0 {
+			break
+		}
+		indexable := make([]models.Message, 0, len(batch))
+		for i := range batch {
+			if search.Indexable(&batch[i]) {
+				indexable = append(indexable, batch[i])
+			}
+		}
+		if err := engine.Put(ctx, indexable); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		indexed += len(indexable)
+		after = search.Cursor(&batch[len(batch)-1])
+		fmt.Printf("Indexed %d messages, through %s\n", indexed, after.Time.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("Done: %d messages indexed\n", indexed)
+	return 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+
+	"github.com/dfunani/AfroChat/backend/pkg/replay"
+)
+
+const replayUsage = `usage: afrochat replay [flags] TRACE TARGET
+
+Re-drives a trace recorded with TRACE_FILE against the instance at TARGET,
+such as https://staging.example.com, registering an account there for each
+recorded user. Never point it at production. The target's
+RATE_LIMIT_REGISTER, RATE_LIMIT_LOGIN and RATE_LIMIT_MESSAGES must be
+raised to let one machine stand in for every user in the trace.
+
+flags:`
+
+// runReplay implements the replay subcommand and returns the exit code.
+func runReplay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := flags.Float64("speed", 1, "how much faster than recorded to replay; 0 sends requests back to back")
+	password := flags.String("password", "replay-password", "password for the accounts the replay registers")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, replayUsage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 || *speed < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	target, err := url.Parse(flags.Arg(1))
+	if err != nil || target.Host == "" {
+		fmt.Fprintf(os.Stderr, "invalid target %q\n", flags.Arg(1))
+		return 2
+	}
+	entries, err := replay.ReadTrace(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Interrupting prints the report so far.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	fmt.Printf("Replaying %d entries against %s\n", len(entries), target)
+	report, err := replay.NewReplayer(target, *speed, *password).Run(ctx, entries)
+	if report != nil {
+		report.Write(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/abuse"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/google/uuid"
+)
+
+const (
+	// sendActivityWindow is how far back a sender's messages are counted
+	// for abuse scoring.
+	sendActivityWindow = time.Hour
+
+	// maxSendsTracked bounds the sends remembered per sender. Past it the
+	// scorers' thresholds are long crossed, so older sends add nothing.
+	maxSendsTracked = 200
+
+	// maxSendersTracked bounds the memory used by recentActivity.
+	maxSendersTracked = 50_000
+
+	// reportWindow is how far back reports against a sender are counted
+	// for abuse scoring.
+	reportWindow = 30 * 24 * time.Hour
+)
+
+// abuseEvaluator scores signups and sends, shadow restricting accounts
+// that look abusive. Without one nobody is restricted automatically.
+var abuseEvaluator *abuse.Evaluator
+
+// SetAbuseEvaluator sets the evaluator signups and sends are scored by.
+func SetAbuseEvaluator(evaluator *abuse.Evaluator) {
+	abuseEvaluator = evaluator
+}
+
+type trackedSend struct {
+	at             time.Time
+	conversationID uuid.UUID
+}
+
+// sendActivity remembers each sender's recent sends, so scoring one needs
+// no count over their message history. It is per instance, so a sender
+// spread over several instances is scored on what each sees.
+type sendActivity struct {
+	mu    sync.Mutex
+	sends map[uuid.UUID][]trackedSend
+}
+
+var recentActivity = &sendActivity{sends: make(map[uuid.UUID][]trackedSend)}
+
+// record notes a send to conversationID and returns how many messages the
+// sender sent within the window, and to how many conversations.
+func (a *sendActivity) record(senderID, conversationID uuid.UUID, now time.Time) (messages, targets int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.sends[senderID]; !ok && len(a.sends) >= maxSendersTracked {
+		for id, sends := range a.sends {
+			if now.Sub(sends[len(sends)-1].at) >= sendActivityWindow {
+				delete(a.sends, id)
+			}
+		}
+		if len(a.sends) >= maxSendersTracked {
+			a.sends = make(map[uuid.UUID][]trackedSend)
+		}
+	}
+
+	sends := a.sends[senderID]
+	kept := sends[:0]
+	for _, send := range sends {
+		if now.Sub(send.at) < sendActivityWindow {
+			kept = append(kept, send)
+		}
+	}
+	if len(kept) >= maxSendsTracked {
+		kept = kept[1:]
+	}
+	kept = append(kept, trackedSend{at: now, conversationID: conversationID})
+	a.sends[senderID] = kept
+
+	conversations := make(map[uuid.UUID]bool, len(kept))
+	for _, send := range kept {
+		conversations[send.conversationID] = true
+	}
+	return len(kept), len(conversations)
+}
+
+// screenSender reports whether a message senderID is sending should be
+// shadowed: delivered to the sender alone. Accounts already restricted
+// are, and otherwise the send is scored and the account restricted, pending
+// a moderator's review, if it looks abusive. Bots and staff are never
+// scored. Muted accounts cannot send at all, and get errMuted.
+func screenSender(ctx context.Context, dbConnection *database.DatabaseConnection, senderID, conversationID uuid.UUID, input messageInput) (bool, error) {
+	var sender models.User
+	err := dbConnection.DB.WithContext(ctx).
+		Select("id", "role", "account_type", "is_shadow_restricted", "muted_until", "created_at").
+		First(&sender, "id = ?", senderID).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load sender: %w", err)
+	}
+	if sender.MutedUntil != nil && time.Now().Before(*sender.MutedUntil) {
+		return false, errMuted
+	}
+	if sender.IsShadowRestricted {
+		return true, nil
+	}
+	if abuseEvaluator == nil || sender.AccountType == models.AccountTypeBot || sender.Role != models.RoleUser {
+		return false, nil
+	}
+
+	now := time.Now()
+	messages, targets := recentActivity.record(senderID, conversationID, now)
+	reports, err := repositories.NewReportRepository(dbConnection.DB).CountAgainst(ctx, senderID, now.Add(-reportWindow))
+	if err != nil {
+		return false, err
+	}
+	signals := abuse.Signals{
+		UserID:           senderID,
+		AccountAge:       now.Sub(sender.CreatedAt),
+		MessageText:      input.Text,
+		MessagesLastHour: messages,
+		DistinctTargets:  targets,
+		ReportsReceived:  int(reports),
+		LinkCount:        trust.LinkCount(input.Text),
+	}
+	return shadowRestrictIfAbusive(ctx, dbConnection, signals), nil
+}
+
+// screenSignup scores a newly registered account on its display name,
+// shadow restricting it pending a moderator's review if it looks abusive.
+func screenSignup(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) {
+	if abuseEvaluator == nil {
+		return
+	}
+	signals := abuse.Signals{
+		UserID:      user.ID,
+		MessageText: user.DisplayName,
+		LinkCount:   trust.LinkCount(user.DisplayName),
+	}
+	shadowRestrictIfAbusive(ctx, dbConnection, signals)
+}
+
+// shadowRestrictIfAbusive evaluates signals, recording a shadow
+// restriction without an actor when they cross the threshold, and reports
+// whether it did. Scorer errors are logged, the other scorers deciding.
+func shadowRestrictIfAbusive(ctx context.Context, dbConnection *database.DatabaseConnection, signals abuse.Signals) bool {
+	decision, err := abuseEvaluator.Evaluate(ctx, signals)
+	if err != nil {
+		slog.WarnContext(ctx, "Abuse scorer failed", "user_id", signals.UserID, "error", err)
+	}
+	if decision.Action != abuse.ActionShadowRestrict {
+		return false
+	}
+	entry := models.ModerationAction{
+		UserID: signals.UserID,
+		Action: models.ModerationShadowRestrict,
+		Reason: fmt.Sprintf("scored %.2f by %s", decision.Score, decision.Scorer),
+	}
+	if err := repositories.NewModerationRepository(dbConnection.DB).Apply(ctx, &entry); err != nil {
+		slog.ErrorContext(ctx, "Failed to shadow restrict user", "user_id", signals.UserID, "error", err)
+		return false
+	}
+	slog.InfoContext(ctx, "Shadow restricted user", "user_id", signals.UserID, "score", decision.Score, "scorer", decision.Scorer)
+	return true
+}
+
+// deliverShadowed sends an event about a shadowed message to its sender's
+// own devices alone, which show it as sent.
+func deliverShadowed(ctx context.Context, hub *realtime.Hub, eventType string, message *models.Message) {
+	event, err := realtime.NewEvent(eventType, message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode message", "event_type", eventType, "message_id", message.ID, "error", err)
+		return
+	}
+	hub.SendToUser(message.SenderID, event)
+}
+
+// hideShadowed shows the shadowed messages among messages to viewerID as
+// tombstones, unless viewerID sent them, so others see no content from a
+// shadow restricted account while sequence numbers stay gapless.
+func hideShadowed(messages []models.Message, viewerID uuid.UUID) {
+	for i := range messages {
+		hideShadowedMessage(&messages[i], viewerID)
+	}
+}
+
+func hideShadowedMessage(message *models.Message, viewerID uuid.UUID) {
+	if !message.Shadowed || message.SenderID == viewerID || message.DeletedAt.Valid {
+		return
+	}
+	message.Text = ""
+	message.Entities = nil
+	message.Payload = nil
+	message.Attachments = nil
+	message.EditedAt = nil
+	message.DeletedAt.Time = message.CreatedAt
+	message.DeletedAt.Valid = true
+}
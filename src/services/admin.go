@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AdminUserView exposes the account fields that are hidden from the user's
+// own API but that moderators need.
+type AdminUserView struct {
+	SelfProfile
+	Role               string     `json:"role"`
+	IsActive           bool       `json:"is_active"`
+	IsSuspended        bool       `json:"is_suspended"`
+	IsBanned           bool       `json:"is_banned"`
+	IsShadowRestricted bool       `json:"is_shadow_restricted"`
+	ShadowRestrictedBy *string    `json:"shadow_restricted_by"`
+	Residency          string     `json:"residency"`
+	SuspendedAt        *time.Time `json:"suspended_at"`
+	SuspendedUntil     *time.Time `json:"suspended_until"`
+	MutedUntil         *time.Time `json:"muted_until"`
+	BannedAt           *time.Time `json:"banned_at"`
+	LastSeenAt         *time.Time `json:"last_seen_at"`
+	LastActivityAt     *time.Time `json:"last_activity_at"`
+	DeletedAt          *time.Time `json:"deleted_at"`
+}
+
+func NewAdminUserView(user *models.User) AdminUserView {
+	view := AdminUserView{
+		SelfProfile:        NewSelfProfile(user),
+		Role:               user.Role,
+		IsActive:           user.IsActive,
+		IsSuspended:        user.IsSuspended,
+		IsBanned:           user.IsBanned,
+		IsShadowRestricted: user.IsShadowRestricted,
+		ShadowRestrictedBy: user.ShadowRestrictedBy,
+		Residency:          user.Residency,
+		SuspendedAt:        user.SuspendedAt,
+		SuspendedUntil:     user.SuspendedUntil,
+		MutedUntil:         user.MutedUntil,
+		BannedAt:           user.BannedAt,
+		LastSeenAt:         user.LastSeenAt,
+		LastActivityAt:     user.LastActivityAt,
+	}
+	if user.DeletedAt.Valid {
+		view.DeletedAt = &user.DeletedAt.Time
+	}
+	return view
+}
+
+// dossierListLimit caps each list in a dossier, newest first.
+const dossierListLimit = 50
+
+// AdminUserDossier is everything known about one user, as moderators see
+// it. Lists hold the newest dossierListLimit entries.
+type AdminUserDossier struct {
+	User               AdminUserView             `json:"user"`
+	BusinessProfile    *models.BusinessProfile   `json:"business_profile"`
+	LegalAcceptances   []LegalAcceptanceView     `json:"legal_acceptances"`
+	PendingReminders   int64                     `json:"pending_reminders"`
+	Sessions           []AdminSessionView        `json:"sessions"`
+	Devices            []DeviceTokenView         `json:"devices"`
+	ModerationActions  []models.ModerationAction `json:"moderation_actions"`
+	OwnedConversations []OwnedConversationView   `json:"owned_conversations"`
+	Storage            StorageUsage              `json:"storage"`
+}
+
+// LegalAcceptanceView is a legal document version the user accepted.
+type LegalAcceptanceView struct {
+	Kind       string    `json:"kind"`
+	Version    string    `json:"version"`
+	AcceptedAt time.Time `json:"accepted_at"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// AdminSessionView is a session of the user, ended ones included.
+type AdminSessionView struct {
+	models.Session
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// OwnedConversationView is a group or channel the user owns.
+type OwnedConversationView struct {
+	ID          uuid.UUID `json:"id"`
+	Kind        string    `json:"kind"`
+	Title       *string   `json:"title"`
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+	Members     int64     `json:"members"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StorageUsage is what the user stores: the uploaded attachments and
+// backups of theirs that are ready.
+type StorageUsage struct {
+	Attachments     int64 `json:"attachments"`
+	AttachmentBytes int64 `json:"attachment_bytes"`
+	Backups         int64 `json:"backups"`
+	BackupBytes     int64 `json:"backup_bytes"`
+}
+
+// GetAdminUserDossier aggregates everything known about the user named by
+// the :id parameter, including soft-deleted accounts, into a single
+// response for moderators.
+func GetAdminUserDossier(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		var user models.User
+		if err := dbConnection.DB.WithContext(ctx).Unscoped().First(&user, "id = ?", userID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, notFound("user not found")
+			}
+			slog.ErrorContext(ctx, "Failed to load user for dossier", "user_id", userID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+		dossier, err := loadDossier(ctx, dbConnection, &user)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load dossier", "user_id", userID, "error", err)
+			return nil, internalError("failed to load dossier")
+		}
+		legacy := gin.H{
+			"user":                dossier.User,
+			"business_profile":    dossier.BusinessProfile,
+			"legal_acceptances":   dossier.LegalAcceptances,
+			"pending_reminders":   dossier.PendingReminders,
+			"sessions":            dossier.Sessions,
+			"devices":             dossier.Devices,
+			"moderation_actions":  dossier.ModerationActions,
+			"owned_conversations": dossier.OwnedConversations,
+			"storage":             dossier.Storage,
+		}
+		return &Response{Data: dossier, Legacy: legacy}, nil
+	}
+}
+
+func loadDossier(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) (*AdminUserDossier, error) {
+	db := dbConnection.DB.WithContext(ctx)
+	dossier := &AdminUserDossier{User: NewAdminUserView(user)}
+
+	var profile models.BusinessProfile
+	if err := db.Where("user_id = ?", user.ID).Limit(1).Find(&profile).Error; err != nil {
+		return nil, fmt.Errorf("failed to load business profile: %w", err)
+	}
+	if profile.ID != uuid.Nil {
+		dossier.BusinessProfile = &profile
+	}
+
+	var acceptances []models.LegalAcceptance
+	if err := db.Preload("Document").Where("user_id = ?", user.ID).Order("accepted_at DESC").Find(&acceptances).Error; err != nil {
+		return nil, fmt.Errorf("failed to load legal acceptances: %w", err)
+	}
+	dossier.LegalAcceptances = make([]LegalAcceptanceView, 0, len(acceptances))
+	for _, acceptance := range acceptances {
+		dossier.LegalAcceptances = append(dossier.LegalAcceptances, LegalAcceptanceView{
+			Kind:       acceptance.Document.Kind,
+			Version:    acceptance.Document.Version,
+			AcceptedAt: acceptance.AcceptedAt,
+			IPAddress:  acceptance.IPAddress,
+		})
+	}
+
+	err := db.Model(&models.Reminder{}).
+		Where("user_id = ? AND delivered_at IS NULL AND cancelled_at IS NULL", user.ID).
+		Count(&dossier.PendingReminders).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to count reminders: %w", err)
+	}
+
+	var sessions []models.Session
+	if err := db.Where("user_id = ?", user.ID).Order("created_at DESC").Limit(dossierListLimit).Find(&sessions).Error; err != nil {
+		return nil, fmt.Errorf("failed to load sessions: %w", err)
+	}
+	dossier.Sessions = make([]AdminSessionView, 0, len(sessions))
+	for _, session := range sessions {
+		dossier.Sessions = append(dossier.Sessions, AdminSessionView{Session: session, RevokedAt: session.RevokedAt})
+	}
+
+	devices, err := repositories.NewNotificationRepository(dbConnection.DB).AllDevices(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	dossier.Devices = make([]DeviceTokenView, 0, len(devices))
+	for i := range devices {
+		dossier.Devices = append(dossier.Devices, newDeviceTokenView(&devices[i], now))
+	}
+
+	dossier.ModerationActions, err = repositories.NewModerationRepository(dbConnection.DB).List(ctx, user.ID, nil, dossierListLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	members := db.Model(&models.ConversationMember{}).
+		Select("COUNT(*)").
+		Where("conversation_members.conversation_id = conversations.id AND conversation_members.deleted_at IS NULL")
+	err = db.Model(&models.Conversation{}).
+		Select("conversations.id, conversations.kind, conversations.title, conversations.workspace_id, conversations.created_at, (?) AS members", members).
+		Joins("JOIN conversation_members owners ON owners.conversation_id = conversations.id").
+		Where("owners.user_id = ? AND owners.role = ? AND owners.deleted_at IS NULL", user.ID, models.MemberRoleOwner).
+		Order("conversations.created_at DESC").
+		Limit(dossierListLimit).
+		Scan(&dossier.OwnedConversations).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load owned conversations: %w", err)
+	}
+	if dossier.OwnedConversations == nil {
+		dossier.OwnedConversations = []OwnedConversationView{}
+	}
+
+	var attachments, backups struct {
+		Count int64
+		Bytes int64
+	}
+	err = db.Model(&models.Attachment{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size_bytes), 0) AS bytes").
+		Where("uploader_id = ? AND status = ?", user.ID, models.AttachmentReady).
+		Scan(&attachments).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum attachments: %w", err)
+	}
+	err = db.Model(&models.Backup{}).
+		Select("COUNT(*) AS count, COALESCE(SUM(size_bytes), 0) AS bytes").
+		Where("user_id = ? AND status = ?", user.ID, models.BackupReady).
+		Scan(&backups).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum backups: %w", err)
+	}
+	dossier.Storage = StorageUsage{
+		Attachments:     attachments.Count,
+		AttachmentBytes: attachments.Bytes,
+		Backups:         backups.Count,
+		BackupBytes:     backups.Bytes,
+	}
+	return dossier, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/agegate"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// isAgeGateError reports whether err is an 18+ room turning a user away.
+func isAgeGateError(err error) bool {
+	return errors.Is(err, agegate.ErrAdultOnly) || errors.Is(err, agegate.ErrUnknownAge)
+}
+
+// checkAgeGate checks that the current user may join the channel when it
+// is 18+. When they may not, it responds with 403 and returns false.
+// Members joining again are let through.
+func checkAgeGate(c *gin.Context, channels *repositories.ChannelRepository, channel *models.Channel) bool {
+	if !channel.AdultOnly {
+		return true
+	}
+	ctx := c.Request.Context()
+	user := CurrentUser(c)
+	err := agegate.CheckAdultRoom(user.DateOfBirth, time.Now())
+	if err == nil {
+		return true
+	}
+	if _, memberErr := channels.Member(ctx, channel.ConversationID, user.ID); memberErr == nil {
+		return true
+	} else if !errors.Is(memberErr, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to check channel membership", "channel_id", channel.ConversationID, "error", memberErr)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"status": "error",
+		"error":  err.Error(),
+	})
+	return false
+}
+
+// checkAdultMembers returns the age gate's error when any of userIDs not
+// yet in the 18+ channel could not join it themselves.
+func checkAdultMembers(ctx context.Context, dbConnection *database.DatabaseConnection, channel *models.Channel, userIDs []uuid.UUID) error {
+	if !channel.AdultOnly {
+		return nil
+	}
+	var users []models.User
+	err := dbConnection.DB.WithContext(ctx).
+		Select("id", "date_of_birth").
+		Where("id IN ?", userIDs).
+		Where("id NOT IN (?)", dbConnection.DB.Model(&models.ChannelMember{}).
+			Select("user_id").
+			Where("conversation_id = ?", channel.ConversationID)).
+		Find(&users).Error
+	if err != nil {
+		return fmt.Errorf("failed to check ages: %w", err)
+	}
+	now := time.Now()
+	for _, user := range users {
+		if err := agegate.CheckAdultRoom(user.DateOfBirth, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dfunani/AfroChat/backend/pkg/analytics"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// The features whose use is counted for product analytics. They name what
+// was done, never its content.
+const (
+	featureMessagesSent    = "messages_sent"
+	featureAttachmentsSent = "attachments_sent"
+	featureSearches        = "searches"
+	featureSignIns         = "sign_ins"
+)
+
+// usageSampler counts usage for product analytics. It is nil, counting
+// nothing, unless analytics are enabled.
+var usageSampler *analytics.Sampler
+
+// NewUsageSampler returns the sampler of anonymized usage for users who
+// opted in, or nil when analytics are disabled.
+func NewUsageSampler(appConfig *config.ApplicationConfig, dbConnection *database.DatabaseConnection) *analytics.Sampler {
+	if !appConfig.AnalyticsEnabled {
+		return nil
+	}
+	var sink analytics.Sink = analytics.LogSink{}
+	if appConfig.AnalyticsEndpoint != "" {
+		sink = analytics.NewHTTPSink(appConfig.AnalyticsEndpoint)
+	}
+	return analytics.New(analytics.Config{
+		HashKey:    appConfig.AnalyticsHashKey,
+		SampleRate: appConfig.AnalyticsSampleRate,
+		Interval:   appConfig.AnalyticsInterval,
+	}, sink, analyticsConsent(dbConnection))
+}
+
+// SetUsageSampler sets the sampler usage is counted with.
+func SetUsageSampler(sampler *analytics.Sampler) {
+	usageSampler = sampler
+}
+
+// analyticsConsent looks up whether a user opted in. Deleted users have
+// not.
+func analyticsConsent(dbConnection *database.DatabaseConnection) analytics.ConsentFunc {
+	return func(ctx context.Context, userID uuid.UUID) (bool, error) {
+		var user models.User
+		err := dbConnection.DB.WithContext(ctx).Select("analytics_opt_in").First(&user, "id = ?", userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return user.AnalyticsOptIn, nil
+	}
+}
+
+// recordUsage counts n uses of a feature by a user, if they are sampled
+// and opted in.
+func recordUsage(ctx context.Context, userID uuid.UUID, feature string, n int) {
+	usageSampler.Add(ctx, userID, feature, n)
+}
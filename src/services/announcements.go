@@ -0,0 +1,127 @@
+package services
+
+import (
+	"encoding/json"
+	"log/slog"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// eventAnnouncementAcked tells a room's owner and admins an announcement
+// was acknowledged.
+const eventAnnouncementAcked = "announcement.ack"
+
+// AnnouncementAckReport is who has and has not acknowledged an
+// announcement. Pending lists the members yet to, its sender aside.
+type AnnouncementAckReport struct {
+	MessageID    uuid.UUID                `json:"message_id"`
+	AckCount     int                      `json:"ack_count"`
+	MemberCount  int                      `json:"member_count"`
+	Acknowledged []models.AnnouncementAck `json:"acknowledged"`
+	Pending      []uuid.UUID              `json:"pending"`
+}
+
+type announcementAckedView struct {
+	MessageID      uuid.UUID `json:"message_id"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	UserID         uuid.UUID `json:"user_id"`
+	AckCount       int64     `json:"ack_count"`
+}
+
+// AcknowledgeAnnouncement records the current user acknowledging the
+// announcement named by the :id parameter, which must ask for it.
+// Acknowledging again keeps the first acknowledgment. The room's owner and
+// admins are told the new count.
+func AcknowledgeAnnouncement(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, conversation, apiErr := announcementMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var announcement content.Announcement
+		if err := json.Unmarshal(message.Payload, &announcement); err != nil || !announcement.RequireAck {
+			return nil, badRequest("announcement does not ask to be acknowledged")
+		}
+
+		ctx := c.Request.Context()
+		announcements := repositories.NewAnnouncementRepository(dbConnection.DB)
+		ack, err := announcements.Acknowledge(ctx, message.ID, CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to acknowledge announcement", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to acknowledge announcement")
+		}
+		count, err := announcements.AckCount(ctx, message.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count acknowledgments", "message_id", message.ID, "error", err)
+		} else {
+			view := announcementAckedView{MessageID: message.ID, ConversationID: conversation.ID, UserID: ack.UserID, AckCount: count}
+			if event, err := realtime.NewEvent(eventAnnouncementAcked, view); err == nil {
+				var moderators []uuid.UUID
+				for _, member := range conversation.Members {
+					if member.Role == models.MemberRoleOwner || member.Role == models.MemberRoleAdmin {
+						moderators = append(moderators, member.UserID)
+					}
+				}
+				hub.SendToUsers(moderators, event)
+			}
+		}
+		return &Response{Data: ack, Legacy: gin.H{"ack": ack}}, nil
+	}
+}
+
+// GetAnnouncementAcks reports who has acknowledged the announcement named
+// by the :id parameter and who has not. Only the room's owner and admins
+// may see it.
+func GetAnnouncementAcks(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, conversation, apiErr := announcementMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if !moderatesConversation(conversation, CurrentUserID(c)) {
+			return nil, forbidden("only the owner and admins can see acknowledgments")
+		}
+
+		acks, err := repositories.NewAnnouncementRepository(dbConnection.DB).Acks(c.Request.Context(), message.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list acknowledgments", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to list acknowledgments")
+		}
+		acked := make(map[uuid.UUID]bool, len(acks))
+		for _, ack := range acks {
+			acked[ack.UserID] = true
+		}
+		report := AnnouncementAckReport{
+			MessageID:    message.ID,
+			AckCount:     len(acks),
+			MemberCount:  len(conversation.Members),
+			Acknowledged: acks,
+			Pending:      []uuid.UUID{},
+		}
+		for _, member := range conversation.Members {
+			if !acked[member.UserID] && member.UserID != message.SenderID {
+				report.Pending = append(report.Pending, member.UserID)
+			}
+		}
+		return &Response{Data: report, Legacy: gin.H{"report": report}}, nil
+	}
+}
+
+// announcementMessage loads the announcement named by the :id parameter,
+// in a conversation the current user belongs to.
+func announcementMessage(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Message, *models.Conversation, *APIError) {
+	message, conversation, apiErr := memberMessage(c, dbConnection)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if message.Type != string(content.TypeAnnouncement) {
+		return nil, nil, badRequest("message is not an announcement")
+	}
+	return message, conversation, nil
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+const apiV2Key = "apiV2"
+
+// APIError is an error response. /api/v1 renders only the message, using
+// LegacyMessage when set; /api/v2 renders the whole envelope, with the ID
+// of the request to quote when reporting it.
+type APIError struct {
+	Status        int          `json:"-"`
+	Code          string       `json:"code"`
+	Message       string       `json:"message"`
+	Details       []FieldError `json:"details,omitempty"`
+	RequestID     string       `json:"request_id,omitempty"`
+	LegacyMessage string       `json:"-"`
+}
+
+func (e *APIError) v1Message() string {
+	if e.LegacyMessage != "" {
+		return e.LegacyMessage
+	}
+	return e.Message
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// FieldError describes one failed validation rule on a request field.
+type FieldError struct {
+	Field string `json:"field"`
+	Rule  string `json:"rule"`
+	Param string `json:"param,omitempty"`
+}
+
+// newAPIError builds an error with the code conventionally used for status.
+func newAPIError(status int, message string) *APIError {
+	switch status {
+	case http.StatusBadRequest:
+		return badRequest(message)
+	case http.StatusUnauthorized:
+		return unauthorized(message)
+	case http.StatusForbidden:
+		return forbidden(message)
+	case http.StatusNotFound:
+		return notFound(message)
+	case http.StatusConflict:
+		return conflict(message)
+	case http.StatusGone:
+		return gone(message)
+	case http.StatusTooManyRequests:
+		return tooManyRequests(message)
+	case http.StatusServiceUnavailable:
+		return serviceUnavailable(message)
+	}
+	return &APIError{Status: status, Code: "internal", Message: message}
+}
+
+func badRequest(message string) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: "invalid_request", Message: message}
+}
+
+// validationFailed reports a request body that broke the rules on its
+// fields, each listed in details.
+func validationFailed(details []FieldError) *APIError {
+	return &APIError{Status: http.StatusBadRequest, Code: "validation_failed", Message: "request validation failed", Details: details}
+}
+
+func unauthorized(message string) *APIError {
+	return &APIError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: message}
+}
+
+func forbidden(message string) *APIError {
+	return &APIError{Status: http.StatusForbidden, Code: "forbidden", Message: message}
+}
+
+func notFound(message string) *APIError {
+	return &APIError{Status: http.StatusNotFound, Code: "not_found", Message: message}
+}
+
+func conflict(message string) *APIError {
+	return &APIError{Status: http.StatusConflict, Code: "conflict", Message: message}
+}
+
+func gone(message string) *APIError {
+	return &APIError{Status: http.StatusGone, Code: "gone", Message: message}
+}
+
+func tooManyRequests(message string) *APIError {
+	return &APIError{Status: http.StatusTooManyRequests, Code: "rate_limited", Message: message}
+}
+
+func serviceUnavailable(message string) *APIError {
+	return &APIError{Status: http.StatusServiceUnavailable, Code: "unavailable", Message: message}
+}
+
+func internalError(message string) *APIError {
+	return &APIError{Status: http.StatusInternalServerError, Code: "internal", Message: message}
+}
+
+// Page describes where a paginated list continues.
+type Page struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Response is an endpoint's successful result. /api/v2 renders Data and
+// Page; /api/v1 renders Legacy next to "status": "success", keeping the
+// body v1 clients already parse.
+type Response struct {
+	Status int
+	Data   any
+	Page   *Page
+	Legacy gin.H
+}
+
+// Endpoint is a handler written once and served on both API versions
+// through the V1 and V2 adapters.
+type Endpoint func(c *gin.Context) (*Response, *APIError)
+
+// V1 serves an endpoint with the /api/v1 response shapes.
+func V1(endpoint Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, apiErr := endpoint(c)
+		if apiErr != nil {
+			c.JSON(apiErr.Status, gin.H{
+				"status": "error",
+				"error":  apiErr.v1Message(),
+			})
+			return
+		}
+		if response.Status == http.StatusNoContent {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		body := gin.H{"status": "success"}
+		for key, value := range response.Legacy {
+			body[key] = value
+		}
+		c.JSON(statusOrOK(response.Status), body)
+	}
+}
+
+// V2 serves an endpoint with the /api/v2 envelope: {"data", "page"} on
+// success and {"error": {"code", "message", "details", "request_id"}} on
+// failure.
+func V2(endpoint Endpoint) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, apiErr := endpoint(c)
+		if apiErr != nil {
+			c.JSON(apiErr.Status, v2Error(c, apiErr))
+			return
+		}
+		if response.Status == http.StatusNoContent {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		body := gin.H{"data": response.Data}
+		if response.Page != nil {
+			body["page"] = response.Page
+		}
+		c.JSON(statusOrOK(response.Status), body)
+	}
+}
+
+func statusOrOK(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+// V2Envelope marks requests in the /api/v2 group so shared middleware
+// reports errors in the v2 envelope.
+func V2Envelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(apiV2Key, true)
+		c.Next()
+	}
+}
+
+// abortWithError stops the request with err in the shape of the API
+// version being served.
+func abortWithError(c *gin.Context, err *APIError) {
+	if c.GetBool(apiV2Key) {
+		c.AbortWithStatusJSON(err.Status, v2Error(c, err))
+		return
+	}
+	c.AbortWithStatusJSON(err.Status, gin.H{
+		"status": "error",
+		"error":  err.v1Message(),
+	})
+}
+
+func v2Error(c *gin.Context, err *APIError) gin.H {
+	err.RequestID = logging.RequestID(c.Request.Context())
+	return gin.H{"error": err}
+}
+
+// RouteNotFound answers requests for routes that do not exist in the
+// shape of the API version asked for, rather than gin's plain text.
+func RouteNotFound(c *gin.Context) {
+	markV2Path(c)
+	abortWithError(c, notFound("route not found"))
+}
+
+// MethodNotAllowed answers requests for a route with a method it does not
+// serve.
+func MethodNotAllowed(c *gin.Context) {
+	markV2Path(c)
+	abortWithError(c, &APIError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed"})
+}
+
+// RecoverPanic logs a handler's panic with the request's ID and answers
+// 500 in the shape of the API version asked for.
+func RecoverPanic() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		slog.ErrorContext(c.Request.Context(), "Handler panicked", "panic", recovered, "stack", string(debug.Stack()))
+		markV2Path(c)
+		abortWithError(c, internalError("internal server error"))
+	})
+}
+
+// markV2Path marks requests under /api/v2 that no group has marked yet.
+func markV2Path(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/api/v2/") {
+		c.Set(apiV2Key, true)
+	}
+}
+
+// bindJSON binds and validates the request body into req. Validation
+// failures list each failed field by its JSON name.
+func bindJSON(c *gin.Context, req any) *APIError {
+	err := c.ShouldBindJSON(req)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return badRequest(err.Error())
+	}
+	details := make([]FieldError, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		details = append(details, FieldError{
+			Field: jsonFieldName(req, fieldErr.StructField()),
+			Rule:  fieldErr.Tag(),
+			Param: fieldErr.Param(),
+		})
+	}
+	apiErr := validationFailed(details)
+	apiErr.LegacyMessage = err.Error()
+	return apiErr
+}
+
+func jsonFieldName(req any, structField string) string {
+	t := reflect.TypeOf(req)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Struct {
+		if field, ok := t.FieldByName(structField); ok {
+			if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+				return name
+			}
+		}
+	}
+	return structField
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// AppealTokenTTL is how long an account refused sign-in has to file
+	// its appeal.
+	AppealTokenTTL = time.Hour
+
+	defaultAppealPage = 50
+	maxAppealPage     = 100
+)
+
+type appealRequest struct {
+	Token     string `json:"token" binding:"required"`
+	Statement string `json:"statement" binding:"required,max=4000"`
+}
+
+type appealDecisionRequest struct {
+	Decision string `json:"decision" binding:"required,oneof=granted denied"`
+	Note     string `json:"note" binding:"max=2000"`
+}
+
+// AppealReview is an appeal with the action it is against and the account
+// that filed it, for the moderator deciding it.
+type AppealReview struct {
+	Appeal models.Appeal           `json:"appeal"`
+	Action models.ModerationAction `json:"action"`
+	User   AdminUserView           `json:"user"`
+}
+
+// appealDetails adds to the response refusing user sign-in what they need
+// to appeal: a token for filing an appeal, or the status of the one they
+// filed. It adds nothing when nothing keeps them out that can be appealed.
+func appealDetails(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User, response gin.H) {
+	action, err := repositories.NewAppealRepository(dbConnection.DB).Appealable(ctx, user.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load appealable action", "user_id", user.ID, "error", err)
+		return
+	}
+	var appeal models.Appeal
+	err = dbConnection.DB.WithContext(ctx).Select("status").First(&appeal, "action_id = ?", action.ID).Error
+	if err == nil {
+		response["appeal_status"] = appeal.Status
+		return
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.ErrorContext(ctx, "Failed to load appeal", "user_id", user.ID, "error", err)
+		return
+	}
+	token, err := issueVerificationToken(ctx, dbConnection, user.ID, models.TokenAppeal, AppealTokenTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to issue appeal token", "user_id", user.ID, "error", err)
+		return
+	}
+	response["appeal_token"] = token
+}
+
+// FileAppeal appeals the suspension or ban keeping an account out, with
+// the token it was given when refused sign-in. Each action can be appealed
+// once.
+func FileAppeal(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req appealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "token and statement are required, and the statement may be at most 4000 characters",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var appeal models.Appeal
+	err := repositories.NewVerificationTokenRepository(dbConnection.DB).Consume(ctx, models.TokenAppeal, auth.HashVerificationToken(req.Token),
+		func(tx *gorm.DB, token *models.VerificationToken) error {
+			appeal = models.Appeal{UserID: token.UserID, Statement: req.Statement}
+			return repositories.NewAppealRepository(tx).File(ctx, &appeal)
+		})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "this action was already appealed",
+		})
+		return
+	}
+	if err != nil {
+		respondTokenError(c, err, "failed to file appeal")
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"appeal": appeal,
+	})
+}
+
+// ListAppeals returns a page of appeals with ?status=, pending by default,
+// oldest first.
+func ListAppeals(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		status := c.DefaultQuery("status", models.AppealPending)
+		switch status {
+		case models.AppealPending, models.AppealGranted, models.AppealDenied:
+		default:
+			return nil, badRequest("status must be pending, granted or denied")
+		}
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultAppealPage, maxAppealPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		appeals, err := repositories.NewAppealRepository(dbConnection.DB).List(c.Request.Context(), status, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list appeals", "error", err)
+			return nil, internalError("failed to list appeals")
+		}
+
+		page := &Page{}
+		if len(appeals) > limit {
+			appeals = appeals[:limit]
+			page.HasMore = true
+			last := appeals[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		legacy := gin.H{"appeals": appeals}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: appeals, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetAppeal returns the appeal named by the :id parameter with the action
+// it is against and the account that filed it.
+func GetAppeal(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid appeal id")
+		}
+		ctx := c.Request.Context()
+		appeal, err := repositories.NewAppealRepository(dbConnection.DB).Get(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("appeal not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load appeal", "appeal_id", id, "error", err)
+			return nil, internalError("failed to load appeal")
+		}
+		var user models.User
+		if err := dbConnection.DB.WithContext(ctx).Unscoped().First(&user, "id = ?", appeal.UserID).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load appellant", "appeal_id", id, "error", err)
+			return nil, internalError("failed to load appeal")
+		}
+		review := AppealReview{Appeal: *appeal, Action: appeal.Action, User: NewAdminUserView(&user)}
+		return &Response{Data: review, Legacy: gin.H{"appeal": review.Appeal, "action": review.Action, "user": review.User}}, nil
+	}
+}
+
+// DecideAppeal grants or denies the pending appeal named by the :id
+// parameter. Granting it lifts the suspension or ban if it still stands.
+// The account is told the outcome by email and in its inbox. Only admins
+// decide appeals against bans.
+func DecideAppeal(dbConnection *database.DatabaseConnection, hub *realtime.Hub, mail mailer.Mailer) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid appeal id")
+		}
+		var req appealDecisionRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		appeals := repositories.NewAppealRepository(dbConnection.DB)
+		pending, err := appeals.Get(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("appeal not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load appeal", "appeal_id", id, "error", err)
+			return nil, internalError("failed to load appeal")
+		}
+		if pending.Action.Action == models.ModerationBan && CurrentUser(c).Role != models.RoleAdmin {
+			return nil, forbidden("only admins can decide appeals against bans")
+		}
+
+		appeal, err := appeals.Decide(ctx, id, CurrentUserID(c), req.Decision == models.AppealGranted, req.Note)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, conflict("appeal was already decided")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to decide appeal", "appeal_id", id, "error", err)
+			return nil, internalError("failed to decide appeal")
+		}
+		if appeal.Status == models.AppealGranted {
+			invalidateProfile(ctx, appeal.UserID)
+		}
+		notifyAppealOutcome(ctx, dbConnection, hub, mail, appeal)
+		return &Response{Data: appeal, Legacy: gin.H{"appeal": appeal}}, nil
+	}
+}
+
+// notifyAppealOutcome tells an account how its appeal was decided, by
+// email, since it may not be able to sign in to see it, and in its inbox.
+// Failures are logged.
+func notifyAppealOutcome(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, mail mailer.Mailer, appeal *models.Appeal) {
+	title, body := "Your appeal was denied", "A moderator reviewed your appeal and the action against your account stands."
+	if appeal.Status == models.AppealGranted {
+		title, body = "Your appeal was granted", "A moderator reviewed your appeal and lifted the action against your account. You can sign in again."
+	}
+	if appeal.Note != "" {
+		body += "\n\nThe moderator's note: " + appeal.Note
+	}
+
+	var user models.User
+	err := dbConnection.DB.WithContext(ctx).Select("id", "email").First(&user, "id = ?", appeal.UserID).Error
+	if err == nil {
+		err = mail.Send(ctx, mailer.Message{To: user.Email, Subject: title, Body: body})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to email appeal outcome", "appeal_id", appeal.ID, "error", err)
+	}
+	notifyInbox(ctx, dbConnection, hub, models.InboxNotification{
+		UserID: appeal.UserID,
+		Kind:   models.InboxSystem,
+		Title:  title,
+		Body:   body,
+	})
+}
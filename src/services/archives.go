@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const JobArchiveDelivery = "archive_delivery"
+
+type setWorkspaceArchiveRequest struct {
+	URL string `json:"url" binding:"required"`
+}
+
+// WorkspaceArchiveView is a workspace's archive, with its signing secret
+// when it was just set. The secret is only ever returned then.
+type WorkspaceArchiveView struct {
+	models.WorkspaceArchive
+	Secret string `json:"secret,omitempty"`
+}
+
+// archiveDeliveryJob holds the message as it was when the event happened,
+// so the archive records every version of it.
+type archiveDeliveryJob struct {
+	WorkspaceID uuid.UUID       `json:"workspace_id"`
+	Event       string          `json:"event"`
+	Message     *models.Message `json:"message"`
+}
+
+// archiveEvent is the body delivered to a workspace's archive.
+type archiveEvent struct {
+	Event          string          `json:"event"`
+	WorkspaceID    uuid.UUID       `json:"workspace_id"`
+	ConversationID uuid.UUID       `json:"conversation_id"`
+	Message        *models.Message `json:"message"`
+}
+
+// workspaceOwnership loads the workspace named by the :slug parameter,
+// which the current user must own.
+func workspaceOwnership(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Workspace, *APIError) {
+	workspace, member, apiErr := workspaceMembership(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if member.Role != models.MemberRoleOwner {
+		return nil, forbidden("insufficient workspace role")
+	}
+	return workspace, nil
+}
+
+// GetWorkspaceArchive returns the archive of the workspace, without its
+// secret. Only the owner may see it.
+func GetWorkspaceArchive(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, apiErr := workspaceOwnership(c, webhooks.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		archive, err := repositories.NewWorkspaceRepository(webhooks.db.DB).Archive(c.Request.Context(), workspace.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("workspace is not archived")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load workspace archive", "workspace_id", workspace.ID, "error", err)
+			return nil, internalError("failed to load workspace archive")
+		}
+		return &Response{Data: archive, Legacy: gin.H{"archive": archive}}, nil
+	}
+}
+
+// SetWorkspaceArchive has copies of every message posted, edited or
+// deleted in the workspace's rooms sent to an archiving endpoint, and
+// flags the rooms so members are told. Its URL is scanned like a short
+// link's destination. Each call makes a new signing secret, returned only
+// in this response. Only the owner may set it.
+func SetWorkspaceArchive(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, apiErr := workspaceOwnership(c, webhooks.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req setWorkspaceArchiveRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		destination, err := webhooks.scanner.Scan(req.URL)
+		var unsafeErr *shortlink.UnsafeDestinationError
+		if errors.As(err, &unsafeErr) {
+			return nil, badRequest(unsafeErr.Reason)
+		}
+		if err != nil {
+			return nil, badRequest("invalid archive url")
+		}
+
+		ctx := c.Request.Context()
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate archive secret", "error", err)
+			return nil, internalError("failed to set workspace archive")
+		}
+		userID := CurrentUserID(c)
+		archive := models.WorkspaceArchive{
+			WorkspaceID: workspace.ID,
+			URL:         destination,
+			Secret:      secret,
+			CreatedByID: &userID,
+		}
+		if err := repositories.NewWorkspaceRepository(webhooks.db.DB).SetArchive(ctx, &archive); err != nil {
+			slog.ErrorContext(ctx, "Failed to set workspace archive", "workspace_id", workspace.ID, "error", err)
+			return nil, internalError("failed to set workspace archive")
+		}
+		view := WorkspaceArchiveView{WorkspaceArchive: archive, Secret: secret}
+		return &Response{Data: view, Legacy: gin.H{"archive": view}}, nil
+	}
+}
+
+// DeleteWorkspaceArchive stops archiving the workspace's rooms and clears
+// their flag. Messages already queued are dropped. Only the owner may.
+func DeleteWorkspaceArchive(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, apiErr := workspaceOwnership(c, webhooks.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err := repositories.NewWorkspaceRepository(webhooks.db.DB).DeleteArchive(c.Request.Context(), workspace.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("workspace is not archived")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete workspace archive", "workspace_id", workspace.ID, "error", err)
+			return nil, internalError("failed to delete workspace archive")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// queueArchive queues delivery of a message event to the archive of the
+// message's workspace when its conversation is archived.
+func queueArchive(ctx context.Context, dbConnection *database.DatabaseConnection, event string, message *models.Message) {
+	var workspaceIDs []uuid.UUID
+	err := dbConnection.DB.WithContext(ctx).Model(&models.Conversation{}).
+		Where("id = ? AND compliance_archived = ?", message.ConversationID, true).
+		Pluck("workspace_id", &workspaceIDs).Error
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check conversation archive", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	if len(workspaceIDs) == 0 {
+		return
+	}
+	job := archiveDeliveryJob{WorkspaceID: workspaceIDs[0], Event: event, Message: message}
+	if _, err := repositories.NewJobRepository(dbConnection.DB).Enqueue(ctx, JobArchiveDelivery, job); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue archive delivery", "workspace_id", job.WorkspaceID, "message_id", message.ID, "error", err)
+	}
+}
+
+// DeliverArchive is the job sending a message event to its workspace's
+// archive, signed with the archive's secret. Failures are retried as for
+// outgoing webhooks and recorded on the archive.
+func DeliverArchive(webhooks *Webhooks) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload archiveDeliveryJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		workspaces := repositories.NewWorkspaceRepository(webhooks.db.DB)
+		archive, err := workspaces.Archive(ctx, payload.WorkspaceID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(archiveEvent{
+			Event:          payload.Event,
+			WorkspaceID:    payload.WorkspaceID,
+			ConversationID: payload.Message.ConversationID,
+			Message:        payload.Message,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to encode archive event: %w", err)
+		}
+
+		retry, err := webhooks.post(ctx, archive.URL, archive.Secret, body)
+		if recordErr := workspaces.RecordArchiveDelivery(ctx, archive.WorkspaceID, err); recordErr != nil {
+			slog.ErrorContext(ctx, "Failed to record archive delivery", "workspace_id", archive.WorkspaceID, "error", recordErr)
+		}
+		if err != nil && !retry {
+			slog.WarnContext(ctx, "Archive refused delivery", "workspace_id", archive.WorkspaceID, "error", err)
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"log/slog"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auditchain"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+)
+
+// auditChainPage is how many messages are loaded at a time while a chain
+// is verified.
+const auditChainPage = 500
+
+// AuditVerification is the outcome of verifying an audit room's chain.
+type AuditVerification struct {
+	Valid bool `json:"valid"`
+
+	// Length is the number of messages the conversation recorded, and
+	// Checked how many were verified before the chain broke, if it did.
+	Length  int64  `json:"length"`
+	Checked int64  `json:"checked"`
+	Head    []byte `json:"head"`
+
+	Break *auditchain.Break `json:"break"`
+}
+
+// VerifyAuditChain checks the hash chain of an audit room the current user
+// belongs to, reporting the first message that was altered, removed or
+// reordered since it was sent.
+func VerifyAuditChain(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if !conversation.Audited {
+			return nil, badRequest("conversation is not an audit room")
+		}
+
+		// Messages sent while the chain is verified extend it past the
+		// length loaded with the conversation, so they are left out.
+		ctx := c.Request.Context()
+		messages := repositories.NewMessageRepository(dbConnection.DB)
+		verifier := auditchain.NewVerifier()
+		var broken *auditchain.Break
+		var after int64
+		for broken == nil {
+			page, err := messages.ListChain(ctx, conversation.ID, after, conversation.AuditLength, auditChainPage)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load audit chain", "conversation_id", conversation.ID, "error", err)
+				return nil, internalError("failed to verify audit chain")
+			}
+			for i := range page {
+				if broken, err = verifier.Check(&page[i]); err != nil {
+					slog.ErrorContext(ctx, "Failed to hash message", "message_id", page[i].ID, "error", err)
+					return nil, internalError("failed to verify audit chain")
+				}
+				if broken != nil {
+					break
+				}
+				after = *page[i].AuditSeq
+			}
+			if len(page) < auditChainPage {
+				break
+			}
+		}
+		if broken == nil {
+			broken = verifier.Finish(conversation.AuditLength, conversation.AuditHead)
+		}
+
+		result := AuditVerification{
+			Valid:   broken == nil,
+			Length:  conversation.AuditLength,
+			Checked: verifier.Checked(),
+			Head:    conversation.AuditHead,
+			Break:   broken,
+		}
+		return &Response{Data: result, Legacy: gin.H{"verification": result}}, nil
+	}
+}
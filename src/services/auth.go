@@ -0,0 +1,494 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/agegate"
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{3,50}$`)
+
+// dummyHash is compared against when a login email is unknown, so that
+// response timing does not reveal which emails have accounts.
+var dummyHash, dummySalt, _ = auth.HashPassword("afrochat-timing-equalizer")
+
+type registerRequest struct {
+	Email       string `json:"email" binding:"required,email,max=255"`
+	Username    string `json:"username" binding:"required"`
+	Password    string `json:"password" binding:"required,min=8,max=128"`
+	DisplayName string `json:"display_name" binding:"max=100"`
+	InviteCode  string `json:"invite_code" binding:"max=16"`
+
+	// ReferralCode credits the signup to the user whose code it is.
+	ReferralCode string `json:"referral_code" binding:"max=16"`
+
+	// DateOfBirth, as YYYY-MM-DD, is optional. Users who give none can
+	// register but not join 18+ rooms.
+	DateOfBirth string `json:"date_of_birth"`
+
+	// SkipWelcomeRooms opts out of joining the welcome rooms.
+	SkipWelcomeRooms bool `json:"skip_welcome_rooms"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type authResponse struct {
+	AccessToken      string      `json:"access_token"`
+	TokenType        string      `json:"token_type"`
+	ExpiresIn        int64       `json:"expires_in"`
+	ExpiresAt        time.Time   `json:"expires_at"`
+	RefreshToken     string      `json:"refresh_token"`
+	RefreshExpiresAt time.Time   `json:"refresh_expires_at"`
+	SessionID        uuid.UUID   `json:"session_id"`
+	User             SelfProfile `json:"user"`
+}
+
+// Register creates an account and signs it in. A link to verify the email
+// is sent in the background; the account works unverified meanwhile. The
+// account's country and time zone are guessed from the client's address
+// when geolocation is on, and the country sets the residency region its
+// data is kept in. The account joins the welcome rooms for everyone and
+// for its country unless the request opts out. A date of birth, when
+// given, must make the user old enough to sign up in that country, and a
+// referral code, when given, must be someone's.
+func Register(c *gin.Context, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager, mail mailer.Mailer, appConfig *config.ApplicationConfig, onboarding *Onboarding) {
+	var req registerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "email, username and a password of at least 8 characters are required",
+		})
+		return
+	}
+
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	req.Username = strings.TrimSpace(req.Username)
+	if !usernamePattern.MatchString(req.Username) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "username must be 3-50 letters, digits, '_' or '-'",
+		})
+		return
+	}
+	if strings.TrimSpace(req.DisplayName) == "" {
+		req.DisplayName = req.Username
+	}
+	inviteOnly := appConfig.RegistrationMode == config.RegistrationInviteOnly
+	if inviteOnly && strings.TrimSpace(req.InviteCode) == "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"error":  "registration is invite-only; an invite_code is required",
+		})
+		return
+	}
+
+	db := dbConnection.DB.WithContext(c.Request.Context())
+
+	var existing int64
+	if err := db.Unscoped().Model(&models.User{}).
+		Where("email = ? OR LOWER(username) = LOWER(?)", req.Email, req.Username).
+		Count(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to register user",
+		})
+		return
+	}
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "email or username is already taken",
+		})
+		return
+	}
+
+	hash, salt, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to register user",
+		})
+		return
+	}
+
+	now := time.Now()
+	user := models.User{
+		Email:        req.Email,
+		Username:     req.Username,
+		DisplayName:  strings.TrimSpace(req.DisplayName),
+		PasswordHash: hash,
+		Salt:         salt,
+		LastLoginAt:  &now,
+	}
+	location, located := onboarding.Locate(c.Request.Context(), c.ClientIP())
+	if located {
+		user.CountryCode = &location.CountryCode
+		user.Residency = string(residency.ForCountry(location.CountryCode))
+		if location.TimeZone != "" {
+			user.TimeZone = location.TimeZone
+		}
+	}
+	if req.DateOfBirth != "" {
+		dateOfBirth, err := time.Parse(time.DateOnly, req.DateOfBirth)
+		if err == nil {
+			err = agegate.CheckRegistration(&dateOfBirth, location.CountryCode, now)
+		}
+		if errors.Is(err, agegate.ErrTooYoung) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "date_of_birth must be a past date as YYYY-MM-DD",
+			})
+			return
+		}
+		user.DateOfBirth = &dateOfBirth
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if inviteOnly {
+			invite, err := RedeemInvite(tx, req.InviteCode)
+			if err != nil {
+				return err
+			}
+			user.InviteCodeID = &invite.ID
+		}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		if err := attributeReferral(c.Request.Context(), tx, req.ReferralCode, user.ID); err != nil {
+			return err
+		}
+		if req.SkipWelcomeRooms {
+			return nil
+		}
+		return joinWelcomeRooms(c.Request.Context(), tx, &user)
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidInvite) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, ErrInvalidReferral) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{
+				"status": "error",
+				"error":  "email or username is already taken",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to register user",
+		})
+		return
+	}
+	if located {
+		onboarding.Suggest(c.Request.Context(), user.ID, location)
+	}
+	screenSignup(c.Request.Context(), dbConnection, &user)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+		defer cancel()
+		if err := sendVerificationEmail(ctx, dbConnection, mail, appConfig, &user); err != nil {
+			slog.ErrorContext(ctx, "Failed to send verification email", "user_id", user.ID, "error", err)
+		}
+	}()
+
+	respondWithToken(c, http.StatusCreated, dbConnection, tokens, &user)
+}
+
+func Login(c *gin.Context, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager) {
+	var req loginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "email and password are required",
+		})
+		return
+	}
+
+	db := dbConnection.DB.WithContext(c.Request.Context())
+
+	var user models.User
+	err := db.Where("email = ?", strings.ToLower(strings.TrimSpace(req.Email))).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to log in",
+		})
+		return
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		auth.CheckPassword(req.Password, dummyHash, dummySalt)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "invalid email or password",
+		})
+		return
+	}
+
+	// Accounts created through an OAuth provider have no password until
+	// their owner sets one with a password reset.
+	if user.PasswordHash == "" {
+		auth.CheckPassword(req.Password, dummyHash, dummySalt)
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "invalid email or password",
+		})
+		return
+	}
+
+	if ok, _ := auth.CheckPassword(req.Password, user.PasswordHash, user.Salt); !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "invalid email or password",
+		})
+		return
+	}
+
+	if reason := accountBlockedReason(&user); reason != "" {
+		response := gin.H{
+			"status": "error",
+			"error":  reason,
+		}
+		appealDetails(c.Request.Context(), dbConnection, &user, response)
+		c.JSON(http.StatusForbidden, response)
+		return
+	}
+
+	now := time.Now()
+	user.LastLoginAt = &now
+	if err := db.Model(&user).Update("last_login_at", now).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to log in",
+		})
+		return
+	}
+
+	respondWithToken(c, http.StatusOK, dbConnection, tokens, &user)
+}
+
+// Refresh exchanges a refresh token for a new access token and a new
+// refresh token. Each refresh token works once; presenting one again revokes
+// its session, since only a stolen copy would be replayed.
+func Refresh(c *gin.Context, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "refresh_token is required",
+		})
+		return
+	}
+
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to refresh session",
+		})
+		return
+	}
+
+	sessions := repositories.NewSessionRepository(dbConnection.DB)
+	expiresAt := time.Now().Add(tokens.RefreshTTL())
+	session, err := sessions.Rotate(c.Request.Context(), auth.HashRefreshToken(req.RefreshToken), refreshHash, expiresAt, sessionDevice(c))
+	if err != nil {
+		status, message := http.StatusInternalServerError, "failed to refresh session"
+		switch {
+		case errors.Is(err, repositories.ErrTokenReused):
+			status, message = http.StatusUnauthorized, "refresh token was already used; the session has been revoked"
+		case errors.Is(err, repositories.ErrNotFound):
+			status, message = http.StatusUnauthorized, "invalid or expired refresh token"
+		}
+		c.JSON(status, gin.H{
+			"status": "error",
+			"error":  message,
+		})
+		return
+	}
+
+	var user models.User
+	if err := dbConnection.DB.WithContext(c.Request.Context()).First(&user, "id = ?", session.UserID).Error; err != nil {
+		status, message := http.StatusInternalServerError, "failed to refresh session"
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusUnauthorized, "invalid or expired refresh token"
+		}
+		c.JSON(status, gin.H{
+			"status": "error",
+			"error":  message,
+		})
+		return
+	}
+	if reason := accountBlockedReason(&user); reason != "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"error":  reason,
+		})
+		return
+	}
+
+	respondWithSession(c, http.StatusOK, dbConnection, tokens, &user, session, refreshToken)
+}
+
+// respondWithToken starts a session for a user who just signed in and
+// responds with its access and refresh tokens.
+func respondWithToken(c *gin.Context, status int, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager, user *models.User) {
+	session, refreshToken, err := startSession(c, dbConnection, user, tokens.RefreshTTL())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to issue token",
+		})
+		return
+	}
+
+	respondWithSession(c, status, dbConnection, tokens, user, session, refreshToken)
+}
+
+// startSession creates a session on the requesting device whose refresh
+// token expires after ttl unless used.
+func startSession(c *gin.Context, dbConnection *database.DatabaseConnection, user *models.User, ttl time.Duration) (*models.Session, string, error) {
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		SessionDevice:    sessionDevice(c),
+		LastUsedAt:       now,
+		ExpiresAt:        now.Add(ttl),
+	}
+	if err := repositories.NewSessionRepository(dbConnection.DB).Create(c.Request.Context(), &session); err != nil {
+		return nil, "", err
+	}
+	recordUsage(c.Request.Context(), user.ID, featureSignIns, 1)
+	return &session, refreshToken, nil
+}
+
+// tokenGrant is what a signed-in user's access tokens may do, in the
+// workspaces they belong to.
+func tokenGrant(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) (auth.Grant, error) {
+	workspaces, err := repositories.NewWorkspaceRepository(dbConnection.DB).IDsForUser(ctx, user.ID)
+	if err != nil {
+		return auth.Grant{}, err
+	}
+	staff := user.Role == models.RoleAdmin || user.Role == models.RoleModerator || user.Role == models.RoleLegal
+	return auth.Grant{
+		Role:       user.Role,
+		Tier:       string(userTier(user)),
+		Scopes:     auth.UserScopes(staff),
+		Workspaces: workspaces,
+	}, nil
+}
+
+func respondWithSession(c *gin.Context, status int, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager, user *models.User, session *models.Session, refreshToken string) {
+	recordIdentitySignals(c, dbConnection, user)
+	assignments := experimentAssignments(c.Request.Context(), dbConnection, user.ID)
+	grant, err := tokenGrant(c.Request.Context(), dbConnection, user)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load token grant", "user_id", user.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to issue token",
+		})
+		return
+	}
+	token, expiresAt, err := tokens.Issue(user.ID, session.ID, user.Username, grant, assignments)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to issue token",
+		})
+		return
+	}
+
+	c.JSON(status, authResponse{
+		AccessToken:      token,
+		TokenType:        "Bearer",
+		ExpiresIn:        int64(tokens.TTL().Seconds()),
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: session.ExpiresAt,
+		SessionID:        session.ID,
+		User:             NewSelfProfile(user),
+	})
+}
+
+// sessionDevice describes the device making the request.
+func sessionDevice(c *gin.Context) models.SessionDevice {
+	platform, version := clientIdentity(c)
+	device := models.SessionDevice{
+		UserAgent: truncate(c.Request.UserAgent(), 255),
+		IPAddress: c.ClientIP(),
+		Platform:  truncate(platform, 20),
+	}
+	if version != nil {
+		device.ClientVersion = version.String()
+	}
+	return device
+}
+
+// accountBlockedReason returns why a user may not authenticate, or "".
+func accountBlockedReason(user *models.User) string {
+	switch {
+	case user.IsBanned:
+		return "account is banned"
+	case user.IsSuspended && (user.SuspendedUntil == nil || time.Now().Before(*user.SuspendedUntil)):
+		return "account is suspended"
+	case !user.IsActive:
+		return "account is deactivated"
+	}
+	return ""
+}
+
+// truncate cuts s to at most n characters to fit a sized column.
+func truncate(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
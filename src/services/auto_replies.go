@@ -0,0 +1,270 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/autoreply"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxAutoReplyRules caps the auto-reply rules of one user.
+	maxAutoReplyRules = 50
+
+	// autoReplyCooldown is how long after answering a sender automatically
+	// a user's rules stay quiet towards them.
+	autoReplyCooldown = 10 * time.Minute
+
+	// autoReplyClientIDPrefix marks the client_id of automatic replies,
+	// which are never answered themselves.
+	autoReplyClientIDPrefix = "autoreply:"
+)
+
+// autoReplies remembers who was answered automatically, per instance.
+var autoReplies = autoreply.NewEngine(autoReplyCooldown)
+
+type autoReplyRuleRequest struct {
+	Kind     string `json:"kind" binding:"required,oneof=keyword schedule greeting"`
+	Keywords string `json:"keywords" binding:"max=1000"`
+	Reply    string `json:"reply" binding:"required,max=2000"`
+	Priority int    `json:"priority" binding:"min=-100,max=100"`
+	Enabled  *bool  `json:"enabled"`
+
+	// Schedule, as minutes after midnight in the owner's time zone, on the
+	// days set in the bitmask with Sunday as bit 0. Every day and all day
+	// when left out.
+	Days        *int `json:"days" binding:"omitempty,min=1,max=127"`
+	StartMinute int  `json:"start_minute" binding:"min=0,max=1439"`
+	EndMinute   *int `json:"end_minute" binding:"omitempty,min=0,max=1440"`
+}
+
+// ListAutoReplyRules returns the current user's auto-reply rules, highest
+// priority first.
+func ListAutoReplyRules(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		rules, err := repositories.NewAutoReplyRepository(dbConnection.DB).List(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list auto-reply rules", "error", err)
+			return nil, internalError("failed to list auto-reply rules")
+		}
+		return &Response{Data: rules, Legacy: gin.H{"rules": rules}}, nil
+	}
+}
+
+// CreateAutoReplyRule adds a rule answering direct messages to the current
+// user automatically: those containing one of its comma-separated
+// keywords, those arriving within its schedule, or the first message of a
+// conversation.
+func CreateAutoReplyRule(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req autoReplyRuleRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		rule := models.AutoReplyRule{OwnerID: CurrentUserID(c)}
+		if apiErr := req.apply(&rule); apiErr != nil {
+			return nil, apiErr
+		}
+
+		err := repositories.NewAutoReplyRepository(dbConnection.DB).Create(c.Request.Context(), &rule, maxAutoReplyRules)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("users may have at most " + strconv.Itoa(maxAutoReplyRules) + " auto-reply rules")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create auto-reply rule", "error", err)
+			return nil, internalError("failed to create auto-reply rule")
+		}
+		return &Response{Status: http.StatusCreated, Data: rule, Legacy: gin.H{"rule": rule}}, nil
+	}
+}
+
+// UpdateAutoReplyRule replaces the settings of the current user's rule
+// named by the :id parameter.
+func UpdateAutoReplyRule(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid auto-reply rule id")
+		}
+		var req autoReplyRuleRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		rules := repositories.NewAutoReplyRepository(dbConnection.DB)
+		rule, err := rules.Get(ctx, CurrentUserID(c), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("auto-reply rule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load auto-reply rule", "id", id, "error", err)
+			return nil, internalError("failed to update auto-reply rule")
+		}
+		if apiErr := req.apply(rule); apiErr != nil {
+			return nil, apiErr
+		}
+		if err := rules.Update(ctx, rule); err != nil {
+			slog.ErrorContext(ctx, "Failed to update auto-reply rule", "id", id, "error", err)
+			return nil, internalError("failed to update auto-reply rule")
+		}
+		return &Response{Data: rule, Legacy: gin.H{"rule": rule}}, nil
+	}
+}
+
+// DeleteAutoReplyRule removes the current user's rule named by the :id
+// parameter.
+func DeleteAutoReplyRule(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid auto-reply rule id")
+		}
+		err = repositories.NewAutoReplyRepository(dbConnection.DB).Delete(c.Request.Context(), CurrentUserID(c), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("auto-reply rule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete auto-reply rule", "id", id, "error", err)
+			return nil, internalError("failed to delete auto-reply rule")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// apply copies the request onto rule.
+func (req *autoReplyRuleRequest) apply(rule *models.AutoReplyRule) *APIError {
+	var keywords []string
+	for _, keyword := range strings.Split(req.Keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	reply := strings.TrimSpace(req.Reply)
+	days, endMinute := 127, 1440
+	if req.Days != nil {
+		days = *req.Days
+	}
+	if req.EndMinute != nil {
+		endMinute = *req.EndMinute
+	}
+	switch {
+	case reply == "":
+		return badRequest("reply cannot be blank")
+	case req.Kind == models.AutoReplyKeyword && len(keywords) == 0:
+		return badRequest("keywords must name at least one keyword")
+	case req.Kind == models.AutoReplySchedule && req.StartMinute == endMinute:
+		return badRequest("start_minute and end_minute cannot be the same")
+	}
+
+	rule.Kind = req.Kind
+	rule.Keywords = strings.Join(keywords, ", ")
+	rule.Reply = reply
+	rule.Priority = req.Priority
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	rule.Days = days
+	rule.StartMinute = req.StartMinute
+	rule.EndMinute = endMinute
+	return nil
+}
+
+// answerWithAutoReply answers a direct message with the recipient's
+// highest priority auto-reply rule matching it, or their business away
+// message when none does, posted as the recipient. Messages from bots and
+// automatic replies are never answered, and a sender is answered at most
+// once per autoReplyCooldown, so two accounts answering automatically
+// cannot keep each other going.
+func answerWithAutoReply(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, message *models.Message, memberIDs []uuid.UUID) {
+	if len(memberIDs) != 2 || message.Type == string(content.TypeEncrypted) {
+		return
+	}
+	recipientID := memberIDs[0]
+	if recipientID == message.SenderID {
+		recipientID = memberIDs[1]
+	}
+	rules, err := repositories.NewAutoReplyRepository(dbConnection.DB).Enabled(ctx, recipientID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load auto-reply rules", "user_id", recipientID, "error", err)
+		return
+	}
+	profile, err := repositories.NewBusinessRepository(dbConnection.DB).Profile(ctx, recipientID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to load business profile", "user_id", recipientID, "error", err)
+		return
+	}
+	if err == nil {
+		if away := awayRule(profile); away != nil {
+			rules = append(rules, *away)
+		}
+	}
+	if len(rules) == 0 {
+		return
+	}
+	conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, message.ConversationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	if conversation.Kind != models.ConversationDirect {
+		return
+	}
+
+	db := dbConnection.DB.WithContext(ctx)
+	var users []models.User
+	if err := db.Select("id", "account_type", "time_zone").Find(&users, "id IN ?", memberIDs).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation members", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	var earlier models.Message
+	err = db.Select("id").
+		Where("conversation_id = ? AND id <> ?", message.ConversationID, message.ID).
+		Take(&earlier).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		slog.ErrorContext(ctx, "Failed to check earlier messages", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+
+	inbound := autoreply.Inbound{
+		SenderID:     message.SenderID,
+		RecipientID:  recipientID,
+		Text:         message.Text,
+		FirstContact: err != nil,
+		IsAutoReply:  message.ClientID != nil && strings.HasPrefix(*message.ClientID, autoReplyClientIDPrefix),
+		ReceivedAt:   message.CreatedAt,
+	}
+	location := time.UTC
+	for _, user := range users {
+		switch user.ID {
+		case message.SenderID:
+			inbound.FromBot = user.AccountType == models.AccountTypeBot
+		case recipientID:
+			if zone, err := time.LoadLocation(user.TimeZone); err == nil {
+				location = zone
+			}
+		}
+	}
+	autoReplies.Prune(message.CreatedAt)
+	rule := autoReplies.Evaluate(rules, inbound, location)
+	if rule == nil {
+		return
+	}
+
+	input := messageInput{Text: rule.Reply, ClientID: autoReplyClientIDPrefix + message.ID.String()}
+	if _, _, err := postMessage(ctx, dbConnection, hub, notifier, suggester, index, recipientID, message.ConversationID, input); err != nil {
+		slog.ErrorContext(ctx, "Failed to post auto-reply", "rule_id", rule.ID, "error", err)
+	}
+}
@@ -0,0 +1,456 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/autoreply"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// JobAutomation is the job running a rule's action once its trigger
+	// fired.
+	JobAutomation = "automation"
+
+	// maxAutomationRules caps the rules of one channel.
+	maxAutomationRules = 20
+
+	// automationRunsKept is how much of each rule's execution log is kept.
+	automationRunsKept = 100
+
+	// maxAutomationErrorLength bounds the reason a failed run logs.
+	maxAutomationErrorLength = 500
+
+	// automationClientIDPrefix marks the client_id of messages posted by
+	// rules, which keeps a retried run from posting twice.
+	automationClientIDPrefix = "automation:"
+)
+
+type automationRuleRequest struct {
+	Name     string     `json:"name" binding:"required,max=100"`
+	Trigger  string     `json:"trigger" binding:"required,oneof=keyword member_joined"`
+	Keywords string     `json:"keywords" binding:"max=1000"`
+	Action   string     `json:"action" binding:"required,oneof=post_message add_label call_webhook"`
+	Enabled  *bool      `json:"enabled"`
+	BotID    *uuid.UUID `json:"bot_id"`
+	Text     string     `json:"text" binding:"max=2000"`
+	LabelID  *uuid.UUID `json:"label_id"`
+	URL      string     `json:"url"`
+}
+
+type updateAutomationRuleRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type automationJob struct {
+	RuleID    uuid.UUID  `json:"rule_id"`
+	MessageID *uuid.UUID `json:"message_id,omitempty"`
+	UserID    uuid.UUID  `json:"user_id"`
+}
+
+// automationEvent is the body a rule posts to its webhook.
+type automationEvent struct {
+	Event     string          `json:"event"`
+	RuleID    uuid.UUID       `json:"rule_id"`
+	ChannelID uuid.UUID       `json:"channel_id"`
+	UserID    uuid.UUID       `json:"user_id"`
+	Message   *models.Message `json:"message,omitempty"`
+}
+
+// AutomationRuleView is a rule, with the secret its webhook calls are
+// signed with when it was just created. The secret is only ever returned
+// then.
+type AutomationRuleView struct {
+	models.AutomationRule
+	Secret string `json:"secret,omitempty"`
+}
+
+// Automations runs channels' automation rules: when a message containing
+// one of a rule's keywords is posted or a member joins, its action posts
+// a message as a bot, labels the channel for its creator's business, or
+// calls a webhook, and the outcome goes into the rule's execution log.
+type Automations struct {
+	db       *database.DatabaseConnection
+	webhooks *Webhooks
+}
+
+func NewAutomations(dbConnection *database.DatabaseConnection, webhooks *Webhooks) *Automations {
+	return &Automations{db: dbConnection, webhooks: webhooks}
+}
+
+// CreateAutomationRule adds a rule to the current channel. Rules posting
+// messages do so as one of the current user's bots, which joins the
+// channel, and rules adding labels apply one of the current user's
+// business labels. The URL of a rule calling a webhook is scanned like a
+// short link's destination, and its signing secret is returned only in
+// this response.
+func CreateAutomationRule(automations *Automations) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req automationRuleRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		rule := models.AutomationRule{
+			ConversationID: CurrentChannel(c).ConversationID,
+			Name:           strings.TrimSpace(req.Name),
+			Trigger:        req.Trigger,
+			Action:         req.Action,
+			Enabled:        req.Enabled == nil || *req.Enabled,
+			CreatedByID:    &userID,
+		}
+		if req.Trigger == models.AutomationKeyword {
+			keywords, apiErr := automationKeywords(req.Keywords)
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			rule.Keywords = keywords
+		}
+
+		ctx := c.Request.Context()
+		var secret string
+		switch req.Action {
+		case models.AutomationPostMessage:
+			text := strings.TrimSpace(req.Text)
+			if req.BotID == nil || text == "" {
+				return nil, badRequest("rules posting messages need a bot_id and text")
+			}
+			bot, err := repositories.NewBotRepository(automations.db.DB).GetForOwner(ctx, userID, *req.BotID)
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, notFound("bot not found")
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load bot", "bot_id", *req.BotID, "error", err)
+				return nil, internalError("failed to create automation rule")
+			}
+			if err := repositories.NewChannelRepository(automations.db.DB).AddMembers(ctx, rule.ConversationID, []uuid.UUID{bot.UserID}); err != nil {
+				slog.ErrorContext(ctx, "Failed to add bot to channel", "channel_id", rule.ConversationID, "bot_id", bot.UserID, "error", err)
+				return nil, internalError("failed to create automation rule")
+			}
+			rule.BotID, rule.Text = &bot.UserID, text
+		case models.AutomationAddLabel:
+			if req.LabelID == nil {
+				return nil, badRequest("rules adding labels need a label_id")
+			}
+			profile, apiErr := currentBusiness(c, automations.db)
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			label, err := repositories.NewBusinessRepository(automations.db.DB).Label(ctx, profile.ID, *req.LabelID)
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, notFound("label not found")
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load label", "label_id", *req.LabelID, "error", err)
+				return nil, internalError("failed to create automation rule")
+			}
+			rule.LabelID = &label.ID
+		case models.AutomationCallWebhook:
+			destination, err := automations.webhooks.scanner.Scan(req.URL)
+			var unsafeErr *shortlink.UnsafeDestinationError
+			if errors.As(err, &unsafeErr) {
+				return nil, badRequest(unsafeErr.Reason)
+			}
+			if err != nil {
+				return nil, badRequest("invalid webhook url")
+			}
+			if secret, err = webhook.NewSecret(); err != nil {
+				slog.ErrorContext(ctx, "Failed to generate webhook secret", "error", err)
+				return nil, internalError("failed to create automation rule")
+			}
+			rule.URL, rule.Secret = destination, secret
+		}
+
+		err := repositories.NewAutomationRepository(automations.db.DB).Create(ctx, &rule, maxAutomationRules)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict(fmt.Sprintf("channels may have at most %d automation rules", maxAutomationRules))
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create automation rule", "channel_id", rule.ConversationID, "error", err)
+			return nil, internalError("failed to create automation rule")
+		}
+		view := AutomationRuleView{AutomationRule: rule, Secret: secret}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"rule": view}}, nil
+	}
+}
+
+// automationKeywords checks a keyword rule's keywords, separated by
+// commas, and returns them tidied.
+func automationKeywords(raw string) (string, *APIError) {
+	var keywords []string
+	for _, keyword := range strings.Split(raw, ",") {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" {
+			continue
+		}
+		if len(keyword) > maxTriggerWordLength {
+			return "", badRequest(fmt.Sprintf("keywords must be at most %d characters", maxTriggerWordLength))
+		}
+		keywords = append(keywords, keyword)
+	}
+	if len(keywords) == 0 || len(keywords) > maxTriggerWords {
+		return "", badRequest(fmt.Sprintf("keyword rules need 1-%d keywords", maxTriggerWords))
+	}
+	return strings.Join(keywords, ","), nil
+}
+
+// ListAutomationRules lists the current channel's automation rules.
+func ListAutomationRules(automations *Automations) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		channelID := CurrentChannel(c).ConversationID
+		rules, err := repositories.NewAutomationRepository(automations.db.DB).List(c.Request.Context(), channelID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list automation rules", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to list automation rules")
+		}
+		return &Response{Data: rules, Legacy: gin.H{"rules": rules}}, nil
+	}
+}
+
+// UpdateAutomationRule turns the current channel's rule named by the
+// :ruleId parameter on or off.
+func UpdateAutomationRule(automations *Automations) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("ruleId"))
+		if err != nil {
+			return nil, badRequest("invalid rule id")
+		}
+		var req updateAutomationRuleRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		channelID := CurrentChannel(c).ConversationID
+		rule, err := repositories.NewAutomationRepository(automations.db.DB).SetEnabled(c.Request.Context(), channelID, id, *req.Enabled)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("automation rule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to update automation rule", "id", id, "error", err)
+			return nil, internalError("failed to update automation rule")
+		}
+		return &Response{Data: rule, Legacy: gin.H{"rule": rule}}, nil
+	}
+}
+
+// DeleteAutomationRule removes the current channel's rule named by the
+// :ruleId parameter.
+func DeleteAutomationRule(automations *Automations) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("ruleId"))
+		if err != nil {
+			return nil, badRequest("invalid rule id")
+		}
+		err = repositories.NewAutomationRepository(automations.db.DB).Delete(c.Request.Context(), CurrentChannel(c).ConversationID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("automation rule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete automation rule", "id", id, "error", err)
+			return nil, internalError("failed to delete automation rule")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListAutomationRuns returns the execution log of the current channel's
+// rule named by the :ruleId parameter, newest first.
+func ListAutomationRuns(automations *Automations) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("ruleId"))
+		if err != nil {
+			return nil, badRequest("invalid rule id")
+		}
+		runs, err := repositories.NewAutomationRepository(automations.db.DB).Runs(c.Request.Context(), CurrentChannel(c).ConversationID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("automation rule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list automation runs", "id", id, "error", err)
+			return nil, internalError("failed to list automation runs")
+		}
+		return &Response{Data: runs, Legacy: gin.H{"runs": runs}}, nil
+	}
+}
+
+// queueKeywordAutomations queues the runs of the keyword rules of a new
+// message's channel that it contains a keyword of. Messages from bots set
+// off no rules, so rules posting messages cannot set each other off.
+func queueKeywordAutomations(ctx context.Context, dbConnection *database.DatabaseConnection, message *models.Message) {
+	if message.Type != string(content.TypeText) || message.Text == "" {
+		return
+	}
+	rules, err := repositories.NewAutomationRepository(dbConnection.DB).Triggered(ctx, message.ConversationID, models.AutomationKeyword)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load automation rules", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	var matched []models.AutomationRule
+	for _, rule := range rules {
+		if autoreply.ContainsKeyword(message.Text, rule.Keywords) {
+			matched = append(matched, rule)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	isBot, err := repositories.NewBotRepository(dbConnection.DB).IsBot(ctx, message.SenderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check message sender", "message_id", message.ID, "error", err)
+		return
+	}
+	if isBot {
+		return
+	}
+	jobs := repositories.NewJobRepository(dbConnection.DB)
+	for _, rule := range matched {
+		if _, err := jobs.Enqueue(ctx, JobAutomation, automationJob{RuleID: rule.ID, MessageID: &message.ID, UserID: message.SenderID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue automation", "rule_id", rule.ID, "message_id", message.ID, "error", err)
+		}
+	}
+}
+
+// queueJoinAutomations queues the runs of a channel's member-joined rules
+// for the users who just joined it. It takes the transaction they joined
+// in, when there is one, so the runs are only queued if they did.
+func queueJoinAutomations(ctx context.Context, db *gorm.DB, channelID uuid.UUID, userIDs []uuid.UUID) {
+	if len(userIDs) == 0 {
+		return
+	}
+	rules, err := repositories.NewAutomationRepository(db).Triggered(ctx, channelID, models.AutomationMemberJoined)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load automation rules", "channel_id", channelID, "error", err)
+		return
+	}
+	jobs := repositories.NewJobRepository(db)
+	for _, rule := range rules {
+		for _, userID := range userIDs {
+			if _, err := jobs.Enqueue(ctx, JobAutomation, automationJob{RuleID: rule.ID, UserID: userID}); err != nil {
+				slog.ErrorContext(ctx, "Failed to queue automation", "rule_id", rule.ID, "user_id", userID, "error", err)
+			}
+		}
+	}
+}
+
+// RunAutomation is the job running a rule's action and logging how it
+// went. Webhook calls that may succeed later are retried and only logged
+// once they succeed or run out of attempts. Rules since deleted or turned
+// off do nothing.
+func RunAutomation(automations *Automations, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload automationJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		automationRepo := repositories.NewAutomationRepository(automations.db.DB)
+		rule, err := automationRepo.Get(ctx, payload.RuleID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !rule.Enabled {
+			return nil
+		}
+
+		var retry bool
+		switch rule.Action {
+		case models.AutomationPostMessage:
+			retry, err = automations.postMessage(ctx, hub, notifier, suggester, index, rule, job, payload)
+		case models.AutomationAddLabel:
+			err = repositories.NewBusinessRepository(automations.db.DB).ApplyLabel(ctx, *rule.LabelID, rule.ConversationID)
+			retry = err != nil
+		case models.AutomationCallWebhook:
+			retry, err = automations.callWebhook(ctx, rule, payload)
+		default:
+			err = fmt.Errorf("unknown action %q", rule.Action)
+		}
+		if err != nil && retry && !finalAttempt(job) {
+			return err
+		}
+
+		run := models.AutomationRun{RuleID: rule.ID, MessageID: payload.MessageID, UserID: &payload.UserID, Status: models.AutomationRunSucceeded}
+		if err != nil {
+			reason := truncate(err.Error(), maxAutomationErrorLength)
+			run.Status, run.Error = models.AutomationRunFailed, &reason
+			slog.WarnContext(ctx, "Automation failed", "rule_id", rule.ID, "error", err)
+		}
+		return automationRepo.RecordRun(ctx, &run, automationRunsKept)
+	}
+}
+
+// postMessage posts the rule's text as its bot, reporting whether a
+// failure is worth retrying.
+func (a *Automations) postMessage(ctx context.Context, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, rule *models.AutomationRule, job *models.Job, payload automationJob) (bool, error) {
+	member, err := repositories.NewConversationRepository(a.db.DB).IsMember(ctx, rule.ConversationID, *rule.BotID)
+	if err != nil {
+		return true, err
+	}
+	if !member {
+		return false, errors.New("the rule's bot is no longer in the channel")
+	}
+	var user models.User
+	if err := a.db.DB.WithContext(ctx).Unscoped().First(&user, "id = ?", payload.UserID).Error; err != nil {
+		return true, fmt.Errorf("failed to load user: %w", err)
+	}
+	input := messageInput{
+		Text:     strings.ReplaceAll(rule.Text, "{name}", user.DisplayName),
+		ClientID: automationClientID(job.ID),
+	}
+	_, _, err = postMessage(ctx, a.db, hub, notifier, suggester, index, *rule.BotID, rule.ConversationID, input)
+	var answered *commandReply
+	if errors.Is(err, content.ErrInvalidContent) || errors.Is(err, errMuted) || errors.As(err, &answered) {
+		return false, err
+	}
+	return err != nil, err
+}
+
+// callWebhook posts the event that set the rule off to its webhook,
+// reporting whether a failure is worth retrying.
+func (a *Automations) callWebhook(ctx context.Context, rule *models.AutomationRule, payload automationJob) (bool, error) {
+	event := automationEvent{
+		Event:     "automation." + rule.Trigger,
+		RuleID:    rule.ID,
+		ChannelID: rule.ConversationID,
+		UserID:    payload.UserID,
+	}
+	if payload.MessageID != nil {
+		message, err := repositories.NewMessageRepository(a.db.DB).Get(ctx, *payload.MessageID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return false, errors.New("the message was deleted")
+		}
+		if err != nil {
+			return true, err
+		}
+		event.Message = message
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode automation event: %w", err)
+	}
+	return a.webhooks.post(ctx, rule.URL, rule.Secret, body)
+}
+
+// automationClientID is the client_id of the message a run posts.
+func automationClientID(jobID uuid.UUID) string {
+	sum := sha256.Sum256([]byte(jobID.String()))
+	return automationClientIDPrefix + hex.EncodeToString(sum[:24])
+}
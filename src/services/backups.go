@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BackupVersionsKept is how many completed backups each user keeps; older
+// ones are removed when a new one completes.
+const BackupVersionsKept = 3
+
+const backupContentType = "application/octet-stream"
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+type createBackupRequest struct {
+	SizeBytes  int64  `json:"size_bytes" binding:"required,gt=0"`
+	SHA256     string `json:"sha256" binding:"required"`
+	Scheme     string `json:"scheme" binding:"required,max=50"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+// CreateBackup starts uploading a new backup version from the current
+// device. The response carries the request to upload the ciphertext with:
+// a presigned URL, or PUT /backups/:id/content on backends without them.
+// The client then calls CompleteBackup.
+func CreateBackup(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createBackupRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !sha256Pattern.MatchString(req.SHA256) {
+			return nil, badRequest("sha256 must be the lowercase hex SHA-256 of the encrypted backup")
+		}
+
+		sessionID := currentSessionID(c)
+		if sessionID == uuid.Nil {
+			return nil, forbidden("backups can only be made from a signed-in device; sign in again")
+		}
+
+		ctx := c.Request.Context()
+		user := CurrentUser(c)
+		limits, err := userLimits(ctx, dbConnection, user.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load limits for user", "user_id", user.ID, "error", err)
+			return nil, internalError("failed to start backup")
+		}
+		if req.SizeBytes > limits.BackupBytes {
+			return nil, badRequest(fmt.Sprintf("backups on the %s tier may be at most %d bytes", userTier(user), limits.BackupBytes))
+		}
+
+		id := uuid.New()
+		backup := &models.Backup{
+			ID:         id,
+			UserID:     user.ID,
+			SessionID:  &sessionID,
+			DeviceName: req.DeviceName,
+			Scheme:     req.Scheme,
+			SizeBytes:  req.SizeBytes,
+			SHA256:     req.SHA256,
+			StorageKey: storage.ResidentKey(store, residency.Region(user.Residency), "backups/"+user.ID.String()+"/"+id.String()),
+			Status:     models.BackupPending,
+		}
+		stale, err := repositories.NewBackupRepository(dbConnection.DB).Create(ctx, backup)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("another backup was started at the same time; try again")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create backup", "error", err)
+			return nil, internalError("failed to start backup")
+		}
+		for _, b := range stale {
+			deleteObject(store, b.StorageKey)
+		}
+
+		upload, err := store.PresignPut(ctx, backup.StorageKey, backupContentType, presignExpiry)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			upload = &storage.PresignedRequest{
+				Method:  http.MethodPut,
+				URL:     "/api/v1/backups/" + id.String() + "/content",
+				Headers: map[string]string{"Content-Type": backupContentType},
+			}
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to presign backup upload", "error", err)
+			return nil, internalError("failed to start backup")
+		}
+
+		data := gin.H{"backup": backup, "upload": upload}
+		return &Response{Status: http.StatusCreated, Data: data, Legacy: data}, nil
+	}
+}
+
+// UploadBackupContent receives the ciphertext of a pending backup through
+// the API, for backends without presigned URLs.
+func UploadBackupContent(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage) {
+	backup, apiErr := pendingBackup(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if c.Request.ContentLength != backup.SizeBytes {
+		abortWithError(c, badRequest(fmt.Sprintf("Content-Length must be the declared size of %d bytes", backup.SizeBytes)))
+		return
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, backup.SizeBytes)
+	if err := store.Put(c.Request.Context(), backup.StorageKey, body, backup.SizeBytes, backupContentType); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to store backup", "backup_id", backup.ID, "error", err)
+		abortWithError(c, internalError("failed to store backup"))
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CompleteBackup checks that the uploaded ciphertext matches the declared
+// size and hash, makes the backup available for restore, and removes the
+// versions it supersedes.
+func CompleteBackup(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		backup, apiErr := pendingBackup(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		info, err := store.Stat(ctx, backup.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, conflict("the backup has not been uploaded yet")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to stat backup", "backup_id", backup.ID, "error", err)
+			return nil, internalError("failed to complete backup")
+		}
+		if info.Size != backup.SizeBytes {
+			deleteObject(store, backup.StorageKey)
+			return nil, badRequest("the uploaded backup does not match the declared size; upload it again")
+		}
+
+		sum, err := storedSHA256(ctx, store, backup.StorageKey)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to hash backup", "backup_id", backup.ID, "error", err)
+			return nil, internalError("failed to complete backup")
+		}
+		if sum != backup.SHA256 {
+			deleteObject(store, backup.StorageKey)
+			return nil, badRequest("the uploaded backup does not match the declared sha256; upload it again")
+		}
+
+		pruned, err := repositories.NewBackupRepository(dbConnection.DB).MarkReady(ctx, backup, BackupVersionsKept)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to complete backup", "backup_id", backup.ID, "error", err)
+			return nil, internalError("failed to complete backup")
+		}
+		for _, b := range pruned {
+			deleteObject(store, b.StorageKey)
+		}
+
+		backup.Status = models.BackupReady
+		return &Response{Data: backup, Legacy: gin.H{"backup": backup}}, nil
+	}
+}
+
+// ListBackups returns the current user's completed backups, newest first.
+func ListBackups(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		backups, err := repositories.NewBackupRepository(dbConnection.DB).ListReady(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list backups", "error", err)
+			return nil, internalError("failed to list backups")
+		}
+		return &Response{Data: backups, Legacy: gin.H{"backups": backups}}, nil
+	}
+}
+
+// GetBackup returns a completed backup and a short-lived URL to download
+// it. Any of the user's devices may restore it, which is what lets a new
+// phone recover history; only the user's key can decrypt it.
+func GetBackup(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		backup, apiErr := readyBackup(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		download, err := store.PresignGet(c.Request.Context(), backup.StorageKey, presignExpiry)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			download = &storage.PresignedRequest{
+				Method: http.MethodGet,
+				URL:    "/api/v1/backups/" + backup.ID.String() + "/content",
+			}
+		} else if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to presign backup download", "backup_id", backup.ID, "error", err)
+			return nil, internalError("failed to load backup")
+		}
+
+		data := gin.H{"backup": backup, "download": download}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// DownloadBackup streams a completed backup through the API, for backends
+// without presigned URLs.
+func DownloadBackup(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage) {
+	backup, apiErr := readyBackup(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	body, err := store.Open(c.Request.Context(), backup.StorageKey)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to open backup", "backup_id", backup.ID, "error", err)
+		abortWithError(c, internalError("failed to load backup"))
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, backup.SizeBytes, backupContentType, body, map[string]string{
+		"Content-Disposition": fmt.Sprintf(`attachment; filename="backup-v%d.bin"`, backup.Version),
+		"Cache-Control":       "no-store",
+	})
+}
+
+// DeleteBackup removes one of the current user's backups, from any device.
+func DeleteBackup(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		backups := repositories.NewBackupRepository(dbConnection.DB)
+		backup, apiErr := loadBackup(c, backups)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		if err := backups.Delete(c.Request.Context(), backup); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete backup", "backup_id", backup.ID, "error", err)
+			return nil, internalError("failed to delete backup")
+		}
+		deleteObject(store, backup.StorageKey)
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+func loadBackup(c *gin.Context, backups *repositories.BackupRepository) (*models.Backup, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid backup id")
+	}
+	backup, err := backups.Get(c.Request.Context(), CurrentUserID(c), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("backup not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load backup", "backup_id", id, "error", err)
+		return nil, internalError("failed to load backup")
+	}
+	return backup, nil
+}
+
+// pendingBackup loads a backup the current device is still uploading.
+// Uploads are bound to the device that started them.
+func pendingBackup(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Backup, *APIError) {
+	backup, apiErr := loadBackup(c, repositories.NewBackupRepository(dbConnection.DB))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if backup.Status != models.BackupPending {
+		return nil, conflict("backup is already complete")
+	}
+	if backup.SessionID == nil || *backup.SessionID != currentSessionID(c) {
+		return nil, forbidden("only the device that started a backup can upload it")
+	}
+	return backup, nil
+}
+
+func readyBackup(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Backup, *APIError) {
+	backup, apiErr := loadBackup(c, repositories.NewBackupRepository(dbConnection.DB))
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if backup.Status != models.BackupReady {
+		return nil, notFound("backup not found")
+	}
+	return backup, nil
+}
+
+// storedSHA256 hashes a stored object.
+func storedSHA256(ctx context.Context, store storage.Storage, key string) (string, error) {
+	body, err := store.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", fmt.Errorf("failed to read object: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	JobAwardBadges = "award_badges"
+
+	// BadgeInterval is how often badges are awarded.
+	BadgeInterval = time.Hour
+
+	// earlyAdopterAccounts is how many of the first accounts are early
+	// adopters.
+	earlyAdopterAccounts = 1000
+
+	// Community builders own a channel of communityBuilderMembers members
+	// or brought in communityBuilderReferrals people who verified their
+	// accounts.
+	communityBuilderMembers   = 50
+	communityBuilderReferrals = 5
+
+	// streakDays is how many days in a row earn the streak badge.
+	streakDays = 7
+
+	// activityRetention is how long activity is kept, well past the
+	// longest streak counted.
+	activityRetention = 30 * 24 * time.Hour
+)
+
+type updateBadgeRequest struct {
+	Hidden *bool `json:"hidden" binding:"required"`
+}
+
+// activityRecorder notes the days users were active, remembering who it
+// noted today so that only the first message of a user's day is written.
+type activityRecorder struct {
+	mu    sync.Mutex
+	day   string
+	noted map[uuid.UUID]bool
+}
+
+var activity activityRecorder
+
+// record reports whether the user's activity at the time has yet to be
+// noted, marking it noted.
+func (a *activityRecorder) record(userID uuid.UUID, at time.Time) bool {
+	day := at.UTC().Format(models.ActivityDayLayout)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.day != day {
+		a.day, a.noted = day, make(map[uuid.UUID]bool)
+	}
+	if a.noted[userID] {
+		return false
+	}
+	a.noted[userID] = true
+	return true
+}
+
+// forget lets the user's activity of the time be noted again, after
+// noting it failed.
+func (a *activityRecorder) forget(userID uuid.UUID, at time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.day == at.UTC().Format(models.ActivityDayLayout) {
+		delete(a.noted, userID)
+	}
+}
+
+// recordActivity notes that the user was active at the time, for their
+// streak. It never fails the request.
+func recordActivity(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID, at time.Time) {
+	if !activity.record(userID, at) {
+		return
+	}
+	if err := repositories.NewBadgeRepository(dbConnection.DB).RecordActivity(ctx, userID, at); err != nil {
+		activity.forget(userID, at)
+		slog.WarnContext(ctx, "Failed to record activity", "user_id", userID, "error", err)
+	}
+}
+
+// AwardBadges is the scheduled job awarding the badges users earned since
+// it last ran, and purging activity too old to count towards a streak.
+func AwardBadges(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		badges := repositories.NewBadgeRepository(dbConnection.DB)
+		now := time.Now()
+		earners := map[string]func() ([]uuid.UUID, error){
+			models.BadgeEarlyAdopter: func() ([]uuid.UUID, error) {
+				return badges.EarlyAdopters(ctx, earlyAdopterAccounts)
+			},
+			models.BadgeCommunityBuilder: func() ([]uuid.UUID, error) {
+				return badges.CommunityBuilders(ctx, communityBuilderMembers, communityBuilderReferrals)
+			},
+			models.BadgeStreak: func() ([]uuid.UUID, error) {
+				return badges.Streaks(ctx, now, streakDays)
+			},
+		}
+		for badge, earned := range earners {
+			userIDs, err := earned()
+			if err != nil {
+				return err
+			}
+			awarded, err := badges.Award(ctx, badge, userIDs, now)
+			if err != nil {
+				return err
+			}
+			if awarded > 0 {
+				slog.InfoContext(ctx, "Awarded badges", "badge", badge, "count", awarded)
+			}
+		}
+		_, err := badges.PurgeActivity(ctx, now.Add(-activityRetention))
+		return err
+	}
+}
+
+// ListMyBadges returns the current user's badges, including those they
+// hid from their profile.
+func ListMyBadges(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		badges, err := repositories.NewBadgeRepository(dbConnection.DB).List(c.Request.Context(), CurrentUserID(c), true)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list badges", "error", err)
+			return nil, internalError("failed to list badges")
+		}
+		return &Response{Data: badges, Legacy: gin.H{"badges": badges}}, nil
+	}
+}
+
+// UpdateMyBadge hides the current user's badge named by the :badge
+// parameter from their profile, or shows it again.
+func UpdateMyBadge(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req updateBadgeRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		badge, err := repositories.NewBadgeRepository(dbConnection.DB).SetHidden(c.Request.Context(), CurrentUserID(c), c.Param("badge"), *req.Hidden)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("badge not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to update badge", "error", err)
+			return nil, internalError("failed to update badge")
+		}
+		return &Response{Data: badge, Legacy: gin.H{"badge": badge}}, nil
+	}
+}
+
+// ListUserBadges returns the badges another user shows on their profile.
+// Users GetUser reports as not found are here too.
+func ListUserBadges(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		visible, err := workspaceUserIDs(ctx, dbConnection, CurrentWorkspaceID(c), []uuid.UUID{id})
+		if err != nil {
+			return nil, internalError("failed to list badges")
+		}
+		profiles, err := publicProfiles(ctx, dbConnection, visible)
+		if err != nil {
+			return nil, internalError("failed to list badges")
+		}
+		if _, ok := profiles[id]; !ok {
+			return nil, notFound("user not found")
+		}
+		badges, err := repositories.NewBadgeRepository(dbConnection.DB).List(ctx, id, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list badges", "user_id", id, "error", err)
+			return nil, internalError("failed to list badges")
+		}
+		return &Response{Data: badges, Legacy: gin.H{"badges": badges}}, nil
+	}
+}
+
+// shownBadges names the badges a user shows on their profile.
+func shownBadges(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID) ([]string, error) {
+	badges, err := repositories.NewBadgeRepository(dbConnection.DB).List(ctx, userID, false)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(badges))
+	for i := range badges {
+		names[i] = badges[i].Badge
+	}
+	return names, nil
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefixLength is how much of a key is kept to tell keys apart:
+// "ak_" and five more characters.
+const apiKeyPrefixLength = 8
+
+type createBotRequest struct {
+	Username    string `json:"username" binding:"required"`
+	DisplayName string `json:"display_name" binding:"max=100"`
+	Description string `json:"description" binding:"max=500"`
+}
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+}
+
+// BotView is a bot with its public profile.
+type BotView struct {
+	models.Bot
+	Profile PublicProfile `json:"profile"`
+}
+
+func newBotView(bot *models.Bot) BotView {
+	return BotView{Bot: *bot, Profile: NewPublicProfile(&bot.User)}
+}
+
+// NewAPIKeyView is a newly issued API key. The key itself is only ever
+// returned here.
+type NewAPIKeyView struct {
+	models.APIKey
+	Key string `json:"key"`
+}
+
+// CreateBot creates a bot account owned by the current user. Bots sign in
+// with API keys only, and cannot create bots of their own.
+func CreateBot(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		if CurrentUser(c).AccountType == models.AccountTypeBot {
+			return nil, forbidden("bots cannot create bots")
+		}
+		var req createBotRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		req.Username = strings.TrimSpace(req.Username)
+		if !usernamePattern.MatchString(req.Username) {
+			return nil, badRequest("username must be 3-50 letters, digits, '_' or '-'")
+		}
+		if strings.TrimSpace(req.DisplayName) == "" {
+			req.DisplayName = req.Username
+		}
+
+		ctx := c.Request.Context()
+		var taken int64
+		if err := dbConnection.DB.WithContext(ctx).Unscoped().Model(&models.User{}).
+			Where("LOWER(username) = LOWER(?)", req.Username).
+			Count(&taken).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to check bot username", "error", err)
+			return nil, internalError("failed to create bot")
+		}
+		if taken > 0 {
+			return nil, conflict("username is already taken")
+		}
+
+		// Bots have no mailbox; the address only keeps emails unique.
+		id := uuid.New()
+		user := models.User{
+			ID:          id,
+			Email:       "bot-" + strings.ReplaceAll(id.String(), "-", "") + "@bots.invalid",
+			Username:    req.Username,
+			DisplayName: strings.TrimSpace(req.DisplayName),
+			AccountType: models.AccountTypeBot,
+		}
+		bot := models.Bot{OwnerID: CurrentUserID(c), Description: strings.TrimSpace(req.Description)}
+		err := repositories.NewBotRepository(dbConnection.DB).Create(ctx, &user, &bot)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("username is already taken")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create bot", "error", err)
+			return nil, internalError("failed to create bot")
+		}
+		view := newBotView(&bot)
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"bot": view}}, nil
+	}
+}
+
+// ListBots returns the current user's bots.
+func ListBots(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		bots, err := repositories.NewBotRepository(dbConnection.DB).ListForOwner(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list bots", "error", err)
+			return nil, internalError("failed to list bots")
+		}
+		views := make([]BotView, 0, len(bots))
+		for i := range bots {
+			views = append(views, newBotView(&bots[i]))
+		}
+		return &Response{Data: views, Legacy: gin.H{"bots": views}}, nil
+	}
+}
+
+// DeleteBot deletes one of the current user's bots, revoking its keys and
+// removing its webhooks.
+func DeleteBot(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		bot, apiErr := ownBot(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if err := repositories.NewBotRepository(dbConnection.DB).Delete(c.Request.Context(), bot); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete bot", "bot_id", bot.UserID, "error", err)
+			return nil, internalError("failed to delete bot")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// CreateAPIKey issues an API key for one of the current user's bots, with
+// the read and write scopes requested. The key is shown only in this
+// response.
+func CreateAPIKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		bot, apiErr := ownBot(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req createAPIKeyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		var scopes []string
+		for _, scope := range req.Scopes {
+			if !slices.Contains(auth.BotScopes, scope) {
+				return nil, badRequest("scopes must be read or write")
+			}
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		ctx := c.Request.Context()
+		key, hash, err := auth.NewAPIKey()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate API key", "error", err)
+			return nil, internalError("failed to create API key")
+		}
+		apiKey := models.APIKey{
+			BotID:   bot.UserID,
+			Name:    strings.TrimSpace(req.Name),
+			Prefix:  key[:apiKeyPrefixLength],
+			KeyHash: hash,
+			Scopes:  strings.Join(scopes, " "),
+		}
+		if err := repositories.NewBotRepository(dbConnection.DB).CreateKey(ctx, &apiKey); err != nil {
+			slog.ErrorContext(ctx, "Failed to create API key", "bot_id", bot.UserID, "error", err)
+			return nil, internalError("failed to create API key")
+		}
+		view := NewAPIKeyView{APIKey: apiKey, Key: key}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"api_key": view}}, nil
+	}
+}
+
+// ListAPIKeys lists the API keys of one of the current user's bots.
+func ListAPIKeys(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		bot, apiErr := ownBot(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		keys, err := repositories.NewBotRepository(dbConnection.DB).Keys(c.Request.Context(), bot.UserID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list API keys", "bot_id", bot.UserID, "error", err)
+			return nil, internalError("failed to list API keys")
+		}
+		return &Response{Data: keys, Legacy: gin.H{"api_keys": keys}}, nil
+	}
+}
+
+// RevokeAPIKey revokes the API key named by the :keyId parameter.
+func RevokeAPIKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		bot, apiErr := ownBot(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		keyID, err := uuid.Parse(c.Param("keyId"))
+		if err != nil {
+			return nil, badRequest("invalid key id")
+		}
+		err = repositories.NewBotRepository(dbConnection.DB).DeleteKey(c.Request.Context(), bot.UserID, keyID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("API key not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to revoke API key", "key_id", keyID, "error", err)
+			return nil, internalError("failed to revoke API key")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ownBot loads the bot named by the :id parameter, which must be the
+// current user's.
+func ownBot(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Bot, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid bot id")
+	}
+	bot, err := repositories.NewBotRepository(dbConnection.DB).GetForOwner(c.Request.Context(), CurrentUserID(c), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("bot not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load bot", "bot_id", id, "error", err)
+		return nil, internalError("failed to load bot")
+	}
+	return bot, nil
+}
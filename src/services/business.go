@@ -0,0 +1,474 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxCatalogItems caps the catalog of one business.
+	maxCatalogItems = 500
+
+	// maxQuickReplies caps the quick replies of one business.
+	maxQuickReplies = 100
+)
+
+type businessProfileRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Category    string `json:"category" binding:"max=50"`
+	Description string `json:"description" binding:"max=2000"`
+	Website     string `json:"website" binding:"max=255"`
+	Email       string `json:"email" binding:"omitempty,email,max=255"`
+	Address     string `json:"address" binding:"max=255"`
+
+	// The away message answers direct messages no auto-reply rule does.
+	AwayEnabled bool   `json:"away_enabled"`
+	AwayMessage string `json:"away_message" binding:"max=2000"`
+}
+
+type catalogItemRequest struct {
+	Name        string `json:"name" binding:"required,max=100"`
+	Description string `json:"description" binding:"max=2000"`
+	PriceCents  int64  `json:"price_cents" binding:"min=0"`
+	Currency    string `json:"currency" binding:"omitempty,len=3,alpha"`
+	ImageURL    string `json:"image_url" binding:"max=2048"`
+	IsAvailable *bool  `json:"is_available"`
+}
+
+type quickReplyRequest struct {
+	Shortcut string `json:"shortcut" binding:"required,max=30"`
+	Text     string `json:"text" binding:"required,max=4000"`
+}
+
+// businessView is what anyone can see about a business: its profile and
+// the items of its catalog that are available.
+type businessView struct {
+	Profile *models.BusinessProfile `json:"profile"`
+	Catalog []models.CatalogItem    `json:"catalog"`
+}
+
+// GetBusinessProfile returns the business profile of the user named by
+// the :id parameter with the available items of its catalog.
+func GetBusinessProfile(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		var user models.User
+		if err := workspaceUsers(dbConnection.DB.WithContext(ctx), CurrentWorkspaceID(c)).
+			Select("id").
+			Where("id = ? AND is_banned = ?", id, false).
+			First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, notFound("user not found")
+			}
+			return nil, internalError("failed to load user")
+		}
+
+		businesses := repositories.NewBusinessRepository(dbConnection.DB)
+		profile, err := businesses.Profile(ctx, user.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("user has no business profile")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load business profile", "user_id", user.ID, "error", err)
+			return nil, internalError("failed to load business profile")
+		}
+		catalog, err := businesses.CatalogItems(ctx, profile.ID, false)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load catalog", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to load business profile")
+		}
+		view := businessView{Profile: profile, Catalog: catalog}
+		return &Response{Data: view, Legacy: gin.H{"business": view}}, nil
+	}
+}
+
+// GetMyBusinessProfile returns the current user's business profile.
+func GetMyBusinessProfile(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return &Response{Data: profile, Legacy: gin.H{"profile": profile}}, nil
+	}
+}
+
+// SaveMyBusinessProfile sets up or replaces the current user's business
+// profile, which makes theirs a business account. Renaming a verified
+// business takes its verification away until it is verified again.
+func SaveMyBusinessProfile(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req businessProfileRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		name := strings.TrimSpace(req.Name)
+		awayMessage := strings.TrimSpace(req.AwayMessage)
+		website := optionalString(req.Website)
+		switch {
+		case name == "":
+			return nil, badRequest("name cannot be blank")
+		case website != nil && !isHTTPURL(*website):
+			return nil, badRequest("website must be an http or https URL")
+		case req.AwayEnabled && awayMessage == "":
+			return nil, badRequest("away_message is required when away_enabled is set")
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		businesses := repositories.NewBusinessRepository(dbConnection.DB)
+		profile, err := businesses.Profile(ctx, userID)
+		status := http.StatusOK
+		if errors.Is(err, repositories.ErrNotFound) {
+			profile, status = &models.BusinessProfile{UserID: userID}, http.StatusCreated
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to load business profile", "user_id", userID, "error", err)
+			return nil, internalError("failed to save business profile")
+		}
+
+		if profile.Name != name {
+			profile.IsVerified, profile.VerifiedAt = false, nil
+		}
+		profile.Name = name
+		profile.Category = strings.TrimSpace(req.Category)
+		profile.Description = strings.TrimSpace(req.Description)
+		profile.Website = website
+		profile.Email = optionalString(req.Email)
+		profile.Address = optionalString(req.Address)
+		profile.AwayEnabled = req.AwayEnabled
+		profile.AwayMessage = awayMessage
+		if err := businesses.SaveProfile(ctx, profile); err != nil {
+			slog.ErrorContext(ctx, "Failed to save business profile", "user_id", userID, "error", err)
+			return nil, internalError("failed to save business profile")
+		}
+		return &Response{Status: status, Data: profile, Legacy: gin.H{"profile": profile}}, nil
+	}
+}
+
+// DeleteMyBusinessProfile removes the current user's business profile,
+// catalog and quick replies, making theirs a personal account again.
+func DeleteMyBusinessProfile(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID := CurrentUserID(c)
+		err := repositories.NewBusinessRepository(dbConnection.DB).DeleteProfile(c.Request.Context(), userID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("you have no business profile")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete business profile", "user_id", userID, "error", err)
+			return nil, internalError("failed to delete business profile")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListCatalogItems returns every item of the current user's catalog,
+// including those not available.
+func ListCatalogItems(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		items, err := repositories.NewBusinessRepository(dbConnection.DB).CatalogItems(c.Request.Context(), profile.ID, true)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list catalog items", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to list catalog items")
+		}
+		return &Response{Data: items, Legacy: gin.H{"items": items}}, nil
+	}
+}
+
+// CreateCatalogItem adds an item to the current user's catalog.
+func CreateCatalogItem(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req catalogItemRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		item := models.CatalogItem{BusinessID: profile.ID}
+		if apiErr := req.apply(&item); apiErr != nil {
+			return nil, apiErr
+		}
+
+		err := repositories.NewBusinessRepository(dbConnection.DB).CreateCatalogItem(c.Request.Context(), &item, maxCatalogItems)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("a catalog may have at most " + strconv.Itoa(maxCatalogItems) + " items")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create catalog item", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to create catalog item")
+		}
+		return &Response{Status: http.StatusCreated, Data: item, Legacy: gin.H{"item": item}}, nil
+	}
+}
+
+// UpdateCatalogItem replaces the item of the current user's catalog named
+// by the :id parameter.
+func UpdateCatalogItem(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid catalog item id")
+		}
+		var req catalogItemRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		businesses := repositories.NewBusinessRepository(dbConnection.DB)
+		item, err := businesses.CatalogItem(ctx, profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("catalog item not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load catalog item", "id", id, "error", err)
+			return nil, internalError("failed to update catalog item")
+		}
+		if apiErr := req.apply(item); apiErr != nil {
+			return nil, apiErr
+		}
+		if err := businesses.UpdateCatalogItem(ctx, item); err != nil {
+			slog.ErrorContext(ctx, "Failed to update catalog item", "id", id, "error", err)
+			return nil, internalError("failed to update catalog item")
+		}
+		return &Response{Data: item, Legacy: gin.H{"item": item}}, nil
+	}
+}
+
+// DeleteCatalogItem removes the item of the current user's catalog named
+// by the :id parameter.
+func DeleteCatalogItem(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid catalog item id")
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err = repositories.NewBusinessRepository(dbConnection.DB).DeleteCatalogItem(c.Request.Context(), profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("catalog item not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete catalog item", "id", id, "error", err)
+			return nil, internalError("failed to delete catalog item")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListQuickReplies returns the current user's quick replies by shortcut.
+func ListQuickReplies(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		replies, err := repositories.NewBusinessRepository(dbConnection.DB).QuickReplies(c.Request.Context(), profile.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list quick replies", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to list quick replies")
+		}
+		return &Response{Data: replies, Legacy: gin.H{"quick_replies": replies}}, nil
+	}
+}
+
+// CreateQuickReply adds a reply template the current user's clients
+// offer when its shortcut is typed.
+func CreateQuickReply(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req quickReplyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		reply := models.QuickReply{BusinessID: profile.ID}
+		if apiErr := req.apply(&reply); apiErr != nil {
+			return nil, apiErr
+		}
+
+		err := repositories.NewBusinessRepository(dbConnection.DB).CreateQuickReply(c.Request.Context(), &reply, maxQuickReplies)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("businesses may have at most " + strconv.Itoa(maxQuickReplies) + " quick replies")
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("you already have a quick reply with this shortcut")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create quick reply", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to create quick reply")
+		}
+		return &Response{Status: http.StatusCreated, Data: reply, Legacy: gin.H{"quick_reply": reply}}, nil
+	}
+}
+
+// UpdateQuickReply replaces the current user's quick reply named by the
+// :id parameter.
+func UpdateQuickReply(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid quick reply id")
+		}
+		var req quickReplyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		businesses := repositories.NewBusinessRepository(dbConnection.DB)
+		reply, err := businesses.QuickReply(ctx, profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("quick reply not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load quick reply", "id", id, "error", err)
+			return nil, internalError("failed to update quick reply")
+		}
+		if apiErr := req.apply(reply); apiErr != nil {
+			return nil, apiErr
+		}
+		err = businesses.UpdateQuickReply(ctx, reply)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("you already have a quick reply with this shortcut")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update quick reply", "id", id, "error", err)
+			return nil, internalError("failed to update quick reply")
+		}
+		return &Response{Data: reply, Legacy: gin.H{"quick_reply": reply}}, nil
+	}
+}
+
+// DeleteQuickReply removes the current user's quick reply named by the
+// :id parameter.
+func DeleteQuickReply(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid quick reply id")
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err = repositories.NewBusinessRepository(dbConnection.DB).DeleteQuickReply(c.Request.Context(), profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("quick reply not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete quick reply", "id", id, "error", err)
+			return nil, internalError("failed to delete quick reply")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// currentBusiness loads the current user's business profile, answering
+// 404 to users without one.
+func currentBusiness(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.BusinessProfile, *APIError) {
+	userID := CurrentUserID(c)
+	profile, err := repositories.NewBusinessRepository(dbConnection.DB).Profile(c.Request.Context(), userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("set up a business profile first")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load business profile", "user_id", userID, "error", err)
+		return nil, internalError("failed to load business profile")
+	}
+	return profile, nil
+}
+
+// awayRule returns the away message of profile as the auto-reply rule
+// answering whenever no rule of the owner's own does, or nil when it is
+// turned off.
+func awayRule(profile *models.BusinessProfile) *models.AutoReplyRule {
+	if !profile.AwayEnabled || profile.AwayMessage == "" {
+		return nil
+	}
+	return &models.AutoReplyRule{
+		OwnerID:     profile.UserID,
+		Kind:        models.AutoReplySchedule,
+		Reply:       profile.AwayMessage,
+		Priority:    math.MinInt,
+		Enabled:     true,
+		Days:        127,
+		StartMinute: 0,
+		EndMinute:   1440,
+	}
+}
+
+// apply copies the request onto item.
+func (req *catalogItemRequest) apply(item *models.CatalogItem) *APIError {
+	name := strings.TrimSpace(req.Name)
+	imageURL := optionalString(req.ImageURL)
+	switch {
+	case name == "":
+		return badRequest("name cannot be blank")
+	case imageURL != nil && !isHTTPURL(*imageURL):
+		return badRequest("image_url must be an http or https URL")
+	}
+
+	item.Name = name
+	item.Description = strings.TrimSpace(req.Description)
+	item.PriceCents = req.PriceCents
+	item.Currency = "ZAR"
+	if req.Currency != "" {
+		item.Currency = strings.ToUpper(req.Currency)
+	}
+	item.ImageURL = imageURL
+	item.IsAvailable = req.IsAvailable == nil || *req.IsAvailable
+	return nil
+}
+
+// apply copies the request onto reply. Shortcuts are one word, matched
+// without a leading slash and regardless of case.
+func (req *quickReplyRequest) apply(reply *models.QuickReply) *APIError {
+	shortcut := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(req.Shortcut), "/"))
+	text := strings.TrimSpace(req.Text)
+	switch {
+	case shortcut == "" || strings.ContainsFunc(shortcut, unicode.IsSpace):
+		return badRequest("shortcut must be a single word")
+	case text == "":
+		return badRequest("text cannot be blank")
+	}
+	reply.Shortcut = shortcut
+	reply.Text = text
+	return nil
+}
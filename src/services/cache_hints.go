@@ -0,0 +1,130 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/gin-gonic/gin"
+)
+
+// The kinds of resource clients cache, as named in the cache manifest.
+const (
+	cacheProfileImages = "profile_images"
+	cacheEmoji         = "emoji"
+	cacheStickerPacks  = "sticker_packs"
+	cacheAttachments   = "attachments"
+
+	// cacheManifestTTL is how long clients keep the manifest itself, and
+	// so how soon changed lifetimes reach them.
+	cacheManifestTTL = 5 * time.Minute
+)
+
+// CacheHints are the cache lifetimes the server suggests for each kind of
+// resource, so they can be tuned for slow and costly networks without
+// releasing the apps.
+type CacheHints struct {
+	resources          map[string]cacheResource
+	lowBandwidthFactor int
+	version            string
+}
+
+type cacheResource struct {
+	ttl time.Duration
+
+	// private resources are kept by the client only, never by shared
+	// caches.
+	private bool
+}
+
+// CacheDirective tells clients how to cache one kind of resource. A copy
+// older than MaxAge seconds may still be shown for StaleWhileRevalidate
+// more while it is fetched again. CacheControl is the same as a header.
+type CacheDirective struct {
+	MaxAge               int    `json:"max_age"`
+	StaleWhileRevalidate int    `json:"stale_while_revalidate"`
+	Scope                string `json:"scope"`
+	CacheControl         string `json:"cache_control"`
+}
+
+func NewCacheHints(appConfig *config.ApplicationConfig) *CacheHints {
+	hints := &CacheHints{
+		resources: map[string]cacheResource{
+			cacheProfileImages: {ttl: appConfig.CacheProfileImageTTL},
+			cacheEmoji:         {ttl: appConfig.CacheEmojiTTL},
+			cacheStickerPacks:  {ttl: appConfig.CacheStickerPackTTL},
+			cacheAttachments:   {ttl: appConfig.CacheAttachmentTTL, private: true},
+		},
+		lowBandwidthFactor: appConfig.CacheLowBandwidthFactor,
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%v|%d", hints.resources, hints.lowBandwidthFactor))
+	hints.version = hex.EncodeToString(sum[:8])
+	return hints
+}
+
+// directive returns how to cache a kind of resource, for longer when the
+// client is saving data.
+func (h *CacheHints) directive(resource string, lowBandwidth bool) CacheDirective {
+	spec := h.resources[resource]
+	ttl := spec.ttl
+	if lowBandwidth {
+		ttl *= time.Duration(h.lowBandwidthFactor)
+	}
+	maxAge := int(ttl.Seconds())
+	scope := "public"
+	if spec.private {
+		scope = "private"
+	}
+	return CacheDirective{
+		MaxAge:               maxAge,
+		StaleWhileRevalidate: maxAge,
+		Scope:                scope,
+		CacheControl:         scope + ", max-age=" + strconv.Itoa(maxAge) + ", stale-while-revalidate=" + strconv.Itoa(maxAge),
+	}
+}
+
+// apply sets the Cache-Control header of a response serving a kind of
+// resource.
+func (h *CacheHints) apply(c *gin.Context, resource string) {
+	c.Header("Cache-Control", h.directive(resource, savesData(c)).CacheControl)
+	c.Writer.Header().Add("Vary", "Save-Data")
+}
+
+// savesData reports whether the client asked to save data with the
+// Save-Data client hint, as browsers and apps do on metered or slow
+// connections.
+func savesData(c *gin.Context) bool {
+	return strings.EqualFold(strings.TrimSpace(c.GetHeader("Save-Data")), "on")
+}
+
+// GetCacheManifest tells clients how long to keep profile images, the
+// emoji catalog, sticker packs and attachments. Clients sending Save-Data:
+// on are given the longer lifetimes meant for low-bandwidth networks.
+func GetCacheManifest(c *gin.Context, hints *CacheHints) {
+	lowBandwidth := savesData(c)
+	etag := `"` + hints.version + "." + strconv.FormatBool(lowBandwidth) + `"`
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(cacheManifestTTL.Seconds())))
+	c.Header("Vary", "Save-Data")
+	if ifNoneMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	resources := make(map[string]CacheDirective, len(hints.resources))
+	for resource := range hints.resources {
+		resources[resource] = hints.directive(resource, lowBandwidth)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "success",
+		"version":       hints.version,
+		"low_bandwidth": lowBandwidth,
+		"manifest_ttl":  int(cacheManifestTTL.Seconds()),
+		"resources":     resources,
+	})
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/calendar"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxCalendarEvents bounds the events listed in a room's calendar.
+	maxCalendarEvents = 200
+
+	// calendarHistory is how long after they end events stay in a room's
+	// iCalendar feed, so subscribed calendars do not drop them at once.
+	calendarHistory = 30 * 24 * time.Hour
+)
+
+// RoomEventView is an event in a room's calendar.
+type RoomEventView struct {
+	MessageID uuid.UUID `json:"message_id"`
+	SenderID  uuid.UUID `json:"sender_id"`
+	content.Event
+}
+
+// ListRoomEvents returns the events of the conversation named by the :id
+// parameter that are not over yet, soonest first, with their RSVP counts.
+func ListRoomEvents(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		events := repositories.NewEventRepository(dbConnection.DB)
+		upcoming, err := events.Upcoming(ctx, conversation.ID, time.Now(), maxCalendarEvents)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list room events", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list events")
+		}
+		ids := make([]uuid.UUID, len(upcoming))
+		for i := range upcoming {
+			ids[i] = upcoming[i].Message.ID
+		}
+		counts, err := events.RSVPCountsByEvent(ctx, ids)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count RSVPs", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list events")
+		}
+
+		views := make([]RoomEventView, 0, len(upcoming))
+		for i := range upcoming {
+			view := RoomEventView{MessageID: upcoming[i].Message.ID, SenderID: upcoming[i].Message.SenderID}
+			if err := json.Unmarshal(upcoming[i].Message.Payload, &view.Event); err != nil {
+				slog.ErrorContext(ctx, "Failed to decode event", "message_id", view.MessageID, "error", err)
+				continue
+			}
+			view.RSVPCount = counts[view.MessageID]
+			views = append(views, view)
+		}
+		return &Response{Data: views, Legacy: gin.H{"events": views}}, nil
+	}
+}
+
+// GetRoomCalendar serves the events of the conversation named by the :id
+// parameter as an iCalendar feed. Events stay in it for calendarHistory
+// after they end, and keep their UIDs, so calendars subscribed to it
+// update them in place.
+func GetRoomCalendar(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	ctx := c.Request.Context()
+	upcoming, err := repositories.NewEventRepository(dbConnection.DB).Upcoming(ctx, conversation.ID, time.Now().Add(-calendarHistory), maxCalendarEvents)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list room events", "conversation_id", conversation.ID, "error", err)
+		abortWithError(c, internalError("failed to load calendar"))
+		return
+	}
+	entries := make([]calendar.Entry, 0, len(upcoming))
+	for i := range upcoming {
+		entry := calendar.Entry{UID: upcoming[i].Message.ID.String() + "@afrochat", UpdatedAt: upcoming[i].Message.UpdatedAt}
+		if err := json.Unmarshal(upcoming[i].Message.Payload, &entry.Event); err != nil {
+			slog.ErrorContext(ctx, "Failed to decode event", "message_id", upcoming[i].Message.ID, "error", err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	var body bytes.Buffer
+	if err := calendar.WriteICS(&body, calendarName(conversation), entries); err != nil {
+		slog.ErrorContext(ctx, "Failed to write calendar", "conversation_id", conversation.ID, "error", err)
+		abortWithError(c, internalError("failed to load calendar"))
+		return
+	}
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Header("Content-Disposition", `inline; filename="calendar.ics"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", body.Bytes())
+}
+
+func calendarName(conversation *models.Conversation) string {
+	if conversation.Title != nil && *conversation.Title != "" {
+		return *conversation.Title
+	}
+	return "AfroChat events"
+}
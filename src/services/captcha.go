@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/captcha"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+)
+
+// linkVisitWindow is how long after resolving a short link to a channel
+// joining it counts as arriving through the link.
+const linkVisitWindow = 24 * time.Hour
+
+// JoinCaptcha decides when joining a channel needs a CAPTCHA, and checks
+// the tokens of those solved.
+type JoinCaptcha struct {
+	provider string
+	siteKey  string
+	verifier captcha.Verifier
+	policy   captcha.JoinPolicy
+}
+
+func NewJoinCaptcha(appConfig *config.ApplicationConfig) *JoinCaptcha {
+	joinCaptcha := &JoinCaptcha{
+		provider: appConfig.Captcha,
+		siteKey:  appConfig.CaptchaSiteKey,
+		verifier: captcha.NoopVerifier{},
+		policy: captcha.JoinPolicy{
+			Enabled:       appConfig.Captcha != config.CaptchaOff,
+			MinRoomSize:   appConfig.CaptchaMinRoomSize,
+			NewAccountAge: appConfig.CaptchaNewAccountAge,
+		},
+	}
+	switch appConfig.Captcha {
+	case config.CaptchaHCaptcha:
+		joinCaptcha.verifier = captcha.NewSiteVerifier(captcha.HCaptchaVerifyURL, appConfig.CaptchaSecret)
+	case config.CaptchaTurnstile:
+		joinCaptcha.verifier = captcha.NewSiteVerifier(captcha.TurnstileVerifyURL, appConfig.CaptchaSecret)
+	}
+	return joinCaptcha
+}
+
+// challengeJoin checks that the current user solved a CAPTCHA, when the
+// channel asks for one of users like them. When they have not, it responds
+// with 403 and the provider and site key to render the widget with, and
+// returns false. Members joining again are not challenged.
+func challengeJoin(c *gin.Context, joinCaptcha *JoinCaptcha, channels *repositories.ChannelRepository, channel *models.Channel, req joinChannelRequest) bool {
+	if !channel.JoinCaptcha || !joinCaptcha.policy.Enabled {
+		return true
+	}
+	ctx := c.Request.Context()
+	user := CurrentUser(c)
+	if _, err := channels.Member(ctx, channel.ConversationID, user.ID); err == nil {
+		return true
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to check channel membership", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return false
+	}
+	size, err := channels.MemberCount(ctx, channel.ConversationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count channel members", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return false
+	}
+	viaLink, err := channels.VisitedViaLink(ctx, channel.ConversationID, user.ID, time.Now().Add(-linkVisitWindow))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check channel link visits", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return false
+	}
+	if !joinCaptcha.policy.Required(int(size), time.Since(user.CreatedAt), viaLink) {
+		return true
+	}
+
+	err = joinCaptcha.verifier.Verify(ctx, req.CaptchaToken, c.ClientIP())
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, captcha.ErrVerificationFailed) {
+		slog.ErrorContext(ctx, "Failed to verify captcha", "error", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "error",
+			"error":  "failed to verify captcha; try again later",
+		})
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"status":  "error",
+		"error":   "solve the captcha to join this channel",
+		"captcha": gin.H{"provider": joinCaptcha.provider, "site_key": joinCaptcha.siteKey},
+	})
+	return false
+}
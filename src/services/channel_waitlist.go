@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/push"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	JobChannelAdmission = "channel_admission"
+
+	// eventChannelAdmitted tells a user waiting for a place in a full
+	// channel that they are now a member.
+	eventChannelAdmitted = "channel.admitted"
+)
+
+// channelAdmissionJob pushes the news of their admission to users who
+// were not connected when they got in.
+type channelAdmissionJob struct {
+	ChannelID uuid.UUID   `json:"channel_id"`
+	UserIDs   []uuid.UUID `json:"user_ids"`
+}
+
+// ListChannelWaitlist lists the users waiting for a place in the channel,
+// first in line first.
+func ListChannelWaitlist(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	entries, err := repositories.NewChannelRepository(dbConnection.DB).Waitlist(c.Request.Context(), CurrentChannel(c).ConversationID)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list channel waitlist", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list channel waitlist",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"waitlist": entries,
+	})
+}
+
+// LeaveChannelWaitlist takes the current user out of the line for a full
+// channel.
+func LeaveChannelWaitlist(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	channelID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid channel id",
+		})
+		return
+	}
+
+	err = repositories.NewChannelRepository(dbConnection.DB).LeaveWaitlist(c.Request.Context(), channelID, CurrentUserID(c))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "you are not waiting for this channel",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to leave channel waitlist", "channel_id", channelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to leave channel waitlist",
+		})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// admitWaiting fills the places free in the channel from its waitlist
+// and tells those admitted.
+func admitWaiting(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, channel *models.Channel) {
+	admitted, err := repositories.NewChannelRepository(dbConnection.DB).
+		AdmitWaiting(ctx, channel.ConversationID, entitlements.Rooms().Channel(channel.IsPrivate))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to admit users waiting for channel", "channel_id", channel.ConversationID, "error", err)
+		return
+	}
+	queueJoinAutomations(ctx, dbConnection.DB, channel.ConversationID, admitted)
+	notifyAdmitted(ctx, dbConnection, hub, channel, admitted)
+}
+
+// notifyAdmitted tells users admitted from the channel's waitlist that
+// they are members, on their connected devices or else by push.
+func notifyAdmitted(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, channel *models.Channel, userIDs []uuid.UUID) {
+	if len(userIDs) == 0 {
+		return
+	}
+	event, err := realtime.NewEvent(eventChannelAdmitted, repositories.ChannelWithRole{Channel: *channel, Role: models.MemberRoleMember})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode event", "event_type", eventChannelAdmitted, "error", err)
+		return
+	}
+	var offline []uuid.UUID
+	for _, userID := range userIDs {
+		if !hub.SendToUser(userID, event) {
+			offline = append(offline, userID)
+		}
+	}
+	if len(offline) == 0 {
+		return
+	}
+	job := channelAdmissionJob{ChannelID: channel.ConversationID, UserIDs: offline}
+	if _, err := repositories.NewJobRepository(dbConnection.DB).Enqueue(ctx, JobChannelAdmission, job); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue channel admission notifications", "channel_id", channel.ConversationID, "error", err)
+	}
+}
+
+// NotifyChannelAdmission is the job pushing the news of their admission
+// to a channel to users who were offline when they got in.
+func NotifyChannelAdmission(dbConnection *database.DatabaseConnection, notifier *Notifier) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload channelAdmissionJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		channel, err := repositories.NewChannelRepository(dbConnection.DB).Get(ctx, payload.ChannelID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		notification := push.Notification{
+			Title:    channel.Name,
+			Body:     "A place opened up and you're now a member. Say hello!",
+			ThreadID: channel.ConversationID.String(),
+			Data: map[string]string{
+				"type":            eventChannelAdmitted,
+				"conversation_id": channel.ConversationID.String(),
+			},
+		}
+		for _, userID := range payload.UserIDs {
+			if err := notifier.Alert(ctx, userID, notification); err != nil {
+				slog.ErrorContext(ctx, "Failed to notify user of channel admission", "user_id", userID, "channel_id", channel.ConversationID, "error", err)
+			}
+		}
+		return nil
+	}
+}
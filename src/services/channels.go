@@ -0,0 +1,547 @@
+package services
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type createChannelRequest struct {
+	Name        string      `json:"name" binding:"required,max=80"`
+	Description string      `json:"description" binding:"max=500"`
+	IsPrivate   bool        `json:"is_private"`
+	MemberIDs   []uuid.UUID `json:"member_ids" binding:"max=256"`
+}
+
+type addChannelMembersRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1,max=100"`
+}
+
+type updateChannelRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=owner admin member"`
+}
+
+type setChannelListingRequest struct {
+	Listed *bool `json:"listed" binding:"required"`
+}
+
+// joinChannelRequest is the optional body of a join. Whether the user
+// arrived through a link is not taken from it: the server knows from the
+// short links they resolved.
+type joinChannelRequest struct {
+	CaptchaToken string `json:"captcha_token"`
+}
+
+type setJoinCaptchaRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+type setAgeGateRequest struct {
+	AdultOnly *bool `json:"adult_only" binding:"required"`
+}
+
+// CreateChannel creates a channel owned by the current user, with the
+// members it names told in their notification centers.
+func CreateChannel(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub) {
+	var req createChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "name cannot be blank",
+		})
+		return
+	}
+	if err := checkRecipients(c.Request.Context(), dbConnection, CurrentWorkspaceID(c), req.MemberIDs); err != nil {
+		respondRecipientError(c, err)
+		return
+	}
+	if !checkRoomCap(c, entitlements.Rooms().Channel(req.IsPrivate), req.MemberIDs) {
+		return
+	}
+	if !checkRoomTrust(c, dbConnection) {
+		return
+	}
+	quota, ok := checkRoomQuota(c, dbConnection)
+	if !ok {
+		return
+	}
+
+	channel, err := repositories.NewChannelRepository(dbConnection.DB).
+		Create(c.Request.Context(), CurrentWorkspaceID(c), CurrentUserID(c), name, strings.TrimSpace(req.Description), req.IsPrivate, req.MemberIDs)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to create channel", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to create channel",
+		})
+		return
+	}
+	quota.Used++
+	setQuotaHeaders(c, "Rooms", quota)
+	notifyChannelInvites(c, dbConnection, hub, channel, req.MemberIDs, nil)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: models.MemberRoleOwner},
+	})
+}
+
+// ListChannels returns the channels the current user belongs to.
+func ListChannels(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	channels, err := repositories.NewChannelRepository(dbConnection.DB).ListForUser(c.Request.Context(), CurrentWorkspaceID(c), CurrentUserID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list channels", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list channels",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"channels": channels,
+	})
+}
+
+// GetChannel returns a channel the current user belongs to.
+func GetChannel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *CurrentChannel(c), Role: CurrentChannelMember(c).Role},
+	})
+}
+
+// JoinChannel adds the current user to a public channel of the current
+// workspace. Private channels can only be joined by being added by an
+// admin. When the channel is full the user waits in line and is told
+// their position, which joining again reports afresh; they are added, and
+// told, when a place opens up. Channels that ask for it have new users,
+// and those who resolved a short link to it, solve a CAPTCHA first.
+func JoinChannel(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, joinCaptcha *JoinCaptcha) {
+	channelID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid channel id",
+		})
+		return
+	}
+	var req joinChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid join request",
+		})
+		return
+	}
+
+	channels := repositories.NewChannelRepository(dbConnection.DB)
+	channel, err := channels.Get(c.Request.Context(), channelID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load channel",
+		})
+		return
+	}
+	if channel == nil || channel.IsPrivate || channel.Conversation.WorkspaceID != CurrentWorkspaceID(c) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "channel not found",
+		})
+		return
+	}
+	if !checkAgeGate(c, channels, channel) || !challengeJoin(c, joinCaptcha, channels, channel, req) {
+		return
+	}
+
+	admitted, position, err := channels.Join(c.Request.Context(), channelID, CurrentUserID(c), entitlements.Rooms().PublicChannel)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to join channel", "channel_id", channelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return
+	}
+	queueJoinAutomations(c.Request.Context(), dbConnection.DB, channelID, admitted)
+	// Places may have opened for others ahead in line, if the cap was
+	// raised.
+	notifyAdmitted(c.Request.Context(), dbConnection, hub, channel, slices.DeleteFunc(admitted, func(userID uuid.UUID) bool {
+		return userID == CurrentUserID(c)
+	}))
+	if position > 0 {
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":   "success",
+			"waitlist": gin.H{"channel_id": channelID, "position": position},
+		})
+		return
+	}
+
+	member, err := channels.Member(c.Request.Context(), channelID, CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join channel",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: member.Role},
+	})
+}
+
+// ChannelMemberView is a channel member with their public profile.
+type ChannelMemberView struct {
+	models.ChannelMember
+	User PublicProfile `json:"user"`
+}
+
+// ListChannelMembers lists a channel's members with their roles and
+// profiles.
+func ListChannelMembers(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	found, err := repositories.NewChannelRepository(dbConnection.DB).Members(c.Request.Context(), CurrentChannel(c).ConversationID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list channel members",
+		})
+		return
+	}
+	members := make([]ChannelMemberView, len(found))
+	for i := range found {
+		members[i] = ChannelMemberView{ChannelMember: found[i], User: NewPublicProfile(&found[i].User)}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"members": members,
+	})
+}
+
+// AddChannelMembers adds users to the channel, ahead of anyone waiting
+// for a place, and tells each one added in their notification center.
+// Users who are already members are left as they are. It fails with 409
+// when that would take the channel past its cap.
+func AddChannelMembers(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub) {
+	var req addChannelMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "user_ids must contain between 1 and 100 user IDs",
+		})
+		return
+	}
+	channel := CurrentChannel(c)
+	err := checkRecipients(c.Request.Context(), dbConnection, CurrentWorkspaceID(c), req.UserIDs)
+	if err == nil {
+		err = checkAdultMembers(c.Request.Context(), dbConnection, channel, req.UserIDs)
+	}
+	if err != nil {
+		respondRecipientError(c, err)
+		return
+	}
+
+	channels := repositories.NewChannelRepository(dbConnection.DB)
+	channelID := channel.ConversationID
+	var existing []uuid.UUID
+	err = dbConnection.DB.WithContext(c.Request.Context()).Model(&models.ChannelMember{}).
+		Where("conversation_id = ? AND user_id IN ?", channelID, req.UserIDs).
+		Pluck("user_id", &existing).Error
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load members of channel", "channel_id", channelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to add channel members",
+		})
+		return
+	}
+	err = channels.AddMembersWithin(c.Request.Context(), channelID, req.UserIDs, entitlements.Rooms().Channel(channel.IsPrivate))
+	if errors.Is(err, repositories.ErrLimitReached) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "channel is full",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to add members to channel", "channel_id", channelID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to add channel members",
+		})
+		return
+	}
+	notifyChannelInvites(c, dbConnection, hub, channel, req.UserIDs, existing)
+	queueJoinAutomations(c.Request.Context(), dbConnection.DB, channelID, slices.DeleteFunc(uniqueIDs(req.UserIDs), func(userID uuid.UUID) bool {
+		return slices.Contains(existing, userID)
+	}))
+
+	ListChannelMembers(c, dbConnection)
+}
+
+// notifyChannelInvites tells the users just added to a channel, those of
+// userIDs not in existing, who added them.
+func notifyChannelInvites(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, channel *models.Channel, userIDs, existing []uuid.UUID) {
+	member := make(map[uuid.UUID]bool, len(existing))
+	for _, userID := range existing {
+		member[userID] = true
+	}
+	actor := CurrentUser(c)
+	notifications := make([]models.InboxNotification, 0, len(userIDs))
+	for _, userID := range uniqueIDs(userIDs) {
+		if member[userID] || userID == actor.ID {
+			continue
+		}
+		notifications = append(notifications, models.InboxNotification{
+			UserID:         userID,
+			Kind:           models.InboxInvite,
+			Title:          actor.DisplayName + " added you to #" + channel.Name,
+			Body:           channel.Description,
+			ActorID:        &actor.ID,
+			ConversationID: &channel.ConversationID,
+		})
+	}
+	if len(notifications) > 0 {
+		notifyInbox(c.Request.Context(), dbConnection, hub, notifications...)
+	}
+}
+
+// RemoveChannelMember removes a member. Anyone may leave; admins may remove
+// members; only the owner may remove admins. The owner cannot leave. The
+// place freed goes to the first user waiting for one.
+func RemoveChannelMember(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub) {
+	targetID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid user id",
+		})
+		return
+	}
+
+	channels := repositories.NewChannelRepository(dbConnection.DB)
+	channelID := CurrentChannel(c).ConversationID
+	actor := CurrentChannelMember(c)
+
+	if targetID != actor.UserID {
+		target, err := channels.Member(c.Request.Context(), channelID, targetID)
+		if err != nil {
+			respondChannelMemberError(c, err)
+			return
+		}
+		if !canManage(actor.Role, target.Role) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": "error",
+				"error":  "insufficient channel permissions",
+			})
+			return
+		}
+	}
+
+	if err := channels.RemoveMember(c.Request.Context(), channelID, targetID); err != nil {
+		respondChannelMemberError(c, err)
+		return
+	}
+	admitWaiting(c.Request.Context(), dbConnection, hub, CurrentChannel(c))
+
+	c.Status(http.StatusNoContent)
+}
+
+// UpdateChannelMemberRole changes a member's role. Only the owner may assign
+// roles; assigning "owner" transfers ownership and makes the previous owner
+// an admin.
+func UpdateChannelMemberRole(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	targetID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid user id",
+		})
+		return
+	}
+
+	var req updateChannelRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "role must be one of owner, admin or member",
+		})
+		return
+	}
+
+	channels := repositories.NewChannelRepository(dbConnection.DB)
+	channelID := CurrentChannel(c).ConversationID
+	actor := CurrentChannelMember(c)
+
+	if req.Role == models.MemberRoleOwner {
+		if targetID != actor.UserID {
+			err = channels.TransferOwnership(c.Request.Context(), channelID, actor.UserID, targetID)
+		}
+	} else {
+		err = channels.SetRole(c.Request.Context(), channelID, targetID, req.Role)
+	}
+	if err != nil {
+		respondChannelMemberError(c, err)
+		return
+	}
+
+	ListChannelMembers(c, dbConnection)
+}
+
+// canManage reports whether a member with actorRole may remove a member
+// with targetRole.
+func canManage(actorRole, targetRole string) bool {
+	switch actorRole {
+	case models.MemberRoleOwner:
+		return targetRole != models.MemberRoleOwner
+	case models.MemberRoleAdmin:
+		return targetRole == models.MemberRoleMember
+	}
+	return false
+}
+
+func respondChannelMemberError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound), errors.Is(err, repositories.ErrNotMember):
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "user is not a member of this channel",
+		})
+	case errors.Is(err, repositories.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+	default:
+		slog.ErrorContext(c.Request.Context(), "Failed to update channel membership", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update channel membership",
+		})
+	}
+}
+
+// SetChannelListing offers the public channel to search engines, or
+// withdraws it. Private channels cannot be listed.
+func SetChannelListing(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req setChannelListingRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "listed must be true or false",
+		})
+		return
+	}
+
+	channel := CurrentChannel(c)
+	if channel.IsPrivate && *req.Listed {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "private channels cannot be listed",
+		})
+		return
+	}
+	if err := repositories.NewChannelRepository(dbConnection.DB).SetListed(c.Request.Context(), channel.ConversationID, *req.Listed); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update channel listing", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update channel listing",
+		})
+		return
+	}
+	channel.IsListed = *req.Listed
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: CurrentChannelMember(c).Role},
+	})
+}
+
+// SetChannelJoinCaptcha turns the CAPTCHA for joining the public channel
+// on or off. Private channels are only joined by being added.
+func SetChannelJoinCaptcha(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req setJoinCaptchaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "required must be true or false",
+		})
+		return
+	}
+
+	channel := CurrentChannel(c)
+	if channel.IsPrivate && *req.Required {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "private channels cannot be joined",
+		})
+		return
+	}
+	if err := repositories.NewChannelRepository(dbConnection.DB).SetJoinCaptcha(c.Request.Context(), channel.ConversationID, *req.Required); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update channel join captcha", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update channel join captcha",
+		})
+		return
+	}
+	channel.JoinCaptcha = *req.Required
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: CurrentChannelMember(c).Role},
+	})
+}
+
+// SetChannelAgeGate restricts the channel to users 18 and over, or lifts
+// the restriction. Members already in are not removed.
+func SetChannelAgeGate(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req setAgeGateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "adult_only must be true or false",
+		})
+		return
+	}
+
+	channel := CurrentChannel(c)
+	if err := repositories.NewChannelRepository(dbConnection.DB).SetAdultOnly(c.Request.Context(), channel.ConversationID, *req.AdultOnly); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update channel age gate", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update channel age gate",
+		})
+		return
+	}
+	channel.AdultOnly = *req.AdultOnly
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: CurrentChannelMember(c).Role},
+	})
+}
@@ -0,0 +1,20 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/chaos"
+	"github.com/gin-gonic/gin"
+)
+
+// ChaosMiddleware delays a random fraction of requests, to test how clients
+// cope with a slow server. Health checks are left alone so probes keep
+// working while faults are injected.
+func ChaosMiddleware(injector *chaos.Injector) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.URL.Path, "/api/v1/health") {
+			injector.Delay(c.Request.Context())
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,320 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/clientconfig"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	clientPlatformHeader = "X-Client-Platform"
+	clientVersionHeader  = "X-Client-Version"
+
+	clientConfigCacheTTL = 30 * time.Second
+)
+
+type clientConfigRuleRequest struct {
+	Platform   *string                `json:"platform" binding:"omitempty,oneof=ios android web"`
+	MinVersion *string                `json:"min_version"`
+	MaxVersion *string                `json:"max_version"`
+	Priority   *int                   `json:"priority"`
+	Settings   *clientconfig.Settings `json:"settings"`
+	Enabled    *bool                  `json:"enabled"`
+	Note       *string                `json:"note" binding:"omitempty,max=255"`
+}
+
+// NewClientConfigCache returns a cache of the enabled client config rules.
+// Rules whose settings cannot be decoded are skipped and logged.
+func NewClientConfigCache(dbConnection *database.DatabaseConnection) *clientconfig.Cache {
+	return clientconfig.NewCache(clientConfigCacheTTL, func(ctx context.Context) ([]clientconfig.Rule, error) {
+		var rows []models.ClientConfigRule
+		if err := dbConnection.DB.WithContext(ctx).Where("enabled = ?", true).Find(&rows).Error; err != nil {
+			return nil, err
+		}
+
+		rules := make([]clientconfig.Rule, 0, len(rows))
+		for _, row := range rows {
+			var settings clientconfig.Settings
+			if err := json.Unmarshal(row.Settings, &settings); err != nil {
+				slog.Warn("Skipping client config rule", "rule_id", row.ID, "error", err)
+				continue
+			}
+			rules = append(rules, clientconfig.Rule{
+				Platform:   row.Platform,
+				MinVersion: row.MinVersion,
+				MaxVersion: row.MaxVersion,
+				Priority:   row.Priority,
+				Settings:   settings,
+			})
+		}
+		return rules, nil
+	})
+}
+
+// clientIdentity reads the client's platform and version from the
+// X-Client-Platform and X-Client-Version headers, falling back to the
+// platform and version query parameters. version is nil if absent or
+// unparseable.
+func clientIdentity(c *gin.Context) (string, *clientconfig.Version) {
+	platform := c.GetHeader(clientPlatformHeader)
+	if platform == "" {
+		platform = c.Query("platform")
+	}
+	rawVersion := c.GetHeader(clientVersionHeader)
+	if rawVersion == "" {
+		rawVersion = c.Query("version")
+	}
+
+	var version *clientconfig.Version
+	if parsed, err := clientconfig.ParseVersion(rawVersion); err == nil {
+		version = &parsed
+	}
+	return strings.ToLower(strings.TrimSpace(platform)), version
+}
+
+// GetClientConfig returns the settings for the calling client's platform
+// and version. It is public so apps can fetch it before signing in.
+func GetClientConfig(c *gin.Context, cache *clientconfig.Cache) {
+	rules, err := cache.Rules(c.Request.Context())
+	if err != nil {
+		// Serve the last known rules rather than failing app start-up.
+		slog.ErrorContext(c.Request.Context(), "Failed to reload client config rules", "error", err)
+	}
+
+	platform, version := clientIdentity(c)
+	response := gin.H{
+		"status":   "success",
+		"platform": platform,
+		"config":   clientconfig.Resolve(rules, platform, version),
+	}
+	if version != nil {
+		response["version"] = version.String()
+	}
+	c.Header("Cache-Control", "public, max-age=60")
+	c.JSON(http.StatusOK, response)
+}
+
+// ListClientConfigRules lists every rule, enabled or not.
+func ListClientConfigRules(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var rules []models.ClientConfigRule
+	if err := dbConnection.DB.WithContext(c.Request.Context()).
+		Order("priority ASC, created_at ASC").
+		Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list client config rules",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"rules":  rules,
+	})
+}
+
+// CreateClientConfigRule adds a rule. New rules are enabled unless the
+// request says otherwise.
+func CreateClientConfigRule(c *gin.Context, dbConnection *database.DatabaseConnection, cache *clientconfig.Cache) {
+	rule := models.ClientConfigRule{Enabled: true}
+	if !applyClientConfigRule(c, &rule) {
+		return
+	}
+	rule.UpdatedByID = CurrentUserID(c)
+
+	if err := dbConnection.DB.WithContext(c.Request.Context()).Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to create client config rule",
+		})
+		return
+	}
+	cache.Invalidate()
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"rule":   rule,
+	})
+}
+
+// UpdateClientConfigRule changes the fields present in the request.
+func UpdateClientConfigRule(c *gin.Context, dbConnection *database.DatabaseConnection, cache *clientconfig.Cache) {
+	rule, ok := loadClientConfigRule(c, dbConnection)
+	if !ok || !applyClientConfigRule(c, rule) {
+		return
+	}
+	rule.UpdatedByID = CurrentUserID(c)
+
+	if err := dbConnection.DB.WithContext(c.Request.Context()).Save(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update client config rule",
+		})
+		return
+	}
+	cache.Invalidate()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"rule":   rule,
+	})
+}
+
+// DeleteClientConfigRule removes a rule.
+func DeleteClientConfigRule(c *gin.Context, dbConnection *database.DatabaseConnection, cache *clientconfig.Cache) {
+	rule, ok := loadClientConfigRule(c, dbConnection)
+	if !ok {
+		return
+	}
+
+	if err := dbConnection.DB.WithContext(c.Request.Context()).Delete(rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to delete client config rule",
+		})
+		return
+	}
+	cache.Invalidate()
+
+	c.Status(http.StatusNoContent)
+}
+
+func loadClientConfigRule(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.ClientConfigRule, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid rule id",
+		})
+		return nil, false
+	}
+
+	var rule models.ClientConfigRule
+	if err := dbConnection.DB.WithContext(c.Request.Context()).First(&rule, "id = ?", id).Error; err != nil {
+		status, message := http.StatusInternalServerError, "failed to load client config rule"
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status, message = http.StatusNotFound, "client config rule not found"
+		}
+		c.JSON(status, gin.H{
+			"status": "error",
+			"error":  message,
+		})
+		return nil, false
+	}
+	return &rule, true
+}
+
+// applyClientConfigRule binds and validates the request onto rule, writing
+// the error response itself when it returns false.
+func applyClientConfigRule(c *gin.Context, rule *models.ClientConfigRule) bool {
+	var req clientConfigRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return false
+	}
+
+	if req.Platform != nil {
+		rule.Platform = *req.Platform
+	}
+	if req.MinVersion != nil {
+		rule.MinVersion = strings.TrimSpace(*req.MinVersion)
+	}
+	if req.MaxVersion != nil {
+		rule.MaxVersion = strings.TrimSpace(*req.MaxVersion)
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Note != nil {
+		rule.Note = *req.Note
+	}
+
+	for _, bound := range []string{rule.MinVersion, rule.MaxVersion} {
+		if _, err := clientconfig.ParseVersion(bound); bound != "" && err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "min_version and max_version must look like 1.2.3",
+			})
+			return false
+		}
+	}
+
+	if req.Settings != nil {
+		if err := req.Settings.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return false
+		}
+		raw, err := json.Marshal(req.Settings)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "invalid settings",
+			})
+			return false
+		}
+		rule.Settings = models.JSON(raw)
+	}
+	if len(rule.Settings) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "settings are required",
+		})
+		return false
+	}
+	return true
+}
+
+// ClientVersionMiddleware rejects clients older than the minimum supported
+// version for their platform with 426 Upgrade Required. Thresholds come from
+// the client config rules, so they can be raised without a deploy. Requests
+// without a version header, such as browsers and scripts, pass through.
+func ClientVersionMiddleware(cache *clientconfig.Cache) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		platform, version := clientIdentity(c)
+		if version == nil || c.GetHeader(clientVersionHeader) == "" {
+			c.Next()
+			return
+		}
+
+		rules, err := cache.Rules(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to reload client config rules", "error", err)
+		}
+
+		settings := clientconfig.Resolve(rules, platform, version)
+		if !settings.Unsupported(*version) {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusUpgradeRequired, gin.H{
+			"status":                "error",
+			"error":                 "this app version is no longer supported; please update",
+			"code":                  "upgrade_required",
+			"platform":              platform,
+			"version":               version.String(),
+			"min_supported_version": settings.MinSupportedVersion,
+			"upgrade_url":           settings.UpgradeURL,
+		})
+	}
+}
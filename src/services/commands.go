@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/commands"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// eventCommandResponse answers a slash command, over the socket it was
+// sent on, with a response only its sender sees.
+const eventCommandResponse = "command.response"
+
+// slashCommands runs the slash commands messages start with. It is nil,
+// running none, until set.
+var slashCommands *commands.Registry
+
+// SetCommands sets the registry of slash commands messages are run by.
+func SetCommands(registry *commands.Registry) {
+	slashCommands = registry
+}
+
+// CommandView describes a slash command for client autocomplete.
+type CommandView struct {
+	Name        string `json:"name"`
+	Usage       string `json:"usage"`
+	Description string `json:"description"`
+}
+
+// commandReply is returned by postMessage, as its error, for a slash
+// command answered privately: nothing was posted, and Response is for the
+// sender alone.
+type commandReply struct {
+	Command  string             `json:"command"`
+	Response *commands.Response `json:"response"`
+}
+
+func (r *commandReply) Error() string {
+	return "/" + r.Command + " was answered privately"
+}
+
+// ListCommands returns the slash commands messages can start with.
+func ListCommands(c *gin.Context) (*Response, *APIError) {
+	views := []CommandView{}
+	if slashCommands != nil {
+		for _, command := range slashCommands.List() {
+			views = append(views, CommandView{Name: command.Name, Usage: command.Usage, Description: command.Description})
+		}
+	}
+	return &Response{Data: views, Legacy: gin.H{"commands": views}}, nil
+}
+
+// runCommand runs the slash command a text message from a person starts
+// with. A public response replaces the text of the message; a private one
+// comes back as a *commandReply error, and nothing is posted. Messages
+// naming no registered command are posted as they are, so the outgoing
+// webhooks of bots triggered by them can answer, and those escaping their
+// leading slash as "//" are posted with one slash.
+func runCommand(ctx context.Context, dbConnection *database.DatabaseConnection, senderID, conversationID uuid.UUID, input *messageInput) error {
+	if slashCommands == nil || (input.Type != "" && input.Type != content.TypeText) || isAutomatedClientID(input.ClientID) {
+		return nil
+	}
+	unescaped, escaped := commands.Unescape(input.Text)
+	name, _, ok := commands.Parse(input.Text)
+	if !ok && !escaped {
+		return nil
+	}
+	isBot, err := repositories.NewBotRepository(dbConnection.DB).IsBot(ctx, senderID)
+	if err != nil || isBot {
+		return err
+	}
+	if escaped {
+		input.Text = unescaped
+		return nil
+	}
+
+	response, err := slashCommands.Dispatch(ctx, input.Text, senderID, conversationID)
+	if errors.Is(err, commands.ErrUnknownCommand) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if response == nil || response.Ephemeral {
+		return &commandReply{Command: name, Response: response}
+	}
+	input.Text = response.Text
+	return nil
+}
+
+// isAutomatedClientID reports whether a message was posted automatically
+// on someone's behalf, as FAQ answers and auto-replies are.
+func isAutomatedClientID(clientID string) bool {
+	return strings.HasPrefix(clientID, faqClientIDPrefix) || strings.HasPrefix(clientID, autoReplyClientIDPrefix)
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxConsentHistory bounds the consent events returned with consents.
+const maxConsentHistory = 50
+
+// Consents are what the user agreed to, with when they gave or withdrew
+// each consent, newest first.
+type Consents struct {
+	Analytics        bool                  `json:"analytics"`
+	MarketingEmails  bool                  `json:"marketing_emails"`
+	ContactDiscovery bool                  `json:"contact_discovery"`
+	History          []models.ConsentEvent `json:"history"`
+}
+
+type updateConsentsRequest struct {
+	Analytics        *bool `json:"analytics"`
+	MarketingEmails  *bool `json:"marketing_emails"`
+	ContactDiscovery *bool `json:"contact_discovery"`
+}
+
+// GetConsents returns what the current user consented to.
+func GetConsents(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		consents, err := loadConsents(c.Request.Context(), dbConnection, CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load consents", "error", err)
+			return nil, internalError("failed to load consents")
+		}
+		return &Response{Data: consents, Legacy: gin.H{"consents": consents}}, nil
+	}
+}
+
+// UpdateConsents gives or withdraws the consents present in the request
+// and leaves the rest alone. Withdrawn consent takes effect at once:
+// usage not yet published is dropped, marketing emails stop and the
+// account can no longer be found by phone number.
+func UpdateConsents(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req updateConsentsRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		changes := make(map[string]bool)
+		if req.Analytics != nil {
+			changes[models.ConsentAnalytics] = *req.Analytics
+		}
+		if req.MarketingEmails != nil {
+			changes[models.ConsentMarketingEmails] = *req.MarketingEmails
+		}
+		if req.ContactDiscovery != nil {
+			changes[models.ConsentContactDiscovery] = *req.ContactDiscovery
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		if err := changeConsents(ctx, dbConnection, userID, changes); err != nil {
+			slog.ErrorContext(ctx, "Failed to update consents", "error", err)
+			return nil, internalError("failed to update consents")
+		}
+		consents, err := loadConsents(ctx, dbConnection, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load consents", "error", err)
+			return nil, internalError("failed to update consents")
+		}
+		return &Response{Data: consents, Legacy: gin.H{"consents": consents}}, nil
+	}
+}
+
+// changeConsents gives or withdraws a user's consents, recording each
+// change, and makes them take effect.
+func changeConsents(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID, changes map[string]bool) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	events, err := repositories.NewConsentRepository(dbConnection.DB).Update(ctx, userID, changes)
+	if err != nil || len(events) == 0 {
+		return err
+	}
+	invalidateProfile(ctx, userID)
+	for _, event := range events {
+		if event.Purpose == models.ConsentAnalytics {
+			// Consent is looked up again, and usage not yet published is
+			// dropped if it was withdrawn.
+			usageSampler.Forget(userID)
+		}
+	}
+	return nil
+}
+
+func loadConsents(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID) (*Consents, error) {
+	var user models.User
+	if err := dbConnection.DB.WithContext(ctx).First(&user, "id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	history, err := repositories.NewConsentRepository(dbConnection.DB).History(ctx, userID, maxConsentHistory)
+	if err != nil {
+		return nil, err
+	}
+	return &Consents{
+		Analytics:        user.AnalyticsOptIn,
+		MarketingEmails:  user.MarketingEmails,
+		ContactDiscovery: user.PhoneDiscoverable,
+		History:          history,
+	}, nil
+}
+
+// RequireMarketingConsent wraps a mailer so marketing emails reach only
+// the accounts that agreed to them. Other emails pass straight through.
+func RequireMarketingConsent(mail mailer.Mailer, dbConnection *database.DatabaseConnection) mailer.Mailer {
+	return consentMailer{mail: mail, consents: repositories.NewConsentRepository(dbConnection.DB)}
+}
+
+type consentMailer struct {
+	mail     mailer.Mailer
+	consents *repositories.ConsentRepository
+}
+
+func (m consentMailer) Send(ctx context.Context, message mailer.Message) error {
+	if !message.Marketing {
+		return m.mail.Send(ctx, message)
+	}
+	allowed, err := m.consents.MarketingAllowed(ctx, message.To)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		slog.DebugContext(ctx, "Dropped marketing email without consent", "subject", message.Subject)
+		return nil
+	}
+	message.Body += "\n\nYou are receiving this because you agreed to product news from AfroChat. To stop, turn off marketing emails in your privacy settings."
+	return m.mail.Send(ctx, message)
+}
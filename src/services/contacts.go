@@ -0,0 +1,448 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/phone"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	maxContactsPage = 100
+
+	eventContactRequest  = "contact.request"
+	eventContactAccepted = "contact.accepted"
+)
+
+// ContactView is a contact or friend request as one of its two users sees
+// it: User is the other user, and Outgoing is true for requests the viewer
+// sent.
+type ContactView struct {
+	ID         uuid.UUID     `json:"id"`
+	Status     string        `json:"status"`
+	User       PublicProfile `json:"user"`
+	Outgoing   bool          `json:"outgoing"`
+	CreatedAt  time.Time     `json:"created_at"`
+	AcceptedAt *time.Time    `json:"accepted_at"`
+}
+
+// newContactView needs both users of the contact loaded.
+func newContactView(contact *models.Contact, viewerID uuid.UUID) ContactView {
+	other := &contact.Addressee
+	if contact.AddresseeID == viewerID {
+		other = &contact.Requester
+	}
+	return ContactView{
+		ID:         contact.ID,
+		Status:     contact.Status,
+		User:       NewPublicProfile(other),
+		Outgoing:   contact.RequesterID == viewerID,
+		CreatedAt:  contact.CreatedAt,
+		AcceptedAt: contact.AcceptedAt,
+	}
+}
+
+type userIDRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+}
+
+// SendContactRequest sends a friend request to another user. If that user
+// had already asked the current user, the two become contacts at once.
+func SendContactRequest(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req userIDRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		if req.UserID == userID {
+			return nil, badRequest("user_id must be another user")
+		}
+
+		ctx := c.Request.Context()
+		if err := checkRecipients(ctx, dbConnection, CurrentWorkspaceID(c), []uuid.UUID{req.UserID}); err != nil {
+			if errors.Is(err, errRecipientNotFound) {
+				return nil, notFound("user not found")
+			}
+			slog.ErrorContext(ctx, "Failed to check friend request recipient", "error", err)
+			return nil, internalError("failed to send friend request")
+		}
+
+		contacts := repositories.NewContactRepository(dbConnection.DB)
+		contact, changed, err := contacts.Request(ctx, userID, req.UserID)
+		if errors.Is(err, repositories.ErrBlocked) {
+			return nil, forbidden("you cannot add this user")
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("a friend request between you was sent at the same time; try again")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to send friend request", "error", err)
+			return nil, internalError("failed to send friend request")
+		}
+
+		view, apiErr := loadContactView(c, dbConnection, contact)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		status := http.StatusOK
+		if changed {
+			if contact.Status == models.ContactAccepted {
+				notifyContact(hub, contact, req.UserID, eventContactAccepted)
+				notifyContactInbox(c, dbConnection, hub, req.UserID, models.InboxContactAccepted)
+			} else {
+				status = http.StatusCreated
+				notifyContact(hub, contact, req.UserID, eventContactRequest)
+				notifyContactInbox(c, dbConnection, hub, req.UserID, models.InboxContactRequest)
+			}
+		}
+		return &Response{Status: status, Data: view, Legacy: gin.H{"contact": view}}, nil
+	}
+}
+
+// ListContactRequests returns a page of pending friend requests sent to the
+// current user, or with ?direction=outgoing those the user sent, newest
+// first.
+func ListContactRequests(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		direction := c.DefaultQuery("direction", "incoming")
+		if direction != "incoming" && direction != "outgoing" {
+			return nil, badRequest("direction must be incoming or outgoing")
+		}
+		after, limit, apiErr := contactsPage(c)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		userID := CurrentUserID(c)
+		requests, err := repositories.NewContactRepository(dbConnection.DB).
+			ListRequests(c.Request.Context(), userID, direction == "outgoing", after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list friend requests", "error", err)
+			return nil, internalError("failed to list friend requests")
+		}
+		return contactsResponse(requests, userID, limit, "requests", repositories.RequestCursor), nil
+	}
+}
+
+// AcceptContactRequest accepts a friend request sent to the current user.
+func AcceptContactRequest(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid request id")
+		}
+
+		contact, err := repositories.NewContactRepository(dbConnection.DB).Accept(c.Request.Context(), id, CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("friend request not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to accept friend request", "friend_request_id", id, "error", err)
+			return nil, internalError("failed to accept friend request")
+		}
+
+		view, apiErr := loadContactView(c, dbConnection, contact)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		notifyContact(hub, contact, contact.RequesterID, eventContactAccepted)
+		notifyContactInbox(c, dbConnection, hub, contact.RequesterID, models.InboxContactAccepted)
+		return &Response{Data: view, Legacy: gin.H{"contact": view}}, nil
+	}
+}
+
+// RejectContactRequest declines a friend request sent to the current user.
+// The sender is not told.
+func RejectContactRequest(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		return removeContactRequest(c, dbConnection, (*repositories.ContactRepository).Reject)
+	}
+}
+
+// CancelContactRequest withdraws a friend request the current user sent.
+func CancelContactRequest(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		return removeContactRequest(c, dbConnection, (*repositories.ContactRepository).Cancel)
+	}
+}
+
+func removeContactRequest(c *gin.Context, dbConnection *database.DatabaseConnection,
+	remove func(*repositories.ContactRepository, context.Context, uuid.UUID, uuid.UUID) error) (*Response, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid request id")
+	}
+	err = remove(repositories.NewContactRepository(dbConnection.DB), c.Request.Context(), id, CurrentUserID(c))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("friend request not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to remove friend request", "friend_request_id", id, "error", err)
+		return nil, internalError("failed to remove friend request")
+	}
+	return &Response{Status: http.StatusNoContent}, nil
+}
+
+// ListContacts returns a page of the current user's contacts, most
+// recently added first.
+func ListContacts(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, limit, apiErr := contactsPage(c)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		userID := CurrentUserID(c)
+		contacts, err := repositories.NewContactRepository(dbConnection.DB).ListContacts(c.Request.Context(), userID, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list contacts", "error", err)
+			return nil, internalError("failed to list contacts")
+		}
+		return contactsResponse(contacts, userID, limit, "contacts", repositories.ContactCursor), nil
+	}
+}
+
+// discoverContactsRequest takes at most about an address book of numbers.
+type discoverContactsRequest struct {
+	PhoneNumbers []string `json:"phone_numbers" binding:"required,min=1,max=500,dive,max=32"`
+
+	// Region is the country numbers without a country code are read in,
+	// instead of the account's.
+	Region string `json:"region" binding:"omitempty,len=2,alpha"`
+}
+
+// DiscoveredContact is a user found by a phone number from the address
+// book, written as it was sent.
+type DiscoveredContact struct {
+	PhoneNumber string        `json:"phone_number"`
+	User        PublicProfile `json:"user"`
+}
+
+// DiscoverContacts finds the users among the phone numbers of the current
+// user's address book, comparing them in E.164 so they match however they
+// were written. Only users who let themselves be found by phone number are
+// found; numbers that cannot be read are skipped.
+func DiscoverContacts(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req discoverContactsRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		user := CurrentUser(c)
+		region := req.Region
+		if region == "" && user.CountryCode != nil {
+			region = *user.CountryCode
+		}
+
+		written := make(map[string][]string, len(req.PhoneNumbers))
+		numbers := make([]string, 0, len(req.PhoneNumbers))
+		for _, number := range req.PhoneNumbers {
+			e164, err := phone.Normalize(number, region)
+			if err != nil {
+				continue
+			}
+			if _, seen := written[e164]; !seen {
+				numbers = append(numbers, e164)
+			}
+			written[e164] = append(written[e164], number)
+		}
+
+		ctx := c.Request.Context()
+		users, err := repositories.NewContactRepository(dbConnection.DB).DiscoverByPhone(ctx, user.ID, CurrentWorkspaceID(c), numbers)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to discover contacts", "error", err)
+			return nil, internalError("failed to discover contacts")
+		}
+		found := make([]DiscoveredContact, 0, len(users))
+		for i := range users {
+			for _, number := range written[*users[i].PhoneE164] {
+				found = append(found, DiscoveredContact{PhoneNumber: number, User: NewPublicProfile(&users[i])})
+			}
+		}
+		return &Response{Data: found, Legacy: gin.H{"contacts": found}}, nil
+	}
+}
+
+// RemoveContact removes another user from the current user's contacts,
+// for both of them.
+func RemoveContact(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		otherID, err := uuid.Parse(c.Param("user_id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		err = repositories.NewContactRepository(dbConnection.DB).Remove(c.Request.Context(), CurrentUserID(c), otherID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("contact not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to remove contact", "error", err)
+			return nil, internalError("failed to remove contact")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// BlockedUserView is a user the current user has blocked.
+type BlockedUserView struct {
+	User      PublicProfile `json:"user"`
+	BlockedAt time.Time     `json:"blocked_at"`
+}
+
+// BlockUser blocks another user: neither can message the other directly,
+// send the other friend requests or see the other's presence, and any
+// contact between them ends. The blocked user is not told.
+func BlockUser(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req userIDRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		if req.UserID == userID {
+			return nil, badRequest("user_id must be another user")
+		}
+
+		ctx := c.Request.Context()
+		var count int64
+		if err := dbConnection.DB.WithContext(ctx).Model(&models.User{}).Where("id = ?", req.UserID).Count(&count).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load user", "user_id", req.UserID, "error", err)
+			return nil, internalError("failed to block user")
+		}
+		if count == 0 {
+			return nil, notFound("user not found")
+		}
+
+		if err := repositories.NewBlockRepository(dbConnection.DB).Block(ctx, userID, req.UserID); err != nil {
+			slog.ErrorContext(ctx, "Failed to block user", "error", err)
+			return nil, internalError("failed to block user")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListBlockedUsers returns the users the current user has blocked.
+func ListBlockedUsers(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		blocks, err := repositories.NewBlockRepository(dbConnection.DB).List(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list blocked users", "error", err)
+			return nil, internalError("failed to list blocked users")
+		}
+
+		views := make([]BlockedUserView, 0, len(blocks))
+		for _, block := range blocks {
+			views = append(views, BlockedUserView{User: NewPublicProfile(&block.Blocked), BlockedAt: block.CreatedAt})
+		}
+		return &Response{Data: views, Legacy: gin.H{"blocked": views}}, nil
+	}
+}
+
+// UnblockUser lifts a block. Contacts it ended are not restored.
+func UnblockUser(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		blockedID, err := uuid.Parse(c.Param("user_id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		err = repositories.NewBlockRepository(dbConnection.DB).Unblock(c.Request.Context(), CurrentUserID(c), blockedID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("user is not blocked")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to unblock user", "error", err)
+			return nil, internalError("failed to unblock user")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+func contactsPage(c *gin.Context) (*pagination.Cursor, int, *APIError) {
+	after, err := pagination.Decode(c.Query("cursor"))
+	if err != nil {
+		return nil, 0, badRequest("invalid cursor")
+	}
+	limit, err := pagination.Limit(c.Query("limit"), maxContactsPage, maxContactsPage)
+	if err != nil {
+		return nil, 0, badRequest("limit must be between 1 and 100")
+	}
+	return after, limit, nil
+}
+
+// contactsResponse pages contacts fetched with one extra row, which tells
+// whether another page exists.
+func contactsResponse(contacts []models.Contact, viewerID uuid.UUID, limit int, key string, cursor func(*models.Contact) pagination.Cursor) *Response {
+	page := &Page{}
+	if len(contacts) > limit {
+		contacts = contacts[:limit]
+		page.HasMore = true
+		page.NextCursor = cursor(&contacts[limit-1]).Encode()
+	}
+
+	views := make([]ContactView, 0, len(contacts))
+	for i := range contacts {
+		views = append(views, newContactView(&contacts[i], viewerID))
+	}
+	legacy := gin.H{key: views}
+	if page.HasMore {
+		legacy["next_cursor"] = page.NextCursor
+	}
+	return &Response{Data: views, Page: page, Legacy: legacy}
+}
+
+// loadContactView loads the users of a contact to present it to the
+// current user.
+func loadContactView(c *gin.Context, dbConnection *database.DatabaseConnection, contact *models.Contact) (ContactView, *APIError) {
+	if err := loadContactUsers(c.Request.Context(), dbConnection, contact); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load users of contact", "contact_id", contact.ID, "error", err)
+		return ContactView{}, internalError("failed to load contact")
+	}
+	return newContactView(contact, CurrentUserID(c)), nil
+}
+
+func loadContactUsers(ctx context.Context, dbConnection *database.DatabaseConnection, contact *models.Contact) error {
+	return dbConnection.DB.WithContext(ctx).
+		Preload("Requester").
+		Preload("Addressee").
+		First(contact, "id = ?", contact.ID).Error
+}
+
+// notifyContact sends the contact, as recipientID sees it, to the
+// recipient's devices.
+func notifyContact(hub *realtime.Hub, contact *models.Contact, recipientID uuid.UUID, eventType string) {
+	event, err := realtime.NewEvent(eventType, newContactView(contact, recipientID))
+	if err != nil {
+		slog.Error("Failed to encode event", "event_type", eventType, "error", err)
+		return
+	}
+	hub.SendToUser(recipientID, event)
+}
+
+// notifyContactInbox puts the current user's friend request, or their
+// accepting one, in the other user's notification center.
+func notifyContactInbox(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, recipientID uuid.UUID, kind string) {
+	actor := CurrentUser(c)
+	title := actor.DisplayName + " sent you a friend request"
+	if kind == models.InboxContactAccepted {
+		title = actor.DisplayName + " accepted your friend request"
+	}
+	notifyInbox(c.Request.Context(), dbConnection, hub, models.InboxNotification{
+		UserID:  recipientID,
+		Kind:    kind,
+		Title:   title,
+		Body:    "@" + actor.Username,
+		ActorID: &actor.ID,
+	})
+}
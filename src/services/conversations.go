@@ -0,0 +1,970 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/markdown"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxConversationsPage = 100
+
+// maxRecipients bounds the users one message is sent to at once, as the
+// binding of sendToUsersRequest does over HTTP.
+const maxRecipients = 256
+
+const (
+	defaultMessagesPage = 50
+	maxMessagesPage     = 100
+)
+
+var (
+	errRecipientNotFound = errors.New("recipient not found")
+
+	// errBlocked means the sender and recipient of a direct message have
+	// blocked one another. It does not say which of them did.
+	errBlocked = errors.New("you cannot message this user")
+
+	// errNotModerator means an announcement was sent by a member who is not
+	// an owner or admin of the room.
+	errNotModerator = errors.New("only the owner and admins can post announcements")
+
+	// errMuted means a moderator muted the sender for a while.
+	errMuted = errors.New("you are muted and cannot send messages for now")
+
+	// errGroupTooLarge and errRoomQuotaReached mean a message to several
+	// users would need a new group the sender may not create.
+	errGroupTooLarge    = errors.New("too many recipients")
+	errRoomQuotaReached = errors.New("daily room limit reached")
+)
+
+type createConversationRequest struct {
+	Kind      string      `json:"kind" binding:"required,oneof=direct group"`
+	UserID    uuid.UUID   `json:"user_id"`
+	Title     string      `json:"title" binding:"max=100"`
+	MemberIDs []uuid.UUID `json:"member_ids" binding:"max=256"`
+
+	// Audited makes a group an audit room. It cannot be changed later.
+	Audited bool `json:"audited"`
+}
+
+// CreateConversation opens a direct conversation with another user, or
+// creates a group, which may be an audit room. Opening a direct
+// conversation that already exists returns the existing one.
+func CreateConversation(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req createConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := CurrentUserID(c)
+	workspaceID := CurrentWorkspaceID(c)
+	conversations := repositories.NewConversationRepository(dbConnection.DB)
+
+	if req.Kind == models.ConversationDirect {
+		if req.UserID == uuid.Nil || req.UserID == userID {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "user_id must be another user",
+			})
+			return
+		}
+		if req.Audited {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "only groups can be audit rooms",
+			})
+			return
+		}
+		if err := checkRecipients(ctx, dbConnection, workspaceID, []uuid.UUID{req.UserID}); err != nil {
+			respondRecipientError(c, err)
+			return
+		}
+		if err := checkNotBlocked(ctx, dbConnection, userID, req.UserID); err != nil {
+			respondRecipientError(c, err)
+			return
+		}
+
+		conversation, created, err := conversations.FindOrCreateDirect(ctx, workspaceID, userID, req.UserID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to open direct conversation", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to create conversation",
+			})
+			return
+		}
+
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		c.JSON(status, gin.H{
+			"status":       "success",
+			"conversation": conversation,
+		})
+		return
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "title is required for group conversations",
+		})
+		return
+	}
+	if err := checkRecipients(ctx, dbConnection, workspaceID, req.MemberIDs); err != nil {
+		respondRecipientError(c, err)
+		return
+	}
+	if !checkRoomCap(c, entitlements.Rooms().Group, req.MemberIDs) {
+		return
+	}
+	if !checkRoomTrust(c, dbConnection) {
+		return
+	}
+	quota, ok := checkRoomQuota(c, dbConnection)
+	if !ok {
+		return
+	}
+
+	conversation, err := conversations.CreateGroup(ctx, workspaceID, userID, title, req.MemberIDs, req.Audited)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create group conversation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to create conversation",
+		})
+		return
+	}
+	quota.Used++
+	setQuotaHeaders(c, "Rooms", quota)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":       "success",
+		"conversation": conversation,
+	})
+}
+
+// ListConversations returns a page of the current user's conversations,
+// most recently active first. The first page starts with the conversations
+// the user pinned, in their order and marked pinned, which the later pages
+// leave out. ?label= keeps those with one of the labels of the user's
+// business. Pass the returned next_cursor as ?cursor= to continue.
+func ListConversations(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxConversationsPage, maxConversationsPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+		filter := repositories.ConversationFilter{Unpinned: true}
+		if c.Query("label") != "" {
+			profile, apiErr := currentBusiness(c, dbConnection)
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			if filter.LabelID, apiErr = labelFilter(c, dbConnection, profile); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+
+		// Fetch one extra row to learn whether another page exists.
+		ctx := c.Request.Context()
+		conversationRepo := repositories.NewConversationRepository(dbConnection.DB)
+		conversations, err := conversationRepo.ListForUser(ctx, CurrentWorkspaceID(c), CurrentUserID(c), filter, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list conversations", "error", err)
+			return nil, internalError("failed to list conversations")
+		}
+
+		page := &Page{}
+		if len(conversations) > limit {
+			conversations = conversations[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.ActivityCursor(&conversations[limit-1]).Encode()
+		}
+		if after == nil {
+			pinned, err := conversationRepo.PinnedForUser(ctx, CurrentWorkspaceID(c), CurrentUserID(c), filter)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to list pinned conversations", "error", err)
+				return nil, internalError("failed to list conversations")
+			}
+			conversations = append(pinned, conversations...)
+		}
+
+		legacy := gin.H{"conversations": conversations}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: conversations, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// ListConversationSummaries returns a page of the current user's inbox:
+// what the conversation list shows of each conversation, read in one
+// query from the summaries kept up to date as conversations change. The
+// first page starts with the pinned conversations, which the later pages
+// leave out. Pass the returned next_cursor as ?cursor= to continue.
+func ListConversationSummaries(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxConversationsPage, maxConversationsPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		ctx := c.Request.Context()
+		summaries, hasMore, err := repositories.NewConversationSummaryRepository(dbConnection.DB).
+			Inbox(ctx, CurrentWorkspaceID(c), CurrentUserID(c), after, limit, maxPinnedConversations)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list conversation summaries", "error", err)
+			return nil, internalError("failed to list conversations")
+		}
+
+		page := &Page{HasMore: hasMore}
+		legacy := gin.H{"conversations": summaries}
+		if hasMore {
+			page.NextCursor = repositories.SummaryCursor(&summaries[len(summaries)-1]).Encode()
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: summaries, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetConversation returns a conversation the current user belongs to.
+func GetConversation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return &Response{Data: conversation, Legacy: gin.H{"conversation": conversation}}, nil
+	}
+}
+
+// ListMessages returns a page of a conversation's history, oldest first.
+// Without a cursor it returns the newest messages. ?before=<message id>
+// pages back through older history and ?after=<message id> catches up on
+// newer messages; the returned next_cursor continues in the same direction.
+func ListMessages(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		before, after := c.Query("before"), c.Query("after")
+		if before != "" && after != "" {
+			return nil, badRequest("pass either before or after, not both")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultMessagesPage, maxMessagesPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		ctx := c.Request.Context()
+		messages := repositories.NewMessageRepository(dbConnection.DB)
+		anchorID := before
+		if after != "" {
+			anchorID = after
+		}
+		var anchor *pagination.Cursor
+		if anchorID != "" {
+			id, err := uuid.Parse(anchorID)
+			if err != nil {
+				return nil, badRequest("invalid message id")
+			}
+			if anchor, err = messages.CursorAt(ctx, conversation.ID, id); err != nil {
+				if errors.Is(err, repositories.ErrNotFound) {
+					return nil, badRequest("message not found in this conversation")
+				}
+				slog.ErrorContext(ctx, "Failed to load message cursor", "error", err)
+				return nil, internalError("failed to list messages")
+			}
+		}
+
+		// Fetch one extra row to learn whether another page exists.
+		var history []models.Message
+		if after != "" {
+			history, err = messages.ListAfter(ctx, conversation.ID, *anchor, limit+1)
+		} else {
+			history, err = messages.ListBefore(ctx, conversation.ID, anchor, limit+1)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list messages", "error", err)
+			return nil, internalError("failed to list messages")
+		}
+		hideShadowed(history, CurrentUserID(c))
+
+		page := &Page{}
+		if len(history) > limit {
+			history = history[:limit]
+			page.HasMore = true
+			page.NextCursor = history[limit-1].ID.String()
+		}
+		if after == "" {
+			slices.Reverse(history)
+		}
+
+		legacy := gin.H{"messages": history}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: history, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// ListMessageRange returns the messages of a conversation numbered
+// ?from=<seq> through ?to=<seq>, in sequence order, for clients filling a
+// gap they found in message seq numbers. Ranges longer than a page return
+// the first page; next_cursor is the seq to continue from.
+func ListMessageRange(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		from, err := strconv.ParseInt(c.Query("from"), 10, 64)
+		if err != nil || from < 1 {
+			return nil, badRequest("from must be a positive sequence number")
+		}
+		to, err := strconv.ParseInt(c.Query("to"), 10, 64)
+		if err != nil || to < from {
+			return nil, badRequest("to must be a sequence number no less than from")
+		}
+
+		ctx := c.Request.Context()
+		history, err := repositories.NewMessageRepository(dbConnection.DB).ListSeqRange(ctx, conversation.ID, from, to, maxMessagesPage)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list messages", "error", err)
+			return nil, internalError("failed to list messages")
+		}
+		hideShadowed(history, CurrentUserID(c))
+
+		page := &Page{}
+		if len(history) == maxMessagesPage {
+			if last := history[len(history)-1].Seq; last < to {
+				page.HasMore = true
+				page.NextCursor = strconv.FormatInt(last+1, 10)
+			}
+		}
+		legacy := gin.H{"messages": history}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: history, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// SendMessage posts a message to a conversation over HTTP and delivers it to
+// the members' open sockets, exactly as the "message.send" event does.
+func SendMessage(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) {
+	conversation, ok := loadMemberConversation(c, dbConnection)
+	if !ok {
+		return
+	}
+
+	var input messageInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid message payload",
+		})
+		return
+	}
+
+	message, created, err := postMessage(c.Request.Context(), dbConnection, hub, notifier, suggester, index, CurrentUserID(c), conversation.ID, input)
+	var answered *commandReply
+	if errors.As(err, &answered) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"command": answered,
+		})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errBlocked) || errors.Is(err, errNotModerator) || errors.Is(err, errMuted) || errors.Is(err, trust.ErrInsufficientTrust) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, content.ErrInvalidContent) {
+			response := gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			}
+			if details := contentErrorDetails(err); details != nil {
+				response["details"] = details
+			}
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
+		slog.ErrorContext(c.Request.Context(), "Failed to send message", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to send message",
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"status":  "success",
+		"message": message,
+	})
+}
+
+type sendToUsersRequest struct {
+	messageInput
+	RecipientIDs []uuid.UUID `json:"recipient_ids" binding:"required,min=1,max=256"`
+}
+
+// SendMessageToUsers posts one message to several users at once, in their
+// conversation with the sender: the direct one for a single recipient, or
+// the group of exactly these users, created untitled on first contact.
+// The response carries the conversation alongside the message.
+func SendMessageToUsers(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) {
+	var req sendToUsersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid message payload",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := CurrentUserID(c)
+	conversation, _, err := openConversationWith(ctx, dbConnection, CurrentWorkspaceID(c), userID, req.RecipientIDs)
+	if err != nil {
+		respondRecipientError(c, err)
+		return
+	}
+
+	message, created, err := postMessage(ctx, dbConnection, hub, notifier, suggester, index, userID, conversation.ID, req.messageInput)
+	var answered *commandReply
+	if errors.As(err, &answered) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"command": answered,
+		})
+		return
+	}
+	if err != nil {
+		if errors.Is(err, errBlocked) || errors.Is(err, errNotModerator) || errors.Is(err, errMuted) || errors.Is(err, trust.ErrInsufficientTrust) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, content.ErrInvalidContent) {
+			response := gin.H{
+				"status": "error",
+				"error":  err.Error(),
+			}
+			if details := contentErrorDetails(err); details != nil {
+				response["details"] = details
+			}
+			c.JSON(http.StatusBadRequest, response)
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to send message",
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"status":       "success",
+		"conversation": conversation,
+		"message":      message,
+	})
+}
+
+// loadMemberConversation loads the conversation named by the :id parameter,
+// answering 404 when it does not exist or the current user is not a member.
+func loadMemberConversation(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, bool) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		c.JSON(apiErr.Status, gin.H{
+			"status": "error",
+			"error":  apiErr.Message,
+		})
+		return nil, false
+	}
+	return conversation, true
+}
+
+func memberConversation(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid conversation id")
+	}
+
+	conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(c.Request.Context(), id)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(c.Request.Context(), "Failed to load conversation", "conversation_id", id, "error", err)
+		return nil, internalError("failed to load conversation")
+	}
+
+	if conversation != nil && conversation.WorkspaceID == CurrentWorkspaceID(c) && hasMember(conversation, CurrentUserID(c)) {
+		return conversation, nil
+	}
+	if conversation == nil {
+		if redirect := mergedRoomRedirect(c, dbConnection, id); redirect != nil {
+			return nil, redirect
+		}
+	}
+
+	// Non-members get the same answer as for a missing conversation.
+	return nil, notFound("conversation not found")
+}
+
+// checkRecipients verifies that every ID belongs to an active, unbanned
+// member of the workspace. Users outside it are reported as not found.
+func checkRecipients(ctx context.Context, dbConnection *database.DatabaseConnection, workspaceID uuid.UUID, ids []uuid.UUID) error {
+	unique := make(map[uuid.UUID]bool, len(ids))
+	for _, id := range ids {
+		unique[id] = true
+	}
+	if len(unique) == 0 {
+		return nil
+	}
+
+	query := dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id IN ? AND is_active = ? AND is_banned = ?", ids, true, false)
+	if workspaceID != models.DefaultWorkspaceID {
+		query = query.Where("id IN (?)", dbConnection.DB.Model(&models.WorkspaceMember{}).
+			Select("user_id").
+			Where("workspace_id = ?", workspaceID))
+	}
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check recipients: %w", err)
+	}
+	if int(count) != len(unique) {
+		return errRecipientNotFound
+	}
+	return nil
+}
+
+// checkNotBlocked returns errBlocked if either user has blocked the other.
+func checkNotBlocked(ctx context.Context, dbConnection *database.DatabaseConnection, userID, otherID uuid.UUID) error {
+	blocked, err := repositories.NewBlockRepository(dbConnection.DB).Between(ctx, userID, otherID)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return errBlocked
+	}
+	return nil
+}
+
+// openConversationWith returns the conversation of the sender with every
+// recipient in a workspace: the direct one for a single recipient, or else
+// the group whose members are exactly the sender and the recipients. When
+// there is no such group, an untitled one owned by the sender is created,
+// counting against their daily room allowance. created reports whether the
+// conversation is new.
+func openConversationWith(ctx context.Context, dbConnection *database.DatabaseConnection, workspaceID, senderID uuid.UUID, recipientIDs []uuid.UUID) (*models.Conversation, bool, error) {
+	recipientIDs = slices.DeleteFunc(uniqueIDs(recipientIDs), func(id uuid.UUID) bool { return id == senderID })
+	if len(recipientIDs) == 0 {
+		return nil, false, errRecipientNotFound
+	}
+	if err := checkRecipients(ctx, dbConnection, workspaceID, append([]uuid.UUID{senderID}, recipientIDs...)); err != nil {
+		return nil, false, err
+	}
+	for _, recipientID := range recipientIDs {
+		if err := checkNotBlocked(ctx, dbConnection, senderID, recipientID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	conversations := repositories.NewConversationRepository(dbConnection.DB)
+	if len(recipientIDs) == 1 {
+		return conversations.FindOrCreateDirect(ctx, workspaceID, senderID, recipientIDs[0])
+	}
+
+	if cap := entitlements.Rooms().Group; cap != 0 && len(recipientIDs)+1 > cap {
+		return nil, false, fmt.Errorf("%w: rooms of this type may have at most %d members", errGroupTooLarge, cap)
+	}
+	members := append([]uuid.UUID{senderID}, recipientIDs...)
+	if conversation, err := conversations.FindGroupWithMembers(ctx, workspaceID, members); err == nil || !errors.Is(err, repositories.ErrNotFound) {
+		return conversation, false, err
+	}
+
+	if err := checkTrust(ctx, dbConnection, senderID, trust.CapabilityCreateRoom); err != nil {
+		return nil, false, err
+	}
+	var sender models.User
+	err := dbConnection.DB.WithContext(ctx).
+		Select("id", "account_type", "is_premium").
+		First(&sender, "id = ?", senderID).Error
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load sender: %w", err)
+	}
+	quota, err := roomQuota(ctx, dbConnection, &sender)
+	if err != nil {
+		return nil, false, err
+	}
+	if quota.Exhausted() {
+		return nil, false, fmt.Errorf("%w: daily limit of %d new groups and channels reached for the %s tier", errRoomQuotaReached, quota.Limit, userTier(&sender))
+	}
+
+	conversation, err := conversations.CreateGroup(ctx, workspaceID, senderID, "", recipientIDs, false)
+	if err != nil {
+		return nil, false, err
+	}
+	return conversation, true, nil
+}
+
+func respondRecipientError(c *gin.Context, err error) {
+	if errors.Is(err, errGroupTooLarge) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errRoomQuotaReached) {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errBlocked) || errors.Is(err, trust.ErrInsufficientTrust) || isAgeGateError(err) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errRecipientNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), "Failed to check recipients", "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"status": "error",
+		"error":  "failed to check recipients",
+	})
+}
+
+// messageInput is the message body accepted over HTTP and WebSocket.
+type messageInput struct {
+	Type          content.Type    `json:"type"`
+	Text          string          `json:"text"`
+	Payload       json.RawMessage `json:"payload"`
+	ClientID      string          `json:"client_id"`
+	AttachmentIDs []uuid.UUID     `json:"attachment_ids"`
+	ViewOnce      bool            `json:"view_once"`
+}
+
+// buildMessage validates the input and produces the message to store. Text
+// messages, within the sender's limits, are parsed as markdown and may be
+// empty when they carry attachments; other types carry a typed payload.
+// View-once messages are text or encrypted messages with attachments.
+func buildMessage(senderID, conversationID uuid.UUID, input messageInput, limits entitlements.ContentLimits) (*models.Message, error) {
+	message := &models.Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Type:           string(content.TypeText),
+		ViewOnce:       input.ViewOnce,
+	}
+	if input.ViewOnce {
+		if len(input.AttachmentIDs) == 0 {
+			return nil, fmt.Errorf("%w: view-once messages need a photo or voice note", content.ErrInvalidContent)
+		}
+		if input.Type != "" && input.Type != content.TypeText && input.Type != content.TypeEncrypted {
+			return nil, fmt.Errorf("%w: only text and encrypted messages can be view-once", content.ErrInvalidContent)
+		}
+	}
+
+	if clientID := strings.TrimSpace(input.ClientID); clientID != "" {
+		if len(clientID) > 64 {
+			return nil, fmt.Errorf("%w: client_id must be at most 64 characters", content.ErrInvalidContent)
+		}
+		message.ClientID = &clientID
+	}
+
+	if input.Type == "" || input.Type == content.TypeText {
+		text, entities, err := formatText(input.Text, len(input.AttachmentIDs) > 0, limits)
+		if err != nil {
+			return nil, err
+		}
+		message.Text = text
+		message.Entities = entities
+		return message, nil
+	}
+
+	payload, err := content.Decode(input.Type, input.Payload)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	message.Type = string(input.Type)
+	message.Payload = models.JSON(raw)
+	return message, nil
+}
+
+// formatText validates the text of a text message against the sender's
+// limits and parses it as markdown. It may be empty when the message
+// carries attachments.
+func formatText(input string, hasAttachments bool, limits entitlements.ContentLimits) (string, models.JSON, error) {
+	text := strings.TrimSpace(input)
+	if text == "" && !hasAttachments {
+		return "", nil, fmt.Errorf("%w: text is required", content.ErrInvalidContent)
+	}
+	if utf8.RuneCountInString(text) > limits.MessageLength {
+		return "", nil, &content.LimitError{Field: "text", Limit: limits.MessageLength, Unit: "characters"}
+	}
+	mentioned := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		mentioned[strings.ToLower(match[1])] = true
+	}
+	if len(mentioned) > limits.MentionsPerMessage {
+		return "", nil, &content.LimitError{Field: "text", Limit: limits.MentionsPerMessage, Unit: "mentions"}
+	}
+
+	formatted := markdown.Parse(text)
+	if formatted.Entities == nil {
+		formatted.Entities = []markdown.Entity{}
+	}
+	entities, err := json.Marshal(formatted.Entities)
+	if err != nil {
+		return "", nil, err
+	}
+	return formatted.Text, models.JSON(entities), nil
+}
+
+// contentErrorDetails describes a content limit error as the failed
+// validation rule, like request validation errors; other errors have no
+// details.
+func contentErrorDetails(err error) []FieldError {
+	var limitErr *content.LimitError
+	if !errors.As(err, &limitErr) {
+		return nil
+	}
+	return []FieldError{{Field: limitErr.Field, Rule: "max_" + limitErr.Unit, Param: strconv.Itoa(limitErr.Limit)}}
+}
+
+// silentMessage is a new message as sent to members who muted its
+// conversation or are in their do-not-disturb hours, for apps to show
+// without sound or banners.
+type silentMessage struct {
+	*models.Message
+	Silent bool `json:"silent"`
+}
+
+// postMessage stores a message and delivers it to every member of the
+// conversation, flagged silent for those who asked not to be disturbed,
+// queueing pushes for those with no open connection, reply suggestions for
+// the recipient of a direct message, deliveries to the channel's outgoing
+// webhooks, to its members who prefer email and to the workspace's
+// archive, and runs of the channel's keyword automations, and indexes it
+// for search.
+// Encrypted messages are only accepted in direct conversations, and
+// announcements only from a room's owner and admins, failing with
+// errNotModerator otherwise. A resend with a known client_id returns the
+// stored message without delivering it again. Direct messages between
+// users who have blocked one another fail with errBlocked, and links or
+// media from senders whose trust level does not allow them with
+// trust.ErrInsufficientTrust. Messages starting with a slash command are
+// run by it first, as runCommand describes. Messages from shadow
+// restricted senders, as screenSender decides, are delivered to the
+// sender alone and have none of the other effects, and muted senders fail
+// with errMuted.
+func postMessage(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, senderID, conversationID uuid.UUID, input messageInput) (*models.Message, bool, error) {
+	resent, err := resentMessage(ctx, dbConnection, senderID, input.ClientID)
+	if err != nil || resent != nil {
+		return resent, false, err
+	}
+	blocked, err := repositories.NewBlockRepository(dbConnection.DB).InDirect(ctx, conversationID, senderID)
+	if err != nil {
+		return nil, false, err
+	}
+	if blocked {
+		return nil, false, errBlocked
+	}
+	shadowed, err := screenSender(ctx, dbConnection, senderID, conversationID, input)
+	if err != nil {
+		return nil, false, err
+	}
+	if err := runCommand(ctx, dbConnection, senderID, conversationID, &input); err != nil {
+		return nil, false, err
+	}
+	if input.Type == content.TypeEncrypted || input.Type == content.TypeAnnouncement {
+		conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, conversationID)
+		if err != nil {
+			return nil, false, err
+		}
+		if input.Type == content.TypeEncrypted && conversation.Kind != models.ConversationDirect {
+			return nil, false, fmt.Errorf("%w: only direct messages can be end-to-end encrypted", content.ErrInvalidContent)
+		}
+		if input.Type == content.TypeAnnouncement && !moderatesConversation(conversation, senderID) {
+			return nil, false, errNotModerator
+		}
+	}
+	limits, err := userLimits(ctx, dbConnection, senderID)
+	if err != nil {
+		return nil, false, err
+	}
+	input.AttachmentIDs = uniqueIDs(input.AttachmentIDs)
+	if len(input.AttachmentIDs) > limits.AttachmentsPerMessage {
+		return nil, false, &content.LimitError{Field: "attachment_ids", Limit: limits.AttachmentsPerMessage, Unit: "attachments"}
+	}
+	if err := checkMessageTrust(ctx, dbConnection, senderID, conversationID, input); err != nil {
+		return nil, false, err
+	}
+	message, err := buildMessage(senderID, conversationID, input, limits.ContentLimits)
+	if err != nil {
+		return nil, false, err
+	}
+	message.Shadowed = shadowed
+
+	created, err := repositories.NewMessageRepository(dbConnection.DB).Create(ctx, message, input.AttachmentIDs)
+	if errors.Is(err, repositories.ErrInvalidAttachment) {
+		return nil, false, fmt.Errorf("%w: %v", content.ErrInvalidContent, err)
+	}
+	if err != nil {
+		return message, false, err
+	}
+	recentSends.remember(message)
+	if !created {
+		return message, false, nil
+	}
+	if message.Shadowed {
+		deliverShadowed(ctx, hub, "message.new", message)
+		return message, true, nil
+	}
+	if err := index.Add(ctx, message); err != nil {
+		// The message is stored; it is only missing from search results.
+		slog.ErrorContext(ctx, "Failed to index message", "message_id", message.ID, "error", err)
+	}
+
+	memberIDs, err := repositories.NewConversationRepository(dbConnection.DB).MemberIDs(ctx, conversationID)
+	if err != nil {
+		// The message is stored; members will pick it up from history.
+		slog.ErrorContext(ctx, "Failed to load members of conversation", "conversation_id", conversationID, "error", err)
+		return message, true, nil
+	}
+
+	event, err := realtime.NewEvent("message.new", message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode message", "message_id", message.ID, "error", err)
+		return message, true, nil
+	}
+	silenced, err := silencedMembers(ctx, dbConnection, conversationID, memberIDs, message.CreatedAt)
+	if err != nil {
+		// Deliver to everyone as usual rather than holding the message up.
+		slog.ErrorContext(ctx, "Failed to load mutes of members", "conversation_id", conversationID, "error", err)
+	}
+	loud := make([]uuid.UUID, 0, len(memberIDs))
+	quiet := make([]uuid.UUID, 0, len(silenced))
+	for _, memberID := range memberIDs {
+		if silenced[memberID] {
+			quiet = append(quiet, memberID)
+		} else {
+			loud = append(loud, memberID)
+		}
+	}
+	hub.SendToUsers(loud, event)
+	if len(quiet) > 0 {
+		silentEvent, err := realtime.NewEvent("message.new", silentMessage{Message: message, Silent: true})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encode message", "message_id", message.ID, "error", err)
+			return message, true, nil
+		}
+		hub.SendToUsers(quiet, silentEvent)
+	}
+	recordDispatch(message.CreatedAt)
+	recordMentions(ctx, dbConnection, hub, message, memberIDs)
+	recordUsage(ctx, senderID, featureMessagesSent, 1)
+	recordActivity(ctx, dbConnection, senderID, message.CreatedAt)
+	recordUsage(ctx, senderID, featureAttachmentsSent, len(input.AttachmentIDs))
+
+	// Without a connection registry only connections to this instance are
+	// visible here, so with several instances a member connected elsewhere
+	// may also get a push. Clients drop pushes for messages they already
+	// have.
+	away := make([]uuid.UUID, 0, len(memberIDs))
+	for _, memberID := range memberIDs {
+		if memberID != senderID && notifier.awayFrom(hub, memberID, conversationID) {
+			away = append(away, memberID)
+		}
+	}
+	notifier.Enqueue(ctx, message, away)
+	suggester.Enqueue(ctx, message)
+	queueOutgoingWebhooks(ctx, dbConnection, message)
+	queueEmailRelays(ctx, dbConnection, message)
+	queueKeywordAutomations(ctx, dbConnection, message)
+	queueArchive(ctx, dbConnection, "message.new", message)
+	answerFromFAQ(ctx, dbConnection, hub, notifier, suggester, index, message)
+	answerWithAutoReply(ctx, dbConnection, hub, notifier, suggester, index, message, memberIDs)
+	return message, true, nil
+}
+
+// uniqueIDs drops repeated IDs, keeping the first occurrence of each.
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
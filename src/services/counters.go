@@ -0,0 +1,49 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobReconcileCounters recomputes the member counters and the
+	// conversation summaries conversation lists read, correcting any
+	// drift.
+	JobReconcileCounters     = "reconcile_counters"
+	CounterReconcileInterval = 24 * time.Hour
+
+	// counterReconcileBatch is how many conversations are reconciled in
+	// one transaction.
+	counterReconcileBatch = 500
+)
+
+// ReconcileCounters is the scheduled job recomputing every conversation's
+// counters and summaries in batches, and logging how many had drifted.
+func ReconcileCounters(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		counters := repositories.NewCounterRepository(dbConnection.DB)
+		var total int64
+		after := uuid.Nil
+		for {
+			last, corrected, err := counters.Reconcile(ctx, after, counterReconcileBatch)
+			if err != nil {
+				return err
+			}
+			total += corrected
+			if last == uuid.Nil {
+				break
+			}
+			after = last
+		}
+		if total > 0 {
+			slog.WarnContext(ctx, "Corrected drifted counters", "counters", total)
+		}
+		return nil
+	}
+}
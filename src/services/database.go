@@ -1,39 +1,53 @@
 package services
 
 import (
-	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
+	"strings"
 
 	"github.com/dfunani/AfroChat/backend/pkg/config"
 	"github.com/dfunani/AfroChat/backend/pkg/database"
-	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/gin-gonic/gin"
 )
 
 func CreateDatabaseClient(appConfig *config.ApplicationConfig) (*database.DatabaseConnection, error) {
 	dbConfig := &database.DatabaseConfig{
+		Driver:   appConfig.DBDriver,
+		Path:     appConfig.DBPath,
 		Host:     appConfig.DBHost,
 		Port:     appConfig.DBPort,
 		User:     appConfig.DBUser,
 		Password: appConfig.DBPass,
 		DBName:   appConfig.DBName,
 		SSLMode:  appConfig.DBSSL,
+
+		ConnectTimeout: appConfig.DBConnectTimeout,
+		QueryLog: database.QueryLogConfig{
+			Level:         appConfig.DBLogLevel,
+			SlowThreshold: appConfig.DBSlowQueryThreshold,
+			SampleBurst:   appConfig.DBLogSampleBurst,
+		},
 	}
 
 	conn, err := database.NewDatabaseConnection(dbConfig)
 	if err != nil {
 		return nil, err
 	}
-	runMigrations(conn)
-	log.Println("✅ Database connected successfully")
+	slog.Info("✅ Database connected successfully")
 	return conn, nil
 }
 
-func runMigrations(dbConnection *database.DatabaseConnection) error {
-	log.Println("Running migrations...")
-	err := dbConnection.DB.AutoMigrate(&models.User{})
-	if err != nil {
-		return fmt.Errorf("failed to run migrations: %w", err)
+// DatabaseAvailable answers 503 while the database is down, rather than
+// letting requests fail one by one. Health and readiness probes are left
+// alone so they can report the outage.
+func DatabaseAvailable(dbConnection *database.DatabaseConnection, retryAfter int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if dbConnection.Available() || strings.HasPrefix(path, "/api/v1/health") || path == "/livez" || path == "/readyz" {
+			c.Next()
+			return
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+		abortWithError(c, serviceUnavailable("the service is temporarily unavailable; try again shortly"))
 	}
-	log.Println("✅ Migrations ran successfully")
-	return nil
 }
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/deeplink"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// LinkExternal is the kind of a resolved short link leading outside the
+// app.
+const LinkExternal = "external"
+
+// ResolvedLink is what a share link leads to, for the apps to open it in
+// place. Kind says which of the other fields are set: the conversation
+// and message for a message, the conversation and, for channels, the
+// channel for a room, and the user for a profile. URL is the link's
+// canonical address, or the destination of an external link.
+type ResolvedLink struct {
+	Kind         string               `json:"kind"`
+	URL          string               `json:"url"`
+	Conversation *models.Conversation `json:"conversation,omitempty"`
+	Channel      *models.Channel      `json:"channel,omitempty"`
+	Message      *models.Message      `json:"message,omitempty"`
+	User         *PublicProfile       `json:"user,omitempty"`
+
+	// IsMember says whether the current user is in the room already, or
+	// has to join it first.
+	IsMember *bool `json:"is_member,omitempty"`
+}
+
+// DeepLinks resolves the share links of messages, rooms and profiles,
+// including short links to them.
+type DeepLinks struct {
+	db         *database.DatabaseConnection
+	base       *deeplink.Base
+	shortLinks *ShortLinks
+}
+
+func NewDeepLinks(dbConnection *database.DatabaseConnection, base *deeplink.Base, shortLinks *ShortLinks) *DeepLinks {
+	return &DeepLinks{db: dbConnection, base: base, shortLinks: shortLinks}
+}
+
+// ResolveLink turns the link in the url query parameter into what it leads
+// to, checking the current user may see it. Links to conversations they
+// are not in, private channels and users they cannot see answer 404, as
+// missing ones do; expired and disabled short links answer 410.
+func ResolveLink(links *DeepLinks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		raw := strings.TrimSpace(c.Query("url"))
+		if raw == "" {
+			return nil, badRequest("url is required")
+		}
+		resolved, apiErr := links.resolve(c.Request.Context(), CurrentUserID(c), raw, true)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		return &Response{Data: resolved, Legacy: gin.H{"link": resolved}}, nil
+	}
+}
+
+// resolve resolves raw for userID. Short links are followed only when
+// followShort is set, so one cannot lead to another.
+func (l *DeepLinks) resolve(ctx context.Context, userID uuid.UUID, raw string, followShort bool) (*ResolvedLink, *APIError) {
+	if code, ok := strings.CutPrefix(raw, l.shortLinks.baseURL+"/"); ok && followShort {
+		return l.resolveShort(ctx, userID, code)
+	}
+
+	link, err := l.base.Parse(raw)
+	if err != nil {
+		return nil, badRequest("not an AfroChat link")
+	}
+	var resolved *ResolvedLink
+	var apiErr *APIError
+	switch link.Kind {
+	case deeplink.KindMessage:
+		resolved, apiErr = l.resolveMessage(ctx, userID, link)
+	case deeplink.KindRoom:
+		resolved, apiErr = l.resolveRoom(ctx, userID, link)
+	default:
+		resolved, apiErr = l.resolveProfile(ctx, userID, link)
+	}
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	resolved.URL = l.base.String(link)
+	return resolved, nil
+}
+
+func (l *DeepLinks) resolveShort(ctx context.Context, userID uuid.UUID, code string) (*ResolvedLink, *APIError) {
+	if !shortlink.ValidCode(code) {
+		return nil, notFound("link not found")
+	}
+	link, err := repositories.NewShortLinkRepository(l.db.DB).Resolve(ctx, code)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("link not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load short link", "error", err)
+		return nil, internalError("failed to resolve link")
+	}
+	if link.DisabledAt != nil || (link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now())) {
+		return nil, gone("this link is no longer available")
+	}
+
+	if _, err := l.base.Parse(link.Destination); err == nil {
+		resolved, apiErr := l.resolve(ctx, userID, link.Destination, false)
+		if apiErr == nil && resolved.Channel != nil && !*resolved.IsMember {
+			// Short links are what gets shared outside the app, so
+			// joining through one may be challenged.
+			err := repositories.NewChannelRepository(l.db.DB).RecordLinkVisit(ctx, resolved.Channel.ConversationID, userID, time.Now())
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to record channel link visit", "channel_id", resolved.Channel.ConversationID, "error", err)
+			}
+		}
+		return resolved, apiErr
+	}
+	// Other destinations are scanned again, as when the link is followed.
+	destination, err := l.shortLinks.scanner.Scan(link.Destination)
+	if err != nil {
+		return nil, gone("this link is no longer available")
+	}
+	return &ResolvedLink{Kind: LinkExternal, URL: destination}, nil
+}
+
+func (l *DeepLinks) resolveMessage(ctx context.Context, userID uuid.UUID, link deeplink.Link) (*ResolvedLink, *APIError) {
+	conversation, apiErr := l.memberConversation(ctx, userID, link.ConversationID)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	found, err := repositories.NewMessageRepository(l.db.DB).ListSeqRange(ctx, conversation.ID, link.Seq, link.Seq, 1)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load linked message", "conversation_id", conversation.ID, "seq", link.Seq, "error", err)
+		return nil, internalError("failed to resolve link")
+	}
+	if len(found) == 0 {
+		return nil, notFound("message not found")
+	}
+	hideShadowedMessage(&found[0], userID)
+	return &ResolvedLink{Kind: deeplink.KindMessage, Conversation: conversation, Message: &found[0]}, nil
+}
+
+// resolveRoom resolves an invitation to a group or channel. Anyone can
+// see a public channel and be told to join it; other rooms are visible to
+// their members only.
+func (l *DeepLinks) resolveRoom(ctx context.Context, userID uuid.UUID, link deeplink.Link) (*ResolvedLink, *APIError) {
+	conversation, err := repositories.NewConversationRepository(l.db.DB).Get(ctx, link.ConversationID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to load linked conversation", "conversation_id", link.ConversationID, "error", err)
+		return nil, internalError("failed to resolve link")
+	}
+	if conversation == nil || conversation.Kind == models.ConversationDirect {
+		return nil, notFound("room not found")
+	}
+	isMember := hasMember(conversation, userID)
+	if !isMember {
+		// Rooms are only ever shown to members of their workspace.
+		inWorkspace, err := workspaceHas(ctx, l.db, conversation.WorkspaceID, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check workspace membership", "workspace_id", conversation.WorkspaceID, "error", err)
+			return nil, internalError("failed to resolve link")
+		}
+		if !inWorkspace {
+			return nil, notFound("room not found")
+		}
+	}
+	resolved := &ResolvedLink{Kind: deeplink.KindRoom, Conversation: conversation, IsMember: &isMember}
+
+	if conversation.Kind == models.ConversationChannel {
+		channel, err := repositories.NewChannelRepository(l.db.DB).Get(ctx, conversation.ID)
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to load linked channel", "channel_id", conversation.ID, "error", err)
+			return nil, internalError("failed to resolve link")
+		}
+		if channel != nil && (!channel.IsPrivate || isMember) {
+			resolved.Channel = channel
+		}
+	}
+	if resolved.Channel == nil && !isMember {
+		return nil, notFound("room not found")
+	}
+	if !isMember {
+		// Outsiders see the channel, not who is in it.
+		conversation.Members = nil
+	}
+	return resolved, nil
+}
+
+// resolveProfile resolves a link to a profile. Banned and deleted users
+// and those blocking or blocked by the current user are reported as not
+// found.
+func (l *DeepLinks) resolveProfile(ctx context.Context, userID uuid.UUID, link deeplink.Link) (*ResolvedLink, *APIError) {
+	var user models.User
+	err := l.db.DB.WithContext(ctx).
+		Where("username = ? AND is_banned = ?", link.Username, false).
+		First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, notFound("user not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load linked user", "error", err)
+		return nil, internalError("failed to resolve link")
+	}
+	if user.ID != userID {
+		if err := checkNotBlocked(ctx, l.db, userID, user.ID); errors.Is(err, errBlocked) {
+			return nil, notFound("user not found")
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Failed to check blocks", "error", err)
+			return nil, internalError("failed to resolve link")
+		}
+	}
+	profile := NewPublicProfile(&user)
+	return &ResolvedLink{Kind: deeplink.KindProfile, User: &profile}, nil
+}
+
+func (l *DeepLinks) memberConversation(ctx context.Context, userID, conversationID uuid.UUID) (*models.Conversation, *APIError) {
+	conversation, err := repositories.NewConversationRepository(l.db.DB).Get(ctx, conversationID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to load linked conversation", "conversation_id", conversationID, "error", err)
+		return nil, internalError("failed to resolve link")
+	}
+	if conversation == nil || !hasMember(conversation, userID) {
+		return nil, notFound("conversation not found")
+	}
+	return conversation, nil
+}
@@ -0,0 +1,55 @@
+package services
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/deprecation"
+	"github.com/gin-gonic/gin"
+)
+
+// DeprecationMiddleware adds Deprecation, Sunset and Link headers to routes
+// marked in the registry and counts their use by client version. The first
+// call from each client version is logged so stragglers are easy to spot.
+func DeprecationMiddleware(registry *deprecation.Registry) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		method, path := c.Request.Method, c.FullPath()
+		notice, ok := registry.Lookup(method, path)
+		if !ok {
+			c.Next()
+			return
+		}
+
+		for name, value := range notice.Headers() {
+			c.Header(name, value)
+		}
+
+		client := clientLabel(c)
+		if registry.Record(method, path, client, time.Now().UTC()) {
+			slog.WarnContext(c.Request.Context(), "Deprecated route called", "method", method, "path", path, "client", client)
+		}
+		c.Next()
+	}
+}
+
+// clientLabel identifies the calling client as "platform/version".
+func clientLabel(c *gin.Context) string {
+	platform, version := clientIdentity(c)
+	if platform == "" {
+		platform = "unknown"
+	}
+	if version == nil {
+		return platform + "/unknown"
+	}
+	return platform + "/" + version.String()
+}
+
+// DeprecationReport lists deprecated routes with their remaining callers on
+// this instance, to show what is still blocking their removal.
+func DeprecationReport(c *gin.Context, registry *deprecation.Registry) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"routes": registry.Report(),
+	})
+}
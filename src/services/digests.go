@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobEmailDigests is the scheduled job emailing digests of unread
+	// messages to the users who take them.
+	JobEmailDigests = "email_digests"
+
+	// DigestInterval is how often each user is sent a digest, if they
+	// missed anything.
+	DigestInterval = time.Hour
+
+	// DigestScanInterval is how often digests falling due are looked for.
+	DigestScanInterval = 5 * time.Minute
+
+	// maxDigestSpan bounds how far back a digest looks, as for a user
+	// who just turned digests back on.
+	maxDigestSpan = 24 * time.Hour
+
+	// digestBatch bounds the digests sent by one run of the job.
+	digestBatch = 500
+
+	// maxDigestConversations bounds the conversations a digest lists.
+	maxDigestConversations = 20
+)
+
+// SendEmailDigests is the scheduled job emailing each user who takes
+// digests, once every DigestInterval, how many messages they have not
+// read in each conversation they have not muted. Digests only give counts,
+// whatever the user's preview mode, and wait out do-not-disturb hours.
+func SendEmailDigests(dbConnection *database.DatabaseConnection, mail mailer.Mailer) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		notifications := repositories.NewNotificationRepository(dbConnection.DB)
+		now := time.Now()
+		due, err := notifications.DigestRecipients(ctx, now.Add(-DigestInterval), digestBatch)
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		userIDs := make([]uuid.UUID, len(due))
+		for i, preferences := range due {
+			userIDs[i] = preferences.UserID
+		}
+		hours, err := notifications.DoNotDisturb(ctx, userIDs)
+		if err != nil {
+			return err
+		}
+		quiet := make(map[uuid.UUID]bool)
+		for _, dnd := range hours {
+			quiet[dnd.UserID] = inDoNotDisturb(dnd.DNDStart, dnd.DNDEnd, dnd.TimeZone, now)
+		}
+
+		sent := 0
+		for _, preferences := range due {
+			if quiet[preferences.UserID] {
+				continue
+			}
+			since := now.Add(-DigestInterval)
+			if preferences.DigestSentAt != nil {
+				since = *preferences.DigestSentAt
+			}
+			since = latest(since, now.Add(-maxDigestSpan))
+			emailed, err := sendDigest(ctx, dbConnection, mail, preferences.UserID, since)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to send email digest", "user_id", preferences.UserID, "error", err)
+				continue
+			}
+			if err := notifications.DigestSent(ctx, preferences.UserID, now); err != nil {
+				return err
+			}
+			if emailed {
+				sent++
+			}
+		}
+		if sent > 0 {
+			slog.InfoContext(ctx, "Sent email digests", "count", sent)
+		}
+		return nil
+	}
+}
+
+// sendDigest emails a user what they missed since, reporting false when
+// there was nothing to send.
+func sendDigest(ctx context.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, userID uuid.UUID, since time.Time) (bool, error) {
+	missed, err := repositories.NewNotificationRepository(dbConnection.DB).MissedActivity(ctx, userID, since)
+	if err != nil || len(missed) == 0 {
+		return false, err
+	}
+	var user models.User
+	if err := dbConnection.DB.WithContext(ctx).First(&user, "id = ?", userID).Error; err != nil {
+		return false, err
+	}
+
+	var total int64
+	lines := make([]string, 0, min(len(missed), maxDigestConversations)+1)
+	for i, conversation := range missed {
+		total += conversation.Messages
+		if i == maxDigestConversations {
+			lines = append(lines, fmt.Sprintf("- and %d more conversations", len(missed)-i))
+			continue
+		}
+		if i > maxDigestConversations {
+			continue
+		}
+		title, err := digestTitle(ctx, dbConnection, userID, conversation.ConversationID)
+		if err != nil {
+			return false, err
+		}
+		lines = append(lines, fmt.Sprintf("- %s: %s", title, plural(conversation.Messages, "new message")))
+	}
+
+	err = mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("You have %s on AfroChat", plural(total, "unread message")),
+		Body: "Here is what you missed:\n\n" + strings.Join(lines, "\n") +
+			"\n\nOpen AfroChat to read and reply. To stop these emails, turn off the email digest in your notification settings.",
+	})
+	if err != nil {
+		notificationEmails.Inc("failed")
+		return false, err
+	}
+	notificationEmails.Inc("sent")
+	return true, nil
+}
+
+// digestTitle names a conversation in a digest: a group by its title and
+// a direct conversation by the other member.
+func digestTitle(ctx context.Context, dbConnection *database.DatabaseConnection, userID, conversationID uuid.UUID) (string, error) {
+	db := dbConnection.DB.WithContext(ctx)
+	var conversation models.Conversation
+	if err := db.First(&conversation, "id = ?", conversationID).Error; err != nil {
+		return "", err
+	}
+	if conversation.Kind != models.ConversationDirect {
+		if conversation.Title != nil && *conversation.Title != "" {
+			return *conversation.Title, nil
+		}
+		return "A group conversation", nil
+	}
+	var names []string
+	err := db.Model(&models.User{}).
+		Joins("JOIN conversation_members ON conversation_members.user_id = users.id AND conversation_members.deleted_at IS NULL").
+		Where("conversation_members.conversation_id = ? AND users.id <> ?", conversationID, userID).
+		Limit(1).
+		Pluck("users.display_name", &names).Error
+	if err != nil {
+		return "", err
+	}
+	if len(names) == 0 || names[0] == "" {
+		return "A direct conversation", nil
+	}
+	return names[0], nil
+}
+
+// plural writes a count of something, as "1 new message" or "3 new
+// messages".
+func plural(count int64, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
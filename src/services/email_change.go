@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailChangeTTL = 24 * time.Hour
+
+	// EmailChangeRevertWindow is how long the old address can undo a
+	// completed change.
+	EmailChangeRevertWindow = 7 * 24 * time.Hour
+
+	// eventEmailChanged tells every session of a user their email changed.
+	eventEmailChanged = "account.email_changed"
+)
+
+type emailChangeRequest struct {
+	Email    string `json:"email" binding:"required,email,max=255"`
+	Password string `json:"password"`
+}
+
+type emailChangeTokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// RequestEmailChange starts moving the current user to a new email
+// address, after confirming their password if they have one. A link is
+// emailed to each address and the change takes effect once both are
+// followed, so neither a stolen session nor a mistyped address can move
+// the account alone. Any change already pending is cancelled.
+func RequestEmailChange(dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req emailChangeRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		user := CurrentUser(c)
+		if user.PasswordHash != "" {
+			ok, err := auth.CheckPassword(req.Password, user.PasswordHash, user.Salt)
+			if err != nil || !ok {
+				return nil, unauthorized("incorrect password")
+			}
+		}
+		email := strings.ToLower(strings.TrimSpace(req.Email))
+		if email == user.Email {
+			return nil, badRequest("that is already your email")
+		}
+
+		ctx := c.Request.Context()
+		var taken int64
+		if err := dbConnection.DB.WithContext(ctx).Unscoped().Model(&models.User{}).Where("email = ?", email).Count(&taken).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to check email", "error", err)
+			return nil, internalError("failed to change email")
+		}
+		if taken > 0 {
+			return nil, conflict("email is already taken")
+		}
+
+		change := models.EmailChange{
+			UserID:    user.ID,
+			OldEmail:  user.Email,
+			NewEmail:  email,
+			ExpiresAt: time.Now().Add(EmailChangeTTL),
+		}
+		if err := repositories.NewEmailChangeRepository(dbConnection.DB).Start(ctx, &change); err != nil {
+			slog.ErrorContext(ctx, "Failed to start email change", "user_id", user.ID, "error", err)
+			return nil, internalError("failed to change email")
+		}
+		if err := sendEmailChangeConfirmations(ctx, dbConnection, mail, appConfig, &change); err != nil {
+			slog.ErrorContext(ctx, "Failed to send email change confirmations", "user_id", user.ID, "error", err)
+			return nil, internalError("failed to send confirmation emails")
+		}
+		return &Response{Status: http.StatusAccepted, Data: change, Legacy: gin.H{"email_change": change}}, nil
+	}
+}
+
+// GetEmailChange returns the current user's email change awaiting
+// confirmation.
+func GetEmailChange(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		change, err := repositories.NewEmailChangeRepository(dbConnection.DB).Pending(c.Request.Context(), CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no email change is pending")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load email change", "error", err)
+			return nil, internalError("failed to load email change")
+		}
+		return &Response{Data: change, Legacy: gin.H{"email_change": change}}, nil
+	}
+}
+
+// CancelEmailChange cancels the current user's pending email change, which
+// the links already sent then no longer complete.
+func CancelEmailChange(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		err := repositories.NewEmailChangeRepository(dbConnection.DB).Cancel(c.Request.Context(), CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no email change is pending")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to cancel email change", "error", err)
+			return nil, internalError("failed to cancel email change")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ConfirmEmailChange confirms one side of an email change with the token
+// from either address's link. When the second side is confirmed the
+// account moves to the new address, the old one is sent a link to revert
+// it, and every session is told.
+func ConfirmEmailChange(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, mail mailer.Mailer, appConfig *config.ApplicationConfig) {
+	var req emailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "token is required",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tokens := repositories.NewVerificationTokenRepository(dbConnection.DB)
+	changes := repositories.NewEmailChangeRepository(dbConnection.DB)
+	hash := auth.HashVerificationToken(req.Token)
+	var change *models.EmailChange
+	confirm := func(side string) func(tx *gorm.DB, token *models.VerificationToken) error {
+		return func(tx *gorm.DB, token *models.VerificationToken) error {
+			var err error
+			change, err = changes.Confirm(tx, token.UserID, side, EmailChangeRevertWindow)
+			return err
+		}
+	}
+	err := tokens.Consume(ctx, models.TokenEmailChangeNew, hash, confirm(repositories.EmailChangeNew))
+	if errors.Is(err, repositories.ErrNotFound) {
+		err = tokens.Consume(ctx, models.TokenEmailChangeOld, hash, confirm(repositories.EmailChangeOld))
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "email is already taken",
+		})
+		return
+	}
+	if err != nil {
+		respondTokenError(c, err, "failed to confirm email change")
+		return
+	}
+
+	if change.CompletedAt == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"status":       "success",
+			"message":      "confirmed; follow the link sent to your other address to finish",
+			"email_change": change,
+		})
+		return
+	}
+
+	completedEmailChange(ctx, dbConnection, hub, mail, appConfig, change)
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"message":      "email changed",
+		"email_change": change,
+	})
+}
+
+// RevertEmailChange moves an account back to the address a revert link
+// was sent to and signs out every session, since the change may have been
+// made by someone who took the account over.
+func RevertEmailChange(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub) {
+	var req emailChangeTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "token is required",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var userID uuid.UUID
+	err := repositories.NewVerificationTokenRepository(dbConnection.DB).Consume(ctx, models.TokenEmailChangeRevert, auth.HashVerificationToken(req.Token),
+		func(tx *gorm.DB, token *models.VerificationToken) error {
+			userID = token.UserID
+			_, err := repositories.NewEmailChangeRepository(tx).Revert(tx, token.UserID)
+			return err
+		})
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "the old email has since been taken by another account",
+		})
+		return
+	}
+	if err != nil {
+		respondTokenError(c, err, "failed to revert email change")
+		return
+	}
+
+	hub.SignOut(userID, "email_change_reverted")
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "email restored; sign in and change your password",
+	})
+}
+
+// sendEmailChangeConfirmations emails a confirmation link to each address
+// of a change.
+func sendEmailChangeConfirmations(ctx context.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig, change *models.EmailChange) error {
+	newToken, err := issueVerificationToken(ctx, dbConnection, change.UserID, models.TokenEmailChangeNew, EmailChangeTTL)
+	if err != nil {
+		return err
+	}
+	oldToken, err := issueVerificationToken(ctx, dbConnection, change.UserID, models.TokenEmailChangeOld, EmailChangeTTL)
+	if err != nil {
+		return err
+	}
+	err = mail.Send(ctx, mailer.Message{
+		To:      change.NewEmail,
+		Subject: "Confirm your new AfroChat email",
+		Body: "Confirm this is the new email address for your AfroChat account by opening the link below:\n\n" +
+			linkWithQuery(appConfig.ConfirmEmailChangeURL, "token", newToken) +
+			"\n\nThe link expires in 24 hours. If you did not ask for this, ignore this email.",
+	})
+	if err != nil {
+		return err
+	}
+	return mail.Send(ctx, mailer.Message{
+		To:      change.OldEmail,
+		Subject: "Confirm the change of your AfroChat email",
+		Body: "Someone asked to change the email of your AfroChat account to " + change.NewEmail + ". Approve it by opening the link below:\n\n" +
+			linkWithQuery(appConfig.ConfirmEmailChangeURL, "token", oldToken) +
+			"\n\nThe link expires in 24 hours. If you did not ask for this, ignore this email, change your password and sign out your other sessions.",
+	})
+}
+
+// completedEmailChange sends the old address a link to revert a change
+// that just took effect and tells the user's sessions. Failures are
+// logged, the change standing regardless.
+func completedEmailChange(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, mail mailer.Mailer, appConfig *config.ApplicationConfig, change *models.EmailChange) {
+	token, err := issueVerificationToken(ctx, dbConnection, change.UserID, models.TokenEmailChangeRevert, EmailChangeRevertWindow)
+	if err == nil {
+		err = mail.Send(ctx, mailer.Message{
+			To:      change.OldEmail,
+			Subject: "Your AfroChat email was changed",
+			Body: "The email of your AfroChat account is now " + change.NewEmail + ". If you did not do this, undo it and sign out every session here:\n\n" +
+				linkWithQuery(appConfig.RevertEmailChangeURL, "token", token) +
+				"\n\nThe link works for 7 days.",
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send email change revert link", "user_id", change.UserID, "error", err)
+	}
+
+	invalidateProfile(ctx, change.UserID)
+
+	event, err := realtime.NewEvent(eventEmailChanged, gin.H{"email": change.NewEmail})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode event", "event_type", eventEmailChanged, "error", err)
+	} else {
+		hub.SendToUser(change.UserID, event)
+	}
+	notifyInbox(ctx, dbConnection, hub, models.InboxNotification{
+		UserID: change.UserID,
+		Kind:   models.InboxSystem,
+		Title:  "Your email was changed",
+		Body:   "Your account's email is now " + change.NewEmail + ". If this wasn't you, use the link sent to your old address to undo it.",
+	})
+}
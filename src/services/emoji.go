@@ -0,0 +1,45 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/emoji"
+	"github.com/gin-gonic/gin"
+)
+
+// GetEmoji returns the emoji catalog with keywords in the language asked
+// for by ?locale= or the Accept-Language header. The catalog changes only
+// with the server, so responses are cached by clients for as long as the
+// cache hints say and revalidated by version.
+func GetEmoji(c *gin.Context, catalog *emoji.Catalog, hints *CacheHints) {
+	locale := catalog.Negotiate(c.Query("locale"), c.GetHeader("Accept-Language"))
+	etag := `"` + catalog.Version() + "." + locale + `"`
+	c.Header("ETag", etag)
+	c.Header("Vary", "Accept-Language")
+	hints.apply(c, cacheEmoji)
+	if ifNoneMatch(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	set := catalog.Set(locale)
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"version":    set.Version,
+		"locale":     set.Locale,
+		"locales":    catalog.Locales(),
+		"categories": set.Categories,
+	})
+}
+
+// ifNoneMatch reports whether an If-None-Match header names etag.
+func ifNoneMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
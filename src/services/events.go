@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/push"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobRemindEvents is the scheduled job reminding members who answered
+	// an event going or maybe that it starts soon.
+	JobRemindEvents = "remind_events"
+
+	// EventReminderScanInterval is how often events starting soon are
+	// looked for.
+	EventReminderScanInterval = time.Minute
+
+	// eventReminderLead is how long before an event starts its reminder
+	// goes out.
+	eventReminderLead = time.Hour
+
+	// eventReminderBatch bounds the events reminded by one run of the job.
+	eventReminderBatch = 200
+
+	// eventRSVPChanged tells a room's members an event's RSVP counts
+	// changed.
+	eventRSVPChanged = "event.rsvp"
+
+	// eventStartingSoon reminds a member an event starts soon.
+	eventStartingSoon = "event.reminder"
+)
+
+type rsvpRequest struct {
+	Status content.RSVPStatus `json:"status" binding:"required"`
+}
+
+// eventRSVPView is an event's RSVP counts, with the current user's answer.
+type eventRSVPView struct {
+	MessageID      uuid.UUID                  `json:"message_id"`
+	ConversationID uuid.UUID                  `json:"conversation_id"`
+	RSVPCount      map[content.RSVPStatus]int `json:"rsvp_count"`
+	Status         content.RSVPStatus         `json:"status,omitempty"`
+}
+
+// RSVPToEvent records the current user's answer to the event message named
+// by the :id parameter, replacing any earlier one, and tells the room the
+// new counts.
+func RSVPToEvent(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req rsvpRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !req.Status.Valid() {
+			return nil, badRequest("status must be going, maybe or declined")
+		}
+		message, conversation, apiErr := eventMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		if err := repositories.NewEventRepository(dbConnection.DB).SetRSVP(ctx, message.ID, CurrentUserID(c), req.Status); err != nil {
+			slog.ErrorContext(ctx, "Failed to set RSVP", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to save RSVP")
+		}
+		return rsvpChanged(ctx, dbConnection, hub, message, conversation, req.Status)
+	}
+}
+
+// ClearEventRSVP withdraws the current user's answer to the event message
+// named by the :id parameter.
+func ClearEventRSVP(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, conversation, apiErr := eventMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		if err := repositories.NewEventRepository(dbConnection.DB).ClearRSVP(ctx, message.ID, CurrentUserID(c)); err != nil {
+			slog.ErrorContext(ctx, "Failed to clear RSVP", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to clear RSVP")
+		}
+		return rsvpChanged(ctx, dbConnection, hub, message, conversation, "")
+	}
+}
+
+// ListEventRSVPs returns the answers to the event message named by the :id
+// parameter, most recent first, with their counts.
+func ListEventRSVPs(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, _, apiErr := eventMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		events := repositories.NewEventRepository(dbConnection.DB)
+		rsvps, err := events.RSVPs(ctx, message.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list RSVPs", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to list RSVPs")
+		}
+		counts, err := events.RSVPCounts(ctx, message.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count RSVPs", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to list RSVPs")
+		}
+		data := gin.H{"rsvps": rsvps, "rsvp_count": counts}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// RemindEvents is the scheduled job reminding the members who answered an
+// event going or maybe, on their open sockets and devices, an
+// eventReminderLead before it starts. Events posted closer to their start
+// than that are reminded straight away.
+func RemindEvents(dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		now := time.Now()
+		events := repositories.NewEventRepository(dbConnection.DB)
+		due, err := events.ClaimDueReminders(ctx, now, now.Add(eventReminderLead), eventReminderBatch)
+		if err != nil {
+			return err
+		}
+		for i := range due {
+			attendees, err := events.Attending(ctx, due[i].Message.ID, due[i].Message.ConversationID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load event attendees", "message_id", due[i].Message.ID, "error", err)
+				continue
+			}
+			remindEvent(ctx, hub, notifier, &due[i], attendees)
+		}
+		if len(due) > 0 {
+			slog.InfoContext(ctx, "Reminded event attendees", "events", len(due))
+		}
+		return nil
+	}
+}
+
+func remindEvent(ctx context.Context, hub *realtime.Hub, notifier *Notifier, upcoming *repositories.UpcomingEvent, attendees []uuid.UUID) {
+	if len(attendees) == 0 {
+		return
+	}
+	var event content.Event
+	if err := json.Unmarshal(upcoming.Message.Payload, &event); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode event", "message_id", upcoming.Message.ID, "error", err)
+		return
+	}
+	if socketEvent, err := realtime.NewEvent(eventStartingSoon, upcoming.Message); err == nil {
+		hub.SendToUsers(attendees, socketEvent)
+	}
+	body := "Starts at " + event.StartsAt.UTC().Format("15:04 MST")
+	if event.Location != "" {
+		body += " at " + event.Location
+	}
+	notification := push.Notification{
+		Title:    event.Title,
+		Body:     body,
+		ThreadID: upcoming.Message.ConversationID.String(),
+		Data: map[string]string{
+			"type":            eventStartingSoon,
+			"conversation_id": upcoming.Message.ConversationID.String(),
+			"message_id":      upcoming.Message.ID.String(),
+		},
+	}
+	for _, userID := range attendees {
+		if err := notifier.Alert(ctx, userID, notification); err != nil {
+			slog.ErrorContext(ctx, "Failed to push event reminder", "message_id", upcoming.Message.ID, "user_id", userID, "error", err)
+		}
+	}
+}
+
+// eventMessage loads the event message named by the :id parameter, in a
+// conversation the current user belongs to.
+func eventMessage(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Message, *models.Conversation, *APIError) {
+	message, conversation, apiErr := memberMessage(c, dbConnection)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	if message.Type != string(content.TypeEvent) {
+		return nil, nil, badRequest("message is not an event")
+	}
+	_, err := repositories.NewEventRepository(dbConnection.DB).Get(c.Request.Context(), message.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil, notFound("event not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load event", "message_id", message.ID, "error", err)
+		return nil, nil, internalError("failed to load event")
+	}
+	return message, conversation, nil
+}
+
+// rsvpChanged sends an event's new RSVP counts to its room and answers
+// with them.
+func rsvpChanged(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, message *models.Message, conversation *models.Conversation, status content.RSVPStatus) (*Response, *APIError) {
+	counts, err := repositories.NewEventRepository(dbConnection.DB).RSVPCounts(ctx, message.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count RSVPs", "message_id", message.ID, "error", err)
+		return nil, internalError("failed to count RSVPs")
+	}
+	view := eventRSVPView{MessageID: message.ID, ConversationID: message.ConversationID, RSVPCount: counts}
+	if event, err := realtime.NewEvent(eventRSVPChanged, view); err == nil {
+		memberIDs := make([]uuid.UUID, 0, len(conversation.Members))
+		for _, member := range conversation.Members {
+			memberIDs = append(memberIDs, member.UserID)
+		}
+		hub.SendToUsers(memberIDs, event)
+	}
+	view.Status = status
+	return &Response{Data: view, Legacy: gin.H{"rsvp": view}}, nil
+}
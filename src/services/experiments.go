@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/experiments"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type createExperimentRequest struct {
+	Key            string                `json:"key" binding:"required"`
+	Description    string                `json:"description" binding:"max=500"`
+	TrafficPercent *int                  `json:"traffic_percent"`
+	Variants       []experiments.Variant `json:"variants" binding:"required"`
+}
+
+type updateExperimentRequest struct {
+	Status         *string `json:"status" binding:"omitempty,oneof=draft running stopped"`
+	TrafficPercent *int    `json:"traffic_percent" binding:"omitempty,min=0,max=100"`
+}
+
+// preloadVariants keeps variant order stable, since assignment walks the
+// variants in order.
+func preloadVariants(db *gorm.DB) *gorm.DB {
+	return db.Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("key ASC")
+	})
+}
+
+func toExperiment(experiment *models.Experiment) experiments.Experiment {
+	variants := make([]experiments.Variant, 0, len(experiment.Variants))
+	for _, variant := range experiment.Variants {
+		variants = append(variants, experiments.Variant{Key: variant.Key, Weight: variant.Weight})
+	}
+	return experiments.Experiment{
+		Key:            experiment.Key,
+		TrafficPercent: experiment.TrafficPercent,
+		Variants:       variants,
+	}
+}
+
+func runningExperiments(ctx context.Context, dbConnection *database.DatabaseConnection) ([]experiments.Experiment, error) {
+	var rows []models.Experiment
+	if err := preloadVariants(dbConnection.DB.WithContext(ctx)).
+		Where("status = ?", models.ExperimentRunning).
+		Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	running := make([]experiments.Experiment, 0, len(rows))
+	for i := range rows {
+		running = append(running, toExperiment(&rows[i]))
+	}
+	return running, nil
+}
+
+// experimentAssignments returns the user's variants for all running
+// experiments. Failures are logged and yield no assignments, so clients fall
+// back to their defaults rather than failing the request.
+func experimentAssignments(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID) map[string]string {
+	running, err := runningExperiments(ctx, dbConnection)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load running experiments", "error", err)
+		return map[string]string{}
+	}
+	return experiments.AssignAll(running, userID)
+}
+
+// GetExperimentAssignments returns the current user's variant for every
+// running experiment they are enrolled in.
+func GetExperimentAssignments(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"assignments": experimentAssignments(c.Request.Context(), dbConnection, CurrentUserID(c)),
+	})
+}
+
+// RecordExposure is called by clients when the user is shown an
+// experiment's variant. The variant is recomputed server-side, so clients
+// cannot log exposures to variants they were not assigned.
+func RecordExposure(c *gin.Context, dbConnection *database.DatabaseConnection, sink experiments.ExposureSink) {
+	var experiment models.Experiment
+	err := preloadVariants(dbConnection.DB.WithContext(c.Request.Context())).
+		Where("key = ? AND status = ?", c.Param("key"), models.ExperimentRunning).
+		First(&experiment).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load experiment",
+		})
+		return
+	}
+
+	userID := CurrentUserID(c)
+	variant, ok := "", false
+	if err == nil {
+		variant, ok = experiments.Assign(toExperiment(&experiment), userID)
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "not enrolled in this experiment",
+		})
+		return
+	}
+
+	exposure := experiments.Exposure{
+		Experiment: experiment.Key,
+		Variant:    variant,
+		UserID:     userID,
+		ExposedAt:  time.Now().UTC(),
+	}
+	if err := sink.Record(c.Request.Context(), exposure); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record exposure", "experiment", experiment.Key, "error", err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"variant": variant,
+	})
+}
+
+// CreateExperiment defines a new experiment in draft status.
+func CreateExperiment(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req createExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	definition := experiments.Experiment{
+		Key:            strings.TrimSpace(req.Key),
+		TrafficPercent: 100,
+		Variants:       req.Variants,
+	}
+	if req.TrafficPercent != nil {
+		definition.TrafficPercent = *req.TrafficPercent
+	}
+	if err := definition.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	experiment := models.Experiment{
+		Key:            definition.Key,
+		Description:    req.Description,
+		Status:         models.ExperimentDraft,
+		TrafficPercent: definition.TrafficPercent,
+		CreatedByID:    CurrentUserID(c),
+	}
+	for _, variant := range definition.Variants {
+		experiment.Variants = append(experiment.Variants, models.ExperimentVariant{Key: variant.Key, Weight: variant.Weight})
+	}
+
+	if err := dbConnection.DB.WithContext(c.Request.Context()).Create(&experiment).Error; err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusConflict, gin.H{
+				"status": "error",
+				"error":  "an experiment with this key already exists",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to create experiment",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":     "success",
+		"experiment": experiment,
+	})
+}
+
+// ListExperiments lists all experiments, newest first.
+func ListExperiments(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var rows []models.Experiment
+	if err := preloadVariants(dbConnection.DB.WithContext(c.Request.Context())).
+		Order("created_at DESC").
+		Find(&rows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list experiments",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "success",
+		"experiments": rows,
+	})
+}
+
+// UpdateExperiment starts or stops an experiment, or changes its traffic.
+// Variants are fixed once created, since changing them would reassign users
+// mid-experiment.
+func UpdateExperiment(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid experiment id",
+		})
+		return
+	}
+
+	var req updateExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  err.Error(),
+		})
+		return
+	}
+
+	updates := map[string]any{}
+	if req.Status != nil {
+		updates["status"] = *req.Status
+	}
+	if req.TrafficPercent != nil {
+		updates["traffic_percent"] = *req.TrafficPercent
+	}
+	if len(updates) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "nothing to update",
+		})
+		return
+	}
+
+	db := dbConnection.DB.WithContext(c.Request.Context())
+	result := db.Model(&models.Experiment{}).Where("id = ?", id).Updates(updates)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update experiment",
+		})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "experiment not found",
+		})
+		return
+	}
+
+	var experiment models.Experiment
+	if err := preloadVariants(db).First(&experiment, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load experiment",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "success",
+		"experiment": experiment,
+	})
+}
@@ -0,0 +1,587 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	JobDataExport     = "data_export"
+	JobAccountErasure = "account_erasure"
+
+	// dataExportTTL is how long a built export can be downloaded.
+	dataExportTTL         = 7 * 24 * time.Hour
+	dataExportContentType = "application/zip"
+
+	// exportBatchSize is how many messages are loaded at a time while
+	// writing an export.
+	exportBatchSize = 500
+
+	// maxErasureErrorRunes bounds the error kept on an erasure record.
+	maxErasureErrorRunes = 500
+)
+
+type dataExportJob struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+type accountErasureJob struct {
+	UserID uuid.UUID `json:"user_id"`
+}
+
+// RequestDataExport starts building an archive of the current user's
+// profile, messages and attachments in the background. It answers 202
+// with the pending export, which GetDataExport reports on; a request while
+// one is being built returns that one.
+func RequestDataExport(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		var export *models.DataExport
+		err := dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			exports := repositories.NewDataExportRepository(tx)
+			pending, err := exports.Pending(ctx, userID)
+			if err == nil {
+				export = pending
+				return nil
+			}
+			if !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+
+			export = &models.DataExport{UserID: userID}
+			if err := exports.Create(ctx, export); err != nil {
+				return err
+			}
+			_, err = repositories.NewJobRepository(tx).Enqueue(ctx, JobDataExport, dataExportJob{ExportID: export.ID})
+			return err
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to request data export", "error", err)
+			return nil, internalError("failed to request data export")
+		}
+		return &Response{Status: http.StatusAccepted, Data: export, Legacy: gin.H{"export": export}}, nil
+	}
+}
+
+// GetDataExport reports on one of the current user's exports and, once it
+// is ready, returns a short-lived URL to download it.
+func GetDataExport(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		export, apiErr := loadDataExport(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		data := gin.H{"export": export}
+		if export.Status != models.DataExportReady {
+			return &Response{Data: data, Legacy: data}, nil
+		}
+
+		download, err := store.PresignGet(c.Request.Context(), *export.StorageKey, presignExpiry)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			download = &storage.PresignedRequest{
+				Method: http.MethodGet,
+				URL:    "/api/v1/users/me/exports/" + export.ID.String() + "/content",
+			}
+		} else if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to presign data export download", "export_id", export.ID, "error", err)
+			return nil, internalError("failed to load data export")
+		}
+		data["download"] = download
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// DownloadDataExport streams a ready export through the API, for backends
+// without presigned URLs.
+func DownloadDataExport(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage) {
+	export, apiErr := loadDataExport(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if export.Status != models.DataExportReady {
+		abortWithError(c, conflict("the data export is not ready"))
+		return
+	}
+
+	body, err := store.Open(c.Request.Context(), *export.StorageKey)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to open data export", "export_id", export.ID, "error", err)
+		abortWithError(c, internalError("failed to load data export"))
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, export.SizeBytes, dataExportContentType, body, map[string]string{
+		"Content-Disposition": `attachment; filename="afrochat-export.zip"`,
+		"Cache-Control":       "no-store",
+	})
+}
+
+// loadDataExport loads the export named by the id path parameter, which
+// must be the current user's and not expired.
+func loadDataExport(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.DataExport, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid export id")
+	}
+	export, err := repositories.NewDataExportRepository(dbConnection.DB).GetForUser(c.Request.Context(), CurrentUserID(c), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("data export not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load data export", "export_id", id, "error", err)
+		return nil, internalError("failed to load data export")
+	}
+	if export.ExpiresAt != nil && time.Now().After(*export.ExpiresAt) {
+		return nil, notFound("data export has expired; request a new one")
+	}
+	return export, nil
+}
+
+// BuildDataExport is the job writing a user's export: a zip archive with
+// profile.json, messages.json and every attachment they uploaded under
+// attachments/. Archives of earlier exports are removed once it is ready.
+func BuildDataExport(dbConnection *database.DatabaseConnection, store storage.Storage) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload dataExportJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		exports := repositories.NewDataExportRepository(dbConnection.DB)
+		export, err := exports.Get(ctx, payload.ExportID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			// The account was erased in the meantime.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if export.Status != models.DataExportPending {
+			return nil
+		}
+
+		err = buildDataExport(ctx, dbConnection, store, exports, export)
+		if err != nil && finalAttempt(job) {
+			if err := exports.MarkFailed(ctx, export.ID); err != nil {
+				slog.ErrorContext(ctx, "Failed to mark data export failed", "export_id", export.ID, "error", err)
+			}
+		}
+		return err
+	}
+}
+
+func buildDataExport(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, exports *repositories.DataExportRepository, export *models.DataExport) error {
+	file, err := os.CreateTemp("", "afrochat-export-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	if err := writeDataExport(ctx, dbConnection, store, export.UserID, file); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to size export file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind export file: %w", err)
+	}
+
+	var user models.User
+	if err := dbConnection.DB.WithContext(ctx).Select("id", "residency").First(&user, "id = ?", export.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load user residency: %w", err)
+	}
+	key := storage.ResidentKey(store, residency.Region(user.Residency), "exports/"+export.UserID.String()+"/"+export.ID.String())
+	if err := store.Put(ctx, key, file, size, dataExportContentType); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+	pruned, err := exports.MarkReady(ctx, export, key, size, dataExportTTL)
+	if err != nil {
+		deleteObject(store, key)
+		return err
+	}
+	for _, old := range pruned {
+		if old.StorageKey != nil {
+			deleteObject(store, *old.StorageKey)
+		}
+	}
+	return nil
+}
+
+// exportProfile is profile.json: the account and what hangs off it.
+type exportProfile struct {
+	User          SelfProfile                 `json:"user"`
+	Identities    []models.UserIdentity       `json:"identities"`
+	Sessions      []models.Session            `json:"sessions"`
+	Contacts      []models.Contact            `json:"contacts"`
+	Conversations []models.ConversationMember `json:"conversations"`
+}
+
+func writeDataExport(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, userID uuid.UUID, w io.Writer) error {
+	db := dbConnection.DB.WithContext(ctx)
+	archive := zip.NewWriter(w)
+
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	profile := exportProfile{User: NewSelfProfile(&user)}
+	queries := []error{
+		db.Where("user_id = ?", userID).Find(&profile.Identities).Error,
+		db.Where("user_id = ?", userID).Order("created_at").Find(&profile.Sessions).Error,
+		db.Where("requester_id = ? OR addressee_id = ?", userID, userID).Order("created_at").Find(&profile.Contacts).Error,
+		db.Where("user_id = ?", userID).Order("joined_at").Find(&profile.Conversations).Error,
+	}
+	if err := errors.Join(queries...); err != nil {
+		return fmt.Errorf("failed to load profile: %w", err)
+	}
+	if err := writeExportJSON(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	if err := writeExportMessages(ctx, archive, repositories.NewMessageRepository(dbConnection.DB), userID); err != nil {
+		return err
+	}
+
+	var attachments []models.Attachment
+	err := db.Where("uploader_id = ? AND status = ?", userID, models.AttachmentReady).
+		Order("created_at").
+		Find(&attachments).Error
+	if err != nil {
+		return fmt.Errorf("failed to list attachments: %w", err)
+	}
+	for _, attachment := range attachments {
+		if err := writeExportFile(ctx, archive, store, "attachments/"+attachment.ID.String()+"/"+path.Base(attachment.FileName), attachment.StorageKey); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish export archive: %w", err)
+	}
+	return nil
+}
+
+// writeExportMessages writes messages.json a batch at a time, so long
+// histories never have to fit in memory.
+func writeExportMessages(ctx context.Context, archive *zip.Writer, messages *repositories.MessageRepository, userID uuid.UUID) error {
+	w, err := archive.Create("messages.json")
+	if err != nil {
+		return fmt.Errorf("failed to add messages to export: %w", err)
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	var after pagination.Cursor
+	first := true
+	for {
+		batch, err := messages.ListSent(ctx, userID, after, exportBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, message := range batch {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			if err := encoder.Encode(message); err != nil {
+				return fmt.Errorf("failed to write message %s: %w", message.ID, err)
+			}
+		}
+		if len(batch) < exportBatchSize {
+			break
+		}
+		last := batch[len(batch)-1]
+		after = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+func writeExportJSON(archive *zip.Writer, name string, v any) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %w", name, err)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+func writeExportFile(ctx context.Context, archive *zip.Writer, store storage.Storage, name, key string) error {
+	body, err := store.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		slog.WarnContext(ctx, "Attachment missing from storage; leaving it out of export", "key", key)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", key, err)
+	}
+	defer body.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %w", name, err)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return fmt.Errorf("failed to copy %s into export: %w", key, err)
+	}
+	return nil
+}
+
+// EraseAccount is the job erasing a deleted account's personal data, then
+// purging it from where copies are kept: its files, the search index, the
+// caches and analytics. Each step is recorded in the account's erasure
+// record once done, so a retry picks up where a failed run stopped and
+// admins can show the erasure was carried out.
+func EraseAccount(dbConnection *database.DatabaseConnection, store storage.Storage, index search.Index) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload accountErasureJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		erasures := repositories.NewAccountErasureRepository(dbConnection.DB)
+		erasure, err := erasures.Open(ctx, payload.UserID)
+		if err != nil {
+			return err
+		}
+
+		erasure.Attempts++
+		err = eraseAccount(ctx, dbConnection, store, index, erasure)
+		erasure.LastError = ""
+		if err != nil {
+			erasure.LastError = truncate(err.Error(), maxErasureErrorRunes)
+		}
+		if saveErr := erasures.Save(ctx, erasure); saveErr != nil {
+			return errors.Join(err, saveErr)
+		}
+		if errors.Is(err, repositories.ErrLegalHold) {
+			// Releasing the hold queues the erasure again.
+			slog.InfoContext(ctx, "Account erasure waits for a legal hold", "user_id", payload.UserID)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Erased account", "user_id", payload.UserID, "files", erasure.FilesDeleted, "search_entries", erasure.SearchEntriesRemoved)
+		return nil
+	}
+}
+
+// eraseAccount carries out the steps of an erasure not yet done, stamping
+// each on erasure, and stops at the first that fails.
+func eraseAccount(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, index search.Index, erasure *models.AccountErasure) error {
+	if erasure.DataErasedAt == nil {
+		keys, err := repositories.EraseUser(ctx, dbConnection.DB, erasure.UserID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		// The file rows went with the data, so the files are deleted
+		// now or never.
+		for _, key := range keys {
+			deleteCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+			if err := store.Delete(deleteCtx, key); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete object of erased account", "key", key, "error", err)
+				erasure.FilesFailed++
+			} else {
+				erasure.FilesDeleted++
+			}
+			cancel()
+		}
+		now := time.Now()
+		erasure.DataErasedAt, erasure.FilesDeletedAt = &now, &now
+	}
+
+	if erasure.SearchPurgedAt == nil {
+		removed, err := purgeSearchEntries(ctx, dbConnection, index, erasure.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to purge search index: %w", err)
+		}
+		now := time.Now()
+		erasure.SearchEntriesRemoved, erasure.SearchPurgedAt = removed, &now
+	}
+
+	if erasure.CachesPurgedAt == nil {
+		invalidateProfile(ctx, erasure.UserID)
+		now := time.Now()
+		erasure.CachesPurgedAt = &now
+	}
+
+	if erasure.AnalyticsPurgedAt == nil {
+		subject, err := usageSampler.Erase(ctx, erasure.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to erase analytics: %w", err)
+		}
+		now := time.Now()
+		erasure.AnalyticsSubject, erasure.AnalyticsPurgedAt = subject, &now
+	}
+
+	now := time.Now()
+	erasure.CompletedAt = &now
+	return nil
+}
+
+// purgeSearchEntries drops the messages a user sent from the search index,
+// returning how many. Their text is already gone, so engines indexing
+// inside the database have nothing left to find.
+func purgeSearchEntries(ctx context.Context, dbConnection *database.DatabaseConnection, index search.Index, userID uuid.UUID) (int, error) {
+	removed := 0
+	last := uuid.Nil
+	for {
+		var ids []uuid.UUID
+		err := dbConnection.DB.WithContext(ctx).Unscoped().Model(&models.Message{}).
+			Where("sender_id = ? AND id > ?", userID, last).
+			Order("id").
+			Limit(exportBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return removed, err
+		}
+		for _, id := range ids {
+			if err := index.Remove(ctx, id); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+		if len(ids) < exportBatchSize {
+			return removed, nil
+		}
+		last = ids[len(ids)-1]
+	}
+}
+
+const maxErasurePage = 100
+
+type reapplyErasuresRequest struct {
+	// Since is when the restored backup was taken.
+	Since time.Time `json:"since" binding:"required"`
+}
+
+// ListAccountErasures returns a page of account erasures, oldest first,
+// with the progress of each, as evidence of compliance. ?since= limits it
+// to those requested from an RFC 3339 time on, and ?pending=true to those
+// not completed.
+func ListAccountErasures(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var since time.Time
+		if raw := c.Query("since"); raw != "" {
+			parsed, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return nil, badRequest("since must be an RFC 3339 time")
+			}
+			since = parsed
+		}
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxErasurePage, maxErasurePage)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("limit must be between 1 and %d", maxErasurePage))
+		}
+
+		erasures, err := repositories.NewAccountErasureRepository(dbConnection.DB).
+			List(c.Request.Context(), since, c.Query("pending") == "true", after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list account erasures", "error", err)
+			return nil, internalError("failed to list account erasures")
+		}
+		page := &Page{}
+		if len(erasures) > limit {
+			erasures = erasures[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.ErasureCursor(&erasures[limit-1]).Encode()
+		}
+		legacy := gin.H{"erasures": erasures}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: erasures, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetAccountErasure returns the erasure record named by the :id parameter.
+func GetAccountErasure(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid erasure id")
+		}
+		erasure, err := repositories.NewAccountErasureRepository(dbConnection.DB).Get(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("erasure not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load account erasure", "id", id, "error", err)
+			return nil, internalError("failed to load account erasure")
+		}
+		return &Response{Data: erasure, Legacy: gin.H{"erasure": erasure}}, nil
+	}
+}
+
+// ReapplyAccountErasures erases again every account erased since a backup
+// was taken, once the database is restored from it, so restoring never
+// brings an erased user back. Each gets a new erasure record.
+func ReapplyAccountErasures(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req reapplyErasuresRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		userIDs, err := repositories.NewAccountErasureRepository(dbConnection.DB).ErasedSince(ctx, req.Since)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list erased accounts", "error", err)
+			return nil, internalError("failed to reapply erasures")
+		}
+		err = dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			erasures := repositories.NewAccountErasureRepository(tx)
+			jobs := repositories.NewJobRepository(tx)
+			for _, userID := range userIDs {
+				if err := erasures.Create(ctx, &models.AccountErasure{UserID: userID}); err != nil {
+					return err
+				}
+				if _, err := jobs.Enqueue(ctx, JobAccountErasure, accountErasureJob{UserID: userID}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to reapply erasures", "error", err)
+			return nil, internalError("failed to reapply erasures")
+		}
+		data := gin.H{"scheduled": len(userIDs)}
+		return &Response{Status: http.StatusAccepted, Data: data, Legacy: data}, nil
+	}
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultFailedJobPage = 50
+	maxFailedJobPage     = 100
+
+	// jobAlertInterval is the least time between alerts about one kind of
+	// job, so an outage failing hundreds of deliveries raises one alert
+	// rather than hundreds. Alerts held back are counted in the next.
+	jobAlertInterval = 5 * time.Minute
+
+	jobAlertTimeout = 10 * time.Second
+)
+
+// JobQueueStats counts the jobs of each kind in each status, across all
+// instances.
+func JobQueueStats(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		counts, err := repositories.NewJobRepository(dbConnection.DB).Counts(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to count jobs", "error", err)
+			return nil, internalError("failed to count jobs")
+		}
+		return &Response{Data: counts, Legacy: gin.H{"counts": counts}}, nil
+	}
+}
+
+// ListFailedJobs lists the jobs that were given up on, most recently
+// failed first, optionally of one ?kind only.
+func ListFailedJobs(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		before, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultFailedJobPage, maxFailedJobPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		jobs, err := repositories.NewJobRepository(dbConnection.DB).ListFailed(c.Request.Context(), c.Query("kind"), before, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list failed jobs", "error", err)
+			return nil, internalError("failed to list failed jobs")
+		}
+
+		page := &Page{}
+		if len(jobs) > limit {
+			jobs = jobs[:limit]
+			page.HasMore = true
+			last := jobs[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.UpdatedAt, ID: last.ID}.Encode()
+		}
+		legacy := gin.H{"jobs": jobs}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: jobs, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetJob shows the job named by the :id parameter, with its payload and
+// last error.
+func GetJob(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid job ID")
+		}
+		job, err := repositories.NewJobRepository(dbConnection.DB).Get(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("job not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load job", "job_id", id, "error", err)
+			return nil, internalError("failed to load job")
+		}
+		return &Response{Data: job, Legacy: gin.H{"job": job}}, nil
+	}
+}
+
+// RetryJob queues the failed job named by the :id parameter to run again at
+// once, with a fresh set of attempts. Fix what made it fail first.
+func RetryJob(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid job ID")
+		}
+		ctx := c.Request.Context()
+		job, err := repositories.NewJobRepository(dbConnection.DB).Retry(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("failed job not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to retry job", "job_id", id, "error", err)
+			return nil, internalError("failed to retry job")
+		}
+		slog.InfoContext(ctx, "Failed job queued again", "job_id", id, "kind", job.Kind, "admin_id", CurrentUserID(c))
+		return &Response{Data: job, Legacy: gin.H{"job": job}}, nil
+	}
+}
+
+type retryFailedJobsRequest struct {
+	Kind string `json:"kind" binding:"required,max=50"`
+}
+
+// RetryFailedJobs queues every failed job of a kind to run again, as after
+// the outage that failed them is over.
+func RetryFailedJobs(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req retryFailedJobsRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		retried, err := repositories.NewJobRepository(dbConnection.DB).RetryFailed(ctx, req.Kind)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to retry jobs", "kind", req.Kind, "error", err)
+			return nil, internalError("failed to retry jobs")
+		}
+		slog.InfoContext(ctx, "Failed jobs queued again", "kind", req.Kind, "count", retried, "admin_id", CurrentUserID(c))
+		data := gin.H{"kind": req.Kind, "retried": retried}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// DiscardJob deletes the failed job named by the :id parameter, for work
+// that should not be retried. Failed jobs are otherwise purged after
+// failedRetention.
+func DiscardJob(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid job ID")
+		}
+		ctx := c.Request.Context()
+		err = repositories.NewJobRepository(dbConnection.DB).DeleteFailed(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("failed job not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to discard job", "job_id", id, "error", err)
+			return nil, internalError("failed to discard job")
+		}
+		slog.InfoContext(ctx, "Failed job discarded", "job_id", id, "admin_id", CurrentUserID(c))
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// jobAlert is the body posted to the job alert webhook.
+type jobAlert struct {
+	Event    string    `json:"event"`
+	JobID    uuid.UUID `json:"job_id"`
+	Kind     string    `json:"kind"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failed_at"`
+
+	// Suppressed counts the jobs of the kind given up on since the last
+	// alert about it, which were not alerted on separately.
+	Suppressed int `json:"suppressed"`
+}
+
+// JobAlertWebhook returns a failure hook posting an alert about each job
+// given up on to url, such as a chat or paging service's incoming webhook,
+// at most once per jobAlertInterval for each kind of job on this instance.
+// With a secret, alerts are signed as outgoing webhooks are.
+func JobAlertWebhook(url, secret string) JobFailureHook {
+	client := &http.Client{Timeout: jobAlertTimeout}
+	var mu sync.Mutex
+	lastSent := make(map[string]time.Time)
+	suppressed := make(map[string]int)
+
+	return func(ctx context.Context, job *models.Job, jobErr error) {
+		now := time.Now()
+		mu.Lock()
+		if now.Sub(lastSent[job.Kind]) < jobAlertInterval {
+			suppressed[job.Kind]++
+			mu.Unlock()
+			return
+		}
+		lastSent[job.Kind] = now
+		held := suppressed[job.Kind]
+		suppressed[job.Kind] = 0
+		mu.Unlock()
+
+		body, err := json.Marshal(jobAlert{
+			Event:      "job.failed",
+			JobID:      job.ID,
+			Kind:       job.Kind,
+			Attempts:   job.Attempts,
+			Error:      jobErr.Error(),
+			FailedAt:   now,
+			Suppressed: held,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encode job alert", "error", err)
+			return
+		}
+		// The job's own context may have run out, which is what failed it.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobAlertTimeout)
+		defer cancel()
+		if err := postJobAlert(ctx, client, url, secret, body); err != nil {
+			slog.ErrorContext(ctx, "Failed to send job alert", "job_id", job.ID, "kind", job.Kind, "error", err)
+		}
+	}
+}
+
+func postJobAlert(ctx context.Context, client *http.Client, url, secret string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, time.Now(), body))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("alert webhook answered %d", resp.StatusCode)
+	}
+	return nil
+}
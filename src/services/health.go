@@ -1,25 +1,55 @@
 package services
 
 import (
+	"context"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/dfunani/AfroChat/backend/pkg/config"
 	"github.com/dfunani/AfroChat/backend/pkg/database"
 	"github.com/gin-gonic/gin"
 )
 
-func HealthCheck(c *gin.Context) {
+// healthCheckTimeout bounds each dependency check, so a hung dependency
+// fails its probe instead of holding it open.
+const healthCheckTimeout = 2 * time.Second
+
+func HealthCheck(c *gin.Context, appConfig *config.ApplicationConfig) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "ok",
 		"service":   "AfroChat Backend",
+		"region":    appConfig.Region,
 		"timestamp": gin.H{},
 	})
 }
 
+// DatabaseHealthCheck pings the database and reports its connection pool,
+// for telling an unreachable database from an exhausted pool.
 func DatabaseHealthCheck(c *gin.Context, dbConnection *database.DatabaseConnection) {
-	if err := dbConnection.Health(); err != nil {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	err := dbConnection.Health(ctx)
+	latency := time.Since(start)
+
+	pool := gin.H{}
+	if dbConnection.SQLDB != nil {
+		stats := dbConnection.SQLDB.Stats()
+		pool = gin.H{
+			"max_open":         stats.MaxOpenConnections,
+			"open":             stats.OpenConnections,
+			"in_use":           stats.InUse,
+			"idle":             stats.Idle,
+			"wait_count":       stats.WaitCount,
+			"wait_duration_ms": stats.WaitDuration.Milliseconds(),
+		}
+	}
+	if err != nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status": "error",
 			"error":  err.Error(),
+			"pool":   pool,
 		})
 		return
 	}
@@ -31,5 +61,70 @@ func DatabaseHealthCheck(c *gin.Context, dbConnection *database.DatabaseConnecti
 			"port": dbConnection.Config.Port,
 			"name": dbConnection.Config.DBName,
 		},
+		"ping_ms": latency.Milliseconds(),
+		"pool":    pool,
 	})
 }
+
+// Readiness is what the server needs before it can take traffic: the
+// database, a migrated schema and, when configured, Redis.
+type Readiness struct {
+	checks []readinessCheck
+}
+
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Add registers a dependency check. Checks run concurrently, each within
+// healthCheckTimeout.
+func (r *Readiness) Add(name string, check func(ctx context.Context) error) {
+	r.checks = append(r.checks, readinessCheck{name: name, check: check})
+}
+
+// Livez answers whether the process is alive. It checks no dependencies:
+// an outage of one is no reason to restart the server.
+func Livez(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz answers whether the server is ready to take traffic, with the
+// outcome of each check. It answers 503 while any check fails, so load
+// balancers route around the instance without restarting it.
+func Readyz(c *gin.Context, readiness *Readiness) {
+	c.Header("Cache-Control", "no-store")
+
+	results := make(gin.H, len(readiness.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	ready := true
+	for _, rc := range readiness.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request.Context(), healthCheckTimeout)
+			defer cancel()
+			result := "ok"
+			if err := rc.check(ctx); err != nil {
+				result = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[rc.name] = result
+			ready = ready && result == "ok"
+		}()
+	}
+	wg.Wait()
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "error", "checks": results})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": results})
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// deviceFingerprintHeader carries the fingerprint clients derive from
+	// the device, stable across reinstalls and accounts.
+	deviceFingerprintHeader = "X-Device-Fingerprint"
+
+	JobIdentityClusters = "identity_clusters"
+
+	// IdentityClusterInterval is how often clusters are grown again
+	// around banned accounts.
+	IdentityClusterInterval = time.Hour
+
+	// identitySignalRetention is how long a signal not seen again is
+	// kept.
+	identitySignalRetention = 180 * 24 * time.Hour
+
+	// maxSignalSharers is how many accounts a signal can be seen on and
+	// still link them. Past it, it is a shared network or computer.
+	maxSignalSharers = 5
+
+	// minSharedIPs is how many addresses two accounts must share to be
+	// linked by them alone. A fingerprint or phone number links on its
+	// own.
+	minSharedIPs = 2
+
+	// A cluster grows at most clusterMaxDepth links from where it starts
+	// and to at most clusterMaxMembers accounts.
+	clusterMaxDepth   = 3
+	clusterMaxMembers = 50
+
+	maxIdentityClusterPage = 50
+)
+
+// hashSignal is the form a signal is stored and compared in.
+func hashSignal(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])
+}
+
+// recordIdentitySignals notes the address, device fingerprint and phone
+// number a user authenticated with. It never fails the request.
+func recordIdentitySignals(c *gin.Context, dbConnection *database.DatabaseConnection, user *models.User) {
+	var signals []models.IdentitySignal
+	if ip := c.ClientIP(); ip != "" {
+		signals = append(signals, models.IdentitySignal{Kind: models.IdentitySignalIP, Value: hashSignal(ip)})
+	}
+	if fingerprint := strings.TrimSpace(c.GetHeader(deviceFingerprintHeader)); fingerprint != "" {
+		signals = append(signals, models.IdentitySignal{Kind: models.IdentitySignalFingerprint, Value: hashSignal(truncate(fingerprint, 255))})
+	}
+	if user.PhoneE164 != nil {
+		signals = append(signals, models.IdentitySignal{Kind: models.IdentitySignalPhone, Value: hashSignal(*user.PhoneE164)})
+	}
+	if err := repositories.NewIdentitySignalRepository(dbConnection.DB).Record(c.Request.Context(), user.ID, signals); err != nil {
+		slog.WarnContext(c.Request.Context(), "Failed to record identity signals", "user_id", user.ID, "error", err)
+	}
+}
+
+// IdentityLinkView is why two accounts are in a cluster: how many of each
+// kind of signal they share.
+type IdentityLinkView struct {
+	UserID       uuid.UUID `json:"user_id"`
+	OtherID      uuid.UUID `json:"other_id"`
+	Fingerprints int       `json:"fingerprints"`
+	Phones       int       `json:"phones"`
+	SharedIPs    int       `json:"shared_ips"`
+}
+
+// linked reports whether what the accounts share is enough to link them.
+func (l *IdentityLinkView) linked() bool {
+	return l.Fingerprints > 0 || l.Phones > 0 || l.SharedIPs >= minSharedIPs
+}
+
+// growCluster finds the accounts linked to seed, directly or through
+// each other, within the cluster bounds, with the links found.
+func growCluster(ctx context.Context, signals *repositories.IdentitySignalRepository, seed uuid.UUID) ([]uuid.UUID, []IdentityLinkView, error) {
+	members := []uuid.UUID{seed}
+	level := map[uuid.UUID]int{seed: 0}
+	var links []IdentityLinkView
+	frontier := members
+	for depth := 0; depth < clusterMaxDepth && len(frontier) > 0; depth++ {
+		shared, err := signals.Links(ctx, frontier, maxSignalSharers)
+		if err != nil {
+			return nil, nil, err
+		}
+		pairs := make(map[[2]uuid.UUID]*IdentityLinkView)
+		var order [][2]uuid.UUID
+		for _, link := range shared {
+			key := [2]uuid.UUID{link.UserID, link.OtherID}
+			pair := pairs[key]
+			if pair == nil {
+				pair = &IdentityLinkView{UserID: link.UserID, OtherID: link.OtherID}
+				pairs[key] = pair
+				order = append(order, key)
+			}
+			switch link.Kind {
+			case models.IdentitySignalFingerprint:
+				pair.Fingerprints++
+			case models.IdentitySignalPhone:
+				pair.Phones++
+			case models.IdentitySignalIP:
+				pair.SharedIPs++
+			}
+		}
+
+		var next []uuid.UUID
+		for _, key := range order {
+			pair := pairs[key]
+			if !pair.linked() {
+				continue
+			}
+			found, ok := level[pair.OtherID]
+			switch {
+			case !ok && len(members) < clusterMaxMembers:
+				level[pair.OtherID] = depth + 1
+				members = append(members, pair.OtherID)
+				next = append(next, pair.OtherID)
+			case !ok:
+				continue
+			// Links to members an earlier round looked from were found
+			// then, and those within this round are found from both ends.
+			case found < depth, found == depth && pair.UserID.String() > pair.OtherID.String():
+				continue
+			}
+			links = append(links, *pair)
+		}
+		frontier = next
+	}
+	return members, links, nil
+}
+
+// loadClusterUsers loads the accounts of a cluster, deleted ones
+// included, by ID.
+func loadClusterUsers(ctx context.Context, dbConnection *database.DatabaseConnection, ids []uuid.UUID) (map[uuid.UUID]*models.User, error) {
+	var users []models.User
+	if err := dbConnection.DB.WithContext(ctx).Unscoped().Where("id IN ?", ids).Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to load cluster members: %w", err)
+	}
+	byID := make(map[uuid.UUID]*models.User, len(users))
+	for i := range users {
+		byID[users[i].ID] = &users[i]
+	}
+	return byID, nil
+}
+
+// UserIdentityCluster is the accounts linked to one user and why.
+type UserIdentityCluster struct {
+	Members []AdminUserView    `json:"members"`
+	Links   []IdentityLinkView `json:"links"`
+}
+
+// GetUserIdentityCluster finds the accounts linked to the user named by
+// the :id parameter by shared device fingerprints, phone numbers and
+// addresses, as they stand now.
+func GetUserIdentityCluster(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		ctx := c.Request.Context()
+		memberIDs, links, err := growCluster(ctx, repositories.NewIdentitySignalRepository(dbConnection.DB), userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to grow identity cluster", "user_id", userID, "error", err)
+			return nil, internalError("failed to load identity cluster")
+		}
+		users, err := loadClusterUsers(ctx, dbConnection, memberIDs)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load identity cluster", "user_id", userID, "error", err)
+			return nil, internalError("failed to load identity cluster")
+		}
+		if users[userID] == nil {
+			return nil, notFound("user not found")
+		}
+
+		cluster := UserIdentityCluster{Members: make([]AdminUserView, 0, len(memberIDs)), Links: links}
+		if cluster.Links == nil {
+			cluster.Links = []IdentityLinkView{}
+		}
+		for _, id := range memberIDs {
+			if user := users[id]; user != nil {
+				cluster.Members = append(cluster.Members, NewAdminUserView(user))
+			}
+		}
+		return &Response{Data: cluster, Legacy: gin.H{"members": cluster.Members, "links": cluster.Links}}, nil
+	}
+}
+
+// ComputeIdentityClusters is the scheduled job growing a cluster around
+// every banned account and storing those that also hold accounts still in
+// good standing, the likely ban evasions. Clusters that meet are merged.
+// It first drops signals older than identitySignalRetention.
+func ComputeIdentityClusters(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		signals := repositories.NewIdentitySignalRepository(dbConnection.DB)
+		if _, err := signals.PurgeStale(ctx, time.Now().Add(-identitySignalRetention)); err != nil {
+			return err
+		}
+		seeds, err := signals.BannedUsers(ctx)
+		if err != nil {
+			return err
+		}
+
+		// groups[i] is nil once merged into another group.
+		var groups [][]uuid.UUID
+		groupOf := make(map[uuid.UUID]int)
+		for _, seed := range seeds {
+			if _, done := groupOf[seed]; done {
+				continue
+			}
+			members, _, err := growCluster(ctx, signals, seed)
+			if err != nil {
+				return err
+			}
+			target := len(groups)
+			groups = append(groups, nil)
+			for _, id := range members {
+				if other, ok := groupOf[id]; ok && other != target {
+					for _, moved := range groups[other] {
+						groupOf[moved] = target
+					}
+					groups[target] = append(groups[target], groups[other]...)
+					groups[other] = nil
+				}
+				if _, ok := groupOf[id]; !ok {
+					groupOf[id] = target
+					groups[target] = append(groups[target], id)
+				}
+			}
+		}
+
+		now := time.Now()
+		var clusters []models.IdentityCluster
+		for _, group := range groups {
+			if len(group) < 2 {
+				continue
+			}
+			users, err := loadClusterUsers(ctx, dbConnection, group)
+			if err != nil {
+				return err
+			}
+			cluster := models.IdentityCluster{ComputedAt: now}
+			for _, id := range group {
+				user := users[id]
+				if user == nil {
+					continue
+				}
+				cluster.Members = append(cluster.Members, models.IdentityClusterMember{UserID: id, Banned: user.IsBanned})
+				cluster.MemberCount++
+				if user.IsBanned {
+					cluster.BannedCount++
+				}
+				if user.CreatedAt.After(cluster.NewestMemberAt) {
+					cluster.NewestMemberAt = user.CreatedAt
+				}
+			}
+			if cluster.BannedCount > 0 && cluster.BannedCount < cluster.MemberCount {
+				clusters = append(clusters, cluster)
+			}
+		}
+		if err := signals.ReplaceClusters(ctx, clusters); err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Computed identity clusters", "banned", len(seeds), "clusters", len(clusters))
+		return nil
+	}
+}
+
+// IdentityClusterView is a stored cluster with its accounts.
+type IdentityClusterView struct {
+	models.IdentityCluster
+	Members []AdminUserView `json:"members"`
+}
+
+// ListIdentityClusters returns a page of the clusters the last scan found
+// holding both banned accounts and accounts in good standing, those with
+// the newest accounts first.
+func ListIdentityClusters(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxIdentityClusterPage, maxIdentityClusterPage)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("limit must be between 1 and %d", maxIdentityClusterPage))
+		}
+		ctx := c.Request.Context()
+		clusters, err := repositories.NewIdentitySignalRepository(dbConnection.DB).ListClusters(ctx, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list identity clusters", "error", err)
+			return nil, internalError("failed to list identity clusters")
+		}
+		page := &Page{}
+		if len(clusters) > limit {
+			clusters = clusters[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.IdentityClusterCursor(&clusters[limit-1]).Encode()
+		}
+
+		var memberIDs []uuid.UUID
+		for _, cluster := range clusters {
+			for _, member := range cluster.Members {
+				memberIDs = append(memberIDs, member.UserID)
+			}
+		}
+		users, err := loadClusterUsers(ctx, dbConnection, memberIDs)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load identity cluster members", "error", err)
+			return nil, internalError("failed to list identity clusters")
+		}
+		views := make([]IdentityClusterView, 0, len(clusters))
+		for _, cluster := range clusters {
+			view := IdentityClusterView{IdentityCluster: cluster, Members: make([]AdminUserView, 0, len(cluster.Members))}
+			for _, member := range cluster.Members {
+				if user := users[member.UserID]; user != nil {
+					view.Members = append(view.Members, NewAdminUserView(user))
+				}
+			}
+			views = append(views, view)
+		}
+		legacy := gin.H{"clusters": views}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: views, Page: page, Legacy: legacy}, nil
+	}
+}
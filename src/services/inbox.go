@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/preview"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// eventInboxNotification delivers a new notification to its user's
+	// open sockets, for the bell to light up.
+	eventInboxNotification = "notification.new"
+
+	// eventInboxRead tells a user's other devices their unread count
+	// changed, so every bell shows the same badge.
+	eventInboxRead = "notification.read"
+
+	maxInboxPage = 50
+
+	// maxInboxBodyRunes is how much of a mentioning message a mention
+	// notification quotes.
+	maxInboxBodyRunes = 200
+)
+
+type inboxReadView struct {
+	UnreadCount int64 `json:"unread_count"`
+}
+
+type markInboxReadRequest struct {
+	IDs []uuid.UUID `json:"ids" binding:"max=100"`
+	All bool        `json:"all"`
+}
+
+type systemNoticeRequest struct {
+	UserIDs []uuid.UUID `json:"user_ids" binding:"required,min=1,max=1000"`
+	Title   string      `json:"title" binding:"required,max=200"`
+	Body    string      `json:"body" binding:"required,max=500"`
+}
+
+// ListInbox returns a page of the current user's notifications, newest
+// first, or with ?unread=true only those not yet read.
+func ListInbox(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxInboxPage, maxInboxPage)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("limit must be between 1 and %d", maxInboxPage))
+		}
+		unread := c.Query("unread") == "true"
+
+		notifications, err := repositories.NewInboxRepository(dbConnection.DB).
+			List(c.Request.Context(), CurrentUserID(c), unread, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list notifications", "error", err)
+			return nil, internalError("failed to list notifications")
+		}
+		page := &Page{}
+		if len(notifications) > limit {
+			notifications = notifications[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.InboxCursor(&notifications[limit-1]).Encode()
+		}
+		legacy := gin.H{"notifications": notifications}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: notifications, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetInboxUnreadCount returns how many of the current user's
+// notifications are unread, for the badge on the bell.
+func GetInboxUnreadCount(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		count, err := repositories.NewInboxRepository(dbConnection.DB).UnreadCount(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to count unread notifications", "error", err)
+			return nil, internalError("failed to count notifications")
+		}
+		view := inboxReadView{UnreadCount: count}
+		return &Response{Data: view, Legacy: gin.H{"unread_count": count}}, nil
+	}
+}
+
+// MarkInboxRead marks the current user's notifications listed in ids
+// read, or every one with all, and returns how many are left unread. The
+// user's other devices are told the new count.
+func MarkInboxRead(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req markInboxReadRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.All == (len(req.IDs) > 0) {
+			return nil, badRequest("give either ids or all")
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		inbox := repositories.NewInboxRepository(dbConnection.DB)
+		marked, err := inbox.MarkRead(ctx, userID, req.IDs, time.Now())
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to mark notifications read", "error", err)
+			return nil, internalError("failed to mark notifications read")
+		}
+		count, err := inbox.UnreadCount(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count unread notifications", "error", err)
+			return nil, internalError("failed to mark notifications read")
+		}
+		view := inboxReadView{UnreadCount: count}
+		if marked > 0 {
+			sendInboxRead(hub, userID, view)
+		}
+		return &Response{Data: view, Legacy: gin.H{"unread_count": count}}, nil
+	}
+}
+
+// DeleteInboxNotification clears the current user's notification named by
+// the :id parameter.
+func DeleteInboxNotification(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid notification id")
+		}
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		inbox := repositories.NewInboxRepository(dbConnection.DB)
+		err = inbox.Delete(ctx, userID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("notification not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete notification", "id", id, "error", err)
+			return nil, internalError("failed to delete notification")
+		}
+		refreshInboxCount(ctx, inbox, hub, userID)
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ClearInbox clears the current user's notifications, or with ?read=true
+// only those already read.
+func ClearInbox(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		inbox := repositories.NewInboxRepository(dbConnection.DB)
+		cleared, err := inbox.Clear(ctx, userID, c.Query("read") == "true")
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to clear notifications", "error", err)
+			return nil, internalError("failed to clear notifications")
+		}
+		if cleared > 0 {
+			refreshInboxCount(ctx, inbox, hub, userID)
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// SendSystemNotice puts a notice from the operators in the notification
+// centers of the listed users. IDs naming no user are skipped, and the
+// response says how many got it.
+func SendSystemNotice(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req systemNoticeRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		title, body := strings.TrimSpace(req.Title), strings.TrimSpace(req.Body)
+		if title == "" || body == "" {
+			return nil, badRequest("title and body must not be blank")
+		}
+
+		ctx := c.Request.Context()
+		var userIDs []uuid.UUID
+		err := dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+			Where("id IN ?", uniqueIDs(req.UserIDs)).
+			Pluck("id", &userIDs).Error
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load recipients of notice", "error", err)
+			return nil, internalError("failed to send notice")
+		}
+		notifications := make([]models.InboxNotification, 0, len(userIDs))
+		for _, userID := range userIDs {
+			notifications = append(notifications, models.InboxNotification{UserID: userID, Kind: models.InboxSystem, Title: title, Body: body})
+		}
+		if err := addToInbox(ctx, dbConnection, hub, notifications); err != nil {
+			slog.ErrorContext(ctx, "Failed to send notice", "error", err)
+			return nil, internalError("failed to send notice")
+		}
+		return &Response{Status: http.StatusCreated, Data: gin.H{"sent": len(notifications)}, Legacy: gin.H{"sent": len(notifications)}}, nil
+	}
+}
+
+// addToInbox stores notifications and delivers each to its user's open
+// sockets.
+func addToInbox(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifications []models.InboxNotification) error {
+	if err := repositories.NewInboxRepository(dbConnection.DB).Add(ctx, notifications); err != nil {
+		return err
+	}
+	for i := range notifications {
+		event, err := realtime.NewEvent(eventInboxNotification, notifications[i])
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encode event", "event_type", eventInboxNotification, "error", err)
+			continue
+		}
+		hub.SendToUser(notifications[i].UserID, event)
+	}
+	return nil
+}
+
+// notifyInbox is addToInbox for callers that carry on regardless, the
+// notification being a courtesy next to what they did.
+func notifyInbox(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifications ...models.InboxNotification) {
+	if err := addToInbox(ctx, dbConnection, hub, notifications); err != nil {
+		slog.ErrorContext(ctx, "Failed to add notifications", "count", len(notifications), "error", err)
+	}
+}
+
+// recordMentions notifies the members of a conversation a new message
+// mentions by username, its sender aside.
+func recordMentions(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, message *models.Message, memberIDs []uuid.UUID) {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(message.Text, -1) {
+		usernames = append(usernames, strings.ToLower(match[1]))
+	}
+	if len(usernames) == 0 {
+		return
+	}
+	db := dbConnection.DB.WithContext(ctx)
+	var mentioned []uuid.UUID
+	err := db.Model(&models.User{}).
+		Where("id IN ? AND id <> ? AND LOWER(username) IN ?", memberIDs, message.SenderID, usernames).
+		Pluck("id", &mentioned).Error
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load mentioned members", "message_id", message.ID, "error", err)
+		return
+	}
+	if len(mentioned) == 0 {
+		return
+	}
+	var sender models.User
+	if err := db.First(&sender, "id = ?", message.SenderID).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load sender of mention", "sender_id", message.SenderID, "error", err)
+		return
+	}
+
+	shown := preview.Build(preview.Message{Sender: sender.DisplayName, Text: message.Text}, preview.Settings{Mode: preview.Full}, maxInboxBodyRunes)
+	notifications := make([]models.InboxNotification, 0, len(mentioned))
+	for _, userID := range mentioned {
+		notifications = append(notifications, models.InboxNotification{
+			UserID:         userID,
+			Kind:           models.InboxMention,
+			Title:          sender.DisplayName + " mentioned you",
+			Body:           shown.Body,
+			ActorID:        &message.SenderID,
+			ConversationID: &message.ConversationID,
+			MessageID:      &message.ID,
+		})
+	}
+	notifyInbox(ctx, dbConnection, hub, notifications...)
+}
+
+// refreshInboxCount tells a user's devices their unread count after
+// notifications were cleared.
+func refreshInboxCount(ctx context.Context, inbox *repositories.InboxRepository, hub *realtime.Hub, userID uuid.UUID) {
+	count, err := inbox.UnreadCount(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count unread notifications", "error", err)
+		return
+	}
+	sendInboxRead(hub, userID, inboxReadView{UnreadCount: count})
+}
+
+func sendInboxRead(hub *realtime.Hub, userID uuid.UUID, view inboxReadView) {
+	event, err := realtime.NewEvent(eventInboxRead, view)
+	if err != nil {
+		slog.Error("Failed to encode event", "event_type", eventInboxRead, "error", err)
+		return
+	}
+	hub.SendToUser(userID, event)
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Crockford alphabet without I, L, O and U so codes survive being read aloud.
+const inviteAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var ErrInvalidInvite = errors.New("invite code is invalid, expired or used up")
+
+type createInvitesRequest struct {
+	Count          int    `json:"count" binding:"required,min=1,max=1000"`
+	MaxUses        int    `json:"max_uses" binding:"omitempty,min=1,max=10000"`
+	ExpiresInHours int    `json:"expires_in_hours" binding:"omitempty,min=1"`
+	Note           string `json:"note" binding:"max=255"`
+}
+
+func generateInviteCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate invite code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = inviteAlphabet[int(b)%len(inviteAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// RedeemInvite consumes one use of code inside tx. The conditional update
+// keeps concurrent registrations from exceeding MaxUses.
+func RedeemInvite(tx *gorm.DB, code string) (*models.InviteCode, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil, ErrInvalidInvite
+	}
+
+	result := tx.Model(&models.InviteCode{}).
+		Where("code = ? AND uses < max_uses AND (expires_at IS NULL OR expires_at > ?)", code, time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to redeem invite: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidInvite
+	}
+
+	var invite models.InviteCode
+	if err := tx.Where("code = ?", code).First(&invite).Error; err != nil {
+		return nil, fmt.Errorf("failed to load invite: %w", err)
+	}
+	return &invite, nil
+}
+
+// CreateInviteBatch generates a batch of invite codes for a controlled
+// rollout wave.
+func CreateInviteBatch(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req createInvitesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "count must be between 1 and 1000",
+		})
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInHours > 0 {
+		t := time.Now().Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	batchID := uuid.New()
+	invites := make([]models.InviteCode, 0, req.Count)
+	for i := 0; i < req.Count; i++ {
+		code, err := generateInviteCode()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to generate invite codes",
+			})
+			return
+		}
+		invites = append(invites, models.InviteCode{
+			Code:        code,
+			BatchID:     batchID,
+			Note:        req.Note,
+			MaxUses:     req.MaxUses,
+			ExpiresAt:   expiresAt,
+			CreatedByID: CurrentUserID(c),
+		})
+	}
+
+	if err := dbConnection.DB.WithContext(c.Request.Context()).CreateInBatches(&invites, 200).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to create invite codes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"batch_id": batchID,
+		"invites":  invites,
+	})
+}
+
+// ListInvites lists invite codes, optionally filtered by batch.
+func ListInvites(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	query := dbConnection.DB.WithContext(c.Request.Context()).Order("created_at DESC").Limit(1000)
+	if batch := c.Query("batch_id"); batch != "" {
+		batchID, err := uuid.Parse(batch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "invalid batch_id",
+			})
+			return
+		}
+		query = query.Where("batch_id = ?", batchID)
+	}
+
+	var invites []models.InviteCode
+	if err := query.Find(&invites).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to list invite codes",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invites": invites,
+	})
+}
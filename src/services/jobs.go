@@ -0,0 +1,297 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+)
+
+const (
+	// jobPollInterval is how long an idle worker waits before looking for
+	// due jobs again.
+	jobPollInterval = 5 * time.Second
+
+	// jobTimeout bounds one run of a job. jobLease, after which a job
+	// still marked running is taken to be abandoned, must exceed it.
+	jobTimeout = 20 * time.Minute
+	jobLease   = 30 * time.Minute
+
+	// scheduleCheckInterval is how often the scheduler looks for periodic
+	// jobs that have fallen due.
+	scheduleCheckInterval = time.Minute
+
+	// maxJobAttempts is how often a failing job runs before it is given
+	// up on. Retries back off quadratically, in minutes.
+	maxJobAttempts = 5
+
+	// jobDepthInterval is how often the queue depth gauge is refreshed
+	// from the database.
+	jobDepthInterval = 30 * time.Second
+)
+
+// jobBuckets are histogram bounds, in seconds, for jobs, which may run
+// for anything from milliseconds to jobTimeout.
+var jobBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1200}
+
+// Job queue metrics. The depth counts jobs across all instances; the rest
+// count the jobs run on this one.
+var (
+	jobQueueDepth = metricsRegistry.Gauge("afrochat_job_queue_depth",
+		"Jobs in the queue, by kind and status: pending, running, or failed (given up on, awaiting inspection).", "kind", "status")
+	jobWait = metricsRegistry.Histogram("afrochat_job_wait_seconds",
+		"Time from a job falling due to a worker taking it, by kind.", jobBuckets, "kind")
+	jobDuration = metricsRegistry.Histogram("afrochat_job_duration_seconds",
+		"Time a job's handler ran, by kind.", jobBuckets, "kind")
+	jobRuns = metricsRegistry.Counter("afrochat_job_runs_total",
+		"Job runs, by kind and outcome: succeeded, retried (failed, to run again) or failed (given up on).", "kind", "outcome")
+)
+
+// JobHandler runs one job. Returning an error retries the job later,
+// unless it was the job's final attempt.
+type JobHandler func(ctx context.Context, job *models.Job) error
+
+// JobFailureHook is told of a job that was given up on, with the error of
+// its final attempt, so it can raise an alert.
+type JobFailureHook func(ctx context.Context, job *models.Job, err error)
+
+// JobRunner runs the jobs queued in the database on a pool of background
+// workers, for work too slow to do during a request, and queues periodic
+// jobs as they fall due.
+type JobRunner struct {
+	dbConnection *database.DatabaseConnection
+	handlers     map[string]JobHandler
+	schedules    []jobSchedule
+	failureHooks []JobFailureHook
+	stop         chan struct{}
+	workers      sync.WaitGroup
+
+	// measuredKinds are the kinds the queue depth gauge has been set for,
+	// by the goroutine measuring it.
+	measuredKinds map[string]bool
+}
+
+type jobSchedule struct {
+	kind     string
+	interval time.Duration
+}
+
+func NewJobRunner(dbConnection *database.DatabaseConnection) *JobRunner {
+	return &JobRunner{
+		dbConnection:  dbConnection,
+		handlers:      make(map[string]JobHandler),
+		measuredKinds: make(map[string]bool),
+		stop:          make(chan struct{}),
+	}
+}
+
+// Handle registers the handler for a kind of job. Handlers must be
+// registered before Start.
+func (r *JobRunner) Handle(kind string, handler JobHandler) {
+	r.handlers[kind] = handler
+}
+
+// Schedule registers the handler for a kind of job queued every interval,
+// across all instances together, rather than by requests. Schedules must be
+// registered before Start.
+func (r *JobRunner) Schedule(kind string, interval time.Duration, handler JobHandler) {
+	r.Handle(kind, handler)
+	r.schedules = append(r.schedules, jobSchedule{kind: kind, interval: interval})
+}
+
+// OnFailure registers a hook run whenever a job is given up on. Hooks must
+// be registered before Start.
+func (r *JobRunner) OnFailure(hook JobFailureHook) {
+	r.failureHooks = append(r.failureHooks, hook)
+}
+
+// Start runs workers goroutines taking due jobs, and the scheduler queueing
+// periodic ones, until Shutdown. It also keeps the queue depth gauge up to
+// date.
+func (r *JobRunner) Start(workers int) {
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		ticker := time.NewTicker(jobDepthInterval)
+		defer ticker.Stop()
+		for {
+			r.measureDepth()
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	if len(r.schedules) > 0 {
+		r.workers.Add(1)
+		go func() {
+			defer r.workers.Done()
+			ticker := time.NewTicker(scheduleCheckInterval)
+			defer ticker.Stop()
+			for {
+				r.queueScheduled()
+				select {
+				case <-r.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	for range workers {
+		r.workers.Add(1)
+		go func() {
+			defer r.workers.Done()
+			for {
+				if !r.runNext() {
+					select {
+					case <-r.stop:
+						return
+					case <-time.After(jobPollInterval):
+					}
+				}
+				select {
+				case <-r.stop:
+					return
+				default:
+				}
+			}
+		}()
+	}
+}
+
+// Shutdown stops taking jobs and waits for those running to finish, or for
+// ctx to end. Jobs cut short are picked up again once their lease runs
+// out.
+func (r *JobRunner) Shutdown(ctx context.Context) error {
+	close(r.stop)
+	done := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up on running jobs: %w", ctx.Err())
+	}
+}
+
+// queueScheduled queues the periodic jobs that have fallen due.
+func (r *JobRunner) queueScheduled() {
+	jobs := repositories.NewJobRepository(r.dbConnection.DB)
+	for _, schedule := range r.schedules {
+		queued, err := jobs.QueueIfDue(context.Background(), schedule.kind, schedule.interval)
+		if err != nil {
+			slog.Error("Failed to queue scheduled job", "kind", schedule.kind, "error", err)
+			continue
+		}
+		if queued {
+			slog.Debug("Queued scheduled job", "kind", schedule.kind)
+		}
+	}
+}
+
+// measureDepth sets the queue depth gauge from the database. Every kind
+// with a handler or measured before is set, so a queue that empties reads
+// zero.
+func (r *JobRunner) measureDepth() {
+	counts, err := repositories.NewJobRepository(r.dbConnection.DB).Counts(context.Background())
+	if err != nil {
+		slog.Error("Failed to measure job queue", "error", err)
+		return
+	}
+	for kind := range r.handlers {
+		r.measuredKinds[kind] = true
+	}
+	for _, count := range counts {
+		r.measuredKinds[count.Kind] = true
+	}
+	depth := make(map[[2]string]int64)
+	for kind := range r.measuredKinds {
+		for _, status := range []string{models.JobPending, models.JobRunning, models.JobFailed} {
+			depth[[2]string{kind, status}] = 0
+		}
+	}
+	for _, count := range counts {
+		depth[[2]string{count.Kind, count.Status}] = count.Count
+	}
+	for key, count := range depth {
+		jobQueueDepth.Set(float64(count), key[0], key[1])
+	}
+}
+
+// runNext runs the next due job, reporting whether there was one.
+func (r *JobRunner) runNext() bool {
+	jobs := repositories.NewJobRepository(r.dbConnection.DB)
+	job, err := jobs.Claim(context.Background(), jobLease)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		slog.Error("Failed to claim job", "error", err)
+		return false
+	}
+	if wait := time.Since(job.RunAt); wait > 0 {
+		jobWait.Observe(wait, job.Kind)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "jobs."+job.Kind, tracing.KindInternal,
+		tracing.String("job_id", job.ID.String()),
+		tracing.Int("attempt", int64(job.Attempts)))
+	defer span.End()
+
+	handler, ok := r.handlers[job.Kind]
+	if !ok {
+		err = fmt.Errorf("no handler for jobs of kind %q", job.Kind)
+	} else {
+		started := time.Now()
+		err = handler(ctx, job)
+		jobDuration.Observe(time.Since(started), job.Kind)
+	}
+	if err == nil {
+		jobRuns.Inc(job.Kind, "succeeded")
+		if err := jobs.Finish(ctx, job.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to finish job", "job_id", job.ID, "error", err)
+		}
+		return true
+	}
+
+	span.RecordError(err)
+	var retryAt *time.Time
+	if ok && !finalAttempt(job) {
+		next := time.Now().Add(time.Duration(job.Attempts*job.Attempts) * time.Minute)
+		retryAt = &next
+	}
+	slog.ErrorContext(ctx, "Job failed", "job_id", job.ID, "kind", job.Kind, "attempt", job.Attempts, "retrying", retryAt != nil, "error", err)
+	if err := jobs.Fail(ctx, job.ID, err, retryAt); err != nil {
+		slog.ErrorContext(ctx, "Failed to record job failure", "job_id", job.ID, "error", err)
+	}
+	if retryAt != nil {
+		jobRuns.Inc(job.Kind, "retried")
+		return true
+	}
+	jobRuns.Inc(job.Kind, "failed")
+	job.Status = models.JobFailed
+	job.LastError = err.Error()
+	for _, hook := range r.failureHooks {
+		hook(ctx, job, err)
+	}
+	return true
+}
+
+// finalAttempt reports whether a failure of this run gives up on the job.
+func finalAttempt(job *models.Job) bool {
+	return job.Attempts >= maxJobAttempts
+}
@@ -0,0 +1,435 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/dfunani/AfroChat/backend/pkg/crosssigning"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/prekeys"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// maxKeyBackupBytes caps an encrypted key backup. Keys are small; this
+	// leaves room for per-conversation session keys of a long history.
+	maxKeyBackupBytes = 4 << 20
+	maxKDFParamsBytes = 4 << 10
+
+	// maxOneTimePreKeys is how many one-time prekeys a device may hold.
+	maxOneTimePreKeys = 100
+
+	// lowOneTimePreKeys is the count below which claiming a device's
+	// prekeys tells its owner to upload more, with eventPreKeysLow.
+	lowOneTimePreKeys = 10
+	eventPreKeysLow   = "keys.prekeys_low"
+)
+
+var deviceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+type saveKeyBackupRequest struct {
+	// ExpectedVersion is the version of the backup being replaced, 0 when
+	// there is none
+	ExpectedVersion *int            `json:"expected_version" binding:"required,gte=0"`
+	Algorithm       string          `json:"algorithm" binding:"required,max=100"`
+	KDFParams       json.RawMessage `json:"kdf_params" binding:"required"`
+	Ciphertext      []byte          `json:"ciphertext" binding:"required"`
+}
+
+// SaveKeyBackup stores the current user's encrypted key backup, replacing
+// the version given as expected_version. Ciphertext is base64 encoded.
+func SaveKeyBackup(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req saveKeyBackupRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if len(req.Ciphertext) > maxKeyBackupBytes {
+			return nil, badRequest("ciphertext must be at most 4 MiB")
+		}
+		var params map[string]any
+		if len(req.KDFParams) > maxKDFParamsBytes || json.Unmarshal(req.KDFParams, &params) != nil || params == nil {
+			return nil, badRequest("kdf_params must be a JSON object of at most 4 KiB")
+		}
+
+		backup := &models.KeyBackup{
+			UserID:     CurrentUserID(c),
+			Algorithm:  req.Algorithm,
+			KDFParams:  models.JSON(req.KDFParams),
+			Ciphertext: req.Ciphertext,
+		}
+		err := repositories.NewKeyRepository(dbConnection.DB).SaveBackup(c.Request.Context(), backup, *req.ExpectedVersion)
+		if errors.Is(err, repositories.ErrStaleVersion) {
+			return nil, conflict("the key backup was replaced by another device; fetch it and try again")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save key backup", "error", err)
+			return nil, internalError("failed to save key backup")
+		}
+		return &Response{Data: backup, Legacy: gin.H{"backup": backup}}, nil
+	}
+}
+
+// GetKeyBackup returns the current user's encrypted key backup, for a new
+// device to decrypt with the user's passphrase.
+func GetKeyBackup(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		backup, err := repositories.NewKeyRepository(dbConnection.DB).Backup(c.Request.Context(), CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no key backup")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load key backup", "error", err)
+			return nil, internalError("failed to load key backup")
+		}
+		return &Response{Data: backup, Legacy: gin.H{"backup": backup}}, nil
+	}
+}
+
+// DeleteKeyBackup removes the current user's key backup.
+func DeleteKeyBackup(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		err := repositories.NewKeyRepository(dbConnection.DB).DeleteBackup(c.Request.Context(), CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no key backup")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete key backup", "error", err)
+			return nil, internalError("failed to delete key backup")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+type setCrossSigningKeyRequest struct {
+	PublicKey []byte `json:"public_key" binding:"required"`
+}
+
+// SetCrossSigningKey sets the current user's cross-signing public key.
+// Replacing it withdraws the signatures of every device, which must then
+// be signed again with the new key.
+func SetCrossSigningKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req setCrossSigningKeyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !crosssigning.ValidKey(req.PublicKey) {
+			return nil, badRequest("public_key must be a base64 Ed25519 public key")
+		}
+
+		key := &models.CrossSigningKey{UserID: CurrentUserID(c), PublicKey: req.PublicKey}
+		if err := repositories.NewKeyRepository(dbConnection.DB).SetCrossSigningKey(c.Request.Context(), key); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to set cross-signing key", "error", err)
+			return nil, internalError("failed to set cross-signing key")
+		}
+		return &Response{Data: key, Legacy: gin.H{"cross_signing_key": key}}, nil
+	}
+}
+
+type saveDeviceKeyRequest struct {
+	IdentityKey []byte `json:"identity_key" binding:"required"`
+	DisplayName string `json:"display_name" binding:"max=100"`
+}
+
+// SaveDeviceKey publishes the identity key of one of the current user's
+// devices. A changed key must be signed again.
+func SaveDeviceKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		deviceID := c.Param("device_id")
+		if !deviceIDPattern.MatchString(deviceID) {
+			return nil, badRequest("device id must be 1 to 64 letters, digits, dashes or underscores")
+		}
+		var req saveDeviceKeyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !crosssigning.ValidKey(req.IdentityKey) {
+			return nil, badRequest("identity_key must be a base64 Ed25519 public key")
+		}
+
+		device := &models.DeviceKey{
+			UserID:      CurrentUserID(c),
+			DeviceID:    deviceID,
+			DisplayName: req.DisplayName,
+			IdentityKey: req.IdentityKey,
+		}
+		if err := repositories.NewKeyRepository(dbConnection.DB).SaveDevice(c.Request.Context(), device); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save device key", "error", err)
+			return nil, internalError("failed to save device key")
+		}
+		return &Response{Data: device, Legacy: gin.H{"device": device}}, nil
+	}
+}
+
+type signDeviceRequest struct {
+	Signature []byte `json:"signature" binding:"required"`
+}
+
+// SignDevice stores the current user's cross-signing signature of one of
+// their devices, after checking it against their cross-signing key.
+func SignDevice(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req signDeviceRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		keys := repositories.NewKeyRepository(dbConnection.DB)
+		device, err := keys.Device(ctx, userID, c.Param("device_id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("device not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load device key", "error", err)
+			return nil, internalError("failed to sign device")
+		}
+		crossSigningKey, err := keys.CrossSigningKey(ctx, userID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, conflict("set a cross-signing key before signing devices")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load cross-signing key", "error", err)
+			return nil, internalError("failed to sign device")
+		}
+		if !crosssigning.Verify(crossSigningKey.PublicKey, req.Signature, userID, device.DeviceID, device.IdentityKey) {
+			return nil, badRequest("signature does not match the cross-signing key and device")
+		}
+
+		err = keys.SignDevice(ctx, device, req.Signature)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, conflict("the device key changed while signing; sign it again")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to sign device", "error", err)
+			return nil, internalError("failed to sign device")
+		}
+		return &Response{Data: device, Legacy: gin.H{"device": device}}, nil
+	}
+}
+
+// DeleteDeviceKey removes one of the current user's devices, so contacts
+// stop encrypting for it.
+func DeleteDeviceKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		err := repositories.NewKeyRepository(dbConnection.DB).DeleteDevice(c.Request.Context(), CurrentUserID(c), c.Param("device_id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("device not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete device key", "error", err)
+			return nil, internalError("failed to delete device key")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// UserKeys is the public key material of a user: their cross-signing key,
+// if set, and their devices, which others verify against it.
+type UserKeys struct {
+	CrossSigningKey []byte             `json:"cross_signing_key"`
+	Devices         []models.DeviceKey `json:"devices"`
+}
+
+// GetUserKeys returns a user's public keys, for others to encrypt to and
+// verify their devices.
+func GetUserKeys(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		keys := repositories.NewKeyRepository(dbConnection.DB)
+		view := UserKeys{}
+		crossSigningKey, err := keys.CrossSigningKey(ctx, userID)
+		switch {
+		case err == nil:
+			view.CrossSigningKey = crossSigningKey.PublicKey
+		case !errors.Is(err, repositories.ErrNotFound):
+			slog.ErrorContext(ctx, "Failed to load cross-signing key", "error", err)
+			return nil, internalError("failed to load keys")
+		}
+		if view.Devices, err = keys.Devices(ctx, userID); err != nil {
+			slog.ErrorContext(ctx, "Failed to load device keys", "error", err)
+			return nil, internalError("failed to load keys")
+		}
+		return &Response{Data: view, Legacy: gin.H{"keys": view}}, nil
+	}
+}
+
+// ownDevice loads the current user's device named by the :device_id
+// parameter.
+func ownDevice(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.DeviceKey, *APIError) {
+	device, err := repositories.NewKeyRepository(dbConnection.DB).Device(c.Request.Context(), CurrentUserID(c), c.Param("device_id"))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("device not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load device key", "error", err)
+		return nil, internalError("failed to load device")
+	}
+	return device, nil
+}
+
+type saveSignedPreKeyRequest struct {
+	KeyID     *int   `json:"key_id" binding:"required,gte=0"`
+	PublicKey []byte `json:"public_key" binding:"required"`
+	Signature []byte `json:"signature" binding:"required"`
+}
+
+// SaveSignedPreKey replaces the signed prekey of one of the current user's
+// devices, after checking its signature against the device's identity key.
+func SaveSignedPreKey(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		device, apiErr := ownDevice(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req saveSignedPreKeyRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !prekeys.ValidKey(req.PublicKey) {
+			return nil, badRequest("public_key must be a base64 X25519 public key")
+		}
+		if !prekeys.Verify(device.IdentityKey, req.Signature, device.UserID, device.DeviceID, *req.KeyID, req.PublicKey) {
+			return nil, badRequest("signature does not match the device's identity key")
+		}
+
+		preKey := &models.SignedPreKey{
+			DeviceKeyID: device.ID,
+			KeyID:       *req.KeyID,
+			PublicKey:   req.PublicKey,
+			Signature:   req.Signature,
+		}
+		if err := repositories.NewKeyRepository(dbConnection.DB).SaveSignedPreKey(c.Request.Context(), preKey); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to save signed prekey", "error", err)
+			return nil, internalError("failed to save signed prekey")
+		}
+		return &Response{Data: preKey, Legacy: gin.H{"signed_prekey": preKey}}, nil
+	}
+}
+
+type uploadOneTimePreKeysRequest struct {
+	PreKeys []oneTimePreKeyInput `json:"prekeys" binding:"required,min=1,max=100,dive"`
+}
+
+type oneTimePreKeyInput struct {
+	KeyID     *int   `json:"key_id" binding:"required,gte=0"`
+	PublicKey []byte `json:"public_key" binding:"required"`
+}
+
+// PreKeyCount is how many one-time prekeys a device has left.
+type PreKeyCount struct {
+	Count int64 `json:"count"`
+}
+
+// UploadOneTimePreKeys adds a batch of one-time prekeys to one of the
+// current user's devices, which may hold up to maxOneTimePreKeys. Keys
+// with an ID already in use are skipped.
+func UploadOneTimePreKeys(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		device, apiErr := ownDevice(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req uploadOneTimePreKeysRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		preKeys := make([]models.OneTimePreKey, 0, len(req.PreKeys))
+		for _, input := range req.PreKeys {
+			if !prekeys.ValidKey(input.PublicKey) {
+				return nil, badRequest("each public_key must be a base64 X25519 public key")
+			}
+			preKeys = append(preKeys, models.OneTimePreKey{KeyID: *input.KeyID, PublicKey: input.PublicKey})
+		}
+
+		count, err := repositories.NewKeyRepository(dbConnection.DB).AddOneTimePreKeys(c.Request.Context(), device.ID, preKeys, maxOneTimePreKeys)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("a device may hold at most 100 one-time prekeys")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to add one-time prekeys", "error", err)
+			return nil, internalError("failed to add one-time prekeys")
+		}
+		view := PreKeyCount{Count: count}
+		return &Response{Data: view, Legacy: gin.H{"count": count}}, nil
+	}
+}
+
+// CountOneTimePreKeys returns how many one-time prekeys one of the current
+// user's devices has left, for it to top them up.
+func CountOneTimePreKeys(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		device, apiErr := ownDevice(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		count, err := repositories.NewKeyRepository(dbConnection.DB).CountOneTimePreKeys(c.Request.Context(), device.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to count one-time prekeys", "error", err)
+			return nil, internalError("failed to count one-time prekeys")
+		}
+		view := PreKeyCount{Count: count}
+		return &Response{Data: view, Legacy: gin.H{"count": count}}, nil
+	}
+}
+
+// ClaimPreKeyBundles returns a prekey bundle for each of a user's devices,
+// for the current user to start encrypted sessions with them. Each claims
+// one of the device's one-time prekeys; a device that has run out is
+// still returned, without one. Devices running low are told with
+// eventPreKeysLow. Users blocking or blocked by the current user are
+// reported as not found.
+func ClaimPreKeyBundles(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		var count int64
+		if err := dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+			Where("id = ? AND is_banned = ?", userID, false).
+			Count(&count).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load user", "user_id", userID, "error", err)
+			return nil, internalError("failed to claim prekeys")
+		}
+		blocked, err := repositories.NewBlockRepository(dbConnection.DB).Between(ctx, CurrentUserID(c), userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check blocks", "user_id", userID, "error", err)
+			return nil, internalError("failed to claim prekeys")
+		}
+		if count == 0 || blocked {
+			return nil, notFound("user not found")
+		}
+
+		bundles, err := repositories.NewKeyRepository(dbConnection.DB).ClaimBundles(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to claim prekey bundles", "user_id", userID, "error", err)
+			return nil, internalError("failed to claim prekeys")
+		}
+		for _, bundle := range bundles {
+			if bundle.Remaining < lowOneTimePreKeys {
+				event, err := realtime.NewEvent(eventPreKeysLow, gin.H{"device_id": bundle.Device.DeviceID, "remaining": bundle.Remaining})
+				if err == nil {
+					hub.SendToUser(userID, event)
+				}
+			}
+		}
+		return &Response{Data: bundles, Legacy: gin.H{"bundles": bundles}}, nil
+	}
+}
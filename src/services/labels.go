@@ -0,0 +1,334 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxConversationLabels caps the labels of one business.
+	maxConversationLabels = 50
+
+	// defaultLabelColor is the color of labels created without one.
+	defaultLabelColor = "#9e9e9e"
+)
+
+type labelRequest struct {
+	Name  string `json:"name" binding:"required,max=50"`
+	Color string `json:"color" binding:"omitempty,len=7,hexcolor"`
+}
+
+// ListLabels returns the current user's conversation labels by name.
+func ListLabels(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		labels, err := repositories.NewBusinessRepository(dbConnection.DB).Labels(c.Request.Context(), profile.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list labels", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to list labels")
+		}
+		return &Response{Data: labels, Legacy: gin.H{"labels": labels}}, nil
+	}
+}
+
+// CreateLabel adds a label the current user's business can sort its
+// conversations with.
+func CreateLabel(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req labelRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		label := models.ConversationLabel{BusinessID: profile.ID}
+		if apiErr := req.apply(&label); apiErr != nil {
+			return nil, apiErr
+		}
+
+		err := repositories.NewBusinessRepository(dbConnection.DB).CreateLabel(c.Request.Context(), &label, maxConversationLabels)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("businesses may have at most " + strconv.Itoa(maxConversationLabels) + " labels")
+		}
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("you already have a label with this name")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create label", "business_id", profile.ID, "error", err)
+			return nil, internalError("failed to create label")
+		}
+		return &Response{Status: http.StatusCreated, Data: label, Legacy: gin.H{"label": label}}, nil
+	}
+}
+
+// UpdateLabel renames or recolors the current user's label named by the
+// :id parameter.
+func UpdateLabel(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid label id")
+		}
+		var req labelRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		businesses := repositories.NewBusinessRepository(dbConnection.DB)
+		label, err := businesses.Label(ctx, profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("label not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load label", "id", id, "error", err)
+			return nil, internalError("failed to update label")
+		}
+		if apiErr := req.apply(label); apiErr != nil {
+			return nil, apiErr
+		}
+		err = businesses.UpdateLabel(ctx, label)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("you already have a label with this name")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update label", "id", id, "error", err)
+			return nil, internalError("failed to update label")
+		}
+		return &Response{Data: label, Legacy: gin.H{"label": label}}, nil
+	}
+}
+
+// DeleteLabel removes the current user's label named by the :id
+// parameter, taking it off every conversation.
+func DeleteLabel(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid label id")
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err = repositories.NewBusinessRepository(dbConnection.DB).DeleteLabel(c.Request.Context(), profile.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("label not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete label", "id", id, "error", err)
+			return nil, internalError("failed to delete label")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListConversationLabels returns the labels the current user's business
+// applied to a conversation they belong to.
+func ListConversationLabels(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		profile, apiErr := currentBusiness(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		labels, err := repositories.NewBusinessRepository(dbConnection.DB).ConversationLabels(c.Request.Context(), profile.ID, conversation.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list conversation labels", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list labels")
+		}
+		return &Response{Data: labels, Legacy: gin.H{"labels": labels}}, nil
+	}
+}
+
+// ApplyLabel applies the current user's label named by the :labelId
+// parameter to a conversation they belong to.
+func ApplyLabel(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, label, apiErr := conversationLabel(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if err := repositories.NewBusinessRepository(dbConnection.DB).ApplyLabel(c.Request.Context(), label.ID, conversation.ID); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to apply label", "conversation_id", conversation.ID, "label_id", label.ID, "error", err)
+			return nil, internalError("failed to apply label")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// RemoveLabel takes the current user's label named by the :labelId
+// parameter off a conversation they belong to.
+func RemoveLabel(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, label, apiErr := conversationLabel(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err := repositories.NewBusinessRepository(dbConnection.DB).RemoveLabel(c.Request.Context(), label.ID, conversation.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("the conversation does not have this label")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to remove label", "conversation_id", conversation.ID, "label_id", label.ID, "error", err)
+			return nil, internalError("failed to remove label")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ExportLabeledConversations downloads the current user's labeled
+// conversations as CSV, most recently active first: one row each with the
+// person on the other end of direct conversations and every label applied.
+// ?label= narrows it down to the conversations with that label.
+func ExportLabeledConversations(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	profile, apiErr := currentBusiness(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	labelID, apiErr := labelFilter(c, dbConnection, profile)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	ctx := c.Request.Context()
+	labeled, err := repositories.NewBusinessRepository(dbConnection.DB).LabeledConversations(ctx, profile, labelID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to export labeled conversations", "business_id", profile.ID, "error", err)
+		abortWithError(c, internalError("failed to export conversations"))
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="conversations.csv"`)
+	c.Status(http.StatusOK)
+	w := csv.NewWriter(c.Writer)
+	_ = w.Write([]string{"conversation_id", "kind", "title", "contact_username", "contact_name", "labels", "last_message_at"})
+	for _, row := range labeled {
+		conversation := row.Conversation
+		var title, username, name, lastMessageAt string
+		if conversation.Title != nil {
+			title = *conversation.Title
+		}
+		if conversation.Kind == models.ConversationDirect {
+			for _, member := range conversation.Members {
+				if member.UserID != profile.UserID {
+					username, name = member.User.Username, member.User.DisplayName
+				}
+			}
+		}
+		if conversation.LastMessageAt != nil {
+			lastMessageAt = conversation.LastMessageAt.UTC().Format(time.RFC3339)
+		}
+		_ = w.Write([]string{
+			conversation.ID.String(),
+			conversation.Kind,
+			csvText(title),
+			csvText(username),
+			csvText(name),
+			csvText(strings.Join(row.Labels, "; ")),
+			lastMessageAt,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		slog.WarnContext(ctx, "Failed to write conversation export", "business_id", profile.ID, "error", err)
+	}
+}
+
+// labelFilter resolves the ?label= parameter to one of the business's
+// labels, returning uuid.Nil when it is not set.
+func labelFilter(c *gin.Context, dbConnection *database.DatabaseConnection, profile *models.BusinessProfile) (uuid.UUID, *APIError) {
+	raw := c.Query("label")
+	if raw == "" {
+		return uuid.Nil, nil
+	}
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, badRequest("invalid label id")
+	}
+	_, err = repositories.NewBusinessRepository(dbConnection.DB).Label(c.Request.Context(), profile.ID, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return uuid.Nil, notFound("label not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load label", "id", id, "error", err)
+		return uuid.Nil, internalError("failed to load label")
+	}
+	return id, nil
+}
+
+// conversationLabel loads the conversation named by the :id parameter,
+// which the current user must belong to, and their label named by the
+// :labelId parameter.
+func conversationLabel(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *models.ConversationLabel, *APIError) {
+	labelID, err := uuid.Parse(c.Param("labelId"))
+	if err != nil {
+		return nil, nil, badRequest("invalid label id")
+	}
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	profile, apiErr := currentBusiness(c, dbConnection)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+	label, err := repositories.NewBusinessRepository(dbConnection.DB).Label(c.Request.Context(), profile.ID, labelID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil, notFound("label not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load label", "id", labelID, "error", err)
+		return nil, nil, internalError("failed to load label")
+	}
+	return conversation, label, nil
+}
+
+// apply copies the request onto label.
+func (req *labelRequest) apply(label *models.ConversationLabel) *APIError {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return badRequest("name cannot be blank")
+	}
+	label.Name = name
+	label.Color = strings.ToLower(req.Color)
+	if label.Color == "" {
+		label.Color = defaultLabelColor
+	}
+	return nil
+}
+
+// csvText keeps a value a spreadsheet would take for a formula from being
+// run when the export is opened.
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// currentLegalDocumentsSQL selects the latest published version of each
+// legal document kind, without DISTINCT ON so it runs on SQLite too.
+const currentLegalDocumentsSQL = `
+	SELECT d.*
+	FROM legal_documents d
+	WHERE d.published_at = (
+		SELECT MAX(latest.published_at)
+		FROM legal_documents latest
+		WHERE latest.kind = d.kind AND latest.published_at <= @now
+	)`
+
+type acceptLegalRequest struct {
+	DocumentIDs []uuid.UUID `json:"document_ids" binding:"required,min=1,max=10"`
+}
+
+// CurrentLegalDocuments returns the latest published version of each legal
+// document kind.
+func CurrentLegalDocuments(db *gorm.DB, now time.Time) ([]models.LegalDocument, error) {
+	documents := []models.LegalDocument{}
+	err := db.Raw(currentLegalDocumentsSQL+`
+		ORDER BY d.kind`, map[string]any{"now": now}).
+		Scan(&documents).Error
+	return documents, err
+}
+
+// pendingLegalDocuments returns the current legal documents userID has not
+// accepted.
+func pendingLegalDocuments(db *gorm.DB, userID uuid.UUID, now time.Time) ([]models.LegalDocument, error) {
+	documents := []models.LegalDocument{}
+	err := db.Raw(currentLegalDocumentsSQL+`
+		AND NOT EXISTS (
+			SELECT 1 FROM legal_acceptances a
+			WHERE a.document_id = d.id AND a.user_id = @user
+		)
+		ORDER BY d.kind`, map[string]any{"now": now, "user": userID}).
+		Scan(&documents).Error
+	return documents, err
+}
+
+func ListLegalDocuments(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	documents, err := CurrentLegalDocuments(dbConnection.DB.WithContext(c.Request.Context()), time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load legal documents",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"documents": documents,
+	})
+}
+
+// RequireLegalAcceptance must run after AuthMiddleware. It answers 428 to
+// users who have not accepted the current version of every legal document,
+// so a newly published version is accepted before the app is used again.
+func RequireLegalAcceptance(dbConnection *database.DatabaseConnection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if apiErr := checkLegalAcceptance(c, dbConnection, CurrentUser(c)); apiErr != nil {
+			abortWithError(c, apiErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkLegalAcceptance returns the 428 for a user with legal documents
+// left to accept. Bots are not asked.
+func checkLegalAcceptance(c *gin.Context, dbConnection *database.DatabaseConnection, user *models.User) *APIError {
+	if user == nil || user.AccountType == models.AccountTypeBot {
+		return nil
+	}
+	pending, err := pendingLegalDocuments(dbConnection.DB.WithContext(c.Request.Context()), user.ID, time.Now())
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to check legal acceptance", "user_id", user.ID, "error", err)
+		return internalError("failed to check legal acceptance")
+	}
+	if len(pending) > 0 {
+		return &APIError{
+			Status:  http.StatusPreconditionRequired,
+			Code:    "legal_acceptance_required",
+			Message: "accept the current terms of service and privacy policy to continue",
+		}
+	}
+	return nil
+}
+
+// ListPendingLegalDocuments returns the current legal documents the user
+// has yet to accept.
+func ListPendingLegalDocuments(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		pending, err := pendingLegalDocuments(dbConnection.DB.WithContext(c.Request.Context()), CurrentUserID(c), time.Now())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load pending legal documents", "error", err)
+			return nil, internalError("failed to load legal documents")
+		}
+		return &Response{Data: pending, Legacy: gin.H{"documents": pending}}, nil
+	}
+}
+
+// AcceptLegalDocuments records that the user accepted the given legal
+// documents, with the address and client they accepted from as evidence.
+// Only current versions can be accepted; accepting one again keeps the
+// first acceptance. It returns the documents still pending.
+func AcceptLegalDocuments(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req acceptLegalRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		db := dbConnection.DB.WithContext(ctx)
+		now := time.Now()
+		current, err := CurrentLegalDocuments(db, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load legal documents", "error", err)
+			return nil, internalError("failed to load legal documents")
+		}
+		isCurrent := make(map[uuid.UUID]bool, len(current))
+		for _, document := range current {
+			isCurrent[document.ID] = true
+		}
+
+		userID := CurrentUserID(c)
+		acceptances := make([]models.LegalAcceptance, 0, len(req.DocumentIDs))
+		for _, documentID := range req.DocumentIDs {
+			if !isCurrent[documentID] {
+				return nil, conflict("only the current version of a legal document can be accepted")
+			}
+			acceptances = append(acceptances, models.LegalAcceptance{
+				UserID:     userID,
+				DocumentID: documentID,
+				IPAddress:  c.ClientIP(),
+				UserAgent:  truncate(c.Request.UserAgent(), 255),
+				AcceptedAt: now,
+			})
+		}
+		if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&acceptances).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to record legal acceptance", "user_id", userID, "error", err)
+			return nil, internalError("failed to record legal acceptance")
+		}
+
+		pending, err := pendingLegalDocuments(db, userID, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load pending legal documents", "error", err)
+			return nil, internalError("failed to load legal documents")
+		}
+		return &Response{Data: pending, Legacy: gin.H{"pending": pending}}, nil
+	}
+}
@@ -0,0 +1,520 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	JobLegalExport = "legal_export"
+
+	// maxLegalPage bounds a page of holds or audit entries.
+	maxLegalPage = 100
+)
+
+type legalExportJob struct {
+	ExportID uuid.UUID `json:"export_id"`
+}
+
+type placeLegalHoldRequest struct {
+	Reference      string     `json:"reference" binding:"required,max=100"`
+	Reason         string     `json:"reason" binding:"required,max=2000"`
+	UserID         *uuid.UUID `json:"user_id" binding:"required_without=ConversationID,excluded_with=ConversationID"`
+	ConversationID *uuid.UUID `json:"conversation_id"`
+}
+
+// auditLegal appends what the current user is doing to the lawful-request
+// audit log. Nothing is done or shown unless it was recorded.
+func auditLegal(c *gin.Context, dbConnection *database.DatabaseConnection, action string, holdID, exportID *uuid.UUID, detail string) *APIError {
+	err := repositories.NewLegalHoldRepository(dbConnection.DB).Audit(c.Request.Context(), &models.LegalAuditEntry{
+		ActorID:   CurrentUserID(c),
+		Action:    action,
+		HoldID:    holdID,
+		ExportID:  exportID,
+		IPAddress: c.ClientIP(),
+		Detail:    detail,
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to write legal audit entry", "action", action, "error", err)
+		return internalError("failed to record the request in the audit log")
+	}
+	return nil
+}
+
+// PlaceLegalHold places a hold on a user or a conversation, keeping its
+// messages from deletion until the hold is released.
+func PlaceLegalHold(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req placeLegalHoldRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		db := dbConnection.DB.WithContext(ctx)
+		if req.UserID != nil {
+			if err := db.Unscoped().Select("id").First(&models.User{}, "id = ?", *req.UserID).Error; err != nil {
+				return nil, notFound("user not found")
+			}
+		} else {
+			if err := db.Unscoped().Select("id").First(&models.Conversation{}, "id = ?", *req.ConversationID).Error; err != nil {
+				return nil, notFound("conversation not found")
+			}
+		}
+
+		hold := &models.LegalHold{
+			Reference:      req.Reference,
+			Reason:         req.Reason,
+			UserID:         req.UserID,
+			ConversationID: req.ConversationID,
+			PlacedByID:     CurrentUserID(c),
+		}
+		err := dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			holds := repositories.NewLegalHoldRepository(tx)
+			if err := holds.Create(ctx, hold); err != nil {
+				return err
+			}
+			return holds.Audit(ctx, &models.LegalAuditEntry{
+				ActorID:   hold.PlacedByID,
+				Action:    models.LegalAuditHoldPlaced,
+				HoldID:    &hold.ID,
+				IPAddress: c.ClientIP(),
+				Detail:    "reference " + hold.Reference,
+			})
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to place legal hold", "error", err)
+			return nil, internalError("failed to place legal hold")
+		}
+		return &Response{Status: http.StatusCreated, Data: hold, Legacy: gin.H{"hold": hold}}, nil
+	}
+}
+
+// ListLegalHolds returns a page of holds, newest first, or only those
+// still active with ?active=true.
+func ListLegalHolds(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxLegalPage, maxLegalPage)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("limit must be between 1 and %d", maxLegalPage))
+		}
+		if apiErr := auditLegal(c, dbConnection, models.LegalAuditHoldsListed, nil, nil, c.Request.URL.RawQuery); apiErr != nil {
+			return nil, apiErr
+		}
+
+		holds, err := repositories.NewLegalHoldRepository(dbConnection.DB).List(c.Request.Context(), c.Query("active") == "true", after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list legal holds", "error", err)
+			return nil, internalError("failed to list legal holds")
+		}
+		page := &Page{}
+		if len(holds) > limit {
+			holds = holds[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.LegalHoldCursor(&holds[limit-1]).Encode()
+		}
+		legacy := gin.H{"holds": holds}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: holds, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetLegalHold returns the hold named by the :id parameter.
+func GetLegalHold(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		hold, apiErr := loadLegalHold(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if apiErr := auditLegal(c, dbConnection, models.LegalAuditHoldViewed, &hold.ID, nil, ""); apiErr != nil {
+			return nil, apiErr
+		}
+		return &Response{Data: hold, Legacy: gin.H{"hold": hold}}, nil
+	}
+}
+
+// ReleaseLegalHold ends the hold named by the :id parameter. Erasures the
+// hold kept waiting are queued again.
+func ReleaseLegalHold(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid hold id")
+		}
+		ctx := c.Request.Context()
+		var hold *models.LegalHold
+		err = dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			holds := repositories.NewLegalHoldRepository(tx)
+			released, err := holds.Release(ctx, id, CurrentUserID(c))
+			if err != nil {
+				return err
+			}
+			hold = released
+			err = holds.Audit(ctx, &models.LegalAuditEntry{
+				ActorID:   CurrentUserID(c),
+				Action:    models.LegalAuditHoldReleased,
+				HoldID:    &hold.ID,
+				IPAddress: c.ClientIP(),
+			})
+			if err != nil || hold.UserID == nil {
+				return err
+			}
+			return requeueHeldErasure(ctx, tx, *hold.UserID)
+		})
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no active legal hold with this id")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to release legal hold", "hold_id", id, "error", err)
+			return nil, internalError("failed to release legal hold")
+		}
+		return &Response{Data: hold, Legacy: gin.H{"hold": hold}}, nil
+	}
+}
+
+// requeueHeldErasure queues the erasure of a user again if one is waiting
+// and no other hold keeps it waiting.
+func requeueHeldErasure(ctx context.Context, tx *gorm.DB, userID uuid.UUID) error {
+	held, err := repositories.NewLegalHoldRepository(tx).UserHeld(ctx, userID)
+	if err != nil || held {
+		return err
+	}
+	var waiting int64
+	err = tx.WithContext(ctx).Model(&models.AccountErasure{}).
+		Where("user_id = ? AND completed_at IS NULL", userID).
+		Count(&waiting).Error
+	if err != nil || waiting == 0 {
+		return err
+	}
+	_, err = repositories.NewJobRepository(tx).Enqueue(ctx, JobAccountErasure, accountErasureJob{UserID: userID})
+	return err
+}
+
+func loadLegalHold(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.LegalHold, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid hold id")
+	}
+	hold, err := repositories.NewLegalHoldRepository(dbConnection.DB).Get(c.Request.Context(), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("legal hold not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load legal hold", "hold_id", id, "error", err)
+		return nil, internalError("failed to load legal hold")
+	}
+	return hold, nil
+}
+
+// RequestLegalExport starts building an archive of what the hold named by
+// the :id parameter preserves, answering 202 with the pending export.
+func RequestLegalExport(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		hold, apiErr := loadLegalHold(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		export := &models.LegalExport{HoldID: hold.ID, RequestedByID: CurrentUserID(c)}
+		err := dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			holds := repositories.NewLegalHoldRepository(tx)
+			if err := holds.CreateExport(ctx, export); err != nil {
+				return err
+			}
+			err := holds.Audit(ctx, &models.LegalAuditEntry{
+				ActorID:   export.RequestedByID,
+				Action:    models.LegalAuditExportCreated,
+				HoldID:    &hold.ID,
+				ExportID:  &export.ID,
+				IPAddress: c.ClientIP(),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = repositories.NewJobRepository(tx).Enqueue(ctx, JobLegalExport, legalExportJob{ExportID: export.ID})
+			return err
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to request legal export", "hold_id", hold.ID, "error", err)
+			return nil, internalError("failed to request legal export")
+		}
+		return &Response{Status: http.StatusAccepted, Data: export, Legacy: gin.H{"export": export}}, nil
+	}
+}
+
+// GetLegalExport reports on the export named by the :id parameter.
+func GetLegalExport(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		export, apiErr := loadLegalExport(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if apiErr := auditLegal(c, dbConnection, models.LegalAuditExportViewed, &export.HoldID, &export.ID, ""); apiErr != nil {
+			return nil, apiErr
+		}
+		return &Response{Data: export, Legacy: gin.H{"export": export}}, nil
+	}
+}
+
+// DownloadLegalExport streams a ready export through the API. It is never
+// handed out as a presigned URL, so every download is made by legal staff
+// and recorded.
+func DownloadLegalExport(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage) {
+	export, apiErr := loadLegalExport(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if export.Status != models.LegalExportReady {
+		abortWithError(c, conflict("the legal export is not ready"))
+		return
+	}
+	if apiErr := auditLegal(c, dbConnection, models.LegalAuditExportDownload, &export.HoldID, &export.ID, ""); apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+
+	body, err := store.Open(c.Request.Context(), *export.StorageKey)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to open legal export", "export_id", export.ID, "error", err)
+		abortWithError(c, internalError("failed to load legal export"))
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, export.SizeBytes, dataExportContentType, body, map[string]string{
+		"Content-Disposition": `attachment; filename="legal-export-` + export.ID.String() + `.zip"`,
+		"Cache-Control":       "no-store",
+	})
+}
+
+func loadLegalExport(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.LegalExport, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid export id")
+	}
+	export, err := repositories.NewLegalHoldRepository(dbConnection.DB).GetExport(c.Request.Context(), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("legal export not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load legal export", "export_id", id, "error", err)
+		return nil, internalError("failed to load legal export")
+	}
+	return export, nil
+}
+
+// ListLegalAudit returns a page of the lawful-request audit log, newest
+// first, or only the entries about one hold with ?hold_id=. Reading the
+// log is recorded in it too.
+func ListLegalAudit(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var holdID *uuid.UUID
+		if raw := c.Query("hold_id"); raw != "" {
+			id, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, badRequest("invalid hold_id")
+			}
+			holdID = &id
+		}
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), maxLegalPage, maxLegalPage)
+		if err != nil {
+			return nil, badRequest(fmt.Sprintf("limit must be between 1 and %d", maxLegalPage))
+		}
+		if apiErr := auditLegal(c, dbConnection, models.LegalAuditLogViewed, holdID, nil, ""); apiErr != nil {
+			return nil, apiErr
+		}
+
+		entries, err := repositories.NewLegalHoldRepository(dbConnection.DB).AuditLog(c.Request.Context(), holdID, after, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list legal audit entries", "error", err)
+			return nil, internalError("failed to list the audit log")
+		}
+		page := &Page{}
+		if len(entries) > limit {
+			entries = entries[:limit]
+			page.HasMore = true
+			page.NextCursor = repositories.LegalAuditCursor(&entries[limit-1]).Encode()
+		}
+		legacy := gin.H{"entries": entries}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: entries, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// BuildLegalExport is the job writing a legal export: a zip archive with
+// hold.json, messages.json holding every message of the hold's subject,
+// deleted ones as tombstones, preserved.json holding the preserved copies
+// of those deleted under the hold, and the attachments of the messages
+// under attachments/.
+func BuildLegalExport(dbConnection *database.DatabaseConnection, store storage.Storage) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload legalExportJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		holds := repositories.NewLegalHoldRepository(dbConnection.DB)
+		export, err := holds.GetExport(ctx, payload.ExportID)
+		if err != nil {
+			return err
+		}
+		if export.Status != models.LegalExportPending {
+			return nil
+		}
+		hold, err := holds.Get(ctx, export.HoldID)
+		if err != nil {
+			return err
+		}
+
+		err = buildLegalExport(ctx, dbConnection, store, holds, hold, export)
+		if err != nil && finalAttempt(job) {
+			if err := holds.FinishExport(ctx, export, "", 0); err != nil {
+				slog.ErrorContext(ctx, "Failed to mark legal export failed", "export_id", export.ID, "error", err)
+			}
+		}
+		return err
+	}
+}
+
+func buildLegalExport(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, holds *repositories.LegalHoldRepository, hold *models.LegalHold, export *models.LegalExport) error {
+	file, err := os.CreateTemp("", "afrochat-legal-export-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	if err := writeExportJSON(archive, "hold.json", hold); err != nil {
+		return err
+	}
+	var messageIDs []uuid.UUID
+	err = writeLegalExportList(archive, "messages.json", func(after pagination.Cursor) ([]models.Message, pagination.Cursor, error) {
+		batch, err := holds.Messages(ctx, hold, after, exportBatchSize)
+		if len(batch) > 0 {
+			last := batch[len(batch)-1]
+			after = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}
+		}
+		for _, message := range batch {
+			messageIDs = append(messageIDs, message.ID)
+		}
+		return batch, after, err
+	})
+	if err != nil {
+		return err
+	}
+	err = writeLegalExportList(archive, "preserved.json", func(after pagination.Cursor) ([]models.PreservedMessage, pagination.Cursor, error) {
+		batch, err := holds.Preserved(ctx, hold, after, exportBatchSize)
+		if len(batch) > 0 {
+			last := batch[len(batch)-1]
+			after = pagination.Cursor{Time: last.SentAt, ID: last.ID}
+		}
+		return batch, after, err
+	})
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(messageIDs); start += exportBatchSize {
+		var attachments []models.Attachment
+		err := dbConnection.DB.WithContext(ctx).Unscoped().
+			Where("message_id IN ? AND status = ?", messageIDs[start:min(start+exportBatchSize, len(messageIDs))], models.AttachmentReady).
+			Order("created_at").
+			Find(&attachments).Error
+		if err != nil {
+			return fmt.Errorf("failed to list attachments: %w", err)
+		}
+		for _, attachment := range attachments {
+			if err := writeExportFile(ctx, archive, store, "attachments/"+attachment.ID.String()+"/"+path.Base(attachment.FileName), attachment.StorageKey); err != nil {
+				return err
+			}
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return fmt.Errorf("failed to finish export archive: %w", err)
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to size export file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind export file: %w", err)
+	}
+	key := "legal-exports/" + hold.ID.String() + "/" + export.ID.String()
+	if err := store.Put(ctx, key, file, size, dataExportContentType); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+	if err := holds.FinishExport(ctx, export, key, size); err != nil {
+		deleteObject(store, key)
+		return err
+	}
+	return nil
+}
+
+// writeLegalExportList writes a JSON array to the archive a batch at a
+// time, calling next with the cursor it returned last until a batch
+// comes back short.
+func writeLegalExportList[T any](archive *zip.Writer, name string, next func(after pagination.Cursor) ([]T, pagination.Cursor, error)) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to add %s to export: %w", name, err)
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	var after pagination.Cursor
+	first := true
+	for {
+		batch, cursor, err := next(after)
+		if err != nil {
+			return err
+		}
+		for _, item := range batch {
+			if !first {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+			if err := encoder.Encode(item); err != nil {
+				return fmt.Errorf("failed to write %s: %w", name, err)
+			}
+		}
+		if len(batch) < exportBatchSize {
+			break
+		}
+		after = cursor
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/loadshed"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// loadSampleInterval is how often the server's load is sampled.
+	loadSampleInterval = time.Second
+
+	// shedRetryAfter is the Retry-After, in seconds, of shed requests.
+	shedRetryAfter = 5
+)
+
+var requestsShed = metricsRegistry.Counter("afrochat_requests_shed_total",
+	"Low-priority requests turned away because the server was near saturation.", "priority")
+
+// NewLoadShedder watches scheduler lag, the database pool and the
+// realtime send queues. Start it with Run.
+func NewLoadShedder(appConfig *config.ApplicationConfig, dbConnection *database.DatabaseConnection, hub *realtime.Hub) *loadshed.Shedder {
+	shedder := loadshed.New(loadshed.Config{
+		Interval:        loadSampleInterval,
+		MaxSchedulerLag: appConfig.LoadShedMaxLag,
+	})
+	shedder.Watch("database_pool", poolPressure(dbConnection.SQLDB))
+	shedder.Watch("realtime_queues", hub.QueueFill)
+	return shedder
+}
+
+// poolPressure measures the database pool by the share of its connections
+// in use and by how long requests waited for one between samples: on
+// average one request always waiting counts as saturated. A pool of one
+// connection, as with SQLite, is measured by waiting alone.
+func poolPressure(db *sql.DB) loadshed.Signal {
+	var mu sync.Mutex
+	last, lastAt := db.Stats(), time.Now()
+	return func() float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		stats, now := db.Stats(), time.Now()
+		waiting := float64(stats.WaitDuration-last.WaitDuration) / float64(now.Sub(lastAt))
+		last, lastAt = stats, now
+		if stats.MaxOpenConnections <= 1 {
+			return waiting
+		}
+		return max(waiting, float64(stats.InUse)/float64(stats.MaxOpenConnections))
+	}
+}
+
+// Shed answers 503 to a growing fraction of requests of the given
+// priority as the server nears saturation, leaving its capacity to
+// messaging. A nil shedder sheds nothing.
+func Shed(shedder *loadshed.Shedder, priority loadshed.Priority) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if shedder == nil || !shedder.Shed(priority) {
+			c.Next()
+			return
+		}
+		requestsShed.Inc(priority.String())
+		c.Header("Retry-After", strconv.Itoa(shedRetryAfter))
+		abortWithError(c, serviceUnavailable("the service is busy; try again shortly"))
+	}
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// defaultPollWait and maxPollWait bound how long a poll is held open
+	// waiting for an event, below the idle timeouts of common proxies.
+	defaultPollWait = 25 * time.Second
+	maxPollWait     = 30 * time.Second
+
+	// maxPolledEvents is the most events returned by one poll.
+	maxPolledEvents = 100
+)
+
+// LongPollView is a newly opened long-polling connection.
+type LongPollView struct {
+	ConnectionID uuid.UUID `json:"connection_id"`
+	Timeout      int       `json:"timeout"`
+}
+
+// PollBatch is the events collected by one poll. Cursor is passed as after
+// on the next poll to acknowledge them.
+type PollBatch struct {
+	Events []realtime.PolledEvent `json:"events"`
+	Cursor int64                  `json:"cursor"`
+}
+
+// OpenLongPoll opens a realtime connection for clients that cannot hold a
+// WebSocket, such as those behind proxies that strip upgrades. It receives
+// the events a WebSocket would, starting with connected, collected with
+// PollLongPoll. A connection not polled for a minute is closed.
+func OpenLongPoll(hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		// A connection both receives and sends, as a socket does.
+		if !CurrentClaims(c).HasScope(auth.ScopeRead) {
+			return nil, forbidden("token lacks the read and write scopes")
+		}
+		client, err := hub.OpenPoll(CurrentUserID(c), CurrentClaims(c).Session())
+		if errors.Is(err, realtime.ErrHubClosing) {
+			return nil, serviceUnavailable("server is restarting; try again")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to open long poll", "error", err)
+			return nil, internalError("failed to open long poll")
+		}
+		view := LongPollView{ConnectionID: client.ID, Timeout: int(maxPollWait / time.Second)}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"connection_id": view.ConnectionID, "timeout": view.Timeout}}, nil
+	}
+}
+
+// PollLongPoll returns the events queued for the long-polling connection
+// named by the :id parameter, holding the request for up to timeout
+// seconds (25 by default, 30 at most) when there are none. after
+// acknowledges the events up to that cursor; events not acknowledged are
+// returned again, so a response lost on the way loses nothing. A
+// connection that is gone answers 410, and the client opens a new one and
+// catches up with sync.
+func PollLongPoll(hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		connectionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid connection id")
+		}
+		var after int64
+		if value := c.Query("after"); value != "" {
+			if after, err = strconv.ParseInt(value, 10, 64); err != nil || after < 0 {
+				return nil, badRequest("invalid after cursor")
+			}
+		}
+		wait := defaultPollWait
+		if value := c.Query("timeout"); value != "" {
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return nil, badRequest("invalid timeout")
+			}
+			wait = min(time.Duration(seconds)*time.Second, maxPollWait)
+		}
+
+		events, err := hub.Poll(c.Request.Context(), CurrentUserID(c), connectionID, after, wait, maxPolledEvents)
+		if errors.Is(err, realtime.ErrPollNotFound) {
+			return nil, gone("connection closed; open a new one")
+		}
+		if errors.Is(err, realtime.ErrPollBusy) {
+			return nil, conflict("connection is already being polled")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to poll", "connection_id", connectionID, "error", err)
+			return nil, internalError("failed to poll")
+		}
+		batch := PollBatch{Events: events, Cursor: after}
+		if len(events) > 0 {
+			batch.Cursor = events[len(events)-1].Seq
+		}
+		return &Response{Data: batch, Legacy: gin.H{"events": batch.Events, "cursor": batch.Cursor}}, nil
+	}
+}
+
+// SendLongPollEvent handles an event from the long-polling connection
+// named by the :id parameter as if it came over a WebSocket, as typing
+// indicators do. Replies arrive on the next poll.
+func SendLongPollEvent(hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		connectionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid connection id")
+		}
+		var event realtime.Event
+		if apiErr := bindJSON(c, &event); apiErr != nil {
+			return nil, apiErr
+		}
+		if event.Type == "" {
+			return nil, badRequest("event type is required")
+		}
+		if err := hub.PollSend(CurrentUserID(c), connectionID, event); errors.Is(err, realtime.ErrPollNotFound) {
+			return nil, gone("connection closed; open a new one")
+		}
+		return &Response{Status: http.StatusAccepted}, nil
+	}
+}
+
+// CloseLongPoll closes the long-polling connection named by the :id
+// parameter, as closing a WebSocket would.
+func CloseLongPoll(hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		connectionID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid connection id")
+		}
+		if err := hub.ClosePoll(CurrentUserID(c), connectionID); errors.Is(err, realtime.ErrPollNotFound) {
+			return nil, notFound("connection not found")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+)
+
+// Periodic housekeeping, scheduled on the job runner.
+const (
+	JobPruneDevices     = "prune_devices"
+	JobPurgeCredentials = "purge_credentials"
+	JobPurgeDataExports = "purge_data_exports"
+	JobPurgeTranscripts = "purge_transcripts"
+	JobPurgeFailedJobs  = "purge_failed_jobs"
+	JobPurgeInbox       = "purge_inbox"
+
+	// JobMessagePartitions creates the monthly message partitions ahead
+	// of time and drops those past the retention period, on Postgres.
+	JobMessagePartitions     = "message_partitions"
+	MessagePartitionInterval = 24 * time.Hour
+
+	// messagePartitionsAhead is how many months past the current one have
+	// their partitions ready.
+	messagePartitionsAhead = 3
+
+	// MaintenanceInterval is how often the purges other than device
+	// pruning run.
+	MaintenanceInterval = 6 * time.Hour
+
+	// credentialRetention is how long ended sessions and spent
+	// verification tokens are kept, so recent sign-outs still show up
+	// when a user reviews their devices.
+	credentialRetention = 30 * 24 * time.Hour
+
+	// failedRetention is how long jobs and data exports that failed are
+	// kept for inspection.
+	failedRetention = 30 * 24 * time.Hour
+
+	// inboxRetention is how long notifications stay in the notification
+	// center.
+	inboxRetention = 90 * 24 * time.Hour
+)
+
+// PurgeCredentials is the scheduled job deleting sessions and
+// verification tokens that stopped working over credentialRetention ago,
+// and expired USSD sessions.
+func PurgeCredentials(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		before := time.Now().Add(-credentialRetention)
+		sessions, err := repositories.NewSessionRepository(dbConnection.DB).PurgeEnded(ctx, before)
+		if err != nil {
+			return err
+		}
+		tokens, err := repositories.NewVerificationTokenRepository(dbConnection.DB).PurgeSpent(ctx, before)
+		if err != nil {
+			return err
+		}
+		emailChanges, err := repositories.NewEmailChangeRepository(dbConnection.DB).PurgeSettled(ctx, before)
+		if err != nil {
+			return err
+		}
+		// USSD sessions last minutes, so expired ones go at once.
+		ussdSessions, err := repositories.NewUSSDSessionRepository(dbConnection.DB).PurgeExpired(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "Purged ended credentials", "sessions", sessions, "verification_tokens", tokens, "email_changes", emailChanges, "ussd_sessions", ussdSessions)
+		return nil
+	}
+}
+
+// PurgeDataExports is the scheduled job deleting expired data exports with
+// their archives, and failed ones past failedRetention.
+func PurgeDataExports(dbConnection *database.DatabaseConnection, store storage.Storage) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		purged, err := repositories.NewDataExportRepository(dbConnection.DB).PurgeExpired(ctx, time.Now().Add(-failedRetention))
+		if err != nil {
+			return err
+		}
+		for _, export := range purged {
+			if export.StorageKey != nil {
+				deleteObject(store, *export.StorageKey)
+			}
+		}
+		if len(purged) > 0 {
+			slog.InfoContext(ctx, "Purged data exports", "count", len(purged))
+		}
+		return nil
+	}
+}
+
+// PurgeTranscripts is the scheduled job deleting expired transcripts with
+// their documents, and failed ones past failedRetention.
+func PurgeTranscripts(dbConnection *database.DatabaseConnection, store storage.Storage) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		purged, err := repositories.NewTranscriptRepository(dbConnection.DB).PurgeExpired(ctx, time.Now().Add(-failedRetention))
+		if err != nil {
+			return err
+		}
+		for _, transcript := range purged {
+			if transcript.StorageKey != nil {
+				deleteObject(store, *transcript.StorageKey)
+			}
+		}
+		if len(purged) > 0 {
+			slog.InfoContext(ctx, "Purged transcripts", "count", len(purged))
+		}
+		return nil
+	}
+}
+
+// PurgeFailedJobs is the scheduled job deleting jobs given up on over
+// failedRetention ago.
+func PurgeFailedJobs(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		purged, err := repositories.NewJobRepository(dbConnection.DB).PurgeFailed(ctx, time.Now().Add(-failedRetention))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			slog.InfoContext(ctx, "Purged failed jobs", "count", purged)
+		}
+		return nil
+	}
+}
+
+// PurgeInbox is the scheduled job deleting notifications older than
+// inboxRetention, read or not.
+func PurgeInbox(dbConnection *database.DatabaseConnection) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		purged, err := repositories.NewInboxRepository(dbConnection.DB).Purge(ctx, time.Now().Add(-inboxRetention))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			slog.InfoContext(ctx, "Purged notifications", "count", purged)
+		}
+		return nil
+	}
+}
+
+// MaintainMessagePartitions is the scheduled job keeping the monthly
+// message partitions messagePartitionsAhead months ahead and, with a
+// retention, dropping the months that ended longer than it ago. A month
+// holding messages under a legal hold is kept until the hold is released.
+func MaintainMessagePartitions(dbConnection *database.DatabaseConnection, retention time.Duration) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		partitions := repositories.NewMessagePartitionRepository(dbConnection.DB)
+		now := time.Now().UTC()
+		created, err := partitions.Ensure(ctx, now, now.AddDate(0, messagePartitionsAhead, 0))
+		if err != nil {
+			return err
+		}
+		if created > 0 {
+			slog.InfoContext(ctx, "Created message partitions", "count", created)
+		}
+		if retention <= 0 {
+			return nil
+		}
+
+		existing, err := partitions.List(ctx)
+		if err != nil {
+			return err
+		}
+		cutoff := now.Add(-retention)
+		for _, partition := range existing {
+			if partition.To.After(cutoff) {
+				break
+			}
+			held, err := partitions.Held(ctx, partition)
+			if err != nil {
+				return err
+			}
+			if held {
+				slog.InfoContext(ctx, "Keeping message partition under legal hold", "partition", partition.Name)
+				continue
+			}
+			if err := partitions.Drop(ctx, partition); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "Dropped message partition", "partition", partition.Name)
+		}
+		return nil
+	}
+}
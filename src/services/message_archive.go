@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/archive"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/google/uuid"
+)
+
+const (
+	JobArchiveMessages = "archive_messages"
+
+	// MessageArchiveInterval is how often old messages are archived and
+	// segments compacted.
+	MessageArchiveInterval = time.Hour
+
+	// archiveSegmentSize is how many messages a segment holds at most,
+	// and archiveSegmentsPerRun how many a run writes, so a backlog is
+	// worked through over several runs.
+	archiveSegmentSize    = 1000
+	archiveSegmentsPerRun = 200
+
+	// compactSegmentsPerRun is how many segments a run rewrites without
+	// the messages deleted or erased since they were archived.
+	compactSegmentsPerRun = 100
+)
+
+// ArchiveMessages is the scheduled job moving the content of messages sent
+// over after ago into compressed segments in object storage, a
+// conversation's messages at a time, where the archive plugin fetches it
+// from when they are read. Archived messages no longer match a Postgres
+// search. The job then rewrites segments holding content that was
+// deleted or erased since, so it does not outlive the messages.
+func ArchiveMessages(dbConnection *database.DatabaseConnection, store storage.Storage, messageArchive *archive.Archive, after time.Duration) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		segments := repositories.NewMessageArchiveRepository(dbConnection.DB)
+		before := time.Now().Add(-after)
+		conversations, err := segments.Conversations(ctx, before, archiveSegmentsPerRun)
+		if err != nil {
+			return err
+		}
+
+		written, archived := 0, 0
+		for _, conversationID := range conversations {
+			key, err := archiveKey(ctx, dbConnection, store, conversationID)
+			if err != nil {
+				return err
+			}
+			for written < archiveSegmentsPerRun {
+				var stored *uuid.UUID
+				count, err := segments.Archive(ctx, conversationID, before, archiveSegmentSize,
+					func(id uuid.UUID, messages []models.Message) (string, int64, error) {
+						entries := make([]archive.Entry, len(messages))
+						for i := range messages {
+							entries[i] = archive.EntryOf(&messages[i])
+						}
+						stored = &id
+						size, err := messageArchive.Write(ctx, id, key(id), entries)
+						return key(id), size, err
+					})
+				if err != nil {
+					// The segment was stored but never recorded.
+					if stored != nil {
+						if err := messageArchive.Delete(ctx, *stored, key(*stored)); err != nil {
+							slog.WarnContext(ctx, "Failed to delete unrecorded archive segment", "segment_id", *stored, "error", err)
+						}
+					}
+					return err
+				}
+				if count == 0 {
+					break
+				}
+				written++
+				archived += count
+				if count < archiveSegmentSize {
+					break
+				}
+			}
+		}
+
+		compacted, err := compactArchive(ctx, segments, messageArchive)
+		if err != nil {
+			return err
+		}
+		if archived > 0 || compacted > 0 {
+			slog.InfoContext(ctx, "Archived messages", "messages", archived, "segments", written, "compacted", compacted)
+		}
+		return nil
+	}
+}
+
+// archiveKey returns where the segments of a conversation go: in the
+// residency region of the user who created it, as room exports are.
+func archiveKey(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, conversationID uuid.UUID) (func(uuid.UUID) string, error) {
+	var region string
+	err := dbConnection.DB.WithContext(ctx).Unscoped().Model(&models.User{}).
+		Select("residency").
+		Where("id = (?)", dbConnection.DB.Model(&models.Conversation{}).Select("created_by_id").Where("id = ?", conversationID)).
+		Scan(&region).Error
+	if err != nil {
+		return nil, err
+	}
+	return func(id uuid.UUID) string {
+		return storage.ResidentKey(store, residency.Region(region), archive.Key(conversationID, id))
+	}, nil
+}
+
+// compactArchive rewrites the segments fewer messages point to than they
+// hold, deleting those no message points to, and returns how many it
+// rewrote or deleted.
+func compactArchive(ctx context.Context, segments *repositories.MessageArchiveRepository, messageArchive *archive.Archive) (int, error) {
+	stale, err := segments.Stale(ctx, compactSegmentsPerRun)
+	if err != nil {
+		return 0, err
+	}
+	for _, segment := range stale {
+		ids, err := segments.MessageIDs(ctx, segment.ID)
+		if err != nil {
+			return 0, err
+		}
+		if len(ids) == 0 {
+			if err := messageArchive.Delete(ctx, segment.ID, segment.StorageKey); err != nil {
+				return 0, err
+			}
+			if err := segments.Delete(ctx, segment.ID); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		content, err := messageArchive.Read(ctx, segment.ID, segment.StorageKey)
+		if err != nil {
+			return 0, err
+		}
+		entries := make([]archive.Entry, 0, len(ids))
+		for _, id := range ids {
+			if entry, ok := content[id]; ok {
+				entries = append(entries, entry)
+			}
+		}
+		size, err := messageArchive.Write(ctx, segment.ID, segment.StorageKey, entries)
+		if err != nil {
+			return 0, err
+		}
+		if err := segments.Shrink(ctx, segment.ID, len(entries), size); err != nil {
+			return 0, err
+		}
+	}
+	return len(stale), nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type editMessageRequest struct {
+	Text string `json:"text"`
+}
+
+// EditMessage replaces the text of one of the current user's text
+// messages, keeping the replaced text in its edit history, and sends the
+// edited message to the members as "message.edited". Messages in audit
+// rooms cannot be edited.
+func EditMessage(dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, conversation, apiErr := memberMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		if message.SenderID != userID {
+			return nil, forbidden("only the sender can edit a message")
+		}
+		if conversation.Audited {
+			return nil, forbidden("messages in audit rooms cannot be edited")
+		}
+		if message.Type != string(content.TypeText) {
+			return nil, badRequest("only text messages can be edited")
+		}
+
+		var req editMessageRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		limits, err := userLimits(ctx, dbConnection, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load message limits", "error", err)
+			return nil, internalError("failed to edit message")
+		}
+		text, entities, err := formatText(req.Text, len(message.Attachments) > 0, limits.ContentLimits)
+		if err != nil {
+			apiErr := badRequest(err.Error())
+			apiErr.Details = contentErrorDetails(err)
+			return nil, apiErr
+		}
+
+		edited, err := repositories.NewMessageRepository(dbConnection.DB).Edit(ctx, message.ID, text, entities)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("message not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to edit message", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to edit message")
+		}
+		if edited.Shadowed {
+			deliverShadowed(ctx, hub, "message.edited", edited)
+			return &Response{Data: edited, Legacy: gin.H{"message": edited}}, nil
+		}
+		if err := index.Add(ctx, edited); err != nil {
+			slog.ErrorContext(ctx, "Failed to reindex message", "message_id", edited.ID, "error", err)
+		}
+
+		broadcastMessage(hub, conversation, "message.edited", edited)
+		queueArchive(ctx, dbConnection, "message.edited", edited)
+		return &Response{Data: edited, Legacy: gin.H{"message": edited}}, nil
+	}
+}
+
+// DeleteMessage turns a message into a tombstone, which keeps its place in
+// history without its content, and sends it to the members as
+// "message.deleted". The sender may delete their messages, and the owner
+// and admins of a group or channel anyone's. Messages in audit rooms
+// cannot be deleted.
+func DeleteMessage(dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, conversation, apiErr := memberMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		if message.SenderID != userID && !moderatesConversation(conversation, userID) {
+			return nil, forbidden("only the sender or an admin can delete a message")
+		}
+		if conversation.Audited {
+			return nil, forbidden("messages in audit rooms cannot be deleted")
+		}
+
+		ctx := c.Request.Context()
+		tombstone, err := repositories.NewMessageRepository(dbConnection.DB).Delete(ctx, message.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("message not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete message", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to delete message")
+		}
+		if err := index.Remove(ctx, tombstone.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to remove message from search", "message_id", tombstone.ID, "error", err)
+		}
+
+		broadcastMessage(hub, conversation, "message.deleted", tombstone)
+		queueArchive(ctx, dbConnection, "message.deleted", tombstone)
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListMessageEdits returns the versions a message's edits replaced, oldest
+// first. The message itself holds the current text.
+func ListMessageEdits(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, _, apiErr := memberMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		edits, err := repositories.NewMessageRepository(dbConnection.DB).Edits(c.Request.Context(), message.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list edits of message", "message_id", message.ID, "error", err)
+			return nil, internalError("failed to list message edits")
+		}
+		return &Response{Data: edits, Legacy: gin.H{"edits": edits}}, nil
+	}
+}
+
+// memberMessage loads the message named by the id path parameter and its
+// conversation, which the current user must belong to. Deleted messages
+// and those of other conversations are not found.
+func memberMessage(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Message, *models.Conversation, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, nil, badRequest("invalid message id")
+	}
+
+	ctx := c.Request.Context()
+	message, err := repositories.NewMessageRepository(dbConnection.DB).Get(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil, notFound("message not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load message", "message_id", id, "error", err)
+		return nil, nil, internalError("failed to load message")
+	}
+	conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, message.ConversationID)
+	if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+		slog.ErrorContext(ctx, "Failed to load conversation", "conversation_id", message.ConversationID, "error", err)
+		return nil, nil, internalError("failed to load message")
+	}
+
+	// Only its sender sees a shadowed message.
+	userID := CurrentUserID(c)
+	if conversation == nil || !hasMember(conversation, userID) || (message.Shadowed && message.SenderID != userID) {
+		return nil, nil, notFound("message not found")
+	}
+	return message, conversation, nil
+}
+
+// moderatesConversation reports whether the user is an owner or admin of
+// the conversation.
+func moderatesConversation(conversation *models.Conversation, userID uuid.UUID) bool {
+	for _, member := range conversation.Members {
+		if member.UserID == userID {
+			return member.Role == models.MemberRoleOwner || member.Role == models.MemberRoleAdmin
+		}
+	}
+	return false
+}
+
+// broadcastMessage sends a changed message to every member of its
+// conversation.
+func broadcastMessage(hub *realtime.Hub, conversation *models.Conversation, eventType string, message *models.Message) {
+	event, err := realtime.NewEvent(eventType, message)
+	if err != nil {
+		slog.Error("Failed to encode message", "message_id", message.ID, "error", err)
+		return
+	}
+	memberIDs := make([]uuid.UUID, 0, len(conversation.Members))
+	for _, member := range conversation.Members {
+		memberIDs = append(memberIDs, member.UserID)
+	}
+	hub.SendToUsers(memberIDs, event)
+}
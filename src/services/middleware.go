@@ -1,12 +1,37 @@
 package services
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	currentUserKey          = "currentUser"
+	currentClaimsKey        = "currentClaims"
+	currentChannelKey       = "currentChannel"
+	currentChannelMemberKey = "currentChannelMember"
+	currentWorkspaceKey     = "currentWorkspace"
+
+	// WorkspaceHeader names, by slug, the workspace a request is made in.
+	WorkspaceHeader = "X-Workspace"
+)
 
 func CorsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Workspace, X-Client-Platform, X-Client-Version, X-Device-Fingerprint, X-Request-ID, traceparent")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, traceparent, Deprecation, Sunset, Link, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, X-Quota-Rooms-Limit, X-Quota-Rooms-Remaining, X-Quota-Rooms-Reset, X-Quota-Uploads-Limit, X-Quota-Uploads-Remaining, X-Quota-Uploads-Reset")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -16,3 +41,328 @@ func CorsMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// AuthMiddleware requires a valid bearer token, or a bot's API key, and
+// loads the user it belongs to. Handlers behind it can call CurrentUser.
+func AuthMiddleware(dbConnection *database.DatabaseConnection, tokens *auth.TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := BearerToken(c.Request)
+		var claims *auth.Claims
+		var err error
+		if auth.IsAPIKey(token) {
+			claims, err = apiKeyClaims(c, dbConnection, token)
+		} else {
+			claims, err = tokens.Parse(token)
+		}
+		if errors.Is(err, auth.ErrInvalidToken) {
+			abortWithError(c, unauthorized("missing or invalid access token"))
+			return
+		}
+		if err != nil {
+			abortWithError(c, internalError("failed to authenticate"))
+			return
+		}
+
+		user, status, message := LoadActiveUser(c, dbConnection, claims)
+		if user == nil {
+			abortWithError(c, newAPIError(status, message))
+			return
+		}
+
+		c.Set(currentUserKey, user)
+		c.Set(currentClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// apiKeyClaims stands in claims for a bot's API key: the bot as subject,
+// with the key's scopes and no session.
+func apiKeyClaims(c *gin.Context, dbConnection *database.DatabaseConnection, key string) (*auth.Claims, error) {
+	apiKey, err := repositories.NewBotRepository(dbConnection.DB).Authenticate(c.Request.Context(), auth.HashAPIKey(key))
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, auth.ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	claims := &auth.Claims{Scope: apiKey.Scopes}
+	claims.Subject = apiKey.BotID.String()
+	return claims, nil
+}
+
+// LoadActiveUser resolves the user behind validated claims, returning the
+// HTTP status and message to respond with when they may not proceed.
+func LoadActiveUser(c *gin.Context, dbConnection *database.DatabaseConnection, claims *auth.Claims) (*models.User, int, string) {
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, http.StatusUnauthorized, "missing or invalid access token"
+	}
+
+	var user models.User
+	if err := dbConnection.DB.WithContext(c.Request.Context()).First(&user, "id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, http.StatusUnauthorized, "missing or invalid access token"
+		}
+		return nil, http.StatusInternalServerError, "failed to authenticate"
+	}
+
+	if reason := accountBlockedReason(&user); reason != "" {
+		return nil, http.StatusForbidden, reason
+	}
+
+	if sessionID := claims.Session(); sessionID != uuid.Nil {
+		active, err := repositories.NewSessionRepository(dbConnection.DB).IsActive(c.Request.Context(), user.ID, sessionID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "failed to authenticate"
+		}
+		if !active {
+			return nil, http.StatusUnauthorized, "session has been signed out"
+		}
+	}
+	return &user, http.StatusOK, ""
+}
+
+// BearerToken extracts the token from an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if scheme, token, ok := strings.Cut(header, " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// CurrentUser returns the user set by AuthMiddleware.
+func CurrentUser(c *gin.Context) *models.User {
+	if value, ok := c.Get(currentUserKey); ok {
+		if user, ok := value.(*models.User); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// CurrentUserID returns the ID of the user set by AuthMiddleware.
+func CurrentUserID(c *gin.Context) uuid.UUID {
+	if user := CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return uuid.Nil
+}
+
+// CurrentClaims returns the token claims set by AuthMiddleware.
+func CurrentClaims(c *gin.Context) *auth.Claims {
+	if value, ok := c.Get(currentClaimsKey); ok {
+		if claims, ok := value.(*auth.Claims); ok {
+			return claims
+		}
+	}
+	return nil
+}
+
+// RequireRole must run after AuthMiddleware and only lets users with one of
+// the given roles through.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			abortWithError(c, unauthorized("missing or invalid access token"))
+			return
+		}
+
+		for _, role := range roles {
+			if user.Role == role {
+				c.Next()
+				return
+			}
+		}
+
+		abortWithError(c, forbidden("insufficient permissions"))
+	}
+}
+
+// RequireScope must run after AuthMiddleware and only lets tokens with the
+// given scope through.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims := CurrentClaims(c)
+		if claims == nil {
+			abortWithError(c, unauthorized("missing or invalid access token"))
+			return
+		}
+		if !claims.HasScope(scope) {
+			c.Header("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+			abortWithError(c, forbidden(fmt.Sprintf("token lacks the %s scope", scope)))
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireMethodScope must run after AuthMiddleware. It requires the read
+// scope for requests that only read, and the write scope for the rest.
+func RequireMethodScope() gin.HandlerFunc {
+	read, write := RequireScope(auth.ScopeRead), RequireScope(auth.ScopeWrite)
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead:
+			read(c)
+		default:
+			write(c)
+		}
+	}
+}
+
+// ChannelMembership loads the channel named by the :id parameter and the
+// current user's membership of it. Non-members, and requests made in
+// another workspace, get a 404, so private channels are not revealed.
+// Handlers behind it can call CurrentChannel and CurrentChannelMember.
+func ChannelMembership(dbConnection *database.DatabaseConnection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			abortWithError(c, badRequest("invalid channel id"))
+			return
+		}
+
+		channels := repositories.NewChannelRepository(dbConnection.DB)
+		channel, err := channels.Get(c.Request.Context(), channelID)
+		if err == nil && channel.Conversation.WorkspaceID != CurrentWorkspaceID(c) {
+			err = repositories.ErrNotFound
+		}
+		var member *models.ChannelMember
+		if err == nil {
+			member, err = channels.Member(c.Request.Context(), channelID, CurrentUserID(c))
+		}
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				if channel == nil {
+					if redirect := mergedRoomRedirect(c, dbConnection, channelID); redirect != nil {
+						abortWithError(c, redirect)
+						return
+					}
+				}
+				abortWithError(c, notFound("channel not found"))
+				return
+			}
+			abortWithError(c, internalError("failed to load channel"))
+			return
+		}
+
+		c.Set(currentChannelKey, channel)
+		c.Set(currentChannelMemberKey, member)
+		c.Next()
+	}
+}
+
+// RequireChannelRole only lets through channel members holding one of the
+// given roles. It must run after ChannelMembership.
+func RequireChannelRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		member := CurrentChannelMember(c)
+		if member != nil {
+			for _, role := range roles {
+				if member.Role == role {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		abortWithError(c, forbidden("insufficient channel permissions"))
+	}
+}
+
+// CurrentChannel returns the channel set by ChannelMembership.
+func CurrentChannel(c *gin.Context) *models.Channel {
+	if value, ok := c.Get(currentChannelKey); ok {
+		if channel, ok := value.(*models.Channel); ok {
+			return channel
+		}
+	}
+	return nil
+}
+
+// CurrentChannelMember returns the membership set by ChannelMembership.
+func CurrentChannelMember(c *gin.Context) *models.ChannelMember {
+	if value, ok := c.Get(currentChannelMemberKey); ok {
+		if member, ok := value.(*models.ChannelMember); ok {
+			return member
+		}
+	}
+	return nil
+}
+
+// WorkspaceMembership selects the workspace named by the X-Workspace
+// header, or the default workspace without one, and requires the current
+// user to belong to it. Non-members get a 404, so workspaces are not
+// revealed. It must run after AuthMiddleware; handlers behind it can call
+// CurrentWorkspace.
+func WorkspaceMembership(dbConnection *database.DatabaseConnection) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := c.GetHeader(WorkspaceHeader)
+		if slug == "" || slug == models.DefaultWorkspaceSlug {
+			if !tokenInWorkspace(c, models.DefaultWorkspaceID) {
+				return
+			}
+			c.Set(currentWorkspaceKey, &models.WorkspaceMember{
+				WorkspaceID: models.DefaultWorkspaceID,
+				UserID:      CurrentUserID(c),
+				Role:        models.MemberRoleMember,
+			})
+			c.Next()
+			return
+		}
+
+		workspaces := repositories.NewWorkspaceRepository(dbConnection.DB)
+		workspace, err := workspaces.GetBySlug(c.Request.Context(), slug)
+		var member *models.WorkspaceMember
+		if err == nil {
+			member, err = workspaces.Member(c.Request.Context(), workspace.ID, CurrentUserID(c))
+		}
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				abortWithError(c, notFound("workspace not found"))
+				return
+			}
+			abortWithError(c, internalError("failed to load workspace"))
+			return
+		}
+		if !tokenInWorkspace(c, workspace.ID) {
+			return
+		}
+
+		c.Set(currentWorkspaceKey, member)
+		c.Next()
+	}
+}
+
+// tokenInWorkspace responds with 403 and returns false when the access
+// token does not claim the workspace, as when it was issued before the
+// user joined it.
+func tokenInWorkspace(c *gin.Context, workspaceID uuid.UUID) bool {
+	if claims := CurrentClaims(c); claims != nil && !claims.InWorkspace(workspaceID) {
+		abortWithError(c, forbidden("token was not issued for this workspace; refresh it"))
+		return false
+	}
+	return true
+}
+
+// CurrentWorkspace returns the current user's membership of the workspace
+// selected by WorkspaceMembership.
+func CurrentWorkspace(c *gin.Context) *models.WorkspaceMember {
+	if value, ok := c.Get(currentWorkspaceKey); ok {
+		if member, ok := value.(*models.WorkspaceMember); ok {
+			return member
+		}
+	}
+	return nil
+}
+
+// CurrentWorkspaceID returns the ID of the workspace selected by
+// WorkspaceMembership, or the default workspace's outside it.
+func CurrentWorkspaceID(c *gin.Context) uuid.UUID {
+	if member := CurrentWorkspace(c); member != nil {
+		return member.WorkspaceID
+	}
+	return models.DefaultWorkspaceID
+}
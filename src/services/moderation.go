@@ -0,0 +1,256 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultModerationPage = 50
+	maxModerationPage     = 100
+)
+
+type moderationRequest struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+
+	// Until ends a suspension at a set time; without it the suspension
+	// lasts until it is lifted. Only suspensions take it.
+	Until *time.Time `json:"until"`
+}
+
+// ModerationResult is the moderated account after an action, and the log
+// entry recording it.
+type ModerationResult struct {
+	User   AdminUserView           `json:"user"`
+	Action models.ModerationAction `json:"action"`
+}
+
+// ModerateUser takes action against the account named by the :id
+// parameter and records who took it and why in the moderation log.
+// Suspending, banning and signing out end every session of the account and
+// close its sockets, and lifting a shadow restriction indexes the messages
+// it released for search. Staff cannot moderate themselves, and only
+// admins can moderate other staff.
+func ModerateUser(dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index, action string) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		targetID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		var req moderationRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.Until != nil && (action != models.ModerationSuspend || !req.Until.After(time.Now())) {
+			return nil, badRequest("until must be a future time, and only suspensions take it")
+		}
+
+		ctx := c.Request.Context()
+		users := dbConnection.DB.WithContext(ctx)
+		var target models.User
+		if err := users.First(&target, "id = ?", targetID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, notFound("user not found")
+			}
+			slog.ErrorContext(ctx, "Failed to load user for moderation", "user_id", targetID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+
+		actor := CurrentUser(c)
+		if target.ID == actor.ID {
+			return nil, forbidden("you cannot moderate your own account")
+		}
+		if target.Role != models.RoleUser && actor.Role != models.RoleAdmin {
+			return nil, forbidden("only admins can moderate staff accounts")
+		}
+		if apiErr := checkModerationState(&target, action); apiErr != nil {
+			return nil, apiErr
+		}
+
+		var released []uuid.UUID
+		if action == models.ModerationShadowLift {
+			err := users.Model(&models.Message{}).Where("sender_id = ? AND shadowed", target.ID).Pluck("id", &released).Error
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load shadowed messages", "user_id", target.ID, "error", err)
+				return nil, internalError("failed to moderate user")
+			}
+		}
+
+		entry := models.ModerationAction{
+			UserID:  target.ID,
+			ActorID: &actor.ID,
+			Action:  action,
+			Reason:  req.Reason,
+			Until:   req.Until,
+		}
+		err = repositories.NewModerationRepository(dbConnection.DB).Apply(ctx, &entry)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("user not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to moderate user", "action", action, "user_id", target.ID, "error", err)
+			return nil, internalError("failed to moderate user")
+		}
+		if action == models.ModerationSuspend || action == models.ModerationBan || action == models.ModerationSignOut {
+			hub.SignOut(target.ID, action)
+		}
+		invalidateProfile(ctx, target.ID)
+		reindexMessages(ctx, dbConnection, index, released)
+
+		if err := users.First(&target, "id = ?", target.ID).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to reload moderated user", "user_id", target.ID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+		result := ModerationResult{User: NewAdminUserView(&target), Action: entry}
+		return &Response{Data: result, Legacy: gin.H{"user": result.User, "action": result.Action}}, nil
+	}
+}
+
+// checkModerationState rejects lifting a suspension, ban, mute or shadow
+// restriction the account does not have, and banning or shadow restricting
+// an account twice. Shadow restricting an account the abuse scorers
+// restricted confirms their restriction.
+func checkModerationState(user *models.User, action string) *APIError {
+	switch {
+	case action == models.ModerationShadowRestrict && user.IsShadowRestricted &&
+		user.ShadowRestrictedBy != nil && *user.ShadowRestrictedBy == models.ShadowRestrictedByModerator:
+		return conflict("user is already shadow restricted")
+	case action == models.ModerationShadowLift && !user.IsShadowRestricted:
+		return conflict("user is not shadow restricted")
+	case action == models.ModerationUnsuspend && !user.IsSuspended:
+		return conflict("user is not suspended")
+	case action == models.ModerationBan && user.IsBanned:
+		return conflict("user is already banned")
+	case action == models.ModerationUnban && !user.IsBanned:
+		return conflict("user is not banned")
+	case action == models.ModerationUnmute && (user.MutedUntil == nil || !time.Now().Before(*user.MutedUntil)):
+		return conflict("user is not muted")
+	}
+	return nil
+}
+
+// ListModerationActions returns a page of the moderation log, newest
+// first, optionally only the actions against ?user_id=.
+func ListModerationActions(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID := uuid.Nil
+		if raw := c.Query("user_id"); raw != "" {
+			var err error
+			if userID, err = uuid.Parse(raw); err != nil {
+				return nil, badRequest("invalid user_id")
+			}
+		}
+		before, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultModerationPage, maxModerationPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		actions, err := repositories.NewModerationRepository(dbConnection.DB).List(c.Request.Context(), userID, before, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list moderation actions", "error", err)
+			return nil, internalError("failed to list moderation actions")
+		}
+
+		page := &Page{}
+		if len(actions) > limit {
+			actions = actions[:limit]
+			page.HasMore = true
+			last := actions[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		legacy := gin.H{"actions": actions}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: actions, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// OverrideAgeGate lets the user named by the :userId parameter into the
+// 18+ channel named by :id though they could not join it themselves, such
+// as when their age was checked some other way. The override is recorded
+// in the moderation log with the room and reason.
+func OverrideAgeGate(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		channelID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid channel id")
+		}
+		targetID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		var req moderationRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.Until != nil {
+			return nil, badRequest("only suspensions take until")
+		}
+
+		ctx := c.Request.Context()
+		channels := repositories.NewChannelRepository(dbConnection.DB)
+		channel, err := channels.Get(ctx, channelID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("channel not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load channel", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to load channel")
+		}
+		if !channel.AdultOnly {
+			return nil, conflict("channel is not restricted to adults")
+		}
+		err = checkRecipients(ctx, dbConnection, channel.Conversation.WorkspaceID, []uuid.UUID{targetID})
+		if errors.Is(err, errRecipientNotFound) {
+			return nil, notFound("user not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check user", "user_id", targetID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+		if _, err := channels.Member(ctx, channelID, targetID); err == nil {
+			return nil, conflict("user is already a member")
+		} else if !errors.Is(err, repositories.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to check channel membership", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to check channel membership")
+		}
+
+		actorID := CurrentUserID(c)
+		entry := models.ModerationAction{
+			UserID:         targetID,
+			ActorID:        &actorID,
+			ConversationID: &channelID,
+			Action:         models.ModerationAgeOverride,
+			Reason:         req.Reason,
+		}
+		err = channels.AdmitPastAgeGate(ctx, &entry, entitlements.Rooms().Channel(channel.IsPrivate))
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("channel is full")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to override age gate", "channel_id", channelID, "user_id", targetID, "error", err)
+			return nil, internalError("failed to add channel member")
+		}
+		queueJoinAutomations(ctx, dbConnection.DB, channelID, []uuid.UUID{targetID})
+		notifyAdmitted(ctx, dbConnection, hub, channel, []uuid.UUID{targetID})
+
+		return &Response{Data: entry, Legacy: gin.H{"action": entry}}, nil
+	}
+}
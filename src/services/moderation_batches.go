@@ -0,0 +1,426 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// ModerationUndoWindow is how long a bulk moderation action can be
+	// undone.
+	ModerationUndoWindow = 15 * time.Minute
+
+	// maxBulkTargets caps each list of targets of a bulk action.
+	maxBulkTargets = 100
+)
+
+// bulkModerationActions are the actions templates and bulk requests take.
+var bulkModerationActions = []string{
+	models.ModerationWarn,
+	models.ModerationMute,
+	models.ModerationSuspend,
+	models.ModerationBan,
+	models.ModerationSignOut,
+	models.ModerationShadowRestrict,
+}
+
+type moderationTemplateRequest struct {
+	Name            string `json:"name" binding:"required,max=100"`
+	Action          string `json:"action" binding:"required"`
+	WarnText        string `json:"warn_text" binding:"max=2000"`
+	DurationSeconds int64  `json:"duration_seconds" binding:"min=0"`
+}
+
+type bulkModerationRequest struct {
+	// TemplateID names a template to apply, or Action, WarnText and
+	// DurationSeconds spell one out.
+	TemplateID      *uuid.UUID `json:"template_id"`
+	Action          string     `json:"action"`
+	WarnText        string     `json:"warn_text" binding:"max=2000"`
+	DurationSeconds int64      `json:"duration_seconds" binding:"min=0"`
+	Reason          string     `json:"reason" binding:"required,max=1000"`
+
+	// The accounts acted on are those named, the senders of the messages
+	// and the accounts reported.
+	UserIDs    []uuid.UUID `json:"user_ids" binding:"max=100"`
+	MessageIDs []uuid.UUID `json:"message_ids" binding:"max=100"`
+	ReportIDs  []uuid.UUID `json:"report_ids" binding:"max=100"`
+}
+
+// SkippedTarget is an account a bulk action left alone, and why.
+type SkippedTarget struct {
+	UserID uuid.UUID `json:"user_id"`
+	Reason string    `json:"reason"`
+}
+
+// ModerationBatchResult is a bulk action, the log entries of what it did
+// and the accounts it skipped.
+type ModerationBatchResult struct {
+	Batch   models.ModerationBatch    `json:"batch"`
+	Actions []models.ModerationAction `json:"actions"`
+	Skipped []SkippedTarget           `json:"skipped,omitempty"`
+}
+
+// checkBulkAction rejects actions templates and bulk requests cannot take,
+// and durations and warnings that do not fit the action.
+func checkBulkAction(action string, durationSeconds int64, warnText string) *APIError {
+	switch {
+	case !slices.Contains(bulkModerationActions, action):
+		return badRequest("action must be one of warn, mute, suspend, ban, sign_out or shadow_restrict")
+	case action == models.ModerationMute && durationSeconds == 0:
+		return badRequest("a mute needs duration_seconds")
+	case durationSeconds > 0 && action != models.ModerationMute && action != models.ModerationSuspend:
+		return badRequest("only mutes and suspensions take duration_seconds")
+	case action == models.ModerationWarn && warnText == "":
+		return badRequest("a warning needs warn_text")
+	}
+	return nil
+}
+
+// ListModerationTemplates returns every moderation template by name.
+func ListModerationTemplates(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		templates, err := repositories.NewModerationTemplateRepository(dbConnection.DB).List(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list moderation templates", "error", err)
+			return nil, internalError("failed to list moderation templates")
+		}
+		return &Response{Data: templates, Legacy: gin.H{"templates": templates}}, nil
+	}
+}
+
+// CreateModerationTemplate stores a reusable moderation action.
+func CreateModerationTemplate(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req moderationTemplateRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if apiErr := checkBulkAction(req.Action, req.DurationSeconds, req.WarnText); apiErr != nil {
+			return nil, apiErr
+		}
+		actorID := CurrentUserID(c)
+		template := models.ModerationTemplate{
+			Name:            req.Name,
+			Action:          req.Action,
+			WarnText:        req.WarnText,
+			DurationSeconds: req.DurationSeconds,
+			CreatedByID:     &actorID,
+		}
+		err := repositories.NewModerationTemplateRepository(dbConnection.DB).Create(c.Request.Context(), &template)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("a template with that name already exists")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create moderation template", "error", err)
+			return nil, internalError("failed to create moderation template")
+		}
+		return &Response{Status: http.StatusCreated, Data: template, Legacy: gin.H{"template": template}}, nil
+	}
+}
+
+// UpdateModerationTemplate replaces the template named by the :id
+// parameter. Batches that already applied it are unchanged.
+func UpdateModerationTemplate(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid template id")
+		}
+		var req moderationTemplateRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if apiErr := checkBulkAction(req.Action, req.DurationSeconds, req.WarnText); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		templates := repositories.NewModerationTemplateRepository(dbConnection.DB)
+		template, err := templates.Get(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("template not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load moderation template", "template_id", id, "error", err)
+			return nil, internalError("failed to load moderation template")
+		}
+		template.Name, template.Action = req.Name, req.Action
+		template.WarnText, template.DurationSeconds = req.WarnText, req.DurationSeconds
+		err = templates.Save(ctx, template)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("a template with that name already exists")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update moderation template", "template_id", id, "error", err)
+			return nil, internalError("failed to update moderation template")
+		}
+		return &Response{Data: template, Legacy: gin.H{"template": template}}, nil
+	}
+}
+
+// DeleteModerationTemplate deletes the template named by the :id
+// parameter.
+func DeleteModerationTemplate(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid template id")
+		}
+		err = repositories.NewModerationTemplateRepository(dbConnection.DB).Delete(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("template not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete moderation template", "template_id", id, "error", err)
+			return nil, internalError("failed to delete moderation template")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// BulkModerate takes one action, from a template or spelled out, against
+// every account named, every sender of the messages named and every
+// account the reports named are against, settling the open reports among
+// them and those of the messages. Accounts that cannot take the action,
+// such as the moderator's own, staff for moderators who are not admins and
+// those the action would not change, are skipped. Warnings are sent to
+// the accounts' inboxes. The batch can be undone for ModerationUndoWindow.
+func BulkModerate(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req bulkModerationRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		if req.TemplateID != nil {
+			if req.Action != "" || req.WarnText != "" || req.DurationSeconds != 0 {
+				return nil, badRequest("give either template_id or action, not both")
+			}
+			template, err := repositories.NewModerationTemplateRepository(dbConnection.DB).Get(ctx, *req.TemplateID)
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, notFound("template not found")
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load moderation template", "template_id", *req.TemplateID, "error", err)
+				return nil, internalError("failed to load moderation template")
+			}
+			req.Action, req.WarnText, req.DurationSeconds = template.Action, template.WarnText, template.DurationSeconds
+		}
+		if apiErr := checkBulkAction(req.Action, req.DurationSeconds, req.WarnText); apiErr != nil {
+			return nil, apiErr
+		}
+		actor := CurrentUser(c)
+		if req.Action == models.ModerationBan && actor.Role != models.RoleAdmin {
+			return nil, forbidden("only admins can ban")
+		}
+
+		reports, err := repositories.NewReportRepository(dbConnection.DB).Open(ctx, uniqueIDs(req.ReportIDs), uniqueIDs(req.MessageIDs))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load reports", "error", err)
+			return nil, internalError("failed to load reports")
+		}
+		for _, id := range uniqueIDs(req.ReportIDs) {
+			if !slices.ContainsFunc(reports, func(report models.Report) bool { return report.ID == id }) {
+				return nil, notFound("report " + id.String() + " is not open")
+			}
+		}
+		targetIDs := uniqueIDs(req.UserIDs)
+		if len(req.MessageIDs) > 0 {
+			var senders []uuid.UUID
+			err := dbConnection.DB.WithContext(ctx).Unscoped().Model(&models.Message{}).
+				Where("id IN ?", uniqueIDs(req.MessageIDs)).Distinct().Pluck("sender_id", &senders).Error
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load senders of reported messages", "error", err)
+				return nil, internalError("failed to load messages")
+			}
+			targetIDs = append(targetIDs, senders...)
+		}
+		for _, report := range reports {
+			targetIDs = append(targetIDs, report.UserID)
+		}
+		targetIDs = uniqueIDs(targetIDs)
+		if len(targetIDs) == 0 {
+			return nil, badRequest("name at least one user, message or report")
+		}
+		if len(targetIDs) > maxBulkTargets {
+			return nil, badRequest("a bulk action takes at most 100 accounts")
+		}
+
+		var targets []models.User
+		if err := dbConnection.DB.WithContext(ctx).Where("id IN ?", targetIDs).Find(&targets).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load users for moderation", "error", err)
+			return nil, internalError("failed to load users")
+		}
+		var applied []uuid.UUID
+		var skipped []SkippedTarget
+		for _, id := range targetIDs {
+			i := slices.IndexFunc(targets, func(user models.User) bool { return user.ID == id })
+			if i < 0 {
+				skipped = append(skipped, SkippedTarget{UserID: id, Reason: "user not found"})
+				continue
+			}
+			if reason := bulkSkipReason(actor, &targets[i], req.Action); reason != "" {
+				skipped = append(skipped, SkippedTarget{UserID: id, Reason: reason})
+				continue
+			}
+			applied = append(applied, id)
+		}
+		if len(applied) == 0 {
+			return nil, conflict("none of the accounts can take this action")
+		}
+		var settled []uuid.UUID
+		for _, report := range reports {
+			if slices.Contains(applied, report.UserID) {
+				settled = append(settled, report.ID)
+			}
+		}
+
+		now := time.Now()
+		batch := models.ModerationBatch{
+			ActorID:       &actor.ID,
+			TemplateID:    req.TemplateID,
+			Action:        req.Action,
+			Reason:        req.Reason,
+			WarnText:      req.WarnText,
+			UndoableUntil: now.Add(ModerationUndoWindow),
+			CreatedAt:     now,
+		}
+		if req.DurationSeconds > 0 {
+			until := now.Add(time.Duration(req.DurationSeconds) * time.Second)
+			batch.Until = &until
+		}
+		actions, err := repositories.NewModerationRepository(dbConnection.DB).ApplyBatch(ctx, &batch, applied, settled)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to apply bulk moderation", "action", req.Action, "error", err)
+			return nil, internalError("failed to moderate users")
+		}
+
+		for _, userID := range applied {
+			if req.Action == models.ModerationSuspend || req.Action == models.ModerationBan || req.Action == models.ModerationSignOut {
+				hub.SignOut(userID, req.Action)
+			}
+			invalidateProfile(ctx, userID)
+			if req.WarnText != "" {
+				notifyInbox(ctx, dbConnection, hub, models.InboxNotification{
+					UserID: userID,
+					Kind:   models.InboxSystem,
+					Title:  "A message from the moderators",
+					Body:   req.WarnText,
+				})
+			}
+		}
+		result := ModerationBatchResult{Batch: batch, Actions: actions, Skipped: skipped}
+		return &Response{Status: http.StatusCreated, Data: result, Legacy: gin.H{"batch": result.Batch, "actions": result.Actions, "skipped": result.Skipped}}, nil
+	}
+}
+
+// bulkSkipReason says why a bulk action leaves user alone, or returns ""
+// to take it. Accounts already in the state the action puts them in are
+// skipped so that undoing the batch does not lift what came before it.
+func bulkSkipReason(actor, user *models.User, action string) string {
+	switch {
+	case user.ID == actor.ID:
+		return "you cannot moderate your own account"
+	case user.Role != models.RoleUser && actor.Role != models.RoleAdmin:
+		return "only admins can moderate staff accounts"
+	case action == models.ModerationSuspend && user.IsSuspended:
+		return "user is already suspended"
+	case action == models.ModerationBan && user.IsBanned:
+		return "user is already banned"
+	case action == models.ModerationShadowRestrict && user.IsShadowRestricted:
+		return "user is already shadow restricted"
+	case action == models.ModerationMute && user.MutedUntil != nil && time.Now().Before(*user.MutedUntil):
+		return "user is already muted"
+	}
+	return ""
+}
+
+// GetModerationBatch returns the bulk action named by the :id parameter
+// and the log entries of what it did.
+func GetModerationBatch(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid batch id")
+		}
+		batch, actions, err := repositories.NewModerationRepository(dbConnection.DB).GetBatch(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("batch not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load moderation batch", "batch_id", id, "error", err)
+			return nil, internalError("failed to load moderation batch")
+		}
+		result := ModerationBatchResult{Batch: *batch, Actions: actions}
+		return &Response{Data: result, Legacy: gin.H{"batch": result.Batch, "actions": result.Actions}}, nil
+	}
+}
+
+// UndoModerationBatch takes back the bulk action named by the :id
+// parameter within ModerationUndoWindow of it: suspensions, bans, shadow
+// restrictions and mutes are lifted and the reports it settled reopened.
+// Warnings already delivered and sessions already ended stay so.
+func UndoModerationBatch(dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid batch id")
+		}
+		ctx := c.Request.Context()
+		moderation := repositories.NewModerationRepository(dbConnection.DB)
+		batch, taken, err := moderation.GetBatch(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("batch not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load moderation batch", "batch_id", id, "error", err)
+			return nil, internalError("failed to load moderation batch")
+		}
+		if batch.Action == models.ModerationBan && CurrentUser(c).Role != models.RoleAdmin {
+			return nil, forbidden("only admins can lift bans")
+		}
+
+		var released []uuid.UUID
+		if batch.Action == models.ModerationShadowRestrict {
+			userIDs := make([]uuid.UUID, len(taken))
+			for i, action := range taken {
+				userIDs[i] = action.UserID
+			}
+			err := dbConnection.DB.WithContext(ctx).Model(&models.Message{}).
+				Where("sender_id IN ? AND shadowed", userIDs).Pluck("id", &released).Error
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to load shadowed messages", "batch_id", id, "error", err)
+				return nil, internalError("failed to undo moderation batch")
+			}
+		}
+
+		batch, undone, err := moderation.UndoBatch(ctx, id, CurrentUserID(c))
+		if errors.Is(err, repositories.ErrExpired) {
+			return nil, gone("this batch was already undone or can no longer be")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to undo moderation batch", "batch_id", id, "error", err)
+			return nil, internalError("failed to undo moderation batch")
+		}
+		for _, action := range undone {
+			invalidateProfile(ctx, action.UserID)
+		}
+		reindexMessages(ctx, dbConnection, index, released)
+
+		result := ModerationBatchResult{Batch: *batch, Actions: undone}
+		return &Response{Data: result, Legacy: gin.H{"batch": result.Batch, "actions": result.Actions}}, nil
+	}
+}
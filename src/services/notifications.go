@@ -0,0 +1,890 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/presence"
+	"github.com/dfunani/AfroChat/backend/pkg/preview"
+	"github.com/dfunani/AfroChat/backend/pkg/push"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// notificationQueueSize bounds the messages waiting to be pushed. When
+	// the push services fall that far behind, further pushes are dropped
+	// rather than holding up message delivery.
+	notificationQueueSize = 1024
+
+	notificationTimeout     = 30 * time.Second
+	maxNotificationBodyRune = 200
+
+	// PushTokenPruneInterval is how often dead device tokens are pruned.
+	PushTokenPruneInterval = time.Hour
+
+	// emailNotificationInterval is the least time between emails to a
+	// user about one conversation, so a busy conversation sends one email
+	// rather than one per message.
+	emailNotificationInterval = 15 * time.Minute
+
+	// maxEmailedConversations bounds the emails remembered for
+	// emailNotificationInterval.
+	maxEmailedConversations = 10_000
+
+	// pushBurstWindow is how long after a push about a conversation the
+	// next one is counted into it, replacing it on the device as one
+	// updated push rather than adding another.
+	pushBurstWindow = 10 * time.Minute
+
+	// maxPushBursts bounds the bursts remembered for pushBurstWindow.
+	maxPushBursts = 10_000
+
+	// dndTimeLayout is how do-not-disturb hours are written.
+	dndTimeLayout = "15:04"
+)
+
+// Push delivery and device token metrics, for diagnosing pushes that
+// stopped arriving.
+var (
+	notificationsDropped = metricsRegistry.Counter("afrochat_notifications_dropped_total",
+		"Messages whose pushes were dropped because the notification queue was full.")
+	notificationEmails = metricsRegistry.Counter("afrochat_notification_emails_total",
+		"Notification emails, by outcome: sent or failed.", "outcome")
+	pushSends = metricsRegistry.Counter("afrochat_push_sends_total",
+		"Pushes sent to devices, by platform and outcome: delivered, rejected (the token is dead) or failed.", "platform", "outcome")
+	pushTokensPruned = metricsRegistry.Counter("afrochat_push_tokens_pruned_total",
+		"Device tokens deleted, by reason: the push service's rejection, a signed out session or staleness.", "reason")
+	pushTokenRegistrations = metricsRegistry.Counter("afrochat_push_token_registrations_total",
+		"Device token registrations, by platform and outcome: new, refreshed, or reassigned from another account.", "platform", "outcome")
+)
+
+// mentionPattern finds @username mentions in message text.
+var mentionPattern = regexp.MustCompile(`@([a-zA-Z0-9_-]{3,50})`)
+
+// NewPushSenders returns the push sender for each platform the
+// configuration enables. The log sender stands in for every platform.
+func NewPushSenders(appConfig *config.ApplicationConfig) (map[string]push.Sender, error) {
+	if appConfig.Push == config.PushLog {
+		return map[string]push.Sender{
+			push.PlatformAndroid: push.LogSender{},
+			push.PlatformIOS:     push.LogSender{},
+			push.PlatformWeb:     push.LogSender{},
+		}, nil
+	}
+
+	senders := make(map[string]push.Sender)
+	if appConfig.FCMCredentialsFile != "" {
+		sender, err := push.NewFCMSender(appConfig.FCMCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		senders[push.PlatformAndroid] = sender
+	}
+	if appConfig.APNsKeyFile != "" {
+		sender, err := push.NewAPNsSender(push.APNsConfig{
+			KeyFile:    appConfig.APNsKeyFile,
+			KeyID:      appConfig.APNsKeyID,
+			TeamID:     appConfig.APNsTeamID,
+			Topic:      appConfig.APNsTopic,
+			Production: appConfig.APNsProduction,
+		})
+		if err != nil {
+			return nil, err
+		}
+		senders[push.PlatformIOS] = sender
+	}
+	if appConfig.WebPushVAPIDKey != "" {
+		sender, err := push.NewWebPushSender(push.WebPushConfig{
+			PrivateKey: appConfig.WebPushVAPIDKey,
+			Subject:    appConfig.WebPushSubject,
+		})
+		if err != nil {
+			return nil, err
+		}
+		senders[push.PlatformWeb] = sender
+	}
+	return senders, nil
+}
+
+// notificationJob is a message to push to the recipients who were offline
+// when it was sent.
+type notificationJob struct {
+	message      *models.Message
+	recipientIDs []uuid.UUID
+
+	// queuedAt is when the job was queued, which push dispatch latency is
+	// measured from.
+	queuedAt time.Time
+
+	// trace is the span of the send, which the pushes are traced under.
+	trace tracing.SpanContext
+}
+
+// Notifier pushes messages to the devices of recipients with no open
+// connection, and emails those who asked for it, on a pool of background
+// workers so slow push services never hold up sending.
+type Notifier struct {
+	dbConnection *database.DatabaseConnection
+	senders      map[string]push.Sender
+	mail         mailer.Mailer
+	workers      sync.WaitGroup
+
+	mu     sync.RWMutex
+	jobs   chan notificationJob
+	closed bool
+
+	// emailed is when each user was last emailed about each conversation.
+	// It is per instance, so with several instances a user may get an
+	// email from each.
+	emailedMu sync.Mutex
+	emailed   map[userConversation]time.Time
+
+	// bursts counts the pushes to each user about each conversation in
+	// quick succession. Like emailed it is per instance.
+	burstsMu sync.Mutex
+	bursts   map[userConversation]pushBurst
+
+	// focus, when set, tells which conversations users have in view,
+	// which they are not pushed messages in.
+	focus *presence.Tracker
+}
+
+type userConversation struct {
+	userID         uuid.UUID
+	conversationID uuid.UUID
+}
+
+// pushBurst is how many pushes a burst has collapsed, and when the last
+// was.
+type pushBurst struct {
+	count int
+	last  time.Time
+}
+
+func NewNotifier(dbConnection *database.DatabaseConnection, senders map[string]push.Sender, mail mailer.Mailer) *Notifier {
+	return &Notifier{
+		dbConnection: dbConnection,
+		senders:      senders,
+		mail:         mail,
+		jobs:         make(chan notificationJob, notificationQueueSize),
+		emailed:      make(map[userConversation]time.Time),
+		bursts:       make(map[userConversation]pushBurst),
+	}
+}
+
+// SuppressWhileViewing stops pushes of messages in a conversation to users
+// who have it in view, as their connections report to focus.
+func (n *Notifier) SuppressWhileViewing(focus *presence.Tracker) {
+	n.focus = focus
+}
+
+// awayFrom reports whether userID should be pushed a message in
+// conversationID: they have no connection to any instance, or their
+// connections to this one report what they have in view and none shows
+// the conversation. Connections that report nothing, including those to
+// other instances, are taken to be watching.
+func (n *Notifier) awayFrom(hub *realtime.Hub, userID, conversationID uuid.UUID) bool {
+	if !hub.IsOnline(userID) {
+		return !hub.IsConnected(userID)
+	}
+	if n.focus == nil {
+		return false
+	}
+	viewing, reported := n.focus.Viewing(userID, conversationID)
+	return reported && !viewing
+}
+
+// Start runs workers goroutines sending queued notifications until
+// Shutdown.
+func (n *Notifier) Start(workers int) {
+	for range workers {
+		n.workers.Add(1)
+		go func() {
+			defer n.workers.Done()
+			for job := range n.jobs {
+				n.notify(job)
+			}
+		}()
+	}
+}
+
+// Enqueue queues a message to be pushed to recipients. It never blocks:
+// when the queue is full the pushes are dropped, since the recipients will
+// still find the message when they next open the app.
+func (n *Notifier) Enqueue(ctx context.Context, message *models.Message, recipientIDs []uuid.UUID) {
+	if len(recipientIDs) == 0 {
+		return
+	}
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.jobs <- notificationJob{message: message, recipientIDs: recipientIDs, queuedAt: time.Now(), trace: tracing.SpanContextFromContext(ctx)}:
+	default:
+		notificationsDropped.Inc()
+		slog.WarnContext(ctx, "Notification queue is full; dropping pushes", "message_id", message.ID)
+	}
+}
+
+// Shutdown stops accepting notifications and waits for the queued ones to
+// be sent, or for ctx to end.
+func (n *Notifier) Shutdown(ctx context.Context) error {
+	n.mu.Lock()
+	n.closed = true
+	close(n.jobs)
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up on %d queued notifications: %w", len(n.jobs), ctx.Err())
+	}
+}
+
+func (n *Notifier) notify(job notificationJob) {
+	ctx, cancel := context.WithTimeout(tracing.ContextWithRemoteParent(context.Background(), job.trace), notificationTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "notifications.push", tracing.KindInternal,
+		tracing.String("message_id", job.message.ID.String()),
+		tracing.Int("recipients", int64(len(job.recipientIDs))))
+	defer span.End()
+
+	db := n.dbConnection.DB.WithContext(ctx)
+	var conversation models.Conversation
+	if err := db.First(&conversation, "id = ?", job.message.ConversationID).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation for notifications", "conversation_id", job.message.ConversationID, "error", err)
+		return
+	}
+	var sender models.User
+	if err := db.First(&sender, "id = ?", job.message.SenderID).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load sender for notifications", "sender_id", job.message.SenderID, "error", err)
+		return
+	}
+	var recipients []models.User
+	if err := db.Where("id IN ?", job.recipientIDs).Find(&recipients).Error; err != nil {
+		slog.ErrorContext(ctx, "Failed to load recipients of message", "message_id", job.message.ID, "error", err)
+		return
+	}
+
+	mentioned := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(job.message.Text, -1) {
+		mentioned[strings.ToLower(match[1])] = true
+	}
+
+	silenced, err := silencedMembers(ctx, n.dbConnection, conversation.ID, job.recipientIDs, time.Now())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load mutes of recipients", "message_id", job.message.ID, "error", err)
+		return
+	}
+
+	notifications := repositories.NewNotificationRepository(n.dbConnection.DB)
+	for _, recipient := range recipients {
+		if silenced[recipient.ID] {
+			continue
+		}
+		if n.focus != nil {
+			// The conversation may have been opened since the message
+			// was queued.
+			if viewing, _ := n.focus.Viewing(recipient.ID, conversation.ID); viewing {
+				continue
+			}
+		}
+		preferences, err := notifications.Preferences(ctx, recipient.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load notification preferences", "user_id", recipient.ID, "error", err)
+			continue
+		}
+		if !wantsPush(preferences, conversation.Kind, mentioned[strings.ToLower(recipient.Username)]) {
+			continue
+		}
+		notification := newNotification(job.message, &conversation, &sender, preferences)
+		if preferences.EmailEnabled {
+			n.email(ctx, &recipient, conversation.ID, notification)
+		}
+		if !preferences.PushEnabled {
+			continue
+		}
+
+		devices, err := notifications.Devices(ctx, recipient.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load devices", "user_id", recipient.ID, "error", err)
+			continue
+		}
+		notification = n.collapse(recipient.ID, conversation.ID, notification)
+		for _, device := range devices {
+			n.send(ctx, notifications, device, notification, job.queuedAt)
+		}
+	}
+}
+
+// collapse counts a push into the recipient's burst of pushes about the
+// conversation. Pushes after the first in a burst say how many messages
+// it holds, and replace the one before on the device through their
+// collapse key.
+func (n *Notifier) collapse(userID, conversationID uuid.UUID, notification push.Notification) push.Notification {
+	key := userConversation{userID: userID, conversationID: conversationID}
+	now := time.Now()
+	n.burstsMu.Lock()
+	burst := n.bursts[key]
+	if now.Sub(burst.last) >= pushBurstWindow {
+		burst.count = 0
+	}
+	burst.count++
+	burst.last = now
+	if len(n.bursts) >= maxPushBursts {
+		for other, last := range n.bursts {
+			if now.Sub(last.last) >= pushBurstWindow {
+				delete(n.bursts, other)
+			}
+		}
+		if len(n.bursts) >= maxPushBursts {
+			n.bursts = make(map[userConversation]pushBurst)
+		}
+	}
+	n.bursts[key] = burst
+	n.burstsMu.Unlock()
+
+	if burst.count > 1 {
+		notification.Body = fmt.Sprintf("%s (+%d more)", notification.Body, burst.count-1)
+		data := make(map[string]string, len(notification.Data)+1)
+		for k, v := range notification.Data {
+			data[k] = v
+		}
+		data["count"] = strconv.Itoa(burst.count)
+		notification.Data = data
+	}
+	return notification
+}
+
+// email sends a recipient the notification by email, unless they were
+// emailed about the conversation within emailNotificationInterval.
+func (n *Notifier) email(ctx context.Context, recipient *models.User, conversationID uuid.UUID, notification push.Notification) {
+	key := userConversation{userID: recipient.ID, conversationID: conversationID}
+	now := time.Now()
+	n.emailedMu.Lock()
+	if last, ok := n.emailed[key]; ok && now.Sub(last) < emailNotificationInterval {
+		n.emailedMu.Unlock()
+		return
+	}
+	if len(n.emailed) >= maxEmailedConversations {
+		for emailed, last := range n.emailed {
+			if now.Sub(last) >= emailNotificationInterval {
+				delete(n.emailed, emailed)
+			}
+		}
+		if len(n.emailed) >= maxEmailedConversations {
+			n.emailed = make(map[userConversation]time.Time)
+		}
+	}
+	n.emailed[key] = now
+	n.emailedMu.Unlock()
+
+	err := n.mail.Send(ctx, mailer.Message{
+		To:      recipient.Email,
+		Subject: notification.Title,
+		Body: notification.Body +
+			"\n\nOpen AfroChat to read and reply. To stop these emails, turn off email notifications in your settings.",
+	})
+	if err != nil {
+		notificationEmails.Inc("failed")
+		slog.ErrorContext(ctx, "Failed to email notification", "user_id", recipient.ID, "error", err)
+		return
+	}
+	notificationEmails.Inc("sent")
+}
+
+// Alert pushes a notification about something other than a message to
+// a user's devices, unless they turned push notifications off.
+func (n *Notifier) Alert(ctx context.Context, userID uuid.UUID, notification push.Notification) error {
+	notifications := repositories.NewNotificationRepository(n.dbConnection.DB)
+	preferences, err := notifications.Preferences(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !preferences.PushEnabled {
+		return nil
+	}
+	devices, err := notifications.Devices(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		n.send(ctx, notifications, device, notification, time.Time{})
+	}
+	return nil
+}
+
+// send pushes a notification to a device. Pushes of queued messages pass
+// when they were queued, for the push dispatch SLI; alerts, sent as they
+// happen, pass the zero time.
+func (n *Notifier) send(ctx context.Context, notifications *repositories.NotificationRepository, device models.DeviceToken, notification push.Notification, queuedAt time.Time) {
+	sender, ok := n.senders[device.Platform]
+	if !ok {
+		return
+	}
+	sendCtx, span := tracing.Start(ctx, "push.send "+device.Platform, tracing.KindClient,
+		tracing.String("push.platform", device.Platform))
+	err := sender.Send(sendCtx, device.Token, notification)
+	span.RecordError(err)
+	span.End()
+	if !queuedAt.IsZero() {
+		recordPushDispatch(queuedAt)
+	}
+	if reason := push.RejectionReason(err); reason != "" {
+		pushSends.Inc(device.Platform, "rejected")
+		if err := notifications.DeleteToken(ctx, device.Token); err != nil {
+			slog.ErrorContext(ctx, "Failed to forget device token", "error", err)
+			return
+		}
+		pushTokensPruned.Inc(reason)
+		return
+	}
+	if err != nil {
+		pushSends.Inc(device.Platform, "failed")
+		slog.ErrorContext(ctx, "Failed to push to device", "platform", device.Platform, "user_id", device.UserID, "error", err)
+	} else {
+		pushSends.Inc(device.Platform, "delivered")
+	}
+	if err := notifications.RecordPush(ctx, device.ID, err); err != nil {
+		slog.ErrorContext(ctx, "Failed to record push to device", "device_id", device.ID, "error", err)
+	}
+}
+
+// PruneDevices is the scheduled job deleting dead device tokens: those of
+// ended sessions, and those the app has not registered again within
+// maxAge.
+func (n *Notifier) PruneDevices(maxAge time.Duration) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		notifications := repositories.NewNotificationRepository(n.dbConnection.DB)
+		signedOut, stale, err := notifications.PruneTokens(ctx, time.Now().Add(-maxAge))
+		pushTokensPruned.Add(uint64(signedOut), "signed_out")
+		pushTokensPruned.Add(uint64(stale), "stale")
+		return err
+	}
+}
+
+// silencedMembers returns which of userIDs should not be disturbed by a
+// message in the conversation at the given time: those who muted it and
+// those in their do-not-disturb hours.
+func silencedMembers(ctx context.Context, dbConnection *database.DatabaseConnection, conversationID uuid.UUID, userIDs []uuid.UUID, at time.Time) (map[uuid.UUID]bool, error) {
+	notifications := repositories.NewNotificationRepository(dbConnection.DB)
+	muted, err := notifications.MutedIn(ctx, conversationID, userIDs, at)
+	if err != nil {
+		return nil, err
+	}
+	hours, err := notifications.DoNotDisturb(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	silenced := make(map[uuid.UUID]bool, len(muted))
+	for _, userID := range muted {
+		silenced[userID] = true
+	}
+	for _, dnd := range hours {
+		if inDoNotDisturb(dnd.DNDStart, dnd.DNDEnd, dnd.TimeZone, at) {
+			silenced[dnd.UserID] = true
+		}
+	}
+	return silenced, nil
+}
+
+// inDoNotDisturb reports whether at falls within the do-not-disturb hours
+// from start to end, given as "HH:MM" in timeZone. Hours ending earlier
+// than they start run past midnight; unreadable ones never apply.
+func inDoNotDisturb(start, end, timeZone string, at time.Time) bool {
+	from, err := time.Parse(dndTimeLayout, start)
+	if err != nil {
+		return false
+	}
+	to, err := time.Parse(dndTimeLayout, end)
+	if err != nil {
+		return false
+	}
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := at.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	fromMinute := from.Hour()*60 + from.Minute()
+	toMinute := to.Hour()*60 + to.Minute()
+	if fromMinute <= toMinute {
+		return minute >= fromMinute && minute < toMinute
+	}
+	return minute >= fromMinute || minute < toMinute
+}
+
+// wantsPush applies a recipient's preferences: direct messages and group
+// messages can each be silenced, while mentions in groups can still be
+// pushed on their own.
+func wantsPush(preferences *models.NotificationPreferences, kind string, mentioned bool) bool {
+	if kind == models.ConversationDirect {
+		return preferences.DirectMessages
+	}
+	return preferences.GroupMessages || (mentioned && preferences.Mentions)
+}
+
+// newNotification writes a push about a message as the recipient's
+// preview settings allow.
+func newNotification(message *models.Message, conversation *models.Conversation, sender *models.User, preferences *models.NotificationPreferences) push.Notification {
+	source := preview.Message{Sender: sender.DisplayName, Text: message.Text}
+	if conversation.Kind != models.ConversationDirect && conversation.Title != nil {
+		source.Conversation = *conversation.Title
+	}
+	shown := preview.Build(source, preview.Settings{Mode: preferences.PreviewMode, Filter: preferences.FilterProfanity}, maxNotificationBodyRune)
+
+	return push.Notification{
+		Title:       shown.Title,
+		Body:        shown.Body,
+		ThreadID:    conversation.ID.String(),
+		CollapseKey: conversation.ID.String(),
+		Data: map[string]string{
+			"type":            "message.new",
+			"conversation_id": conversation.ID.String(),
+			"message_id":      message.ID.String(),
+		},
+	}
+}
+
+type registerDeviceRequest struct {
+	Platform string `json:"platform" binding:"required,oneof=android ios web"`
+	Token    string `json:"token" binding:"required_unless=Platform web,max=512"`
+
+	// Subscription is what browsers register in place of a token, their
+	// PushSubscription as JSON.
+	Subscription *push.Subscription `json:"subscription" binding:"required_if=Platform web"`
+}
+
+// RegisterPushToken registers the push token of the device making the
+// request, replacing any token it registered before. Browsers register
+// their Web Push subscription, which is stored as their token.
+func RegisterPushToken(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req registerDeviceRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		var token string
+		var err error
+		if req.Platform == push.PlatformWeb {
+			token, err = push.NormalizeSubscription(*req.Subscription)
+		} else {
+			token, err = push.NormalizeToken(req.Platform, req.Token)
+		}
+		if err != nil {
+			return nil, badRequest(err.Error())
+		}
+		// Tokens belong to sessions so signing out stops pushes.
+		sessionID := currentSessionID(c)
+		if sessionID == uuid.Nil {
+			return nil, unauthorized("sign in again to register this device")
+		}
+
+		device := &models.DeviceToken{
+			UserID:    CurrentUserID(c),
+			SessionID: sessionID,
+			Platform:  req.Platform,
+			Token:     token,
+		}
+		outcome, err := repositories.NewNotificationRepository(dbConnection.DB).RegisterDevice(c.Request.Context(), device)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to register push token", "error", err)
+			return nil, internalError("failed to register push token")
+		}
+		pushTokenRegistrations.Inc(device.Platform, outcome)
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// GetWebPushKey returns the VAPID public key browsers subscribe to Web
+// Push with, as their applicationServerKey.
+func GetWebPushKey(senders map[string]push.Sender) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		sender, ok := senders[push.PlatformWeb].(interface{ PublicKey() string })
+		if !ok {
+			return nil, notFound("web push is not enabled")
+		}
+		key := gin.H{"public_key": sender.PublicKey()}
+		return &Response{Data: key, Legacy: key}, nil
+	}
+}
+
+// UnregisterPushToken stops pushes to the device making the request.
+func UnregisterPushToken(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		err := repositories.NewNotificationRepository(dbConnection.DB).UnregisterDevice(c.Request.Context(), currentSessionID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no push token is registered for this device")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to unregister push token", "error", err)
+			return nil, internalError("failed to unregister push token")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// GetNotificationPreferences returns which messages the current user is
+// notified about, how and when.
+func GetNotificationPreferences(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		preferences, err := repositories.NewNotificationRepository(dbConnection.DB).Preferences(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load notification preferences", "error", err)
+			return nil, internalError("failed to load notification preferences")
+		}
+		return &Response{Data: preferences, Legacy: gin.H{"preferences": preferences}}, nil
+	}
+}
+
+type updateNotificationPreferencesRequest struct {
+	DirectMessages  *bool   `json:"direct_messages"`
+	GroupMessages   *bool   `json:"group_messages"`
+	Mentions        *bool   `json:"mentions"`
+	PreviewMode     *string `json:"preview_mode" binding:"omitempty,oneof=full sender_only none"`
+	FilterProfanity *bool   `json:"filter_profanity"`
+
+	// ShowPreviews is how clients older than preview modes choose between
+	// full previews and sender-only ones.
+	ShowPreviews *bool `json:"show_previews"`
+
+	PushEnabled  *bool `json:"push_enabled"`
+	EmailEnabled *bool `json:"email_enabled"`
+	EmailDigest  *bool `json:"email_digest"`
+
+	// DNDStart and DNDEnd set the do-not-disturb hours together, as
+	// "HH:MM" in the user's time zone; empty strings clear them.
+	DNDStart *string `json:"dnd_start" binding:"omitempty,datetime=15:04"`
+	DNDEnd   *string `json:"dnd_end" binding:"omitempty,datetime=15:04"`
+}
+
+// UpdateNotificationPreferences changes the preferences present in the
+// request and leaves the rest alone.
+func UpdateNotificationPreferences(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req updateNotificationPreferencesRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		notifications := repositories.NewNotificationRepository(dbConnection.DB)
+		preferences, err := notifications.Preferences(ctx, CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load notification preferences", "error", err)
+			return nil, internalError("failed to update notification preferences")
+		}
+		if req.DirectMessages != nil {
+			preferences.DirectMessages = *req.DirectMessages
+		}
+		if req.GroupMessages != nil {
+			preferences.GroupMessages = *req.GroupMessages
+		}
+		if req.Mentions != nil {
+			preferences.Mentions = *req.Mentions
+		}
+		switch {
+		case req.PreviewMode != nil:
+			preferences.PreviewMode = *req.PreviewMode
+		case req.ShowPreviews != nil && *req.ShowPreviews:
+			preferences.PreviewMode = preview.Full
+		case req.ShowPreviews != nil:
+			preferences.PreviewMode = preview.SenderOnly
+		}
+		preferences.ShowPreviews = preferences.PreviewMode == preview.Full
+		if req.FilterProfanity != nil {
+			preferences.FilterProfanity = *req.FilterProfanity
+		}
+		if req.PushEnabled != nil {
+			preferences.PushEnabled = *req.PushEnabled
+		}
+		if req.EmailEnabled != nil {
+			preferences.EmailEnabled = *req.EmailEnabled
+		}
+		if req.EmailDigest != nil && *req.EmailDigest != preferences.EmailDigest {
+			// A digest turned on starts from now rather than from whenever
+			// the last one was sent.
+			now := time.Now()
+			preferences.EmailDigest, preferences.DigestSentAt = *req.EmailDigest, &now
+		}
+		if (req.DNDStart == nil) != (req.DNDEnd == nil) {
+			return nil, badRequest("dnd_start and dnd_end must be changed together")
+		}
+		if req.DNDStart != nil {
+			if (*req.DNDStart == "") != (*req.DNDEnd == "") {
+				return nil, badRequest("dnd_start and dnd_end must both be set or both be cleared")
+			}
+			preferences.DNDStart, preferences.DNDEnd = nil, nil
+			if *req.DNDStart != "" {
+				preferences.DNDStart, preferences.DNDEnd = req.DNDStart, req.DNDEnd
+			}
+		}
+
+		if err := notifications.SavePreferences(ctx, preferences); err != nil {
+			slog.ErrorContext(ctx, "Failed to save notification preferences", "error", err)
+			return nil, internalError("failed to update notification preferences")
+		}
+		return &Response{Data: preferences, Legacy: gin.H{"preferences": preferences}}, nil
+	}
+}
+
+// DeviceTokenView is a user's device token as support staff see it when a
+// user reports pushes stopped arriving. Only the start of the token is
+// shown, enough to match it against the push service's logs.
+type DeviceTokenView struct {
+	Platform      string     `json:"platform"`
+	TokenPrefix   string     `json:"token_prefix"`
+	SessionActive bool       `json:"session_active"`
+	RegisteredAt  time.Time  `json:"registered_at"`
+	SeenAt        time.Time  `json:"seen_at"`
+	LastPushedAt  *time.Time `json:"last_pushed_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LastError     *string    `json:"last_error"`
+	Failures      int        `json:"failures"`
+}
+
+func newDeviceTokenView(device *models.DeviceToken, now time.Time) DeviceTokenView {
+	return DeviceTokenView{
+		Platform:      device.Platform,
+		TokenPrefix:   device.Token[:min(len(device.Token), 8)],
+		SessionActive: device.Session.RevokedAt == nil && device.Session.ExpiresAt.After(now),
+		RegisteredAt:  device.CreatedAt,
+		SeenAt:        device.UpdatedAt,
+		LastPushedAt:  device.LastPushedAt,
+		LastFailureAt: device.LastFailureAt,
+		LastError:     device.LastError,
+		Failures:      device.Failures,
+	}
+}
+
+// AdminUserDevices lists every push token of the user named by the :id
+// parameter with its delivery health, including tokens of ended sessions
+// that have not been pruned yet.
+func AdminUserDevices(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		devices, err := repositories.NewNotificationRepository(dbConnection.DB).AllDevices(c.Request.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list devices", "user_id", userID, "error", err)
+			return nil, internalError("failed to list devices")
+		}
+
+		now := time.Now()
+		views := make([]DeviceTokenView, 0, len(devices))
+		for i := range devices {
+			views = append(views, newDeviceTokenView(&devices[i], now))
+		}
+		return &Response{Data: views, Legacy: gin.H{"devices": views}}, nil
+	}
+}
+
+// PushTokenHealth counts the device tokens of each platform by condition,
+// taking tokens not registered again within maxAge as stale.
+func PushTokenHealth(dbConnection *database.DatabaseConnection, maxAge time.Duration) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		health, err := repositories.NewNotificationRepository(dbConnection.DB).TokenHealth(c.Request.Context(), time.Now().Add(-maxAge))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to count device tokens", "error", err)
+			return nil, internalError("failed to count device tokens")
+		}
+		return &Response{Data: health, Legacy: gin.H{"platforms": health}}, nil
+	}
+}
+
+type muteConversationRequest struct {
+	// Until is when the mute ends; without it the conversation stays
+	// muted until unmuted.
+	Until *time.Time `json:"until"`
+}
+
+// MuteConversation mutes a conversation the current user belongs to,
+// replacing any mute they set on it before.
+func MuteConversation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		// A request without a body mutes until unmuted.
+		var req muteConversationRequest
+		if c.Request.ContentLength != 0 {
+			if apiErr := bindJSON(c, &req); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+		if req.Until != nil && !req.Until.After(time.Now()) {
+			return nil, badRequest("until must be in the future")
+		}
+
+		mute := &models.ConversationMute{
+			UserID:         CurrentUserID(c),
+			ConversationID: conversation.ID,
+			Until:          req.Until,
+			CreatedAt:      time.Now(),
+		}
+		if err := repositories.NewNotificationRepository(dbConnection.DB).Mute(c.Request.Context(), mute); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to mute conversation", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to mute conversation")
+		}
+		return &Response{Data: mute, Legacy: gin.H{"mute": mute}}, nil
+	}
+}
+
+// UnmuteConversation lifts the current user's mute of a conversation.
+func UnmuteConversation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err := repositories.NewNotificationRepository(dbConnection.DB).Unmute(c.Request.Context(), CurrentUserID(c), conversation.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("conversation is not muted")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to unmute conversation", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to unmute conversation")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ListMutes returns the conversations the current user has muted.
+func ListMutes(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		mutes, err := repositories.NewNotificationRepository(dbConnection.DB).Mutes(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list mutes", "error", err)
+			return nil, internalError("failed to list mutes")
+		}
+		return &Response{Data: mutes, Legacy: gin.H{"mutes": mutes}}, nil
+	}
+}
@@ -0,0 +1,509 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// oauthStateTTL is how long a user has to sign in at the provider.
+	oauthStateTTL = 10 * time.Minute
+
+	// oauthCookie holds the PKCE verifier of a sign-in in progress, which
+	// also binds it to the browser that started it.
+	oauthCookie     = "afrochat_oauth"
+	oauthCookiePath = "/api/v1/auth/oauth"
+
+	// oauthHandoffTTL is how long an app has to redeem the refresh token
+	// it is handed after a sign-in; redeeming it starts the full session.
+	oauthHandoffTTL = 2 * time.Minute
+)
+
+var (
+	errOAuthNoEmail      = errors.New("the provider did not share an email address")
+	errOAuthEmailTaken   = errors.New("an account with this email already exists; sign in with your password")
+	errOAuthInviteOnly   = errors.New("registration is invite-only; an invite_code is required")
+	errOAuthUserNotFound = errors.New("this account no longer exists")
+	errOAuthLastSignIn   = errors.New("set a password before unlinking your only way to sign in")
+)
+
+// OAuth signs users in with their accounts at the providers configured,
+// creating accounts for new users and linking existing ones by email.
+type OAuth struct {
+	db           *database.DatabaseConnection
+	tokens       *auth.TokenManager
+	providers    map[string]oauth.Provider
+	callbackURL  string
+	appRedirects []string
+	inviteOnly   bool
+}
+
+// NewOAuth sets up each provider whose client ID is configured.
+func NewOAuth(dbConnection *database.DatabaseConnection, tokens *auth.TokenManager, appConfig *config.ApplicationConfig) (*OAuth, error) {
+	providers := make(map[string]oauth.Provider)
+	if appConfig.GoogleClientID != "" {
+		providers[models.ProviderGoogle] = oauth.NewGoogle(appConfig.GoogleClientID, appConfig.GoogleClientSecret)
+	}
+	if appConfig.GitHubClientID != "" {
+		providers[models.ProviderGitHub] = oauth.NewGitHub(appConfig.GitHubClientID, appConfig.GitHubClientSecret)
+	}
+	if appConfig.AppleClientID != "" {
+		apple, err := oauth.NewApple(oauth.AppleConfig{
+			ClientID: appConfig.AppleClientID,
+			KeyFile:  appConfig.AppleKeyFile,
+			KeyID:    appConfig.AppleKeyID,
+			TeamID:   appConfig.AppleTeamID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		providers[models.ProviderApple] = apple
+	}
+	return &OAuth{
+		db:           dbConnection,
+		tokens:       tokens,
+		providers:    providers,
+		callbackURL:  strings.TrimSuffix(appConfig.OAuthCallbackURL, "/"),
+		appRedirects: appConfig.OAuthAppRedirectURLs,
+		inviteOnly:   appConfig.RegistrationMode == config.RegistrationInviteOnly,
+	}, nil
+}
+
+// callback is the address a provider sends users back to.
+func (o *OAuth) callback(provider string) string {
+	return o.callbackURL + "/" + provider + "/callback"
+}
+
+// StartOAuth sends the browser to sign in at the provider named by the
+// :provider parameter. Once signed in, the user returns to the app at the
+// redirect_uri query parameter, which must be one of those configured.
+// Without one, the callback responds with tokens like Login does. New
+// accounts redeem the invite_code parameter when registration is
+// invite-only.
+func StartOAuth(c *gin.Context, o *OAuth) {
+	c.Header("Cache-Control", "no-store")
+
+	name := c.Param("provider")
+	provider, ok := o.providers[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "sign-in provider not available",
+		})
+		return
+	}
+	redirectURI := c.Query("redirect_uri")
+	if redirectURI != "" && !slices.Contains(o.appRedirects, redirectURI) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "redirect_uri is not allowed",
+		})
+		return
+	}
+	inviteCode := strings.TrimSpace(c.Query("invite_code"))
+	if len(inviteCode) > 16 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invite_code is invalid",
+		})
+		return
+	}
+
+	verifier, err := oauth.NewVerifier()
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to start OAuth sign-in", "provider", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to start sign-in",
+		})
+		return
+	}
+	challenge := oauth.Challenge(verifier)
+	state, err := o.tokens.IssueOAuthState(auth.OAuthState{
+		Provider:    name,
+		RedirectURI: redirectURI,
+		InviteCode:  inviteCode,
+		Binding:     challenge,
+	}, oauthStateTTL)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to start OAuth sign-in", "provider", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to start sign-in",
+		})
+		return
+	}
+
+	setOAuthCookie(c, verifier, int(oauthStateTTL.Seconds()))
+	c.Redirect(http.StatusFound, provider.AuthCodeURL(state, challenge, o.callback(name)))
+}
+
+// setOAuthCookie stores the verifier, or clears it with a negative maxAge.
+// Apple posts its callback from its own site, so the cookie must be sent
+// on cross-site requests.
+func setOAuthCookie(c *gin.Context, verifier string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     oauthCookie,
+		Value:    verifier,
+		Path:     oauthCookiePath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// OAuthCallback finishes a sign-in started by StartOAuth when the provider
+// sends the user back, by GET or, for Apple, by POST. The user signs in to
+// the account linked to their provider account, or else to the account
+// with the same email if the provider verified it, or else to a new
+// account without a password.
+//
+// Apps are sent back to their redirect_uri with a refresh token in the
+// fragment, which they redeem at /auth/refresh within two minutes, or with
+// an error.
+func OAuthCallback(c *gin.Context, o *OAuth) {
+	c.Header("Cache-Control", "no-store")
+
+	name := c.Param("provider")
+	provider, ok := o.providers[name]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "sign-in provider not available",
+		})
+		return
+	}
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid callback",
+		})
+		return
+	}
+
+	verifier, _ := c.Cookie(oauthCookie)
+	state, err := o.tokens.ParseOAuthState(c.Request.Form.Get("state"))
+	if err != nil || state.Provider != name || verifier == "" || oauth.Challenge(verifier) != state.Binding {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "sign-in expired or was started in another browser; please try again",
+		})
+		return
+	}
+	setOAuthCookie(c, "", -1)
+
+	if c.Request.Form.Get("error") != "" {
+		o.fail(c, state, http.StatusUnauthorized, "sign-in was cancelled")
+		return
+	}
+	code := c.Request.Form.Get("code")
+	if code == "" {
+		o.fail(c, state, http.StatusBadRequest, "invalid callback")
+		return
+	}
+
+	ctx := c.Request.Context()
+	identity, err := provider.Exchange(ctx, code, verifier, o.callback(name), c.Request.Form)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to complete OAuth sign-in", "provider", name, "error", err)
+		o.fail(c, state, http.StatusBadGateway, "failed to sign in with "+name)
+		return
+	}
+
+	user, created, err := o.signIn(ctx, name, identity, state.InviteCode)
+	if err != nil {
+		status, message := http.StatusInternalServerError, "failed to sign in"
+		switch {
+		case errors.Is(err, errOAuthNoEmail):
+			status, message = http.StatusBadRequest, err.Error()
+		case errors.Is(err, errOAuthEmailTaken), errors.Is(err, gorm.ErrDuplicatedKey):
+			status, message = http.StatusConflict, errOAuthEmailTaken.Error()
+		case errors.Is(err, errOAuthInviteOnly), errors.Is(err, ErrInvalidInvite):
+			status, message = http.StatusForbidden, err.Error()
+		case errors.Is(err, errOAuthUserNotFound):
+			status, message = http.StatusUnauthorized, err.Error()
+		default:
+			slog.ErrorContext(ctx, "Failed to sign in with OAuth identity", "provider", name, "error", err)
+		}
+		o.fail(c, state, status, message)
+		return
+	}
+	if reason := accountBlockedReason(user); reason != "" {
+		o.fail(c, state, http.StatusForbidden, reason)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	if state.RedirectURI == "" {
+		respondWithToken(c, status, o.db, o.tokens, user)
+		return
+	}
+	_, refreshToken, err := startSession(c, o.db, user, oauthHandoffTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to start session", "user_id", user.ID, "error", err)
+		o.fail(c, state, http.StatusInternalServerError, "failed to issue token")
+		return
+	}
+	fragment := url.Values{
+		"refresh_token": {refreshToken},
+		"new_user":      {strconv.FormatBool(created)},
+	}
+	c.Redirect(http.StatusFound, state.RedirectURI+"#"+fragment.Encode())
+}
+
+// fail sends the user back to the app with an error, or responds with it
+// when there is no app to return to.
+func (o *OAuth) fail(c *gin.Context, state *auth.OAuthState, status int, message string) {
+	if state.RedirectURI == "" {
+		c.JSON(status, gin.H{
+			"status": "error",
+			"error":  message,
+		})
+		return
+	}
+	fragment := url.Values{"error": {message}}
+	c.Redirect(http.StatusFound, state.RedirectURI+"#"+fragment.Encode())
+}
+
+// signIn finds or creates the user a provider identity belongs to,
+// reporting whether it created them.
+func (o *OAuth) signIn(ctx context.Context, provider string, identity *oauth.Identity, inviteCode string) (*models.User, bool, error) {
+	var user models.User
+	created, verified, clearedPassword := false, false, false
+	now := time.Now()
+	email := strings.ToLower(strings.TrimSpace(identity.Email))
+
+	err := o.db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		err := tx.Where("provider = ? AND subject = ?", provider, identity.Subject).First(&link).Error
+		if err == nil {
+			if err := tx.First(&user, "id = ?", link.UserID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return errOAuthUserNotFound
+				}
+				return err
+			}
+			if err := tx.Model(&link).Updates(map[string]any{"email": email, "last_login_at": now}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&user).Update("last_login_at", now).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if email == "" {
+			return errOAuthNoEmail
+		}
+		err = tx.Where("email = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			// An email the provider has not verified could belong to
+			// someone else.
+			if !identity.EmailVerified {
+				return errOAuthEmailTaken
+			}
+			updates := map[string]any{"last_login_at": now}
+			if !user.IsVerified {
+				// Whoever chose the password never proved they own the
+				// email, unlike the user signing in now.
+				updates["is_verified"] = true
+				verified = true
+				updates["password_hash"] = ""
+				updates["salt"] = ""
+				clearedPassword = user.PasswordHash != ""
+			}
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return err
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if err := o.register(tx, &user, email, identity, inviteCode); err != nil {
+				return err
+			}
+			created = true
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    provider,
+			Subject:     identity.Subject,
+			Email:       email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if verified {
+		invalidateProfile(ctx, user.ID)
+	}
+	if clearedPassword {
+		if _, err := repositories.NewSessionRepository(o.db.DB).RevokeAll(ctx, user.ID, uuid.Nil); err != nil {
+			slog.ErrorContext(ctx, "Failed to revoke sessions of linked user", "user_id", user.ID, "error", err)
+		}
+	}
+	return &user, created, nil
+}
+
+// register creates an account for a provider identity inside tx, in the
+// welcome rooms for everyone. The account has no password; its owner signs
+// in through the provider, or sets one with a password reset.
+func (o *OAuth) register(tx *gorm.DB, user *models.User, email string, identity *oauth.Identity, inviteCode string) error {
+	var inviteID *uuid.UUID
+	if o.inviteOnly {
+		if inviteCode == "" {
+			return errOAuthInviteOnly
+		}
+		invite, err := RedeemInvite(tx, inviteCode)
+		if err != nil {
+			return err
+		}
+		inviteID = &invite.ID
+	}
+
+	username, err := availableUsername(tx, identity, email)
+	if err != nil {
+		return err
+	}
+	displayName := truncate(strings.TrimSpace(identity.Name), 100)
+	if displayName == "" {
+		displayName = username
+	}
+
+	now := time.Now()
+	*user = models.User{
+		Email:        email,
+		Username:     username,
+		DisplayName:  displayName,
+		IsVerified:   identity.EmailVerified,
+		InviteCodeID: inviteID,
+		LastLoginAt:  &now,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return err
+	}
+	return joinWelcomeRooms(tx.Statement.Context, tx, user)
+}
+
+// availableUsername derives a free username from the provider's handle for
+// the user or their email, adding a number when it is taken.
+func availableUsername(tx *gorm.DB, identity *oauth.Identity, email string) (string, error) {
+	base := identity.Username
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		case r == '.':
+			return '_'
+		}
+		return -1
+	}, base)
+	base = truncate(base, 40)
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for range 5 {
+		var taken int64
+		if err := tx.Unscoped().Model(&models.User{}).
+			Where("LOWER(username) = LOWER(?)", candidate).
+			Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken == 0 {
+			return candidate, nil
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(100000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s-%05d", base, n)
+	}
+	return "", fmt.Errorf("no free username for %q: %w", base, gorm.ErrDuplicatedKey)
+}
+
+// ListIdentities returns the provider accounts linked to the current user.
+func ListIdentities(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		identities := []models.UserIdentity{}
+		if err := dbConnection.DB.WithContext(c.Request.Context()).
+			Where("user_id = ?", CurrentUserID(c)).
+			Order("created_at").
+			Find(&identities).Error; err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list identities", "error", err)
+			return nil, internalError("failed to list linked accounts")
+		}
+		return &Response{Data: identities, Legacy: gin.H{"identities": identities}}, nil
+	}
+}
+
+// UnlinkIdentity removes the current user's account at the provider named
+// by the :provider parameter. A user without a password must keep at
+// least one, so they can still sign in.
+func UnlinkIdentity(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		user := CurrentUser(c)
+		err := dbConnection.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			if user.PasswordHash == "" {
+				var others int64
+				if err := tx.Model(&models.UserIdentity{}).
+					Where("user_id = ? AND provider <> ?", user.ID, c.Param("provider")).
+					Count(&others).Error; err != nil {
+					return err
+				}
+				if others == 0 {
+					return errOAuthLastSignIn
+				}
+			}
+			result := tx.Where("user_id = ? AND provider = ?", user.ID, c.Param("provider")).Delete(&models.UserIdentity{})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return gorm.ErrRecordNotFound
+			}
+			return nil
+		})
+		switch {
+		case errors.Is(err, errOAuthLastSignIn):
+			return nil, conflict(err.Error())
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return nil, notFound("no linked account at this provider")
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "Failed to unlink identity", "error", err)
+			return nil, internalError("failed to unlink account")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
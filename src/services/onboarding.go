@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/geoip"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// geoIPTimeout bounds the lookup made while registering, which goes
+	// ahead without suggestions when the provider is slow.
+	geoIPTimeout = 2 * time.Second
+
+	// geoIPCacheTTL and geoIPCacheSize bound the remembered lookups.
+	geoIPCacheTTL  = 24 * time.Hour
+	geoIPCacheSize = 10_000
+
+	// maxSuggestedRooms is how many local rooms a new user is offered.
+	maxSuggestedRooms = 5
+)
+
+// NewGeoIPProvider returns the geolocation provider the configuration
+// selects, cached, or nil when guessing is off.
+func NewGeoIPProvider(appConfig *config.ApplicationConfig) geoip.Provider {
+	if appConfig.GeoIP == config.GeoIPMaxMind {
+		provider := geoip.NewMaxMind(appConfig.MaxMindAccountID, appConfig.MaxMindLicenseKey, appConfig.MaxMindHost)
+		return geoip.NewCached(provider, geoIPCacheTTL, geoIPCacheSize)
+	}
+	return nil
+}
+
+// Onboarding guesses settings for new users from the address they
+// register from: the time zone and country are filled in on the account,
+// which the user can change like any other setting, and those with the
+// language and local rooms are kept as suggestions for the apps to offer.
+type Onboarding struct {
+	dbConnection *database.DatabaseConnection
+	geo          geoip.Provider
+}
+
+func NewOnboarding(dbConnection *database.DatabaseConnection, geo geoip.Provider) *Onboarding {
+	return &Onboarding{dbConnection: dbConnection, geo: geo}
+}
+
+// Locate guesses where clientIP is, reporting false when it cannot.
+func (o *Onboarding) Locate(ctx context.Context, clientIP string) (geoip.Location, bool) {
+	addr, err := netip.ParseAddr(clientIP)
+	if o.geo == nil || err != nil {
+		return geoip.Location{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, geoIPTimeout)
+	defer cancel()
+	location, err := o.geo.Lookup(ctx, addr.Unmap())
+	if err != nil {
+		if !errors.Is(err, geoip.ErrUnknown) {
+			slog.WarnContext(ctx, "Failed to locate client", "error", err)
+		}
+		return geoip.Location{}, false
+	}
+	if _, err := time.LoadLocation(location.TimeZone); err != nil || location.TimeZone == "Local" {
+		location.TimeZone = ""
+	}
+	return location, true
+}
+
+// Suggest stores the suggestions for a new user. Failing to is logged and
+// otherwise ignored: registration does not depend on it.
+func (o *Onboarding) Suggest(ctx context.Context, userID uuid.UUID, location geoip.Location) {
+	channels, err := repositories.NewChannelRepository(o.dbConnection.DB).PopularIn(ctx, location.CountryCode, maxSuggestedRooms)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find local rooms", "user_id", userID, "error", err)
+	}
+	roomIDs := make([]uuid.UUID, 0, len(channels))
+	for _, channel := range channels {
+		roomIDs = append(roomIDs, channel.ConversationID)
+	}
+	raw, err := json.Marshal(roomIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode suggested rooms", "user_id", userID, "error", err)
+		return
+	}
+
+	suggestion := models.SettingsSuggestion{
+		UserID:      userID,
+		CountryCode: location.CountryCode,
+		TimeZone:    location.TimeZone,
+		Language:    geoip.Language(location.CountryCode),
+		RoomIDs:     models.JSON(raw),
+	}
+	if err := repositories.NewSettingsSuggestionRepository(o.dbConnection.DB).Create(ctx, &suggestion); err != nil {
+		slog.ErrorContext(ctx, "Failed to store settings suggestions", "user_id", userID, "error", err)
+	}
+}
+
+// SettingsSuggestions are the settings suggested to the current user, with
+// the suggested rooms that are still public.
+type SettingsSuggestions struct {
+	models.SettingsSuggestion
+	Rooms []models.Channel `json:"rooms"`
+}
+
+// GetSettingsSuggestions returns the settings suggested to the current
+// user when they registered, until they dismiss them.
+func GetSettingsSuggestions(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		suggestion, err := repositories.NewSettingsSuggestionRepository(dbConnection.DB).Get(ctx, CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no settings suggestions")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load settings suggestions", "error", err)
+			return nil, internalError("failed to load settings suggestions")
+		}
+
+		view := SettingsSuggestions{SettingsSuggestion: *suggestion, Rooms: []models.Channel{}}
+		var roomIDs []uuid.UUID
+		if len(suggestion.RoomIDs) > 0 {
+			if err := json.Unmarshal(suggestion.RoomIDs, &roomIDs); err != nil {
+				slog.ErrorContext(ctx, "Failed to decode suggested rooms", "error", err)
+			}
+		}
+		if len(roomIDs) > 0 {
+			if err := dbConnection.DB.WithContext(ctx).
+				Where("conversation_id IN ? AND is_private = ?", roomIDs, false).
+				Find(&view.Rooms).Error; err != nil {
+				slog.ErrorContext(ctx, "Failed to load suggested rooms", "error", err)
+				return nil, internalError("failed to load settings suggestions")
+			}
+		}
+		return &Response{Data: view, Legacy: gin.H{"suggestions": view}}, nil
+	}
+}
+
+// DismissSettingsSuggestions discards the current user's suggestions.
+func DismissSettingsSuggestions(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		if err := repositories.NewSettingsSuggestionRepository(dbConnection.DB).Delete(c.Request.Context(), CurrentUserID(c)); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to dismiss settings suggestions", "error", err)
+			return nil, internalError("failed to dismiss settings suggestions")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
@@ -0,0 +1,295 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// JobOnboardingDrip is the scheduled job sending new users the
+	// onboarding steps that fell due.
+	JobOnboardingDrip = "onboarding_drip"
+
+	// OnboardingScanInterval is how often due onboarding steps are looked
+	// for, and so about how late one may be sent.
+	OnboardingScanInterval = 15 * time.Minute
+
+	// OnboardingWindow is how long after falling due a step is still sent,
+	// so a step added later reaches only users still in their first days
+	// and the sequence catches up after an outage without flooding anyone.
+	OnboardingWindow = 3 * 24 * time.Hour
+
+	// onboardingBatch bounds the users one run sends each step to.
+	onboardingBatch = 500
+)
+
+// onboardingKeyPattern is what step keys look like: profile_tips.
+var onboardingKeyPattern = regexp.MustCompile(`^[a-z0-9_]{1,50}$`)
+
+type onboardingStepRequest struct {
+	Key          string `json:"key" binding:"required,max=50"`
+	Text         string `json:"text" binding:"required,max=4000"`
+	DelaySeconds int64  `json:"delay_seconds" binding:"min=0"`
+}
+
+// OnboardingStepView is a step of the onboarding sequence with the
+// current user's progress on it.
+type OnboardingStepView struct {
+	Key         string     `json:"key"`
+	Text        string     `json:"text"`
+	DueAt       time.Time  `json:"due_at"`
+	MessageID   *uuid.UUID `json:"message_id"`
+	SentAt      *time.Time `json:"sent_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// OnboardingView is the current user's onboarding sequence. Enabled is
+// whether they still take its messages.
+type OnboardingView struct {
+	Enabled bool                 `json:"enabled"`
+	Steps   []OnboardingStepView `json:"steps"`
+}
+
+// GetMyOnboarding returns the steps of the onboarding sequence with when
+// each falls due for the current user, and which were sent and
+// completed. Users opt out with onboarding_messages in their profile.
+func GetMyOnboarding(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		user := CurrentUser(c)
+		onboarding := repositories.NewOnboardingRepository(dbConnection.DB)
+		steps, err := onboarding.Steps(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list onboarding steps", "error", err)
+			return nil, internalError("failed to load onboarding")
+		}
+		progress, err := onboarding.Progress(ctx, user.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load onboarding progress", "error", err)
+			return nil, internalError("failed to load onboarding")
+		}
+		byStep := make(map[uuid.UUID]models.OnboardingProgress, len(progress))
+		for _, p := range progress {
+			byStep[p.StepID] = p
+		}
+
+		view := OnboardingView{Enabled: user.OnboardingMessages, Steps: make([]OnboardingStepView, 0, len(steps))}
+		for _, step := range steps {
+			p := byStep[step.ID]
+			view.Steps = append(view.Steps, OnboardingStepView{
+				Key:         step.Key,
+				Text:        step.Text,
+				DueAt:       user.CreatedAt.Add(time.Duration(step.DelaySeconds) * time.Second),
+				MessageID:   p.MessageID,
+				SentAt:      p.SentAt,
+				CompletedAt: p.CompletedAt,
+			})
+		}
+		return &Response{Data: view, Legacy: gin.H{"onboarding": view}}, nil
+	}
+}
+
+// CompleteOnboardingStep marks the step named by the :key parameter done
+// for the current user, once they did what it suggests. A step completed
+// before it falls due is not sent.
+func CompleteOnboardingStep(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		onboarding := repositories.NewOnboardingRepository(dbConnection.DB)
+		step, err := onboarding.StepByKey(ctx, c.Param("key"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("onboarding step not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load onboarding step", "error", err)
+			return nil, internalError("failed to complete onboarding step")
+		}
+		progress, err := onboarding.Complete(ctx, CurrentUserID(c), step.ID, time.Now())
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to complete onboarding step", "step_id", step.ID, "error", err)
+			return nil, internalError("failed to complete onboarding step")
+		}
+		return &Response{Data: progress, Legacy: gin.H{"progress": progress}}, nil
+	}
+}
+
+// ListOnboardingSteps returns the onboarding sequence in the order it is
+// sent.
+func ListOnboardingSteps(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		steps, err := repositories.NewOnboardingRepository(dbConnection.DB).Steps(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list onboarding steps", "error", err)
+			return nil, internalError("failed to list onboarding steps")
+		}
+		return &Response{Data: steps, Legacy: gin.H{"steps": steps}}, nil
+	}
+}
+
+// CreateOnboardingStep adds a step to the onboarding sequence, sent to
+// each new user delay_seconds after they sign up.
+func CreateOnboardingStep(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req onboardingStepRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !onboardingKeyPattern.MatchString(req.Key) {
+			return nil, badRequest("key must be lowercase letters, digits and underscores")
+		}
+		step := models.OnboardingStep{Key: req.Key, Text: req.Text, DelaySeconds: req.DelaySeconds}
+		err := repositories.NewOnboardingRepository(dbConnection.DB).CreateStep(c.Request.Context(), &step)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("a step with that key already exists")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create onboarding step", "error", err)
+			return nil, internalError("failed to create onboarding step")
+		}
+		return &Response{Status: http.StatusCreated, Data: step, Legacy: gin.H{"step": step}}, nil
+	}
+}
+
+// UpdateOnboardingStep replaces the step named by the :id parameter.
+// Users it was already sent to are not sent it again.
+func UpdateOnboardingStep(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid step id")
+		}
+		var req onboardingStepRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if !onboardingKeyPattern.MatchString(req.Key) {
+			return nil, badRequest("key must be lowercase letters, digits and underscores")
+		}
+
+		ctx := c.Request.Context()
+		onboarding := repositories.NewOnboardingRepository(dbConnection.DB)
+		step, err := onboarding.GetStep(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("onboarding step not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load onboarding step", "step_id", id, "error", err)
+			return nil, internalError("failed to load onboarding step")
+		}
+		step.Key, step.Text, step.DelaySeconds = req.Key, req.Text, req.DelaySeconds
+		err = onboarding.SaveStep(ctx, step)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("a step with that key already exists")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to update onboarding step", "step_id", id, "error", err)
+			return nil, internalError("failed to update onboarding step")
+		}
+		return &Response{Data: step, Legacy: gin.H{"step": step}}, nil
+	}
+}
+
+// DeleteOnboardingStep removes the step named by the :id parameter from
+// the sequence.
+func DeleteOnboardingStep(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid step id")
+		}
+		err = repositories.NewOnboardingRepository(dbConnection.DB).DeleteStep(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("onboarding step not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete onboarding step", "step_id", id, "error", err)
+			return nil, internalError("failed to delete onboarding step")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// SendOnboarding is the scheduled job sending each step of the onboarding
+// sequence that fell due, as a direct message from the account named
+// sender, to the users who take onboarding messages and have not
+// completed it. A step that fails to send is tried again on the next run.
+func SendOnboarding(dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, sender string) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		var from models.User
+		err := dbConnection.DB.WithContext(ctx).First(&from, "username = ? AND is_banned = ?", sender, false).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("onboarding sender %q does not exist", sender)
+		}
+		if err != nil {
+			return err
+		}
+
+		onboarding := repositories.NewOnboardingRepository(dbConnection.DB)
+		steps, err := onboarding.Steps(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		sent := 0
+		for i := range steps {
+			step := &steps[i]
+			recipients, err := onboarding.DueRecipients(ctx, step, now, OnboardingWindow, onboardingBatch)
+			if err != nil {
+				return err
+			}
+			for _, userID := range recipients {
+				if userID == from.ID {
+					continue
+				}
+				if err := sendOnboardingStep(ctx, dbConnection, hub, notifier, suggester, index, onboarding, from.ID, userID, step, now); err != nil {
+					slog.WarnContext(ctx, "Failed to send onboarding step", "step", step.Key, "user_id", userID, "error", err)
+					continue
+				}
+				sent++
+			}
+		}
+		if sent > 0 {
+			slog.InfoContext(ctx, "Sent onboarding steps", "count", sent)
+		}
+		return nil
+	}
+}
+
+// sendOnboardingStep claims a step for a user and sends it, releasing the
+// claim if sending fails. Users who blocked the sender keep the claim and
+// are not tried again.
+func sendOnboardingStep(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, onboarding *repositories.OnboardingRepository, senderID, userID uuid.UUID, step *models.OnboardingStep, now time.Time) error {
+	claimed, err := onboarding.Claim(ctx, userID, step.ID, now)
+	if err != nil || !claimed {
+		return err
+	}
+	conversation, _, err := repositories.NewConversationRepository(dbConnection.DB).FindOrCreateDirect(ctx, models.DefaultWorkspaceID, senderID, userID)
+	var message *models.Message
+	if err == nil {
+		message, _, err = postMessage(ctx, dbConnection, hub, notifier, suggester, index, senderID, conversation.ID, messageInput{Text: step.Text})
+	}
+	if errors.Is(err, errBlocked) {
+		return nil
+	}
+	if err != nil {
+		if unclaimErr := onboarding.Unclaim(ctx, userID, step.ID); unclaimErr != nil {
+			slog.ErrorContext(ctx, "Failed to release onboarding step", "step", step.Key, "user_id", userID, "error", unclaimErr)
+		}
+		return err
+	}
+	return onboarding.Sent(ctx, userID, step.ID, message.ID)
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/idgen"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+)
+
+// messageRateWindow is how far back messages are counted for the rate
+// the overview shows.
+const messageRateWindow = 5 * time.Minute
+
+// AdminOverview is the state of the service at a glance.
+type AdminOverview struct {
+	RegistrationsToday int64 `json:"registrations_today"`
+
+	// ActiveConnections counts the WebSocket and long-polling connections
+	// open to the instance that answered.
+	ActiveConnections int `json:"active_connections"`
+
+	// MessagesPerMinute is averaged over the last few minutes.
+	MessagesPerMinute float64 `json:"messages_per_minute"`
+
+	PendingReports int64 `json:"pending_reports"`
+	PendingAppeals int64 `json:"pending_appeals"`
+	FailedJobs     int64 `json:"failed_jobs"`
+
+	StorageGrowth StorageGrowth `json:"storage_growth"`
+	GeneratedAt   time.Time     `json:"generated_at"`
+}
+
+// StorageGrowth is the bytes of attachments, backups and data exports
+// stored recently.
+type StorageGrowth struct {
+	TodayBytes     int64 `json:"today_bytes"`
+	Last7DaysBytes int64 `json:"last_7_days_bytes"`
+}
+
+// GetAdminOverview returns the day's registrations, open connections,
+// message rate, moderation and job backlogs, and storage growth. Days
+// start at midnight UTC.
+func GetAdminOverview(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		now := time.Now().UTC()
+		today := now.Truncate(24 * time.Hour)
+		overview := AdminOverview{ActiveConnections: hub.ConnectionCount(), GeneratedAt: now}
+
+		db := dbConnection.DB.WithContext(ctx)
+		counts := []struct {
+			name  string
+			query func() error
+		}{
+			{"registrations", func() error {
+				return db.Model(&models.User{}).Where("created_at >= ?", today).Count(&overview.RegistrationsToday).Error
+			}},
+			{"pending reports", func() error {
+				return db.Model(&models.Report{}).Where("status = ?", models.ReportOpen).Count(&overview.PendingReports).Error
+			}},
+			{"pending appeals", func() error {
+				return db.Model(&models.Appeal{}).Where("status = ?", models.AppealPending).Count(&overview.PendingAppeals).Error
+			}},
+			{"failed jobs", func() error {
+				return db.Model(&models.Job{}).Where("status = ?", models.JobFailed).Count(&overview.FailedJobs).Error
+			}},
+			{"messages", func() error {
+				// Message IDs sort by creation, so the primary key finds
+				// the recent ones.
+				var recent int64
+				err := db.Unscoped().Model(&models.Message{}).
+					Where("id >= ?", idgen.Floor(now.Add(-messageRateWindow)).UUID()).
+					Count(&recent).Error
+				overview.MessagesPerMinute = float64(recent) / messageRateWindow.Minutes()
+				return err
+			}},
+			{"storage growth", func() error {
+				var err error
+				overview.StorageGrowth, err = storageGrowth(ctx, dbConnection, today)
+				return err
+			}},
+		}
+		for _, count := range counts {
+			if err := count.query(); err != nil {
+				slog.ErrorContext(ctx, "Failed to build admin overview", "count", count.name, "error", err)
+				return nil, internalError("failed to build overview")
+			}
+		}
+		return &Response{Data: overview, Legacy: gin.H{"overview": overview}}, nil
+	}
+}
+
+// storageGrowth sums the bytes of the files stored since the start of the
+// week before today and since today.
+func storageGrowth(ctx context.Context, dbConnection *database.DatabaseConnection, today time.Time) (StorageGrowth, error) {
+	var growth StorageGrowth
+	weekAgo := today.AddDate(0, 0, -6)
+	for _, files := range []struct {
+		model any
+		ready string
+	}{
+		{&models.Attachment{}, models.AttachmentReady},
+		{&models.Backup{}, models.BackupReady},
+		{&models.DataExport{}, models.DataExportReady},
+		{&models.Transcript{}, models.TranscriptReady},
+	} {
+		var sums struct {
+			Week  int64
+			Today int64
+		}
+		err := dbConnection.DB.WithContext(ctx).Unscoped().Model(files.model).
+			Select("COALESCE(SUM(size_bytes), 0) AS week, COALESCE(SUM(CASE WHEN created_at >= ? THEN size_bytes ELSE 0 END), 0) AS today", today).
+			Where("status = ? AND created_at >= ?", files.ready, weekAgo).
+			Scan(&sums).Error
+		if err != nil {
+			return StorageGrowth{}, err
+		}
+		growth.Last7DaysBytes += sums.Week
+		growth.TodayBytes += sums.Today
+	}
+	return growth, nil
+}
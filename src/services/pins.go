@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxPinnedConversations caps the conversations a user may pin in one
+// workspace.
+const maxPinnedConversations = 10
+
+type reorderPinsRequest struct {
+	ConversationIDs []uuid.UUID `json:"conversation_ids" binding:"required,max=10"`
+}
+
+// ListPinnedConversations returns the conversations the current user
+// pinned, in their order.
+func ListPinnedConversations(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversations, err := repositories.NewConversationRepository(dbConnection.DB).
+			PinnedForUser(c.Request.Context(), CurrentWorkspaceID(c), CurrentUserID(c), repositories.ConversationFilter{})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list pinned conversations", "error", err)
+			return nil, internalError("failed to list pinned conversations")
+		}
+		return &Response{Data: conversations, Legacy: gin.H{"conversations": conversations}}, nil
+	}
+}
+
+// PinConversation pins a conversation the current user belongs to at the
+// top of their conversation list, after their other pins.
+func PinConversation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err := repositories.NewConversationRepository(dbConnection.DB).Pin(c.Request.Context(), CurrentUserID(c), conversation, maxPinnedConversations)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("you can pin at most " + strconv.Itoa(maxPinnedConversations) + " conversations")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to pin conversation", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to pin conversation")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// UnpinConversation unpins a conversation the current user belongs to.
+func UnpinConversation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		err := repositories.NewConversationRepository(dbConnection.DB).Unpin(c.Request.Context(), CurrentUserID(c), conversation.ID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("conversation is not pinned")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to unpin conversation", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to unpin conversation")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// ReorderPinnedConversations puts the current user's pins in the order
+// given, which must list each of their pinned conversations once.
+func ReorderPinnedConversations(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req reorderPinsRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		conversations := repositories.NewConversationRepository(dbConnection.DB)
+		pinned, err := conversations.PinnedIDs(ctx, CurrentWorkspaceID(c), userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list pins", "error", err)
+			return nil, internalError("failed to reorder pins")
+		}
+		remaining := make(map[uuid.UUID]bool, len(pinned))
+		for _, id := range pinned {
+			remaining[id] = true
+		}
+		for _, id := range req.ConversationIDs {
+			if !remaining[id] {
+				return nil, badRequest("conversation_ids must list each pinned conversation once")
+			}
+			delete(remaining, id)
+		}
+		if len(remaining) > 0 {
+			return nil, badRequest("conversation_ids must list each pinned conversation once")
+		}
+
+		if err := conversations.ReorderPins(ctx, userID, req.ConversationIDs); err != nil {
+			slog.ErrorContext(ctx, "Failed to reorder pins", "error", err)
+			return nil, internalError("failed to reorder pins")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
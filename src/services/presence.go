@@ -0,0 +1,130 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/presence"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/google/uuid"
+)
+
+const (
+	presenceGracePeriod     = 10 * time.Second
+	PresenceRefreshInterval = time.Minute
+
+	eventPresenceUpdate = "presence.update"
+
+	// eventFocus reports the conversation a connection has in view, or
+	// none when conversation_id is missing, as when the app goes to the
+	// background. Users are not pushed messages in a conversation they
+	// have in view.
+	eventFocus = "conversation.focus"
+)
+
+type focusPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+type presenceStore struct {
+	dbConnection *database.DatabaseConnection
+}
+
+func (s presenceStore) SetStatus(ctx context.Context, userID uuid.UUID, status string, lastSeenAt time.Time) error {
+	return s.dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]any{"status": status, "last_seen_at": lastSeenAt}).Error
+}
+
+func (s presenceStore) TouchLastSeen(ctx context.Context, userIDs []uuid.UUID, at time.Time) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	// Re-asserting online corrects a user another instance marked offline
+	// while they were still connected here.
+	return s.dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+		Where("id IN ?", userIDs).
+		Updates(map[string]any{"status": presence.StatusOnline, "last_seen_at": at}).Error
+}
+
+func (s presenceStore) ExpireStale(ctx context.Context, before time.Time) ([]uuid.UUID, error) {
+	var expired []models.User
+	err := s.dbConnection.DB.WithContext(ctx).
+		Raw("UPDATE users SET status = ? WHERE status = ? AND (last_seen_at IS NULL OR last_seen_at < ?) RETURNING id",
+			presence.StatusOffline, presence.StatusOnline, before).
+		Scan(&expired).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uuid.UUID, 0, len(expired))
+	for _, user := range expired {
+		ids = append(ids, user.ID)
+	}
+	return ids, nil
+}
+
+// presenceAudience returns everyone who shares a conversation or channel
+// with the user, except users the user has blocked or been blocked by.
+func presenceAudience(dbConnection *database.DatabaseConnection) presence.Audience {
+	return func(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+		var ids []uuid.UUID
+		err := dbConnection.DB.WithContext(ctx).Model(&models.ConversationMember{}).
+			Distinct("user_id").
+			Where("conversation_id IN (?)", dbConnection.DB.Model(&models.ConversationMember{}).
+				Select("conversation_id").
+				Where("user_id = ? AND deleted_at IS NULL", userID)).
+			Where("user_id <> ?", userID).
+			Where("user_id NOT IN (?)", repositories.BlockedWith(dbConnection.DB, userID)).
+			Pluck("user_id", &ids).Error
+		return ids, err
+	}
+}
+
+// NewPresenceTracker tracks presence from the hub's connections and
+// broadcasts presence.update events to the user's conversation partners.
+func NewPresenceTracker(hub *realtime.Hub, dbConnection *database.DatabaseConnection) *presence.Tracker {
+	broadcast := func(userIDs []uuid.UUID, update presence.Update) {
+		event, err := realtime.NewEvent(eventPresenceUpdate, update)
+		if err != nil {
+			slog.Error("Failed to encode presence update", "error", err)
+			return
+		}
+		hub.SendToUsers(userIDs, event)
+	}
+
+	tracker := presence.NewTracker(hub, presenceStore{dbConnection}, presenceAudience(dbConnection), broadcast, presenceGracePeriod)
+	hub.OnConnect(func(client *realtime.Client) { tracker.Connected(client.UserID) })
+	hub.OnDisconnect(func(client *realtime.Client) {
+		tracker.Unfocus(client.UserID, client.ID)
+		tracker.Disconnected(client.UserID)
+	})
+
+	hub.Handle(eventFocus, func(_ context.Context, client *realtime.Client, event realtime.Event) {
+		var payload focusPayload
+		if len(event.Data) > 0 {
+			if err := json.Unmarshal(event.Data, &payload); err != nil {
+				replyError(client, event, "invalid focus payload")
+				return
+			}
+		}
+		tracker.Focus(client.UserID, client.ID, payload.ConversationID)
+	})
+	// Someone typing in a conversation has it in view, whether or not
+	// their client reports focus.
+	hub.OnEvent(func(client *realtime.Client, event realtime.Event) {
+		if event.Type != eventTypingStart {
+			return
+		}
+		var payload typingPayload
+		if err := json.Unmarshal(event.Data, &payload); err == nil && payload.ConversationID != uuid.Nil {
+			tracker.Focus(client.UserID, client.ID, payload.ConversationID)
+		}
+	})
+	return tracker
+}
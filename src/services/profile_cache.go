@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/profilecache"
+	"github.com/google/uuid"
+)
+
+// missingProfileTTL is how long users found missing are cached, short so
+// an ID looked up just before its account is restored soon resolves.
+const missingProfileTTL = 30 * time.Second
+
+// profileCache, when set, holds the public profiles GetUser and
+// ResolveUsers return. Without one every lookup reads the database.
+var profileCache *profilecache.Cache
+
+// NewProfileCache returns the profile cache named by the config, or nil
+// when it is off.
+func NewProfileCache(appConfig *config.ApplicationConfig) (*profilecache.Cache, error) {
+	if appConfig.ProfileCache == config.ProfileCacheOff {
+		return nil, nil
+	}
+	return profilecache.New(appConfig.RedisURL, profilecache.DefaultChannel, appConfig.ProfileCacheTTL, missingProfileTTL)
+}
+
+// SetProfileCache sets the cache public profiles are looked up in.
+func SetProfileCache(cache *profilecache.Cache) {
+	profileCache = cache
+}
+
+// publicProfiles returns the public profiles of the users with the given
+// IDs, leaving out those that are deleted or banned. Profiles are taken
+// from the cache where it has them, and what the database says about the
+// rest is cached. Cache errors are logged and the database used instead.
+func publicProfiles(ctx context.Context, dbConnection *database.DatabaseConnection, ids []uuid.UUID) (map[uuid.UUID]PublicProfile, error) {
+	profiles := make(map[uuid.UUID]PublicProfile, len(ids))
+	uncached := ids
+	if profileCache != nil {
+		entries, err := profileCache.Get(ctx, ids)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read profile cache", "error", err)
+		}
+		uncached = make([]uuid.UUID, 0, len(ids))
+		for _, id := range ids {
+			entry, ok := entries[id]
+			if !ok {
+				uncached = append(uncached, id)
+				continue
+			}
+			if entry.Missing {
+				continue
+			}
+			var profile PublicProfile
+			if err := json.Unmarshal(entry.Profile, &profile); err != nil {
+				uncached = append(uncached, id)
+				continue
+			}
+			profiles[id] = profile
+		}
+	}
+	if len(uncached) == 0 {
+		return profiles, nil
+	}
+
+	var users []models.User
+	if err := dbConnection.DB.WithContext(ctx).Where("id IN ? AND is_banned = ?", uncached, false).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	loaded := make(map[uuid.UUID][]byte, len(users))
+	for i := range users {
+		profile := NewPublicProfile(&users[i])
+		profiles[profile.ID] = profile
+		if profileCache != nil {
+			if encoded, err := json.Marshal(profile); err == nil {
+				loaded[profile.ID] = encoded
+			}
+		}
+	}
+	if profileCache != nil {
+		var missing []uuid.UUID
+		for _, id := range uncached {
+			if _, ok := profiles[id]; !ok {
+				missing = append(missing, id)
+			}
+		}
+		if err := profileCache.Set(ctx, loaded, missing); err != nil {
+			slog.WarnContext(ctx, "Failed to fill profile cache", "error", err)
+		}
+	}
+	return profiles, nil
+}
+
+// invalidateProfile drops a user's cached public profile after it
+// changed, or they were banned, deleted or verified.
+func invalidateProfile(ctx context.Context, userID uuid.UUID) {
+	if profileCache == nil {
+		return
+	}
+	if err := profileCache.Invalidate(ctx, userID); err != nil {
+		slog.WarnContext(ctx, "Failed to invalidate cached profile", "user_id", userID, "error", err)
+	}
+}
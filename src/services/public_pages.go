@@ -0,0 +1,236 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/deeplink"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// publicPageTTL is how long a public page is cached, here and by
+	// browsers and CDNs. Turning a page private takes up to this long to
+	// be seen everywhere.
+	publicPageTTL = 5 * time.Minute
+
+	// maxCachedPublicPages bounds the pages held in memory, found or not,
+	// so crawling made-up names cannot grow the cache without limit.
+	maxCachedPublicPages = 10_000
+)
+
+// PublicProfilePage is what anyone may see of a user who made their
+// profile public: no ID, contact details or presence.
+type PublicProfilePage struct {
+	Username    string   `json:"username"`
+	DisplayName string   `json:"display_name"`
+	AvatarURL   *string  `json:"avatar_url"`
+	Bio         string   `json:"bio"`
+	IsVerified  bool     `json:"is_verified"`
+	Badges      []string `json:"badges"`
+	URL         string   `json:"url"`
+}
+
+// PublicRoomPreview is what anyone may see of a public channel, to decide
+// whether to join it.
+type PublicRoomPreview struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	MemberCount int64     `json:"member_count"`
+	URL         string    `json:"url"`
+}
+
+// PublicPages serves profiles and room previews without signing in, for
+// link previews and landing pages. Rendered pages, and the names that
+// have none, are cached for publicPageTTL.
+type PublicPages struct {
+	db    *database.DatabaseConnection
+	links *deeplink.Base
+
+	mu    sync.Mutex
+	pages map[string]publicPage
+}
+
+type publicPage struct {
+	body        []byte
+	contentType string
+	etag        string
+	found       bool
+	expiresAt   time.Time
+}
+
+// newPublicPage tags a rendered page for caching.
+func newPublicPage(body []byte, contentType string, found bool) publicPage {
+	sum := sha256.Sum256(body)
+	return publicPage{
+		body:        body,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(sum[:8]) + `"`,
+		found:       found,
+		expiresAt:   time.Now().Add(publicPageTTL),
+	}
+}
+
+func NewPublicPages(dbConnection *database.DatabaseConnection, links *deeplink.Base) *PublicPages {
+	return &PublicPages{db: dbConnection, links: links, pages: make(map[string]publicPage)}
+}
+
+// GetPublicProfilePage returns the public profile of the user named by
+// the :username parameter, with the badges they show. Users who have not
+// made their profile public, and banned or deleted ones, are reported as
+// not found.
+func GetPublicProfilePage(c *gin.Context, pages *PublicPages) {
+	username := c.Param("username")
+	pages.serveJSON(c, "user:"+username, "user not found", func(ctx context.Context) (gin.H, error) {
+		var user models.User
+		err := pages.db.DB.WithContext(ctx).
+			Where("username = ? AND public_page = ? AND is_banned = ?", username, true, false).
+			First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		badges, err := shownBadges(ctx, pages.db, user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"profile": PublicProfilePage{
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+			Bio:         user.Bio,
+			IsVerified:  user.IsVerified,
+			Badges:      badges,
+			URL:         pages.links.Profile(user.Username),
+		}}, nil
+	})
+}
+
+// GetPublicRoomPreview returns the preview of the public channel named by
+// the :id parameter. Private channels, those of workspaces other than the
+// default one and other rooms are reported as not found.
+func GetPublicRoomPreview(c *gin.Context, pages *PublicPages) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "invalid room id"})
+		return
+	}
+	pages.serveJSON(c, "room:"+id.String(), "room not found", func(ctx context.Context) (gin.H, error) {
+		channels := repositories.NewChannelRepository(pages.db.DB)
+		channel, err := channels.Get(ctx, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if channel.IsPrivate || channel.Conversation.WorkspaceID != models.DefaultWorkspaceID {
+			return nil, nil
+		}
+		members, err := channels.MemberCount(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"room": PublicRoomPreview{
+			ID:          channel.ConversationID,
+			Name:        channel.Name,
+			Description: channel.Description,
+			MemberCount: members,
+			URL:         pages.links.Room(channel.ConversationID),
+		}}, nil
+	})
+}
+
+// serveJSON serves the page load returns in the API's v1 shape. load
+// returns nil when there is no such page, which answers 404 with missing.
+func (p *PublicPages) serveJSON(c *gin.Context, key, missing string, load func(ctx context.Context) (gin.H, error)) {
+	p.serve(c, key, func(ctx context.Context) (publicPage, error) {
+		data, err := load(ctx)
+		if err != nil {
+			return publicPage{}, err
+		}
+		body := gin.H{"status": "error", "error": missing}
+		if data != nil {
+			body = gin.H{"status": "success"}
+			for key, value := range data {
+				body[key] = value
+			}
+		}
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return publicPage{}, err
+		}
+		return newPublicPage(raw, "application/json; charset=utf-8", data != nil), nil
+	})
+}
+
+// serve answers with the page cached under key, rendering it first when
+// it is missing or stale.
+func (p *PublicPages) serve(c *gin.Context, key string, render func(ctx context.Context) (publicPage, error)) {
+	page, ok := p.cached(key)
+	if !ok {
+		var err error
+		page, err = render(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load public page", "page", key, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "failed to load page"})
+			return
+		}
+		p.store(key, page)
+	}
+
+	c.Header("Cache-Control", "public, max-age="+strconv.Itoa(int(publicPageTTL.Seconds())))
+	c.Header("ETag", page.etag)
+	if !page.found {
+		c.Data(http.StatusNotFound, page.contentType, page.body)
+		return
+	}
+	if ifNoneMatch(c.GetHeader("If-None-Match"), page.etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, page.contentType, page.body)
+}
+
+func (p *PublicPages) cached(key string) (publicPage, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	page, ok := p.pages[key]
+	if !ok || time.Now().After(page.expiresAt) {
+		return publicPage{}, false
+	}
+	return page, true
+}
+
+func (p *PublicPages) store(key string, page publicPage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pages) >= maxCachedPublicPages {
+		now := time.Now()
+		for k, cached := range p.pages {
+			if now.After(cached.expiresAt) {
+				delete(p.pages, k)
+			}
+		}
+		if len(p.pages) >= maxCachedPublicPages {
+			p.pages = make(map[string]publicPage)
+		}
+	}
+	p.pages[key] = page
+}
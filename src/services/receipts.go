@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	eventTypingStart   = "typing.start"
+	eventTypingStop    = "typing.stop"
+	eventReceiptUpdate = "receipt.update"
+)
+
+type markReadRequest struct {
+	MessageID uuid.UUID `json:"message_id" binding:"required"`
+	Status    string    `json:"status" binding:"omitempty,oneof=delivered read"`
+}
+
+// MarkRead records that the current user has received, or by default read,
+// a conversation up to and including message_id, and tells the other
+// members.
+func MarkRead(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		var req markReadRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.Status == "" {
+			req.Status = models.ReceiptRead
+		}
+
+		userID := CurrentUserID(c)
+		receipt, change, err := repositories.NewReceiptRepository(dbConnection.DB).
+			Mark(c.Request.Context(), conversation.ID, userID, req.MessageID, req.Status)
+		if err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, badRequest("message not found in this conversation")
+			}
+			slog.ErrorContext(c.Request.Context(), "Failed to mark conversation", "conversation_id", conversation.ID, "status", req.Status, "error", err)
+			return nil, internalError("failed to update receipt")
+		}
+
+		if change.Delivered {
+			recordDelivery(hub, userID, change.SentAt)
+		}
+		if change.Changed() {
+			notifyMembers(hub, conversation, userID, eventReceiptUpdate, receipt)
+		}
+		return &Response{Data: receipt, Legacy: gin.H{"receipt": receipt}}, nil
+	}
+}
+
+// ListReceipts returns every member's delivered and read markers, so
+// clients can show read state when opening a conversation.
+func ListReceipts(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		receipts, err := repositories.NewReceiptRepository(dbConnection.DB).List(c.Request.Context(), conversation.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list receipts", "error", err)
+			return nil, internalError("failed to list receipts")
+		}
+		return &Response{Data: receipts, Legacy: gin.H{"receipts": receipts}}, nil
+	}
+}
+
+type typingPayload struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+// relayTyping forwards typing.start and typing.stop to the other members
+// of the conversation. Nothing is stored; clients should treat a start they
+// never see stopped as expired after a few seconds.
+func relayTyping(dbConnection *database.DatabaseConnection, hub *realtime.Hub) realtime.HandlerFunc {
+	return func(ctx context.Context, client *realtime.Client, event realtime.Event) {
+		var payload typingPayload
+		if err := json.Unmarshal(event.Data, &payload); err != nil || payload.ConversationID == uuid.Nil {
+			replyError(client, event, "conversation_id is required")
+			return
+		}
+
+		conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, payload.ConversationID)
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to load conversation", "conversation_id", payload.ConversationID, "error", err)
+		}
+		if conversation == nil || !hasMember(conversation, client.UserID) {
+			replyError(client, event, "conversation not found")
+			return
+		}
+
+		notifyMembers(hub, conversation, client.UserID, event.Type, gin.H{
+			"conversation_id": conversation.ID,
+			"user_id":         client.UserID,
+		})
+	}
+}
+
+// notifyMembers sends an event to every member of the conversation except
+// the user who caused it.
+func notifyMembers(hub *realtime.Hub, conversation *models.Conversation, actorID uuid.UUID, eventType string, data any) {
+	event, err := realtime.NewEvent(eventType, data)
+	if err != nil {
+		slog.Error("Failed to encode event", "event_type", eventType, "error", err)
+		return
+	}
+
+	recipients := make([]uuid.UUID, 0, len(conversation.Members))
+	for _, member := range conversation.Members {
+		if member.UserID != actorID {
+			recipients = append(recipients, member.UserID)
+		}
+	}
+	hub.SendToUsers(recipients, event)
+}
+
+func hasMember(conversation *models.Conversation, userID uuid.UUID) bool {
+	for _, member := range conversation.Members {
+		if member.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
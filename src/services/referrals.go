@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// referralCodeLength is how many characters referral codes have.
+const referralCodeLength = 8
+
+// referralMilestones are the numbers of qualified referrals that earn a
+// referrer a reward, in order.
+var referralMilestones = []int{1, 5, 10, 25, 50}
+
+var ErrInvalidReferral = errors.New("referral code is invalid")
+
+// ReferralView is the current user's referral code and how their
+// referrals are doing. NextMilestone is null once every milestone is
+// reached.
+type ReferralView struct {
+	Code string `json:"code"`
+	repositories.ReferralStats
+	NextMilestone *int `json:"next_milestone"`
+}
+
+func generateReferralCode() (string, error) {
+	buf := make([]byte, referralCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate referral code: %w", err)
+	}
+	for i, b := range buf {
+		buf[i] = inviteAlphabet[int(b)%len(inviteAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// GetReferrals returns the current user's referral code, made the first
+// time it is asked for, with the signups it brought in, how many of them
+// qualified by verifying their email, and the reward milestones reached.
+func GetReferrals(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		referrals := repositories.NewReferralRepository(dbConnection.DB)
+		code, err := referrals.Code(ctx, userID, generateReferralCode)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load referral code", "error", err)
+			return nil, internalError("failed to load referrals")
+		}
+		stats, err := referrals.Stats(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load referral stats", "error", err)
+			return nil, internalError("failed to load referrals")
+		}
+
+		view := ReferralView{Code: code.Code, ReferralStats: *stats}
+		for _, milestone := range referralMilestones {
+			if int64(milestone) > stats.Qualified {
+				view.NextMilestone = &milestone
+				break
+			}
+		}
+		return &Response{Data: view, Legacy: gin.H{"referrals": view}}, nil
+	}
+}
+
+// attributeReferral credits a new account's signup to the owner of the
+// referral code it registered with, inside the registration's tx. It
+// fails with ErrInvalidReferral when nobody has the code.
+func attributeReferral(ctx context.Context, tx *gorm.DB, code string, userID uuid.UUID) error {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil
+	}
+	_, err := repositories.NewReferralRepository(tx).Attribute(ctx, code, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ErrInvalidReferral
+	}
+	return err
+}
+
+// qualifyReferral qualifies the referral that brought a user in, now that
+// they verified their email, inside the verification's tx.
+func qualifyReferral(ctx context.Context, tx *gorm.DB, userID uuid.UUID) error {
+	rewards, err := repositories.NewReferralRepository(tx).Qualify(ctx, userID, time.Now(), referralMilestones)
+	if err != nil {
+		return err
+	}
+	for _, reward := range rewards {
+		slog.InfoContext(ctx, "Referral milestone reached", "referrer_id", reward.ReferrerID, "milestone", reward.Milestone)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/push"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobDeliverReminders is the scheduled job delivering the reminders
+	// that fell due.
+	JobDeliverReminders = "deliver_reminders"
+
+	// ReminderScanInterval is how often due reminders are looked for, and
+	// so about how late one may be delivered.
+	ReminderScanInterval = time.Minute
+
+	// reminderBatch bounds the reminders delivered by one run of the job.
+	reminderBatch = 500
+
+	// eventReminderDue delivers a reminder to its user's open sockets.
+	eventReminderDue = "reminder.due"
+)
+
+// ListReminders returns the current user's pending reminders, soonest
+// first.
+func ListReminders(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		reminders, err := repositories.NewReminderRepository(dbConnection.DB).Pending(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list reminders", "error", err)
+			return nil, internalError("failed to list reminders")
+		}
+		return &Response{Data: reminders, Legacy: gin.H{"reminders": reminders}}, nil
+	}
+}
+
+// CancelReminder cancels the current user's pending reminder named by the
+// :id parameter.
+func CancelReminder(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid reminder id")
+		}
+		err = repositories.NewReminderRepository(dbConnection.DB).Cancel(c.Request.Context(), CurrentUserID(c), id, time.Now())
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("reminder not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to cancel reminder", "id", id, "error", err)
+			return nil, internalError("failed to cancel reminder")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// DeliverReminders is the scheduled job delivering the reminders that
+// fell due to their users' open sockets and, as a push, to their devices.
+func DeliverReminders(dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		due, err := repositories.NewReminderRepository(dbConnection.DB).ClaimDue(ctx, time.Now(), reminderBatch)
+		if err != nil {
+			return err
+		}
+		for i := range due {
+			deliverReminder(ctx, hub, notifier, &due[i])
+		}
+		if len(due) > 0 {
+			slog.InfoContext(ctx, "Delivered reminders", "count", len(due))
+		}
+		return nil
+	}
+}
+
+func deliverReminder(ctx context.Context, hub *realtime.Hub, notifier *Notifier, reminder *models.Reminder) {
+	if event, err := realtime.NewEvent(eventReminderDue, reminder); err == nil {
+		hub.SendToUser(reminder.UserID, event)
+	}
+	notification := push.Notification{
+		Title: "Reminder",
+		Body:  reminder.Text,
+		Data: map[string]string{
+			"type":        eventReminderDue,
+			"reminder_id": reminder.ID.String(),
+		},
+	}
+	if reminder.ConversationID != nil {
+		notification.ThreadID = reminder.ConversationID.String()
+		notification.Data["conversation_id"] = reminder.ConversationID.String()
+	}
+	if err := notifier.Alert(ctx, reminder.UserID, notification); err != nil {
+		slog.ErrorContext(ctx, "Failed to push reminder", "reminder_id", reminder.ID, "error", err)
+	}
+}
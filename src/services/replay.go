@@ -0,0 +1,89 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/replay"
+	"github.com/gin-gonic/gin"
+)
+
+// maxTracedBody is the largest request or response body recorded. Larger
+// ones, mostly uploads, are replayed without a body.
+const maxTracedBody = 1 << 20
+
+// untracedPrefixes are routes left out of traces. Sign-in requests carry
+// credentials and the replayer signs its users in itself, WebSocket
+// events are recorded one by one, and admin and operational routes are
+// not user traffic.
+var untracedPrefixes = []string{
+	"/api/v1/auth/",
+	"/api/v1/ws",
+	"/api/v1/admin",
+	"/api/v1/health",
+	"/metrics",
+}
+
+// traceWriter keeps a copy of the response body for the recorder.
+type traceWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *traceWriter) Write(data []byte) (int, error) {
+	if w.body.Len()+len(data) <= maxTracedBody {
+		w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *traceWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+// TraceMiddleware records an anonymized trace of the requests of sampled
+// users, for the replay command to re-drive against a staging instance.
+func TraceMiddleware(recorder *replay.Recorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, prefix := range untracedPrefixes {
+			if strings.HasPrefix(c.Request.URL.Path, prefix) {
+				c.Next()
+				return
+			}
+		}
+
+		var body []byte
+		if c.ContentType() == gin.MIMEJSON && c.Request.ContentLength <= maxTracedBody {
+			body, _ = io.ReadAll(io.LimitReader(c.Request.Body, maxTracedBody))
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		writer := &traceWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		started := time.Now()
+		c.Next()
+
+		userID := CurrentUserID(c)
+		if !recorder.Sampled(userID) {
+			return
+		}
+		var response []byte
+		if strings.HasPrefix(writer.Header().Get("Content-Type"), gin.MIMEJSON) {
+			response = writer.body.Bytes()
+		}
+		recorder.RecordHTTP(started, userID, c.Request.Method, c.Request.URL.Path, c.Request.URL.Query(),
+			body, c.Writer.Status(), time.Since(started), response)
+	}
+}
+
+// TraceEvents records the inbound WebSocket events of sampled users.
+func TraceEvents(hub *realtime.Hub, recorder *replay.Recorder) {
+	hub.OnEvent(func(client *realtime.Client, event realtime.Event) {
+		if recorder.Sampled(client.UserID) {
+			recorder.RecordEvent(time.Now(), client.UserID, event.Type, event.Data)
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultReportPage = 50
+	maxReportPage     = 100
+)
+
+type reportRequest struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+// ReportMessage reports the message named by the :id parameter, from a
+// conversation the current user belongs to, to the moderators.
+func ReportMessage(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		message, _, apiErr := memberMessage(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req reportRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if message.SenderID == CurrentUserID(c) {
+			return nil, badRequest("you cannot report your own message")
+		}
+		return fileReport(c, dbConnection, &models.Report{
+			ReporterID: CurrentUserID(c),
+			UserID:     message.SenderID,
+			MessageID:  &message.ID,
+			Reason:     req.Reason,
+		})
+	}
+}
+
+// ReportUser reports the user named by the :id parameter, from the
+// current workspace, to the moderators.
+func ReportUser(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		var req reportRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if userID == CurrentUserID(c) {
+			return nil, badRequest("you cannot report yourself")
+		}
+		ctx := c.Request.Context()
+		err = checkRecipients(ctx, dbConnection, CurrentWorkspaceID(c), []uuid.UUID{userID})
+		if errors.Is(err, errRecipientNotFound) {
+			return nil, notFound("user not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check reported user", "user_id", userID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+		return fileReport(c, dbConnection, &models.Report{
+			ReporterID: CurrentUserID(c),
+			UserID:     userID,
+			Reason:     req.Reason,
+		})
+	}
+}
+
+func fileReport(c *gin.Context, dbConnection *database.DatabaseConnection, report *models.Report) (*Response, *APIError) {
+	report.Status = models.ReportOpen
+	if err := repositories.NewReportRepository(dbConnection.DB).Create(c.Request.Context(), report); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to file report", "error", err)
+		return nil, internalError("failed to file report")
+	}
+	return &Response{Status: http.StatusCreated, Data: report, Legacy: gin.H{"report": report}}, nil
+}
+
+// ListReports returns a page of reports, newest first, with ?status= or
+// of any status.
+func ListReports(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		status := c.Query("status")
+		switch status {
+		case "", models.ReportOpen, models.ReportActioned, models.ReportDismissed:
+		default:
+			return nil, badRequest("status must be open, actioned or dismissed")
+		}
+		before, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultReportPage, maxReportPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		reports, err := repositories.NewReportRepository(dbConnection.DB).List(c.Request.Context(), status, before, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list reports", "error", err)
+			return nil, internalError("failed to list reports")
+		}
+
+		page := &Page{}
+		if len(reports) > limit {
+			reports = reports[:limit]
+			page.HasMore = true
+			last := reports[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		legacy := gin.H{"reports": reports}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: reports, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// DismissReport closes the open report named by the :id parameter without
+// taking action.
+func DismissReport(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid report id")
+		}
+		err = repositories.NewReportRepository(dbConnection.DB).Dismiss(c.Request.Context(), id, CurrentUserID(c))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("no such open report")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to dismiss report", "report_id", id, "error", err)
+			return nil, internalError("failed to dismiss report")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	maxReputationPage = 100
+
+	// maxPostingReputation bounds the reputation a channel may ask for
+	// before members post links and media.
+	maxPostingReputation = 10000
+)
+
+type setPostingReputationRequest struct {
+	Reputation *int `json:"reputation" binding:"required,min=0,max=10000"`
+}
+
+// ListChannelReputation ranks the current channel's top members by their
+// reputation there, for its owners and admins: a point per message they
+// posted, less repositories.ReportPenalty per report against one that was
+// not dismissed.
+func ListChannelReputation(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		limit, err := pagination.Limit(c.Query("limit"), maxReputationPage, maxReputationPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+		channelID := CurrentChannel(c).ConversationID
+		rankings, err := repositories.NewReputationRepository(dbConnection.DB).Rankings(c.Request.Context(), channelID, limit)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to rank channel members", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to rank channel members")
+		}
+		return &Response{Data: rankings, Legacy: gin.H{"members": rankings}}, nil
+	}
+}
+
+// SetChannelPostingReputation sets the reputation members need to post
+// links and media in the channel, so that they unlock it by contributing.
+// Zero lets everyone.
+func SetChannelPostingReputation(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req setPostingReputationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  fmt.Sprintf("reputation must be 0-%d", maxPostingReputation),
+		})
+		return
+	}
+
+	channel := CurrentChannel(c)
+	if err := repositories.NewChannelRepository(dbConnection.DB).SetPostingReputation(c.Request.Context(), channel.ConversationID, *req.Reputation); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to update channel posting reputation", "channel_id", channel.ConversationID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to update channel posting reputation",
+		})
+		return
+	}
+	channel.PostingReputation = *req.Reputation
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"channel": repositories.ChannelWithRole{Channel: *channel, Role: CurrentChannelMember(c).Role},
+	})
+}
+
+// checkRoomReputation returns an error wrapping trust.ErrInsufficientTrust
+// when the conversation is a channel asking for more reputation than the
+// sender has there to post links and media. Its owners and admins, and
+// bots, are not held to it.
+func checkRoomReputation(ctx context.Context, dbConnection *database.DatabaseConnection, senderID, conversationID uuid.UUID) error {
+	var required []int
+	err := dbConnection.DB.WithContext(ctx).Model(&models.Channel{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("posting_reputation", &required).Error
+	if err != nil {
+		return fmt.Errorf("failed to load channel: %w", err)
+	}
+	if len(required) == 0 || required[0] == 0 {
+		return nil
+	}
+
+	var sender struct {
+		Role        string
+		AccountType string
+	}
+	err = dbConnection.DB.WithContext(ctx).Model(&models.ConversationMember{}).
+		Select("conversation_members.role, users.account_type").
+		Joins("JOIN users ON users.id = conversation_members.user_id").
+		Where("conversation_members.conversation_id = ? AND conversation_members.user_id = ?", conversationID, senderID).
+		Scan(&sender).Error
+	if err != nil {
+		return fmt.Errorf("failed to load channel member: %w", err)
+	}
+	if sender.Role == models.MemberRoleOwner || sender.Role == models.MemberRoleAdmin || sender.AccountType == models.AccountTypeBot {
+		return nil
+	}
+	score, err := repositories.NewReputationRepository(dbConnection.DB).Score(ctx, conversationID, senderID)
+	if err != nil {
+		return err
+	}
+	if score < int64(required[0]) {
+		return fmt.Errorf("%w: links and media in this channel need a reputation of %d, and yours is %d", trust.ErrInsufficientTrust, required[0], score)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/google/uuid"
+)
+
+const (
+	// resendWindow is how long the client_id of a message is remembered,
+	// long enough for a client to reconnect and resend what was never
+	// acknowledged. Later resends are still caught by the database.
+	resendWindow = 10 * time.Minute
+
+	// maxRecentClientIDs bounds the memory used by recentSends.
+	maxRecentClientIDs = 100_000
+)
+
+type clientMessageKey struct {
+	senderID uuid.UUID
+	clientID string
+}
+
+// clientIDWindow remembers the client_ids messages were recently sent
+// with, so a resend is answered with the stored message from a single
+// indexed read, without locking the conversation or counting against the
+// sender's rate limit. It is per instance, so a client reconnecting to
+// another instance is deduplicated by the database alone.
+type clientIDWindow struct {
+	mu   sync.Mutex
+	sent map[clientMessageKey]time.Time
+}
+
+var recentSends = &clientIDWindow{sent: make(map[clientMessageKey]time.Time)}
+
+// seen reports whether senderID sent a message with clientID within the
+// window.
+func (w *clientIDWindow) seen(senderID uuid.UUID, clientID string) bool {
+	clientID = strings.TrimSpace(clientID)
+	if clientID == "" {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	at, ok := w.sent[clientMessageKey{senderID, clientID}]
+	return ok && time.Since(at) < resendWindow
+}
+
+// remember records that message was sent, if it carries a client_id.
+func (w *clientIDWindow) remember(message *models.Message) {
+	if message.ClientID == nil {
+		return
+	}
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.sent) >= maxRecentClientIDs {
+		for key, at := range w.sent {
+			if now.Sub(at) >= resendWindow {
+				delete(w.sent, key)
+			}
+		}
+		if len(w.sent) >= maxRecentClientIDs {
+			w.sent = make(map[clientMessageKey]time.Time)
+		}
+	}
+	w.sent[clientMessageKey{message.SenderID, *message.ClientID}] = now
+}
+
+// resentMessage returns the message senderID already sent with clientID
+// if they did so within the window, or nil.
+func resentMessage(ctx context.Context, dbConnection *database.DatabaseConnection, senderID uuid.UUID, clientID string) (*models.Message, error) {
+	if !recentSends.seen(senderID, clientID) {
+		return nil, nil
+	}
+	message, err := repositories.NewMessageRepository(dbConnection.DB).BySenderClientID(ctx, senderID, strings.TrimSpace(clientID))
+	if errors.Is(err, repositories.ErrNotFound) {
+		// Erased with the sender's account.
+		return nil, nil
+	}
+	return message, err
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// resumeSubprotocol is followed in the subprotocol list by a resume
+	// token, to reopen a dropped connection.
+	resumeSubprotocol = "resume"
+
+	// resumeTokenTTL is how long a resume token lets a client back in.
+	// Clients ask for a fresh one before it runs out.
+	resumeTokenTTL = 2 * time.Minute
+
+	// eventResumeToken carries a fresh resume token, sent on connecting
+	// and in answer to an event of the same type.
+	eventResumeToken = "session.resume_token"
+)
+
+type resumeTokenData struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RegisterResumeTokens hands every connection a resume token when it
+// opens and whenever it asks for one. A client whose socket drops, as
+// when a phone switches networks, reconnects with the token instead of
+// its access token, which spares the server the user and session lookups
+// when many clients reconnect at once.
+func RegisterResumeTokens(hub *realtime.Hub, tokens *auth.TokenManager) {
+	send := func(client *realtime.Client, requestID string) {
+		token, expiresAt, err := tokens.IssueResumeToken(client.UserID, client.SessionID, resumeTokenTTL)
+		if err != nil {
+			slog.Error("Failed to issue resume token", "user_id", client.UserID, "error", err)
+			return
+		}
+		event, err := realtime.NewEvent(eventResumeToken, resumeTokenData{Token: token, ExpiresAt: expiresAt})
+		if err != nil {
+			slog.Error("Failed to encode event", "event_type", eventResumeToken, "error", err)
+			return
+		}
+		event.RequestID = requestID
+		client.Send(event)
+	}
+
+	hub.OnConnect(func(client *realtime.Client) {
+		// Long-polling clients have no socket to resume.
+		if !client.LongPolling() {
+			send(client, "")
+		}
+	})
+	hub.Handle(eventResumeToken, func(_ context.Context, client *realtime.Client, event realtime.Event) {
+		send(client, event.RequestID)
+	})
+}
+
+// resumeConnection checks the resume token offered in the handshake and
+// returns the user and session it resumes. Users signed out since it was
+// issued are refused.
+func resumeConnection(r *http.Request, tokens *auth.TokenManager, hub *realtime.Hub) (userID, sessionID uuid.UUID, ok bool) {
+	token := subprotocolValue(r, resumeSubprotocol)
+	if token == "" {
+		return uuid.Nil, uuid.Nil, false
+	}
+	claims, err := tokens.ParseResumeToken(token)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err = claims.UserID()
+	if err != nil || hub.SignedOutSince(userID, claims.IssuedAt.Time) {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, claims.Session(), true
+}
+
+// subprotocolValue returns the subprotocol following name in the list the
+// client offered.
+func subprotocolValue(r *http.Request, name string) string {
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == name && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
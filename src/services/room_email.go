@@ -0,0 +1,492 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/markdown"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobEmailRelay is the job emailing a channel's new message to one of
+	// its members who prefers mail.
+	JobEmailRelay = "email_relay"
+
+	// maxInboundEmailBytes bounds an inbound email as posted by the mail
+	// provider, attachments included.
+	maxInboundEmailBytes = 25 << 20
+
+	// roomEmailPrefix starts the local part of every channel's address.
+	roomEmailPrefix = "room-"
+
+	// emailClientIDPrefix marks the client_id of messages posted from
+	// email, which keeps a message the provider posts twice from being
+	// posted twice.
+	emailClientIDPrefix = "email:"
+)
+
+var roomEmailEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type setRoomEmailRelayRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+type emailRelayJob struct {
+	MessageID uuid.UUID `json:"message_id"`
+	UserID    uuid.UUID `json:"user_id"`
+}
+
+// RoomEmailView is a channel's email address, and whether the current
+// user has the channel's messages emailed to them.
+type RoomEmailView struct {
+	models.RoomEmailAddress
+	Address string `json:"address"`
+	Relay   bool   `json:"relay"`
+}
+
+// RoomEmail gives channels email addresses. Members post into a channel
+// by mailing its address, through the mail provider's inbound webhook,
+// and those who prefer mail have the channel's messages emailed to them,
+// with the address to reply to.
+type RoomEmail struct {
+	db     *database.DatabaseConnection
+	store  storage.Storage
+	mail   mailer.Mailer
+	domain string
+	token  string
+}
+
+func NewRoomEmail(dbConnection *database.DatabaseConnection, store storage.Storage, mail mailer.Mailer, domain, token string) *RoomEmail {
+	return &RoomEmail{db: dbConnection, store: store, mail: mail, domain: strings.ToLower(domain), token: token}
+}
+
+// Enabled reports whether channel email is configured, with a domain and
+// a token for the provider's webhook.
+func (e *RoomEmail) Enabled() bool {
+	return e.domain != "" && e.token != ""
+}
+
+func (e *RoomEmail) address(localPart string) string {
+	return localPart + "@" + e.domain
+}
+
+// GetRoomEmail returns the current channel's email address.
+func GetRoomEmail(roomEmail *RoomEmail) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		channelID := CurrentChannel(c).ConversationID
+		emails := repositories.NewRoomEmailRepository(roomEmail.db.DB)
+		address, err := emails.Address(ctx, channelID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("the channel has no email address")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load room email address", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to load email address")
+		}
+		relay, err := emails.Relays(ctx, channelID, CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load email relay", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to load email address")
+		}
+		view := RoomEmailView{RoomEmailAddress: *address, Address: roomEmail.address(address.LocalPart), Relay: relay}
+		return &Response{Data: view, Legacy: gin.H{"email": view}}, nil
+	}
+}
+
+// CreateRoomEmail gives the current channel a new email address, which
+// replaces the one it had, if any.
+func CreateRoomEmail(roomEmail *RoomEmail) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		random := make([]byte, 15)
+		if _, err := rand.Read(random); err != nil {
+			slog.ErrorContext(ctx, "Failed to generate room email address", "error", err)
+			return nil, internalError("failed to create email address")
+		}
+		userID := CurrentUserID(c)
+		address := models.RoomEmailAddress{
+			ConversationID: CurrentChannel(c).ConversationID,
+			LocalPart:      roomEmailPrefix + strings.ToLower(roomEmailEncoding.EncodeToString(random)),
+			CreatedByID:    &userID,
+		}
+		emails := repositories.NewRoomEmailRepository(roomEmail.db.DB)
+		if err := emails.SetAddress(ctx, &address); err != nil {
+			slog.ErrorContext(ctx, "Failed to create room email address", "channel_id", address.ConversationID, "error", err)
+			return nil, internalError("failed to create email address")
+		}
+		relay, err := emails.Relays(ctx, address.ConversationID, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load email relay", "channel_id", address.ConversationID, "error", err)
+			return nil, internalError("failed to create email address")
+		}
+		view := RoomEmailView{RoomEmailAddress: address, Address: roomEmail.address(address.LocalPart), Relay: relay}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"email": view}}, nil
+	}
+}
+
+// DeleteRoomEmail removes the current channel's email address. Mail to it
+// is refused from then on and nothing more is relayed.
+func DeleteRoomEmail(roomEmail *RoomEmail) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		channelID := CurrentChannel(c).ConversationID
+		err := repositories.NewRoomEmailRepository(roomEmail.db.DB).DeleteAddress(c.Request.Context(), channelID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("the channel has no email address")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete room email address", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to delete email address")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// SetRoomEmailRelay turns emailing the current channel's new messages to
+// the current user on or off. Mail only goes to verified addresses.
+func SetRoomEmailRelay(roomEmail *RoomEmail) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req setRoomEmailRelayRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		channelID := CurrentChannel(c).ConversationID
+		err := repositories.NewRoomEmailRepository(roomEmail.db.DB).SetRelay(ctx, channelID, CurrentUserID(c), *req.Enabled)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to set email relay", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to update email relay")
+		}
+		return &Response{Data: gin.H{"relay": *req.Enabled}, Legacy: gin.H{"relay": *req.Enabled}}, nil
+	}
+}
+
+// rejectEmail refuses an inbound email for good. Mail providers retry
+// other failures, but not a 406.
+func rejectEmail(message string) *APIError {
+	return &APIError{Status: http.StatusNotAcceptable, Code: "rejected", Message: message}
+}
+
+// ReceiveRoomEmail posts an inbound email into the channel it was sent
+// to, as the member whose verified address sent it, with its files as
+// attachments. The mail provider posts the email to /api/v1/email/<token>
+// as a form, with the fields of Mailgun's routes: recipient, from,
+// subject, body-plain, stripped-text, Message-Id, message-headers and the
+// files. Mail to no channel, from someone who is not a member, or sent
+// automatically, such as out-of-office replies to relayed messages, is
+// refused.
+func ReceiveRoomEmail(c *gin.Context, roomEmail *RoomEmail, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) {
+	if subtle.ConstantTimeCompare([]byte(c.Param("token")), []byte(roomEmail.token)) != 1 {
+		abortWithError(c, notFound("not found"))
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxInboundEmailBytes)
+	if err := c.Request.ParseMultipartForm(32 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		abortWithError(c, badRequest("invalid email"))
+		return
+	}
+	if autoSubmitted(c.PostForm("message-headers")) {
+		abortWithError(c, rejectEmail("automatic replies are not posted"))
+		return
+	}
+
+	ctx := c.Request.Context()
+	address, apiErr := roomEmail.recipient(ctx, c.PostForm("recipient"))
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	sender, apiErr := roomEmail.sender(ctx, address.ConversationID, c.PostForm("from"))
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	limits, err := userLimits(ctx, roomEmail.db, sender.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load sender limits", "user_id", sender.ID, "error", err)
+		abortWithError(c, internalError("failed to post email"))
+		return
+	}
+
+	input := messageInput{
+		Text:     emailText(c.PostForm("subject"), c.PostForm("stripped-text"), c.PostForm("body-plain"), limits.MessageLength),
+		ClientID: emailClientID(address.ConversationID, c.PostForm("Message-Id")),
+	}
+	if input.ClientID != "" {
+		// Providers retry for hours, long after resends are remembered, so
+		// a posted email is looked up before its files are stored again.
+		posted, err := repositories.NewMessageRepository(roomEmail.db.DB).BySenderClientID(ctx, sender.ID, input.ClientID)
+		if err == nil {
+			c.JSON(http.StatusOK, gin.H{"status": "success", "message": posted})
+			return
+		}
+		if !errors.Is(err, repositories.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to look up posted email", "channel_id", address.ConversationID, "error", err)
+			abortWithError(c, internalError("failed to post email"))
+			return
+		}
+	}
+	if form := c.Request.MultipartForm; form != nil {
+		fields := make([]string, 0, len(form.File))
+		for field := range form.File {
+			fields = append(fields, field)
+		}
+		slices.Sort(fields)
+		for _, field := range fields {
+			for _, header := range form.File[field] {
+				if len(input.AttachmentIDs) == limits.AttachmentsPerMessage {
+					break
+				}
+				attachment, apiErr := storeFile(ctx, roomEmail.db, roomEmail.store, sender, header)
+				if apiErr != nil {
+					// A file that cannot be attached leaves the rest of
+					// the email to post.
+					slog.WarnContext(ctx, "Skipped email attachment", "file_name", header.Filename, "error", apiErr.Message)
+					continue
+				}
+				input.AttachmentIDs = append(input.AttachmentIDs, attachment.ID)
+			}
+		}
+	}
+
+	message, _, err := postMessage(ctx, roomEmail.db, hub, notifier, suggester, index, sender.ID, address.ConversationID, input)
+	var answered *commandReply
+	switch {
+	case err == nil:
+	case errors.As(err, &answered):
+		abortWithError(c, rejectEmail("commands can only be used in the app"))
+		return
+	case errors.Is(err, content.ErrInvalidContent), errors.Is(err, errMuted), errors.Is(err, errNotModerator), errors.Is(err, trust.ErrInsufficientTrust):
+		abortWithError(c, rejectEmail(err.Error()))
+		return
+	default:
+		slog.ErrorContext(ctx, "Failed to post email", "channel_id", address.ConversationID, "error", err)
+		abortWithError(c, internalError("failed to post email"))
+		return
+	}
+	if err := repositories.NewRoomEmailRepository(roomEmail.db.DB).RecordReceived(ctx, address.ConversationID, time.Now()); err != nil {
+		slog.ErrorContext(ctx, "Failed to record received email", "channel_id", address.ConversationID, "error", err)
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": message,
+	})
+}
+
+// recipient returns the channel address among the email's recipients.
+func (e *RoomEmail) recipient(ctx context.Context, recipients string) (*models.RoomEmailAddress, *APIError) {
+	list, err := mail.ParseAddressList(recipients)
+	if err != nil {
+		return nil, rejectEmail("invalid recipient")
+	}
+	for _, recipient := range list {
+		local, domain, ok := strings.Cut(strings.ToLower(recipient.Address), "@")
+		if !ok || domain != e.domain || !strings.HasPrefix(local, roomEmailPrefix) {
+			continue
+		}
+		address, err := repositories.NewRoomEmailRepository(e.db.DB).ByLocalPart(ctx, local)
+		if errors.Is(err, repositories.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load room email address", "error", err)
+			return nil, internalError("failed to post email")
+		}
+		return address, nil
+	}
+	return nil, rejectEmail("no channel has this address")
+}
+
+// sender returns the member of the channel whose verified address the
+// email is from.
+func (e *RoomEmail) sender(ctx context.Context, conversationID uuid.UUID, from string) (*models.User, *APIError) {
+	parsed, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, rejectEmail("invalid sender")
+	}
+	var user models.User
+	err = e.db.DB.WithContext(ctx).Where("email = ? AND is_verified = ?", strings.ToLower(parsed.Address), true).First(&user).Error
+	if err != nil {
+		return nil, rejectEmail("only the channel's members can post by email")
+	}
+	if accountBlockedReason(&user) != "" {
+		return nil, rejectEmail("only the channel's members can post by email")
+	}
+	member, err := repositories.NewConversationRepository(e.db.DB).IsMember(ctx, conversationID, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check email sender membership", "channel_id", conversationID, "error", err)
+		return nil, internalError("failed to post email")
+	}
+	if !member {
+		return nil, rejectEmail("only the channel's members can post by email")
+	}
+	return &user, nil
+}
+
+// autoSubmitted reports whether the email's headers, as the provider's
+// JSON list of name and value pairs, mark it as sent automatically.
+func autoSubmitted(headers string) bool {
+	var pairs [][2]string
+	if json.Unmarshal([]byte(headers), &pairs) != nil {
+		return false
+	}
+	for _, pair := range pairs {
+		if strings.EqualFold(pair[0], "Auto-Submitted") && !strings.EqualFold(strings.TrimSpace(pair[1]), "no") {
+			return true
+		}
+	}
+	return false
+}
+
+// emailText is the text posted for an email: its body without the quoted
+// message it replies to, where the provider could tell, and its subject
+// in bold above, unless it is a reply. It is cut to the sender's message
+// length.
+func emailText(subject, stripped, plain string, maxRunes int) string {
+	body := strings.TrimSpace(stripped)
+	if body == "" {
+		body = strings.TrimSpace(plain)
+	}
+	subject = strings.Join(strings.Fields(subject), " ")
+	lower := strings.ToLower(subject)
+	if subject != "" && !strings.HasPrefix(lower, "re:") && !strings.HasPrefix(lower, "aw:") {
+		body = strings.TrimSpace("**" + markdown.Escape(subject) + "**\n\n" + body)
+	}
+	if runes := []rune(body); len(runes) > maxRunes {
+		body = string(runes[:maxRunes-1]) + "…"
+	}
+	return body
+}
+
+// emailClientID is the client_id of an email posted to a channel, which
+// fits the column however long its Message-Id. Emails without one are
+// not told apart.
+func emailClientID(conversationID uuid.UUID, messageID string) string {
+	messageID = strings.TrimSpace(messageID)
+	if messageID == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(conversationID.String() + "\n" + messageID))
+	return emailClientIDPrefix + hex.EncodeToString(sum[:24])
+}
+
+// queueEmailRelays queues emailing a new channel message to the members
+// who prefer mail.
+func queueEmailRelays(ctx context.Context, dbConnection *database.DatabaseConnection, message *models.Message) {
+	recipients, err := repositories.NewRoomEmailRepository(dbConnection.DB).RelayRecipients(ctx, message.ConversationID, message.SenderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list email relay recipients", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	jobs := repositories.NewJobRepository(dbConnection.DB)
+	for _, recipient := range recipients {
+		if _, err := jobs.Enqueue(ctx, JobEmailRelay, emailRelayJob{MessageID: message.ID, UserID: recipient.ID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue email relay", "message_id", message.ID, "user_id", recipient.ID, "error", err)
+		}
+	}
+}
+
+// RelayEmail is the job emailing a channel message to a member who
+// prefers mail, from the channel's address so their reply is posted back.
+// Nothing is sent once the message was deleted, the member stopped the
+// relay or left, or the channel lost its address.
+func RelayEmail(roomEmail *RoomEmail) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload emailRelayJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		if !roomEmail.Enabled() {
+			return nil
+		}
+		message, err := repositories.NewMessageRepository(roomEmail.db.DB).Get(ctx, payload.MessageID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		emails := repositories.NewRoomEmailRepository(roomEmail.db.DB)
+		recipients, err := emails.RelayRecipients(ctx, message.ConversationID, message.SenderID)
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(recipients, func(user models.User) bool { return user.ID == payload.UserID })
+		if i < 0 {
+			return nil
+		}
+		address, err := emails.Address(ctx, message.ConversationID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		channel, err := repositories.NewChannelRepository(roomEmail.db.DB).Get(ctx, message.ConversationID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var author models.User
+		if err := roomEmail.db.DB.WithContext(ctx).Unscoped().First(&author, "id = ?", message.SenderID).Error; err != nil {
+			return fmt.Errorf("failed to load sender: %w", err)
+		}
+		sender := author.DisplayName
+
+		return roomEmail.mail.Send(ctx, mailer.Message{
+			To:      recipients[i].Email,
+			Subject: fmt.Sprintf("[%s] %s", channel.Name, sender),
+			Body:    relayedEmailBody(channel.Name, sender, message),
+			ReplyTo: roomEmail.address(address.LocalPart),
+		})
+	}
+}
+
+// relayedEmailBody is the body of a channel message emailed to a member.
+func relayedEmailBody(channelName, sender string, message *models.Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s wrote in %s:\n\n", sender, channelName)
+	switch {
+	case message.DeletedAt.Valid:
+		b.WriteString("[deleted]")
+	case message.Type != string(content.TypeText):
+		b.WriteString("[" + message.Type + ", open AfroChat to see it]")
+	default:
+		b.WriteString(message.Text)
+	}
+	b.WriteString("\n")
+	if len(message.Attachments) > 0 {
+		b.WriteString("\nAttached, in AfroChat:\n")
+		for _, attachment := range message.Attachments {
+			fmt.Fprintf(&b, "- %s (%s)\n", attachment.FileName, strings.ToLower(attachmentLabel(attachment.Kind)))
+		}
+	}
+	b.WriteString("\n--\nReply to this email to answer in " + channelName + ". You can stop these emails in the channel's settings.\n")
+	return b.String()
+}
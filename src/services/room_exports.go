@@ -0,0 +1,708 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/secretbox"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// JobRoomExports is the scheduled job queueing the room exports that
+	// fell due; JobRoomExport builds and delivers one.
+	JobRoomExports = "room_exports"
+	JobRoomExport  = "room_export"
+
+	// RoomExportScanInterval is how often due room exports are looked for.
+	RoomExportScanInterval = 15 * time.Minute
+
+	// maxRoomExportSchedules caps the export schedules of one room.
+	maxRoomExportSchedules = 5
+
+	defaultRoomExportDays = 7
+	roomExportContentType = "application/json"
+
+	// roomExportTTL is how long an emailed snapshot can be downloaded. It
+	// is also the longest S3 allows a presigned URL to last.
+	roomExportTTL = 7 * 24 * time.Hour
+
+	// roomExportLogRetention is how long deliveries stay in the log.
+	roomExportLogRetention = 90 * 24 * time.Hour
+
+	// roomExportBatch is how many due schedules are queued per scan.
+	roomExportBatch = 500
+
+	defaultRoomExportDeliveryPage = 20
+	maxRoomExportDeliveryPage     = 100
+)
+
+// RoomExports builds scheduled snapshots of rooms and delivers them to
+// their owners' buckets, or keeps them in storage and emails a link.
+type RoomExports struct {
+	db    *database.DatabaseConnection
+	store storage.Storage
+	mail  mailer.Mailer
+
+	// secrets seals the keys of owners' buckets. Without it, exports to
+	// buckets cannot be set up.
+	secrets *secretbox.Box
+
+	// transport reaches owners' buckets, refusing private addresses as
+	// outgoing webhooks do.
+	transport http.RoundTripper
+}
+
+func NewRoomExports(dbConnection *database.DatabaseConnection, store storage.Storage, mail mailer.Mailer, secrets *secretbox.Box) *RoomExports {
+	return &RoomExports{
+		db:        dbConnection,
+		store:     store,
+		mail:      mail,
+		secrets:   secrets,
+		transport: webhook.NewClient(jobTimeout).Transport,
+	}
+}
+
+type roomExportJob struct {
+	ScheduleID uuid.UUID `json:"schedule_id"`
+}
+
+type createRoomExportRequest struct {
+	Destination  string `json:"destination" binding:"required,oneof=s3 email"`
+	IntervalDays int    `json:"interval_days" binding:"omitempty,min=1,max=30"`
+
+	// Bucket, for the s3 destination. Endpoint is a host name with an
+	// optional port, such as s3.af-south-1.amazonaws.com; it is reached
+	// over TLS.
+	S3Endpoint  string `json:"s3_endpoint" binding:"max=255"`
+	S3Region    string `json:"s3_region" binding:"max=50"`
+	S3Bucket    string `json:"s3_bucket" binding:"max=63"`
+	S3Prefix    string `json:"s3_prefix" binding:"max=200"`
+	S3AccessKey string `json:"s3_access_key" binding:"max=128"`
+	S3SecretKey string `json:"s3_secret_key" binding:"max=255"`
+}
+
+type updateRoomExportRequest struct {
+	IntervalDays *int  `json:"interval_days" binding:"omitempty,min=1,max=30"`
+	Enabled      *bool `json:"enabled"`
+}
+
+// roomSnapshotMeta is what a snapshot holds besides its messages.
+type roomSnapshotMeta struct {
+	ExportedAt   time.Time            `json:"exported_at"`
+	Conversation *models.Conversation `json:"conversation"`
+}
+
+// ListRoomExports returns the export schedules of a group or channel the
+// current user owns.
+func ListRoomExports(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomExportOwnership(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		schedules, err := repositories.NewRoomExportRepository(exports.db.DB).List(c.Request.Context(), conversation.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list room export schedules", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list export schedules")
+		}
+		return &Response{Data: schedules, Legacy: gin.H{"schedules": schedules}}, nil
+	}
+}
+
+// CreateRoomExport schedules a JSON snapshot of the room, with its members
+// and messages, every interval_days (7 unless given), to the owner's S3
+// bucket or by email to the owner's verified address. The first is made
+// within RoomExportScanInterval. Only the room's owner may.
+func CreateRoomExport(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomExportOwnership(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req createRoomExportRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.IntervalDays == 0 {
+			req.IntervalDays = defaultRoomExportDays
+		}
+
+		userID := CurrentUserID(c)
+		schedule := models.RoomExportSchedule{
+			ConversationID: conversation.ID,
+			CreatedByID:    &userID,
+			Destination:    req.Destination,
+			IntervalDays:   req.IntervalDays,
+			Enabled:        true,
+			NextRunAt:      time.Now(),
+		}
+		switch req.Destination {
+		case models.RoomExportToS3:
+			if apiErr := req.bucket(&schedule, exports.secrets); apiErr != nil {
+				return nil, apiErr
+			}
+		case models.RoomExportToEmail:
+			if !CurrentUser(c).IsVerified {
+				return nil, forbidden("verify your email address before having exports emailed to it")
+			}
+		}
+
+		ctx := c.Request.Context()
+		err := repositories.NewRoomExportRepository(exports.db.DB).Create(ctx, &schedule, maxRoomExportSchedules)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("rooms may have at most " + strconv.Itoa(maxRoomExportSchedules) + " export schedules")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create room export schedule", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to create export schedule")
+		}
+		return &Response{Status: http.StatusCreated, Data: schedule, Legacy: gin.H{"schedule": schedule}}, nil
+	}
+}
+
+// bucket checks the request's S3 settings and copies them to schedule,
+// with the secret key sealed by secrets.
+func (req *createRoomExportRequest) bucket(schedule *models.RoomExportSchedule, secrets *secretbox.Box) *APIError {
+	if secrets == nil {
+		return badRequest("exports to buckets are not enabled on this server")
+	}
+	if req.S3Endpoint == "" || req.S3Bucket == "" || req.S3AccessKey == "" || req.S3SecretKey == "" {
+		return badRequest("s3_endpoint, s3_bucket, s3_access_key and s3_secret_key are required for s3 exports")
+	}
+	host := req.S3Endpoint
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return badRequest("invalid s3_endpoint port")
+		}
+		host = h
+	}
+	if host == "" || strings.ContainsAny(host, "/:@?# ") {
+		return badRequest("s3_endpoint must be a host name, without a scheme or path")
+	}
+	if ip := net.ParseIP(host); ip != nil && (!ip.IsGlobalUnicast() || ip.IsPrivate()) {
+		return badRequest("s3_endpoint must be a public address")
+	}
+	prefix := strings.Trim(req.S3Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	schedule.S3Endpoint = req.S3Endpoint
+	schedule.S3Region = req.S3Region
+	schedule.S3Bucket = req.S3Bucket
+	schedule.S3Prefix = prefix
+	schedule.S3AccessKey = req.S3AccessKey
+	sealed, err := secrets.Seal(req.S3SecretKey)
+	if err != nil {
+		slog.Error("Failed to seal bucket secret key", "error", err)
+		return internalError("failed to create export schedule")
+	}
+	schedule.S3SecretKey = sealed
+	return nil
+}
+
+// UpdateRoomExport changes how often the schedule named by the
+// :scheduleId parameter runs, or pauses or resumes it. A changed interval
+// counts from now. Only the room's owner may.
+func UpdateRoomExport(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		schedule, apiErr := loadRoomExport(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req updateRoomExportRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.IntervalDays != nil && *req.IntervalDays != schedule.IntervalDays {
+			schedule.IntervalDays = *req.IntervalDays
+			schedule.NextRunAt = time.Now().Add(time.Duration(schedule.IntervalDays) * 24 * time.Hour)
+		}
+		if req.Enabled != nil && *req.Enabled && !schedule.Enabled {
+			// Snapshots only ever go to an owner's bucket or address.
+			if schedule.CreatedByID == nil || *schedule.CreatedByID != CurrentUserID(c) {
+				return nil, conflict("the schedule was set up by a former owner; create a new one")
+			}
+			if schedule.NextRunAt.Before(time.Now()) {
+				schedule.NextRunAt = time.Now()
+			}
+		}
+		if req.Enabled != nil {
+			schedule.Enabled = *req.Enabled
+		}
+		ctx := c.Request.Context()
+		if err := repositories.NewRoomExportRepository(exports.db.DB).Update(ctx, schedule); err != nil {
+			slog.ErrorContext(ctx, "Failed to update room export schedule", "id", schedule.ID, "error", err)
+			return nil, internalError("failed to update export schedule")
+		}
+		return &Response{Data: schedule, Legacy: gin.H{"schedule": schedule}}, nil
+	}
+}
+
+// DeleteRoomExport removes the schedule named by the :scheduleId parameter
+// with its delivery log and the emailed snapshots still kept. Only the
+// room's owner may.
+func DeleteRoomExport(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomExportOwnership(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		id, err := uuid.Parse(c.Param("scheduleId"))
+		if err != nil {
+			return nil, badRequest("invalid export schedule id")
+		}
+		ctx := c.Request.Context()
+		keys, err := repositories.NewRoomExportRepository(exports.db.DB).Delete(ctx, conversation.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("export schedule not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete room export schedule", "id", id, "error", err)
+			return nil, internalError("failed to delete export schedule")
+		}
+		for _, key := range keys {
+			deleteObject(exports.store, key)
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// RunRoomExport queues a snapshot for the schedule named by the
+// :scheduleId parameter now, as to check its destination works, without
+// moving its next run. Only the room's owner may.
+func RunRoomExport(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		schedule, apiErr := loadRoomExport(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		if _, err := repositories.NewJobRepository(exports.db.DB).Enqueue(ctx, JobRoomExport, roomExportJob{ScheduleID: schedule.ID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue room export", "id", schedule.ID, "error", err)
+			return nil, internalError("failed to queue export")
+		}
+		return &Response{Status: http.StatusAccepted, Data: schedule, Legacy: gin.H{"schedule": schedule}}, nil
+	}
+}
+
+// ListRoomExportDeliveries returns the log of the room's scheduled
+// exports, newest first. Only the room's owner may see it.
+func ListRoomExportDeliveries(exports *RoomExports) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomExportOwnership(c, exports.db)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		before, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultRoomExportDeliveryPage, maxRoomExportDeliveryPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		deliveries, err := repositories.NewRoomExportRepository(exports.db.DB).ListDeliveries(c.Request.Context(), conversation.ID, before, limit+1)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list room export deliveries", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list export deliveries")
+		}
+		page := &Page{}
+		if len(deliveries) > limit {
+			deliveries = deliveries[:limit]
+			page.HasMore = true
+			last := deliveries[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		legacy := gin.H{"deliveries": deliveries}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: deliveries, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// DownloadRoomExport streams an emailed snapshot named by the :deliveryId
+// parameter until it expires. Only the room's owner may.
+func DownloadRoomExport(c *gin.Context, exports *RoomExports) {
+	conversation, apiErr := roomExportOwnership(c, exports.db)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	id, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		abortWithError(c, badRequest("invalid delivery id"))
+		return
+	}
+	ctx := c.Request.Context()
+	delivery, err := repositories.NewRoomExportRepository(exports.db.DB).GetDelivery(ctx, conversation.ID, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		abortWithError(c, notFound("export delivery not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load room export delivery", "id", id, "error", err)
+		abortWithError(c, internalError("failed to load export"))
+		return
+	}
+	if delivery.StorageKey == nil || (delivery.ExpiresAt != nil && time.Now().After(*delivery.ExpiresAt)) {
+		abortWithError(c, notFound("export is not available for download"))
+		return
+	}
+
+	body, err := exports.store.Open(ctx, *delivery.StorageKey)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to open room export", "id", id, "error", err)
+		abortWithError(c, internalError("failed to load export"))
+		return
+	}
+	defer body.Close()
+	c.DataFromReader(http.StatusOK, delivery.SizeBytes, roomExportContentType, body, map[string]string{
+		"Content-Disposition": `attachment; filename="afrochat-room-` + conversation.ID.String() + `.json"`,
+		"Cache-Control":       "no-store",
+	})
+}
+
+// QueueRoomExports is the scheduled job queueing an export for each
+// schedule that fell due, and forgetting emailed snapshots past
+// roomExportTTL and deliveries past roomExportLogRetention.
+func QueueRoomExports(exports *RoomExports) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		now := time.Now()
+		var queued int
+		err := exports.db.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			due, err := repositories.NewRoomExportRepository(tx).ClaimDue(ctx, now, roomExportBatch)
+			if err != nil {
+				return err
+			}
+			jobs := repositories.NewJobRepository(tx)
+			for _, schedule := range due {
+				if _, err := jobs.Enqueue(ctx, JobRoomExport, roomExportJob{ScheduleID: schedule.ID}); err != nil {
+					return err
+				}
+			}
+			queued = len(due)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if queued > 0 {
+			slog.InfoContext(ctx, "Queued room exports", "count", queued)
+		}
+
+		schedules := repositories.NewRoomExportRepository(exports.db.DB)
+		keys, err := schedules.ExpireSnapshots(ctx, now)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			deleteObject(exports.store, key)
+		}
+		purged, err := schedules.PurgeDeliveries(ctx, now.Add(-roomExportLogRetention))
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 || purged > 0 {
+			slog.InfoContext(ctx, "Purged room exports", "snapshots", len(keys), "deliveries", purged)
+		}
+		return nil
+	}
+}
+
+// DeliverRoomExport is the job building a room's snapshot and delivering
+// it. The delivery is logged once it succeeds or is given up on. Schedules
+// whose creator no longer owns the room are disabled instead.
+func DeliverRoomExport(exports *RoomExports) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload roomExportJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		schedules := repositories.NewRoomExportRepository(exports.db.DB)
+		schedule, err := schedules.GetByID(ctx, payload.ScheduleID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		conversation, err := repositories.NewConversationRepository(exports.db.DB).Get(ctx, schedule.ConversationID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		delivery := models.RoomExportDelivery{ID: uuid.New(), ScheduleID: schedule.ID, ConversationID: conversation.ID}
+		if schedule.CreatedByID == nil || !ownsConversation(conversation, *schedule.CreatedByID) {
+			if err := schedules.Disable(ctx, schedule.ID); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "Room export disabled; its creator no longer owns the room", "id", schedule.ID)
+			return exports.logDelivery(ctx, &delivery, errors.New("the schedule's creator no longer owns the room; it was disabled"))
+		}
+
+		err = exports.deliver(ctx, schedule, conversation, &delivery)
+		if err != nil && !finalAttempt(job) {
+			return err
+		}
+		if logErr := exports.logDelivery(ctx, &delivery, err); logErr != nil {
+			return logErr
+		}
+		return err
+	}
+}
+
+// deliver writes the room's snapshot to a temporary file and sends it to
+// the schedule's destination, filling in the delivery.
+func (e *RoomExports) deliver(ctx context.Context, schedule *models.RoomExportSchedule, conversation *models.Conversation, delivery *models.RoomExportDelivery) error {
+	file, err := os.CreateTemp("", "afrochat-room-export-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	now := time.Now()
+	messages, err := writeRoomSnapshot(ctx, repositories.NewMessageRepository(e.db.DB), conversation, now, file)
+	if err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to size export file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind export file: %w", err)
+	}
+	delivery.Messages = messages
+	delivery.SizeBytes = size
+
+	switch schedule.Destination {
+	case models.RoomExportToS3:
+		return e.deliverToBucket(ctx, schedule, file, now, delivery)
+	case models.RoomExportToEmail:
+		return e.deliverByEmail(ctx, schedule, conversation, file, delivery)
+	}
+	return fmt.Errorf("unknown room export destination %q", schedule.Destination)
+}
+
+func (e *RoomExports) deliverToBucket(ctx context.Context, schedule *models.RoomExportSchedule, file *os.File, now time.Time, delivery *models.RoomExportDelivery) error {
+	secretKey, err := e.secretKey(ctx, schedule)
+	if err != nil {
+		return err
+	}
+	bucket, err := storage.NewS3(storage.S3Config{
+		Endpoint:  schedule.S3Endpoint,
+		Region:    schedule.S3Region,
+		Bucket:    schedule.S3Bucket,
+		AccessKey: schedule.S3AccessKey,
+		SecretKey: secretKey,
+		UseSSL:    true,
+		Transport: e.transport,
+	})
+	if err != nil {
+		return err
+	}
+	key := schedule.S3Prefix + "afrochat/" + schedule.ConversationID.String() + "/" + now.UTC().Format("20060102T150405Z") + ".json"
+	delivery.Location = "s3://" + schedule.S3Bucket + "/" + key
+	return bucket.Put(ctx, key, file, delivery.SizeBytes, roomExportContentType)
+}
+
+// secretKey opens the sealed secret key of the schedule's bucket. Keys
+// stored in the clear, before keys were sealed, are used as they are and
+// sealed in their place.
+func (e *RoomExports) secretKey(ctx context.Context, schedule *models.RoomExportSchedule) (string, error) {
+	if !secretbox.IsSealed(schedule.S3SecretKey) {
+		if e.secrets != nil {
+			if sealed, err := e.secrets.Seal(schedule.S3SecretKey); err == nil {
+				if err := repositories.NewRoomExportRepository(e.db.DB).SetSecretKey(ctx, schedule.ID, sealed); err != nil {
+					slog.ErrorContext(ctx, "Failed to seal bucket secret key", "id", schedule.ID, "error", err)
+				}
+			}
+		}
+		return schedule.S3SecretKey, nil
+	}
+	if e.secrets == nil {
+		return "", errors.New("the bucket's secret key is sealed, and SECRETS_KEY is not set")
+	}
+	return e.secrets.Open(schedule.S3SecretKey)
+}
+
+// deliverByEmail keeps the snapshot in storage for roomExportTTL and
+// emails its creator a link to it.
+func (e *RoomExports) deliverByEmail(ctx context.Context, schedule *models.RoomExportSchedule, conversation *models.Conversation, file *os.File, delivery *models.RoomExportDelivery) error {
+	var owner models.User
+	if err := e.db.DB.WithContext(ctx).First(&owner, "id = ?", *schedule.CreatedByID).Error; err != nil {
+		return fmt.Errorf("failed to load room owner: %w", err)
+	}
+	delivery.Location = owner.Email
+
+	key := storage.ResidentKey(e.store, residency.Region(owner.Residency), "room-exports/"+conversation.ID.String()+"/"+delivery.ID.String()+".json")
+	if err := e.store.Put(ctx, key, file, delivery.SizeBytes, roomExportContentType); err != nil {
+		return fmt.Errorf("failed to store export: %w", err)
+	}
+	expires := time.Now().Add(roomExportTTL)
+	delivery.StorageKey = &key
+	delivery.ExpiresAt = &expires
+
+	download := "Download it from the room's export log in AfroChat within 7 days."
+	link, err := e.store.PresignGet(ctx, key, roomExportTTL)
+	if err == nil {
+		download = "Download it within 7 days here:\n\n" + link.URL
+	} else if !errors.Is(err, storage.ErrPresignUnsupported) {
+		return fmt.Errorf("failed to presign export download: %w", err)
+	}
+	title := "your room"
+	if conversation.Title != nil {
+		title = *conversation.Title
+	}
+	body := "Your scheduled export of " + title + " is ready, with " + strconv.Itoa(delivery.Messages) + " messages. " +
+		download + "\n\nTo stop these emails, remove the export schedule in the room's settings."
+	err = e.mail.Send(ctx, mailer.Message{
+		To:      owner.Email,
+		Subject: "Your AfroChat export of " + title,
+		Body:    body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to email export: %w", err)
+	}
+	return nil
+}
+
+// logDelivery records the outcome of a delivery. A failed one keeps
+// nothing in storage.
+func (e *RoomExports) logDelivery(ctx context.Context, delivery *models.RoomExportDelivery, deliveryErr error) error {
+	delivery.Status = models.RoomExportDelivered
+	if deliveryErr != nil {
+		message := deliveryErr.Error()
+		delivery.Status = models.RoomExportFailed
+		delivery.Error = &message
+		if delivery.StorageKey != nil {
+			deleteObject(e.store, *delivery.StorageKey)
+			delivery.StorageKey, delivery.ExpiresAt = nil, nil
+		}
+		slog.WarnContext(ctx, "Room export failed", "schedule_id", delivery.ScheduleID, "error", deliveryErr)
+	}
+	return repositories.NewRoomExportRepository(e.db.DB).RecordDelivery(ctx, delivery)
+}
+
+// writeRoomSnapshot writes a room as a JSON object holding the room with
+// its members and its messages, oldest first, a batch at a time. It
+// returns how many messages it wrote.
+func writeRoomSnapshot(ctx context.Context, messages *repositories.MessageRepository, conversation *models.Conversation, at time.Time, w io.Writer) (int, error) {
+	meta, err := json.Marshal(roomSnapshotMeta{ExportedAt: at, Conversation: conversation})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode room: %w", err)
+	}
+	// The messages go in the same object, after the room.
+	if _, err := w.Write(meta[:len(meta)-1]); err != nil {
+		return 0, err
+	}
+	if _, err := io.WriteString(w, `,"messages":[`); err != nil {
+		return 0, err
+	}
+	encoder := json.NewEncoder(w)
+	var after pagination.Cursor
+	written := 0
+	for {
+		batch, err := messages.ListAfter(ctx, conversation.ID, after, exportBatchSize)
+		if err != nil {
+			return 0, err
+		}
+		for _, message := range batch {
+			if message.DeletedAt.Valid || message.Shadowed {
+				continue
+			}
+			if written > 0 {
+				if _, err := io.WriteString(w, ","); err != nil {
+					return 0, err
+				}
+			}
+			if err := encoder.Encode(message); err != nil {
+				return 0, fmt.Errorf("failed to write message %s: %w", message.ID, err)
+			}
+			written++
+		}
+		if len(batch) < exportBatchSize {
+			break
+		}
+		last := batch[len(batch)-1]
+		after = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return written, err
+}
+
+// roomExportOwnership loads the group or channel named by the :id
+// parameter, which the current user must own.
+func roomExportOwnership(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *APIError) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if conversation.Kind == models.ConversationDirect {
+		return nil, badRequest("direct conversations cannot be exported on a schedule")
+	}
+	if !ownsConversation(conversation, CurrentUserID(c)) {
+		return nil, forbidden("only the room's owner can schedule exports")
+	}
+	return conversation, nil
+}
+
+// loadRoomExport loads the export schedule named by the :scheduleId
+// parameter of a room the current user owns.
+func loadRoomExport(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.RoomExportSchedule, *APIError) {
+	conversation, apiErr := roomExportOwnership(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	id, err := uuid.Parse(c.Param("scheduleId"))
+	if err != nil {
+		return nil, badRequest("invalid export schedule id")
+	}
+	schedule, err := repositories.NewRoomExportRepository(dbConnection.DB).Get(c.Request.Context(), conversation.ID, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("export schedule not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load room export schedule", "id", id, "error", err)
+		return nil, internalError("failed to load export schedule")
+	}
+	return schedule, nil
+}
+
+// ownsConversation reports whether the user is the conversation's owner.
+func ownsConversation(conversation *models.Conversation, userID uuid.UUID) bool {
+	for _, member := range conversation.Members {
+		if member.UserID == userID {
+			return member.Role == models.MemberRoleOwner
+		}
+	}
+	return false
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/autoreply"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// eventFAQSuggestion points a member who asked a question to the FAQ
+	// entry answering it.
+	eventFAQSuggestion = "faq.suggestion"
+
+	// maxRoomFAQs caps the FAQ entries of one room.
+	maxRoomFAQs = 100
+
+	// faqRepostInterval is how long after posting an answer to a room it
+	// is only suggested, so members asking in turn do not flood the room.
+	faqRepostInterval = 10 * time.Minute
+
+	// faqClientIDPrefix marks the client_id of posted answers, which are
+	// not themselves answered.
+	faqClientIDPrefix = "faq:"
+)
+
+type roomFAQRequest struct {
+	Question string `json:"question" binding:"required,max=300"`
+	Keywords string `json:"keywords" binding:"required,max=1000"`
+	Answer   string `json:"answer" binding:"required,max=4000"`
+	Enabled  *bool  `json:"enabled"`
+	AutoPost bool   `json:"auto_post"`
+}
+
+// faqSuggestion is the data of a faq.suggestion event.
+type faqSuggestion struct {
+	ConversationID uuid.UUID       `json:"conversation_id"`
+	MessageID      uuid.UUID       `json:"message_id"`
+	FAQ            *models.RoomFAQ `json:"faq"`
+}
+
+// ListRoomFAQ returns the FAQ of a group or channel the current user
+// belongs to, oldest entry first, with how often each answered a
+// question. ?q= keeps the entries whose question, keywords or answer
+// contain it.
+func ListRoomFAQ(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := faqRoom(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		faqs, err := repositories.NewRoomFAQRepository(dbConnection.DB).List(c.Request.Context(), conversation.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list room FAQ", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list room FAQ")
+		}
+		if query := strings.ToLower(strings.TrimSpace(c.Query("q"))); query != "" {
+			found := make([]models.RoomFAQ, 0, len(faqs))
+			for _, faq := range faqs {
+				if strings.Contains(strings.ToLower(faq.Question+"\n"+faq.Keywords+"\n"+faq.Answer), query) {
+					found = append(found, faq)
+				}
+			}
+			faqs = found
+		}
+		return &Response{Data: faqs, Legacy: gin.H{"faq": faqs}}, nil
+	}
+}
+
+// CreateRoomFAQ adds an entry to a room's FAQ. Members asking a question
+// containing its comma-separated keywords are pointed to it, or with
+// auto_post it is posted to the room for them, as the member who last
+// wrote it. Only the owner and admins may.
+func CreateRoomFAQ(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := faqRoomModeration(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req roomFAQRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		faq := models.RoomFAQ{ConversationID: conversation.ID}
+		if apiErr := req.apply(c, &faq); apiErr != nil {
+			return nil, apiErr
+		}
+
+		err := repositories.NewRoomFAQRepository(dbConnection.DB).Create(c.Request.Context(), &faq, maxRoomFAQs)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict("rooms may have at most " + strconv.Itoa(maxRoomFAQs) + " FAQ entries")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create room FAQ entry", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to create FAQ entry")
+		}
+		return &Response{Status: http.StatusCreated, Data: faq, Legacy: gin.H{"entry": faq}}, nil
+	}
+}
+
+// UpdateRoomFAQ replaces the question, keywords, answer and settings of
+// the FAQ entry named by the :faqId parameter, keeping its counts. Only
+// the owner and admins may.
+func UpdateRoomFAQ(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := faqRoomModeration(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		id, err := uuid.Parse(c.Param("faqId"))
+		if err != nil {
+			return nil, badRequest("invalid FAQ entry id")
+		}
+		var req roomFAQRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		faqs := repositories.NewRoomFAQRepository(dbConnection.DB)
+		faq, err := faqs.Get(ctx, conversation.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("FAQ entry not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load room FAQ entry", "id", id, "error", err)
+			return nil, internalError("failed to update FAQ entry")
+		}
+		if apiErr := req.apply(c, faq); apiErr != nil {
+			return nil, apiErr
+		}
+		if err := faqs.Update(ctx, faq); err != nil {
+			slog.ErrorContext(ctx, "Failed to update room FAQ entry", "id", id, "error", err)
+			return nil, internalError("failed to update FAQ entry")
+		}
+		return &Response{Data: faq, Legacy: gin.H{"entry": faq}}, nil
+	}
+}
+
+// DeleteRoomFAQ removes the FAQ entry named by the :faqId parameter. Only
+// the owner and admins may.
+func DeleteRoomFAQ(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := faqRoomModeration(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		id, err := uuid.Parse(c.Param("faqId"))
+		if err != nil {
+			return nil, badRequest("invalid FAQ entry id")
+		}
+		err = repositories.NewRoomFAQRepository(dbConnection.DB).Delete(c.Request.Context(), conversation.ID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("FAQ entry not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete room FAQ entry", "id", id, "error", err)
+			return nil, internalError("failed to delete FAQ entry")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// apply copies the request onto faq, written by the current user.
+func (req *roomFAQRequest) apply(c *gin.Context, faq *models.RoomFAQ) *APIError {
+	var keywords []string
+	for _, keyword := range strings.Split(req.Keywords, ",") {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			keywords = append(keywords, keyword)
+		}
+	}
+	question, answer := strings.TrimSpace(req.Question), strings.TrimSpace(req.Answer)
+	switch {
+	case question == "":
+		return badRequest("question cannot be blank")
+	case answer == "":
+		return badRequest("answer cannot be blank")
+	case len(keywords) == 0:
+		return badRequest("keywords must name at least one keyword")
+	}
+
+	userID := CurrentUserID(c)
+	faq.Question = question
+	faq.Keywords = strings.Join(keywords, ", ")
+	faq.Answer = answer
+	faq.Enabled = req.Enabled == nil || *req.Enabled
+	faq.AutoPost = req.AutoPost
+	faq.AuthorID = &userID
+	return nil
+}
+
+// faqRoom loads the group or channel named by the id path parameter,
+// which the current user must belong to. Direct conversations have no
+// FAQ.
+func faqRoom(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *APIError) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if conversation.Kind == models.ConversationDirect {
+		return nil, badRequest("direct conversations have no FAQ")
+	}
+	return conversation, nil
+}
+
+// faqRoomModeration loads the room as faqRoom does, for its owner and
+// admins only.
+func faqRoomModeration(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *APIError) {
+	conversation, apiErr := faqRoom(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if !moderatesConversation(conversation, CurrentUserID(c)) {
+		return nil, forbidden("only the owner and admins can manage the FAQ")
+	}
+	return conversation, nil
+}
+
+// answerFromFAQ answers a question asked in a room with the FAQ entry
+// sharing the most keywords with it. An entry set to auto-post is posted
+// to the room as its author, unless it was posted within
+// faqRepostInterval or its author no longer runs the room; otherwise the
+// member who asked is pointed to it.
+func answerFromFAQ(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, message *models.Message) {
+	if message.Type != string(content.TypeText) || !autoreply.IsQuestion(message.Text) {
+		return
+	}
+	if message.ClientID != nil && strings.HasPrefix(*message.ClientID, faqClientIDPrefix) {
+		return
+	}
+	faqs := repositories.NewRoomFAQRepository(dbConnection.DB)
+	entries, err := faqs.List(ctx, message.ConversationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load room FAQ", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	faq := autoreply.MatchFAQ(entries, message.Text)
+	if faq == nil {
+		return
+	}
+	now := time.Now()
+	if err := faqs.RecordHit(ctx, faq.ID, now); err != nil {
+		slog.ErrorContext(ctx, "Failed to record room FAQ hit", "id", faq.ID, "error", err)
+	}
+
+	if faq.AutoPost && faq.AuthorID != nil && *faq.AuthorID != message.SenderID {
+		conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, message.ConversationID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load conversation", "conversation_id", message.ConversationID, "error", err)
+			return
+		}
+		if moderatesConversation(conversation, *faq.AuthorID) {
+			claimed, err := faqs.ClaimAutoPost(ctx, faq.ID, now, faqRepostInterval)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to claim room FAQ post", "id", faq.ID, "error", err)
+			}
+			if claimed {
+				input := messageInput{Text: faq.Answer, ClientID: faqClientIDPrefix + message.ID.String()}
+				_, _, err := postMessage(ctx, dbConnection, hub, notifier, suggester, index, *faq.AuthorID, message.ConversationID, input)
+				if err == nil {
+					return
+				}
+				slog.ErrorContext(ctx, "Failed to post room FAQ answer", "id", faq.ID, "error", err)
+			}
+		}
+	}
+
+	event, err := realtime.NewEvent(eventFAQSuggestion, faqSuggestion{ConversationID: message.ConversationID, MessageID: message.ID, FAQ: faq})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode event", "event_type", eventFAQSuggestion, "error", err)
+		return
+	}
+	hub.SendToUser(message.SenderID, event)
+}
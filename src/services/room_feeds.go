@@ -0,0 +1,280 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/feeds"
+	"github.com/dfunani/AfroChat/backend/pkg/markdown"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// JobPollRoomFeeds is the scheduled job posting new feed items into
+	// their rooms.
+	JobPollRoomFeeds = "poll_room_feeds"
+
+	// RoomFeedScanInterval is how often feeds due a poll are looked for.
+	RoomFeedScanInterval = 5 * time.Minute
+
+	// roomFeedPollInterval is how long a feed is left between polls.
+	roomFeedPollInterval = 15 * time.Minute
+
+	// roomFeedTimeout bounds fetching one feed.
+	roomFeedTimeout = 15 * time.Second
+
+	// roomFeedBatch bounds the feeds polled by one run of the job.
+	roomFeedBatch = 50
+
+	// maxRoomFeeds caps the feeds of one channel.
+	maxRoomFeeds = 10
+
+	// maxFeedItemsPerPoll caps the items of one feed posted at a time, so
+	// a feed publishing its archive at once does not flood the room.
+	maxFeedItemsPerPoll = 5
+
+	// maxFeedSummaryRunes is how much of an item's summary is posted.
+	maxFeedSummaryRunes = 500
+
+	// feedClientIDPrefix marks the client_id of posted feed items, which
+	// keeps an item from being posted twice.
+	feedClientIDPrefix = "feed:"
+)
+
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+type createRoomFeedRequest struct {
+	Name  string    `json:"name" binding:"required,max=100"`
+	URL   string    `json:"url" binding:"required"`
+	BotID uuid.UUID `json:"bot_id" binding:"required"`
+}
+
+// RoomFeeds polls channels' RSS and Atom feeds and posts their new items.
+type RoomFeeds struct {
+	db      *database.DatabaseConnection
+	scanner shortlink.Scanner
+	poller  *feeds.Poller
+}
+
+func NewRoomFeeds(dbConnection *database.DatabaseConnection, scanner shortlink.Scanner) *RoomFeeds {
+	return &RoomFeeds{db: dbConnection, scanner: scanner, poller: feeds.NewPoller(webhook.NewClient(roomFeedTimeout))}
+}
+
+// CreateRoomFeed adds a feed to the current channel, posting as one of the
+// current user's bots, which joins the channel. Its URL is scanned like a
+// short link's destination and fetched straight away, so a feed that
+// cannot be read is refused and one that can is only posted from as it
+// publishes new items.
+func CreateRoomFeed(roomFeeds *RoomFeeds) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createRoomFeedRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		destination, err := roomFeeds.scanner.Scan(req.URL)
+		var unsafeErr *shortlink.UnsafeDestinationError
+		if errors.As(err, &unsafeErr) {
+			return nil, badRequest(unsafeErr.Reason)
+		}
+		if err != nil {
+			return nil, badRequest("invalid feed url")
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		bot, err := repositories.NewBotRepository(roomFeeds.db.DB).GetForOwner(ctx, userID, req.BotID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("bot not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load bot", "bot_id", req.BotID, "error", err)
+			return nil, internalError("failed to add feed")
+		}
+		_, seen, err := roomFeeds.poller.Poll(ctx, destination, nil)
+		if err != nil {
+			return nil, badRequest("the feed could not be read: " + err.Error())
+		}
+		seenJSON, err := json.Marshal(seen)
+		if err != nil {
+			return nil, internalError("failed to add feed")
+		}
+
+		channelID := CurrentChannel(c).ConversationID
+		if err := repositories.NewChannelRepository(roomFeeds.db.DB).AddMembers(ctx, channelID, []uuid.UUID{bot.UserID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to add bot to channel", "channel_id", channelID, "bot_id", bot.UserID, "error", err)
+			return nil, internalError("failed to add feed")
+		}
+		now := time.Now()
+		feed := models.RoomFeed{
+			ConversationID: channelID,
+			BotID:          bot.UserID,
+			Name:           strings.TrimSpace(req.Name),
+			URL:            destination,
+			SeenGUIDs:      models.JSON(seenJSON),
+			CreatedByID:    &userID,
+			LastPolledAt:   &now,
+		}
+		err = repositories.NewWebhookRepository(roomFeeds.db.DB).CreateFeed(ctx, &feed, maxRoomFeeds)
+		if errors.Is(err, repositories.ErrLimitReached) {
+			return nil, conflict(fmt.Sprintf("channels may have at most %d feeds", maxRoomFeeds))
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create feed", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to add feed")
+		}
+		return &Response{Status: http.StatusCreated, Data: feed, Legacy: gin.H{"feed": feed}}, nil
+	}
+}
+
+// ListRoomFeeds lists the current channel's feeds.
+func ListRoomFeeds(roomFeeds *RoomFeeds) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		channelID := CurrentChannel(c).ConversationID
+		list, err := repositories.NewWebhookRepository(roomFeeds.db.DB).ListFeeds(c.Request.Context(), channelID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list feeds", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to list feeds")
+		}
+		return &Response{Data: list, Legacy: gin.H{"feeds": list}}, nil
+	}
+}
+
+// DeleteRoomFeed removes the feed named by the :feedId parameter from the
+// current channel.
+func DeleteRoomFeed(roomFeeds *RoomFeeds) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("feedId"))
+		if err != nil {
+			return nil, badRequest("invalid feed id")
+		}
+		err = repositories.NewWebhookRepository(roomFeeds.db.DB).DeleteFeed(c.Request.Context(), CurrentChannel(c).ConversationID, id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("feed not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete feed", "id", id, "error", err)
+			return nil, internalError("failed to delete feed")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// PollRoomFeeds is the scheduled job polling the feeds not polled for
+// roomFeedPollInterval and posting their new items into their channels,
+// as their bots. A feed that fails has the error recorded on it and is
+// tried again next time.
+func PollRoomFeeds(roomFeeds *RoomFeeds, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		now := time.Now()
+		hooks := repositories.NewWebhookRepository(roomFeeds.db.DB)
+		due, err := hooks.ClaimDueFeeds(ctx, now.Add(-roomFeedPollInterval), now, roomFeedBatch)
+		if err != nil {
+			return err
+		}
+		var posted int
+		for i := range due {
+			n, err := roomFeeds.poll(ctx, hub, notifier, suggester, index, &due[i])
+			posted += n
+			if recordErr := hooks.RecordPoll(ctx, due[i].ID, due[i].SeenGUIDs, err); recordErr != nil {
+				slog.ErrorContext(ctx, "Failed to record feed poll", "feed_id", due[i].ID, "error", recordErr)
+			}
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to poll feed", "feed_id", due[i].ID, "error", err)
+			}
+		}
+		if posted > 0 {
+			slog.InfoContext(ctx, "Posted feed items", "feeds", len(due), "items", posted)
+		}
+		return nil
+	}
+}
+
+// poll posts the new items of feed, newest last, and leaves the items the
+// feed lists in its SeenGUIDs. It returns how many items it posted.
+func (f *RoomFeeds) poll(ctx context.Context, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, feed *models.RoomFeed) (int, error) {
+	member, err := repositories.NewConversationRepository(f.db.DB).IsMember(ctx, feed.ConversationID, feed.BotID)
+	if err != nil {
+		return 0, err
+	}
+	if !member {
+		return 0, errors.New("the feed's bot is no longer in the channel")
+	}
+	// The URL was scanned when the feed was added; hosts blocked since
+	// are refused too.
+	destination, err := f.scanner.Scan(feed.URL)
+	if err != nil {
+		return 0, err
+	}
+	var seen []string
+	if len(feed.SeenGUIDs) > 0 {
+		if err := json.Unmarshal(feed.SeenGUIDs, &seen); err != nil {
+			return 0, fmt.Errorf("failed to decode seen items: %w", err)
+		}
+	}
+	items, current, err := f.poller.Poll(ctx, destination, seen)
+	if err != nil {
+		return 0, err
+	}
+	if len(items) > maxFeedItemsPerPoll {
+		items = items[len(items)-maxFeedItemsPerPoll:]
+	}
+	for _, item := range items {
+		input := messageInput{Text: feedItemText(item), ClientID: feedClientID(feed.ID, item.GUID)}
+		if _, _, err := postMessage(ctx, f.db, hub, notifier, suggester, index, feed.BotID, feed.ConversationID, input); err != nil {
+			return 0, fmt.Errorf("failed to post feed item: %w", err)
+		}
+	}
+	seenJSON, err := json.Marshal(current)
+	if err != nil {
+		return 0, err
+	}
+	feed.SeenGUIDs = models.JSON(seenJSON)
+	return len(items), nil
+}
+
+// feedItemText writes a feed item as a message: its title, its link on a
+// line of its own for clients to unfurl, and the start of its summary as
+// plain text.
+func feedItemText(item feeds.Item) string {
+	var lines []string
+	if title := strings.TrimSpace(item.Title); title != "" {
+		lines = append(lines, "**"+markdown.Escape(title)+"**")
+	}
+	if item.Link != "" {
+		lines = append(lines, item.Link)
+	}
+	summary := strings.Join(strings.Fields(html.UnescapeString(htmlTagPattern.ReplaceAllString(item.Summary, " "))), " ")
+	if utf8.RuneCountInString(summary) > maxFeedSummaryRunes {
+		summary = string([]rune(summary)[:maxFeedSummaryRunes-1]) + "…"
+	}
+	if summary != "" {
+		lines = append(lines, "", markdown.Escape(summary))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// feedClientID is the client_id of a feed's item, which fits the column
+// however long the item's GUID.
+func feedClientID(feedID uuid.UUID, guid string) string {
+	sum := sha256.Sum256([]byte(feedID.String() + "\n" + guid))
+	return feedClientIDPrefix + hex.EncodeToString(sum[:24])
+}
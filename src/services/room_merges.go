@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// eventConversationMerged tells the members of a merged room which room
+// it went into, for apps to move there.
+const eventConversationMerged = "conversation.merged"
+
+// reindexBatch is how many moved messages are handed to the search index
+// at once after a merge.
+const reindexBatch = 500
+
+type mergeRoomRequest struct {
+	TargetID    uuid.UUID `json:"target_id" binding:"required"`
+	MoveHistory bool      `json:"move_history"`
+}
+
+type conversationMerged struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MergedInto     uuid.UUID `json:"merged_into"`
+}
+
+// MergeRoom merges the group or channel :id into the one named by
+// target_id, to consolidate duplicate communities. Its members join the
+// target and, with move_history, its messages move there too. A system
+// message in the target explains the merge. The merged room is deleted,
+// and its members asking for it are redirected to the target. The
+// target's member cap does not apply, and history cannot move into or out
+// of an audit room without breaking its chain.
+func MergeRoom(dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		sourceID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid conversation id")
+		}
+		var req mergeRoomRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.TargetID == sourceID {
+			return nil, badRequest("a room cannot be merged into itself")
+		}
+
+		ctx := c.Request.Context()
+		source, apiErr := mergeableRoom(ctx, dbConnection, sourceID)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		target, apiErr := mergeableRoom(ctx, dbConnection, req.TargetID)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if source.WorkspaceID != target.WorkspaceID {
+			return nil, badRequest("rooms must be in the same workspace")
+		}
+		if req.MoveHistory && (source.Audited || target.Audited) {
+			return nil, conflict("history cannot be moved into or out of an audit room")
+		}
+
+		adminID := CurrentUserID(c)
+		merge := &models.RoomMerge{
+			SourceID:     source.ID,
+			TargetID:     target.ID,
+			MergedByID:   &adminID,
+			MovedHistory: req.MoveHistory,
+		}
+		movedIDs, err := repositories.NewRoomMergeRepository(dbConnection.DB).Merge(ctx, merge)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("conversation not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to merge rooms", "source_id", source.ID, "target_id", target.ID, "error", err)
+			return nil, internalError("failed to merge rooms")
+		}
+		slog.InfoContext(ctx, "Merged rooms", "source_id", source.ID, "target_id", target.ID,
+			"admin_id", adminID, "moved_members", merge.MovedMembers, "moved_messages", merge.MovedMessages)
+
+		notifyMembers(hub, source, uuid.Nil, eventConversationMerged, conversationMerged{ConversationID: source.ID, MergedInto: target.ID})
+		reindexMessages(ctx, dbConnection, index, movedIDs)
+
+		title := "another room"
+		if source.Title != nil {
+			title = *source.Title
+		}
+		text := fmt.Sprintf("%s was merged into this room. Its members were added here", title)
+		if merge.MovedHistory {
+			text += " and its history was moved over"
+		}
+		notice := content.RoomMerged{
+			Event:        content.NoticeRoomMerged,
+			SourceID:     source.ID,
+			SourceTitle:  title,
+			MovedHistory: merge.MovedHistory,
+			MovedMembers: merge.MovedMembers,
+		}
+		if _, err := postSystemMessage(ctx, dbConnection, hub, index, adminID, target.ID, text+".", notice); err != nil {
+			// The merge is done; only its explanation is missing.
+			slog.ErrorContext(ctx, "Failed to post room merge notice", "conversation_id", target.ID, "error", err)
+		}
+
+		return &Response{Data: merge, Legacy: gin.H{"merge": merge}}, nil
+	}
+}
+
+// mergeableRoom loads a group or channel to merge.
+func mergeableRoom(ctx context.Context, dbConnection *database.DatabaseConnection, id uuid.UUID) (*models.Conversation, *APIError) {
+	conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("conversation not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation", "conversation_id", id, "error", err)
+		return nil, internalError("failed to load conversation")
+	}
+	if conversation.Kind == models.ConversationDirect {
+		return nil, badRequest("direct conversations cannot be merged")
+	}
+	return conversation, nil
+}
+
+// reindexMessages hands messages that moved between rooms to the search
+// index, which files them under their room.
+func reindexMessages(ctx context.Context, dbConnection *database.DatabaseConnection, index search.Index, ids []uuid.UUID) {
+	for start := 0; start < len(ids); start += reindexBatch {
+		batch := ids[start:min(start+reindexBatch, len(ids))]
+		var messages []models.Message
+		if err := dbConnection.DB.WithContext(ctx).Unscoped().Where("id IN ?", batch).Find(&messages).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load moved messages", "error", err)
+			return
+		}
+		for i := range messages {
+			if messages[i].DeletedAt.Valid || !search.Indexable(&messages[i]) {
+				continue
+			}
+			if err := index.Add(ctx, &messages[i]); err != nil {
+				slog.ErrorContext(ctx, "Failed to index message", "message_id", messages[i].ID, "error", err)
+			}
+		}
+	}
+}
+
+// postSystemMessage stores a system message explaining a change to a room
+// and delivers it to the members' open sockets. It is not pushed: it is
+// news about the room, not from anyone in it. senderID is who made the
+// change.
+func postSystemMessage(ctx context.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, index search.Index, senderID, conversationID uuid.UUID, text string, payload any) (*models.Message, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	message := &models.Message{
+		ConversationID: conversationID,
+		SenderID:       senderID,
+		Type:           string(content.TypeSystem),
+		Text:           text,
+		Entities:       models.JSON("[]"),
+		Payload:        models.JSON(raw),
+	}
+	if _, err := repositories.NewMessageRepository(dbConnection.DB).Create(ctx, message, nil); err != nil {
+		return nil, err
+	}
+	if err := index.Add(ctx, message); err != nil {
+		slog.ErrorContext(ctx, "Failed to index message", "message_id", message.ID, "error", err)
+	}
+	queueArchive(ctx, dbConnection, "message.new", message)
+
+	memberIDs, err := repositories.NewConversationRepository(dbConnection.DB).MemberIDs(ctx, conversationID)
+	if err != nil {
+		// The message is stored; members will pick it up from history.
+		slog.ErrorContext(ctx, "Failed to load members of conversation", "conversation_id", conversationID, "error", err)
+		return message, nil
+	}
+	event, err := realtime.NewEvent("message.new", message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode message", "message_id", message.ID, "error", err)
+		return message, nil
+	}
+	hub.SendToUsers(memberIDs, event)
+	return message, nil
+}
+
+// mergedRoomRedirect answers a request for a merged room, when the current
+// user belongs to the room it went into, with a permanent redirect to the
+// same path there. It returns nil otherwise.
+func mergedRoomRedirect(c *gin.Context, dbConnection *database.DatabaseConnection, id uuid.UUID) *APIError {
+	ctx := c.Request.Context()
+	targetID, err := repositories.NewRoomMergeRepository(dbConnection.DB).Redirect(ctx, id)
+	if err != nil {
+		if !errors.Is(err, repositories.ErrNotFound) {
+			slog.ErrorContext(ctx, "Failed to look up room merge", "conversation_id", id, "error", err)
+		}
+		return nil
+	}
+	isMember, err := repositories.NewConversationRepository(dbConnection.DB).IsMember(ctx, targetID, CurrentUserID(c))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check conversation membership", "error", err)
+		return nil
+	}
+	if !isMember {
+		return nil
+	}
+
+	location := *c.Request.URL
+	location.Path = strings.Replace(location.Path, id.String(), targetID.String(), 1)
+	c.Header("Location", location.RequestURI())
+	return &APIError{Status: http.StatusPermanentRedirect, Code: "moved", Message: "conversation was merged into " + targetID.String()}
+}
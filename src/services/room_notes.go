@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/ot"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventRoomNotesEdited = "room_notes.edited"
+
+	// maxRoomNotesLength caps room notes, in characters.
+	maxRoomNotesLength = 20000
+
+	maxRoomNoteEditsPage = 100
+)
+
+type editRoomNotesRequest struct {
+	// BaseVersion is the version of the notes the operation was made
+	// against.
+	BaseVersion *int64       `json:"base_version" binding:"required,gte=0"`
+	Operation   ot.Operation `json:"operation"`
+}
+
+// GetRoomNotes returns the shared notes of a group or channel the current
+// user belongs to.
+func GetRoomNotes(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomWithNotes(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		note, err := repositories.NewRoomNoteRepository(dbConnection.DB).Get(c.Request.Context(), conversation.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to load room notes", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to load room notes")
+		}
+		return &Response{Data: note, Legacy: gin.H{"notes": note}}, nil
+	}
+}
+
+// EditRoomNotes applies an edit to a room's notes, made against
+// base_version as an operation: an array in which a positive number keeps
+// that many characters, a negative number deletes them and a string
+// inserts itself. Edits made concurrently by other admins are merged, and
+// the edit as applied is sent to the other members as "room_notes.edited".
+// Only the owner and admins may edit.
+func EditRoomNotes(dbConnection *database.DatabaseConnection, hub *realtime.Hub) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomWithNotes(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		userID := CurrentUserID(c)
+		if !moderatesConversation(conversation, userID) {
+			return nil, forbidden("only the owner and admins can edit the notes")
+		}
+
+		var req editRoomNotesRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		note, edit, err := repositories.NewRoomNoteRepository(dbConnection.DB).Edit(ctx, conversation.ID, userID, *req.BaseVersion, req.Operation, maxRoomNotesLength)
+		switch {
+		case errors.Is(err, repositories.ErrStaleVersion):
+			return nil, conflict("the notes have moved on too far from base_version; reload them and try again")
+		case errors.Is(err, repositories.ErrTooLong):
+			apiErr := badRequest("notes must be at most " + strconv.Itoa(maxRoomNotesLength) + " characters")
+			apiErr.Details = []FieldError{{Field: "operation", Rule: "max_characters", Param: strconv.Itoa(maxRoomNotesLength)}}
+			return nil, apiErr
+		case errors.Is(err, ot.ErrInvalid):
+			return nil, badRequest(err.Error())
+		case err != nil:
+			slog.ErrorContext(ctx, "Failed to edit room notes", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to edit room notes")
+		}
+
+		if edit != nil {
+			notifyMembers(hub, conversation, userID, eventRoomNotesEdited, edit)
+		}
+		return &Response{Data: note, Legacy: gin.H{"notes": note}}, nil
+	}
+}
+
+// ListRoomNoteEdits returns the edits to a room's notes after ?since=<version>,
+// oldest first, for clients that missed "room_notes.edited" events.
+func ListRoomNoteEdits(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := roomWithNotes(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		since, err := strconv.ParseInt(c.Query("since"), 10, 64)
+		if err != nil || since < 0 {
+			return nil, badRequest("since must be a version number")
+		}
+
+		ctx := c.Request.Context()
+		edits, err := repositories.NewRoomNoteRepository(dbConnection.DB).EditsSince(ctx, conversation.ID, since, maxRoomNoteEditsPage+1)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list room note edits", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to list room note edits")
+		}
+
+		page := &Page{}
+		if len(edits) > maxRoomNoteEditsPage {
+			edits = edits[:maxRoomNoteEditsPage]
+			page.HasMore = true
+			page.NextCursor = strconv.FormatInt(edits[len(edits)-1].Version, 10)
+		}
+		legacy := gin.H{"edits": edits}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: edits, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// roomWithNotes loads the group or channel named by the id path
+// parameter, which the current user must belong to. Direct conversations
+// have no notes.
+func roomWithNotes(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Conversation, *APIError) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if conversation.Kind == models.ConversationDirect {
+		return nil, badRequest("direct conversations have no notes")
+	}
+	return conversation, nil
+}
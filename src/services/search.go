@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultSearchPage = 20
+	maxSearchPage     = 50
+)
+
+const JobSearchIndex = "search_index"
+
+type searchIndexJob struct {
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// NewSearchEngine returns the search engine outside the database named by
+// the config, with its index created, or nil when Postgres searches the
+// messages table itself.
+func NewSearchEngine(ctx context.Context, appConfig *config.ApplicationConfig, dbConnection *database.DatabaseConnection) (*search.OpenSearch, error) {
+	if appConfig.SearchBackend != config.SearchOpenSearch {
+		return nil, nil
+	}
+	engine := search.NewOpenSearch(search.OpenSearchConfig{
+		URL:      appConfig.OpenSearchURL,
+		Index:    appConfig.OpenSearchIndex,
+		Username: appConfig.OpenSearchUsername,
+		Password: appConfig.OpenSearchPassword,
+	}, dbConnection.DB)
+	if err := engine.EnsureIndex(ctx); err != nil {
+		return nil, err
+	}
+	return engine, nil
+}
+
+// QueuedSearchIndex is the index of a remote engine, which messages reach
+// through JobSearchIndex jobs run by IndexMessage.
+func QueuedSearchIndex(dbConnection *database.DatabaseConnection, engine search.Remote) search.Index {
+	return &search.Queued{
+		Remote: engine,
+		Enqueue: func(ctx context.Context, messageID uuid.UUID) error {
+			_, err := repositories.NewJobRepository(dbConnection.DB).Enqueue(ctx, JobSearchIndex, searchIndexJob{MessageID: messageID})
+			return err
+		},
+	}
+}
+
+// IndexMessage is the job bringing a message's search entry up to date:
+// indexing it as currently stored, or dropping it once deleted.
+func IndexMessage(dbConnection *database.DatabaseConnection, engine search.Remote) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload searchIndexJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		message, err := repositories.NewMessageRepository(dbConnection.DB).Get(ctx, payload.MessageID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return engine.Delete(ctx, payload.MessageID)
+		}
+		if err != nil {
+			return err
+		}
+		if !search.Indexable(message) {
+			return engine.Delete(ctx, message.ID)
+		}
+		return engine.Put(ctx, []models.Message{*message})
+	}
+}
+
+// SearchMessages finds messages containing the words in q, in every
+// conversation of the current workspace the user belongs to, newest first. sender_id
+// narrows the search to one sender, and since and until (RFC 3339) to
+// when messages were sent.
+func SearchMessages(index search.Index) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		text := strings.TrimSpace(c.Query("q"))
+		if text == "" {
+			return nil, badRequest("q is required")
+		}
+		if utf8.RuneCountInString(text) > search.MaxQueryLength {
+			return nil, badRequest("q must be at most 256 characters")
+		}
+
+		query := search.Query{UserID: CurrentUserID(c), WorkspaceID: CurrentWorkspaceID(c), Text: text}
+		if raw := c.Query("sender_id"); raw != "" {
+			senderID, err := uuid.Parse(raw)
+			if err != nil {
+				return nil, badRequest("invalid sender_id")
+			}
+			query.SenderID = senderID
+		}
+		var apiErr *APIError
+		if query.Since, apiErr = timeQuery(c, "since"); apiErr != nil {
+			return nil, apiErr
+		}
+		if query.Until, apiErr = timeQuery(c, "until"); apiErr != nil {
+			return nil, apiErr
+		}
+
+		after, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultSearchPage, maxSearchPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 50")
+		}
+		// Fetch one extra row to learn whether another page exists.
+		query.After, query.Limit = after, limit+1
+
+		messages, err := index.Search(c.Request.Context(), query)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to search messages", "error", err)
+			return nil, internalError("failed to search messages")
+		}
+		if after == nil {
+			recordUsage(c.Request.Context(), query.UserID, featureSearches, 1)
+		}
+
+		page := &Page{}
+		if len(messages) > limit {
+			messages = messages[:limit]
+			page.HasMore = true
+			page.NextCursor = search.Cursor(&messages[limit-1]).Encode()
+		}
+		if messages == nil {
+			messages = []models.Message{}
+		}
+		legacy := gin.H{"messages": messages}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: messages, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// timeQuery parses an optional RFC 3339 query parameter.
+func timeQuery(c *gin.Context, param string) (*time.Time, *APIError) {
+	raw := c.Query(param)
+	if raw == "" {
+		return nil, nil
+	}
+	at, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, badRequest(param + " must be an RFC 3339 time")
+	}
+	return &at, nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SessionView is a signed-in device as shown to its owner.
+type SessionView struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// ListSessions returns the current user's active sessions, marking the one
+// making the request.
+func ListSessions(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		sessions, err := repositories.NewSessionRepository(dbConnection.DB).ListActive(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			return nil, internalError("failed to list sessions")
+		}
+
+		current := currentSessionID(c)
+		views := make([]SessionView, len(sessions))
+		for i, session := range sessions {
+			views[i] = SessionView{Session: session, Current: session.ID == current}
+		}
+		return &Response{Data: views, Legacy: gin.H{"sessions": views}}, nil
+	}
+}
+
+// RevokeSession signs out one of the current user's sessions. Its refresh
+// token stops working and its access tokens are rejected immediately.
+func RevokeSession(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid session id")
+		}
+
+		if err := repositories.NewSessionRepository(dbConnection.DB).Revoke(c.Request.Context(), CurrentUserID(c), id); err != nil {
+			if errors.Is(err, repositories.ErrNotFound) {
+				return nil, notFound("session not found")
+			}
+			return nil, internalError("failed to revoke session")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// RevokeOtherSessions signs out every session of the current user except
+// the one making the request.
+func RevokeOtherSessions(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		revoked, err := repositories.NewSessionRepository(dbConnection.DB).RevokeAll(c.Request.Context(), CurrentUserID(c), currentSessionID(c))
+		if err != nil {
+			return nil, internalError("failed to revoke sessions")
+		}
+		data := gin.H{"revoked": revoked}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// Logout signs out the session making the request.
+func Logout(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		user := CurrentUser(c)
+		if sessionID := currentSessionID(c); sessionID != uuid.Nil {
+			err := repositories.NewSessionRepository(dbConnection.DB).Revoke(c.Request.Context(), user.ID, sessionID)
+			if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+				return nil, internalError("failed to log out")
+			}
+		}
+
+		if err := dbConnection.DB.WithContext(c.Request.Context()).
+			Model(user).Update("last_logout_at", time.Now()).Error; err != nil {
+			return nil, internalError("failed to log out")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// currentSessionID returns the session of the request's access token, or
+// uuid.Nil for tokens issued before sessions existed.
+func currentSessionID(c *gin.Context) uuid.UUID {
+	if claims := CurrentClaims(c); claims != nil {
+		return claims.Session()
+	}
+	return uuid.Nil
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultShortLinkPage = 50
+	maxShortLinkPage     = 100
+
+	// maxShortLinkLifetime caps how far ahead a link's expiry may be set.
+	maxShortLinkLifetime = 365 * 24 * time.Hour
+
+	// shortLinkStatsWindow is how far back link stats go.
+	shortLinkStatsWindow = 90 * 24 * time.Hour
+)
+
+type createShortLinkRequest struct {
+	URL       string     `json:"url" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type disableShortLinkRequest struct {
+	Reason string `json:"reason" binding:"required,max=255"`
+}
+
+// ShortLinkView is a short link with its public address.
+type ShortLinkView struct {
+	models.ShortLink
+	ShortURL string `json:"short_url"`
+}
+
+// ShortLinkStats is a link with the breakdown of its recent clicks.
+type ShortLinkStats struct {
+	Link  ShortLinkView            `json:"link"`
+	Since time.Time                `json:"since"`
+	Stats *repositories.ClickStats `json:"stats"`
+}
+
+// ShortLinks creates and serves short links under a public base address.
+type ShortLinks struct {
+	db      *database.DatabaseConnection
+	scanner shortlink.Scanner
+	baseURL string
+	ttl     time.Duration
+}
+
+func NewShortLinks(dbConnection *database.DatabaseConnection, scanner shortlink.Scanner, baseURL string, ttl time.Duration) *ShortLinks {
+	return &ShortLinks{db: dbConnection, scanner: scanner, baseURL: strings.TrimSuffix(baseURL, "/"), ttl: ttl}
+}
+
+// Shorten scans a destination and makes a link to it, expiring at
+// expiresAt or after the default lifetime when expiresAt is nil. Unsafe
+// destinations fail with a shortlink.UnsafeDestinationError.
+func (s *ShortLinks) Shorten(ctx context.Context, destination, kind string, createdByID *uuid.UUID, expiresAt *time.Time) (*ShortLinkView, error) {
+	destination, err := s.scanner.Scan(destination)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt == nil {
+		t := time.Now().Add(s.ttl)
+		expiresAt = &t
+	}
+	link := models.ShortLink{
+		Destination: destination,
+		Kind:        kind,
+		CreatedByID: createdByID,
+		ExpiresAt:   expiresAt,
+	}
+	if err := repositories.NewShortLinkRepository(s.db.DB).Create(ctx, &link); err != nil {
+		return nil, err
+	}
+	view := s.view(link)
+	return &view, nil
+}
+
+func (s *ShortLinks) view(link models.ShortLink) ShortLinkView {
+	return ShortLinkView{ShortLink: link, ShortURL: s.baseURL + "/" + link.Code}
+}
+
+// CreateShortLink shortens a link the current user wants to share.
+// Destinations are scanned first: only public http and https addresses
+// are accepted.
+func CreateShortLink(links *ShortLinks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createShortLinkRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		now := time.Now()
+		if req.ExpiresAt != nil && (!req.ExpiresAt.After(now) || req.ExpiresAt.After(now.Add(maxShortLinkLifetime))) {
+			return nil, badRequest("expires_at must be in the next year")
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		view, err := links.Shorten(ctx, req.URL, models.ShortLinkShare, &userID, req.ExpiresAt)
+		var unsafeErr *shortlink.UnsafeDestinationError
+		if errors.As(err, &unsafeErr) {
+			return nil, badRequest(unsafeErr.Reason)
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create short link", "error", err)
+			return nil, internalError("failed to create short link")
+		}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"link": view}}, nil
+	}
+}
+
+// ListShortLinks returns a page of the current user's short links, newest
+// first.
+func ListShortLinks(links *ShortLinks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		before, err := pagination.Decode(c.Query("cursor"))
+		if err != nil {
+			return nil, badRequest("invalid cursor")
+		}
+		limit, err := pagination.Limit(c.Query("limit"), defaultShortLinkPage, maxShortLinkPage)
+		if err != nil {
+			return nil, badRequest("limit must be between 1 and 100")
+		}
+
+		ctx := c.Request.Context()
+		found, err := repositories.NewShortLinkRepository(links.db.DB).ListByCreator(ctx, CurrentUserID(c), before, limit+1)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list short links", "error", err)
+			return nil, internalError("failed to list short links")
+		}
+
+		page := &Page{}
+		if len(found) > limit {
+			found = found[:limit]
+			page.HasMore = true
+			last := found[limit-1]
+			page.NextCursor = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}.Encode()
+		}
+		views := make([]ShortLinkView, 0, len(found))
+		for _, link := range found {
+			views = append(views, links.view(link))
+		}
+		legacy := gin.H{"links": views}
+		if page.HasMore {
+			legacy["next_cursor"] = page.NextCursor
+		}
+		return &Response{Data: views, Page: page, Legacy: legacy}, nil
+	}
+}
+
+// GetShortLinkStats returns the link named by the :code parameter with its
+// clicks over the last 90 days by day and platform. Only the link's
+// creator and staff can see them.
+func GetShortLinkStats(links *ShortLinks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		linkRepo := repositories.NewShortLinkRepository(links.db.DB)
+		link, err := linkRepo.Resolve(ctx, c.Param("code"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("short link not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load short link", "error", err)
+			return nil, internalError("failed to load short link")
+		}
+		user := CurrentUser(c)
+		ownLink := link.CreatedByID != nil && *link.CreatedByID == user.ID
+		if !ownLink && user.Role == models.RoleUser {
+			return nil, notFound("short link not found")
+		}
+
+		since := time.Now().Add(-shortLinkStatsWindow)
+		stats, err := linkRepo.Stats(ctx, link.ID, since)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to count short link clicks", "link_id", link.ID, "error", err)
+			return nil, internalError("failed to load short link stats")
+		}
+		result := ShortLinkStats{Link: links.view(*link), Since: since, Stats: stats}
+		return &Response{Data: result, Legacy: gin.H{"link": result.Link, "since": result.Since, "stats": result.Stats}}, nil
+	}
+}
+
+// DisableShortLink takes down the link named by the :code parameter, so
+// following it answers 410 Gone.
+func DisableShortLink(links *ShortLinks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req disableShortLinkRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		link, err := repositories.NewShortLinkRepository(links.db.DB).Disable(ctx, c.Param("code"), req.Reason)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("short link not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to disable short link", "error", err)
+			return nil, internalError("failed to disable short link")
+		}
+		view := links.view(*link)
+		return &Response{Data: view, Legacy: gin.H{"link": view}}, nil
+	}
+}
+
+// FollowShortLink redirects to the destination of the link named by the
+// :code parameter and counts the click. Expired and disabled links answer
+// 410 Gone. The destination is scanned again first, and a link whose
+// destination has since been blocked is disabled.
+func FollowShortLink(c *gin.Context, links *ShortLinks) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Robots-Tag", "noindex")
+
+	code := c.Param("code")
+	if !shortlink.ValidCode(code) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "link not found"})
+		return
+	}
+	ctx := c.Request.Context()
+	linkRepo := repositories.NewShortLinkRepository(links.db.DB)
+	link, err := linkRepo.Resolve(ctx, code)
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"status": "error", "error": "link not found"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load short link", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "failed to follow link"})
+		return
+	}
+
+	now := time.Now()
+	if link.DisabledAt != nil || (link.ExpiresAt != nil && !link.ExpiresAt.After(now)) {
+		c.JSON(http.StatusGone, gin.H{"status": "error", "error": "this link is no longer available"})
+		return
+	}
+	destination, err := links.scanner.Scan(link.Destination)
+	if err != nil {
+		slog.WarnContext(ctx, "Disabling short link with unsafe destination", "link_id", link.ID, "error", err)
+		if _, err := linkRepo.Disable(ctx, code, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "Failed to disable short link", "link_id", link.ID, "error", err)
+		}
+		c.JSON(http.StatusGone, gin.H{"status": "error", "error": "this link is no longer available"})
+		return
+	}
+
+	// Counting the click is best effort; a failure must not strand the
+	// visitor.
+	click := models.ShortLinkClick{
+		LinkID:       link.ID,
+		Platform:     shortlink.ClientPlatform(c.Request.UserAgent()),
+		ReferrerHost: referrerHost(c.Request.Referer()),
+		ClickedAt:    now,
+	}
+	if err := linkRepo.RecordClick(ctx, &click); err != nil {
+		slog.ErrorContext(ctx, "Failed to record short link click", "link_id", link.ID, "error", err)
+	}
+	c.Redirect(http.StatusFound, destination)
+}
+
+// referrerHost is the host of a Referer header, without the path or query
+// that might identify the visitor.
+func referrerHost(referer string) *string {
+	if referer == "" {
+		return nil
+	}
+	link, err := url.Parse(referer)
+	if err != nil || link.Hostname() == "" {
+		return nil
+	}
+	host := strings.ToLower(link.Hostname())
+	if len(host) > 255 {
+		return nil
+	}
+	return &host
+}
+
+// inviteLinks shortens the signup links of a batch of invites, expiring
+// them with the invites. Links that cannot be shortened stay long, as all
+// of them do when the signup address itself fails the scan.
+func (s *ShortLinks) inviteLinks(ctx context.Context, signupURL string, invites []models.InviteCode, createdByID uuid.UUID) []string {
+	result := make([]string, len(invites))
+	for i, invite := range invites {
+		result[i] = signupLink(signupURL, invite.Code)
+		expiresAt := invite.ExpiresAt
+		if expiresAt == nil {
+			t := time.Now().Add(maxShortLinkLifetime)
+			expiresAt = &t
+		}
+		view, err := s.Shorten(ctx, result[i], models.ShortLinkInvite, &createdByID, expiresAt)
+		if errors.Is(err, shortlink.ErrUnsafeDestination) {
+			slog.WarnContext(ctx, "Signup address cannot be shortened", "error", err)
+			for j := i + 1; j < len(invites); j++ {
+				result[j] = signupLink(signupURL, invites[j].Code)
+			}
+			break
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to shorten invite link", "invite_code_id", invite.ID, "error", err)
+			continue
+		}
+		result[i] = view.ShortURL
+	}
+	return result
+}
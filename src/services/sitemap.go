@@ -0,0 +1,121 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxSitemapURLs is the most URLs one sitemap may list.
+const maxSitemapURLs = 50_000
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// roomOpenGraph is the page a link unfurler or crawler gets for a room.
+var roomOpenGraph = template.Must(template.New("room").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<meta name="description" content="{{.Description}}">
+<link rel="canonical" href="{{.URL}}">
+<meta property="og:type" content="website">
+<meta property="og:site_name" content="AfroChat">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+<meta name="twitter:card" content="summary">
+</head>
+<body><a href="{{.URL}}">{{.Title}}</a></body>
+</html>
+`))
+
+// GetSitemap returns sitemap.xml, listing the page of every public channel
+// whose owners offered it to search engines, most recently active first.
+func GetSitemap(c *gin.Context, pages *PublicPages) {
+	pages.serve(c, "sitemap", func(ctx context.Context) (publicPage, error) {
+		channels, err := repositories.NewChannelRepository(pages.db.DB).Listed(ctx, maxSitemapURLs)
+		if err != nil {
+			return publicPage{}, err
+		}
+		set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+		for _, channel := range channels {
+			updated := channel.UpdatedAt
+			if last := channel.Conversation.LastMessageAt; last != nil && last.After(updated) {
+				updated = *last
+			}
+			set.URLs = append(set.URLs, sitemapURL{
+				Loc:     pages.links.Room(channel.ConversationID),
+				LastMod: updated.UTC().Format(time.RFC3339),
+			})
+		}
+		body, err := xml.Marshal(set)
+		if err != nil {
+			return publicPage{}, err
+		}
+		return newPublicPage(append([]byte(xml.Header), body...), "application/xml; charset=utf-8", true), nil
+	})
+}
+
+// GetRoomOpenGraph returns an HTML page with the Open Graph metadata of
+// the listed public channel named by the :id parameter, for the web app to
+// hand to crawlers in place of its own page. Other rooms are not found.
+func GetRoomOpenGraph(c *gin.Context, pages *PublicPages) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "invalid room id"})
+		return
+	}
+	pages.serve(c, "og:room:"+id.String(), func(ctx context.Context) (publicPage, error) {
+		channels := repositories.NewChannelRepository(pages.db.DB)
+		channel, err := channels.Get(ctx, id)
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			return publicPage{}, err
+		}
+		if channel == nil || channel.IsPrivate || !channel.IsListed || channel.Conversation.WorkspaceID != models.DefaultWorkspaceID {
+			return newPublicPage([]byte("room not found\n"), "text/plain; charset=utf-8", false), nil
+		}
+		members, err := channels.MemberCount(ctx, id)
+		if err != nil {
+			return publicPage{}, err
+		}
+
+		description := channel.Description
+		if description == "" {
+			description = "Join " + channel.Name + " on AfroChat"
+		}
+		count := fmt.Sprintf("%d members", members)
+		if members == 1 {
+			count = "1 member"
+		}
+		var body bytes.Buffer
+		err = roomOpenGraph.Execute(&body, map[string]string{
+			"Title":       channel.Name,
+			"Description": description + " · " + count,
+			"URL":         pages.links.Room(channel.ConversationID),
+		})
+		if err != nil {
+			return publicPage{}, err
+		}
+		return newPublicPage(body.Bytes(), "text/html; charset=utf-8", true), nil
+	})
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/metrics"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// The message delivery SLIs. Dispatch covers the server's part: from a
+// message being stored to its message.new event being handed to every
+// member's connections and the realtime bus. Delivery covers the whole
+// path: from a message being stored to a recipient's device acknowledging
+// it with a delivered receipt. Push dispatch covers recipients who are
+// away: from a message being queued for pushes to the push service
+// answering for each device.
+var (
+	metricsRegistry = metrics.NewRegistry()
+
+	dispatchLatency = metricsRegistry.Histogram("afrochat_message_dispatch_seconds",
+		"Time from a message being stored to its event being handed to the members' connections.", metrics.LatencyBuckets)
+	deliveryLatency = metricsRegistry.Histogram("afrochat_message_delivery_seconds",
+		"Time from a message being stored to a connected recipient acknowledging it as delivered.", metrics.LatencyBuckets)
+	pushDispatchLatency = metricsRegistry.Histogram("afrochat_push_dispatch_seconds",
+		"Time from a message being queued for pushes to FCM, APNs or the Web Push service answering for a device.", metrics.LatencyBuckets)
+
+	dispatchSLO     = metricsRegistry.SLO("message_dispatch", 0.99, 250*time.Millisecond)
+	deliverySLO     = metricsRegistry.SLO("message_delivery", 0.99, 2*time.Second)
+	pushDispatchSLO = metricsRegistry.SLO("push_dispatch", 0.99, 5*time.Second)
+)
+
+func recordDispatch(sentAt time.Time) {
+	now := time.Now()
+	dispatchLatency.Observe(now.Sub(sentAt))
+	dispatchSLO.Record(now.Sub(sentAt), now)
+}
+
+// recordPushDispatch records the push service answering for one device,
+// whatever it answered, about a message queued at queuedAt.
+func recordPushDispatch(queuedAt time.Time) {
+	now := time.Now()
+	pushDispatchLatency.Observe(now.Sub(queuedAt))
+	pushDispatchSLO.Record(now.Sub(queuedAt), now)
+}
+
+// recordDelivery records a recipient acknowledging a message as delivered.
+// Only recipients who were connected to this instance since before the
+// message was sent count: a device catching up after being offline says
+// nothing about how quickly messages are delivered.
+func recordDelivery(hub *realtime.Hub, userID uuid.UUID, sentAt time.Time) {
+	since, online := hub.ConnectedSince(userID)
+	if !online || since.After(sentAt) {
+		return
+	}
+	now := time.Now()
+	deliveryLatency.Observe(now.Sub(sentAt))
+	deliverySLO.Record(now.Sub(sentAt), now)
+}
+
+// Metrics serves the delivery histograms and SLO burn rates in the
+// Prometheus text format, to scrapers presenting the metrics token.
+func Metrics(c *gin.Context, token string) {
+	if subtle.ConstantTimeCompare([]byte(BearerToken(c.Request)), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "invalid metrics token",
+		})
+		return
+	}
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	metricsRegistry.WritePrometheus(c.Writer, time.Now())
+}
+
+// SLOReport shows how the delivery SLOs have fared on this instance over
+// recent windows, and whether their burn rate is high enough to page.
+func SLOReport(c *gin.Context) {
+	now := time.Now()
+	slos := metricsRegistry.SLOs()
+	report := make([]metrics.SLOStatus, 0, len(slos))
+	for _, slo := range slos {
+		report = append(report, slo.Status(now))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"slos":   report,
+	})
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/strikes"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type strikeRequest struct {
+	Reason string `json:"reason" binding:"required,max=1000"`
+}
+
+// StrikeView is a strike as the account it was issued sees it, without
+// the moderator who issued it.
+type StrikeView struct {
+	ID        uuid.UUID `json:"id"`
+	Reason    string    `json:"reason"`
+	Active    bool      `json:"active"`
+	Suspended bool      `json:"suspended"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssuedStrike is a strike just issued, how many strikes the account has
+// counting, and the suspension they escalated to, if any.
+type IssuedStrike struct {
+	Strike        models.Strike            `json:"strike"`
+	ActiveStrikes int                      `json:"active_strikes"`
+	Suspension    *models.ModerationAction `json:"suspension,omitempty"`
+}
+
+// IssueStrike warns the account named by the :id parameter, with a strike
+// that counts against it until the policy's TTL passes. Reaching a step of
+// the policy suspends the account for that step's time, ending its
+// sessions. The account is told in its inbox. Staff cannot strike
+// themselves, and only admins can strike other staff.
+func IssueStrike(dbConnection *database.DatabaseConnection, hub *realtime.Hub, policy strikes.Policy) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		targetID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		var req strikeRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		var target models.User
+		if err := dbConnection.DB.WithContext(ctx).First(&target, "id = ?", targetID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, notFound("user not found")
+			}
+			slog.ErrorContext(ctx, "Failed to load user for strike", "user_id", targetID, "error", err)
+			return nil, internalError("failed to load user")
+		}
+		actor := CurrentUser(c)
+		if target.ID == actor.ID {
+			return nil, forbidden("you cannot moderate your own account")
+		}
+		if target.Role != models.RoleUser && actor.Role != models.RoleAdmin {
+			return nil, forbidden("only admins can moderate staff accounts")
+		}
+
+		strike := models.Strike{UserID: target.ID, ActorID: &actor.ID, Reason: req.Reason}
+		suspension, active, err := repositories.NewStrikeRepository(dbConnection.DB).Issue(ctx, &strike, policy)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("user not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to issue strike", "user_id", target.ID, "error", err)
+			return nil, internalError("failed to issue strike")
+		}
+
+		body := req.Reason + "\n\nThis strike counts against your account until " + strike.ExpiresAt.Format("2 January 2006") + "."
+		if suspension != nil {
+			body += " Your account is suspended until " + suspension.Until.Format("2 January 2006 15:04 MST") + "."
+		}
+		notifyInbox(ctx, dbConnection, hub, models.InboxNotification{
+			UserID: target.ID,
+			Kind:   models.InboxSystem,
+			Title:  "You received a strike",
+			Body:   body,
+		})
+		if suspension != nil {
+			hub.SignOut(target.ID, models.ModerationSuspend)
+			invalidateProfile(ctx, target.ID)
+		}
+
+		result := IssuedStrike{Strike: strike, ActiveStrikes: active, Suspension: suspension}
+		return &Response{Status: http.StatusCreated, Data: result, Legacy: gin.H{"strike": result.Strike, "active_strikes": result.ActiveStrikes, "suspension": result.Suspension}}, nil
+	}
+}
+
+// ListMyStrikes returns every strike issued the current user, newest
+// first, with whether each still counts.
+func ListMyStrikes(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		issued, err := repositories.NewStrikeRepository(dbConnection.DB).List(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list strikes", "error", err)
+			return nil, internalError("failed to list strikes")
+		}
+		now := time.Now()
+		views := make([]StrikeView, len(issued))
+		active := 0
+		for i, strike := range issued {
+			views[i] = StrikeView{
+				ID:        strike.ID,
+				Reason:    strike.Reason,
+				Active:    now.Before(strike.ExpiresAt),
+				Suspended: strike.SuspensionID != nil,
+				CreatedAt: strike.CreatedAt,
+				ExpiresAt: strike.ExpiresAt,
+			}
+			if views[i].Active {
+				active++
+			}
+		}
+		return &Response{Data: views, Legacy: gin.H{"strikes": views, "active_strikes": active}}, nil
+	}
+}
+
+// ListUserStrikes returns every strike issued the account named by the :id
+// parameter, newest first.
+func ListUserStrikes(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		issued, err := repositories.NewStrikeRepository(dbConnection.DB).List(c.Request.Context(), userID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list strikes", "user_id", userID, "error", err)
+			return nil, internalError("failed to list strikes")
+		}
+		return &Response{Data: issued, Legacy: gin.H{"strikes": issued}}, nil
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/suggest"
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"github.com/google/uuid"
+)
+
+const (
+	// suggestionQueueSize bounds the messages waiting for suggestions.
+	// Suggestions are a convenience, so when providers fall behind the
+	// newest are dropped.
+	suggestionQueueSize = 256
+
+	// suggestionTimeout gives up on suggestions that would arrive too
+	// long after the message to be useful.
+	suggestionTimeout = 5 * time.Second
+
+	suggestionWorkers = 2
+
+	eventMessageSuggestions = "message.suggestions"
+)
+
+// NewSuggestionProvider returns the reply suggestion provider the
+// configuration selects, or nil when smart replies are off.
+func NewSuggestionProvider(appConfig *config.ApplicationConfig) suggest.Provider {
+	if appConfig.SmartReplies == config.SmartRepliesCanned {
+		return suggest.Canned{}
+	}
+	return nil
+}
+
+// MessageSuggestions are replies offered to the recipient of a direct
+// message.
+type MessageSuggestions struct {
+	ConversationID uuid.UUID `json:"conversation_id"`
+	MessageID      uuid.UUID `json:"message_id"`
+	Suggestions    []string  `json:"suggestions"`
+}
+
+// Suggester offers reply suggestions to recipients of direct messages who
+// opted in, as a "message.suggestions" event following the message. Only
+// the message text reaches the provider, and suggestions are sent to the
+// recipient's connections alone: they are never stored, logged or pushed.
+// Like the Notifier it works in the background, so providers never hold
+// up sending.
+type Suggester struct {
+	dbConnection *database.DatabaseConnection
+	hub          *realtime.Hub
+	provider     suggest.Provider
+	workers      sync.WaitGroup
+
+	mu     sync.RWMutex
+	jobs   chan suggestionJob
+	closed bool
+}
+
+// suggestionJob is a message to suggest replies to, and the span of its
+// send, which the suggestions are traced under.
+type suggestionJob struct {
+	message *models.Message
+	trace   tracing.SpanContext
+}
+
+// NewSuggester returns a suggester using provider, which may be nil to
+// turn suggestions off.
+func NewSuggester(dbConnection *database.DatabaseConnection, hub *realtime.Hub, provider suggest.Provider) *Suggester {
+	return &Suggester{
+		dbConnection: dbConnection,
+		hub:          hub,
+		provider:     provider,
+		jobs:         make(chan suggestionJob, suggestionQueueSize),
+	}
+}
+
+// Start runs the workers until Shutdown.
+func (s *Suggester) Start() {
+	for range suggestionWorkers {
+		s.workers.Add(1)
+		go func() {
+			defer s.workers.Done()
+			for job := range s.jobs {
+				s.suggest(job)
+			}
+		}()
+	}
+}
+
+// Enqueue queues a newly sent message for suggestions. It never blocks,
+// and ignores messages no suggestions can be made for.
+func (s *Suggester) Enqueue(ctx context.Context, message *models.Message) {
+	if s.provider == nil || message.Type != string(content.TypeText) || message.Text == "" {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.jobs <- suggestionJob{message: message, trace: tracing.SpanContextFromContext(ctx)}:
+	default:
+	}
+}
+
+// Shutdown stops accepting messages and waits for the queued ones, or for
+// ctx to end.
+func (s *Suggester) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.jobs)
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("gave up on %d queued suggestions: %w", len(s.jobs), ctx.Err())
+	}
+}
+
+func (s *Suggester) suggest(job suggestionJob) {
+	message := job.message
+	ctx, cancel := context.WithTimeout(tracing.ContextWithRemoteParent(context.Background(), job.trace), suggestionTimeout)
+	defer cancel()
+	ctx, span := tracing.Start(ctx, "suggestions.suggest", tracing.KindInternal,
+		tracing.String("message_id", message.ID.String()))
+	defer span.End()
+
+	recipientID, ok, err := s.recipient(ctx, message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load message recipient for suggestions", "message_id", message.ID, "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	suggestions, err := s.provider.Suggest(ctx, message.Text)
+	if err != nil {
+		// The error may quote the text, so it is not logged.
+		slog.WarnContext(ctx, "Reply suggestion provider failed", "message_id", message.ID)
+		return
+	}
+	suggestions = suggest.Clean(suggestions)
+	if len(suggestions) == 0 {
+		return
+	}
+
+	event, err := realtime.NewEvent(eventMessageSuggestions, MessageSuggestions{
+		ConversationID: message.ConversationID,
+		MessageID:      message.ID,
+		Suggestions:    suggestions,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode suggestions", "message_id", message.ID, "error", err)
+		return
+	}
+	s.hub.SendToUser(recipientID, event)
+}
+
+// recipient returns the other member of the direct conversation a message
+// was sent in, if they opted in to suggestions.
+func (s *Suggester) recipient(ctx context.Context, message *models.Message) (uuid.UUID, bool, error) {
+	db := s.dbConnection.DB.WithContext(ctx)
+	var conversation models.Conversation
+	if err := db.First(&conversation, "id = ?", message.ConversationID).Error; err != nil {
+		return uuid.Nil, false, err
+	}
+	if conversation.Kind != models.ConversationDirect {
+		return uuid.Nil, false, nil
+	}
+
+	var recipients []models.User
+	err := db.Where("id IN (?)", db.Model(&models.ConversationMember{}).
+		Select("user_id").
+		Where("conversation_id = ? AND user_id <> ?", conversation.ID, message.SenderID)).
+		Where("smart_replies = ?", true).
+		Limit(1).
+		Find(&recipients).Error
+	if err != nil || len(recipients) == 0 {
+		return uuid.Nil, false, err
+	}
+	return recipients[0].ID, true, nil
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// syncConversationsPage is how many conversations an initial sync
+	// sends per call, each with a history snapshot.
+	syncConversationsPage = 20
+
+	// syncSnapshotSize is how many of a conversation's latest messages a
+	// snapshot holds. Older history is paged in with ListMessages.
+	syncSnapshotSize = 20
+
+	// syncMessagesPage is how many message changes an incremental sync
+	// sends per call.
+	syncMessagesPage = 200
+
+	// maxSyncAcks bounds the acks one call may record.
+	maxSyncAcks = 500
+
+	// eventSyncAck is sent by clients to acknowledge messages over the
+	// socket, as an alternative to AckSync.
+	eventSyncAck = "sync.ack"
+
+	// syncOverlap is subtracted from each watermark, so rows committed just
+	// after a sync with earlier timestamps are still picked up by the next.
+	// Clients may see a change twice and must apply them idempotently.
+	syncOverlap = 5 * time.Second
+)
+
+// SyncBatch is one response of the sync protocol. Clients upsert the
+// conversations, messages and receipts, drop the removed ones, replace
+// their pins with PinnedConversationIDs, store SyncToken for the next
+// call, and call again right away while HasMore is true.
+type SyncBatch struct {
+	Conversations         []models.Conversation   `json:"conversations"`
+	LeftConversationIDs   []uuid.UUID             `json:"left_conversation_ids"`
+	PinnedConversationIDs []uuid.UUID             `json:"pinned_conversation_ids"`
+	Messages              []models.Message        `json:"messages"`
+	DeletedMessageIDs     []uuid.UUID             `json:"deleted_message_ids"`
+	Receipts              []models.MessageReceipt `json:"receipts"`
+	SyncToken             string                  `json:"sync_token"`
+	HasMore               bool                    `json:"has_more"`
+}
+
+// syncToken is where a device is in the sync protocol. Clients treat its
+// encoded form as opaque.
+type syncToken struct {
+	// Since is the watermark: changes after it have not been sent yet.
+	Since time.Time `json:"since"`
+
+	// Conversations is set while an initial sync is still paging through
+	// conversations, and Messages while an incremental sync is still
+	// paging through message changes.
+	Conversations *pagination.Cursor `json:"conversations,omitempty"`
+	Messages      *pagination.Cursor `json:"messages,omitempty"`
+}
+
+func (t syncToken) encode() string {
+	raw, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeSyncToken(encoded string) (*syncToken, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, pagination.ErrInvalidCursor
+	}
+	var token syncToken
+	if err := json.Unmarshal(raw, &token); err != nil || token.Since.IsZero() {
+		return nil, pagination.ErrInvalidCursor
+	}
+	return &token, nil
+}
+
+// Sync brings a device's copy of the user's conversations up to date.
+//
+// A newly linked device calls it without a token for an initial sync: every
+// conversation the user belongs to, each with a snapshot of its latest
+// messages and its receipts. Afterwards it calls with since set to the
+// last sync_token it got and receives only what changed: conversations
+// that changed, were joined (with a snapshot) or whose members changed,
+// conversations left, messages sent, edited or deleted, and receipts
+// that moved. Every batch lists the user's pinned conversations in their
+// order. The token may also be sent as token, as older clients do.
+func Sync(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		token := &syncToken{Since: time.Now().Add(-syncOverlap)}
+		initial := true
+		encoded := c.Query("since")
+		if encoded == "" {
+			encoded = c.Query("token")
+		}
+		if encoded != "" {
+			var err error
+			if token, err = decodeSyncToken(encoded); err != nil {
+				return nil, badRequest("invalid sync token; start over with an initial sync")
+			}
+			initial = token.Conversations != nil
+		}
+
+		var batch *SyncBatch
+		var apiErr *APIError
+		if initial {
+			batch, apiErr = initialSync(c, dbConnection, token)
+		} else {
+			batch, apiErr = incrementalSync(c, dbConnection, token)
+		}
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		pinned, err := repositories.NewConversationRepository(dbConnection.DB).PinnedIDs(c.Request.Context(), CurrentWorkspaceID(c), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list pins for sync", "error", err)
+			return nil, internalError("failed to sync")
+		}
+		batch.PinnedConversationIDs = pinned
+
+		legacy := gin.H{
+			"conversations":           batch.Conversations,
+			"left_conversation_ids":   batch.LeftConversationIDs,
+			"pinned_conversation_ids": batch.PinnedConversationIDs,
+			"messages":                batch.Messages,
+			"deleted_message_ids":     batch.DeletedMessageIDs,
+			"receipts":                batch.Receipts,
+			"sync_token":              batch.SyncToken,
+			"has_more":                batch.HasMore,
+		}
+		return &Response{Data: batch, Legacy: legacy}, nil
+	}
+}
+
+// initialSync sends the next page of the user's conversations with their
+// snapshots. The watermark stays where the initial sync began, so the
+// incremental sync that follows covers everything that happened during it.
+func initialSync(c *gin.Context, dbConnection *database.DatabaseConnection, token *syncToken) (*SyncBatch, *APIError) {
+	ctx := c.Request.Context()
+	conversations, err := repositories.NewConversationRepository(dbConnection.DB).
+		ListForUser(ctx, CurrentWorkspaceID(c), CurrentUserID(c), repositories.ConversationFilter{}, token.Conversations, syncConversationsPage+1)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list conversations for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+
+	next := syncToken{Since: token.Since}
+	hasMore := len(conversations) > syncConversationsPage
+	if hasMore {
+		conversations = conversations[:syncConversationsPage]
+		cursor := repositories.ActivityCursor(&conversations[syncConversationsPage-1])
+		next.Conversations = &cursor
+	}
+
+	messages, err := syncSnapshots(c, dbConnection, conversations)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load sync snapshots", "error", err)
+		return nil, internalError("failed to sync")
+	}
+	ids := make([]uuid.UUID, 0, len(conversations))
+	for _, conversation := range conversations {
+		ids = append(ids, conversation.ID)
+	}
+	receipts, err := repositories.NewReceiptRepository(dbConnection.DB).ListIn(ctx, ids)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list receipts for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+	return &SyncBatch{
+		Conversations:       conversations,
+		LeftConversationIDs: []uuid.UUID{},
+		Messages:            messages,
+		DeletedMessageIDs:   []uuid.UUID{},
+		Receipts:            receipts,
+		SyncToken:           next.encode(),
+		HasMore:             hasMore,
+	}, nil
+}
+
+// incrementalSync sends what changed after the token's watermark.
+// Conversation and receipt changes are resent with every page of message
+// changes;
+// the watermark only advances once all message changes have been sent.
+func incrementalSync(c *gin.Context, dbConnection *database.DatabaseConnection, token *syncToken) (*SyncBatch, *APIError) {
+	ctx := c.Request.Context()
+	userID, workspaceID := CurrentUserID(c), CurrentWorkspaceID(c)
+	started := time.Now()
+	conversationRepo := repositories.NewConversationRepository(dbConnection.DB)
+
+	conversations, err := conversationRepo.ChangedForUser(ctx, workspaceID, userID, token.Since)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list changed conversations for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+	left, err := conversationRepo.LeftSince(ctx, workspaceID, userID, token.Since)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list left conversations for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+
+	receipts, err := repositories.NewReceiptRepository(dbConnection.DB).ChangedForUser(ctx, workspaceID, userID, token.Since)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list changed receipts for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+
+	after := pagination.Cursor{Time: token.Since}
+	if token.Messages != nil {
+		after = *token.Messages
+	}
+	changes, err := repositories.NewMessageRepository(dbConnection.DB).ListChanged(ctx, workspaceID, userID, after, syncMessagesPage+1)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list changed messages for sync", "error", err)
+		return nil, internalError("failed to sync")
+	}
+	hideShadowed(changes, userID)
+
+	next := syncToken{Since: started.Add(-syncOverlap)}
+	hasMore := len(changes) > syncMessagesPage
+	if hasMore {
+		changes = changes[:syncMessagesPage]
+		cursor := repositories.ChangeCursor(&changes[syncMessagesPage-1])
+		next = syncToken{Since: token.Since, Messages: &cursor}
+	}
+
+	batch := &SyncBatch{
+		Conversations:       conversations,
+		LeftConversationIDs: left,
+		Messages:            make([]models.Message, 0, len(changes)),
+		DeletedMessageIDs:   []uuid.UUID{},
+		Receipts:            receipts,
+		SyncToken:           next.encode(),
+		HasMore:             hasMore,
+	}
+	for _, message := range changes {
+		if message.DeletedAt.Valid {
+			batch.DeletedMessageIDs = append(batch.DeletedMessageIDs, message.ID)
+		} else {
+			batch.Messages = append(batch.Messages, message)
+		}
+	}
+
+	// Conversations joined since the watermark come with a snapshot, since
+	// their earlier history is not among the changes.
+	var joined []models.Conversation
+	for _, conversation := range conversations {
+		for _, member := range conversation.Members {
+			if member.UserID == userID && member.CreatedAt.After(token.Since) {
+				joined = append(joined, conversation)
+			}
+		}
+	}
+	snapshots, err := syncSnapshots(c, dbConnection, joined)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load sync snapshots", "error", err)
+		return nil, internalError("failed to sync")
+	}
+	batch.Messages = append(snapshots, batch.Messages...)
+	return batch, nil
+}
+
+// syncSnapshots returns the latest messages of each conversation, oldest
+// first within each.
+func syncSnapshots(c *gin.Context, dbConnection *database.DatabaseConnection, conversations []models.Conversation) ([]models.Message, error) {
+	messages := repositories.NewMessageRepository(dbConnection.DB)
+	snapshots := make([]models.Message, 0)
+	for _, conversation := range conversations {
+		latest, err := messages.ListBefore(c.Request.Context(), conversation.ID, nil, syncSnapshotSize)
+		if err != nil {
+			return nil, err
+		}
+		slices.Reverse(latest)
+		hideShadowed(latest, CurrentUserID(c))
+		snapshots = append(snapshots, latest...)
+	}
+	return snapshots, nil
+}
+
+type syncAckRequest struct {
+	Acks []syncAck `json:"acks" binding:"required,min=1,max=500,dive"`
+}
+
+type syncAck struct {
+	ConversationID uuid.UUID `json:"conversation_id" binding:"required"`
+	Seq            int64     `json:"seq" binding:"min=1"`
+}
+
+func (r syncAckRequest) acks() []models.SyncAck {
+	acks := make([]models.SyncAck, 0, len(r.Acks))
+	for _, ack := range r.Acks {
+		acks = append(acks, models.SyncAck{ConversationID: ack.ConversationID, Seq: ack.Seq})
+	}
+	return acks
+}
+
+// AckSync records, for the current device, the Seq of the latest message
+// it has received in each conversation. CatchUp then sends it what came
+// after. Acks only ever move forward.
+func AckSync(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		sessionID := CurrentClaims(c).Session()
+		if sessionID == uuid.Nil {
+			return nil, badRequest("acknowledging needs a signed-in device")
+		}
+		var req syncAckRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if err := repositories.NewSyncAckRepository(dbConnection.DB).Ack(c.Request.Context(), sessionID, CurrentUserID(c), req.acks()); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to record sync acks", "error", err)
+			return nil, internalError("failed to record acks")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// CatchUpBatch holds the messages a device has not acknowledged. Clients
+// apply them, acknowledge them and call again while HasMore is true.
+type CatchUpBatch struct {
+	Gaps     []repositories.SyncGap `json:"gaps"`
+	Messages []models.Message       `json:"messages"`
+	HasMore  bool                   `json:"has_more"`
+}
+
+// CatchUp returns, in sequence order, the messages after the current
+// device's acks in each conversation it has acknowledged, including
+// tombstones. Unlike Sync it depends on nothing the client keeps, so a
+// device that reconnects gets exactly what it missed, however long it was
+// away. Edits are not covered; Sync sends those.
+func CatchUp(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		ctx := c.Request.Context()
+		sessionID := CurrentClaims(c).Session()
+		if sessionID == uuid.Nil {
+			return nil, badRequest("catching up needs a signed-in device")
+		}
+		gaps, err := repositories.NewSyncAckRepository(dbConnection.DB).Behind(ctx, CurrentWorkspaceID(c), CurrentUserID(c), sessionID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list unacknowledged conversations", "error", err)
+			return nil, internalError("failed to catch up")
+		}
+
+		batch := CatchUpBatch{Gaps: make([]repositories.SyncGap, 0, len(gaps)), Messages: []models.Message{}}
+		messages := repositories.NewMessageRepository(dbConnection.DB)
+		for _, gap := range gaps {
+			remaining := syncMessagesPage - len(batch.Messages)
+			if remaining == 0 {
+				batch.HasMore = true
+				break
+			}
+			missed, err := messages.ListSeqRange(ctx, gap.ConversationID, gap.AckedSeq+1, gap.LastSeq, remaining)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to list missed messages", "conversation_id", gap.ConversationID, "error", err)
+				return nil, internalError("failed to catch up")
+			}
+			hideShadowed(missed, CurrentUserID(c))
+			batch.Gaps = append(batch.Gaps, gap)
+			batch.Messages = append(batch.Messages, missed...)
+			if int64(len(missed)) < gap.LastSeq-gap.AckedSeq {
+				batch.HasMore = true
+				break
+			}
+		}
+		legacy := gin.H{"gaps": batch.Gaps, "messages": batch.Messages, "has_more": batch.HasMore}
+		return &Response{Data: batch, Legacy: legacy}, nil
+	}
+}
+
+// ackOverSocket handles eventSyncAck, which carries the same body as
+// AckSync.
+func ackOverSocket(dbConnection *database.DatabaseConnection) realtime.HandlerFunc {
+	return func(ctx context.Context, client *realtime.Client, event realtime.Event) {
+		var req syncAckRequest
+		if err := json.Unmarshal(event.Data, &req); err != nil || len(req.Acks) == 0 || len(req.Acks) > maxSyncAcks {
+			replyError(client, event, "invalid ack payload")
+			return
+		}
+		for _, ack := range req.Acks {
+			if ack.ConversationID == uuid.Nil || ack.Seq < 1 {
+				replyError(client, event, "invalid ack payload")
+				return
+			}
+		}
+		if client.SessionID == uuid.Nil {
+			replyError(client, event, "acknowledging needs a signed-in device")
+			return
+		}
+		if err := repositories.NewSyncAckRepository(dbConnection.DB).Ack(ctx, client.SessionID, client.UserID, req.acks()); err != nil {
+			slog.ErrorContext(ctx, "Failed to record sync acks", "error", err)
+			replyError(client, event, "failed to record acks")
+			return
+		}
+		reply(client, event, eventSyncAck, nil)
+	}
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/capture"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/idgen"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fixtureNamespace names the IDs of test mode fixtures, which are derived
+// from the seed and the fixture's key.
+var fixtureNamespace = uuid.MustParse("5f0c7a52-8d7e-4b0e-9a31-6f3d2c1b7e44")
+
+// fixtureEpoch is when fixture messages were sent, a second apart, so
+// their IDs and times are the same on every run.
+var fixtureEpoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+type resetRequest struct {
+	Seed string `json:"seed" binding:"max=100"`
+}
+
+// fixtureRequest is a set of users, group rooms and messages for a test to
+// start from. Each has a key the others refer to it by and its ID is
+// derived from.
+type fixtureRequest struct {
+	Seed     string           `json:"seed" binding:"max=100"`
+	Users    []fixtureUser    `json:"users" binding:"dive"`
+	Rooms    []fixtureRoom    `json:"rooms" binding:"dive"`
+	Messages []fixtureMessage `json:"messages" binding:"dive"`
+}
+
+type fixtureUser struct {
+	Key         string `json:"key" binding:"required,max=100"`
+	Email       string `json:"email" binding:"required,email"`
+	Username    string `json:"username" binding:"required,max=50"`
+	DisplayName string `json:"display_name" binding:"max=100"`
+	Password    string `json:"password" binding:"required"`
+}
+
+type fixtureRoom struct {
+	Key     string   `json:"key" binding:"required,max=100"`
+	Title   string   `json:"title" binding:"max=100"`
+	Owner   string   `json:"owner" binding:"required"`
+	Members []string `json:"members"`
+}
+
+type fixtureMessage struct {
+	Key    string `json:"key" binding:"required,max=100"`
+	Room   string `json:"room" binding:"required"`
+	Sender string `json:"sender" binding:"required"`
+	Text   string `json:"text" binding:"required"`
+}
+
+// FixtureIDs maps the keys of loaded fixtures to their IDs.
+type FixtureIDs struct {
+	Users    map[string]uuid.UUID `json:"users"`
+	Rooms    map[string]uuid.UUID `json:"rooms"`
+	Messages map[string]uuid.UUID `json:"messages"`
+}
+
+// testModeAuthorized checks the test mode token, answering 401 when it is
+// missing or wrong.
+func testModeAuthorized(c *gin.Context, token string) bool {
+	if subtle.ConstantTimeCompare([]byte(BearerToken(c.Request)), []byte(token)) != 1 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "invalid test mode token",
+		})
+		return false
+	}
+	return true
+}
+
+// ListCaptures returns the email and pushes this instance captured in test
+// mode, oldest first. kind (email or push) and to (an address or device
+// token) narrow them down, and after skips those up to a known ID, for
+// tests to poll for what their last step sent.
+func ListCaptures(c *gin.Context, store *capture.Store, token string) {
+	if !testModeAuthorized(c, token) {
+		return
+	}
+	kind := c.Query("kind")
+	if kind != "" && kind != capture.KindEmail && kind != capture.KindPush {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "kind must be email or push",
+		})
+		return
+	}
+	var after int64
+	if raw := c.Query("after"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status": "error",
+				"error":  "invalid after",
+			})
+			return
+		}
+		after = parsed
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "success",
+		"captures": store.List(kind, c.Query("to"), after),
+	})
+}
+
+// ClearCaptures forgets everything captured in test mode, for a test to
+// start from a clean slate.
+func ClearCaptures(c *gin.Context, store *capture.Store, token string) {
+	if !testModeAuthorized(c, token) {
+		return
+	}
+	store.Clear()
+	c.Status(http.StatusNoContent)
+}
+
+// ResetTestData empties the database and forgets everything captured, for
+// a test to start from a clean slate, and seeds the IDs generated from
+// then on with the seed in the body, or the environment's TEST_MODE_SEED.
+func ResetTestData(c *gin.Context, dbConnection *database.DatabaseConnection, store *capture.Store, token, seed string) {
+	if !testModeAuthorized(c, token) {
+		return
+	}
+	var req resetRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid request body",
+		})
+		return
+	}
+	if req.Seed != "" {
+		seed = req.Seed
+	}
+	if err := dbConnection.Reset(c.Request.Context()); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to reset test data", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to reset test data",
+		})
+		return
+	}
+	store.Clear()
+	idgen.Seed(seed)
+	c.Status(http.StatusNoContent)
+}
+
+// LoadFixtures adds the users, group rooms and messages in the body to the
+// database. Their IDs are derived from the seed, or the environment's
+// TEST_MODE_SEED, and their keys, so the same fixtures get the same IDs on
+// every run; the IDs are returned by key all the same. Messages are stored
+// as they are, without the notifications sending them would cause.
+func LoadFixtures(c *gin.Context, dbConnection *database.DatabaseConnection, token, seed string) {
+	if !testModeAuthorized(c, token) {
+		return
+	}
+	var req fixtureRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid request body",
+		})
+		return
+	}
+	if req.Seed != "" {
+		seed = req.Seed
+	}
+
+	ids := FixtureIDs{Users: map[string]uuid.UUID{}, Rooms: map[string]uuid.UUID{}, Messages: map[string]uuid.UUID{}}
+	fixtureID := func(kind, key string) uuid.UUID {
+		return uuid.NewSHA1(fixtureNamespace, []byte(seed+"/"+kind+"/"+key))
+	}
+	user := func(key string) (uuid.UUID, error) {
+		id, ok := ids.Users[key]
+		if !ok {
+			return uuid.Nil, fmt.Errorf("unknown user %q", key)
+		}
+		return id, nil
+	}
+
+	var invalid error
+	err := dbConnection.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		for _, fixture := range req.Users {
+			hash, salt, err := auth.HashPassword(fixture.Password)
+			if err != nil {
+				return err
+			}
+			displayName := strings.TrimSpace(fixture.DisplayName)
+			if displayName == "" {
+				displayName = fixture.Username
+			}
+			record := models.User{
+				ID:           fixtureID("user", fixture.Key),
+				Email:        fixture.Email,
+				Username:     fixture.Username,
+				DisplayName:  displayName,
+				PasswordHash: hash,
+				Salt:         salt,
+				IsVerified:   true,
+			}
+			if err := tx.Create(&record).Error; err != nil {
+				return err
+			}
+			ids.Users[fixture.Key] = record.ID
+		}
+
+		for _, fixture := range req.Rooms {
+			ownerID, err := user(fixture.Owner)
+			if err != nil {
+				invalid = err
+				return err
+			}
+			room := models.Conversation{
+				ID:          fixtureID("room", fixture.Key),
+				WorkspaceID: models.DefaultWorkspaceID,
+				Kind:        models.ConversationGroup,
+				CreatedByID: ownerID,
+				Members:     []models.ConversationMember{{UserID: ownerID, Role: models.MemberRoleOwner, JoinedAt: fixtureEpoch}},
+			}
+			if title := strings.TrimSpace(fixture.Title); title != "" {
+				room.Title = &title
+			}
+			seen := map[uuid.UUID]bool{ownerID: true}
+			for _, key := range fixture.Members {
+				memberID, err := user(key)
+				if err != nil {
+					invalid = err
+					return err
+				}
+				if !seen[memberID] {
+					seen[memberID] = true
+					room.Members = append(room.Members, models.ConversationMember{UserID: memberID, Role: models.MemberRoleMember, JoinedAt: fixtureEpoch})
+				}
+			}
+			room.MemberCount = int64(len(room.Members))
+			if err := tx.Create(&room).Error; err != nil {
+				return err
+			}
+			if err := repositories.NewConversationSummaryRepository(tx).Sync(c.Request.Context(), []uuid.UUID{room.ID}); err != nil {
+				return err
+			}
+			ids.Rooms[fixture.Key] = room.ID
+		}
+
+		messages := repositories.NewMessageRepository(tx)
+		generator := idgen.NewSeededGenerator(seed)
+		for i, fixture := range req.Messages {
+			roomID, ok := ids.Rooms[fixture.Room]
+			if !ok {
+				invalid = fmt.Errorf("unknown room %q", fixture.Room)
+				return invalid
+			}
+			senderID, err := user(fixture.Sender)
+			if err != nil {
+				invalid = err
+				return err
+			}
+			sentAt := fixtureEpoch.Add(time.Duration(i) * time.Second)
+			message := models.Message{
+				ID:             generator.At(sentAt).UUID(),
+				ConversationID: roomID,
+				SenderID:       senderID,
+				Type:           string(content.TypeText),
+				Text:           fixture.Text,
+				CreatedAt:      sentAt,
+			}
+			if _, err := messages.Create(c.Request.Context(), &message, nil); err != nil {
+				return err
+			}
+			ids.Messages[fixture.Key] = message.ID
+		}
+		return nil
+	})
+	if invalid != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  invalid.Error(),
+		})
+		return
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "fixtures clash with existing data; reset first",
+		})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load fixtures", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load fixtures",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "success",
+		"ids":    ids,
+	})
+}
@@ -0,0 +1,232 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RateLimitWindow is the period requests-per-minute limits refill over.
+const RateLimitWindow = time.Minute
+
+// MessageLimitName is the rate limit shared by messages sent over HTTP and
+// over WebSocket.
+const MessageLimitName = "messages"
+
+// userTier returns the subscription tier of a user's account.
+func userTier(user *models.User) entitlements.Tier {
+	switch {
+	case user.AccountType == models.AccountTypeBusiness:
+		return entitlements.TierBusiness
+	case user.IsPremium:
+		return entitlements.TierPremium
+	}
+	return entitlements.TierFree
+}
+
+// userLimits loads the limits of a user who is not the request's current
+// user, such as the sender of a WebSocket message.
+func userLimits(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID) (entitlements.Limits, error) {
+	var user models.User
+	err := dbConnection.DB.WithContext(ctx).
+		Select("id", "account_type", "is_premium").
+		First(&user, "id = ?", userID).Error
+	if err != nil {
+		return entitlements.Limits{}, fmt.Errorf("failed to load user tier: %w", err)
+	}
+	return entitlements.For(userTier(&user)), nil
+}
+
+// TierRateLimit limits each user's requests per minute by their tier and
+// reports the state of the limit in X-RateLimit-* headers. It must run after
+// AuthMiddleware.
+func TierRateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := CurrentUser(c)
+		if user == nil {
+			c.Next()
+			return
+		}
+
+		limits := entitlements.For(userTier(user))
+		policy := ratelimit.Policy{Limit: limits.RequestsPerMinute, Period: RateLimitWindow}
+		if !checkRateLimit(c, limiter, "tier:"+user.ID.String(), policy) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// RateLimit limits requests to a route by policy, counted separately for
+// each key, such as ByClientIP. Limits with the same name share their
+// counts, so a route can share a limit with a WebSocket event. Its
+// X-RateLimit-* headers replace those of TierRateLimit, since the route's
+// limit is the stricter one.
+func RateLimit(limiter *ratelimit.Limiter, name string, policy ratelimit.Policy, key func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !checkRateLimit(c, limiter, name+":"+key(c), policy) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// ByClientIP keys rate limits by client address, for routes called before
+// signing in.
+func ByClientIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// maxPeekedBody bounds how much of a request body ByLoginEmail reads.
+const maxPeekedBody = 64 << 10
+
+// ByLoginEmail keys rate limits by the email address in a login request,
+// normalized as Login looks it up. The body is left for the handler to
+// read.
+func ByLoginEmail(c *gin.Context) string {
+	peeked, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPeekedBody))
+	c.Request.Body = readCloser{io.MultiReader(bytes.NewReader(peeked), c.Request.Body), c.Request.Body}
+	if err != nil {
+		return ""
+	}
+	var req struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(peeked, &req) != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// readCloser reads from a replayed body and closes the original.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ByUser keys rate limits by the current user. It must run after
+// AuthMiddleware.
+func ByUser(c *gin.Context) string {
+	return CurrentUserID(c).String()
+}
+
+// checkRateLimit spends a request from key's limit and reports it in
+// X-RateLimit-* headers. When the limit is used up it aborts with 429 and
+// returns false.
+func checkRateLimit(c *gin.Context, limiter *ratelimit.Limiter, key string, policy ratelimit.Policy) bool {
+	result := limiter.Allow(c.Request.Context(), key, policy)
+	c.Header("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+
+	if !result.Allowed {
+		retryAfter := int(math.Ceil(result.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+		abortWithError(c, tooManyRequests("rate limit exceeded; try again later"))
+		return false
+	}
+	return true
+}
+
+// roomQuota counts the groups and channels the user created today against
+// their tier's allowance.
+func roomQuota(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) (entitlements.Quota, error) {
+	start, reset := entitlements.Day(time.Now())
+	var used int64
+	err := dbConnection.DB.WithContext(ctx).Model(&models.Conversation{}).
+		Where("created_by_id = ? AND kind IN ? AND created_at >= ?",
+			user.ID, []string{models.ConversationGroup, models.ConversationChannel}, start).
+		Count(&used).Error
+	if err != nil {
+		return entitlements.Quota{}, fmt.Errorf("failed to count rooms created today: %w", err)
+	}
+	return entitlements.Quota{
+		Limit: entitlements.For(userTier(user)).RoomsPerDay,
+		Used:  int(used),
+		Reset: reset,
+	}, nil
+}
+
+// uploadQuota counts the uploads the user started today against their
+// tier's allowance.
+func uploadQuota(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) (entitlements.Quota, error) {
+	start, reset := entitlements.Day(time.Now())
+	used, err := repositories.NewAttachmentRepository(dbConnection.DB).CountSince(ctx, user.ID, start)
+	if err != nil {
+		return entitlements.Quota{}, err
+	}
+	return entitlements.Quota{
+		Limit: entitlements.For(userTier(user)).UploadsPerDay,
+		Used:  int(used),
+		Reset: reset,
+	}, nil
+}
+
+// checkRoomQuota responds with 429 and returns false when the current user
+// has used up today's room allowance.
+func checkRoomQuota(c *gin.Context, dbConnection *database.DatabaseConnection) (entitlements.Quota, bool) {
+	user := CurrentUser(c)
+	quota, err := roomQuota(c.Request.Context(), dbConnection, user)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to check room quota", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to check room quota",
+		})
+		return quota, false
+	}
+
+	if quota.Exhausted() {
+		setQuotaHeaders(c, "Rooms", quota)
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"status": "error",
+			"error":  fmt.Sprintf("daily limit of %d new groups and channels reached for the %s tier", quota.Limit, userTier(user)),
+		})
+		return quota, false
+	}
+	return quota, true
+}
+
+// checkRoomCap responds with 400 and returns false when a room created by
+// the current user with memberIDs would have more than cap members. A cap
+// of zero means no cap.
+func checkRoomCap(c *gin.Context, cap int, memberIDs []uuid.UUID) bool {
+	if cap == 0 {
+		return true
+	}
+	members := map[uuid.UUID]bool{CurrentUserID(c): true}
+	for _, memberID := range memberIDs {
+		members[memberID] = true
+	}
+	if len(members) > cap {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  fmt.Sprintf("rooms of this type may have at most %d members", cap),
+		})
+		return false
+	}
+	return true
+}
+
+// setQuotaHeaders reports a daily quota in X-Quota-<name>-* headers.
+func setQuotaHeaders(c *gin.Context, name string, quota entitlements.Quota) {
+	c.Header("X-Quota-"+name+"-Limit", strconv.Itoa(quota.Limit))
+	c.Header("X-Quota-"+name+"-Remaining", strconv.Itoa(quota.Remaining()))
+	c.Header("X-Quota-"+name+"-Reset", strconv.FormatInt(quota.Reset.Unix(), 10))
+}
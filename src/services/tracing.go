@@ -0,0 +1,55 @@
+package services
+
+import (
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// TraceparentHeader carries W3C trace context between services.
+const TraceparentHeader = "traceparent"
+
+// Tracing records a server span for each request, continuing the trace of
+// the caller's traceparent header when it sends one. Database queries and
+// the background work the request leads to are recorded beneath it, and
+// the response's traceparent names the request's span.
+func Tracing() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if parent, ok := tracing.ParseTraceparent(c.GetHeader(TraceparentHeader)); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+		// Unmatched paths are named by method alone, so probes for random
+		// paths do not each make a span name of their own.
+		route := c.FullPath()
+		name := c.Request.Method
+		if route != "" {
+			name += " " + route
+		}
+		ctx, span := tracing.Start(ctx, name, tracing.KindServer,
+			tracing.String("http.request.method", c.Request.Method),
+			tracing.String("http.route", route),
+			tracing.String("url.path", c.Request.URL.Path),
+			tracing.String("user_agent.original", c.Request.UserAgent()))
+		if span == nil {
+			c.Next()
+			return
+		}
+		defer span.End()
+		c.Header(TraceparentHeader, span.Context().Traceparent())
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(tracing.Int("http.response.status_code", int64(status)))
+		if userID := CurrentUserID(c); userID != uuid.Nil {
+			span.SetAttributes(tracing.String("enduser.id", userID.String()))
+		}
+		if status >= http.StatusInternalServerError {
+			span.Fail(http.StatusText(status))
+		}
+	}
+}
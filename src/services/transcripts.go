@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/pagination"
+	"github.com/dfunani/AfroChat/backend/pkg/pdf"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	JobTranscript = "transcript"
+
+	// transcriptTTL is how long a rendered transcript can be downloaded.
+	// It is short because it copies other members' messages, which they
+	// may delete in the meantime.
+	transcriptTTL         = 24 * time.Hour
+	transcriptContentType = "application/pdf"
+
+	// maxTranscriptMessages bounds a transcript; longer histories are cut
+	// off after the oldest this many.
+	maxTranscriptMessages = 20000
+
+	transcriptTimeLayout = "2 Jan 2006 15:04"
+)
+
+type transcriptJob struct {
+	TranscriptID uuid.UUID `json:"transcript_id"`
+}
+
+// RequestTranscript starts rendering a PDF transcript of a conversation
+// the current user is a member of, in the background. It answers 202 with
+// the pending transcript, which GetTranscript reports on; a request while
+// one is being rendered returns that one.
+func RequestTranscript(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		conversation, apiErr := memberConversation(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		var transcript *models.Transcript
+		err := dbConnection.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			transcripts := repositories.NewTranscriptRepository(tx)
+			pending, err := transcripts.Pending(ctx, conversation.ID, userID)
+			if err == nil {
+				transcript = pending
+				return nil
+			}
+			if !errors.Is(err, repositories.ErrNotFound) {
+				return err
+			}
+
+			transcript = &models.Transcript{ConversationID: conversation.ID, UserID: userID}
+			if err := transcripts.Create(ctx, transcript); err != nil {
+				return err
+			}
+			_, err = repositories.NewJobRepository(tx).Enqueue(ctx, JobTranscript, transcriptJob{TranscriptID: transcript.ID})
+			return err
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to request transcript", "conversation_id", conversation.ID, "error", err)
+			return nil, internalError("failed to request transcript")
+		}
+		return &Response{Status: http.StatusAccepted, Data: transcript, Legacy: gin.H{"transcript": transcript}}, nil
+	}
+}
+
+// GetTranscript reports on one of the current user's transcripts of the
+// conversation and, once it is ready, returns a short-lived signed URL to
+// download it.
+func GetTranscript(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		transcript, apiErr := loadTranscript(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		data := gin.H{"transcript": transcript}
+		if transcript.Status != models.TranscriptReady {
+			return &Response{Data: data, Legacy: data}, nil
+		}
+
+		download, err := store.PresignGet(c.Request.Context(), *transcript.StorageKey, presignExpiry)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			download = &storage.PresignedRequest{
+				Method: http.MethodGet,
+				URL:    "/api/v1/conversations/" + transcript.ConversationID.String() + "/transcripts/" + transcript.ID.String() + "/content",
+			}
+		} else if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to presign transcript download", "transcript_id", transcript.ID, "error", err)
+			return nil, internalError("failed to load transcript")
+		}
+		data["download"] = download
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// DownloadTranscript streams a ready transcript through the API, for
+// backends without presigned URLs.
+func DownloadTranscript(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage) {
+	transcript, apiErr := loadTranscript(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if transcript.Status != models.TranscriptReady {
+		abortWithError(c, conflict("the transcript is not ready"))
+		return
+	}
+
+	body, err := store.Open(c.Request.Context(), *transcript.StorageKey)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to open transcript", "transcript_id", transcript.ID, "error", err)
+		abortWithError(c, internalError("failed to load transcript"))
+		return
+	}
+	defer body.Close()
+
+	c.DataFromReader(http.StatusOK, transcript.SizeBytes, transcriptContentType, body, map[string]string{
+		"Content-Disposition": `attachment; filename="afrochat-transcript-` + transcript.ConversationID.String() + `.pdf"`,
+		"Cache-Control":       "no-store",
+	})
+}
+
+// loadTranscript loads the transcript named by the transcriptId path
+// parameter, which must be the current user's, of the conversation they
+// are still a member of, and not expired.
+func loadTranscript(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Transcript, *APIError) {
+	conversation, apiErr := memberConversation(c, dbConnection)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	id, err := uuid.Parse(c.Param("transcriptId"))
+	if err != nil {
+		return nil, badRequest("invalid transcript id")
+	}
+	transcript, err := repositories.NewTranscriptRepository(dbConnection.DB).GetForUser(c.Request.Context(), conversation.ID, CurrentUserID(c), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("transcript not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load transcript", "transcript_id", id, "error", err)
+		return nil, internalError("failed to load transcript")
+	}
+	if transcript.ExpiresAt != nil && time.Now().After(*transcript.ExpiresAt) {
+		return nil, notFound("transcript has expired; request a new one")
+	}
+	return transcript, nil
+}
+
+// RenderTranscript is the job rendering a transcript and storing it in
+// the requester's residency region. Earlier transcripts of theirs of the
+// same conversation are removed once it is ready.
+func RenderTranscript(dbConnection *database.DatabaseConnection, store storage.Storage) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload transcriptJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		transcripts := repositories.NewTranscriptRepository(dbConnection.DB)
+		transcript, err := transcripts.Get(ctx, payload.TranscriptID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			// The conversation or the account went in the meantime.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if transcript.Status != models.TranscriptPending {
+			return nil
+		}
+
+		err = renderTranscript(ctx, dbConnection, store, transcripts, transcript)
+		if err != nil && finalAttempt(job) {
+			if err := transcripts.MarkFailed(ctx, transcript.ID); err != nil {
+				slog.ErrorContext(ctx, "Failed to mark transcript failed", "transcript_id", transcript.ID, "error", err)
+			}
+		}
+		return err
+	}
+}
+
+func renderTranscript(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, transcripts *repositories.TranscriptRepository, transcript *models.Transcript) error {
+	db := dbConnection.DB.WithContext(ctx)
+	var requester models.User
+	if err := db.First(&requester, "id = ?", transcript.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load requester: %w", err)
+	}
+	conversation, err := repositories.NewConversationRepository(dbConnection.DB).Get(ctx, transcript.ConversationID)
+	if err != nil {
+		return err
+	}
+	if !hasMember(conversation, requester.ID) {
+		// They left before it was rendered.
+		return transcripts.MarkFailed(ctx, transcript.ID)
+	}
+	zone, err := time.LoadLocation(requester.TimeZone)
+	if err != nil {
+		zone = time.UTC
+	}
+
+	file, err := os.CreateTemp("", "afrochat-transcript-*.pdf")
+	if err != nil {
+		return fmt.Errorf("failed to create transcript file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	doc, written, err := writeTranscript(ctx, dbConnection, conversation, requester.ID, zone)
+	if err != nil {
+		return err
+	}
+	size, err := doc.WriteTo(file)
+	if err != nil {
+		return fmt.Errorf("failed to write transcript file: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind transcript file: %w", err)
+	}
+
+	key := storage.ResidentKey(store, residency.Region(requester.Residency), "transcripts/"+requester.ID.String()+"/"+transcript.ID.String())
+	if err := store.Put(ctx, key, file, size, transcriptContentType); err != nil {
+		return fmt.Errorf("failed to store transcript: %w", err)
+	}
+	pruned, err := transcripts.MarkReady(ctx, transcript, key, size, written, transcriptTTL)
+	if err != nil {
+		deleteObject(store, key)
+		return err
+	}
+	for _, old := range pruned {
+		if old.StorageKey != nil {
+			deleteObject(store, *old.StorageKey)
+		}
+	}
+	return nil
+}
+
+// writeTranscript sets the conversation's history, oldest first, as
+// viewerID sees it: deleted messages are left out, as are shadowed ones
+// unless they sent them. Each message is headed by its sender's name and
+// its time in zone, with its attachments listed under it by name; the
+// files themselves stay behind the attachment endpoints. It returns the
+// document and how many messages it holds.
+func writeTranscript(ctx context.Context, dbConnection *database.DatabaseConnection, conversation *models.Conversation, viewerID uuid.UUID, zone *time.Location) (*pdf.Document, int, error) {
+	now := time.Now()
+	title := "Conversation"
+	if conversation.Title != nil && *conversation.Title != "" {
+		title = *conversation.Title
+	}
+	doc := pdf.New(title+" transcript", now)
+	doc.Write(pdf.Bold, 16, title)
+	doc.Write(pdf.Regular, 9, "Transcript exported "+now.In(zone).Format(transcriptTimeLayout+" MST"))
+	doc.Gap(12)
+
+	messages := repositories.NewMessageRepository(dbConnection.DB)
+	names := make(map[uuid.UUID]string)
+	var after pagination.Cursor
+	written := 0
+	for written < maxTranscriptMessages {
+		batch, err := messages.ListAfter(ctx, conversation.ID, after, exportBatchSize)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := loadSenderNames(ctx, dbConnection, batch, names); err != nil {
+			return nil, 0, err
+		}
+		for i := range batch {
+			message := &batch[i]
+			if message.DeletedAt.Valid || (message.Shadowed && message.SenderID != viewerID) {
+				continue
+			}
+			if written == maxTranscriptMessages {
+				break
+			}
+			writeTranscriptMessage(doc, message, names[message.SenderID], zone)
+			written++
+		}
+		if len(batch) < exportBatchSize {
+			return doc, written, nil
+		}
+		last := batch[len(batch)-1]
+		after = pagination.Cursor{Time: last.CreatedAt, ID: last.ID}
+	}
+	doc.Write(pdf.Oblique, 9, fmt.Sprintf("The transcript stops after the first %d messages.", maxTranscriptMessages))
+	return doc, written, nil
+}
+
+func writeTranscriptMessage(doc *pdf.Document, message *models.Message, sender string, zone *time.Location) {
+	doc.Write(pdf.Bold, 10, sender+" · "+message.CreatedAt.In(zone).Format(transcriptTimeLayout))
+	switch {
+	case message.Type == string(content.TypeEncrypted):
+		doc.Write(pdf.Oblique, 10, "[End-to-end encrypted message]")
+	case message.Type != string(content.TypeText):
+		doc.Write(pdf.Oblique, 10, "["+message.Type+" message]")
+	case message.Text != "":
+		doc.Write(pdf.Regular, 10, message.Text)
+	}
+	for _, attachment := range message.Attachments {
+		switch {
+		case attachment.ViewOnce:
+			doc.Write(pdf.Oblique, 9, "[View-once "+strings.ToLower(attachmentLabel(attachment.Kind))+"]")
+		case attachment.Encrypted:
+			doc.Write(pdf.Oblique, 9, "[Encrypted "+strings.ToLower(attachmentLabel(attachment.Kind))+"]")
+		default:
+			doc.Write(pdf.Oblique, 9, "["+attachmentLabel(attachment.Kind)+": "+attachment.FileName+", /api/v1/uploads/"+attachment.ID.String()+"]")
+		}
+	}
+	if message.EditedAt != nil {
+		doc.Write(pdf.Oblique, 8, "(edited)")
+	}
+	doc.Gap(6)
+}
+
+func attachmentLabel(kind string) string {
+	switch content.AttachmentKind(kind) {
+	case content.AttachmentImage:
+		return "Image"
+	case content.AttachmentAudio:
+		return "Audio"
+	case content.AttachmentVideo:
+		return "Video"
+	}
+	return "File"
+}
+
+// loadSenderNames adds the display names of the senders of messages that
+// names lacks, erased accounts included.
+func loadSenderNames(ctx context.Context, dbConnection *database.DatabaseConnection, messages []models.Message, names map[uuid.UUID]string) error {
+	var missing []uuid.UUID
+	for _, message := range messages {
+		if _, ok := names[message.SenderID]; !ok {
+			names[message.SenderID] = ""
+			missing = append(missing, message.SenderID)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	var senders []models.User
+	err := dbConnection.DB.WithContext(ctx).Unscoped().
+		Select("id", "display_name", "username").
+		Where("id IN ?", missing).
+		Find(&senders).Error
+	if err != nil {
+		return fmt.Errorf("failed to load senders: %w", err)
+	}
+	for _, sender := range senders {
+		names[sender.ID] = sender.DisplayName
+		if names[sender.ID] == "" {
+			names[sender.ID] = sender.Username
+		}
+	}
+	return nil
+}
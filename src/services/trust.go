@@ -0,0 +1,183 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// sentHistoryTTL is how long a user's counted message history is
+	// reused for trust checks, so the whole history is not counted on
+	// every message with a link or attachment. A user crossing a level's
+	// thresholds may wait this long to be promoted.
+	sentHistoryTTL = 5 * time.Minute
+
+	// maxSentHistories bounds the histories remembered for sentHistoryTTL.
+	maxSentHistories = 10_000
+)
+
+// sentHistory is how many messages a user has sent, and on how many days,
+// as counted at a time.
+type sentHistory struct {
+	messages  int
+	days      int
+	countedAt time.Time
+}
+
+// sentHistories remembers the message histories counted for trust checks.
+// It is per instance, like the other caches of the send path.
+type sentHistories struct {
+	mu      sync.Mutex
+	counted map[uuid.UUID]sentHistory
+}
+
+var sentCounts = sentHistories{counted: make(map[uuid.UUID]sentHistory)}
+
+// get returns the user's history if it was counted within sentHistoryTTL.
+func (s *sentHistories) get(userID uuid.UUID, now time.Time) (sentHistory, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	history, ok := s.counted[userID]
+	if !ok || now.Sub(history.countedAt) >= sentHistoryTTL {
+		return sentHistory{}, false
+	}
+	return history, true
+}
+
+func (s *sentHistories) put(userID uuid.UUID, history sentHistory) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.counted) >= maxSentHistories {
+		for other, counted := range s.counted {
+			if history.countedAt.Sub(counted.countedAt) >= sentHistoryTTL {
+				delete(s.counted, other)
+			}
+		}
+		if len(s.counted) >= maxSentHistories {
+			s.counted = make(map[uuid.UUID]sentHistory)
+		}
+	}
+	s.counted[userID] = history
+}
+
+// checkTrust returns an error wrapping trust.ErrInsufficientTrust when the
+// user's trust level is below what capability needs under the configured
+// policy. Staff and bots are not held to the policy.
+func checkTrust(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID, capability trust.Capability) error {
+	policy := trust.Current()
+	if policy.Capabilities[capability] == trust.LevelNew {
+		return nil
+	}
+
+	var user models.User
+	err := dbConnection.DB.WithContext(ctx).
+		Select("id", "role", "account_type", "is_verified", "created_at").
+		First(&user, "id = ?", userID).Error
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if user.AccountType == models.AccountTypeBot || user.Role == models.RoleAdmin || user.Role == models.RoleModerator {
+		return nil
+	}
+	activity, err := trustActivity(ctx, dbConnection, &user)
+	if err != nil {
+		return err
+	}
+	return policy.Check(activity, capability, time.Now())
+}
+
+// trustActivity loads the account history a user's trust level is computed
+// from. Suspensions count as upheld reports. They are counted every time,
+// so a suspension demotes at once, while the messages sent are counted
+// at most once per sentHistoryTTL.
+func trustActivity(ctx context.Context, dbConnection *database.DatabaseConnection, user *models.User) (trust.Activity, error) {
+	db := dbConnection.DB.WithContext(ctx)
+	now := time.Now()
+	sent, ok := sentCounts.get(user.ID, now)
+	if !ok {
+		var counted struct {
+			Messages int
+			Days     int
+		}
+		err := db.Model(&models.Message{}).
+			Select("COUNT(*) AS messages, COUNT(DISTINCT DATE(created_at)) AS days").
+			Where("sender_id = ?", user.ID).
+			Scan(&counted).Error
+		if err != nil {
+			return trust.Activity{}, fmt.Errorf("failed to count messages sent: %w", err)
+		}
+		sent = sentHistory{messages: counted.Messages, days: counted.Days, countedAt: now}
+		sentCounts.put(user.ID, sent)
+	}
+	var suspensions int64
+	err := db.Model(&models.ModerationAction{}).
+		Where("user_id = ? AND action = ?", user.ID, models.ModerationSuspend).
+		Count(&suspensions).Error
+	if err != nil {
+		return trust.Activity{}, fmt.Errorf("failed to count suspensions: %w", err)
+	}
+	return trust.Activity{
+		CreatedAt:     user.CreatedAt,
+		MessagesSent:  sent.messages,
+		DaysActive:    sent.days,
+		ReportsUpheld: int(suspensions),
+		VerifiedEmail: user.IsVerified,
+	}, nil
+}
+
+// checkMessageTrust checks that the sender may post what a message
+// carries: attachments and documents need CapabilityPostMedia, and links
+// in its text or payload CapabilityPostLinks. In channels, either also
+// needs the reputation there checkRoomReputation asks for.
+func checkMessageTrust(ctx context.Context, dbConnection *database.DatabaseConnection, senderID, conversationID uuid.UUID, input messageInput) error {
+	media := len(input.AttachmentIDs) > 0 || input.Type == content.TypeDocument
+	if media {
+		if err := checkTrust(ctx, dbConnection, senderID, trust.CapabilityPostMedia); err != nil {
+			return err
+		}
+	}
+	links := trust.HasLink(input.Text) || trust.HasLink(string(input.Payload))
+	if links {
+		if err := checkTrust(ctx, dbConnection, senderID, trust.CapabilityPostLinks); err != nil {
+			return err
+		}
+	}
+	if media || links {
+		return checkRoomReputation(ctx, dbConnection, senderID, conversationID)
+	}
+	return nil
+}
+
+// trustError turns a failed trust check into the response for it.
+func trustError(ctx context.Context, err error) *APIError {
+	if errors.Is(err, trust.ErrInsufficientTrust) {
+		return forbidden(err.Error())
+	}
+	slog.ErrorContext(ctx, "Failed to check trust level", "error", err)
+	return internalError("failed to check trust level")
+}
+
+// checkRoomTrust responds with 403 and returns false when the current user
+// may not create rooms yet.
+func checkRoomTrust(c *gin.Context, dbConnection *database.DatabaseConnection) bool {
+	if err := checkTrust(c.Request.Context(), dbConnection, CurrentUserID(c), trust.CapabilityCreateRoom); err != nil {
+		apiErr := trustError(c.Request.Context(), err)
+		c.JSON(apiErr.Status, gin.H{
+			"status": "error",
+			"error":  apiErr.Message,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,557 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/residency"
+	"github.com/dfunani/AfroChat/backend/pkg/storage"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	presignExpiry = 15 * time.Minute
+
+	// viewOnceTokenTTL is how long a recipient has to download a view-once
+	// attachment with the token they were issued.
+	viewOnceTokenTTL = 5 * time.Minute
+
+	// sniffLen is how much of a file http.DetectContentType looks at.
+	sniffLen = 512
+
+	// encryptedMimeType is what encrypted attachments are stored and served
+	// as, whatever the file inside.
+	encryptedMimeType = "application/octet-stream"
+)
+
+// NewStorage creates the attachment storage backend named by the config,
+// keeping each residency region's objects in its own bucket when the
+// config names them.
+func NewStorage(appConfig *config.ApplicationConfig) (storage.Storage, error) {
+	defaultBucket := ""
+	switch appConfig.StorageBackend {
+	case config.StorageS3:
+		defaultBucket = appConfig.S3Bucket
+	case config.StorageGCS:
+		defaultBucket = appConfig.GCSBucket
+	}
+	if len(appConfig.StorageRegionBuckets) == 0 {
+		return openBucket(appConfig, defaultBucket)
+	}
+	policy := residency.Policy{Default: defaultBucket, Buckets: appConfig.StorageRegionBuckets}
+	return storage.NewRegional(policy, func(bucket string) (storage.Storage, error) {
+		return openBucket(appConfig, bucket)
+	})
+}
+
+// openBucket opens one bucket of the configured backend. Local storage
+// keeps buckets other than the default in directories of their own.
+func openBucket(appConfig *config.ApplicationConfig, bucket string) (storage.Storage, error) {
+	switch appConfig.StorageBackend {
+	case config.StorageLocal:
+		return storage.NewLocal(filepath.Join(appConfig.StorageLocalDir, bucket))
+	case config.StorageS3:
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  appConfig.S3Endpoint,
+			Region:    appConfig.S3Region,
+			Bucket:    bucket,
+			AccessKey: appConfig.S3AccessKey,
+			SecretKey: appConfig.S3SecretKey,
+			UseSSL:    appConfig.S3UseSSL,
+		})
+	case config.StorageGCS:
+		return storage.NewGCS(storage.GCSConfig{
+			Bucket:          bucket,
+			CredentialsFile: appConfig.GCSCredentialsFile,
+			Endpoint:        appConfig.GCSEndpoint,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", appConfig.StorageBackend)
+}
+
+type presignUploadRequest struct {
+	FileName  string `json:"file_name" binding:"required_without=Encrypted"`
+	MimeType  string `json:"mime_type" binding:"required_without=Encrypted"`
+	SizeBytes int64  `json:"size_bytes" binding:"required_without=Encrypted"`
+
+	// Encrypted, in place of the fields above, starts the upload of a
+	// client-encrypted file for an end-to-end encrypted conversation.
+	Encrypted *content.EncryptedManifest `json:"encrypted"`
+}
+
+// CreateUpload starts an attachment upload. A multipart/form-data request
+// carries the file in its "file" field and is stored immediately. A JSON
+// request describes the file and gets back a presigned URL to PUT it to,
+// after which the client calls CompleteUpload; backends without presigned
+// URLs only accept multipart. Accounts below the trust level for posting
+// media cannot upload.
+//
+// Files a client encrypted for an end-to-end encrypted conversation come
+// with a manifest instead of a name and type, in the "manifest" field of a
+// multipart request or the "encrypted" field of a JSON one. The server
+// checks them against the manifest's limits and never looks inside.
+func CreateUpload(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		user := CurrentUser(c)
+		if err := checkTrust(c.Request.Context(), dbConnection, user.ID, trust.CapabilityPostMedia); err != nil {
+			return nil, trustError(c.Request.Context(), err)
+		}
+		quota, err := uploadQuota(c.Request.Context(), dbConnection, user)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to check upload quota", "error", err)
+			return nil, internalError("failed to check upload quota")
+		}
+		if quota.Exhausted() {
+			setQuotaHeaders(c, "Uploads", quota)
+			return nil, tooManyRequests(fmt.Sprintf("daily limit of %d uploads reached for the %s tier", quota.Limit, userTier(user)))
+		}
+
+		var response *Response
+		var apiErr *APIError
+		if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType == "multipart/form-data" {
+			response, apiErr = storeMultipartUpload(c, dbConnection, store, user)
+		} else {
+			response, apiErr = presignUpload(c, dbConnection, store, user)
+		}
+		if apiErr == nil {
+			quota.Used++
+			setQuotaHeaders(c, "Uploads", quota)
+		}
+		return response, apiErr
+	}
+}
+
+func storeMultipartUpload(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage, user *models.User) (*Response, *APIError) {
+	// Bound the body before parsing; the per-kind limit is checked below.
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, content.MaxVideoBytes+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, badRequest("a file field is required")
+	}
+	if raw := c.PostForm("manifest"); raw != "" {
+		var manifest content.EncryptedManifest
+		if err := json.Unmarshal([]byte(raw), &manifest); err != nil {
+			return nil, badRequest("invalid manifest")
+		}
+		return storeEncryptedUpload(c, dbConnection, store, user, header, &manifest)
+	}
+
+	attachment, apiErr := storeFile(c.Request.Context(), dbConnection, store, user, header)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &Response{Status: http.StatusCreated, Data: attachment, Legacy: gin.H{"attachment": attachment}}, nil
+}
+
+// storeFile checks an uploaded file against the limits of its kind and its
+// sniffed content type, and stores it as a ready attachment of user.
+func storeFile(ctx context.Context, dbConnection *database.DatabaseConnection, store storage.Storage, user *models.User, header *multipart.FileHeader) (*models.Attachment, *APIError) {
+	upload := content.Upload{
+		FileName:  header.Filename,
+		MimeType:  header.Header.Get("Content-Type"),
+		SizeBytes: header.Size,
+	}
+	kind, err := upload.Validate()
+	if err != nil {
+		return nil, badRequest(err.Error())
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, internalError("failed to read upload")
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, internalError("failed to read upload")
+	}
+	if err := upload.CheckSniffed(http.DetectContentType(head[:n])); err != nil {
+		return nil, badRequest(err.Error())
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, internalError("failed to read upload")
+	}
+
+	attachment := newAttachment(store, user, kind, upload, models.AttachmentReady)
+	if err := store.Put(ctx, attachment.StorageKey, file, upload.SizeBytes, upload.MimeType); err != nil {
+		slog.ErrorContext(ctx, "Failed to store upload", "error", err)
+		return nil, internalError("failed to store upload")
+	}
+	if err := repositories.NewAttachmentRepository(dbConnection.DB).Create(ctx, attachment); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload", "error", err)
+		deleteObject(store, attachment.StorageKey)
+		return nil, internalError("failed to store upload")
+	}
+	return attachment, nil
+}
+
+// storeEncryptedUpload stores a client-encrypted file sent with its
+// manifest. Its size must match the manifest; nothing else can be checked.
+func storeEncryptedUpload(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage, user *models.User, header *multipart.FileHeader, manifest *content.EncryptedManifest) (*Response, *APIError) {
+	if err := manifest.Validate(); err != nil {
+		return nil, badRequest(err.Error())
+	}
+	if header.Size != manifest.SizeBytes {
+		return nil, badRequest(fmt.Sprintf("the file is %d bytes but its manifest declared %d", header.Size, manifest.SizeBytes))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return nil, internalError("failed to read upload")
+	}
+	defer file.Close()
+
+	attachment := newEncryptedAttachment(store, user, manifest, models.AttachmentReady)
+	ctx := c.Request.Context()
+	if err := store.Put(ctx, attachment.StorageKey, file, attachment.SizeBytes, attachment.MimeType); err != nil {
+		slog.ErrorContext(ctx, "Failed to store upload", "error", err)
+		return nil, internalError("failed to store upload")
+	}
+	if err := repositories.NewAttachmentRepository(dbConnection.DB).Create(ctx, attachment); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload", "error", err)
+		deleteObject(store, attachment.StorageKey)
+		return nil, internalError("failed to store upload")
+	}
+	return &Response{Status: http.StatusCreated, Data: attachment, Legacy: gin.H{"attachment": attachment}}, nil
+}
+
+func presignUpload(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage, user *models.User) (*Response, *APIError) {
+	var req presignUploadRequest
+	if apiErr := bindJSON(c, &req); apiErr != nil {
+		return nil, apiErr
+	}
+
+	var attachment *models.Attachment
+	if req.Encrypted != nil {
+		if err := req.Encrypted.Validate(); err != nil {
+			return nil, badRequest(err.Error())
+		}
+		attachment = newEncryptedAttachment(store, user, req.Encrypted, models.AttachmentPending)
+	} else {
+		upload := content.Upload{FileName: req.FileName, MimeType: req.MimeType, SizeBytes: req.SizeBytes}
+		kind, err := upload.Validate()
+		if err != nil {
+			return nil, badRequest(err.Error())
+		}
+		attachment = newAttachment(store, user, kind, upload, models.AttachmentPending)
+	}
+	ctx := c.Request.Context()
+	request, err := store.PresignPut(ctx, attachment.StorageKey, attachment.MimeType, presignExpiry)
+	if errors.Is(err, storage.ErrPresignUnsupported) {
+		return nil, badRequest("this server only accepts multipart/form-data uploads")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to presign upload", "error", err)
+		return nil, internalError("failed to start upload")
+	}
+	if err := repositories.NewAttachmentRepository(dbConnection.DB).Create(ctx, attachment); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload", "error", err)
+		return nil, internalError("failed to start upload")
+	}
+
+	data := gin.H{"attachment": attachment, "upload": request}
+	return &Response{Status: http.StatusCreated, Data: data, Legacy: data}, nil
+}
+
+// CompleteUpload checks that the bytes of a presigned upload arrived and
+// are within the limits, and makes the attachment sendable.
+func CompleteUpload(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		attachments := repositories.NewAttachmentRepository(dbConnection.DB)
+		attachment, apiErr := loadAttachment(c, attachments)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if attachment.UploaderID != CurrentUserID(c) {
+			return nil, notFound("attachment not found")
+		}
+		if attachment.Status == models.AttachmentReady {
+			return &Response{Data: attachment, Legacy: gin.H{"attachment": attachment}}, nil
+		}
+
+		ctx := c.Request.Context()
+		info, err := store.Stat(ctx, attachment.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, conflict("the file has not been uploaded yet")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to stat upload", "attachment_id", attachment.ID, "error", err)
+			return nil, internalError("failed to complete upload")
+		}
+
+		// Encrypted files cannot be sniffed, only held to their manifest.
+		if attachment.Encrypted {
+			if info.Size != attachment.SizeBytes {
+				deleteObject(store, attachment.StorageKey)
+				return nil, badRequest(fmt.Sprintf("the file is %d bytes but its manifest declared %d", info.Size, attachment.SizeBytes))
+			}
+		} else if apiErr := checkCompletedUpload(ctx, store, attachment, info.Size); apiErr != nil {
+			return nil, apiErr
+		}
+
+		if err := attachments.MarkReady(ctx, attachment, info.Size); err != nil {
+
+			slog.ErrorContext(ctx, "Failed to complete upload", "attachment_id", attachment.ID, "error", err)
+			return nil, internalError("failed to complete upload")
+		}
+		attachment.Status, attachment.SizeBytes = models.AttachmentReady, info.Size
+		return &Response{Data: attachment, Legacy: gin.H{"attachment": attachment}}, nil
+	}
+}
+
+// GetUpload returns an attachment and a short-lived URL to download it.
+// View-once attachments come without one: a presigned URL could be
+// followed any number of times, so they are only downloaded through the
+// API with a token from OpenViewOnce.
+func GetUpload(dbConnection *database.DatabaseConnection, store storage.Storage) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		attachment, apiErr := visibleAttachment(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if attachment.ViewOnce {
+			data := gin.H{"attachment": attachment}
+			return &Response{Data: data, Legacy: data}, nil
+		}
+
+		download, err := store.PresignGet(c.Request.Context(), attachment.StorageKey, presignExpiry)
+		if errors.Is(err, storage.ErrPresignUnsupported) {
+			download = &storage.PresignedRequest{
+				Method: http.MethodGet,
+				URL:    "/api/v1/uploads/" + attachment.ID.String() + "/content",
+			}
+		} else if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to presign download", "attachment_id", attachment.ID, "error", err)
+			return nil, internalError("failed to load attachment")
+		}
+
+		data := gin.H{"attachment": attachment, "download": download}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// OpenViewOnce issues the current user a single-use token to download a
+// view-once attachment sent to them with, valid for viewOnceTokenTTL.
+// Asking again before using it replaces the token; once it has been used
+// the attachment is gone for them, and its sender cannot open it at all.
+func OpenViewOnce(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		attachment, apiErr := visibleAttachment(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if !attachment.ViewOnce {
+			return nil, badRequest("attachment is not view-once")
+		}
+		userID := CurrentUserID(c)
+		if attachment.UploaderID == userID {
+			return nil, forbidden("view-once media cannot be opened by its sender")
+		}
+
+		token, hash, err := auth.NewViewOnceToken()
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to generate view-once token", "error", err)
+			return nil, internalError("failed to open attachment")
+		}
+		record := models.ViewOnceToken{
+			AttachmentID: attachment.ID,
+			UserID:       userID,
+			TokenHash:    hash,
+			ExpiresAt:    time.Now().Add(viewOnceTokenTTL),
+		}
+		err = repositories.NewViewOnceTokenRepository(dbConnection.DB).Issue(c.Request.Context(), &record)
+		if errors.Is(err, repositories.ErrConsumed) {
+			return nil, gone("view-once media was already opened")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to issue view-once token", "attachment_id", attachment.ID, "error", err)
+			return nil, internalError("failed to open attachment")
+		}
+
+		download := &storage.PresignedRequest{
+			Method: http.MethodGet,
+			URL:    "/api/v1/uploads/" + attachment.ID.String() + "/content?token=" + url.QueryEscape(token),
+		}
+		data := gin.H{"token": token, "expires_at": record.ExpiresAt, "download": download}
+		return &Response{Data: data, Legacy: data}, nil
+	}
+}
+
+// DownloadUpload streams an attachment's bytes through the API, for
+// backends without presigned URLs and for view-once attachments, which
+// take the token query parameter OpenViewOnce issued and consume it.
+func DownloadUpload(c *gin.Context, dbConnection *database.DatabaseConnection, store storage.Storage, hints *CacheHints) {
+	attachment, apiErr := visibleAttachment(c, dbConnection)
+	if apiErr != nil {
+		abortWithError(c, apiErr)
+		return
+	}
+	if attachment.ViewOnce {
+		err := repositories.NewViewOnceTokenRepository(dbConnection.DB).
+			Consume(c.Request.Context(), attachment.ID, CurrentUserID(c), auth.HashViewOnceToken(c.Query("token")))
+		switch {
+		case errors.Is(err, repositories.ErrConsumed):
+			abortWithError(c, gone("view-once media was already opened"))
+			return
+		case errors.Is(err, repositories.ErrNotFound):
+			abortWithError(c, forbidden("a valid view-once token is required"))
+			return
+		case err != nil:
+			slog.ErrorContext(c.Request.Context(), "Failed to consume view-once token", "attachment_id", attachment.ID, "error", err)
+			abortWithError(c, internalError("failed to load attachment"))
+			return
+		}
+	}
+
+	body, err := store.Open(c.Request.Context(), attachment.StorageKey)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to open attachment", "attachment_id", attachment.ID, "error", err)
+		abortWithError(c, internalError("failed to load attachment"))
+		return
+	}
+	defer body.Close()
+
+	// Only media is shown inline; everything else downloads, and nothing
+	// is sniffed into a type the browser would execute.
+	disposition := "attachment"
+	if attachment.Kind != string(content.AttachmentDocument) && !attachment.Encrypted {
+		disposition = "inline"
+	}
+	if attachment.ViewOnce {
+		c.Header("Cache-Control", "no-store")
+	} else {
+		hints.apply(c, cacheAttachments)
+	}
+	c.DataFromReader(http.StatusOK, attachment.SizeBytes, attachment.MimeType, body, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}),
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// newAttachment describes an attachment uploader is adding, stored in the
+// bucket of their residency region.
+func newAttachment(store storage.Storage, uploader *models.User, kind content.AttachmentKind, upload content.Upload, status string) *models.Attachment {
+	id := uuid.New()
+	key := "attachments/" + uploader.ID.String() + "/" + id.String()
+	return &models.Attachment{
+		ID:         id,
+		UploaderID: uploader.ID,
+		Kind:       string(kind),
+		FileName:   upload.FileName,
+		MimeType:   upload.MimeType,
+		SizeBytes:  upload.SizeBytes,
+		StorageKey: storage.ResidentKey(store, residency.Region(uploader.Residency), key),
+		Status:     status,
+	}
+}
+
+// newEncryptedAttachment describes a client-encrypted attachment. Its name
+// and type are placeholders, the real ones being in the client's metadata.
+func newEncryptedAttachment(store storage.Storage, uploader *models.User, manifest *content.EncryptedManifest, status string) *models.Attachment {
+	attachment := newAttachment(store, uploader, manifest.Kind, content.Upload{
+		MimeType:  encryptedMimeType,
+		SizeBytes: manifest.SizeBytes,
+	}, status)
+	attachment.FileName = attachment.ID.String()
+	attachment.Encrypted = true
+	attachment.Metadata = manifest.Metadata
+	return attachment
+}
+
+func loadAttachment(c *gin.Context, attachments *repositories.AttachmentRepository) (*models.Attachment, *APIError) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest("invalid attachment id")
+	}
+	attachment, err := attachments.Get(c.Request.Context(), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("attachment not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to load attachment", "attachment_id", id, "error", err)
+		return nil, internalError("failed to load attachment")
+	}
+	return attachment, nil
+}
+
+// visibleAttachment loads a ready attachment the current user may fetch.
+// Others get a 404, so attachment IDs reveal nothing.
+func visibleAttachment(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Attachment, *APIError) {
+	attachments := repositories.NewAttachmentRepository(dbConnection.DB)
+	attachment, apiErr := loadAttachment(c, attachments)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	visible, err := attachments.IsVisibleTo(c.Request.Context(), attachment, CurrentUserID(c))
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to check access to attachment", "attachment_id", attachment.ID, "error", err)
+		return nil, internalError("failed to load attachment")
+	}
+	if !visible || attachment.Status != models.AttachmentReady {
+		return nil, notFound("attachment not found")
+	}
+	return attachment, nil
+}
+
+// checkCompletedUpload checks the bytes of a presigned upload against the
+// name and type it was declared with, deleting them if they do not match.
+func checkCompletedUpload(ctx context.Context, store storage.Storage, attachment *models.Attachment, size int64) *APIError {
+	upload := content.Upload{FileName: attachment.FileName, MimeType: attachment.MimeType, SizeBytes: size}
+	if _, err := upload.Validate(); err != nil {
+		deleteObject(store, attachment.StorageKey)
+		return badRequest(err.Error())
+	}
+	if err := checkStoredContent(ctx, store, attachment.StorageKey, &upload); err != nil {
+		deleteObject(store, attachment.StorageKey)
+		return badRequest(err.Error())
+	}
+	return nil
+}
+
+// checkStoredContent sniffs the start of a stored object against its
+// declared type.
+func checkStoredContent(ctx context.Context, store storage.Storage, key string, upload *content.Upload) error {
+	body, err := store.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	defer body.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	return upload.CheckSniffed(http.DetectContentType(head[:n]))
+}
+
+// deleteObject removes an object that will not be recorded. Failures only
+// leave an orphaned object behind, so they are logged.
+func deleteObject(store storage.Storage, key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.Delete(ctx, key); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete object", "key", key, "error", err)
+	}
+}
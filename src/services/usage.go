@@ -0,0 +1,185 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	JobMeterUsage = "meter_usage"
+
+	// UsageMeterInterval is how often usage is measured, and finished
+	// days exported.
+	UsageMeterInterval = time.Hour
+
+	// usageExportBatch is how many records one export posts.
+	usageExportBatch = 500
+
+	usageExportTimeout = 30 * time.Second
+
+	defaultUsageDays = 30
+	maxUsageDays     = 90
+)
+
+// usageExport is the body posted to the billing provider. A record is
+// posted again if its export failed, so it is identified by its account,
+// meter and day.
+type usageExport struct {
+	Event   string            `json:"event"`
+	Records []usageExportLine `json:"records"`
+}
+
+type usageExportLine struct {
+	AccountID uuid.UUID `json:"account_id"`
+	Meter     string    `json:"meter"`
+	Day       string    `json:"day"`
+	Quantity  int64     `json:"quantity"`
+}
+
+// Usage meters accounts' billable usage into daily usage records and
+// exports each finished day to the billing provider.
+type Usage struct {
+	db     *database.DatabaseConnection
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewUsage(dbConnection *database.DatabaseConnection, appConfig *config.ApplicationConfig) *Usage {
+	return &Usage{
+		db:     dbConnection,
+		url:    appConfig.BillingUsageURL,
+		secret: appConfig.BillingUsageSecret,
+		client: &http.Client{Timeout: usageExportTimeout},
+	}
+}
+
+// MeterUsage is the scheduled job measuring the bytes each account stores
+// into the day's usage, and exporting the usage of finished days when a
+// billing provider is configured. Exports that fail are posted again the
+// next time it runs.
+func MeterUsage(usage *Usage) JobHandler {
+	return func(ctx context.Context, _ *models.Job) error {
+		usages := repositories.NewUsageRepository(usage.db.DB)
+		now := time.Now().UTC()
+		stored, err := usages.StoredBytes(ctx)
+		if err != nil {
+			return err
+		}
+		records := make([]models.UsageRecord, 0, len(stored))
+		for userID, bytes := range stored {
+			records = append(records, models.UsageRecord{
+				UserID:    userID,
+				Meter:     models.UsageStorageBytes,
+				Day:       now.Format(time.DateOnly),
+				Quantity:  bytes,
+				UpdatedAt: now,
+			})
+		}
+		if err := usages.Measure(ctx, records); err != nil {
+			return err
+		}
+		if usage.url == "" {
+			return nil
+		}
+
+		for {
+			pending, err := usages.Unexported(ctx, now, usageExportBatch)
+			if err != nil {
+				return err
+			}
+			if len(pending) == 0 {
+				return nil
+			}
+			if err := usage.export(ctx, pending); err != nil {
+				return err
+			}
+			if err := usages.MarkExported(ctx, pending, time.Now()); err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "Exported usage", "records", len(pending))
+		}
+	}
+}
+
+// export posts records to the billing provider, signed as outgoing
+// webhooks are.
+func (u *Usage) export(ctx context.Context, records []models.UsageRecord) error {
+	lines := make([]usageExportLine, len(records))
+	for i, record := range records {
+		lines[i] = usageExportLine{AccountID: record.UserID, Meter: record.Meter, Day: record.Day, Quantity: record.Quantity}
+	}
+	body, err := json.Marshal(usageExport{Event: "usage.daily", Records: lines})
+	if err != nil {
+		return fmt.Errorf("failed to encode usage: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(u.secret, time.Now(), body))
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export usage: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("billing provider answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GetMyUsage returns the current user's metered usage of the last days
+// given by the days parameter, 30 by default.
+func GetMyUsage(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		return usageOf(c, dbConnection, CurrentUserID(c))
+	}
+}
+
+// GetUserUsage returns the metered usage of the account named by the :id
+// parameter, for platform admins, as GetMyUsage does.
+func GetUserUsage(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		userID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		return usageOf(c, dbConnection, userID)
+	}
+}
+
+func usageOf(c *gin.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID) (*Response, *APIError) {
+	days := defaultUsageDays
+	if raw := c.Query("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxUsageDays {
+			return nil, badRequest(fmt.Sprintf("days must be between 1 and %d", maxUsageDays))
+		}
+		days = n
+	}
+	since := time.Now().UTC().AddDate(0, 0, 1-days)
+	records, err := repositories.NewUsageRepository(dbConnection.DB).List(c.Request.Context(), userID, since)
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to list usage", "user_id", userID, "error", err)
+		return nil, internalError("failed to list usage")
+	}
+	return &Response{Data: records, Legacy: gin.H{"usage": records}}, nil
+}
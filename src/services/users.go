@@ -0,0 +1,439 @@
+package services
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/dfunani/AfroChat/backend/pkg/phone"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PublicProfile is the subset of a user that any client may see.
+type PublicProfile struct {
+	ID          uuid.UUID `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"display_name"`
+	AvatarURL   *string   `json:"avatar_url"`
+	Bio         string    `json:"bio"`
+	IsVerified  bool      `json:"is_verified"`
+}
+
+func NewPublicProfile(user *models.User) PublicProfile {
+	return PublicProfile{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		AvatarURL:   user.AvatarURL,
+		Bio:         user.Bio,
+		IsVerified:  user.IsVerified,
+	}
+}
+
+type resolveUsersRequest struct {
+	IDs []uuid.UUID `json:"ids" binding:"required,min=1,max=100"`
+}
+
+// ResolveUsers returns public profiles for up to 100 user IDs in one
+// call. IDs that do not exist, or belong to deleted or banned users or
+// those outside the current workspace, are listed under "missing".
+func ResolveUsers(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req resolveUsersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "ids must contain between 1 and 100 user IDs",
+		})
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(req.IDs))
+	seen := make(map[uuid.UUID]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	ctx := c.Request.Context()
+	visible, err := workspaceUserIDs(ctx, dbConnection, CurrentWorkspaceID(c), ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to resolve users",
+		})
+		return
+	}
+	found, err := publicProfiles(ctx, dbConnection, visible)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to resolve users",
+		})
+		return
+	}
+
+	profiles := make([]PublicProfile, 0, len(found))
+	missing := make([]uuid.UUID, 0)
+	for _, id := range ids {
+		if profile, ok := found[id]; ok {
+			profiles = append(profiles, profile)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":   profiles,
+		"missing": missing,
+	})
+}
+
+// SelfProfile is what a user sees about their own account.
+type SelfProfile struct {
+	PublicProfile
+	Email       string     `json:"email"`
+	FirstName   string     `json:"first_name"`
+	LastName    string     `json:"last_name"`
+	PhoneNumber *string    `json:"phone_number"`
+	PhoneE164   *string    `json:"phone_e164"`
+	TimeZone    string     `json:"time_zone"`
+	Location    *string    `json:"location"`
+	Status      string     `json:"status"`
+	AccountType string     `json:"account_type"`
+	IsPremium   bool       `json:"is_premium"`
+	LastLoginAt *time.Time `json:"last_login_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// SmartReplies is whether the user gets reply suggestions for direct
+	// messages.
+	SmartReplies bool `json:"smart_replies"`
+
+	// PublicPage is whether the profile can be seen without signing in.
+	PublicPage bool `json:"public_page"`
+
+	// PhoneDiscoverable is whether users who have the phone number can
+	// find the account by it.
+	PhoneDiscoverable bool `json:"phone_discoverable"`
+
+	// AnalyticsOptIn is whether the user's usage may be sampled,
+	// anonymously, for product analytics.
+	AnalyticsOptIn bool `json:"analytics_opt_in"`
+
+	// MarketingEmails is whether product news and offers may be emailed
+	// to the user.
+	MarketingEmails bool `json:"marketing_emails"`
+
+	// OnboardingMessages is whether the onboarding sequence may still
+	// send the user tips.
+	OnboardingMessages bool `json:"onboarding_messages"`
+
+	// USSDAccess is whether the user's direct messages can be reached from
+	// the USSD menu by dialling from their phone number.
+	USSDAccess bool `json:"ussd_access"`
+
+	// Tier and Limits tell clients what the account is entitled to, such
+	// as the longest message they may send.
+	Tier   entitlements.Tier   `json:"tier"`
+	Limits entitlements.Limits `json:"limits"`
+}
+
+func NewSelfProfile(user *models.User) SelfProfile {
+	return SelfProfile{
+		PublicProfile:      NewPublicProfile(user),
+		Email:              user.Email,
+		FirstName:          user.FirstName,
+		LastName:           user.LastName,
+		PhoneNumber:        user.PhoneNumber,
+		PhoneE164:          user.PhoneE164,
+		TimeZone:           user.TimeZone,
+		Location:           user.Location,
+		Status:             user.Status,
+		AccountType:        user.AccountType,
+		IsPremium:          user.IsPremium,
+		LastLoginAt:        user.LastLoginAt,
+		CreatedAt:          user.CreatedAt,
+		SmartReplies:       user.SmartReplies,
+		PublicPage:         user.PublicPage,
+		PhoneDiscoverable:  user.PhoneDiscoverable,
+		AnalyticsOptIn:     user.AnalyticsOptIn,
+		MarketingEmails:    user.MarketingEmails,
+		OnboardingMessages: user.OnboardingMessages,
+		USSDAccess:         user.USSDAccess,
+		Tier:               userTier(user),
+		Limits:             entitlements.For(userTier(user)),
+	}
+}
+
+type updateProfileRequest struct {
+	Username    *string `json:"username"`
+	DisplayName *string `json:"display_name" binding:"omitempty,min=1,max=100"`
+	FirstName   *string `json:"first_name" binding:"omitempty,max=50"`
+	LastName    *string `json:"last_name" binding:"omitempty,max=50"`
+	AvatarURL   *string `json:"avatar_url" binding:"omitempty,max=2048"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500"`
+	PhoneNumber *string `json:"phone_number" binding:"omitempty,max=32"`
+	TimeZone    *string `json:"time_zone" binding:"omitempty,max=50"`
+	Location    *string `json:"location" binding:"omitempty,max=100"`
+
+	SmartReplies    *bool `json:"smart_replies"`
+	PublicPage      *bool `json:"public_page"`
+	AnalyticsOptIn  *bool `json:"analytics_opt_in"`
+	MarketingEmails *bool `json:"marketing_emails"`
+
+	OnboardingMessages *bool `json:"onboarding_messages"`
+	USSDAccess         *bool `json:"ussd_access"`
+
+	// PhoneRegion is the country a phone number written without its
+	// country code is read in, instead of the account's.
+	PhoneRegion       *string `json:"phone_region" binding:"omitempty,len=2,alpha"`
+	PhoneDiscoverable *bool   `json:"phone_discoverable"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+// GetUser returns another user's public profile. Deleted and banned users,
+// and those outside the current workspace, are reported as not found.
+func GetUser(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		visible, err := workspaceUserIDs(ctx, dbConnection, CurrentWorkspaceID(c), []uuid.UUID{id})
+		if err != nil {
+			return nil, internalError("failed to load user")
+		}
+		profiles, err := publicProfiles(ctx, dbConnection, visible)
+		if err != nil {
+			return nil, internalError("failed to load user")
+		}
+		profile, ok := profiles[id]
+		if !ok {
+			return nil, notFound("user not found")
+		}
+		return &Response{Data: profile, Legacy: gin.H{"user": profile}}, nil
+	}
+}
+
+// GetMe returns the current user's own profile.
+func GetMe(c *gin.Context) (*Response, *APIError) {
+	profile := NewSelfProfile(CurrentUser(c))
+	return &Response{Data: profile, Legacy: gin.H{"user": profile}}, nil
+}
+
+// UpdateMe applies a partial update to the current user's profile. Only
+// fields present in the request are changed; empty strings clear the
+// optional ones.
+func UpdateMe(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req updateProfileRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		user := CurrentUser(c)
+		db := dbConnection.DB.WithContext(c.Request.Context())
+		updates := map[string]any{}
+
+		if req.Username != nil {
+			username := strings.TrimSpace(*req.Username)
+			if !usernamePattern.MatchString(username) {
+				return nil, badRequest("username must be 3-50 letters, digits, '_' or '-'")
+			}
+
+			var taken int64
+			if err := db.Unscoped().Model(&models.User{}).
+				Where("LOWER(username) = LOWER(?) AND id <> ?", username, user.ID).
+				Count(&taken).Error; err != nil {
+				return nil, internalError("failed to update profile")
+			}
+			if taken > 0 {
+				return nil, conflict("username is already taken")
+			}
+			updates["username"] = username
+		}
+		if req.DisplayName != nil {
+			displayName := strings.TrimSpace(*req.DisplayName)
+			if displayName == "" {
+				return nil, badRequest("display_name cannot be blank")
+			}
+			updates["display_name"] = displayName
+		}
+		if req.TimeZone != nil {
+			if _, err := time.LoadLocation(*req.TimeZone); err != nil || *req.TimeZone == "" || *req.TimeZone == "Local" {
+				return nil, badRequest("time_zone must be an IANA time zone such as Africa/Johannesburg")
+			}
+			updates["time_zone"] = *req.TimeZone
+		}
+		if req.FirstName != nil {
+			updates["first_name"] = strings.TrimSpace(*req.FirstName)
+		}
+		if req.LastName != nil {
+			updates["last_name"] = strings.TrimSpace(*req.LastName)
+		}
+		if req.Bio != nil {
+			updates["bio"] = strings.TrimSpace(*req.Bio)
+		}
+		if req.AvatarURL != nil {
+			avatarURL := optionalString(*req.AvatarURL)
+			if avatarURL != nil && !isHTTPURL(*avatarURL) {
+				return nil, badRequest("avatar_url must be an http or https URL")
+			}
+			updates["avatar_url"] = avatarURL
+		}
+		if req.PhoneNumber != nil {
+			phoneNumber := optionalString(*req.PhoneNumber)
+			var normalized *string
+			if phoneNumber != nil {
+				region := ""
+				if req.PhoneRegion != nil {
+					region = *req.PhoneRegion
+				} else if user.CountryCode != nil {
+					region = *user.CountryCode
+				}
+				e164, err := phone.Normalize(*phoneNumber, region)
+				if errors.Is(err, phone.ErrNoCountry) {
+					return nil, badRequest("phone_number needs a country code, e.g. +27821234567, or a phone_region")
+				}
+				if err != nil {
+					return nil, badRequest("phone_number is not a valid phone number")
+				}
+				normalized = &e164
+			}
+			updates["phone_number"] = phoneNumber
+			updates["phone_e164"] = normalized
+			updates["ussd_access"] = false
+		}
+		if req.Location != nil {
+			updates["location"] = optionalString(*req.Location)
+		}
+		if req.SmartReplies != nil {
+			updates["smart_replies"] = *req.SmartReplies
+		}
+		if req.PublicPage != nil {
+			updates["public_page"] = *req.PublicPage
+		}
+		// Consents are changed apart from the rest so each change is
+		// recorded.
+		consents := make(map[string]bool)
+		if req.PhoneDiscoverable != nil {
+			consents[models.ConsentContactDiscovery] = *req.PhoneDiscoverable
+		}
+		if req.AnalyticsOptIn != nil {
+			consents[models.ConsentAnalytics] = *req.AnalyticsOptIn
+		}
+		if req.MarketingEmails != nil {
+			consents[models.ConsentMarketingEmails] = *req.MarketingEmails
+		}
+		if req.OnboardingMessages != nil {
+			updates["onboarding_messages"] = *req.OnboardingMessages
+		}
+		if req.USSDAccess != nil {
+			updates["ussd_access"] = *req.USSDAccess
+		}
+
+		if len(updates) > 0 {
+			if err := db.Model(user).Updates(updates).Error; err != nil {
+				if errors.Is(err, gorm.ErrDuplicatedKey) {
+					return nil, conflict("username is already taken")
+				}
+				return nil, internalError("failed to update profile")
+			}
+			invalidateProfile(c.Request.Context(), user.ID)
+		}
+		if err := changeConsents(c.Request.Context(), dbConnection, user.ID, consents); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to update consents", "error", err)
+			return nil, internalError("failed to update profile")
+		}
+
+		var updated models.User
+		if err := db.First(&updated, "id = ?", user.ID).Error; err != nil {
+			return nil, internalError("failed to load profile")
+		}
+
+		profile := NewSelfProfile(&updated)
+		return &Response{Data: profile, Legacy: gin.H{"user": profile}}, nil
+	}
+}
+
+// DeleteMe soft-deletes the current user's account after confirming their
+// password, if they have one. The row is kept, so the email and username
+// stay reserved and existing tokens stop resolving to a user.
+//
+// With ?erase=true the account's personal data is also erased for good by
+// a background job, and the request answers 202.
+func DeleteMe(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		user := CurrentUser(c)
+		if user.PasswordHash != "" {
+			var req deleteAccountRequest
+			if apiErr := bindJSON(c, &req); apiErr != nil {
+				apiErr.Message = "password is required to delete your account"
+				apiErr.LegacyMessage = ""
+				return nil, apiErr
+			}
+			ok, err := auth.CheckPassword(req.Password, user.PasswordHash, user.Salt)
+			if err != nil || !ok {
+				return nil, unauthorized("incorrect password")
+			}
+		}
+
+		erase := c.Query("erase") == "true"
+		err := dbConnection.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(user).Error; err != nil {
+				return err
+			}
+			if !erase {
+				return nil
+			}
+			if err := repositories.NewAccountErasureRepository(tx).Create(c.Request.Context(), &models.AccountErasure{UserID: user.ID}); err != nil {
+				return err
+			}
+			_, err := repositories.NewJobRepository(tx).Enqueue(c.Request.Context(), JobAccountErasure, accountErasureJob{UserID: user.ID})
+			return err
+		})
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete account", "user_id", user.ID, "error", err)
+			return nil, internalError("failed to delete account")
+		}
+		if _, err := repositories.NewSessionRepository(dbConnection.DB).RevokeAll(c.Request.Context(), user.ID, uuid.Nil); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to revoke sessions of deleted user", "user_id", user.ID, "error", err)
+		}
+		usageSampler.Forget(user.ID)
+		invalidateProfile(c.Request.Context(), user.ID)
+		if erase {
+			data := gin.H{"erasure": "scheduled"}
+			return &Response{Status: http.StatusAccepted, Data: data, Legacy: data}, nil
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+func isHTTPURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "https" || parsed.Scheme == "http") && parsed.Host != ""
+}
+
+func optionalString(value string) *string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/phone"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/dfunani/AfroChat/backend/pkg/ussd"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// ussdSessionTTL outlasts the few minutes a network keeps a USSD
+	// session open.
+	ussdSessionTTL = 10 * time.Minute
+
+	// ussdConversations is how many direct conversations the menu lists,
+	// and ussdMessages how many of the latest messages it shows of one.
+	ussdConversations = 5
+	ussdMessages      = 3
+
+	// ussdLineLength keeps each listed conversation or message to a line
+	// of a small screen.
+	ussdLineLength = 40
+)
+
+// USSD serves the USSD menu, through which users without data reach
+// their direct messages by dialling from the phone number on their
+// account, once they turned on USSD access.
+type USSD struct {
+	db        *database.DatabaseConnection
+	hub       *realtime.Hub
+	notifier  *Notifier
+	suggester *Suggester
+	index     search.Index
+	token     string
+}
+
+func NewUSSD(dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index, token string) *USSD {
+	return &USSD{db: dbConnection, hub: hub, notifier: notifier, suggester: suggester, index: index, token: token}
+}
+
+// USSDCallback answers the aggregator's callback for a step of a USSD
+// session, authenticated by the token in its URL. The form carries the
+// session's ID, the dialling number and the inputs so far, as package
+// ussd describes. The menu lists the latest direct conversations, shows
+// the latest messages of one and sends a reply to it, and counts unread
+// messages and notifications.
+func USSDCallback(c *gin.Context, gateway *USSD) {
+	if subtle.ConstantTimeCompare([]byte(c.Param("token")), []byte(gateway.token)) != 1 {
+		abortWithError(c, notFound("not found"))
+		return
+	}
+	sessionID, msisdn := c.PostForm("sessionId"), c.PostForm("phoneNumber")
+	if sessionID == "" || msisdn == "" {
+		abortWithError(c, badRequest("sessionId and phoneNumber are required"))
+		return
+	}
+
+	reply := gateway.respond(c.Request.Context(), sessionID, msisdn, ussd.Inputs(c.PostForm("text")))
+	c.String(http.StatusOK, reply.String())
+}
+
+func (u *USSD) respond(ctx context.Context, sessionID, msisdn string, inputs []string) ussd.Reply {
+	number, err := phone.Normalize(msisdn, "")
+	if err != nil {
+		return ussd.End("AfroChat cannot read your phone number.")
+	}
+	user, err := repositories.NewUSSDSessionRepository(u.db.DB).Account(ctx, number)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ussd.End("This number is not set up for AfroChat USSD. Turn on USSD access in the app's settings.")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load USSD account", "error", err)
+		return ussd.End("AfroChat is unavailable. Try again later.")
+	}
+	if accountBlockedReason(user) != "" {
+		return ussd.End("Your AfroChat account cannot be used right now.")
+	}
+
+	var reply ussd.Reply
+	switch {
+	case len(inputs) == 0:
+		reply = ussd.Continue("AfroChat", "1. Latest messages", "2. Notifications")
+	case inputs[0] == "1":
+		reply, err = u.directMessages(ctx, sessionID, user, inputs[1:])
+	case inputs[0] == "2" && len(inputs) == 1:
+		reply, err = u.notifications(ctx, user)
+	default:
+		reply = ussd.End("Invalid choice.")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to answer USSD session", "user_id", user.ID, "error", err)
+		return ussd.End("AfroChat is unavailable. Try again later.")
+	}
+	return reply
+}
+
+// directMessages is the menu of direct conversations: the list, then the
+// latest messages of the one chosen, then a prompt for a reply and the
+// reply itself, which may run over several inputs.
+func (u *USSD) directMessages(ctx context.Context, sessionID string, user *models.User, inputs []string) (ussd.Reply, error) {
+	sessions := repositories.NewUSSDSessionRepository(u.db.DB)
+	if len(inputs) == 0 {
+		summaries, err := repositories.NewConversationSummaryRepository(u.db.DB).LatestDirect(ctx, user.ID, ussdConversations)
+		if err != nil {
+			return ussd.Reply{}, err
+		}
+		if len(summaries) == 0 {
+			return ussd.End("You have no direct messages yet."), nil
+		}
+		ids := make([]uuid.UUID, len(summaries))
+		for i := range summaries {
+			ids[i] = summaries[i].ConversationID
+		}
+		if err := sessions.Save(ctx, sessionID, user.ID, ids, ussdSessionTTL); err != nil {
+			return ussd.Reply{}, err
+		}
+		names, err := u.peerNames(ctx, user.ID, ids)
+		if err != nil {
+			return ussd.Reply{}, err
+		}
+		lines := []string{"Latest messages"}
+		for i, summary := range summaries {
+			line := fmt.Sprintf("%d. %s", i+1, names[summary.ConversationID])
+			if summary.UnreadCount > 0 {
+				line += fmt.Sprintf(" (%d)", summary.UnreadCount)
+			}
+			if summary.LastMessagePreview != "" {
+				line += ": " + summary.LastMessagePreview
+			}
+			lines = append(lines, ussd.Truncate(line, ussdLineLength))
+		}
+		return ussd.Continue(lines...), nil
+	}
+
+	listed, err := sessions.Listed(ctx, sessionID, user.ID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return ussd.End("This session has expired. Dial again."), nil
+	}
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	choice, err := strconv.Atoi(inputs[0])
+	if err != nil || choice < 1 || choice > len(listed) {
+		return ussd.End("Invalid choice."), nil
+	}
+	conversation, err := repositories.NewConversationRepository(u.db.DB).Get(ctx, listed[choice-1])
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && !hasMember(conversation, user.ID)) {
+		return ussd.End("This conversation is no longer available."), nil
+	}
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	names, err := u.peerNames(ctx, user.ID, []uuid.UUID{conversation.ID})
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	peer := names[conversation.ID]
+
+	switch {
+	case len(inputs) == 1:
+		return u.latestMessages(ctx, user, conversation, peer)
+	case inputs[1] != "1":
+		return ussd.End("Invalid choice."), nil
+	case len(inputs) == 2:
+		return ussd.Continue("Reply to " + peer + ":"), nil
+	}
+	return u.sendReply(ctx, user, conversation, peer, strings.Join(inputs[2:], "*")), nil
+}
+
+// latestMessages shows the latest messages of a conversation, oldest
+// first, with the option to reply.
+func (u *USSD) latestMessages(ctx context.Context, user *models.User, conversation *models.Conversation, peer string) (ussd.Reply, error) {
+	latest, err := repositories.NewMessageRepository(u.db.DB).ListBefore(ctx, conversation.ID, nil, ussdMessages)
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	hideShadowed(latest, user.ID)
+	slices.Reverse(latest)
+
+	lines := make([]string, 0, len(latest)+1)
+	for _, message := range latest {
+		sender := peer
+		if message.SenderID == user.ID {
+			sender = "You"
+		}
+		lines = append(lines, ussd.Truncate(sender+": "+ussdMessageText(&message), ussdLineLength))
+	}
+	return ussd.Continue(append(lines, "1. Reply", "0. Back")...), nil
+}
+
+// ussdMessageText is how a message reads on a USSD screen, which shows
+// only text.
+func ussdMessageText(message *models.Message) string {
+	switch {
+	case message.DeletedAt.Valid:
+		return "[deleted]"
+	case message.Type == string(content.TypeEncrypted):
+		return "[encrypted, open the app]"
+	case message.Type != string(content.TypeText):
+		return "[" + message.Type + "]"
+	case message.Text == "" && len(message.Attachments) > 0:
+		return "[" + strings.ToLower(attachmentLabel(message.Attachments[0].Kind)) + "]"
+	}
+	return strings.Join(strings.Fields(message.Text), " ")
+}
+
+// sendReply posts text to the conversation as the user, as if sent from
+// the app, and closes the session.
+func (u *USSD) sendReply(ctx context.Context, user *models.User, conversation *models.Conversation, peer, text string) ussd.Reply {
+	_, _, err := postMessage(ctx, u.db, u.hub, u.notifier, u.suggester, u.index, user.ID, conversation.ID, messageInput{Text: text})
+	var answered *commandReply
+	switch {
+	case err == nil:
+		return ussd.End("Reply sent to " + peer + ".")
+	case errors.As(err, &answered):
+		return ussd.End("Commands can only be used in the app.")
+	case errors.Is(err, errBlocked), errors.Is(err, errMuted), errors.Is(err, trust.ErrInsufficientTrust), errors.Is(err, content.ErrInvalidContent):
+		return ussd.End("Reply not sent: " + err.Error())
+	}
+	slog.ErrorContext(ctx, "Failed to send USSD reply", "user_id", user.ID, "conversation_id", conversation.ID, "error", err)
+	return ussd.End("Reply not sent. Try again later.")
+}
+
+// notifications counts the user's unread messages and notifications.
+func (u *USSD) notifications(ctx context.Context, user *models.User) (ussd.Reply, error) {
+	messages, conversations, err := repositories.NewConversationSummaryRepository(u.db.DB).Unread(ctx, user.ID)
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	notifications, err := repositories.NewInboxRepository(u.db.DB).UnreadCount(ctx, user.ID)
+	if err != nil {
+		return ussd.Reply{}, err
+	}
+	return ussd.End(
+		fmt.Sprintf("Unread messages: %d in %d chats", messages, conversations),
+		fmt.Sprintf("New notifications: %d", notifications),
+	), nil
+}
+
+// peerNames returns the display name of the other member of each direct
+// conversation, or a placeholder when there is none.
+func (u *USSD) peerNames(ctx context.Context, userID uuid.UUID, conversationIDs []uuid.UUID) (map[uuid.UUID]string, error) {
+	var peers []struct {
+		ConversationID uuid.UUID
+		DisplayName    string
+	}
+	err := u.db.DB.WithContext(ctx).Model(&models.ConversationMember{}).
+		Select("conversation_members.conversation_id, users.display_name").
+		Joins("JOIN users ON users.id = conversation_members.user_id").
+		Where("conversation_members.conversation_id IN ? AND conversation_members.user_id <> ?", conversationIDs, userID).
+		Scan(&peers).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conversation members: %w", err)
+	}
+	names := make(map[uuid.UUID]string, len(conversationIDs))
+	for _, id := range conversationIDs {
+		names[id] = "Direct message"
+	}
+	for _, peer := range peers {
+		names[peer.ConversationID] = peer.DisplayName
+	}
+	return names, nil
+}
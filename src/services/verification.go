@@ -0,0 +1,274 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailVerificationTTL = 48 * time.Hour
+	PasswordResetTTL     = time.Hour
+
+	// emailTimeout bounds mail sent after the response has been written.
+	emailTimeout = 30 * time.Second
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=128"`
+}
+
+// NewMailer creates the mailer named by the config.
+func NewMailer(appConfig *config.ApplicationConfig) (mailer.Mailer, error) {
+	switch appConfig.Mailer {
+	case config.MailerLog:
+		return mailer.NewLogMailer(), nil
+	case config.MailerSMTP:
+		return mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     appConfig.SMTPHost,
+			Port:     appConfig.SMTPPort,
+			Username: appConfig.SMTPUsername,
+			Password: appConfig.SMTPPassword,
+			From:     appConfig.MailFrom,
+		})
+	}
+	return nil, fmt.Errorf("unknown mailer %q", appConfig.Mailer)
+}
+
+// VerifyEmail marks the account a verification link was sent to as
+// verified, which qualifies the referral that brought it in.
+func VerifyEmail(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "token is required",
+		})
+		return
+	}
+
+	var userID uuid.UUID
+	tokens := repositories.NewVerificationTokenRepository(dbConnection.DB)
+	err := tokens.Consume(c.Request.Context(), models.TokenEmailVerification, auth.HashVerificationToken(req.Token),
+		func(tx *gorm.DB, token *models.VerificationToken) error {
+			userID = token.UserID
+			if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Update("is_verified", true).Error; err != nil {
+				return err
+			}
+			return qualifyReferral(c.Request.Context(), tx, token.UserID)
+		})
+	if err != nil {
+		respondTokenError(c, err, "failed to verify email")
+		return
+	}
+	invalidateProfile(c.Request.Context(), userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "email verified",
+	})
+}
+
+// ResendVerification emails the current user a new verification link,
+// invalidating any earlier one.
+func ResendVerification(c *gin.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig) {
+	user := CurrentUser(c)
+	if user.IsVerified {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "email is already verified",
+		})
+		return
+	}
+
+	if err := sendVerificationEmail(c.Request.Context(), dbConnection, mail, appConfig, user); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to send verification email", "user_id", user.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to send verification email",
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "verification email sent",
+	})
+}
+
+// ForgotPassword emails a password reset link to the account with the given
+// email, if there is one. The response is the same either way, and the
+// email is sent after responding, so neither the body nor its timing reveals
+// which emails have accounts.
+func ForgotPassword(c *gin.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "a valid email is required",
+		})
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailTimeout)
+		defer cancel()
+		if err := sendPasswordResetEmail(ctx, dbConnection, mail, appConfig, email); err != nil {
+			slog.ErrorContext(ctx, "Failed to send password reset email", "error", err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":  "success",
+		"message": "if an account exists for that email, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using the token from a reset link. It
+// also verifies the email, which the link proves control of, and signs out
+// every session, since whoever knew the old password may hold one.
+func ResetPassword(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "token and a password of at least 8 characters are required",
+		})
+		return
+	}
+
+	hash, salt, err := auth.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to reset password",
+		})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var userID uuid.UUID
+	tokens := repositories.NewVerificationTokenRepository(dbConnection.DB)
+	err = tokens.Consume(ctx, models.TokenPasswordReset, auth.HashVerificationToken(req.Token),
+		func(tx *gorm.DB, token *models.VerificationToken) error {
+			userID = token.UserID
+			if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).Updates(map[string]any{
+				"password_hash": hash,
+				"salt":          salt,
+				"is_verified":   true,
+			}).Error; err != nil {
+				return err
+			}
+			_, err := repositories.NewSessionRepository(tx).RevokeAll(ctx, token.UserID, uuid.Nil)
+			return err
+		})
+	if err != nil {
+		respondTokenError(c, err, "failed to reset password")
+		return
+	}
+	invalidateProfile(ctx, userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "password reset; sign in with the new password",
+	})
+}
+
+func respondTokenError(c *gin.Context, err error, message string) {
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid or expired token",
+		})
+		return
+	}
+	slog.ErrorContext(c.Request.Context(), message, "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"status": "error",
+		"error":  message,
+	})
+}
+
+// sendVerificationEmail emails the user a link to verify their address.
+func sendVerificationEmail(ctx context.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig, user *models.User) error {
+	token, err := issueVerificationToken(ctx, dbConnection, user.ID, models.TokenEmailVerification, EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your AfroChat email",
+		Body: "Confirm this is your email address by opening the link below:\n\n" +
+			linkWithQuery(appConfig.VerifyEmailURL, "token", token) +
+			"\n\nThe link expires in 48 hours. If you did not create an AfroChat account, ignore this email.",
+	})
+}
+
+// sendPasswordResetEmail emails a reset link to the account with the given
+// email. Unknown emails and blocked accounts get nothing.
+func sendPasswordResetEmail(ctx context.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, appConfig *config.ApplicationConfig, email string) error {
+	var user models.User
+	err := dbConnection.DB.WithContext(ctx).Where("email = ?", email).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+	if accountBlockedReason(&user) != "" {
+		return nil
+	}
+
+	token, err := issueVerificationToken(ctx, dbConnection, user.ID, models.TokenPasswordReset, PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	return mail.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your AfroChat password",
+		Body: "Someone asked to reset the password for your AfroChat account. Choose a new one here:\n\n" +
+			linkWithQuery(appConfig.ResetPasswordURL, "token", token) +
+			"\n\nThe link expires in 1 hour. If you did not ask for this, ignore this email; your password is unchanged.",
+	})
+}
+
+func issueVerificationToken(ctx context.Context, dbConnection *database.DatabaseConnection, userID uuid.UUID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := auth.NewVerificationToken()
+	if err != nil {
+		return "", err
+	}
+	record := models.VerificationToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := repositories.NewVerificationTokenRepository(dbConnection.DB).Replace(ctx, &record); err != nil {
+		return "", err
+	}
+	return token, nil
+}
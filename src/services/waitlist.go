@@ -0,0 +1,298 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/config"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/mailer"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type joinWaitlistRequest struct {
+	Email string `json:"email" binding:"required,email,max=255"`
+}
+
+type admitWaitlistRequest struct {
+	Count          int `json:"count" binding:"required,min=1,max=1000"`
+	ExpiresInHours int `json:"expires_in_hours" binding:"omitempty,min=1"`
+}
+
+func generateWaitlistToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate waitlist token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// waitlistPosition is the entry's 1-based place among those still waiting.
+func waitlistPosition(db *gorm.DB, entry *models.WaitlistEntry) (int64, error) {
+	var ahead int64
+	err := db.Model(&models.WaitlistEntry{}).
+		Where("status = ?", models.WaitlistWaiting).
+		Where("created_at < ? OR (created_at = ? AND id < ?)", entry.CreatedAt, entry.CreatedAt, entry.ID).
+		Count(&ahead).Error
+	if err != nil {
+		return 0, err
+	}
+	return ahead + 1, nil
+}
+
+// JoinWaitlist adds an email address to the waitlist. The lookup token is
+// only returned on the first signup, so an address cannot be used to query
+// someone else's place.
+func JoinWaitlist(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	var req joinWaitlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "a valid email is required",
+		})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	db := dbConnection.DB.WithContext(c.Request.Context())
+
+	var registered int64
+	if err := db.Model(&models.User{}).Where("email = ?", email).Count(&registered).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join waitlist",
+		})
+		return
+	}
+	if registered > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"status": "error",
+			"error":  "this email is already registered",
+		})
+		return
+	}
+
+	token, err := generateWaitlistToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to join waitlist",
+		})
+		return
+	}
+
+	entry := models.WaitlistEntry{
+		Email:  email,
+		Token:  token,
+		Status: models.WaitlistWaiting,
+	}
+	status := http.StatusCreated
+	if err := db.Create(&entry).Error; err != nil {
+		if !errors.Is(err, gorm.ErrDuplicatedKey) {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to join waitlist",
+			})
+			return
+		}
+		if err := db.Where("email = ?", email).First(&entry).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to join waitlist",
+			})
+			return
+		}
+		entry.Token = ""
+		status = http.StatusOK
+	}
+
+	response := gin.H{
+		"status":          "success",
+		"waitlist_status": entry.Status,
+	}
+	if entry.Token != "" {
+		response["token"] = entry.Token
+	}
+	if entry.Status == models.WaitlistWaiting {
+		position, err := waitlistPosition(db, &entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to join waitlist",
+			})
+			return
+		}
+		response["position"] = position
+	}
+	c.JSON(status, response)
+}
+
+// WaitlistPosition reports where the holder of a waitlist token stands.
+func WaitlistPosition(c *gin.Context, dbConnection *database.DatabaseConnection) {
+	db := dbConnection.DB.WithContext(c.Request.Context())
+
+	var entry models.WaitlistEntry
+	if err := db.Where("token = ?", c.Param("token")).First(&entry).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status": "error",
+				"error":  "waitlist entry not found",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to load waitlist entry",
+		})
+		return
+	}
+
+	response := gin.H{
+		"status":          "success",
+		"waitlist_status": entry.Status,
+		"joined_at":       entry.CreatedAt,
+	}
+	if entry.Status == models.WaitlistWaiting {
+		position, err := waitlistPosition(db, &entry)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status": "error",
+				"error":  "failed to load waitlist position",
+			})
+			return
+		}
+		response["position"] = position
+	} else {
+		response["admitted_at"] = entry.AdmittedAt
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// AdmitWaitlist admits the next count applicants in signup order. Each gets
+// a single-use invite code, and is emailed a short signup link once the
+// batch is committed.
+func AdmitWaitlist(c *gin.Context, dbConnection *database.DatabaseConnection, mail mailer.Mailer, links *ShortLinks, appConfig *config.ApplicationConfig) {
+	var req admitWaitlistRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "count must be between 1 and 1000",
+		})
+		return
+	}
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresInHours > 0 {
+		t := now.Add(time.Duration(req.ExpiresInHours) * time.Hour)
+		expiresAt = &t
+	}
+
+	batchID := uuid.New()
+	var entries []models.WaitlistEntry
+	var invites []models.InviteCode
+
+	err := dbConnection.DB.WithContext(c.Request.Context()).Transaction(func(tx *gorm.DB) error {
+		// SKIP LOCKED lets two admins admit at once without double-admitting.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ?", models.WaitlistWaiting).
+			Order("created_at ASC, id ASC").
+			Limit(req.Count).
+			Find(&entries).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		invites = make([]models.InviteCode, 0, len(entries))
+		for range entries {
+			code, err := generateInviteCode()
+			if err != nil {
+				return err
+			}
+			invites = append(invites, models.InviteCode{
+				Code:        code,
+				BatchID:     batchID,
+				Note:        "waitlist",
+				MaxUses:     1,
+				ExpiresAt:   expiresAt,
+				CreatedByID: CurrentUserID(c),
+			})
+		}
+		if err := tx.CreateInBatches(&invites, 200).Error; err != nil {
+			return err
+		}
+
+		for i := range entries {
+			entries[i].Status = models.WaitlistAdmitted
+			entries[i].InviteCodeID = &invites[i].ID
+			entries[i].AdmittedAt = &now
+			if err := tx.Model(&entries[i]).Updates(map[string]any{
+				"status":         entries[i].Status,
+				"invite_code_id": entries[i].InviteCodeID,
+				"admitted_at":    entries[i].AdmittedAt,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to admit waitlist batch", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "failed to admit waitlist batch",
+		})
+		return
+	}
+
+	// A failed email leaves the invite valid; the code is still listed
+	// under the batch for manual follow-up.
+	signupLinks := links.inviteLinks(c.Request.Context(), appConfig.SignupURL, invites, CurrentUserID(c))
+	failed := make([]string, 0)
+	for i := range entries {
+		message := mailer.Message{
+			To:      entries[i].Email,
+			Subject: "You're off the AfroChat waitlist",
+			Body:    "Your spot is ready. Create your account here:\n\n" + signupLinks[i],
+		}
+		if err := mail.Send(c.Request.Context(), message); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to email waitlist invite", "email", entries[i].Email, "error", err)
+			failed = append(failed, entries[i].Email)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":         "success",
+		"batch_id":       batchID,
+		"admitted":       len(entries),
+		"email_failures": failed,
+	})
+}
+
+func signupLink(base, code string) string {
+	return linkWithQuery(base, "invite", code)
+}
+
+// linkWithQuery adds key=value to the query of the link at base.
+func linkWithQuery(base, key, value string) string {
+	link, err := url.Parse(base)
+	if err != nil {
+		return base + "?" + key + "=" + url.QueryEscape(value)
+	}
+	query := link.Query()
+	query.Set(key, value)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
@@ -0,0 +1,421 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/shortlink"
+	"github.com/dfunani/AfroChat/backend/pkg/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	JobWebhookDelivery = "webhook_delivery"
+
+	// webhookTimeout bounds one delivery to an outgoing webhook.
+	webhookTimeout = 10 * time.Second
+
+	// maxTriggerWords and maxTriggerWordLength bound an outgoing
+	// webhook's trigger words.
+	maxTriggerWords      = 10
+	maxTriggerWordLength = 50
+)
+
+type createIncomingWebhookRequest struct {
+	Name  string    `json:"name" binding:"required,max=100"`
+	BotID uuid.UUID `json:"bot_id" binding:"required"`
+}
+
+type createOutgoingWebhookRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	URL          string   `json:"url" binding:"required"`
+	TriggerWords []string `json:"trigger_words"`
+}
+
+// incomingWebhookRequest is a message posted to an incoming webhook: text,
+// or a typed payload as in messageInput.
+type incomingWebhookRequest struct {
+	Type    content.Type    `json:"type"`
+	Text    string          `json:"text"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+type webhookDeliveryJob struct {
+	WebhookID uuid.UUID `json:"webhook_id"`
+	MessageID uuid.UUID `json:"message_id"`
+}
+
+// webhookEvent is the body delivered to an outgoing webhook.
+type webhookEvent struct {
+	Event     string          `json:"event"`
+	WebhookID uuid.UUID       `json:"webhook_id"`
+	ChannelID uuid.UUID       `json:"channel_id"`
+	Message   *models.Message `json:"message"`
+}
+
+// IncomingWebhookView is an incoming webhook, with its URL when it was
+// just created. The URL holds the webhook's secret token and is only ever
+// returned then.
+type IncomingWebhookView struct {
+	models.IncomingWebhook
+	URL string `json:"url,omitempty"`
+}
+
+// OutgoingWebhookView is an outgoing webhook, with its signing secret when
+// it was just created. The secret is only ever returned then.
+type OutgoingWebhookView struct {
+	models.OutgoingWebhook
+	Secret string `json:"secret,omitempty"`
+}
+
+// Webhooks manages channels' webhooks and delivers to the outgoing ones.
+type Webhooks struct {
+	db      *database.DatabaseConnection
+	scanner shortlink.Scanner
+	client  *http.Client
+}
+
+func NewWebhooks(dbConnection *database.DatabaseConnection, scanner shortlink.Scanner) *Webhooks {
+	return &Webhooks{db: dbConnection, scanner: scanner, client: webhook.NewClient(webhookTimeout)}
+}
+
+// CreateIncomingWebhook adds an incoming webhook to the current channel,
+// posting as one of the current user's bots, which joins the channel.
+func CreateIncomingWebhook(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createIncomingWebhookRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		userID := CurrentUserID(c)
+		bot, err := repositories.NewBotRepository(webhooks.db.DB).GetForOwner(ctx, userID, req.BotID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("bot not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load bot", "bot_id", req.BotID, "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+
+		channelID := CurrentChannel(c).ConversationID
+		if err := repositories.NewChannelRepository(webhooks.db.DB).AddMembers(ctx, channelID, []uuid.UUID{bot.UserID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to add bot to channel", "channel_id", channelID, "bot_id", bot.UserID, "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+		token, hash, err := auth.NewWebhookToken()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate webhook token", "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+		hook := models.IncomingWebhook{
+			ConversationID: channelID,
+			BotID:          bot.UserID,
+			Name:           strings.TrimSpace(req.Name),
+			TokenHash:      hash,
+			CreatedByID:    &userID,
+		}
+		if err := repositories.NewWebhookRepository(webhooks.db.DB).CreateIncoming(ctx, &hook); err != nil {
+			slog.ErrorContext(ctx, "Failed to create webhook", "channel_id", channelID, "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+		view := IncomingWebhookView{IncomingWebhook: hook, URL: "/api/v1/hooks/" + token}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"webhook": view}}, nil
+	}
+}
+
+// ListIncomingWebhooks lists the current channel's incoming webhooks.
+func ListIncomingWebhooks(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		hooks, err := repositories.NewWebhookRepository(webhooks.db.DB).ListIncoming(c.Request.Context(), CurrentChannel(c).ConversationID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list webhooks", "error", err)
+			return nil, internalError("failed to list webhooks")
+		}
+		return &Response{Data: hooks, Legacy: gin.H{"webhooks": hooks}}, nil
+	}
+}
+
+// DeleteIncomingWebhook removes the incoming webhook named by the
+// :webhookId parameter from the current channel.
+func DeleteIncomingWebhook(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		return deleteWebhook(c, repositories.NewWebhookRepository(webhooks.db.DB).DeleteIncoming)
+	}
+}
+
+// CreateOutgoingWebhook adds an outgoing webhook to the current channel.
+// Its URL is scanned like a short link's destination, and its signing
+// secret is returned only in this response.
+func CreateOutgoingWebhook(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createOutgoingWebhookRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		destination, err := webhooks.scanner.Scan(req.URL)
+		var unsafeErr *shortlink.UnsafeDestinationError
+		if errors.As(err, &unsafeErr) {
+			return nil, badRequest(unsafeErr.Reason)
+		}
+		if err != nil {
+			return nil, badRequest("invalid webhook url")
+		}
+		if len(req.TriggerWords) > maxTriggerWords {
+			return nil, badRequest(fmt.Sprintf("at most %d trigger words are allowed", maxTriggerWords))
+		}
+		words := make([]string, 0, len(req.TriggerWords))
+		for _, word := range req.TriggerWords {
+			word = strings.TrimSpace(word)
+			if word == "" || strings.Contains(word, ",") || len(word) > maxTriggerWordLength {
+				return nil, badRequest(fmt.Sprintf("trigger words must be 1-%d characters without commas", maxTriggerWordLength))
+			}
+			words = append(words, word)
+		}
+
+		ctx := c.Request.Context()
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to generate webhook secret", "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+		userID := CurrentUserID(c)
+		hook := models.OutgoingWebhook{
+			ConversationID: CurrentChannel(c).ConversationID,
+			Name:           strings.TrimSpace(req.Name),
+			URL:            destination,
+			Secret:         secret,
+			TriggerWords:   strings.Join(words, ","),
+			CreatedByID:    &userID,
+		}
+		if err := repositories.NewWebhookRepository(webhooks.db.DB).CreateOutgoing(ctx, &hook); err != nil {
+			slog.ErrorContext(ctx, "Failed to create webhook", "channel_id", hook.ConversationID, "error", err)
+			return nil, internalError("failed to create webhook")
+		}
+		view := OutgoingWebhookView{OutgoingWebhook: hook, Secret: secret}
+		return &Response{Status: http.StatusCreated, Data: view, Legacy: gin.H{"webhook": view}}, nil
+	}
+}
+
+// ListOutgoingWebhooks lists the current channel's outgoing webhooks.
+func ListOutgoingWebhooks(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		hooks, err := repositories.NewWebhookRepository(webhooks.db.DB).ListOutgoing(c.Request.Context(), CurrentChannel(c).ConversationID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list webhooks", "error", err)
+			return nil, internalError("failed to list webhooks")
+		}
+		return &Response{Data: hooks, Legacy: gin.H{"webhooks": hooks}}, nil
+	}
+}
+
+// DeleteOutgoingWebhook removes the outgoing webhook named by the
+// :webhookId parameter from the current channel.
+func DeleteOutgoingWebhook(webhooks *Webhooks) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		return deleteWebhook(c, repositories.NewWebhookRepository(webhooks.db.DB).DeleteOutgoing)
+	}
+}
+
+func deleteWebhook(c *gin.Context, remove func(ctx context.Context, conversationID, id uuid.UUID) error) (*Response, *APIError) {
+	id, err := uuid.Parse(c.Param("webhookId"))
+	if err != nil {
+		return nil, badRequest("invalid webhook id")
+	}
+	err = remove(c.Request.Context(), CurrentChannel(c).ConversationID, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, notFound("webhook not found")
+	}
+	if err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to delete webhook", "webhook_id", id, "error", err)
+		return nil, internalError("failed to delete webhook")
+	}
+	return &Response{Status: http.StatusNoContent}, nil
+}
+
+// PostIncomingWebhook posts the message in the body to the channel of the
+// incoming webhook named by the :token parameter, as the webhook's bot.
+// It needs no other credentials, so the token must be kept secret.
+func PostIncomingWebhook(c *gin.Context, dbConnection *database.DatabaseConnection, hub *realtime.Hub, notifier *Notifier, suggester *Suggester, index search.Index) {
+	ctx := c.Request.Context()
+	hook, err := repositories.NewWebhookRepository(dbConnection.DB).IncomingByToken(ctx, auth.HashWebhookToken(c.Param("token")))
+	if errors.Is(err, repositories.ErrNotFound) {
+		abortWithError(c, notFound("webhook not found"))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load webhook", "error", err)
+		abortWithError(c, internalError("failed to post message"))
+		return
+	}
+	member, err := repositories.NewConversationRepository(dbConnection.DB).IsMember(ctx, hook.ConversationID, hook.BotID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check webhook bot membership", "webhook_id", hook.ID, "error", err)
+		abortWithError(c, internalError("failed to post message"))
+		return
+	}
+	if !member {
+		abortWithError(c, forbidden("the webhook's bot is no longer in the channel"))
+		return
+	}
+
+	var req incomingWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, badRequest("invalid message payload"))
+		return
+	}
+	input := messageInput{Type: req.Type, Text: req.Text, Payload: req.Payload}
+	message, _, err := postMessage(ctx, dbConnection, hub, notifier, suggester, index, hook.BotID, hook.ConversationID, input)
+	if errors.Is(err, content.ErrInvalidContent) {
+		apiErr := badRequest(err.Error())
+		apiErr.Details = contentErrorDetails(err)
+		abortWithError(c, apiErr)
+		return
+	}
+	if errors.Is(err, errNotModerator) {
+		abortWithError(c, forbidden(err.Error()))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to post webhook message", "webhook_id", hook.ID, "error", err)
+		abortWithError(c, internalError("failed to post message"))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": message,
+	})
+}
+
+// queueOutgoingWebhooks queues delivery of a new message to the outgoing
+// webhooks of its channel whose trigger words it matches. Messages from
+// bots are not sent on, so bots answering webhooks cannot set each other
+// off.
+func queueOutgoingWebhooks(ctx context.Context, dbConnection *database.DatabaseConnection, message *models.Message) {
+	hooks, err := repositories.NewWebhookRepository(dbConnection.DB).ListOutgoing(ctx, message.ConversationID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list outgoing webhooks", "conversation_id", message.ConversationID, "error", err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	isBot, err := repositories.NewBotRepository(dbConnection.DB).IsBot(ctx, message.SenderID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check message sender", "message_id", message.ID, "error", err)
+		return
+	}
+	if isBot {
+		return
+	}
+	jobs := repositories.NewJobRepository(dbConnection.DB)
+	for _, hook := range hooks {
+		if !webhook.Matches(message.Text, hook.TriggerWords) {
+			continue
+		}
+		if _, err := jobs.Enqueue(ctx, JobWebhookDelivery, webhookDeliveryJob{WebhookID: hook.ID, MessageID: message.ID}); err != nil {
+			slog.ErrorContext(ctx, "Failed to queue webhook delivery", "webhook_id", hook.ID, "message_id", message.ID, "error", err)
+		}
+	}
+}
+
+// DeliverWebhook is the job sending a message to an outgoing webhook,
+// signed with its secret. Server errors and unreachable hosts are retried;
+// other refusals are recorded on the webhook and dropped.
+func DeliverWebhook(webhooks *Webhooks) JobHandler {
+	return func(ctx context.Context, job *models.Job) error {
+		var payload webhookDeliveryJob
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return fmt.Errorf("failed to decode job: %w", err)
+		}
+		hookRepo := repositories.NewWebhookRepository(webhooks.db.DB)
+		hook, err := hookRepo.GetOutgoing(ctx, payload.WebhookID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		message, err := repositories.NewMessageRepository(webhooks.db.DB).Get(ctx, payload.MessageID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		retry, err := webhooks.deliver(ctx, hook, message)
+		if recordErr := hookRepo.RecordDelivery(ctx, hook.ID, err); recordErr != nil {
+			slog.ErrorContext(ctx, "Failed to record webhook delivery", "webhook_id", hook.ID, "error", recordErr)
+		}
+		if err != nil && !retry {
+			slog.WarnContext(ctx, "Webhook refused delivery", "webhook_id", hook.ID, "error", err)
+			return nil
+		}
+		return err
+	}
+}
+
+// deliver posts message to hook, reporting whether a failure is worth
+// retrying.
+func (w *Webhooks) deliver(ctx context.Context, hook *models.OutgoingWebhook, message *models.Message) (bool, error) {
+	body, err := json.Marshal(webhookEvent{
+		Event:     "message.new",
+		WebhookID: hook.ID,
+		ChannelID: hook.ConversationID,
+		Message:   message,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+	return w.post(ctx, hook.URL, hook.Secret, body)
+}
+
+// post sends body to url signed with secret, reporting whether a failure
+// is worth retrying.
+func (w *Webhooks) post(ctx context.Context, url, secret string, body []byte) (bool, error) {
+	// The URL was scanned when it was configured; hosts blocked since are
+	// refused too.
+	destination, err := w.scanner.Scan(url)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, destination, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AfroChat-Webhooks/1.0")
+	req.Header.Set(webhook.SignatureHeader, webhook.Sign(secret, time.Now(), body))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, webhook.ErrPrivateHost), fmt.Errorf("failed to deliver webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	default:
+		return false, fmt.Errorf("webhook answered %d", resp.StatusCode)
+	}
+}
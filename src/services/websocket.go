@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/dfunani/AfroChat/backend/pkg/auth"
+	"github.com/dfunani/AfroChat/backend/pkg/content"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/ratelimit"
+	"github.com/dfunani/AfroChat/backend/pkg/realtime"
+	"github.com/dfunani/AfroChat/backend/pkg/search"
+	"github.com/dfunani/AfroChat/backend/pkg/trust"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const bearerSubprotocol = "bearer"
+
+// Clients choose the classes of events they receive, everywhere or in one
+// conversation, with these; both are answered with eventSubscriptions.
+const (
+	eventSubscribe     = "subscriptions.subscribe"
+	eventUnsubscribe   = "subscriptions.unsubscribe"
+	eventSubscriptions = "subscriptions"
+)
+
+// Tokens are not cookies, so a cross-site page cannot ride on the user's
+// session; any origin may open a socket as long as it presents a token.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	Subprotocols:    []string{bearerSubprotocol, resumeSubprotocol},
+	CheckOrigin:     func(*http.Request) bool { return true },
+}
+
+// WebSocketHandler authenticates the handshake and hands the connection to
+// the hub. Native clients send an Authorization header; browsers, which
+// cannot, send the subprotocols "bearer" and the token. A client
+// reconnecting after a drop may instead send "resume" and the resume token
+// it was last given.
+func WebSocketHandler(c *gin.Context, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager, hub *realtime.Hub) {
+	userID, sessionID, resumed := resumeConnection(c.Request, tokens, hub)
+	if !resumed {
+		// Clients may offer an access token too, to fall back on.
+		offered := BearerToken(c.Request) != "" || subprotocolValue(c.Request, bearerSubprotocol) != ""
+		if subprotocolValue(c.Request, resumeSubprotocol) != "" && !offered {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status": "error",
+				"error":  "invalid or expired resume token",
+			})
+			return
+		}
+		user, status, message := authenticateSocket(c, dbConnection, tokens)
+		if user == nil {
+			c.JSON(status, gin.H{
+				"status": "error",
+				"error":  message,
+			})
+			return
+		}
+		userID, sessionID = user.ID, user.sessionID
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response.
+		slog.WarnContext(c.Request.Context(), "WebSocket upgrade failed", "user_id", userID, "error", err)
+		return
+	}
+
+	hub.Serve(conn, userID, sessionID, resumed)
+}
+
+type socketUser struct {
+	*models.User
+	sessionID uuid.UUID
+}
+
+// authenticateSocket checks the access token offered in the handshake.
+func authenticateSocket(c *gin.Context, dbConnection *database.DatabaseConnection, tokens *auth.TokenManager) (*socketUser, int, string) {
+	token := BearerToken(c.Request)
+	if token == "" {
+		token = subprotocolValue(c.Request, bearerSubprotocol)
+	}
+
+	claims, err := tokens.Parse(token)
+	if err != nil {
+		return nil, http.StatusUnauthorized, "missing or invalid access token"
+	}
+
+	// A socket both receives and sends, so it needs both scopes.
+	if !claims.HasScope(auth.ScopeRead) || !claims.HasScope(auth.ScopeWrite) {
+		return nil, http.StatusForbidden, "token lacks the read and write scopes"
+	}
+
+	user, status, message := LoadActiveUser(c, dbConnection, claims)
+	if user == nil {
+		return nil, status, message
+	}
+	if apiErr := checkLegalAcceptance(c, dbConnection, user); apiErr != nil {
+		return nil, apiErr.Status, apiErr.Message
+	}
+	return &socketUser{User: user, sessionID: claims.Session()}, http.StatusOK, ""
+}
+
+type sendMessagePayload struct {
+	messageInput
+	ConversationID uuid.UUID `json:"conversation_id"`
+	RecipientID    uuid.UUID `json:"recipient_id"`
+
+	// RecipientIDs sends to several users at once, in the group of exactly
+	// them and the sender, created on first contact.
+	RecipientIDs []uuid.UUID `json:"recipient_ids"`
+
+	// WorkspaceID is the workspace of the conversation opened with
+	// recipient_id or recipient_ids, the default workspace when missing.
+	WorkspaceID uuid.UUID `json:"workspace_id"`
+}
+
+// RegisterRealtimeHandlers wires inbound WebSocket event types to their
+// handlers. Sent messages count against messageLimit together with those
+// sent over HTTP.
+func RegisterRealtimeHandlers(hub *realtime.Hub, dbConnection *database.DatabaseConnection, notifier *Notifier, suggester *Suggester, index search.Index, limiter *ratelimit.Limiter, messageLimit ratelimit.Policy) {
+	hub.Handle("ping", func(ctx context.Context, client *realtime.Client, event realtime.Event) {
+		reply(client, event, "pong", nil)
+	})
+
+	hub.Handle(eventTypingStart, relayTyping(dbConnection, hub))
+	hub.Handle(eventTypingStop, relayTyping(dbConnection, hub))
+	hub.Handle(eventSyncAck, ackOverSocket(dbConnection))
+	hub.Handle(eventSubscribe, updateSubscriptions(true))
+	hub.Handle(eventUnsubscribe, updateSubscriptions(false))
+
+	// message.send posts to conversation_id, or to the direct conversation
+	// with recipient_id in workspace_id, or to the group of recipient_ids,
+	// opening it on first contact. Every user must belong to the workspace.
+	hub.Handle("message.send", func(ctx context.Context, client *realtime.Client, event realtime.Event) {
+		var payload sendMessagePayload
+		if err := json.Unmarshal(event.Data, &payload); err != nil {
+			replyError(client, event, "invalid message payload")
+			return
+		}
+
+		// Resends after a reconnect store nothing, so they are not limited.
+		resend := recentSends.seen(client.UserID, payload.ClientID)
+		if !resend && !limiter.Allow(ctx, MessageLimitName+":"+client.UserID.String(), messageLimit).Allowed {
+			replyError(client, event, "rate limit exceeded; try again later")
+			return
+		}
+
+		conversations := repositories.NewConversationRepository(dbConnection.DB)
+		conversationID := payload.ConversationID
+
+		switch {
+		case conversationID != uuid.Nil:
+			isMember, err := conversations.IsMember(ctx, conversationID, client.UserID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to check conversation membership", "error", err)
+			}
+			if !isMember {
+				replyError(client, event, "conversation not found")
+				return
+			}
+		case payload.RecipientID != uuid.Nil && payload.RecipientID != client.UserID:
+			if payload.WorkspaceID == uuid.Nil {
+				payload.WorkspaceID = models.DefaultWorkspaceID
+			}
+			if err := checkRecipients(ctx, dbConnection, payload.WorkspaceID, []uuid.UUID{client.UserID, payload.RecipientID}); err != nil {
+				if !errors.Is(err, errRecipientNotFound) {
+					slog.ErrorContext(ctx, "Failed to load message recipient", "error", err)
+				}
+				replyError(client, event, "recipient not found")
+				return
+			}
+			if err := checkNotBlocked(ctx, dbConnection, client.UserID, payload.RecipientID); err != nil {
+				if !errors.Is(err, errBlocked) {
+					slog.ErrorContext(ctx, "Failed to check blocks", "error", err)
+				}
+				replyError(client, event, errBlocked.Error())
+				return
+			}
+			conversation, _, err := conversations.FindOrCreateDirect(ctx, payload.WorkspaceID, client.UserID, payload.RecipientID)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to open direct conversation", "error", err)
+				replyError(client, event, "failed to send message")
+				return
+			}
+			conversationID = conversation.ID
+		case len(payload.RecipientIDs) > 0:
+			if payload.WorkspaceID == uuid.Nil {
+				payload.WorkspaceID = models.DefaultWorkspaceID
+			}
+			if len(payload.RecipientIDs) > maxRecipients {
+				replyError(client, event, errGroupTooLarge.Error())
+				return
+			}
+			conversation, _, err := openConversationWith(ctx, dbConnection, payload.WorkspaceID, client.UserID, payload.RecipientIDs)
+			switch {
+			case errors.Is(err, errRecipientNotFound):
+				replyError(client, event, "recipient not found")
+				return
+			case errors.Is(err, errBlocked), errors.Is(err, errGroupTooLarge), errors.Is(err, errRoomQuotaReached), errors.Is(err, trust.ErrInsufficientTrust):
+				replyError(client, event, err.Error())
+				return
+			case err != nil:
+				slog.ErrorContext(ctx, "Failed to open conversation with recipients", "error", err)
+				replyError(client, event, "failed to send message")
+				return
+			}
+			conversationID = conversation.ID
+		default:
+			replyError(client, event, "conversation_id, recipient_id or recipient_ids is required")
+			return
+		}
+
+		message, _, err := postMessage(ctx, dbConnection, hub, notifier, suggester, index, client.UserID, conversationID, payload.messageInput)
+		var answered *commandReply
+		if errors.As(err, &answered) {
+			reply(client, event, eventCommandResponse, answered)
+			return
+		}
+		if err != nil {
+			if errors.Is(err, content.ErrInvalidContent) || errors.Is(err, errBlocked) || errors.Is(err, errNotModerator) || errors.Is(err, errMuted) || errors.Is(err, trust.ErrInsufficientTrust) {
+				replyError(client, event, err.Error(), contentErrorDetails(err)...)
+				return
+			}
+			slog.ErrorContext(ctx, "Failed to send message", "error", err)
+			replyError(client, event, "failed to send message")
+			return
+		}
+		reply(client, event, "message.ack", message)
+	})
+}
+
+type subscriptionsPayload struct {
+	Events         []string  `json:"events"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+}
+
+// updateSubscriptions turns the classes of events named in events on or
+// off for the connection: presence, typing, receipts and reactions. With a
+// conversation_id, only that conversation's events are affected, which
+// low-end devices use to follow typing in the open conversation alone.
+// Messages are always sent. Reconnecting clients subscribe again.
+func updateSubscriptions(on bool) realtime.HandlerFunc {
+	return func(ctx context.Context, client *realtime.Client, event realtime.Event) {
+		var payload subscriptionsPayload
+		if err := json.Unmarshal(event.Data, &payload); err != nil || len(payload.Events) == 0 {
+			replyError(client, event, "events is required")
+			return
+		}
+		subscriptions, err := client.Subscribe(payload.Events, payload.ConversationID, on)
+		if err != nil {
+			replyError(client, event, err.Error())
+			return
+		}
+		reply(client, event, eventSubscriptions, subscriptions)
+	}
+}
+
+func reply(client *realtime.Client, request realtime.Event, eventType string, data any) {
+	response, err := realtime.NewEvent(eventType, data)
+	if err != nil {
+		replyError(client, request, "failed to encode response")
+		return
+	}
+	response.RequestID = request.RequestID
+	client.Send(response)
+}
+
+// replyError answers a request with an error, detailing the fields that
+// failed validation if there are any.
+func replyError(client *realtime.Client, request realtime.Event, message string, details ...FieldError) {
+	data := gin.H{"message": message}
+	if len(details) > 0 {
+		data["details"] = details
+	}
+	response, _ := realtime.NewEvent(realtime.EventError, data)
+	response.RequestID = request.RequestID
+	client.Send(response)
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dfunani/AfroChat/backend/pkg/agegate"
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/dfunani/AfroChat/backend/pkg/entitlements"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type addWelcomeRoomRequest struct {
+	ChannelID   uuid.UUID `json:"channel_id" binding:"required"`
+	CountryCode string    `json:"country_code" binding:"omitempty,len=2,alpha"`
+}
+
+// ListWelcomeRooms lists the channels new users are added to.
+func ListWelcomeRooms(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		rooms, err := repositories.NewChannelRepository(dbConnection.DB).WelcomeRooms(c.Request.Context())
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list welcome rooms", "error", err)
+			return nil, internalError("failed to list welcome rooms")
+		}
+		return &Response{Data: rooms, Legacy: gin.H{"rooms": rooms}}, nil
+	}
+}
+
+// AddWelcomeRoom has new users added to a public channel of the default
+// workspace when they sign up: all of them, or with country_code only
+// those from that country.
+func AddWelcomeRoom(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req addWelcomeRoomRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		ctx := c.Request.Context()
+		channels := repositories.NewChannelRepository(dbConnection.DB)
+		channel, err := channels.Get(ctx, req.ChannelID)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("channel not found")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load channel", "channel_id", req.ChannelID, "error", err)
+			return nil, internalError("failed to add welcome room")
+		}
+		if channel.IsPrivate || channel.Conversation.WorkspaceID != models.DefaultWorkspaceID {
+			return nil, badRequest("welcome rooms must be public channels of the default workspace")
+		}
+
+		userID := CurrentUserID(c)
+		room := models.WelcomeRoom{
+			ChannelID:   channel.ConversationID,
+			CountryCode: strings.ToUpper(req.CountryCode),
+			CreatedByID: &userID,
+		}
+		err = channels.AddWelcomeRoom(ctx, &room)
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("channel is already a welcome room for that country")
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to add welcome room", "channel_id", req.ChannelID, "error", err)
+			return nil, internalError("failed to add welcome room")
+		}
+		return &Response{Status: http.StatusCreated, Data: room, Legacy: gin.H{"room": room}}, nil
+	}
+}
+
+// DeleteWelcomeRoom stops adding new users to the welcome room named by
+// the :id parameter. Users already in the channel stay.
+func DeleteWelcomeRoom(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		id, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, badRequest("invalid welcome room id")
+		}
+		err = repositories.NewChannelRepository(dbConnection.DB).DeleteWelcomeRoom(c.Request.Context(), id)
+		if errors.Is(err, repositories.ErrNotFound) {
+			return nil, notFound("welcome room not found")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to delete welcome room", "id", id, "error", err)
+			return nil, internalError("failed to delete welcome room")
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// joinWelcomeRooms adds a user who just signed up to the welcome rooms
+// for everyone and for their country, inside the transaction creating
+// them. They wait in line for rooms that are full, and 18+ rooms are left
+// out unless their date of birth makes them an adult. The rooms'
+// member-joined automations run for the rooms they got into.
+func joinWelcomeRooms(ctx context.Context, tx *gorm.DB, user *models.User) error {
+	adult := agegate.CheckAdultRoom(user.DateOfBirth, time.Now()) == nil
+	joined, err := repositories.NewChannelRepository(tx).JoinWelcomeRooms(ctx, user.ID, user.CountryCode, adult, entitlements.Rooms().PublicChannel)
+	if err != nil {
+		return err
+	}
+	for _, channelID := range joined {
+		queueJoinAutomations(ctx, tx, channelID, []uuid.UUID{user.ID})
+	}
+	return nil
+}
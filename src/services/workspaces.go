@@ -0,0 +1,269 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/dfunani/AfroChat/backend/pkg/database"
+	"github.com/dfunani/AfroChat/backend/pkg/database/models"
+	"github.com/dfunani/AfroChat/backend/pkg/database/repositories"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// workspaceSlugPattern is what a workspace slug may look like: it is sent
+// in the X-Workspace header and used in paths.
+var workspaceSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,48}[a-z0-9]$`)
+
+// workspaceHas reports whether userID belongs to the workspace. Everyone
+// belongs to the default workspace.
+func workspaceHas(ctx context.Context, dbConnection *database.DatabaseConnection, workspaceID, userID uuid.UUID) (bool, error) {
+	_, err := repositories.NewWorkspaceRepository(dbConnection.DB).Member(ctx, workspaceID, userID)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// workspaceUsers limits a query on users to the members of the workspace,
+// so profiles are not visible across workspaces.
+func workspaceUsers(tx *gorm.DB, workspaceID uuid.UUID) *gorm.DB {
+	if workspaceID == models.DefaultWorkspaceID {
+		return tx
+	}
+	return tx.Where("id IN (?)", tx.Session(&gorm.Session{NewDB: true}).
+		Model(&models.WorkspaceMember{}).Select("user_id").Where("workspace_id = ?", workspaceID))
+}
+
+// workspaceUserIDs returns those of ids that belong to the workspace, which
+// in the default workspace is all of them.
+func workspaceUserIDs(ctx context.Context, dbConnection *database.DatabaseConnection, workspaceID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	if workspaceID == models.DefaultWorkspaceID {
+		return ids, nil
+	}
+	var members []uuid.UUID
+	err := dbConnection.DB.WithContext(ctx).Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND user_id IN ?", workspaceID, ids).
+		Pluck("user_id", &members).Error
+	return members, err
+}
+
+// ListWorkspaces returns the workspaces the current user belongs to, with
+// their role in each.
+func ListWorkspaces(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspaces, err := repositories.NewWorkspaceRepository(dbConnection.DB).ListForUser(c.Request.Context(), CurrentUserID(c))
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list workspaces", "error", err)
+			return nil, internalError("failed to list workspaces")
+		}
+		return &Response{Data: workspaces, Legacy: gin.H{"workspaces": workspaces}}, nil
+	}
+}
+
+type createWorkspaceRequest struct {
+	Slug string `json:"slug" binding:"required"`
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// CreateWorkspace creates a workspace owned by the current user.
+func CreateWorkspace(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		var req createWorkspaceRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		req.Slug = strings.ToLower(req.Slug)
+		if !workspaceSlugPattern.MatchString(req.Slug) || req.Slug == models.DefaultWorkspaceSlug {
+			return nil, badRequest("slug must be 3 to 50 lowercase letters, digits and hyphens")
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			return nil, badRequest("name is required")
+		}
+
+		workspace := &models.Workspace{Slug: req.Slug, Name: name}
+		err := repositories.NewWorkspaceRepository(dbConnection.DB).Create(c.Request.Context(), workspace, CurrentUserID(c))
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, conflict("slug is already taken")
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to create workspace", "error", err)
+			return nil, internalError("failed to create workspace")
+		}
+		return &Response{Status: http.StatusCreated, Data: workspace, Legacy: gin.H{"workspace": workspace}}, nil
+	}
+}
+
+// workspaceMembership loads the workspace named by the :slug parameter
+// and the current user's membership of it. The default workspace has no
+// member list of its own, and non-members get a 404.
+func workspaceMembership(c *gin.Context, dbConnection *database.DatabaseConnection) (*models.Workspace, *models.WorkspaceMember, *APIError) {
+	ctx := c.Request.Context()
+	workspaces := repositories.NewWorkspaceRepository(dbConnection.DB)
+	workspace, err := workspaces.GetBySlug(ctx, c.Param("slug"))
+	var member *models.WorkspaceMember
+	if err == nil {
+		member, err = workspaces.Member(ctx, workspace.ID, CurrentUserID(c))
+	}
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, nil, notFound("workspace not found")
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load workspace", "slug", c.Param("slug"), "error", err)
+		return nil, nil, internalError("failed to load workspace")
+	}
+	if workspace.ID == models.DefaultWorkspaceID {
+		return nil, nil, badRequest("the default workspace includes every user")
+	}
+	return workspace, member, nil
+}
+
+// WorkspaceMemberView is a workspace member with their public profile.
+type WorkspaceMemberView struct {
+	models.WorkspaceMember
+	User PublicProfile `json:"user"`
+}
+
+// ListWorkspaceMembers returns the members of a workspace with their
+// profiles.
+func ListWorkspaceMembers(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, _, apiErr := workspaceMembership(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		found, err := repositories.NewWorkspaceRepository(dbConnection.DB).Members(c.Request.Context(), workspace.ID)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to list workspace members", "workspace_id", workspace.ID, "error", err)
+			return nil, internalError("failed to list workspace members")
+		}
+		members := make([]WorkspaceMemberView, len(found))
+		for i := range found {
+			members[i] = WorkspaceMemberView{WorkspaceMember: found[i], User: NewPublicProfile(&found[i].User)}
+		}
+		return &Response{Data: members, Legacy: gin.H{"members": members}}, nil
+	}
+}
+
+type addWorkspaceMemberRequest struct {
+	UserID uuid.UUID `json:"user_id" binding:"required"`
+	Role   string    `json:"role" binding:"omitempty,oneof=admin member"`
+}
+
+// AddWorkspaceMember adds a user to a workspace. Owners may add admins and
+// members, admins only members.
+func AddWorkspaceMember(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, actor, apiErr := workspaceMembership(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		var req addWorkspaceMemberRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+		if req.Role == "" {
+			req.Role = models.MemberRoleMember
+		}
+		if actor.Role != models.MemberRoleOwner && (actor.Role != models.MemberRoleAdmin || req.Role != models.MemberRoleMember) {
+			return nil, forbidden("insufficient workspace role")
+		}
+
+		ctx := c.Request.Context()
+		var count int64
+		if err := dbConnection.DB.WithContext(ctx).Model(&models.User{}).
+			Where("id = ? AND is_banned = ?", req.UserID, false).
+			Count(&count).Error; err != nil {
+			slog.ErrorContext(ctx, "Failed to load user", "user_id", req.UserID, "error", err)
+			return nil, internalError("failed to add workspace member")
+		}
+		if count == 0 {
+			return nil, notFound("user not found")
+		}
+
+		member, err := repositories.NewWorkspaceRepository(dbConnection.DB).AddMember(ctx, workspace.ID, req.UserID, req.Role)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to add workspace member", "workspace_id", workspace.ID, "error", err)
+			return nil, internalError("failed to add workspace member")
+		}
+		return &Response{Status: http.StatusCreated, Data: member, Legacy: gin.H{"member": member}}, nil
+	}
+}
+
+type updateWorkspaceMemberRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=admin member"`
+}
+
+// UpdateWorkspaceMemberRole changes a member's role. Only the owner may.
+func UpdateWorkspaceMemberRole(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, actor, apiErr := workspaceMembership(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		if actor.Role != models.MemberRoleOwner {
+			return nil, forbidden("insufficient workspace role")
+		}
+		targetID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+		var req updateWorkspaceMemberRoleRequest
+		if apiErr := bindJSON(c, &req); apiErr != nil {
+			return nil, apiErr
+		}
+
+		if err := repositories.NewWorkspaceRepository(dbConnection.DB).SetRole(c.Request.Context(), workspace.ID, targetID, req.Role); err != nil {
+			return nil, workspaceMemberError(c, workspace.ID, err)
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+// RemoveWorkspaceMember removes a member from a workspace and its rooms.
+// Members may leave; owners and admins may remove those below them.
+func RemoveWorkspaceMember(dbConnection *database.DatabaseConnection) Endpoint {
+	return func(c *gin.Context) (*Response, *APIError) {
+		workspace, actor, apiErr := workspaceMembership(c, dbConnection)
+		if apiErr != nil {
+			return nil, apiErr
+		}
+		targetID, err := uuid.Parse(c.Param("userId"))
+		if err != nil {
+			return nil, badRequest("invalid user id")
+		}
+
+		ctx := c.Request.Context()
+		workspaces := repositories.NewWorkspaceRepository(dbConnection.DB)
+		if targetID != actor.UserID {
+			target, err := workspaces.Member(ctx, workspace.ID, targetID)
+			if err != nil {
+				return nil, workspaceMemberError(c, workspace.ID, err)
+			}
+			if !canManage(actor.Role, target.Role) {
+				return nil, forbidden("insufficient workspace role")
+			}
+		}
+		if err := workspaces.RemoveMember(ctx, workspace.ID, targetID); err != nil {
+			return nil, workspaceMemberError(c, workspace.ID, err)
+		}
+		return &Response{Status: http.StatusNoContent}, nil
+	}
+}
+
+func workspaceMemberError(c *gin.Context, workspaceID uuid.UUID, err error) *APIError {
+	switch {
+	case errors.Is(err, repositories.ErrNotFound), errors.Is(err, repositories.ErrNotMember):
+		return notFound("member not found")
+	case errors.Is(err, repositories.ErrLastWorkspaceOwner):
+		return conflict(err.Error())
+	}
+	slog.ErrorContext(c.Request.Context(), "Failed to update workspace member", "workspace_id", workspaceID, "error", err)
+	return internalError("failed to update workspace member")
+}